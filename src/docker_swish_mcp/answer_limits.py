@@ -0,0 +1,80 @@
+"""
+Answer Size Limits for Docker SWISH MCP
+
+SWISH_MCP_MAX_ANSWER_BYTES (default 0, no limit) caps the size of the
+solutions one answer of execute_prolog_query returns from the persistent
+session, and its max_answer_bytes argument sets the cap for one query
+(0 lifts it). Solutions are measured the way spill.py writes them, a
+line each: their JSON bindings, or their printed text.
+
+An answer over the cap is cut after the last solution that fits, though
+never before the first, so that every answer makes progress. Instead of
+failing or clipping a solution, it says so: JSON results carry
+"truncated": true, the solutions returned and found and, for the rest,
+a next_cursor that execute_prolog_query(cursor=...) pages through, with
+the same cap, like the cursor of a paginated query. The solutions held
+back wait on the server (see cursors.py); a plain query has run to its
+end, so they are all it found. A page of a paginated query that is cut
+starts the next page with what it held back. Answers cut this way are
+not spilled to results/ (see spill.py), nor cached.
+"""
+
+from dataclasses import dataclass
+from typing import Any
+
+from .spill import solution_lines
+
+
+def fitting(solutions: list[Any], structured: bool, max_bytes: int) -> int:
+    """How many of solutions, from the first, fit in max_bytes (all without a cap)."""
+    if max_bytes <= 0:
+        return len(solutions)
+    size = 0
+    for count, line in enumerate(solution_lines(solutions, structured)):
+        size += len(line.encode("utf-8")) + 1
+        if size > max_bytes:
+            return count
+    return len(solutions)
+
+
+@dataclass
+class Truncation:
+    """How an answer was cut to its size limit."""
+    returned: int
+    found: int
+    max_bytes: int
+    next_cursor: str = ""
+    # The first solution alone is larger than the limit
+    oversized: bool = False
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "truncated": True,
+            "solutions_returned": self.returned,
+            "solutions_found": self.found,
+            "max_answer_bytes": self.max_bytes,
+            "next_cursor": self.next_cursor or None,
+        }
+
+    def note(self, paged: bool = False) -> str:
+        found = "fetched for this page" if paged else "found"
+        note = (
+            f"✂️ Answer size limit ({self.max_bytes} bytes) reached: "
+            f"returned {self.returned} of the {self.found} solutions {found}"
+        )
+        if self.oversized:
+            note += " (the first alone is larger than the limit)"
+        if paged:
+            return f"{note}; the rest start the next page."
+        return f"{note}. Fetch the rest with cursor=\"{self.next_cursor}\", or refine the query."
+
+
+def cut(solutions: list[Any], structured: bool, max_bytes: int) -> tuple[list[Any], Truncation | None]:
+    """Cut solutions to max_bytes in place; returns the solutions cut off and how, if any were."""
+    kept = fitting(solutions, structured, max_bytes)
+    if kept == len(solutions):
+        return [], None
+    truncation = Truncation(max(kept, 1), len(solutions), max_bytes, oversized=kept == 0)
+    held = solutions[truncation.returned:]
+    del solutions[truncation.returned:]
+    return held, truncation
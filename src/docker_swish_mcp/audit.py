@@ -0,0 +1,329 @@
+"""
+Knowledge Base Audit Log and Undo Stack for Docker SWISH MCP
+
+Every change a tool makes to the knowledge base is appended to a JSON
+Lines log kept next to the data directory (outside the container mount):
+queries that assert or retract clauses, and files written, renamed or
+deleted under the data directory. Each entry records who made the change,
+with which tool, and a summary of what changed.
+
+Entries also keep enough state to be undone: the dynamic database as it
+was before a query (see mcp_db_snapshot/1) and the previous contents of
+the files a tool touched. Undo state lives in memory and covers the most
+recent changes only; the log itself survives restarts. Database entries
+also log the clauses added and removed (up to MAX_DELTA_CLAUSES), which
+is what as_of queries replay backwards (see timetravel.py). A database
+past MAX_UNDO_CLAUSES is not dumped at all: only its clause counts are
+read, and the change is logged by net count, neither undoable nor
+replayable.
+
+Undo restores the dynamic predicates of module user and the files as
+they were. Changes made outside the tracked tools (consulting a file,
+restarting the session) are not tracked, and undoing a database change
+also reverts them for the predicates concerned.
+"""
+
+import json
+import logging
+import time
+from collections import Counter, deque
+from dataclasses import asdict, dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+logger = logging.getLogger("docker-swish-mcp.audit")
+
+# Files larger than this (in total, per change) are logged but not undoable
+MAX_UNDO_FILE_BYTES = 4 * 1024 * 1024
+# Databases with more clauses than this are logged but not undoable
+MAX_UNDO_CLAUSES = 100_000
+# Changes of more clauses than this are logged without them, and as_of cannot go back past them
+MAX_DELTA_CLAUSES = 10_000
+
+# Contents as read from disk, so binary files round-trip too
+FileState = dict[str, bytes | None]
+# Clauses added and removed per predicate, per module:
+# {"user": {"parent/2": {"added": [...], "removed": [...]}}}
+ClauseDelta = dict[str, dict[str, dict[str, list[str]]]]
+
+
+def audit_log_path(data_dir: Path) -> Path:
+    """Log file for a data directory, kept outside the mount like snapshots."""
+    return data_dir.parent / "swish-audit" / f"{data_dir.name}.jsonl"
+
+
+@dataclass
+class AuditEntry:
+    """One logged change."""
+    seq: int
+    time: float
+    client: str
+    tool: str
+    # "database", "files" or "undo"
+    kind: str
+    detail: str
+    changes: list[str] = field(default_factory=list)
+    undoable: bool = False
+    # None for changes logged without their clauses
+    delta: ClauseDelta | None = None
+
+    def describe(self) -> str:
+        stamp = time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(self.time))
+        changes = f" [{'; '.join(self.changes)}]" if self.changes else ""
+        marker = " ↩️" if self.undoable else ""
+        return f"#{self.seq} {stamp} {self.client} {self.tool}: {self.detail}{changes}{marker}"
+
+
+@dataclass
+class UndoState:
+    """What an entry changed, as it was before."""
+    database: list[dict[str, Any]] | None = None
+    # Module the database state belongs to
+    module: str = "user"
+    files: FileState | None = None
+
+
+def predicate_key(row: dict[str, Any]) -> str:
+    return f"{row['name']}/{row['arity']}"
+
+
+def counted_only(rows: list[dict[str, Any]]) -> bool:
+    """Whether snapshot rows carry clause counts only (mcp_db_snapshot/3 past its cap)."""
+    return any("clauses" not in row for row in rows)
+
+
+def diff_counts(before: list[dict[str, Any]], after: list[dict[str, Any]]) -> list[str]:
+    """Net clause count change per predicate, e.g. "parent/2 +1 net"."""
+    def counts(rows: list[dict[str, Any]]) -> dict[str, int]:
+        return {predicate_key(row): row["count"] if "count" in row else len(row["clauses"]) for row in rows}
+    old, new = counts(before), counts(after)
+    return [
+        f"{key} {new.get(key, 0) - old.get(key, 0):+d} net"
+        for key in sorted(set(old) | set(new))
+        if new.get(key, 0) != old.get(key, 0)
+    ]
+
+
+def database_delta(before: list[dict[str, Any]], after: list[dict[str, Any]]) -> dict[str, dict[str, list[str]]]:
+    """Clauses added to and removed from each predicate that changed."""
+    old = {predicate_key(row): Counter(row["clauses"]) for row in before}
+    new = {predicate_key(row): Counter(row["clauses"]) for row in after}
+    delta = {}
+    for key in sorted(set(old) | set(new)):
+        added = list((new.get(key, Counter()) - old.get(key, Counter())).elements())
+        removed = list((old.get(key, Counter()) - new.get(key, Counter())).elements())
+        if added or removed:
+            delta[key] = {"added": added, "removed": removed}
+    return delta
+
+
+def diff_database(before: list[dict[str, Any]], after: list[dict[str, Any]]) -> list[str]:
+    """Per-predicate clause counts added and removed, e.g. "parent/2 +2 -1"."""
+    return [
+        f"{key} +{len(change['added'])} -{len(change['removed'])}"
+        for key, change in database_delta(before, after).items()
+    ]
+
+
+def delta_size(delta: ClauseDelta) -> int:
+    return sum(
+        len(change["added"]) + len(change["removed"])
+        for predicates in delta.values() for change in predicates.values()
+    )
+
+
+def delta_changes(delta: ClauseDelta) -> list[str]:
+    """The "parent/2 +2 -1" summary of a delta, with the module when it is not user."""
+    return [
+        f"{'' if module == 'user' else module + ':'}{key} +{len(change['added'])} -{len(change['removed'])}"
+        for module, predicates in delta.items() for key, change in predicates.items()
+    ]
+
+
+def restore_call(predicates: list[dict[str, Any]], module: str = "user") -> tuple[str, list[str]]:
+    """mcp_db_restore/3 call putting a module's dynamic database back to a snapshot."""
+    terms = [
+        f"pred({prolog_atom(row['name'])}, {int(row['arity'])}, "
+        f"[{', '.join(prolog_string(clause) for clause in row['clauses'])}])"
+        for row in predicates
+    ]
+    return "mcp_db_restore", [prolog_atom(module), f"[{', '.join(terms)}]"]
+
+
+class AuditLog:
+    """
+    Append-only change log of one data directory, with an undo stack.
+
+    Args:
+        data_dir: Data directory whose changes are logged
+        max_undo: Number of recent changes that can be undone
+    """
+
+    def __init__(self, data_dir: Path, max_undo: int = 50):
+        self.data_dir = data_dir
+        self.path = audit_log_path(data_dir)
+        self.undo_stack: deque[tuple[AuditEntry, UndoState]] = deque(maxlen=max(0, max_undo))
+        self.next_seq = self._last_seq() + 1
+
+    def _last_seq(self) -> int:
+        last = 0
+        for entry in self.read():
+            last = max(last, entry.seq)
+        return last
+
+    def read(self) -> list[AuditEntry]:
+        """All logged entries, oldest first."""
+        if not self.path.exists():
+            return []
+        entries = []
+        for line in self.path.read_text(encoding="utf-8").splitlines():
+            try:
+                entries.append(AuditEntry(**json.loads(line)))
+            except (ValueError, TypeError) as e:
+                logger.warning(f"Skipping unreadable audit log line: {e}")
+        return entries
+
+    def history(self, limit: int = 20) -> list[AuditEntry]:
+        """The most recent entries, newest first, marked undoable if they still are."""
+        undoable = {entry.seq for entry, _state in self.undo_stack}
+        entries = self.read()[-max(1, limit):] if limit > 0 else self.read()
+        for entry in entries:
+            entry.undoable = entry.seq in undoable
+        return list(reversed(entries))
+
+    def _append(self, entry: AuditEntry) -> None:
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        with open(self.path, "a", encoding="utf-8") as f:
+            f.write(json.dumps(asdict(entry), ensure_ascii=False) + "\n")
+
+    def record(
+        self,
+        client: str,
+        tool: str,
+        kind: str,
+        detail: str,
+        changes: list[str],
+        undo: UndoState | None = None,
+        delta: ClauseDelta | None = None
+    ) -> AuditEntry:
+        """Append an entry and, if undo state is given, push it on the undo stack."""
+        if delta is not None and delta_size(delta) > MAX_DELTA_CLAUSES:
+            delta = None
+        entry = AuditEntry(
+            self.next_seq, time.time(), client, tool, kind, detail, changes, undo is not None, delta
+        )
+        self.next_seq += 1
+        self._append(entry)
+        if undo is not None and self.undo_stack.maxlen:
+            self.undo_stack.append((entry, undo))
+        logger.info(f"📝 {entry.describe()}")
+        return entry
+
+    def record_database(
+        self,
+        client: str,
+        tool: str,
+        detail: str,
+        before: list[dict[str, Any]],
+        after: list[dict[str, Any]],
+        module: str = "user"
+    ) -> AuditEntry | None:
+        """
+        Log a query's effect on a module's dynamic database; None if it changed nothing.
+
+        Snapshots with counts only are logged by net count, without undo
+        or clauses; changes that leave every count as it was go unseen.
+        """
+        if counted_only(before) or counted_only(after):
+            changes = diff_counts(before, after)
+            if not changes:
+                return None
+            undo = None if counted_only(before) else UndoState(database=before, module=module)
+            return self.record(client, tool, "database", detail, changes, undo)
+        delta = {module: database_delta(before, after)}
+        if not delta[module]:
+            return None
+        clauses = sum(len(row["clauses"]) for row in before)
+        undo = UndoState(database=before, module=module) if clauses <= MAX_UNDO_CLAUSES else None
+        return self.record(client, tool, "database", detail, diff_database(before, after), undo, delta)
+
+    def capture_files(self, paths: list[Path]) -> FileState:
+        """Current contents of paths (directories recursively), None for missing files."""
+        state: FileState = {}
+        for path in paths:
+            files = sorted(p for p in path.rglob("*") if p.is_file()) if path.is_dir() else [path]
+            for file in files:
+                try:
+                    relative = file.resolve().relative_to(self.data_dir.resolve()).as_posix()
+                except ValueError:
+                    # Only files inside the data directory are tracked
+                    continue
+                state[relative] = file.read_bytes() if file.is_file() else None
+        return state
+
+    def record_files(self, client: str, tool: str, detail: str, paths: list[Path], before: FileState) -> AuditEntry | None:
+        """Log the files a tool changed since before was captured; None if none changed."""
+        after = self.capture_files(paths)
+        changes = []
+        for relative in sorted(set(before) | set(after)):
+            old, new = before.get(relative), after.get(relative)
+            if old == new:
+                continue
+            if old is None:
+                changes.append(f"created {relative}")
+            elif new is None:
+                changes.append(f"deleted {relative}")
+            else:
+                changes.append(f"modified {relative}")
+        if not changes:
+            return None
+        # Files that appeared are removed again on undo; paths that were
+        # missing before and are directories now contribute their files
+        previous = {
+            relative: before.get(relative)
+            for relative in set(before) | set(after)
+            if relative in after or before.get(relative) is not None
+        }
+        size = sum(len(data) for data in previous.values() if data)
+        undo = UndoState(files=previous) if size <= MAX_UNDO_FILE_BYTES else None
+        return self.record(client, tool, "files", detail, changes, undo)
+
+    def pop_undo(self, steps: int = 1, to_seq: int = 0) -> list[tuple[AuditEntry, UndoState]]:
+        """
+        Take entries off the undo stack, newest first.
+
+        Args:
+            steps: Number of changes to take
+            to_seq: If given, take every change made after entry to_seq instead
+        """
+        popped = []
+        while self.undo_stack:
+            entry, _state = self.undo_stack[-1]
+            if to_seq > 0 and entry.seq <= to_seq:
+                break
+            if to_seq <= 0 and len(popped) >= steps:
+                break
+            popped.append(self.undo_stack.pop())
+        return popped
+
+    def push_undo(self, popped: list[tuple[AuditEntry, UndoState]]) -> None:
+        """Put entries taken by pop_undo back, e.g. after a failed undo."""
+        for item in reversed(popped):
+            self.undo_stack.append(item)
+
+    def restore_files(self, state: FileState) -> None:
+        for relative, data in state.items():
+            path = self.data_dir / relative
+            if data is None:
+                path.unlink(missing_ok=True)
+                # Drop directories the change created, e.g. a new project
+                parent = path.parent
+                while parent != self.data_dir and parent.is_dir() and not any(parent.iterdir()):
+                    parent.rmdir()
+                    parent = parent.parent
+            else:
+                path.parent.mkdir(parents=True, exist_ok=True)
+                path.write_bytes(data)
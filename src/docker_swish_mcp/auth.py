@@ -0,0 +1,358 @@
+"""
+API Key Authentication for the HTTP Transports
+
+Requests to the http/sse transports must carry "Authorization: Bearer
+<key>" once keys are configured. Each key has capability scopes:
+
+- query: run read-only queries and inspect state; goals run under the
+  strict sandbox policy, so safe_goal/1 refuses assert/retract, file
+  access and shell, however the goal is built
+- write: also create, load and change knowledge bases, and run goals
+  that can (implies query)
+- admin: also manage the container, packs, cluster and restores (implies write)
+
+Keys come from SWISH_MCP_API_KEYS, JSON or the path of a JSON file:
+
+    {"keys": [
+        {"id": "ci-bot", "sha256": "<hex digest of the key>", "scopes": ["query"]},
+        {"id": "alice", "key": "plain-text-key", "scopes": ["admin"], "profile": "developer"}
+    ]}
+
+"profile" picks the tools the key's connections are shown (see
+tool_profiles.py); without it the key's scope does.
+
+A file is re-read when it changes (and on SIGHUP), so keys can be rotated
+by adding the new key, switching clients over and removing the old one.
+SWISH_MCP_API_KEY adds a single admin key for simple setups.
+"""
+
+import hashlib
+import hmac
+import json
+import logging
+import os
+import re
+from collections.abc import Awaitable, Callable, MutableMapping
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp.exceptions import ToolError
+
+logger = logging.getLogger("docker-swish-mcp.auth")
+
+SCOPES = ("query", "write", "admin")
+IMPLIED_SCOPES = {
+    "query": frozenset({"query"}),
+    "write": frozenset({"query", "write"}),
+    "admin": frozenset({"query", "write", "admin"}),
+}
+
+# Scope each tool needs; tools not listed need admin
+TOOL_SCOPES = {
+    "execute_prolog_query": "query",
+    "cancel_query": "query",
+    "trace_query": "query",
+    "profile_query": "query",
+    "fact_feed_subscribe": "query",
+    "fact_feed_events": "query",
+    "fact_feed_unsubscribe": "query",
+    "fact_store_attach": "write",
+    "fact_store_detach": "write",
+    "fact_store_status": "query",
+    "repl_send": "write",
+    "execute_queries_concurrently": "query",
+    "query_map": "write",
+    "engine_query": "write",
+    "engine_result": "query",
+    "engine_list": "query",
+    "engine_stop": "query",
+    "query_batch": "write",
+    "list_prolog_files": "query",
+    "get_swish_status": "query",
+    "project_list": "query",
+    "rdf_triples": "query",
+    "rdf_query": "query",
+    "rdf_graphs": "query",
+    "solve_constraints": "query",
+    "pengine_create": "query",
+    "pengine_ask": "query",
+    "pengine_next": "query",
+    "pengine_stop": "query",
+    "pengine_list": "query",
+    "cluster_status": "query",
+    "workspace_list": "query",
+    "share_program": "write",
+    "collab_join": "query",
+    "collab_events": "query",
+    "collab_push": "write",
+    "collab_leave": "query",
+    "pack_list": "query",
+    "swish_status": "query",
+    "container_stats": "query",
+    "network_status": "query",
+    "volume_list": "query",
+    "prolog_stats": "query",
+    "get_flags": "query",
+    "set_flag": "write",
+    "table_statistics": "query",
+    "kb_history": "query",
+    "kb_graph": "query",
+    "kb_diff": "query",
+    "kb_search": "query",
+    "describe_predicate": "query",
+    "run_tests": "query",
+    "lint_program": "query",
+    "probabilistic_query": "query",
+    "scasp_query": "query",
+    "geo_load": "write",
+    "geo_query": "query",
+    "geo_layers": "query",
+    "kb_quarantine": "query",
+    "draft_rules": "query",
+    "geo_clear": "write",
+    "parse_with_grammar": "query",
+    "share_module": "write",
+    "schedule_query": "write",
+    "list_scheduled_queries": "query",
+    "cancel_scheduled_query": "query",
+    "template_register": "write",
+    "template_run": "query",
+    "template_delete": "query",
+    "sync_status": "query",
+    "quota_status": "query",
+    "disk_usage": "query",
+    "chaos_status": "query",
+    "tool_profile": "query",
+    "create_prolog_file": "write",
+    "clause_insert": "write",
+    "clause_replace": "write",
+    "clause_retract": "write",
+    "write_resource": "write",
+    "load_knowledge_base": "write",
+    "project_create": "write",
+    "project_write_file": "write",
+    "project_rename_file": "write",
+    "project_delete_file": "write",
+    "project_set_load_order": "write",
+    "project_consult": "write",
+    "consult_url": "write",
+    "notebook_create": "write",
+    "notebook_add_cell": "write",
+    "notebook_run": "write",
+    "rdf_load": "write",
+    "owl_import": "write",
+    "import_data": "write",
+    "export_results": "write",
+    "export_rules": "write",
+    "kb_snapshot": "write",
+    "kb_export_bundle": "write",
+    "replay": "write",
+    "recording_start": "write",
+    "recording_stop": "write",
+    "retract_matching": "write",
+    "kb_prune": "write",
+    "table_declare": "write",
+    "table_remove": "write",
+    "table_abolish": "write",
+    "undo_last": "write",
+}
+
+
+def hash_key(key: str) -> str:
+    return hashlib.sha256(key.encode()).hexdigest()
+
+
+def required_scope(tool_name: str) -> str:
+    return TOOL_SCOPES.get(tool_name, "admin")
+
+
+@dataclass(frozen=True)
+class ApiKey:
+    """A configured key; only its SHA-256 digest is kept."""
+    key_id: str
+    digest: str
+    scopes: frozenset[str]
+    # Tool profile of the key's connections; "" picks it by scope (see tool_profiles.py)
+    profile: str = ""
+
+    def allows(self, scope: str) -> bool:
+        return scope in self.scopes
+
+
+def _parse_keys(raw: Any) -> list[ApiKey]:
+    entries = raw.get("keys", []) if isinstance(raw, dict) else raw
+    if not isinstance(entries, list):
+        raise ValueError("API keys must be a list or an object with a \"keys\" list")
+    keys = []
+    for i, entry in enumerate(entries, 1):
+        if not isinstance(entry, dict):
+            raise ValueError(f"API key entry {i} must be an object")
+        key_id = str(entry.get("id") or f"key{i}")
+        if entry.get("sha256"):
+            digest = str(entry["sha256"]).strip().lower()
+            if not re.fullmatch(r"[0-9a-f]{64}", digest):
+                raise ValueError(f"API key '{key_id}' has a malformed sha256 digest")
+        elif entry.get("key"):
+            digest = hash_key(str(entry["key"]))
+        else:
+            raise ValueError(f"API key '{key_id}' has neither \"key\" nor \"sha256\"")
+        scopes = entry.get("scopes", ["query"])
+        if isinstance(scopes, str):
+            scopes = [scopes]
+        unknown = [s for s in scopes if s not in SCOPES]
+        if unknown:
+            raise ValueError(f"API key '{key_id}' has unknown scopes {unknown}. Use: {', '.join(SCOPES)}")
+        granted = frozenset().union(*(IMPLIED_SCOPES[s] for s in scopes))
+        keys.append(ApiKey(key_id, digest, granted, str(entry.get("profile") or "")))
+    return keys
+
+
+class ApiKeyStore:
+    """
+    The set of accepted keys, reloadable at runtime.
+
+    Args:
+        source: JSON text or path of a JSON file with the keys
+        single_key: Extra admin key (SWISH_MCP_API_KEY)
+    """
+
+    def __init__(self, source: str = "", single_key: str = ""):
+        self.source = source.strip()
+        self.single_key = single_key
+        self.keys: list[ApiKey] = []
+        # Set when the initial load failed; every request is then refused
+        self.error: str | None = None
+        self._mtime: float | None = None
+        try:
+            self.load()
+        except ValueError as e:
+            self.error = str(e)
+
+    @classmethod
+    def from_env(cls) -> "ApiKeyStore":
+        return cls(os.environ.get("SWISH_MCP_API_KEYS", ""), os.environ.get("SWISH_MCP_API_KEY", ""))
+
+    @property
+    def path(self) -> Path | None:
+        if not self.source or self.source.startswith(("{", "[")):
+            return None
+        return Path(self.source).expanduser()
+
+    @property
+    def enabled(self) -> bool:
+        return bool(self.keys) or self.error is not None
+
+    def load(self) -> None:
+        """Read the keys; raises ValueError if they cannot be parsed."""
+        keys: list[ApiKey] = []
+        if self.source:
+            path = self.path
+            try:
+                text = path.read_text(encoding="utf-8") if path else self.source
+                if path:
+                    self._mtime = path.stat().st_mtime
+                keys = _parse_keys(json.loads(text))
+            except (OSError, json.JSONDecodeError) as e:
+                raise ValueError(f"Cannot read SWISH_MCP_API_KEYS: {e}") from e
+        if self.single_key:
+            keys.append(ApiKey("default", hash_key(self.single_key), IMPLIED_SCOPES["admin"]))
+        self.keys = keys
+
+    def reload(self) -> bool:
+        """Re-read the keys, keeping the current ones if the new set is invalid."""
+        previous = self.keys
+        try:
+            self.load()
+        except ValueError as e:
+            self.keys = previous
+            logger.error(f"Keeping previous API keys: {e}")
+            return False
+        self.error = None
+        logger.info(f"🔑 Loaded {len(self.keys)} API keys")
+        return True
+
+    def maybe_reload(self) -> None:
+        """Reload when the key file changed since it was last read."""
+        path = self.path
+        if path is None:
+            return
+        try:
+            mtime = path.stat().st_mtime
+        except OSError:
+            return
+        if mtime != self._mtime:
+            self.reload()
+
+    def authenticate(self, token: str) -> ApiKey | None:
+        digest = hash_key(token)
+        match = None
+        for key in self.keys:
+            # Compare against every key so timing does not reveal which matched
+            if hmac.compare_digest(key.digest, digest):
+                match = key
+        return match
+
+
+def enforce_tool_scopes(server: FastMCP, current_key: Callable[[], ApiKey | None]) -> None:
+    """
+    Refuse tool calls the caller's key is not scoped for.
+
+    Calls without a key (stdio, or HTTP with authentication disabled) are
+    not restricted.
+    """
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        key = current_key()
+        scope = required_scope(name)
+        if key is not None and not key.allows(scope):
+            logger.warning(f"API key '{key.key_id}' refused {name}: needs {scope} scope")
+            raise ToolError(f"API key '{key.key_id}' lacks the {scope} scope needed for {name}")
+        return await base_call_tool(name, arguments, *args, **kwargs)
+
+    tool_manager.call_tool = call_tool
+
+
+ASGIApp = Callable[[MutableMapping[str, Any], Callable[[], Awaitable[Any]], Callable[[Any], Awaitable[None]]], Awaitable[None]]
+
+
+class BearerAuthMiddleware:
+    """ASGI middleware rejecting HTTP requests without a valid bearer key."""
+
+    def __init__(self, app: ASGIApp, store: ApiKeyStore):
+        self.app = app
+        self.store = store
+
+    async def __call__(self, scope: MutableMapping[str, Any], receive: Any, send: Any) -> None:
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        self.store.maybe_reload()
+        headers = dict(scope.get("headers") or [])
+        authorization = headers.get(b"authorization", b"").decode("latin-1")
+        scheme, _, token = authorization.partition(" ")
+        key = self.store.authenticate(token.strip()) if scheme.lower() == "bearer" and token else None
+        if key is None:
+            await self._reject(send)
+            return
+
+        # Starlette's Request.state reads scope["state"]; tools find the key there
+        scope.setdefault("state", {})["api_key"] = key
+        await self.app(scope, receive, send)
+
+    async def _reject(self, send: Any) -> None:
+        body = json.dumps({"error": "unauthorized", "message": "A valid bearer API key is required"}).encode()
+        await send({
+            "type": "http.response.start",
+            "status": 401,
+            "headers": [
+                (b"content-type", b"application/json"),
+                (b"www-authenticate", b'Bearer realm="docker-swish-mcp"'),
+                (b"content-length", str(len(body)).encode()),
+            ],
+        })
+        await send({"type": "http.response.body", "body": body})
@@ -0,0 +1,85 @@
+"""
+Transactional Query Batches for Docker SWISH MCP
+
+A batch is a list of goals run in order inside one SWI-Prolog
+transaction/1 by mcp_batch/3 (see mcp_helpers.pl): either every goal
+succeeds and all their asserts and retracts are kept, or the first goal to
+fail or raise stops the batch and the database is left as it was.
+
+Only dynamic predicates are covered by the transaction; other side effects
+(output, flags, files) are not undone.
+"""
+
+from dataclasses import dataclass, field
+from typing import Any
+
+from .simple_session import clean_query_text, prolog_string
+
+MAX_BATCH_GOALS = 100
+
+
+@dataclass
+class BatchResult:
+    """Per-goal rows of a batch and whether its changes were kept."""
+    goals: list[str]
+    rows: list[dict[str, Any]] = field(default_factory=list)
+    committed: bool = False
+
+    @classmethod
+    def from_rows(cls, goals: list[str], rows: list[dict[str, Any]]) -> "BatchResult":
+        result = cls(goals)
+        for row in rows:
+            if "committed" in row:
+                result.committed = bool(row["committed"])
+            else:
+                result.rows.append(row)
+        return result
+
+    def to_json(self) -> dict[str, Any]:
+        results = []
+        for row in self.rows:
+            entry = {"index": row["goal"], "goal": self.goals[row["goal"] - 1], "status": row["status"]}
+            if "json" in row:
+                entry["bindings"] = row["json"]
+            if "error" in row:
+                entry["error"] = row["error"]
+            results.append(entry)
+        return {"committed": self.committed, "results": results}
+
+    def format(self) -> str:
+        lines = []
+        for row in self.rows:
+            goal = self.goals[row["goal"] - 1]
+            status = row["status"]
+            if status == "succeeded":
+                lines.append(f"  ✅ {row['goal']}. {goal} → {row.get('bindings', 'true')}")
+            elif status == "failed":
+                lines.append(f"  ❌ {row['goal']}. {goal} → failed")
+            else:
+                lines.append(f"  ❌ {row['goal']}. {goal} → error: {row.get('error', '')}")
+        skipped = len(self.goals) - len(self.rows)
+        if skipped > 0:
+            lines.append(f"  ⏭️ {skipped} remaining goal(s) not run")
+        if self.committed:
+            heading = f"📦 Batch committed: all {len(self.goals)} goal(s) succeeded"
+        else:
+            heading = "↩️ Batch rolled back: no database changes were kept"
+        return heading + "\n" + "\n".join(lines)
+
+
+def batch_goals(goals: list[str]) -> list[str]:
+    """Clean the goals of a batch; raises ValueError for an empty or oversized batch."""
+    cleaned = [clean_query_text(goal) for goal in goals if isinstance(goal, str)]
+    if len(cleaned) != len(goals):
+        raise ValueError("Every goal must be a string")
+    if not cleaned:
+        raise ValueError("The batch has no goals")
+    if any(not goal for goal in cleaned):
+        raise ValueError("The batch contains an empty goal")
+    if len(cleaned) > MAX_BATCH_GOALS:
+        raise ValueError(f"A batch may hold at most {MAX_BATCH_GOALS} goals")
+    return cleaned
+
+
+def batch_call(goals: list[str], limits_term: str) -> tuple[str, list[str]]:
+    return "mcp_batch", [f"[{', '.join(prolog_string(goal) for goal in goals)}]", limits_term]
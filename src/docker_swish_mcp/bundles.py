@@ -0,0 +1,404 @@
+"""
+Signed Knowledge Base Bundles for Docker SWISH MCP
+
+A bundle is a zip of program files for exchanging a rule base with
+another team, with evidence of what it contained and who sent it:
+
+    manifest.json   name, creation time and, for every file, its path,
+                    size and SHA-256 hash
+    manifest.sig    optional ed25519 signature of manifest.json (base64)
+    files/<path>    the files themselves
+
+kb_export_bundle signs the manifest when SWISH_MCP_BUNDLE_KEY names an
+ed25519 private key (PEM, e.g. from `openssl genpkey -algorithm ed25519`);
+the signer's public key goes into the manifest. kb_import_bundle checks
+every hash, and that nothing outside the manifest is in the zip. A
+signature must verify, and its key must be one of
+SWISH_MCP_BUNDLE_TRUSTED_KEYS (a file with one base64 public key per line,
+optionally followed by a name) when that is set. With
+SWISH_MCP_BUNDLE_SIGNATURES=required unsigned bundles are refused too.
+
+Bundles are kept in swish-bundles/ next to the data directory, like
+snapshots. Signing and verifying signatures need the cryptography package.
+"""
+
+import base64
+import hashlib
+import json
+import os
+import zipfile
+from dataclasses import dataclass, field
+from datetime import datetime, timezone
+from pathlib import Path, PurePosixPath
+from typing import Any
+
+BUNDLE_FORMAT = "docker-swish-mcp-bundle"
+BUNDLE_VERSION = 1
+MANIFEST = "manifest.json"
+SIGNATURE = "manifest.sig"
+FILES_PREFIX = "files/"
+SIGNATURE_POLICIES = ("optional", "required")
+# File types exported when no files are named
+DEFAULT_PATTERNS = ("**/*.pl", "**/*.swinb")
+# Directories of the data directory written by the server itself
+SKIPPED_DIRS = ("results", "exports", "url-cache")
+MAX_BUNDLE_BYTES = 64 * 1024 * 1024
+
+
+class BundleError(ValueError):
+    """Raised for a bundle that cannot be created, or fails verification."""
+
+
+@dataclass(frozen=True)
+class TrustedKey:
+    name: str
+    # Raw 32-byte ed25519 public key
+    key: bytes
+
+    @property
+    def key_id(self) -> str:
+        return key_id(self.key)
+
+
+@dataclass(frozen=True)
+class BundleSettings:
+    """Signing key, trusted keys and signature policy of bundles."""
+    signing_key: Path | None = None
+    trusted_keys_file: Path | None = None
+    # optional or required
+    signatures: str = "optional"
+
+    @classmethod
+    def from_env(cls) -> "BundleSettings":
+        key = os.environ.get("SWISH_MCP_BUNDLE_KEY", "").strip()
+        trusted = os.environ.get("SWISH_MCP_BUNDLE_TRUSTED_KEYS", "").strip()
+        policy = os.environ.get("SWISH_MCP_BUNDLE_SIGNATURES", "").strip().lower() or "optional"
+        return cls(
+            signing_key=Path(key).expanduser() if key else None,
+            trusted_keys_file=Path(trusted).expanduser() if trusted else None,
+            signatures=policy if policy in SIGNATURE_POLICIES else "optional",
+        )
+
+    def trusted_keys(self) -> list[TrustedKey]:
+        """The keys of trusted_keys_file; raises BundleError if it is unreadable."""
+        if self.trusted_keys_file is None:
+            return []
+        try:
+            text = self.trusted_keys_file.read_text(encoding="utf-8")
+        except OSError as e:
+            raise BundleError(f"Cannot read SWISH_MCP_BUNDLE_TRUSTED_KEYS: {e}") from e
+        keys = []
+        for number, line in enumerate(text.splitlines(), start=1):
+            line = line.strip()
+            if not line or line.startswith("#"):
+                continue
+            encoded, _, name = line.partition(" ")
+            try:
+                key = base64.b64decode(encoded, validate=True)
+            except ValueError:
+                key = b""
+            if len(key) != 32:
+                raise BundleError(f"Line {number} of {self.trusted_keys_file} is not a base64 ed25519 public key")
+            keys.append(TrustedKey(name.strip() or key_id(key), key))
+        return keys
+
+
+@dataclass
+class BundleFile:
+    path: str
+    sha256: str
+    size: int
+
+
+@dataclass
+class Verification:
+    """What kb_import_bundle found out about a bundle."""
+    name: str
+    created: str
+    files: list[BundleFile] = field(default_factory=list)
+    signed: bool = False
+    key_id: str = ""
+    # Base64 public key of the signer
+    public_key: str = ""
+    # Name of the trusted key that signed it; "" if unsigned or no keys are configured
+    trusted_as: str = ""
+
+    def describe(self) -> str:
+        if not self.signed:
+            return "unsigned"
+        if self.trusted_as:
+            return f"signed by trusted key {self.trusted_as} ({self.key_id})"
+        return f"signed by key {self.key_id} (no trusted keys configured)"
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "name": self.name,
+            "created": self.created,
+            "files": [file.__dict__ for file in self.files],
+            "signed": self.signed,
+            "key_id": self.key_id or None,
+            "public_key": self.public_key or None,
+            "trusted_as": self.trusted_as or None,
+        }
+
+
+def bundle_dir_for(data_dir: Path) -> Path:
+    """Directory holding the bundles of a data directory (kept outside the mount)."""
+    return data_dir.parent / "swish-bundles" / data_dir.name
+
+
+def key_id(public_key: bytes) -> str:
+    return hashlib.sha256(public_key).hexdigest()[:16]
+
+
+def _crypto() -> Any:
+    try:
+        from cryptography.hazmat.primitives.asymmetric import ed25519
+    except ImportError as e:
+        raise BundleError("Signed bundles need the cryptography package (pip install 'docker-swish-mcp[signing]')") from e
+    return ed25519
+
+
+def load_signing_key(path: Path) -> Any:
+    """The ed25519 private key in a PEM file."""
+    ed25519 = _crypto()
+    from cryptography.hazmat.primitives import serialization
+    try:
+        key = serialization.load_pem_private_key(path.read_bytes(), password=None)
+    except (OSError, ValueError, TypeError) as e:
+        raise BundleError(f"Cannot load the bundle signing key {path}: {e}") from e
+    if not isinstance(key, ed25519.Ed25519PrivateKey):
+        raise BundleError(f"{path} is not an ed25519 private key")
+    return key
+
+
+def public_key_bytes(private_key: Any) -> bytes:
+    from cryptography.hazmat.primitives import serialization
+    return private_key.public_key().public_bytes(serialization.Encoding.Raw, serialization.PublicFormat.Raw)
+
+
+def safe_member_path(path: str) -> str:
+    """path as a relative POSIX path; raises BundleError for absolute paths or ones with .."""
+    pure = PurePosixPath(path)
+    if not path or pure.is_absolute() or ".." in pure.parts or "\\" in path:
+        raise BundleError(f"Bundle path '{path}' is not a plain relative path")
+    return pure.as_posix()
+
+
+def select_files(data_dir: Path, patterns: list[str] | None) -> list[Path]:
+    """The files to export: those matching patterns, or every program and notebook."""
+    root = data_dir.resolve()
+    chosen: dict[str, Path] = {}
+    for pattern in patterns or DEFAULT_PATTERNS:
+        safe_member_path(pattern)
+        matches = [path for path in root.glob(pattern) if path.is_file() and path.resolve().is_relative_to(root)]
+        if patterns and not matches:
+            raise BundleError(f"No files in the data directory match '{pattern}'")
+        for path in matches:
+            relative = path.relative_to(root)
+            if not patterns and (relative.parts[0] in SKIPPED_DIRS or any(part.startswith(".") for part in relative.parts)):
+                continue
+            chosen[relative.as_posix()] = path
+    if not chosen:
+        raise BundleError("No program files to export")
+    return [chosen[name] for name in sorted(chosen)]
+
+
+def export_bundle(
+    data_dir: Path,
+    name: str,
+    patterns: list[str] | None,
+    settings: BundleSettings,
+    sign: bool = True
+) -> tuple[Path, Verification]:
+    """Write a bundle of the selected files; signed when sign and a signing key is configured."""
+    safe_name = "".join(c if c.isalnum() or c in "-_" else "_" for c in name) or "kb"
+    root = data_dir.resolve()
+    files = select_files(data_dir, patterns)
+    created = datetime.now(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+    entries = []
+    contents = {}
+    for path in files:
+        data = path.read_bytes()
+        relative = path.relative_to(root).as_posix()
+        contents[relative] = data
+        entries.append(BundleFile(relative, hashlib.sha256(data).hexdigest(), len(data)))
+
+    manifest: dict[str, Any] = {
+        "format": BUNDLE_FORMAT,
+        "version": BUNDLE_VERSION,
+        "name": name,
+        "created": created,
+        "files": [entry.__dict__ for entry in entries],
+    }
+    private_key = None
+    if sign and settings.signing_key is not None:
+        private_key = load_signing_key(settings.signing_key)
+        public_key = public_key_bytes(private_key)
+        manifest["signer"] = {"key_id": key_id(public_key), "public_key": base64.b64encode(public_key).decode()}
+    manifest_bytes = json.dumps(manifest, indent=2, sort_keys=True).encode("utf-8")
+
+    target_dir = bundle_dir_for(data_dir)
+    target_dir.mkdir(parents=True, exist_ok=True)
+    stamp = datetime.now().strftime("%Y%m%d-%H%M%S")
+    bundle = target_dir / f"{safe_name}-{stamp}.zip"
+    with zipfile.ZipFile(bundle, "w", compression=zipfile.ZIP_DEFLATED) as archive:
+        archive.writestr(MANIFEST, manifest_bytes)
+        if private_key is not None:
+            archive.writestr(SIGNATURE, base64.b64encode(private_key.sign(manifest_bytes)))
+        for relative, data in contents.items():
+            archive.writestr(FILES_PREFIX + relative, data)
+
+    verification = Verification(name, created, entries, signed=private_key is not None)
+    if private_key is not None:
+        verification.key_id = manifest["signer"]["key_id"]
+        verification.public_key = manifest["signer"]["public_key"]
+    return bundle, verification
+
+
+def resolve_bundle(data_dir: Path, name: str) -> Path:
+    """A bundle by file name in swish-bundles/, or by path."""
+    candidate = bundle_dir_for(data_dir) / name
+    if "/" not in name and candidate.is_file():
+        return candidate
+    path = Path(name).expanduser()
+    if path.is_file():
+        return path
+    raise FileNotFoundError(f"No bundle '{name}' in {bundle_dir_for(data_dir)}")
+
+
+def list_bundles(data_dir: Path) -> list[Path]:
+    directory = bundle_dir_for(data_dir)
+    if not directory.is_dir():
+        return []
+    return sorted(directory.glob("*.zip"), key=lambda path: path.stat().st_mtime, reverse=True)
+
+
+def verify_bundle(bundle: Path, settings: BundleSettings) -> tuple[Verification, dict[str, bytes]]:
+    """
+    Check a bundle's hashes and signature against the trust settings.
+
+    Returns:
+        What was verified, and the contents of its files by path
+
+    Raises:
+        BundleError: if anything does not match, the signature is invalid
+            or untrusted, or an unsigned bundle is refused by the policy
+    """
+    if bundle.stat().st_size > MAX_BUNDLE_BYTES:
+        raise BundleError(f"{bundle.name} is larger than {MAX_BUNDLE_BYTES // (1024 * 1024)} MiB")
+    try:
+        archive = zipfile.ZipFile(bundle)
+    except zipfile.BadZipFile as e:
+        raise BundleError(f"{bundle.name} is not a zip file") from e
+    with archive:
+        names = set(archive.namelist())
+        if MANIFEST not in names:
+            raise BundleError(f"{bundle.name} has no {MANIFEST}")
+        manifest_bytes = archive.read(MANIFEST)
+        try:
+            manifest = json.loads(manifest_bytes)
+        except ValueError as e:
+            raise BundleError(f"{MANIFEST} is not valid JSON: {e}") from e
+        if not isinstance(manifest, dict) or manifest.get("format") != BUNDLE_FORMAT:
+            raise BundleError(f"{bundle.name} is not a knowledge base bundle")
+        if manifest.get("version") != BUNDLE_VERSION:
+            raise BundleError(f"Unsupported bundle version {manifest.get('version')!r}")
+
+        verification = Verification(str(manifest.get("name", "")), str(manifest.get("created", "")))
+        if SIGNATURE in names:
+            _check_signature(verification, manifest, manifest_bytes, archive.read(SIGNATURE), settings)
+        elif settings.signatures == "required":
+            raise BundleError(f"{bundle.name} is not signed, and SWISH_MCP_BUNDLE_SIGNATURES is required")
+
+        contents = {}
+        for entry in manifest.get("files", []):
+            path = safe_member_path(str(entry.get("path", "")))
+            member = FILES_PREFIX + path
+            if member not in names:
+                raise BundleError(f"{path} is listed in the manifest but missing from the bundle")
+            data = archive.read(member)
+            digest = hashlib.sha256(data).hexdigest()
+            if digest != entry.get("sha256") or len(data) != entry.get("size"):
+                raise BundleError(f"{path} does not match its hash in the manifest: the bundle was modified")
+            contents[path] = data
+            verification.files.append(BundleFile(path, digest, len(data)))
+        listed = {FILES_PREFIX + path for path in contents}
+        extra = sorted(name for name in names - {MANIFEST, SIGNATURE} - listed if not name.endswith("/"))
+        if extra:
+            raise BundleError(f"The bundle contains files not in its manifest: {', '.join(extra)}")
+    return verification, contents
+
+
+def _check_signature(
+    verification: Verification,
+    manifest: dict[str, Any],
+    manifest_bytes: bytes,
+    signature_text: bytes,
+    settings: BundleSettings
+) -> None:
+    ed25519 = _crypto()
+    from cryptography.exceptions import InvalidSignature
+    signer = manifest.get("signer")
+    if not isinstance(signer, dict):
+        raise BundleError(f"The bundle has a signature but its {MANIFEST} names no signer")
+    try:
+        public_key = base64.b64decode(str(signer.get("public_key", "")), validate=True)
+        signature = base64.b64decode(signature_text.strip(), validate=True)
+        ed25519.Ed25519PublicKey.from_public_bytes(public_key).verify(signature, manifest_bytes)
+    except InvalidSignature as e:
+        raise BundleError("The manifest signature does not verify: the bundle was modified or mis-signed") from e
+    except ValueError as e:
+        raise BundleError(f"The bundle signature cannot be read: {e}") from e
+    verification.signed = True
+    verification.key_id = key_id(public_key)
+    verification.public_key = base64.b64encode(public_key).decode()
+
+    trusted = settings.trusted_keys()
+    if not trusted:
+        return
+    for key in trusted:
+        if key.key == public_key:
+            verification.trusted_as = key.name
+            return
+    raise BundleError(f"The bundle is signed by key {verification.key_id}, which is not in SWISH_MCP_BUNDLE_TRUSTED_KEYS")
+
+
+def install_files(
+    data_dir: Path,
+    contents: dict[str, bytes],
+    target: str = "",
+    overwrite: bool = False
+) -> tuple[list[str], list[str]]:
+    """
+    Write a verified bundle's files under data_dir/target.
+
+    Returns:
+        The paths written, and those skipped because an identical file exists
+
+    Raises:
+        BundleError: if a different file exists and overwrite is False
+    """
+    root = data_dir.resolve()
+    base = root / safe_member_path(target) if target.strip() else root
+    if not base.resolve().is_relative_to(root):
+        raise BundleError(f"target '{target}' is outside the data directory")
+    written, unchanged, conflicts = [], [], []
+    for path, data in contents.items():
+        destination = base / path
+        if destination.is_file():
+            if destination.read_bytes() == data:
+                unchanged.append(path)
+                continue
+            if not overwrite:
+                conflicts.append(path)
+    if conflicts:
+        raise BundleError(f"These files exist with other contents: {', '.join(conflicts)}. Pass overwrite=True to replace them")
+    for path, data in contents.items():
+        if path in unchanged:
+            continue
+        destination = base / path
+        destination.parent.mkdir(parents=True, exist_ok=True)
+        destination.write_bytes(data)
+        written.append(destination.relative_to(root).as_posix())
+    return written, unchanged
@@ -0,0 +1,140 @@
+"""
+Query Cancellation for Docker SWISH MCP
+
+A query execute_prolog_query runs can be stopped while it runs, without
+touching other queries or sessions:
+
+- cancel_query(query_id) stops one of the queries cancel_query() lists;
+  stream mode also names the query_id in every progress notification
+- an MCP notifications/cancelled for the tool call does the same, as the
+  SDK cancels the task of the call
+
+Both come down to cancelling that task. Where the query runs decides
+what happens to it then:
+
+- in the persistent session, swipl gets SIGINT, on which the running
+  goal raises mcp_cancelled (see mcp_interrupt/1 in mcp_helpers.pl).
+  The session keeps its process and state; the lines the goal still
+  prints carry its own query id, which later queries skip. A goal that
+  catches every exception swallows the interrupt and runs on to its own
+  limits, and with the nerdctl runtime the signal only reaches the
+  nerdctl process
+- on an isolated pengine, the pengine is aborted
+- in an isolated swipl process (execution mode exec), the process is
+  killed
+
+A query still waiting for the session or a worker just stops waiting.
+
+The registry also keeps the last RECENT_QUERIES finished queries, which
+the dashboard lists.
+"""
+
+import asyncio
+import time
+import uuid
+from collections import deque
+from collections.abc import Iterator
+from contextlib import contextmanager
+from contextvars import ContextVar
+from dataclasses import dataclass, field
+
+RECENT_QUERIES = 50
+
+# The running query of the current tool call, for progress notifications
+current_query: ContextVar["RunningQuery | None"] = ContextVar("current_query", default=None)
+
+
+@dataclass
+class RunningQuery:
+    query_id: str
+    client_id: str
+    goal: str
+    # "session" or "isolated"
+    where: str
+    instance: str = ""
+    started: float = field(default_factory=time.time)
+    task: asyncio.Task | None = None
+    # Client that called cancel_query, "" until one does
+    cancelled_by: str = ""
+
+    def describe(self) -> str:
+        where = f"{self.where}, {self.instance}" if self.instance else self.where
+        return f"{self.query_id}: {self.goal} ({where}, running {time.time() - self.started:.1f}s)"
+
+
+@dataclass
+class FinishedQuery:
+    query_id: str
+    client_id: str
+    goal: str
+    where: str
+    instance: str
+    started: float
+    seconds: float
+    # "done", "cancelled" or "error"; a goal that failed or raised a Prolog error is still done
+    status: str
+
+
+class QueryRegistry:
+    """The queries running right now, by query id."""
+
+    def __init__(self) -> None:
+        self.running: dict[str, RunningQuery] = {}
+        self.recent: deque[FinishedQuery] = deque(maxlen=RECENT_QUERIES)
+        self.counter = 0
+
+    @contextmanager
+    def track(self, client_id: str, goal: str, where: str, instance: str = "") -> Iterator[RunningQuery]:
+        """Register the current task's query for as long as the block runs."""
+        self.counter += 1
+        query = RunningQuery(
+            f"c{self.counter}x{uuid.uuid4().hex[:6]}", client_id, goal, where, instance,
+            task=asyncio.current_task()
+        )
+        self.running[query.query_id] = query
+        token = current_query.set(query)
+        status = "error"
+        try:
+            yield query
+            status = "done"
+        except asyncio.CancelledError:
+            status = "cancelled"
+            raise
+        finally:
+            current_query.reset(token)
+            self.running.pop(query.query_id, None)
+            if query.cancelled_by:
+                status = "cancelled"
+            self.recent.append(FinishedQuery(
+                query.query_id, client_id, goal, where, instance, query.started,
+                round(time.time() - query.started, 3), status
+            ))
+
+    def visible(self, client_id: str, everyone: bool = False) -> list[RunningQuery]:
+        """The running queries of client_id, or of every client."""
+        return [q for q in self.running.values() if everyone or q.client_id == client_id]
+
+    def cancel(self, query_id: str, client_id: str, everyone: bool = False) -> RunningQuery:
+        """
+        Cancel the task running query_id.
+
+        Raises:
+            ValueError: if no such query is running, or it belongs to
+                another client and everyone is not set
+        """
+        query = self.running.get(query_id)
+        if query is None:
+            raise ValueError(f"No running query '{query_id}'; it may have finished already")
+        if not everyone and query.client_id != client_id:
+            raise ValueError(f"{query_id} was started by another client")
+        if query.task is None or query.task.done():
+            raise ValueError(f"{query_id} is finishing and can no longer be cancelled")
+        query.cancelled_by = client_id
+        query.task.cancel()
+        return query
+
+
+def uncancel(task: asyncio.Task | None) -> None:
+    """Take back a cancellation that was handled, so the task's later awaits run (Python 3.11+)."""
+    if task is not None and hasattr(task, "uncancel"):
+        task.uncancel()
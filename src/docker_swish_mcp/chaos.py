@@ -0,0 +1,269 @@
+"""
+Chaos Mode for Docker SWISH MCP
+
+A developer setting that makes the server a flaky backend on purpose, so
+authors of MCP clients and agents can see how they cope. With
+SWISH_MCP_CHAOS=on every tool call may, each with its own probability:
+
+- latency: wait up to SWISH_MCP_CHAOS_MAX_DELAY seconds (default 3)
+  before running (SWISH_MCP_CHAOS_LATENCY, default 0.2)
+- error: not run at all and return a typed error instead
+  (SWISH_MCP_CHAOS_ERRORS, default 0.05). The errors are the transient
+  kinds a real outage produces (transport, timeout, not_ready,
+  resource_error, ...) and always valid by ERROR_SCHEMA, but awkward:
+  empty, multi-line, very long or non-ASCII messages, and keys no
+  client has seen before
+- restart: have the container restarted while it runs, as if it had
+  crashed (SWISH_MCP_CHAOS_RESTARTS, default 0.01)
+
+and each notification the server sends (resources/updated, list
+changes, fact feed log messages, progress) may be dropped
+(SWISH_MCP_CHAOS_DROPS, default 0.1). SWISH_MCP_CHAOS_SEED makes a run
+repeatable and SWISH_MCP_CHAOS_TOOLS=execute_prolog_query,consult_file
+limits the faults to those tools. chaos_status is never disturbed and
+reports what was injected. Never turn this on for real users.
+"""
+
+import asyncio
+import logging
+import os
+import random
+import time
+from collections import Counter, deque
+from collections.abc import Awaitable, Callable
+from dataclasses import dataclass
+from typing import Any
+
+from .errors import ToolError
+
+logger = logging.getLogger("docker-swish-mcp.chaos")
+
+CHAOS_MODES = ("off", "on")
+CHAOS_FAULTS = ("latency", "error", "restart", "drop")
+# Tools chaos mode leaves alone, so a client can always find out what is going on
+EXEMPT_TOOLS = ("chaos_status",)
+# ServerSession methods whose notifications may be dropped
+NOTIFICATIONS = (
+    "send_resource_updated",
+    "send_resource_list_changed",
+    "send_tool_list_changed",
+    "send_prompt_list_changed",
+    "send_log_message",
+    "send_progress_notification",
+)
+MAX_RECENT = 50
+
+# Kind, message and details of the errors injected
+INJECTED_ERRORS: tuple[tuple[str, str, dict[str, Any]], ...] = (
+    ("transport", "SWISH did not answer: connection reset by peer", {}),
+    ("transport", "SWISH answered 503 Service Unavailable", {"status": 503}),
+    ("timeout", "Query exceeded time limit", {"limit": "wall"}),
+    ("not_ready", "SWISH container is not ready. Please wait a moment and try again.", {}),
+    ("resource_error", "Out of global stack", {"culprit": "global"}),
+    ("resource_limit", "CPU time limit exceeded", {"limit": "cpu"}),
+    ("prolog_error", "Unhandled exception: unknown message", {}),
+    ("internal", "Failed to run query", {}),
+)
+
+
+def awkward_message(rng: random.Random, message: str) -> str:
+    """message as it arrives from a misbehaving backend, now and then."""
+    variant = rng.randrange(6)
+    if variant == 1:
+        return ""
+    if variant == 2:
+        return f"{message}\n  at swish:pengine_rpc/3\n  at http_dispatch/1"
+    if variant == 3:
+        return f"{message}: " + "x" * 4000
+    if variant == 4:
+        return f"{message} – réessayez plus tard ⏳ 稍后重试"
+    return message
+
+
+def awkward_details(rng: random.Random, details: dict[str, Any]) -> dict[str, Any]:
+    extra = rng.choice([
+        {},
+        {"retry_after": round(rng.uniform(0.1, 30), 1)},
+        {"trace_id": f"{rng.getrandbits(64):016x}", "attempt": rng.randint(1, 5)},
+        {"detail": {"upstream": "swish", "code": None, "hints": []}},
+    ])
+    return {**details, **extra}
+
+
+def _probability(name: str, default: float) -> float:
+    try:
+        value = float(os.environ.get(name, default))
+    except ValueError:
+        return default
+    return min(max(value, 0.0), 1.0)
+
+
+@dataclass(frozen=True)
+class ChaosSettings:
+    enabled: bool = False
+    # Probability of each fault per tool call, or per notification for drops
+    latency: float = 0.2
+    errors: float = 0.05
+    restarts: float = 0.01
+    drops: float = 0.1
+    max_delay: float = 3.0
+    seed: int | None = None
+    # Tools the faults apply to; empty means all
+    tools: tuple[str, ...] = ()
+
+    @classmethod
+    def from_env(cls) -> "ChaosSettings":
+        seed = os.environ.get("SWISH_MCP_CHAOS_SEED", "").strip()
+        tools = os.environ.get("SWISH_MCP_CHAOS_TOOLS", "")
+        try:
+            max_delay = max(float(os.environ.get("SWISH_MCP_CHAOS_MAX_DELAY", 3.0)), 0.0)
+        except ValueError:
+            max_delay = 3.0
+        return cls(
+            enabled=os.environ.get("SWISH_MCP_CHAOS", "").strip().lower() == "on",
+            latency=_probability("SWISH_MCP_CHAOS_LATENCY", 0.2),
+            errors=_probability("SWISH_MCP_CHAOS_ERRORS", 0.05),
+            restarts=_probability("SWISH_MCP_CHAOS_RESTARTS", 0.01),
+            drops=_probability("SWISH_MCP_CHAOS_DROPS", 0.1),
+            max_delay=max_delay,
+            seed=int(seed) if seed.lstrip("-").isdigit() else None,
+            tools=tuple(tool.strip() for tool in tools.split(",") if tool.strip()),
+        )
+
+    def describe(self) -> str:
+        scope = ", ".join(self.tools) if self.tools else "all tools"
+        return (
+            f"latency {self.latency:.0%} (up to {self.max_delay:g}s), errors {self.errors:.0%}, "
+            f"restarts {self.restarts:.0%}, dropped notifications {self.drops:.0%}; {scope}"
+        )
+
+
+class ChaosMonkey:
+    """Decides which faults to inject and remembers the ones it did."""
+
+    def __init__(self, settings: ChaosSettings):
+        self.settings = settings
+        self.random = random.Random(settings.seed)
+        self.injected: Counter[str] = Counter()
+        self.calls = 0
+        # (time, fault, tool or notification)
+        self.recent: deque[tuple[float, str, str]] = deque(maxlen=MAX_RECENT)
+
+    def applies(self, tool: str) -> bool:
+        if not self.settings.enabled or tool in EXEMPT_TOOLS:
+            return False
+        return not self.settings.tools or tool in self.settings.tools
+
+    def roll(self, fault: str, target: str) -> bool:
+        probability = {
+            "latency": self.settings.latency,
+            "error": self.settings.errors,
+            "restart": self.settings.restarts,
+            "drop": self.settings.drops,
+        }[fault]
+        if probability <= 0 or self.random.random() >= probability:
+            return False
+        self.injected[fault] += 1
+        self.recent.append((time.time(), fault, target))
+        return True
+
+    def delay(self) -> float:
+        return self.random.uniform(0, self.settings.max_delay)
+
+    def error(self) -> ToolError:
+        kind, message, details = self.random.choice(INJECTED_ERRORS)
+        return ToolError(kind, awkward_message(self.random, message), awkward_details(self.random, details))
+
+    def status(self) -> dict[str, Any]:
+        return {
+            "enabled": self.settings.enabled,
+            "settings": self.settings.describe(),
+            "calls": self.calls,
+            "injected": {fault: self.injected[fault] for fault in CHAOS_FAULTS},
+            "recent": [
+                {"time": when, "fault": fault, "target": target}
+                for when, fault, target in reversed(self.recent)
+            ],
+        }
+
+
+def install_chaos(
+    server: Any,
+    monkey: ChaosMonkey,
+    restart: Callable[[dict[str, Any]], Awaitable[Any]],
+    spawn: Callable[[Awaitable[Any]], Any]
+) -> None:
+    """
+    Inject monkey's faults into the tool calls and notifications of a FastMCP server.
+
+    restart restarts the container a call's arguments name; spawn runs a
+    coroutine in the background.
+    """
+    if not monkey.settings.enabled:
+        return
+    logger.warning(f"🐒 Chaos mode is on: {monkey.settings.describe()}")
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def restart_later(arguments: dict[str, Any]) -> None:
+        # Land while the call is still running, now and then
+        await asyncio.sleep(monkey.random.uniform(0, 1))
+        try:
+            await restart(arguments)
+        except Exception as e:
+            logger.warning(f"🐒 Injected restart failed: {e}")
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        if not monkey.applies(name):
+            return await base_call_tool(name, arguments, *args, **kwargs)
+        monkey.calls += 1
+        if monkey.roll("latency", name):
+            await asyncio.sleep(monkey.delay())
+        if monkey.roll("restart", name):
+            logger.info(f"🐒 Restarting the container during {name}")
+            spawn(restart_later(dict(arguments or {})))
+        if monkey.roll("error", name):
+            text = monkey.error().render()
+            logger.info(f"🐒 Injected an error into {name}")
+            if not kwargs.get("convert_result"):
+                return text
+            from mcp.types import TextContent
+            return [TextContent(type="text", text=text)]
+        return await base_call_tool(name, arguments, *args, **kwargs)
+
+    tool_manager.call_tool = call_tool
+
+    try:
+        from mcp.server.session import ServerSession
+    except ImportError:
+        logger.warning("🐒 This mcp version has no ServerSession; notifications are not dropped")
+        return
+    for method in NOTIFICATIONS:
+        base = getattr(ServerSession, method, None)
+        if base is not None:
+            setattr(ServerSession, method, dropping(monkey, method, base))
+
+
+def dropping(monkey: ChaosMonkey, method: str, base: Callable[..., Awaitable[Any]]) -> Callable[..., Awaitable[Any]]:
+    async def send(self: Any, *args: Any, **kwargs: Any) -> Any:
+        if monkey.roll("drop", method.removeprefix("send_")):
+            return None
+        return await base(self, *args, **kwargs)
+
+    return send
+
+
+def format_chaos_status(status: dict[str, Any]) -> str:
+    if not status["enabled"]:
+        return "🐒 Chaos mode is off (start the server with SWISH_MCP_CHAOS=on to inject faults)"
+    injected = ", ".join(f"{count} {fault}" for fault, count in status["injected"].items())
+    lines = [
+        f"🐒 Chaos mode is on: {status['settings']}",
+        f"📊 {status['calls']} call(s) exposed; injected {injected}",
+    ]
+    if status["recent"]:
+        lines.append("Recent faults:")
+        for entry in status["recent"][:20]:
+            stamp = time.strftime("%H:%M:%S", time.localtime(entry["time"]))
+            lines.append(f"  • {stamp} {entry['fault']} → {entry['target']}")
+    return "\n".join(lines)
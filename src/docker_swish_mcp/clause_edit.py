@@ -0,0 +1,151 @@
+"""
+Clause-Level Source Edits for Docker SWISH MCP
+
+clause_insert, clause_replace and clause_retract change one clause of a
+.pl file without rewriting the rest of it. SWI-Prolog reads the file
+with mcp_clause_spans/4 (see mcp_helpers.pl), which reports the
+character range of every clause (directives are skipped); the edit is
+then made on the file text, so comments, blank lines and the layout of
+the other clauses stay exactly as they were.
+
+- an inserted clause goes on a line of its own after the line where
+  the clause before it ends (or before the first clause of its
+  predicate); a predicate the file does not define yet is appended
+- a replaced clause keeps its place and whatever shares its lines
+- a retracted clause takes its line with it when nothing else is on it
+"""
+
+from dataclasses import dataclass
+from typing import Any
+
+from .rdf import prolog_atom
+
+
+class ClauseEditError(ValueError):
+    """Raised when the clause to edit does not exist or the edit is invalid."""
+
+
+@dataclass
+class ClauseSpan:
+    """One clause of a file: its predicate and where its text is."""
+    predicate: str
+    # Character offsets of the clause, up to and including its full stop
+    start: int
+    end: int
+    line: int
+    matches: bool = False
+
+
+def predicate_indicator(text: str) -> str:
+    """Name/Arity for text, with a DCG's Name//Arity counted as Name/(Arity+2)."""
+    name, dcg, arity = text.strip().rpartition("//")
+    if not dcg:
+        name, _, arity = text.strip().rpartition("/")
+    if not name or not arity.isdigit():
+        raise ClauseEditError(f"Invalid predicate indicator '{text}'; use Name/Arity, e.g. parent/2")
+    return f"{name}/{int(arity) + (2 if dcg else 0)}"
+
+
+def spans_call(path: str, clause: str = "", head: str = "") -> tuple[str, list[str]]:
+    return "mcp_clause_spans", [prolog_atom(path), prolog_atom(clause), prolog_atom(head)]
+
+
+def clause_text(clause: str) -> str:
+    """clause without surrounding layout, ending in a full stop."""
+    text = clause.strip()
+    if not text:
+        raise ClauseEditError("Empty clause")
+    return text if text.endswith(".") else f"{text}."
+
+
+def parse_spans(text: str, rows: list[dict[str, Any]]) -> tuple[list[ClauseSpan], str]:
+    """The file's clause spans, and the predicate of the new clause ("" if none was given)."""
+    spans = []
+    new_predicate = ""
+    for row in rows:
+        if row.get("new"):
+            new_predicate = row["predicate"]
+            continue
+        stop = text.find(".", int(row["end"]))
+        end = stop + 1 if stop >= 0 else int(row["end"])
+        spans.append(ClauseSpan(row["predicate"], int(row["start"]), end, int(row["line"]), bool(row.get("matches"))))
+    return spans, new_predicate
+
+
+def predicate_spans(spans: list[ClauseSpan], predicate: str) -> list[ClauseSpan]:
+    return [span for span in spans if span.predicate == predicate]
+
+
+def nth_span(spans: list[ClauseSpan], predicate: str, index: int) -> ClauseSpan:
+    """The index-th (from 1) clause of predicate in the file."""
+    clauses = predicate_spans(spans, predicate)
+    if not clauses:
+        raise ClauseEditError(f"The file has no clauses for {predicate}")
+    if not 1 <= index <= len(clauses):
+        raise ClauseEditError(f"{predicate} has {len(clauses)} clause(s) in the file; there is no clause {index}")
+    return clauses[index - 1]
+
+
+def line_start(text: str, offset: int) -> int:
+    return text.rfind("\n", 0, offset) + 1
+
+
+def line_end(text: str, offset: int) -> int:
+    """Offset just past the newline of the line offset is on."""
+    newline = text.find("\n", offset)
+    return len(text) if newline < 0 else newline + 1
+
+
+def insert_clause(text: str, spans: list[ClauseSpan], predicate: str, clause: str, after: int = -1) -> tuple[str, int]:
+    """
+    text with clause inserted after the after-th clause of predicate.
+
+    after=0 inserts before the first clause, -1 after the last.
+
+    Returns:
+        The new text and the 1-based index the clause now has
+    """
+    clause = clause_text(clause)
+    clauses = predicate_spans(spans, predicate)
+    if after < 0:
+        after = len(clauses)
+    if after > len(clauses):
+        raise ClauseEditError(f"{predicate} has {len(clauses)} clause(s) in the file; cannot insert after clause {after}")
+    if not clauses:
+        separator = "" if not text or text.endswith("\n\n") else ("\n" if text.endswith("\n") else "\n\n")
+        return f"{text}{separator}{clause}\n", 1
+    if after == 0:
+        at = line_start(text, clauses[0].start)
+    else:
+        at = line_end(text, clauses[after - 1].end)
+    prefix = text[:at]
+    if prefix and not prefix.endswith("\n"):
+        prefix += "\n"
+    return f"{prefix}{clause}\n{text[at:]}", after + 1
+
+
+def replace_clause(text: str, span: ClauseSpan, clause: str) -> str:
+    return text[:span.start] + clause_text(clause) + text[span.end:]
+
+
+def remove_clauses(text: str, spans: list[ClauseSpan]) -> str:
+    """text without the given clauses; removed clauses alone on their lines take the lines along."""
+    for span in sorted(spans, key=lambda span: span.start, reverse=True):
+        start, end = span.start, span.end
+        first = line_start(text, start)
+        last = line_end(text, end)
+        if not text[first:start].strip() and not text[end:last].strip():
+            start, end = first, last
+        else:
+            while end < len(text) and text[end] in " \t":
+                end += 1
+        text = text[:start] + text[end:]
+    return text
+
+
+def retract_targets(spans: list[ClauseSpan], all_matches: bool) -> list[ClauseSpan]:
+    """The clauses whose head matched: the first one, or all of them."""
+    matches = [span for span in spans if span.matches]
+    if not matches:
+        raise ClauseEditError("No clause in the file has a head that unifies with the given head")
+    return matches if all_matches else matches[:1]
@@ -0,0 +1,346 @@
+"""
+Pair Programming with SWISH's Web UI for Docker SWISH MCP
+
+SWISH's browser clients keep a websocket open on /chat. Over it each
+client says which stored files it has open (has-open-files), and the
+server tells everyone who has a file open when someone saves a new
+version of it, chats about it, or opens or closes it. CollabBridge joins
+that websocket as one more visitor, so an agent and a person in the
+browser can work on the same file:
+
+- collab_join opens a file of SWISH's gitty storage (/p/<file>) and
+  announces it as open; the browser shows the agent among its visitors
+- saves made in the browser arrive as "updated" events; the bridge
+  fetches the new version and keeps the diff against the previous one
+  for collab_events, and sends resources/updated for swish://collab/<file>
+- collab_push saves the agent's version as a new commit on top of the
+  one it last saw, which SWISH announces to the browser, where the
+  editor offers to reload it
+
+SWISH shares saved versions, not keystrokes: edits reach the other side
+when they are saved. A save based on an outdated commit is refused by
+SWISH; the agent then has to look at the newer version first.
+
+The websocket is reopened (and the open files announced again) when the
+connection drops, e.g. across a container restart.
+"""
+
+import asyncio
+import difflib
+import json
+import logging
+import time
+from collections import deque
+from collections.abc import Awaitable, Callable
+from dataclasses import asdict, dataclass, field
+from typing import Any
+from urllib.parse import quote, urlencode
+
+import aiohttp
+
+from .swish_http import SwishHttp, SwishRequestFailed
+from .swish_links import STORAGE_NAME_RE, STORAGE_PATH
+
+logger = logging.getLogger("docker-swish-mcp.collab")
+
+CHAT_PATH = "/chat"
+DEFAULT_NICKNAME = "mcp-agent"
+URI_PREFIX = "swish://collab/"
+COLLAB_URI_TEMPLATE = URI_PREFIX + "{file}"
+# Events kept until collab_events collects them
+MAX_EVENTS = 500
+RECONNECT_DELAY = 1.0
+MAX_RECONNECT_DELAY = 30.0
+
+# Message types SWISH sends about a file, by what happened to it
+FILE_EVENTS = {"updated": "updated", "deleted": "deleted", "forked": "forked", "created": "created"}
+VISITOR_EVENTS = {"opened": "joined", "closed": "left", "joined": "joined", "rejoined": "joined", "left": "left"}
+
+
+class CollabError(Exception):
+    """Raised when a file cannot be joined or saved."""
+
+
+@dataclass
+class SharedFile:
+    """A file the bridge has open, as of the last version it saw."""
+    name: str
+    commit: str = ""
+    text: str = ""
+
+
+@dataclass
+class CollabEvent:
+    """Something that happened to a file open in the bridge."""
+    # updated, deleted, forked, created, chat, joined or left
+    kind: str
+    file: str
+    at: float = field(default_factory=time.time)
+    user: str = ""
+    commit: str = ""
+    # For updates, the unified diff from the previous version
+    diff: str = ""
+    # For chat, the message
+    text: str = ""
+
+    def to_json(self) -> dict[str, Any]:
+        return {key: value for key, value in asdict(self).items() if value != ""}
+
+    def describe(self) -> str:
+        stamp = time.strftime("%H:%M:%S", time.localtime(self.at))
+        who = f" by {self.user}" if self.user else ""
+        if self.kind == "chat":
+            return f"[{stamp}] 💬 {self.user or 'someone'} on {self.file}: {self.text}"
+        line = f"[{stamp}] {self.file} {self.kind}{who}" + (f" ({self.commit[:8]})" if self.commit else "")
+        return f"{line}\n{self.diff}" if self.diff else line
+
+
+def storage_name(file: str) -> str:
+    """file (a name or a /p/ link) as a storage file name: name.pl, with only letters, digits, _ and -."""
+    stem = file.strip().rsplit("/", 1)[-1].removesuffix(".pl")
+    if not STORAGE_NAME_RE.match(stem):
+        raise ValueError(f"Invalid SWISH file name '{file}' (use letters, digits, '_' or '-')")
+    return f"{stem}.pl"
+
+
+def collab_uri(name: str) -> str:
+    return URI_PREFIX + name
+
+
+def chat_url(base_url: str, nickname: str) -> str:
+    """The websocket URL of SWISH's /chat on base_url."""
+    scheme, _, rest = base_url.rstrip("/").partition("://")
+    ws_scheme = "wss" if scheme == "https" else "ws"
+    return f"{ws_scheme}://{rest}{CHAT_PATH}?{urlencode({'nickname': nickname})}"
+
+
+def text_diff(name: str, old: str, new: str) -> str:
+    lines = difflib.unified_diff(
+        old.splitlines(keepends=True), new.splitlines(keepends=True), f"{name} (before)", f"{name} (after)"
+    )
+    return "".join(lines).rstrip("\n")
+
+
+def message_file(message: dict[str, Any]) -> str:
+    """The storage file a message is about, from its file or gitty:<file> docid."""
+    file = message.get("file") or message.get("docid") or ""
+    return str(file).removeprefix("gitty:")
+
+
+def commit_of(reply: dict[str, Any]) -> str:
+    """The commit hash in a storage reply."""
+    meta = reply.get("meta")
+    return str(reply.get("commit") or (meta.get("commit") if isinstance(meta, dict) else "") or "")
+
+
+class CollabBridge:
+    """
+    A visitor on SWISH's /chat websocket, for the files the agent has open.
+
+    Args:
+        http: HTTP client of the SWISH container, for its storage
+        nickname: Name the browser shows for the agent
+        notify: Coroutine sending resources/updated for a URI
+    """
+
+    def __init__(self, http: SwishHttp, nickname: str, notify: Callable[[str], Awaitable[None]]):
+        self.http = http
+        self.nickname = nickname
+        self.notify = notify
+        self.files: dict[str, SharedFile] = {}
+        self.events: deque[CollabEvent] = deque(maxlen=MAX_EVENTS)
+        self.arrived = asyncio.Event()
+        self.ws: Any = None
+        self.wsid = ""
+        self.connects = 0
+        self.task: asyncio.Task[None] | None = None
+
+    @property
+    def connected(self) -> bool:
+        return self.ws is not None and not self.ws.closed
+
+    async def fetch(self, name: str) -> SharedFile:
+        """The current version of a stored file."""
+        try:
+            reply = await self.http.request("GET", f"{STORAGE_PATH}{quote(name)}?format=json", timeout=15)
+        except SwishRequestFailed as e:
+            if e.status == 404:
+                raise CollabError(f"SWISH has no stored file {name}; collab_push creates it") from e
+            raise
+        data = reply.json()
+        return SharedFile(name, commit_of(data), str(data.get("data", "")))
+
+    async def join(self, name: str) -> SharedFile:
+        """Open a stored file and tell SWISH the agent has it open."""
+        shared = await self.fetch(name)
+        self.files[name] = shared
+        if self.task is None or self.task.done():
+            self.task = asyncio.create_task(self._run())
+        await self._announce()
+        return shared
+
+    async def leave(self, name: str) -> None:
+        if self.files.pop(name, None) is None:
+            raise CollabError(f"{name} is not open; collab_join it first")
+        await self._send({"type": "unload", "file": name})
+        await self._announce()
+        if not self.files:
+            await self.close()
+
+    async def push(self, name: str, text: str, message: str = "") -> SharedFile:
+        """
+        Save text as the next version of a stored file, creating it if needed.
+
+        Raises:
+            CollabError: if SWISH refuses the save, e.g. because the file
+                changed since the version the agent last saw
+        """
+        shared = self.files.get(name)
+        if shared is None:
+            try:
+                await self.fetch(name)
+            except CollabError:
+                pass
+            else:
+                raise CollabError(f"SWISH already has {name}; collab_join it first so the push builds on its current version")
+        meta: dict[str, Any] = {"name": name}
+        if message:
+            meta["commit_message"] = message
+        try:
+            if shared is not None:
+                meta["previous"] = shared.commit
+                reply = await self.http.request(
+                    "PUT", f"{STORAGE_PATH}{quote(name)}", timeout=30, idempotent=False,
+                    json={"data": text, "type": "pl", "meta": meta}
+                )
+            else:
+                reply = await self.http.request(
+                    "POST", STORAGE_PATH, timeout=30, idempotent=False,
+                    json={"data": text, "type": "pl", "meta": meta}
+                )
+        except SwishRequestFailed as e:
+            detail = e.text.strip()[:300]
+            if e.status == 409 or "modified" in detail or "previous" in detail:
+                raise CollabError(f"{name} was changed in SWISH since the version you last saw; "
+                                  "check collab_events and push again") from e
+            raise CollabError(f"SWISH refused to save {name} (HTTP {e.status}): {detail}") from e
+        data = reply.json()
+        if data.get("error"):
+            raise CollabError(f"SWISH did not save {name}: {data['error']}")
+        saved = SharedFile(name, commit_of(data), text)
+        if name in self.files:
+            self.files[name] = saved
+        return saved
+
+    def take_events(self, file: str = "") -> list[CollabEvent]:
+        """The events not collected yet, for one file or all of them."""
+        taken = [event for event in self.events if not file or event.file == file]
+        kept = [event for event in self.events if file and event.file != file]
+        self.events.clear()
+        self.events.extend(kept)
+        if not self.events:
+            self.arrived.clear()
+        return taken
+
+    async def wait(self, timeout: float) -> None:
+        """Wait up to timeout seconds for an event, unless one is waiting already."""
+        if self.events or timeout <= 0:
+            return
+        try:
+            await asyncio.wait_for(self.arrived.wait(), timeout=timeout)
+        except asyncio.TimeoutError:
+            pass
+
+    async def close(self) -> None:
+        if self.task is not None:
+            self.task.cancel()
+            try:
+                await self.task
+            except (asyncio.CancelledError, Exception):
+                pass
+            self.task = None
+        self.ws = None
+
+    def get_status(self) -> dict[str, Any]:
+        return {
+            "connected": self.connected,
+            "wsid": self.wsid,
+            "connects": self.connects,
+            "files": {name: shared.commit for name, shared in self.files.items()},
+            "pending_events": len(self.events),
+        }
+
+    async def _send(self, message: dict[str, Any]) -> None:
+        if self.connected:
+            try:
+                await self.ws.send_str(json.dumps(message))
+            except (aiohttp.ClientError, ConnectionError) as e:
+                logger.debug(f"Collaboration message not sent: {e}")
+
+    async def _announce(self) -> None:
+        await self._send({"type": "has-open-files", "files": [{"file": name} for name in self.files]})
+
+    async def _run(self) -> None:
+        """Keep the websocket open while files are open, reconnecting when it drops."""
+        delay = RECONNECT_DELAY
+        while self.files:
+            try:
+                async with aiohttp.ClientSession() as session:
+                    async with session.ws_connect(chat_url(self.http.base_url, self.nickname), heartbeat=30) as ws:
+                        self.ws = ws
+                        self.connects += 1
+                        delay = RECONNECT_DELAY
+                        await self._announce()
+                        async for frame in ws:
+                            if frame.type == aiohttp.WSMsgType.TEXT:
+                                await self._handle(json.loads(frame.data))
+                            elif frame.type in (aiohttp.WSMsgType.CLOSED, aiohttp.WSMsgType.ERROR):
+                                break
+            except asyncio.CancelledError:
+                raise
+            except Exception as e:
+                logger.debug(f"SWISH collaboration websocket: {e}")
+            self.ws = None
+            if self.files:
+                await asyncio.sleep(delay)
+                delay = min(delay * 2, MAX_RECONNECT_DELAY)
+
+    async def _handle(self, message: dict[str, Any]) -> None:
+        kind = str(message.get("type", ""))
+        if kind == "welcome":
+            self.wsid = str(message.get("wsid", ""))
+            return
+        if kind == "notify":
+            kind = str(message.get("action", ""))
+        name = message_file(message)
+        shared = self.files.get(name)
+        if shared is None:
+            return
+        user = str(message.get("nick_name") or message.get("user") or message.get("author") or "")
+
+        if kind == "chat-message":
+            self._record(CollabEvent("chat", name, user=user, text=str(message.get("text", ""))))
+        elif kind in VISITOR_EVENTS:
+            if message.get("wsid") != self.wsid:
+                self._record(CollabEvent(VISITOR_EVENTS[kind], name, user=user))
+        elif kind in FILE_EVENTS:
+            commit = str(message.get("commit", ""))
+            if kind == "updated" and commit and commit == shared.commit:
+                # The echo of the agent's own push
+                return
+            event = CollabEvent(FILE_EVENTS[kind], name, user=user, commit=commit)
+            if kind == "updated":
+                try:
+                    latest = await self.fetch(name)
+                except Exception as e:
+                    logger.debug(f"Could not fetch the new version of {name}: {e}")
+                else:
+                    event.diff = text_diff(name, shared.text, latest.text)
+                    event.commit = latest.commit or commit
+                    self.files[name] = latest
+            self._record(event)
+            await self.notify(collab_uri(name))
+
+    def _record(self, event: CollabEvent) -> None:
+        self.events.append(event)
+        self.arrived.set()
@@ -0,0 +1,721 @@
+"""
+Server Configuration for Docker SWISH MCP
+
+Global defaults are read from SWISH_MCP_* environment variables; tools may
+override individual values per call.
+
+SWISH_MCP_CONFIG may name a TOML (or, with PyYAML installed, YAML) file
+whose settings take precedence over the environment:
+
+    [container]
+    port = 3050
+    data_dir = "~/swish-data"
+    image = "swipl/swish:latest"
+    # Build the image from a Dockerfile instead; see images.py
+    # dockerfile = "~/swish-image/Dockerfile"
+    pull_policy = "always"
+    # platform = "linux/amd64"   # default auto: the daemon's own; see images.py
+    # No outbound connections but to these hosts; see network_isolation.py
+    # network = "allowlist"
+    # network_allow = ["pypi.org", "*.swi-prolog.org"]
+
+    [limits]
+    wall_seconds = 30
+    cpu_seconds = 10
+    inferences = 50000000
+
+    [prolog]
+    stack_limit = "4g"
+    table_space = "2g"
+
+    [sandbox]
+    mode = "readonly"
+    allow = ["format/2"]
+    clients = { "agent-1" = { mode = "strict" } }
+
+    [startup]
+    programs = ["family.pl", { code = "likes(mary, wine)." }]
+    on_error = "abort"
+
+The file is re-read when it changes or on SIGHUP (see config_watch.py).
+"""
+
+import logging
+import os
+import sys
+from dataclasses import dataclass, field, replace
+from pathlib import Path
+from typing import Any
+
+if sys.version_info >= (3, 11):
+    import tomllib
+else:
+    import tomli as tomllib
+
+from .auth import ApiKeyStore
+from .bundles import BundleSettings
+from .chaos import ChaosSettings
+from .container_env import ContainerEnvironment
+from .disk_usage import DiskSettings
+from .host_platform import PATH_STYLES
+from .http_serving import HttpSettings
+from .images import PULL_POLICIES, validate_image, validate_platform
+from .lifecycle import ORPHAN_POLICIES, SHUTDOWN_POLICIES
+from .network_isolation import NetworkProfile
+from .prolog_memory import PrologMemory
+from .quotas import QuotaSettings
+from .request_logging import LOG_FORMATS, LOG_LEVELS
+from .resources import ContainerResources
+from .sandbox import SandboxConfig
+from .spill import SpillThresholds
+from .swish_http import RetryPolicy
+from .telemetry import GOAL_MODES
+from .tool_profiles import ProfileSet
+from .volumes import validate_volume_name
+
+CONFIG_SECTIONS = ("container", "limits", "prolog", "sandbox", "startup")
+ISOLATION_MODES = ("auto", "on", "off")
+# Where Prolog runs: the SWISH container, a swipl installed on this machine, or swipl compiled to WebAssembly
+BACKENDS = ("container", "local", "wasm", "mock")
+# Probabilistic inference for probabilistic_query: off, or the cplint pack (see probabilistic.py)
+PROBABILISTIC_MODES = ("off", "cplint")
+# Answer set programming for scasp_query with the scasp pack (see scasp.py)
+SCASP_MODES = ("off", "on")
+# Spatial indexes for the geo tools with the space pack (see geospatial.py)
+GEO_MODES = ("off", "on")
+# Whether a loaded file that changes on disk is consulted again (see quarantine.py)
+AUTO_RELOAD_MODES = ("off", "on")
+# How isolated queries and health probes reach Prolog (see execution.py)
+EXECUTION_MODES = ("auto", "http", "exec")
+# OpenTelemetry span export over OTLP (see telemetry.py)
+OTEL_MODES = ("off", "on")
+# Whether images may be pulled from a registry (see images.py)
+OFFLINE_MODES = ("off", "on")
+# How query results print, see PrintOptions
+PRINT_STYLES = ("plain", "pretty", "clause")
+# What a startup program that fails to load does (see startup.py)
+STARTUP_POLICIES = ("warn", "abort")
+
+logger = logging.getLogger("docker-swish-mcp.config")
+
+
+def _env_float(name: str, default: float) -> float:
+    value = os.environ.get(name)
+    if value is None or value == "":
+        return default
+    try:
+        return float(value)
+    except ValueError:
+        logger.warning(f"Ignoring invalid {name}={value!r}, using {default}")
+        return default
+
+
+def _env_int(name: str, default: int) -> int:
+    return int(_env_float(name, default))
+
+
+def _env_choice(name: str, choices: tuple[str, ...], default: str) -> str:
+    value = os.environ.get(name, "").strip().lower()
+    if not value:
+        return default
+    if value not in choices:
+        logger.warning(f"Ignoring invalid {name}={value!r}, using {default}")
+        return default
+    return value
+
+
+@dataclass(frozen=True)
+class QueryLimits:
+    """
+    Resource limits applied to a single Prolog query.
+
+    A cpu_seconds or inferences value of 0 disables that limit. The wall
+    clock limit is always enforced.
+    """
+    wall_seconds: float = 30.0
+    cpu_seconds: float = 0.0
+    inferences: int = 0
+    # Bytes of the stack_limit and table_space flags while the query runs;
+    # 0 keeps the session's (see prolog_memory.py)
+    stack_limit: int = 0
+    table_space: int = 0
+
+    def override(
+        self,
+        wall_seconds: float | None = None,
+        cpu_seconds: float | None = None,
+        inferences: int | None = None,
+        stack_limit: int | None = None,
+        table_space: int | None = None
+    ) -> "QueryLimits":
+        """Return a copy with the given per-call values; None or <= 0 keeps the default."""
+        changes: dict[str, float | int] = {}
+        if wall_seconds is not None and wall_seconds > 0:
+            changes["wall_seconds"] = wall_seconds
+        if cpu_seconds is not None and cpu_seconds > 0:
+            changes["cpu_seconds"] = cpu_seconds
+        if inferences is not None and inferences > 0:
+            changes["inferences"] = inferences
+        if stack_limit is not None and stack_limit > 0:
+            changes["stack_limit"] = stack_limit
+        if table_space is not None and table_space > 0:
+            changes["table_space"] = table_space
+        return replace(self, **changes)
+
+    def with_settings(self, raw: dict[str, Any]) -> "QueryLimits":
+        """Copy with the values of a config file's [limits] table."""
+        unknown = [key for key in raw if key not in ("wall_seconds", "cpu_seconds", "inferences")]
+        if unknown:
+            raise ValueError(f"Unknown limits {unknown}. Use: wall_seconds, cpu_seconds, inferences")
+        limits = QueryLimits(
+            wall_seconds=_number(raw, "wall_seconds", self.wall_seconds),
+            cpu_seconds=_number(raw, "cpu_seconds", self.cpu_seconds),
+            inferences=int(_number(raw, "inferences", self.inferences)),
+        )
+        if limits.wall_seconds <= 0:
+            raise ValueError("limits.wall_seconds must be positive")
+        if limits.cpu_seconds < 0 or limits.inferences < 0:
+            raise ValueError("limits.cpu_seconds and limits.inferences must not be negative")
+        return limits
+
+    def to_prolog(self) -> str:
+        """Render as the limits/3 (or, with memory limits, limits/4) term understood by mcp_limited/2."""
+        if self.stack_limit or self.table_space:
+            return (
+                f"limits({float(self.wall_seconds)}, {float(self.cpu_seconds)}, {int(self.inferences)}, "
+                f"memory({int(self.stack_limit)}, {int(self.table_space)}))"
+            )
+        return f"limits({float(self.wall_seconds)}, {float(self.cpu_seconds)}, {int(self.inferences)})"
+
+
+@dataclass(frozen=True)
+class PrintOptions:
+    """
+    How the bindings of query results are printed.
+
+    Subterms nested deeper than max_depth print as ..., and lists longer
+    than max_list keep their first max_list elements followed by ...; 0
+    disables either cut. style is "plain" (writeq), "pretty" (print_term/2,
+    laid out over lines along the operator structure) or "clause"
+    (portray_clause/1 layout, variables named A, B, ...). portray lets
+    user:portray/1 hooks print the terms. In JSON output only the cuts
+    apply.
+    """
+    max_depth: int = 0
+    max_list: int = 0
+    style: str = "plain"
+    portray: bool = False
+
+    def override(
+        self,
+        max_depth: int | None = None,
+        max_list: int | None = None,
+        style: str | None = None,
+        portray: bool | None = None
+    ) -> "PrintOptions":
+        """Copy with the given per-call values; None keeps the default. Raises ValueError."""
+        options = replace(
+            self,
+            max_depth=self.max_depth if max_depth is None else max_depth,
+            max_list=self.max_list if max_list is None else max_list,
+            style=style or self.style,
+            portray=self.portray if portray is None else portray,
+        )
+        if options.style not in PRINT_STYLES:
+            raise ValueError(f"Unknown print style '{options.style}'. Use one of: {', '.join(PRINT_STYLES)}")
+        if options.max_depth < 0 or options.max_list < 0:
+            raise ValueError("max_depth and max_list must not be negative")
+        return options
+
+    @property
+    def is_default(self) -> bool:
+        return self == PrintOptions()
+
+    def to_prolog(self, output_format: str) -> str:
+        """The Format argument of mcp_run/4 and mcp_cursor_open/6 for output_format."""
+        if self.is_default:
+            return output_format
+        portray = "true" if self.portray else "false"
+        return f"{output_format}(print({int(self.max_depth)}, {int(self.max_list)}, {self.style}, {portray}))"
+
+
+@dataclass(frozen=True)
+class StartupProgram:
+    """One entry of the startup list: a data directory file, a URL or inline code."""
+    file: str = ""
+    url: str = ""
+    checksum: str = ""
+    code: str = ""
+
+    @classmethod
+    def from_setting(cls, entry: Any) -> "StartupProgram":
+        if isinstance(entry, str):
+            text = entry.strip()
+            if text.startswith(("http://", "https://")):
+                return cls(url=text)
+            return cls(file=text).checked()
+        if not isinstance(entry, dict):
+            raise ValueError(f"A startup program is a string or a table, not {entry!r}")
+        unknown = [key for key in entry if key not in ("file", "url", "checksum", "code")]
+        if unknown:
+            raise ValueError(f"Unknown startup program keys {unknown}. Use: file, url, checksum, code")
+        kinds = [key for key in ("file", "url", "code") if entry.get(key)]
+        if len(kinds) != 1:
+            raise ValueError(f"A startup program needs exactly one of file, url or code: {entry!r}")
+        values = {key: entry.get(key, "") for key in ("file", "url", "checksum", "code")}
+        if not all(isinstance(value, str) for value in values.values()):
+            raise ValueError(f"Startup program values must be strings: {entry!r}")
+        return cls(**values).checked()
+
+    def checked(self) -> "StartupProgram":
+        if self.file:
+            path = Path(self.file)
+            if path.is_absolute() or ".." in path.parts:
+                raise ValueError(f"Startup file '{self.file}' must be relative to the data directory")
+        return self
+
+    def label(self) -> str:
+        if self.code:
+            first = self.code.strip().splitlines()[0] if self.code.strip() else ""
+            return f"code: {first[:60]}"
+        return self.file or self.url
+
+
+@dataclass(frozen=True)
+class StartupSettings:
+    """The startup programs, in load order, and what a failure does."""
+    programs: tuple[StartupProgram, ...] = ()
+    on_error: str = "warn"
+
+    @classmethod
+    def from_env(cls) -> "StartupSettings":
+        entries = [entry for entry in os.environ.get("SWISH_MCP_STARTUP", "").split(",") if entry.strip()]
+        on_error = os.environ.get("SWISH_MCP_STARTUP_ON_ERROR", "").strip().lower() or "warn"
+        if on_error not in STARTUP_POLICIES:
+            logger.warning(f"Ignoring invalid SWISH_MCP_STARTUP_ON_ERROR={on_error!r}, using warn")
+            on_error = "warn"
+        programs = []
+        for entry in entries:
+            try:
+                programs.append(StartupProgram.from_setting(entry))
+            except ValueError as e:
+                logger.warning(f"Ignoring SWISH_MCP_STARTUP entry: {e}")
+        return cls(tuple(programs), on_error)
+
+    def with_settings(self, raw: dict[str, Any]) -> "StartupSettings":
+        """Copy with the values of a config file's [startup] table."""
+        unknown = [key for key in raw if key not in ("programs", "on_error")]
+        if unknown:
+            raise ValueError(f"Unknown startup settings {unknown}. Use: programs, on_error")
+        on_error = raw.get("on_error", self.on_error)
+        if on_error not in STARTUP_POLICIES:
+            raise ValueError(f"startup.on_error must be one of {', '.join(STARTUP_POLICIES)}, not {on_error!r}")
+        programs = raw.get("programs")
+        if programs is None:
+            return replace(self, on_error=on_error)
+        if not isinstance(programs, list):
+            raise ValueError(f"startup.programs must be a list, not {programs!r}")
+        try:
+            return StartupSettings(tuple(StartupProgram.from_setting(entry) for entry in programs), on_error)
+        except ValueError as e:
+            raise ValueError(f"startup.programs: {e}")
+
+
+def read_config_file(path: Path) -> dict[str, Any]:
+    """Parse a TOML or YAML config file; raises ValueError if it cannot be used."""
+    try:
+        text = path.read_text(encoding="utf-8")
+    except OSError as e:
+        raise ValueError(f"Cannot read config file {path}: {e}") from e
+    if path.suffix.lower() in (".yaml", ".yml"):
+        try:
+            import yaml
+        except ImportError as e:
+            raise ValueError("YAML config files need PyYAML (pip install pyyaml); or use TOML") from e
+        try:
+            raw = yaml.safe_load(text) or {}
+        except yaml.YAMLError as e:
+            raise ValueError(f"Invalid YAML in {path}: {e}") from e
+    else:
+        try:
+            raw = tomllib.loads(text)
+        except tomllib.TOMLDecodeError as e:
+            raise ValueError(f"Invalid TOML in {path}: {e}") from e
+    if not isinstance(raw, dict):
+        raise ValueError(f"Config file {path} must contain a table of settings")
+    unknown = [key for key in raw if key not in CONFIG_SECTIONS]
+    if unknown:
+        raise ValueError(f"Unknown config sections {unknown} in {path}. Use: {', '.join(CONFIG_SECTIONS)}")
+    for section in CONFIG_SECTIONS:
+        if not isinstance(raw.get(section, {}), dict):
+            raise ValueError(f"[{section}] in {path} must be a table")
+    return raw
+
+
+def _number(raw: dict[str, Any], key: str, default: float) -> float:
+    value = raw.get(key, default)
+    if isinstance(value, bool) or not isinstance(value, (int, float)):
+        raise ValueError(f"{key} must be a number, not {value!r}")
+    return value
+
+
+@dataclass(frozen=True)
+class ContainerSettings:
+    """How the default SWISH container is created; changing these recreates it."""
+    # Only the test harness changes it, for its ephemeral containers
+    name: str = "swish-mcp-auto"
+    port: int = 3050
+    data_dir: Path = field(default_factory=lambda: Path.cwd() / "swish-data-new")
+    # Image reference; "" uses the runtime's default image
+    image: str = ""
+    # Dockerfile built into image on startup
+    dockerfile: Path | None = None
+    # When the image is pulled or built: always, missing or never
+    pull_policy: str = "always"
+    # Platform the image is pulled for, e.g. linux/arm64; auto is the daemon's (see images.py)
+    platform: str = "auto"
+    # Memory, CPU, process and ulimit limits of the container (see resources.py)
+    resources: ContainerResources = field(default_factory=ContainerResources)
+    # Named volume mounted at /data instead of data_dir (see volumes.py); "" bind-mounts data_dir
+    volume: str = ""
+    # Network the container is attached to (see network_isolation.py)
+    network: NetworkProfile = field(default_factory=NetworkProfile)
+    # Variables and secret files passed to the container (see container_env.py)
+    environment: ContainerEnvironment = field(default_factory=ContainerEnvironment)
+
+    @property
+    def base_url(self) -> str:
+        return f"http://localhost:{self.port}"
+
+    @classmethod
+    def from_env(cls) -> "ContainerSettings":
+        data_dir = os.environ.get("SWISH_MCP_DATA_DIR", "")
+        dockerfile = os.environ.get("SWISH_MCP_DOCKERFILE", "").strip()
+        image = os.environ.get("SWISH_MCP_IMAGE", "").strip()
+        try:
+            validate_image(image)
+        except ValueError as e:
+            logger.warning(f"Ignoring SWISH_MCP_IMAGE: {e}")
+            image = ""
+        platform = os.environ.get("SWISH_MCP_PLATFORM", "").strip().lower() or "auto"
+        try:
+            validate_platform(platform)
+        except ValueError as e:
+            logger.warning(f"Ignoring SWISH_MCP_PLATFORM: {e}")
+            platform = "auto"
+        try:
+            resources = ContainerResources.from_settings({
+                "memory": os.environ.get("SWISH_MCP_MEMORY", "").strip(),
+                "cpus": os.environ.get("SWISH_MCP_CPUS", "").strip(),
+                "pids_limit": os.environ.get("SWISH_MCP_PIDS_LIMIT", "").strip(),
+                "ulimits": os.environ.get("SWISH_MCP_ULIMITS", "").strip(),
+            })
+        except ValueError as e:
+            logger.warning(f"Ignoring container resource limits: {e}")
+            resources = ContainerResources()
+        volume = os.environ.get("SWISH_MCP_DATA_VOLUME", "").strip()
+        if volume:
+            try:
+                validate_volume_name(volume)
+            except ValueError as e:
+                logger.warning(f"Ignoring SWISH_MCP_DATA_VOLUME: {e}")
+                volume = ""
+        try:
+            network = NetworkProfile.from_settings({
+                "network": os.environ.get("SWISH_MCP_NETWORK", "").strip(),
+                "network_allow": os.environ.get("SWISH_MCP_NETWORK_ALLOW", "").strip(),
+                "network_proxy_port": os.environ.get("SWISH_MCP_NETWORK_PROXY_PORT", "").strip(),
+            })
+        except ValueError as e:
+            logger.warning(f"Ignoring the network profile: {e}")
+            network = NetworkProfile()
+        try:
+            environment = ContainerEnvironment.from_settings({
+                "env": os.environ.get("SWISH_MCP_CONTAINER_ENV", "").strip(),
+                "secrets": os.environ.get("SWISH_MCP_CONTAINER_SECRETS", "").strip(),
+            })
+        except ValueError as e:
+            logger.warning(f"Ignoring the container environment: {e}")
+            environment = ContainerEnvironment()
+        return cls(
+            port=_env_int("SWISH_MCP_PORT", 3050),
+            data_dir=Path(data_dir).expanduser() if data_dir else Path.cwd() / "swish-data-new",
+            image=image,
+            dockerfile=Path(dockerfile).expanduser() if dockerfile else None,
+            pull_policy=_env_choice("SWISH_MCP_PULL_POLICY", PULL_POLICIES, "always"),
+            platform=platform,
+            resources=resources,
+            volume=volume,
+            network=network,
+            environment=environment,
+        )
+
+    def with_settings(self, raw: dict[str, Any]) -> "ContainerSettings":
+        """Copy with the values of a config file's [container] table."""
+        known = (
+            "port", "data_dir", "image", "dockerfile", "pull_policy", "platform", "volume", "memory", "cpus", "pids_limit",
+            "ulimits", "network", "network_allow", "network_proxy_port", "env", "secrets"
+        )
+        unknown = [key for key in raw if key not in known]
+        if unknown:
+            raise ValueError(f"Unknown container settings {unknown}. Use: {', '.join(known)}")
+        port = raw.get("port", self.port)
+        if isinstance(port, bool) or not isinstance(port, int) or not 1 <= port <= 65535:
+            raise ValueError(f"container.port must be a port number, not {port!r}")
+        data_dir = raw.get("data_dir", self.data_dir)
+        if not isinstance(data_dir, (str, Path)) or not str(data_dir):
+            raise ValueError(f"container.data_dir must be a path, not {data_dir!r}")
+        image = raw.get("image", self.image)
+        if not isinstance(image, str):
+            raise ValueError(f"container.image must be a string, not {image!r}")
+        validate_image(image.strip())
+        dockerfile = raw.get("dockerfile", self.dockerfile)
+        if dockerfile is not None and (not isinstance(dockerfile, (str, Path)) or not str(dockerfile)):
+            raise ValueError(f"container.dockerfile must be a path, not {dockerfile!r}")
+        pull_policy = raw.get("pull_policy", self.pull_policy)
+        if pull_policy not in PULL_POLICIES:
+            raise ValueError(f"container.pull_policy must be one of {', '.join(PULL_POLICIES)}, not {pull_policy!r}")
+        platform = raw.get("platform", self.platform)
+        if not isinstance(platform, str):
+            raise ValueError(f"container.platform must be a string, not {platform!r}")
+        try:
+            validate_platform(platform.strip().lower())
+        except ValueError as e:
+            raise ValueError(f"container.platform: {e}")
+        volume = raw.get("volume", self.volume)
+        if not isinstance(volume, str):
+            raise ValueError(f"container.volume must be a string, not {volume!r}")
+        if volume.strip():
+            try:
+                validate_volume_name(volume.strip())
+            except ValueError as e:
+                raise ValueError(f"container.volume: {e}")
+        try:
+            resources = ContainerResources.from_settings(raw, self.resources)
+        except ValueError as e:
+            raise ValueError(f"container.{e}")
+        try:
+            network = NetworkProfile.from_settings(raw, self.network)
+        except ValueError as e:
+            raise ValueError(f"container.{e}")
+        try:
+            environment = ContainerEnvironment.from_settings(raw, self.environment)
+        except ValueError as e:
+            raise ValueError(f"container.{e}")
+        return replace(
+            self,
+            port=port,
+            data_dir=Path(data_dir).expanduser(),
+            image=image.strip(),
+            dockerfile=Path(dockerfile).expanduser() if dockerfile is not None else None,
+            pull_policy=pull_policy,
+            platform=platform.strip().lower(),
+            resources=resources,
+            volume=volume.strip(),
+            network=network,
+            environment=environment,
+        )
+
+
+@dataclass
+class ServerConfig:
+    """Settings shared by every tool call."""
+    limits: QueryLimits = field(default_factory=QueryLimits)
+    # Stack and table space limits the persistent session's swipl starts with
+    prolog: PrologMemory = field(default_factory=PrologMemory)
+    # Default cuts of printed query results, see PrintOptions
+    printing: PrintOptions = field(default_factory=PrintOptions)
+    # Seconds between container health probes; 0 disables the supervisor
+    health_interval: float = 15.0
+    sandbox: SandboxConfig = field(default_factory=SandboxConfig)
+    # Seconds between scans of the data directory for swish://kb/ resources
+    kb_poll_interval: float = 5.0
+    auto_reload: str = "off"
+    # Container engine: docker, podman or nerdctl
+    runtime: str = "docker"
+    podman_socket: str = ""
+    # How the data directory's path is written for the daemon: auto, posix, windows or wsl (see host_platform.py)
+    host_paths: str = "auto"
+    # container, local for a swipl on PATH (see local_backend.py), wasm (see wasm_backend.py)
+    # or mock, the in-memory interpreter (see mock_backend.py)
+    backend: str = "container"
+    swipl_path: str = "swipl"
+    wasm_module: str = ""
+    wasm_runtime: str = "wasmtime"
+    # Worker pool for queries run concurrently on separate pengines
+    max_workers: int = 4
+    max_workers_per_client: int = 2
+    max_queued_queries: int = 64
+    # Recent knowledge base changes that undo_last can revert
+    undo_depth: int = 50
+    # Query results cached by execute_prolog_query; 0 disables the cache
+    cache_size: int = 0
+    # Programs loaded every time the persistent session starts (see startup.py)
+    startup: StartupSettings = field(default_factory=StartupSettings)
+    # Query results larger than this are written to results/ in the data directory (see spill.py)
+    spill: SpillThresholds = field(default_factory=SpillThresholds)
+    # Bytes of solutions one answer returns before it is cut, with a cursor for the rest
+    # (see answer_limits.py); 0 is no limit
+    max_answer_bytes: int = 0
+    # What happens to started containers on shutdown, and to orphans on startup (see lifecycle.py)
+    shutdown_policy: str = "remove"
+    orphan_policy: str = "adopt"
+    # Host workspace mirrored with the data directory (see sync.py)
+    sync_dir: Path | None = None
+    sync_interval: float = 2.0
+    # Root of the workspace registry and of workspaces with their own container; None is
+    # swish-workspaces next to the data directory (see workspaces.py)
+    workspaces_dir: Path | None = None
+    # Packs installed in the container on startup for probabilistic_query, scasp_query and the geo tools
+    probabilistic: str = "off"
+    scasp: str = "off"
+    geo: str = "off"
+    # Signing key, trusted keys and signature policy of knowledge base bundles (see bundles.py)
+    bundles: BundleSettings = field(default_factory=BundleSettings)
+    # Per-client tool call rate and usage quotas (see quotas.py)
+    quotas: QuotaSettings = field(default_factory=QuotaSettings)
+    # Faults injected for testing clients against a flaky backend (see chaos.py)
+    chaos: ChaosSettings = field(default_factory=ChaosSettings)
+    # Disk quota and free-space reserve of the data directory (see disk_usage.py)
+    disk: DiskSettings = field(default_factory=DiskSettings)
+    # Bearer keys required by the http/sse transports; none means no auth
+    api_keys: ApiKeyStore = field(default_factory=ApiKeyStore)
+    # Tools each connection is shown and may call (see tool_profiles.py)
+    profiles: ProfileSet = field(default_factory=ProfileSet)
+    # TLS, trusted proxies and CORS of the http/sse transports (see http_serving.py)
+    http: HttpSettings = field(default_factory=HttpSettings)
+    # Retries and circuit breaking of requests to SWISH (see swish_http.py)
+    swish_http: RetryPolicy = field(default_factory=RetryPolicy)
+    # Address links to the SWISH web UI are built on; "" uses the container's URL
+    public_url: str = ""
+    # Pengine API (http), docker exec (exec), or the API with exec as fallback (auto)
+    execution: str = "auto"
+    # OpenTelemetry tracing, and how goals are recorded on spans: full, redacted or omit
+    otel: str = "off"
+    otel_goals: str = "full"
+    # Level and format (text or json) of the server's log (see request_logging.py)
+    log_level: str = "info"
+    log_format: str = "text"
+    container: ContainerSettings = field(default_factory=ContainerSettings)
+    # on never pulls an image; a missing one fails at startup (see images.py)
+    offline: str = "off"
+    # Per-client Prolog modules: auto (on for the http/sse transports), on or off
+    isolation: str = "auto"
+    # File the settings above were (partly) read from, see SWISH_MCP_CONFIG
+    config_path: Path | None = None
+
+    @classmethod
+    def from_env(cls) -> "ServerConfig":
+        """Build the configuration from SWISH_MCP_* environment variables."""
+        limits = QueryLimits(
+            wall_seconds=_env_float("SWISH_MCP_QUERY_TIMEOUT", 30.0),
+            cpu_seconds=_env_float("SWISH_MCP_CPU_LIMIT", 0.0),
+            inferences=_env_int("SWISH_MCP_INFERENCE_LIMIT", 0),
+        )
+        if limits.wall_seconds <= 0:
+            logger.warning("SWISH_MCP_QUERY_TIMEOUT must be positive, using 30 seconds")
+            limits = replace(limits, wall_seconds=30.0)
+        try:
+            prolog = PrologMemory.from_settings({
+                "stack_limit": os.environ.get("SWISH_MCP_STACK_LIMIT", "").strip(),
+                "table_space": os.environ.get("SWISH_MCP_TABLE_SPACE", "").strip(),
+                "shared_table_space": os.environ.get("SWISH_MCP_SHARED_TABLE_SPACE", "").strip(),
+            })
+        except ValueError as e:
+            logger.warning(f"Ignoring Prolog memory limits: {e}")
+            prolog = PrologMemory()
+        sync_dir = os.environ.get("SWISH_MCP_SYNC_DIR", "").strip()
+        workspaces_dir = os.environ.get("SWISH_MCP_WORKSPACES_DIR", "").strip()
+        return cls(
+            limits=limits,
+            prolog=prolog,
+            printing=PrintOptions(
+                max_depth=max(_env_int("SWISH_MCP_PRINT_DEPTH", 0), 0),
+                max_list=max(_env_int("SWISH_MCP_PRINT_LIST", 0), 0),
+            ),
+            health_interval=_env_float("SWISH_MCP_HEALTH_INTERVAL", 15.0),
+            sandbox=SandboxConfig.from_env(),
+            kb_poll_interval=max(_env_float("SWISH_MCP_KB_POLL_INTERVAL", 5.0), 0.5),
+            auto_reload=_env_choice("SWISH_MCP_AUTO_RELOAD", AUTO_RELOAD_MODES, "off"),
+            runtime=os.environ.get("SWISH_MCP_RUNTIME", "docker").strip().lower() or "docker",
+            podman_socket=os.environ.get("SWISH_MCP_PODMAN_SOCKET", ""),
+            host_paths=_env_choice("SWISH_MCP_HOST_PATHS", PATH_STYLES, "auto"),
+            backend=_env_choice("SWISH_MCP_BACKEND", BACKENDS, "container"),
+            swipl_path=os.environ.get("SWISH_MCP_SWIPL", "").strip() or "swipl",
+            wasm_module=os.environ.get("SWISH_MCP_WASM_MODULE", "").strip(),
+            wasm_runtime=os.environ.get("SWISH_MCP_WASM_RUNTIME", "").strip() or "wasmtime",
+            max_workers=max(_env_int("SWISH_MCP_WORKERS", 4), 1),
+            max_workers_per_client=max(_env_int("SWISH_MCP_WORKERS_PER_CLIENT", 2), 1),
+            max_queued_queries=max(_env_int("SWISH_MCP_WORKER_QUEUE", 64), 0),
+            undo_depth=max(_env_int("SWISH_MCP_UNDO_DEPTH", 50), 0),
+            cache_size=max(_env_int("SWISH_MCP_CACHE_SIZE", 0), 0),
+            startup=StartupSettings.from_env(),
+            spill=SpillThresholds(
+                solutions=max(_env_int("SWISH_MCP_SPILL_SOLUTIONS", 1000), 0),
+                bytes=max(_env_int("SWISH_MCP_SPILL_BYTES", 256 * 1024), 0),
+            ),
+            max_answer_bytes=max(_env_int("SWISH_MCP_MAX_ANSWER_BYTES", 0), 0),
+            shutdown_policy=_env_choice("SWISH_MCP_SHUTDOWN_POLICY", SHUTDOWN_POLICIES, "remove"),
+            orphan_policy=_env_choice("SWISH_MCP_ORPHAN_POLICY", ORPHAN_POLICIES, "adopt"),
+            sync_dir=Path(sync_dir).expanduser() if sync_dir else None,
+            sync_interval=max(_env_float("SWISH_MCP_SYNC_INTERVAL", 2.0), 0.5),
+            workspaces_dir=Path(workspaces_dir).expanduser() if workspaces_dir else None,
+            probabilistic=_env_choice("SWISH_MCP_PROBABILISTIC", PROBABILISTIC_MODES, "off"),
+            scasp=_env_choice("SWISH_MCP_SCASP", SCASP_MODES, "off"),
+            geo=_env_choice("SWISH_MCP_GEO", GEO_MODES, "off"),
+            bundles=BundleSettings.from_env(),
+            chaos=ChaosSettings.from_env(),
+            disk=DiskSettings.from_env(),
+            quotas=QuotaSettings(
+                calls_per_minute=max(_env_float("SWISH_MCP_RATE_LIMIT", 0.0), 0.0),
+                burst=max(_env_int("SWISH_MCP_RATE_BURST", 0), 0),
+                cpu_seconds=max(_env_float("SWISH_MCP_CPU_QUOTA", 0.0), 0.0),
+                clauses=max(_env_int("SWISH_MCP_CLAUSE_QUOTA", 0), 0),
+                window=max(_env_float("SWISH_MCP_QUOTA_WINDOW", 3600.0), 1.0),
+            ),
+            api_keys=ApiKeyStore.from_env(),
+            profiles=ProfileSet.from_env(),
+            http=HttpSettings.from_env(),
+            swish_http=RetryPolicy(
+                retries=max(_env_int("SWISH_MCP_HTTP_RETRIES", 4), 0),
+                backoff=max(_env_float("SWISH_MCP_HTTP_BACKOFF", 0.25), 0.0),
+                max_backoff=max(_env_float("SWISH_MCP_HTTP_BACKOFF_MAX", 4.0), 0.0),
+                breaker_failures=max(_env_int("SWISH_MCP_HTTP_BREAKER_FAILURES", 5), 0),
+                breaker_reset=max(_env_float("SWISH_MCP_HTTP_BREAKER_RESET", 15.0), 1.0),
+            ),
+            public_url=os.environ.get("SWISH_MCP_PUBLIC_URL", "").strip().rstrip("/"),
+            execution=_env_choice("SWISH_MCP_EXECUTION", EXECUTION_MODES, "auto"),
+            otel=_env_choice("SWISH_MCP_OTEL", OTEL_MODES, "off"),
+            otel_goals=_env_choice("SWISH_MCP_OTEL_GOALS", GOAL_MODES, "full"),
+            log_level=_env_choice("SWISH_MCP_LOG_LEVEL", tuple(LOG_LEVELS), "info"),
+            log_format=_env_choice("SWISH_MCP_LOG_FORMAT", LOG_FORMATS, "text"),
+            container=ContainerSettings.from_env(),
+            offline=_env_choice("SWISH_MCP_OFFLINE", OFFLINE_MODES, "off"),
+            isolation=_env_choice("SWISH_MCP_ISOLATION", ISOLATION_MODES, "auto"),
+        )
+
+    def with_file(self, path: Path) -> "ServerConfig":
+        """Copy with the settings of a config file; raises ValueError if it is invalid."""
+        raw = read_config_file(path)
+        return replace(
+            self,
+            container=self.container.with_settings(raw.get("container", {})),
+            limits=self.limits.with_settings(raw.get("limits", {})),
+            prolog=PrologMemory.from_settings(raw.get("prolog", {}), self.prolog),
+            sandbox=self.sandbox.with_settings(raw.get("sandbox", {})),
+            startup=self.startup.with_settings(raw.get("startup", {})),
+            config_path=path,
+        )
+
+    @classmethod
+    def load(cls) -> "ServerConfig":
+        """Configuration from the environment, overlaid with SWISH_MCP_CONFIG if set."""
+        config = cls.from_env()
+        path = os.environ.get("SWISH_MCP_CONFIG", "").strip()
+        if not path:
+            return config
+        try:
+            return config.with_file(Path(path).expanduser())
+        except ValueError as e:
+            logger.error(f"Ignoring SWISH_MCP_CONFIG: {e}")
+            return replace(config, config_path=Path(path).expanduser())
@@ -0,0 +1,118 @@
+"""
+Configuration Hot Reload for Docker SWISH MCP
+
+Re-reads the SWISH_MCP_CONFIG file when its modification time changes
+(polled, like the knowledge base watcher) or when the server receives
+SIGHUP. Query limits and sandbox policies take effect for the next tool
+call, startup programs and Prolog memory limits the next time the
+session starts; a changed port, data directory, image (reference,
+Dockerfile or pull policy), resource limit or container environment
+needs a new container, which the server recreates once the queries
+running on it have finished.
+
+An invalid file is reported and the running configuration is kept.
+"""
+
+import asyncio
+import logging
+from collections.abc import Awaitable, Callable
+from dataclasses import dataclass, field
+from pathlib import Path
+
+from .config import ServerConfig
+
+logger = logging.getLogger("docker-swish-mcp.config_watch")
+
+
+@dataclass
+class ConfigChanges:
+    """What a reload changed, split by how it is applied."""
+    # Applied to the next tool call
+    live: list[str] = field(default_factory=list)
+    # Applied by recreating the container
+    recreate: list[str] = field(default_factory=list)
+
+    def __bool__(self) -> bool:
+        return bool(self.live or self.recreate)
+
+    def describe(self) -> str:
+        parts = self.live + [f"{change} (recreating container)" for change in self.recreate]
+        return ", ".join(parts) if parts else "no changes"
+
+
+def diff_config(old: ServerConfig, new: ServerConfig) -> ConfigChanges:
+    changes = ConfigChanges()
+    if old.limits != new.limits:
+        changes.live.append(f"limits {new.limits.to_prolog()}")
+    if old.sandbox != new.sandbox:
+        changes.live.append(f"sandbox {new.sandbox.default.mode} ({len(new.sandbox.clients)} client policies)")
+    if old.startup != new.startup:
+        changes.live.append(f"startup {len(new.startup.programs)} program(s), on_error {new.startup.on_error}")
+    if old.prolog != new.prolog:
+        changes.live.append(f"prolog {new.prolog.describe()} (from the next session start)")
+    for name in ("port", "data_dir", "image", "dockerfile", "pull_policy", "resources", "volume", "network", "environment"):
+        before, after = getattr(old.container, name), getattr(new.container, name)
+        if before != after:
+            changes.recreate.append(f"{name} {before or 'default'} → {after or 'default'}")
+    return changes
+
+
+class ConfigWatcher:
+    """
+    Reloads a config file and hands the result to apply.
+
+    Args:
+        path: Config file to watch
+        current: Returns the running configuration
+        apply: Coroutine switching the server to a reloaded configuration
+        interval: Seconds between checks of the file's modification time
+    """
+
+    def __init__(
+        self,
+        path: Path,
+        current: Callable[[], ServerConfig],
+        apply: Callable[[ServerConfig, ConfigChanges], Awaitable[None]],
+        interval: float = 2.0
+    ):
+        self.path = path
+        self.current = current
+        self.apply = apply
+        self.interval = interval
+        self.pending = False
+        self._mtime = self._stat()
+
+    def _stat(self) -> float | None:
+        try:
+            return self.path.stat().st_mtime
+        except OSError:
+            return None
+
+    def request_reload(self) -> None:
+        """Reload on the next check; safe to call from a signal handler."""
+        self.pending = True
+
+    async def reload(self) -> ConfigChanges | None:
+        """Re-read the file and apply it; None if it is invalid."""
+        self._mtime = self._stat()
+        try:
+            # Environment values are the base the file is laid over
+            new = await asyncio.to_thread(lambda: ServerConfig.from_env().with_file(self.path))
+        except ValueError as e:
+            logger.error(f"Keeping the running configuration: {e}")
+            return None
+        changes = diff_config(self.current(), new)
+        logger.info(f"⚙️ Reloaded {self.path}: {changes.describe()}")
+        if changes:
+            await self.apply(new, changes)
+        return changes
+
+    async def watch(self) -> None:
+        while True:
+            await asyncio.sleep(self.interval)
+            if self.pending or self._stat() != self._mtime:
+                self.pending = False
+                try:
+                    await self.reload()
+                except Exception as e:
+                    logger.error(f"Applying the reloaded configuration failed: {e}")
@@ -0,0 +1,187 @@
+"""
+CLP(FD) Constraint Models for Docker SWISH MCP
+
+Compiles a JSON description of a finite-domain problem into a
+library(clpfd) program for mcp_clpfd_solve/4 (see mcp_helpers.pl):
+
+    {
+      "variables": {
+        "X": [1, 10],
+        "Y": {"values": [2, 3, 5, 7]},
+        "Z": "0..3 \\/ 8..9",
+        "Queens": {"length": 8, "domain": [1, 8]}
+      },
+      "constraints": ["X + Y #= Z * 2", "X #< Y", "all_distinct(Queens)"],
+      "minimize": "X + Y"
+    }
+
+Domains are [Low, High] ranges, {"values": [...]} sets, a single integer
+or clpfd domain text. An entry with "length" declares a list of that many
+variables. Constraints are clpfd expressions over the declared variables;
+they are tokenized and only clpfd operators and constraints are accepted,
+so the model cannot call arbitrary predicates.
+"""
+
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .simple_session import prolog_string
+
+STRATEGIES = ("leftmost", "ff", "ffc", "min", "max")
+VALUE_ORDERS = ("up", "down")
+BRANCHINGS = ("step", "enum", "bisect")
+
+VARIABLE_RE = re.compile(r"^[A-Z][A-Za-z0-9_]*$")
+BOUND_RE = re.compile(r"^(-?\d+|inf)(?:\.\.(-?\d+|sup))?$")
+# Same token classes as the Prolog reader, so a constraint is split the way
+# term_string/3 will read it
+TOKEN_RE = re.compile(
+    r"\s*(?:(?P<int>\d+)|(?P<var>[A-Z_][A-Za-z0-9_]*)|(?P<name>[a-z][A-Za-z0-9_]*)"
+    r"|(?P<sym>[#$&*+\-./:<=>?@^~\\]+)|(?P<punct>[()\[\],|]))"
+)
+
+OPERATORS = frozenset({
+    "#=", "#\\=", "#<", "#>", "#=<", "#>=",
+    "#/\\", "#\\/", "#\\", "#==>", "#<==", "#<==>",
+    "+", "-", "*", "/", "//", "^", "..", "\\/",
+})
+NAMES = frozenset({
+    "in", "ins", "mod", "rem", "div", "abs", "min", "max", "sign", "inf", "sup",
+    "sum", "scalar_product", "all_distinct", "all_different", "element",
+    "global_cardinality", "tuples_in", "lex_chain", "circuit", "chain", "transpose",
+})
+
+
+class ModelError(ValueError):
+    """Raised for constraint models that cannot be compiled."""
+
+
+@dataclass
+class ConstraintModel:
+    """A compiled model: variable domains, constraints and an optional objective."""
+    # Name -> (length, domain) in declaration order; length is None for
+    # single variables
+    variables: dict[str, tuple[int | None, str]] = field(default_factory=dict)
+    constraints: list[str] = field(default_factory=list)
+    # ("min" | "max", expression)
+    objective: tuple[str, str] | None = None
+
+    def goal(self) -> str:
+        """The clpfd goal posting every domain and constraint."""
+        parts = []
+        for name, (length, domain) in self.variables.items():
+            if length is None:
+                parts.append(f"{name} in {domain}")
+            else:
+                parts.append(f"length({name}, {length}), {name} ins {domain}")
+        parts.extend(f"({constraint})" for constraint in self.constraints)
+        return ", ".join(parts) if parts else "true"
+
+
+def domain_text(spec: Any, name: str) -> str:
+    """clpfd domain for a variable's domain spec."""
+    if isinstance(spec, bool):
+        raise ModelError(f"Invalid domain for {name}: {spec!r}")
+    if isinstance(spec, int):
+        return str(spec)
+    if isinstance(spec, list) and len(spec) == 2 and all(isinstance(b, int) and not isinstance(b, bool) for b in spec):
+        low, high = spec
+        if low > high:
+            raise ModelError(f"Empty domain for {name}: {low} > {high}")
+        return f"{low}..{high}"
+    if isinstance(spec, dict) and "values" in spec:
+        values = spec["values"]
+        if not values or not all(isinstance(v, int) and not isinstance(v, bool) for v in values):
+            raise ModelError(f"Domain values for {name} must be a non-empty list of integers")
+        return " \\/ ".join(str(v) for v in sorted(set(values)))
+    if isinstance(spec, str):
+        parts = [part.replace(" ", "") for part in spec.split("\\/")]
+        if all(BOUND_RE.match(part) for part in parts):
+            return " \\/ ".join(parts)
+    raise ModelError(
+        f"Invalid domain for {name}: {spec!r}. Use [low, high], {{\"values\": [...]}} or text like \"1..5 \\/ 9\""
+    )
+
+
+def check_expression(text: str, names: set[str], what: str) -> str:
+    """Accept a clpfd expression that uses only declared variables and clpfd syntax."""
+    text = text.strip().rstrip(".").strip()
+    if not text:
+        raise ModelError(f"Empty {what}")
+    position = 0
+    while position < len(text):
+        match = TOKEN_RE.match(text, position)
+        if not match or match.end() == position:
+            raise ModelError(f"Unexpected character {text[position:].strip()[:1]!r} in {what} '{text}'")
+        position = match.end()
+        kind = match.lastgroup
+        token = match.group(kind)
+        if kind == "var" and token not in names:
+            raise ModelError(f"Undeclared variable {token} in {what} '{text}'")
+        if kind == "name" and token not in NAMES:
+            raise ModelError(f"'{token}' is not a clpfd constraint or function (in {what} '{text}')")
+        if kind == "sym" and token not in OPERATORS:
+            raise ModelError(f"'{token}' is not a clpfd operator (in {what} '{text}')")
+    return text
+
+
+def parse_model(raw: dict[str, Any]) -> ConstraintModel:
+    """Validate a JSON model and compile its domains and constraints."""
+    if not isinstance(raw, dict):
+        raise ModelError("The model must be a JSON object with \"variables\" and \"constraints\"")
+    variables = raw.get("variables")
+    if not isinstance(variables, dict) or not variables:
+        raise ModelError("The model needs a \"variables\" object mapping names to domains")
+
+    model = ConstraintModel()
+    for name, spec in variables.items():
+        if not VARIABLE_RE.match(name):
+            raise ModelError(f"Invalid variable name '{name}': start with an uppercase letter")
+        if isinstance(spec, dict) and "length" in spec:
+            length = spec["length"]
+            if not isinstance(length, int) or isinstance(length, bool) or length < 1:
+                raise ModelError(f"Length of {name} must be a positive integer")
+            model.variables[name] = (length, domain_text(spec.get("domain"), name))
+        else:
+            model.variables[name] = (None, domain_text(spec, name))
+
+    constraints = raw.get("constraints", [])
+    if isinstance(constraints, str):
+        constraints = [constraints]
+    for i, constraint in enumerate(constraints, 1):
+        if not isinstance(constraint, str):
+            raise ModelError(f"Constraint {i} must be a string such as \"X + Y #= 10\"")
+        model.constraints.append(check_expression(constraint, set(model.variables), f"constraint {i}"))
+
+    if "minimize" in raw and "maximize" in raw:
+        raise ModelError("Give either \"minimize\" or \"maximize\", not both")
+    for key, direction in (("minimize", "min"), ("maximize", "max")):
+        if key in raw:
+            model.objective = (direction, check_expression(str(raw[key]), set(model.variables), key))
+    return model
+
+
+def labeling_options(model: ConstraintModel, strategy: str, value_order: str, branching: str) -> str:
+    """Options term for labeling/2."""
+    for value, allowed, what in (
+        (strategy, STRATEGIES, "strategy"),
+        (value_order, VALUE_ORDERS, "value order"),
+        (branching, BRANCHINGS, "branching"),
+    ):
+        if value not in allowed:
+            raise ModelError(f"Unknown labeling {what} '{value}'. Use one of: {', '.join(allowed)}")
+    options = [strategy, value_order, branching]
+    if model.objective is not None:
+        direction, expression = model.objective
+        options.append(f"{direction}({expression})")
+    return "[" + ", ".join(options) + "]"
+
+
+def solve_call(model: ConstraintModel, options: str, limits_term: str, max_solutions: int) -> tuple[str, list[str]]:
+    text = f"model(({model.goal()}), {options})"
+    return "mcp_clpfd_solve", [prolog_string(text), limits_term, str(int(max_solutions))]
+
+
+def format_solution(solution: dict[str, Any]) -> str:
+    return ", ".join(f"{name} = {value}" for name, value in solution.items())
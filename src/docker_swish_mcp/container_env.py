@@ -0,0 +1,182 @@
+"""
+Environment Variables and Secrets of the SWISH Container
+
+Prolog code often needs credentials, e.g. an API token for http_get/3.
+They reach the container from the environment or the [container] table
+of the config file:
+
+- SWISH_MCP_CONTAINER_ENV / env: variables set in the container, as
+  "MODE=prod,API_TOKEN" or a table such as {MODE = "prod", API_TOKEN =
+  true}. A name without a value (or true) passes on the server's own
+  value of that variable, so the token itself need not be written into
+  any config file
+- SWISH_MCP_CONTAINER_SECRETS / secrets: files mounted read-only at
+  /run/secrets/<name>, as Docker and compose do with secrets, e.g.
+  "api_token=~/.config/swish/token" or {api_token = "/etc/swish/token"};
+  Prolog reads them with read_file_to_string/3
+
+The values of the variables and the contents of the secret files are
+replaced by *** in the server's logs and in container logs shown by
+swish_logs; get_swish_status lists only their names. The container is
+labelled with a digest of them, so a changed value recreates it.
+"""
+
+import hashlib
+import json
+import logging
+import os
+import re
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.env")
+
+SECRETS_DIR = "/run/secrets"
+ENV_NAME_RE = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*$")
+SECRET_NAME_RE = re.compile(r"^[A-Za-z0-9_][A-Za-z0-9_.-]*$")
+# Values shorter than this are not redacted: "1" or "on" would blank out half the log
+MIN_REDACTED = 4
+REDACTED = "***"
+
+
+def _pairs(value: Any, option: str) -> list[tuple[str, Any]]:
+    """(name, value) pairs of "A=1,B" text, a table or a list of "A=1" strings."""
+    if isinstance(value, dict):
+        return list(value.items())
+    if isinstance(value, str):
+        text = value.strip()
+        if text.startswith("{"):
+            try:
+                raw = json.loads(text)
+            except ValueError as e:
+                raise ValueError(f"{option} is not valid JSON: {e}")
+            return _pairs(raw, option)
+        value = [part for part in text.split(",") if part.strip()]
+    if not isinstance(value, list) or not all(isinstance(item, str) for item in value):
+        raise ValueError(f"{option} must be a string, a table or a list of strings, not {value!r}")
+    pairs: list[tuple[str, Any]] = []
+    for item in value:
+        name, sep, rest = item.partition("=")
+        pairs.append((name.strip(), rest if sep else True))
+    return pairs
+
+
+def parse_env(value: Any, environ: dict[str, str] | None = None) -> dict[str, str]:
+    """Variables of the container; names without a value take theirs from environ."""
+    environ = os.environ if environ is None else environ
+    variables = {}
+    for name, raw in _pairs(value, "env"):
+        if not ENV_NAME_RE.match(name):
+            raise ValueError(f"env has an invalid variable name {name!r}")
+        if raw is True:
+            if name not in environ:
+                logger.warning(f"⚠️ {name} is to be passed to the container but is not set")
+                continue
+            raw = environ[name]
+        if isinstance(raw, bool) or not isinstance(raw, (str, int, float)):
+            raise ValueError(f"env {name} must be a string, a number or true, not {raw!r}")
+        variables[name] = str(raw)
+    return variables
+
+
+def parse_secrets(value: Any) -> dict[str, Path]:
+    """Secret files by the name they are mounted as."""
+    secrets = {}
+    for name, raw in _pairs(value, "secrets"):
+        if not SECRET_NAME_RE.match(name):
+            raise ValueError(f"secrets has an invalid name {name!r}")
+        if not isinstance(raw, str) or not raw.strip():
+            raise ValueError(f"secret {name} must be the path of a file, not {raw!r}")
+        secrets[name] = Path(raw.strip()).expanduser()
+    return secrets
+
+
+@dataclass(frozen=True)
+class ContainerEnvironment:
+    """Variables set in, and secret files mounted into, the SWISH container."""
+    variables: dict[str, str] = field(default_factory=dict)
+    secrets: dict[str, Path] = field(default_factory=dict)
+
+    def __bool__(self) -> bool:
+        return bool(self.variables or self.secrets)
+
+    def __str__(self) -> str:
+        """Names and a digest of the values, for the container's label; never the values."""
+        if not self:
+            return ""
+        digest = hashlib.sha256()
+        for name, value in sorted(self.variables.items()):
+            digest.update(f"env {name}={value}\0".encode())
+        for name, path in sorted(self.secrets.items()):
+            digest.update(f"secret {name}={path}\0".encode())
+            digest.update(_read_secret(path).encode())
+        names = ",".join([*sorted(self.variables), *(f"secret:{name}" for name in sorted(self.secrets))])
+        return f"{names} sha256:{digest.hexdigest()[:16]}"
+
+    @classmethod
+    def from_settings(cls, raw: dict[str, Any], base: "ContainerEnvironment | None" = None) -> "ContainerEnvironment":
+        """Environment from env/secrets values, defaulting to base's."""
+        base = base or cls()
+        variables = parse_env(raw["env"]) if raw.get("env") not in (None, "") else base.variables
+        secrets = parse_secrets(raw["secrets"]) if raw.get("secrets") not in (None, "") else base.secrets
+        for name, path in secrets.items():
+            if not path.is_file():
+                raise ValueError(f"secret {name}: {path} is not a file")
+        return cls(variables=variables, secrets=secrets)
+
+    def mounts(self, mode: str = "ro") -> dict[str, dict[str, str]]:
+        """Host path to bind of each secret file, for the volumes of containers.run()."""
+        return {
+            str(path.resolve()): {"bind": f"{SECRETS_DIR}/{name}", "mode": mode}
+            for name, path in self.secrets.items()
+        }
+
+    def sensitive_values(self) -> list[str]:
+        values = [*self.variables.values(), *(_read_secret(path) for path in self.secrets.values())]
+        return [value for value in values if len(value) >= MIN_REDACTED]
+
+    def describe(self) -> str:
+        parts = []
+        if self.variables:
+            parts.append(f"{', '.join(sorted(self.variables))} (values hidden)")
+        if self.secrets:
+            parts.append(f"secrets {', '.join(f'{SECRETS_DIR}/{name}' for name in sorted(self.secrets))}")
+        return "; ".join(parts) or "none"
+
+
+def _read_secret(path: Path) -> str:
+    try:
+        return path.read_text(encoding="utf-8", errors="replace").strip()
+    except OSError:
+        return ""
+
+
+class Redactor(logging.Filter):
+    """Replaces the container's variable values and secrets in text, and in log records."""
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.pattern: re.Pattern[str] | None = None
+
+    def update(self, environment: ContainerEnvironment) -> None:
+        # Longest first, so a value containing another is replaced whole
+        values = sorted(set(environment.sensitive_values()), key=len, reverse=True)
+        self.pattern = re.compile("|".join(re.escape(value) for value in values)) if values else None
+
+    def redact(self, text: str) -> str:
+        return self.pattern.sub(REDACTED, text) if self.pattern else text
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        if self.pattern:
+            message = record.getMessage()
+            redacted = self.redact(message)
+            if redacted != message:
+                record.msg, record.args = redacted, None
+        return True
+
+    def install(self) -> None:
+        """Redact every record of this process' log handlers."""
+        for handler in logging.getLogger().handlers:
+            if self not in handler.filters:
+                handler.addFilter(self)
@@ -0,0 +1,320 @@
+"""
+Command Execution in the SWISH Container through the Docker API
+
+Processes are started with the Docker Engine API (docker-py) rather than
+the docker CLI, so they work without docker in PATH, against a remote
+DOCKER_HOST or a rootless daemon, and report failures as structured API
+errors instead of localized CLI text.
+
+ExecProcess mirrors the parts of asyncio.subprocess.Process the session
+relies on (stdin.write/drain/close, stdout.readline, returncode, wait,
+send_signal, terminate, kill). Clients without an exec API (see
+runtimes.NerdctlClient) provide their own open_exec().
+"""
+
+import asyncio
+import logging
+import signal
+import socket
+import threading
+from collections.abc import Iterator
+from typing import Any
+
+from .request_logging import correlation_environment
+from .telemetry import telemetry
+
+try:
+    import docker
+    from docker.errors import APIError, NotFound
+    from docker.utils.socket import STDERR, STDOUT, frames_iter
+except ImportError:  # pragma: no cover - docker is a declared dependency
+    docker = None
+    APIError = NotFound = ()  # match nothing; _docker_client() fails first
+
+logger = logging.getLogger("docker-swish-mcp.exec")
+
+# The wrapper shell prints this before exec'ing the command, so we learn the
+# PID inside the container and can signal it later. The Docker API only
+# reports host PIDs for exec instances.
+PID_MARKER = b"@PID "
+PID_WRAPPER = ["sh", "-c", 'echo "@PID $$"; exec "$@"', "sh"]
+
+
+class ContainerExecError(Exception):
+    """Raised when the Docker API refuses to run a command."""
+
+
+def _docker_client(docker_client: Any) -> Any:
+    if docker_client is not None:
+        return docker_client
+    if docker is None:
+        raise ContainerExecError("The docker Python package is not installed")
+    return docker.from_env()
+
+
+class _ExecStdin:
+    """Buffered writer over the exec's attach socket."""
+
+    def __init__(self, raw: socket.socket):
+        self._raw = raw
+        self._buffer = bytearray()
+        self._closed = False
+
+    def write(self, data: bytes) -> None:
+        if self._closed:
+            raise ConnectionError("stdin of the exec process is closed")
+        self._buffer.extend(data)
+
+    async def drain(self) -> None:
+        if not self._buffer:
+            return
+        data = bytes(self._buffer)
+        self._buffer.clear()
+        await asyncio.to_thread(self._raw.sendall, data)
+
+    def close(self) -> None:
+        if self._closed:
+            return
+        self._closed = True
+        try:
+            if self._buffer:
+                self._raw.sendall(bytes(self._buffer))
+                self._buffer.clear()
+            self._raw.shutdown(socket.SHUT_WR)
+        except OSError as e:
+            logger.debug(f"Closing exec stdin: {e}")
+
+
+class ExecProcess:
+    """A command running in a container, attached through the Docker API."""
+
+    def __init__(self, docker_client: Any, container_id: str, exec_id: str, attach: Any, stdin: bool):
+        self._client = docker_client
+        self._container_id = container_id
+        self._exec_id = exec_id
+        self._attach = attach
+        self._raw: socket.socket = getattr(attach, "_sock", attach)
+        self._loop = asyncio.get_running_loop()
+        self._done = asyncio.Event()
+        self.pid: int | None = None
+        self.returncode: int | None = None
+        self.stdin = _ExecStdin(self._raw) if stdin else None
+        self.stdout = asyncio.StreamReader()
+        self.stderr = asyncio.StreamReader()
+        self._pid_seen = asyncio.Event()
+        self._reader = threading.Thread(target=self._pump, name=f"exec-{exec_id[:12]}", daemon=True)
+        self._reader.start()
+
+    def _frames(self) -> Iterator[tuple[int, bytes]]:
+        return frames_iter(self._attach, False)
+
+    def _pump(self) -> None:
+        """Demultiplex the attach stream into the stdout/stderr readers."""
+        pending = b""
+        try:
+            for stream, data in self._frames():
+                if stream == STDERR:
+                    self._loop.call_soon_threadsafe(self.stderr.feed_data, data)
+                    continue
+                if stream != STDOUT:
+                    continue
+                if self.pid is None:
+                    # Strip the wrapper's PID line before handing output on
+                    pending += data
+                    if b"\n" not in pending:
+                        continue
+                    line, _, data = pending.partition(b"\n")
+                    pending = b""
+                    if line.startswith(PID_MARKER):
+                        self.pid = int(line[len(PID_MARKER):].strip() or 0) or None
+                    else:
+                        data = line + b"\n" + data
+                    self._loop.call_soon_threadsafe(self._pid_seen.set)
+                if data:
+                    self._loop.call_soon_threadsafe(self.stdout.feed_data, data)
+        except Exception as e:
+            logger.debug(f"Exec stream ended: {e}")
+        finally:
+            if pending:
+                self._loop.call_soon_threadsafe(self.stdout.feed_data, pending)
+            try:
+                exit_code = self._client.api.exec_inspect(self._exec_id).get("ExitCode")
+            except Exception:
+                exit_code = None
+            self._loop.call_soon_threadsafe(self._finish, exit_code)
+
+    def _finish(self, exit_code: int | None) -> None:
+        self.returncode = exit_code if exit_code is not None else -1
+        self.stdout.feed_eof()
+        self.stderr.feed_eof()
+        self._pid_seen.set()
+        self._done.set()
+
+    async def wait(self) -> int:
+        await self._done.wait()
+        return self.returncode if self.returncode is not None else -1
+
+    async def communicate(self) -> tuple[bytes, bytes]:
+        """Read stdout and stderr until the command exits."""
+        stdout, stderr = await asyncio.gather(self.stdout.read(), self.stderr.read())
+        await self.wait()
+        return stdout, stderr
+
+    def _signal(self, sig: str) -> None:
+        if self.returncode is not None:
+            return
+        if self.pid:
+            try:
+                exec_id = self._client.api.exec_create(self._container_id, ["kill", f"-{sig}", str(self.pid)])["Id"]
+                self._client.api.exec_start(exec_id)
+            except Exception as e:
+                logger.debug(f"Could not signal PID {self.pid}: {e}")
+        if sig == "KILL":
+            try:
+                self._raw.shutdown(socket.SHUT_RDWR)
+            except OSError:
+                pass
+
+    def send_signal(self, sig: int) -> None:
+        self._signal(signal.Signals(sig).name.removeprefix("SIG"))
+
+    def terminate(self) -> None:
+        self._signal("TERM")
+
+    def kill(self) -> None:
+        self._signal("KILL")
+
+
+async def open_exec(
+    docker_client: Any,
+    container_name: str,
+    cmd: list[str],
+    stdin: bool = True
+) -> Any:
+    """
+    Start cmd in the container with its output (and optionally stdin) attached.
+
+    Raises:
+        ContainerExecError: if the container does not exist or the daemon
+            refuses the exec
+    """
+    client = _docker_client(docker_client)
+    if hasattr(client, "open_exec"):
+        return await client.open_exec(container_name, cmd, stdin)
+
+    environment = correlation_environment()
+
+    def start() -> tuple[str, str, Any]:
+        container = client.containers.get(container_name)
+        exec_id = client.api.exec_create(
+            container.id, PID_WRAPPER + cmd,
+            stdin=stdin, stdout=True, stderr=True, tty=False, environment=environment or None
+        )["Id"]
+        return container.id, exec_id, client.api.exec_start(exec_id, socket=True, tty=False)
+
+    try:
+        container_id, exec_id, attach = await asyncio.to_thread(start)
+    except NotFound as e:
+        raise ContainerExecError(f"Container {container_name} not found") from e
+    except APIError as e:
+        raise ContainerExecError(f"Docker refused to run {cmd[0]}: {e.explanation or e}") from e
+
+    process = ExecProcess(client, container_id, exec_id, attach, stdin)
+    await process._pid_seen.wait()
+    return process
+
+
+async def exec_in_container(
+    docker_client: Any,
+    container_name: str,
+    cmd: list[str],
+    timeout: float = 60
+) -> tuple[int, str, str]:
+    """
+    Run a command in the container and capture its output.
+
+    Returns:
+        Tuple of (exit code, stdout, stderr)
+
+    Raises:
+        asyncio.TimeoutError: if the command does not finish in time; the
+            command is killed, as it is when the call is cancelled
+        ContainerExecError: if the command could not be started
+    """
+    with telemetry.span("container.exec", exec_attributes(container_name, cmd)) as span:
+        process = await open_exec(docker_client, container_name, cmd, stdin=False)
+        try:
+            stdout, stderr = await asyncio.wait_for(process.communicate(), timeout=timeout)
+        except asyncio.TimeoutError:
+            await asyncio.to_thread(process.kill)
+            raise
+        except asyncio.CancelledError:
+            # Not awaited: the task is being cancelled
+            asyncio.get_running_loop().run_in_executor(None, process.kill)
+            raise
+
+        exit_code = process.returncode if process.returncode is not None else -1
+        span.set_attribute("process.exit.code", exit_code)
+        return (
+            exit_code,
+            stdout.decode("utf-8", errors="replace"),
+            stderr.decode("utf-8", errors="replace"),
+        )
+
+
+def exec_attributes(container_name: str, cmd: list[str]) -> dict[str, Any]:
+    """Span attributes for running cmd; a swipl command's last -g goal is the goal traced."""
+    goals = [cmd[i + 1] for i, arg in enumerate(cmd[:-1]) if arg == "-g"]
+    return {
+        "container.name": container_name,
+        "process.command": cmd[0] if cmd else None,
+        "prolog.goal": telemetry.goal(goals[-1]) if cmd and cmd[0] == "swipl" and goals else None,
+    }
+
+
+async def run_swipl_goal(
+    docker_client: Any,
+    container_name: str,
+    goal: str,
+    timeout: float = 60
+) -> tuple[int, str, str]:
+    """Run a goal in a fresh, non-interactive swipl process in the container."""
+    return await exec_in_container(
+        docker_client,
+        container_name,
+        ["swipl", "-q", "-g", goal, "-t", "halt"],
+        timeout=timeout
+    )
+
+
+async def run_swipl_with_program(
+    docker_client: Any,
+    container_name: str,
+    program: str,
+    goal: str,
+    timeout: float = 60
+) -> tuple[int, str, str]:
+    """Run a goal in a fresh swipl process after consulting program, sent on its stdin."""
+    cmd = ["swipl", "-q", "-g", "load_files(mcp_program, [stream(user_input)])", "-g", goal, "-t", "halt"]
+    with telemetry.span("container.exec", exec_attributes(container_name, cmd)) as span:
+        process = await open_exec(docker_client, container_name, cmd, stdin=True)
+        try:
+            process.stdin.write(program.encode("utf-8"))
+            await process.stdin.drain()
+            process.stdin.close()
+            stdout, stderr = await asyncio.wait_for(process.communicate(), timeout=timeout)
+        except asyncio.TimeoutError:
+            await asyncio.to_thread(process.kill)
+            raise
+        except asyncio.CancelledError:
+            # Not awaited: the task is being cancelled
+            asyncio.get_running_loop().run_in_executor(None, process.kill)
+            raise
+
+        exit_code = process.returncode if process.returncode is not None else -1
+        span.set_attribute("process.exit.code", exit_code)
+        return (
+            exit_code,
+            stdout.decode("utf-8", errors="replace"),
+            stderr.decode("utf-8", errors="replace"),
+        )
@@ -0,0 +1,114 @@
+"""
+Query Result Cursors for Docker SWISH MCP
+
+Tracks the paginated queries open in the persistent session. The solutions
+themselves stay in a Prolog engine (see mcp_cursor_open/6 in
+mcp_helpers.pl); this table only remembers who owns each cursor, how it
+pages, and when it was last used so idle engines can be destroyed. The
+exception are solutions an answer size limit held back (see
+answer_limits.py), which wait here for the next page.
+"""
+
+import time
+import uuid
+from dataclasses import dataclass, field
+from typing import Any
+
+
+class CursorError(Exception):
+    """Raised for unknown, foreign or expired cursors."""
+
+
+@dataclass
+class CursorInfo:
+    """An open paginated query."""
+    cursor_id: str
+    client_id: str
+    query: str
+    output_format: str
+    page_size: int
+    generation: int
+    fetched: int = 0
+    pages: int = 0
+    # Solutions fetched but held back by the answer size limit, returned first
+    pending: list[Any] = field(default_factory=list)
+    # The engine has no more solutions (or there is none): only pending are left
+    exhausted: bool = False
+    # The answer size limit of its pages, in bytes; 0 is none
+    answer_bytes: int = 0
+    created: float = field(default_factory=time.time)
+    last_used: float = field(default_factory=time.time)
+
+
+class CursorTable:
+    """
+    Server-side cursor table.
+
+    Args:
+        ttl: Seconds a cursor may stay idle before it is expired
+        max_per_client: Open cursors allowed per client; opening another
+            evicts that client's least recently used cursor
+    """
+
+    def __init__(self, ttl: float = 300.0, max_per_client: int = 16):
+        self.ttl = ttl
+        self.max_per_client = max_per_client
+        self.cursors: dict[str, CursorInfo] = {}
+
+    def open(
+        self,
+        client_id: str,
+        query: str,
+        output_format: str,
+        page_size: int,
+        generation: int
+    ) -> tuple[CursorInfo, list[str]]:
+        """
+        Register a new cursor.
+
+        Returns:
+            The cursor and the ids of cursors evicted to make room, whose
+            engines the caller should destroy
+        """
+        evicted = []
+        owned = sorted(
+            (c for c in self.cursors.values() if c.client_id == client_id),
+            key=lambda c: c.last_used
+        )
+        while len(owned) >= self.max_per_client:
+            evicted.append(owned.pop(0).cursor_id)
+        for cursor_id in evicted:
+            del self.cursors[cursor_id]
+
+        info = CursorInfo(
+            cursor_id=f"c{uuid.uuid4().hex[:12]}",
+            client_id=client_id,
+            query=query,
+            output_format=output_format,
+            page_size=page_size,
+            generation=generation,
+        )
+        self.cursors[info.cursor_id] = info
+        return info, evicted
+
+    def get(self, cursor_id: str, client_id: str, generation: int) -> CursorInfo:
+        """Look up a cursor owned by client_id in the current session."""
+        info = self.cursors.get(cursor_id)
+        if info is None or info.client_id != client_id:
+            raise CursorError(f"Unknown cursor '{cursor_id}'. It may have expired; run the query again.")
+        if info.generation != generation:
+            del self.cursors[cursor_id]
+            raise CursorError(f"Cursor '{cursor_id}' was lost when the Prolog session restarted; run the query again.")
+        info.last_used = time.time()
+        return info
+
+    def close(self, cursor_id: str) -> None:
+        self.cursors.pop(cursor_id, None)
+
+    def expire(self) -> list[str]:
+        """Drop idle cursors and return their ids."""
+        cutoff = time.time() - self.ttl
+        expired = [c.cursor_id for c in self.cursors.values() if c.last_used < cutoff]
+        for cursor_id in expired:
+            del self.cursors[cursor_id]
+        return expired
@@ -0,0 +1,273 @@
+"""
+Status Dashboard for Docker SWISH MCP
+
+With SWISH_MCP_DASHBOARD=on the http/sse transports also serve a small
+web page at /dashboard for operators without an MCP client at hand:
+container health, the Prolog sessions and workers, running and recent
+queries, the knowledge base files and the last container log lines,
+refreshed every few seconds, with buttons that restart the Prolog
+session and snapshot the knowledge base.
+
+Everything it shows and does is admin business, so once API keys are
+configured it needs a key with the admin scope: as a bearer header (for
+curl and scripts), or entered once on the login form, which keeps it in
+an HttpOnly, SameSite=Strict cookie scoped to the dashboard. Buttons
+post to the dashboard, and posts from another origin are refused.
+
+Routes, under DASHBOARD_PATH:
+
+- GET /: the page
+- GET /state: what the page shows, as JSON
+- POST /actions/restart, POST /actions/snapshot: run an action, answering
+  {"action": ..., "result": <the tool's text>}
+- POST /login, POST /logout
+"""
+
+import html
+import json
+import logging
+from collections.abc import Awaitable, Callable, MutableMapping
+from typing import Any
+from urllib.parse import parse_qs, quote, unquote
+
+from .auth import ApiKey, ApiKeyStore
+from .http_serving import _header
+
+logger = logging.getLogger("docker-swish-mcp.dashboard")
+
+DASHBOARD_PATH = "/dashboard"
+COOKIE = "swish_mcp_dashboard"
+# Seconds between refreshes of the page
+REFRESH_SECONDS = 5
+DASHBOARD_LOG_LINES = 50
+MAX_FORM_BYTES = 4096
+
+LOGIN_PAGE = """<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8"><title>SWISH MCP dashboard</title>
+<style>body{{font-family:system-ui,sans-serif;margin:4em auto;max-width:24em}}
+input{{width:100%;padding:.4em;margin:.5em 0}} .error{{color:#b00}}</style></head>
+<body><h1>SWISH MCP</h1><p>An API key with the admin scope is needed to open the dashboard.</p>
+<form method="post" action="{path}/login"><input type="password" name="key" placeholder="API key" autofocus>
+<button type="submit">Open dashboard</button></form>{message}</body></html>
+"""
+
+PAGE = """<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8"><title>SWISH MCP dashboard</title>
+<style>
+body{font-family:system-ui,sans-serif;margin:1.5em;color:#222}
+h1{font-size:1.4em} h2{font-size:1.1em;margin-top:1.5em}
+table{border-collapse:collapse;width:100%} td,th{text-align:left;padding:.2em .6em;border-bottom:1px solid #ddd}
+pre{background:#f4f4f4;padding:.6em;max-height:24em;overflow:auto;font-size:.85em}
+.ok{color:#070} .bad{color:#b00} .muted{color:#777} button{margin-right:.5em}
+</style></head>
+<body>
+<h1>SWISH MCP <span class="muted" id="updated"></span></h1>
+<p><button data-action="restart">Restart Prolog session</button>
+<button data-action="snapshot">Snapshot knowledge base</button>
+<form method="post" action="PATH/logout" style="display:inline"><button type="submit">Log out</button></form></p>
+<pre id="result" hidden></pre>
+<h2>Containers</h2><table id="health"></table>
+<h2>Sessions</h2><table id="sessions"></table>
+<h2>Running queries</h2><table id="running"></table>
+<h2>Recent queries</h2><table id="recent"></table>
+<h2>Knowledge base files</h2><table id="files"></table>
+<h2>Container log</h2><pre id="logs"></pre>
+<script>
+const base = "PATH";
+function cell(value) {
+  const td = document.createElement("td");
+  td.textContent = value === undefined || value === null ? "" : String(value);
+  return td;
+}
+function table(id, headings, rows) {
+  const t = document.getElementById(id);
+  t.replaceChildren();
+  const head = document.createElement("tr");
+  for (const h of headings) { const th = document.createElement("th"); th.textContent = h; head.append(th); }
+  t.append(head);
+  if (!rows.length) { const tr = document.createElement("tr"); const td = cell("none"); td.className = "muted"; tr.append(td); t.append(tr); }
+  for (const row of rows) { const tr = document.createElement("tr"); for (const v of row) tr.append(cell(v)); t.append(tr); }
+}
+function when(seconds) { return new Date(seconds * 1000).toLocaleTimeString(); }
+async function refresh() {
+  const response = await fetch(base + "/state", {credentials: "same-origin"});
+  if (response.status === 401) { location.reload(); return; }
+  const s = await response.json();
+  table("health", ["Instance", "Container", "Status", "Ready", "Session"],
+    Object.entries(s.health).map(([n, h]) => [n, h.container, h.status, h.ready ? "yes" : "no", h.session_active ? "active" : "none"]));
+  table("sessions", ["Instance", "Active", "Queries", "Workers running", "Queued"],
+    Object.entries(s.sessions).map(([n, x]) => [n, x.active ? "yes" : "no", x.query_count, x.workers_running, x.workers_queued]));
+  table("running", ["Id", "Goal", "Where", "Client", "Seconds"],
+    s.running.map(q => [q.query_id, q.goal, q.instance ? q.where + ", " + q.instance : q.where, q.client, q.seconds]));
+  table("recent", ["Finished", "Goal", "Where", "Client", "Seconds", "Status"],
+    s.recent.map(q => [when(q.started + q.seconds), q.goal, q.where, q.client_id, q.seconds, q.status]));
+  table("files", ["File", "Bytes", "Modified"], s.files.map(f => [f.name, f.bytes, when(f.modified)]));
+  document.getElementById("logs").textContent = s.logs.join("\\n") || "No log output yet";
+  document.getElementById("updated").textContent = "· " + new Date().toLocaleTimeString();
+}
+for (const button of document.querySelectorAll("button[data-action]")) {
+  button.addEventListener("click", async () => {
+    const out = document.getElementById("result");
+    button.disabled = true;
+    try {
+      const response = await fetch(base + "/actions/" + button.dataset.action, {method: "POST", credentials: "same-origin"});
+      const body = await response.json();
+      out.textContent = body.result || body.message;
+    } finally { out.hidden = false; button.disabled = false; refresh(); }
+  });
+}
+refresh();
+setInterval(refresh, REFRESH * 1000);
+</script>
+</body></html>
+"""
+
+
+def cookie_value(scope: MutableMapping[str, Any], name: str) -> str:
+    for part in _header(scope, b"cookie").split(";"):
+        key, _, value = part.strip().partition("=")
+        if key == name:
+            return unquote(value)
+    return ""
+
+
+class DashboardMiddleware:
+    """ASGI middleware serving the dashboard under DASHBOARD_PATH and passing every other request on."""
+
+    def __init__(
+        self,
+        app: Any,
+        keys: ApiKeyStore,
+        state: Callable[[], Awaitable[dict[str, Any]]],
+        actions: dict[str, Callable[[], Awaitable[str]]],
+    ):
+        self.app = app
+        self.keys = keys
+        self.state = state
+        self.actions = actions
+
+    async def __call__(self, scope: MutableMapping[str, Any], receive: Any, send: Any) -> None:
+        path = scope.get("path", "") if scope["type"] == "http" else ""
+        if path != DASHBOARD_PATH and not path.startswith(DASHBOARD_PATH + "/"):
+            await self.app(scope, receive, send)
+            return
+        route = path[len(DASHBOARD_PATH):].rstrip("/") or "/"
+        method = scope["method"]
+
+        if route == "/login" and method == "POST":
+            await self.login(scope, receive, send)
+            return
+        if route == "/logout" and method == "POST":
+            await self._redirect(send, self._cookie(scope, "", 0))
+            return
+
+        key, by_cookie = self.authenticate(scope)
+        if self.keys.enabled and (key is None or not key.allows("admin")):
+            if route == "/" and method == "GET":
+                message = "<p class=error>That key does not have the admin scope.</p>" if key else ""
+                page = LOGIN_PAGE.format(path=DASHBOARD_PATH, message=message)
+                await self._respond(send, 200, "text/html", page.encode())
+            else:
+                await self._json(send, 401, {"error": "unauthorized", "message": "An admin API key is required"})
+            return
+
+        if route == "/" and method == "GET":
+            page = PAGE.replace("PATH", DASHBOARD_PATH).replace("REFRESH", str(REFRESH_SECONDS))
+            await self._respond(send, 200, "text/html", page.encode())
+        elif route == "/state" and method == "GET":
+            try:
+                state = await self.state()
+            except Exception as e:
+                await self._json(send, 503, {"error": "not_ready", "message": str(e)})
+                return
+            await self._json(send, 200, state)
+        elif route.startswith("/actions/") and method == "POST":
+            await self.act(scope, send, route.removeprefix("/actions/"), by_cookie, key)
+        else:
+            await self._json(send, 404, {"error": "not_found", "message": f"No dashboard route {method} {route}"})
+
+    def authenticate(self, scope: MutableMapping[str, Any]) -> tuple[ApiKey | None, bool]:
+        """The key of the request, and whether it came from the cookie."""
+        if not self.keys.enabled:
+            return None, False
+        self.keys.maybe_reload()
+        scheme, _, token = _header(scope, b"authorization").partition(" ")
+        if scheme.lower() == "bearer" and token.strip():
+            return self.keys.authenticate(token.strip()), False
+        token = cookie_value(scope, COOKIE)
+        return (self.keys.authenticate(token), True) if token else (None, False)
+
+    async def login(self, scope: MutableMapping[str, Any], receive: Any, send: Any) -> None:
+        form = parse_qs((await self._body(receive)).decode("utf-8", "replace"))
+        token = (form.get("key") or [""])[0].strip()
+        key = self.keys.authenticate(token) if token and self.keys.enabled else None
+        if key is None or not key.allows("admin"):
+            message = "That key does not have the admin scope." if key else "Unknown API key."
+            page = LOGIN_PAGE.format(path=DASHBOARD_PATH, message=f"<p class=error>{html.escape(message)}</p>")
+            await self._respond(send, 401, "text/html", page.encode())
+            return
+        logger.info(f"API key '{key.key_id}' opened the dashboard")
+        await self._redirect(send, self._cookie(scope, token, 12 * 3600))
+
+    async def act(
+        self, scope: MutableMapping[str, Any], send: Any, name: str, by_cookie: bool, key: ApiKey | None
+    ) -> None:
+        origin = _header(scope, b"origin")
+        expected = f"{scope.get('scheme', 'http')}://{_header(scope, b'host')}"
+        # A browser always sends Origin on a fetch POST; without it the cookie was sent by a form elsewhere
+        if (origin or by_cookie) and origin != expected:
+            await self._json(send, 403, {
+                "error": "origin_not_allowed", "message": "Dashboard actions must come from the dashboard"
+            })
+            return
+        action = self.actions.get(name)
+        if action is None:
+            await self._json(send, 404, {
+                "error": "not_found", "message": f"Unknown action '{name}'. Use: {', '.join(self.actions)}"
+            })
+            return
+        logger.info(f"Dashboard action {name} by {key.key_id if key else 'anonymous'}")
+        try:
+            result = await action()
+        except Exception as e:
+            logger.error(f"Dashboard action {name} failed: {e}")
+            await self._json(send, 500, {"error": "internal", "message": str(e)})
+            return
+        await self._json(send, 200, {"action": name, "result": result})
+
+    def _cookie(self, scope: MutableMapping[str, Any], value: str, max_age: int) -> bytes:
+        secure = "; Secure" if scope.get("scheme") == "https" else ""
+        cookie = f"{COOKIE}={quote(value, safe='')}; Path={DASHBOARD_PATH}; Max-Age={max_age}"
+        return f"{cookie}; HttpOnly; SameSite=Strict{secure}".encode("latin-1")
+
+    async def _body(self, receive: Any) -> bytes:
+        body = b""
+        while True:
+            message = await receive()
+            body += message.get("body", b"")
+            if len(body) > MAX_FORM_BYTES or not message.get("more_body"):
+                return body[:MAX_FORM_BYTES]
+
+    async def _redirect(self, send: Any, cookie: bytes) -> None:
+        await send({
+            "type": "http.response.start",
+            "status": 303,
+            "headers": [(b"location", (DASHBOARD_PATH + "/").encode()), (b"set-cookie", cookie), (b"content-length", b"0")],
+        })
+        await send({"type": "http.response.body", "body": b""})
+
+    async def _json(self, send: Any, status: int, payload: Any) -> None:
+        await self._respond(send, status, "application/json", json.dumps(payload, default=str).encode())
+
+    async def _respond(self, send: Any, status: int, content_type: str, body: bytes) -> None:
+        await send({
+            "type": "http.response.start",
+            "status": status,
+            "headers": [
+                (b"content-type", f"{content_type}; charset=utf-8".encode()),
+                (b"content-length", str(len(body)).encode()),
+                (b"cache-control", b"no-store"),
+                (b"x-frame-options", b"DENY"),
+            ],
+        })
+        await send({"type": "http.response.body", "body": body})
@@ -0,0 +1,194 @@
+"""
+Query Result Export for Docker SWISH MCP
+
+export_results runs a goal and writes every solution as one row of a CSV,
+JSON Lines or Parquet file, the counterpart of import_data. Solutions
+arrive as typed bindings (see mcp_term_json/2 in mcp_helpers.pl); the
+columns and their types are taken from the first solution:
+
+- integer, float: numbers
+- string:         atoms and strings, written as their plain text
+- list:           lists, JSON arrays in JSON Lines and Prolog text otherwise
+- term:           compounds and unbound variables, as Prolog text
+
+Integers in a float column are widened. Other cells that do not match
+their column's type are written as Prolog text in string columns and as
+empty (null) cells in numeric ones, and are counted in the report.
+Parquet needs pyarrow (pip install pyarrow, or the parquet extra).
+"""
+
+import csv
+import io
+import json
+import math
+import re
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+EXPORT_FORMATS = ("csv", "jsonl", "parquet")
+FORMAT_SUFFIXES = {"csv": ".csv", "jsonl": ".jsonl", "parquet": ".parquet"}
+MAX_EXPORT_ROWS = 100_000
+# Results up to this size are returned inline when no filename is given
+INLINE_LIMIT = 16 * 1024
+EXPORT_DIR = "exports"
+
+PLAIN_ATOM_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+TYPE_COLUMNS = {"integer": "integer", "float": "float", "atom": "string", "string": "string", "list": "list"}
+
+
+@dataclass
+class Export:
+    """Solutions as typed columns, ready to be written in any format."""
+    columns: list[str]
+    types: list[str]
+    rows: list[list[Any]] = field(default_factory=list)
+    # Cells that did not match their column's type
+    mismatched: int = 0
+
+    def schema(self) -> str:
+        return ", ".join(f"{name}:{kind}" for name, kind in zip(self.columns, self.types))
+
+
+def term_text(value: dict[str, Any]) -> str:
+    """Prolog text of a typed binding."""
+    kind = value.get("type")
+    if kind == "integer":
+        return str(value["value"])
+    if kind == "float":
+        number = value["value"]
+        return number if isinstance(number, str) else repr(float(number))
+    if kind == "atom":
+        text = value["value"]
+        return text if PLAIN_ATOM_RE.match(text) else prolog_atom(text)
+    if kind == "string":
+        return prolog_string(value["value"])
+    if kind == "list":
+        return "[" + ",".join(term_text(item) for item in value["items"]) + "]"
+    if kind == "compound":
+        functor = value["functor"]
+        name = functor if PLAIN_ATOM_RE.match(functor) else prolog_atom(functor)
+        return f"{name}({','.join(term_text(arg) for arg in value['args'])})"
+    if kind == "var":
+        return "_"
+    return str(value.get("text", ""))
+
+
+def _float(value: Any) -> float:
+    if not isinstance(value, str):
+        return float(value)
+    # inf and nan arrive as text: "inf", "nan", or "1.0Inf", "1.5NaN" in older versions
+    text = value.lower()
+    if "nan" in text:
+        return math.nan
+    return -math.inf if text.startswith("-") else math.inf
+
+
+def plain_value(value: dict[str, Any]) -> Any:
+    """JSON value of a typed binding, for list items."""
+    kind = value.get("type")
+    if kind == "integer":
+        return value["value"]
+    if kind == "float":
+        number = _float(value["value"])
+        return number if math.isfinite(number) else None
+    if kind in ("atom", "string"):
+        return value["value"]
+    if kind == "list":
+        return [plain_value(item) for item in value["items"]]
+    return term_text(value)
+
+
+def build_export(solutions: list[dict[str, dict[str, Any]]]) -> Export:
+    """Typed rows of the solutions, with the schema of the first one."""
+    first = solutions[0]
+    export = Export(list(first), [TYPE_COLUMNS.get(value.get("type"), "term") for value in first.values()])
+    for solution in solutions:
+        row = []
+        for name, kind in zip(export.columns, export.types):
+            value = solution.get(name, {"type": "var"})
+            actual = TYPE_COLUMNS.get(value.get("type"), "term")
+            if kind in ("list", "term") and actual == kind:
+                row.append(value)
+            elif kind == "float" and actual in ("integer", "float"):
+                row.append(_float(value["value"]))
+            elif actual == kind:
+                row.append(value["value"])
+            elif kind in ("integer", "float"):
+                row.append(None)
+                export.mismatched += 1
+            else:
+                row.append(term_text(value))
+                export.mismatched += 1
+        export.rows.append(row)
+    return export
+
+
+def _cell(value: Any, kind: str, structured: bool) -> Any:
+    if value is None or isinstance(value, str):
+        return value
+    if kind in ("list", "term"):
+        return plain_value(value) if structured and kind == "list" else term_text(value)
+    if kind == "float" and not math.isfinite(value):
+        return None if structured else str(value)
+    return value
+
+
+def render_csv(export: Export) -> str:
+    buffer = io.StringIO()
+    writer = csv.writer(buffer, lineterminator="\n")
+    writer.writerow(export.columns)
+    for row in export.rows:
+        writer.writerow([
+            "" if (cell := _cell(value, kind, False)) is None else cell
+            for value, kind in zip(row, export.types)
+        ])
+    return buffer.getvalue()
+
+
+def render_jsonl(export: Export) -> str:
+    lines = []
+    for row in export.rows:
+        record = {name: _cell(value, kind, True) for name, value, kind in zip(export.columns, row, export.types)}
+        lines.append(json.dumps(record, ensure_ascii=False, allow_nan=False))
+    return "\n".join(lines) + "\n"
+
+
+def render_parquet(export: Export) -> bytes:
+    """Parquet file bytes; raises ValueError without pyarrow."""
+    try:
+        import pyarrow as pa
+        import pyarrow.parquet as pq
+    except ImportError as e:
+        raise ValueError("Parquet export needs pyarrow (pip install pyarrow); or use csv or jsonl") from e
+    arrow_types = {"integer": pa.int64(), "float": pa.float64()}
+    arrays = []
+    for index, kind in enumerate(export.types):
+        if kind == "float":
+            cells = [row[index] for row in export.rows]
+        else:
+            cells = [_cell(row[index], kind, False) for row in export.rows]
+        arrays.append(pa.array(cells, type=arrow_types.get(kind, pa.string())))
+    buffer = io.BytesIO()
+    pq.write_table(pa.Table.from_arrays(arrays, names=export.columns), buffer)
+    return buffer.getvalue()
+
+
+def render(export: Export, data_format: str) -> bytes:
+    if data_format == "parquet":
+        return render_parquet(export)
+    text = render_csv(export) if data_format == "csv" else render_jsonl(export)
+    return text.encode("utf-8")
+
+
+def export_filename(filename: str, data_format: str) -> str:
+    """filename with the format's suffix added when it has none; raises ValueError on another format's."""
+    suffix = Path(filename).suffix.lower()
+    if not suffix:
+        return filename + FORMAT_SUFFIXES[data_format]
+    if suffix in FORMAT_SUFFIXES.values() and suffix != FORMAT_SUFFIXES[data_format]:
+        raise ValueError(f"'{filename}' does not match data_format {data_format}")
+    return filename
@@ -0,0 +1,270 @@
+"""
+Tabular Data Import for Docker SWISH MCP
+
+import_data turns CSV, TSV, JSON or JSON Lines into facts of one
+predicate, one fact per row, and asserts them through
+mcp_import_facts/5 (see mcp_helpers.pl) in a single transaction.
+
+Columns become arguments in the order they are listed, each optionally
+with a type: "name:atom", "age:integer". Without a list every column is
+used in source order. Typed cells that cannot be converted skip their row,
+and the row is reported. Types:
+
+- auto:    integers and floats become numbers, except codes with leading
+           zeros; everything else becomes an atom (the default)
+- atom, string, integer, float, number, boolean ("true"/"false",
+  "yes"/"no", "1"/"0")
+
+Empty cells and JSON nulls are '' (auto, atom), "" (string) or an error
+for the numeric types. JSON arrays in a cell become Prolog lists.
+"""
+
+import csv
+import io
+import json
+import math
+import re
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+import aiohttp
+
+from .rdf import prolog_atom
+from .remote_sources import MAX_SOURCE_BYTES, RemoteSourceError
+from .simple_session import prolog_string
+
+IMPORT_FORMATS = ("auto", "csv", "tsv", "json", "jsonl")
+COLUMN_TYPES = ("auto", "atom", "string", "integer", "float", "number", "boolean")
+SUFFIX_FORMATS = {".csv": "csv", ".tsv": "tsv", ".json": "json", ".jsonl": "jsonl", ".ndjson": "jsonl"}
+MAX_IMPORT_ROWS = 100_000
+# Facts shown by a dry run
+PREVIEW_ROWS = 10
+
+PREDICATE_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+PLAIN_ATOM_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+INTEGER_RE = re.compile(r"^[+-]?\d+$")
+FLOAT_RE = re.compile(r"^[+-]?(\d+\.\d*|\.\d+|\d+)([eE][+-]?\d+)?$")
+# Codes such as zip codes, which auto keeps as atoms
+LEADING_ZERO_RE = re.compile(r"^[+-]?0\d")
+BOOLEANS = {"true": "true", "false": "false", "yes": "true", "no": "false", "1": "true", "0": "false"}
+
+
+class CellError(ValueError):
+    """A cell that cannot be converted to its column's type."""
+
+
+@dataclass(frozen=True)
+class Column:
+    name: str
+    type: str = "auto"
+
+
+@dataclass
+class ImportPlan:
+    """Facts built from the rows, and the rows that could not be converted."""
+    predicate: str
+    columns: list[Column]
+    facts: list[str] = field(default_factory=list)
+    # (row number, reason), rows counted from 1 after any header
+    skipped: list[tuple[int, str]] = field(default_factory=list)
+
+    @property
+    def arity(self) -> int:
+        return len(self.columns)
+
+
+def parse_columns(specs: list[str]) -> list[Column]:
+    """Columns from "name" or "name:type" specs; raises ValueError."""
+    columns = []
+    for spec in specs:
+        name, _, kind = spec.strip().rpartition(":")
+        if not name or kind.lower() not in COLUMN_TYPES:
+            name, kind = spec.strip(), "auto"
+        if not name:
+            raise ValueError("Column names must not be empty")
+        columns.append(Column(name, kind.lower()))
+    return columns
+
+
+def detect_format(text: str, source: str = "") -> str:
+    suffix = Path(source.split("?")[0]).suffix.lower()
+    if suffix in SUFFIX_FORMATS:
+        return SUFFIX_FORMATS[suffix]
+    stripped = text.lstrip()
+    if stripped.startswith("["):
+        return "json"
+    if stripped.startswith("{"):
+        return "jsonl" if "\n{" in stripped or "\n {" in stripped else "json"
+    first_line = stripped.split("\n", 1)[0]
+    return "tsv" if "\t" in first_line and "," not in first_line else "csv"
+
+
+def read_rows(text: str, data_format: str, header: bool = True) -> tuple[list[str], list[list[Any]]]:
+    """Column names and rows of the data; without a header columns are named 1, 2, ..."""
+    if data_format in ("csv", "tsv"):
+        reader = csv.reader(io.StringIO(text), delimiter="\t" if data_format == "tsv" else ",")
+        rows: list[list[Any]] = [row for row in reader if row]
+        names = rows.pop(0) if header and rows else []
+    else:
+        if data_format == "jsonl":
+            records = [json.loads(line) for line in text.splitlines() if line.strip()]
+        else:
+            records = json.loads(text)
+            if isinstance(records, dict):
+                records = [records]
+        if not isinstance(records, list):
+            raise ValueError("JSON data must be an array of objects or arrays")
+        names = []
+        rows = []
+        for record in records:
+            if isinstance(record, dict):
+                for key in record:
+                    if key not in names:
+                        names.append(key)
+                rows.append([record.get(key) for key in names])
+            elif isinstance(record, list):
+                rows.append(record)
+            else:
+                raise ValueError(f"JSON rows must be objects or arrays, not {type(record).__name__}")
+        # Objects seen earlier lack keys introduced later
+        rows = [row + [None] * (len(names) - len(row)) if len(row) < len(names) else row for row in rows]
+    width = max([len(names), *(len(row) for row in rows)], default=0)
+    names = [*names, *(str(i + 1) for i in range(len(names), width))]
+    if len(rows) > MAX_IMPORT_ROWS:
+        raise ValueError(f"At most {MAX_IMPORT_ROWS} rows can be imported at once, got {len(rows)}")
+    return names, rows
+
+
+def atom_text(text: str) -> str:
+    return text if PLAIN_ATOM_RE.match(text) else prolog_atom(text)
+
+
+def float_text(value: float) -> str:
+    if math.isinf(value) or math.isnan(value):
+        raise CellError(f"{value} has no Prolog float syntax")
+    text = repr(value)
+    mantissa, e, exponent = text.partition("e")
+    if "." not in mantissa:
+        mantissa += ".0"
+    return f"{mantissa}{e}{exponent}"
+
+
+def prolog_value(value: Any, kind: str) -> str:
+    """Prolog term text of a cell; raises CellError if it does not fit kind."""
+    if isinstance(value, list):
+        return "[" + ", ".join(prolog_value(item, kind) for item in value) + "]"
+    if isinstance(value, dict):
+        value = json.dumps(value)
+    if value is None:
+        value = ""
+    if isinstance(value, bool):
+        value = "true" if value else "false"
+    text = value if isinstance(value, str) else repr(value)
+    stripped = text.strip()
+
+    if kind == "string":
+        return prolog_string(text)
+    if kind == "atom":
+        return atom_text(text)
+    if kind == "boolean":
+        if stripped.lower() not in BOOLEANS:
+            raise CellError(f"'{text}' is not a boolean")
+        return BOOLEANS[stripped.lower()]
+    if kind == "integer":
+        if isinstance(value, float) and value.is_integer():
+            return str(int(value))
+        if not INTEGER_RE.match(stripped):
+            raise CellError(f"'{text}' is not an integer")
+        return str(int(stripped))
+    if kind == "auto" and LEADING_ZERO_RE.match(stripped):
+        return atom_text(text)
+    if kind in ("float", "number", "auto"):
+        if isinstance(value, int) or INTEGER_RE.match(stripped):
+            if kind != "float":
+                return str(int(stripped))
+            return float_text(float(stripped))
+        if isinstance(value, float) or (stripped and FLOAT_RE.match(stripped)):
+            return float_text(float(stripped))
+        if kind != "auto":
+            raise CellError(f"'{text}' is not a number")
+    return atom_text(text)
+
+
+def plan_import(predicate: str, names: list[str], rows: list[list[Any]], columns: list[Column]) -> ImportPlan:
+    """Build the facts, skipping rows with cells that do not convert."""
+    if not PREDICATE_RE.match(predicate):
+        raise ValueError(f"Invalid predicate name '{predicate}'; use lower_case_with_underscores")
+    columns = columns or [Column(name) for name in names]
+    missing = [column.name for column in columns if column.name not in names]
+    if missing:
+        raise ValueError(f"Unknown column(s) {', '.join(missing)}. The data has: {', '.join(names)}")
+    indexes = [names.index(column.name) for column in columns]
+    plan = ImportPlan(predicate, columns)
+    for number, row in enumerate(rows, start=1):
+        try:
+            args = [
+                prolog_value(row[index] if index < len(row) else None, column.type)
+                for index, column in zip(indexes, columns)
+            ]
+        except CellError as e:
+            plan.skipped.append((number, str(e)))
+            continue
+        plan.facts.append(f"{predicate}({', '.join(args)})" if args else predicate)
+    return plan
+
+
+def import_call(module: str, plan: ImportPlan, replace: bool) -> tuple[str, list[str]]:
+    return "mcp_import_facts", [
+        prolog_atom(module),
+        f"{plan.predicate}/{plan.arity}",
+        prolog_string("[" + ", ".join(plan.facts) + "]"),
+        "true" if replace else "false",
+    ]
+
+
+def format_plan(plan: ImportPlan, total_rows: int) -> str:
+    signature = ", ".join(f"{column.name}:{column.type}" for column in plan.columns)
+    lines = [
+        f"🔍 Dry run: {len(plan.facts)} of {total_rows} row(s) would become {plan.predicate}/{plan.arity} facts",
+        f"📐 {plan.predicate}({signature})",
+    ]
+    if plan.facts:
+        lines.append("📋 Preview:")
+        lines.extend(f"  {fact}." for fact in plan.facts[:PREVIEW_ROWS])
+        if len(plan.facts) > PREVIEW_ROWS:
+            lines.append(f"  ... {len(plan.facts) - PREVIEW_ROWS} more")
+    lines.extend(format_skipped(plan))
+    return "\n".join(lines)
+
+
+def format_skipped(plan: ImportPlan) -> list[str]:
+    if not plan.skipped:
+        return []
+    lines = [f"⚠️ {len(plan.skipped)} row(s) skipped:"]
+    lines.extend(f"  row {number}: {reason}" for number, reason in plan.skipped[:PREVIEW_ROWS])
+    if len(plan.skipped) > PREVIEW_ROWS:
+        lines.append(f"  ... {len(plan.skipped) - PREVIEW_ROWS} more")
+    return lines
+
+
+async def fetch_data(url: str, timeout: float = 30) -> str:
+    """Download a data file; raises RemoteSourceError."""
+    try:
+        async with aiohttp.ClientSession() as session:
+            async with session.get(url, timeout=aiohttp.ClientTimeout(total=timeout)) as response:
+                if response.status != 200:
+                    raise RemoteSourceError(f"Download failed: HTTP {response.status} for {url}")
+                chunks = []
+                received = 0
+                async for chunk in response.content.iter_chunked(64 * 1024):
+                    received += len(chunk)
+                    if received > MAX_SOURCE_BYTES:
+                        raise RemoteSourceError(f"{url} is larger than {MAX_SOURCE_BYTES // (1024 * 1024)} MB")
+                    chunks.append(chunk)
+    except aiohttp.ClientError as e:
+        raise RemoteSourceError(f"Download of {url} failed: {e}") from e
+    try:
+        return b"".join(chunks).decode("utf-8-sig")
+    except UnicodeDecodeError as e:
+        raise RemoteSourceError(f"{url} is not UTF-8 text") from e
@@ -0,0 +1,234 @@
+"""
+Disk Usage and the Data Directory Quota for Docker SWISH MCP
+
+The server writes into the mounted data directory (program files,
+exports/, spilled results/, notebooks, projects) and next to it
+(swish-snapshots/, swish-bundles/, swish-repro/ and swish-recordings/).
+disk_usage() shows what each of these holds, its largest files and the
+space left on the disk. Two limits keep a runaway export from filling the disk:
+
+- SWISH_MCP_DISK_QUOTA:   bytes the data directory and its snapshots,
+                          bundles, reproduce bundles and recordings may
+                          hold together, e.g. "5g"
+- SWISH_MCP_DISK_RESERVE: free space to leave on the disk, e.g. "2g"
+
+0 turns a limit off, and both are off by default. Once one is reached,
+tools that add files (exports, snapshots, bundles, file writes and
+Prolog goals that write files) fail with a resource_limit error whose
+"limit" is "disk", until files are deleted; results too large to
+return are then returned whole instead of spilled to a file. Reading,
+querying and deleting still work.
+
+Walking a large directory takes a while, so the sizes are measured at
+most every MEASURE_INTERVAL seconds; what the server writes in between
+is added to the last measurement.
+"""
+
+import heapq
+import logging
+import os
+import re
+import shutil
+import time
+from collections.abc import Callable
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .prolog_memory import parse_size
+from .resources import format_bytes
+
+logger = logging.getLogger("docker-swish-mcp.disk")
+
+MEASURE_INTERVAL = 10.0
+# Largest files listed by disk_usage
+LARGEST_FILES = 5
+# Goals that create or grow files: tell/1, append/1, open/3,4 for writing, and predicates writing a file whole
+WRITE_GOAL_RE = re.compile(
+    r"\b(?:tell|append)\(\s*[^,()]+\)"
+    r"|\bopen\([^,]+,\s*(?:write|append|update)\b"
+    r"|\b(?:copy_file|qsave_program|save_program|csv_write_file|rdf_save|rdf_save_turtle|save_tables)\("
+)
+
+
+def writes_files(goal: str) -> bool:
+    return bool(WRITE_GOAL_RE.search(goal))
+
+
+@dataclass(frozen=True)
+class DiskSettings:
+    """Limits on the data directory's disk use, in bytes; 0 turns a limit off."""
+    quota: int = 0
+    reserve: int = 0
+
+    @classmethod
+    def from_env(cls) -> "DiskSettings":
+        values = {}
+        for name, variable in (("quota", "SWISH_MCP_DISK_QUOTA"), ("reserve", "SWISH_MCP_DISK_RESERVE")):
+            try:
+                values[name] = parse_size(variable, os.environ.get(variable, "").strip() or 0)
+            except ValueError as e:
+                logger.error(f"Ignoring {variable}: {e}")
+        return cls(**values)
+
+    def describe(self) -> str:
+        parts = []
+        if self.quota:
+            parts.append(f"quota {format_bytes(self.quota)}")
+        if self.reserve:
+            parts.append(f"reserve {format_bytes(self.reserve)} free")
+        return ", ".join(parts) or "no limits"
+
+
+class DiskQuotaExceeded(Exception):
+    """Raised when a write would take the data directory past its quota or the disk below its reserve."""
+
+    def __init__(self, message: str, details: dict[str, Any]):
+        super().__init__(message)
+        # "quota" and "used", or "reserve" and "free", in bytes
+        self.details = details
+
+
+@dataclass
+class AreaUsage:
+    """Size of one directory the server writes to."""
+    path: Path
+    bytes: int = 0
+    files: int = 0
+    # (size, path relative to the directory) of its largest files, largest first
+    largest: list[tuple[int, str]] = field(default_factory=list)
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "path": str(self.path), "bytes": self.bytes, "files": self.files,
+            "largest": [{"file": name, "bytes": size} for size, name in self.largest],
+        }
+
+
+def measure_dir(path: Path) -> AreaUsage:
+    """Total size and file count of a directory, not following symbolic links."""
+    usage = AreaUsage(path)
+    largest: list[tuple[int, str]] = []
+    for root, _dirs, files in os.walk(path, onerror=lambda error: None):
+        for name in files:
+            file_path = os.path.join(root, name)
+            try:
+                size = os.lstat(file_path).st_size
+            except OSError:
+                continue
+            usage.bytes += size
+            usage.files += 1
+            entry = (size, os.path.relpath(file_path, path))
+            if len(largest) < LARGEST_FILES:
+                heapq.heappush(largest, entry)
+            else:
+                heapq.heappushpop(largest, entry)
+    usage.largest = sorted(largest, reverse=True)
+    return usage
+
+
+def free_space(path: Path) -> tuple[int, int]:
+    """(free, total) bytes of the disk holding path, or of its nearest existing parent."""
+    for candidate in (path, *path.parents):
+        if candidate.exists():
+            usage = shutil.disk_usage(candidate)
+            return usage.free, usage.total
+    return 0, 0
+
+
+@dataclass
+class DiskUsage:
+    """A measurement of the directories the server writes to, by area name."""
+    areas: dict[str, AreaUsage]
+    free: int
+    total: int
+    measured: float
+    # Bytes written since the measurement
+    written: int = 0
+
+    @property
+    def used(self) -> int:
+        return sum(area.bytes for area in self.areas.values()) + self.written
+
+    def to_json(self, settings: DiskSettings) -> dict[str, Any]:
+        return {
+            "used": self.used,
+            "areas": {name: area.to_json() for name, area in self.areas.items()},
+            "written_since": self.written,
+            "disk": {"free": max(self.free - self.written, 0), "total": self.total},
+            "quota": settings.quota or None,
+            "reserve": settings.reserve or None,
+            "measured": time.strftime("%Y-%m-%dT%H:%M:%S", time.localtime(self.measured)),
+        }
+
+    def describe(self, settings: DiskSettings) -> str:
+        lines = ["💽 Disk usage of the data directory"]
+        for name, area in self.areas.items():
+            lines.append(f"• {name}: {format_bytes(area.bytes)} in {area.files} file(s) ({area.path})")
+        if self.written:
+            lines.append(f"• written since: {format_bytes(self.written)}")
+        if settings.quota:
+            share = self.used / settings.quota
+            icon = "🛑" if share >= 1 else "⚠️" if share >= 0.9 else "📏"
+            lines.append(f"\n{icon} {format_bytes(self.used)} of the {format_bytes(settings.quota)} quota ({share:.0%})")
+        else:
+            lines.append(f"\n📏 {format_bytes(self.used)} in total; no quota (SWISH_MCP_DISK_QUOTA)")
+        free = max(self.free - self.written, 0)
+        reserve = f", {format_bytes(settings.reserve)} kept in reserve" if settings.reserve else ""
+        lines.append(f"🗄️ Disk: {format_bytes(free)} free of {format_bytes(self.total)}{reserve}")
+        largest = sorted(
+            ((size, f"{name}/{file}") for name, area in self.areas.items() for size, file in area.largest),
+            reverse=True
+        )[:LARGEST_FILES]
+        if largest:
+            lines.append("\n📦 Largest files:")
+            lines.extend(f"  {format_bytes(size)}  {file}" for size, file in largest)
+        lines.append(f"\n🕒 Measured {time.strftime('%H:%M:%S', time.localtime(self.measured))}")
+        return "\n".join(lines)
+
+
+class DiskMonitor:
+    """Measurements of data directories' areas, and the checks of their limits before writes."""
+
+    def __init__(self, settings: DiskSettings, clock: Callable[[], float] = time.monotonic):
+        self.settings = settings
+        self.clock = clock
+        self._measured: dict[Path, tuple[float, DiskUsage]] = {}
+
+    def usage(self, areas: dict[str, Path], refresh: bool = False) -> DiskUsage:
+        """Usage of areas (the first is the data directory), measured again if older than MEASURE_INTERVAL."""
+        key = next(iter(areas.values()))
+        cached = self._measured.get(key)
+        now = self.clock()
+        if cached is not None and not refresh and now - cached[0] < MEASURE_INTERVAL:
+            return cached[1]
+        free, total = free_space(key)
+        usage = DiskUsage({name: measure_dir(path) for name, path in areas.items()}, free, total, time.time())
+        self._measured[key] = (now, usage)
+        return usage
+
+    def charge(self, data_dir: Path, size: int) -> None:
+        """Count size bytes written to data_dir's areas since they were measured."""
+        cached = self._measured.get(data_dir)
+        if cached is not None and size > 0:
+            cached[1].written += size
+
+    def check(self, areas: dict[str, Path], what: str, adding: int = 0) -> None:
+        """Raise DiskQuotaExceeded if what, writing adding bytes, would pass a limit."""
+        if not self.settings.quota and not self.settings.reserve:
+            return
+        usage = self.usage(areas)
+        if self.settings.quota and usage.used + adding > self.settings.quota:
+            raise DiskQuotaExceeded(
+                f"{what} refused: the data directory, snapshots and bundles hold {format_bytes(usage.used)} "
+                f"of the {format_bytes(self.settings.quota)} disk quota (SWISH_MCP_DISK_QUOTA). "
+                f"Delete files, snapshots or bundles to make room; disk_usage() shows the largest",
+                {"quota": self.settings.quota, "used": usage.used}
+            )
+        free = usage.free - usage.written
+        if self.settings.reserve and free - adding < self.settings.reserve:
+            raise DiskQuotaExceeded(
+                f"{what} refused: only {format_bytes(max(free, 0))} is free on the disk, and "
+                f"{format_bytes(self.settings.reserve)} is kept in reserve (SWISH_MCP_DISK_RESERVE)",
+                {"reserve": self.settings.reserve, "free": max(free, 0)}
+            )
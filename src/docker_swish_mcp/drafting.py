@@ -0,0 +1,141 @@
+"""
+Drafting Rules from a Description for Docker SWISH MCP
+
+draft_rules("a grandparent is a parent of a parent") writes Prolog
+without a model of its own: it sends the description, with the schema
+of the client's module (each predicate, its clause count and a few
+example clauses, from mcp_kb_schema/3), to the client's model through
+an MCP sampling request, and asks for a number of alternative drafts.
+
+Each draft comes back as a fenced prolog block. Before any is returned
+it is screened by the sandbox and loaded into a scratch module the way
+mcp_safe_consult/5 checks a file (mcp_draft_check/3); drafts with
+directives, sandbox violations or load errors are dropped, with the
+reason. Surviving drafts are only shown, never consulted: use
+create_prolog_file or clause_insert to keep one.
+"""
+
+import re
+from typing import Any
+
+from mcp.types import (
+    ClientCapabilities,
+    SamplingCapability,
+    SamplingMessage,
+    TextContent,
+)
+
+from .quarantine import ConsultMessage, scratch_module
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+DEFAULT_CANDIDATES = 3
+MAX_CANDIDATES = 10
+# Example clauses of each predicate shown to the model
+SCHEMA_EXAMPLES = 2
+# Predicates described; the schema of a large knowledge base is cut off here
+MAX_SCHEMA_PREDICATES = 200
+DRAFT_MAX_TOKENS = 2000
+
+FENCE_RE = re.compile(r"```[ \t]*(?:prolog|pl)?[ \t]*\n(.*?)```", re.S | re.I)
+# A directive at the start of a line, outside comments
+DIRECTIVE_RE = re.compile(r"^\s*:-", re.M)
+
+SYSTEM_PROMPT = (
+    "You write SWI-Prolog clauses for an existing knowledge base. Use the "
+    "predicates it already has where they fit and define only what the "
+    "description asks for. Answer with each alternative in its own "
+    "```prolog fenced block, and nothing but clauses in the blocks: no "
+    "directives, no queries, no explanations."
+)
+
+
+def schema_call(module: str, examples: int = SCHEMA_EXAMPLES) -> tuple[str, list[str]]:
+    return "mcp_kb_schema", [prolog_atom(module), str(int(examples))]
+
+
+def format_schema(rows: list[dict[str, Any]], limit: int = MAX_SCHEMA_PREDICATES) -> str:
+    """The schema as Prolog comments and example clauses, for the prompt."""
+    if not rows:
+        return "% The knowledge base is empty."
+    lines = []
+    for row in sorted(rows, key=lambda row: row["predicate"])[:limit]:
+        kind = "dynamic, " if row["dynamic"] else ""
+        lines.append(f"% {row['predicate']} ({kind}{row['clauses']} clause(s))")
+        lines.extend(row["examples"])
+    if len(rows) > limit:
+        lines.append(f"% ... and {len(rows) - limit} more predicates")
+    return "\n".join(lines)
+
+
+def draft_prompt(description: str, schema: str, candidates: int) -> str:
+    return (
+        f"The knowledge base currently has these predicates:\n\n{schema}\n\n"
+        f"Write {candidates} alternative draft(s) of Prolog clauses for:\n\n{description.strip()}"
+    )
+
+
+async def sample(session: Any, prompt: str, max_tokens: int = DRAFT_MAX_TOKENS) -> str:
+    """The client's model's answer to prompt; raises RuntimeError if the client does not offer sampling."""
+    if session is None or not session.check_client_capability(ClientCapabilities(sampling=SamplingCapability())):
+        raise RuntimeError("This client does not support MCP sampling, so there is no model to draft rules with")
+    result = await session.create_message(
+        messages=[SamplingMessage(role="user", content=TextContent(type="text", text=prompt))],
+        max_tokens=max_tokens,
+        system_prompt=SYSTEM_PROMPT,
+    )
+    if getattr(result.content, "type", "") != "text":
+        raise RuntimeError("The client's model did not answer with text")
+    return result.content.text
+
+
+def parse_candidates(text: str) -> list[str]:
+    """The drafts in a model's answer: its fenced blocks, or all of it when it has none."""
+    blocks = FENCE_RE.findall(text) or [text]
+    return [block.strip() for block in blocks if block.strip()]
+
+
+def has_directive(candidate: str) -> bool:
+    return bool(DIRECTIVE_RE.search(candidate))
+
+
+def draft_check_call(candidates: list[str]) -> tuple[str, list[str]]:
+    texts = ", ".join(prolog_string(candidate) for candidate in candidates)
+    return "mcp_draft_check", [prolog_atom(scratch_module()), f"[{texts}]"]
+
+
+def parse_draft_check(rows: list[dict[str, Any]]) -> list[tuple[int, list[ConsultMessage]]]:
+    """Errors and diagnostics of each candidate, in order."""
+    results = []
+    diagnostics: list[ConsultMessage] = []
+    for row in rows:
+        if "severity" in row:
+            diagnostics.append(ConsultMessage(row["severity"], row["message"], row.get("line", 0)))
+        elif "candidate" in row:
+            results.append((row["errors"], diagnostics))
+            diagnostics = []
+    return results
+
+
+def format_drafts(accepted: list[tuple[str, list[ConsultMessage]]], rejected: list[tuple[str, str]]) -> str:
+    if not accepted:
+        lines = ["❌ None of the drafts loads cleanly:"]
+    else:
+        lines = [f"📝 {len(accepted)} draft(s) load cleanly (not consulted):"]
+        for number, (candidate, warnings) in enumerate(accepted, 1):
+            lines.append(f"\nDraft {number}:\n```prolog\n{candidate}\n```")
+            lines.extend(f"  {warning.describe()}" for warning in warnings)
+        if rejected:
+            lines.append("")
+    if rejected:
+        if accepted:
+            lines.append(f"🗑️ Dropped {len(rejected)} draft(s):")
+        lines.extend(f"  • {first_line(candidate)}: {reason}" for candidate, reason in rejected)
+    if accepted:
+        lines.append("\n💡 Keep a draft with create_prolog_file or clause_insert, then load_knowledge_base")
+    return "\n".join(lines)
+
+
+def first_line(candidate: str) -> str:
+    line = candidate.splitlines()[0] if candidate else ""
+    return line if len(line) <= 60 else line[:57] + "..."
@@ -0,0 +1,139 @@
+"""
+Per-Session Prolog Engine Threads for Docker SWISH MCP
+
+The persistent session answers one goal at a time. engine_query instead
+runs a goal on a Prolog thread belonging to the calling MCP session,
+inside the same swipl process (mcp_engine_submit/7 in mcp_helpers.pl):
+
+- goals of different sessions run at the same time, and a slow one does
+  not hold up execute_prolog_query; the session is only taken for the
+  moment it takes to hand a goal over or collect a result
+- goals of one session run one after another on its thread, in order,
+  so a session's goals never race each other
+- each thread has its own Prolog flags, global variables and stacks,
+  while clauses, loaded files and tables are shared with the rest of
+  the session, as with other threads of one process
+
+Threads start on first use and are listed with their status by
+engine_list; engine_stop interrupts the running goal (and with
+close=True ends the thread). They go when the session restarts. What a
+goal prints is captured with its result rather than sent to the
+session's output. Goals run on a thread are not in the audit log, so
+undo_last does not revert their changes.
+"""
+
+import hashlib
+import uuid
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+ENGINE_PREFIX = "mcp_engine_"
+# Seconds between polls of a job that is still running, growing to the maximum
+POLL_START = 0.05
+POLL_MAX = 0.5
+# Time allowed past a job's wall-clock limit (plus the wait for its turn) before waiting stops
+WAIT_GRACE = 5.0
+# A job that was collected already, or was lost when the session restarted, is unknown
+DONE_STATUSES = ("succeeded", "failed", "error", "unknown")
+
+
+def engine_alias(client_id: str) -> str:
+    """Alias of a client's engine thread."""
+    return f"{ENGINE_PREFIX}{hashlib.sha256(client_id.encode()).hexdigest()[:12]}"
+
+
+def new_job_id() -> str:
+    return f"job_{uuid.uuid4().hex[:12]}"
+
+
+def submit_call(alias: str, job: str, goal: str, module: str, limits: str, max_solutions: int) -> tuple[str, list[str]]:
+    return "mcp_engine_submit", [
+        prolog_atom(alias), prolog_atom(job), prolog_string(goal), prolog_atom(module), limits,
+        str(max(1, int(max_solutions))),
+    ]
+
+
+def poll_call(alias: str, job: str) -> tuple[str, list[str]]:
+    return "mcp_engine_poll", [prolog_atom(alias), prolog_atom(job)]
+
+
+def list_call() -> tuple[str, list[str]]:
+    return "mcp_engine_list", []
+
+
+def stop_call(alias: str, close: bool = False) -> tuple[str, list[str]]:
+    return "mcp_engine_stop", [prolog_atom(alias), "true" if close else "false"]
+
+
+@dataclass
+class EngineJob:
+    """The state of a goal handed to an engine thread."""
+    job: str
+    goal: str
+    # queued, running, succeeded, failed, error or unknown
+    status: str
+    seconds: float = 0.0
+    solutions: list[dict[str, Any]] = field(default_factory=list)
+    more: bool = False
+    output: str = ""
+    error: str | None = None
+
+    @classmethod
+    def from_row(cls, row: dict[str, Any]) -> "EngineJob":
+        return cls(
+            row["job"], row.get("goal", ""), row["status"], float(row.get("seconds", 0.0)),
+            row.get("solutions", []), bool(row.get("more", False)), row.get("output", ""), row.get("error"),
+        )
+
+    @property
+    def done(self) -> bool:
+        return self.status in DONE_STATUSES
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "job": self.job, "goal": self.goal, "status": self.status, "seconds": round(self.seconds, 3),
+            "solutions": [row["json"] for row in self.solutions], "more": self.more,
+            "output": self.output, "error": self.error,
+        }
+
+    def describe(self, engine: str) -> str:
+        if self.status == "queued":
+            return f"⏳ Job {self.job} is queued on {engine}: {self.goal}"
+        if self.status == "running":
+            return f"⚙️ Job {self.job} has been running on {engine} for {self.seconds:.1f}s: {self.goal}"
+        if self.status == "unknown":
+            return f"❌ No job {self.job} on {engine}: it was collected already, or the session restarted"
+        lines = []
+        if self.status == "succeeded":
+            more = "; more solutions not fetched (raise max_solutions)" if self.more else ""
+            lines.append(f"✅ Query: {self.goal}\n📋 Results:")
+            lines.extend(f"  • {row['bindings']}" for row in self.solutions)
+            lines.append(f"\n💡 {len(self.solutions)} solution(s) in {self.seconds:.2f}s on {engine}{more}")
+        elif self.status == "failed":
+            lines.append(f"❌ Query: {self.goal}\n📋 Result: false (no solutions found, {engine})")
+        else:
+            lines.append(f"❌ Query: {self.goal}\n📋 Error: {self.error}")
+        if self.output:
+            lines.append(f"📤 Output:\n{self.output.rstrip()}")
+        return "\n".join(lines)
+
+
+def format_engines(rows: list[dict[str, Any]], own: str) -> str:
+    if not rows:
+        return "🧵 No engine threads are running. engine_query starts this session's on first use."
+    lines = [f"🧵 Engine threads: {len(rows)}"]
+    for row in sorted(rows, key=lambda row: row["engine"]):
+        mine = " (this session)" if row["engine"] == own else ""
+        running = row.get("running")
+        doing = (
+            f", running {running['job']} for {float(running['seconds']):.1f}s: {running['goal']}"
+            if running else ", idle"
+        )
+        lines.append(
+            f"• {row['engine']}{mine}: {row['status']}, {float(row['cpu']):.2f}s CPU, "
+            f"{row['queued']} queued, {row['unread']} result(s) not collected{doing}"
+        )
+    return "\n".join(lines)
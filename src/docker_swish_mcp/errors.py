@@ -0,0 +1,265 @@
+"""
+Typed Errors in Tool Results for Docker SWISH MCP
+
+Tool results are text meant for people, so clients used to tell error
+classes apart by matching on the wording. Every error a tool reports
+now also has a ToolError, written as the last line of the result:
+
+    ❌ Query: foo(X).
+    📋 Error: Unknown procedure: foo/1
+    🏷️ {"kind": "existence_error", "message": "Unknown procedure: foo/1", "predicate": "foo/1"}
+
+and as the "error" object of JSON results. kind is one of ERROR_KINDS;
+the other keys depend on it:
+
+- syntax_error: "line" and "column" (from 1) where reading stopped, and
+  "file" when it was in a file
+- existence_error: "predicate" (Name/Arity) for an unknown procedure,
+  otherwise "type" and "culprit" (e.g. a missing source_sink)
+- type_error, domain_error: "expected" and "culprit"
+- permission_error: "action", "type" and "culprit"
+- evaluation_error, resource_error, representation_error: "culprit"
+- timeout and resource_limit: "limit" (wall, cpu, inferences or disk);
+  a disk limit also has "quota" and "used", or "reserve" and "free"
+- sandbox_violation: "violations", the predicates the policy denied
+- cancelled: "query_id" of the query cancel_query stopped
+
+Prolog errors are recognised both as error terms printed by the session
+(error(existence_error(procedure, foo/1), foo/1)) and as the messages
+pengines send ("Unknown procedure: foo/1").
+"""
+
+import asyncio
+import json
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .disk_usage import DiskQuotaExceeded
+from .sandbox import SandboxViolation
+from .swish_http import SwishRequestFailed, SwishUnavailable
+
+ERROR_KINDS = (
+    "syntax_error", "existence_error", "type_error", "domain_error", "instantiation_error",
+    "permission_error", "evaluation_error", "resource_error", "representation_error",
+    "timeout", "resource_limit", "sandbox_violation", "transport", "not_ready",
+    "invalid_argument", "prolog_error", "cancelled", "internal",
+)
+# Marks the typed error line of a text result
+ERROR_TAG = "🏷️"
+
+KIND_ICONS = {"timeout": "⏱️", "resource_limit": "⏱️", "transport": "⏳", "not_ready": "⏳", "cancelled": "🛑"}
+
+PROLOG_ERROR_RE = re.compile(r"^error\((.*)\)$", re.S)
+# Limit exceptions of mcp_limited/2
+LIMIT_ERRORS = {
+    "time_limit_exceeded": ("timeout", "wall"),
+    "session_timeout": ("timeout", "wall"),
+    "cpu_time_limit_exceeded": ("resource_limit", "cpu"),
+    "inference_limit_exceeded": ("resource_limit", "inferences"),
+}
+# Messages of pengine errors, which arrive already translated
+MESSAGE_PATTERNS = [
+    (re.compile(r"Unknown procedure:?\s+(?:'?user'?:)?(\S+/\d+)"), "existence_error", "predicate"),
+    (re.compile(r"Syntax error:?\s*(.*)", re.S), "syntax_error", None),
+    (re.compile(r"Arguments are not sufficiently instantiated"), "instantiation_error", None),
+    (re.compile(r"Type error:?\s*`?([^`']+)'? expected, found `?(.+?)'?(?:\s|$)"), "type_error", "expected"),
+    (re.compile(r"Domain error:?\s*`?([^`']+)'? expected, found `?(.+?)'?(?:\s|$)"), "domain_error", "expected"),
+    (re.compile(r"No permission to (.*)"), "permission_error", None),
+    (re.compile(r"Arithmetic: evaluation error:?\s*`?(\w+)"), "evaluation_error", "culprit"),
+    (re.compile(r"[Tt]ime limit exceeded"), "timeout", None),
+]
+
+
+@dataclass
+class ToolError:
+    kind: str
+    message: str
+    details: dict[str, Any] = field(default_factory=dict)
+
+    def to_json(self) -> dict[str, Any]:
+        return {"kind": self.kind, "message": self.message, **self.details}
+
+    def tag(self) -> str:
+        """The typed error line of a text result."""
+        return f"{ERROR_TAG} {json.dumps(self.to_json(), ensure_ascii=False)}"
+
+    def render(self, action: str = "") -> str:
+        """The message as a tool result: icon, optional action, message, then the tag."""
+        icon = KIND_ICONS.get(self.kind, "❌")
+        text = f"{action}: {self.message}" if action else self.message
+        return f"{icon} {text}\n{self.tag()}"
+
+
+def split_arguments(text: str) -> list[str]:
+    """Top-level arguments of a Prolog term's argument text."""
+    parts: list[str] = []
+    depth = 0
+    quote = ""
+    start = 0
+    i = 0
+    while i < len(text):
+        char = text[i]
+        if quote:
+            if char == "\\":
+                i += 1
+            elif char == quote:
+                quote = ""
+        elif char in "'\"`":
+            quote = char
+        elif char in "([{":
+            depth += 1
+        elif char in ")]}":
+            depth -= 1
+        elif char == "," and depth == 0:
+            parts.append(text[start:i].strip())
+            start = i + 1
+        i += 1
+    parts.append(text[start:].strip())
+    return [part for part in parts if part]
+
+
+def split_term(text: str) -> tuple[str, list[str]]:
+    """Name and argument texts of a compound (or atom) term text."""
+    text = text.strip()
+    match = re.match(r"^([a-z]\w*|'(?:[^'\\]|\\.)*')\((.*)\)$", text, re.S)
+    if match is None:
+        return text, []
+    return match.group(1), split_arguments(match.group(2))
+
+
+ESCAPES = {"n": "\n", "t": "\t", "\\": "\\", "'": "'", '"': '"'}
+
+
+def unquote(text: str) -> str:
+    """Text of a quoted atom or string as ~q writes it."""
+    if len(text) < 2 or text[0] != text[-1] or text[0] not in "'\"":
+        return text
+    body = text[1:-1]
+    return re.sub(r"\\(.)", lambda match: ESCAPES.get(match.group(1), match.group(0)), body)
+
+
+def predicate_text(text: str) -> str:
+    """Name/Arity, dropping a user: qualifier and quotes around plain names."""
+    text = text.strip()
+    if text.startswith("user:"):
+        text = text[len("user:"):]
+    name, _, arity = text.rpartition("/")
+    return f"{unquote(name)}/{arity}" if name else text
+
+
+def syntax_position(context: str, query: str) -> dict[str, Any]:
+    """line/column (and file) of a syntax error from its context term."""
+    name, args = split_term(context)
+    if name == "string" and len(args) == 2 and args[1].isdigit():
+        # The text read may be the query wrapped by the sandbox; report
+        # the position within the query itself
+        source = unquote(args[0])
+        offset = min(int(args[1]), len(source))
+        start = source.find(query) if query else -1
+        if start >= 0:
+            source = query
+            offset = min(max(offset - start, 0), len(query))
+        before = source[:offset]
+        return {"line": before.count("\n") + 1, "column": offset - (before.rfind("\n") + 1) + 1}
+    if name in ("file", "stream") and len(args) == 4 and args[1].isdigit() and args[2].isdigit():
+        position: dict[str, Any] = {"line": int(args[1]), "column": int(args[2]) + 1}
+        if name == "file":
+            position["file"] = unquote(args[0])
+        return position
+    return {}
+
+
+def from_prolog(error: str, query: str = "", message: str = "") -> ToolError:
+    """Classify an error the session reported, as printed with ~q."""
+    text = error.strip()
+    message = message or text
+    limit_name, _ = split_term(text)
+    if limit_name in LIMIT_ERRORS:
+        kind, limit = LIMIT_ERRORS[limit_name]
+        return ToolError(kind, message, {"limit": limit})
+    if text == "mcp_cancelled":
+        # Raised by mcp_interrupt/1 when cancel_query interrupts the goal
+        return ToolError("cancelled", message)
+
+    match = PROLOG_ERROR_RE.match(text)
+    if match is None:
+        return from_message(text)
+    args = split_arguments(match.group(1))
+    formal = args[0] if args else ""
+    context = args[1] if len(args) > 1 else ""
+    name, formal_args = split_term(formal)
+
+    if name == "syntax_error":
+        details = {"reason": unquote(formal_args[0])} if formal_args else {}
+        return ToolError("syntax_error", message, {**details, **syntax_position(context, query)})
+    if name == "existence_error" and len(formal_args) == 2:
+        if formal_args[0] == "procedure":
+            return ToolError("existence_error", message, {"predicate": predicate_text(formal_args[1])})
+        return ToolError("existence_error", message, {"type": formal_args[0], "culprit": unquote(formal_args[1])})
+    if name in ("type_error", "domain_error") and len(formal_args) == 2:
+        return ToolError(name, message, {"expected": formal_args[0], "culprit": formal_args[1]})
+    if name == "permission_error" and len(formal_args) == 3:
+        return ToolError(name, message, {
+            "action": formal_args[0], "type": formal_args[1], "culprit": formal_args[2],
+        })
+    if name in ("evaluation_error", "resource_error", "representation_error") and formal_args:
+        return ToolError(name, message, {"culprit": formal_args[0]})
+    if name == "instantiation_error":
+        return ToolError(name, message)
+    return ToolError("prolog_error", message)
+
+
+def from_message(message: str) -> ToolError:
+    """Classify a translated error message, such as pengines send."""
+    for pattern, kind, key in MESSAGE_PATTERNS:
+        match = pattern.search(message)
+        if match is None:
+            continue
+        details: dict[str, Any] = {}
+        if key == "predicate":
+            details["predicate"] = predicate_text(match.group(1))
+        elif key == "expected":
+            details = {"expected": match.group(1).strip(), "culprit": match.group(2).strip()}
+        elif key == "culprit":
+            details["culprit"] = match.group(1)
+        elif kind == "timeout":
+            details["limit"] = "wall"
+        return ToolError(kind, message.strip(), details)
+    return ToolError("prolog_error", message.strip())
+
+
+def from_exception(error: BaseException, fallback: str = "internal") -> ToolError:
+    """
+    Classify an exception a tool caught.
+
+    fallback is the kind of exceptions nothing else matches: "internal"
+    for unexpected failures, or e.g. "invalid_argument" where a tool
+    catches its own error class for bad input.
+    """
+    message = str(error)
+    if PROLOG_ERROR_RE.match(message.strip()) or split_term(message)[0] in LIMIT_ERRORS:
+        return from_prolog(message)
+    if isinstance(error, SandboxViolation):
+        return ToolError("sandbox_violation", message, {"violations": error.violations})
+    if isinstance(error, DiskQuotaExceeded):
+        return ToolError("resource_limit", message, {"limit": "disk", **error.details})
+    if isinstance(error, asyncio.TimeoutError):
+        return ToolError("timeout", message or "The operation timed out", {"limit": "wall"})
+    if isinstance(error, SwishUnavailable):
+        return ToolError("transport", message)
+    if isinstance(error, SwishRequestFailed):
+        return ToolError("transport", message, {"status": error.status})
+    if isinstance(error, (ConnectionError, OSError)) and not isinstance(error, FileNotFoundError):
+        return ToolError("transport", message)
+    if isinstance(error, (ValueError, KeyError, FileNotFoundError)):
+        return ToolError("invalid_argument", message)
+    return ToolError(fallback, message)
+
+
+def error_result(error: BaseException, action: str = "", fallback: str = "internal") -> str:
+    """A caught exception as a tool result; action prefixes the message."""
+    return from_exception(error, fallback).render(action)
+
+
+NOT_READY = ToolError("not_ready", "SWISH container is not ready. Please wait a moment and try again.").render()
@@ -0,0 +1,221 @@
+"""
+Query Execution Strategies for Docker SWISH MCP
+
+Isolated queries, and the health probes that decide whether a container
+is ready, can reach SWI-Prolog in two ways:
+
+- http: SWISH's pengine API on the container's published port
+- exec: a fresh `swipl -g Goal` process started with docker exec, which
+  works on hosts whose firewall blocks the published port but allow
+  the Docker API
+
+SWISH_MCP_EXECUTION picks one, or auto (the default): the pengine API
+while SWISH answers on its port, docker exec when it does not. The
+persistent session always runs through docker exec, so with auto a
+firewalled container is still usable for everything but the pengine
+tools and links to the web UI.
+
+Both strategies answer in the shape of a pengine answer event
+({"event": "success", "data": [{"X": "a"}], "more": false}, or failure
+or error), so callers format either the same way.
+"""
+
+import asyncio
+import json
+import logging
+from collections.abc import Callable
+from typing import Any
+
+from .config import QueryLimits
+from .container_exec import ContainerExecError, run_swipl_goal, run_swipl_with_program
+from .data_export import term_text
+from .pengines import PengineManager
+from .simple_session import (
+    HELPERS_PATH,
+    LIMIT_GRACE_SECONDS,
+    MARKER_RE,
+    clean_query_text,
+    prolog_string,
+)
+from .swish_http import SwishHttp, SwishUnavailable
+
+logger = logging.getLogger("docker-swish-mcp.execution")
+
+# Seconds a starting container gets to answer on its port before auto
+# settles for docker exec
+EXEC_FALLBACK_AFTER = 10
+
+# Query id of the solution lines of an exec'd query
+EXEC_ID = "exec"
+
+
+class ExecutionStrategy:
+    """One way of running isolated queries in a container."""
+
+    name = ""
+
+    async def probe(self, timeout: float = 3) -> bool:
+        """Whether queries can be run this way now."""
+        raise NotImplementedError
+
+    async def run_once(
+        self,
+        query: str,
+        src_text: str = "",
+        max_solutions: int = 100,
+        timeout: float = 30
+    ) -> dict[str, Any]:
+        """Run query once, with src_text loaded, and return its pengine-style answer."""
+        raise NotImplementedError
+
+
+class PengineStrategy(ExecutionStrategy):
+    """Throwaway pengines created over SWISH's HTTP API."""
+
+    name = "http"
+
+    def __init__(self, http: SwishHttp, pengines: Callable[[], PengineManager | None]):
+        self.http = http
+        self.pengines = pengines
+
+    async def probe(self, timeout: float = 3) -> bool:
+        return await self.http.probe(timeout=timeout)
+
+    async def run_once(
+        self,
+        query: str,
+        src_text: str = "",
+        max_solutions: int = 100,
+        timeout: float = 30
+    ) -> dict[str, Any]:
+        pengines = self.pengines()
+        if pengines is None:
+            raise SwishUnavailable(f"SWISH at {self.http.base_url} has no pengine manager yet")
+        return await pengines.run_once(query, src_text, max_solutions, timeout=timeout)
+
+
+class ExecStrategy(ExecutionStrategy):
+    """A fresh swipl process per query, started with docker exec."""
+
+    name = "exec"
+
+    def __init__(self, docker_client: Any, container_name: str):
+        self.docker_client = docker_client
+        self.container_name = container_name
+
+    async def probe(self, timeout: float = 3) -> bool:
+        try:
+            exit_code, _, _ = await run_swipl_goal(self.docker_client, self.container_name, "true", timeout=timeout)
+        except (ContainerExecError, asyncio.TimeoutError, OSError) as e:
+            logger.debug(f"exec probe of {self.container_name} failed: {e}")
+            return False
+        return exit_code == 0
+
+    async def run_once(
+        self,
+        query: str,
+        src_text: str = "",
+        max_solutions: int = 100,
+        timeout: float = 30
+    ) -> dict[str, Any]:
+        program = "\n".join([HELPERS_PATH.read_text(encoding="utf-8"), src_text, ""])
+        exit_code, stdout, stderr = await run_swipl_with_program(
+            self.docker_client,
+            self.container_name,
+            program,
+            exec_goal(query, max_solutions, QueryLimits(wall_seconds=timeout)),
+            timeout=timeout + LIMIT_GRACE_SECONDS
+        )
+        return parse_exec_answer(stdout, stderr, exit_code, max_solutions)
+
+
+def exec_goal(query: str, max_solutions: int, limits: QueryLimits) -> str:
+    """mcp_run/4 on query, asking for one solution more than wanted to learn whether there are more."""
+    text = f"limit({max(1, max_solutions) + 1}, ({clean_query_text(query)}))"
+    return f"mcp_run({EXEC_ID}, {prolog_string(text)}, {limits.to_prolog()}, json)"
+
+
+def parse_exec_answer(stdout: str, stderr: str, exit_code: int, max_solutions: int) -> dict[str, Any]:
+    """The pengine-style answer of an exec'd mcp_run/4."""
+    rows: list[dict[str, str]] = []
+    finished = False
+    for line in stdout.splitlines():
+        match = MARKER_RE.search(line)
+        if match is None or match.group(1) != EXEC_ID:
+            continue
+        kind, payload = match.group(2), match.group(3) or ""
+        if kind == "ERROR":
+            return {"event": "error", "data": payload.strip()}
+        if kind == "SOLUTION":
+            bindings = json.loads(payload)
+            rows.append({name: term_text(value) for name, value in bindings.items()})
+        elif kind == "END":
+            finished = True
+    if not finished:
+        return {"event": "error", "data": stderr.strip() or f"swipl exited with code {exit_code} before answering"}
+    if not rows:
+        return {"event": "failure"}
+    limit = max(1, max_solutions)
+    return {"event": "success", "data": rows[:limit], "more": len(rows) > limit}
+
+
+class FallbackStrategy(ExecutionStrategy):
+    """
+    The strategy SWISH_MCP_EXECUTION selects.
+
+    With mode auto, queries go over HTTP and fall back to docker exec when
+    SWISH cannot be reached; active is the strategy that last worked.
+    """
+
+    def __init__(self, mode: str, http: PengineStrategy | None, exec_: ExecStrategy):
+        self.mode = mode if http is not None else "exec"
+        self.http = http
+        self.exec = exec_
+        self.active = "exec" if self.mode == "exec" else "http"
+        self.fallbacks = 0
+
+    async def probe(self, timeout: float = 3, fallback: bool = True) -> bool:
+        """
+        Whether the container can run queries.
+
+        With mode auto and fallback set, a container that does not answer
+        over HTTP passes when docker exec works.
+        """
+        if self.mode != "exec" and self.http is not None:
+            if await self.http.probe(timeout=timeout):
+                self.active = "http"
+                return True
+            if self.mode == "http" or not fallback:
+                return False
+        if await self.exec.probe(timeout=timeout):
+            if self.active != "exec":
+                logger.warning(f"⚠️ SWISH does not answer on its port; running queries in "
+                               f"{self.exec.container_name} with docker exec")
+            self.active = "exec"
+            return True
+        return False
+
+    async def run_once(
+        self,
+        query: str,
+        src_text: str = "",
+        max_solutions: int = 100,
+        timeout: float = 30
+    ) -> dict[str, Any]:
+        if self.mode != "exec" and self.http is not None:
+            try:
+                answer = await self.http.run_once(query, src_text, max_solutions, timeout)
+            except SwishUnavailable as e:
+                if self.mode == "http":
+                    raise
+                logger.info(f"Pengine API unavailable ({e}); running the query with docker exec")
+                self.fallbacks += 1
+            else:
+                self.active = "http"
+                return answer
+        answer = await self.exec.run_once(query, src_text, max_solutions, timeout)
+        self.active = "exec"
+        return answer
+
+    def get_status(self) -> dict[str, Any]:
+        return {"mode": self.mode, "active": self.active, "fallbacks": self.fallbacks}
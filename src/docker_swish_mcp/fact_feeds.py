@@ -0,0 +1,174 @@
+"""
+Fact Feeds for Docker SWISH MCP
+
+fact_feed_subscribe("alert/2") hooks a dynamic predicate of the
+persistent session with prolog_listen/2 (see mcp_feed_watch/5 in
+mcp_helpers.pl). Whenever a fact is asserted to or retracted from it,
+by any query, tool or scheduled job, the session prints a FACT line,
+which SimplePrologSession hands to the feed:
+
+- the change is kept, numbered, among the feed's last MAX_FEED_EVENTS
+- subscribers of the feed's swish://feeds/{feed_id} resource get
+  resources/updated, and the client that created the feed a logging
+  notification carrying the change itself
+
+An optional pattern such as alert(high, _) keeps only the facts whose
+head unifies with it. fact_feed_events(feed_id, since) returns the
+changes after a sequence number, so a client that missed notifications
+catches up without gaps. Hooks live in the Prolog process; they are put
+back whenever the session (re)starts, and changes made meanwhile are not
+seen.
+"""
+
+import json
+import time
+import uuid
+from collections import deque
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+FEEDS_URI_PREFIX = "swish://feeds/"
+FEEDS_URI_TEMPLATE = FEEDS_URI_PREFIX + "{feed_id}"
+# Logger name of the logging notifications carrying changes
+FEEDS_LOGGER = "fact-feed"
+MAX_FEED_EVENTS = 500
+MAX_FEEDS = 64
+
+
+def feed_uri(feed_id: str) -> str:
+    return f"{FEEDS_URI_PREFIX}{feed_id}"
+
+
+def feed_indicator(predicate: str) -> str:
+    """Name/Arity of predicate; raises ValueError unless it is one."""
+    name, _, arity = predicate.strip().rpartition("/")
+    if not name or not arity.isdigit():
+        raise ValueError(f"Invalid predicate indicator '{predicate}'; use Name/Arity, e.g. alert/2")
+    return f"{name.strip()}/{int(arity)}"
+
+
+@dataclass
+class FactEvent:
+    seq: int
+    # "asserted" or "retracted"
+    action: str
+    fact: str
+    # mcp_term_json/2 terms of the fact's arguments
+    args: list[dict[str, Any]]
+    time: float = field(default_factory=time.time)
+
+    def to_json(self) -> dict[str, Any]:
+        return {"seq": self.seq, "action": self.action, "fact": self.fact, "args": self.args, "time": self.time}
+
+
+@dataclass
+class FactFeed:
+    feed_id: str
+    # Name/Arity as asked for, resolved in module
+    indicator: str
+    module: str
+    pattern: str = ""
+    # "Module:Name/Arity" of the predicate hooked, once it is
+    predicate: str = ""
+    client_id: str = ""
+    # Server session of the client that created the feed, for its notifications
+    session: Any = None
+    created: float = field(default_factory=time.time)
+    events: deque[FactEvent] = field(default_factory=lambda: deque(maxlen=MAX_FEED_EVENTS))
+    last_seq: int = 0
+
+    @property
+    def uri(self) -> str:
+        return feed_uri(self.feed_id)
+
+    def watch_call(self) -> tuple[str, list[str]]:
+        return "mcp_feed_watch", [
+            prolog_atom(self.feed_id), prolog_atom(self.module),
+            prolog_string(self.indicator), prolog_string(self.pattern)
+        ]
+
+    def unwatch_call(self) -> tuple[str, list[str]]:
+        return "mcp_feed_unwatch", [prolog_atom(self.feed_id)]
+
+    def since(self, seq: int) -> list[FactEvent]:
+        return [event for event in self.events if event.seq > seq]
+
+    def describe(self) -> str:
+        what = self.pattern or self.predicate or self.indicator
+        return f"{self.feed_id}: {what} ({self.last_seq} change(s), {self.uri})"
+
+
+class FactFeeds:
+    """The fact feeds of one session, by feed id."""
+
+    def __init__(self) -> None:
+        self.feeds: dict[str, FactFeed] = {}
+
+    def create(self, indicator: str, module: str, pattern: str = "", client_id: str = "", session: Any = None) -> FactFeed:
+        if len(self.feeds) >= MAX_FEEDS:
+            raise ValueError(f"There are already {MAX_FEEDS} fact feeds; remove one with fact_feed_unsubscribe")
+        feed = FactFeed(
+            feed_id=f"feed-{uuid.uuid4().hex[:8]}",
+            indicator=feed_indicator(indicator),
+            module=module,
+            pattern=pattern.strip().removesuffix(".").rstrip(),
+            client_id=client_id,
+            session=session,
+        )
+        self.feeds[feed.feed_id] = feed
+        return feed
+
+    def get(self, feed_id: str) -> FactFeed:
+        feed = self.feeds.get(feed_id)
+        if feed is None:
+            known = ", ".join(sorted(self.feeds)) or "none"
+            raise ValueError(f"Unknown fact feed '{feed_id}' (known: {known})")
+        return feed
+
+    def remove(self, feed_id: str) -> FactFeed:
+        return self.feeds.pop(self.get(feed_id).feed_id)
+
+    def record(self, payload: str) -> tuple[FactFeed, FactEvent] | None:
+        """Add the change a FACT line reports to its feed; None for feeds removed meanwhile or bad lines."""
+        try:
+            data = json.loads(payload)
+        except ValueError:
+            return None
+        feed = self.feeds.get(str(data.get("feed", "")))
+        if feed is None:
+            return None
+        feed.last_seq += 1
+        event = FactEvent(feed.last_seq, str(data.get("action", "")), str(data.get("fact", "")), data.get("args", []))
+        feed.events.append(event)
+        return feed, event
+
+    def watch_calls(self) -> list[tuple[str, list[str]]]:
+        return [feed.watch_call() for feed in self.feeds.values()]
+
+
+def format_feed_events(feed: FactFeed, events: list[FactEvent], since: int) -> str:
+    lines = [f"📡 Fact feed {feed.describe()}"]
+    oldest = feed.events[0].seq if feed.events else feed.last_seq + 1
+    if since + 1 < oldest and feed.last_seq >= oldest:
+        lines.append(f"⚠️ Changes {since + 1}-{oldest - 1} are no longer kept (the feed keeps {MAX_FEED_EVENTS})")
+    if not events:
+        lines.append(f"No changes after {since}")
+    for event in events:
+        sign = "+" if event.action == "asserted" else "-"
+        lines.append(f"  {event.seq}. {sign} {event.fact}")
+    lines.append(f"💡 Next: fact_feed_events(feed_id=\"{feed.feed_id}\", since={feed.last_seq})")
+    return "\n".join(lines)
+
+
+def feed_document(feed: FactFeed, events: list[FactEvent]) -> str:
+    """The swish://feeds/ resource: the feed and its kept changes, as JSON."""
+    return json.dumps({
+        "feed_id": feed.feed_id,
+        "predicate": feed.predicate or feed.indicator,
+        "pattern": feed.pattern,
+        "last_seq": feed.last_seq,
+        "events": [event.to_json() for event in events],
+    }, indent=2)
@@ -0,0 +1,530 @@
+"""
+Shared Fact Store for Docker SWISH MCP
+
+Dynamic predicates live in the Prolog process, so a restarted session or
+container starts without the facts queries asserted, and every workspace
+has facts of its own. With SWISH_MCP_FACT_STORE set, designated
+predicates are mirrored to a database instead:
+
+- sqlite:///path/to/facts.db (or a plain path ending in .db): a file,
+  shared by every server on this machine that names it
+- redis://host:6379/0: a Redis server (the redis package must be
+  installed), shared by every server that can reach it
+
+SWISH_MCP_STORED_PREDICATES lists the designated predicates ("seen/2,
+alert/3"); fact_store_attach adds more, and the designations are kept
+in the store too. A predicate is stored by Name/Arity, whichever module
+or workspace asserts to it.
+
+Facts are loaded lazily: the first query a session runs after it
+starts, or after another session changed the store, loads the stored
+facts of each designated predicate into the client's module, replacing
+the clauses it had (mcp_store_load/6). From then on every fact asserted
+or retracted, by any query or tool, is written to the store; changes
+reach it in batches, one transaction per predicate. A predicate with
+nothing stored yet is seeded with the facts the session has instead.
+
+Only facts are stored, not rules. Changes made while consulting a file,
+with abolish/1 or inside query_batch are not seen one by one: the batch
+copies the predicates it may have changed once it commits.
+"""
+
+import asyncio
+import json
+import logging
+import os
+import sqlite3
+import threading
+from collections.abc import Awaitable, Callable, Iterator
+from contextlib import contextmanager
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any, Protocol
+
+from .fact_feeds import feed_indicator
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+logger = logging.getLogger("docker-swish-mcp.facts")
+
+# Facts sent to the session per mcp_store_load/6 call
+LOAD_CHUNK = 500
+# Changes kept while the store cannot be written; older ones are dropped beyond this
+MAX_PENDING = 100_000
+
+SQLITE_SCHEMA = """
+CREATE TABLE IF NOT EXISTS stored_predicates (
+    predicate TEXT PRIMARY KEY,
+    version INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS stored_facts (
+    predicate TEXT NOT NULL,
+    fact TEXT NOT NULL,
+    copies INTEGER NOT NULL,
+    UNIQUE (predicate, fact)
+);
+"""
+
+# KEYS: facts hash, order sorted set, version, sequence; ARGV: action, fact, ...
+# where action "clear" empties the predicate first
+REDIS_APPLY = """
+local version = redis.call('INCR', KEYS[3])
+for i = 1, #ARGV, 2 do
+    local fact = ARGV[i + 1]
+    if ARGV[i] == 'clear' then
+        redis.call('DEL', KEYS[1], KEYS[2])
+    elseif ARGV[i] == 'asserted' then
+        if redis.call('HINCRBY', KEYS[1], fact, 1) == 1 then
+            redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[4]), fact)
+        end
+    elseif redis.call('HINCRBY', KEYS[1], fact, -1) <= 0 then
+        redis.call('HDEL', KEYS[1], fact)
+        redis.call('ZREM', KEYS[2], fact)
+    end
+end
+return version
+"""
+
+
+@dataclass(frozen=True)
+class FactStoreSettings:
+    # sqlite:///path, a .db path or redis://host:port/db; "" turns the store off
+    url: str = ""
+    # Name/Arity of the predicates stored from the start
+    predicates: tuple[str, ...] = ()
+    # Prefix of the Redis keys, so several knowledge bases can share a server
+    prefix: str = "swish-mcp"
+
+    @classmethod
+    def from_env(cls) -> "FactStoreSettings":
+        predicates = os.environ.get("SWISH_MCP_STORED_PREDICATES", "")
+        return cls(
+            url=os.environ.get("SWISH_MCP_FACT_STORE", "").strip(),
+            predicates=tuple(part.strip() for part in predicates.split(",") if part.strip()),
+            prefix=os.environ.get("SWISH_MCP_FACT_STORE_PREFIX", "").strip() or "swish-mcp",
+        )
+
+
+class FactBackend(Protocol):
+    """Where the stored facts are kept; each predicate has a version bumped on every write."""
+    description: str
+
+    def designated(self) -> dict[str, int]: ...
+    def designate(self, predicate: str) -> None: ...
+    def release(self, predicate: str, drop: bool) -> int: ...
+    def versions(self, predicates: list[str]) -> dict[str, int]: ...
+    def load(self, predicate: str) -> tuple[list[str], int]: ...
+    def apply(self, predicate: str, changes: list[tuple[str, str]]) -> int: ...
+    def replace(self, predicate: str, facts: list[str]) -> int: ...
+    def counts(self) -> dict[str, int]: ...
+    def close(self) -> None: ...
+
+
+class SqliteBackend:
+    def __init__(self, path: Path):
+        path.parent.mkdir(parents=True, exist_ok=True)
+        self.description = f"SQLite {path}"
+        # Used from the event loop and from worker threads, one at a time
+        self.lock = threading.Lock()
+        self.db = sqlite3.connect(path, timeout=10, isolation_level=None, check_same_thread=False)
+        self.db.execute("PRAGMA journal_mode=WAL")
+        self.db.executescript(SQLITE_SCHEMA)
+
+    @contextmanager
+    def _transaction(self) -> Iterator[sqlite3.Connection]:
+        """BEGIN IMMEDIATE ... COMMIT, so writers on other servers wait instead of interleaving."""
+        with self.lock:
+            self.db.execute("BEGIN IMMEDIATE")
+            try:
+                yield self.db
+            except BaseException:
+                self.db.execute("ROLLBACK")
+                raise
+            self.db.execute("COMMIT")
+
+    def designated(self) -> dict[str, int]:
+        with self.lock:
+            return dict(self.db.execute("SELECT predicate, version FROM stored_predicates"))
+
+    def designate(self, predicate: str) -> None:
+        with self.lock:
+            self.db.execute("INSERT OR IGNORE INTO stored_predicates (predicate) VALUES (?)", (predicate,))
+
+    def release(self, predicate: str, drop: bool) -> int:
+        with self._transaction() as db:
+            db.execute("DELETE FROM stored_predicates WHERE predicate = ?", (predicate,))
+            if not drop:
+                return 0
+            count = db.execute(
+                "SELECT COALESCE(SUM(copies), 0) FROM stored_facts WHERE predicate = ?", (predicate,)
+            ).fetchone()[0]
+            db.execute("DELETE FROM stored_facts WHERE predicate = ?", (predicate,))
+            return count
+
+    def versions(self, predicates: list[str]) -> dict[str, int]:
+        versions = self.designated()
+        return {predicate: versions.get(predicate, 0) for predicate in predicates}
+
+    def load(self, predicate: str) -> tuple[list[str], int]:
+        with self._transaction() as db:
+            rows = db.execute(
+                "SELECT fact, copies FROM stored_facts WHERE predicate = ? ORDER BY rowid", (predicate,)
+            ).fetchall()
+            version = _sqlite_version(db, predicate)
+        return [fact for fact, copies in rows for _ in range(copies)], version
+
+    def apply(self, predicate: str, changes: list[tuple[str, str]]) -> int:
+        with self._transaction() as db:
+            return _sqlite_write(db, predicate, changes)
+
+    def replace(self, predicate: str, facts: list[str]) -> int:
+        with self._transaction() as db:
+            db.execute("DELETE FROM stored_facts WHERE predicate = ?", (predicate,))
+            return _sqlite_write(db, predicate, [("asserted", fact) for fact in facts])
+
+    def counts(self) -> dict[str, int]:
+        with self.lock:
+            return dict(self.db.execute("SELECT predicate, SUM(copies) FROM stored_facts GROUP BY predicate"))
+
+    def close(self) -> None:
+        with self.lock:
+            self.db.close()
+
+
+def _sqlite_version(db: sqlite3.Connection, predicate: str) -> int:
+    row = db.execute("SELECT version FROM stored_predicates WHERE predicate = ?", (predicate,)).fetchone()
+    return row[0] if row else 0
+
+
+def _sqlite_write(db: sqlite3.Connection, predicate: str, changes: list[tuple[str, str]]) -> int:
+    """Apply changes and bump the predicate's version, inside the caller's transaction."""
+    for action, fact in changes:
+        if action == "asserted":
+            db.execute(
+                "INSERT INTO stored_facts (predicate, fact, copies) VALUES (?, ?, 1) "
+                "ON CONFLICT (predicate, fact) DO UPDATE SET copies = copies + 1",
+                (predicate, fact)
+            )
+        else:
+            db.execute(
+                "UPDATE stored_facts SET copies = copies - 1 WHERE predicate = ? AND fact = ?",
+                (predicate, fact)
+            )
+    db.execute("DELETE FROM stored_facts WHERE predicate = ? AND copies <= 0", (predicate,))
+    db.execute(
+        "INSERT INTO stored_predicates (predicate, version) VALUES (?, 1) "
+        "ON CONFLICT (predicate) DO UPDATE SET version = version + 1",
+        (predicate,)
+    )
+    return _sqlite_version(db, predicate)
+
+
+class RedisBackend:
+    def __init__(self, url: str, prefix: str):
+        try:
+            import redis
+        except ImportError:
+            raise ValueError("A redis:// fact store needs the redis package: pip install redis")
+        self.description = f"Redis {url.split('@')[-1]}"
+        self.client = redis.Redis.from_url(url, decode_responses=True)
+        self.prefix = prefix
+        self.apply_script = self.client.register_script(REDIS_APPLY)
+
+    def _keys(self, predicate: str) -> list[str]:
+        return [
+            f"{self.prefix}:facts:{predicate}", f"{self.prefix}:order:{predicate}",
+            f"{self.prefix}:version:{predicate}", f"{self.prefix}:sequence",
+        ]
+
+    def designated(self) -> dict[str, int]:
+        predicates = sorted(self.client.smembers(f"{self.prefix}:predicates"))
+        return self.versions(predicates)
+
+    def designate(self, predicate: str) -> None:
+        self.client.sadd(f"{self.prefix}:predicates", predicate)
+
+    def release(self, predicate: str, drop: bool) -> int:
+        count = sum(int(copies) for copies in self.client.hvals(self._keys(predicate)[0])) if drop else 0
+        self.client.srem(f"{self.prefix}:predicates", predicate)
+        if drop:
+            self.apply(predicate, [("clear", "")])
+        return count
+
+    def versions(self, predicates: list[str]) -> dict[str, int]:
+        if not predicates:
+            return {}
+        values = self.client.mget([self._keys(predicate)[2] for predicate in predicates])
+        return {predicate: int(value or 0) for predicate, value in zip(predicates, values)}
+
+    def load(self, predicate: str) -> tuple[list[str], int]:
+        facts, order, version, _ = self._keys(predicate)
+        pipe = self.client.pipeline(transaction=True)
+        pipe.zrange(order, 0, -1)
+        pipe.hgetall(facts)
+        pipe.get(version)
+        ordered, copies, current = pipe.execute()
+        return [fact for fact in ordered for _ in range(int(copies.get(fact, 0)))], int(current or 0)
+
+    def apply(self, predicate: str, changes: list[tuple[str, str]]) -> int:
+        args = [item for change in changes for item in change]
+        return int(self.apply_script(keys=self._keys(predicate), args=args))
+
+    def replace(self, predicate: str, facts: list[str]) -> int:
+        return self.apply(predicate, [("clear", ""), *(("asserted", fact) for fact in facts)])
+
+    def counts(self) -> dict[str, int]:
+        counts = {}
+        for predicate in self.designated():
+            counts[predicate] = sum(int(copies) for copies in self.client.hvals(self._keys(predicate)[0]))
+        return counts
+
+    def close(self) -> None:
+        self.client.close()
+
+
+def open_backend(settings: FactStoreSettings, base: Path) -> FactBackend:
+    """The backend a store URL names; relative SQLite paths resolve against base."""
+    url = settings.url
+    if url.startswith(("redis://", "rediss://", "unix://")):
+        return RedisBackend(url, settings.prefix)
+    if url.startswith("sqlite://"):
+        url = url.removeprefix("sqlite://")
+    elif not url.endswith((".db", ".sqlite", ".sqlite3")):
+        raise ValueError(f"Unknown fact store '{settings.url}'; use sqlite:///path/facts.db or redis://host:6379/0")
+    path = Path(url).expanduser()
+    return SqliteBackend(path if path.is_absolute() else base / path)
+
+
+def store_key(predicate: str) -> str:
+    """Name/Arity a predicate is stored as; raises ValueError unless it is one."""
+    key = feed_indicator(predicate)
+    if ":" in key:
+        raise ValueError(f"Stored predicates are shared by every module; give '{predicate}' without one")
+    return key
+
+
+@dataclass
+class SessionFacts:
+    """Which stored predicates a context's session has loaded, and at which store version."""
+    # (module, predicate): version loaded
+    loaded: dict[tuple[str, str], int] = field(default_factory=dict)
+    # (module, predicate): module holding the clauses, which client modules inheriting from user share
+    implementations: dict[tuple[str, str], str] = field(default_factory=dict)
+    # Session generation the versions are for; a restarted session has none loaded
+    generation: int = -1
+
+    def reset(self, generation: int) -> None:
+        if generation != self.generation:
+            self.loaded.clear()
+            self.implementations.clear()
+            self.generation = generation
+
+    def mark(self, module: str, predicate: str, version: int, implementation: str) -> None:
+        self.loaded[(module, predicate)] = version
+        self.implementations[(module, predicate)] = implementation
+
+    def advance(self, implementation: str, predicate: str, version: int) -> None:
+        """After this session wrote version: copies that were at the one before are up to date."""
+        for key, loaded in self.loaded.items():
+            if key[1] == predicate and self.implementations.get(key) == implementation and loaded == version - 1:
+                self.loaded[key] = version
+
+
+@dataclass
+class PendingChange:
+    facts: SessionFacts
+    # Module holding the changed clauses
+    module: str
+    predicate: str
+    action: str
+    fact: str
+
+
+def store_load_call(module: str, predicate: str, replace: bool, facts: list[str]) -> tuple[str, list[str]]:
+    texts = ", ".join(prolog_string(fact) for fact in facts)
+    return "mcp_store_load", [
+        prolog_atom(module), prolog_string(predicate), prolog_string(predicate),
+        "true" if replace else "false", f"[{texts}]"
+    ]
+
+
+def store_facts_call(module: str, predicate: str) -> tuple[str, list[str]]:
+    return "mcp_store_facts", [prolog_atom(module), prolog_string(predicate)]
+
+
+def store_unwatch_call(predicate: str) -> tuple[str, list[str]]:
+    return "mcp_store_unwatch", [prolog_string(predicate)]
+
+
+HelperRunner = Callable[[tuple[str, list[str]]], Awaitable[list[dict[str, Any]]]]
+
+
+class FactStore:
+    """
+    The designated predicates and the backend they are stored in.
+
+    Changes reported by sessions are queued by record() and written by
+    flush(), scheduled right after, so a query asserting many facts
+    costs one write per predicate rather than one per fact.
+    """
+
+    def __init__(self, backend: FactBackend, predicates: tuple[str, ...] = ()):
+        self.backend = backend
+        for predicate in predicates:
+            self.backend.designate(store_key(predicate))
+        self.predicates: set[str] = set(self.backend.designated())
+        self.pending: list[PendingChange] = []
+        self.flush_scheduled = False
+        self.written = 0
+        self.last_error = ""
+
+    @classmethod
+    def from_settings(cls, settings: FactStoreSettings, base: Path) -> "FactStore | None":
+        if not settings.url:
+            return None
+        try:
+            store = cls(open_backend(settings, base), settings.predicates)
+        except Exception as e:
+            logger.error(f"❌ Fact store unavailable, stored predicates are kept in memory only: {e}")
+            return None
+        logger.info(f"🗄️ Fact store: {store.backend.description} ({len(store.predicates)} predicate(s))")
+        return store
+
+    def attach(self, predicate: str) -> str:
+        key = store_key(predicate)
+        self.backend.designate(key)
+        self.predicates.add(key)
+        return key
+
+    def detach(self, predicate: str, drop: bool = False) -> tuple[str, int]:
+        key = store_key(predicate)
+        if key not in self.predicates:
+            raise ValueError(f"{key} is not stored (stored: {', '.join(sorted(self.predicates)) or 'none'})")
+        self.flush()
+        self.predicates.discard(key)
+        return key, self.backend.release(key, drop)
+
+    def record(self, facts: SessionFacts, payload: str) -> None:
+        """Queue the change a STORE line reports, to be written once the current output is read."""
+        try:
+            data = json.loads(payload)
+            change = PendingChange(
+                facts, str(data["module"]), str(data["predicate"]), str(data["action"]), str(data["fact"])
+            )
+        except (ValueError, KeyError):
+            return
+        if change.predicate not in self.predicates:
+            return
+        self.pending.append(change)
+        if len(self.pending) > MAX_PENDING:
+            del self.pending[:len(self.pending) - MAX_PENDING]
+            logger.error(f"❌ Fact store write backlog over {MAX_PENDING}; oldest changes dropped")
+        if not self.flush_scheduled:
+            self.flush_scheduled = True
+            asyncio.get_running_loop().call_soon(self.flush)
+
+    def flush(self) -> None:
+        """Write the queued changes, one transaction per session and predicate."""
+        self.flush_scheduled = False
+        pending, self.pending = self.pending, []
+        batches: dict[tuple[int, str, str], list[PendingChange]] = {}
+        for change in pending:
+            batches.setdefault((id(change.facts), change.module, change.predicate), []).append(change)
+        failed: list[PendingChange] = []
+        for changes in batches.values():
+            facts, module, predicate = changes[0].facts, changes[0].module, changes[0].predicate
+            try:
+                version = self.backend.apply(predicate, [(change.action, change.fact) for change in changes])
+            except Exception as e:
+                if self.last_error != str(e):
+                    logger.error(f"❌ Cannot write {predicate} to the fact store, will retry: {e}")
+                self.last_error = str(e)
+                failed.extend(changes)
+                continue
+            self.written += len(changes)
+            self.last_error = ""
+            # Up to date only if nobody else wrote in between; otherwise the next query reloads
+            facts.advance(module, predicate, version)
+        self.pending[:0] = failed
+
+    async def prepare(self, facts: SessionFacts, generation: int, module: str, run: HelperRunner) -> list[str]:
+        """Load the stored predicates the session is missing or has outdated copies of; returns those loaded."""
+        self.flush()
+        facts.reset(generation)
+        if not self.predicates:
+            return []
+        predicates = sorted(self.predicates)
+        versions = await asyncio.to_thread(self.backend.versions, predicates)
+        loaded = []
+        for predicate in predicates:
+            if facts.loaded.get((module, predicate)) == versions[predicate]:
+                continue
+            if versions[predicate] == 0:
+                # Nothing stored yet: what the session has becomes the stored copy
+                rows = await run(store_facts_call(module, predicate))
+                version = await asyncio.to_thread(self.backend.replace, predicate, [row["fact"] for row in rows])
+                result = await run(store_load_call(module, predicate, False, []))
+            else:
+                stored, version = await asyncio.to_thread(self.backend.load, predicate)
+                result = await load_chunks(run, module, predicate, stored)
+            facts.mark(module, predicate, version, implementation_module(result, module))
+            loaded.append(predicate)
+        return loaded
+
+    async def copy_from_session(self, facts: SessionFacts, module: str, run: HelperRunner) -> None:
+        """Make the store hold module's facts of the predicates it loaded, e.g. after a transaction."""
+        for loaded_module, predicate in list(facts.loaded):
+            if loaded_module != module or predicate not in self.predicates:
+                continue
+            rows = await run(store_facts_call(module, predicate))
+            replaced = await asyncio.to_thread(self.backend.replace, predicate, [row["fact"] for row in rows])
+            facts.advance(facts.implementations[(module, predicate)], predicate, replaced)
+            facts.loaded[(module, predicate)] = replaced
+
+    def status(self) -> dict[str, Any]:
+        counts = self.backend.counts()
+        versions = self.backend.versions(sorted(self.predicates))
+        return {
+            "backend": self.backend.description,
+            "predicates": [
+                {"predicate": predicate, "facts": counts.get(predicate, 0), "version": versions[predicate]}
+                for predicate in sorted(self.predicates)
+            ],
+            "written": self.written,
+            "pending": len(self.pending),
+            "error": self.last_error,
+        }
+
+    def close(self) -> None:
+        self.flush()
+        self.backend.close()
+
+
+async def load_chunks(run: HelperRunner, module: str, predicate: str, stored: list[str]) -> list[dict[str, Any]]:
+    """Replace the session's clauses of predicate with stored, LOAD_CHUNK facts per call."""
+    chunks = [stored[start:start + LOAD_CHUNK] for start in range(0, len(stored), LOAD_CHUNK)] or [[]]
+    rows: list[dict[str, Any]] = []
+    for number, chunk in enumerate(chunks):
+        rows = await run(store_load_call(module, predicate, number == 0, chunk))
+    return rows
+
+
+def implementation_module(rows: list[dict[str, Any]], module: str) -> str:
+    """Module of the "Module:Name/Arity" mcp_store_load/6 reports, module if it reports none."""
+    reported = next((row["predicate"] for row in rows if "predicate" in row), "")
+    return reported.partition(":")[0].strip("'") if ":" in reported else module
+
+
+def format_store_status(store: FactStore, facts: SessionFacts, module: str) -> str:
+    status = store.status()
+    lines = [f"🗄️ Fact store: {status['backend']}"]
+    if not status["predicates"]:
+        lines.append("No stored predicates; add one with fact_store_attach")
+    for entry in status["predicates"]:
+        loaded = facts.loaded.get((module, entry["predicate"]))
+        state = "loaded" if loaded == entry["version"] else "loads on the next query"
+        lines.append(f"  • {entry['predicate']}: {entry['facts']} fact(s), version {entry['version']} ({state})")
+    lines.append(f"✍️ Changes written: {status['written']}, waiting: {status['pending']}")
+    if status["error"]:
+        lines.append(f"⚠️ Last write failed: {status['error']}")
+    return "\n".join(lines)
@@ -0,0 +1,455 @@
+"""
+Geospatial Reasoning for Docker SWISH MCP
+
+With SWISH_MCP_GEO=on the server installs the space pack in the
+container when it starts (it compiles against libspatialindex and GEOS,
+so the image needs their development packages, see Custom Images) and
+enables the geo tools:
+
+- geo_load reads GeoJSON (a geometry, Feature or FeatureCollection) or
+  WKT, one geometry per line with an optional id in front
+  ("depot-1 POINT (4.89 52.37)"), into a named layer, and builds the
+  layer's R-tree index in the persistent session.
+- geo_query finds the features of a layer that intersect a geometry
+  (for a point: the areas containing it), that lie inside it
+  (contains), or that are nearest to it, and returns them as a GeoJSON
+  FeatureCollection.
+- geo_layers lists the layers, geo_clear drops one.
+
+Coordinates are WGS 84 longitude, latitude as in GeoJSON and WKT. The
+space pack writes points latitude first, point(Lat, Long), so
+shape_term() swaps them; rules in the knowledge base can query a layer
+directly with space_intersects/3 and friends, using geo_index(layer)
+as the index name. Features and their properties are kept here, so a
+result is the GeoJSON that was loaded; the indexes live in the Prolog
+process and are rebuilt from these features whenever the session
+(re)starts.
+"""
+
+import json
+import math
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+
+SPACE_PACK = "space"
+
+GEO_FORMATS = ("auto", "geojson", "wkt")
+GEO_OPERATIONS = ("intersects", "contains", "nearest")
+LAYER_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+# Features added to an index per mcp_geo_load/3 call
+LOAD_CHUNK = 500
+MAX_RESULTS = 1000
+EARTH_RADIUS_M = 6371008.8
+
+# Nesting depth of each geometry type's coordinates below a position
+GEOMETRY_DEPTH = {
+    "Point": 0,
+    "LineString": 1,
+    "MultiPoint": 1,
+    "Polygon": 2,
+    "MultiLineString": 2,
+    "MultiPolygon": 3,
+}
+WKT_TYPES = {name.upper(): name for name in GEOMETRY_DEPTH}
+WKT_TOKEN_RE = re.compile(r"\s*(?:([A-Za-z]+)|([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)|([(),]))")
+WKT_PREFIX_RE = re.compile(r"^(?:SRID=\d+;)?([A-Za-z]+)")
+
+
+def geo_index(layer: str) -> str:
+    """Name of a layer's index in the space pack."""
+    return f"geo_{layer}"
+
+
+def validate_layer(layer: str) -> str:
+    if not LAYER_RE.match(layer):
+        raise ValueError(f"Invalid layer name '{layer}'; use a lowercase identifier such as depots")
+    return layer
+
+
+def position(value: Any) -> list[float]:
+    """A [longitude, latitude] position; raises ValueError unless value is one."""
+    if not isinstance(value, (list, tuple)) or len(value) < 2:
+        raise ValueError(f"Invalid position {json.dumps(value)}; use [longitude, latitude]")
+    try:
+        lon, lat = float(value[0]), float(value[1])
+    except (TypeError, ValueError):
+        raise ValueError(f"Invalid position {json.dumps(value)}; coordinates must be numbers") from None
+    if not (-180 <= lon <= 180 and -90 <= lat <= 90):
+        raise ValueError(f"Position {json.dumps(value)} is outside longitude -180..180, latitude -90..90")
+    return [lon, lat]
+
+
+def coordinates(value: Any, depth: int) -> Any:
+    if depth == 0:
+        return position(value)
+    if not isinstance(value, list) or not value:
+        raise ValueError("Empty or malformed coordinates")
+    return [coordinates(item, depth - 1) for item in value]
+
+
+def closed_ring(ring: list[list[float]]) -> list[list[float]]:
+    if ring[0] != ring[-1]:
+        ring = [*ring, ring[0]]
+    if len(ring) < 4:
+        raise ValueError("A polygon ring needs at least three distinct positions")
+    return ring
+
+
+def check_geometry(geometry: Any) -> dict[str, Any]:
+    """geometry as a GeoJSON geometry with 2D positions and closed rings; raises ValueError."""
+    if not isinstance(geometry, dict):
+        raise ValueError("A geometry must be a JSON object")
+    kind = geometry.get("type")
+    if kind not in GEOMETRY_DEPTH:
+        raise ValueError(f"Unsupported geometry type '{kind}'. Use: {', '.join(GEOMETRY_DEPTH)}")
+    coords = coordinates(geometry.get("coordinates"), GEOMETRY_DEPTH[kind])
+    if kind == "LineString" and len(coords) < 2:
+        raise ValueError("A LineString needs at least two positions")
+    if kind == "Polygon":
+        coords = [closed_ring(ring) for ring in coords]
+    elif kind == "MultiPolygon":
+        coords = [[closed_ring(ring) for ring in polygon] for polygon in coords]
+    return {"type": kind, "coordinates": coords}
+
+
+@dataclass
+class GeoFeature:
+    id: str
+    geometry: dict[str, Any]
+    properties: dict[str, Any] = field(default_factory=dict)
+
+    def to_geojson(self, **extra: Any) -> dict[str, Any]:
+        return {
+            "type": "Feature",
+            "id": self.id,
+            "geometry": self.geometry,
+            "properties": {**self.properties, **extra},
+        }
+
+
+def parse_geojson(data: Any, id_property: str = "id") -> list[GeoFeature]:
+    """Features of a GeoJSON geometry, Feature or FeatureCollection; ids are "" where none is given."""
+    kind = data.get("type") if isinstance(data, dict) else None
+    if kind == "FeatureCollection":
+        features = data.get("features")
+        if not isinstance(features, list):
+            raise ValueError("A FeatureCollection needs a features list")
+    elif kind == "Feature":
+        features = [data]
+    elif kind in GEOMETRY_DEPTH:
+        features = [{"type": "Feature", "geometry": data}]
+    else:
+        raise ValueError(f"Not GeoJSON: expected a geometry, Feature or FeatureCollection, got type {kind!r}")
+    result = []
+    for number, feature in enumerate(features, 1):
+        if not isinstance(feature, dict) or feature.get("type") != "Feature":
+            raise ValueError(f"Feature {number} is not a GeoJSON Feature")
+        properties = feature.get("properties") or {}
+        if not isinstance(properties, dict):
+            raise ValueError(f"Feature {number}: properties must be an object")
+        try:
+            geometry = check_geometry(feature.get("geometry"))
+        except ValueError as e:
+            raise ValueError(f"Feature {number}: {e}") from None
+        feature_id = feature.get("id", properties.get(id_property, ""))
+        result.append(GeoFeature(str(feature_id) if feature_id is not None else "", geometry, properties))
+    return result
+
+
+def wkt_tokens(text: str) -> list[str]:
+    tokens = []
+    offset = 0
+    text = text.rstrip()
+    while offset < len(text):
+        match = WKT_TOKEN_RE.match(text, offset)
+        if match is None or match.end() == offset:
+            raise ValueError(f"Unexpected '{text[offset:offset + 10].strip()}' in WKT")
+        tokens.append(match.group(match.lastindex))
+        offset = match.end()
+    return tokens
+
+
+def wkt_geometry(text: str) -> dict[str, Any]:
+    """The GeoJSON geometry of a WKT text such as POLYGON ((0 0, 1 0, 1 1, 0 0)); raises ValueError."""
+    tokens = wkt_tokens(re.sub(r"^SRID=\d+;", "", text.strip()))
+    at = 0
+
+    def take(expected: str = "") -> str:
+        nonlocal at
+        if at >= len(tokens):
+            raise ValueError(f"WKT ends early: {text.strip()[:60]}")
+        token = tokens[at]
+        if expected and token != expected:
+            raise ValueError(f"Expected '{expected}' in WKT, found '{token}'")
+        at += 1
+        return token
+
+    def is_number(token: str) -> bool:
+        return token not in "()," and not token[0].isalpha()
+
+    def nested(depth: int) -> Any:
+        if depth == 0:
+            values = []
+            while at < len(tokens) and is_number(tokens[at]):
+                values.append(float(take()))
+            if not 2 <= len(values) <= 4:
+                raise ValueError("A WKT position needs two to four coordinates")
+            return values[:2]
+        take("(")
+        items = []
+        while True:
+            if depth == 1 and kind == "MultiPoint" and at < len(tokens) and tokens[at] == "(":
+                # MULTIPOINT ((1 2), (3 4)) as well as MULTIPOINT (1 2, 3 4)
+                take("(")
+                items.append(nested(0))
+                take(")")
+            else:
+                items.append(nested(depth - 1))
+            if take() == ")":
+                return items
+            if tokens[at - 1] != ",":
+                raise ValueError(f"Expected ',' or ')' in WKT, found '{tokens[at - 1]}'")
+
+    name = take().upper()
+    kind = WKT_TYPES.get(name)
+    if kind is None:
+        raise ValueError(f"Unsupported WKT geometry '{name}'. Use: {', '.join(WKT_TYPES)}")
+    while at < len(tokens) and tokens[at].upper() in ("Z", "M", "ZM"):
+        at += 1
+    if at < len(tokens) and tokens[at].upper() == "EMPTY":
+        raise ValueError(f"Empty {kind} geometries cannot be indexed")
+    coords = nested(GEOMETRY_DEPTH[kind] + (1 if kind == "Point" else 0))
+    if at != len(tokens):
+        raise ValueError(f"Unexpected '{tokens[at]}' after the WKT geometry")
+    return check_geometry({"type": kind, "coordinates": coords[0] if kind == "Point" else coords})
+
+
+def parse_wkt(text: str) -> list[GeoFeature]:
+    """One feature per non-blank line "[id] WKT"; lines starting with # are comments."""
+    features = []
+    for number, line in enumerate(text.splitlines(), 1):
+        line = line.strip()
+        if not line or line.startswith("#"):
+            continue
+        feature_id = ""
+        match = WKT_PREFIX_RE.match(line)
+        if match is None or match.group(1).upper() not in WKT_TYPES:
+            feature_id, _, line = line.partition(" ")
+        try:
+            features.append(GeoFeature(feature_id, wkt_geometry(line)))
+        except ValueError as e:
+            raise ValueError(f"Line {number}: {e}") from None
+    return features
+
+
+def parse_features(text: str, data_format: str = "auto", id_property: str = "id") -> list[GeoFeature]:
+    if data_format not in GEO_FORMATS:
+        raise ValueError(f"Unknown data_format '{data_format}'. Use: {', '.join(GEO_FORMATS)}")
+    if data_format == "auto":
+        data_format = "geojson" if text.lstrip().startswith("{") else "wkt"
+    if data_format == "wkt":
+        features = parse_wkt(text)
+    else:
+        try:
+            data = json.loads(text)
+        except ValueError as e:
+            raise ValueError(f"Invalid GeoJSON: {e}") from None
+        features = parse_geojson(data, id_property)
+    if not features:
+        raise ValueError("No geometries found")
+    return features
+
+
+def query_geometry(text: str) -> dict[str, Any]:
+    """A GeoJSON geometry or Feature, or WKT, as a geometry to query with."""
+    text = text.strip()
+    if not text.startswith("{"):
+        return wkt_geometry(text)
+    features = parse_features(text, "geojson")
+    if len(features) != 1:
+        raise ValueError("Query with a single geometry")
+    return features[0].geometry
+
+
+def number(value: float) -> str:
+    text = repr(float(value))
+    return text if "." in text or "e" not in text else text.replace("e", ".0e", 1)
+
+
+def point_term(coords: list[float]) -> str:
+    lon, lat = coords
+    return f"point({number(lat)}, {number(lon)})"
+
+
+def shape_term(geometry: dict[str, Any]) -> str:
+    """geometry as a space pack shape, e.g. point(52.37, 4.89) for the GeoJSON point [4.89, 52.37]."""
+    coords = geometry["coordinates"]
+
+    def points(items: list[list[float]]) -> str:
+        return "[" + ", ".join(point_term(item) for item in items) + "]"
+
+    def polygon(rings: list[list[list[float]]]) -> str:
+        return "polygon([" + ", ".join(points(ring) for ring in rings) + "])"
+
+    kind = geometry["type"]
+    if kind == "Point":
+        return point_term(coords)
+    if kind == "LineString":
+        return f"linestring({points(coords)})"
+    if kind == "MultiPoint":
+        return f"multipoint({points(coords)})"
+    if kind == "Polygon":
+        return polygon(coords)
+    if kind == "MultiLineString":
+        return "multilinestring([" + ", ".join(f"linestring({points(line)})" for line in coords) + "])"
+    return "multipolygon([" + ", ".join(polygon(rings) for rings in coords) + "])"
+
+
+def positions(geometry: dict[str, Any]) -> list[list[float]]:
+    depth = GEOMETRY_DEPTH[geometry["type"]]
+    items = [geometry["coordinates"]]
+    for _ in range(depth):
+        items = [inner for item in items for inner in item]
+    return items
+
+
+def bounding_box(geometries: list[dict[str, Any]]) -> list[float]:
+    """[west, south, east, north] of geometries, as a GeoJSON bbox."""
+    lons, lats = zip(*(p for geometry in geometries for p in positions(geometry)))
+    return [min(lons), min(lats), max(lons), max(lats)]
+
+
+def haversine(a: list[float], b: list[float]) -> float:
+    """Great-circle distance in metres between two [longitude, latitude] positions."""
+    lon1, lat1, lon2, lat2 = map(math.radians, (*a, *b))
+    h = math.sin((lat2 - lat1) / 2) ** 2 + math.cos(lat1) * math.cos(lat2) * math.sin((lon2 - lon1) / 2) ** 2
+    return 2 * EARTH_RADIUS_M * math.asin(math.sqrt(h))
+
+
+@dataclass
+class GeoLayer:
+    name: str
+    features: dict[str, GeoFeature] = field(default_factory=dict)
+
+    def add(self, features: list[GeoFeature]) -> None:
+        """Add features, numbering those without an id; raises ValueError for a taken id."""
+        taken = set(self.features)
+        for feature in features:
+            if feature.id in taken:
+                raise ValueError(f"Feature id '{feature.id}' is already in layer {self.name}; use replace=True")
+            if feature.id:
+                taken.add(feature.id)
+        serial = len(self.features)
+        for feature in features:
+            while not feature.id:
+                serial += 1
+                if f"{self.name}-{serial}" not in taken:
+                    feature.id = f"{self.name}-{serial}"
+            self.features[feature.id] = feature
+
+    def load_calls(self, features: list[GeoFeature] | None = None) -> list[tuple[str, list[str]]]:
+        """Helper calls adding features (all of the layer's by default) to its index, then building it."""
+        features = list(self.features.values()) if features is None else features
+        index = prolog_atom(geo_index(self.name))
+        calls = []
+        for start in range(0, len(features), LOAD_CHUNK):
+            pairs = ", ".join(
+                f"{prolog_atom(feature.id)}-{shape_term(feature.geometry)}"
+                for feature in features[start:start + LOAD_CHUNK]
+            )
+            calls.append(("mcp_geo_load", [index, f"[{pairs}]"]))
+        calls.append(("mcp_geo_index", [index]))
+        return calls
+
+    def query_call(self, operation: str, geometry: dict[str, Any], limit: int) -> tuple[str, list[str]]:
+        if operation not in GEO_OPERATIONS:
+            raise ValueError(f"Unknown operation '{operation}'. Use: {', '.join(GEO_OPERATIONS)}")
+        if not 1 <= limit <= MAX_RESULTS:
+            raise ValueError(f"limit must be between 1 and {MAX_RESULTS}")
+        return "mcp_geo_query", [prolog_atom(geo_index(self.name)), operation, shape_term(geometry), str(limit)]
+
+    def clear_call(self) -> tuple[str, list[str]]:
+        return "mcp_geo_clear", [prolog_atom(geo_index(self.name))]
+
+    def describe(self) -> str:
+        if not self.features:
+            return f"{self.name}: empty"
+        kinds: dict[str, int] = {}
+        for feature in self.features.values():
+            kinds[feature.geometry["type"]] = kinds.get(feature.geometry["type"], 0) + 1
+        counts = ", ".join(f"{n} {kind}" for kind, n in sorted(kinds.items()))
+        west, south, east, north = bounding_box([f.geometry for f in self.features.values()])
+        return (
+            f"{self.name} (index {geo_index(self.name)}): {len(self.features)} feature(s), {counts}; "
+            f"bbox [{west:g}, {south:g}, {east:g}, {north:g}]"
+        )
+
+
+class GeoLayers:
+    """The geo layers of one session, by name."""
+
+    def __init__(self) -> None:
+        self.layers: dict[str, GeoLayer] = {}
+
+    def get(self, name: str) -> GeoLayer:
+        layer = self.layers.get(name)
+        if layer is None:
+            known = ", ".join(sorted(self.layers)) or "none"
+            raise ValueError(f"Unknown geo layer '{name}' (known: {known}); load it with geo_load")
+        return layer
+
+    def remove(self, name: str) -> GeoLayer:
+        return self.layers.pop(self.get(name).name)
+
+    def load_calls(self) -> list[tuple[str, list[str]]]:
+        """Helper calls rebuilding every layer's index, for a session that (re)starts."""
+        return [call for layer in self.layers.values() for call in layer.load_calls()]
+
+
+def load_errors(rows: list[dict[str, Any]]) -> list[str]:
+    return [f"{row['id']}: {row['error']}" for row in rows if row.get("error")]
+
+
+def matched_features(
+    layer: GeoLayer,
+    rows: list[dict[str, Any]],
+    operation: str,
+    geometry: dict[str, Any]
+) -> list[dict[str, Any]]:
+    """The GeoJSON features of rows in order; nearest point features get distance_m from a point query."""
+    features = []
+    for rank, row in enumerate(rows, 1):
+        feature = layer.features.get(str(row.get("id", "")))
+        if feature is None:
+            continue
+        extra: dict[str, Any] = {}
+        if operation == "nearest":
+            extra["rank"] = rank
+            if geometry["type"] == "Point" and feature.geometry["type"] == "Point":
+                extra["distance_m"] = round(haversine(geometry["coordinates"], feature.geometry["coordinates"]), 1)
+        features.append(feature.to_geojson(**extra))
+    return features
+
+
+def feature_collection(features: list[dict[str, Any]]) -> dict[str, Any]:
+    collection: dict[str, Any] = {"type": "FeatureCollection", "features": features}
+    if features:
+        collection["bbox"] = bounding_box([feature["geometry"] for feature in features])
+    return collection
+
+
+def format_matches(layer: str, operation: str, features: list[dict[str, Any]]) -> str:
+    if not features:
+        return f"🔍 No features of {layer} match ({operation})"
+    lines = [f"🗺️ {len(features)} feature(s) of {layer} ({operation}):"]
+    for feature in features:
+        properties = dict(feature["properties"])
+        distance = properties.pop("distance_m", None)
+        properties.pop("rank", None)
+        away = f", {distance:g} m away" if distance is not None else ""
+        shown = f" {json.dumps(properties, ensure_ascii=False)}" if properties else ""
+        lines.append(f"  • {feature['id']} ({feature['geometry']['type']}{away}){shown}")
+    return "\n".join(lines)
@@ -0,0 +1,181 @@
+"""
+DCG Parsing for Docker SWISH MCP
+
+parse_with_grammar runs phrase/2 for the agent: it loads a grammar
+(inline, or a .pl file of the data directory) in a fresh swipl process,
+turns the input string into a list of codes, chars or white-space
+separated atoms, and asks for parses of the start nonterminal that
+consume all of it. The bindings of the start nonterminal's variables,
+e.g. the Tree of expr(Tree), are the parse result.
+
+When nothing parses, the failure is located: while the grammar loads,
+every rule gets a mcp_dcg_mark//1 call appended to its body, which
+records the furthest input position a rule completed at and which rule
+that was. The input after that point is where parsing got stuck. The
+position Start itself reached on a prefix of the input is reported too,
+for input with trailing text it cannot parse.
+
+The persistent session is not affected.
+"""
+
+import json
+import re
+from dataclasses import asdict, dataclass, field
+from typing import Any
+
+from .config import QueryLimits
+from .data_export import term_text
+from .simple_session import HELPERS_PATH, MARKER_RE, prolog_string
+
+INPUT_TYPES = ("codes", "chars", "tokens")
+MAX_PARSES = 100
+
+# Query id of the parse rows in the process output
+PARSE_ID = "parse"
+
+NONTERMINAL_RE = re.compile(r"^([a-z]\w*)//(\d+)$")
+
+# Appends mcp_dcg_mark//1 to the rules read from the program text itself, not
+# to those of the libraries it loads
+MARK_RULES = """
+:- multifile user:term_expansion/2.
+:- dynamic user:term_expansion/2.
+user:term_expansion((Head --> Body), Clause) :-
+    prolog_load_context(stream, Stream),
+    stream_property(Stream, alias(user_input)),
+    callable(Head),
+    Head \\= (_, _),
+    functor(Head, Name, Arity),
+    format(atom(Rule), "~w//~w", [Name, Arity]),
+    dcg_translate_rule((Head --> Body, mcp_dcg_mark(Rule)), Clause).
+"""
+
+
+@dataclass
+class ParseFailure:
+    """Where parsing stopped, as an offset into the input (a token index for tokens)."""
+    position: int
+    # Name//Arity of the rule that completed furthest, "" if none did
+    rule: str = ""
+    # Furthest position the start nonterminal reached on a prefix of the input
+    prefix: int = 0
+    line: int = 0
+    column: int = 0
+    near: str = ""
+
+    def to_json(self) -> dict[str, Any]:
+        return asdict(self)
+
+
+@dataclass
+class ParseOutcome:
+    parses: list[dict[str, Any]] = field(default_factory=list)
+    # More parses exist than were asked for
+    more: bool = False
+    failure: ParseFailure | None = None
+
+
+def start_goal(start: str) -> str:
+    """The start nonterminal as a goal text: name//N becomes name(A1, ..., AN)."""
+    text = start.strip().removesuffix(".").strip()
+    if not text:
+        raise ValueError("start must name a nonterminal, e.g. 'sentence' or 'expr(Tree)'")
+    match = NONTERMINAL_RE.match(text)
+    if match is not None:
+        arity = int(match[2])
+        return match[1] if arity == 0 else f"{match[1]}({', '.join(f'A{i}' for i in range(1, arity + 1))})"
+    return text
+
+
+def grammar_program(grammar: str) -> str:
+    """The helpers, the rule marking and the grammar."""
+    return "\n".join([HELPERS_PATH.read_text(encoding="utf-8"), MARK_RULES, grammar, ""])
+
+
+def parse_goal(start: str, text: str, input_type: str, max_parses: int, limits: QueryLimits) -> str:
+    if input_type not in INPUT_TYPES:
+        raise ValueError(f"Unknown input_type '{input_type}'. Use: {', '.join(INPUT_TYPES)}")
+    if not 1 <= max_parses <= MAX_PARSES:
+        raise ValueError(f"max_parses must be between 1 and {MAX_PARSES}")
+    # One more than asked for, to tell whether there are more
+    options = f"parse({input_type}, {int(max_parses) + 1})"
+    return (
+        f"mcp_parse({PARSE_ID}, {prolog_string(start_goal(start))}, {prolog_string(text)}, "
+        f"{limits.to_prolog()}, {options})"
+    )
+
+
+def input_tokens(text: str) -> list[str]:
+    return text.split()
+
+
+def locate(failure: dict[str, Any], text: str, input_type: str) -> ParseFailure:
+    """A failure row of mcp_parse/5 with line, column and the input around its position."""
+    position = int(failure.get("position", 0))
+    located = ParseFailure(position, str(failure.get("rule") or ""), int(failure.get("prefix", 0)))
+    if input_type == "tokens":
+        tokens = input_tokens(text)
+        located.near = " ".join(tokens[position:position + 5])
+        return located
+    before = text[:position]
+    located.line = before.count("\n") + 1
+    located.column = position - (before.rfind("\n") + 1) + 1
+    located.near = text[position:position + 30].split("\n", 1)[0]
+    return located
+
+
+def parse_grammar_output(stdout: str, text: str, input_type: str, max_parses: int) -> ParseOutcome:
+    """The parses or failure reported by mcp_parse/5; raises RuntimeError if it raised."""
+    outcome = ParseOutcome()
+    finished = False
+    for line in stdout.splitlines():
+        match = MARKER_RE.search(line)
+        if match is None or match.group(1) != PARSE_ID:
+            continue
+        kind, payload = match.group(2), match.group(3) or ""
+        if kind == "ERROR":
+            raise RuntimeError(payload)
+        if kind == "SOLUTION":
+            row = json.loads(payload)
+            if "failure" in row:
+                outcome.failure = locate(row["failure"], text, input_type)
+            else:
+                outcome.parses.append(row["bindings"])
+        elif kind == "END":
+            finished = True
+    if not finished:
+        raise RuntimeError("the parsing process exited before reporting")
+    if len(outcome.parses) > max_parses:
+        outcome.parses = outcome.parses[:max_parses]
+        outcome.more = True
+    return outcome
+
+
+def format_parse(start: str, text: str, input_type: str, outcome: ParseOutcome) -> str:
+    if outcome.failure is not None:
+        failure = outcome.failure
+        unit = "token" if input_type == "tokens" else "character"
+        where = f"{unit} {failure.position}" + (
+            f" (line {failure.line}, column {failure.column})" if input_type != "tokens" else ""
+        )
+        lines = [f"❌ No parse of the whole input as {start_goal(start)}", f"📍 Stuck at {where}"]
+        if failure.rule:
+            lines.append(f"   after {failure.rule} matched up to there")
+        lines.append(f"   near: {failure.near!r}" if failure.near else "   at the end of the input")
+        if failure.prefix and failure.prefix < failure.position:
+            lines.append(f"💡 {start_goal(start)} itself parses the first {failure.prefix} {unit}(s)")
+        elif failure.prefix:
+            lines.append(f"💡 {start_goal(start)} parses the first {failure.prefix} {unit}(s); the rest is left over")
+        return "\n".join(lines)
+
+    count = len(outcome.parses)
+    lines = [f"✅ Parsed as {start_goal(start)}: {count} parse(s)" + (", and more exist" if outcome.more else "")]
+    for index, bindings in enumerate(outcome.parses, start=1):
+        if not bindings:
+            lines.append(f"  {index}. (no variables to bind)")
+            continue
+        shown = ", ".join(f"{name} = {term_text(value)}" for name, value in bindings.items())
+        lines.append(f"  {index}. {shown}")
+    if outcome.more:
+        lines.append("💡 The input is ambiguous: raise max_parses to see more parses")
+    return "\n".join(lines)
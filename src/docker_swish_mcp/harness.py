@@ -0,0 +1,359 @@
+"""
+Integration Test Harness for Docker SWISH MCP
+
+docker-swish-mcp --test-harness scenario.yaml starts an ephemeral SWISH
+container (its own name, a free port and a temporary data directory),
+makes the tool calls a scenario lists, checks their results and removes
+the container again. It exits non-zero when a check fails, for CI;
+packages embedding the server call run_test_harness() in main.py.
+
+A scenario is a YAML (with PyYAML) or JSON file:
+
+    name: family
+    files:
+      family.pl: |
+        parent(tom, bob).
+    steps:
+      - tool: load_knowledge_base
+        args: {filename: family.pl}
+      - name: bob has a parent
+        tool: execute_prolog_query
+        args: {query: "parent(X, bob)", output_format: json}
+        expect:
+          json: {solutions: [{X: {type: atom, value: tom}}]}
+
+files are written to the data directory before the first step. A step
+passes when every check of its expect table holds:
+
+- contains / not_contains: text (or a list of texts) the result has or lacks
+- matches: a regular expression the result matches
+- equals: the whole result text
+- json: a value the JSON result contains: tables by their keys, lists
+  item by item from the start, anything else by equality
+- error: the typed error kind the call must fail with, or true for any
+
+Without error in expect, a step that fails with a typed error fails.
+With stop_on_failure: true the steps after the first failing one are
+skipped.
+"""
+
+import json
+import re
+import socket
+import time
+import uuid
+from collections.abc import Awaitable, Callable
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .errors import from_exception
+from .tool_schemas import structured_result
+
+SCENARIO_KEYS = ("name", "files", "steps", "stop_on_failure")
+STEP_KEYS = ("name", "tool", "args", "expect")
+EXPECT_KEYS = ("contains", "not_contains", "matches", "equals", "json", "error")
+# Characters of a result shown for a failing step
+SHOWN_OUTPUT = 400
+# Prefix of ephemeral container names; lifecycle.py treats swish-mcp-* as ours
+CONTAINER_PREFIX = "swish-mcp-test-"
+
+
+class ScenarioError(ValueError):
+    """Raised for scenario files that cannot be read or are malformed."""
+
+
+def _texts(value: Any, where: str) -> tuple[str, ...]:
+    values = value if isinstance(value, list) else [value]
+    if not all(isinstance(text, str) for text in values):
+        raise ScenarioError(f"{where} must be a text or a list of texts")
+    return tuple(values)
+
+
+@dataclass(frozen=True)
+class Expectation:
+    contains: tuple[str, ...] = ()
+    not_contains: tuple[str, ...] = ()
+    matches: str = ""
+    equals: str | None = None
+    json: Any = None
+    # Error kind, "*" for any error, "" for none
+    error: str = ""
+
+    @classmethod
+    def from_setting(cls, raw: Any, where: str) -> "Expectation":
+        if raw is None:
+            return cls()
+        if not isinstance(raw, dict):
+            raise ScenarioError(f"{where} must be a table of checks")
+        unknown = [key for key in raw if key not in EXPECT_KEYS]
+        if unknown:
+            raise ScenarioError(f"Unknown checks {unknown} in {where}. Use: {', '.join(EXPECT_KEYS)}")
+        matches = raw.get("matches", "")
+        try:
+            re.compile(matches)
+        except (re.error, TypeError) as e:
+            raise ScenarioError(f"{where}.matches is not a regular expression: {e}") from e
+        error = raw.get("error", "")
+        if error is True:
+            error = "*"
+        elif error is False or error is None:
+            error = ""
+        elif not isinstance(error, str):
+            raise ScenarioError(f"{where}.error must be an error kind or true")
+        equals = raw.get("equals")
+        if equals is not None and not isinstance(equals, str):
+            raise ScenarioError(f"{where}.equals must be a text")
+        return cls(
+            contains=_texts(raw.get("contains", []), f"{where}.contains"),
+            not_contains=_texts(raw.get("not_contains", []), f"{where}.not_contains"),
+            matches=matches,
+            equals=equals,
+            json=raw.get("json"),
+            error=error,
+        )
+
+
+@dataclass(frozen=True)
+class Step:
+    name: str
+    tool: str
+    args: dict[str, Any] = field(default_factory=dict)
+    expect: Expectation = field(default_factory=Expectation)
+
+
+@dataclass(frozen=True)
+class Scenario:
+    name: str
+    steps: tuple[Step, ...]
+    # Data directory path -> content, written before the first step
+    files: dict[str, str] = field(default_factory=dict)
+    stop_on_failure: bool = False
+
+    @classmethod
+    def from_setting(cls, raw: Any, default_name: str = "scenario") -> "Scenario":
+        if not isinstance(raw, dict):
+            raise ScenarioError("A scenario must be a table with a list of steps")
+        unknown = [key for key in raw if key not in SCENARIO_KEYS]
+        if unknown:
+            raise ScenarioError(f"Unknown scenario keys {unknown}. Use: {', '.join(SCENARIO_KEYS)}")
+        files = raw.get("files") or {}
+        if not isinstance(files, dict) or not all(isinstance(v, str) for v in files.values()):
+            raise ScenarioError("files must be a table of file names and their contents")
+        for name in files:
+            path = Path(name)
+            if path.is_absolute() or ".." in path.parts:
+                raise ScenarioError(f"File '{name}' must be a path inside the data directory")
+        steps = raw.get("steps")
+        if not isinstance(steps, list) or not steps:
+            raise ScenarioError("steps must be a non-empty list")
+        return cls(
+            name=str(raw.get("name") or default_name),
+            steps=tuple(parse_step(step, index) for index, step in enumerate(steps, start=1)),
+            files={str(name): content for name, content in files.items()},
+            stop_on_failure=bool(raw.get("stop_on_failure", False)),
+        )
+
+
+def parse_step(raw: Any, index: int) -> Step:
+    where = f"steps[{index}]"
+    if not isinstance(raw, dict):
+        raise ScenarioError(f"{where} must be a table with a tool")
+    unknown = [key for key in raw if key not in STEP_KEYS]
+    if unknown:
+        raise ScenarioError(f"Unknown keys {unknown} in {where}. Use: {', '.join(STEP_KEYS)}")
+    tool = raw.get("tool")
+    if not isinstance(tool, str) or not tool:
+        raise ScenarioError(f"{where} needs the name of a tool")
+    args = raw.get("args") or {}
+    if not isinstance(args, dict):
+        raise ScenarioError(f"{where}.args must be a table of arguments")
+    return Step(
+        name=str(raw.get("name") or f"{index}. {tool}"),
+        tool=tool,
+        args=args,
+        expect=Expectation.from_setting(raw.get("expect"), f"{where}.expect"),
+    )
+
+
+def load_scenario(path: Path) -> Scenario:
+    """Read a YAML or JSON scenario file; raises ScenarioError if it cannot be used."""
+    try:
+        text = path.read_text(encoding="utf-8")
+    except OSError as e:
+        raise ScenarioError(f"Cannot read scenario {path}: {e}") from e
+    if path.suffix.lower() == ".json":
+        try:
+            raw = json.loads(text)
+        except ValueError as e:
+            raise ScenarioError(f"Invalid JSON in {path}: {e}") from e
+    else:
+        try:
+            import yaml
+        except ImportError as e:
+            raise ScenarioError("YAML scenarios need PyYAML (pip install pyyaml); or use JSON") from e
+        try:
+            raw = yaml.safe_load(text)
+        except yaml.YAMLError as e:
+            raise ScenarioError(f"Invalid YAML in {path}: {e}") from e
+    try:
+        return Scenario.from_setting(raw, path.stem)
+    except ScenarioError as e:
+        raise ScenarioError(f"{path}: {e}") from e
+
+
+def json_contains(expected: Any, actual: Any, where: str = "json") -> list[str]:
+    """Where actual does not contain expected, as json checks compare them."""
+    if isinstance(expected, dict):
+        if not isinstance(actual, dict):
+            return [f"{where} is {json.dumps(actual)}, not a table"]
+        problems = []
+        for key, value in expected.items():
+            if key not in actual:
+                problems.append(f"{where} has no {key}")
+            else:
+                problems.extend(json_contains(value, actual[key], f"{where}.{key}"))
+        return problems
+    if isinstance(expected, list):
+        if not isinstance(actual, list):
+            return [f"{where} is {json.dumps(actual)}, not a list"]
+        if len(actual) < len(expected):
+            return [f"{where} has {len(actual)} item(s), expected at least {len(expected)}"]
+        return [
+            problem
+            for index, value in enumerate(expected)
+            for problem in json_contains(value, actual[index], f"{where}[{index}]")
+        ]
+    if expected != actual:
+        return [f"{where} is {json.dumps(actual)}, expected {json.dumps(expected)}"]
+    return []
+
+
+def check_result(expect: Expectation, envelope: dict[str, Any]) -> list[str]:
+    """The checks of expect that a tool's result envelope (see tool_schemas.py) fails."""
+    text, error = envelope["text"], envelope["error"]
+    problems = []
+    kind = error.get("kind", "") if error else ""
+    if expect.error and not error:
+        wanted = "an error" if expect.error == "*" else f"a {expect.error} error"
+        problems.append(f"expected {wanted}, the call succeeded")
+    elif expect.error not in ("", "*", kind) and error:
+        problems.append(f"expected a {expect.error} error, got {kind}: {error.get('message', '')}")
+    elif not expect.error and error:
+        problems.append(f"the call failed with {kind}: {error.get('message', '')}")
+    problems.extend(f"does not contain {needle!r}" for needle in expect.contains if needle not in text)
+    problems.extend(f"contains {needle!r}" for needle in expect.not_contains if needle in text)
+    if expect.matches and not re.search(expect.matches, text):
+        problems.append(f"does not match /{expect.matches}/")
+    if expect.equals is not None and text.strip() != expect.equals.strip():
+        problems.append("is not the expected text")
+    if expect.json is not None:
+        if envelope["json"] is None:
+            problems.append("is not a JSON document; pass output_format: json")
+        else:
+            problems.extend(json_contains(expect.json, envelope["json"]))
+    return problems
+
+
+@dataclass
+class StepResult:
+    name: str
+    tool: str
+    seconds: float
+    # Failed checks; empty when the step passed
+    problems: list[str] = field(default_factory=list)
+    output: str = ""
+    skipped: bool = False
+
+    @property
+    def passed(self) -> bool:
+        return not self.problems and not self.skipped
+
+
+@dataclass
+class ScenarioReport:
+    name: str
+    steps: list[StepResult] = field(default_factory=list)
+    # Why the scenario could not run at all, e.g. no container
+    error: str = ""
+    seconds: float = 0.0
+
+    @property
+    def passed(self) -> bool:
+        return not self.error and all(step.passed for step in self.steps)
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "name": self.name,
+            "passed": self.passed,
+            "error": self.error,
+            "seconds": round(self.seconds, 3),
+            "steps": [
+                {
+                    "name": step.name, "tool": step.tool, "passed": step.passed, "skipped": step.skipped,
+                    "seconds": round(step.seconds, 3), "problems": step.problems,
+                }
+                for step in self.steps
+            ],
+        }
+
+
+async def run_scenario(scenario: Scenario, call_tool: Callable[[str, dict[str, Any]], Awaitable[Any]]) -> ScenarioReport:
+    """Make the scenario's tool calls through call_tool and check their results."""
+    report = ScenarioReport(scenario.name)
+    started = time.monotonic()
+    failed = False
+    for step in scenario.steps:
+        if failed and scenario.stop_on_failure:
+            report.steps.append(StepResult(step.name, step.tool, 0.0, skipped=True))
+            continue
+        step_started = time.monotonic()
+        raised: Exception | None = None
+        try:
+            text = str(await call_tool(step.tool, step.args))
+        except Exception as e:
+            # Refused calls raise: invalid arguments with the rendered error, unknown tools without
+            text, raised = str(e), e
+        envelope = structured_result(step.tool, text)
+        if raised is not None and envelope["error"] is None:
+            envelope["error"] = from_exception(raised).to_json()
+        result = StepResult(step.name, step.tool, time.monotonic() - step_started, output=text)
+        result.problems = check_result(step.expect, envelope)
+        failed = failed or bool(result.problems)
+        report.steps.append(result)
+    report.seconds = time.monotonic() - started
+    return report
+
+
+def format_report(report: ScenarioReport) -> str:
+    lines = [f"{'✅' if report.passed else '❌'} {report.name} ({report.seconds:.1f}s)"]
+    if report.error:
+        lines.append(f"   {report.error}")
+    for step in report.steps:
+        if step.skipped:
+            lines.append(f"   ⏭️ {step.name} (skipped)")
+            continue
+        lines.append(f"   {'✅' if step.passed else '❌'} {step.name} ({step.seconds:.2f}s)")
+        lines.extend(f"      • {problem}" for problem in step.problems)
+        if step.problems and step.output:
+            shown = step.output if len(step.output) <= SHOWN_OUTPUT else step.output[:SHOWN_OUTPUT] + "…"
+            lines.extend(f"      | {line}" for line in shown.splitlines())
+    return "\n".join(lines)
+
+
+def ephemeral_name() -> str:
+    return f"{CONTAINER_PREFIX}{uuid.uuid4().hex[:8]}"
+
+
+def free_host_port() -> int:
+    """A TCP port nothing listens on now, for an ephemeral container."""
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as probe:
+        probe.bind(("127.0.0.1", 0))
+        return probe.getsockname()[1]
+
+
+def write_files(data_dir: Path, files: dict[str, str]) -> None:
+    for name, content in files.items():
+        path = data_dir / name
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_text(content, encoding="utf-8", newline="\n")
@@ -0,0 +1,158 @@
+"""
+Windows and WSL2 Support for Docker SWISH MCP
+
+Three things differ from Linux and macOS when the server runs on Windows
+or inside a WSL2 distribution:
+
+- Reaching the daemon. Docker Desktop for Windows listens on a named
+  pipe, npipe:////./pipe/docker_engine (docker-py needs pywin32 for
+  npipe:// URLs). Without DOCKER_HOST the runtime tries that pipe and
+  dockerDesktopLinuxEngine on Windows, and in WSL2 the distribution's
+  /var/run/docker.sock (Docker Desktop's WSL integration or a daemon in
+  the distribution) before Docker Desktop's tcp://localhost:2375.
+
+- Bind-mount paths. The daemon resolves the data directory's path on
+  its own side. SWISH_MCP_HOST_PATHS picks how paths are written:
+  posix (unchanged), windows (C:\\Users\\me\\data becomes
+  /c/Users/me/data, as docker-compose writes them for Docker Desktop)
+  or wsl (/mnt/c/Users/me/data becomes /c/Users/me/data, for a server
+  in WSL2 talking to Docker Desktop's Windows endpoint). auto, the
+  default, picks windows on Windows, wsl in WSL2 when DOCKER_HOST is a
+  tcp:// or npipe:// URL, and posix otherwise. Paths inside the WSL
+  distribution itself (not below /mnt/<drive>) are left alone; keep the
+  data directory on a Windows drive when the daemon is Docker Desktop.
+
+- Line endings. Files written with CRLF line endings (Windows editors,
+  git's autocrlf) load into SWI-Prolog with a carriage return in every
+  multi-line quoted text, and a backslash ending a line inside quotes
+  is a syntax error. Files the server writes always get LF endings, and
+  load_knowledge_base converts a file with CRLF endings before loading
+  it.
+"""
+
+import ctypes
+import logging
+import os
+import re
+from pathlib import Path, PurePosixPath, PureWindowsPath
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.platform")
+
+PATH_STYLES = ("auto", "posix", "windows", "wsl")
+WINDOWS_PIPES = ("npipe:////./pipe/docker_engine", "npipe:////./pipe/dockerDesktopLinuxEngine")
+WSL_ENDPOINTS = ("unix:///var/run/docker.sock", "tcp://localhost:2375")
+WSL_DRIVE_RE = re.compile(r"^/mnt/([a-zA-Z])(?:/|$)")
+# Windows process access right, error and exit codes of OpenProcess and GetExitCodeProcess
+PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+ERROR_ACCESS_DENIED = 5
+STILL_ACTIVE = 259
+
+
+def is_windows() -> bool:
+    return os.name == "nt"
+
+
+def is_wsl() -> bool:
+    """Whether this is a Linux distribution running under WSL."""
+    if os.environ.get("WSL_DISTRO_NAME"):
+        return True
+    try:
+        return "microsoft" in Path("/proc/sys/kernel/osrelease").read_text().lower()
+    except OSError:
+        return False
+
+
+def docker_endpoints() -> list[str]:
+    """Daemon URLs to try without DOCKER_HOST; empty leaves it to docker-py's default."""
+    if os.environ.get("DOCKER_HOST"):
+        return []
+    if is_windows():
+        return list(WINDOWS_PIPES)
+    if is_wsl():
+        return [url for url in WSL_ENDPOINTS if not url.startswith("unix://") or Path(url[len("unix://"):]).exists()]
+    return []
+
+
+def connect_docker() -> Any:
+    """A docker-py client for DOCKER_HOST, or the first of docker_endpoints() that answers."""
+    import docker
+    endpoints = docker_endpoints()
+    if not endpoints:
+        return docker.from_env()
+    errors = []
+    for url in endpoints:
+        try:
+            client = docker.DockerClient(base_url=url)
+            client.ping()
+        except Exception as e:
+            if url.startswith("npipe://") and "pywin32" in str(e).lower().replace("pypiwin32", "pywin32"):
+                raise RuntimeError("Docker's named pipe needs pywin32: pip install pywin32") from e
+            errors.append(f"{url}: {e}")
+            continue
+        logger.info(f"Using the Docker daemon at {url}")
+        return client
+    raise RuntimeError(f"No Docker daemon answered ({'; '.join(errors)}). Start Docker Desktop or set DOCKER_HOST")
+
+
+def path_style(setting: str = "auto") -> str:
+    """The style host paths are written in for the daemon, resolving auto."""
+    if setting != "auto":
+        return setting
+    if is_windows():
+        return "windows"
+    docker_host = os.environ.get("DOCKER_HOST", "")
+    if is_wsl() and docker_host.startswith(("tcp://", "npipe://")):
+        return "wsl"
+    return "posix"
+
+
+def daemon_path(path: Path, style: str = "auto") -> str:
+    """path as the daemon resolves a bind mount of it, e.g. /c/Users/me/data for C:\\Users\\me\\data."""
+    style = path_style(style)
+    if style == "windows":
+        windows = PureWindowsPath(path.resolve())
+        if not windows.drive or not windows.drive.endswith(":"):
+            # UNC paths (\\server\share) are passed on as they are
+            return str(windows)
+        return str(PurePosixPath("/", windows.drive[0].lower(), *windows.parts[1:]))
+    resolved = path.resolve().as_posix()
+    if style == "wsl":
+        match = WSL_DRIVE_RE.match(resolved)
+        if match:
+            return f"/{match[1].lower()}{resolved[len('/mnt/c'):]}"
+    return resolved
+
+
+def normalize_newlines(text: str) -> str:
+    """text with CRLF and lone CR line endings made LF."""
+    return text.replace("\r\n", "\n").replace("\r", "\n")
+
+
+def has_crlf(path: Path) -> bool:
+    try:
+        return b"\r\n" in path.read_bytes()
+    except OSError:
+        return False
+
+
+def process_alive(pid: int) -> bool:
+    """Whether process pid runs; on Windows without os.kill, whose signal 0 is CTRL_C_EVENT there."""
+    if is_windows():
+        kernel32 = ctypes.WinDLL("kernel32", use_last_error=True)
+        handle = kernel32.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, False, pid)
+        if not handle:
+            # Another user's process
+            return ctypes.get_last_error() == ERROR_ACCESS_DENIED
+        try:
+            code = ctypes.c_ulong()
+            return bool(kernel32.GetExitCodeProcess(handle, ctypes.byref(code))) and code.value == STILL_ACTIVE
+        finally:
+            kernel32.CloseHandle(handle)
+    try:
+        os.kill(pid, 0)
+    except ProcessLookupError:
+        return False
+    except PermissionError:
+        return True
+    return True
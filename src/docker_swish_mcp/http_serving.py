@@ -0,0 +1,313 @@
+"""
+HTTPS, Reverse Proxies and CORS for Docker SWISH MCP
+
+How the http/sse transports face the network:
+
+- TLS: SWISH_MCP_TLS_CERT and SWISH_MCP_TLS_KEY (and
+  SWISH_MCP_TLS_KEY_PASSWORD for an encrypted key) serve HTTPS with an
+  existing certificate, e.g. certbot's fullchain.pem and privkey.pem.
+  SWISH_MCP_TLS=auto instead creates a self-signed certificate for
+  SWISH_MCP_TLS_HOSTS (default: localhost, 127.0.0.1 and the host name)
+  in SWISH_MCP_TLS_DIR and renews it before it expires; clients must be
+  told to trust it. This needs the cryptography package.
+- Reverse proxies: requests arriving from an address in
+  SWISH_MCP_TRUSTED_PROXIES (addresses or networks, e.g.
+  "127.0.0.1,10.0.0.0/8") may set X-Forwarded-For and X-Forwarded-Proto.
+  The client address is the rightmost X-Forwarded-For entry that is not
+  itself a trusted proxy. From any other address the headers are ignored,
+  so clients cannot spoof their address.
+- CORS: SWISH_MCP_CORS_ORIGINS lists the browser origins allowed to call
+  the server ("https://app.example.com,https://*.example.org", or "*").
+  Requests carrying an Origin header that is not listed are refused,
+  which also stops DNS rebinding attacks on a server bound to localhost.
+  Without the setting no CORS headers are sent and Origin is not checked.
+- Dashboard: SWISH_MCP_DASHBOARD=on also serves the status page of
+  dashboard.py at /dashboard.
+"""
+
+import fnmatch
+import hashlib
+import ipaddress
+import json
+import logging
+import os
+import socket
+from collections.abc import MutableMapping
+from dataclasses import dataclass, field, replace
+from datetime import datetime, timedelta, timezone
+from pathlib import Path
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.http")
+
+IPNetwork = ipaddress.IPv4Network | ipaddress.IPv6Network
+
+# Validity of a self-signed certificate, and how long before expiry it is renewed
+AUTOCERT_DAYS = 365
+AUTOCERT_RENEW_DAYS = 30
+
+CORS_METHODS = "GET, POST, DELETE, OPTIONS"
+CORS_HEADERS = "authorization, content-type, accept, last-event-id, mcp-session-id, mcp-protocol-version"
+# Response headers a browser client must be able to read
+CORS_EXPOSE_HEADERS = "mcp-session-id, mcp-protocol-version"
+CORS_MAX_AGE = "600"
+
+
+def parse_networks(value: str) -> tuple[IPNetwork, ...]:
+    """Networks from "127.0.0.1,10.0.0.0/8,::1"; raises ValueError on a bad entry."""
+    networks = []
+    for part in value.split(","):
+        if part.strip():
+            try:
+                networks.append(ipaddress.ip_network(part.strip(), strict=False))
+            except ValueError:
+                raise ValueError(f"Invalid trusted proxy address {part.strip()!r}")
+    return tuple(networks)
+
+
+def default_tls_hosts() -> tuple[str, ...]:
+    return tuple(dict.fromkeys(["localhost", "127.0.0.1", "::1", socket.gethostname()]))
+
+
+@dataclass(frozen=True)
+class HttpSettings:
+    """TLS, proxy and CORS settings of the http/sse transports."""
+    tls_cert: Path | None = None
+    tls_key: Path | None = None
+    tls_key_password: str = ""
+    # Serve HTTPS with a generated self-signed certificate
+    autocert: bool = False
+    autocert_hosts: tuple[str, ...] = field(default_factory=default_tls_hosts)
+    autocert_dir: Path = field(default_factory=lambda: Path.home() / ".cache" / "docker-swish-mcp" / "tls")
+    trusted_proxies: tuple[IPNetwork, ...] = ()
+    cors_origins: tuple[str, ...] = ()
+    dashboard: bool = False
+    # Why the settings cannot be used; the server refuses to start with them
+    error: str = ""
+
+    @property
+    def tls(self) -> bool:
+        return self.autocert or self.tls_cert is not None
+
+    @classmethod
+    def from_env(cls) -> "HttpSettings":
+        cert = os.environ.get("SWISH_MCP_TLS_CERT", "").strip()
+        key = os.environ.get("SWISH_MCP_TLS_KEY", "").strip()
+        mode = os.environ.get("SWISH_MCP_TLS", "").strip().lower()
+        hosts = os.environ.get("SWISH_MCP_TLS_HOSTS", "").strip()
+        directory = os.environ.get("SWISH_MCP_TLS_DIR", "").strip()
+        origins = os.environ.get("SWISH_MCP_CORS_ORIGINS", "").strip()
+        dashboard = os.environ.get("SWISH_MCP_DASHBOARD", "").strip().lower()
+
+        errors = []
+        if mode not in ("", "auto", "off"):
+            errors.append(f"SWISH_MCP_TLS must be auto or off, not {mode!r}")
+        if bool(cert) != bool(key):
+            errors.append("SWISH_MCP_TLS_CERT and SWISH_MCP_TLS_KEY must be set together")
+        if cert and mode == "auto":
+            errors.append("Set either SWISH_MCP_TLS=auto or SWISH_MCP_TLS_CERT/SWISH_MCP_TLS_KEY, not both")
+        if dashboard not in ("", "on", "off"):
+            errors.append(f"SWISH_MCP_DASHBOARD must be on or off, not {dashboard!r}")
+        for path in (cert, key):
+            if path and not Path(path).expanduser().is_file():
+                errors.append(f"TLS file {path} does not exist")
+        try:
+            trusted = parse_networks(os.environ.get("SWISH_MCP_TRUSTED_PROXIES", ""))
+        except ValueError as e:
+            errors.append(str(e))
+            trusted = ()
+
+        settings = cls(
+            tls_cert=Path(cert).expanduser() if cert else None,
+            tls_key=Path(key).expanduser() if key else None,
+            tls_key_password=os.environ.get("SWISH_MCP_TLS_KEY_PASSWORD", ""),
+            autocert=mode == "auto",
+            trusted_proxies=trusted,
+            cors_origins=tuple(origin.strip().rstrip("/") for origin in origins.split(",") if origin.strip()),
+            dashboard=dashboard == "on",
+            error="; ".join(errors),
+        )
+        if hosts:
+            settings = replace(settings, autocert_hosts=tuple(h.strip() for h in hosts.split(",") if h.strip()))
+        if directory:
+            settings = replace(settings, autocert_dir=Path(directory).expanduser())
+        return settings
+
+
+def ensure_self_signed(directory: Path, hosts: tuple[str, ...]) -> tuple[Path, Path]:
+    """
+    A self-signed certificate and key for hosts, reused until close to expiry.
+
+    Raises:
+        ValueError: if the cryptography package is missing
+    """
+    try:
+        from cryptography import x509
+        from cryptography.hazmat.primitives import hashes, serialization
+        from cryptography.hazmat.primitives.asymmetric import ec
+        from cryptography.x509.oid import NameOID
+    except ImportError:
+        raise ValueError("SWISH_MCP_TLS=auto needs the cryptography package (pip install 'docker-swish-mcp[tls]')")
+
+    digest = hashlib.sha256(",".join(hosts).encode()).hexdigest()[:12]
+    cert_path, key_path = directory / f"self-signed-{digest}.pem", directory / f"self-signed-{digest}.key"
+    now = datetime.now(timezone.utc)
+    if cert_path.is_file() and key_path.is_file():
+        try:
+            existing = x509.load_pem_x509_certificate(cert_path.read_bytes())
+            if existing.not_valid_after_utc - now > timedelta(days=AUTOCERT_RENEW_DAYS):
+                return cert_path, key_path
+        except Exception as e:
+            logger.warning(f"Replacing unreadable certificate {cert_path}: {e}")
+
+    names: list[x509.GeneralName] = []
+    for host in hosts:
+        try:
+            names.append(x509.IPAddress(ipaddress.ip_address(host)))
+        except ValueError:
+            names.append(x509.DNSName(host))
+    key = ec.generate_private_key(ec.SECP256R1())
+    subject = x509.Name([x509.NameAttribute(NameOID.COMMON_NAME, hosts[0] if hosts else "localhost")])
+    certificate = (
+        x509.CertificateBuilder()
+        .subject_name(subject)
+        .issuer_name(subject)
+        .public_key(key.public_key())
+        .serial_number(x509.random_serial_number())
+        .not_valid_before(now - timedelta(minutes=5))
+        .not_valid_after(now + timedelta(days=AUTOCERT_DAYS))
+        .add_extension(x509.SubjectAlternativeName(names), critical=False)
+        .add_extension(x509.BasicConstraints(ca=False, path_length=None), critical=True)
+        .sign(key, hashes.SHA256())
+    )
+
+    directory.mkdir(parents=True, exist_ok=True)
+    key_path.touch(mode=0o600, exist_ok=True)
+    key_path.write_bytes(key.private_bytes(
+        serialization.Encoding.PEM, serialization.PrivateFormat.PKCS8, serialization.NoEncryption()
+    ))
+    cert_path.write_bytes(certificate.public_bytes(serialization.Encoding.PEM))
+    logger.info(f"🔐 Created a self-signed certificate for {', '.join(hosts)} in {directory}")
+    return cert_path, key_path
+
+
+def uvicorn_tls_options(settings: HttpSettings) -> dict[str, Any]:
+    """ssl_* options of uvicorn.Config; raises ValueError if TLS cannot be set up."""
+    if settings.autocert:
+        cert, key = ensure_self_signed(settings.autocert_dir, settings.autocert_hosts)
+        return {"ssl_certfile": str(cert), "ssl_keyfile": str(key)}
+    if settings.tls_cert is not None and settings.tls_key is not None:
+        options: dict[str, Any] = {"ssl_certfile": str(settings.tls_cert), "ssl_keyfile": str(settings.tls_key)}
+        if settings.tls_key_password:
+            options["ssl_keyfile_password"] = settings.tls_key_password
+        return options
+    return {}
+
+
+def _header(scope: MutableMapping[str, Any], name: bytes) -> str:
+    for key, value in scope.get("headers") or []:
+        if key.lower() == name:
+            return value.decode("latin-1")
+    return ""
+
+
+class ForwardedHeadersMiddleware:
+    """ASGI middleware taking the client address and scheme from a trusted proxy's headers."""
+
+    def __init__(self, app: Any, trusted: tuple[IPNetwork, ...]):
+        self.app = app
+        self.trusted = trusted
+
+    def is_trusted(self, address: str) -> bool:
+        try:
+            ip = ipaddress.ip_address(address)
+        except ValueError:
+            return False
+        return any(ip in network for network in self.trusted)
+
+    async def __call__(self, scope: MutableMapping[str, Any], receive: Any, send: Any) -> None:
+        client = scope.get("client")
+        if scope["type"] in ("http", "websocket") and client and self.is_trusted(client[0]):
+            forwarded = [hop.strip() for hop in _header(scope, b"x-forwarded-for").split(",") if hop.strip()]
+            # Proxies append, so the rightmost untrusted hop is the real client
+            for hop in reversed(forwarded):
+                if not self.is_trusted(hop):
+                    scope["client"] = (hop, 0)
+                    break
+            else:
+                if forwarded:
+                    scope["client"] = (forwarded[0], 0)
+            proto = _header(scope, b"x-forwarded-proto").split(",")[0].strip().lower()
+            if proto in ("http", "https"):
+                secure = proto == "https"
+                if scope["type"] == "websocket":
+                    scope["scheme"] = "wss" if secure else "ws"
+                else:
+                    scope["scheme"] = proto
+        await self.app(scope, receive, send)
+
+
+def origin_allowed(origin: str, allowed: tuple[str, ...]) -> bool:
+    """Whether origin matches an allowed origin, where "*" in a host matches any label(s)."""
+    origin = origin.rstrip("/").lower()
+    return any(
+        pattern == "*" or origin == pattern.lower() or ("*" in pattern and fnmatch.fnmatchcase(origin, pattern.lower()))
+        for pattern in allowed
+    )
+
+
+class CorsMiddleware:
+    """ASGI middleware answering CORS preflights and refusing unlisted browser origins."""
+
+    def __init__(self, app: Any, origins: tuple[str, ...]):
+        self.app = app
+        self.origins = origins
+
+    async def __call__(self, scope: MutableMapping[str, Any], receive: Any, send: Any) -> None:
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        origin = _header(scope, b"origin")
+        if not origin:
+            # Not a browser request
+            await self.app(scope, receive, send)
+            return
+        if not origin_allowed(origin, self.origins):
+            logger.warning(f"Refused request from origin {origin}")
+            await self._respond(send, 403, [], json.dumps({
+                "error": "origin_not_allowed", "message": f"Origin {origin} is not allowed"
+            }).encode())
+            return
+
+        cors = [
+            (b"access-control-allow-origin", origin.encode("latin-1")),
+            (b"vary", b"Origin"),
+        ]
+        if scope["method"] == "OPTIONS" and _header(scope, b"access-control-request-method"):
+            await self._respond(send, 204, cors + [
+                (b"access-control-allow-methods", CORS_METHODS.encode()),
+                (b"access-control-allow-headers", CORS_HEADERS.encode()),
+                (b"access-control-max-age", CORS_MAX_AGE.encode()),
+            ], b"")
+            return
+
+        async def cors_send(message: Any) -> None:
+            if message["type"] == "http.response.start":
+                message = {
+                    **message,
+                    "headers": [*message.get("headers", []), *cors,
+                                (b"access-control-expose-headers", CORS_EXPOSE_HEADERS.encode())],
+                }
+            await send(message)
+
+        await self.app(scope, receive, cors_send)
+
+    async def _respond(self, send: Any, status: int, headers: list[tuple[bytes, bytes]], body: bytes) -> None:
+        if body:
+            headers = [*headers, (b"content-type", b"application/json")]
+        await send({
+            "type": "http.response.start",
+            "status": status,
+            "headers": [*headers, (b"content-length", str(len(body)).encode())],
+        })
+        await send({"type": "http.response.body", "body": body})
@@ -0,0 +1,341 @@
+"""
+SWISH Image Selection for Docker SWISH MCP
+
+The container runs the runtime's default swipl/swish:latest unless the
+[container] settings (or SWISH_MCP_IMAGE, SWISH_MCP_DOCKERFILE and
+SWISH_MCP_PULL_POLICY) choose another:
+
+    [container]
+    image = "swipl/swish@sha256:..."     # any tag or pinned digest
+    dockerfile = "~/swish-image/Dockerfile"
+    pull_policy = "missing"
+
+With a Dockerfile the server builds the image itself, using the
+Dockerfile's directory as build context, and tags it with image (or
+docker-swish-mcp/swish:custom). The pull policy says when an image is
+pulled or built before the container starts:
+
+- always:  on every start (the default); builds also pull the base image
+- missing: only when the image is not present locally
+- never:   never; the image must already exist
+
+rebuild_image pulls or builds on demand, whatever the policy.
+
+Pulls stream per-layer progress (PullProgress): the server logs it, and
+rebuild_image and docker-swish-mcp --prepull report it as they go. The
+prepull option makes sure the image is present before a deployment goes
+offline. In offline mode (--offline, SWISH_MCP_OFFLINE=on) the registry
+is never contacted: the policy is never whatever is configured, builds
+do not pull their base image (it must be present too), and a missing
+image fails at startup with a message saying how to get it there,
+instead of as an error from a pull that cannot reach the registry.
+
+Images are pulled for the daemon's own platform: linux/arm64 on Apple
+Silicon and other ARM hosts, linux/amd64 on x86. If the image is not
+published for it, the pull falls back to whatever the registry serves,
+and an image of another architecture runs emulated (QEMU or Rosetta),
+several times slower; the server warns about that at startup and in
+swish_status. SWISH_MCP_PLATFORM (or platform under [container]) forces
+a platform instead, e.g. linux/amd64 for an image that only exists for
+it; the container is then created with that platform too:
+
+    [container]
+    platform = "linux/amd64"   # "auto" (the default) uses the daemon's
+"""
+
+import logging
+import platform as host
+import re
+import time
+from collections.abc import Callable
+from pathlib import Path
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.images")
+
+PULL_POLICIES = ("always", "missing", "never")
+
+# Tag of images built from a Dockerfile when no image is configured
+CUSTOM_IMAGE_TAG = "docker-swish-mcp/swish:custom"
+
+# [registry[:port]/]path[:tag][@sha256:digest]
+IMAGE_REF_RE = re.compile(
+    r"^[a-z0-9][\w.-]*(:\d+)?(/[a-z0-9][\w.-]*)*(:\w[\w.-]{0,127})?(@sha256:[0-9a-f]{64})?$"
+)
+
+# The configured platform "auto" pulls for the daemon's own
+PLATFORM_RE = re.compile(r"^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$")
+# Machine names of uname and the daemon's info -> OCI architectures
+ARCHITECTURES = {
+    "x86_64": "amd64", "amd64": "amd64",
+    "aarch64": "arm64", "arm64": "arm64", "armv8": "arm64",
+    "armv7l": "arm/v7", "armhf": "arm/v7",
+    "ppc64le": "ppc64le", "s390x": "s390x", "riscv64": "riscv64",
+}
+# What the registry says when an image has no manifest for the platform asked for
+NO_MANIFEST_RE = re.compile(r"no matching manifest|does not provide the specified platform|not found in manifest list", re.I)
+
+# Build output lines kept for rebuild_image's report
+BUILD_LOG_TAIL = 30
+# Seconds between progress reports of a pull, but for layers finishing
+PROGRESS_INTERVAL = 2.0
+
+
+class ImageError(Exception):
+    """Raised when the configured image cannot be pulled, built or found."""
+
+
+def validate_image(image: str) -> None:
+    """Raise ValueError unless image is a plausible image reference."""
+    if image and not IMAGE_REF_RE.match(image):
+        raise ValueError(f"Invalid image reference '{image}'")
+
+
+def validate_platform(value: str) -> None:
+    """Raise ValueError unless value is auto or an os/arch[/variant] platform."""
+    if value != "auto" and not PLATFORM_RE.match(value):
+        raise ValueError(f"Invalid platform '{value}'. Use auto or os/arch, e.g. linux/arm64")
+
+
+def normalize_platform(os_name: str, machine: str) -> str:
+    """os/arch of an OS and machine name; "" for an unknown machine."""
+    arch = ARCHITECTURES.get(machine.lower())
+    return f"{(os_name or 'linux').lower()}/{arch}" if arch else ""
+
+
+def native_platform(client: Any) -> str:
+    """
+    The platform images run on without emulation: the daemon's, as it
+    reports it, or else this machine's. Docker Desktop's VM has the
+    architecture of the Mac or PC it runs on, so the two agree there.
+    """
+    try:
+        info = client.info()
+        if found := normalize_platform(info.get("OSType", "linux"), info.get("Architecture", "")):
+            return found
+    except Exception as e:
+        logger.debug(f"Could not read the daemon's architecture: {e}")
+    return normalize_platform("linux", host.machine())
+
+
+def image_platform(client: Any, reference: str) -> str:
+    """os/arch[/variant] of a local image; "" if it is not present or does not say."""
+    try:
+        image = client.images.get(reference)
+    except Exception as e:
+        logger.debug(f"Image {reference} not present: {e}")
+        return ""
+    # docker-py returns an Image, nerdctl the inspect output itself
+    attrs = getattr(image, "attrs", image)
+    if not isinstance(attrs, dict) or not attrs.get("Architecture"):
+        return ""
+    variant = f"/{attrs['Variant']}" if attrs.get("Variant") else ""
+    return f"{attrs.get('Os', 'linux')}/{attrs['Architecture']}{variant}"
+
+
+def same_platform(first: str, second: str) -> bool:
+    """Whether two platforms run the same binaries; an arm64 variant (v8) does not matter."""
+    def strip(value: str) -> str:
+        return value.removesuffix("/v8")
+    return strip(first) == strip(second)
+
+
+def emulation_warning(client: Any, reference: str, native: str) -> str:
+    """Why reference will be slow here, if it is for another platform than native; "" if not."""
+    found = image_platform(client, reference)
+    if not found or not native or same_platform(found, native):
+        return ""
+    return (
+        f"Image {reference} is built for {found} but the daemon runs {native}, so it runs emulated "
+        f"and much slower. Use an image published for {native}, or build one from a Dockerfile "
+        f"(SWISH_MCP_DOCKERFILE)."
+    )
+
+
+def image_reference(image: str, dockerfile: Path | None, default: str) -> str:
+    """The image the container runs."""
+    if dockerfile:
+        return image or CUSTOM_IMAGE_TAG
+    return image or default
+
+
+def offline_message(reference: str) -> str:
+    return (
+        f"Image {reference} is not present locally and offline mode is on (--offline, SWISH_MCP_OFFLINE), "
+        f"so it is not pulled. Copy it over with `docker save {reference}` on a connected machine and "
+        f"`docker load` here, or run `docker-swish-mcp --prepull` while online."
+    )
+
+
+class PullProgress:
+    """Bytes and layers of one pull so far, from the runtime's progress events."""
+
+    def __init__(self, reference: str):
+        self.reference = reference
+        # Layer id -> (bytes downloaded, bytes in total)
+        self.layers: dict[str, tuple[int, int]] = {}
+        self.done: set[str] = set()
+        self.reported = 0.0
+
+    def update(self, event: dict[str, Any]) -> bool:
+        """Take an event; True when it is time to report progress."""
+        layer = event.get("id")
+        status = str(event.get("status", ""))
+        # "Pulling from library/swish" and the final digest and status carry the tag as id
+        if not layer or status.startswith(("Pulling from", "Digest:", "Status:")):
+            return False
+        detail = event.get("progressDetail") or {}
+        if status == "Downloading" and detail.get("total"):
+            self.layers[layer] = (int(detail.get("current", 0)), int(detail["total"]))
+        finished = status in ("Download complete", "Pull complete", "Already exists")
+        if finished:
+            # Layers already present report nothing but this
+            total = self.layers.get(layer, (0, 0))[1]
+            self.layers[layer] = (total, total)
+            if layer in self.done:
+                return False
+            self.done.add(layer)
+        else:
+            self.layers.setdefault(layer, (0, 0))
+        now = time.monotonic()
+        if finished or now - self.reported >= PROGRESS_INTERVAL:
+            self.reported = now
+            return True
+        return False
+
+    @property
+    def downloaded(self) -> int:
+        return sum(current for current, _ in self.layers.values())
+
+    def describe(self) -> str:
+        current = self.downloaded / 1e6
+        total = sum(size for _, size in self.layers.values()) / 1e6
+        size = f"{current:.1f}/{total:.1f} MB, " if total else ""
+        return f"⬇️ Pulling {self.reference}: {size}{len(self.done)}/{len(self.layers)} layers"
+
+
+def pull_image(
+    client: Any,
+    reference: str,
+    on_progress: Callable[[PullProgress], None] | None = None,
+    platform: str = ""
+) -> None:
+    """Pull reference (for platform, if given), calling on_progress now and then; raises ImageError if it fails."""
+    api = getattr(client, "api", None)
+    options = {"platform": platform} if platform else {}
+    try:
+        if api is None:
+            # Runtimes without the Docker API (nerdctl) pull in one go
+            client.images.pull(reference, **options)
+            return
+        progress = PullProgress(reference)
+        for event in api.pull(reference, stream=True, decode=True, **options):
+            if "error" in event:
+                raise ImageError(f"Could not pull {reference}: {event['error']}")
+            if progress.update(event):
+                if on_progress:
+                    on_progress(progress)
+                else:
+                    logger.info(progress.describe())
+    except ImageError:
+        raise
+    except Exception as e:
+        raise ImageError(f"Could not pull {reference}: {e}") from e
+
+
+def image_present(client: Any, reference: str) -> bool:
+    try:
+        client.images.get(reference)
+        return True
+    except Exception as e:
+        logger.debug(f"Image {reference} not present: {e}")
+        return False
+
+
+def build_image(
+    client: Any,
+    dockerfile: Path,
+    tag: str,
+    pull: bool = True,
+    no_cache: bool = False,
+    platform: str = ""
+) -> list[str]:
+    """Build and tag an image from a Dockerfile (for platform, if given); returns the build output."""
+    if not dockerfile.is_file():
+        raise ImageError(f"Dockerfile {dockerfile} does not exist")
+    try:
+        _, log = client.images.build(
+            path=str(dockerfile.parent),
+            dockerfile=dockerfile.name,
+            tag=tag,
+            pull=pull,
+            nocache=no_cache,
+            rm=True,
+            **({"platform": platform} if platform else {})
+        )
+    except Exception as e:
+        # docker.errors.BuildError carries the output up to the failing step
+        output = [entry.get("stream", "").rstrip() for entry in getattr(e, "build_log", [])]
+        detail = "\n".join(line for line in output[-BUILD_LOG_TAIL:] if line)
+        raise ImageError(f"Building {tag} from {dockerfile} failed: {e}" + (f"\n{detail}" if detail else "")) from e
+    return [line for entry in log if (line := entry.get("stream", "").rstrip())]
+
+
+def ensure_image(
+    client: Any,
+    reference: str,
+    dockerfile: Path | None,
+    pull_policy: str,
+    force: bool = False,
+    no_cache: bool = False,
+    offline: bool = False,
+    on_progress: Callable[[PullProgress], None] | None = None,
+    platform: str = "auto"
+) -> tuple[str, list[str]]:
+    """
+    Pull or build reference as the pull policy (or force) requires.
+
+    Offline, nothing is pulled: the image must be present, or be built
+    from a Dockerfile whose base image is. With platform auto, images are
+    pulled for the daemon's platform, or as the registry serves them if
+    it has none for it; a forced platform is pulled or built as is, and a
+    present image of another platform counts as missing.
+
+    Returns:
+        What was done ("pulled", "built" or "present") and any build output
+    """
+    forced = platform != "auto"
+
+    def present() -> bool:
+        if not forced:
+            return image_present(client, reference)
+        found = image_platform(client, reference)
+        if found and not same_platform(found, platform):
+            logger.info(f"Image {reference} is present for {found}, not {platform}")
+            return False
+        return image_present(client, reference)
+
+    if offline and not dockerfile:
+        if present():
+            return "present", []
+        raise ImageError(offline_message(reference))
+    if not force and (pull_policy != "always" or offline):
+        if present():
+            return "present", []
+        if pull_policy == "never" and not offline:
+            raise ImageError(f"Image {reference} is not present locally and pull_policy is never")
+    if dockerfile:
+        logger.info(f"🔨 Building {reference} from {dockerfile}")
+        return "built", build_image(
+            client, dockerfile, reference, pull=not offline, no_cache=no_cache, platform=platform if forced else ""
+        )
+    target = platform if forced else native_platform(client)
+    logger.info(f"⬇️ Pulling {reference}" + (f" for {target}" if target else ""))
+    try:
+        pull_image(client, reference, on_progress, target)
+    except ImageError as e:
+        if forced or not target or not NO_MANIFEST_RE.search(str(e)):
+            raise
+        logger.warning(f"⚠️ {reference} is not published for {target}; pulling the platform the registry serves")
+        pull_image(client, reference, on_progress)
+    return "pulled", []
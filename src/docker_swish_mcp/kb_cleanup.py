@@ -0,0 +1,80 @@
+"""
+Bulk Cleanup of the Knowledge Base for Docker SWISH MCP
+
+An exploratory session leaves visited/2 facts, memo tables and helper
+predicates behind. Two tools clear them out of the session (not the
+source files, see clause_retract for those):
+
+- retract_matching("visited(_, _)") erases every clause whose head
+  unifies with the pattern; "cache(K, _) :- true" or "step(_) :- _"
+  matches bodies too. Only dynamic predicates are touched.
+- kb_prune(["main", "solve/2"]) walks the call graph from the entry
+  points (goals or Name/Arity) and abolishes every user predicate not
+  reachable from them. Calls built at runtime (call/N on a constructed
+  goal, =../2) are not seen, so name such predicates as entry points.
+
+With dry_run=True either reports what it would remove and changes
+nothing. Both are recorded in the audit log, so undo_last restores
+dynamic clauses; abolished static predicates come back by consulting
+their file again.
+"""
+
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+
+def retract_call(module: str, pattern: str, dry_run: bool) -> tuple[str, list[str]]:
+    return "mcp_retract_matching", [prolog_atom(module), prolog_string(pattern), "true" if dry_run else "false"]
+
+
+def prune_call(module: str, entry_points: list[str], dry_run: bool) -> tuple[str, list[str]]:
+    roots = [entry.strip().removesuffix(".").rstrip() for entry in entry_points]
+    roots = [root for root in roots if root]
+    if not roots:
+        raise ValueError("No entry points given; name the goals the knowledge base must still answer, e.g. [\"main\"]")
+    goals = ", ".join(prolog_string(root) for root in roots)
+    return "mcp_kb_prune", [prolog_atom(module), f"[{goals}]", "true" if dry_run else "false"]
+
+
+def format_retracted(pattern: str, rows: list[dict[str, Any]], dry_run: bool) -> str:
+    if not rows:
+        return f"🔍 No clauses match {pattern}"
+    verb = "Would retract" if dry_run else "Retracted"
+    predicates = sorted({row["predicate"] for row in rows})
+    lines = [f"{'🔍' if dry_run else '🗑️'} {verb} {len(rows)} clause(s) of {', '.join(predicates)}:"]
+    lines.extend(f"  • {row['clause']}" for row in rows)
+    if dry_run:
+        lines.append("\n💡 Run again with dry_run=False to retract them")
+    return "\n".join(lines)
+
+
+def format_pruned(rows: list[dict[str, Any]], dry_run: bool) -> str:
+    summary = next((row for row in rows if "kept" in row), {"roots": [], "kept": 0})
+    unused = [row for row in rows if "predicate" in row]
+    if not summary["roots"]:
+        return "❌ None of the entry points is a predicate of the knowledge base; nothing would be kept"
+    lines = [f"🌱 Entry points: {', '.join(summary['roots'])}; {summary['kept']} predicate(s) reachable"]
+    if not unused:
+        lines.append("✅ Every predicate is reachable; nothing to prune")
+        return "\n".join(lines)
+    failed = [row for row in unused if row.get("error")]
+    removed = len(unused) - len(failed)
+    verb = "Would abolish" if dry_run else "Abolished"
+    if removed:
+        lines.append(f"{'🔍' if dry_run else '🗑️'} {verb} {removed} unreachable predicate(s):")
+    for row in unused:
+        if row.get("error"):
+            continue
+        kind = "dynamic" if row["dynamic"] else "static"
+        origin = f", from {row['file']}" if row.get("file") else ""
+        lines.append(f"  • {row['predicate']} ({row['clauses']} clause(s), {kind}{origin})")
+    if failed:
+        lines.append("❌ Could not abolish:")
+        lines.extend(f"  • {row['predicate']}: {row['error']}" for row in failed)
+    if dry_run:
+        lines.append("\n💡 Run again with dry_run=False to abolish them")
+    elif any(not row["dynamic"] and row.get("file") for row in unused):
+        lines.append("\n💡 Static predicates come back when their file is consulted again")
+    return "\n".join(lines)
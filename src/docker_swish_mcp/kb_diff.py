@@ -0,0 +1,122 @@
+"""
+Clause-Level Knowledge Base Diffs for Docker SWISH MCP
+
+kb_diff compares two versions of a program clause by clause rather than
+line by line. Both sides are read by SWI-Prolog through mcp_kb_clauses/2
+(see mcp_helpers.pl), which prints every clause with its variables
+renamed in order of appearance; a refactor that only renames variables,
+reformats or moves comments therefore shows no changes.
+
+Clauses are matched per predicate, in order: a clause that takes the
+place of a different one is reported as modified, others as added or
+removed. With ignore_order, clause order within a predicate is ignored
+too.
+"""
+
+from dataclasses import dataclass
+from difflib import SequenceMatcher
+from typing import Any
+
+from .rdf import prolog_atom
+
+# Right-hand side meaning the program as currently loaded in the session
+LOADED = "loaded"
+
+
+def file_clauses_call(path: str) -> tuple[str, list[str]]:
+    return "mcp_kb_clauses", [f"file({prolog_atom(path)})"]
+
+
+def loaded_clauses_call(module: str, path: str, predicates: list[str]) -> tuple[str, list[str]]:
+    """Clauses loaded in module of the given predicates and of those path defined."""
+    indicators = []
+    for predicate in predicates:
+        name, _, arity = predicate.rpartition("/")
+        indicators.append(f"{prolog_atom(name)}/{int(arity)}")
+    return "mcp_kb_clauses", [f"loaded({prolog_atom(module)}, {prolog_atom(path)}, [{', '.join(indicators)}])"]
+
+
+@dataclass
+class ClauseChange:
+    """One clause added, removed or modified between the two sides."""
+    # "added", "removed" or "modified"
+    kind: str
+    predicate: str
+    old: str = ""
+    new: str = ""
+    old_line: int = 0
+    new_line: int = 0
+
+    def to_json(self) -> dict[str, Any]:
+        entry: dict[str, Any] = {"kind": self.kind, "predicate": self.predicate}
+        if self.kind != "added":
+            entry["old"] = self.old
+            entry["old_line"] = self.old_line
+        if self.kind != "removed":
+            entry["new"] = self.new
+            entry["new_line"] = self.new_line
+        return entry
+
+
+def group_clauses(rows: list[dict[str, Any]]) -> dict[str, list[tuple[str, int]]]:
+    """Clause texts and lines per predicate, in source order."""
+    groups: dict[str, list[tuple[str, int]]] = {}
+    for row in rows:
+        groups.setdefault(row["predicate"], []).append((row["clause"], int(row.get("line", 0))))
+    return groups
+
+
+def diff_clauses(
+    left_rows: list[dict[str, Any]],
+    right_rows: list[dict[str, Any]],
+    ignore_order: bool = False
+) -> list[ClauseChange]:
+    left, right = group_clauses(left_rows), group_clauses(right_rows)
+    changes: list[ClauseChange] = []
+    for predicate in [*left, *(p for p in right if p not in left)]:
+        old, new = left.get(predicate, []), right.get(predicate, [])
+        if ignore_order:
+            old, new = sorted(old), sorted(new)
+        matcher = SequenceMatcher(None, [text for text, _ in old], [text for text, _ in new], autojunk=False)
+        for tag, i1, i2, j1, j2 in matcher.get_opcodes():
+            if tag == "equal":
+                continue
+            removed, added = old[i1:i2], new[j1:j2]
+            paired = min(len(removed), len(added)) if tag == "replace" else 0
+            for (old_text, old_line), (new_text, new_line) in zip(removed[:paired], added[:paired]):
+                changes.append(ClauseChange("modified", predicate, old_text, new_text, old_line, new_line))
+            for old_text, old_line in removed[paired:]:
+                changes.append(ClauseChange("removed", predicate, old=old_text, old_line=old_line))
+            for new_text, new_line in added[paired:]:
+                changes.append(ClauseChange("added", predicate, new=new_text, new_line=new_line))
+    return changes
+
+
+def _at(line: int) -> str:
+    return f" (line {line})" if line else ""
+
+
+def format_diff(changes: list[ClauseChange], left: str, right: str, clauses: tuple[int, int]) -> str:
+    if not changes:
+        return (
+            f"✅ {left} and {right} have the same clauses "
+            f"({clauses[0]} clause(s), variable names ignored)"
+        )
+    counts = {kind: sum(1 for c in changes if c.kind == kind) for kind in ("added", "removed", "modified")}
+    lines = [
+        f"🔀 {left} → {right}: {counts['added']} added, {counts['removed']} removed, "
+        f"{counts['modified']} modified ({clauses[0]} → {clauses[1]} clause(s))"
+    ]
+    predicate = None
+    for change in changes:
+        if change.predicate != predicate:
+            predicate = change.predicate
+            lines.append(f"\n📌 {predicate}")
+        if change.kind == "added":
+            lines.append(f"  + {change.new}{_at(change.new_line)}")
+        elif change.kind == "removed":
+            lines.append(f"  - {change.old}{_at(change.old_line)}")
+        else:
+            lines.append(f"  ~ {change.old}{_at(change.old_line)}")
+            lines.append(f"    → {change.new}{_at(change.new_line)}")
+    return "\n".join(lines)
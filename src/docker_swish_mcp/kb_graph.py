@@ -0,0 +1,110 @@
+"""
+Knowledge Base Graphs for Docker SWISH MCP
+
+Builds Graphviz DOT graphs of the knowledge base from mcp_kb_graph/4 (see
+mcp_helpers.pl):
+
+- calls: predicates defined in the client's module and which call which,
+  optionally narrowed to what a focus predicate (transitively) calls
+- facts: the clauses of one relation drawn as edges from their first to
+  their second argument, e.g. parent/2 as a family tree
+
+DOT is rendered to SVG or PNG with Graphviz's dot inside the SWISH
+container; the DOT text itself is returned when dot is not installed.
+"""
+
+import base64
+import re
+from collections import deque
+from typing import Any
+
+from .rdf import prolog_atom
+
+GRAPH_KINDS = ("calls", "facts")
+GRAPH_FORMATS = ("svg", "png", "dot")
+PREDICATE_RE = re.compile(r"^([a-z][A-Za-z0-9_]*)/(\d+)$")
+
+
+def parse_indicator(text: str) -> tuple[str, int]:
+    """Split "name/arity"; raises ValueError for anything else."""
+    match = PREDICATE_RE.match(text.strip())
+    if not match:
+        raise ValueError(f"Invalid predicate '{text}': use name/arity, e.g. parent/2")
+    return match.group(1), int(match.group(2))
+
+
+def graph_call(kind: str, relation: str, max_edges: int, module: str = "user") -> tuple[str, list[str]]:
+    if kind not in GRAPH_KINDS:
+        raise ValueError(f"Unknown graph kind '{kind}'. Use one of: {', '.join(GRAPH_KINDS)}")
+    if kind == "calls":
+        return "mcp_kb_graph", [prolog_atom(module), "calls", str(int(max_edges))]
+    if not relation:
+        raise ValueError('kind="facts" needs relation, e.g. relation="parent/2"')
+    name, arity = parse_indicator(relation)
+    if arity < 2:
+        raise ValueError(f"Relation {relation} needs at least two arguments to draw edges")
+    return "mcp_kb_graph", [prolog_atom(module), f"facts({prolog_atom(name)}, {arity})", str(int(max_edges))]
+
+
+def reachable_from(focus: str, edges: list[tuple[str, str]]) -> set[str]:
+    """Predicates focus calls directly or indirectly, including itself."""
+    callees: dict[str, list[str]] = {}
+    for source, target in edges:
+        callees.setdefault(source, []).append(target)
+    seen = {focus}
+    queue = deque([focus])
+    while queue:
+        for target in callees.get(queue.popleft(), []):
+            if target not in seen:
+                seen.add(target)
+                queue.append(target)
+    return seen
+
+
+def _quote(*lines: str) -> str:
+    """DOT string literal; several lines are joined with DOT's \\n."""
+    escaped = [line.replace("\\", "\\\\").replace('"', '\\"') for line in lines]
+    return '"' + "\\n".join(escaped) + '"'
+
+
+def call_graph_dot(rows: list[dict[str, Any]], focus: str = "") -> tuple[str, int, int]:
+    """DOT for a calls graph; returns (dot, nodes, edges)."""
+    nodes = {row["node"]: row for row in rows if "node" in row}
+    edges = [(row["from"], row["to"]) for row in rows if "from" in row]
+    if focus:
+        parse_indicator(focus)
+        if focus not in nodes:
+            raise ValueError(f"Predicate {focus} is not defined in the knowledge base")
+        keep = reachable_from(focus, edges)
+        nodes = {name: row for name, row in nodes.items() if name in keep}
+        edges = [(s, t) for s, t in edges if s in keep and t in keep]
+
+    lines = ["digraph calls {", "  rankdir=LR;", '  node [shape=box, fontname="Helvetica"];']
+    for name, row in sorted(nodes.items()):
+        style = ', style=dashed' if row.get("dynamic") else ""
+        emphasis = ", penwidth=2" if name == focus else ""
+        label = _quote(name, f"{row.get('clauses', 0)} clause(s)")
+        lines.append(f"  {_quote(name)} [label={label}{style}{emphasis}];")
+    for source, target in edges:
+        lines.append(f"  {_quote(source)} -> {_quote(target)};")
+    lines.append("}")
+    return "\n".join(lines), len(nodes), len(edges)
+
+
+def fact_graph_dot(rows: list[dict[str, Any]], relation: str) -> tuple[str, int, int]:
+    """DOT for a facts graph; returns (dot, nodes, edges)."""
+    lines = [f"digraph {_quote(relation)} {{", '  node [shape=ellipse, fontname="Helvetica"];']
+    nodes: set[str] = set()
+    for row in rows:
+        nodes.update((row["from"], row["to"]))
+        label = f" [label={_quote(row['label'])}]" if row.get("label") else ""
+        lines.append(f"  {_quote(row['from'])} -> {_quote(row['to'])}{label};")
+    lines.append("}")
+    return "\n".join(lines), len(nodes), len(rows)
+
+
+def render_command(dot: str, fmt: str) -> list[str]:
+    """Container command piping dot text through Graphviz; PNG comes back base64-encoded."""
+    encoded = base64.b64encode(dot.encode("utf-8")).decode("ascii")
+    output = "dot -Tpng | base64 -w0" if fmt == "png" else "dot -Tsvg"
+    return ["sh", "-c", f"echo {encoded} | base64 -d | {output}"]
@@ -0,0 +1,223 @@
+"""
+Knowledge Base Files as MCP Resources
+
+Every .pl file in the data directory (and in project folders one level
+down) is registered as a resource swish://kb/<path>, so resource-aware
+clients can browse the knowledge base without calling a tool.
+
+Clients may subscribe to these resources. Subscribers get
+resources/updated when a file changes on disk or when a query asserts or
+retracts clauses, and resources/list_changed when files appear or
+disappear.
+
+The resources are writeable: a swish://kb/{file} template is listed for
+files that do not exist yet, and MCP has no resources/write request, so
+the server advertises the experimental "swish/resourceWrite" capability
+naming the tool (write_resource) that replaces a resource's text once
+SWI-Prolog has read it without syntax errors.
+"""
+
+import asyncio
+import logging
+from collections.abc import Awaitable, Callable
+from pathlib import Path
+from typing import Any
+
+from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp.resources import FunctionResource
+
+logger = logging.getLogger("docker-swish-mcp.kb_resources")
+
+URI_PREFIX = "swish://kb/"
+URI_TEMPLATE = f"{URI_PREFIX}{{file}}"
+CONTAINER_DATA_DIR = "/data"
+WRITE_CAPABILITY = "swish/resourceWrite"
+WRITE_TOOL = "write_resource"
+
+
+def kb_uri(relative_path: str) -> str:
+    return f"{URI_PREFIX}{relative_path}"
+
+
+def kb_relative_path(uri: str) -> str:
+    """The data directory path a swish://kb/ URI names; raises ValueError for other URIs."""
+    if not uri.startswith(URI_PREFIX):
+        raise ValueError(f"'{uri}' is not a knowledge base resource; use {URI_TEMPLATE}")
+    relative = uri[len(URI_PREFIX):].strip("/")
+    parts = relative.split("/")
+    if not relative.endswith(".pl") or len(parts) > 2 or any(part in ("", ".", "..") for part in parts):
+        raise ValueError(f"'{uri}' must name a .pl file in the data directory or a folder one level down")
+    return relative
+
+
+def scan_kb_files(data_dir: Path) -> dict[str, int]:
+    """Map each knowledge base file (relative path) to its mtime in ns."""
+    if not data_dir.exists():
+        return {}
+    files = {}
+    for pattern in ("*.pl", "*/*.pl"):
+        for path in data_dir.glob(pattern):
+            try:
+                files[path.relative_to(data_dir).as_posix()] = path.stat().st_mtime_ns
+            except OSError:
+                continue
+    return files
+
+
+class KnowledgeBaseResources:
+    """
+    Keeps the swish://kb/ resources in step with the data directory.
+
+    Args:
+        server: FastMCP server to register resources with
+        data_dir: Host data directory mounted at /data in the container;
+            set once the environment is up
+        runtime_clauses: Coroutine returning a listing of dynamic clauses
+            currently loaded from a container file path, or "" if none
+        on_change: Coroutine called with the files whose content changed
+            since the last scan
+    """
+
+    def __init__(
+        self,
+        server: FastMCP,
+        data_dir: Path | None = None,
+        runtime_clauses: Callable[[str], Awaitable[str]] | None = None,
+        on_change: Callable[[list[str]], Awaitable[None]] | None = None
+    ):
+        self.server = server
+        self.data_dir = data_dir
+        # Where the Prolog session sees data_dir; the host path itself for the local backend
+        self.prolog_data_dir = CONTAINER_DATA_DIR
+        self.runtime_clauses = runtime_clauses
+        self.on_change = on_change
+        self.files: dict[str, int] = {}
+        self.subscribers: dict[str, set[Any]] = {}
+        self.sessions: set[Any] = set()
+
+    def install(self) -> None:
+        """Register subscribe/unsubscribe handlers and advertise the capability."""
+        lowlevel = self.server._mcp_server
+
+        @lowlevel.subscribe_resource()
+        async def subscribe(uri: Any) -> None:
+            session = lowlevel.request_context.session
+            self.sessions.add(session)
+            self.subscribers.setdefault(str(uri), set()).add(session)
+
+        @lowlevel.unsubscribe_resource()
+        async def unsubscribe(uri: Any) -> None:
+            session = lowlevel.request_context.session
+            self.subscribers.get(str(uri), set()).discard(session)
+
+        # Files not registered yet are read through the template
+        @self.server.resource(URI_TEMPLATE, name="kb-file", mime_type="text/x-prolog",
+                              description="Prolog knowledge base file; write it with write_resource")
+        async def read_template(file: str) -> str:
+            return await self.read(file)
+
+        # FastMCP advertises resources without subscribe/listChanged support
+        base_capabilities = lowlevel.get_capabilities
+
+        def get_capabilities(notification_options: Any, experimental_capabilities: Any) -> Any:
+            experimental = {
+                **(experimental_capabilities or {}),
+                WRITE_CAPABILITY: {"tool": WRITE_TOOL, "uriTemplate": URI_TEMPLATE, "validates": "syntax"},
+            }
+            capabilities = base_capabilities(notification_options, experimental)
+            if capabilities.resources is not None:
+                capabilities.resources.subscribe = True
+                capabilities.resources.listChanged = True
+            return capabilities
+
+        lowlevel.get_capabilities = get_capabilities
+
+    def _register(self, relative_path: str) -> None:
+        async def read() -> str:
+            return await self.read(relative_path)
+
+        self.server.add_resource(FunctionResource(
+            uri=kb_uri(relative_path),
+            name=relative_path,
+            description=f"Prolog knowledge base file {relative_path}",
+            mime_type="text/x-prolog",
+            fn=read,
+        ))
+
+    def _unregister(self, relative_path: str) -> None:
+        # ResourceManager has no public removal API
+        self.server._resource_manager._resources.pop(kb_uri(relative_path), None)
+
+    async def read(self, relative_path: str) -> str:
+        """File source, followed by clauses asserted into it at runtime."""
+        if self.data_dir is None:
+            raise ValueError("Knowledge base is not available yet")
+        path = self.data_dir / relative_path
+        text = await asyncio.to_thread(path.read_text, encoding="utf-8")
+        if self.runtime_clauses is None:
+            return text
+        try:
+            runtime = await self.runtime_clauses(f"{self.prolog_data_dir}/{relative_path}")
+        except Exception as e:
+            logger.debug(f"Could not list runtime clauses for {relative_path}: {e}")
+            runtime = ""
+        if runtime.strip():
+            text += f"\n\n% ---- Dynamic clauses currently loaded (swish://kb/{relative_path}) ----\n{runtime}"
+        return text
+
+    async def refresh(self) -> None:
+        """Rescan the data directory, registering files and notifying clients."""
+        if self.data_dir is None:
+            return
+        current = await asyncio.to_thread(scan_kb_files, self.data_dir)
+        added = current.keys() - self.files.keys()
+        removed = self.files.keys() - current.keys()
+        changed = [f for f in current.keys() & self.files.keys() if current[f] != self.files[f]]
+
+        for relative_path in added:
+            self._register(relative_path)
+        for relative_path in removed:
+            self._unregister(relative_path)
+        self.files = current
+
+        if added or removed:
+            await self.notify_list_changed()
+        if changed and self.on_change:
+            await self.on_change(changed)
+        for relative_path in [*changed, *removed]:
+            await self.notify_updated(kb_uri(relative_path))
+
+    async def notify_all_updated(self) -> None:
+        """Tell every subscriber their resource may have changed (e.g. after assert)."""
+        for uri in list(self.subscribers):
+            await self.notify_updated(uri)
+
+    async def notify_updated(self, uri: str) -> None:
+        for session in list(self.subscribers.get(uri, ())):
+            try:
+                await session.send_resource_updated(uri)
+            except Exception as e:
+                logger.debug(f"Dropping subscriber of {uri}: {e}")
+                self._drop_session(session)
+
+    async def notify_list_changed(self) -> None:
+        for session in list(self.sessions):
+            try:
+                await session.send_resource_list_changed()
+            except Exception as e:
+                logger.debug(f"Dropping session: {e}")
+                self._drop_session(session)
+
+    def _drop_session(self, session: Any) -> None:
+        self.sessions.discard(session)
+        for sessions in self.subscribers.values():
+            sessions.discard(session)
+
+    async def watch(self, interval: float = 5.0) -> None:
+        """Poll the data directory so edits made outside the tools are seen."""
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.debug(f"Knowledge base rescan failed: {e}")
+            await asyncio.sleep(interval)
@@ -0,0 +1,118 @@
+"""
+Knowledge Base Search for Docker SWISH MCP
+
+kb_search finds things in the files loaded from the data directory
+without reading them whole. The session reports what it knows through
+mcp_kb_search/4 (see mcp_helpers.pl), with the file and line of every
+hit:
+
+- definition: predicates whose Name/Arity matches a regex, with the
+  line of their first clause and their clause count
+- body: clauses whose body contains a subterm unifying with a Prolog
+  term, e.g. "parent(_, bob)" or "format(_, _)"
+- comment: comments matching a regex, reported at the matching line
+"""
+
+import re
+from dataclasses import dataclass
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import clean_query_text, prolog_string
+
+SEARCH_KINDS = ("definition", "body", "comment")
+
+
+def search_call(kind: str, pattern: str, root: str, path: str, max_results: int) -> tuple[str, list[str]]:
+    """The mcp_kb_search/4 call for kind; path is a session path or ""."""
+    if kind == "definition":
+        query = "definitions"
+    elif kind == "body":
+        query = f"body({prolog_string(clean_query_text(pattern))}, {prolog_atom(path)})"
+    else:
+        query = "comments"
+    return "mcp_kb_search", [prolog_atom(root), query, str(max(1, max_results) + 1)]
+
+
+def compile_pattern(kind: str, pattern: str) -> re.Pattern[str] | None:
+    """The regex for definition and comment searches; raises ValueError if invalid."""
+    if kind == "body":
+        return None
+    try:
+        return re.compile(pattern)
+    except re.error as e:
+        raise ValueError(f"Invalid regex '{pattern}': {e}") from e
+
+
+@dataclass
+class SearchHit:
+    file: str
+    line: int
+    predicate: str = ""
+    # Clause text for body hits, the matching comment line for comment hits
+    text: str = ""
+    clauses: int = 0
+
+    def to_json(self) -> dict[str, Any]:
+        entry: dict[str, Any] = {"file": self.file, "line": self.line}
+        if self.predicate:
+            entry["predicate"] = self.predicate
+        if self.text:
+            entry["text"] = self.text
+        if self.clauses:
+            entry["clauses"] = self.clauses
+        return entry
+
+
+def relative_file(path: str, root: str) -> str:
+    prefix = root.rstrip("/") + "/"
+    return path[len(prefix):] if path.startswith(prefix) else path
+
+
+def collect_hits(
+    kind: str,
+    regex: re.Pattern[str] | None,
+    rows: list[dict[str, Any]],
+    root: str,
+    only: str,
+    max_results: int
+) -> tuple[list[SearchHit], bool]:
+    """Hits from the helper's rows, limited to the file only if given; True when truncated."""
+    hits: list[SearchHit] = []
+    for row in rows:
+        file = relative_file(row["file"], root)
+        if only and file != only:
+            continue
+        if kind == "definition":
+            if regex is not None and regex.search(row["predicate"]):
+                module = f"{row['module']}:" if row.get("module") not in (None, "user") else ""
+                hits.append(SearchHit(file, row["line"], f"{module}{row['predicate']}", clauses=row["clauses"]))
+        elif kind == "body":
+            hits.append(SearchHit(file, row["line"], row["predicate"], row["clause"]))
+        elif regex is not None:
+            for offset, text in enumerate(row["comment"].splitlines()):
+                if regex.search(text):
+                    hits.append(SearchHit(file, row["line"] + offset, text=text.strip()))
+    hits.sort(key=lambda hit: (hit.file, hit.line))
+    return hits[:max_results], len(hits) > max_results
+
+
+def format_hits(kind: str, pattern: str, hits: list[SearchHit], truncated: bool) -> str:
+    if not hits:
+        return f"🔍 No {kind} matches for: {pattern}"
+    lines = [f"🔍 {len(hits)} {kind} match(es) for: {pattern}"]
+    current = ""
+    for hit in hits:
+        if hit.file != current:
+            current = hit.file
+            lines.append(f"\n📄 {hit.file}")
+        if kind == "definition":
+            lines.append(f"  {hit.line:>5}: {hit.predicate} ({hit.clauses} clause(s))")
+        elif kind == "body":
+            clause = hit.text.replace("\n", "\n         ")
+            lines.append(f"  {hit.line:>5}: {clause}")
+        else:
+            lines.append(f"  {hit.line:>5}: {hit.text}")
+    if truncated:
+        lines.append("\n✂️ More matches were found; raise max_results or narrow the search")
+    return "\n".join(lines)
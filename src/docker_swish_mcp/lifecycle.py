@@ -0,0 +1,167 @@
+"""
+Container Lifecycle for Docker SWISH MCP
+
+Containers the server starts are labelled with the process and host that
+own them. On shutdown SWISH_MCP_SHUTDOWN_POLICY says what happens to them:
+
+- remove: stop and remove them (the default)
+- stop:   stop them, so the next start can adopt them
+- keep:   leave them running
+
+A crashed server cannot clean up, so on startup every swish-mcp-*
+container whose owner process is gone is an orphan, and
+SWISH_MCP_ORPHAN_POLICY says what to do with it:
+
+- adopt:  reuse an orphan with the name, image, port and data directory
+          of a container about to start, and remove the rest (the default)
+- remove: remove all orphans
+- keep:   leave them alone
+
+Containers owned by a live server, or by a server on another host, are
+never touched.
+"""
+
+import logging
+import os
+import socket
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .host_platform import process_alive
+
+logger = logging.getLogger("docker-swish-mcp.lifecycle")
+
+SHUTDOWN_POLICIES = ("remove", "stop", "keep")
+ORPHAN_POLICIES = ("adopt", "remove", "keep")
+
+MANAGED_BY = "docker-swish-mcp"
+NAME_PREFIX = "swish-mcp-"
+OWNER_PID_LABEL = "mcp-owner-pid"
+OWNER_HOST_LABEL = "mcp-owner-host"
+PORT_LABEL = "mcp-port"
+DATA_DIR_LABEL = "mcp-data-dir"
+RESOURCES_LABEL = "mcp-resources"
+VOLUME_LABEL = "mcp-volume"
+NETWORK_LABEL = "mcp-network"
+ENVIRONMENT_LABEL = "mcp-environment"
+
+
+def container_labels(
+    version: str, port: int, data_dir: Path, resources: str = "", volume: str = "", network: str = "bridge",
+    environment: str = ""
+) -> dict[str, str]:
+    """
+    Labels of a container started by this process; resources describes its
+    limits, volume the one at /data, network its network profile and
+    environment its variables and secrets (names and a digest).
+    """
+    return {
+        "managed-by": MANAGED_BY,
+        "mcp-version": version,
+        "auto-managed": "true",
+        OWNER_PID_LABEL: str(os.getpid()),
+        OWNER_HOST_LABEL: socket.gethostname(),
+        PORT_LABEL: str(port),
+        DATA_DIR_LABEL: str(data_dir.resolve()),
+        RESOURCES_LABEL: resources,
+        VOLUME_LABEL: volume,
+        NETWORK_LABEL: network,
+        ENVIRONMENT_LABEL: environment,
+    }
+
+
+def _labels(container: Any) -> dict[str, str]:
+    return container.attrs.get("Config", {}).get("Labels") or {}
+
+
+def is_managed(container: Any) -> bool:
+    return _labels(container).get("managed-by") == MANAGED_BY or container.name.startswith(NAME_PREFIX)
+
+
+def is_orphan(container: Any) -> bool:
+    """Whether a managed container's owner process is gone."""
+    labels = _labels(container)
+    host, pid = labels.get(OWNER_HOST_LABEL), labels.get(OWNER_PID_LABEL, "")
+    if host is None:
+        # Started before containers were labelled with their owner
+        return True
+    if host != socket.gethostname() or not pid.isdigit():
+        return False
+    return int(pid) != os.getpid() and not process_alive(int(pid))
+
+
+def find_orphans(client: Any) -> list[Any]:
+    return [c for c in client.containers.list(all=True) if is_managed(c) and is_orphan(c)]
+
+
+@dataclass(frozen=True)
+class WantedContainer:
+    """A container the server is about to start, which an orphan may stand in for."""
+    name: str
+    image: str
+    port: int
+    data_dir: Path
+    resources: str = ""
+    volume: str = ""
+    network: str = "bridge"
+    environment: str = ""
+
+
+def adoptable(container: Any, wanted: WantedContainer) -> bool:
+    """Whether container runs with wanted's image, port, data directory, limits, volume, network and environment."""
+    labels = _labels(container)
+    image = container.attrs.get("Config", {}).get("Image", "")
+    return (
+        container.name == wanted.name
+        and image == wanted.image
+        and labels.get(PORT_LABEL) == str(wanted.port)
+        and labels.get(DATA_DIR_LABEL) == str(wanted.data_dir.resolve())
+        and labels.get(RESOURCES_LABEL, "") == wanted.resources
+        and labels.get(VOLUME_LABEL, "") == wanted.volume
+        and labels.get(NETWORK_LABEL, "bridge") == wanted.network
+        and labels.get(ENVIRONMENT_LABEL, "") == wanted.environment
+    )
+
+
+@dataclass
+class SweepReport:
+    """Orphaned containers found on startup, by name."""
+    adopted: list[str] = field(default_factory=list)
+    removed: list[str] = field(default_factory=list)
+    kept: list[str] = field(default_factory=list)
+
+    def describe(self) -> str:
+        parts = [f"{len(self.adopted)} adopted", f"{len(self.removed)} removed"]
+        if self.kept:
+            parts.append(f"{len(self.kept)} kept")
+        return ", ".join(parts)
+
+
+def sweep_orphans(client: Any, policy: str, wanted: list[WantedContainer]) -> SweepReport:
+    """Adopt, remove or keep orphaned containers as policy says; blocking."""
+    report = SweepReport()
+    by_name = {w.name: w for w in wanted}
+    for container in find_orphans(client):
+        if policy == "keep":
+            report.kept.append(container.name)
+        elif policy == "adopt" and container.name in by_name and adoptable(container, by_name[container.name]):
+            report.adopted.append(container.name)
+        else:
+            try:
+                container.remove(force=True)
+                report.removed.append(container.name)
+            except Exception as e:
+                logger.warning(f"Could not remove orphaned container {container.name}: {e}")
+    return report
+
+
+def shutdown_container(container: Any, policy: str) -> str:
+    """Stop and/or remove a container on shutdown; returns what was done."""
+    if policy == "keep":
+        return "kept"
+    container.stop(timeout=5)
+    if policy == "stop":
+        return "stopped"
+    container.remove(force=True)
+    return "removed"
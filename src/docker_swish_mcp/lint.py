@@ -0,0 +1,122 @@
+"""
+Prolog Source Linting for Docker SWISH MCP
+
+lint_program loads a file in a fresh swipl process, with the singleton,
+discontiguous, no_effect and var_branches style checks on, and runs
+check/0. Neither the file's directives nor its clauses reach the
+persistent session. mcp_lint/2 (see mcp_helpers.pl) intercepts every
+warning and error through message_hook/3 and prints it as a JSON row,
+which this module turns into diagnostics.
+
+check/0 reports (undefined procedures, clauses that always fail, ...)
+are not about the term being loaded; their locations are taken from the
+"File:Line:" lines in the message text, one diagnostic per location.
+"""
+
+import json
+import re
+from dataclasses import asdict, dataclass
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import HELPERS_PATH, MARKER_RE, prolog_string
+
+# Query id of the lint rows in the process output
+LINT_ID = "lint"
+
+# "/data/kb.pl:12:4: " as SWI-Prolog prints source locations
+LOCATION_RE = re.compile(r"^\s*(?P<file>/[^:\n]+):(?P<line>\d+)(?::\d+)?:\s*(?P<rest>.*)$")
+
+
+@dataclass
+class Diagnostic:
+    """One warning or error; file is relative to the data directory when inside it."""
+    file: str
+    line: int
+    # "error" or "warning"
+    severity: str
+    message: str
+
+    def to_json(self) -> dict[str, Any]:
+        return asdict(self)
+
+
+def lint_program_text() -> str:
+    """Program consulted into the lint process before the goal runs."""
+    return HELPERS_PATH.read_text(encoding="utf-8")
+
+
+def lint_goal(path: str) -> str:
+    return f"mcp_lint({LINT_ID}, {prolog_atom(path)})"
+
+
+def syntax_call(text: str) -> tuple[str, list[str]]:
+    """mcp_syntax_check/2 call reading text as a file's terms, without loading it."""
+    return "mcp_syntax_check", [prolog_string(text)]
+
+
+def _relative(path: str, data_dir: str) -> str:
+    prefix = data_dir.rstrip("/") + "/"
+    return path[len(prefix):] if path.startswith(prefix) else path
+
+
+def diagnostics_from_row(row: dict[str, Any], data_dir: str) -> list[Diagnostic]:
+    severity = row.get("severity", "warning")
+    lines = [line.rstrip() for line in str(row.get("message", "")).splitlines()]
+    file, line = str(row.get("file") or ""), int(row.get("line") or 0)
+
+    # Syntax errors start with their own location
+    if lines and (match := LOCATION_RE.match(lines[0])):
+        file, line = file or match["file"], line or int(match["line"])
+        lines[0] = match["rest"]
+    if file:
+        message = "\n".join(text for text in lines if text.strip()).strip()
+        return [Diagnostic(_relative(file, data_dir), line, severity, message)]
+
+    diagnostics = []
+    context = ""
+    for text in lines:
+        match = LOCATION_RE.match(text)
+        if match is None:
+            if text.strip():
+                context = text.strip()
+            continue
+        message = f"{context} {match['rest']}".strip()
+        diagnostics.append(Diagnostic(_relative(match["file"], data_dir), int(match["line"]), severity, message))
+    if not diagnostics:
+        message = "\n".join(text for text in lines if text.strip()).strip()
+        diagnostics.append(Diagnostic("", 0, severity, message))
+    return diagnostics
+
+
+def parse_lint_output(stdout: str, data_dir: str) -> list[Diagnostic]:
+    """Diagnostics from the lint process output; raises RuntimeError if linting failed."""
+    diagnostics = []
+    finished = False
+    for line in stdout.splitlines():
+        match = MARKER_RE.search(line)
+        if match is None or match.group(1) != LINT_ID:
+            continue
+        kind, payload = match.group(2), match.group(3) or ""
+        if kind == "ERROR":
+            raise RuntimeError(payload)
+        if kind == "SOLUTION":
+            diagnostics.extend(diagnostics_from_row(json.loads(payload), data_dir))
+        elif kind == "END":
+            finished = True
+    if not finished:
+        raise RuntimeError("the lint process exited before reporting")
+    return diagnostics
+
+
+def format_diagnostics(name: str, diagnostics: list[Diagnostic]) -> str:
+    if not diagnostics:
+        return f"✅ {name}: no warnings or errors"
+    errors = sum(1 for d in diagnostics if d.severity == "error")
+    lines = [f"🔎 {name}: {errors} error(s), {len(diagnostics) - errors} warning(s)"]
+    for d in sorted(diagnostics, key=lambda d: (d.file != name, d.file, d.line)):
+        icon = "❌" if d.severity == "error" else "⚠️"
+        where = f"{d.file}:{d.line}" if d.file and d.line else d.file or "program"
+        message = d.message.replace("\n", "\n     ")
+        lines.append(f"  {icon} {where}: {message}")
+    return "\n".join(lines)
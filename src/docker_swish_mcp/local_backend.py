@@ -0,0 +1,112 @@
+"""
+Local SWI-Prolog Backend for Docker SWISH MCP
+
+With --backend=local (or SWISH_MCP_BACKEND=local) no container is started:
+the persistent session runs a swipl installed on this machine, with the
+data directory as its working directory, for CI runners and laptops where
+Docker is unavailable.
+
+The process speaks the same @MCP line protocol over stdin/stdout as the
+session in the container (see simple_session.py and mcp_helpers.pl), so
+every session-based tool works unchanged. What needs the SWISH web server
+does not: pengines, isolated and concurrent queries, and the container
+tools (logs, snapshots of /data through the runtime API).
+
+LocalProcessClient stands in for the container runtime client: it has the
+open_exec() that container_exec.open_exec() prefers, and runs commands on
+the host instead of in a container.
+"""
+
+import asyncio
+import logging
+import shutil
+import subprocess
+from pathlib import Path
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.local_backend")
+
+# SWI-Prolog releases older than this lack transaction/1 and friends
+MIN_SWIPL_VERSION = (9, 0, 0)
+
+
+class LocalBackendError(Exception):
+    """Raised when no usable swipl is installed."""
+
+
+def find_swipl(binary: str = "swipl") -> str:
+    """Absolute path of the swipl executable; raises LocalBackendError if missing."""
+    path = shutil.which(binary)
+    if path is None:
+        raise LocalBackendError(
+            f"'{binary}' not found on PATH; install SWI-Prolog or set SWISH_MCP_SWIPL"
+        )
+    return path
+
+
+def parse_swipl_version(text: str) -> tuple[int, ...] | None:
+    """Version from `swipl --version` output, e.g. "SWI-Prolog version 9.2.9 for x86_64-linux"."""
+    for word in text.split():
+        parts = word.split(".")
+        if len(parts) == 3 and all(part.isdigit() for part in parts):
+            return tuple(int(part) for part in parts)
+    return None
+
+
+class LocalProcessClient:
+    """
+    Runs the session's commands on the host.
+
+    Args:
+        data_dir: Working directory of every process, so relative consults
+            find the knowledge base files as they do under /data
+        binary: swipl executable, by name on PATH or as a path
+    """
+
+    def __init__(self, data_dir: Path, binary: str = "swipl"):
+        self.data_dir = data_dir
+        self.binary = binary
+        self.version: tuple[int, ...] | None = None
+
+    def for_dir(self, data_dir: Path) -> "LocalProcessClient":
+        """A client like this one working in another directory, for workspaces."""
+        return LocalProcessClient(data_dir, self.binary)
+
+    def ping(self) -> bool:
+        """Check that swipl runs, recording its version."""
+        path = find_swipl(self.binary)
+        try:
+            result = subprocess.run([path, "--version"], capture_output=True, timeout=10)
+        except (OSError, subprocess.TimeoutExpired) as e:
+            raise LocalBackendError(f"Could not run {path}: {e}") from e
+        if result.returncode != 0:
+            raise LocalBackendError(f"{path} --version failed: {result.stderr.decode(errors='replace').strip()}")
+        self.version = parse_swipl_version(result.stdout.decode(errors="replace"))
+        if self.version and self.version < MIN_SWIPL_VERSION:
+            logger.warning(
+                f"SWI-Prolog {'.'.join(map(str, self.version))} is older than "
+                f"{'.'.join(map(str, MIN_SWIPL_VERSION))}; some tools may not work"
+            )
+        return True
+
+    @property
+    def version_text(self) -> str:
+        return ".".join(map(str, self.version)) if self.version else "unknown"
+
+    @property
+    def engine(self) -> str:
+        return f"{self.binary} ({self.version_text})"
+
+    def host_command(self, cmd: list[str]) -> list[str]:
+        """cmd as run on the host, with the configured swipl."""
+        return [self.binary, *cmd[1:]] if cmd and cmd[0] == "swipl" else cmd
+
+    async def open_exec(self, container_name: str, cmd: list[str], stdin: bool = True) -> Any:
+        """Start cmd on the host; container_name is ignored."""
+        return await asyncio.create_subprocess_exec(
+            *self.host_command(cmd),
+            cwd=self.data_dir,
+            stdin=asyncio.subprocess.PIPE if stdin else asyncio.subprocess.DEVNULL,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE
+        )
@@ -0,0 +1,126 @@
+"""
+Container Log Tailing for Docker SWISH MCP
+
+Reads the SWISH container's stdout/stderr with docker-py's logs() API
+(mirrored by the nerdctl runtime), filtered by stream and by a regular
+expression, for the swish_logs tool and the swish://container/logs
+resource.
+
+While a client subscribes to swish://container/logs, LogFollower keeps a
+following log stream open on the default container and sends
+resources/updated when new output arrives, at most once per interval.
+The stream is reopened when the container restarts.
+"""
+
+import asyncio
+import logging
+import re
+from collections.abc import Awaitable, Callable
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.log_stream")
+
+LOGS_URI = "swish://container/logs"
+STREAMS = ("both", "stdout", "stderr")
+# Lines kept when following, and shown by the resource
+MAX_FOLLOW_LINES = 1000
+RESOURCE_LINES = 200
+
+
+def stream_options(stream: str) -> dict[str, bool]:
+    """logs() keyword arguments selecting stdout, stderr or both."""
+    if stream not in STREAMS:
+        raise ValueError(f"Unknown log stream '{stream}'. Use one of: {', '.join(STREAMS)}")
+    return {"stdout": stream != "stderr", "stderr": stream != "stdout"}
+
+
+def compile_filter(grep: str) -> re.Pattern[str] | None:
+    """Regular expression lines must match, or None to keep every line."""
+    if not grep:
+        return None
+    try:
+        return re.compile(grep)
+    except re.error as e:
+        raise ValueError(f"Invalid grep pattern '{grep}': {e}") from e
+
+
+def split_lines(data: bytes, pattern: re.Pattern[str] | None = None) -> list[str]:
+    lines = data.decode("utf-8", errors="replace").splitlines()
+    return [line for line in lines if pattern is None or pattern.search(line)]
+
+
+def read_logs(container: Any, lines: int, stream: str = "both", grep: str = "") -> list[str]:
+    """
+    The last lines of the container's log, with timestamps.
+
+    With grep, the last lines matching it: the log is read in full so
+    matches further back than lines are still found.
+    """
+    pattern = compile_filter(grep)
+    tail: int | str = "all" if pattern else max(lines, 0)
+    data = container.logs(tail=tail, timestamps=True, **stream_options(stream))
+    matched = split_lines(data, pattern)
+    return matched[-lines:] if lines > 0 else []
+
+
+class LogFollower:
+    """
+    Notifies subscribers of swish://container/logs about new log output.
+
+    Args:
+        notify: Coroutine sending resources/updated for LOGS_URI
+        subscribed: Whether any client currently subscribes to LOGS_URI
+        interval: Minimum seconds between notifications
+    """
+
+    def __init__(
+        self,
+        notify: Callable[[], Awaitable[None]],
+        subscribed: Callable[[], bool],
+        interval: float = 2.0
+    ):
+        self.notify = notify
+        self.subscribed = subscribed
+        self.interval = interval
+
+    async def watch(self, container: Callable[[], Any]) -> None:
+        """Follow whichever container container() returns while there are subscribers."""
+        while True:
+            target = container()
+            if target is not None and self.subscribed():
+                try:
+                    await self._follow(target)
+                except Exception as e:
+                    logger.debug(f"Log follower stopped: {e}")
+            await asyncio.sleep(self.interval)
+
+    async def _follow(self, target: Any) -> None:
+        loop = asyncio.get_running_loop()
+        arrived = asyncio.Event()
+        ended = asyncio.Event()
+        stream = await asyncio.to_thread(target.logs, stream=True, follow=True, tail=0)
+
+        def pump() -> None:
+            try:
+                for _chunk in stream:
+                    loop.call_soon_threadsafe(arrived.set)
+            except Exception as e:
+                logger.debug(f"Log stream ended: {e}")
+            finally:
+                loop.call_soon_threadsafe(ended.set)
+
+        pump_task = asyncio.create_task(asyncio.to_thread(pump))
+        try:
+            # The stream ends when the container stops; watch() reopens it
+            # on the restarted container
+            while not ended.is_set() and self.subscribed():
+                try:
+                    await asyncio.wait_for(arrived.wait(), timeout=self.interval)
+                except asyncio.TimeoutError:
+                    continue
+                arrived.clear()
+                await self.notify()
+                await asyncio.sleep(self.interval)
+        finally:
+            stream.close()
+            await pump_task
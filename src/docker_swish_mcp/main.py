@@ -8,22 +8,524 @@ Main purpose: Enable Claude to use Prolog for logic programming and reasoning.
 
 from __future__ import annotations
 
+import argparse
 import asyncio
 import atexit
+import base64
+import json
 import logging
+import os
+import shutil
 import signal
 import sys
-from collections.abc import AsyncIterator
-from contextlib import asynccontextmanager
-from dataclasses import dataclass
-from pathlib import Path
+import tempfile
+import time
+import uuid
+from collections.abc import AsyncIterator, Awaitable, Callable
+from contextlib import AsyncExitStack, asynccontextmanager
+from dataclasses import asdict, dataclass, field, replace
+from pathlib import Path, PurePosixPath
 from typing import Any
-
-import aiohttp
-from mcp.server.fastmcp import FastMCP
-
-# Import the persistent session manager
-from .simple_session import SimplePrologSession
+from weakref import WeakKeyDictionary
+
+import uvicorn
+from mcp.server.fastmcp import FastMCP, Image
+
+from .answer_limits import cut
+from .audit import MAX_UNDO_CLAUSES, AuditLog, ClauseDelta, database_delta, restore_call
+from .auth import ApiKey, BearerAuthMiddleware, enforce_tool_scopes
+from .batches import BatchResult, batch_call, batch_goals
+from .bundles import (
+    BundleError,
+    bundle_dir_for,
+    export_bundle,
+    install_files,
+    list_bundles,
+    resolve_bundle,
+    verify_bundle,
+)
+from .cancellation import QueryRegistry, RunningQuery, current_query, uncancel
+from .chaos import ChaosMonkey, format_chaos_status, install_chaos
+from .clause_edit import (
+    ClauseSpan,
+    insert_clause,
+    nth_span,
+    parse_spans,
+    predicate_indicator,
+    remove_clauses,
+    replace_clause,
+    retract_targets,
+    spans_call,
+)
+from .collab import (
+    COLLAB_URI_TEMPLATE,
+    DEFAULT_NICKNAME,
+    CollabBridge,
+    CollabError,
+    collab_uri,
+    storage_name,
+)
+from .config import BACKENDS, ContainerSettings, PrintOptions, QueryLimits, ServerConfig
+from .config_watch import ConfigChanges, ConfigWatcher
+from .constraints import (
+    ModelError,
+    format_solution,
+    labeling_options,
+    parse_model,
+    solve_call,
+)
+from .container_env import ContainerEnvironment, Redactor
+from .container_exec import (
+    ContainerExecError,
+    exec_in_container,
+    run_swipl_goal,
+    run_swipl_with_program,
+)
+from .cursors import CursorError, CursorInfo, CursorTable
+from .dashboard import DASHBOARD_LOG_LINES, DASHBOARD_PATH, DashboardMiddleware
+from .data_export import (
+    EXPORT_DIR,
+    EXPORT_FORMATS,
+    INLINE_LIMIT,
+    MAX_EXPORT_ROWS,
+    build_export,
+    export_filename,
+    render,
+)
+from .data_import import (
+    IMPORT_FORMATS,
+    detect_format,
+    fetch_data,
+    format_plan,
+    format_skipped,
+    import_call,
+    parse_columns,
+    plan_import,
+    read_rows,
+)
+from .disk_usage import DiskMonitor, DiskQuotaExceeded, writes_files
+from .drafting import (
+    DEFAULT_CANDIDATES,
+    MAX_CANDIDATES,
+    draft_check_call,
+    draft_prompt,
+    format_drafts,
+    format_schema,
+    has_directive,
+    parse_candidates,
+    parse_draft_check,
+    sample,
+    schema_call,
+)
+from .engines import (
+    POLL_MAX,
+    POLL_START,
+    WAIT_GRACE,
+    EngineJob,
+    engine_alias,
+    format_engines,
+    list_call,
+    new_job_id,
+    poll_call,
+    stop_call,
+    submit_call,
+)
+from .errors import (
+    ERROR_TAG,
+    NOT_READY,
+    ToolError,
+    error_result,
+    from_message,
+    from_prolog,
+)
+from .execution import (
+    EXEC_FALLBACK_AFTER,
+    ExecStrategy,
+    FallbackStrategy,
+    PengineStrategy,
+)
+from .fact_feeds import (
+    FEEDS_LOGGER,
+    FEEDS_URI_TEMPLATE,
+    FactEvent,
+    FactFeed,
+    FactFeeds,
+    feed_document,
+    format_feed_events,
+)
+from .fact_store import (
+    FactStore,
+    FactStoreSettings,
+    SessionFacts,
+    format_store_status,
+    store_unwatch_call,
+)
+from .geospatial import (
+    SPACE_PACK,
+    GeoLayer,
+    GeoLayers,
+    feature_collection,
+    format_matches,
+    load_errors,
+    matched_features,
+    parse_features,
+    query_geometry,
+    validate_layer,
+)
+from .grammars import (
+    format_parse,
+    grammar_program,
+    parse_goal,
+    parse_grammar_output,
+    start_goal,
+)
+from .harness import (
+    Scenario,
+    ScenarioError,
+    ScenarioReport,
+    ephemeral_name,
+    format_report,
+    free_host_port,
+    load_scenario,
+    run_scenario,
+    write_files,
+)
+from .host_platform import daemon_path, has_crlf, normalize_newlines
+from .http_serving import (
+    CorsMiddleware,
+    ForwardedHeadersMiddleware,
+    uvicorn_tls_options,
+)
+from .images import (
+    BUILD_LOG_TAIL,
+    ImageError,
+    PullProgress,
+    emulation_warning,
+    ensure_image,
+    image_present,
+    image_reference,
+    native_platform,
+    offline_message,
+    validate_image,
+)
+from .kb_cleanup import format_pruned, format_retracted, prune_call, retract_call
+from .kb_diff import (
+    LOADED,
+    diff_clauses,
+    file_clauses_call,
+    format_diff,
+    loaded_clauses_call,
+)
+from .kb_graph import (
+    GRAPH_FORMATS,
+    call_graph_dot,
+    fact_graph_dot,
+    graph_call,
+    parse_indicator,
+    render_command,
+)
+from .kb_resources import CONTAINER_DATA_DIR, KnowledgeBaseResources, kb_relative_path
+from .kb_search import (
+    SEARCH_KINDS,
+    collect_hits,
+    compile_pattern,
+    format_hits,
+    search_call,
+)
+from .lifecycle import (
+    ENVIRONMENT_LABEL,
+    NETWORK_LABEL,
+    WantedContainer,
+    adoptable,
+    container_labels,
+    shutdown_container,
+    sweep_orphans,
+)
+from .lint import (
+    format_diagnostics,
+    lint_goal,
+    lint_program_text,
+    parse_lint_output,
+    syntax_call,
+)
+from .local_backend import LocalBackendError, LocalProcessClient
+from .log_stream import (
+    LOGS_URI,
+    MAX_FOLLOW_LINES,
+    RESOURCE_LINES,
+    LogFollower,
+    compile_filter,
+    read_logs,
+    split_lines,
+    stream_options,
+)
+from .metrics import (
+    ServerMetrics,
+    TransportMetricsMiddleware,
+    instrument_tool_calls,
+    query_outcome,
+    result_failed,
+    start_metrics_server,
+)
+from .mock_backend import MockProcessClient
+from .namespaces import ModuleTable, in_module
+from .network_isolation import (
+    INTERNAL_NETWORK,
+    AllowlistProxy,
+    NetworkProfile,
+    container_address,
+    ensure_network,
+    format_network_status,
+    join_network,
+    network_gateway,
+)
+from .notebooks import (
+    NotebookCell,
+    NotebookError,
+    insert_cell,
+    load_notebook,
+    make_cells,
+    new_cell,
+    notebook_path,
+    save_notebook,
+    write_program,
+)
+from .orchestration import InstanceSpec, load_cluster_spec
+from .owl import format_owl_import, owl_content_format, owl_import_call
+from .packs import install_goal, list_goal, parse_pack_list, remove_goal
+from .pengines import PengineError, PengineManager, answer_rows, format_answer
+from .predicate_docs import describe_call, format_descriptions, parse_spec
+from .probabilistic import (
+    CPLINT_PACK,
+    format_probabilities,
+    lpad_program,
+    parse_prob_output,
+    prob_goal,
+)
+from .profiling import SORT_KEYS, format_profile, profile_call, profile_entries
+from .projects import (
+    ProjectError,
+    ProjectManifest,
+    consult_targets,
+    create_project,
+    delete_file,
+    list_projects,
+    load_manifest,
+    project_dir,
+    rename_file,
+    set_load_order,
+    write_file,
+)
+from .prolog_flags import (
+    FLAGS,
+    SessionFlags,
+    flag_rows,
+    flag_value,
+    format_flags,
+    get_flags_call,
+    set_flag_call,
+    set_flag_errors,
+)
+from .prolog_memory import format_stats, parse_size
+from .prompts import (
+    KbContext,
+    constraint_model_prompt,
+    debug_goal_prompt,
+    facts_conversion_prompt,
+    goal_names,
+    listing_goal,
+    undefined_names,
+)
+from .proofs import explain_call, format_explanations, parse_explanations
+from .quarantine import (
+    ConsultMessage,
+    Quarantine,
+    format_messages,
+    format_quarantine,
+    parse_consult,
+    safe_consult_call,
+    vet_call,
+)
+from .query_cache import QueryCache, cacheable, deps_call, loads_code
+from .query_map import MapItem, build_items, format_items, summarize
+from .quotas import QuotaTracker, enforce_quotas
+from .rdf import (
+    RDF_DIR,
+    default_graph,
+    graphs_call,
+    load_call,
+    prolog_atom,
+    query_call,
+    rdf_format,
+    triples_call,
+    validate_rdf_name,
+)
+from .recordings import (
+    Recording,
+    RecordingError,
+    Replay,
+    SessionRecorder,
+    StepOutcome,
+    capture_files,
+    list_recordings,
+    read_recording,
+    recordings_dir_for,
+    replay_workspace_name,
+    resolve_recording,
+    result_difference,
+    result_text,
+    validate_recording_name,
+    write_recording,
+)
+from .remote_sources import RemoteSourceError, fetch_source
+from .repl import (
+    ReplReply,
+    format_reply,
+    parse_input,
+    repl_alias,
+    repl_request,
+    vetted_goal,
+)
+from .repro import (
+    RESULT_PREVIEW,
+    ReproFile,
+    ReproManifest,
+    environment_differences,
+    flag_errors,
+    flags_call,
+    list_repro_bundles,
+    parse_flags,
+    read_repro_bundle,
+    repro_dir_for,
+    resolve_repro_bundle,
+    restore_files,
+    set_flags_call,
+    write_repro_bundle,
+)
+from .request_logging import (
+    CORRELATION_HEADER,
+    LOG_FORMATS,
+    LOG_LEVELS,
+    attach_correlation_ids,
+    configure_logging,
+)
+from .resources import (
+    ContainerResources,
+    format_bytes,
+    format_usage,
+    usage_from_any,
+    was_oom_killed,
+)
+from .result_hooks import ResultHooks
+from .rule_export import (
+    EXPORT_TARGETS,
+    format_rule_export,
+    rule_export_filename,
+    rules_export_call,
+    translate_rules,
+)
+from .runtimes import ContainerRuntime, get_runtime
+from .sandbox import (
+    DATABASE_CATEGORY,
+    SandboxPolicy,
+    SandboxViolation,
+    apply_policy,
+    check_text,
+    policy_guard,
+    require_unrestricted,
+    uses_category,
+)
+from .scasp import (
+    MAX_MODELS,
+    SCASP_PACK,
+    format_answers,
+    parse_scasp_output,
+    scasp_goal,
+    scasp_program,
+)
+from .scheduler import JobRun, QueryScheduler, ScheduledJob, jobs_path
+from .shaping import ResultShape
+from .simple_session import SimplePrologSession, clean_query_text
+from .snapshots import (
+    list_snapshots,
+    resolve_snapshot,
+    restore_container_dir,
+    restore_host_dir,
+    snapshot_container_dir,
+    snapshot_dir_for,
+    snapshot_host_dir,
+)
+from .spill import (
+    RESULTS_URI_TEMPLATE,
+    SAMPLE_SIZE,
+    SpillError,
+    read_result,
+    spill_results,
+)
+from .startup import startup_plan
+from .supervisor import ContainerSupervisor
+from .swish_http import SwishHttp, SwishUnavailable
+from .swish_links import (
+    LINK_KINDS,
+    STORAGE_PATH,
+    editor_link,
+    permalink,
+    storage_payload,
+    with_examples,
+)
+from .sync import CONFLICT_SUFFIX, WorkspaceSync, check_sync_dirs
+from .tabling import (
+    abolish_call,
+    format_table_stats,
+    spec_errors,
+    stats_call,
+    table_call,
+    untable_call,
+)
+from .telemetry import instrument_tool_spans, telemetry
+from .templates import QueryTemplate, TemplateRegistry, templates_path
+from .timetravel import (
+    AsOf,
+    asof_drop_call,
+    asof_load_call,
+    format_notes,
+    replay_database,
+    scratch_module as asof_module,
+)
+from .tool_profiles import PROFILE_HEADER, ToolProfile, enforce_tool_profiles
+from .tool_schemas import describe_tools, enforce_tool_schemas
+from .tracing import build_trace_tree, failed_calls, format_trace
+from .unit_tests import (
+    RUN_WALL_SECONDS,
+    TestResult,
+    format_results,
+    summary,
+    tests_call,
+)
+from .upgrades import (
+    RETIRED_SUFFIX,
+    STANDBY_SUFFIX,
+    KnowledgeState,
+    UpgradeError,
+    parse_state,
+    replay_call,
+    replay_errors,
+    state_call,
+)
+from .volumes import (
+    COPY_DIRECTIONS,
+    VolumeMirror,
+    copy_to_host,
+    copy_to_volume,
+    describe_volumes,
+    ensure_volume,
+    helper_container,
+    prune_volumes,
+    validate_volume_name,
+)
+from .wasm_backend import WasmProcessClient
+from .workers import WorkerPool, WorkerPoolError
+from .workspaces import Workspace, WorkspaceError, WorkspaceRegistry, free_port
 
 # Try to import docker, but don't fail if not available
 try:
@@ -40,15 +542,69 @@ logging.basicConfig(
     stream=sys.stderr
 )
 logger = logging.getLogger("docker-swish-mcp")
-
 # Version info
 __version__ = "0.3.0"
 
+# Global defaults; tools may override limits per call
+server_config = ServerConfig.load()
+configure_logging(server_config.log_level, server_config.log_format)
+# Keeps the container's variable values and secrets out of logs, see container_env.py
+env_redactor = Redactor()
+env_redactor.update(server_config.container.environment)
+env_redactor.install()
+metrics = ServerMetrics()
+
 # Global tracking for cleanup
 running_processes: dict[str, Any] = {}
 background_tasks: set[asyncio.Task] = set()
 global_swish_context: SwishContext | None = None
 
+# HTTP transports enter the lifespan once per client session; these keep a
+# single shared SWISH environment alive across all of them.
+lifespan_lock = asyncio.Lock()
+lifespan_users = 0
+shared_environment: AsyncExitStack | None = None
+keep_environment_alive = False
+
+# Module each client's session goals run in, see namespaces.py
+client_modules = ModuleTable()
+query_cache = QueryCache(server_config.cache_size)
+quota_tracker = QuotaTracker(server_config.quotas)
+disk_monitor = DiskMonitor(server_config.disk)
+chaos_monkey = ChaosMonkey(server_config.chaos)
+# Queries execute_prolog_query is running, which cancel_query can stop
+running_queries = QueryRegistry()
+# Post-processors of query solutions registered by embedders, see result_hooks.py
+result_hooks = ResultHooks()
+# Tool calls being recorded, see recording_start()
+session_recorder = SessionRecorder()
+# Recordings replayed a step at a time, by the name replay_session was given
+step_replays: dict[str, Replay] = {}
+
+# Watches SWISH_MCP_CONFIG once the environment is up
+config_watcher: ConfigWatcher | None = None
+# Mirrors SWISH_MCP_SYNC_DIR with the data directory once the environment is up
+workspace_sync: WorkspaceSync | None = None
+# Mirrors the data volume with the data directory, when there is one
+volume_mirror: VolumeMirror | None = None
+# Lets containers with the allowlist network profile reach the allowed hosts
+network_proxy: AllowlistProxy | None = None
+# Workspaces saved by workspace_create(), loaded once the environment is up
+workspace_registry: WorkspaceRegistry | None = None
+# Predicates mirrored to SWISH_MCP_FACT_STORE, opened once the environment is up
+fact_store: FactStore | None = None
+# Seconds a container recreation waits for running queries to finish; later ones are killed
+RECREATE_GRACE_SECONDS = 60
+recreate_lock = asyncio.Lock()
+
+
+def new_worker_pool() -> WorkerPool:
+    return WorkerPool(
+        server_config.max_workers,
+        server_config.max_workers_per_client,
+        server_config.max_queued_queries
+    )
+
 
 @dataclass
 class SwishContext:
@@ -62,6 +618,63 @@ class SwishContext:
     docker_available: bool = False
     container_ready: bool = False
     prolog_session: SimplePrologSession | None = None
+    pengines: PengineManager | None = None
+    supervisor: ContainerSupervisor | None = None
+    runtime: ContainerRuntime | None = None
+    cursors: CursorTable = field(default_factory=CursorTable)
+    # Fact feeds hooked in prolog_session, see fact_feed_subscribe()
+    fact_feeds: FactFeeds = field(default_factory=FactFeeds)
+    # Stored predicates loaded into prolog_session, see load_stored_facts()
+    stored_facts: SessionFacts = field(default_factory=SessionFacts)
+    # Geo layers indexed in prolog_session, see geo_load()
+    geo_layers: GeoLayers = field(default_factory=GeoLayers)
+    # Flags set in prolog_session, see set_flag()
+    prolog_flags: SessionFlags = field(default_factory=SessionFlags)
+    # Files of the data directory whose last consult was refused, see kb_quarantine()
+    quarantine: Quarantine = field(default_factory=Quarantine)
+    # Caps queries run concurrently on pengines against this container
+    workers: WorkerPool = field(default_factory=new_worker_pool)
+    # Named instances brought up from a cluster spec, keyed by instance name
+    instances: dict[str, SwishContext] = field(default_factory=dict)
+    # Change log of data_dir, see audit_log()
+    audit: AuditLog | None = None
+    # Image reference to run; "" uses the runtime's default image
+    image: str = ""
+    # Dockerfile the image is built from, and when to pull or build it
+    dockerfile: Path | None = None
+    pull_policy: str = "always"
+    # Platform the image is pulled for and the container runs; auto is the daemon's (see images.py)
+    platform: str = "auto"
+    # Why the image could not be pulled or built at the last start, shown by swish_status
+    image_error: str = ""
+    # Why the image runs emulated, shown by swish_status; "" when it runs natively
+    platform_warning: str = ""
+    # "local" when the session runs a swipl on this machine instead of the container
+    backend: str = "container"
+    # Limits the container is started with, and how often it ran out of memory
+    resources: ContainerResources = field(default_factory=ContainerResources)
+    oom_kills: int = 0
+    # Named volume mounted at /data instead of data_dir, mirrored with it (see volumes.py)
+    volume: str = ""
+    # Network the container is attached to (see network_isolation.py)
+    network: NetworkProfile = field(default_factory=NetworkProfile)
+    # Variables and secret files passed to the container (see container_env.py)
+    environment: ContainerEnvironment = field(default_factory=ContainerEnvironment)
+    # Setup of the packs server_packs() needs, by pack: "installing", "ready" or the error
+    pack_states: dict[str, str] = field(default_factory=dict)
+    # Retrying HTTP client for swish_base_url, see swish_http()
+    http: SwishHttp | None = None
+    # How isolated queries and probes reach Prolog, see execution_strategy()
+    execution: FallbackStrategy | None = None
+    # Workspaces by name (see workspaces.py); each has its own context
+    workspaces: dict[str, SwishContext] = field(default_factory=dict)
+    # For a workspace: its name, and the context whose container it shares, if any
+    workspace: str = ""
+    shared_with: SwishContext | None = None
+    # The data directory as the container sees it
+    container_data_dir: str = CONTAINER_DATA_DIR
+    # Visitor on SWISH's /chat websocket for the files opened with collab_join
+    collab: CollabBridge | None = None
 
 
 def cleanup_processes() -> None:
@@ -88,21 +701,29 @@ def cleanup_processes() -> None:
         except Exception as e:
             logger.debug(f"Prolog session cleanup: {e}")
 
+    # Stop named cluster instances and workspace containers, as the shutdown policy says
+    policy = server_config.shutdown_policy
+    if global_swish_context:
+        workspaces = [w for w in global_swish_context.workspaces.values() if not w.shared_with]
+        for instance in [*global_swish_context.instances.values(), *workspaces]:
+            if instance.container:
+                try:
+                    outcome = shutdown_container(instance.container, policy)
+                    logger.info(f"SWISH instance {instance.container_name} {outcome}")
+                except Exception as e:
+                    logger.debug(f"Instance cleanup: {e}")
+
     # Stop SWISH container if running
     if global_swish_context and global_swish_context.container:
         try:
-            logger.info(f"Stopping SWISH container {global_swish_context.container_name}")
-            # First try graceful stop
-            global_swish_context.container.stop(timeout=5)
-            # Then remove the container
-            global_swish_context.container.remove(force=True)
-            logger.info("SWISH container stopped and removed successfully")
+            outcome = shutdown_container(global_swish_context.container, policy)
+            logger.info(f"SWISH container {global_swish_context.container_name} {outcome}")
         except Exception as e:
             logger.debug(f"Container cleanup: {e}")
             # Try to force remove if graceful stop failed
             try:
-                if global_swish_context.docker_available and docker:
-                    client = docker.from_env()
+                if policy == "remove" and global_swish_context.docker_available and global_swish_context.docker_client:
+                    client = global_swish_context.docker_client
                     container = client.containers.get(global_swish_context.container_name)
                     container.remove(force=True)
             except Exception as e2:
@@ -120,6 +741,75 @@ def signal_handler(signum: int, frame: Any) -> None:
     sys.exit(0)
 
 
+def record_fact_change(context: SwishContext, payload: str) -> None:
+    """Keep a change a fact feed reported and tell its subscribers, without holding up the query."""
+    recorded = context.fact_feeds.record(payload)
+    if recorded is not None:
+        track_background_task(asyncio.create_task(notify_fact_change(*recorded)))
+
+
+async def notify_fact_change(feed: FactFeed, event: FactEvent) -> None:
+    await kb_resources.notify_updated(feed.uri)
+    if feed.session is None:
+        return
+    try:
+        await feed.session.send_log_message(
+            level="info", data={"feed_id": feed.feed_id, **event.to_json()}, logger=FEEDS_LOGGER
+        )
+    except Exception as e:
+        logger.debug(f"Fact feed {feed.feed_id} lost its client: {e}")
+        feed.session = None
+
+
+def record_stored_fact(context: SwishContext, payload: str) -> None:
+    if fact_store is not None:
+        fact_store.record(context.stored_facts, payload)
+
+
+async def load_stored_facts(context: SwishContext) -> None:
+    """Load the fact store's predicates the session lacks, or has outdated copies of, before a query."""
+    session = context.prolog_session
+    if fact_store is None or session is None or not session.session_active:
+        return
+    try:
+        loaded = await fact_store.prepare(
+            context.stored_facts, session.generation, client_module(), lambda call: run_json_helper(context, call)
+        )
+    except Exception as e:
+        logger.warning(f"⚠️ Could not load stored facts: {e}")
+        return
+    if loaded:
+        logger.info(f"🗄️ Loaded stored predicates {', '.join(loaded)}")
+
+
+def session_restore_calls(context: SwishContext) -> list[tuple[str, list[str]]]:
+    """Helper calls putting back what lives in a context's Prolog process: feed hooks, geo indexes and flags."""
+    return [
+        *context.fact_feeds.watch_calls(), *context.geo_layers.load_calls(), *context.prolog_flags.restore_calls(),
+    ]
+
+
+def new_prolog_session(context: SwishContext) -> SimplePrologSession:
+    """A persistent session for a context, reporting predicate changes to the query cache."""
+    # Workspaces sharing a container keep their session in their own directory
+    working_dir = context.container_data_dir if context.shared_with and context.backend != "local" else ""
+    session = SimplePrologSession(context.container_name, context.docker_client, working_dir)
+    bind_session_callbacks(session, context)
+    return session
+
+
+def bind_session_callbacks(session: SimplePrologSession, context: SwishContext) -> None:
+    """Point a session's callbacks at the context it serves, e.g. after a standby takes over."""
+    session.on_invalidate = lambda dep: query_cache.invalidate(cache_scope(context), dep)
+    session.on_fact = lambda payload: record_fact_change(context, payload)
+    session.on_store = lambda payload: record_stored_fact(context, payload)
+    session.restore = lambda: session_restore_calls(context)
+    session.on_cpu = lambda seconds: quota_tracker.charge_cpu(quota_client_id(), seconds)
+    session.on_clauses = lambda count: quota_tracker.charge_clauses(quota_client_id(), count)
+    session.startup = lambda: startup_plan(server_config.startup, context.data_dir)
+    session.swipl_options = lambda: server_config.prolog.swipl_options()
+
+
 async def start_swish_container(context: SwishContext) -> bool:
     """
     Start SWISH container automatically with proper cleanup and port management.
@@ -133,6 +823,9 @@ async def start_swish_container(context: SwishContext) -> bool:
             return False
 
         docker_client = context.docker_client
+        runtime = context.runtime or get_runtime("docker")
+        image = context_image(context)
+        container = None
 
         # Ensure data directory exists (mount the swish-data directly)
         data_path = context.data_dir  # Mount swish-data/ to /data in container
@@ -145,83 +838,136 @@ async def start_swish_container(context: SwishContext) -> bool:
 
             if existing.status == "running":
                 # Check if it's responsive
-                try:
-                    async with aiohttp.ClientSession() as session:
-                        async with session.get(
-                            f"{context.swish_base_url}/",
-                            timeout=aiohttp.ClientTimeout(total=2)
-                        ) as response:
-                            if response.status == 200:
-                                logger.info("✅ Existing SWISH container is working, reusing it")
-                                context.container = existing
-                                context.container_ready = True
-                                return True
-                except Exception:
-                    logger.info("Existing container not responsive, will replace it")
+                point_at_network(context, existing)
+                labels = existing.attrs.get("Config", {}).get("Labels") or {}
+                same_network = labels.get(NETWORK_LABEL, "bridge") == str(context.network)
+                # Variables are fixed when a container is created, so new ones need a new container
+                same_network = same_network and labels.get(ENVIRONMENT_LABEL, "") == str(context.environment)
+                if same_network and await execution_strategy(context).probe(timeout=2, fallback=not context.network.has_http):
+                    logger.info("✅ Existing SWISH container is working, reusing it")
+                    context.container = existing
+                    context.container_ready = True
+                    return True
+                logger.info("Existing container not responsive, will replace it")
 
                 # Stop and remove unresponsive container
                 existing.stop(timeout=5)
+            elif server_config.orphan_policy == "adopt" and adoptable(existing, wanted_container(context)):
+                # Left stopped by an earlier run (SWISH_MCP_SHUTDOWN_POLICY=stop)
+                logger.info(f"♻️ Adopting stopped container {context.container_name}")
+                try:
+                    await asyncio.to_thread(existing.start)
+                    container = existing
+                except Exception as e:
+                    logger.warning(f"Could not restart {context.container_name}, replacing it: {e}")
 
-            # Remove any existing container (stopped or unresponsive)
-            existing.remove(force=True)
-            logger.info(f"Removed existing container: {context.container_name}")
+            if container is None:
+                # Remove any existing container (stopped or unresponsive)
+                existing.remove(force=True)
+                logger.info(f"Removed existing container: {context.container_name}")
 
         except Exception as e:
             logger.debug(f"No existing container found: {e}")
 
-        # Check for port conflicts and clean them up
-        try:
-            # List all containers using our port
-            all_containers = docker_client.containers.list(all=True)
-            for container in all_containers:
-                if container.ports and any(
-                    binding and str(context.port) in str(binding)
-                    for bindings in container.ports.values()
-                    for binding in (bindings or [])
-                ):
-                    if container.name != context.container_name:
-                        logger.warning(f"Port {context.port} in use by container {container.name}, stopping it")
-                        try:
-                            if container.status == "running":
-                                container.stop(timeout=5)
-                            container.remove(force=True)
-                        except Exception as e:
-                            logger.warning(f"Could not remove conflicting container: {e}")
-        except Exception as e:
-            logger.debug(f"Port conflict check failed: {e}")
+        if container is None:
+            # Check for port conflicts and clean them up
+            try:
+                # List all containers using our port
+                all_containers = docker_client.containers.list(all=True)
+                for other in all_containers:
+                    if other.ports and any(
+                        binding and str(context.port) in str(binding)
+                        for bindings in other.ports.values()
+                        for binding in (bindings or [])
+                    ):
+                        if other.name != context.container_name:
+                            logger.warning(f"Port {context.port} in use by container {other.name}, stopping it")
+                            try:
+                                if other.status == "running":
+                                    other.stop(timeout=5)
+                                other.remove(force=True)
+                            except Exception as e:
+                                logger.warning(f"Could not remove conflicting container: {e}")
+            except Exception as e:
+                logger.debug(f"Port conflict check failed: {e}")
 
-        # Pull latest image
-        logger.info("Ensuring SWISH image is available...")
-        try:
-            docker_client.images.pull("swipl/swish:latest")
-        except Exception as e:
-            logger.warning(f"Could not pull latest image: {e}")
-
-        # Container configuration for automatic management
-        container_config = {
-            "image": "swipl/swish:latest",
-            "name": context.container_name,
-            "ports": {"3050/tcp": context.port},
-            "volumes": {str(data_path): {"bind": "/data", "mode": "rw"}},
-            "detach": True,
-            "remove": False,
-            "environment": {},
-            "labels": {
-                "managed-by": "docker-swish-mcp",
-                "mcp-version": __version__,
-                "auto-managed": "true"
-            },
-            "restart_policy": {"Name": "no"}  # Don't auto-restart
-        }
+            # Pull or build the image as the pull policy says
+            logger.info(f"Ensuring SWISH image {image} is available...")
+            offline = server_config.offline == "on"
+            try:
+                action, _ = await asyncio.to_thread(
+                    ensure_image, docker_client, image, context.dockerfile, context.pull_policy, offline=offline,
+                    platform=context.platform
+                )
+                logger.info(f"Image {image}: {action}")
+                context.image_error = ""
+                context.platform_warning = await asyncio.to_thread(
+                    lambda: emulation_warning(docker_client, image, native_platform(docker_client))
+                )
+                if context.platform_warning:
+                    logger.warning(f"🐢 {context.platform_warning}")
+            except ImageError as e:
+                context.image_error = str(e)
+                if offline:
+                    # Running it would only try the registry again
+                    logger.error(f"❌ {e}")
+                    return False
+                logger.warning(f"{e}")
+
+            # A named volume, when configured, stands in for the host data directory
+            if context.volume:
+                try:
+                    if await asyncio.to_thread(ensure_volume, docker_client, context.volume):
+                        logger.info(f"📦 Created data volume {context.volume}")
+                except Exception as e:
+                    # The runtime creates it, unlabelled, on run
+                    logger.warning(f"⚠️ Could not create data volume {context.volume}: {e}")
 
-        # Start container
-        logger.info(f"Starting SWISH container on port {context.port}...")
-        container = docker_client.containers.run(**container_config)
+            # Better not to start than to start with more network than configured
+            try:
+                network_options = await prepare_network(context)
+            except Exception as e:
+                logger.error(f"❌ Could not set up the {context.network.mode} network profile: {e}")
+                return False
+
+            # Container configuration for automatic management
+            container_config = {
+                "image": image,
+                "name": context.container_name,
+                "ports": {"3050/tcp": context.port} if context.network.publishes_port else {},
+                "volumes": {
+                    context.volume or daemon_path(data_path, server_config.host_paths): {
+                        "bind": "/data", "mode": runtime.volume_mode
+                    },
+                    **{
+                        daemon_path(Path(path), server_config.host_paths): bind
+                        for path, bind in context.environment.mounts(runtime.volume_mode.replace("rw", "ro")).items()
+                    }
+                },
+                "detach": True,
+                "remove": False,
+                # The proxy variables of the network profile win over configured ones
+                "environment": context.environment.variables | network_options.pop("environment", {}),
+                "labels": container_labels(
+                    __version__, context.port, data_path, str(context.resources), context.volume, str(context.network),
+                    str(context.environment)
+                ),
+                "restart_policy": {"Name": "no"},  # Don't auto-restart
+                # auto runs whichever platform the image was pulled for
+                **({"platform": context.platform} if context.platform != "auto" else {}),
+                **context.resources.run_options(),
+                **network_options
+            }
+
+            # Start container
+            logger.info(f"Starting SWISH container on port {context.port}...")
+            container = docker_client.containers.run(**container_config)
         context.container = container
+        point_at_network(context, container)
 
         # Wait for container to be ready
         max_wait = 30
-        for _ in range(max_wait):
+        for waited in range(max_wait):
             try:
                 # Refresh container status
                 container.reload()
@@ -232,28 +978,24 @@ async def start_swish_container(context: SwishContext) -> bool:
                     logger.error(f"Container logs: {logs}")
                     return False
 
-                # Check if SWISH is responding
-                async with aiohttp.ClientSession() as session:
-                    async with session.get(
-                        f"{context.swish_base_url}/",
-                        timeout=aiohttp.ClientTimeout(total=2)
-                    ) as response:
-                        if response.status == 200:
-                            context.container_ready = True
-                            logger.info(f"✅ SWISH container ready at {context.swish_base_url}")
-
-                            # Initialize persistent Prolog session
-                            logger.info("🧠 Initializing persistent Prolog session...")
-                            context.prolog_session = SimplePrologSession(context.container_name)
-                            session_started = await context.prolog_session.start_session()
-
-                            if session_started:
-                                logger.info("✅ Persistent Prolog session ready")
-                            else:
-                                logger.warning("⚠️ Failed to start persistent Prolog session")
-                                logger.warning("Queries will fall back to individual execution mode")
-
-                            return True
+                # Check if SWISH is responding, or at least runs queries with docker exec
+                fallback = waited >= EXEC_FALLBACK_AFTER or not context.network.has_http
+                if await execution_strategy(context).probe(timeout=2, fallback=fallback):
+                    context.container_ready = True
+                    logger.info(f"✅ SWISH container ready at {context.swish_base_url}")
+
+                    # Initialize persistent Prolog session
+                    logger.info("🧠 Initializing persistent Prolog session...")
+                    context.prolog_session = new_prolog_session(context)
+                    session_started = await context.prolog_session.start_session()
+
+                    if session_started:
+                        logger.info("✅ Persistent Prolog session ready")
+                    else:
+                        logger.warning("⚠️ Failed to start persistent Prolog session")
+                        logger.warning("Queries will fall back to individual execution mode")
+
+                    return True
             except Exception as e:
                 logger.debug(f"Waiting for container readiness: {e}")
 
@@ -276,26 +1018,112 @@ async def start_swish_container(context: SwishContext) -> bool:
         return False
 
 
+async def start_local_session(context: SwishContext) -> bool:
+    """
+    Start the persistent session on the local swipl (--backend=local).
+
+    Returns:
+        True if the session started, False otherwise
+    """
+    client = context.docker_client
+    if not isinstance(client, LocalProcessClient):
+        return False
+    # The data directory may have moved with a config reload
+    context.data_dir.mkdir(parents=True, exist_ok=True)
+    client.data_dir = context.data_dir
+    logger.info(f"🧠 Starting local SWI-Prolog {client.version_text} in {context.data_dir}...")
+    context.prolog_session = new_prolog_session(context)
+    if not await context.prolog_session.start_session():
+        logger.error("Failed to start the local Prolog session")
+        context.container_ready = False
+        return False
+    context.container_ready = True
+    logger.info("✅ Local Prolog session ready")
+    return True
+
+
 @asynccontextmanager
 async def app_lifespan(server: FastMCP) -> AsyncIterator[SwishContext]:
+    """
+    Share one SWISH environment between all MCP sessions.
+
+    With stdio there is a single session; over HTTP every client session
+    enters the lifespan, so the environment is created by the first one and
+    (unless keep_environment_alive is set) torn down after the last leaves.
+    """
+    global lifespan_users, shared_environment
+
+    async with lifespan_lock:
+        if shared_environment is None:
+            stack = AsyncExitStack()
+            await stack.enter_async_context(swish_environment(server))
+            shared_environment = stack
+        lifespan_users += 1
+
+    try:
+        assert global_swish_context is not None
+        yield global_swish_context
+    finally:
+        async with lifespan_lock:
+            lifespan_users -= 1
+            if lifespan_users == 0 and not keep_environment_alive and shared_environment:
+                await shared_environment.aclose()
+                shared_environment = None
+
+
+@asynccontextmanager
+async def swish_environment(server: FastMCP) -> AsyncIterator[SwishContext]:
     """Manage application lifecycle with automatic SWISH container management"""
-    global global_swish_context
+    global global_swish_context, config_watcher, workspace_sync, workspace_registry, fact_store
 
     logger.info(f"Initializing Docker SWISH MCP Server v{__version__}")
+    telemetry.configure(server_config.otel, server_config.otel_goals, __version__)
+    # Hooks named in SWISH_MCP_RESULT_HOOKS; registering again replaces them
+    result_hooks.load_from_env()
 
     context = None  # Ensure context is always defined
 
     try:
-        # Initialize Docker if available
-        if DOCKER_AVAILABLE and docker:
+        # Connect to the configured container runtime
+        runtime = get_runtime(server_config.runtime, server_config.podman_socket)
+        backend = server_config.backend
+        local_client: LocalProcessClient | None = None
+        if backend == "wasm":
+            # swipl as a WebAssembly module runs like the local one; without it, the container does
+            local_client = WasmProcessClient(
+                server_config.container.data_dir, server_config.wasm_module, server_config.wasm_runtime
+            )
+            try:
+                server_config.container.data_dir.mkdir(parents=True, exist_ok=True)
+                await asyncio.to_thread(local_client.ping)
+                backend = "local"
+                logger.info(f"🕸️ Using the WebAssembly backend: {local_client.engine}")
+            except LocalBackendError as e:
+                logger.warning(f"⚠️ {e}; falling back to the container backend")
+                local_client, backend = None, "container"
+        if backend == "mock":
+            # Neither Docker nor swipl: the in-memory interpreter answers
+            local_client, backend = MockProcessClient(server_config.container.data_dir), "local"
+            server_config.container.data_dir.mkdir(parents=True, exist_ok=True)
+            logger.info(f"🧪 Using the mock backend: {local_client.engine}")
+        if backend == "local":
+            # No container: commands run on the host's swipl
+            if server_config.backend == "local":
+                logger.warning("⚠️ Local backend: queries run on this machine, so every goal goes through the strict sandbox")
+            docker_client = local_client or LocalProcessClient(
+                server_config.container.data_dir, server_config.swipl_path
+            )
+            docker_available = False
+            runtime = None
+        elif (DOCKER_AVAILABLE and docker) or runtime.name == "nerdctl":
             try:
-                docker_client = docker.from_env()
-                # Test Docker connection
+                docker_client = runtime.connect()
+                # Test the connection
                 docker_client.ping()
                 docker_available = True
-                logger.info("✅ Docker client initialized successfully")
+                logger.info(f"✅ {runtime.name} client initialized successfully")
             except Exception as e:
-                logger.warning(f"⚠️ Docker not available: {e}")
+                logger.warning(f"⚠️ {runtime.name} not available: {e}")
                 docker_client = None
                 docker_available = False
         else:
@@ -306,19 +1134,49 @@ async def app_lifespan(server: FastMCP) -> AsyncIterator[SwishContext]:
         # Create context
         context = SwishContext(
             docker_client=docker_client,
-            docker_available=docker_available
+            docker_available=docker_available,
+            runtime=runtime,
+            container_name=server_config.container.name,
+            port=server_config.container.port,
+            data_dir=server_config.container.data_dir,
+            swish_base_url=server_config.container.base_url,
+            image=server_config.container.image,
+            dockerfile=server_config.container.dockerfile,
+            pull_policy=server_config.container.pull_policy,
+            platform=server_config.container.platform,
+            resources=server_config.container.resources,
+            volume=server_config.container.volume,
+            network=server_config.container.network,
+            environment=server_config.container.environment,
+            backend=backend
         )
+        if context.backend != "local":
+            context.pengines = PengineManager(context.swish_base_url, http=swish_http(context))
+        specs = cluster_specs() if docker_available else []
 
         # Ensure data directory exists
-        context.data_dir.mkdir(exist_ok=True)
+        context.data_dir.mkdir(parents=True, exist_ok=True)
         logger.info(f"📁 Data directory: {context.data_dir}")
 
+        if context.backend == "local":
+            logger.info("💻 Using the local SWI-Prolog backend; no container is started")
+            try:
+                await asyncio.to_thread(docker_client.ping)
+                await start_local_session(context)
+            except LocalBackendError as e:
+                logger.warning(f"⚠️ {e}")
+                logger.warning("Prolog queries will not be available")
+            # Relative consults resolve against the data directory itself
+            kb_resources.prolog_data_dir = prolog_data_dir(context)
         # Auto-start SWISH container if Docker is available
-        if docker_available:
+        elif docker_available:
+            # Containers left behind by a crashed run
+            await sweep_orphaned_containers(context, specs)
             logger.info("🚀 Starting SWISH container automatically...")
             success = await start_swish_container(context)
             if success:
                 logger.info("✅ SWISH container started successfully")
+                start_pack_setup(context)
             else:
                 logger.warning("⚠️ Failed to start SWISH container - running in limited mode")
                 logger.warning("Container management and Prolog queries will not be available")
@@ -326,6 +1184,72 @@ async def app_lifespan(server: FastMCP) -> AsyncIterator[SwishContext]:
         # Set global context
         global_swish_context = context
 
+        # Publish knowledge base files as swish://kb/ resources
+        kb_resources.data_dir = context.data_dir
+        await kb_resources.refresh()
+        track_background_task(asyncio.create_task(kb_resources.watch(server_config.kb_poll_interval)))
+
+        # Mirror the host workspace with the data directory
+        if server_config.sync_dir:
+            try:
+                check_sync_dirs(server_config.sync_dir, context.data_dir)
+                workspace_sync = WorkspaceSync(
+                    server_config.sync_dir, context.data_dir, reload_synced_files, server_config.sync_interval
+                )
+                workspace_sync.load()
+                track_background_task(asyncio.create_task(workspace_sync.watch()))
+                logger.info(f"🔁 Syncing workspace {server_config.sync_dir} with {context.data_dir}")
+            except ValueError as e:
+                logger.warning(f"⚠️ {e}; workspace sync is off")
+
+        # Mirror the data volume with the data directory
+        start_volume_mirror(context)
+
+        # Keep designated predicates in SWISH_MCP_FACT_STORE, loaded on each session's first query
+        fact_store = FactStore.from_settings(FactStoreSettings.from_env(), context.data_dir.resolve().parent)
+
+        # Run scheduled queries, including those saved by an earlier run
+        scheduler.load(jobs_path(context.data_dir))
+        track_background_task(asyncio.create_task(scheduler.watch()))
+        query_templates.load(templates_path(context.data_dir))
+
+        # Tell subscribers of swish://container/logs about new output
+        log_follower = LogFollower(
+            lambda: kb_resources.notify_updated(LOGS_URI),
+            lambda: bool(kb_resources.subscribers.get(LOGS_URI))
+        )
+        track_background_task(asyncio.create_task(log_follower.watch(lambda: context.container)))
+
+        # Watch the container so a crash leads to a restart, not silent failures
+        if docker_available:
+            start_supervisor(context)
+
+        # Apply edits to the SWISH_MCP_CONFIG file without a restart
+        if server_config.config_path:
+            config_watcher = ConfigWatcher(
+                server_config.config_path,
+                current=lambda: server_config,
+                apply=lambda new, changes: apply_config(context, new, changes)
+            )
+            track_background_task(asyncio.create_task(config_watcher.watch()))
+
+        # Bring up named instances declared in a cluster spec
+        if specs:
+            try:
+                results = await cluster_up_specs(context, specs)
+                logger.info(f"🧩 Cluster instances: {results}")
+            except Exception as e:
+                logger.warning(f"⚠️ Could not bring up cluster spec: {e}")
+
+        # Bring back the workspaces of earlier runs
+        workspace_registry = WorkspaceRegistry(
+            server_config.workspaces_dir or context.data_dir.resolve().parent / "swish-workspaces"
+        )
+        workspace_registry.load()
+        for workspace in workspace_registry.workspaces.values():
+            state = await open_workspace(context, workspace)
+            logger.info(f"🗂️ Workspace '{workspace.name}': {state}")
+
         logger.info("🧠 MCP Server ready for Prolog interaction")
         if context.container_ready:
             logger.info(f"🌐 SWISH available at: {context.swish_base_url}")
@@ -348,18 +1272,127 @@ async def app_lifespan(server: FastMCP) -> AsyncIterator[SwishContext]:
         # Cleanup on shutdown
         logger.info("🛑 Shutting down Docker SWISH MCP server")
 
-        # Cleanup persistent session first
-        if context and context.prolog_session:
-            try:
-                await context.prolog_session.cleanup()
-                logger.info("✅ Persistent Prolog session cleaned up")
-            except Exception as e:
-                logger.debug(f"Session cleanup error: {e}")
+        # Stop supervisors and sessions before the containers go away
+        if context:
+            await release_instance_resources(context)
+            for instance in [*context.instances.values(), *context.workspaces.values()]:
+                await release_instance_resources(instance)
 
+        await stop_network_proxy()
+        if fact_store is not None:
+            fact_store.close()
+            fact_store = None
         cleanup_processes()
+        telemetry.shutdown()
         global_swish_context = None
 
 
+def cluster_specs() -> list[InstanceSpec]:
+    """Instances declared in SWISH_MCP_CLUSTER_SPEC, if any."""
+    cluster_spec = os.environ.get("SWISH_MCP_CLUSTER_SPEC")
+    if not cluster_spec:
+        return []
+    try:
+        return load_cluster_spec(cluster_spec, Path.cwd())
+    except Exception as e:
+        logger.warning(f"⚠️ Could not load cluster spec: {e}")
+        return []
+
+
+async def sweep_orphaned_containers(context: SwishContext, specs: list[InstanceSpec]) -> None:
+    """Adopt or remove containers whose server is gone, as SWISH_MCP_ORPHAN_POLICY says."""
+    # Cluster instances run the primary container's image
+    wanted = [wanted_container(context)] + [
+        WantedContainer(spec.container_name, context_image(context), spec.port, spec.data_dir, str(context.resources))
+        for spec in specs
+    ]
+    try:
+        report = await asyncio.to_thread(
+            sweep_orphans, context.docker_client, server_config.orphan_policy, wanted
+        )
+    except Exception as e:
+        logger.warning(f"⚠️ Could not look for orphaned containers: {e}")
+        return
+    if report.adopted or report.removed or report.kept:
+        logger.info(f"🧹 Orphaned containers: {report.describe()}")
+
+
+async def prepare_network(context: SwishContext) -> dict[str, Any]:
+    """containers.run() options attaching a context's container to its network, with the proxy it needs."""
+    global network_proxy
+    profile = context.network
+    if not profile.isolated:
+        return profile.run_options()
+    network = await asyncio.to_thread(ensure_network, context.docker_client)
+    own_address = await asyncio.to_thread(join_network, context.docker_client, network)
+    if profile.mode != "allowlist":
+        return profile.run_options()
+    host = own_address or network_gateway(network)
+    if not host:
+        raise ValueError(f"Network {INTERNAL_NETWORK} has no gateway address for the allowlist proxy")
+    if network_proxy and (network_proxy.host, network_proxy.port) != (host, profile.proxy_port):
+        await stop_network_proxy()
+    if network_proxy is None:
+        proxy = AllowlistProxy(profile.allow, host, profile.proxy_port)
+        await proxy.start()
+        network_proxy = proxy
+    network_proxy.allow = profile.allow
+    return profile.run_options(network_proxy.url)
+
+
+async def stop_network_proxy() -> None:
+    global network_proxy
+    if network_proxy:
+        await network_proxy.stop()
+        network_proxy = None
+
+
+def point_at_network(context: SwishContext, container: Any) -> None:
+    """Reach an isolated container's pengine API at its address on the internal network."""
+    if not context.network.isolated:
+        return
+    try:
+        container.reload()
+    except Exception as e:
+        logger.debug(f"Reloading {context.container_name}: {e}")
+    address = container_address(container)
+    if not address:
+        logger.warning(f"⚠️ {context.container_name} has no address on {INTERNAL_NETWORK}; queries use docker exec")
+        return
+    base_url = f"http://{address}:3050"
+    if base_url != context.swish_base_url:
+        context.swish_base_url = base_url
+        context.pengines = PengineManager(context.swish_base_url, http=swish_http(context))
+
+
+def wanted_container(context: SwishContext) -> WantedContainer:
+    """What a context's container runs with, for adopting an orphan in its place."""
+    return WantedContainer(
+        context.container_name, context_image(context), context.port, context.data_dir, str(context.resources),
+        context.volume, str(context.network), str(context.environment)
+    )
+
+
+def context_image(context: SwishContext) -> str:
+    """Image reference a context's container runs."""
+    runtime = context.runtime or get_runtime("docker")
+    return image_reference(context.image, context.dockerfile, runtime.image)
+
+
+def prolog_data_dir(context: SwishContext) -> str:
+    """The data directory as the Prolog session sees it."""
+    if context.backend == "local":
+        return str(context.data_dir.resolve())
+    return context.container_data_dir
+
+
+def cache_scope(context: SwishContext) -> str:
+    """Query cache scope of a context's session; shared-container workspaces get their own."""
+    if context.shared_with:
+        return f"{context.container_name}#{context.workspace}"
+    return context.container_name
+
+
 def refresh_container_reference(context: SwishContext) -> bool:
     """Refresh the container reference in case it was recreated"""
     try:
@@ -383,18 +1416,693 @@ def refresh_container_reference(context: SwishContext) -> bool:
         return False
 
 
-def get_context() -> SwishContext:
-    """Get current context with proper error handling"""
+def get_context(instance: str = "") -> SwishContext:
+    """
+    Get current context with proper error handling.
+
+    Args:
+        instance: Name of a cluster instance or workspace; empty for the primary container
+    """
     if global_swish_context is None:
         raise RuntimeError("SWISH context not initialized. Server may not be properly started.")
 
+    context = global_swish_context
+    if instance:
+        if instance in global_swish_context.instances:
+            context = global_swish_context.instances[instance]
+        elif instance in global_swish_context.workspaces:
+            context = global_swish_context.workspaces[instance]
+        else:
+            raise RuntimeError(
+                f"Unknown SWISH instance or workspace '{instance}'. "
+                "Use cluster_status() or workspace_list() to list them."
+            )
+
+    # A shared-container workspace is ready whenever its container is
+    if context.shared_with:
+        context.container = context.shared_with.container
+        context.container_ready = context.shared_with.container_ready
+
     # Auto-refresh container reference if needed
-    if (global_swish_context.docker_available and
-        global_swish_context.container and
-        not global_swish_context.container_ready):
-        refresh_container_reference(global_swish_context)
+    if (context.docker_available and
+        context.container and
+        not context.container_ready):
+        refresh_container_reference(context)
+
+    return context
+
+
+def swish_http(context: SwishContext) -> SwishHttp:
+    """The context's HTTP client, recreated if its base URL changed."""
+    if context.http is None or context.http.base_url != context.swish_base_url.rstrip("/"):
+        context.http = SwishHttp(context.swish_base_url, server_config.swish_http)
+    return context.http
+
+
+def collab_bridge(context: SwishContext, nickname: str = "") -> CollabBridge:
+    """The context's collaboration bridge, recreated if its HTTP client changed."""
+    http = swish_http(context)
+    if context.collab is None or context.collab.http is not http:
+        context.collab = CollabBridge(http, nickname or DEFAULT_NICKNAME, kb_resources.notify_updated)
+    return context.collab
+
+
+def execution_strategy(context: SwishContext) -> FallbackStrategy:
+    """The context's execution strategy, recreated if its HTTP client or container changed."""
+    http = swish_http(context)
+    strategy = context.execution
+    if (strategy is None or strategy.exec.container_name != context.container_name
+            or (strategy.http is not None and strategy.http.http is not http)):
+        pengines = None if context.backend == "local" else PengineStrategy(http, lambda: context.pengines)
+        context.execution = FallbackStrategy(
+            server_config.execution, pengines, ExecStrategy(context.docker_client, context.container_name)
+        )
+    return context.execution
+
+
+async def probe_swish(context: SwishContext) -> bool:
+    """Check that SWISH answers HTTP requests, or (see SWISH_MCP_EXECUTION) runs goals with docker exec."""
+    return await execution_strategy(context).probe(timeout=3)
+
+
+async def restart_swish_container(context: SwishContext) -> bool:
+    """Recreate a context's container and its Prolog session."""
+    logger.info(f"🔄 Restarting SWISH container {context.container_name}")
+    context.container_ready = False
+    if context.container and await asyncio.to_thread(was_oom_killed, context.container):
+        context.oom_kills += 1
+        logger.warning(
+            f"⚠️ {context.container_name} ran out of memory ({context.resources or 'no limits'}); "
+            "consider a higher SWISH_MCP_MEMORY or smaller queries"
+        )
+    if context.prolog_session:
+        try:
+            await context.prolog_session.cleanup()
+        except Exception as e:
+            logger.debug(f"Session cleanup error: {e}")
+        context.prolog_session = None
+    if context.pengines:
+        # Pengines lived in the old SWISH process
+        context.pengines.pengines.clear()
+    if context.backend == "local":
+        success = await start_local_session(context)
+    else:
+        success = await start_swish_container(context)
+        if success:
+            start_pack_setup(context)
+    metrics.container_restarts.inc(container=context.container_name, result="success" if success else "failure")
+    return success
+
+
+def server_packs() -> list[str]:
+    """Packs the configuration needs in every container."""
+    packs = []
+    if server_config.probabilistic == "cplint":
+        packs.append(CPLINT_PACK)
+    if server_config.scasp == "on":
+        packs.append(SCASP_PACK)
+    if server_config.geo == "on":
+        packs.append(SPACE_PACK)
+    return packs
+
+
+async def ensure_server_packs(context: SwishContext, packs: list[str]) -> None:
+    """Install the packs missing from a context's container."""
+    for pack in packs:
+        context.pack_states[pack] = "installing"
+    try:
+        code, stdout, stderr = await run_swipl_goal(context.docker_client, context.container_name, list_goal())
+        if code != 0:
+            raise RuntimeError(f"could not list packs: {stderr.strip()}")
+        installed = {p["name"] for p in parse_pack_list(stdout)}
+    except Exception as e:
+        for pack in packs:
+            context.pack_states[pack] = str(e)
+        logger.warning(f"⚠️ Could not check packs in {context.container_name}: {e}")
+        return
+    for pack in packs:
+        try:
+            if pack not in installed:
+                logger.info(f"📦 Installing the {pack} pack in {context.container_name}")
+                code, stdout, stderr = await run_swipl_goal(
+                    context.docker_client, context.container_name, install_goal(pack), timeout=900
+                )
+                if code != 0:
+                    raise RuntimeError(f"pack_install({pack}) failed: {(stderr or stdout).strip()}")
+            context.pack_states[pack] = "ready"
+            logger.info(f"✅ Pack {pack} is available in {context.container_name}")
+        except asyncio.TimeoutError:
+            context.pack_states[pack] = f"pack_install({pack}) timed out"
+            logger.warning(f"⚠️ {context.pack_states[pack]}")
+        except Exception as e:
+            context.pack_states[pack] = str(e)
+            logger.warning(f"⚠️ Pack {pack} is unavailable: {e}")
+
+
+def start_pack_setup(context: SwishContext) -> None:
+    """Install the packs server_packs() names in the background."""
+    if context.backend == "local":
+        return
+    packs = [pack for pack in server_packs() if context.pack_states.get(pack) != "installing"]
+    if packs:
+        track_background_task(asyncio.create_task(ensure_server_packs(context, packs)))
+
+
+def pack_unavailable(context: SwishContext, pack: str, variable: str, value: str) -> str:
+    """Why a tool that needs pack cannot run yet, or "" if it can."""
+    if pack not in server_packs():
+        return f"❌ This tool is off. Start the server with {variable}={value}."
+    state = context.pack_states.get(pack, "")
+    if state == "installing":
+        return f"⏳ The {pack} pack is still being installed. Please try again in a few minutes."
+    if state != "ready":
+        return f"❌ The {pack} pack is unavailable: {state or 'it is not installed'}"
+    return ""
+
+
+async def chaos_restart(arguments: dict[str, Any]) -> None:
+    """Restart the container of a tool call's instance, for a restart chaos mode injects."""
+    context = get_context(str(arguments.get("instance", "")))
+    if context.container_ready:
+        await restart_swish_container(context)
+
+
+def start_supervisor(context: SwishContext) -> None:
+    """Start health supervision for a context, unless disabled by config."""
+    if server_config.health_interval <= 0:
+        return
+    if context.supervisor is None:
+        context.supervisor = ContainerSupervisor(
+            context.container_name,
+            probe=lambda: probe_swish(context),
+            restart=lambda: restart_swish_container(context),
+            interval=server_config.health_interval
+        )
+    track_background_task(context.supervisor.start())
+
+
+def queries_running_on(context: SwishContext) -> list[RunningQuery]:
+    """Queries running on a context's container right now, including workspaces sharing it."""
+    if global_swish_context is None:
+        return []
+    contexts = {"": global_swish_context, **global_swish_context.instances, **global_swish_context.workspaces}
+    running = []
+    for query in running_queries.running.values():
+        target = contexts.get(query.instance)
+        if target is not None and (target is context or target.shared_with is context):
+            running.append(query)
+    return running
+
+
+async def wait_for_queries(context: SwishContext, what: str) -> list[str]:
+    """
+    Wait up to RECREATE_GRACE_SECONDS for a context's running queries to finish.
+
+    Returns a note on the queries still running then, which what (e.g.
+    "recreating the container") is about to kill; empty if none are.
+    """
+    for _ in range(RECREATE_GRACE_SECONDS):
+        if context.workers.running_total == 0 and not queries_running_on(context):
+            return []
+        await asyncio.sleep(1)
+    left = queries_running_on(context)
+    if not left and context.workers.running_total == 0:
+        return []
+    count = max(len(left), context.workers.running_total)
+    note = f"⚠️ {count} quer{'y' if count == 1 else 'ies'} still running after {RECREATE_GRACE_SECONDS}s, killed by {what}"
+    logger.warning(note + "".join(f"; {query.describe()}" for query in left))
+    return [note, *(f"  • {query.describe()}" for query in left)]
+
+
+async def recreate_swish_container(
+    context: SwishContext,
+    settings: ContainerSettings | None = None,
+    notes: list[str] | None = None
+) -> bool:
+    """
+    Move a context's container to new settings once its running queries are done.
+
+    Without settings the container is recreated as it is configured, e.g.
+    to run a rebuilt image. Queries still running after
+    RECREATE_GRACE_SECONDS are killed with the old container; notes, if
+    given, gets a line on each.
+    """
+    async with recreate_lock:
+        if context.supervisor:
+            await context.supervisor.stop()
+        killed = await wait_for_queries(context, "recreating the container")
+        if notes is not None:
+            notes.extend(killed)
+        if context.container:
+            try:
+                # Stopped first so start_swish_container does not reuse it
+                await asyncio.to_thread(context.container.stop, timeout=5)
+            except Exception as e:
+                logger.debug(f"Stopping container for recreation: {e}")
+        if settings:
+            context.port = settings.port
+            context.swish_base_url = settings.base_url
+            context.data_dir = settings.data_dir
+            context.image = settings.image
+            context.dockerfile = settings.dockerfile
+            context.pull_policy = settings.pull_policy
+            context.platform = settings.platform
+            context.resources = settings.resources
+            context.volume = settings.volume
+            context.network = settings.network
+            context.environment = settings.environment
+            if context is global_swish_context:
+                env_redactor.update(settings.environment)
+            if context is global_swish_context and settings.network.mode != "allowlist":
+                await stop_network_proxy()
+            if context.backend == "local":
+                kb_resources.prolog_data_dir = prolog_data_dir(context)
+            else:
+                context.pengines = PengineManager(context.swish_base_url, http=swish_http(context))
+            # The audit log belongs to the data directory
+            context.audit = None
+            kb_resources.data_dir = context.data_dir
+            if workspace_sync and context is global_swish_context:
+                workspace_sync.set_data_dir(context.data_dir)
+            if context is global_swish_context:
+                start_volume_mirror(context)
+        success = await restart_swish_container(context)
+        if context.docker_available:
+            start_supervisor(context)
+        await refresh_kb_resources()
+        return success
+
+
+async def capture_kb_state(context: SwishContext) -> KnowledgeState:
+    """What a context's session has loaded, to replay elsewhere; empty without a session."""
+    state = KnowledgeState()
+    if not context.prolog_session or not context.prolog_session.session_active:
+        return state
+    state.files, modules = parse_state(await run_json_helper(context, state_call(prolog_data_dir(context))))
+    for module in modules:
+        rows = await run_json_helper(context, ("mcp_db_snapshot", [prolog_atom(module)]))
+        if rows:
+            state.databases[module] = rows
+    return state
+
+
+async def repro_environment(context: SwishContext, flags: dict[str, str]) -> dict[str, Any]:
+    """What a context runs Prolog on, for reproduce bundles."""
+    environment: dict[str, Any] = {"backend": context.backend, "server": __version__, "swipl": flags.get("version", "")}
+    if context.backend == "local":
+        environment["engine"] = context.docker_client.engine
+    elif context.container is not None:
+        try:
+            image = await asyncio.to_thread(lambda: context.container.image)
+            environment.update(
+                image=context_image(context), image_id=image.id, repo_digests=image.attrs.get("RepoDigests") or []
+            )
+        except Exception as e:
+            logger.debug(f"Could not inspect the container image: {e}")
+    return environment
+
+
+async def capture_repro(
+    context: SwishContext,
+    goal: str,
+    module: str,
+    output_format: str,
+    limits: QueryLimits,
+    printing: PrintOptions
+) -> tuple[ReproManifest, dict[str, bytes]]:
+    """The manifest and file contents of a reproduce bundle for goal, before it runs."""
+    state = await capture_kb_state(context)
+    flags = parse_flags(await run_json_helper(context, flags_call()))
+    root = PurePosixPath(prolog_data_dir(context))
+    files, contents = [], {}
+    for path, file_module in state.files:
+        relative = PurePosixPath(path).relative_to(root).as_posix()
+        data = await asyncio.to_thread(context.data_dir.joinpath(*PurePosixPath(relative).parts).read_bytes)
+        files.append(ReproFile.of(relative, file_module, data))
+        contents[relative] = data
+    manifest = ReproManifest(
+        goal, module, output_format, asdict(limits), asdict(printing),
+        await repro_environment(context, flags), flags, files, state.databases
+    )
+    return manifest, contents
+
+
+async def attach_repro_bundle(
+    context: SwishContext,
+    manifest: ReproManifest,
+    contents: dict[str, bytes],
+    result: str
+) -> str:
+    """Write the reproduce bundle of a query's result and say where it is."""
+    manifest.record_result(result)
+    try:
+        await check_disk(context, "Writing the reproduce bundle", sum(len(content) for content in contents.values()))
+        bundle = await asyncio.to_thread(write_repro_bundle, context.data_dir, manifest, contents)
+    except (OSError, DiskQuotaExceeded) as e:
+        logger.warning(f"Could not write reproduce bundle: {e}")
+        return result if manifest.output_format == "json" else f"{result}\n\n⚠️ Could not write the reproduce bundle: {e}"
+    if manifest.output_format == "json":
+        document = json.loads(result)
+        document["reproduce_bundle"] = str(bundle)
+        return json.dumps(document, indent=2)
+    return f"""{result}
+
+🧾 Reproduce bundle: {bundle} ({manifest.summary()})
+🔁 Replay with: replay("{bundle.name}")"""
+
+
+async def prepare_query_tables(context: SwishContext, module: str, tabled: list[str], abolish_tables: bool) -> str:
+    """Table predicates and abolish tables before a query; returns why that failed, else ""."""
+    call = table_call(module, tabled) if tabled else None
+    if abolish_tables:
+        await run_json_helper(context, abolish_call(module))
+    if call:
+        errors = spec_errors(await run_json_helper(context, call))
+        if errors:
+            return "❌ Could not table predicates for the query:\n" + "\n".join(f"  • {error}" for error in errors)
+        query_cache.clear(cache_scope(context))
+    return ""
+
+
+async def replay_kb_state(context: SwishContext, state: KnowledgeState) -> list[str]:
+    """Load state into a context's session; returns what could not be replayed."""
+    errors = []
+    if state.files:
+        errors.extend(replay_errors(await run_json_helper(context, replay_call(state.files))))
+    for module, rows in state.databases.items():
+        try:
+            await run_json_helper(context, restore_call(rows, module))
+        except RuntimeError as e:
+            errors.append(f"{module} database: {e}")
+    return errors
+
+
+async def upgrade_swish_container(context: SwishContext, image: str, force: bool = False) -> list[str]:
+    """
+    Move a context's container to image through a warm standby (see upgrades.py).
+
+    Returns:
+        Report lines
+
+    Raises:
+        UpgradeError: if the standby did not come up or take the state over;
+            the old container is left serving
+    """
+    async with recreate_lock:
+        lines = []
+        standby = SwishContext(
+            docker_client=context.docker_client,
+            runtime=context.runtime,
+            docker_available=context.docker_available,
+            container_name=f"{context.container_name}{STANDBY_SUFFIX}",
+            data_dir=context.data_dir,
+            image=image or context.image,
+            dockerfile=None if image else context.dockerfile,
+            # Pulled or built below, so starting it does not do it again
+            pull_policy="missing",
+            platform=context.platform,
+            resources=context.resources,
+            volume=context.volume,
+            network=context.network,
+            environment=context.environment
+        )
+        reference = context_image(standby)
+        action, _ = await asyncio.to_thread(
+            ensure_image, context.docker_client, reference, standby.dockerfile, "always", force=True,
+            offline=server_config.offline == "on", platform=standby.platform
+        )
+        lines.append(f"✅ {action.capitalize()} {reference}")
+
+        # Shared-container workspaces move along, each with its own session state
+        movers = [context] + [w for w in context.workspaces.values() if w.shared_with is context]
+        states = {id(mover): await capture_kb_state(mover) for mover in movers}
+        lines.append(f"📸 Captured {states[id(context)].summary()}")
+
+        used = {ctx.port for ctx in [context, *context.instances.values(), *context.workspaces.values()]}
+        standby.port = free_port(used)
+        standby.swish_base_url = f"http://localhost:{standby.port}"
+        standby.pengines = PengineManager(standby.swish_base_url, http=swish_http(standby))
+        logger.info(f"🔁 Starting standby {standby.container_name} on {reference}, port {standby.port}")
+
+        async def retire_standby() -> None:
+            await release_instance_resources(standby)
+            if standby.container:
+                try:
+                    await asyncio.to_thread(standby.container.remove, force=True)
+                except Exception as e:
+                    logger.debug(f"Removing standby: {e}")
+
+        if not await start_swish_container(standby) or not standby.prolog_session:
+            await retire_standby()
+            raise UpgradeError(f"The standby on {reference} did not become ready; {context.container_name} keeps serving")
+        errors = await replay_kb_state(standby, states[id(context)])
+        healthy = await probe_swish(standby) and standby.prolog_session.session_active
+        if not healthy or (errors and not force):
+            await retire_standby()
+            problems = "; ".join(errors) if errors else "it failed its health check after the replay"
+            raise UpgradeError(
+                f"The standby could not take over ({problems}); {context.container_name} keeps serving. "
+                + ("Pass force=True to switch anyway." if healthy else "")
+            )
+        lines.append(f"🧠 Replayed into {standby.container_name}" + (f" ({len(errors)} problem(s))" if errors else ""))
+        lines.extend(f"  ⚠️ {error}" for error in errors)
+
+        # Switch once the running queries are done
+        if context.supervisor:
+            await context.supervisor.stop()
+        lines.extend(await wait_for_queries(context, "the switch"))
+        old_container, old_session, old_pengines = context.container, context.prolog_session, context.pengines
+        name = context.container_name
+        if old_container:
+            await asyncio.to_thread(old_container.rename, f"{name}{RETIRED_SUFFIX}")
+        await asyncio.to_thread(standby.container.rename, name)
+        session = standby.prolog_session
+        session.container_name = name
+        bind_session_callbacks(session, context)
+        context.container = standby.container
+        context.port = standby.port
+        context.swish_base_url = standby.swish_base_url
+        context.image = standby.image
+        context.dockerfile = standby.dockerfile
+        context.platform_warning = standby.platform_warning
+        context.prolog_session = session
+        context.pengines = PengineManager(context.swish_base_url, http=swish_http(context))
+        context.pack_states = standby.pack_states
+        context.cursors = CursorTable()
+        context.container_ready = True
+        query_cache.clear(cache_scope(context))
+        await session.restore_state()
+        lines.append(f"🔀 {name} now runs {reference} at {context.swish_base_url}")
+
+        # Workspaces sharing the container get new sessions with their state back
+        for mover in movers[1:]:
+            if mover.prolog_session:
+                await mover.prolog_session.cleanup()
+            mover.container = context.container
+            mover.port = context.port
+            mover.swish_base_url = context.swish_base_url
+            mover.pengines = context.pengines
+            mover.http = context.http
+            mover.image = context.image
+            mover.prolog_session = new_prolog_session(mover)
+            if await mover.prolog_session.start_session():
+                moved = await replay_kb_state(mover, states[id(mover)])
+                lines.append(f"🗂️ Workspace '{mover.workspace}' moved" + (f" ({len(moved)} problem(s))" if moved else ""))
+            else:
+                lines.append(f"⚠️ Workspace '{mover.workspace}' moved, but its session did not start")
+
+        if old_session:
+            await old_session.cleanup()
+        if old_pengines:
+            old_pengines.pengines.clear()
+        if old_container:
+            try:
+                await asyncio.to_thread(old_container.stop, timeout=5)
+                await asyncio.to_thread(old_container.remove, force=True)
+                lines.append("🧹 Retired the previous container")
+            except Exception as e:
+                lines.append(f"⚠️ Could not remove {name}{RETIRED_SUFFIX}: {e}")
+        if context.docker_available:
+            start_supervisor(context)
+        start_pack_setup(context)
+        await refresh_kb_resources()
+        return lines
+
+
+async def apply_config(context: SwishContext, new: ServerConfig, changes: ConfigChanges) -> None:
+    """Switch to a reloaded configuration: limits and policies now, the container when needed."""
+    server_config.limits = new.limits
+    server_config.sandbox = new.sandbox
+    server_config.startup = new.startup
+    server_config.prolog = new.prolog
+    if changes.recreate and new.container != server_config.container:
+        server_config.container = new.container
+        logger.info(f"🔁 Recreating {context.container_name}: {', '.join(changes.recreate)}")
+        track_background_task(asyncio.create_task(recreate_swish_container(context, new.container)))
+
+
+async def release_instance_resources(context: SwishContext) -> None:
+    """Stop supervision and close the Prolog session and pengines for a context."""
+    if context.supervisor:
+        await context.supervisor.stop()
+    if context.prolog_session:
+        try:
+            await context.prolog_session.cleanup()
+        except Exception as e:
+            logger.debug(f"Session cleanup error: {e}")
+    if context.collab:
+        await context.collab.close()
+    # A shared-container workspace's pengines belong to the container's own context
+    if context.pengines and not context.shared_with:
+        try:
+            await context.pengines.cleanup()
+        except Exception as e:
+            logger.debug(f"Pengine cleanup error: {e}")
+
+
+async def cluster_up_specs(parent: SwishContext, specs: list[InstanceSpec]) -> dict[str, str]:
+    """
+    Bring up named SWISH instances that share the parent's Docker client.
+
+    Instances that are already running are left alone, so applying the
+    same spec twice is harmless.
+
+    Returns:
+        Mapping of instance name to "ready", "running" or "failed"
+    """
+    results = {}
+    for spec in specs:
+        if spec.name in parent.workspaces:
+            logger.warning(f"⚠️ Instance name '{spec.name}' is taken by a workspace")
+            results[spec.name] = "failed"
+            continue
+        existing = parent.instances.get(spec.name)
+        if existing and existing.container_ready:
+            results[spec.name] = "running"
+            continue
+
+        instance = SwishContext(
+            docker_client=parent.docker_client,
+            runtime=parent.runtime,
+            docker_available=parent.docker_available,
+            container_name=spec.container_name,
+            port=spec.port,
+            data_dir=spec.data_dir,
+            swish_base_url=f"http://localhost:{spec.port}",
+            # Instances run the same image as the primary container
+            image=parent.image,
+            dockerfile=parent.dockerfile,
+            pull_policy=parent.pull_policy,
+            platform=parent.platform,
+            resources=parent.resources,
+            environment=parent.environment
+        )
+        instance.pengines = PengineManager(instance.swish_base_url, http=swish_http(instance))
+        parent.instances[spec.name] = instance
+
+        logger.info(f"🧩 Starting SWISH instance '{spec.name}' on port {spec.port}")
+        success = await start_swish_container(instance)
+        results[spec.name] = "ready" if success else "failed"
+        if success:
+            start_pack_setup(instance)
+        start_supervisor(instance)
+    return results
+
+
+async def cluster_down_instance(parent: SwishContext, name: str) -> None:
+    """Stop and remove a named instance's container."""
+    instance = parent.instances.pop(name)
+    await release_instance_resources(instance)
+    if instance.container:
+        await asyncio.to_thread(instance.container.stop, timeout=5)
+        await asyncio.to_thread(instance.container.remove, force=True)
+
+
+async def open_workspace(parent: SwishContext, workspace: Workspace) -> str:
+    """
+    Create the context of a workspace and start its session or container.
 
-    return global_swish_context
+    Returns:
+        "ready", "running" or "failed"
+    """
+    existing = parent.workspaces.get(workspace.name)
+    if existing and existing.container_ready and existing.prolog_session and existing.prolog_session.session_active:
+        return "running"
+    workspace.data_dir.mkdir(parents=True, exist_ok=True)
+
+    if workspace.container:
+        if not parent.docker_available:
+            return "failed"
+        context = SwishContext(
+            docker_client=parent.docker_client,
+            runtime=parent.runtime,
+            docker_available=parent.docker_available,
+            container_name=workspace.container_name,
+            port=workspace.port,
+            data_dir=workspace.data_dir,
+            swish_base_url=f"http://localhost:{workspace.port}",
+            image=parent.image,
+            dockerfile=parent.dockerfile,
+            pull_policy=parent.pull_policy,
+            platform=parent.platform,
+            resources=parent.resources,
+            environment=parent.environment,
+            workspace=workspace.name
+        )
+        context.pengines = PengineManager(context.swish_base_url, http=swish_http(context))
+        parent.workspaces[workspace.name] = context
+        logger.info(f"🗂️ Starting the container of workspace '{workspace.name}' on port {workspace.port}")
+        success = await start_swish_container(context)
+        if success:
+            start_pack_setup(context)
+        start_supervisor(context)
+        return "ready" if success else "failed"
+
+    # Shares the parent's container, pengines and workers, with a session of its own
+    try:
+        relative = workspace.data_dir.resolve().relative_to(parent.data_dir.resolve())
+    except ValueError:
+        logger.warning(f"⚠️ Workspace '{workspace.name}' is not below the data directory {parent.data_dir}")
+        return "failed"
+    docker_client = parent.docker_client
+    if isinstance(docker_client, LocalProcessClient):
+        docker_client = docker_client.for_dir(workspace.data_dir)
+    context = SwishContext(
+        docker_client=docker_client,
+        container=parent.container,
+        container_name=parent.container_name,
+        port=parent.port,
+        data_dir=workspace.data_dir,
+        swish_base_url=parent.swish_base_url,
+        docker_available=parent.docker_available,
+        container_ready=parent.container_ready,
+        pengines=parent.pengines,
+        runtime=parent.runtime,
+        workers=parent.workers,
+        image=parent.image,
+        backend=parent.backend,
+        resources=parent.resources,
+        http=parent.http,
+        workspace=workspace.name,
+        shared_with=parent,
+        container_data_dir=f"{CONTAINER_DATA_DIR}/{relative.as_posix()}"
+    )
+    parent.workspaces[workspace.name] = context
+    if not parent.container_ready:
+        return "failed"
+    context.prolog_session = new_prolog_session(context)
+    return "ready" if await context.prolog_session.start_session() else "failed"
+
+
+async def close_workspace(parent: SwishContext, name: str) -> None:
+    """Close a workspace's session, and stop and remove its container if it has one."""
+    context = parent.workspaces.pop(name, None)
+    if context is None:
+        return
+    await release_instance_resources(context)
+    if context.container and not context.shared_with:
+        await asyncio.to_thread(context.container.stop, timeout=5)
+        await asyncio.to_thread(context.container.remove, force=True)
 
 
 def track_background_task(task: asyncio.Task) -> None:
@@ -403,9 +2111,18 @@ def track_background_task(task: asyncio.Task) -> None:
     task.add_done_callback(background_tasks.discard)
 
 
+def reload_handler(signum: int, frame: Any) -> None:
+    """SIGHUP: re-read API keys (for key rotation) and the config file."""
+    server_config.api_keys.reload()
+    if config_watcher:
+        config_watcher.request_reload()
+
+
 # Register cleanup handlers
 signal.signal(signal.SIGTERM, signal_handler)
 signal.signal(signal.SIGINT, signal_handler)
+if hasattr(signal, "SIGHUP"):
+    signal.signal(signal.SIGHUP, reload_handler)
 atexit.register(cleanup_processes)
 
 # Initialize MCP server with metadata
@@ -417,75 +2134,1160 @@ mcp = FastMCP(
     lifespan=app_lifespan
 )
 
-@mcp.tool()
-async def execute_prolog_query(
-    query: str,
-    timeout: int = 30
-) -> str:
-    """
-    Execute a Prolog query against the running SWISH instance with persistent state.
 
-    This is the primary way to interact with Prolog for logic programming,
-    reasoning, and knowledge base queries. State persists between queries,
-    so consulted files and asserted facts remain available.
+def current_api_key() -> ApiKey | None:
+    """API key the current HTTP request authenticated with, if any."""
+    try:
+        request = mcp.get_context().request_context.request
+    except ValueError:
+        return None
+    return getattr(getattr(request, "state", None), "api_key", None)
 
-    Args:
-        query: Prolog query to execute (e.g., "member(X, [1,2,3]).", "?- factorial(5, N).")
-        timeout: Timeout in seconds for query execution
 
-    Returns:
-        Query results or error message
-    """
+def current_profiles() -> list[ToolProfile]:
+    """Profiles limiting the current connection's tools: its key's, and the one its X-MCP-Profile header asks for."""
     try:
-        context = get_context()
+        request = mcp.get_context().request_context.request
+    except ValueError:
+        request = None
+    headers = getattr(request, "headers", None)
+    requested = headers.get(PROFILE_HEADER, "").strip() if headers is not None else ""
+    return server_config.profiles.connection_profiles(current_api_key(), requested)
+
+
+def current_correlation_header() -> str | None:
+    """The X-Correlation-ID header of the current HTTP request, if any."""
+    try:
+        request = mcp.get_context().request_context.request
+    except ValueError:
+        return None
+    headers = getattr(request, "headers", None)
+    return headers.get(CORRELATION_HEADER) if headers is not None else None
+
+
+# Ids of the MCP sessions seen so far; see session_id()
+session_ids: WeakKeyDictionary[Any, str] = WeakKeyDictionary()
+
+
+def session_id(session: Any) -> str:
+    """
+    A server session's id, made up the first time it is asked for.
+
+    Ids are random rather than the session's address, which the next
+    session may get once this one is gone, so an id is never reused.
+    """
+    ident = session_ids.get(session)
+    if ident is None:
+        ident = session_ids[session] = f"session-{uuid.uuid4().hex[:12]}"
+    return ident
+
+
+def current_client_id() -> str:
+    """Identify the MCP client behind the current request.
+
+    An authenticated request is identified by its API key's id, otherwise
+    by its server session. The client_id a client may send in request
+    metadata is ignored: ownership of cursors, engines and modules, and
+    the sandbox policy, all hang on this, and a client can send any id.
+    """
+    key = current_api_key()
+    if key is not None:
+        return key.key_id
+    ctx = mcp.get_context()
+    try:
+        return session_id(ctx.session)
+    except ValueError:
+        return "local"
+
+
+def quota_client_id() -> str:
+    """Identify who the current request's rate limit and quotas are charged to.
+
+    An authenticated request is charged to its API key's id. Without keys
+    it is the HTTP client's address, since a client can send any client_id
+    and reconnect for a new session; stdio has a single client.
+    """
+    key = current_api_key()
+    if key is not None:
+        return key.key_id
+    try:
+        request = mcp.get_context().request_context.request
+    except ValueError:
+        return "local"
+    peer = getattr(request, "client", None)
+    return f"peer-{peer.host}" if peer is not None else "local"
+
+
+# Filled by describe_tools() once every tool is registered, below
+tool_input_schemas: dict[str, dict[str, Any]] = {}
+
+# Innermost, so that injected results still get their result envelope
+install_chaos(
+    mcp,
+    chaos_monkey,
+    lambda arguments: chaos_restart(arguments),
+    lambda coroutine: track_background_task(asyncio.create_task(coroutine))
+)
+# Installed first, so that calls refused for their scope or arguments use no quota
+enforce_quotas(mcp, lambda: quota_tracker, quota_client_id)
+enforce_tool_schemas(mcp, tool_input_schemas)
+enforce_tool_scopes(mcp, current_api_key)
+enforce_tool_profiles(mcp, current_profiles, current_api_key)
+instrument_tool_calls(mcp, metrics)
+instrument_tool_spans(mcp, current_client_id, result_failed)
+# Records refused calls too; inside the correlation ID so that its log lines carry it
+session_recorder.attach(mcp, current_client_id)
+# Outermost, so that the span, refusals and the result envelope all carry the call's ID
+attach_correlation_ids(mcp, current_correlation_header, result_failed)
+
+
+def collect_metrics(server_metrics: ServerMetrics) -> None:
+    """Refresh the gauges of the primary container and its cluster instances before a scrape."""
+    if global_swish_context is None:
+        return
+    pool = global_swish_context.workers.get_status()
+    server_metrics.workers_running.set(pool["running"])
+    server_metrics.workers_queued.set(pool["queued"])
+    for context in [global_swish_context, *global_swish_context.instances.values()]:
+        server_metrics.container_ready.set(1 if context.container_ready else 0, container=context.container_name)
+
+
+metrics.collectors.append(collect_metrics)
+
+
+async def dynamic_clauses_from(container_path: str) -> str:
+    """Listing of dynamic predicates loaded from a file, as the session sees them now."""
+    context = get_context()
+    session = context.prolog_session
+    if not session or not session.session_active:
+        return ""
+    file_atom = "'" + container_path.replace("\\", "\\\\").replace("'", "\\'") + "'"
+    goal = (
+        f"forall(( source_file(M:H, {file_atom}), predicate_property(M:H, dynamic) ), "
+        f"( functor(H, N, A), listing(M:N/A) ))"
+    )
+    lines = []
+    async for event in session.stream_query(goal, server_config.limits):
+        if event["type"] == "output":
+            lines.append(event["text"])
+    return "\n".join(lines)
+
+
+async def safe_consult(context: SwishContext, relative_path: str, module: str) -> tuple[bool, list[ConsultMessage]]:
+    """Consult a data directory file into module if it loads cleanly, quarantining it otherwise."""
+    text = await asyncio.to_thread(
+        (context.data_dir / relative_path).read_text, encoding="utf-8", errors="replace"
+    )
+    call = safe_consult_call(module, f"{prolog_data_dir(context)}/{relative_path}", text)
+    loaded, diagnostics = parse_consult(await run_json_helper(context, call))
+    if loaded:
+        context.quarantine.release(relative_path)
+        query_cache.clear(cache_scope(context))
+    else:
+        context.quarantine.reject(relative_path, module, diagnostics)
+    return loaded, diagnostics
+
+
+async def reload_changed_files(changed: list[str]) -> None:
+    """With SWISH_MCP_AUTO_RELOAD=on, consult the loaded or quarantined files that changed on disk again."""
+    if server_config.auto_reload != "on":
+        return
+    context = get_context()
+    if not context.container_ready or context.prolog_session is None:
+        return
+    try:
+        root = prolog_data_dir(context)
+        files, _ = parse_state(await run_json_helper(context, state_call(root)))
+        modules = {path: module for path, module in files}
+        for relative_path in changed:
+            entry = context.quarantine.files.get(relative_path)
+            module = modules.get(f"{root}/{relative_path}") or (entry.module if entry else None)
+            if module is None:
+                continue
+            loaded, diagnostics = await safe_consult(context, relative_path, module)
+            if loaded:
+                logger.info(f"🔄 Reloaded {relative_path}")
+            else:
+                errors = sum(1 for diagnostic in diagnostics if diagnostic.severity == "error")
+                logger.warning(f"🚧 Quarantined {relative_path} ({errors} error(s)); its previous version stays loaded")
+    except Exception as e:
+        logger.warning(f"Auto-reload failed: {e}")
+
+
+kb_resources = KnowledgeBaseResources(mcp, runtime_clauses=dynamic_clauses_from, on_change=reload_changed_files)
+kb_resources.install()
+
+
+async def run_scheduled_job(job: ScheduledJob) -> JobRun:
+    """Run a scheduled query's goal once in the primary session."""
+    context = get_context()
+    run = JobRun(time=time.time())
+    session = context.prolog_session
+    if not context.container_ready or session is None or not session.session_active:
+        run.error = "Persistent Prolog session is not available"
+        return run
+    limits = server_config.limits.override(job.timeout, None, None)
+    await load_stored_facts(context)
+    started = time.monotonic()
+    try:
+        async for event in session.stream_query(f"limit({job.max_solutions}, ({job.runnable}))", limits):
+            if event["type"] == "solution":
+                run.solutions.append(event["text"])
+            elif event["type"] == "output":
+                run.output.append(event["text"])
+            elif run.error is None:
+                # Read on to END, so the session knows the goal is over
+                run.error = event["error"]
+    except asyncio.TimeoutError:
+        run.error = "session_timeout"
+    metrics.observe_query("scheduled", query_outcome(run.error, len(run.solutions)), time.monotonic() - started, len(run.solutions))
+    return run
+
+
+scheduler = QueryScheduler(
+    mcp,
+    run=run_scheduled_job,
+    notify_updated=kb_resources.notify_updated,
+    notify_list_changed=kb_resources.notify_list_changed
+)
+# Goals registered with template_register, loaded once the environment is up
+query_templates = TemplateRegistry()
+
+
+async def refresh_kb_resources() -> None:
+    """Pick up knowledge base files written by a tool without waiting for the poll."""
+    if volume_mirror:
+        # Files written on the host only reach SWISH through the volume
+        try:
+            await volume_mirror.run()
+        except Exception as e:
+            logger.warning(f"Volume sync failed: {e}")
+    try:
+        await kb_resources.refresh()
+    except Exception as e:
+        logger.debug(f"Knowledge base resource refresh failed: {e}")
+
+
+def start_volume_mirror(context: SwishContext) -> None:
+    """Mirror context's data volume with its data directory, following later changes to either."""
+    global volume_mirror
+    if volume_mirror:
+        volume_mirror.volume, volume_mirror.data_dir = context.volume, context.data_dir
+        if context.volume:
+            volume_mirror.load()
+        return
+    if not context.volume or not context.docker_available:
+        return
+
+    def mounted() -> Any:
+        return context.container if context.container_ready and context.volume else None
+
+    volume_mirror = VolumeMirror(
+        context.volume, context.data_dir, mounted, context.docker_client, reload_synced_files, server_config.sync_interval
+    )
+    volume_mirror.load()
+    track_background_task(asyncio.create_task(volume_mirror.watch()))
+    logger.info(f"📦 Mirroring data volume {context.volume} with {context.data_dir}")
+
+
+async def reload_synced_files(changed: list[str]) -> None:
+    """Reload consulted files the workspace or volume sync replaced in the data directory or volume."""
+    await refresh_kb_resources()
+    context = get_context()
+    session = context.prolog_session
+    if not any(path.endswith(".pl") for path in changed) or not session or not session.session_active:
+        return
+    # make/0 reloads loaded files modified since they were loaded
+    query_cache.clear(cache_scope(context))
+    async for event in session.stream_query("make", server_config.limits):
+        if event["type"] == "error":
+            logger.warning(f"make after workspace sync failed: {event['error']}")
+    await kb_resources.notify_all_updated()
+
+
+def audit_log(context: SwishContext) -> AuditLog:
+    """The audit log of a context's data directory, opened on first use."""
+    if context.audit is None:
+        context.audit = AuditLog(context.data_dir, server_config.undo_depth)
+    return context.audit
+
+
+async def database_snapshot(
+    context: SwishContext,
+    module: str = "user",
+    cap: int | None = None
+) -> list[dict[str, Any]] | None:
+    """
+    Dynamic predicates of a session module with their clauses, or None if unavailable.
+
+    A database of more than cap clauses comes back with clause counts
+    only (see mcp_db_snapshot/3), which is cheap however large it is.
+    """
+    args = [prolog_atom(module)] if cap is None else [prolog_atom(module), str(cap)]
+    try:
+        return await run_json_helper(context, ("mcp_db_snapshot", args))
+    except Exception as e:
+        logger.debug(f"Database snapshot failed: {e}")
+        return None
+
+
+@asynccontextmanager
+async def audited_database(
+    context: SwishContext,
+    tool: str,
+    detail: str,
+    enabled: bool = True,
+    module: str = "user"
+) -> AsyncIterator[None]:
+    """
+    Log the body's changes to a module's dynamic database, keeping the old state for undo.
+
+    Past MAX_UNDO_CLAUSES, where undo is dropped anyway, only clause
+    counts are read, so the change is logged by its net count.
+    """
+    before = await database_snapshot(context, module, MAX_UNDO_CLAUSES) if enabled else None
+    try:
+        yield
+    finally:
+        if before is not None:
+            after = await database_snapshot(context, module, MAX_UNDO_CLAUSES)
+            if after is not None:
+                audit_log(context).record_database(current_client_id(), tool, detail, before, after, module)
+
+
+@asynccontextmanager
+async def audited_files(
+    context: SwishContext,
+    tool: str,
+    detail: str,
+    paths: list[Path],
+    grows: bool = True
+) -> AsyncIterator[None]:
+    """
+    Log the body's changes to paths (files or directories), keeping their old contents for undo.
+
+    Unless grows is False (the body only removes or renames files), the
+    body does not run once the data directory is over its disk quota.
+    """
+    if grows:
+        await check_disk(context, tool)
+    log = audit_log(context)
+    before = log.capture_files(paths)
+    try:
+        yield
+    finally:
+        log.record_files(current_client_id(), tool, detail, paths, before)
+        if grows:
+            disk_monitor.charge(context.data_dir, sum(path.stat().st_size for path in paths if path.is_file()))
+
+
+def storage_areas(context: SwishContext) -> dict[str, Path]:
+    """Directories the server writes an instance's files to, its data directory first (see disk_usage.py)."""
+    return {
+        "data": context.data_dir,
+        "snapshots": snapshot_dir_for(context.data_dir),
+        "bundles": bundle_dir_for(context.data_dir),
+        "repro": repro_dir_for(context.data_dir),
+        "recordings": recordings_dir_for(context.data_dir),
+    }
+
+
+async def check_disk(context: SwishContext, what: str, adding: int = 0) -> None:
+    """Raise DiskQuotaExceeded if writing adding more bytes would pass the disk quota or reserve."""
+    await asyncio.to_thread(disk_monitor.check, storage_areas(context), what, adding)
+
+
+async def run_as_of_query(
+    context: SwishContext,
+    query: str,
+    moment: AsOf,
+    module: str,
+    shape: ResultShape,
+    limits: QueryLimits,
+    stream: bool,
+    batch_size: int,
+    output_format: str,
+    printing: PrintOptions,
+    instance: str = ""
+) -> str:
+    """Run a query against module's dynamic database as it was at moment, in a temporary module."""
+    current = await database_snapshot(context, module)
+    if current is None:
+        return "❌ Could not read the current database to rebuild the past one from."
+    try:
+        predicates, notes = replay_database(audit_log(context).read(), current, module, moment)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    scratch = asof_module()
+    try:
+        await run_json_helper(context, asof_load_call(module, scratch, predicates))
+        goal = in_module(clean_query_text(query), scratch)
+        session_query = shape.wrap(goal) if shape.active else goal
+        result = await cancellable(clean_query_text(query), "session", instance, lambda: run_session_query(
+            context, session_query, limits, stream, batch_size, output_format, printing=printing
+        ))
+    except RuntimeError as e:
+        return error_result(e, f"Could not rebuild the knowledge base as of {moment.describe()}")
+    finally:
+        try:
+            await run_json_helper(context, asof_drop_call(scratch))
+        except Exception as e:
+            logger.warning(f"Could not drop time-travel module {scratch}: {e}")
+    if output_format == "json":
+        try:
+            document = json.loads(result)
+        except ValueError:
+            return result
+        document["as_of"] = {"moment": moment.describe(), "notes": notes}
+        return json.dumps(document, indent=2)
+    return f"{result}\n\n{format_notes(notes)}"
+
+
+async def run_explained_query(
+    context: SwishContext,
+    query: str,
+    guard: str,
+    module: str,
+    limits: QueryLimits,
+    output_format: str
+) -> str:
+    """Run a query under the proof-recording meta-interpreter and give each solution with its proof."""
+    started = time.monotonic()
+    try:
+        rows = await run_json_helper(context, explain_call(query, guard, module, limits), limits)
+    except RuntimeError as e:
+        error = str(e)
+        metrics.observe_query("session", query_outcome(error, 0), time.monotonic() - started, 0)
+        typed_error = from_prolog(error, query)
+        if output_format == "json":
+            return json.dumps({
+                "query": f"{query}.", "success": False, "solutions": [], "output": [],
+                "error": typed_error.to_json(), "proofs": [], "more": False,
+            }, indent=2)
+        limit_message = describe_limit_error(error, limits)
+        if limit_message:
+            return f"{limit_message}\n{typed_error.tag()}"
+        return f"❌ Query: {query}.\n📋 Error: {error}\n{typed_error.tag()}"
+    explanations, more, output = parse_explanations(rows)
+    metrics.observe_query(
+        "session", query_outcome(None, len(explanations)), time.monotonic() - started, len(explanations)
+    )
+    if output_format == "json":
+        return json.dumps({
+            "query": f"{query}.",
+            "success": bool(explanations),
+            "solutions": [explanation.values for explanation in explanations],
+            "output": [output] if output else [],
+            "error": None,
+            "proofs": [explanation.proof_json() for explanation in explanations],
+            "more": more,
+        }, indent=2)
+    return format_explanations(f"{query}.", explanations, more, output)
+
+
+def sandbox_policy() -> SandboxPolicy:
+    """Sandbox policy for the client behind the current request.
+
+    Keys without the write scope get the strict policy: the static scan
+    alone misses goals built at runtime, such as call/N of a name made
+    with atom_concat/3. So does everyone on the local backend, where
+    goals run on this machine with the server's own rights.
+    """
+    policy = server_config.sandbox.policy_for(current_client_id())
+    key = current_api_key()
+    if server_config.backend == "local" or (key is not None and not key.allows("write")):
+        if policy.mode != "strict":
+            policy = replace(policy, mode="strict")
+    return policy
+
+
+def client_module() -> str:
+    """Prolog module the current client's session goals run in ("user" unless isolated)."""
+    return client_modules.module_for(current_client_id())
+
+
+async def report_progress(progress: float, message: str) -> None:
+    """Send an MCP progress notification for the current tool call, if any.
+
+    Notifications are only delivered when the client supplied a progress
+    token; outside of a request this is a no-op.
+    """
+    ctx = mcp.get_context()
+    try:
+        await ctx.report_progress(progress=progress, total=None, message=message)
+    except ValueError:
+        logger.debug("No request context, skipping progress notification")
+
+
+def pull_reporter() -> Callable[[PullProgress], None]:
+    """Progress callback for a pull running in a thread, sent as progress notifications of the current call."""
+    loop = asyncio.get_running_loop()
+
+    def report(progress: PullProgress) -> None:
+        logger.info(progress.describe())
+        # asyncio.to_thread copied the request's context into the thread, and this takes it back
+        asyncio.run_coroutine_threadsafe(report_progress(progress.downloaded, progress.describe()), loop)
+
+    return report
+
+
+def describe_limit_error(error: str, limits: QueryLimits) -> str | None:
+    """Turn a resource-limit exception from mcp_limited/2 into a message."""
+    if error == "time_limit_exceeded":
+        return f"⏱️ Query exceeded its wall-clock limit of {limits.wall_seconds:g} seconds"
+    if error == "cpu_time_limit_exceeded":
+        return f"⏱️ Query exceeded its CPU time limit of {limits.cpu_seconds:g} seconds"
+    if error.startswith("inference_limit_exceeded"):
+        return f"⏱️ Query exceeded its limit of {limits.inferences} inferences"
+    return None
+
+
+async def run_session_query(
+    context: SwishContext,
+    query: str,
+    limits: QueryLimits,
+    stream: bool = False,
+    batch_size: int = 10,
+    output_format: str = "text",
+    events: AsyncIterator[dict[str, Any]] | None = None,
+    cursor: CursorInfo | None = None,
+    printing: PrintOptions | None = None,
+    answer_bytes: int = 0,
+    seen: set[str] | None = None
+) -> str:
+    """
+    Run a query in the persistent session and format the results.
+
+    In stream mode every batch of solutions is also sent as an MCP
+    progress notification while the query is still running. With
+    output_format "json" the result (and each batch) is a JSON document
+    whose solutions map variable names to typed values.
+
+    For paginated queries, events is the page being fetched for cursor;
+    the result then says whether (and how) to fetch the next page.
+    printing sets how bindings are printed when events is not given.
+    Solutions beyond answer_bytes are held back behind a cursor (see
+    answer_limits.py), and "truncated" is added to seen.
+    """
+    if context.prolog_session is None:
+        return "❌ Persistent Prolog session is not available. Try restart_prolog_session()."
+
+    structured = output_format == "json"
+    clean_query = clean_query_text(query) + "."
+    batch_size = max(1, batch_size)
+    solutions: list[Any] = []
+    output: list[str] = []
+    batch: list[Any] = []
+    batches_sent = 0
+    error: str | None = None
+    cursor_state = "done"
+
+    async def flush_batch() -> None:
+        nonlocal batches_sent
+        batches_sent += 1
+        running = current_query.get()
+        await report_progress(
+            len(solutions),
+            json.dumps({
+                "query": clean_query, "query_id": running.query_id if running else None,
+                "batch": batches_sent, "solutions": batch,
+            })
+        )
+        batch.clear()
+
+    if events is None:
+        events = context.prolog_session.stream_query(query, limits, output_format, printing)
+
+    started = time.monotonic()
+    try:
+        async for event in events:
+            if event["type"] == "cursor":
+                cursor_state = event["state"]
+            elif event["type"] == "solution":
+                solution = event["bindings"] if structured else event["text"]
+                # Solutions held back by the answer size limit went through the hooks before
+                if result_hooks and not event.get("held"):
+                    solution = result_hooks.process(clean_query, client_module(), output_format, solution)
+                    if solution is None:
+                        continue
+                solutions.append(solution)
+                if stream:
+                    batch.append(solution)
+                    if len(batch) >= batch_size:
+                        await flush_batch()
+            elif event["type"] == "output":
+                output.append(event["text"])
+            elif error is None:
+                # Read on to END, so the session knows the goal is over
+                error = event["error"]
+    except asyncio.TimeoutError:
+        error = "session_timeout"
+    metrics.observe_query("session", query_outcome(error, len(solutions)), time.monotonic() - started, len(solutions))
+
+    if batch:
+        await flush_batch()
+
+    held, truncation = cut(solutions, structured, answer_bytes) if error is None else ([], None)
+    if truncation is not None:
+        if seen is not None:
+            seen.add("truncated")
+        if cursor is None:
+            # The query ran to its end: a cursor without an engine pages through the rest
+            rest, evicted = context.cursors.open(
+                current_client_id(), query, output_format, truncation.returned, context.prolog_session.generation
+            )
+            if evicted:
+                await context.prolog_session.close_cursors(evicted)
+            rest.pending, rest.exhausted, rest.answer_bytes = held, True, answer_bytes
+            rest.fetched, rest.pages = truncation.returned, 1
+            truncation.next_cursor = rest.cursor_id
+        else:
+            cursor.pending[:0] = held
+            truncation.next_cursor = cursor.cursor_id
+
+    next_cursor = truncation.next_cursor if truncation is not None else None
+    page_note = ""
+    if cursor is not None:
+        first = cursor.fetched + 1
+        cursor.fetched += len(solutions)
+        cursor.pages += 1
+        if cursor_state == "done":
+            cursor.exhausted = True
+        if error is None and (cursor_state == "more" or cursor.pending):
+            next_cursor = cursor.cursor_id
+            page_note = (
+                f"\n\n📄 Page {cursor.pages} (solutions {first}-{cursor.fetched}). "
+                f"More available: call again with cursor=\"{next_cursor}\""
+            )
+        else:
+            # The engine is gone once exhausted or after an error
+            context.cursors.close(cursor.cursor_id)
+            page_note = f"\n\n📄 Page {cursor.pages}, last page ({cursor.fetched} solutions in total)"
+
+    # Pages and cut answers are already bounded; other results too large to return go to a file
+    spilled = None
+    if error is None and cursor is None and truncation is None and server_config.spill.exceeded(solutions, structured):
+        try:
+            await check_disk(context, f"Spilling {len(solutions)} solutions to a file")
+            spilled = await asyncio.to_thread(spill_results, context.data_dir, solutions, structured)
+            disk_monitor.charge(context.data_dir, spilled.size)
+        except (OSError, DiskQuotaExceeded) as e:
+            logger.warning(f"Could not write {len(solutions)} solutions to the data directory: {e}")
+
+    typed_error = from_prolog(error, clean_query_text(query)) if error is not None else None
+    if structured:
+        result: dict[str, Any] = {
+            "query": clean_query,
+            "success": error is None and bool(solutions),
+            "solutions": solutions[:SAMPLE_SIZE] if spilled is not None else solutions,
+            "output": output,
+            "error": typed_error.to_json() if typed_error else None,
+            "truncated": truncation is not None,
+        }
+        if cursor is not None:
+            result["page"] = cursor.pages
+            result["next_cursor"] = next_cursor
+        if truncation is not None:
+            result.update(truncation.to_json())
+        if spilled is not None:
+            result["spilled"] = spilled.to_json()
+        return json.dumps(result, indent=2)
+
+    if typed_error is not None and error == "session_timeout":
+        return f"⏱️ Query did not respond within {limits.wall_seconds:g} seconds; the Prolog session was reset\n{typed_error.tag()}"
+    if typed_error is not None:
+        limit_message = describe_limit_error(error, limits)
+        if limit_message:
+            return f"{limit_message} ({len(solutions)} solutions found before it was stopped)\n{typed_error.tag()}"
+        return f"❌ Query: {clean_query}\n📋 Error: {error}\n{typed_error.tag()}"
+
+    printed = f"\n🖨️ Output:\n{chr(10).join(output)}" if output else ""
+    if truncation is not None:
+        page_note = f"\n\n{truncation.note(paged=cursor is not None)}{page_note}"
+    if not solutions and cursor is not None and cursor.pages > 1:
+        return f"✅ Query: {clean_query}\n📋 No more solutions{printed}{page_note}"
+    if not solutions:
+        return f"❌ Query: {clean_query}\n📋 Result: false (no solutions found){printed}"
+    if solutions == ["true"] and cursor is None and truncation is None:
+        return f"✅ Query: {clean_query}\n📋 Result: true (query succeeded){printed}"
+
+    mode = f"streamed in {batches_sent} batches of up to {batch_size}" if stream else "persistent session"
+    if spilled is not None:
+        return f"""✅ Query: {clean_query}
+📋 First {min(SAMPLE_SIZE, spilled.count)} of {spilled.count} results:
+{chr(10).join("  • " + solution.replace(chr(10), chr(10) + "    ") for solution in solutions[:SAMPLE_SIZE])}{printed}
+
+💾 All {spilled.count} solutions ({format_bytes(spilled.size)}) were written to {spilled.path}: read the resource {spilled.uri}
+💡 Total solutions: {spilled.count} ({mode})"""
+    return f"""✅ Query: {clean_query}
+📋 Results:
+{chr(10).join("  • " + solution.replace(chr(10), chr(10) + "    ") for solution in solutions)}{printed}
+
+💡 Total solutions: {len(solutions)} ({mode}){page_note}"""
+
+
+async def observed_events(
+    events: AsyncIterator[dict[str, Any]],
+    seen: set[str]
+) -> AsyncIterator[dict[str, Any]]:
+    """Pass a query's events through, noting their types, and "done" once all arrived."""
+    async for event in events:
+        seen.add(event["type"])
+        yield event
+    seen.add("done")
+
+
+async def cache_dependencies(context: SwishContext, goal: str) -> frozenset[str] | None:
+    """
+    Dynamic predicates a goal depends on, now watched for changes.
+
+    None if the goal cannot be cached: its calls could not all be
+    followed, or it does not parse.
+    """
+    try:
+        rows = await run_json_helper(context, deps_call(goal))
+    except RuntimeError as e:
+        logger.debug(f"Not caching {goal}: {e}")
+        return None
+    if any(row.get("opaque") for row in rows):
+        return None
+    return frozenset(row["predicate"] for row in rows if "predicate" in row)
+
+
+async def expire_cursors(context: SwishContext) -> None:
+    """Destroy the engines of cursors that have been idle too long."""
+    expired = context.cursors.expire()
+    if expired and context.prolog_session:
+        await context.prolog_session.close_cursors(expired)
+
+
+async def open_cursor_query(
+    context: SwishContext,
+    query: str,
+    limits: QueryLimits,
+    page_size: int,
+    stream: bool,
+    batch_size: int,
+    output_format: str,
+    printing: PrintOptions | None = None,
+    answer_bytes: int = 0
+) -> str:
+    """Start a paginated query and return its first page."""
+    session = context.prolog_session
+    if session is None:
+        return "❌ Persistent Prolog session is not available. Try restart_prolog_session()."
+
+    await expire_cursors(context)
+    cursor, evicted = context.cursors.open(
+        current_client_id(), query, output_format, page_size, session.generation
+    )
+    if evicted:
+        await session.close_cursors(evicted)
+    cursor.answer_bytes = answer_bytes
+    events = session.open_cursor(cursor.cursor_id, query, limits, output_format, page_size, printing)
+    return await run_session_query(
+        context, query, limits, stream, batch_size, output_format, events=events, cursor=cursor,
+        answer_bytes=answer_bytes
+    )
+
+
+async def held_page(cursor: CursorInfo) -> AsyncIterator[dict[str, Any]]:
+    """The next page of the solutions an answer size limit held back, as the cursor's engine gives pages."""
+    page, cursor.pending = cursor.pending[:cursor.page_size], cursor.pending[cursor.page_size:]
+    key = "bindings" if cursor.output_format == "json" else "text"
+    for solution in page:
+        yield {"type": "solution", key: solution, "held": True}
+    yield {"type": "cursor", "state": "more" if cursor.pending or not cursor.exhausted else "done"}
+
+
+async def fetch_cursor_page(
+    context: SwishContext,
+    cursor_id: str,
+    limits: QueryLimits,
+    page_size: int,
+    stream: bool,
+    batch_size: int,
+    answer_bytes: int | None = None
+) -> str:
+    """Return the next page of an open cursor without re-running its goal."""
+    session = context.prolog_session
+    if session is None:
+        return "❌ Persistent Prolog session is not available. Try restart_prolog_session()."
+
+    try:
+        cursor = context.cursors.get(cursor_id, current_client_id(), session.generation)
+    except CursorError as e:
+        return error_result(e, fallback="invalid_argument")
+    if page_size > 0:
+        cursor.page_size = page_size
+    if answer_bytes is not None:
+        cursor.answer_bytes = max(answer_bytes, 0)
+    if cursor.pending:
+        events = held_page(cursor)
+    else:
+        events = session.next_page(cursor.cursor_id, limits, cursor.output_format, cursor.page_size)
+    return await run_session_query(
+        context, cursor.query, limits, stream, batch_size, cursor.output_format,
+        events=events, cursor=cursor, answer_bytes=cursor.answer_bytes
+    )
+
+
+async def cancellable(goal: str, where: str, instance: str, run: Callable[[], Awaitable[str]]) -> str:
+    """
+    Run a query under an id cancel_query() can stop it by (see cancellation.py).
+
+    A query cancel_query stopped comes back as a cancelled error; one the
+    MCP client cancelled is left to the SDK, which answers the call.
+    """
+    with running_queries.track(current_client_id(), goal, where, instance) as running:
+        try:
+            return await run()
+        except asyncio.CancelledError:
+            if not running.cancelled_by:
+                raise
+            uncancel(running.task)
+            logger.info(f"Query {running.query_id} cancelled by {running.cancelled_by}")
+            return ToolError(
+                "cancelled",
+                f"Query: {goal}. was cancelled ({running.query_id})",
+                {"query_id": running.query_id}
+            ).render()
+
+
+async def run_isolated_query(
+    context: SwishContext,
+    query: str,
+    limits: QueryLimits,
+    src_text: str = "",
+    max_solutions: int = 100
+) -> str:
+    """
+    Run a query on its own pengine through the worker pool.
+
+    Isolated queries see only src_text and SWISH's libraries, not the
+    persistent session, but never wait behind other clients' queries
+    beyond the pool's concurrency limits. When SWISH's port cannot be
+    reached they run in a fresh swipl process instead (see execution.py).
+    """
+    strategy = execution_strategy(context)
+    clean_query = clean_query_text(query) + "."
+
+    async def job() -> dict[str, Any]:
+        started = time.monotonic()
+        try:
+            answer = await strategy.run_once(query, src_text, max_solutions, timeout=limits.wall_seconds + 5)
+        except asyncio.TimeoutError:
+            metrics.observe_query("isolated", "timeout", time.monotonic() - started, 0)
+            raise
+        solutions = len(answer_rows(answer)) if answer.get("event") == "success" else 0
+        error = None if answer.get("event") in ("success", "failure") else str(answer.get("data") or answer.get("event"))
+        metrics.observe_query("isolated", query_outcome(error, solutions), time.monotonic() - started, solutions)
+        return answer
+
+    # Only the pengine call is timed; waiting for a free worker is not
+    try:
+        answer = await context.workers.submit(current_client_id(), job)
+    except asyncio.TimeoutError:
+        return ToolError(
+            "timeout",
+            f"Query: {clean_query} did not finish within {limits.wall_seconds:g} seconds (isolated)",
+            {"limit": "wall"}
+        ).render()
+    except SwishUnavailable as e:
+        return error_result(e, f"Query: {clean_query} was not run")
+
+    where = "isolated pengine" if strategy.active == "http" else "isolated swipl process"
+    event = answer.get("event")
+    if event == "success":
+        rows = answer_rows(answer)
+        if result_hooks and rows != ["true"]:
+            module = client_module()
+            processed = (result_hooks.process(clean_query, module, "text", row) for row in rows)
+            rows = [row for row in processed if row is not None]
+            if not rows:
+                return f"❌ Query: {clean_query}\n📋 Result: false (no solutions left by the result hooks)"
+        if rows == ["true"]:
+            return f"✅ Query: {clean_query}\n📋 Result: true (query succeeded, {where})"
+        more = f"; stopped at max_solutions={max_solutions}" if answer.get("more") else ""
+        return f"""✅ Query: {clean_query}
+📋 Results:
+{chr(10).join(f"  • {row}" for row in rows)}
+
+💡 Total solutions: {len(rows)} ({where}{more})"""
+    if event == "failure":
+        return f"❌ Query: {clean_query}\n📋 Result: false (no solutions found)"
+    if event == "error":
+        typed_error = from_message(str(answer.get("data")))
+        return f"❌ Query: {clean_query}\n📋 Error: {answer.get('data')}\n{typed_error.tag()}"
+    return f"❌ Query: {clean_query}\n{format_answer(answer)}"
+
+
+@mcp.tool()
+async def execute_prolog_query(
+    query: str,
+    timeout: int | None = None,
+    cpu_limit: float | None = None,
+    inference_limit: int | None = None,
+    stack_limit: str = "",
+    table_space: str = "",
+    stream: bool = False,
+    batch_size: int = 10,
+    output_format: str = "text",
+    limit: int = 0,
+    cursor: str = "",
+    max_answer_bytes: int | None = None,
+    isolated: bool = False,
+    use_cache: bool = True,
+    max_depth: int | None = None,
+    max_list: int | None = None,
+    print_style: str = "",
+    portray: bool | None = None,
+    reproduce_bundle: bool = False,
+    tabled: list[str] | None = None,
+    abolish_tables: bool = False,
+    distinct: bool | list[str] = False,
+    order_by: list[str] | None = None,
+    group_by: list[str] | None = None,
+    count: bool = False,
+    sum: list[str] | None = None,
+    as_of: str = "",
+    explain: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Execute a Prolog query against the running SWISH instance with persistent state.
+
+    This is the primary way to interact with Prolog for logic programming,
+    reasoning, and knowledge base queries. State persists between queries,
+    so consulted files and asserted facts remain available.
+
+    Args:
+        query: Prolog query to execute (e.g., "member(X, [1,2,3]).", "?- factorial(5, N).")
+        timeout: Wall-clock limit in seconds (default: SWISH_MCP_QUERY_TIMEOUT, 30)
+        cpu_limit: CPU time limit in seconds (default: SWISH_MCP_CPU_LIMIT, off)
+        inference_limit: Maximum logical inferences (default: SWISH_MCP_INFERENCE_LIMIT, off)
+        stack_limit: Stack size for this query, e.g. "4g", for deep recursion
+            (default: the session's, SWISH_MCP_STACK_LIMIT)
+        table_space: Space for the answer tables of tabled predicates during
+            this query, e.g. "2g" (default: SWISH_MCP_TABLE_SPACE)
+        stream: Emit solutions as MCP progress notifications while the query runs
+        batch_size: Number of solutions per progress notification in stream mode
+        output_format: "text" for readable bindings, or "json" for one object per
+            solution mapping variable names to typed values, e.g.
+            {"X": {"type": "compound", "functor": "f", "arity": 1, "args": [...]}}
+        limit: Page size; when set, at most this many solutions are returned
+            and a cursor is given for the rest
+        cursor: Cursor from a previous page; fetches the next page without
+            re-running the goal (query is then ignored)
+        max_answer_bytes: Bytes of solutions to return at most (default:
+            SWISH_MCP_MAX_ANSWER_BYTES, off; 0 lifts it); a larger answer is
+            cut to the solutions that fit and says so ("truncated", solutions
+            returned and found), with a cursor for the rest
+        isolated: Run on a separate pengine from the worker pool instead of
+            the persistent session, so it runs concurrently with other
+            queries; it does not see consulted files or asserted facts
+        use_cache: With the query cache enabled (SWISH_MCP_CACHE_SIZE), answer a
+            repeated read-only query from the cache while nothing it depends
+            on has changed; False always runs it
+        max_depth: Print subterms nested deeper than this as ... (default:
+            SWISH_MCP_PRINT_DEPTH, off), so huge terms stay small
+        max_list: Print only the first this many elements of longer lists,
+            then ... (default: SWISH_MCP_PRINT_LIST, off)
+        print_style: "plain" (writeq), "pretty" (print_term/2, laid out over
+            lines along the operators) or "clause" (portray_clause/1 layout);
+            JSON output only applies the depth and list cuts
+        portray: Let user:portray/1 hooks print the bindings
+        reproduce_bundle: Record the image digest, consulted files with their
+            hashes, dynamic databases, flags, goal and result in a bundle that
+            replay() runs again (session queries without limit or stream)
+        tabled: Predicates to table before the query runs, as Name/Arity or
+            mode-directed heads, e.g. ["path/2"], so left recursion terminates;
+            they stay tabled (table_remove undoes it)
+        abolish_tables: Abolish all answer tables first, so tabled predicates
+            are computed afresh
+        distinct: True drops solutions repeating earlier bindings; a list of
+            variable names, e.g. ["X"], keeps the first solution per value
+        order_by: Variables to sort the solutions by, e.g. ["X", "-Y"] for X
+            ascending then Y descending ("asc(X)" and "desc(Y)" work too)
+        group_by: Variables to group the solutions by; each group is one
+            solution binding only these and the aggregates
+        count: Bind Count to the number of solutions (per group with group_by)
+        sum: Variables to sum over the solutions (per group), e.g. ["Price"]
+            binds SumPrice
+        as_of: Answer against the dynamic database as it was at a past moment,
+            rebuilt from the audit log: a timestamp ("2026-10-13 09:00"), a
+            span ("2h ago") or a kb_history() entry ("#12"); what the query
+            asserts is discarded
+        explain: Prove the goal with a meta-interpreter and return each solution
+            with its proof tree: the facts and rules (with file:line) each goal
+            was resolved with; built-in and library predicates are leaves. At
+            most 10 solutions are explained
+        instance: Cluster instance or workspace to query (default: primary container)
+
+    Returns:
+        Query results or error message
+    """
+    try:
+        context = get_context(instance)
 
         if not context.container_ready:
             # Try to refresh container reference and check again
             if context.docker_available:
                 refresh_success = refresh_container_reference(context)
                 if not refresh_success or not context.container_ready:
-                    return "❌ SWISH container is not ready. Please wait a moment and try again or restart the MCP server."
+                    return ToolError(
+                        "not_ready",
+                        "SWISH container is not ready. Please wait a moment and try again or restart the MCP server."
+                    ).render()
+            elif context.backend == "local":
+                return ToolError(
+                    "not_ready",
+                    "The local SWI-Prolog session is not running. Check that swipl is installed and try restart_prolog_session()."
+                ).render()
             else:
-                return "❌ Docker not available. Cannot execute Prolog queries."
+                return ToolError("not_ready", "Docker not available. Cannot execute Prolog queries.").render()
+
+        try:
+            limits = server_config.limits.override(
+                timeout, cpu_limit, inference_limit,
+                parse_size("stack_limit", stack_limit or 0), parse_size("table_space", table_space or 0)
+            )
+            printing = server_config.printing.override(max_depth, max_list, print_style, portray)
+            shape = ResultShape.parse(distinct, order_by, group_by, count, sum)
+            moment = AsOf.parse(as_of) if as_of.strip() else None
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        cpu_left = quota_tracker.cpu_left(quota_client_id())
+        if cpu_left is not None and (limits.cpu_seconds <= 0 or cpu_left < limits.cpu_seconds):
+            limits = limits.override(cpu_seconds=max(cpu_left, 0.01))
+
+        if reproduce_bundle and (cursor or limit > 0 or stream or isolated or shape.active):
+            return "❌ reproduce_bundle records a complete result; run the query without cursor, limit, stream, isolated or result shaping."
+        answer_bytes = server_config.max_answer_bytes if max_answer_bytes is None else max(max_answer_bytes, 0)
+        if cursor:
+            return await fetch_cursor_page(context, cursor, limits, limit, stream, batch_size, max_answer_bytes)
+        if moment and (limit > 0 or isolated or reproduce_bundle or tabled or abolish_tables):
+            return "❌ as_of queries run once against a temporary module; use them without limit, isolated, reproduce_bundle or tabling."
+        if explain and (limit > 0 or stream or isolated or reproduce_bundle or shape.active or moment):
+            return "❌ explain proves the goal under a meta-interpreter; use it without limit, stream, isolated, reproduce_bundle, result shaping or as_of."
 
         # Validate query format
         if not query.strip():
             return "❌ Empty query provided"
 
-        # Use persistent session if available
-        if context.prolog_session:
+        policy = sandbox_policy()
+        query_text = clean_query_text(query)
+        changes_database = uses_category(query, DATABASE_CATEGORY)
+        if writes_files(query_text):
             try:
-                result = await context.prolog_session.execute_query(query, timeout)
-
-                # Format the response based on result type
-                clean_query = query.strip()
-                if clean_query.startswith("?-"):
-                    clean_query = clean_query[2:].strip()
-                if not clean_query.endswith('.'):
-                    clean_query = clean_query + '.'
-
-                if result["success"]:
-                    if result.get("response_type") == "solutions":
-                        solutions = result.get("solutions", [])
-                        return f"""✅ Query: {clean_query}
-📋 Results:
-{chr(10).join(f"  • {solution}" for solution in solutions)}
-
-💡 Total solutions: {len(solutions)} (persistent session)"""
-
-                    elif result.get("response_type") == "simple_success":
-                        return f"✅ Query: {clean_query}\n📋 Result: true (query succeeded)"
+                await check_disk(context, "A query writing files")
+            except DiskQuotaExceeded as e:
+                return error_result(e)
+        if isolated:
+            if output_format != "text" or limit > 0 or stream:
+                return "❌ Isolated queries support text output only, without streaming or pagination."
+            if stack_limit or table_space:
+                return "❌ stack_limit and table_space apply to the persistent session; isolated queries run with SWI-Prolog's defaults."
+            if tabled or abolish_tables:
+                return "❌ tabled and abolish_tables apply to the persistent session; isolated queries do not see its tables."
+            if shape.active:
+                return "❌ distinct, order_by, group_by, count and sum apply to persistent session queries, not isolated ones."
+            try:
+                check_text(query, policy)
+                require_unrestricted(policy, "call", "isolated queries")
+            except SandboxViolation as e:
+                return error_result(e, fallback="invalid_argument")
+            return await cancellable(query_text, "isolated", instance, lambda: run_isolated_query(context, query, limits))
 
-                    else:
-                        return f"✅ Query: {clean_query}\n📋 Result: Query completed successfully"
+        if policy.checks_goals:
+            try:
+                query = apply_policy(clean_query_text(query), policy)
+            except SandboxViolation as e:
+                logger.warning(f"Sandbox ({policy.mode}) blocked query from {current_client_id()}: {e}")
+                return error_result(e, fallback="invalid_argument")
 
-                elif result.get("response_type") == "failure":
-                    return f"❌ Query: {clean_query}\n📋 Result: false (no solutions found)"
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
 
-                else:
-                    error_msg = result.get("error", "Unknown error")
-                    return f"❌ Query: {clean_query}\n📋 Error: {error_msg}"
+        if (stream or output_format == "json" or limit > 0 or reproduce_bundle or tabled or abolish_tables or shape.active or moment or explain) and not context.prolog_session:
+            return "❌ Streaming, JSON output, pagination, reproduce bundles, tabling, result shaping, as_of and explain require the persistent Prolog session. Try restart_prolog_session()."
 
+        # Use persistent session if available
+        if context.prolog_session:
+            module = client_module()
+            base_query = in_module(clean_query_text(query), module)
+            session_query = shape.wrap(base_query) if shape.active else base_query
+            session = context.prolog_session
+            await load_stored_facts(context)
+            if moment:
+                return await run_as_of_query(
+                    context, query, moment, module, shape, limits, stream, batch_size, output_format, printing, instance
+                )
+            if tabled or abolish_tables:
+                try:
+                    failed = await prepare_query_tables(context, module, tabled or [], abolish_tables)
+                except ValueError as e:
+                    return error_result(e, fallback="invalid_argument")
+                except RuntimeError as e:
+                    return error_result(e, "Could not prepare the query's tables")
+                if failed:
+                    return failed
+            if explain:
+                guard = policy_guard(query_text, policy) if policy.checks_goals else "true"
+                async with audited_database(context, "execute_prolog_query", query_text, changes_database, module):
+                    result = await cancellable(query_text, "session", instance, lambda: run_explained_query(
+                        context, query_text, guard, module, limits, output_format
+                    ))
+                if not instance and changes_database:
+                    await kb_resources.notify_all_updated()
+                return result
+            use_cache = (
+                use_cache and query_cache.enabled and limit <= 0 and not stream and not reproduce_bundle
+                and not tabled and cacheable(query_text)
+            )
+            cache_key = query_cache.key(
+                cache_scope(context), session_query, module, printing.to_prolog(output_format), limits, answer_bytes
+            )
+            if use_cache:
+                cached = query_cache.get(cache_key, session.generation)
+                metrics.cache_lookups.inc(result="hit" if cached is not None else "miss")
+                if cached is not None:
+                    if output_format == "text":
+                        cached += "\n\n♻️ Cached result: nothing it depends on has changed since it was computed"
+                    return cached
+            # Recorded before the query runs, as its answer depends on the state it starts from
+            repro = None
+            if reproduce_bundle:
+                repro = await capture_repro(context, query_text, module, output_format, limits, printing)
+            try:
+                events = None
+                seen: set[str] = set()
+                if use_cache:
+                    since, generation = query_cache.sequence, session.generation
+                    # The grouping wrapper hides the goal in a string, where no dependency is seen
+                    deps = await cache_dependencies(context, base_query)
+                    events = observed_events(session.stream_query(session_query, limits, output_format, printing), seen)
+                async with audited_database(context, "execute_prolog_query", query_text, changes_database, module):
+                    if limit > 0:
+                        result = await cancellable(query_text, "session", instance, lambda: open_cursor_query(
+                            context, session_query, limits, limit, stream, batch_size, output_format, printing,
+                            answer_bytes
+                        ))
+                    else:
+                        result = await cancellable(query_text, "session", instance, lambda: run_session_query(
+                            context, session_query, limits, stream, batch_size, output_format, events,
+                            printing=printing, answer_bytes=answer_bytes, seen=seen
+                        ))
+                # A cut answer points at a cursor of its own, which a cached copy would share
+                if use_cache and deps is not None and "done" in seen and not seen & {"output", "error", "truncated"}:
+                    query_cache.put(cache_key, result, deps, generation, since)
+                if loads_code(query_text):
+                    query_cache.clear(cache_scope(context))
+                if not instance and changes_database:
+                    await kb_resources.notify_all_updated()
+                if repro:
+                    result = await attach_repro_bundle(context, *repro, result)
+                return result
             except Exception as session_error:
                 logger.warning(f"Persistent session failed: {session_error}")
                 logger.info("Falling back to direct execution mode")
@@ -507,6 +3309,7 @@ async def execute_prolog_query(
             # For queries with variables, we need to format output specially
             if any(c.isupper() for c in clean_query):  # Has variables
                 prolog_cmd = f"""
+                call_with_time_limit({limits.wall_seconds}, (
                 (   {clean_query[:-1]},
                     term_variables({clean_query[:-1]}, Vars),
                     copy_term({clean_query[:-1]}, Term),
@@ -514,38 +3317,30 @@ async def execute_prolog_query(
                     writeq(solution(Term)), nl,
                     fail
                 ;   write('no_more_solutions'), nl
-                ), halt.
+                ))), halt.
                 """
             else:  # No variables, just test success/failure
                 prolog_cmd = f"""
+                call_with_time_limit({limits.wall_seconds}, (
                 (   {clean_query[:-1]} ->
                     write('success'), nl
                 ;   write('failure'), nl
-                ), halt.
+                ))), halt.
                 """
 
-            # Execute the command in the container
-            cmd = [
-                "docker", "exec", context.container_name,
-                "swipl", "-g", prolog_cmd, "-t", "halt"
-            ]
-
-            # Run the command asynchronously
-            process = await asyncio.create_subprocess_exec(
-                *cmd,
-                stdout=asyncio.subprocess.PIPE,
-                stderr=asyncio.subprocess.PIPE
-            )
-
-            stdout, stderr = await asyncio.wait_for(
-                process.communicate(), timeout=timeout
+            # Execute the command in the container through the Docker API
+            returncode, stdout, stderr = await exec_in_container(
+                context.docker_client,
+                context.container_name,
+                ["swipl", "-g", prolog_cmd, "-t", "halt"],
+                timeout=limits.wall_seconds + 5
             )
 
             # Process the output
-            output = stdout.decode('utf-8').strip()
-            error_output = stderr.decode('utf-8').strip()
+            output = stdout.strip()
+            error_output = stderr.strip()
 
-            if process.returncode != 0:
+            if returncode != 0:
                 if error_output:
                     return f"❌ Prolog Error in query '{clean_query}': {error_output}"
                 else:
@@ -583,329 +3378,6477 @@ async def execute_prolog_query(
                 return f"✅ Query: {clean_query}\n📋 Result: Query completed successfully (direct execution)"
 
         except asyncio.TimeoutError:
-            return f"⏱️ Query timed out after {timeout} seconds"
+            return f"⏱️ Query timed out after {limits.wall_seconds:g} seconds"
+        except ContainerExecError as e:
+            return error_result(e, "Could not run the query in the container")
         except Exception as e:
             logger.error(f"Direct execution failed: {e}")
-            return f"❌ Failed to execute query via both persistent session and direct execution: {e}"
+            return error_result(e, "Failed to execute query via both persistent session and direct execution")
 
     except Exception as e:
         logger.error(f"Failed to execute Prolog query: {e}")
-        return f"❌ Failed to execute query: {e}"
+        return error_result(e, "Failed to execute query")
 
 
 @mcp.tool()
-async def create_prolog_file(
-    filename: str,
-    content: str,
-    overwrite: bool = False
-) -> str:
+async def cancel_query(query_id: str = "", output_format: str = "text") -> str:
     """
-    Create a Prolog knowledge base file in the SWISH data directory.
+    Stop a query execute_prolog_query is running, leaving other queries and sessions alone.
 
-    Use this to create logic programs, facts, and rules that can be
-    consulted and used in Prolog queries.
+    A query in the persistent session is interrupted, and the session keeps
+    its state; an isolated query's pengine is aborted, or its swipl process
+    killed. The call running it returns a "cancelled" error. Cancelling the
+    MCP request of that call (notifications/cancelled) does the same.
 
     Args:
-        filename: Name of the .pl file (without extension)
-        content: Prolog code content (facts, rules, predicates)
-        overwrite: Whether to overwrite existing file
+        query_id: Id of the query, as listed by cancel_query() without one
+            or named in stream mode's progress notifications; "" lists the
+            running queries
+        output_format: "text" or "json"
 
     Returns:
-        Status message with instructions on how to use the file
+        Confirmation, or the running queries
     """
     try:
-        context = get_context()
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        key = current_api_key()
+        everyone = key is None or key.allows("admin")
+        client_id = current_client_id()
+        if not query_id:
+            running = running_queries.visible(client_id, everyone)
+            if output_format == "json":
+                return json.dumps({"running": [
+                    {
+                        "query_id": q.query_id, "goal": q.goal, "where": q.where, "instance": q.instance,
+                        "client": q.client_id, "seconds": round(time.time() - q.started, 1),
+                    }
+                    for q in running
+                ]}, indent=2)
+            if not running:
+                return "📭 No queries running"
+            return "\n".join(["🏃 Running queries:", *(f"  • {q.describe()}" for q in running)])
+
+        query = running_queries.cancel(query_id, client_id, everyone)
+        if output_format == "json":
+            return json.dumps({"cancelled": query.query_id, "goal": query.goal, "where": query.where})
+        return f"🛑 Cancelled {query.query_id} ({query.goal}) after {time.time() - query.started:.1f}s"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to cancel query: {e}")
+        return error_result(e, "Failed to cancel query")
 
-        # Ensure filename has .pl extension
-        if not filename.endswith('.pl'):
-            filename = f"{filename}.pl"
 
-        # Create file path in swish-data directory (mounted as /data in container)
-        file_path = context.data_dir / filename
-        file_path.parent.mkdir(parents=True, exist_ok=True)
+@mcp.tool()
+async def fact_feed_subscribe(predicate: str, pattern: str = "", instance: str = "") -> str:
+    """
+    Watch a dynamic predicate and be notified whenever a fact is asserted to or retracted from it.
 
-        # Check if file exists
-        if file_path.exists() and not overwrite:
-            return f"❌ File '{filename}' already exists. Use overwrite=True to replace."
+    Changes made by any query, tool or scheduled job are numbered and
+    kept by the feed. The client creating the feed gets each one as a
+    logging notification (logger "fact-feed"); any client can subscribe
+    to the feed's swish://feeds/{feed_id} resource for resources/updated.
+    fact_feed_events returns the changes after a sequence number.
 
-        # Write Prolog content
-        with open(file_path, 'w', encoding='utf-8') as f:
-            f.write(content)
+    Args:
+        predicate: Name/Arity of the predicate, e.g. "alert/2"; one that
+            does not exist yet is declared dynamic
+        pattern: Only report facts unifying with this head, e.g.
+            "alert(high, _)"; "" reports every fact
+        instance: Cluster instance or workspace to watch (default: primary)
 
-        logger.info(f"Created Prolog file: {file_path}")
+    Returns:
+        The feed id and resource URI
+    """
+    try:
+        context = get_context(instance)
+        if not context.container_ready:
+            return NOT_READY
+        try:
+            session = mcp.get_context().session
+        except ValueError:
+            session = None
+        feed = context.fact_feeds.create(predicate, client_module(), pattern, current_client_id(), session)
+        try:
+            rows = await run_json_helper(context, feed.watch_call())
+        except Exception:
+            context.fact_feeds.remove(feed.feed_id)
+            raise
+        feed.predicate = rows[0]["predicate"] if rows else feed.indicator
+        created = bool(rows and rows[0].get("created"))
+        lines = [
+            f"📡 Fact feed {feed.feed_id} watches {feed.predicate}" + (f" for {feed.pattern}" if feed.pattern else ""),
+            f"🔗 Subscribe to {feed.uri} for resources/updated; this client gets each change as a log message",
+        ]
+        if created:
+            lines.append(f"🆕 {feed.indicator} did not exist and was declared dynamic")
+        lines.append(f"💡 Next: fact_feed_events(feed_id=\"{feed.feed_id}\")")
+        return "\n".join(lines)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create fact feed: {e}")
+        return error_result(e, "Failed to create fact feed")
 
-        # Get the basename without extension for consulting
-        base_name = filename[:-3] if filename.endswith('.pl') else filename
 
-        return f"""✅ Created Prolog file: {filename}
-📁 Path: {file_path}
-📝 Size: {len(content)} characters
-🔄 To use this knowledge base, run: ?- consult({base_name}).
+@mcp.tool()
+async def fact_feed_events(feed_id: str = "", since: int = 0, output_format: str = "text", instance: str = "") -> str:
+    """
+    Get the changes a fact feed saw after a sequence number, or list the feeds.
 
-💡 Example queries after consulting:
-   - List all predicates: ?- current_predicate(F/A).
-   - Get help: ?- help({base_name}).
-"""
+    Args:
+        feed_id: Feed from fact_feed_subscribe; "" lists the feeds
+        since: Only changes numbered after this; pass the last one seen
+            to catch up on missed notifications
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace of the feed (default: primary)
 
+    Returns:
+        The changes, each one "asserted" or "retracted" with the fact
+    """
+    try:
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        context = get_context(instance)
+        if not feed_id:
+            feeds = list(context.fact_feeds.feeds.values())
+            if output_format == "json":
+                return json.dumps({"feeds": [
+                    {
+                        "feed_id": f.feed_id, "predicate": f.predicate, "pattern": f.pattern,
+                        "last_seq": f.last_seq, "uri": f.uri, "client": f.client_id,
+                    }
+                    for f in feeds
+                ]}, indent=2)
+            if not feeds:
+                return "📭 No fact feeds; create one with fact_feed_subscribe"
+            return "\n".join(["📡 Fact feeds:", *(f"  • {f.describe()}" for f in feeds)])
+
+        feed = context.fact_feeds.get(feed_id)
+        events = feed.since(since)
+        if output_format == "json":
+            return feed_document(feed, events)
+        return format_feed_events(feed, events, since)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
     except Exception as e:
-        logger.error(f"Failed to create Prolog file: {e}")
-        return f"❌ Failed to create file: {e}"
+        logger.error(f"Failed to read fact feed: {e}")
+        return error_result(e, "Failed to read fact feed")
 
 
 @mcp.tool()
-async def list_prolog_files() -> str:
+async def fact_feed_unsubscribe(feed_id: str, instance: str = "") -> str:
     """
-    List all Prolog files in the SWISH data directory.
+    Stop a fact feed, removing its hook from the predicate.
 
-    Shows available knowledge bases that can be consulted.
+    Args:
+        feed_id: Feed from fact_feed_subscribe
+        instance: Cluster instance or workspace of the feed (default: primary)
 
     Returns:
-        List of available Prolog files with sizes and usage instructions
+        Confirmation
     """
     try:
-        context = get_context()
-        data_path = context.data_dir
+        context = get_context(instance)
+        feed = context.fact_feeds.remove(feed_id)
+        kb_resources.subscribers.pop(feed.uri, None)
+        if context.prolog_session and context.prolog_session.session_active:
+            await run_json_helper(context, feed.unwatch_call())
+        return f"🔕 Stopped fact feed {feed.feed_id} on {feed.predicate} after {feed.last_seq} change(s)"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to stop fact feed: {e}")
+        return error_result(e, "Failed to stop fact feed")
 
-        if not data_path.exists():
-            return "📁 No data directory found. Create some Prolog files first with create_prolog_file()."
 
-        # Find all .pl files
-        prolog_files = list(data_path.glob("*.pl"))
+NO_FACT_STORE = "❌ No fact store; set SWISH_MCP_FACT_STORE to sqlite:///path/facts.db or redis://host:6379/0"
 
-        if not prolog_files:
-            return """📁 No Prolog files found in data directory.
 
-💡 Create your first knowledge base:
-   create_prolog_file("example", "
-   % Facts
-   parent(tom, bob).
-   parent(bob, ann).
+@mcp.tool()
+async def fact_store_attach(predicate: str) -> str:
+    """
+    Keep a dynamic predicate's facts in the shared fact store (SWISH_MCP_FACT_STORE).
+
+    The facts then survive session and container restarts and are shared
+    by every workspace, and every server using the same store. Each
+    session loads the stored facts on its next query, replacing the ones
+    it has; a predicate with nothing stored yet keeps them and they are
+    stored. From then on asserts and retracts are written to the store.
+
+    Args:
+        predicate: Name/Arity, e.g. "seen/2"; one that does not exist yet is declared dynamic
+
+    Returns:
+        Confirmation
+    """
+    try:
+        if fact_store is None:
+            return NO_FACT_STORE
+        key = await asyncio.to_thread(fact_store.attach, predicate)
+        return f"🗄️ {key} is kept in {fact_store.backend.description}; sessions load it on their next query"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to attach predicate to the fact store: {e}")
+        return error_result(e, "Failed to attach predicate to the fact store")
+
+
+@mcp.tool()
+async def fact_store_detach(predicate: str, drop: bool = False) -> str:
+    """
+    Stop keeping a predicate in the shared fact store.
+
+    Sessions keep the facts they have, but their changes are no longer
+    written to the store.
+
+    Args:
+        predicate: Name/Arity of a stored predicate
+        drop: Also delete its facts from the store
+
+    Returns:
+        Confirmation, with the number of facts deleted
+    """
+    try:
+        if fact_store is None:
+            return NO_FACT_STORE
+        key, dropped = await asyncio.to_thread(fact_store.detach, predicate, drop)
+        root = get_context()
+        for context in (root, *root.instances.values(), *root.workspaces.values()):
+            for loaded in [loaded for loaded in context.stored_facts.loaded if loaded[1] == key]:
+                del context.stored_facts.loaded[loaded]
+            if context.prolog_session and context.prolog_session.session_active:
+                await run_json_helper(context, store_unwatch_call(key))
+        deleted = f"; deleted its {dropped} stored fact(s)" if drop else "; its stored facts are kept"
+        return f"🗄️ {key} is no longer kept in the fact store{deleted}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to detach predicate from the fact store: {e}")
+        return error_result(e, "Failed to detach predicate from the fact store")
+
+
+@mcp.tool()
+async def fact_store_status(output_format: str = "text", instance: str = "") -> str:
+    """
+    Show the predicates kept in the shared fact store, and whether this session has loaded them.
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace whose session to report on (default: primary)
+
+    Returns:
+        Each stored predicate with its fact count and version
+    """
+    try:
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if fact_store is None:
+            return NO_FACT_STORE
+        context = get_context(instance)
+        if output_format == "json":
+            return json.dumps(await asyncio.to_thread(fact_store.status), indent=2)
+        return await asyncio.to_thread(format_store_status, fact_store, context.stored_facts, client_module())
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to read the fact store: {e}")
+        return error_result(e, "Failed to read the fact store")
+
+
+@mcp.tool()
+async def trace_query(
+    query: str,
+    max_depth: int = 10,
+    max_ports: int = 500,
+    output_format: str = "text",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Run a query under the SWI-Prolog tracer and return its call/exit/redo/fail trace.
+
+    Use this to find out why a rule does not fire: the trace shows every
+    call, which ones exited, which were retried, and which failed. The
+    query runs until its first solution.
+
+    Args:
+        query: Prolog query to trace
+        max_depth: Deepest call level to trace; calls at this depth run
+            without tracing their subgoals
+        max_ports: Stop recording after this many port events
+        output_format: "text" for a tracer-style listing, or "json" for the
+            call tree (nodes with goal, predicate, outcome, ports, children)
+        timeout: Wall-clock limit in seconds
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        The trace and the query's outcome
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.prolog_session is None:
+            return "❌ Tracing requires the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if not query.strip():
+            return "❌ Empty query provided"
+
+        policy = sandbox_policy()
+        check_text(query, policy)
+        require_unrestricted(policy, "call", "traced queries")
+
+        limits = server_config.limits.override(timeout, None, None)
+        ports: list[dict[str, Any]] = []
+        output: list[str] = []
+        solution: str | None = None
+        error: str | None = None
+        truncated = False
+        changes_database = uses_category(query, DATABASE_CATEGORY)
+        module = client_module()
+        async with audited_database(context, "trace_query", clean_query_text(query), changes_database, module):
+            try:
+                async for event in context.prolog_session.trace_query(
+                    in_module(clean_query_text(query), module), limits, max(1, max_depth), max(1, max_ports), safe=policy.mode == "strict"
+                ):
+                    if event["type"] == "trace" and event.get("truncated"):
+                        truncated = True
+                    elif event["type"] == "trace":
+                        ports.append(event["port"])
+                    elif event["type"] == "solution":
+                        solution = event["text"]
+                    elif event["type"] == "output":
+                        output.append(event["text"])
+                    else:
+                        error = event["error"]
+            except asyncio.TimeoutError:
+                error = "session_timeout"
+
+        tree = build_trace_tree(ports)
+        clean_query = clean_query_text(query) + "."
+
+        typed_error = from_prolog(error, clean_query_text(query)) if error is not None else None
+        if output_format == "json":
+            return json.dumps({
+                "query": clean_query,
+                "success": solution is not None,
+                "solution": solution,
+                "error": typed_error.to_json() if typed_error else None,
+                "truncated": truncated,
+                "ports": len(ports),
+                "tree": [node.to_dict() for node in tree],
+                "output": output,
+            }, indent=2)
+
+        if error == "session_timeout":
+            outcome = f"⏱️ Query did not respond within {limits.wall_seconds:g} seconds; the Prolog session was reset"
+        elif error is not None:
+            outcome = describe_limit_error(error, limits) or f"💥 Error: {error}"
+        elif solution is not None:
+            outcome = f"✅ Succeeded: {solution}"
+        else:
+            outcome = "❌ Failed (no solutions)"
+
+        failures = failed_calls(tree)
+        failure_note = ""
+        if failures and solution is None:
+            listed = "\n".join(f"   • {node.goal} ({node.outcome})" for node in failures[:10])
+            failure_note = f"\n\n🔍 Calls that failed, deepest first:\n{listed}"
+        truncated_note = f"\n\n✂️ Trace truncated after {max_ports} ports; raise max_ports to see more" if truncated else ""
+        printed = f"\n\n🖨️ Output:\n{chr(10).join(output)}" if output else ""
+        error_tag = f"\n{typed_error.tag()}" if typed_error else ""
+
+        return f"""🔬 Trace of: {clean_query}
+{format_trace(ports) or "(no traced calls)"}
+
+{outcome}{failure_note}{truncated_note}{printed}{error_tag}"""
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to trace query: {e}")
+        return error_result(e, "Failed to trace query")
+
+
+@mcp.tool()
+async def profile_query(
+    query: str,
+    top: int = 20,
+    sort_by: str = "inclusive",
+    output_format: str = "json",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Run a query under SWI-Prolog's profiler and report where its time went.
+
+    The query runs once, until its first solution, in the persistent
+    session. For each predicate that ran the profile gives its call, redo
+    and fail counts and the CPU time spent in its own clauses (exclusive)
+    and including the predicates it called (inclusive). A query that
+    raises or hits its time limit is profiled up to that point.
+
+    Args:
+        query: Prolog query to profile
+        top: Number of predicates to report
+        sort_by: "inclusive" or "exclusive" time, or "calls"
+        output_format: "json" for the entries and the profiler's summary,
+            or "text" for a table
+        timeout: Wall-clock limit in seconds
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        The top predicates with their call counts and times
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.prolog_session is None:
+            return "❌ Profiling requires the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if sort_by not in SORT_KEYS:
+            return f"❌ Unknown sort_by '{sort_by}'. Use: {', '.join(SORT_KEYS)}"
+        if not query.strip():
+            return "❌ Empty query provided"
+
+        query_text = clean_query_text(query)
+        goal = in_module(apply_policy(query_text, sandbox_policy()), client_module())
+        limits = server_config.limits.override(timeout, None, None)
+        changes_database = uses_category(query, DATABASE_CATEGORY)
+        async with audited_database(context, "profile_query", query_text, changes_database, client_module()):
+            try:
+                rows = await run_json_helper(context, profile_call(goal, limits), limits)
+            except RuntimeError as e:
+                return error_result(e, f"Could not profile {query_text}.")
+        report = rows[0] if rows else {}
+        entries = profile_entries(report, sort_by, top)
+        typed_error = from_prolog(report["error"], query_text) if report.get("outcome") == "error" else None
+
+        if output_format == "text":
+            error_tag = f"\n{typed_error.tag()}" if typed_error else ""
+            return format_profile(query_text, report, entries, sort_by) + error_tag
+        return json.dumps({
+            "query": f"{query_text}.",
+            "outcome": report.get("outcome"),
+            "bindings": report.get("bindings"),
+            "error": typed_error.to_json() if typed_error else None,
+            "summary": report.get("summary", {}),
+            "sort_by": sort_by,
+            "predicates": [entry.to_json() for entry in entries],
+        }, indent=2)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to profile query: {e}")
+        return error_result(e, "Failed to profile query")
+
+
+@mcp.tool()
+async def repl_send(
+    input: str,
+    reset: bool = False,
+    output_format: str = "text",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Type a line at a persistent ?- prompt and get the toplevel's reply.
+
+    Unlike execute_prolog_query, answers come one at a time as at a real
+    prompt, and the toplevel keeps Prolog flags, global variables and
+    operator definitions between calls. Send ";" for the next answer and
+    "." to accept the last; a new query abandons a pending one.
+
+    Args:
+        input: A query or ":- directive" (the trailing "." is optional),
+            ";" for the next answer, or "." to stop
+        reset: Discard this client's toplevel first, with its flags,
+            global variables and pending answers
+        output_format: "text" for toplevel-style output, or "json" for the
+            reply (kind, text, more, message) and printed output
+        timeout: Wall-clock limit in seconds for this step
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        The answer, false, or the error, as the toplevel prints it
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.prolog_session is None:
+            return "❌ The toplevel requires the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        alias = repl_alias(current_client_id())
+        limits = server_config.limits.override(timeout, None, None)
+        module = client_module()
+        kind, goal = parse_input(input)
+        if reset:
+            await run_json_helper(context, ("mcp_repl_send", [alias, "reset", limits.to_prolog()]), limits)
+            if kind == "stop":
+                return format_reply(ReplReply(kind="reset"), [])
+        if kind == "query":
+            goal = vetted_goal(goal, sandbox_policy())
+
+        reply: ReplReply | None = None
+        output: list[str] = []
+        request = repl_request(kind, goal, module)
+        if kind == "query":
+            await load_stored_facts(context)
+        changes_database = kind == "query" and uses_category(goal, DATABASE_CATEGORY)
+        async with audited_database(context, "repl_send", goal, changes_database, module):
+            try:
+                async for event in context.prolog_session.run_helper(
+                    "mcp_repl_send", [alias, request, limits.to_prolog()], limits
+                ):
+                    if event["type"] == "solution":
+                        reply = ReplReply.from_json(event["bindings"])
+                    elif event["type"] == "output":
+                        output.append(event["text"])
+                    else:
+                        reply = ReplReply(kind="error", message=describe_limit_error(event["error"], limits) or event["error"])
+            except asyncio.TimeoutError:
+                reply = ReplReply(
+                    kind="error",
+                    message=f"No reply within {limits.wall_seconds:g} seconds; the Prolog session and its toplevels were reset"
+                )
+        if reply is None:
+            reply = ReplReply(kind="error", message="The toplevel did not reply")
+
+        if output_format == "json":
+            return json.dumps({**reply.to_json(), "output": output}, indent=2)
+        return format_reply(reply, output)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to send to the toplevel: {e}")
+        return error_result(e, "Failed to send to the toplevel")
+
+
+async def wait_engine_job(context: SwishContext, alias: str, job: str, seconds: float) -> EngineJob:
+    """Poll an engine job until it is done or seconds have passed; its last state."""
+    deadline = time.monotonic() + seconds
+    delay = POLL_START
+    while True:
+        rows = await run_json_helper(context, poll_call(alias, job))
+        state = EngineJob.from_row(rows[0])
+        if state.done or time.monotonic() >= deadline:
+            return state
+        await asyncio.sleep(delay)
+        delay = min(delay * 2, POLL_MAX)
+
+
+def format_engine_job(state: EngineJob, alias: str, output_format: str) -> str:
+    if output_format == "json":
+        return json.dumps({"engine": alias, **state.to_json()}, indent=2)
+    text = state.describe(alias)
+    if not state.done:
+        text += f"\n💡 Collect the result with engine_result(job=\"{state.job}\")"
+    return text
+
+
+@mcp.tool()
+async def engine_query(
+    goal: str,
+    wait: bool = True,
+    max_solutions: int = 100,
+    timeout: int | None = None,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Run a goal on this MCP session's own Prolog thread in the persistent session.
+
+    Goals of different sessions run at the same time, and a slow one does
+    not hold up execute_prolog_query; this session's goals run one after
+    another on its thread. The thread sees the session's clauses and
+    loaded files but has its own flags and global variables. Changes
+    made on it are not in the audit log.
+
+    Args:
+        goal: Prolog goal to run
+        wait: Wait for the result; False returns a job id for engine_result()
+        max_solutions: Maximum solutions collected
+        timeout: Wall-clock limit in seconds
+        output_format: "text" or "json" (solutions as typed values, as for execute_prolog_query)
+        instance: Cluster instance or workspace to run on
+
+    Returns:
+        The goal's solutions, or the job id when not waiting
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.prolog_session is None:
+            return "❌ Engine threads require the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        goal = clean_query_text(goal)
+        if not goal:
+            return "❌ Empty goal provided"
+
+        limits = server_config.limits.override(timeout, None, None)
+        goal = vetted_goal(goal, sandbox_policy())
+        alias = engine_alias(current_client_id())
+        job = new_job_id()
+        await load_stored_facts(context)
+        await run_json_helper(context, submit_call(alias, job, goal, client_module(), limits.to_prolog(), max_solutions))
+        if not wait:
+            return f"🧵 Job {job} queued on {alias}\n💡 Collect the result with engine_result(job=\"{job}\")"
+        state = await wait_engine_job(context, alias, job, limits.wall_seconds + WAIT_GRACE)
+        return format_engine_job(state, alias, output_format)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run the goal on an engine thread: {e}")
+        return error_result(e, "Failed to run the goal on an engine thread")
+
+
+@mcp.tool()
+async def engine_result(
+    job: str,
+    wait: bool = False,
+    timeout: int | None = None,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Collect the result of a goal engine_query(wait=False) queued on this session's thread.
+
+    A finished job's result is returned once, then forgotten.
+
+    Args:
+        job: Job id returned by engine_query
+        wait: Wait for the job to finish instead of reporting its state
+        timeout: Seconds to wait at most (default: SWISH_MCP_QUERY_TIMEOUT)
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace the job runs on
+
+    Returns:
+        The job's solutions, or whether it is still queued or running
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        alias = engine_alias(current_client_id())
+        seconds = server_config.limits.override(timeout, None, None).wall_seconds if wait else 0
+        state = await wait_engine_job(context, alias, job.strip(), seconds)
+        return format_engine_job(state, alias, output_format)
+
+    except Exception as e:
+        logger.error(f"Failed to collect the engine job: {e}")
+        return error_result(e, "Failed to collect the engine job")
+
+
+@mcp.tool()
+async def engine_list(output_format: str = "text", instance: str = "") -> str:
+    """
+    List the Prolog engine threads of MCP sessions with their status.
+
+    Shows each thread's state, CPU time, queued jobs, results not yet
+    collected and the goal it is running. Only this session's thread is
+    shown unless the API key has the admin scope.
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace whose threads to list
+
+    Returns:
+        The engine threads
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        own = engine_alias(current_client_id())
+        key = current_api_key()
+        everyone = key is None or key.allows("admin")
+        rows = await run_json_helper(context, list_call())
+        if not everyone:
+            rows = [row for row in rows if row["engine"] == own]
+        if output_format == "json":
+            return json.dumps({"engine": own, "engines": rows}, indent=2)
+        return format_engines(rows, own)
+
+    except Exception as e:
+        logger.error(f"Failed to list engine threads: {e}")
+        return error_result(e, "Failed to list engine threads")
+
+
+@mcp.tool()
+async def engine_stop(close: bool = False, instance: str = "") -> str:
+    """
+    Interrupt the goal running on this session's engine thread.
+
+    The interrupted job ends with an error; queued jobs then run as
+    usual. close=True also drops the queued jobs and ends the thread,
+    which engine_query starts again when next used.
+
+    Args:
+        close: End the thread as well
+        instance: Cluster instance or workspace of the thread
+
+    Returns:
+        What was stopped
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        alias = engine_alias(current_client_id())
+        rows = await run_json_helper(context, stop_call(alias, close))
+        interrupted = bool(rows and rows[0].get("interrupted"))
+        parts = ["interrupted its running goal" if interrupted else "had no running goal"]
+        if close:
+            parts.append("closed it")
+        return f"🛑 {alias}: {' and '.join(parts)}"
+
+    except Exception as e:
+        logger.error(f"Failed to stop the engine thread: {e}")
+        return error_result(e, "Failed to stop the engine thread")
+
+
+@mcp.tool()
+async def execute_queries_concurrently(
+    queries: list[str],
+    src_text: str = "",
+    max_solutions: int = 100,
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Run independent queries at the same time, each on its own pengine.
+
+    Queries go through the worker pool, which limits how many run at once
+    (SWISH_MCP_WORKERS) and per client (SWISH_MCP_WORKERS_PER_CLIENT), so
+    a batch neither overloads the container nor starves other clients.
+    They do not see the persistent session; pass the program they need
+    as src_text.
+
+    Args:
+        queries: Prolog queries to run
+        src_text: Prolog clauses loaded into every query's pengine
+        max_solutions: Maximum solutions returned per query
+        timeout: Wall-clock limit per query in seconds
+        instance: Cluster instance or workspace to run on
+
+    Returns:
+        One result section per query, in the order given
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not queries:
+            return "❌ No queries provided"
+
+        policy = sandbox_policy()
+        check_text(src_text, policy)
+        require_unrestricted(policy, "call", "concurrent queries")
+        for query in queries:
+            check_text(query, policy)
+
+        limits = server_config.limits.override(timeout, None, None)
+        results = await asyncio.gather(
+            *(run_isolated_query(context, q, limits, src_text, max_solutions) for q in queries),
+            return_exceptions=True
+        )
+
+        sections = []
+        for i, (query, result) in enumerate(zip(queries, results), 1):
+            if isinstance(result, WorkerPoolError):
+                result = error_result(result, fallback="resource_limit")
+            elif isinstance(result, BaseException):
+                result = error_result(result, f"Query: {query}")
+            sections.append(f"[{i}] {result}")
+
+        status = context.workers.get_status()
+        return "\n\n".join(sections) + (
+            f"\n\n👷 Ran {len(queries)} queries on up to {status['max_concurrency']} workers "
+            f"({status['max_per_client']} per client)"
+        )
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run concurrent queries: {e}")
+        return error_result(e, "Failed to run concurrent queries")
+
+
+@mcp.tool()
+async def query_map(
+    goal: str,
+    bindings: list[dict[str, Any]],
+    src_text: str = "",
+    files: list[str] | None = None,
+    max_solutions: int = 10,
+    timeout: int | None = None,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Evaluate one goal for each of a list of bindings, in parallel on the worker pool.
+
+    Each binding gives values for variables of the goal, e.g. goal
+    "eligible(Person, Plan)" with [{"Person": "alice"}, {"Person": "bob"}].
+    Text values are atoms ({"string": "..."} for strings); numbers,
+    booleans and lists are written as such. Items run on isolated
+    pengines, which do not see the persistent session: pass the program
+    as src_text or files. One item's error or timeout fails only that item.
+
+    Args:
+        goal: Goal template using the bound variables
+        bindings: Values of the goal's variables, one object per item
+        src_text: Prolog clauses loaded into every item's pengine
+        files: Data-directory .pl files loaded into every item's pengine too
+        max_solutions: Maximum solutions returned per item
+        timeout: Wall-clock limit per item in seconds
+        output_format: "text" for one line per item, or "json"
+        instance: Cluster instance or workspace to run on
+
+    Returns:
+        The result of every binding, in the order given
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        goal = clean_query_text(goal)
+        if not goal:
+            return "❌ Empty goal provided"
+        if not bindings:
+            return "❌ No bindings provided"
+
+        items = build_items(goal, bindings)
+        program = [program_file(context, name).read_text(encoding="utf-8") for name in files or []]
+        if src_text.strip():
+            program.append(src_text)
+        source = "\n".join(program)
+        policy = sandbox_policy()
+        check_text(source, policy)
+        require_unrestricted(policy, "call", "query_map")
+        check_text(goal, policy)
+
+        limits = server_config.limits.override(timeout, None, None)
+        strategy = execution_strategy(context)
+        status = context.workers.get_status()
+        # No more than the client's share at once, so a long list waits here instead of flooding the pool's queue
+        window = asyncio.Semaphore(status["max_per_client"])
+        done = 0
+
+        async def run(item: MapItem) -> None:
+            nonlocal done
+            if not item.goal:
+                return
+            async with window:
+                async def job() -> dict[str, Any]:
+                    started = time.monotonic()
+                    answer = await strategy.run_once(item.goal, source, max_solutions, timeout=limits.wall_seconds + 5)
+                    solutions = len(answer.get("data", [])) if answer.get("event") == "success" else 0
+                    error = None if answer.get("event") in ("success", "failure") else str(answer.get("event"))
+                    metrics.observe_query("isolated", query_outcome(error, solutions), time.monotonic() - started, solutions)
+                    return answer
+                try:
+                    item.record(await context.workers.submit(current_client_id(), job))
+                except asyncio.TimeoutError:
+                    item.error = f"did not finish within {limits.wall_seconds:g} seconds"
+                except (WorkerPoolError, SwishUnavailable) as e:
+                    item.error = str(e)
+            done += 1
+            await report_progress(done, f"{done}/{len(items)} items")
+
+        await asyncio.gather(*(run(item) for item in items))
+
+        if output_format == "json":
+            return json.dumps({
+                "goal": goal, "items": [item.to_json() for item in items], "counts": summarize(items),
+            }, indent=2)
+        return format_items(items, status["max_per_client"])
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to map the goal: {e}")
+        return error_result(e, "Failed to map the goal")
+
+
+@mcp.tool()
+async def query_batch(
+    goals: list[str],
+    timeout: int | None = None,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Run several goals as one transaction in the persistent session.
+
+    The goals run in order, each once, inside SWI-Prolog's transaction/1:
+    if every goal succeeds their asserts and retracts are all kept; if one
+    fails or raises an error the batch stops there and none of them are.
+    Use this for multi-step knowledge base updates that must not be left
+    half done. Variables are not shared between goals, so steps that pass
+    a value on belong in one goal.
+
+    Args:
+        goals: Prolog goals, e.g. ["retract(stock(apple, N)), N1 is N - 1, assertz(stock(apple, N1))",
+            "assertz(sold(apple))"]
+        timeout: Wall-clock limit in seconds for the whole batch
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        Whether the batch was committed, and each goal's bindings or failure
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        cleaned = batch_goals(goals)
+        policy = sandbox_policy()
+        try:
+            runnable = [apply_policy(goal, policy) for goal in cleaned]
+        except SandboxViolation as e:
+            logger.warning(f"Sandbox ({policy.mode}) blocked batch from {current_client_id()}: {e}")
+            return error_result(e, fallback="invalid_argument")
+
+        limits = server_config.limits.override(timeout, None, None)
+        changes_database = any(uses_category(goal, DATABASE_CATEGORY) for goal in cleaned)
+        module = client_module()
+        runnable = [in_module(goal, module) for goal in runnable]
+        await load_stored_facts(context)
+        try:
+            async with audited_database(context, "query_batch", "; ".join(cleaned), changes_database, module):
+                rows = await run_json_helper(context, batch_call(runnable, limits.to_prolog()), limits)
+        except RuntimeError as e:
+            limit_message = describe_limit_error(str(e), limits)
+            if limit_message:
+                return f"{limit_message}; the batch was rolled back"
+            return error_result(e, "Batch failed before running")
+
+        result = BatchResult.from_rows(cleaned, rows)
+        if result.committed and changes_database and fact_store is not None:
+            # Changes inside the transaction were not mirrored as they happened
+            await fact_store.copy_from_session(context.stored_facts, module, lambda call: run_json_helper(context, call))
+        if result.committed and not instance and changes_database:
+            await kb_resources.notify_all_updated()
+        if output_format == "json":
+            return json.dumps(result.to_json(), indent=2)
+        return result.format()
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run query batch: {e}")
+        return error_result(e, "Failed to run query batch")
+
+
+@mcp.tool()
+async def schedule_query(
+    goal: str,
+    cron: str,
+    max_solutions: int = 100,
+    timeout: int | None = None,
+    run_now: bool = True
+) -> str:
+    """
+    Run a goal in the persistent session on a recurring schedule.
+
+    Each job's recent results are published as a swish://jobs/<id>
+    resource; subscribe to it to be notified when a run's solutions differ
+    from the previous run's, e.g. to watch for rule violations as facts
+    change. Scheduled goals may not change the knowledge base.
+
+    Args:
+        goal: Prolog goal, e.g. "overdue(Task)"
+        cron: Five-field cron expression in server local time, e.g. "*/5 * * * *",
+            or @hourly, @daily, @weekly, @monthly, @yearly
+        max_solutions: Solutions kept per run
+        timeout: Wall-clock limit in seconds for each run
+        run_now: Also run once immediately, recording a baseline
+
+    Returns:
+        The job id, its resource URI and next run time
+    """
+    try:
+        context = get_context()
+
+        clean_goal = clean_query_text(goal)
+        if not clean_goal:
+            return "❌ Empty goal provided"
+        if uses_category(clean_goal, DATABASE_CATEGORY):
+            return "❌ Scheduled queries may not change the knowledge base; schedule a query that only reads it"
+        policy = sandbox_policy()
+        try:
+            runnable = apply_policy(clean_goal, policy)
+        except SandboxViolation as e:
+            logger.warning(f"Sandbox ({policy.mode}) blocked scheduled query from {current_client_id()}: {e}")
+            return error_result(e, fallback="invalid_argument")
+
+        job = await scheduler.add(
+            clean_goal,
+            cron,
+            current_client_id(),
+            in_module(runnable, client_module()),
+            max(1, max_solutions),
+            timeout
+        )
+        message = f"""✅ Scheduled {job.job_id}: {clean_goal}
+⏰ Schedule: {job.cron} (next run {job.next_run_text})
+📡 Results: {job.uri} (subscribe to be notified of changes)"""
+        if run_now and context.container_ready:
+            run = await scheduler.run_job(job)
+            message += f"\n🏁 First run: {run.summary()}"
+        return message
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to schedule query: {e}")
+        return error_result(e, "Failed to schedule query")
+
+
+@mcp.tool()
+async def list_scheduled_queries(job_id: str = "") -> str:
+    """
+    List scheduled queries, or show one job's recent runs.
+
+    Args:
+        job_id: Job to show in detail; empty lists all jobs
+
+    Returns:
+        Jobs with their schedules and latest results
+    """
+    try:
+        if not job_id:
+            if not scheduler.jobs:
+                return "📭 No scheduled queries. Use schedule_query() to add one."
+            jobs = "\n".join(job.describe() for job in scheduler.jobs.values())
+            return f"⏰ Scheduled queries ({len(scheduler.jobs)}):\n{jobs}"
+
+        job = scheduler.jobs.get(job_id)
+        if job is None:
+            return f"❌ Unknown scheduled query '{job_id}'"
+        lines = [job.describe(), f"📡 {job.uri}"]
+        for run in reversed(job.runs):
+            lines.append(f"  • {run.summary()}")
+            for solution in run.solutions[:10]:
+                lines.append(f"      {solution}")
+            if len(run.solutions) > 10:
+                lines.append(f"      … {len(run.solutions) - 10} more")
+        return "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to list scheduled queries: {e}")
+        return error_result(e, "Failed to list scheduled queries")
+
+
+@mcp.tool()
+async def cancel_scheduled_query(job_id: str) -> str:
+    """
+    Stop a scheduled query and remove its resource.
+
+    Args:
+        job_id: Job id returned by schedule_query
+
+    Returns:
+        Confirmation or error message
+    """
+    try:
+        job = scheduler.jobs.get(job_id)
+        if job is None:
+            return f"❌ Unknown scheduled query '{job_id}'"
+        key = current_api_key()
+        if job.client != current_client_id() and key is not None and not key.allows("admin"):
+            return f"❌ {job_id} was scheduled by another client"
+        await scheduler.remove(job_id)
+        return f"🛑 Cancelled {job_id} ({job.goal}) after {len(job.runs)} run(s)"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to cancel scheduled query: {e}")
+        return error_result(e, "Failed to cancel scheduled query")
+
+
+@mcp.tool()
+async def template_register(
+    name: str,
+    goal: str,
+    params: dict[str, Any] | None = None,
+    description: str = "",
+    replace: bool = False
+) -> str:
+    """
+    Register a parameterized goal once, to be run with template_run by parameter values alone.
+
+    Write each parameter as {{name}} in the goal and give its type: atom,
+    string, integer, float, number, boolean or list[<type>]. Values are
+    checked against the type and quoted as Prolog literals, so they can
+    never change the shape of the goal.
+
+    Args:
+        name: Template name, e.g. "ancestors_of"
+        goal: Goal with placeholders, e.g. "ancestor({{person}}, Descendant)"
+        params: Type of each placeholder, e.g. {"person": "atom"}, or an
+            object such as {"type": "integer", "default": 10, "choices": [...],
+            "description": "..."}; a default makes the parameter optional
+        description: What the template is for
+        replace: Overwrite a template of the same name
+
+    Returns:
+        The template and how to run it
+    """
+    try:
+        template = QueryTemplate.build(name, goal, params or {}, description, current_client_id())
+        policy = sandbox_policy()
+        try:
+            apply_policy(template.sample(), policy)
+        except SandboxViolation as e:
+            logger.warning(f"Sandbox ({policy.mode}) blocked template from {current_client_id()}: {e}")
+            return error_result(e, fallback="invalid_argument")
+        query_templates.add(template, replace)
+        await asyncio.to_thread(query_templates.save)
+
+        values = ", ".join(f'"{param.name}": <{param.type}>' for param in template.params if param.required)
+        return f"""✅ Registered template {template.signature()}
+{template.describe()}
+▶️ Run with: template_run(name="{template.name}", params={{{values}}})"""
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to register template: {e}")
+        return error_result(e, "Failed to register template")
+
+
+@mcp.tool()
+async def template_run(
+    name: str = "",
+    params: dict[str, Any] | None = None,
+    timeout: int | None = None,
+    output_format: str = "text",
+    limit: int = 0,
+    instance: str = ""
+) -> str:
+    """
+    Run a registered query template with parameter values, or list the templates.
+
+    Args:
+        name: Template registered with template_register; empty lists templates
+        params: Value of each parameter, e.g. {"person": "alice", "min_age": 21}
+        timeout: Wall-clock limit in seconds
+        output_format: "text" or "json", as for execute_prolog_query
+        limit: Return only the first limit solutions, with a cursor for more
+        instance: Cluster instance or workspace to run in
+
+    Returns:
+        The query's solutions, or the templates with their parameters
+    """
+    try:
+        if not name:
+            if not query_templates.templates:
+                return "📭 No query templates. Register one with template_register()."
+            listing = "\n".join(template.describe() for template in query_templates.templates.values())
+            return f"📚 Query templates ({len(query_templates.templates)}):\n{listing}"
+
+        goal = query_templates.get(name).render(params or {})
+        return await execute_prolog_query(
+            f"{goal}.", timeout=timeout, output_format=output_format, limit=limit, instance=instance
+        )
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run template: {e}")
+        return error_result(e, "Failed to run template")
+
+
+@mcp.tool()
+async def template_delete(name: str) -> str:
+    """
+    Remove a registered query template.
+
+    Args:
+        name: Template name
+
+    Returns:
+        Confirmation or error message
+    """
+    try:
+        template = query_templates.get(name)
+        key = current_api_key()
+        if template.client != current_client_id() and key is not None and not key.allows("admin"):
+            return f"❌ Template '{name}' was registered by another client"
+        query_templates.remove(name)
+        await asyncio.to_thread(query_templates.save)
+        return f"🗑️ Removed template {template.signature()}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to delete template: {e}")
+        return error_result(e, "Failed to delete template")
+
+
+@mcp.tool()
+async def create_prolog_file(
+    filename: str,
+    content: str,
+    overwrite: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Create a Prolog knowledge base file in the SWISH data directory.
+
+    Use this to create logic programs, facts, and rules that can be
+    consulted and used in Prolog queries.
+
+    Args:
+        filename: Name of the .pl file (without extension)
+        content: Prolog code content (facts, rules, predicates)
+        overwrite: Whether to overwrite existing file
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        Status message with instructions on how to use the file
+    """
+    try:
+        context = get_context(instance)
+
+        # Ensure filename has .pl extension
+        if not filename.endswith('.pl'):
+            filename = f"{filename}.pl"
+
+        # Create file path in swish-data directory (mounted as /data in container)
+        file_path = context.data_dir / filename
+        file_path.parent.mkdir(parents=True, exist_ok=True)
+
+        # Check if file exists
+        if file_path.exists() and not overwrite:
+            return f"❌ File '{filename}' already exists. Use overwrite=True to replace."
+
+        check_text(content, sandbox_policy())
+        content = normalize_newlines(content)
+
+        # Write Prolog content
+        async with audited_files(context, "create_prolog_file", filename, [file_path]):
+            with open(file_path, 'w', encoding='utf-8', newline='\n') as f:
+                f.write(content)
+
+        logger.info(f"Created Prolog file: {file_path}")
+        if not instance:
+            await refresh_kb_resources()
+
+        # Get the basename without extension for consulting
+        base_name = filename[:-3] if filename.endswith('.pl') else filename
+
+        return f"""✅ Created Prolog file: {filename}
+📁 Path: {file_path}
+📝 Size: {len(content)} characters
+🔄 To use this knowledge base, run: ?- consult({base_name}).
+
+💡 Example queries after consulting:
+   - List all predicates: ?- current_predicate(F/A).
+   - Get help: ?- help({base_name}).
+"""
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create Prolog file: {e}")
+        return error_result(e, "Failed to create file")
+
+
+@mcp.tool()
+async def list_prolog_files(instance: str = "") -> str:
+    """
+    List all Prolog files in the SWISH data directory.
+
+    Shows available knowledge bases that can be consulted.
+
+    Args:
+        instance: Cluster instance or workspace whose data directory to list
+
+    Returns:
+        List of available Prolog files with sizes and usage instructions
+    """
+    try:
+        context = get_context(instance)
+        data_path = context.data_dir
+
+        if not data_path.exists():
+            return "📁 No data directory found. Create some Prolog files first with create_prolog_file()."
+
+        # Find all .pl files
+        prolog_files = list(data_path.glob("*.pl"))
+
+        if not prolog_files:
+            return """📁 No Prolog files found in data directory.
+
+💡 Create your first knowledge base:
+   create_prolog_file("example", "
+   % Facts
+   parent(tom, bob).
+   parent(bob, ann).
 
    % Rules
    grandparent(X, Z) :- parent(X, Y), parent(Y, Z).
    ")"""
 
-        file_info = []
-        for file_path in sorted(prolog_files):
+        file_info = []
+        for file_path in sorted(prolog_files):
+            try:
+                stat = file_path.stat()
+                size = stat.st_size
+                base_name = file_path.stem
+
+                file_info.append(f"  📄 {file_path.name} ({size} bytes)")
+                file_info.append(f"      💡 Load with: ?- consult({base_name}).")
+            except Exception:
+                file_info.append(f"  📄 {file_path.name}")
+
+        return f"""📚 Prolog Knowledge Bases in {data_path}:
+{chr(10).join(file_info)}
+
+🔄 After loading files, you can:
+   - Query facts: ?- parent(tom, bob).
+   - Test rules: ?- grandparent(tom, ann).
+   - List predicates: ?- current_predicate(F/A).
+"""
+
+    except Exception as e:
+        logger.error(f"Failed to list Prolog files: {e}")
+        return error_result(e, "Failed to list files")
+
+
+@mcp.tool()
+async def get_swish_status() -> str:
+    """
+    Get the current status of the SWISH container and Prolog environment.
+
+    Useful for troubleshooting and checking if the Prolog environment is ready.
+
+    Returns:
+        Detailed status information about the SWISH container and service
+    """
+    try:
+        context = get_context()
+
+        if context.backend == "local":
+            client = context.docker_client
+            session = context.prolog_session
+            session_state = "🟢 Active" if session and session.session_active else "🔴 Not running"
+            return f"""💻 Local SWI-Prolog backend (no container)
+
+🧠 SWI-Prolog: {client.engine}
+🧠 Persistent session: {session_state}
+📁 Data directory: {context.data_dir}
+
+Pengines, isolated queries and container tools need the SWISH container."""
+
+        if not context.docker_available:
+            return """⚠️ Docker is not available
+
+The MCP server is running but cannot manage containers.
+Please ensure Docker Desktop is running and accessible."""
+
+        if not context.container:
+            # Try to refresh container reference first
+            refresh_success = refresh_container_reference(context)
+            if not refresh_success:
+                return """❌ No SWISH container found
+
+Container failed to start during initialization or was removed.
+Try restarting the MCP server or check Docker status."""
+
+        try:
+            # Refresh container status - handle stale references
+            try:
+                context.container.reload()
+            except Exception as reload_error:
+                logger.warning(f"Container reload failed, trying to refresh reference: {reload_error}")
+                if not refresh_container_reference(context):
+                    return f"❌ Container reference is stale and could not be refreshed: {reload_error}"
+
+            status = context.container.status
+
+            # Check SWISH accessibility
+            swish_accessible = await swish_http(context).probe(timeout=3)
+
+            # Get basic container info
+            created = context.container.attrs.get('Created', 'Unknown')
+
+            # Get persistent session status
+            session_status = ""
+            if context.prolog_session:
+                session_info = context.prolog_session.get_status()
+                session_status = f"""
+🧠 Persistent Session: {'✅ Active' if session_info['active'] else '❌ Inactive'}
+📊 Queries Executed: {session_info['query_count']}
+📚 Consulted Files: {', '.join(session_info.get('consulted_files', [])) or 'None'}"""
+                startup = session_info.get('startup', [])
+                if startup:
+                    startup_failed = [result['item'] for result in startup if result.get('error')]
+                    session_status += f"\n🚀 Startup Programs: {len(startup) - len(startup_failed)}/{len(startup)} loaded" + (
+                        f" (failed: {', '.join(startup_failed)})" if startup_failed else ""
+                    )
+            else:
+                session_status = "\n🧠 Persistent Session: ⚠️ Not initialized"
+
+            workers = context.workers.get_status()
+            session_status += (
+                f"\n👷 Worker Pool: {workers['running']}/{workers['max_concurrency']} running, "
+                f"{workers['queued']} queued, {workers['completed']} completed"
+            )
+            if server_config.disk.quota or server_config.disk.reserve:
+                disk = await asyncio.to_thread(disk_monitor.usage, storage_areas(context))
+                session_status += f"\n💽 Disk: {format_bytes(disk.used)} used ({server_config.disk.describe()})"
+            http = swish_http(context).get_status()
+            session_status += f"\n🔌 SWISH HTTP: circuit {http['circuit']}, {http['retries']} retried request(s)"
+            execution = execution_strategy(context).get_status()
+            session_status += (
+                f"\n🚚 Execution: {execution['mode']}, using {execution['active']}"
+                + (f" ({execution['fallbacks']} fallback(s) to docker exec)" if execution['fallbacks'] else "")
+            )
+            if result_hooks:
+                session_status += f"\n🪝 Result hooks: {'; '.join(entry.describe() for entry in result_hooks.ordered())}"
+
+            return f"""📊 SWISH Prolog Environment Status
+
+🐳 Container: {context.container.name} ({context.container.id[:12]})
+⚙️ Runtime: {context.runtime.name if context.runtime else 'docker'}
+📦 Image: {context_image(context)}
+📊 Status: {status.upper()}
+🌐 URL: {context.swish_base_url}
+🚀 Service: {'✅ Ready for Prolog queries' if swish_accessible else '⚠️ Starting up...'}
+📅 Started: {created[:19] if 'T' in created else created}
+📁 Data: {context.data_dir}
+🔐 Environment: {context.environment.describe()}{session_status}
+
+💡 {'Ready to execute Prolog queries with persistent state!' if swish_accessible and context.prolog_session and context.prolog_session.get_status()['active'] else 'Container starting or session initializing, please wait...'}
+
+🧠 Available operations:
+   - execute_prolog_query("member(X, [1,2,3]).")
+   - create_prolog_file("my_kb", "fact(a). rule(X) :- fact(X).")
+   - list_prolog_files()
+"""
+
+        except Exception as e:
+            return error_result(e, "Error checking container status")
+
+    except Exception as e:
+        logger.error(f"Failed to get status: {e}")
+        return error_result(e, "Failed to get status")
+
+
+@mcp.tool()
+async def load_knowledge_base(filename: str, instance: str = "") -> str:
+    """
+    Load (consult) a Prolog knowledge base file into the SWISH session.
+
+    This makes the facts and rules in the file available for queries.
+    The file is loaded into a scratch module first; if that prints any
+    error, it is quarantined with its diagnostics (see kb_quarantine)
+    and the version loaded before stays live.
+
+    Args:
+        filename: Name of the .pl file to load (with or without extension)
+        instance: Cluster instance or workspace to load the file into
+
+    Returns:
+        Status of the loading operation
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        # Ensure filename has .pl extension for file checking
+        if not filename.endswith('.pl'):
+            check_filename = f"{filename}.pl"
+            consult_name = filename
+        else:
+            check_filename = filename
+            consult_name = filename[:-3]  # Remove .pl for consulting
+
+        # Check if file exists
+        file_path = context.data_dir / check_filename
+        if not file_path.exists():
+            return f"❌ File '{check_filename}' not found. Use list_prolog_files() to see available files."
+
+        # A carriage return in multi-line quoted text is a syntax error or a stray character
+        converted = ""
+        if await asyncio.to_thread(has_crlf, file_path):
+            text = normalize_newlines(file_path.read_bytes().decode("utf-8", errors="surrogateescape"))
+            async with audited_files(context, "load_knowledge_base", check_filename, [file_path]):
+                file_path.write_bytes(text.encode("utf-8", errors="surrogateescape"))
+            converted = "\n↩️ Converted its CRLF line endings to LF"
+
+        if context.prolog_session:
+            policy = sandbox_policy()
+            check_text(f"consult({consult_name})", policy)
+            require_unrestricted(policy, "assert", "Loading a knowledge base")
+            require_unrestricted(policy, "retract", "Loading a knowledge base")
+            module = client_module()
+            try:
+                if policy.mode == "strict":
+                    await run_json_helper(context, vet_call(f"{prolog_data_dir(context)}/{check_filename}"))
+                loaded, diagnostics = await safe_consult(context, check_filename, module)
+            except RuntimeError as e:
+                return error_result(e, "Could not load the knowledge base")
+            if not instance:
+                await kb_resources.notify_all_updated()
+            warnings = format_messages(diagnostics)
+            if not loaded:
+                return "\n".join([
+                    f"🚧 '{check_filename}' does not load; it is quarantined and the version loaded before stays live:",
+                    *warnings,
+                    f"\n💡 Fix the file and load it again{converted}",
+                ])
+            if warnings:
+                converted += "\n⚠️ Loaded with warnings:\n" + "\n".join(warnings)
+        else:
+            # Load the knowledge base using consult
+            consult_query = f"consult({consult_name})."
+            result = await execute_prolog_query(consult_query, instance=instance)
+            if "✅" not in result:
+                return f"⚠️ There may have been an issue loading the file:\n{result}"
+
+        return f"""✅ Knowledge base '{check_filename}' loaded successfully!
+
+📚 The facts and rules from {check_filename} are now available.
+💡 You can now query them directly, for example:
+   - List all facts: ?- current_predicate(F/A).
+   - Query specific facts from your knowledge base
+
+🔍 File loaded from: {file_path}{converted}
+"""
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to load knowledge base: {e}")
+        return error_result(e, "Failed to load knowledge base")
+
+
+@mcp.tool()
+async def kb_quarantine(output_format: str = "text", instance: str = "") -> str:
+    """
+    List the files whose last consult was refused because they do not load.
+
+    load_knowledge_base (and, with SWISH_MCP_AUTO_RELOAD=on, a change of
+    a loaded file on disk) loads a file into a scratch module first. A
+    file that prints errors there is quarantined: it is not consulted
+    and the version loaded before stays live until it loads cleanly.
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace
+
+    Returns:
+        Each quarantined file with the module it loads into, since when
+        it is quarantined and the errors of its last attempt
+    """
+    try:
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        context = get_context(instance)
+        if output_format == "json":
+            return json.dumps({"files": context.quarantine.status()}, indent=2)
+        return format_quarantine(context.quarantine)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to list quarantined files: {e}")
+        return error_result(e, "Failed to list quarantined files")
+
+
+def format_manifest(manifest: ProjectManifest) -> str:
+    """Render a project manifest with its load order."""
+    order = "\n".join(f"   {i}. {f}" for i, f in enumerate(manifest.files, 1)) or "   (no files yet)"
+    description = f"\n📝 {manifest.description}" if manifest.description else ""
+    return f"""📦 Project: {manifest.name}{description}
+🔢 Load order:
+{order}"""
+
+
+@mcp.tool()
+async def project_create(name: str, description: str = "", instance: str = "") -> str:
+    """
+    Create a multi-file knowledge base project.
+
+    A project is a folder in the data directory with a project.json
+    manifest listing its .pl files in load order.
+
+    Args:
+        name: Project name (letters, digits, '_' or '-')
+        description: Optional description stored in the manifest
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The new project's manifest
+    """
+    try:
+        context = get_context(instance)
+        folder = project_dir(context.data_dir, name)
+        async with audited_files(context, "project_create", name, [folder]):
+            manifest = create_project(context.data_dir, name, description)
+        return f"✅ Created project '{name}'\n{format_manifest(manifest)}\n\n💡 Add files with project_write_file(\"{name}\", \"facts\", \"...\")"
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create project: {e}")
+        return error_result(e, "Failed to create project")
+
+
+@mcp.tool()
+async def project_write_file(
+    project: str,
+    filename: str,
+    content: str,
+    overwrite: bool = False,
+    position: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Create or replace a .pl file inside a project.
+
+    New files are appended to the load order unless position (0-based) is
+    given. Replacing an existing file keeps its place in the order.
+
+    Args:
+        project: Project name
+        filename: File name (with or without .pl)
+        content: Prolog source
+        overwrite: Whether to replace an existing file
+        position: Where to insert a new file in the load order
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The updated manifest
+    """
+    try:
+        context = get_context(instance)
+        check_text(content, sandbox_policy())
+        folder = project_dir(context.data_dir, project)
+        async with audited_files(context, "project_write_file", f"{project}/{filename}", [folder]):
+            manifest = write_file(context.data_dir, project, filename, content, overwrite, position)
+        if not instance:
+            await refresh_kb_resources()
+        return f"✅ Wrote {filename} ({len(content)} characters)\n{format_manifest(manifest)}"
+    except (ProjectError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to write project file: {e}")
+        return error_result(e, "Failed to write project file")
+
+
+@mcp.tool()
+async def project_rename_file(project: str, old_name: str, new_name: str, instance: str = "") -> str:
+    """
+    Rename a file in a project, keeping its position in the load order.
+
+    Args:
+        project: Project name
+        old_name: Current file name
+        new_name: New file name
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The updated manifest
+    """
+    try:
+        context = get_context(instance)
+        detail = f"{project}/{old_name} -> {new_name}"
+        folder = project_dir(context.data_dir, project)
+        async with audited_files(context, "project_rename_file", detail, [folder], grows=False):
+            manifest = rename_file(context.data_dir, project, old_name, new_name)
+        if not instance:
+            await refresh_kb_resources()
+        return f"✅ Renamed {old_name} to {new_name}\n{format_manifest(manifest)}"
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to rename project file: {e}")
+        return error_result(e, "Failed to rename project file")
+
+
+@mcp.tool()
+async def project_delete_file(project: str, filename: str, instance: str = "") -> str:
+    """
+    Delete a file from a project and its load order.
+
+    Args:
+        project: Project name
+        filename: File to delete
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The updated manifest
+    """
+    try:
+        context = get_context(instance)
+        folder = project_dir(context.data_dir, project)
+        async with audited_files(context, "project_delete_file", f"{project}/{filename}", [folder], grows=False):
+            manifest = delete_file(context.data_dir, project, filename)
+        if not instance:
+            await refresh_kb_resources()
+        return f"✅ Deleted {filename}\n{format_manifest(manifest)}"
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to delete project file: {e}")
+        return error_result(e, "Failed to delete project file")
+
+
+@mcp.tool()
+async def project_set_load_order(project: str, files: list[str], instance: str = "") -> str:
+    """
+    Declare the order in which a project's files are consulted.
+
+    The list must name every file in the project exactly once.
+
+    Args:
+        project: Project name
+        files: File names in load order
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The updated manifest
+    """
+    try:
+        context = get_context(instance)
+        folder = project_dir(context.data_dir, project)
+        async with audited_files(context, "project_set_load_order", project, [folder]):
+            manifest = set_load_order(context.data_dir, project, files)
+        return f"✅ Load order updated\n{format_manifest(manifest)}"
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to set load order: {e}")
+        return error_result(e, "Failed to set load order")
+
+
+@mcp.tool()
+async def project_list(project: str = "", instance: str = "") -> str:
+    """
+    List projects, or show one project's manifest.
+
+    Args:
+        project: Project to show; empty lists all projects
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        Projects with their load order
+    """
+    try:
+        context = get_context(instance)
+        if project:
+            return format_manifest(load_manifest(context.data_dir, project))
+
+        projects = list_projects(context.data_dir)
+        if not projects:
+            return "📦 No projects yet. Create one with project_create(\"name\")."
+        return "\n\n".join(format_manifest(m) for m in projects)
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to list projects: {e}")
+        return error_result(e, "Failed to list projects")
+
+
+@mcp.tool()
+async def project_consult(project: str, instance: str = "") -> str:
+    """
+    Consult every file of a project into the session, in load order.
+
+    Loading stops at the first file that fails so later files never see a
+    half-loaded program.
+
+    Args:
+        project: Project name
+        instance: Cluster instance or workspace to load the project into
+
+    Returns:
+        Per-file load results
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        targets = consult_targets(context.data_dir, project)
+        if not targets:
+            return f"⚠️ Project '{project}' has no files to consult"
+
+        loaded = []
+        for i, target in enumerate(targets, 1):
+            await report_progress(i - 1, f"Consulting {target}.pl ({i}/{len(targets)})")
+            result = await execute_prolog_query(f"consult('{target}').", instance=instance)
+            if "✅" not in result:
+                done = "".join(f"   ✅ {t}.pl\n" for t in loaded)
+                return f"""❌ Project '{project}' stopped loading at {target}.pl
+{done}   ❌ {target}.pl
+{result}"""
+            loaded.append(target)
+
+        files = "\n".join(f"   ✅ {t}.pl" for t in loaded)
+        return f"""✅ Project '{project}' loaded ({len(loaded)} files)
+{files}
+
+💡 Predicates from all files are now available for queries."""
+
+    except ProjectError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to consult project: {e}")
+        return error_result(e, "Failed to consult project")
+
+
+@mcp.tool()
+async def consult_url(url: str, checksum: str = "", refresh: bool = False, instance: str = "") -> str:
+    """
+    Download a Prolog source from an HTTP(S) URL and consult it.
+
+    The file is cached in the data directory (url-cache/), so later calls
+    load it without network access unless refresh is set, which
+    revalidates with the server and downloads only if it changed.
+
+    Args:
+        url: http:// or https:// URL of a Prolog source file
+        checksum: Expected digest, e.g. "sha256:<hex>" (sha512, sha1, md5 also accepted)
+        refresh: Check the server for a newer version instead of using the cache
+        instance: Cluster instance or workspace to load the source into
+
+    Returns:
+        Download and load result
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        policy = sandbox_policy()
+        source = await fetch_source(context.data_dir, url, checksum, refresh, check=lambda text: check_text(text, policy))
+
+        result = await execute_prolog_query(f"consult('{source.consult_name}').", instance=instance)
+        if not instance:
+            await refresh_kb_resources()
+        origin = "cache" if source.from_cache else "download"
+        verified = "\n🔐 Checksum verified" if checksum else ""
+        if "✅" not in result:
+            return f"⚠️ Fetched {url} ({origin}) but consulting it may have failed:\n{result}"
+
+        return f"""✅ Consulted {url}
+📦 Cached as: {source.path.name} ({source.size} bytes, from {origin})
+#️⃣ sha256: {source.sha256}{verified}
+💡 Pass refresh=True to pick up a newer version"""
+
+    except (RemoteSourceError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to consult URL: {e}")
+        return error_result(e, "Failed to consult URL")
+
+
+def check_cells(cells: list[NotebookCell]) -> None:
+    """Apply the sandbox policy to every executable cell."""
+    policy = sandbox_policy()
+    for cell in cells:
+        if cell.type in ("program", "query"):
+            check_text(cell.text, policy)
+
+
+def format_cells(cells: list[NotebookCell]) -> str:
+    """One line per cell: name, type and first line of text."""
+    icons = {"markdown": "📝", "program": "📜", "query": "❓", "html": "🌐"}
+    lines = []
+    for i, cell in enumerate(cells):
+        first = cell.text.strip().splitlines()[0] if cell.text.strip() else ""
+        if len(first) > 60:
+            first = first[:57] + "..."
+        lines.append(f"   {i}. {icons.get(cell.type, '▫️')} {cell.name} ({cell.type}): {first}")
+    return "\n".join(lines) or "   (no cells)"
+
+
+@mcp.tool()
+async def notebook_create(
+    name: str,
+    cells: list[dict[str, str]],
+    overwrite: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Create a SWISH notebook (.swinb) from markdown, program and query cells.
+
+    Notebooks are saved as notebooks/<name>.swinb in the data directory.
+    Program cells form the notebook's program; query cells run against it
+    (see notebook_run).
+
+    Args:
+        name: Notebook name (letters, digits, '_' or '-')
+        cells: Cells in order, each {"type": "markdown"|"program"|"query"|"html",
+            "text": "..."} with an optional "name"
+        overwrite: Whether to replace an existing notebook
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The notebook's cell outline
+    """
+    try:
+        context = get_context(instance)
+        path = notebook_path(context.data_dir, name)
+        if path.exists() and not overwrite:
+            return f"❌ Notebook '{name}' already exists. Use overwrite=True to replace."
+
+        notebook_cells = make_cells(cells)
+        check_cells(notebook_cells)
+        async with audited_files(context, "notebook_create", name, [notebook_path(context.data_dir, name)]):
+            save_notebook(context.data_dir, name, notebook_cells)
+        logger.info(f"Created notebook: {path}")
+
+        return f"""✅ Created notebook {path.name} ({len(notebook_cells)} cells)
+📁 Path: {path}
+{format_cells(notebook_cells)}
+
+💡 Run every cell with notebook_run("{name}")"""
+
+    except (NotebookError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create notebook: {e}")
+        return error_result(e, "Failed to create notebook")
+
+
+@mcp.tool()
+async def notebook_add_cell(
+    name: str,
+    cell_type: str,
+    text: str,
+    position: int | None = None,
+    cell_name: str = "",
+    instance: str = ""
+) -> str:
+    """
+    Add a cell to an existing notebook.
+
+    Args:
+        name: Notebook name
+        cell_type: "markdown", "program", "query" or "html"
+        text: Cell content
+        position: 0-based index to insert at (default: append)
+        cell_name: Optional cell name (default: md1, p1, q1, ... style)
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The notebook's updated cell outline
+    """
+    try:
+        context = get_context(instance)
+        cells = load_notebook(context.data_dir, name)
+        cell = new_cell(cell_type, text, cell_name)
+        check_cells([cell])
+        cells = insert_cell(cells, cell, position)
+        async with audited_files(context, "notebook_add_cell", name, [notebook_path(context.data_dir, name)]):
+            save_notebook(context.data_dir, name, cells)
+        return f"✅ Added {cell.type} cell {cell.name} to {name}.swinb\n{format_cells(cells)}"
+
+    except (NotebookError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to add notebook cell: {e}")
+        return error_result(e, "Failed to add notebook cell")
+
+
+@mcp.tool()
+async def notebook_run(
+    name: str,
+    output_format: str = "text",
+    stop_on_error: bool = False,
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Execute a notebook: consult its program cells, then run each query cell.
+
+    Args:
+        name: Notebook name
+        output_format: "text" for a literate transcript, or "json" for a
+            document with one entry per cell (query results in the
+            execute_prolog_query JSON format)
+        stop_on_error: Stop at the first query that raises an error
+        timeout: Wall-clock limit per query cell in seconds
+        instance: Cluster instance or workspace to run the notebook in
+
+    Returns:
+        Per-cell results
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        cells = load_notebook(context.data_dir, name)
+        check_cells(cells)
+        structured = output_format == "json"
+
+        program = write_program(context.data_dir, name, cells)
+        program_result = ""
+        if program:
+            program_result = await execute_prolog_query(f"consult('{program}').", instance=instance)
+            if "✅" not in program_result:
+                if structured:
+                    return json.dumps({"notebook": name, "program": program_result, "cells": []}, indent=2)
+                return f"❌ The program cells of {name}.swinb failed to load:\n{program_result}"
+
+        queries = [cell for cell in cells if cell.type == "query"]
+        entries: list[dict[str, Any]] = []
+        transcript: list[str] = []
+        ran = 0
+        stopped = False
+        for cell in cells:
+            if stopped:
+                entries.append({"name": cell.name, "type": cell.type, "status": "skipped"})
+                continue
+            if cell.type != "query":
+                entries.append({"name": cell.name, "type": cell.type, "text": cell.text})
+                if cell.type == "markdown":
+                    transcript.append(cell.text)
+                elif cell.type == "program":
+                    transcript.append(f"📜 [{cell.name}] program cell ({len(cell.text.splitlines())} lines, loaded)")
+                continue
+
+            ran += 1
+            await report_progress(ran - 1, f"Running {cell.name} ({ran}/{len(queries)})")
+            result = await execute_prolog_query(
+                cell.text, timeout=timeout, output_format=output_format, instance=instance
+            )
+            if structured:
+                try:
+                    answer: Any = json.loads(result)
+                    failed = answer.get("error") is not None
+                except json.JSONDecodeError:
+                    answer, failed = {"error": result}, True
+                entries.append({"name": cell.name, "type": "query", "query": cell.text, "result": answer})
+            else:
+                failed = ERROR_TAG in result or not result.startswith(("✅", "❌ Query:"))
+                transcript.append(f"❓ [{cell.name}] ?- {cell.text}\n{result}")
+            if failed and stop_on_error:
+                stopped = True
+
+        if structured:
+            return json.dumps({"notebook": name, "program": program, "cells": entries}, indent=2)
+
+        note = f"\n\n⚠️ Stopped after query {ran} of {len(queries)} because of an error" if stopped else ""
+        separator = "\n\n" + "─" * 40 + "\n\n"
+        return f"""📓 Notebook {name}.swinb: {len(cells)} cells, {len(queries)} queries
+
+{separator.join(transcript) if transcript else "(empty notebook)"}{note}"""
+
+    except (NotebookError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run notebook: {e}")
+        return error_result(e, "Failed to run notebook")
+
+
+async def run_json_helper(
+    context: SwishContext,
+    call: tuple[str, list[str]],
+    limits: QueryLimits | None = None
+) -> list[dict[str, Any]]:
+    """Run a JSON-emitting helper in the persistent session and return its rows."""
+    if context.prolog_session is None:
+        raise RuntimeError("This tool requires the persistent Prolog session. Try restart_prolog_session().")
+    predicate, args = call
+    rows: list[dict[str, Any]] = []
+    error = None
+    async for event in context.prolog_session.run_helper(predicate, args, limits or server_config.limits):
+        if event["type"] == "solution":
+            rows.append(event["bindings"])
+        elif event["type"] == "error" and error is None:
+            # Read on to END, so the session knows the goal is over
+            error = event["error"]
+    if error is not None:
+        raise RuntimeError(error)
+    return rows
+
+
+@mcp.tool()
+async def import_data(
+    predicate: str,
+    data: str = "",
+    source: str = "",
+    columns: list[str] | None = None,
+    data_format: str = "auto",
+    header: bool = True,
+    replace: bool = False,
+    dry_run: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Assert the rows of CSV, TSV, JSON or JSON Lines data as Prolog facts.
+
+    Every row becomes one fact of predicate, with the listed columns as
+    its arguments, e.g. columns=["name:atom", "age:integer"] turns
+    name,age / alice,34 into person(alice, 34). Rows whose cells do not
+    convert are skipped and reported. Use dry_run to preview the facts.
+
+    Args:
+        predicate: Name of the predicate to assert, e.g. "person"
+        data: The data itself
+        source: Instead of data, a file in the data directory or an http(s) URL
+        columns: Columns to use as arguments, in order, each "name" or "name:type"
+            (type auto, atom, string, integer, float, number or boolean); all
+            columns with auto types when omitted. Without a header, columns
+            are named 1, 2, ...
+        data_format: "auto" (from the file extension or content), "csv", "tsv", "json" or "jsonl"
+        header: Whether the first CSV/TSV row holds the column names
+        replace: Retract the predicate's existing clauses first
+        dry_run: Show the facts that would be asserted without asserting them
+        instance: Cluster instance or workspace to import into
+
+    Returns:
+        How many facts were asserted, or the dry-run preview
+    """
+    try:
+        context = get_context(instance)
+
+        if data_format not in IMPORT_FORMATS:
+            return f"❌ Unknown data_format '{data_format}'. Use one of: {', '.join(IMPORT_FORMATS)}"
+        if bool(data) == bool(source):
+            return "❌ Pass either data or source"
+
+        if source.startswith(("http://", "https://")):
+            text = await fetch_data(source)
+        elif source:
+            path = (context.data_dir / source).resolve()
+            if not path.is_relative_to(context.data_dir.resolve()):
+                return f"❌ '{source}' is outside the data directory"
+            if not path.is_file():
+                return f"❌ File '{source}' not found in the data directory"
+            text = await asyncio.to_thread(path.read_text, encoding="utf-8-sig")
+        else:
+            text = data
+        if data_format == "auto":
+            data_format = detect_format(text, source)
+
+        names, rows = read_rows(text, data_format, header)
+        plan = plan_import(predicate, names, rows, parse_columns(columns or []))
+        if dry_run:
+            return format_plan(plan, len(rows))
+        if not plan.facts:
+            return "\n".join([f"❌ No rows could be imported as {predicate}/{plan.arity}", *format_skipped(plan)])
+
+        if not context.container_ready:
+            return NOT_READY
+        module = client_module()
+        policy = sandbox_policy()
+        if policy.enabled and module not in policy.modules and not policy.allows("assertz", 1):
+            return f"❌ The sandbox policy does not allow asserting into module {module}"
+        target = f"{module}:{predicate}/{plan.arity}"
+        if not policy.acl.allows("assert", target) or (replace and not policy.acl.allows("retract", target)):
+            return f"❌ The access control list does not allow {'replacing' if replace else 'asserting to'} {target}"
+
+        detail = f"{predicate}/{plan.arity} ({len(plan.facts)} facts)"
+        async with audited_database(context, "import_data", detail, module=module):
+            try:
+                result = await run_json_helper(context, import_call(module, plan, replace))
+            except RuntimeError as e:
+                return f"❌ Could not assert {predicate}/{plan.arity}: {e}"
+        await kb_resources.notify_all_updated()
+
+        total = result[0]["total"] if result else len(plan.facts)
+        lines = [
+            f"✅ Asserted {len(plan.facts)} {predicate}/{plan.arity} fact(s)"
+            + (" (replacing the previous clauses)" if replace else ""),
+            f"📊 {predicate}/{plan.arity} now has {total} clause(s)",
+            f"💡 Try: ?- {predicate}({', '.join('_' for _ in range(plan.arity))}).",
+        ]
+        lines.extend(format_skipped(plan))
+        return "\n".join(lines)
+
+    except (ValueError, RemoteSourceError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to import data: {e}")
+        return error_result(e, "Failed to import data")
+
+
+@mcp.tool()
+async def export_results(
+    query: str,
+    data_format: str = "csv",
+    filename: str = "",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Run a goal and export all its solutions as CSV, JSON Lines or Parquet.
+
+    Each solution becomes one row with a column per variable; the column
+    types (integer, float, string, list or term) are inferred from the
+    first solution. Without a filename, CSV and JSON Lines results up to
+    16 KB are returned inline; larger results and Parquet files are
+    written to exports/ in the data directory.
+
+    Args:
+        query: Prolog goal whose solutions to export, e.g. "person(Name, Age)"
+        data_format: "csv", "jsonl" or "parquet" (Parquet needs pyarrow)
+        filename: File in the data directory to write, e.g. "people.csv"
+        timeout: Wall-clock limit in seconds (default: SWISH_MCP_QUERY_TIMEOUT)
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        The exported rows, or where they were written and their schema
+    """
+    try:
+        context = get_context(instance)
+
+        if data_format not in EXPORT_FORMATS:
+            return f"❌ Unknown data_format '{data_format}'. Use one of: {', '.join(EXPORT_FORMATS)}"
+        if not query.strip():
+            return "❌ Empty query provided"
+        if not context.container_ready:
+            return NOT_READY
+        if context.prolog_session is None:
+            return "❌ Exporting results requires the persistent Prolog session. Try restart_prolog_session()."
+
+        query_text = clean_query_text(query)
+        policy = sandbox_policy()
+        goal = apply_policy(query_text, policy) if policy.checks_goals else query_text
+        module = client_module()
+        session_query = in_module(f"limit({MAX_EXPORT_ROWS + 1}, ({goal}))", module)
+        limits = server_config.limits.override(timeout, None, None)
+
+        solutions: list[dict[str, Any]] = []
+        changes_database = uses_category(query, DATABASE_CATEGORY)
+        async with audited_database(context, "export_results", query_text, changes_database, module):
+            async for event in context.prolog_session.stream_query(session_query, limits, "json"):
+                if event["type"] == "solution":
+                    solutions.append(event["bindings"])
+                elif event["type"] == "error":
+                    error = event["error"]
+                    return describe_limit_error(error, limits) or f"❌ Query failed: {error}"
+        if not solutions:
+            return f"❌ {query_text} has no solutions to export"
+        truncated = len(solutions) > MAX_EXPORT_ROWS
+        del solutions[MAX_EXPORT_ROWS:]
+        if not solutions[0]:
+            return f"❌ {query_text} has no variables to export as columns"
+
+        export = build_export(solutions)
+        content = await asyncio.to_thread(render, export, data_format)
+        notes = []
+        if truncated:
+            notes.append(f"⚠️ Only the first {MAX_EXPORT_ROWS} solutions were exported")
+        if export.mismatched:
+            notes.append(f"⚠️ {export.mismatched} cell(s) did not match the column types of the first solution")
+
+        if not filename and data_format != "parquet" and len(content) <= INLINE_LIMIT:
+            lines = [f"✅ {len(export.rows)} solution(s) of {query_text}", f"📐 {export.schema()}", *notes]
+            return "\n".join(lines) + "\n\n" + content.decode("utf-8")
+
+        filename = export_filename(filename or f"{EXPORT_DIR}/results-{time.strftime('%Y%m%d-%H%M%S')}", data_format)
+        path = (context.data_dir / filename).resolve()
+        if not path.is_relative_to(context.data_dir.resolve()):
+            return f"❌ '{filename}' is outside the data directory"
+        await check_disk(context, f"Exporting {len(content)} bytes to {filename}", len(content))
+        async with audited_files(context, "export_results", filename, [path]):
+            path.parent.mkdir(parents=True, exist_ok=True)
+            await asyncio.to_thread(path.write_bytes, content)
+
+        lines = [
+            f"✅ Exported {len(export.rows)} solution(s) of {query_text} to {filename} ({len(content)} bytes)",
+            f"📐 {export.schema()}",
+            *notes,
+        ]
+        return "\n".join(lines)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except DiskQuotaExceeded as e:
+        return error_result(e)
+    except Exception as e:
+        logger.error(f"Failed to export results: {e}")
+        return error_result(e, "Failed to export results")
+
+
+@mcp.tool()
+async def export_rules(
+    predicates: list[str] | None = None,
+    target: str = "souffle",
+    filename: str = "",
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Translate the function-free rules and facts of the knowledge base to Soufflé Datalog or SQL.
+
+    Clauses whose arguments are constants and variables, and whose
+    bodies are conjunctions of knowledge base predicates, their negations
+    and comparisons, become Soufflé rules and facts, or SQL tables and
+    (recursive) views. Every other clause is left out and reported with
+    the reason, e.g. a compound argument, a cut or a call of a built-in,
+    along with the predicates left incomplete by it. Without a filename,
+    programs up to 16 KB are returned inline; larger ones are written to
+    exports/ in the data directory.
+
+    Args:
+        predicates: Predicates to export with what they use, e.g. ["ancestor/2"] (default: all)
+        target: "souffle" for a Soufflé program (.dl) or "sql" for SQL tables and views (.sql)
+        filename: File in the data directory to write, e.g. "family.dl"
+        output_format: "text" or "json" (the report, with the program as "program")
+        instance: Cluster instance or workspace to export from
+
+    Returns:
+        The translation report and the program, or where it was written
+    """
+    try:
+        context = get_context(instance)
+
+        if target not in EXPORT_TARGETS:
+            return f"❌ Unknown target '{target}'. Use one of: {', '.join(EXPORT_TARGETS)}"
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if not context.container_ready:
+            return NOT_READY
+
+        requested = []
+        for text in predicates or []:
+            name, arity = parse_indicator(text)
+            requested.append(f"{name}/{arity}")
+        module = client_module()
+        try:
+            rows = await run_json_helper(context, rules_export_call(module))
+        except RuntimeError as e:
+            return error_result(e, "Could not read the knowledge base")
+        if not rows:
+            return "📭 No predicates defined yet. Load or assert some clauses first."
+        export = translate_rules(rows, target, module, requested)
+
+        written = ""
+        content = export.text.encode("utf-8")
+        if filename or len(content) > INLINE_LIMIT:
+            written = rule_export_filename(filename or f"{EXPORT_DIR}/rules-{time.strftime('%Y%m%d-%H%M%S')}", target)
+            path = (context.data_dir / written).resolve()
+            if not path.is_relative_to(context.data_dir.resolve()):
+                return f"❌ '{written}' is outside the data directory"
+            await check_disk(context, f"Exporting {len(content)} bytes to {written}", len(content))
+            async with audited_files(context, "export_rules", written, [path]):
+                path.parent.mkdir(parents=True, exist_ok=True)
+                await asyncio.to_thread(path.write_bytes, content)
+
+        if output_format == "json":
+            document = export.document()
+            document["file" if written else "program"] = written or export.text
+            return json.dumps(document, indent=2)
+        return format_rule_export(export, written)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except DiskQuotaExceeded as e:
+        return error_result(e)
+    except Exception as e:
+        logger.error(f"Failed to export rules: {e}")
+        return error_result(e, "Failed to export rules")
+
+
+EXTENSIONS_BY_FORMAT = {"turtle": "ttl", "ntriples": "nt", "nquads": "nq", "trig": "trig", "xml": "rdf"}
+
+
+def rdf_source(
+    context: SwishContext, filename: str, content: str, graph: str, format: str, content_format: str = "turtle"
+) -> tuple[str, str]:
+    """
+    The data-directory path and parser of an RDF file to load: filename,
+    or content saved as rdf/<filename>.<ext> first.
+
+    Raises:
+        ValueError: if the file is missing or outside the data directory
+    """
+    if content:
+        fmt = content_format if format == "auto" else rdf_format("", format)
+        stem = validate_rdf_name((filename or graph or "data").rsplit(".", 1)[0])
+        relative = f"{RDF_DIR}/{stem}.{EXTENSIONS_BY_FORMAT[fmt]}"
+        path = context.data_dir / relative
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_text(normalize_newlines(content), encoding="utf-8", newline="\n")
+        return relative, fmt
+    if not filename:
+        raise ValueError("Give the filename of an RDF file in the data directory, or its content")
+    path = (context.data_dir / filename).resolve()
+    if not path.is_relative_to(context.data_dir.resolve()):
+        raise ValueError(f"'{filename}' is outside the data directory")
+    if not path.is_file():
+        raise ValueError(f"RDF file '{filename}' not found in {context.data_dir}")
+    relative = path.relative_to(context.data_dir.resolve()).as_posix()
+    return relative, rdf_format(relative, format)
+
+
+@mcp.tool()
+async def rdf_load(
+    filename: str = "",
+    content: str = "",
+    graph: str = "",
+    format: str = "auto",
+    instance: str = ""
+) -> str:
+    """
+    Load an RDF file into SWI-Prolog's semweb triple store.
+
+    Either load an existing file from the data directory (Turtle .ttl,
+    N-Triples .nt, N-Quads .nq, TriG .trig or RDF/XML .rdf/.owl), or pass
+    content to save it as rdf/<filename>.ttl first. Loaded triples stay in
+    the persistent session for rdf_triples() and rdf_query().
+
+    Args:
+        filename: File in the data directory, or the name to save content under
+        content: RDF document text to save and load (Turtle unless format says otherwise)
+        graph: Named graph to load into (default: the file name without extension)
+        format: "auto" (from the extension), "turtle", "ntriples", "nquads", "trig" or "xml"
+        instance: Cluster instance or workspace to load into
+
+    Returns:
+        The graph and its triple count
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        relative, fmt = rdf_source(context, filename, content, graph, format)
+        graph = graph or default_graph(relative)
+        rows = await run_json_helper(context, load_call(f"{prolog_data_dir(context)}/{relative}", graph, fmt))
+        triples = rows[0]["triples"] if rows else 0
+
+        return f"""✅ Loaded {relative} into graph '{graph}'
+🔗 Triples in graph: {triples}
+💡 Explore with rdf_triples(graph="{graph}") or rdf_query("rdf(S, P, O)")"""
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to load RDF: {e}")
+        return error_result(e, "Failed to load RDF")
+
+
+@mcp.tool()
+async def rdf_triples(
+    subject: str = "",
+    predicate: str = "",
+    object: str = "",
+    graph: str = "",
+    limit: int = 100,
+    instance: str = ""
+) -> str:
+    """
+    Match a triple pattern against the RDF store and return JSON triples.
+
+    Terms are written as in Turtle: <http://...> (or a bare http/urn IRI),
+    prefix:local (rdf:type, rdfs:label, foaf:name, ...), and for objects
+    also "text", "text"@en, "42"^^xsd:integer or a number. Leave a
+    position empty to match anything.
+
+    Args:
+        subject: Subject IRI, or "" for any
+        predicate: Predicate IRI, or "" for any
+        object: Object IRI or literal, or "" for any
+        graph: Graph name, or "" for all graphs
+        limit: Maximum number of triples to return
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        JSON with the matching triples, SPARQL JSON style terms
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        limit = max(1, limit)
+        rows = await run_json_helper(context, triples_call(subject, predicate, object, graph, limit + 1))
+        return json.dumps({
+            "count": min(len(rows), limit),
+            "truncated": len(rows) > limit,
+            "triples": rows[:limit],
+        }, indent=2)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to match RDF triples: {e}")
+        return error_result(e, "Failed to match RDF triples")
+
+
+@mcp.tool()
+async def rdf_query(goal: str, limit: int = 100, timeout: int | None = None, instance: str = "") -> str:
+    """
+    Run a Prolog goal over the RDF store, SPARQL-style, returning JSON bindings.
+
+    The goal uses rdf/3 and rdf/4 with prefixed names expanded, e.g.
+    "rdf(P, rdf:type, foaf:'Person'), rdf(P, foaf:name, N)". Each solution
+    maps variable names to SPARQL JSON style terms.
+
+    Args:
+        goal: Prolog goal over rdf/3, rdf/4 and other semweb predicates
+        limit: Maximum number of solutions
+        timeout: Wall-clock limit in seconds
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        JSON with one bindings object per solution
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        policy = sandbox_policy()
+        check_text(goal, policy)
+        require_unrestricted(policy, "call", "RDF queries")
+        if policy.mode == "strict":
+            return "❌ rdf_query is not available in strict sandbox mode; use rdf_triples()"
+
+        limit = max(1, limit)
+        limits = server_config.limits.override(timeout, None, None)
+        clean_goal = clean_query_text(goal)
+        rows = await run_json_helper(context, query_call(clean_goal, limits.to_prolog(), limit + 1), limits)
+        return json.dumps({
+            "goal": clean_goal,
+            "count": min(len(rows), limit),
+            "truncated": len(rows) > limit,
+            "bindings": rows[:limit],
+        }, indent=2)
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run RDF query: {e}")
+        return error_result(e, "Failed to run RDF query")
+
+
+@mcp.tool()
+async def rdf_graphs(instance: str = "") -> str:
+    """
+    List the named graphs in the RDF store with their triple counts.
+
+    Args:
+        instance: Cluster instance or workspace to inspect
+
+    Returns:
+        Graph names and sizes
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        rows = await run_json_helper(context, graphs_call())
+        if not rows:
+            return "🕸️ The RDF store is empty. Load data with rdf_load()."
+        lines = [f"  🕸️ {row['graph']} ({row['triples']} triples)" for row in sorted(rows, key=lambda r: str(r["graph"]))]
+        return "🕸️ RDF graphs:\n" + "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to list RDF graphs: {e}")
+        return error_result(e, "Failed to list RDF graphs")
+
+
+@mcp.tool()
+async def owl_import(
+    filename: str = "",
+    content: str = "",
+    graph: str = "",
+    format: str = "auto",
+    names: str = "local",
+    replace: bool = True,
+    instance: str = ""
+) -> str:
+    """
+    Import an OWL ontology as Prolog facts with subclass and instance reasoning.
+
+    The ontology is loaded into the RDF store like rdf_load(), then its
+    classes, properties, individuals and axioms become facts such as
+    owl_subclass(dog, mammal), owl_type(rex, dog) and
+    owl_value(rex, owner, alice), and rules are added that reason over
+    them: owl_subclass_of/2, owl_instance_of/2, owl_subproperty_of/2,
+    owl_holds/3 (inverse, symmetric and transitive properties) and
+    owl_inconsistent/3 (members of disjoint classes). Restrictions and
+    other class expressions are not converted.
+
+    Args:
+        filename: Ontology file in the data directory (.owl, .rdf, .ttl, ...), or the name to save content under
+        content: Ontology text to save and import (RDF/XML when it starts with "<", else Turtle)
+        graph: Named graph to load into (default: the file name without extension)
+        format: "auto" (from the extension or content), "turtle", "ntriples", "nquads", "trig" or "xml"
+        names: "local" to name resources by their IRI's local name (dog) where unique, or "iri" for full IRIs
+        replace: Retract the owl_* facts of earlier imports first; False adds to them
+        instance: Cluster instance or workspace to import into
+
+    Returns:
+        The facts created per predicate and the reasoning predicates to query
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        module = client_module()
+        policy = sandbox_policy()
+        if policy.enabled and module not in policy.modules and not policy.allows("assertz", 1):
+            return f"❌ The sandbox policy does not allow asserting into module {module}"
+        require_unrestricted(policy, "assert", "OWL imports")
+
+        relative, fmt = rdf_source(context, filename, content, graph, format, owl_content_format(content))
+        graph = graph or default_graph(relative)
+        conversion = owl_import_call(module, graph, names, replace)
+        await run_json_helper(context, load_call(f"{prolog_data_dir(context)}/{relative}", graph, fmt))
+        async with audited_database(context, "owl_import", f"{relative} (graph {graph})", module=module):
+            rows = await run_json_helper(context, conversion)
+        await kb_resources.notify_all_updated()
+        return format_owl_import(relative, rows[0] if rows else {}, replace)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to import ontology: {e}")
+        return error_result(e, "Failed to import ontology")
+
+
+@mcp.tool()
+async def solve_constraints(
+    model: dict[str, Any],
+    max_solutions: int = 1,
+    strategy: str = "leftmost",
+    value_order: str = "up",
+    branching: str = "step",
+    output_format: str = "text",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Solve a finite-domain constraint problem with CLP(FD), without writing clpfd code.
+
+    The model is JSON: "variables" maps names (uppercase first letter) to
+    domains, "constraints" lists clpfd expressions over them and an
+    optional "minimize" or "maximize" expression makes the first solution
+    optimal. Example:
+
+        {"variables": {"X": [1, 9], "Y": [1, 9], "Digits": {"length": 3, "domain": [0, 9]}},
+         "constraints": ["X + Y #= 10", "X #< Y", "all_distinct(Digits)", "sum(Digits, #=, X)"],
+         "maximize": "X * Y"}
+
+    Domains are [low, high], {"values": [...]} or clpfd text like
+    "1..3 \\/ 7". Constraints may use #=, #\\=, #<, #>, #=<, #>=, the
+    reification operators, arithmetic, in/ins and clpfd globals such as
+    all_distinct, sum, element, global_cardinality and tuples_in.
+
+    Args:
+        model: Variables, constraints and optional objective as described above
+        max_solutions: Maximum number of solutions to return
+        strategy: Variable selection: leftmost, ff, ffc, min or max
+        value_order: Value order: up or down
+        branching: Branching: step, enum or bisect
+        output_format: "text" or "json"
+        timeout: Wall-clock limit in seconds
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The solutions found, or why none could be found
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+
+        compiled = parse_model(model)
+        options = labeling_options(compiled, strategy, value_order, branching)
+        max_solutions = max(1, max_solutions)
+        limits = server_config.limits.override(timeout, None, None)
+        try:
+            rows = await run_json_helper(
+                context, solve_call(compiled, options, limits.to_prolog(), max_solutions + 1), limits
+            )
+        except RuntimeError as e:
+            limit_message = describe_limit_error(str(e), limits)
+            if limit_message:
+                return limit_message
+            return error_result(e, "Constraint model failed")
+
+        truncated = len(rows) > max_solutions
+        rows = rows[:max_solutions]
+        if output_format == "json":
+            return json.dumps({
+                "constraints": compiled.constraints,
+                "labeling": options,
+                "count": len(rows),
+                "truncated": truncated,
+                "solutions": rows,
+            }, indent=2)
+
+        if not rows:
+            return "🧮 No solution: the constraints are unsatisfiable."
+        heading = f"🧮 {len(rows)} solution(s)" + (", best first" if compiled.objective else "")
+        lines = [f"  {i}. {format_solution(row)}" for i, row in enumerate(rows, 1)]
+        more = "\n\n📄 More solutions exist; raise max_solutions to see them." if truncated else ""
+        return f"{heading} (labeling {options}):\n" + "\n".join(lines) + more
+
+    except ModelError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to solve constraints: {e}")
+        return error_result(e, "Failed to solve constraints")
+
+
+@mcp.tool()
+async def restart_prolog_session() -> str:
+    """
+    Restart the persistent Prolog session.
+
+    This can be useful if the session becomes unresponsive or if you want
+    to start fresh while preserving consulted knowledge bases.
+
+    Returns:
+        Status of the restart operation
+    """
+    try:
+        context = get_context()
+
+        if not context.container_ready:
+            return ToolError("not_ready", "SWISH container is not ready. Cannot restart Prolog session.").render()
+
+        if not context.prolog_session:
+            logger.info("No existing session, creating new persistent session")
+            context.prolog_session = new_prolog_session(context)
+            success = await context.prolog_session.start_session()
+
+            if success:
+                return "✅ New persistent Prolog session started successfully!"
+            else:
+                return "❌ Failed to start new persistent Prolog session"
+
+        # Restart existing session
+        logger.info("Restarting persistent Prolog session")
+        success = await context.prolog_session.restart_session()
+
+        if success:
+            session_info = context.prolog_session.get_status()
+            consulted_files = session_info.get('consulted_files', [])
+
+            restart_msg = "✅ Persistent Prolog session restarted successfully!"
+            if consulted_files:
+                restart_msg += f"\n📚 Re-consulted files: {', '.join(consulted_files)}"
+
+            return restart_msg
+        else:
+            return "❌ Failed to restart persistent Prolog session"
+
+    except Exception as e:
+        logger.error(f"Failed to restart Prolog session: {e}")
+        return error_result(e, "Failed to restart session")
+
+
+def _get_pengines() -> PengineManager:
+    """Return the pengine manager, ensuring SWISH is reachable."""
+    context = get_context()
+    if context.backend == "local":
+        raise PengineError("Pengines need the SWISH container; they are not available with the local backend")
+    if not context.container_ready or context.pengines is None:
+        raise PengineError("SWISH container is not ready. Please wait a moment and try again.")
+    return context.pengines
+
+
+@mcp.tool()
+async def pengine_create(
+    src_text: str = "",
+    query: str = "",
+    chunk: int = 1
+) -> str:
+    """
+    Create a SWISH pengine that keeps a query open between tool calls.
+
+    Unlike execute_prolog_query(), a pengine hands out solutions lazily:
+    use pengine_next() to backtrack for more, like typing ';' at the
+    Prolog prompt. Pengines run in SWISH's sandbox with src_text as their
+    private program.
+
+    Args:
+        src_text: Prolog clauses to load into the pengine's module
+        query: Optional first query to ask immediately
+        chunk: Number of solutions to return per answer
+
+    Returns:
+        The pengine ID and the first answer if a query was given
+    """
+    try:
+        policy = sandbox_policy()
+        check_text(src_text, policy)
+        require_unrestricted(policy, "call", "pengine queries")
+        check_text(query, policy)
+        state, answer = await _get_pengines().create(
+            current_client_id(), src_text, query or None, chunk
+        )
+        message = f"✅ Created pengine {state.pengine_id}"
+        if answer is not None:
+            message += f"\n🔎 Query: {query}\n{format_answer(answer)}"
+        else:
+            message += "\n💡 Ask a query with pengine_ask()"
+        return message
+    except SwishUnavailable as e:
+        return error_result(e)
+    except (PengineError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create pengine: {e}")
+        return error_result(e, "Failed to create pengine")
+
+
+@mcp.tool()
+async def pengine_ask(pengine_id: str, query: str, chunk: int = 1) -> str:
+    """
+    Ask a query on an existing pengine.
+
+    Args:
+        pengine_id: ID returned by pengine_create()
+        query: Prolog query to run
+        chunk: Number of solutions to return per answer
+
+    Returns:
+        The first answer for the query
+    """
+    try:
+        check_text(query, sandbox_policy())
+        require_unrestricted(sandbox_policy(), "call", "pengine queries")
+        answer = await _get_pengines().ask(current_client_id(), pengine_id, query, chunk)
+        return f"🔎 Query: {query}\n{format_answer(answer)}"
+    except SwishUnavailable as e:
+        return error_result(e)
+    except (PengineError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Pengine ask failed: {e}")
+        return error_result(e, "Pengine ask failed")
+
+
+@mcp.tool()
+async def pengine_next(pengine_id: str) -> str:
+    """
+    Fetch the next chunk of solutions from a pengine's open query.
+
+    Args:
+        pengine_id: ID returned by pengine_create()
+
+    Returns:
+        The next answer, or false when solutions are exhausted
+    """
+    try:
+        answer = await _get_pengines().next(current_client_id(), pengine_id)
+        return format_answer(answer)
+    except SwishUnavailable as e:
+        return error_result(e)
+    except PengineError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Pengine next failed: {e}")
+        return error_result(e, "Pengine next failed")
+
+
+@mcp.tool()
+async def pengine_stop(pengine_id: str, destroy: bool = True) -> str:
+    """
+    Stop a pengine's open query and, by default, destroy the pengine.
+
+    Args:
+        pengine_id: ID returned by pengine_create()
+        destroy: Also destroy the pengine; set False to keep it for pengine_ask()
+
+    Returns:
+        Status of the stop operation
+    """
+    try:
+        await _get_pengines().stop(current_client_id(), pengine_id, destroy)
+        return f"✅ Pengine {pengine_id} {'destroyed' if destroy else 'stopped'}"
+    except SwishUnavailable as e:
+        return error_result(e)
+    except PengineError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Pengine stop failed: {e}")
+        return error_result(e, "Pengine stop failed")
+
+
+@mcp.tool()
+async def pengine_list() -> str:
+    """
+    List the pengines owned by the calling client.
+
+    Returns:
+        JSON list of pengines with their open query and progress
+    """
+    try:
+        pengines = _get_pengines().get_status(current_client_id())
+        if not pengines:
+            return "📭 No pengines. Create one with pengine_create()."
+        return json.dumps(pengines, indent=2)
+    except PengineError as e:
+        return error_result(e, fallback="invalid_argument")
+
+
+@mcp.tool()
+async def share_program(
+    program: str = "",
+    filename: str = "",
+    query: str = "",
+    link: str = "permalink",
+    name: str = "",
+    title: str = "",
+    public: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Publish a program (and a query on it) as a link to the SWISH web UI.
+
+    A permalink saves the program in SWISH's storage, with the query as its
+    examples, so a collaborator can open it in the browser and pick up the
+    work; an editor link opens SWISH's editor prefilled without saving.
+    Links use SWISH_MCP_PUBLIC_URL when set.
+
+    Args:
+        program: Program text to share
+        filename: Or a .pl file in the data directory to share
+        query: Query to include, e.g. "ancestor(tom, X)"
+        link: "permalink", "editor" or "both"
+        name: File name for the permalink (default: one SWISH picks)
+        title: Title shown in SWISH's file list
+        public: List the saved program in SWISH's public search
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The shareable URL(s)
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.backend == "local":
+            return ToolError("not_ready", "SWISH links need the SWISH container; the local backend has no web UI").render()
+        if link not in LINK_KINDS:
+            return ToolError("invalid_argument", f"Unknown link '{link}'. Use one of: {', '.join(LINK_KINDS)}.").render()
+        if bool(program) == bool(filename):
+            return ToolError("invalid_argument", "Pass either program or filename").render()
+        if filename:
+            program = program_file(context, filename).read_text(encoding="utf-8")
+
+        base_url = server_config.public_url or context.swish_base_url
+        lines = []
+        if link in ("permalink", "both"):
+            reply = await swish_http(context).request(
+                "POST", STORAGE_PATH, timeout=30, idempotent=False,
+                json=storage_payload(with_examples(program, query), name, title, public)
+            )
+            lines.append(f"🔗 Permalink: {permalink(base_url, reply.json())}")
+        if link in ("editor", "both"):
+            lines.append(f"✏️ Editor: {editor_link(base_url, program, query)}")
+        return "\n".join(lines)
+    except SwishUnavailable as e:
+        return error_result(e)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to share program: {e}")
+        return error_result(e, "Failed to share program")
+
+
+@mcp.tool()
+async def collab_join(file: str, nickname: str = "", instance: str = "") -> str:
+    """
+    Open a file saved in SWISH's web UI to work on it together with the person editing it there.
+
+    The agent joins SWISH's collaboration websocket as a visitor of the
+    file. Every version saved in the browser from then on is collected
+    for collab_events, with its diff, and announced as resources/updated
+    for swish://collab/<file>; collab_push saves the agent's versions.
+
+    Args:
+        file: Name of the stored file, e.g. "family.pl", or its /p/ link
+        nickname: Name the browser shows for the agent (set by the first join)
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The file's current commit and text
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.backend == "local":
+            return ToolError("not_ready", "Collaboration needs the SWISH container; the local backend has no web UI").render()
+
+        name = storage_name(file)
+        bridge = collab_bridge(context, nickname)
+        shared = await bridge.join(name)
+        return (
+            f"🤝 Joined {name} at commit {shared.commit[:8] or '?'}; saves made in SWISH now show up in "
+            f"collab_events and as {collab_uri(name)}\n\n{shared.text}"
+        )
+    except CollabError as e:
+        return ToolError("existence_error", str(e), {"file": file}).render()
+    except SwishUnavailable as e:
+        return error_result(e)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to join {file}: {e}")
+        return error_result(e, "Failed to join the file")
+
+
+@mcp.tool()
+async def collab_events(
+    wait_seconds: float = 0,
+    file: str = "",
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Collect what happened to the joined files since the last call: saves (with diffs), chat, visitors.
+
+    Args:
+        wait_seconds: Wait up to this long for an event when none is waiting
+        file: Only the events of this file; all joined files when empty
+        output_format: "text", or "json" for a list of events
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The events, oldest first
+    """
+    try:
+        context = get_context(instance)
+
+        if context.collab is None or not context.collab.files:
+            return "🤝 No files joined. Use collab_join first."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        name = storage_name(file) if file.strip() else ""
+        await context.collab.wait(min(max(wait_seconds, 0), server_config.limits.wall_seconds))
+        events = context.collab.take_events(name)
+        if output_format == "json":
+            return json.dumps({"events": [event.to_json() for event in events], **context.collab.get_status()}, indent=2)
+        if not events:
+            return "🤝 Nothing new"
+        return "\n\n".join(event.describe() for event in events)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to collect collaboration events: {e}")
+        return error_result(e, "Failed to collect collaboration events")
+
+
+@mcp.tool()
+async def collab_push(
+    file: str,
+    text: str = "",
+    filename: str = "",
+    message: str = "",
+    instance: str = ""
+) -> str:
+    """
+    Save the agent's version of a joined file in SWISH, where the browser offers to reload it.
+
+    The save builds on the last version the agent saw; when the file was
+    saved in the browser since, SWISH refuses it, and the newer version
+    is waiting in collab_events. A file SWISH does not have yet is created.
+
+    Args:
+        file: Name of the stored file, e.g. "family.pl"
+        text: The new program text
+        filename: Or a .pl file in the data directory whose text to push
+        message: Commit message shown in SWISH's history
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The new commit
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if context.backend == "local":
+            return ToolError("not_ready", "Collaboration needs the SWISH container; the local backend has no web UI").render()
+        if bool(text) == bool(filename):
+            return ToolError("invalid_argument", "Pass either text or filename").render()
+        if filename:
+            text = program_file(context, filename).read_text(encoding="utf-8")
+
+        name = storage_name(file)
+        saved = await collab_bridge(context).push(name, text, message)
+        return f"✅ Saved {name} in SWISH as commit {saved.commit[:8] or '?'}"
+    except CollabError as e:
+        return ToolError("permission_error", str(e), {"file": file}).render()
+    except SwishUnavailable as e:
+        return error_result(e)
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to push {file}: {e}")
+        return error_result(e, "Failed to save the file in SWISH")
+
+
+@mcp.tool()
+async def collab_leave(file: str, instance: str = "") -> str:
+    """
+    Close a joined file; the websocket is closed with the last one.
+
+    Args:
+        file: Name of the stored file, e.g. "family.pl"
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        Confirmation
+    """
+    try:
+        context = get_context(instance)
+
+        name = storage_name(file)
+        if context.collab is None:
+            return f"❌ {name} is not open; collab_join it first"
+        await context.collab.leave(name)
+        return f"👋 Left {name}"
+    except CollabError as e:
+        return f"❌ {e}"
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to leave {file}: {e}")
+        return error_result(e, "Failed to leave the file")
+
+
+@mcp.tool()
+async def cluster_up(spec: str) -> str:
+    """
+    Bring up named SWISH instances from a declarative cluster spec.
+
+    The spec is JSON (inline or a file path) of the form
+    {"instances": [{"name": "tenant-a", "port": 3051, "data_dir": "..."}]}.
+    Port and data_dir are optional. Pass the instance name as the
+    `instance` argument of query and file tools to route calls to it.
+
+    Args:
+        spec: Inline JSON spec or path to a JSON spec file
+
+    Returns:
+        Per-instance start results
+    """
+    try:
+        context = get_context()
+        if not context.docker_available:
+            return "❌ Docker not available. Cannot start SWISH instances."
+
+        specs = load_cluster_spec(spec, Path.cwd())
+        results = await cluster_up_specs(context, specs)
+        icons = {"ready": "✅", "running": "♻️", "failed": "❌"}
+        lines = [f"  {icons[state]} {name}: {state}" for name, state in results.items()]
+        return "🧩 Cluster instances:\n" + "\n".join(lines)
+    except ValueError as e:
+        return error_result(e, "Invalid cluster spec")
+    except Exception as e:
+        logger.error(f"Failed to bring up cluster: {e}")
+        return error_result(e, "Failed to bring up cluster")
+
+
+@mcp.tool()
+async def cluster_down(name: str = "") -> str:
+    """
+    Stop and remove named SWISH instances.
+
+    Args:
+        name: Instance to stop; empty stops every named instance
+
+    Returns:
+        Status of the shutdown
+    """
+    try:
+        context = get_context()
+        names = [name] if name else list(context.instances)
+        if name and name not in context.instances:
+            return f"❌ Unknown SWISH instance '{name}'"
+        for instance_name in names:
+            await cluster_down_instance(context, instance_name)
+        return f"✅ Stopped instances: {', '.join(names) if names else 'none'}"
+    except Exception as e:
+        logger.error(f"Failed to stop cluster instances: {e}")
+        return error_result(e, "Failed to stop instances")
+
+
+@mcp.tool()
+async def cluster_status() -> str:
+    """
+    Report the status of the primary container and every named instance.
+
+    Returns:
+        JSON object keyed by instance name
+    """
+    try:
+        context = get_context()
+        status = {}
+        for name, instance in {"primary": context, **context.instances}.items():
+            container_status = "missing"
+            if instance.container:
+                try:
+                    await asyncio.to_thread(instance.container.reload)
+                    container_status = instance.container.status
+                except Exception:
+                    container_status = "unknown"
+            status[name] = {
+                "container": instance.container_name,
+                "status": container_status,
+                "ready": instance.container_ready,
+                "url": instance.swish_base_url,
+                "data_dir": str(instance.data_dir),
+                "session_active": bool(instance.prolog_session and instance.prolog_session.session_active),
+            }
+        return json.dumps(status, indent=2)
+    except Exception as e:
+        logger.error(f"Failed to get cluster status: {e}")
+        return error_result(e, "Failed to get cluster status")
+
+
+@mcp.tool()
+async def workspace_create(name: str, container: bool = False, description: str = "") -> str:
+    """
+    Create a workspace: a separate program directory with its own Prolog session.
+
+    Pass the name as the `instance` argument of query and file tools to
+    work in the workspace; its files, consulted predicates and asserted
+    facts are not seen by the primary session or other workspaces.
+    Workspaces are remembered across server restarts.
+
+    Args:
+        name: Workspace name (lowercase letters, digits, '.', '_' or '-')
+        container: Run the workspace in a container of its own, on its own
+            port, instead of a session in the primary container
+        description: What the workspace is for, shown by workspace_list()
+
+    Returns:
+        Where the workspace's files live and whether its session started
+    """
+    try:
+        context = get_context()
+        if workspace_registry is None:
+            return ToolError("not_ready", "Workspaces are not available until the server has started.").render()
+        if container and not context.docker_available:
+            return ToolError("not_ready", "Docker not available. Cannot start a workspace container.").render()
+
+        used_ports = {context.port} | {instance.port for instance in context.instances.values()}
+        workspace = workspace_registry.create(
+            name, context.data_dir, container, description, taken=set(context.instances), used_ports=used_ports
+        )
+        state = await open_workspace(context, workspace)
+        where = f"its own container on port {workspace.port}" if workspace.container else "the primary container"
+        if state == "failed":
+            return (
+                f"⚠️ Created workspace '{name}' in {where}, but its session did not start\n"
+                f"📁 Files: {workspace.data_dir}"
+            )
+        return (
+            f"✅ Created workspace '{name}' in {where}\n"
+            f"📁 Files: {workspace.data_dir}\n"
+            f"💡 Pass instance=\"{name}\" to query and file tools to use it"
+        )
+    except WorkspaceError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create workspace: {e}")
+        return error_result(e, "Failed to create workspace")
+
+
+@mcp.tool()
+async def workspace_list() -> str:
+    """
+    List the workspaces with their data directories and session state.
+
+    Returns:
+        JSON object keyed by workspace name
+    """
+    try:
+        context = get_context()
+        if not workspace_registry or not workspace_registry.workspaces:
+            return "📭 No workspaces. Create one with workspace_create()."
+        listing = {}
+        for name, workspace in sorted(workspace_registry.workspaces.items()):
+            instance = context.workspaces.get(name)
+            session = instance.prolog_session if instance else None
+            listing[name] = {
+                "description": workspace.description,
+                "data_dir": str(workspace.data_dir),
+                "container": workspace.container_name if workspace.container else context.container_name,
+                "own_container": workspace.container,
+                "url": f"http://localhost:{workspace.port}" if workspace.container else context.swish_base_url,
+                "ready": bool(instance and get_context(name).container_ready),
+                "session_active": bool(session and session.session_active),
+                "created": time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(workspace.created)),
+            }
+        return json.dumps(listing, indent=2)
+    except Exception as e:
+        logger.error(f"Failed to list workspaces: {e}")
+        return error_result(e, "Failed to list workspaces")
+
+
+@mcp.tool()
+async def workspace_delete(name: str, delete_files: bool = False) -> str:
+    """
+    Delete a workspace: close its session and stop its container, if it has one.
+
+    Args:
+        name: Workspace to delete
+        delete_files: Also remove the workspace's data directory; by default
+            the files stay on disk
+
+    Returns:
+        Status of the deletion
+    """
+    try:
+        context = get_context()
+        if workspace_registry is None:
+            return ToolError("not_ready", "Workspaces are not available until the server has started.").render()
+        workspace = workspace_registry.remove(name)
+        await close_workspace(context, name)
+        if delete_files:
+            await asyncio.to_thread(shutil.rmtree, workspace.data_dir, ignore_errors=True)
+            return f"✅ Deleted workspace '{name}' and its files"
+        return f"✅ Deleted workspace '{name}'\n📁 Its files remain in {workspace.data_dir}"
+    except WorkspaceError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to delete workspace: {e}")
+        return error_result(e, "Failed to delete workspace")
+
+
+@mcp.tool()
+async def sync_status(run_now: bool = False) -> str:
+    """
+    Show the state of the workspace sync, optionally syncing right away.
+
+    With SWISH_MCP_SYNC_DIR set, source files are mirrored both ways between
+    that host directory and the data directory, and consulted files edited
+    in the workspace are reloaded.
+
+    Args:
+        run_now: Sync now instead of waiting for the next poll
+
+    Returns:
+        Workspace, last sync, what it changed and any conflicts
+    """
+    try:
+        if workspace_sync is None:
+            return "ℹ️ Workspace sync is off. Set SWISH_MCP_SYNC_DIR (or --sync-dir) to a host directory."
+
+        if run_now:
+            await workspace_sync.run()
+
+        last = (
+            time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(workspace_sync.last_sync))
+            if workspace_sync.last_sync else "not yet"
+        )
+        report = workspace_sync.last_report
+        lines = [
+            f"🔁 Workspace: {workspace_sync.workspace}",
+            f"📁 Data directory: {workspace_sync.data_dir}",
+            f"⏱️ Last sync: {last} (every {workspace_sync.interval:g}s)",
+            f"📄 Files in sync: {len(workspace_sync.synced)}",
+        ]
+        if workspace_sync.last_error:
+            lines.append(f"❌ Last sync failed: {workspace_sync.last_error}")
+        if report:
+            lines.append(f"📋 Last change: {report.describe()}")
+            for label, paths in (
+                ("→ data", report.to_data),
+                ("→ workspace", report.to_workspace),
+                ("deleted", report.deleted),
+            ):
+                if paths:
+                    lines.append(f"  {label}: {', '.join(paths)}")
+            for path in report.conflicts:
+                lines.append(f"  ⚠️ {path} changed on both sides; the older version is in {path}{CONFLICT_SUFFIX}")
+        return "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to sync workspace: {e}")
+        return error_result(e, "Failed to sync workspace")
+
+
+@mcp.tool()
+async def quota_status() -> str:
+    """
+    Show the calling client's rate limit and quota usage.
+
+    Limits are set with SWISH_MCP_RATE_LIMIT (tool calls per minute),
+    SWISH_MCP_CPU_QUOTA (CPU seconds) and SWISH_MCP_CLAUSE_QUOTA (clauses
+    added) per SWISH_MCP_QUOTA_WINDOW; 0 means unlimited. Without API keys
+    a client is the address it connects from. This tool
+    still answers once a quota is used up.
+
+    Returns:
+        JSON with the limits, what was used in the current window and
+        when the window resets
+    """
+    try:
+        return json.dumps(quota_tracker.describe(quota_client_id()), indent=2)
+    except Exception as e:
+        logger.error(f"Failed to read quota usage: {e}")
+        return error_result(e, "Failed to read quota usage")
+
+
+@mcp.tool()
+async def disk_usage(refresh: bool = False, output_format: str = "text", instance: str = "") -> str:
+    """
+    Show the disk space the data directory, its snapshots and its bundles take.
+
+    Lists each area with its largest files, the free space on the disk,
+    and the limits beyond which tools that add files are refused:
+    SWISH_MCP_DISK_QUOTA (bytes all areas may hold) and
+    SWISH_MCP_DISK_RESERVE (free space to leave on the disk). This tool
+    still answers once a limit is reached.
+
+    Args:
+        refresh: Measure again instead of using a measurement up to 10 seconds old
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to measure
+
+    Returns:
+        Sizes of the data directory, exports, snapshots and bundles, and the limits
+    """
+    try:
+        context = get_context(instance)
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        usage = await asyncio.to_thread(disk_monitor.usage, storage_areas(context), refresh)
+        if output_format == "json":
+            return json.dumps(usage.to_json(server_config.disk), indent=2)
+        return usage.describe(server_config.disk)
+    except Exception as e:
+        logger.error(f"Failed to measure disk usage: {e}")
+        return error_result(e, "Failed to measure disk usage")
+
+
+@mcp.tool()
+async def tool_profile() -> str:
+    """
+    Show the tool profile of this connection and the profiles there are.
+
+    A profile decides which tools a connection is shown and may call:
+    analyst (queries and inspection), developer (also files, consults
+    and edits), admin (everything) or one configured in
+    SWISH_MCP_PROFILES. It follows the API key, or SWISH_MCP_PROFILE
+    without one; the X-MCP-Profile header narrows it further.
+
+    Returns:
+        The connection's profiles, how many tools they expose, and every
+        defined profile
+    """
+    try:
+        profiles = current_profiles()
+        key = current_api_key()
+        tools = [tool.name for tool in mcp._tool_manager.list_tools()]
+        lines = [
+            f"🧰 Profile: {' ∩ '.join(profile.name for profile in profiles)} "
+            f"({len(tools)} of {len(tool_input_schemas) or len(tools)} tools)",
+        ]
+        if key is not None:
+            lines.append(f"🔑 API key {key.key_id} ({', '.join(sorted(key.scopes))})")
+        lines.append("Profiles:")
+        for name in server_config.profiles.names():
+            profile = server_config.profiles.profiles[name]
+            marker = " (default without a key)" if name == server_config.profiles.default else ""
+            lines.append(f"  • {profile.describe()}{marker}")
+        return "\n".join(lines)
+    except Exception as e:
+        logger.error(f"Failed to describe tool profile: {e}")
+        return error_result(e, "Failed to describe tool profile")
+
+
+@mcp.tool()
+async def chaos_status(output_format: str = "text") -> str:
+    """
+    Show what chaos mode is injecting into tool calls and notifications.
+
+    Chaos mode (SWISH_MCP_CHAOS=on) is for testing clients: it delays
+    calls, fails them with typed errors, restarts the container and drops
+    notifications at random. This tool is never disturbed by it.
+
+    Args:
+        output_format: "text" or "json"
+
+    Returns:
+        The fault probabilities, how many faults of each kind were
+        injected so far and the most recent ones
+    """
+    try:
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        status = chaos_monkey.status()
+        if output_format == "json":
+            return json.dumps(status, indent=2)
+        return format_chaos_status(status)
+    except Exception as e:
+        logger.error(f"Failed to read chaos status: {e}")
+        return error_result(e, "Failed to read chaos status")
+
+
+@mcp.tool()
+async def kb_snapshot(label: str = "kb", source: str = "host", instance: str = "") -> str:
+    """
+    Archive the knowledge base directory into a timestamped snapshot.
+
+    Snapshots are stored next to the data directory (in swish-snapshots/),
+    so they survive container restarts and are not visible inside SWISH.
+
+    Args:
+        label: Short label used as the snapshot file name prefix
+        source: "host" to archive the mounted data directory, or "container"
+            to archive /data through the Docker API (for named volumes)
+        instance: Cluster instance or workspace to snapshot
+
+    Returns:
+        Path and size of the new snapshot
+    """
+    try:
+        context = get_context(instance)
+
+        await check_disk(context, "kb_snapshot")
+        if source == "container":
+            if not context.container:
+                return "❌ No SWISH container to snapshot"
+            archive = await asyncio.to_thread(
+                snapshot_container_dir, context.container, context.data_dir, label
+            )
+        elif source == "host":
+            if not context.data_dir.exists():
+                return f"❌ Data directory {context.data_dir} does not exist"
+            archive = await asyncio.to_thread(snapshot_host_dir, context.data_dir, label)
+        else:
+            return f"❌ Unknown snapshot source '{source}'. Use 'host' or 'container'."
+        disk_monitor.charge(context.data_dir, archive.stat().st_size)
+
+        return f"""✅ Snapshot created: {archive.name}
+📁 Path: {archive}
+📝 Size: {archive.stat().st_size} bytes
+🔄 Restore with: kb_restore("{archive.name}")"""
+
+    except DiskQuotaExceeded as e:
+        return error_result(e)
+    except Exception as e:
+        logger.error(f"Failed to create snapshot: {e}")
+        return error_result(e, "Failed to create snapshot")
+
+
+@mcp.tool()
+async def kb_restore(
+    name: str = "",
+    source: str = "host",
+    clean: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Restore the knowledge base directory from a snapshot.
+
+    Call without a name to list available snapshots. Before a host restore
+    the current directory is snapshotted as "pre-restore", so a restore
+    can itself be undone.
+
+    Args:
+        name: Snapshot file name, or "latest"; empty lists snapshots
+        source: "host" to extract into the mounted directory, or "container"
+            to upload into /data through the Docker API
+        clean: Remove current files before extracting (host only)
+        instance: Cluster instance or workspace to restore into
+
+    Returns:
+        Restore status, or the list of snapshots
+    """
+    try:
+        context = get_context(instance)
+
+        if not name:
+            snapshots = list_snapshots(context.data_dir)
+            if not snapshots:
+                return "📭 No snapshots yet. Create one with kb_snapshot()."
+            lines = [f"  📦 {p.name} ({p.stat().st_size} bytes)" for p in snapshots]
+            return "📚 Available snapshots (newest first):\n" + "\n".join(lines)
+
+        archive = resolve_snapshot(context.data_dir, name)
+
+        if source == "container":
+            if not context.container:
+                return "❌ No SWISH container to restore into"
+            restored = await asyncio.to_thread(restore_container_dir, archive, context.container)
+        elif source == "host":
+            safety = None
+            if context.data_dir.exists():
+                safety = await asyncio.to_thread(snapshot_host_dir, context.data_dir, "pre-restore")
+            restored = await asyncio.to_thread(restore_host_dir, archive, context.data_dir, clean)
+            if safety:
+                logger.info(f"Pre-restore snapshot saved as {safety.name}")
+        else:
+            return f"❌ Unknown restore target '{source}'. Use 'host' or 'container'."
+
+        if not instance:
+            await refresh_kb_resources()
+        return f"""✅ Restored {restored} files from {archive.name}
+🔄 Reload knowledge bases with load_knowledge_base() or restart_prolog_session()"""
+
+    except FileNotFoundError as e:
+        return f"❌ {e}. Call kb_restore() without a name to list snapshots."
+    except ValueError as e:
+        return error_result(e, "Snapshot rejected")
+    except Exception as e:
+        logger.error(f"Failed to restore snapshot: {e}")
+        return error_result(e, "Failed to restore snapshot")
+
+
+@mcp.tool()
+async def volume_list(everything: bool = False, output_format: str = "text") -> str:
+    """
+    List the named Docker volumes this server created, with the containers using them.
+
+    With SWISH_MCP_DATA_VOLUME (or volume in the config file's [container]
+    table) the container mounts that volume at /data, mirrored with the
+    host data directory.
+
+    Args:
+        everything: List every volume of the runtime, not just ours
+        output_format: "text" or "json"
+
+    Returns:
+        The volumes, which one is mounted for SWISH and the mirror's last sync
+    """
+    try:
+        context = get_context()
+        if not context.docker_available:
+            return "❌ Docker is not available. Cannot list volumes."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        rows = await asyncio.to_thread(describe_volumes, context.docker_client, everything)
+        if output_format == "json":
+            return json.dumps({"data_volume": context.volume or None, "volumes": rows}, indent=2)
+
+        if not rows:
+            return "📭 No volumes yet. Create one with volume_create() or set SWISH_MCP_DATA_VOLUME."
+        lines = ["📦 Volumes:"]
+        for row in rows:
+            mounted = " (mounted at /data)" if row["name"] == context.volume else ""
+            users = ", ".join(row["containers"]) or "unused"
+            lines.append(f"  {row['name']}{mounted}: {row['driver']}, {users}")
+        if volume_mirror and volume_mirror.volume:
+            last = (
+                time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(volume_mirror.last_sync))
+                if volume_mirror.last_sync else "not yet"
+            )
+            lines.append(f"🔁 Mirrored with {volume_mirror.data_dir}, last sync {last}")
+            if volume_mirror.last_error:
+                lines.append(f"❌ Last sync failed: {volume_mirror.last_error}")
+        return "\n".join(lines)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to list volumes: {e}")
+        return error_result(e, "Failed to list volumes")
+
+
+@mcp.tool()
+async def volume_create(name: str) -> str:
+    """
+    Create a named Docker volume for SWISH data, labelled as this server's.
+
+    Args:
+        name: Volume name (letters, digits, '_', '.' or '-')
+
+    Returns:
+        Whether the volume was created or already existed
+    """
+    try:
+        context = get_context()
+        if not context.docker_available:
+            return "❌ Docker is not available. Cannot create volumes."
+
+        if not await asyncio.to_thread(ensure_volume, context.docker_client, name):
+            return f"ℹ️ Volume {name} already exists"
+        return f"""✅ Created volume {name}
+💡 Mount it for SWISH with SWISH_MCP_DATA_VOLUME={name}, or fill it with volume_copy(direction="to_volume", volume="{name}")"""
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to create volume: {e}")
+        return error_result(e, "Failed to create volume")
+
+
+@mcp.tool()
+async def volume_prune(everything: bool = False) -> str:
+    """
+    Remove volumes no container uses, keeping the configured data volume.
+
+    Args:
+        everything: Also remove unused volumes this server did not create
+
+    Returns:
+        The volumes removed
+    """
+    try:
+        context = get_context()
+        if not context.docker_available:
+            return "❌ Docker is not available. Cannot prune volumes."
+
+        keep = frozenset({context.volume} - {""})
+        removed = await asyncio.to_thread(prune_volumes, context.docker_client, everything, keep)
+        if not removed:
+            return "ℹ️ No unused volumes to remove"
+        return f"🗑️ Removed {len(removed)} volume(s): {', '.join(removed)}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to prune volumes: {e}")
+        return error_result(e, "Failed to prune volumes")
+
+
+@mcp.tool()
+async def volume_copy(direction: str = "to_host", volume: str = "", path: str = "", instance: str = "") -> str:
+    """
+    Copy files between a named volume and the host data directory.
+
+    The mounted data volume is copied through the SWISH container; any
+    other volume through a helper container that is never started.
+
+    Args:
+        direction: "to_host" copies out of the volume, "to_volume" into it
+        volume: Volume name; default the mounted data volume
+        path: File or directory inside the data directory; default all of it
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The number of files copied
+    """
+    try:
+        context = get_context(instance)
+        if not context.docker_available:
+            return "❌ Docker is not available. Cannot copy volumes."
+        if direction not in COPY_DIRECTIONS:
+            return f"❌ Unknown direction '{direction}'. Use: {', '.join(COPY_DIRECTIONS)}"
+        volume = validate_volume_name(volume or context.volume)
+
+        copy = copy_to_host if direction == "to_host" else copy_to_volume
+        helper = None
+        if volume == context.volume and context.container:
+            container = context.container
+        else:
+            helper = await asyncio.to_thread(helper_container, context.docker_client, context_image(context), volume)
+            container = helper
+        try:
+            copied = await asyncio.to_thread(copy, container, context.data_dir, path)
+        finally:
+            if helper is not None:
+                await asyncio.to_thread(helper.remove, force=True)
+
+        if not instance:
+            await refresh_kb_resources()
+        arrow = f"{volume} → {context.data_dir}" if direction == "to_host" else f"{context.data_dir} → {volume}"
+        return f"✅ Copied {copied} file(s) {arrow}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to copy volume files: {e}")
+        return error_result(e, "Failed to copy volume files")
+
+
+@mcp.tool()
+async def kb_export_bundle(
+    name: str = "kb",
+    files: list[str] | None = None,
+    sign: bool = True,
+    instance: str = ""
+) -> str:
+    """
+    Export program files as a bundle: a zip with a manifest of SHA-256 hashes, signed when a key is configured.
+
+    Bundles are for handing a rule base to another team; kb_import_bundle
+    on their side checks that nothing was changed on the way. The
+    manifest is signed with the ed25519 key of SWISH_MCP_BUNDLE_KEY when
+    that is set.
+
+    Args:
+        name: Bundle name, used as the file name prefix
+        files: Files or glob patterns in the data directory, e.g.
+            ["family.pl", "rules/*.pl"]; default every .pl and .swinb file
+        sign: Sign the manifest when a signing key is configured
+        instance: Cluster instance or workspace to export from
+
+    Returns:
+        Path of the bundle, its files and hashes, and the signing key
+    """
+    try:
+        context = get_context(instance)
+        await check_disk(context, "kb_export_bundle")
+        bundle, verification = await asyncio.to_thread(
+            export_bundle, context.data_dir, name, files, server_config.bundles, sign
+        )
+        disk_monitor.charge(context.data_dir, bundle.stat().st_size)
+        lines = [f"✅ Bundle created: {bundle.name}", f"📁 Path: {bundle}", f"📄 {len(verification.files)} file(s):"]
+        lines.extend(f"  • {file.path} ({file.size} bytes, sha256 {file.sha256[:16]}…)" for file in verification.files)
+        if verification.signed:
+            lines.append(
+                f"🔏 Signed with key {verification.key_id}. To trust it, recipients add this line to their "
+                f"SWISH_MCP_BUNDLE_TRUSTED_KEYS file:\n  {verification.public_key} {name}"
+            )
+        elif sign and server_config.bundles.signing_key is None:
+            lines.append("⚠️ Not signed: set SWISH_MCP_BUNDLE_KEY to an ed25519 private key to sign bundles")
+        else:
+            lines.append("⚠️ Not signed")
+        lines.append(f"🔄 Import with: kb_import_bundle(\"{bundle.name}\")")
+        return "\n".join(lines)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except DiskQuotaExceeded as e:
+        return error_result(e)
+    except Exception as e:
+        logger.error(f"Failed to export bundle: {e}")
+        return error_result(e, "Failed to export bundle")
+
+
+@mcp.tool()
+async def kb_import_bundle(
+    bundle: str = "",
+    target: str = "",
+    overwrite: bool = False,
+    verify_only: bool = False,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Verify a knowledge base bundle and install its files into the data directory.
+
+    Every file must match its hash in the manifest, and nothing else may
+    be in the zip. A signed bundle's signature must verify, and its key
+    must be listed in SWISH_MCP_BUNDLE_TRUSTED_KEYS when that is set;
+    with SWISH_MCP_BUNDLE_SIGNATURES=required unsigned bundles are
+    refused. Nothing is written unless all checks pass. Call without a
+    bundle to list bundles.
+
+    Args:
+        bundle: Bundle file name in swish-bundles/, or a path to one
+        target: Directory in the data directory to install into; default its root
+        overwrite: Replace existing files with different contents (the data
+            directory is snapshotted as "pre-import" first)
+        verify_only: Only check the bundle and report its contents
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to import into
+
+    Returns:
+        The verification result and the files installed
+    """
+    try:
+        context = get_context(instance)
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        if not bundle:
+            bundles = list_bundles(context.data_dir)
+            if not bundles:
+                return "📭 No bundles yet. Create one with kb_export_bundle()."
+            lines = [f"  📦 {p.name} ({p.stat().st_size} bytes)" for p in bundles]
+            return "📚 Available bundles (newest first):\n" + "\n".join(lines)
+
+        path = resolve_bundle(context.data_dir, bundle)
+        try:
+            verification, contents = await asyncio.to_thread(verify_bundle, path, server_config.bundles)
+        except BundleError as e:
+            logger.warning(f"Bundle {path.name} rejected: {e}")
+            return error_result(e, "Bundle rejected")
+
+        written: list[str] = []
+        unchanged: list[str] = []
+        if not verify_only:
+            if overwrite and context.data_dir.exists():
+                safety = await asyncio.to_thread(snapshot_host_dir, context.data_dir, "pre-import")
+                logger.info(f"Pre-import snapshot saved as {safety.name}")
+            written, unchanged = await asyncio.to_thread(install_files, context.data_dir, contents, target, overwrite)
+            if written and not instance:
+                await refresh_kb_resources()
+
+        if output_format == "json":
+            return json.dumps({
+                "bundle": path.name,
+                **verification.to_json(),
+                "installed": written,
+                "unchanged": unchanged,
+            }, indent=2)
+        lines = [
+            f"✅ Bundle {path.name} verified: {len(verification.files)} file(s), hashes match, {verification.describe()}",
+            f"📝 {verification.name or 'unnamed'}, created {verification.created or 'unknown'}",
+        ]
+        if verify_only:
+            lines.extend(f"  • {file.path} ({file.size} bytes)" for file in verification.files)
+        else:
+            lines.extend(f"  📥 {name}" for name in written)
+            if unchanged:
+                lines.append(f"  ♻️ Already up to date: {', '.join(unchanged)}")
+            lines.append("🔄 Load them with load_knowledge_base()")
+        return "\n".join(lines)
+
+    except FileNotFoundError as e:
+        return f"❌ {e}. Call kb_import_bundle() without a bundle to list bundles."
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to import bundle: {e}")
+        return error_result(e, "Failed to import bundle")
+
+
+@mcp.tool()
+async def replay(bundle: str = "", restore: bool = False, instance: str = "") -> str:
+    """
+    Run a query recorded with reproduce_bundle=True again and compare the result.
+
+    Reports how the environment differs from the recording (image digest,
+    SWI-Prolog version, flags, hashes of the consulted files), then runs
+    the goal with the recorded limits and print options.
+
+    Args:
+        bundle: Reproduce bundle file name, "latest", or a path; empty lists bundles
+        restore: First write the bundled files back into the data directory
+            and reload them, set the recorded flags and restore the dynamic
+            databases (a pre-replay snapshot is taken), so the goal runs on
+            exactly the recorded state
+        instance: Cluster instance or workspace to replay in
+
+    Returns:
+        The environment differences and whether the result matches
+    """
+    try:
+        context = get_context(instance)
+
+        if not bundle:
+            bundles = list_repro_bundles(context.data_dir)
+            if not bundles:
+                return "📭 No reproduce bundles yet. Run execute_prolog_query(..., reproduce_bundle=True)."
+            lines = [f"  🧾 {p.name} ({p.stat().st_size} bytes)" for p in bundles]
+            return "🧾 Reproduce bundles (newest first):\n" + "\n".join(lines)
+
+        if not context.container_ready:
+            return NOT_READY
+        path = resolve_repro_bundle(context.data_dir, bundle)
+        manifest, contents = await asyncio.to_thread(read_repro_bundle, path)
+        goal = in_module(apply_policy(manifest.goal, sandbox_policy()), manifest.module)
+        lines = [f"🧾 {path.name}: {manifest.goal} (recorded {manifest.created}; {manifest.summary()})"]
+
+        if restore:
+            safety = None
+            if context.data_dir.exists():
+                safety = await asyncio.to_thread(snapshot_host_dir, context.data_dir, "pre-replay")
+            await asyncio.to_thread(restore_files, context.data_dir, contents)
+            root = prolog_data_dir(context)
+            state = KnowledgeState([(f"{root}/{file.path}", file.module) for file in manifest.files], manifest.databases)
+            problems = await replay_kb_state(context, state)
+            problems.extend(flag_errors(await run_json_helper(context, set_flags_call(manifest.flags))))
+            query_cache.clear(cache_scope(context))
+            saved = f" (the previous files are in snapshot {safety.name})" if safety else ""
+            lines.append(f"♻️ Restored {len(contents)} file(s), the flags and the dynamic databases{saved}")
+            lines.extend(f"  ⚠️ {problem}" for problem in problems)
+            if not instance:
+                await refresh_kb_resources()
+
+        flags = parse_flags(await run_json_helper(context, flags_call()))
+        differences = await asyncio.to_thread(
+            environment_differences, manifest, await repro_environment(context, flags), flags, context.data_dir
+        )
+        if differences:
+            lines.append("⚠️ The environment differs from the recording:")
+            lines.extend(f"  • {difference}" for difference in differences)
+        else:
+            lines.append("✅ The environment matches the recording")
+
+        changes_database = uses_category(manifest.goal, DATABASE_CATEGORY)
+        limits = QueryLimits(**manifest.limits)
+        printing = PrintOptions(**manifest.printing)
+        async with audited_database(context, "replay", manifest.goal, changes_database, manifest.module):
+            result = await cancellable(manifest.goal, "session", instance, lambda: run_session_query(
+                context, goal, limits, output_format=manifest.output_format, printing=printing
+            ))
+
+        if manifest.same_result(result):
+            lines.append("✅ Same result as recorded")
+        else:
+            lines.extend([
+                "❌ The result differs from the recording",
+                f"📼 Recorded:\n{manifest.result[:RESULT_PREVIEW]}",
+                f"🔁 Now:\n{result[:RESULT_PREVIEW]}",
+            ])
+        return "\n".join(lines)
+
+    except FileNotFoundError as e:
+        return f"❌ {e}. Call replay() without a bundle to list bundles."
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to replay query: {e}")
+        return error_result(e, "Failed to replay query")
+
+
+@mcp.tool()
+async def recording_start(name: str = "", include_files: bool = True) -> str:
+    """
+    Start recording your tool calls and their results, for replay_session().
+
+    Every call you make from now on is recorded with its arguments and
+    result until recording_stop(), which writes the recording to
+    swish-recordings/ next to the data directory. The data directory's
+    text files are recorded as they are now, so the recording can be
+    replayed elsewhere, e.g. attached to a bug report.
+
+    Args:
+        name: Recording name (letters, digits, '.', '_' or '-'); empty
+            names it after the current time
+        include_files: Record the data directory's files, for replaying
+            against a fresh container
+
+    Returns:
+        What the recording starts from
+    """
+    try:
+        context = get_context()
+        name = validate_recording_name(name or time.strftime("session-%Y%m%d-%H%M%S"))
+        if (recordings_dir_for(context.data_dir) / f"{name}.json").exists():
+            raise RecordingError(f"A recording named '{name}' already exists; choose another name")
+        files, skipped = await asyncio.to_thread(capture_files, context.data_dir) if include_files else ({}, [])
+        environment = {
+            "server": __version__,
+            "backend": context.backend,
+            "image": context_image(context) if context.backend != "local" else "",
+            "data_dir": str(context.data_dir),
+            "prolog_data_dir": prolog_data_dir(context),
+            "client_module": client_module(),
+        }
+        session_recorder.start(current_client_id(), Recording(name, environment, files, skipped))
+        lines = [f"⏺️ Recording '{name}': {len(files)} file(s) of the data directory recorded"]
+        if skipped:
+            lines.append(f"⚠️ Left out (too large or not text): {', '.join(skipped[:10])}"
+                         + (f" and {len(skipped) - 10} more" if len(skipped) > 10 else ""))
+        lines.append("💡 Your tool calls are recorded from now on; end with recording_stop()")
+        return "\n".join(lines)
+    except RecordingError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to start recording: {e}")
+        return error_result(e, "Failed to start recording")
+
+
+@mcp.tool()
+async def recording_stop() -> str:
+    """
+    Stop recording and write the recording started with recording_start().
+
+    Returns:
+        Where the recording was written and how many calls it holds
+    """
+    client = current_client_id()
+    try:
+        context = get_context()
+        recording = session_recorder.stop(client)
+    except RecordingError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        return error_result(e, "Failed to stop recording")
+    try:
+        await check_disk(context, "Writing the recording", len(json.dumps(recording.to_json())))
+        path = await asyncio.to_thread(write_recording, context.data_dir, recording)
+        disk_monitor.charge(context.data_dir, path.stat().st_size)
+        return f"""⏹️ Recording '{recording.name}' saved: {recording.summary()}
+📁 Path: {path}
+🔁 Replay with: replay_session("{recording.name}")"""
+    except Exception as e:
+        # Keep recording, so that a retry after freeing space loses nothing
+        session_recorder.active.setdefault(client, recording)
+        logger.error(f"Failed to write recording: {e}")
+        return error_result(e, "Failed to write recording; still recording")
+
+
+def tool_takes_instance(tool: str) -> bool:
+    return "instance" in tool_input_schemas.get(tool, {}).get("properties", {})
+
+
+async def start_replay(context: SwishContext, recording: Recording, keep: bool) -> Replay:
+    """A workspace with the recording's files to replay it in; its own container unless the backend is local."""
+    if workspace_registry is None:
+        raise RecordingError("Workspaces are not available until the server has started")
+    own_container = context.docker_available and context.backend != "local"
+    used_ports = {context.port} | {instance.port for instance in context.instances.values()}
+    workspace = workspace_registry.create(
+        replay_workspace_name(), context.data_dir, own_container, f"Replay of recording {recording.name}",
+        taken=set(context.instances), used_ports=used_ports
+    )
+    replay = Replay(recording, workspace.name, keep=keep)
+    await asyncio.to_thread(write_files, workspace.data_dir, recording.files)
+    if await open_workspace(context, workspace) == "failed":
+        replay.keep = False
+        await end_replay(context, replay)
+        raise RecordingError(f"The session of replay workspace {workspace.name} did not start")
+    replay.paths = {
+        str(workspace.data_dir): "<data>",
+        recording.environment.get("data_dir", ""): "<data>",
+        prolog_data_dir(get_context(workspace.name)): "<data>",
+        recording.environment.get("prolog_data_dir", ""): "<data>",
+        workspace.container_name: "<container>",
+    }
+    return replay
+
+
+async def end_replay(context: SwishContext, replay: Replay) -> str:
+    """Remove a replay's workspace, unless it is kept; says which."""
+    if replay.keep:
+        return f"📁 Kept workspace {replay.workspace}; pass instance=\"{replay.workspace}\" to look at it"
+    if workspace_registry is not None and replay.workspace in workspace_registry.workspaces:
+        workspace = workspace_registry.remove(replay.workspace)
+        await close_workspace(context, replay.workspace)
+        await asyncio.to_thread(shutil.rmtree, workspace.data_dir, ignore_errors=True)
+    return f"🧹 Removed workspace {replay.workspace}"
+
+
+async def run_replay_step(replay: Replay) -> StepOutcome:
+    """Make the next recorded call again in the replay's workspace and compare its result."""
+    index = replay.position + 1
+    step = replay.recording.steps[index - 1]
+    if not tool_takes_instance(step.tool):
+        outcome = StepOutcome(index, step.tool, skipped="acts on the server, not the session; not replayed")
+    else:
+        try:
+            text = result_text(await mcp._tool_manager.call_tool(step.tool, {**step.args, "instance": replay.workspace}))
+            failed = result_failed(text)
+        except Exception as e:
+            text, failed = str(e), True
+        outcome = StepOutcome(index, step.tool, result_difference(step, text, failed, replay.paths), result=text)
+    replay.outcomes.append(outcome)
+    return outcome
+
+
+@mcp.tool()
+async def replay_session(name: str = "", step: bool = False, stop: bool = False, keep: bool = False) -> str:
+    """
+    Run a session recording again against a fresh container and compare the results.
+
+    A temporary workspace with a container of its own gets the recorded
+    files, and the recorded calls are made again in it; tools without an
+    instance argument act on the server and are skipped. Each result is
+    compared with the recorded one, ignoring durations, timestamps and
+    the data directory's path.
+
+    Args:
+        name: Recording name, "latest", or a path; empty lists the recordings
+        step: Run one step per call: the first call starts the replay and
+            runs step 1, each further call with the same name the next one.
+            Without step, a replay started in step mode runs to its end
+        stop: End a step-mode replay before its last step
+        keep: Keep the replay's workspace afterwards, to look at with its
+            name as instance (remove it with workspace_delete)
+
+    Returns:
+        Each step's outcome, with the recorded and new results of steps that differ
+    """
+    try:
+        context = get_context()
+        if not name:
+            recordings = list_recordings(context.data_dir)
+            if not recordings and not step_replays:
+                return "📭 No recordings yet. Start one with recording_start()."
+            lines = ["📼 Recordings (newest first):"]
+            lines.extend(f"  📼 {path.stem} ({path.stat().st_size} bytes)" for path in recordings)
+            for replay_name, replay in step_replays.items():
+                lines.append(f"⏯️ {replay_name}: step {replay.position}/{len(replay.recording.steps)} in {replay.workspace}")
+            return "\n".join(lines)
+
+        replay = step_replays.get(name)
+        if stop:
+            if replay is None:
+                return f"❌ No step-mode replay of '{name}' is running"
+            del step_replays[name]
+            return f"⏹️ Stopped replaying {name} at step {replay.position}\n{replay.summary()}\n{await end_replay(context, replay)}"
+
+        lines = []
+        if replay is None:
+            if not context.container_ready:
+                return NOT_READY
+            recording = await asyncio.to_thread(read_recording, resolve_recording(context.data_dir, name))
+            if not recording.steps:
+                return f"📭 Recording {recording.name} has no steps"
+            replay = await start_replay(context, recording, keep)
+            lines.append(f"🎬 Replaying {recording.name} ({recording.summary()}) in workspace {replay.workspace}")
+            if recording.skipped_files:
+                lines.append(f"⚠️ Not recorded, so missing from the replay: {', '.join(recording.skipped_files[:10])}")
+        steps = replay.recording.steps
+        total = len(steps)
+
+        if step:
+            step_replays[name] = replay
+            outcome = await run_replay_step(replay)
+            lines.extend(outcome.describe(steps[outcome.index - 1]))
+            if not replay.finished:
+                lines.append(
+                    f"⏯️ Step {replay.position}/{total}; call replay_session(\"{name}\", step=True) for the next, "
+                    "or with stop=True to end"
+                )
+                return "\n".join(lines)
+        else:
+            while not replay.finished:
+                outcome = await run_replay_step(replay)
+                await report_progress(replay.position, f"Step {replay.position}/{total}: {outcome.tool}")
+                lines.extend(outcome.describe(steps[outcome.index - 1]))
+
+        step_replays.pop(name, None)
+        lines.append(replay.summary())
+        lines.append(await end_replay(context, replay))
+        return "\n".join(lines)
+
+    except FileNotFoundError as e:
+        return f"❌ {e}. Call replay_session() without a name to list recordings."
+    except (RecordingError, WorkspaceError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to replay session: {e}")
+        return error_result(e, "Failed to replay session")
+
+
+async def refresh_session_packs(context: SwishContext) -> None:
+    """Make newly installed or removed packs visible to the persistent session."""
+    if context.prolog_session:
+        async for event in context.prolog_session.stream_query("attach_packs"):
+            if event["type"] == "error":
+                logger.warning(f"attach_packs failed: {event['error']}")
+
+
+@mcp.tool()
+async def share_module(name: str = "", leave: bool = False) -> str:
+    """
+    Choose the Prolog module your queries, asserts and consults go to.
+
+    When clients are isolated (SWISH_MCP_ISOLATION), each one works in a
+    private module so their facts do not collide. Join a named module to
+    share facts with other clients that join it too; "user" shares the
+    global module. Modules inherit from user either way.
+
+    Args:
+        name: Shared module to join, e.g. "team_kb"; empty just reports the current module
+        leave: Return to your private module
+
+    Returns:
+        The module your goals now run in
+    """
+    try:
+        client_id = current_client_id()
+        if not client_modules.enabled:
+            return "🧱 Client isolation is off: every client works in module user. Set SWISH_MCP_ISOLATION=on to isolate clients."
+        if leave:
+            module = client_modules.leave(client_id)
+            return f"🧱 Back in your private module {module}"
+        if name:
+            module = client_modules.share(client_id, name)
+            others = [c for c in client_modules.members(module) if c != client_id]
+            joined = f"; also used by {', '.join(others)}" if others else ""
+            return f"🤝 Your goals now run in shared module {module}{joined}"
+        module = client_module()
+        kind = "shared" if client_id in client_modules.shared else "private"
+        return f"🧱 Your goals run in {kind} module {module}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to change module: {e}")
+        return error_result(e, "Failed to change module")
+
+
+@mcp.tool()
+async def kb_graph(
+    kind: str = "calls",
+    relation: str = "",
+    focus: str = "",
+    format: str = "svg",
+    max_edges: int = 500,
+    instance: str = ""
+) -> str | list[Any]:
+    """
+    Draw the knowledge base as a graph, rendered with Graphviz.
+
+    kind="calls" shows the predicates defined in the session and which
+    call which (dynamic predicates dashed); focus narrows it to what one
+    predicate calls, directly or indirectly. kind="facts" draws the
+    clauses of relation as edges from their first to their second
+    argument, labelled with any further arguments.
+
+    Args:
+        kind: "calls" or "facts"
+        relation: Relation to draw for kind="facts", e.g. "parent/2"
+        focus: For kind="calls", a predicate such as "ancestor/2" to start from
+        format: "svg" or "png" for an image, or "dot" for the Graphviz source
+        max_edges: Maximum number of edges to draw
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        A summary and the rendered image, or the DOT text
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if format not in GRAPH_FORMATS:
+            return f"❌ Unknown format '{format}'. Use one of: {', '.join(GRAPH_FORMATS)}"
+
+        call = graph_call(kind, relation, max(1, max_edges), client_module())
+        try:
+            rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not read the knowledge base")
+
+        if kind == "calls":
+            dot, nodes, edges = call_graph_dot(rows, focus.strip())
+            if not nodes:
+                return "🕸️ No predicates defined yet. Load or assert some clauses first."
+        else:
+            dot, nodes, edges = fact_graph_dot(rows, relation.strip())
+            if not edges:
+                return f"🕸️ No facts for {relation.strip()}"
+        truncated = " (edge limit reached)" if edges >= max_edges else ""
+        summary = f"🕸️ {kind} graph: {nodes} nodes, {edges} edges{truncated}"
+        if format == "dot":
+            return f"{summary}\n\n{dot}"
+
+        try:
+            returncode, stdout, stderr = await exec_in_container(
+                context.docker_client, context.container_name, render_command(dot, format), timeout=60
+            )
+        except (ContainerExecError, asyncio.TimeoutError) as e:
+            returncode, stdout, stderr = -1, "", str(e) or "timed out"
+        if returncode != 0:
+            logger.warning(f"Graphviz rendering failed: {stderr.strip()}")
+            return f"{summary}\n⚠️ Graphviz is not available in the container ({stderr.strip()}); DOT source:\n\n{dot}"
+
+        if format == "png":
+            image = Image(data=base64.b64decode(stdout), format="png")
+        else:
+            image = Image(data=stdout.encode("utf-8"), format="svg+xml")
+        return [summary, image]
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to draw knowledge base graph: {e}")
+        return error_result(e, "Failed to draw knowledge base graph")
+
+
+def program_file(context: SwishContext, filename: str) -> Path:
+    """A .pl file in the data directory; raises ValueError if missing or outside it."""
+    name = filename.strip()
+    if not name.endswith(".pl"):
+        name += ".pl"
+    path = (context.data_dir / name).resolve()
+    if not path.is_relative_to(context.data_dir.resolve()):
+        raise ValueError(f"'{filename}' is outside the data directory")
+    if not path.is_file():
+        raise ValueError(f"File '{name}' not found. Use list_prolog_files() to see available files.")
+    return path
+
+
+async def read_clause_spans(
+    context: SwishContext,
+    path: Path,
+    clause: str = "",
+    head: str = ""
+) -> tuple[str, list[ClauseSpan], str]:
+    """A data file's text and clause spans, and the predicate of clause if given."""
+    relative = path.relative_to(context.data_dir.resolve()).as_posix()
+    rows = await run_json_helper(context, spans_call(f"{prolog_data_dir(context)}/{relative}", clause, head))
+    text = path.read_bytes().decode("utf-8")
+    spans, predicate = parse_spans(text, rows)
+    return text, spans, predicate
+
+
+async def write_clause_edit(
+    context: SwishContext,
+    tool: str,
+    detail: str,
+    path: Path,
+    text: str,
+    reload: bool
+) -> str:
+    """Save an edited file and, with reload, have make/0 reload it if it is loaded."""
+    async with audited_files(context, tool, detail, [path]):
+        path.write_bytes(text.encode("utf-8"))
+    if context is global_swish_context:
+        await refresh_kb_resources()
+    session = context.prolog_session
+    if not reload or not session or not session.session_active:
+        return ""
+    query_cache.clear(cache_scope(context))
+    async for event in session.stream_query("make", server_config.limits):
+        if event["type"] == "error":
+            return f"\n⚠️ Reloading failed: {event['error']}"
+    return "\n🔄 Reloaded where loaded"
+
+
+@mcp.tool()
+async def clause_insert(
+    filename: str,
+    clause: str,
+    after: int = -1,
+    reload: bool = True,
+    instance: str = ""
+) -> str:
+    """
+    Insert a clause into a .pl file next to the other clauses of its predicate.
+
+    Only the new clause's line is added; comments and the layout of the
+    rest of the file are kept. A predicate the file does not define yet
+    is appended at the end.
+
+    Args:
+        filename: .pl file in the data directory
+        clause: The clause, e.g. "parent(ann, bob)." or "grandparent(X, Z) :- parent(X, Y), parent(Y, Z)."
+        after: Insert after this clause (from 1) of the predicate; 0 inserts
+            before the first clause, -1 after the last
+        reload: Reload the file with make/0 if it is loaded in the session
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        Where the clause was inserted
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        check_text(clause, sandbox_policy())
+        path = program_file(context, filename)
+        try:
+            text, spans, predicate = await read_clause_spans(context, path, clause=clean_query_text(clause))
+        except RuntimeError as e:
+            return error_result(e, "Could not read clauses")
+        text, index = insert_clause(text, spans, predicate, clause, after)
+        note = await write_clause_edit(context, "clause_insert", f"{path.name}: {predicate}", path, text, reload)
+        return f"✅ Inserted clause {index} of {predicate} in {path.name}{note}"
+    except (SandboxViolation, ValueError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to insert clause: {e}")
+        return error_result(e, "Failed to insert clause")
+
+
+@mcp.tool()
+async def clause_replace(
+    filename: str,
+    predicate: str,
+    index: int,
+    clause: str,
+    reload: bool = True,
+    instance: str = ""
+) -> str:
+    """
+    Replace the Nth clause of a predicate in a .pl file, leaving the rest of the file as it is.
+
+    Args:
+        filename: .pl file in the data directory
+        predicate: Predicate indicator, e.g. "parent/2" (DCG rules: "greeting//0" or "greeting/2")
+        index: Which clause of the predicate, counting from 1 in file order
+        clause: The clause to put in its place
+        reload: Reload the file with make/0 if it is loaded in the session
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The clause that was replaced
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        predicate = predicate_indicator(predicate)
+        check_text(clause, sandbox_policy())
+        path = program_file(context, filename)
+        try:
+            text, spans, new_predicate = await read_clause_spans(context, path, clause=clean_query_text(clause))
+        except RuntimeError as e:
+            return error_result(e, "Could not read clauses")
+        span = nth_span(spans, predicate, index)
+        old = text[span.start:span.end]
+        text = replace_clause(text, span, clause)
+        note = await write_clause_edit(context, "clause_replace", f"{path.name}: {predicate} #{index}", path, text, reload)
+        moved = "" if new_predicate == predicate else f"\n⚠️ The new clause belongs to {new_predicate}"
+        return f"✅ Replaced clause {index} of {predicate} (line {span.line}) in {path.name}\n🗑️ Was: {old}{moved}{note}"
+    except (SandboxViolation, ValueError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to replace clause: {e}")
+        return error_result(e, "Failed to replace clause")
+
+
+@mcp.tool()
+async def clause_retract(
+    filename: str,
+    head: str,
+    all_matches: bool = False,
+    reload: bool = True,
+    instance: str = ""
+) -> str:
+    """
+    Remove clauses whose head unifies with head from a .pl file.
+
+    Unlike retract/1 this edits the source, so the clause stays gone when
+    the file is consulted again. Comments and other clauses are kept.
+
+    Args:
+        filename: .pl file in the data directory
+        head: Head to match, e.g. "parent(tom, _)"
+        all_matches: Remove every matching clause instead of the first
+        reload: Reload the file with make/0 if it is loaded in the session
+        instance: Cluster instance or workspace whose data directory to use
+
+    Returns:
+        The clauses that were removed
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        head = clean_query_text(head)
+        if not head:
+            return ToolError("invalid_argument", "Empty head").render()
+        path = program_file(context, filename)
+        try:
+            text, spans, _ = await read_clause_spans(context, path, head=head)
+        except RuntimeError as e:
+            return error_result(e, "Could not read clauses")
+        targets = retract_targets(spans, all_matches)
+        removed = [f"  • line {span.line}: {text[span.start:span.end]}" for span in targets]
+        text = remove_clauses(text, targets)
+        note = await write_clause_edit(context, "clause_retract", f"{path.name}: {head}", path, text, reload)
+        return f"✅ Removed {len(targets)} clause(s) from {path.name}:\n" + "\n".join(removed) + note
+    except (SandboxViolation, ValueError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to retract clause: {e}")
+        return error_result(e, "Failed to retract clause")
+
+
+@mcp.tool()
+async def retract_matching(pattern: str, dry_run: bool = False, instance: str = "") -> str:
+    """
+    Retract every clause whose head unifies with a pattern from the session.
+
+    Cleans up after exploration in one call instead of clause by clause,
+    e.g. "visited(_, _)" or "memo(fib(_), _)". "Head :- Body" also matches
+    the body. Only dynamic predicates of the client's module are touched;
+    the change is in the audit log, so undo_last brings the clauses back.
+
+    Args:
+        pattern: Head to match, e.g. "visited(_, _)", or "Head :- Body"
+        dry_run: Only list the clauses that would be retracted
+        instance: Cluster instance or workspace
+
+    Returns:
+        The clauses retracted (or that would be)
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ retract_matching requires the persistent Prolog session. Try restart_prolog_session()."
+        pattern = clean_query_text(pattern)
+        if not pattern:
+            return ToolError("invalid_argument", "Empty pattern").render()
+        if not dry_run:
+            check_text(f"retract(({pattern}))", sandbox_policy())
+            require_unrestricted(sandbox_policy(), "retract", "retract_matching")
+
+        module = client_module()
+        try:
+            async with audited_database(context, "retract_matching", pattern, not dry_run, module):
+                rows = await run_json_helper(context, retract_call(module, pattern, dry_run))
+        except RuntimeError as e:
+            return error_result(e, "Could not retract the clauses")
+        if rows and not dry_run:
+            query_cache.clear(cache_scope(context))
+            if not instance:
+                await kb_resources.notify_all_updated()
+        return format_retracted(pattern, rows, dry_run)
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to retract matching clauses: {e}")
+        return error_result(e, "Failed to retract matching clauses")
+
+
+@mcp.tool()
+async def kb_prune(entry_points: list[str], dry_run: bool = True, instance: str = "") -> str:
+    """
+    Abolish the predicates none of the given entry points can reach.
+
+    Follows the call graph (as kb_graph draws it) from the entry points
+    and removes every other user predicate of the client's module from
+    the session. Predicates only called through goals built at runtime
+    are not seen; list them as entry points too. Dry run by default.
+
+    Args:
+        entry_points: Goals or Name/Arity indicators the knowledge base
+            must still answer, e.g. ["main", "solve/2", "path(a, _)"]
+        dry_run: Only report what would be abolished (default); False abolishes it
+        instance: Cluster instance or workspace
+
+    Returns:
+        The unreachable predicates, abolished or that would be
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ kb_prune requires the persistent Prolog session. Try restart_prolog_session()."
+        if not dry_run:
+            check_text("abolish(_)", sandbox_policy())
+            require_unrestricted(sandbox_policy(), "retract", "pruning the knowledge base")
+
+        module = client_module()
+        try:
+            call = prune_call(module, entry_points, dry_run)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            async with audited_database(context, "kb_prune", ", ".join(entry_points), not dry_run, module):
+                rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not prune the knowledge base")
+        if not dry_run and any("predicate" in row for row in rows):
+            query_cache.clear(cache_scope(context))
+            if not instance:
+                await kb_resources.notify_all_updated()
+        return format_pruned(rows, dry_run)
+
+    except SandboxViolation as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to prune the knowledge base: {e}")
+        return error_result(e, "Failed to prune the knowledge base")
+
+
+@mcp.tool()
+async def draft_rules(description: str, candidates: int = DEFAULT_CANDIDATES, instance: str = "") -> str:
+    """
+    Draft Prolog clauses from a natural-language description with the client's model.
+
+    Sends the description and the schema of the client's module (its
+    predicates with a few example clauses) to the client's model through
+    an MCP sampling request. Each draft that comes back is screened by
+    the sandbox and loaded into a scratch module; only drafts that load
+    without errors are returned, and none is consulted. Needs a client
+    that supports sampling.
+
+    Args:
+        description: What the rules should express, e.g. "a grandparent
+            is a parent of a parent"
+        candidates: Number of alternative drafts to ask for (1-10)
+        instance: Cluster instance or workspace whose knowledge base to draft for
+
+    Returns:
+        The drafts that load cleanly, with their warnings, and why the others were dropped
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not description.strip():
+            raise ValueError("Describe the rules to draft")
+        if not 1 <= candidates <= MAX_CANDIDATES:
+            raise ValueError(f"candidates must be between 1 and {MAX_CANDIDATES}")
+        try:
+            session = mcp.get_context().session
+        except ValueError:
+            session = None
+
+        try:
+            schema = format_schema(await run_json_helper(context, schema_call(client_module())))
+            answer = await sample(session, draft_prompt(description, schema, candidates))
+        except RuntimeError as e:
+            return error_result(e, "Could not draft rules")
+
+        policy = sandbox_policy()
+        drafts, rejected = [], []
+        for candidate in parse_candidates(answer)[:MAX_CANDIDATES]:
+            if has_directive(candidate):
+                rejected.append((candidate, "contains a directive"))
+                continue
+            try:
+                check_text(candidate, policy)
+            except SandboxViolation as e:
+                rejected.append((candidate, str(e)))
+                continue
+            drafts.append(candidate)
+
+        accepted = []
+        if drafts:
+            try:
+                results = parse_draft_check(await run_json_helper(context, draft_check_call(drafts)))
+            except RuntimeError as e:
+                return error_result(e, "Could not check the drafts")
+            for candidate, (errors, diagnostics) in zip(drafts, results):
+                if errors:
+                    first = next((d.describe() for d in diagnostics if d.severity == "error"), "does not load")
+                    rejected.append((candidate, first))
+                else:
+                    accepted.append((candidate, diagnostics))
+        return format_drafts(accepted, rejected)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to draft rules: {e}")
+        return error_result(e, "Failed to draft rules")
+
+
+@mcp.tool()
+async def write_resource(uri: str, text: str, reload: bool = True) -> str:
+    """
+    Replace the text of a knowledge base resource (swish://kb/{file}).
+
+    MCP has no resource write request, so this is the write side of the
+    swish://kb/ resources (advertised as the swish/resourceWrite
+    capability). SWI-Prolog reads the new text first, without loading it;
+    if it has syntax errors the file is left as it was and the errors are
+    reported with their lines. A file that does not exist yet is created.
+
+    Args:
+        uri: Resource URI, e.g. "swish://kb/family.pl" or "swish://kb/project/rules.pl"
+        text: The complete new file text
+        reload: Reload the file with make/0 if it is loaded in the session
+
+    Returns:
+        Confirmation, or the syntax errors that kept the write from happening
+    """
+    try:
+        context = get_context()
+
+        if not context.container_ready:
+            return NOT_READY
+        relative = kb_relative_path(uri)
+        path = (context.data_dir / relative).resolve()
+        if not path.is_relative_to(context.data_dir.resolve()):
+            return ToolError("invalid_argument", f"'{uri}' is outside the data directory").render()
+        check_text(text, sandbox_policy())
+
+        try:
+            rows = await run_json_helper(context, syntax_call(text))
+        except RuntimeError as e:
+            return error_result(e, f"Could not check {relative}")
+        if rows:
+            lines = [f"  • line {row['line']}: {row['message']}" for row in rows]
+            return ToolError(
+                "syntax_error",
+                f"{relative} was not written; SWI-Prolog found {len(rows)} syntax error(s):\n" + "\n".join(lines),
+                {"uri": uri, "errors": rows}
+            ).render()
+
+        created = not path.exists()
+        path.parent.mkdir(parents=True, exist_ok=True)
+        note = await write_clause_edit(context, "write_resource", relative, path, text, reload and not created)
+        return f"✅ {'Created' if created else 'Wrote'} {uri} ({len(text.splitlines())} lines){note}"
+    except (SandboxViolation, ValueError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to write resource: {e}")
+        return error_result(e, "Failed to write resource")
+
+
+@mcp.tool()
+async def kb_diff(
+    left: str,
+    right: str = "loaded",
+    ignore_order: bool = False,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Compare two versions of a program clause by clause.
+
+    Clauses are compared with their variables renamed consistently, so
+    renaming variables, reformatting or editing comments is not a change.
+    Changed clauses are listed per predicate as added, removed or
+    modified. Compare two files, or a file with what is loaded now
+    (right="loaded"), e.g. to see what was asserted or retracted since it
+    was consulted.
+
+    Args:
+        left: Program file in the data directory, e.g. "family.pl"
+        right: Another program file, or "loaded" for the clauses currently in the
+            session of the predicates left defines
+        ignore_order: Treat reordered clauses within a predicate as unchanged
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The clause-level differences between left and right
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        root = context.data_dir.resolve()
+        left_path = program_file(context, left)
+        left_name = left_path.relative_to(root).as_posix()
+        try:
+            left_rows = await run_json_helper(context, file_clauses_call(f"{prolog_data_dir(context)}/{left_name}"))
+            if right.strip() == LOADED:
+                right_name = "loaded program"
+                predicates = sorted({row["predicate"] for row in left_rows})
+                call = loaded_clauses_call(client_module(), f"{prolog_data_dir(context)}/{left_name}", predicates)
+            else:
+                right_name = program_file(context, right).relative_to(root).as_posix()
+                call = file_clauses_call(f"{prolog_data_dir(context)}/{right_name}")
+            right_rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not read clauses")
+
+        changes = diff_clauses(left_rows, right_rows, ignore_order)
+        if output_format == "json":
+            return json.dumps({
+                "left": left_name,
+                "right": right_name,
+                "clauses": [len(left_rows), len(right_rows)],
+                "changes": [change.to_json() for change in changes],
+            }, indent=2)
+        return format_diff(changes, left_name, right_name, (len(left_rows), len(right_rows)))
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to diff knowledge base: {e}")
+        return error_result(e, "Failed to diff knowledge base")
+
+
+@mcp.tool()
+async def kb_search(
+    pattern: str,
+    kind: str = "definition",
+    filename: str = "",
+    max_results: int = 50,
+    output_format: str = "text",
+    instance: str = ""
+) -> str:
+    """
+    Search the loaded knowledge base files for definitions, clause bodies or comments.
+
+    Use this instead of reading large files to find where something is
+    defined or used. Only files loaded from the data directory are
+    searched; every hit has its file and line.
+
+    Args:
+        pattern: A regex over Name/Arity for kind="definition" (e.g.
+            "^parent/"), a Prolog term for kind="body" (e.g. "ancestor(_, _)";
+            variables match anything), or a regex for kind="comment"
+        kind: "definition", "body" or "comment"
+        filename: Only search this loaded file, e.g. "family.pl"
+        max_results: Most hits to return
+        output_format: "text" for hits grouped by file, or "json"
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        The matching predicates, clauses or comment lines with their locations
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if kind not in SEARCH_KINDS:
+            return f"❌ Unknown kind '{kind}'. Use one of: {', '.join(SEARCH_KINDS)}"
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if not pattern.strip():
+            return "❌ Empty search pattern provided"
+
+        regex = compile_pattern(kind, pattern)
+        root = prolog_data_dir(context)
+        name = ""
+        if filename.strip():
+            name = program_file(context, filename).relative_to(context.data_dir.resolve()).as_posix()
+        call = search_call(kind, pattern, root, f"{root}/{name}" if name else "", max_results)
+        try:
+            rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Search failed")
+
+        hits, truncated = collect_hits(kind, regex, rows, root, name, max(1, max_results))
+        if output_format == "json":
+            return json.dumps({
+                "kind": kind,
+                "pattern": pattern,
+                "truncated": truncated,
+                "hits": [hit.to_json() for hit in hits],
+            }, indent=2)
+        return format_hits(kind, pattern, hits, truncated)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to search knowledge base: {e}")
+        return error_result(e, "Failed to search knowledge base")
+
+
+@mcp.tool()
+async def describe_predicate(predicate: str, output_format: str = "text", instance: str = "") -> str:
+    """
+    Look up a predicate's documentation: its modes, determinism, PlDoc comment and where it is defined.
+
+    Works for predicates of the loaded program and for library and
+    built-in predicates; a library predicate that would be autoloaded is
+    loaded to find its source. Without an arity every predicate of that
+    name is described.
+
+    Args:
+        predicate: Name, Name/Arity, Name//Arity (a DCG) or Module:Name/Arity,
+            e.g. "foldl/4", "append" or "lists:append/3"
+        output_format: "text" for markdown, or "json" for the raw fields
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        One markdown section per predicate found
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        module, name, arity = parse_spec(predicate)
+        try:
+            rows = await run_json_helper(context, describe_call(name, arity, module or client_module()))
+        except RuntimeError as e:
+            return error_result(e, "Could not look up the predicate")
+        if not rows:
+            return ToolError(
+                "existence_error",
+                f"No predicate {predicate.strip()} is defined, loaded or autoloadable",
+                {"predicate": predicate.strip()}
+            ).render()
+
+        if output_format == "json":
+            return json.dumps({"predicate": predicate.strip(), "predicates": rows}, indent=2)
+        return format_descriptions(rows, prolog_data_dir(context))
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to describe predicate: {e}")
+        return error_result(e, "Failed to describe predicate")
+
+
+@mcp.tool()
+async def run_tests(
+    units: list[str] | None = None,
+    output_format: str = "text",
+    timeout: int | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Run the plunit test units loaded in the session and report each test's result.
+
+    Load a file containing begin_tests/end_tests blocks first. Failed
+    tests show the check that failed with the value the test produced
+    and the expected one; errors show the exception.
+
+    Args:
+        units: Names of the units to run; all loaded units when omitted
+        output_format: "text" for a summary, or "json" for one result per test
+            (unit, test, line, status, reason, check, got, expected, error)
+        timeout: Wall-clock limit in seconds for each test
+        instance: Cluster instance or workspace to query
+
+    Returns:
+        Pass/fail counts and the details of every test that did not pass
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        limits = server_config.limits.override(timeout, None, None)
+        run_limits = replace(limits, wall_seconds=max(limits.wall_seconds, RUN_WALL_SECONDS))
+        try:
+            rows = await run_json_helper(context, tests_call(units or [], limits), run_limits)
+        except RuntimeError as e:
+            return error_result(e, "Could not run tests")
+        results = [TestResult.from_json(row) for row in rows]
+        if units and not results:
+            return f"❌ No loaded tests in unit(s): {', '.join(units)}"
+
+        if output_format == "json":
+            return json.dumps({
+                "summary": summary(results),
+                "results": [result.to_json() for result in results],
+            }, indent=2)
+        return format_results(results)
+
+    except asyncio.TimeoutError:
+        return "⏱️ The test run did not finish in time; the Prolog session was reset"
+    except Exception as e:
+        logger.error(f"Failed to run tests: {e}")
+        return error_result(e, "Failed to run tests")
+
+
+@mcp.tool()
+async def lint_program(filename: str, output_format: str = "json", instance: str = "") -> str:
+    """
+    Check a Prolog file for mistakes and return them as structured diagnostics.
+
+    The file is loaded in a separate swipl process with singleton,
+    discontiguous, no-effect and variable-branch style checks enabled, then
+    check/0 looks for undefined procedures and similar problems. The
+    persistent session is not affected.
+
+    Args:
+        filename: Program file in the data directory, e.g. "family.pl"
+        output_format: "json" (file, line, severity, message per diagnostic) or "text"
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The warnings and errors found, with their locations
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        path = program_file(context, filename)
+        name = path.relative_to(context.data_dir.resolve()).as_posix()
+        # Loading runs the file's directives
+        check_text(path.read_text(encoding="utf-8"), sandbox_policy())
+
+        code, stdout, stderr = await run_swipl_with_program(
+            context.docker_client,
+            context.container_name,
+            lint_program_text(),
+            lint_goal(f"{prolog_data_dir(context)}/{name}"),
+            timeout=server_config.limits.wall_seconds
+        )
+        try:
+            diagnostics = parse_lint_output(stdout, prolog_data_dir(context))
+        except RuntimeError as e:
+            detail = stderr.strip() or f"exit status {code}"
+            return f"❌ Could not lint {name}: {e}\n{detail}"
+
+        if output_format == "json":
+            errors = sum(1 for d in diagnostics if d.severity == "error")
+            return json.dumps({
+                "file": name,
+                "errors": errors,
+                "warnings": len(diagnostics) - errors,
+                "diagnostics": [d.to_json() for d in diagnostics],
+            }, indent=2)
+        return format_diagnostics(name, diagnostics)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except asyncio.TimeoutError:
+        return f"⏱️ Linting '{filename}' timed out after {server_config.limits.wall_seconds:g} seconds"
+    except Exception as e:
+        logger.error(f"Failed to lint program: {e}")
+        return error_result(e, "Failed to lint program")
+
+
+@mcp.tool()
+async def probabilistic_query(
+    query: str,
+    program: str = "",
+    filename: str = "",
+    output_format: str = "text",
+    timeout: float | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Compute the probability of a query against a probabilistic logic program.
+
+    The program is a Logic Program with Annotated Disjunctions, with
+    probabilistic facts such as `rain:0.3.`, and is evaluated by cplint's
+    PITA in a separate swipl process; the persistent session is not
+    affected. Needs SWISH_MCP_PROBABILISTIC=cplint, which installs cplint in
+    the container on startup.
+
+    Args:
+        query: Goal whose probability to compute, e.g. "heads(coin)"; every
+            ground instance of a goal with variables gets its own probability
+        program: LPAD program text, e.g. "heads(C):0.5 ; tails(C):0.5 :- toss(C). toss(coin)."
+        filename: Program file in the data directory to use instead of program
+        output_format: "text" or "json"
+        timeout: Wall-clock limit in seconds; defaults to the server's query limit
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        Each instance of the query with its probability
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        unavailable = pack_unavailable(context, CPLINT_PACK, "SWISH_MCP_PROBABILISTIC", "cplint")
+        if unavailable:
+            return unavailable
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if bool(program.strip()) == bool(filename.strip()):
+            return "❌ Give exactly one of program or filename"
+
+        query = clean_query_text(query)
+        if filename.strip():
+            program = program_file(context, filename).read_text(encoding="utf-8")
+        check_text(f"{program}\n{query}", sandbox_policy())
+        require_unrestricted(sandbox_policy(), "call", "probabilistic queries")
+
+        limits = server_config.limits.override(timeout, None, None)
+        code, stdout, stderr = await run_swipl_with_program(
+            context.docker_client,
+            context.container_name,
+            lpad_program(program),
+            prob_goal(query, limits),
+            timeout=limits.wall_seconds + 5
+        )
+        try:
+            rows = parse_prob_output(stdout)
+        except RuntimeError as e:
+            detail = stderr.strip() or f"exit status {code}"
+            return f"❌ Inference failed: {e}\n{detail}"
+
+        if output_format == "json":
+            return json.dumps({"query": query, "solutions": rows}, indent=2)
+        return format_probabilities(query, rows)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except asyncio.TimeoutError:
+        return f"⏱️ Probabilistic query timed out after {limits.wall_seconds:g} seconds"
+    except Exception as e:
+        logger.error(f"Failed to run probabilistic query: {e}")
+        return error_result(e, "Failed to run probabilistic query")
+
+
+@mcp.tool()
+async def scasp_query(
+    query: str,
+    program: str = "",
+    filename: str = "",
+    max_models: int = 3,
+    show_model: bool = True,
+    output_format: str = "text",
+    timeout: float | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Answer a query with s(CASP) and explain each answer.
+
+    The program is read as an answer set program: not/1 is negation as
+    failure under the stable model semantics, -p is classical negation,
+    and variables need not be ground, so answers may carry constraints
+    such as X \\= a. Every answer comes with its partial stable model and
+    the justification tree in English, the chain of rules that supports
+    it. Runs in a separate swipl process; the persistent session is not
+    affected. Needs SWISH_MCP_SCASP=on, which installs the scasp pack in
+    the container on startup.
+
+    Args:
+        query: Goal to answer, e.g. "flies(tweety)" or "not flies(X)"
+        program: s(CASP) program text, e.g. "flies(X) :- bird(X), not ab(X). bird(tweety)."
+        filename: Program file in the data directory to use instead of program
+        max_models: Answers to compute at most (up to 20)
+        show_model: Include the partial stable model of each answer in text output
+        output_format: "text" or "json"
+        timeout: Wall-clock limit in seconds; defaults to the server's query limit
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        Each answer with its bindings, constraints, model and justification
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        unavailable = pack_unavailable(context, SCASP_PACK, "SWISH_MCP_SCASP", "on")
+        if unavailable:
+            return unavailable
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if bool(program.strip()) == bool(filename.strip()):
+            return "❌ Give exactly one of program or filename"
+        if not 1 <= max_models <= MAX_MODELS:
+            return f"❌ max_models must be between 1 and {MAX_MODELS}"
+
+        query = clean_query_text(query)
+        if filename.strip():
+            program = program_file(context, filename).read_text(encoding="utf-8")
+        check_text(f"{program}\n{query}", sandbox_policy())
+        require_unrestricted(sandbox_policy(), "call", "s(CASP) queries")
+
+        limits = server_config.limits.override(timeout, None, None)
+        code, stdout, stderr = await run_swipl_with_program(
+            context.docker_client,
+            context.container_name,
+            scasp_program(program),
+            scasp_goal(query, max_models, limits),
+            timeout=limits.wall_seconds + 5
+        )
+        try:
+            answers = parse_scasp_output(stdout)
+        except RuntimeError as e:
+            detail = stderr.strip() or f"exit status {code}"
+            return f"❌ s(CASP) failed: {e}\n{detail}"
+
+        if output_format == "json":
+            return json.dumps({"query": query, "answers": [a.to_json() for a in answers]}, indent=2)
+        return format_answers(query, answers, show_model)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except asyncio.TimeoutError:
+        return f"⏱️ s(CASP) query timed out after {limits.wall_seconds:g} seconds"
+    except Exception as e:
+        logger.error(f"Failed to run s(CASP) query: {e}")
+        return error_result(e, "Failed to run s(CASP) query")
+
+
+@mcp.tool()
+async def geo_load(
+    data: str = "",
+    source: str = "",
+    layer: str = "default",
+    data_format: str = "auto",
+    id_property: str = "id",
+    replace: bool = False,
+    instance: str = ""
+) -> str:
+    """
+    Load geometries into a layer and build its spatial index.
+
+    GeoJSON may be a geometry, a Feature or a FeatureCollection; WKT is
+    one geometry per line, optionally after an id, e.g.
+    "depot-1 POINT (4.89 52.37)". Coordinates are longitude, latitude.
+    Needs SWISH_MCP_GEO=on, which installs the space pack in the
+    container on startup.
+
+    Args:
+        data: The GeoJSON or WKT itself
+        source: Instead of data, a file in the data directory or an http(s) URL
+        layer: Layer to load into, a lowercase identifier; its index is geo_<layer>
+        data_format: "auto" (GeoJSON if it starts with "{"), "geojson" or "wkt"
+        id_property: Feature property used as the id when a Feature has no id
+        replace: Drop the layer's features first instead of adding to them
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The layer's features, geometry types and bounding box
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        unavailable = pack_unavailable(context, SPACE_PACK, "SWISH_MCP_GEO", "on")
+        if unavailable:
+            return unavailable
+        validate_layer(layer)
+        if bool(data) == bool(source):
+            return "❌ Pass either data or source"
+
+        if source.startswith(("http://", "https://")):
+            text = await fetch_data(source)
+        elif source:
+            path = (context.data_dir / source).resolve()
+            if not path.is_relative_to(context.data_dir.resolve()):
+                return f"❌ '{source}' is outside the data directory"
+            if not path.is_file():
+                return f"❌ File '{source}' not found in the data directory"
+            text = await asyncio.to_thread(path.read_text, encoding="utf-8-sig")
+        else:
+            text = data
+        features = parse_features(text, data_format, id_property)
+
+        existing = context.geo_layers.layers.get(layer)
+        target = GeoLayer(layer, dict(existing.features) if existing and not replace else {})
+        target.add(features)
+        if existing and replace:
+            context.geo_layers.remove(layer)
+            await run_json_helper(context, existing.clear_call())
+        rows = []
+        try:
+            for call in target.load_calls(features):
+                rows.extend(await run_json_helper(context, call))
+        except RuntimeError as e:
+            return f"❌ Could not index layer {layer}: {e}"
+        errors = load_errors(rows)
+        for row in rows:
+            if row.get("error"):
+                target.features.pop(str(row["id"]), None)
+        context.geo_layers.layers[layer] = target
+
+        lines = [f"🗺️ Loaded {len(features) - len(errors)} feature(s) into {target.describe()}"]
+        if errors:
+            lines.append("❌ Refused by the space pack:")
+            lines.extend(f"  • {error}" for error in errors)
+        lines.append(f"💡 Try: geo_query(geometry=\"POINT (lon lat)\", layer=\"{layer}\")")
+        return "\n".join(lines)
+
+    except (ValueError, RemoteSourceError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to load geometries: {e}")
+        return error_result(e, "Failed to load geometries")
+
+
+@mcp.tool()
+async def geo_query(
+    geometry: str,
+    operation: str = "intersects",
+    layer: str = "default",
+    limit: int = 10,
+    output_format: str = "json",
+    instance: str = ""
+) -> str:
+    """
+    Find the features of a layer by their position relative to a geometry.
+
+    intersects finds the features overlapping the geometry, so a point
+    finds the areas containing it; contains finds the features lying
+    inside it; nearest finds the features closest to it, nearest first,
+    with distance_m for point features when querying with a point.
+
+    Args:
+        geometry: GeoJSON geometry or Feature, or WKT, e.g. "POINT (4.89 52.37)"
+        operation: "intersects", "contains" or "nearest"
+        layer: Layer loaded with geo_load
+        limit: Features to return at most (up to 1000)
+        output_format: "json" for a GeoJSON FeatureCollection, or "text"
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The matching features with the properties they were loaded with
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        unavailable = pack_unavailable(context, SPACE_PACK, "SWISH_MCP_GEO", "on")
+        if unavailable:
+            return unavailable
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        target = context.geo_layers.get(layer)
+        shape = query_geometry(geometry)
+        rows = await run_json_helper(context, target.query_call(operation, shape, limit))
+        features = matched_features(target, rows, operation, shape)
+
+        if output_format == "json":
+            return json.dumps(feature_collection(features), indent=2)
+        return format_matches(layer, operation, features)
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to run geo query: {e}")
+        return error_result(e, "Failed to run geo query")
+
+
+@mcp.tool()
+async def geo_layers(instance: str = "") -> str:
+    """
+    List the geo layers loaded with geo_load.
+
+    Args:
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        Each layer's index name, features, geometry types and bounding box
+    """
+    try:
+        context = get_context(instance)
+
+        layers = list(context.geo_layers.layers.values())
+        if not layers:
+            return "🗺️ No geo layers; load one with geo_load"
+        lines = [f"🗺️ Geo layers: {len(layers)}"]
+        lines.extend(f"  • {layer.describe()}" for layer in layers)
+        return "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to list geo layers: {e}")
+        return error_result(e, "Failed to list geo layers")
+
+
+@mcp.tool()
+async def geo_clear(layer: str, instance: str = "") -> str:
+    """
+    Drop a geo layer and its spatial index.
+
+    Args:
+        layer: Layer loaded with geo_load
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        How many features the layer had
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        target = context.geo_layers.remove(layer)
+        await run_json_helper(context, target.clear_call())
+        return f"🗑️ Dropped layer {layer} ({len(target.features)} feature(s))"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to clear geo layer: {e}")
+        return error_result(e, "Failed to clear geo layer")
+
+
+@mcp.tool()
+async def parse_with_grammar(
+    text: str,
+    start: str,
+    grammar: str = "",
+    filename: str = "",
+    input_type: str = "codes",
+    max_parses: int = 1,
+    output_format: str = "text",
+    timeout: float | None = None,
+    instance: str = ""
+) -> str:
+    """
+    Parse a string with a DCG and return the parse, or where parsing failed.
+
+    The grammar is loaded in a separate swipl process and phrase/2 must
+    consume the whole input. The parse result is the bindings of the
+    start nonterminal's variables, e.g. Tree in "expr(Tree)". If no parse
+    consumes the input, the result gives the position where parsing got
+    stuck: the furthest point any grammar rule completed at, and which
+    rule that was. The persistent session is not affected.
+
+    Args:
+        text: Text to parse
+        start: Start nonterminal, e.g. "sentence", "expr(Tree)" or "greeting//0"
+        grammar: DCG rules, e.g. "greeting --> [hello], name. name --> [world]."
+        filename: Grammar file in the data directory to use instead of grammar
+        input_type: What the grammar's terminals are: "codes" (the default,
+            as "abc" literals in DCG bodies), "chars", or "tokens", atoms
+            split at white space, for grammars such as np --> [the], noun
+        max_parses: Parses to return at most (up to 100); more than one
+            shows whether the input is ambiguous
+        output_format: "text" or "json"
+        timeout: Wall-clock limit in seconds; defaults to the server's query limit
+        instance: Cluster instance or workspace to use
+
+    Returns:
+        The bindings of each parse, or the position where parsing failed
+    """
+    limits = server_config.limits.override(timeout, None, None)
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+        if bool(grammar.strip()) == bool(filename.strip()):
+            return "❌ Give exactly one of grammar or filename"
+
+        goal = parse_goal(start, text, input_type, max_parses, limits)
+        if filename.strip():
+            grammar = program_file(context, filename).read_text(encoding="utf-8")
+        check_text(f"{grammar}\n{start_goal(start)}", sandbox_policy())
+        require_unrestricted(sandbox_policy(), "call", "grammar parses")
+
+        code, stdout, stderr = await run_swipl_with_program(
+            context.docker_client,
+            context.container_name,
+            grammar_program(grammar),
+            goal,
+            timeout=limits.wall_seconds + 5
+        )
+        try:
+            outcome = parse_grammar_output(stdout, text, input_type, max_parses)
+        except RuntimeError as e:
+            detail = stderr.strip() or f"exit status {code}"
+            return f"❌ Parsing failed: {e}\n{detail}"
+
+        if output_format == "json":
+            return json.dumps({
+                "start": start_goal(start),
+                "parsed": outcome.failure is None,
+                "parses": outcome.parses,
+                "more": outcome.more,
+                "failure": outcome.failure.to_json() if outcome.failure else None,
+            }, indent=2)
+        return format_parse(start, text, input_type, outcome)
+
+    except (ValueError, SandboxViolation) as e:
+        return error_result(e, fallback="invalid_argument")
+    except asyncio.TimeoutError:
+        return f"⏱️ Parsing timed out after {limits.wall_seconds:g} seconds"
+    except Exception as e:
+        logger.error(f"Failed to parse with grammar: {e}")
+        return error_result(e, "Failed to parse with grammar")
+
+
+@mcp.tool()
+async def kb_history(limit: int = 20, instance: str = "") -> str:
+    """
+    Show the audit log of knowledge base changes made through the tools.
+
+    Every query that asserted or retracted clauses and every file a tool
+    created, changed or deleted is logged with the client and tool behind
+    it. Entries marked ↩️ can still be reverted with undo_last().
+
+    Args:
+        limit: Number of recent entries to show (0 for all)
+        instance: Named cluster instance whose log to show
+
+    Returns:
+        Logged changes, newest first
+    """
+    try:
+        context = get_context(instance)
+        log = audit_log(context)
+        entries = log.history(limit)
+        if not entries:
+            return "📝 No knowledge base changes have been logged yet."
+        lines = [f"  {entry.describe()}" for entry in entries]
+        return f"📝 Knowledge base history, newest first ({log.path}):\n" + "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to read knowledge base history: {e}")
+        return error_result(e, "Failed to read knowledge base history")
+
+
+@mcp.tool()
+async def undo_last(steps: int = 1, to_entry: int = 0, instance: str = "") -> str:
+    """
+    Revert the most recent knowledge base changes listed by kb_history().
+
+    Database changes are undone by restoring the dynamic predicates as
+    they were before the change; file changes by restoring the previous
+    file contents (files a change created are deleted). Only the most
+    recent changes (SWISH_MCP_UNDO_DEPTH, default 50) can be undone.
+
+    Args:
+        steps: Number of changes to revert
+        to_entry: Revert every change after this kb_history() entry number instead
+        instance: Cluster instance or workspace to revert
+
+    Returns:
+        The changes that were reverted
+    """
+    try:
+        context = get_context(instance)
+        log = audit_log(context)
+        popped = log.pop_undo(max(1, steps), to_entry)
+        if not popped:
+            return "↩️ Nothing to undo: no recent changes are on the undo stack. See kb_history()."
+
+        # popped is newest first, so the state kept per module is the oldest one
+        database = {
+            state.module: state.database for _entry, state in popped if state.database is not None
+        }
+        # Logged with the clauses the undo changed, so as_of queries can replay it
+        delta: ClauseDelta | None = {}
+        if database:
+            if not context.container_ready:
+                log.push_undo(popped)
+                return NOT_READY
             try:
-                stat = file_path.stat()
-                size = stat.st_size
-                base_name = file_path.stem
+                for module, predicates in database.items():
+                    before = await database_snapshot(context, module)
+                    await run_json_helper(context, restore_call(predicates, module))
+                    after = await database_snapshot(context, module)
+                    if delta is not None and before is not None and after is not None:
+                        delta[module] = database_delta(before, after)
+                    else:
+                        delta = None
+            except RuntimeError as e:
+                log.push_undo(popped)
+                return error_result(e, "Could not restore the database")
+        try:
+            for _entry, state in popped:
+                if state.files is not None:
+                    log.restore_files(state.files)
+        except OSError as e:
+            log.push_undo(popped)
+            return error_result(e, "Could not restore files")
+
+        reverted = [entry for entry, _state in popped]
+        log.record(
+            current_client_id(), "undo_last", "undo",
+            "reverted " + ", ".join(f"#{entry.seq}" for entry in reverted),
+            [change for entry in reverted for change in entry.changes],
+            delta=delta,
+        )
+        if not instance:
+            await refresh_kb_resources()
+            if database:
+                await kb_resources.notify_all_updated()
 
-                file_info.append(f"  📄 {file_path.name} ({size} bytes)")
-                file_info.append(f"      💡 Load with: ?- consult({base_name}).")
-            except Exception:
-                file_info.append(f"  📄 {file_path.name}")
+        lines = [f"  ↩️ #{entry.seq} {entry.tool}: {entry.detail}" for entry in reverted]
+        return f"✅ Reverted {len(reverted)} change(s):\n" + "\n".join(lines)
 
-        return f"""📚 Prolog Knowledge Bases in {data_path}:
-{chr(10).join(file_info)}
+    except Exception as e:
+        logger.error(f"Failed to undo changes: {e}")
+        return error_result(e, "Failed to undo changes")
+
+
+@mcp.tool()
+async def pack_install(name: str, url: str = "", upgrade: bool = False, instance: str = "") -> str:
+    """
+    Install a SWI-Prolog pack inside the SWISH container.
+
+    Runs pack_install/2 non-interactively, then attaches packs in the
+    persistent session so the library can be loaded with use_module/1.
+
+    Args:
+        name: Pack name from the pack registry (e.g. "regex")
+        url: Optional https:// URL (git repository or archive) to install from
+        upgrade: Upgrade the pack if it is already installed
+        instance: Named cluster instance to install into
+
+    Returns:
+        Installation result
+    """
+    try:
+        context = get_context(instance)
+        if not context.container_ready:
+            return NOT_READY
+        if sandbox_policy().enabled:
+            return "❌ Pack management is disabled while the sandbox policy is active"
+
+        goal = install_goal(name, url, upgrade)
+        code, stdout, stderr = await run_swipl_goal(context.docker_client, context.container_name, goal, timeout=300)
+        if code != 0:
+            return f"❌ pack_install failed for '{name or url}':\n{(stderr or stdout).strip()}"
+
+        await refresh_session_packs(context)
+        return f"""✅ Installed pack '{name or url}'
+💡 Load it with: ?- use_module(library(<module>)).
+{stdout.strip()}"""
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except asyncio.TimeoutError:
+        return f"⏱️ pack_install for '{name or url}' timed out after 300 seconds"
+    except Exception as e:
+        logger.error(f"Failed to install pack: {e}")
+        return error_result(e, "Failed to install pack")
+
+
+@mcp.tool()
+async def pack_list(instance: str = "") -> str:
+    """
+    List the SWI-Prolog packs installed in the SWISH container.
+
+    Args:
+        instance: Cluster instance or workspace to inspect
+
+    Returns:
+        Installed packs with versions and titles
+    """
+    try:
+        context = get_context(instance)
+        if not context.container_ready:
+            return NOT_READY
+
+        code, stdout, stderr = await run_swipl_goal(context.docker_client, context.container_name, list_goal())
+        if code != 0:
+            return f"❌ Could not list packs:\n{stderr.strip()}"
+
+        packs = parse_pack_list(stdout)
+        if not packs:
+            return "📦 No packs installed. Add one with pack_install(\"name\")."
+        lines = [f"  📦 {p['name']} {p['version']}" + (f" - {p['title']}" if p['title'] else "") for p in packs]
+        return "📦 Installed packs:\n" + "\n".join(lines)
+
+    except Exception as e:
+        logger.error(f"Failed to list packs: {e}")
+        return error_result(e, "Failed to list packs")
+
+
+@mcp.tool()
+async def pack_remove(name: str, instance: str = "") -> str:
+    """
+    Remove an installed SWI-Prolog pack from the SWISH container.
+
+    Args:
+        name: Name of the installed pack
+        instance: Named cluster instance to remove it from
+
+    Returns:
+        Removal result
+    """
+    try:
+        context = get_context(instance)
+        if not context.container_ready:
+            return NOT_READY
+        if sandbox_policy().enabled:
+            return "❌ Pack management is disabled while the sandbox policy is active"
+
+        code, stdout, stderr = await run_swipl_goal(context.docker_client, context.container_name, remove_goal(name))
+        if code != 0:
+            return f"❌ pack_remove failed for '{name}':\n{(stderr or stdout).strip()}"
+
+        await refresh_session_packs(context)
+        return f"✅ Removed pack '{name}'"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to remove pack: {e}")
+        return error_result(e, "Failed to remove pack")
+
+
+@mcp.tool()
+async def rebuild_image(no_cache: bool = False, restart: bool = True, instance: str = "") -> str:
+    """
+    Pull or rebuild the SWISH image, then recreate the container on it.
+
+    With a Dockerfile configured the image is built again, pulling its base
+    image; otherwise the configured image is pulled, with its progress sent
+    as MCP progress notifications. The pull policy does not apply; offline
+    mode does, so only a present image or a build without pulls is used.
+    The container is replaced once its running queries are done.
+
+    Args:
+        no_cache: Run every Dockerfile step again instead of reusing cached layers
+        restart: Recreate the container so it runs the new image
+        instance: Named cluster instance whose image to rebuild
+
+    Returns:
+        What was pulled or built, the end of the build output and the restart result
+    """
+    try:
+        context = get_context(instance)
+        if context.backend == "local":
+            return "❌ The local backend runs the installed swipl; there is no image to rebuild"
+        if not context.docker_available:
+            return "❌ Docker is not available. Cannot pull or build images."
+
+        image = context_image(context)
+        action, log = await asyncio.to_thread(
+            ensure_image, context.docker_client, image, context.dockerfile, context.pull_policy,
+            force=True, no_cache=no_cache, offline=server_config.offline == "on", on_progress=pull_reporter(),
+            platform=context.platform
+        )
+        lines = [f"✅ {action.capitalize()} {image}" + (f" from {context.dockerfile}" if context.dockerfile else "")]
+        if log:
+            lines.append(f"📜 Build output (last {min(len(log), BUILD_LOG_TAIL)} lines):")
+            lines.extend(f"  {line}" for line in log[-BUILD_LOG_TAIL:])
+        if not restart:
+            lines.append("💡 The container keeps running the previous image until it is restarted")
+            return "\n".join(lines)
+
+        if await recreate_swish_container(context, notes=lines):
+            lines.append(f"🔄 {context.container_name} recreated on the new image")
+        else:
+            lines.append(f"⚠️ {context.container_name} did not come back up; check container_logs()")
+        return "\n".join(lines)
+
+    except ImageError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to rebuild image: {e}")
+        return error_result(e, "Failed to rebuild image")
+
+
+@mcp.tool()
+async def upgrade_swish(image: str = "", force: bool = False, instance: str = "") -> str:
+    """
+    Move the container to a new image without losing the session's state.
+
+    The image is pulled (or rebuilt from the Dockerfile) and started as a
+    standby container; the files the session consulted and every module's
+    dynamic facts are replayed into it, and once it passes a health check
+    it takes over the container's name and the old container is removed.
+    If anything fails before the switch, the old container keeps serving.
+    The web UI moves to the standby's port.
+
+    Args:
+        image: Image reference to move to; "" pulls or rebuilds the current one
+        force: Switch even when some files or facts could not be replayed
+        instance: Cluster instance or workspace with its own container to upgrade
+
+    Returns:
+        What was pulled, captured, replayed and switched
+    """
+    try:
+        context = get_context(instance)
+        if context.backend == "local":
+            return "❌ The local backend runs the installed swipl; there is no image to upgrade"
+        if context.shared_with:
+            return "❌ This workspace shares the primary container; upgrade that one instead (instance=\"\")"
+        if not context.container_ready:
+            return NOT_READY
+        if image:
+            validate_image(image)
+
+        return "\n".join(await upgrade_swish_container(context, image, force))
+
+    except (ImageError, ValueError) as e:
+        return error_result(e, fallback="invalid_argument")
+    except UpgradeError as e:
+        return error_result(e, "Upgrade cancelled")
+    except Exception as e:
+        logger.error(f"Failed to upgrade SWISH: {e}")
+        return error_result(e, "Failed to upgrade SWISH")
+
+
+def health_report(context: SwishContext) -> dict[str, Any]:
+    """Collect supervisor health for the primary container and instances."""
+    report = {}
+    for name, instance in {"primary": context, **context.instances}.items():
+        if instance.supervisor:
+            health = instance.supervisor.get_status()
+        else:
+            health = {"container": instance.container_name, "status": "unsupervised", "supervising": False}
+        health["ready"] = instance.container_ready
+        if instance.image_error:
+            health["image_error"] = instance.image_error
+        if instance.platform_warning:
+            health["platform_warning"] = instance.platform_warning
+        health["session_active"] = bool(instance.prolog_session and instance.prolog_session.session_active)
+        report[name] = health
+    return report
+
+
+@mcp.tool()
+async def swish_status(probe_now: bool = False) -> str:
+    """
+    Report container health as tracked by the supervisor.
+
+    The supervisor probes SWISH periodically (SWISH_MCP_HEALTH_INTERVAL
+    seconds) and restarts the container with exponential backoff when it
+    stops answering.
+
+    Args:
+        probe_now: Run a health probe immediately instead of reporting the last one
+
+    Returns:
+        JSON health report per container
+    """
+    try:
+        context = get_context()
+        if probe_now:
+            for instance in [context, *context.instances.values()]:
+                if instance.supervisor:
+                    await instance.supervisor.check_now()
+        return json.dumps(health_report(context), indent=2)
+    except Exception as e:
+        logger.error(f"Failed to get health status: {e}")
+        return error_result(e, "Failed to get health status")
+
+
+@mcp.tool()
+async def swish_logs(
+    lines: int = 100,
+    follow_seconds: float = 0,
+    grep: str = "",
+    stream: str = "both",
+    instance: str = ""
+) -> str:
+    """
+    Tail the SWISH container's stdout/stderr.
+
+    With follow_seconds set, new log lines are streamed as MCP progress
+    notifications for that long before the collected lines are returned.
+    Clients can also subscribe to the swish://container/logs resource to
+    be told when new output arrives.
+
+    Args:
+        lines: Number of existing lines to include (after filtering)
+        follow_seconds: Keep following the log for this many seconds (max 120)
+        grep: Only show lines matching this regular expression
+        stream: "both", "stdout" or "stderr"
+        instance: Named cluster instance whose logs to show
+
+    Returns:
+        Log lines with timestamps
+    """
+    try:
+        context = get_context(instance)
+        if not context.container:
+            return "❌ No SWISH container running"
+
+        pattern = compile_filter(grep)
+        options = stream_options(stream)
+        container = context.container
+        label = context.container_name
+        if stream != "both":
+            label += f" ({stream})"
+        if grep:
+            label += f" matching /{grep}/"
+        existing = [env_redactor.redact(line) for line in await asyncio.to_thread(read_logs, container, lines, stream, grep)]
+        if follow_seconds <= 0:
+            text = "\n".join(existing)
+            return f"📜 Logs for {label}:\n{text}" if text else f"📜 No log output for {label} yet"
+
+        loop = asyncio.get_running_loop()
+        queue: asyncio.Queue[str | None] = asyncio.Queue()
+        log_stream = await asyncio.to_thread(
+            container.logs, stream=True, follow=True, tail=0, timestamps=True, **options
+        )
+
+        def pump() -> None:
+            try:
+                for chunk in log_stream:
+                    for line in split_lines(chunk, pattern):
+                        loop.call_soon_threadsafe(queue.put_nowait, env_redactor.redact(line))
+            except Exception as e:
+                logger.debug(f"Log stream ended: {e}")
+            finally:
+                loop.call_soon_threadsafe(queue.put_nowait, None)
+
+        pump_task = asyncio.create_task(asyncio.to_thread(pump))
+        collected: list[str] = list(existing)
+        followed = 0
+        deadline = loop.time() + min(follow_seconds, 120)
+        try:
+            while (remaining := deadline - loop.time()) > 0:
+                try:
+                    line = await asyncio.wait_for(queue.get(), timeout=remaining)
+                except asyncio.TimeoutError:
+                    break
+                if line is None:
+                    break
+                collected.append(line)
+                followed += 1
+                await report_progress(followed, line)
+        finally:
+            log_stream.close()
+            await pump_task
+
+        text = "\n".join(collected[-MAX_FOLLOW_LINES:])
+        return f"📜 Logs for {label} (followed {follow_seconds:g}s, {followed} new lines):\n{text}"
+
+    except ValueError as e:
+        return error_result(e, fallback="invalid_argument")
+    except Exception as e:
+        logger.error(f"Failed to read container logs: {e}")
+        return error_result(e, "Failed to read container logs")
+
+
+@mcp.tool()
+async def container_logs(tail: int = 100, follow_seconds: float = 0, instance: str = "") -> str:
+    """
+    Show the SWISH container's logs (same as swish_logs without filters).
+
+    Args:
+        tail: Number of existing lines to include
+        follow_seconds: Keep following the log for this many seconds (max 120)
+        instance: Named cluster instance whose logs to show
+
+    Returns:
+        Log lines with timestamps
+    """
+    return await swish_logs(lines=tail, follow_seconds=follow_seconds, instance=instance)
+
+
+@mcp.tool()
+async def container_stats(output_format: str = "text", instance: str = "") -> str:
+    """
+    Report the SWISH container's live CPU, memory, process and I/O usage.
+
+    Usage comes from the runtime's stats API and is shown against the
+    limits the container was started with (SWISH_MCP_MEMORY,
+    SWISH_MCP_CPUS, SWISH_MCP_PIDS_LIMIT, SWISH_MCP_ULIMITS).
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to inspect
+
+    Returns:
+        Current usage, the configured limits and any OOM kills
+    """
+    try:
+        context = get_context(instance)
+        if context.backend == "local":
+            return "❌ The local backend runs no container; resource usage is not available"
+        if not context.container:
+            return "❌ No SWISH container running"
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        stats = await asyncio.to_thread(context.container.stats, stream=False)
+        usage = usage_from_any(stats)
+        if output_format == "json":
+            resources = context.resources
+            return json.dumps({
+                "container": context.container_name,
+                "usage": usage.to_json(),
+                "limits": {
+                    "memory": resources.memory or None,
+                    "cpus": resources.cpus or None,
+                    "pids_limit": resources.pids_limit or None,
+                    "ulimits": {limit.name: [limit.soft, limit.hard] for limit in resources.ulimits},
+                },
+                "oom_kills": context.oom_kills,
+            }, indent=2)
+        return format_usage(context.container_name, usage, context.resources, context.oom_kills)
+
+    except Exception as e:
+        logger.error(f"Failed to read container stats: {e}")
+        return error_result(e, "Failed to read container stats")
+
+
+@mcp.tool()
+async def network_status(output_format: str = "text", instance: str = "") -> str:
+    """
+    Report the SWISH container's network profile and what its proxy let through.
+
+    With SWISH_MCP_NETWORK=none, internal or allowlist (see
+    network_isolation.py) Prolog code cannot connect out freely; the
+    allowlist profile lets it reach the hosts in SWISH_MCP_NETWORK_ALLOW
+    through a proxy in this server, which counts and logs the connections
+    it refuses.
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to inspect
 
-🔄 After loading files, you can:
-   - Query facts: ?- parent(tom, bob).
-   - Test rules: ?- grandparent(tom, ann).
-   - List predicates: ?- current_predicate(F/A).
-"""
+    Returns:
+        The profile, the addresses in use and the proxy's connection counts
+    """
+    try:
+        context = get_context(instance)
+        if context.backend == "local":
+            return "❌ The local backend runs no container; it has the host's network"
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        profile = context.network
+        address = container_address(context.container) if context.container is not None else ""
+        proxy = network_proxy if profile.mode == "allowlist" else None
+        if output_format == "json":
+            stats = proxy.stats if proxy else None
+            return json.dumps({
+                "mode": profile.mode,
+                "allow": list(profile.allow),
+                "network": INTERNAL_NETWORK if profile.isolated else profile.mode,
+                "address": address,
+                "pengine_url": context.swish_base_url if profile.has_http else None,
+                "proxy": {
+                    "url": proxy.url,
+                    "allowed": stats.allowed,
+                    "denied": stats.denied,
+                    "recent_denials": [{"time": when, "target": target} for when, target in stats.recent_denials],
+                } if proxy else None,
+            }, indent=2)
+        return format_network_status(profile, address, context.swish_base_url, proxy)
 
     except Exception as e:
-        logger.error(f"Failed to list Prolog files: {e}")
-        return f"❌ Failed to list files: {e}"
+        logger.error(f"Failed to read network status: {e}")
+        return error_result(e, "Failed to read network status")
 
 
 @mcp.tool()
-async def get_swish_status() -> str:
+async def prolog_stats(output_format: str = "text", instance: str = "") -> str:
     """
-    Get the current status of the SWISH container and Prolog environment.
+    Report the persistent session's stack, table space and program statistics.
 
-    Useful for troubleshooting and checking if the Prolog environment is ready.
+    Shows what statistics/2 reports for the session: stack usage against
+    stack_limit, table space used, atoms, clauses, CPU time, inferences
+    and garbage collection, with the stack_limit, table_space and
+    shared_table_space flags (SWISH_MCP_STACK_LIMIT and friends).
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to inspect
 
     Returns:
-        Detailed status information about the SWISH container and service
+        The session's memory usage and limits
     """
     try:
-        context = get_context()
+        context = get_context(instance)
 
-        if not context.docker_available:
-            return """⚠️ Docker is not available
+        if not context.container_ready:
+            return NOT_READY
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
 
-The MCP server is running but cannot manage containers.
-Please ensure Docker Desktop is running and accessible."""
+        try:
+            rows = await run_json_helper(context, ("mcp_stats", []))
+        except RuntimeError as e:
+            return error_result(e, "Could not read Prolog statistics")
+        row = rows[0] if rows else {}
+        if output_format == "json":
+            return json.dumps({**row, "configured": server_config.prolog.describe()}, indent=2)
+        return f"{format_stats(row)}\n\n⚙️ Configured at startup: {server_config.prolog.describe()}"
 
-        if not context.container:
-            # Try to refresh container reference first
-            refresh_success = refresh_container_reference(context)
-            if not refresh_success:
-                return """❌ No SWISH container found
+    except Exception as e:
+        logger.error(f"Failed to read Prolog statistics: {e}")
+        return error_result(e, "Failed to read Prolog statistics")
 
-Container failed to start during initialization or was removed.
-Try restarting the MCP server or check Docker status."""
 
-        try:
-            # Refresh container status - handle stale references
-            try:
-                context.container.reload()
-            except Exception as reload_error:
-                logger.warning(f"Container reload failed, trying to refresh reference: {reload_error}")
-                if not refresh_container_reference(context):
-                    return f"❌ Container reference is stale and could not be refreshed: {reload_error}"
+@mcp.tool()
+async def get_flags(flags: list[str] | None = None, output_format: str = "text", instance: str = "") -> str:
+    """
+    Show the persistent session's Prolog flags.
 
-            status = context.container.status
+    double_quotes, back_quotes and unknown are read from the client's
+    module, where set_flag sets them; the others are the whole session's.
 
-            # Check SWISH accessibility
-            swish_accessible = False
-            try:
-                async with aiohttp.ClientSession() as session:
-                    async with session.get(
-                        f"{context.swish_base_url}/",
-                        timeout=aiohttp.ClientTimeout(total=3)
-                    ) as response:
-                        swish_accessible = response.status == 200
-            except Exception:
-                pass
+    Args:
+        flags: Flag names to show, any SWI-Prolog flag; default the ones
+            set_flag changes (double_quotes, back_quotes, unknown,
+            occurs_check, answer_write_options, print_write_options,
+            prefer_rationals)
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to inspect
 
-            # Get basic container info
-            created = context.container.attrs.get('Created', 'Unknown')
+    Returns:
+        Each flag's value, its scope and whether set_flag changed it
+    """
+    try:
+        context = get_context(instance)
 
-            # Get persistent session status
-            session_status = ""
-            if context.prolog_session:
-                session_info = context.prolog_session.get_status()
-                session_status = f"""
-🧠 Persistent Session: {'✅ Active' if session_info['active'] else '❌ Inactive'}
-📊 Queries Executed: {session_info['query_count']}
-📚 Consulted Files: {', '.join(session_info['consulted_files']) if session_info['consulted_files'] else 'None'}"""
-            else:
-                session_status = "\n🧠 Persistent Session: ⚠️ Not initialized"
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ Prolog flags require the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
 
-            return f"""📊 SWISH Prolog Environment Status
+        names = [name.strip() for name in flags or FLAGS if name.strip()]
+        module = client_module()
+        try:
+            call = get_flags_call(module, names)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not read the Prolog flags")
+        entries = flag_rows(rows, module, context.prolog_flags)
+        missing = [name for name in names if name not in {entry["flag"] for entry in entries}]
+        if output_format == "json":
+            return json.dumps({"module": module, "flags": entries, "missing": missing}, indent=2)
+        return format_flags(entries, missing)
 
-🐳 Container: {context.container.name} ({context.container.id[:12]})
-📊 Status: {status.upper()}
-🌐 URL: {context.swish_base_url}
-🚀 Service: {'✅ Ready for Prolog queries' if swish_accessible else '⚠️ Starting up...'}
-📅 Started: {created[:19] if 'T' in created else created}
-📁 Data: {context.data_dir}{session_status}
+    except Exception as e:
+        logger.error(f"Failed to read Prolog flags: {e}")
+        return error_result(e, "Failed to read Prolog flags")
 
-💡 {'Ready to execute Prolog queries with persistent state!' if swish_accessible and context.prolog_session and context.prolog_session.get_status()['active'] else 'Container starting or session initializing, please wait...'}
 
-🧠 Available operations:
-   - execute_prolog_query("member(X, [1,2,3]).")
-   - create_prolog_file("my_kb", "fact(a). rule(X) :- fact(X).")
-   - list_prolog_files()
-"""
+@mcp.tool()
+async def set_flag(flag: str, value: str, instance: str = "") -> str:
+    """
+    Set a Prolog flag of the persistent session, kept across restarts.
 
-        except Exception as e:
-            return f"❌ Error checking container status: {e}"
+    Tunes how queries read and run without a set_prolog_flag/2 in each of
+    them. double_quotes, back_quotes and unknown are set in the client's
+    module only, and its queries are read with them; the others apply to
+    the whole session.
+
+    Args:
+        flag: double_quotes (codes, chars, atom or string), back_quotes
+            (codes, chars, string or symbol_char), unknown (error,
+            warning or fail), occurs_check (false, true or error),
+            answer_write_options or print_write_options (a list of write
+            options, e.g. "[quoted(true), max_depth(20)]") or
+            prefer_rationals (true or false)
+        value: The new value, or "default" to put the flag back
+        instance: Cluster instance or workspace to set it in
+
+    Returns:
+        The flag's value after setting it, or why it was refused
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ Prolog flags require the persistent Prolog session. Try restart_prolog_session()."
+
+        flag = flag.strip()
+        module = client_module()
+        try:
+            text = flag_value(flag, value)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            rows = await run_json_helper(context, set_flag_call(module, {flag: text}))
+        except RuntimeError as e:
+            return error_result(e, f"Could not set {flag}")
+        errors = set_flag_errors(rows)
+        if errors or not rows:
+            return f"❌ SWI-Prolog refused {flag} = {text}: {'; '.join(errors) or 'no reply'}"
+        context.prolog_flags.record(module, flag, rows[0]["value"])
+        query_cache.clear(cache_scope(context))
+        scope = f"in module {module}" if FLAGS[flag].module else "for the whole session"
+        return f"✅ {flag} = {rows[0]['value']} {scope}; set again when the session restarts"
 
     except Exception as e:
-        logger.error(f"Failed to get status: {e}")
-        return f"❌ Failed to get status: {e}"
+        logger.error(f"Failed to set Prolog flag {flag}: {e}")
+        return error_result(e, "Failed to set the Prolog flag")
 
 
 @mcp.tool()
-async def load_knowledge_base(filename: str) -> str:
+async def table_declare(predicates: list[str], mode: str = "variant", instance: str = "") -> str:
     """
-    Load (consult) a Prolog knowledge base file into the SWISH session.
+    Table predicates at runtime, so recursive ones terminate under SLG resolution.
 
-    This makes the facts and rules in the file available for queries.
+    Wraps each predicate of the client's module with table/1, keeping the
+    clauses already loaded; no source file needs the :- table directive.
+    Left-recursive rules such as path(X, Y) :- path(X, Z), edge(Z, Y)
+    then terminate with every answer.
 
     Args:
-        filename: Name of the .pl file to load (with or without extension)
+        predicates: Name/Arity indicators, e.g. ["path/2"], or mode-directed
+            heads such as "path(_, _, min)" for answer subsumption
+        mode: "variant" (default), "subsumptive", "incremental" (tables are
+            updated when dynamic incremental predicates change) or "shared"
+            (tables shared between threads)
+        instance: Cluster instance or workspace to table them in
 
     Returns:
-        Status of the loading operation
+        The predicates tabled, and any SWI-Prolog refused
     """
     try:
-        context = get_context()
+        context = get_context(instance)
 
         if not context.container_ready:
-            return "❌ SWISH container is not ready. Please wait a moment and try again."
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ Tabling requires the persistent Prolog session. Try restart_prolog_session()."
 
-        # Ensure filename has .pl extension for file checking
-        if not filename.endswith('.pl'):
-            check_filename = f"{filename}.pl"
-            consult_name = filename
-        else:
-            check_filename = filename
-            consult_name = filename[:-3]  # Remove .pl for consulting
+        try:
+            call = table_call(client_module(), predicates, mode)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not table the predicates")
+        query_cache.clear(cache_scope(context))
+        tabled = [row["spec"] for row in rows if not row.get("error")]
+        lines = [f"📑 Tabled ({mode}): {', '.join(tabled)}"] if tabled else []
+        errors = spec_errors(rows)
+        if errors:
+            lines.append("❌ Not tabled:")
+            lines.extend(f"  • {error}" for error in errors)
+        return "\n".join(lines)
 
-        # Check if file exists
-        file_path = context.data_dir / check_filename
-        if not file_path.exists():
-            return f"❌ File '{check_filename}' not found. Use list_prolog_files() to see available files."
+    except Exception as e:
+        logger.error(f"Failed to table predicates: {e}")
+        return error_result(e, "Failed to table predicates")
 
-        # Load the knowledge base using consult
-        consult_query = f"consult({consult_name})."
-        result = await execute_prolog_query(consult_query)
 
-        if "✅" in result:
-            # Track the consulted file in the persistent session
-            # Note: Simplified session doesn't track consulted files
-            # if context.prolog_session:
-            #     context.prolog_session.track_consult(consult_name)
+@mcp.tool()
+async def table_remove(predicates: list[str], instance: str = "") -> str:
+    """
+    Stop tabling predicates, undoing table_declare (untable/1).
 
-            return f"""✅ Knowledge base '{check_filename}' loaded successfully!
+    Their tables are abolished and the plain clauses run again.
 
-📚 The facts and rules from {check_filename} are now available.
-💡 You can now query them directly, for example:
-   - List all facts: ?- current_predicate(F/A).
-   - Query specific facts from your knowledge base
+    Args:
+        predicates: Table specifications as given to table_declare, e.g. ["path/2"]
+        instance: Cluster instance or workspace
 
-🔍 File loaded from: {file_path}
-"""
-        else:
-            return f"⚠️ There may have been an issue loading the file:\n{result}"
+    Returns:
+        The predicates no longer tabled, and any that could not be untabled
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ Tabling requires the persistent Prolog session. Try restart_prolog_session()."
+
+        try:
+            call = untable_call(client_module(), predicates)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            rows = await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not untable the predicates")
+        query_cache.clear(cache_scope(context))
+        untabled = [row["spec"] for row in rows if not row.get("error")]
+        lines = [f"📑 No longer tabled: {', '.join(untabled)}"] if untabled else []
+        errors = spec_errors(rows)
+        if errors:
+            lines.append("❌ Not untabled:")
+            lines.extend(f"  • {error}" for error in errors)
+        return "\n".join(lines)
 
     except Exception as e:
-        logger.error(f"Failed to load knowledge base: {e}")
-        return f"❌ Failed to load knowledge base: {e}"
+        logger.error(f"Failed to untable predicates: {e}")
+        return error_result(e, "Failed to untable predicates")
 
 
 @mcp.tool()
-async def restart_prolog_session() -> str:
+async def table_abolish(predicate: str = "", instance: str = "") -> str:
     """
-    Restart the persistent Prolog session.
+    Throw away answer tables, so tabled predicates are computed afresh.
 
-    This can be useful if the session becomes unresponsive or if you want
-    to start fresh while preserving consulted knowledge bases.
+    Needed after changing the facts a non-incremental table was computed
+    from; otherwise it keeps answering from the old answers.
+
+    Args:
+        predicate: Name/Arity of the predicate whose tables to abolish, e.g.
+            "path/2"; empty abolishes every table (abolish_all_tables/0)
+        instance: Cluster instance or workspace
 
     Returns:
-        Status of the restart operation
+        What was abolished
     """
     try:
-        context = get_context()
+        context = get_context(instance)
 
         if not context.container_ready:
-            return "❌ SWISH container is not ready. Cannot restart Prolog session."
-
+            return NOT_READY
         if not context.prolog_session:
-            logger.info("No existing session, creating new persistent session")
-            context.prolog_session = SimplePrologSession(context.container_name)
-            success = await context.prolog_session.start_session()
+            return "❌ Tabling requires the persistent Prolog session. Try restart_prolog_session()."
 
-            if success:
-                return "✅ New persistent Prolog session started successfully!"
-            else:
-                return "❌ Failed to start new persistent Prolog session"
+        try:
+            call = abolish_call(client_module(), predicate)
+        except ValueError as e:
+            return error_result(e, fallback="invalid_argument")
+        try:
+            await run_json_helper(context, call)
+        except RuntimeError as e:
+            return error_result(e, "Could not abolish the tables")
+        return f"🗑️ Abolished the tables of {predicate}" if predicate.strip() else "🗑️ Abolished all tables"
 
-        # Restart existing session
-        logger.info("Restarting persistent Prolog session")
-        success = await context.prolog_session.restart_session()
+    except Exception as e:
+        logger.error(f"Failed to abolish tables: {e}")
+        return error_result(e, "Failed to abolish tables")
 
-        if success:
-            session_info = context.prolog_session.get_status()
-            consulted_files = session_info.get('consulted_files', [])
 
-            restart_msg = "✅ Persistent Prolog session restarted successfully!"
-            if consulted_files:
-                restart_msg += f"\n📚 Re-consulted files: {', '.join(consulted_files)}"
+@mcp.tool()
+async def table_statistics(output_format: str = "text", instance: str = "") -> str:
+    """
+    Report the tabled predicates of the client's module and what their tables hold.
 
-            return restart_msg
-        else:
-            return "❌ Failed to restart persistent Prolog session"
+    For each tabled predicate: its tabling modes, how many variant tables
+    (one per distinct call) it has, their answers and memory; then the
+    table space used against the table_space flag.
+
+    Args:
+        output_format: "text" or "json"
+        instance: Cluster instance or workspace to inspect
+
+    Returns:
+        The tabled predicates with their table statistics
+    """
+    try:
+        context = get_context(instance)
+
+        if not context.container_ready:
+            return NOT_READY
+        if not context.prolog_session:
+            return "❌ Tabling requires the persistent Prolog session. Try restart_prolog_session()."
+        if output_format not in ("text", "json"):
+            return f"❌ Unknown output_format '{output_format}'. Use 'text' or 'json'."
+
+        module = client_module()
+        try:
+            rows = await run_json_helper(context, stats_call(module))
+        except RuntimeError as e:
+            return error_result(e, "Could not read table statistics")
+        if output_format == "json":
+            return json.dumps({"module": module, "predicates": [row for row in rows if "predicate" in row],
+                               **next((row for row in rows if "table_space_used" in row), {})}, indent=2)
+        return format_table_stats(module, rows)
 
     except Exception as e:
-        logger.error(f"Failed to restart Prolog session: {e}")
-        return f"❌ Failed to restart session: {e}"
+        logger.error(f"Failed to read table statistics: {e}")
+        return error_result(e, "Failed to read table statistics")
 
 
 # AI assistance prompts for Prolog programming
@@ -1002,6 +9945,49 @@ Focus on clarity, correctness, and educational value.
 """
 
 
+async def prompt_kb_context(goal: str = "") -> KbContext:
+    """Knowledge base summary for a prompt, with the listing of goal's predicates."""
+    try:
+        context = get_context()
+    except RuntimeError:
+        return KbContext(available=False)
+    if context.prolog_session is None or not context.container_ready:
+        return KbContext(available=False, files=sorted(kb_resources.files))
+    module = client_module()
+    kb = KbContext(module=module, files=sorted(kb_resources.files))
+    try:
+        rows = await run_json_helper(context, graph_call("calls", "", 0, module))
+        kb.predicates = [row for row in rows if "node" in row]
+        names = [name for name in goal_names(goal) if name in kb.names()]
+        if names:
+            events = context.prolog_session.stream_query(listing_goal(names, module), server_config.limits, "json")
+            async for event in events:
+                if event["type"] == "solution":
+                    kb.listing = event["bindings"]["Listing"].get("value", "")
+    except Exception as e:
+        logger.debug(f"Prompt context incomplete: {e}")
+    return kb
+
+
+@mcp.prompt()
+async def model_as_constraints(problem: str) -> str:
+    """Turn a problem description into a CLP(FD) constraint model, using the current knowledge base."""
+    return constraint_model_prompt(problem, await prompt_kb_context())
+
+
+@mcp.prompt()
+async def convert_facts_to_prolog(information: str, predicate: str = "") -> str:
+    """Convert prose, lists or tables into Prolog facts that fit the predicates already loaded."""
+    return facts_conversion_prompt(information, predicate, await prompt_kb_context())
+
+
+@mcp.prompt()
+async def debug_failing_goal(goal: str) -> str:
+    """Find out why a goal fails, with the definitions it calls and any undefined predicates."""
+    kb = await prompt_kb_context(goal)
+    return debug_goal_prompt(goal, kb, undefined_names(goal, kb))
+
+
 def _get_level_guidance(level: str) -> str:
     """Get level-specific guidance for Prolog programming."""
     guidance = {
@@ -1052,6 +10038,61 @@ This container is automatically managed by the MCP server.
         return f"Error getting container info: {e}"
 
 
+@mcp.resource("swish://container/health")
+async def get_container_health() -> str:
+    """Get supervisor health state for every SWISH container as JSON."""
+    try:
+        return json.dumps(health_report(get_context()), indent=2)
+    except Exception as e:
+        return f"Error getting container health: {e}"
+
+
+@mcp.resource(LOGS_URI)
+async def get_container_logs() -> str:
+    """Get the most recent SWISH container log lines; subscribe to be told about new output."""
+    key = current_api_key()
+    if key is not None and not key.allows("admin"):
+        raise ValueError(f"API key '{key.key_id}' lacks the admin scope needed for container logs")
+    try:
+        context = get_context()
+        if not context.container:
+            return "No SWISH container currently running"
+        lines = await asyncio.to_thread(read_logs, context.container, RESOURCE_LINES)
+        return env_redactor.redact("\n".join(lines)) if lines else "No log output yet"
+    except Exception as e:
+        return f"Error reading container logs: {e}"
+
+
+@mcp.resource(RESULTS_URI_TEMPLATE)
+async def get_spilled_result(name: str) -> str:
+    """Every solution of a query whose results were too large to return, one per line."""
+    try:
+        return await asyncio.to_thread(read_result, get_context().data_dir, name)
+    except SpillError as e:
+        raise ValueError(str(e)) from e
+
+
+@mcp.resource(FEEDS_URI_TEMPLATE)
+async def get_fact_feed(feed_id: str) -> str:
+    """A fact feed's kept changes as JSON; subscribe to be told about new ones."""
+    root = get_context()
+    for context in (root, *root.instances.values(), *root.workspaces.values()):
+        feed = context.fact_feeds.feeds.get(feed_id)
+        if feed is not None:
+            return feed_document(feed, list(feed.events))
+    raise ValueError(f"Unknown fact feed '{feed_id}'; create one with fact_feed_subscribe")
+
+
+@mcp.resource(COLLAB_URI_TEMPLATE)
+async def get_collab_file(file: str) -> str:
+    """A file joined with collab_join, as of the last version saved in SWISH."""
+    context = get_context()
+    shared = context.collab.files.get(file) if context.collab else None
+    if shared is None:
+        raise ValueError(f"{file} is not joined; use collab_join first")
+    return shared.text
+
+
 @mcp.resource("swish://files/list")
 async def get_files_list() -> str:
     """Get list of available Prolog files as a resource."""
@@ -1085,17 +10126,382 @@ Use load_knowledge_base() to load any of these files.
         return f"Error listing files: {e}"
 
 
+def parse_listen_address(listen: str) -> tuple[str, int]:
+    """Parse a "host:port" or ":port" listen address."""
+    host, _, port = listen.rpartition(":")
+    if not port.isdigit():
+        raise argparse.ArgumentTypeError(f"Invalid listen address '{listen}', expected host:port")
+    return host or "0.0.0.0", int(port)
+
+
+def parse_args(argv: list[str] | None = None) -> argparse.Namespace:
+    """Parse command line options."""
+    parser = argparse.ArgumentParser(
+        prog="docker-swish-mcp",
+        description="MCP server providing Prolog through an auto-managed SWISH container"
+    )
+    parser.add_argument(
+        "--transport",
+        choices=["stdio", "http", "sse"],
+        default=os.environ.get("SWISH_MCP_TRANSPORT", "stdio"),
+        help="MCP transport: stdio (default), http (Streamable HTTP) or sse (legacy SSE)"
+    )
+    parser.add_argument(
+        "--listen",
+        type=parse_listen_address,
+        default=os.environ.get("SWISH_MCP_LISTEN", "127.0.0.1:8080"),
+        help="Address for the http/sse transports, e.g. :8080 or 127.0.0.1:8080"
+    )
+    parser.add_argument(
+        "--backend",
+        choices=list(BACKENDS),
+        default=server_config.backend,
+        help=(
+            "Where Prolog runs: the SWISH container (default), local, a swipl on PATH without Docker, "
+            "wasm, a WebAssembly swipl (SWISH_MCP_WASM_MODULE) falling back to the container, "
+            "or mock, an in-memory Prolog for demos and tests"
+        )
+    )
+    parser.add_argument(
+        "--sync-dir",
+        type=Path,
+        default=server_config.sync_dir,
+        help="Host directory to keep in sync with the data directory, both ways (default: SWISH_MCP_SYNC_DIR)"
+    )
+    parser.add_argument(
+        "--metrics-listen",
+        type=lambda value: parse_listen_address(value) if value else None,
+        default=os.environ.get("SWISH_MCP_METRICS_LISTEN", ""),
+        help="Address for the Prometheus /metrics endpoint, e.g. 127.0.0.1:9464 (default: disabled)"
+    )
+    parser.add_argument(
+        "--log-level",
+        choices=list(LOG_LEVELS),
+        default=server_config.log_level,
+        help="Lowest level of the log written to stderr (default: SWISH_MCP_LOG_LEVEL, or info)"
+    )
+    parser.add_argument(
+        "--log-format",
+        choices=list(LOG_FORMATS),
+        default=server_config.log_format,
+        help="text lines, or json objects one per line, each with its tool call's correlation_id (default: SWISH_MCP_LOG_FORMAT)"
+    )
+    parser.add_argument(
+        "--offline",
+        action="store_true",
+        default=server_config.offline == "on",
+        help="Never contact an image registry; fail at startup if the image is missing (default: SWISH_MCP_OFFLINE)"
+    )
+    parser.add_argument(
+        "--prepull",
+        action="store_true",
+        help="Make sure the SWISH image is present, pulling or building it with progress, then exit"
+    )
+    parser.add_argument(
+        "--test-harness",
+        type=Path,
+        nargs="+",
+        metavar="SCENARIO",
+        help="Run YAML/JSON test scenarios against an ephemeral SWISH container instead of serving, then exit"
+    )
+    parser.add_argument(
+        "--harness-report",
+        type=Path,
+        help="With --test-harness, also write the results as JSON to this file"
+    )
+    parser.add_argument(
+        "--replay",
+        type=Path,
+        metavar="RECORDING",
+        help="Replay a session recording (see recording_start) against an ephemeral SWISH container, then exit"
+    )
+    return parser.parse_args(argv)
+
+
+async def dashboard_state() -> dict[str, Any]:
+    """What the dashboard shows: health, sessions, queries, files and log lines of the primary container."""
+    context = get_context()
+    sessions = {}
+    for name, instance in {"primary": context, **context.instances}.items():
+        session = instance.prolog_session.get_status() if instance.prolog_session else {"active": False}
+        pool = instance.workers.get_status()
+        sessions[name] = {**session, "workers_running": pool["running"], "workers_queued": pool["queued"]}
+    files = []
+    if context.data_dir.exists():
+        for path in sorted(context.data_dir.glob("*.pl")):
+            stat = path.stat()
+            files.append({"name": path.name, "bytes": stat.st_size, "modified": stat.st_mtime})
+    logs: list[str] = []
+    if context.container:
+        try:
+            logs = await asyncio.to_thread(read_logs, context.container, DASHBOARD_LOG_LINES)
+        except Exception as e:
+            logs = [f"Could not read the container log: {e}"]
+    return {
+        "health": health_report(context),
+        "sessions": sessions,
+        "running": [
+            {
+                "query_id": q.query_id, "goal": q.goal, "where": q.where, "instance": q.instance,
+                "client": q.client_id, "seconds": round(time.time() - q.started, 1),
+            }
+            for q in running_queries.visible("", everyone=True)
+        ],
+        "recent": [asdict(q) for q in reversed(running_queries.recent)],
+        "files": files,
+        "logs": logs,
+    }
+
+
+# Dashboard buttons, run as tool calls so they are counted and traced like any other
+DASHBOARD_ACTIONS = {
+    "restart": lambda: mcp._tool_manager.call_tool("restart_prolog_session", {}),
+    "snapshot": lambda: mcp._tool_manager.call_tool("kb_snapshot", {"label": "dashboard"}),
+}
+
+
+def serve_http(transport: str, listen: tuple[str, int], tls_options: dict[str, Any]) -> None:
+    """Serve the http/sse app, behind bearer-key authentication when keys are configured."""
+    http = server_config.http
+    app = mcp.streamable_http_app() if transport == "streamable-http" else mcp.sse_app()
+    if server_config.api_keys.enabled:
+        app = BearerAuthMiddleware(app, server_config.api_keys)
+    # Preflights carry no credentials, so CORS is answered before authentication
+    if http.cors_origins:
+        app = CorsMiddleware(app, http.cors_origins)
+    # Outside CORS and bearer authentication: the page is same-origin and checks keys itself
+    if http.dashboard:
+        app = DashboardMiddleware(app, server_config.api_keys, dashboard_state, DASHBOARD_ACTIONS)
+    app = TransportMetricsMiddleware(app, metrics, transport)
+    if http.trusted_proxies:
+        app = ForwardedHeadersMiddleware(app, http.trusted_proxies)
+    config = uvicorn.Config(
+        app,
+        host=listen[0],
+        port=listen[1],
+        log_level=mcp.settings.log_level.lower(),
+        # Forwarded headers are only taken from SWISH_MCP_TRUSTED_PROXIES, above
+        proxy_headers=False,
+        **tls_options,
+    )
+    asyncio.run(uvicorn.Server(config).serve())
+
+
+async def run_test_harness(scenario: Scenario, image: str = "", keep_data: bool = False) -> ScenarioReport:
+    """
+    Run a scenario's tool calls against an ephemeral SWISH container, then remove it.
+
+    The container gets its own name, a free port and a temporary data
+    directory holding the scenario's files, so it never touches the
+    container of a server running alongside. For CI and packages
+    embedding this server; --test-harness runs it from the command line.
+
+    Args:
+        scenario: Steps to run, e.g. from harness.load_scenario()
+        image: Image to run instead of the configured one
+        keep_data: Leave the temporary data directory behind, to look at
+    """
+    name = ephemeral_name()
+    data_dir = Path(tempfile.mkdtemp(prefix=f"{name}-"))
+    saved = (server_config.container, server_config.shutdown_policy, server_config.orphan_policy,
+             server_config.sync_dir, server_config.config_path)
+    server_config.container = replace(
+        server_config.container,
+        name=name,
+        port=free_host_port(),
+        data_dir=data_dir,
+        image=image or server_config.container.image,
+    )
+    # Whatever happens, the container goes; orphans of other servers stay
+    server_config.shutdown_policy, server_config.orphan_policy = "remove", "keep"
+    server_config.sync_dir = server_config.config_path = None
+    try:
+        await asyncio.to_thread(write_files, data_dir, scenario.files)
+        logger.info(f"🧪 Running scenario '{scenario.name}' on {server_config.container.name}")
+        async with swish_environment(mcp) as context:
+            if not context.container_ready:
+                return ScenarioReport(scenario.name, error="The ephemeral SWISH container did not become ready")
+            return await run_scenario(
+                scenario, lambda tool, args: mcp._tool_manager.call_tool(tool, args)
+            )
+    finally:
+        (server_config.container, server_config.shutdown_policy, server_config.orphan_policy,
+         server_config.sync_dir, server_config.config_path) = saved
+        if keep_data:
+            logger.info(f"📁 Kept the scenario's data directory {data_dir}")
+        else:
+            shutil.rmtree(data_dir, ignore_errors=True)
+
+
+def run_harness_cli(paths: list[Path], report_path: Path | None = None) -> bool:
+    """Run --test-harness scenarios one after another, printing their reports; True if all passed."""
+    reports = []
+    for path in paths:
+        try:
+            scenario = load_scenario(path)
+        except ScenarioError as e:
+            reports.append(ScenarioReport(path.stem, error=str(e)))
+        else:
+            reports.append(asyncio.run(run_test_harness(scenario)))
+        print(format_report(reports[-1]), flush=True)
+    passed = sum(report.passed for report in reports)
+    print(f"\n{'✅' if passed == len(reports) else '❌'} {passed}/{len(reports)} scenario(s) passed", flush=True)
+    if report_path:
+        report_path.write_text(json.dumps({"scenarios": [r.to_json() for r in reports]}, indent=2), encoding="utf-8")
+    return passed == len(reports)
+
+
+def run_replay_cli(path: Path) -> bool:
+    """--replay: run a recording's calls in an ephemeral container, printing how each compares; True if all match."""
+    try:
+        recording = read_recording(path)
+    except RecordingError as e:
+        print(f"❌ {e}", flush=True)
+        return False
+    print(f"🎬 Replaying {recording.name} ({recording.summary()})", flush=True)
+    report = asyncio.run(run_test_harness(recording.scenario()))
+    if report.error:
+        print(f"❌ {report.error}", flush=True)
+        return False
+    paths = {
+        recording.environment.get("data_dir", ""): "<data>",
+        recording.environment.get("prolog_data_dir", ""): "<data>",
+    }
+    replay = Replay(recording, "")
+    for index, (step, result) in enumerate(zip(recording.steps, report.steps), start=1):
+        outcome = StepOutcome(
+            index, step.tool, result_difference(step, result.output, result_failed(result.output), paths),
+            result=result.output
+        )
+        replay.outcomes.append(outcome)
+        print("\n".join(outcome.describe(step)), flush=True)
+    print(replay.summary(), flush=True)
+    return all(outcome.same for outcome in replay.outcomes)
+
+
+def configured_image() -> tuple[ContainerRuntime, str]:
+    runtime = get_runtime(server_config.runtime, server_config.podman_socket)
+    container = server_config.container
+    return runtime, image_reference(container.image, container.dockerfile, runtime.image)
+
+
+def prepull_cli() -> bool:
+    """--prepull: pull or build the image unless it is present, printing progress; True if it is there now."""
+    runtime, image = configured_image()
+    container = server_config.container
+    try:
+        client = runtime.connect()
+        action, _ = ensure_image(
+            client, image, container.dockerfile, "missing", offline=server_config.offline == "on",
+            on_progress=lambda progress: print(progress.describe(), flush=True), platform=container.platform
+        )
+        if warning := emulation_warning(client, image, native_platform(client)):
+            print(f"🐢 {warning}", flush=True)
+    except ImageError as e:
+        print(f"❌ {e}", flush=True)
+        return False
+    except Exception as e:
+        print(f"❌ Could not reach the {runtime.name} daemon: {e}", flush=True)
+        return False
+    print(f"✅ {image}: {action}", flush=True)
+    return True
+
+
+def offline_image_error() -> str:
+    """Why offline mode cannot start the container, checked before serving; "" if it can (or cannot tell)."""
+    if server_config.backend != "container" or server_config.container.dockerfile:
+        # A Dockerfile is built at startup; its base image is checked then
+        return ""
+    runtime, image = configured_image()
+    try:
+        client = runtime.connect()
+    except Exception as e:
+        logger.debug(f"Cannot check for image {image}: {e}")
+        return ""
+    return "" if image_present(client, image) else offline_message(image)
+
+
+# Declare argument and result schemas of every tool registered above
+tool_input_schemas.update(describe_tools(mcp))
+
+
 # Main entry point
 def main() -> None:
     """Main entry point for the MCP server."""
+    global keep_environment_alive
     try:
+        args = parse_args()
+        listen: tuple[str, int] = args.listen
+        server_config.log_level, server_config.log_format = args.log_level, args.log_format
+        configure_logging(server_config.log_level, server_config.log_format)
+
         logger.info("=" * 60)
         logger.info(f"Docker SWISH MCP Server v{__version__}")
         logger.info("Prolog Integration Server")
         logger.info("=" * 60)
 
+        if args.metrics_listen:
+            start_metrics_server(metrics, *args.metrics_listen)
+
+        server_config.backend = args.backend
+        server_config.sync_dir = args.sync_dir.expanduser() if args.sync_dir else None
+        server_config.offline = "on" if args.offline else "off"
+
+        if args.prepull:
+            sys.exit(0 if prepull_cli() else 1)
+        if server_config.offline == "on":
+            logger.info("✈️ Offline mode: images are never pulled from a registry")
+            error = offline_image_error()
+            if error:
+                logger.error(f"❌ {error}")
+                sys.exit(1)
+
+        # Several remote clients share the session; by default each gets its own module
+        isolation = server_config.isolation
+        client_modules.enabled = isolation == "on" or (isolation == "auto" and args.transport != "stdio")
+        if client_modules.enabled:
+            logger.info("🧱 Running each client's goals in its own Prolog module")
+
+        if args.test_harness:
+            sys.exit(0 if run_harness_cli(args.test_harness, args.harness_report) else 1)
+        if args.replay:
+            sys.exit(0 if run_replay_cli(args.replay) else 1)
+
         # Run the MCP server
-        mcp.run()
+        if args.transport == "stdio":
+            mcp.run()
+        else:
+            # Remote clients come and go; keep the container up between them
+            keep_environment_alive = True
+            mcp.settings.host, mcp.settings.port = listen
+            transport = "streamable-http" if args.transport == "http" else "sse"
+            path = mcp.settings.streamable_http_path if args.transport == "http" else mcp.settings.sse_path
+            http = server_config.http
+            if http.error:
+                logger.error(f"❌ {http.error}")
+                sys.exit(1)
+            try:
+                tls_options = uvicorn_tls_options(http)
+            except ValueError as e:
+                logger.error(f"❌ {e}")
+                sys.exit(1)
+            scheme = "https" if tls_options else "http"
+            logger.info(f"🌐 Serving MCP over {transport} at {scheme}://{listen[0]}:{listen[1]}{path}")
+            if http.trusted_proxies:
+                logger.info(f"🔀 Trusting X-Forwarded-* headers from {', '.join(map(str, http.trusted_proxies))}")
+            if http.cors_origins:
+                logger.info(f"🌍 Allowing browser origins: {', '.join(http.cors_origins)}")
+            if http.dashboard:
+                logger.info(f"📋 Serving the dashboard at {scheme}://{listen[0]}:{listen[1]}{DASHBOARD_PATH}")
+            api_keys = server_config.api_keys
+            if api_keys.error:
+                logger.error(f"❌ {api_keys.error}")
+                sys.exit(1)
+            if api_keys.enabled:
+                logger.info(f"🔑 Requiring a bearer API key ({len(api_keys.keys)} configured)")
+            else:
+                logger.warning("⚠️ No API keys configured: anyone who can reach this address has full access")
+            serve_http(transport, listen, tls_options)
 
     except KeyboardInterrupt:
         logger.info("Server interrupted by user")
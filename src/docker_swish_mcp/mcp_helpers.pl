@@ -0,0 +1,4143 @@
+/*  Helper predicates for the Docker SWISH MCP server.
+
+    Loaded into the persistent Prolog session at startup. Every reply the
+    server parses is a line of the form
+
+        @MCP <QueryId> <Kind> [Payload]
+
+    so that output printed by the user's own goals can be told apart from
+    protocol events.
+*/
+
+:- use_module(library(time)).
+:- use_module(library(lists)).
+:- use_module(library(http/json)).
+:- use_module(library(pprint)).
+:- use_module(library(listing)).
+:- use_module(library(modules)).
+:- use_module(library(prolog_xref)).
+:- use_module(library(statistics)).
+
+%!  mcp_run(+Id, +Text, +Limits) is det.
+%!  mcp_run(+Id, +Text, +Limits, +Format) is det.
+%
+%   Parse Text as a goal, run it under Limits and emit one SOLUTION line
+%   per answer, an ERROR line on exceptions, and a final END line.
+%   Format is text (Name = Value pairs) or json (one JSON object mapping
+%   variable names to typed values, see mcp_term_json/2), or either with
+%   print options, as in text(print(...)) (see mcp_print_bindings/3).
+
+mcp_run(Id, Text, Limits) :-
+    mcp_run(Id, Text, Limits, text).
+
+mcp_run(Id, Text, Limits, Format) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            mcp_limited(Limits, mcp_solutions(Id, Goal, Bindings, Format))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%   The END line carries the thread's CPU time so far and the clauses
+%   in the program, from which the server works out what each query
+%   used (see quotas.py).
+
+mcp_end(Id) :-
+    statistics(cputime, Cpu),
+    statistics(clauses, Clauses),
+    format("@MCP ~w END ~6f ~d~n", [Id, Cpu, Clauses]),
+    flush_output.
+
+mcp_emit(Id, Kind, Term) :-
+    format("@MCP ~w ~w ~q~n", [Id, Kind, Term]),
+    flush_output.
+
+%!  mcp_read_goal(+Text, -Goal, -Bindings) is det.
+%
+%   Read the goal of a query. One the server qualified as Module:Goal
+%   (see namespaces.py) is read again with the flags of Module, so what
+%   set_flag set there, double_quotes for one, applies to its queries.
+
+mcp_read_goal(Text, Goal, Bindings) :-
+    term_string(Goal0, Text, [variable_names(Bindings0)]),
+    (   nonvar(Goal0),
+        Goal0 = Module:_,
+        atom(Module),
+        Module \== user,
+        current_module(Module)
+    ->  term_string(Goal, Text, [variable_names(Bindings), module(Module)])
+    ;   Goal = Goal0,
+        Bindings = Bindings0
+    ).
+
+mcp_solutions(Id, Goal, Bindings, Format) :-
+    (   call(Goal),
+        mcp_solution_text(Format, Bindings, Text),
+        format("@MCP ~w SOLUTION ~w~n", [Id, Text]),
+        flush_output,
+        fail
+    ;   true
+    ).
+
+mcp_solution_text(text, Bindings, Text) :-
+    mcp_bindings_text(Bindings, Text).
+mcp_solution_text(json, Bindings, Text) :-
+    mcp_bindings_json(Bindings, Dict),
+    with_output_to(string(Text), json_write_dict(current_output, Dict, [width(0)])).
+mcp_solution_text(text(Print), Bindings, Text) :-
+    mcp_print_bindings(Print, Bindings, Text0),
+    split_string(Text0, "\n", "", Lines),
+    atomic_list_concat(Lines, '\x1e\', Text).
+mcp_solution_text(json(print(Depth, Length, _, _)), Bindings0, Text) :-
+    mcp_cut_bindings(Depth, Length, Bindings0, Bindings),
+    mcp_solution_text(json, Bindings, Text).
+
+mcp_bindings_text([], true) :- !.
+mcp_bindings_text(Bindings, Text) :-
+    findall(S,
+            ( member(Name=Value, Bindings),
+              format(string(S), "~w = ~q", [Name, Value])
+            ),
+            Parts),
+    atomic_list_concat(Parts, ', ', Text).
+
+%!  mcp_print_bindings(+Print, +Bindings, -Text) is det.
+%
+%   Text of Bindings under print(MaxDepth, MaxList, Style, Portray).
+%   Subterms nested deeper than MaxDepth become ..., and lists longer
+%   than MaxList keep their first MaxList elements followed by ... (0
+%   disables either cut). Style is plain (writeq), pretty (print_term/2)
+%   or clause (portray_clause/3, with the variables of all bindings
+%   named together). The line breaks of pretty and clause layout are
+%   sent as \x1e, so a solution stays on one line of the protocol.
+
+mcp_print_bindings(print(Depth, Length, Style, Portray), Bindings0, Text) :-
+    mcp_cut_bindings(Depth, Length, Bindings0, Bindings1),
+    (   Bindings1 == []
+    ->  Text = true
+    ;   (   Style == clause
+        ->  copy_term(Bindings1, Bindings),
+            numbervars(Bindings, 0, _, [singletons(true)])
+        ;   Bindings = Bindings1
+        ),
+        findall(S,
+                ( member(Name=Value, Bindings),
+                  mcp_print_value(Style, Portray, Value, V),
+                  format(string(S), "~w = ~w", [Name, V])
+                ),
+                Parts),
+        (   Style == plain
+        ->  Separator = ', '
+        ;   Separator = ',\n'
+        ),
+        atomic_list_concat(Parts, Separator, Text)
+    ).
+
+mcp_print_value(plain, Portray, Value, Text) :-
+    format(string(Text), "~W", [Value, [quoted(true), portray(Portray), numbervars(true)]]).
+mcp_print_value(pretty, Portray, Value, Text) :-
+    with_output_to(string(Text),
+                   print_term(Value,
+                              [ right_margin(72),
+                                write_options([quoted(true), portray(Portray), numbervars(true)])
+                              ])).
+mcp_print_value(clause, Portray, Value, Text) :-
+    with_output_to(string(Text0), portray_clause(current_output, Value, [portray(Portray)])),
+    (   string_concat(Text, ".\n", Text0)
+    ->  true
+    ;   Text = Text0
+    ).
+
+mcp_cut_bindings(Depth, Length, Bindings, Cut) :-
+    maplist(mcp_cut_binding(Depth, Length), Bindings, Cut).
+
+mcp_cut_binding(Depth, Length, Name=Value, Name=Cut) :-
+    mcp_cut_term(Value, Depth, Length, Cut).
+
+mcp_cut_term(Term, _, _, Term) :-
+    \+ compound(Term), !.
+mcp_cut_term(_, stop, _, '...') :- !.
+mcp_cut_term(Term, Depth, Length, Cut) :-
+    (   Depth =:= 0
+    ->  Next = 0
+    ;   Depth =:= 1
+    ->  Next = stop
+    ;   Next is Depth - 1
+    ),
+    (   is_list(Term)
+    ->  mcp_cut_list(Term, Next, Length, Cut)
+    ;   compound_name_arguments(Term, Name, Args),
+        maplist(mcp_cut_arg(Next, Length), Args, CutArgs),
+        compound_name_arguments(Cut, Name, CutArgs)
+    ).
+
+mcp_cut_arg(Depth, Length, Term, Cut) :-
+    mcp_cut_term(Term, Depth, Length, Cut).
+
+mcp_cut_list(Items, Depth, Length, Cut) :-
+    length(Items, N),
+    (   Length > 0, N > Length
+    ->  length(Kept, Length),
+        append(Kept, _, Items),
+        maplist(mcp_cut_arg(Depth, Length), Kept, Cut0),
+        append(Cut0, ['...'], Cut)
+    ;   maplist(mcp_cut_arg(Depth, Length), Items, Cut)
+    ).
+
+%!  mcp_cursor_open(+Id, +Cursor, +Text, +Limits, +Format, +PageSize) is det.
+%!  mcp_cursor_next(+Id, +Cursor, +Limits, +PageSize) is det.
+%
+%   Paginated queries. The goal runs in an engine kept in mcp_cursor/3,
+%   so each page continues where the previous one stopped instead of
+%   re-running the goal. A page emits up to PageSize SOLUTION lines and
+%   then "CURSOR more" or, once the engine is exhausted and destroyed,
+%   "CURSOR done". Errors (including exceeded limits) destroy the cursor.
+
+:- dynamic mcp_cursor/3.
+
+mcp_cursor_open(Id, Cursor, Text, Limits, Format, PageSize) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            engine_create(Bindings, Goal, Engine),
+            assertz(mcp_cursor(Cursor, Engine, Format)),
+            mcp_cursor_page(Id, Cursor, Limits, PageSize)
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_cursor_next(Id, Cursor, Limits, PageSize) :-
+    catch(mcp_cursor_page(Id, Cursor, Limits, PageSize),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_cursor_page(Id, Cursor, Limits, PageSize) :-
+    (   mcp_cursor(Cursor, Engine, Format)
+    ->  true
+    ;   throw(existence_error(mcp_cursor, Cursor))
+    ),
+    catch(mcp_limited(Limits, mcp_cursor_take(Id, Engine, Format, PageSize, State)),
+          Error,
+          ( mcp_cursor_close(Cursor), throw(Error) )),
+    (   State == done
+    ->  mcp_cursor_close(Cursor)
+    ;   true
+    ),
+    format("@MCP ~w CURSOR ~w~n", [Id, State]),
+    flush_output.
+
+mcp_cursor_take(_, _, _, 0, more) :- !.
+mcp_cursor_take(Id, Engine, Format, N, State) :-
+    (   engine_next(Engine, Bindings)
+    ->  mcp_solution_text(Format, Bindings, Text),
+        format("@MCP ~w SOLUTION ~w~n", [Id, Text]),
+        flush_output,
+        N1 is N - 1,
+        mcp_cursor_take(Id, Engine, Format, N1, State)
+    ;   State = done
+    ).
+
+mcp_cursor_close(Cursor) :-
+    forall(retract(mcp_cursor(Cursor, Engine, _)),
+           catch(engine_destroy(Engine), _, true)).
+
+%!  mcp_repl_send(+Id, +Alias, +Request, +Limits) is det.
+%
+%   Drive the toplevel engine Alias for repl_send, creating it on first
+%   use. Request is query(Text, Module), more (";" at the prompt), stop
+%   (accept the last answer) or reset (destroy the engine). All queries
+%   of one toplevel run in the same engine, so Prolog flags and global
+%   variables set by one query are seen by the next, and a query with
+%   more answers keeps its choice points while the engine waits for the
+%   next request. Emits one SOLUTION with a "kind" of answer (with text
+%   and more), false, done, idle (nothing to continue), error (with
+%   message) or reset; "new" is true when the engine was just created.
+
+mcp_repl_send(Id, Alias, reset, _) :- !,
+    catch(( (   is_engine(Alias)
+            ->  engine_destroy(Alias)
+            ;   true
+            ),
+            mcp_emit_json(Id, _{kind:reset, new:false})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+mcp_repl_send(Id, Alias, Request, Limits) :-
+    catch(( (   is_engine(Alias)
+            ->  New = false
+            ;   engine_create(_, mcp_repl_loop, _, [alias(Alias)]),
+                New = true
+            ),
+            catch(mcp_limited(Limits, mcp_repl_post(Alias, Request, Reply)),
+                  Error,
+                  ( catch(engine_destroy(Alias), _, true), throw(Error) )),
+            put_dict(new, Reply, New, Json),
+            mcp_emit_json(Id, Json)
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_repl_post(Alias, Request, Reply) :-
+    (   engine_post(Alias, Request, Reply)
+    ->  true
+    ;   engine_destroy(Alias),
+        Reply = _{kind:error, message:"The toplevel stopped and was reset"}
+    ).
+
+mcp_repl_loop :-
+    engine_fetch(Request),
+    mcp_repl_request(Request).
+
+mcp_repl_request(query(Text, Module)) :- !,
+    catch(term_string(Term, Text, [variable_names(Bindings), module(Module)]), Error, true),
+    (   nonvar(Error)
+    ->  mcp_repl_error(Error, Reply),
+        engine_yield(Reply),
+        mcp_repl_loop
+    ;   Term = (:- Directive)
+    ->  mcp_repl_query(Module:once(Directive), [])
+    ;   mcp_repl_query(Module:Term, Bindings)
+    ).
+mcp_repl_request(_) :-
+    engine_yield(_{kind:idle}),
+    mcp_repl_loop.
+
+mcp_repl_query(Goal, Bindings) :-
+    catch(mcp_repl_answers(Goal, Bindings, Next),
+          Error,
+          ( mcp_repl_error(Error, Reply),
+            engine_yield(Reply),
+            Next = none
+          )),
+    (   Next == none
+    ->  mcp_repl_loop
+    ;   mcp_repl_request(Next)
+    ).
+
+%   Answers are yielded one at a time, as the toplevel prints them. On
+%   more the engine backtracks into the goal; any other request cuts its
+%   choice points and is handled next.
+
+mcp_repl_answers(Goal, Bindings, Next) :-
+    (   call_cleanup(Goal, Det = true),
+        mcp_repl_answer(Bindings, Text),
+        (   Det == true
+        ->  !,
+            engine_yield(_{kind:answer, text:Text, more:false}),
+            Next = none
+        ;   engine_yield(_{kind:answer, text:Text, more:true}),
+            engine_fetch(Request),
+            Request \== more,
+            !,
+            (   Request == stop
+            ->  engine_yield(_{kind:done}),
+                Next = none
+            ;   Next = Request
+            )
+        )
+    ;   engine_yield(_{kind:false}),
+        Next = none
+    ).
+
+%   Bindings as the toplevel shows them: variables named by the query,
+%   "_"-prefixed ones hidden, residual constraints after the bindings,
+%   written with the answer_write_options flag.
+
+mcp_repl_answer(Bindings, Text) :-
+    copy_term(Bindings, Copy, Residual),
+    maplist(mcp_repl_name_var, Copy),
+    current_prolog_flag(answer_write_options, Options0),
+    Options = [quoted(true), numbervars(true)|Options0],
+    findall(Part,
+            ( member(Name=Value, Copy),
+              \+ sub_atom(Name, 0, _, _, '_'),
+              Value \== '$VAR'(Name),
+              format(string(Part), "~w = ~W", [Name, Value, Options])
+            ),
+            Parts0),
+    findall(Part,
+            ( member(Goal, Residual),
+              format(string(Part), "~W", [Goal, Options])
+            ),
+            Parts1),
+    append(Parts0, Parts1, Parts),
+    (   Parts == []
+    ->  Text = "true"
+    ;   atomic_list_concat(Parts, ',\n', Text)
+    ).
+
+mcp_repl_name_var(Name=Var) :-
+    ignore(Var = '$VAR'(Name)).
+
+mcp_repl_error(Error, _{kind:error, message:Message}) :-
+    (   catch('$messages':translate_message(Error, Lines, []), _, fail)
+    ->  with_output_to(string(Message0), print_message_lines(current_output, '', Lines)),
+        split_string(Message0, "", " \n", [Message])
+    ;   format(string(Message), "~q", [Error])
+    ).
+
+%!  mcp_engine_submit(+Id, +Engine, +Job, +Text, +Module, +Limits, +Max) is det.
+%!  mcp_engine_poll(+Id, +Engine, +Job) is det.
+%!  mcp_engine_list(+Id) is det.
+%!  mcp_engine_stop(+Id, +Engine, +Close) is det.
+%
+%   Goals of an MCP session run on a Prolog thread of its own, Engine,
+%   so a slow goal neither waits for the persistent session nor holds it
+%   up (see engines.py). Submitting creates the thread on first use and
+%   queues job Job: the goal Text, read and run in Module under Limits,
+%   for at most Max solutions, with what it prints captured. It emits
+%   {"engine", "job", "status": "queued"} and returns at once. Polling
+%   emits the job's state: queued, running (with "seconds"), or once it
+%   is done succeeded, failed or error, with its solutions (bindings as
+%   mcp_batch/3 reports them), "more", output and error; a finished job
+%   is forgotten once polled, after which it is unknown. Listing emits one SOLUTION per engine
+%   thread with its status, CPU time, queued and unread jobs and the job
+%   it is running. Stopping interrupts the running job, which ends with
+%   the error mcp_engine_stopped, and with Close drops the queued jobs
+%   and ends the thread once it is free.
+
+:- dynamic mcp_engine_job/4.
+
+mcp_engine_submit(Id, Engine, Job, Text, Module, Limits, Max) :-
+    catch(( mcp_engine_thread(Engine),
+            assertz(mcp_engine_job(Engine, Job, Text, queued)),
+            thread_send_message(Engine, job(Job, Text, Module, Limits, Max)),
+            mcp_emit_json(Id, _{engine:Engine, job:Job, status:queued})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_engine_thread(Engine) :-
+    (   catch(thread_property(Engine, status(running)), _, fail)
+    ->  true
+    ;   % A thread that ended leaves its alias taken until joined
+        catch(thread_join(Engine, _), _, true),
+        forall(( mcp_engine_job(Engine, Job, _, State),
+                 State \= done(_)
+               ),
+               mcp_engine_state(Engine, Job,
+                                done(_{status:error, error:"The engine thread ended", output:""}))),
+        thread_create(mcp_engine_loop(Engine), _, [alias(Engine)])
+    ).
+
+mcp_engine_loop(Engine) :-
+    % A stop signal arriving between jobs must not end the thread
+    catch(thread_get_message(Message), mcp_engine_stopped, Message = none),
+    (   Message == stop
+    ->  true
+    ;   (   Message = job(Job, Text, Module, Limits, Max),
+            mcp_engine_job(Engine, Job, _, queued)
+        ->  catch(mcp_engine_run(Engine, Job, Text, Module, Limits, Max), mcp_engine_stopped, true)
+        ;   true
+        ),
+        mcp_engine_loop(Engine)
+    ).
+
+mcp_engine_run(Engine, Job, Text, Module, Limits, Max) :-
+    get_time(Started),
+    mcp_engine_state(Engine, Job, running(Started)),
+    Take is Max + 1,
+    with_output_to(string(Output),
+                   catch(( term_string(Goal, Text, [variable_names(Bindings), module(Module)]),
+                           mcp_limited(Limits, findnsols(Take, Bindings, Module:Goal, All)),
+                           Result = solutions(All)
+                         ),
+                         Error,
+                         Result = error(Error))),
+    get_time(Finished),
+    Seconds is Finished - Started,
+    mcp_engine_outcome(Result, Max, Dict0),
+    put_dict(_{output:Output, seconds:Seconds}, Dict0, Dict),
+    mcp_engine_state(Engine, Job, done(Dict)).
+
+mcp_engine_outcome(solutions([]), _, _{status:failed}) :- !.
+mcp_engine_outcome(solutions(All), Max, _{status:succeeded, solutions:Rows, more:More}) :- !,
+    length(All, Count),
+    (   Count > Max
+    ->  length(Kept, Max),
+        append(Kept, _, All),
+        More = true
+    ;   Kept = All,
+        More = false
+    ),
+    maplist(mcp_engine_row, Kept, Rows).
+mcp_engine_outcome(error(Error), _, _{status:error, error:Text}) :-
+    format(string(Text), "~q", [Error]).
+
+mcp_engine_row(Bindings, _{bindings:Text, json:Json}) :-
+    mcp_bindings_text(Bindings, Text),
+    mcp_bindings_json(Bindings, Json).
+
+mcp_engine_state(Engine, Job, State) :-
+    with_mutex(mcp_engine,
+               (   retract(mcp_engine_job(Engine, Job, Text, _))
+               ->  assertz(mcp_engine_job(Engine, Job, Text, State))
+               ;   true
+               )).
+
+mcp_engine_poll(Id, Engine, Job) :-
+    catch(( mcp_engine_job(Engine, Job, Text, State)
+          ->  mcp_engine_report(Id, Job, Text, State)
+          ;   mcp_emit_json(Id, _{job:Job, status:unknown})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_engine_report(Id, Job, Text, done(Dict)) :- !,
+    retractall(mcp_engine_job(_, Job, _, _)),
+    put_dict(_{job:Job, goal:Text}, Dict, Json),
+    mcp_emit_json(Id, Json).
+mcp_engine_report(Id, Job, Text, running(Started)) :- !,
+    get_time(Now),
+    Seconds is Now - Started,
+    mcp_emit_json(Id, _{job:Job, goal:Text, status:running, seconds:Seconds}).
+mcp_engine_report(Id, Job, Text, queued) :-
+    mcp_emit_json(Id, _{job:Job, goal:Text, status:queued}).
+
+mcp_engine_list(Id) :-
+    catch(forall(( thread_property(Thread, alias(Engine)),
+                   sub_atom(Engine, 0, _, _, mcp_engine_)
+                 ),
+                 mcp_engine_describe(Id, Thread, Engine)),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_engine_describe(Id, Thread, Engine) :-
+    (   catch(thread_property(Thread, status(Status0)), _, fail)
+    ->  format(string(Status), "~q", [Status0])
+    ;   Status = "gone"
+    ),
+    (   catch(thread_statistics(Thread, cputime, Cpu), _, fail)
+    ->  true
+    ;   Cpu = 0
+    ),
+    aggregate_all(count, mcp_engine_job(Engine, _, _, queued), Queued),
+    aggregate_all(count, mcp_engine_job(Engine, _, _, done(_)), Unread),
+    (   mcp_engine_job(Engine, Job, Text, running(Started))
+    ->  get_time(Now),
+        Seconds is Now - Started,
+        Running = _{job:Job, goal:Text, seconds:Seconds}
+    ;   Running = null
+    ),
+    mcp_emit_json(Id, _{engine:Engine, status:Status, cpu:Cpu, queued:Queued, unread:Unread, running:Running}).
+
+mcp_engine_stop(Id, Engine, Close) :-
+    catch(( (   mcp_engine_job(Engine, _, _, running(_))
+            ->  thread_signal(Engine, throw(mcp_engine_stopped)),
+                Interrupted = true
+            ;   Interrupted = false
+            ),
+            (   Close == true
+            ->  forall(mcp_engine_job(Engine, Job, _, queued),
+                       mcp_engine_state(Engine, Job,
+                                        done(_{status:error, error:"The engine was closed", output:""}))),
+                (   catch(thread_property(Engine, status(running)), _, fail)
+                ->  thread_send_message(Engine, stop),
+                    thread_detach(Engine)
+                ;   catch(thread_join(Engine, _), _, true)
+                )
+            ;   true
+            ),
+            mcp_emit_json(Id, _{engine:Engine, interrupted:Interrupted, closed:Close})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_trace(+Id, +Text, +Limits, +Options) is det.
+%
+%   Run the goal in Text once under the tracer and emit a TRACE line per
+%   port: a JSON object with port (call, exit, redo, fail or exception),
+%   depth (1 for the goals of the query itself), frame (the frame
+%   reference, shared by all ports of one call), goal and predicate.
+%   Options is trace(MaxDepth, MaxPorts, Safe): calls below MaxDepth are
+%   run without tracing their subgoals, after MaxPorts events tracing
+%   stops ("TRACE truncated"), and Safe = true vets the goal with
+%   safe_goal/1 before tracing starts. The first answer, if any, is
+%   emitted as a SOLUTION line.
+
+:- multifile user:prolog_trace_interception/4.
+:- dynamic user:prolog_trace_interception/4.
+
+user:prolog_trace_interception(Port, Frame, _PC, Action) :-
+    nb_current(mcp_trace, State),
+    State = trace(_, _, _, _, _),
+    !,
+    mcp_trace_port(State, Port, Frame, Action).
+
+mcp_trace(Id, Text, Limits, trace(MaxDepth, MaxPorts, Safe)) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            (   Safe == true
+            ->  use_module(library(sandbox)),
+                safe_goal(Goal)
+            ;   true
+            ),
+            mcp_limited(Limits, mcp_trace_goal(Id, Goal, Bindings, MaxDepth, MaxPorts))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_trace_goal(Id, Goal, Bindings, MaxDepth, MaxPorts) :-
+    setup_call_cleanup(
+        nb_setval(mcp_trace, trace(Id, none, MaxDepth, MaxPorts, 0)),
+        (   mcp_traced(Goal)
+        ->  mcp_bindings_text(Bindings, Text),
+            format("@MCP ~w SOLUTION ~w~n", [Id, Text]),
+            flush_output
+        ;   true
+        ),
+        ( notrace, nodebug, nb_setval(mcp_trace, off) )).
+
+mcp_traced(Goal) :-
+    trace,
+    call(Goal),
+    notrace,
+    !.
+mcp_traced(_) :-
+    notrace,
+    fail.
+
+mcp_trace_port(trace(_, _, _, MaxPorts, Count), _, _, nodebug) :-
+    Count >= MaxPorts, !.
+mcp_trace_port(State, Port, Frame, Action) :-
+    State = trace(Id, Base0, MaxDepth, MaxPorts, Count),
+    prolog_frame_attribute(Frame, level, Level),
+    (   prolog_frame_attribute(Frame, predicate_indicator, PI)
+    ->  true
+    ;   PI = unknown
+    ),
+    (   mcp_trace_internal(PI)
+    ->  Action = continue
+    ;   mcp_port_name(Port, Name, Extra)
+    ->  (   Base0 == none
+        ->  Base is Level - 1
+        ;   Base = Base0
+        ),
+        Depth is Level - Base,
+        (   Depth > MaxDepth
+        ->  Action = continue
+        ;   prolog_frame_attribute(Frame, goal, Goal),
+            format(string(GoalText), "~W", [Goal, [quoted(true), max_depth(12), portray(true)]]),
+            format(string(PIText), "~q", [PI]),
+            Event0 = _{port:Name, depth:Depth, frame:Frame, goal:GoalText, predicate:PIText},
+            (   Extra == none
+            ->  Event = Event0
+            ;   format(string(ExtraText), "~q", [Extra]),
+                put_dict(error, Event0, ExtraText, Event)
+            ),
+            with_output_to(string(Json), json_write_dict(current_output, Event, [width(0)])),
+            format("@MCP ~w TRACE ~w~n", [Id, Json]),
+            Count1 is Count + 1,
+            (   Count1 >= MaxPorts
+            ->  format("@MCP ~w TRACE truncated~n", [Id])
+            ;   true
+            ),
+            flush_output,
+            nb_setval(mcp_trace, trace(Id, Base, MaxDepth, MaxPorts, Count1)),
+            (   Name == call, Depth >= MaxDepth
+            ->  Action = skip
+            ;   Action = continue
+            )
+        )
+    ;   Action = continue
+    ).
+
+mcp_trace_internal(Name/_) :-
+    atom(Name),
+    sub_atom(Name, 0, _, _, mcp_).
+mcp_trace_internal(_:Name/_) :-
+    atom(Name),
+    sub_atom(Name, 0, _, _, mcp_).
+
+mcp_port_name(call, call, none).
+mcp_port_name(exit, exit, none).
+mcp_port_name(redo(_), redo, none).
+mcp_port_name(fail, fail, none).
+mcp_port_name(exception(Error), exception, Error).
+
+%!  mcp_explain(+Id, +Guard, +Text, +Module, +Limits, +Options) is det.
+%
+%   Prove the goal in Text, read in Module, with a meta-interpreter that
+%   records how each solution was derived (see proofs.py). Guard is run
+%   first, as is, with the goal's variables: the sandbox's vetting of
+%   the goal, so it does not show in the proofs. Options is
+%   explain(MaxSolutions, MaxDepth, MaxNodes). Emits one SOLUTION per
+%   solution, up to MaxSolutions:
+%
+%     {"bindings": Text, "json": Dict, "proof": [Node, ...], "truncated": Bool}
+%
+%   with a Node per goal proved, {"goal", "kind", "clause", "source",
+%   "children"}. kind is fact or rule (a clause of a user predicate, with
+%   its text and File:Line), builtin (a built-in or library predicate,
+%   run as is), tabled (answered from its table), negation (\+ Goal,
+%   which has no proof) or depth (a goal deeper than MaxDepth, run
+%   without recording its proof). A proof is cut off after MaxNodes
+%   nodes; the nodes that lost children have "truncated": true. A last
+%   row {"more": Bool, "output": Text} says whether there were further
+%   solutions and carries what the goal printed.
+%
+%   A cut in a clause body cuts the clause's alternatives and those of
+%   the goals before it, as in a normal call; a cut inside a disjunction
+%   or if-then-else of the body only commits that construct. The goals
+%   passed to findall/3, forall/2 and other meta-predicates are run, not
+%   proved.
+
+mcp_explain(Id, GuardText, Text, Module, Limits, explain(Max, MaxDepth, MaxNodes)) :-
+    catch(( format(string(Both), "mcp_explained((~w), (~w))", [GuardText, Text]),
+            term_string(mcp_explained(Guard, Goal), Both, [variable_names(Bindings), module(Module)]),
+            Take is Max + 1,
+            with_output_to(string(Output),
+                           mcp_limited(Limits,
+                                       findnsols(Take, Bindings-Proof,
+                                                 ( call(Module:Guard),
+                                                   mcp_prove_body(Goal, Module, 1, MaxDepth, Proof)
+                                                 ),
+                                                 All))),
+            length(All, Count),
+            (   Count > Max
+            ->  length(Kept, Max),
+                append(Kept, _, All),
+                More = true
+            ;   Kept = All,
+                More = false
+            ),
+            forall(member(Solution, Kept), mcp_explain_emit(Id, Solution, MaxNodes)),
+            mcp_emit_json(Id, _{more:More, output:Output})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_explain_emit(Id, Bindings-Proof, MaxNodes) :-
+    mcp_bindings_text(Bindings, Text),
+    mcp_bindings_json(Bindings, Json),
+    Budget = budget(MaxNodes),
+    mcp_proof_nodes(Proof, Budget, Nodes, Truncated),
+    mcp_emit_json(Id, _{bindings:Text, json:Json, proof:Nodes, truncated:Truncated}).
+
+%   Proofs are lists of node(Kind, Goal, ClauseRef, Children), one per
+%   goal of a conjunction; control constructs leave no node of their own.
+
+mcp_prove(Goal, _, _, _, _) :-
+    var(Goal), !,
+    instantiation_error(Goal).
+mcp_prove(true, _, _, _, []) :- !.
+mcp_prove(!, _, _, _, []) :- !.
+mcp_prove(Module:Goal, _, Depth, Max, Proof) :- !,
+    mcp_prove(Goal, Module, Depth, Max, Proof).
+mcp_prove((A, B), M, Depth, Max, Proof) :- !,
+    mcp_prove(A, M, Depth, Max, PA),
+    mcp_prove(B, M, Depth, Max, PB),
+    append(PA, PB, Proof).
+mcp_prove((If -> Then ; Else), M, Depth, Max, Proof) :- !,
+    (   mcp_prove(If, M, Depth, Max, PI)
+    ->  mcp_prove(Then, M, Depth, Max, PT),
+        append(PI, PT, Proof)
+    ;   mcp_prove(Else, M, Depth, Max, Proof)
+    ).
+mcp_prove((If *-> Then ; Else), M, Depth, Max, Proof) :- !,
+    (   mcp_prove(If, M, Depth, Max, PI)
+    *-> mcp_prove(Then, M, Depth, Max, PT),
+        append(PI, PT, Proof)
+    ;   mcp_prove(Else, M, Depth, Max, Proof)
+    ).
+mcp_prove((A ; B), M, Depth, Max, Proof) :- !,
+    (   mcp_prove(A, M, Depth, Max, Proof)
+    ;   mcp_prove(B, M, Depth, Max, Proof)
+    ).
+mcp_prove((If -> Then), M, Depth, Max, Proof) :- !,
+    (   mcp_prove(If, M, Depth, Max, PI)
+    ->  mcp_prove(Then, M, Depth, Max, PT),
+        append(PI, PT, Proof)
+    ).
+mcp_prove(\+ Goal, M, _, _, [node(negation, \+ Goal, none, [])]) :- !,
+    \+ call(M:Goal).
+mcp_prove(call(Goal), M, Depth, Max, Proof) :- !,
+    mcp_prove_body(Goal, M, Depth, Max, Proof).
+mcp_prove(Goal, M, Depth, Max, [Node]) :-
+    mcp_prove_goal(Goal, M, Depth, Max, Node).
+
+mcp_prove_goal(Goal, M, Depth, Max, Node) :-
+    (   mcp_proof_opaque(M:Goal, Kind)
+    ->  call(M:Goal),
+        Node = node(Kind, Goal, none, [])
+    ;   Depth > Max
+    ->  call(M:Goal),
+        Node = node(depth, Goal, none, [])
+    ;   mcp_prove_clauses(Goal, M, Depth, Max, Node)
+    ).
+
+%   The cut after the goals before a body's first cut commits to the
+%   clause, as the cut itself would.
+
+mcp_prove_clauses(Goal, M, Depth, Max, node(Kind, Goal, Ref, Children)) :-
+    Depth1 is Depth + 1,
+    clause(M:Goal, Body, Ref),
+    (   clause_property(Ref, module(BodyModule))
+    ->  true
+    ;   BodyModule = M
+    ),
+    (   Body == true
+    ->  Kind = fact
+    ;   Kind = rule
+    ),
+    (   mcp_cut_split(Body, Before, After)
+    ->  mcp_prove(Before, BodyModule, Depth1, Max, PB),
+        !,
+        mcp_prove_body(After, BodyModule, Depth1, Max, PA),
+        append(PB, PA, Children)
+    ;   mcp_prove(Body, BodyModule, Depth1, Max, Children)
+    ).
+
+%   A body's later cuts commit to the goals between them.
+
+mcp_prove_body(Body, M, Depth, Max, Proof) :-
+    mcp_cut_split(Body, Before, After),
+    !,
+    once(mcp_prove(Before, M, Depth, Max, PB)),
+    mcp_prove_body(After, M, Depth, Max, PA),
+    append(PB, PA, Proof).
+mcp_prove_body(Body, M, Depth, Max, Proof) :-
+    mcp_prove(Body, M, Depth, Max, Proof).
+
+mcp_cut_split(Body, true, true) :-
+    Body == !, !.
+mcp_cut_split(Body, Before, After) :-
+    nonvar(Body),
+    Body = (A, B),
+    (   A == !
+    ->  Before = true,
+        After = B
+    ;   mcp_cut_split(B, Before0, After),
+        Before = (A, Before0)
+    ).
+
+%   Goals whose clauses are not proved: built-in, library and helper
+%   predicates, tabled ones, undefined ones (calling them raises the
+%   usual existence error) and, with protect_static_code, static ones.
+
+mcp_proof_opaque(Goal, tabled) :-
+    predicate_property(Goal, tabled), !.
+mcp_proof_opaque(Goal, builtin) :-
+    (   predicate_property(Goal, built_in)
+    ;   predicate_property(Goal, foreign)
+    ;   \+ predicate_property(Goal, defined)
+    ;   predicate_property(Goal, imported_from(From)),
+        module_property(From, class(Class)),
+        memberchk(Class, [system, library])
+    ;   Goal = _:Head,
+        functor(Head, Name, _),
+        sub_atom(Name, 0, _, _, mcp_)
+    ;   current_prolog_flag(protect_static_code, true),
+        \+ predicate_property(Goal, dynamic)
+    ),
+    !.
+
+mcp_proof_nodes([], _, [], false).
+mcp_proof_nodes([_|_], Budget, [], true) :-
+    arg(1, Budget, Left),
+    Left =< 0, !.
+mcp_proof_nodes([node(Kind, Goal, Ref, Children)|Nodes], Budget, [Dict|Dicts], Truncated) :-
+    arg(1, Budget, Left),
+    Left1 is Left - 1,
+    nb_setarg(1, Budget, Left1),
+    mcp_proof_nodes(Children, Budget, Kids, Cut),
+    format(string(GoalText), "~W", [Goal, [quoted(true), max_depth(12), portray(true), numbervars(true)]]),
+    mcp_proof_clause(Ref, Clause, Source),
+    Dict0 = _{goal:GoalText, kind:Kind, clause:Clause, source:Source, children:Kids},
+    (   Cut == true
+    ->  put_dict(truncated, Dict0, true, Dict)
+    ;   Dict = Dict0
+    ),
+    mcp_proof_nodes(Nodes, Budget, Dicts, Truncated).
+
+mcp_proof_clause(none, "", "") :- !.
+mcp_proof_clause(Ref, Clause, Source) :-
+    (   catch(clause(Head0, Body, Ref), _, fail)
+    ->  strip_module(Head0, _, Head),
+        (   Body == true
+        ->  Term = Head
+        ;   Term = (Head :- Body)
+        ),
+        with_output_to(string(Text), portray_clause(Term)),
+        split_string(Text, "", " \n", [Clause])
+    ;   Clause = ""
+    ),
+    (   clause_property(Ref, file(File)),
+        clause_property(Ref, line_count(Line))
+    ->  file_base_name(File, Base),
+        format(string(Source), "~w:~w", [Base, Line])
+    ;   Source = ""
+    ).
+
+%!  mcp_rdf_load(+Id, +File, +Graph, +Format) is det.
+%!  mcp_rdf_triples(+Id, +S, +P, +O, +G, +Limit) is det.
+%!  mcp_rdf_run(+Id, +Text, +Limits, +Limit) is det.
+%!  mcp_rdf_graphs(+Id) is det.
+%
+%   Bridge to the semweb triple store (library(semweb/rdf_db)), loaded on
+%   first use. Each SOLUTION line is a JSON object whose RDF terms use
+%   the layout of SPARQL JSON results ({"type": "uri"|"literal"|"bnode",
+%   "value": ...} plus "datatype" or "xml:lang" for literals).
+%
+%   Triple patterns are any, iri(IRI), pname(Prefix, Local) or, for
+%   objects, lit(Text, Lang, Type) matching literals by lexical form,
+%   with none for an unconstrained language or datatype.
+
+mcp_rdf_ensure :-
+    use_module(library(semweb/rdf_db)),
+    use_module(library(semweb/turtle)),
+    use_module(library(semweb/rdf_ntriples)).
+
+mcp_rdf_load(Id, File, Graph, Format) :-
+    catch(( mcp_rdf_ensure,
+            (   Format == auto
+            ->  Options = [graph(Graph)]
+            ;   Options = [graph(Graph), format(Format)]
+            ),
+            rdf_db:rdf_load(File, Options),
+            mcp_rdf_graph_size(Graph, Count),
+            mcp_emit_json(Id, _{graph:Graph, triples:Count})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_rdf_graphs(Id) :-
+    catch(( mcp_rdf_ensure,
+            forall(rdf_db:rdf_graph(Graph),
+                   ( mcp_rdf_graph_size(Graph, Count),
+                     mcp_emit_json(Id, _{graph:Graph, triples:Count})
+                   ))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_rdf_graph_size(Graph, Count) :-
+    (   catch(rdf_db:rdf_graph_property(Graph, triples(Count)), _, fail)
+    ->  true
+    ;   aggregate_all(count, rdf_db:rdf(_, _, _, Graph), Count)
+    ).
+
+mcp_rdf_triples(Id, SP, PP, OP, GP, Limit) :-
+    catch(( mcp_rdf_ensure,
+            mcp_rdf_pattern(SP, S, _),
+            mcp_rdf_pattern(PP, P, _),
+            mcp_rdf_pattern(OP, O, Filter),
+            mcp_rdf_pattern(GP, G, _),
+            forall(limit(Limit, ( rdf_db:rdf(S, P, O, Source),
+                                  mcp_rdf_source_graph(Source, G),
+                                  call(Filter)
+                                )),
+                   ( mcp_rdf_term_json(S, SJ),
+                     mcp_rdf_term_json(P, PJ),
+                     mcp_rdf_term_json(O, OJ),
+                     mcp_emit_json(Id, _{subject:SJ, predicate:PJ, object:OJ, graph:G})
+                   ))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_rdf_run(Id, Text, Limits, Limit) :-
+    catch(( mcp_rdf_ensure,
+            term_string(Goal0, Text, [variable_names(Bindings)]),
+            expand_goal(Goal0, Goal),
+            mcp_limited(Limits,
+                        forall(limit(Limit, Goal),
+                               ( findall(Name-Json,
+                                         ( member(Name=Value, Bindings),
+                                           mcp_rdf_term_json(Value, Json)
+                                         ),
+                                         Pairs),
+                                 dict_pairs(Dict, _, Pairs),
+                                 mcp_emit_json(Id, Dict)
+                               )))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_emit_json(Id, Dict) :-
+    with_output_to(string(Json), json_write_dict(current_output, Dict, [width(0)])),
+    format("@MCP ~w SOLUTION ~w~n", [Id, Json]),
+    flush_output.
+
+mcp_rdf_pattern(any, _, true).
+mcp_rdf_pattern(iri(IRI), IRI, true).
+mcp_rdf_pattern(pname(Prefix, Local), IRI, true) :-
+    rdf_db:rdf_global_id(Prefix:Local, IRI).
+mcp_rdf_pattern(lit(Text, Lang, Type), O, mcp_rdf_literal_match(Text, Lang, TypeIRI, O)) :-
+    (   Type == none
+    ->  TypeIRI = none
+    ;   mcp_rdf_pattern(Type, TypeIRI, _)
+    ).
+
+%   Triples loaded from a file have Graph:Line as their source
+
+mcp_rdf_source_graph(Graph:_, Graph) :- !.
+mcp_rdf_source_graph(Graph, Graph).
+
+mcp_rdf_literal_match(Text, Lang, Type, literal(Literal)) :-
+    mcp_rdf_literal_parts(Literal, Value, Lang1, Type1),
+    format(string(Lexical), "~w", [Value]),
+    Lexical == Text,
+    (   Lang == none
+    ->  true
+    ;   downcase_atom(Lang1, Lang)
+    ),
+    (   Type == none
+    ->  true
+    ;   Type1 == Type
+    ).
+
+mcp_rdf_literal_parts(type(Type, Value), Value, none, Type) :- !.
+mcp_rdf_literal_parts(lang(Lang, Value), Value, Lang, none) :- !.
+mcp_rdf_literal_parts(Value, Value, none, none).
+
+mcp_rdf_term_json(Term, _{type:var, name:Name}) :-
+    var(Term), !,
+    format(string(Name), "~w", [Term]).
+mcp_rdf_term_json(literal(Literal), Dict) :- !,
+    mcp_rdf_literal_parts(Literal, Value, Lang, Type),
+    mcp_rdf_literal_json(Value, Lang, Type, Dict).
+%   library(semweb/rdf11) writes literals as Value^^Type and Text@Lang;
+%   canonical syntax, as those operators only exist once it is loaded
+mcp_rdf_term_json(^^(Value, Type), Dict) :- !,
+    mcp_rdf_literal_json(Value, none, Type, Dict).
+mcp_rdf_term_json(@(Text, Lang), Dict) :- !,
+    mcp_rdf_literal_json(Text, Lang, none, Dict).
+mcp_rdf_term_json(Atom, _{type:bnode, value:Atom}) :-
+    atom(Atom),
+    sub_atom(Atom, 0, _, _, '_:'), !.
+mcp_rdf_term_json(Atom, _{type:uri, value:Atom}) :-
+    atom(Atom), !.
+mcp_rdf_term_json(Term, Dict) :-
+    mcp_term_json(Term, Dict).
+
+mcp_rdf_literal_json(Value, Lang, Type, Dict) :-
+    format(string(Lexical), "~w", [Value]),
+    Dict0 = _{type:literal, value:Lexical},
+    (   Lang == none
+    ->  Dict1 = Dict0
+    ;   put_dict('xml:lang', Dict0, Lang, Dict1)
+    ),
+    (   Type == none
+    ->  Dict = Dict1
+    ;   put_dict(datatype, Dict1, Type, Dict)
+    ).
+
+%!  mcp_owl_import(+Id, +Module, +Graph, +Names, +Replace) is det.
+%
+%   Convert the ontology in RDF graph Graph to owl_* facts in Module (see
+%   mcp_owl_fact/1 for the predicates) and add the reasoning rules of
+%   mcp_owl_rule/1 to Module unless it has them. Names is local, for an
+%   IRI's local name unless two IRIs share it, or iri. Triples about
+%   blank nodes (restrictions, class expressions, lists) are skipped and
+%   counted. With Replace true the owl_* facts already in Module are
+%   retracted first. Emits one SOLUTION with the facts per predicate.
+
+mcp_owl_import(Id, Module, Graph, Names, Replace) :-
+    catch(( mcp_rdf_ensure,
+            findall(Fact, ( rdf_db:rdf(S, P, O, Graph),
+                            mcp_owl_axiom(S, P, O, Fact0),
+                            mcp_owl_implied(Fact0, Fact)
+                          ),
+                    Facts0),
+            partition(==(skipped), Facts0, Skipped, Facts1),
+            length(Skipped, SkippedCount),
+            sort(Facts1, Facts2),
+            mcp_owl_names(Names, Facts2, Facts),
+            forall(mcp_owl_fact(Name/Arity),
+                   ( dynamic(Module:Name/Arity),
+                     (   Replace == true
+                     ->  functor(Head, Name, Arity),
+                         retractall(Module:Head)
+                     ;   true
+                     )
+                   )),
+            forall(member(Fact, Facts),
+                   (   call(Module:Fact)
+                   ->  true
+                   ;   assertz(Module:Fact)
+                   )),
+            (   catch(clause(Module:owl_subclass_of(_, _), _), _, fail)
+            ->  Registered = false
+            ;   forall(mcp_owl_rule(Rule), assertz(Module:Rule)),
+                Registered = true
+            ),
+            findall(Name-Count, ( mcp_owl_fact(Name/Arity),
+                                  functor(Head, Name, Arity),
+                                  aggregate_all(count, Module:Head, Count)
+                                ),
+                    Pairs),
+            dict_pairs(Counts, _, Pairs),
+            mcp_rdf_graph_size(Graph, Triples),
+            mcp_emit_json(Id, _{graph:Graph, triples:Triples, facts:Counts,
+                                skipped:SkippedCount, rules_added:Registered})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_owl_fact(?PI) is nondet.
+%
+%   The predicates an imported ontology is converted to.
+
+mcp_owl_fact(owl_class/1).
+mcp_owl_fact(owl_subclass/2).
+mcp_owl_fact(owl_equivalent_class/2).
+mcp_owl_fact(owl_disjoint_with/2).
+mcp_owl_fact(owl_object_property/1).
+mcp_owl_fact(owl_datatype_property/1).
+mcp_owl_fact(owl_subproperty/2).
+mcp_owl_fact(owl_equivalent_property/2).
+mcp_owl_fact(owl_domain/2).
+mcp_owl_fact(owl_range/2).
+mcp_owl_fact(owl_inverse_of/2).
+mcp_owl_fact(owl_transitive/1).
+mcp_owl_fact(owl_symmetric/1).
+mcp_owl_fact(owl_functional/1).
+mcp_owl_fact(owl_individual/1).
+mcp_owl_fact(owl_type/2).
+mcp_owl_fact(owl_value/3).
+mcp_owl_fact(owl_label/2).
+
+%   The fact a triple states, skipped for blank nodes, or nothing for
+%   vocabulary triples without a fact (owl:Ontology, rdfs:comment, ...)
+
+mcp_owl_axiom(S, _, _, skipped) :-
+    mcp_owl_blank(S), !.
+mcp_owl_axiom(_, _, O, skipped) :-
+    atom(O),
+    mcp_owl_blank(O), !.
+mcp_owl_axiom(S, P, O, Fact) :-
+    (   mcp_owl_vocabulary(P, Property)
+    ->  mcp_owl_schema(Property, S, O, Fact)
+    ;   mcp_owl_object(O, Value),
+        Fact = owl_value(S, P, Value)
+    ).
+
+mcp_owl_blank(Node) :-
+    sub_atom(Node, 0, _, _, '_:').
+
+mcp_owl_vocabulary(IRI, Prefix:Local) :-
+    atom(IRI),
+    member(Prefix, [rdf, rdfs, owl, xsd]),
+    rdf_db:rdf_current_prefix(Prefix, Namespace),
+    atom_concat(Namespace, Local, IRI), !.
+
+mcp_owl_schema(rdf:type, S, O, Fact) :-
+    (   mcp_owl_vocabulary(O, Type)
+    ->  mcp_owl_declaration(Type, S, Fact)
+    ;   Fact = owl_type(S, O)
+    ).
+mcp_owl_schema(rdfs:subClassOf, C, D, owl_subclass(C, D)).
+mcp_owl_schema(owl:equivalentClass, C, D, owl_equivalent_class(C, D)).
+mcp_owl_schema(owl:disjointWith, C, D, owl_disjoint_with(C, D)).
+mcp_owl_schema(rdfs:subPropertyOf, P, Q, owl_subproperty(P, Q)).
+mcp_owl_schema(owl:equivalentProperty, P, Q, owl_equivalent_property(P, Q)).
+mcp_owl_schema(rdfs:domain, P, C, owl_domain(P, C)).
+mcp_owl_schema(rdfs:range, P, C, owl_range(P, C)).
+mcp_owl_schema(owl:inverseOf, P, Q, owl_inverse_of(P, Q)).
+mcp_owl_schema(rdfs:label, R, literal(Literal), owl_label(R, Text)) :-
+    mcp_rdf_literal_parts(Literal, Value, _, _),
+    atom_string(Value, Text).
+
+mcp_owl_declaration(owl:'Class', C, owl_class(C)).
+mcp_owl_declaration(rdfs:'Class', C, owl_class(C)).
+mcp_owl_declaration(owl:'ObjectProperty', P, owl_object_property(P)).
+mcp_owl_declaration(owl:'DatatypeProperty', P, owl_datatype_property(P)).
+mcp_owl_declaration(owl:'TransitiveProperty', P, owl_transitive(P)).
+mcp_owl_declaration(owl:'SymmetricProperty', P, owl_symmetric(P)).
+mcp_owl_declaration(owl:'FunctionalProperty', P, owl_functional(P)).
+mcp_owl_declaration(owl:'NamedIndividual', I, owl_individual(I)).
+
+%   Literal objects become numbers (numeric datatypes) or strings;
+%   resources stay atoms
+
+mcp_owl_object(literal(Literal), Value) :- !,
+    mcp_rdf_literal_parts(Literal, Lexical, _, Type),
+    (   Type \== none,
+        rdf_db:rdf_current_prefix(xsd, XSD),
+        atom_concat(XSD, Local, Type),
+        memberchk(Local, [integer, int, long, short, decimal, double, float,
+                          nonNegativeInteger, positiveInteger]),
+        atom_number(Lexical, Number)
+    ->  Value = Number
+    ;   atom_string(Lexical, Value)
+    ).
+mcp_owl_object(Resource, Resource).
+
+%   A fact with the facts it implies, e.g. that both sides of a
+%   subclass axiom are classes
+
+mcp_owl_implied(skipped, skipped) :- !.
+mcp_owl_implied(Fact, Implied) :-
+    (   Implied = Fact
+    ;   mcp_owl_implies(Fact, Implied)
+    ).
+
+mcp_owl_implies(owl_subclass(C, D), owl_class(X)) :- member(X, [C, D]).
+mcp_owl_implies(owl_equivalent_class(C, D), owl_class(X)) :- member(X, [C, D]).
+mcp_owl_implies(owl_disjoint_with(C, D), owl_class(X)) :- member(X, [C, D]).
+mcp_owl_implies(owl_type(I, _), owl_individual(I)).
+mcp_owl_implies(owl_type(_, C), owl_class(C)).
+mcp_owl_implies(owl_domain(_, C), owl_class(C)).
+mcp_owl_implies(owl_transitive(P), owl_object_property(P)).
+mcp_owl_implies(owl_symmetric(P), owl_object_property(P)).
+mcp_owl_implies(owl_inverse_of(P, Q), owl_object_property(X)) :- member(X, [P, Q]).
+
+%   Replace IRIs by their local names where that name is unique
+
+mcp_owl_names(iri, Facts, Facts).
+mcp_owl_names(local, Facts0, Facts) :-
+    findall(IRI, ( member(Fact, Facts0),
+                   arg(_, Fact, IRI),
+                   atom(IRI)
+                 ),
+            IRIs0),
+    sort(IRIs0, IRIs),
+    findall(Local-IRI, ( member(IRI, IRIs), mcp_owl_local(IRI, Local) ), Pairs),
+    msort(Pairs, Sorted),
+    findall(IRI-Name, ( member(Local-IRI, Sorted),
+                        (   aggregate_all(count, member(Local-_, Sorted), 1)
+                        ->  Name = Local
+                        ;   Name = IRI
+                        )
+                      ),
+            Mapping),
+    list_to_assoc(Mapping, Assoc),
+    maplist(mcp_owl_rename(Assoc), Facts0, Facts1),
+    sort(Facts1, Facts).
+
+mcp_owl_local(IRI, Local) :-
+    (   iri_xml_namespace(IRI, _, Local),
+        Local \== ''
+    ->  true
+    ;   Local = IRI
+    ).
+
+mcp_owl_rename(Assoc, Fact0, Fact) :-
+    Fact0 =.. [Name|Args0],
+    maplist(mcp_owl_rename_arg(Assoc), Args0, Args),
+    Fact =.. [Name|Args].
+
+mcp_owl_rename_arg(Assoc, Arg, Name) :-
+    atom(Arg),
+    get_assoc(Arg, Assoc, Name), !.
+mcp_owl_rename_arg(_, Arg, Arg).
+
+%!  mcp_owl_reach(:Step, +From, ?To) is nondet.
+%
+%   To is reached from From in one or more calls of Step, each reached
+%   node once, also when Step's graph has cycles.
+
+:- meta_predicate mcp_owl_reach(2, +, ?).
+
+mcp_owl_reach(Step, From, To) :-
+    findall(Y, call(Step, From, Y), Ys0),
+    sort(Ys0, Ys),
+    mcp_owl_reach_(Ys, Step, Ys, Seen),
+    member(To, Seen).
+
+mcp_owl_reach_([], _, Seen, Seen).
+mcp_owl_reach_([X|Xs], Step, Seen0, Seen) :-
+    findall(Y, ( call(Step, X, Y), \+ memberchk(Y, Seen0) ), Ys0),
+    sort(Ys0, Ys),
+    append(Seen0, Ys, Seen1),
+    append(Xs, Ys, Queue),
+    mcp_owl_reach_(Queue, Step, Seen1, Seen).
+
+%!  mcp_owl_rule(-Clause) is nondet.
+%
+%   The reasoning rules mcp_owl_import/5 adds next to the facts:
+%
+%     - owl_subclass_of(C, D): C is D or a subclass of it, through
+%       subclass and equivalence axioms
+%     - owl_subproperty_of(P, Q): likewise for properties
+%     - owl_holds(S, P, O): S has P value O, asserted or entailed by
+%       subproperties, inverses, symmetric and transitive properties
+%     - owl_instance_of(I, C): I belongs to C, from its asserted types,
+%       the domains and ranges of its property values, and superclasses
+%     - owl_inconsistent(I, C, D): I belongs to disjoint classes C and D
+
+mcp_owl_rule((owl_subclass_of(C, D) :-
+    (   nonvar(C)
+    ->  distinct(D, ( D = C ; mcp_owl_reach(mcp_owl_super, C, D) ))
+    ;   nonvar(D)
+    ->  distinct(C, ( C = D ; mcp_owl_reach(mcp_owl_sub, D, C) ))
+    ;   owl_class(C),
+        distinct(D, ( D = C ; mcp_owl_reach(mcp_owl_super, C, D) ))
+    ))).
+mcp_owl_rule((mcp_owl_super(C, D) :-
+    (   owl_subclass(C, D)
+    ;   owl_equivalent_class(C, D)
+    ;   owl_equivalent_class(D, C)
+    ))).
+mcp_owl_rule((mcp_owl_sub(D, C) :- mcp_owl_super(C, D))).
+mcp_owl_rule((owl_subproperty_of(P, Q) :-
+    (   nonvar(P)
+    ->  distinct(Q, ( Q = P ; mcp_owl_reach(mcp_owl_superproperty, P, Q) ))
+    ;   nonvar(Q)
+    ->  distinct(P, ( P = Q ; mcp_owl_reach(mcp_owl_subproperty, Q, P) ))
+    ;   mcp_owl_property(P),
+        distinct(Q, ( Q = P ; mcp_owl_reach(mcp_owl_superproperty, P, Q) ))
+    ))).
+mcp_owl_rule((mcp_owl_superproperty(P, Q) :-
+    (   owl_subproperty(P, Q)
+    ;   owl_equivalent_property(P, Q)
+    ;   owl_equivalent_property(Q, P)
+    ))).
+mcp_owl_rule((mcp_owl_subproperty(Q, P) :- mcp_owl_superproperty(P, Q))).
+mcp_owl_rule((mcp_owl_property(P) :-
+    distinct(P, ( owl_object_property(P) ; owl_datatype_property(P) ; owl_value(_, P, _) )))).
+mcp_owl_rule((owl_holds(S, P, O) :-
+    (   nonvar(P)
+    ->  true
+    ;   mcp_owl_property(P)
+    ),
+    (   owl_transitive(P)
+    ->  (   nonvar(S)
+        ->  mcp_owl_reach(mcp_owl_edge(P), S, O)
+        ;   nonvar(O)
+        ->  mcp_owl_reach(mcp_owl_back(P), O, S)
+        ;   distinct(S, mcp_owl_edge(P, S, _)),
+            mcp_owl_reach(mcp_owl_edge(P), S, O)
+        )
+    ;   distinct(S-O, mcp_owl_edge(P, S, O))
+    ))).
+mcp_owl_rule((mcp_owl_edge(P, S, O) :-
+    owl_subproperty_of(P0, P),
+    (   owl_value(S, P0, O)
+    ;   ( owl_inverse_of(P0, Q) ; owl_inverse_of(Q, P0) ),
+        owl_value(O, Q, S)
+    ;   owl_symmetric(P),
+        owl_value(O, P0, S)
+    ))).
+mcp_owl_rule((mcp_owl_back(P, O, S) :- mcp_owl_edge(P, S, O))).
+mcp_owl_rule((owl_instance_of(I, C) :-
+    (   nonvar(I)
+    ->  true
+    ;   owl_individual(I)
+    ),
+    mcp_owl_types(I, Types),
+    member(C, Types))).
+mcp_owl_rule((mcp_owl_types(I, Types) :-
+    findall(T, ( owl_type(I, T)
+               ; owl_holds(I, P, _), owl_domain(P, T)
+               ; owl_holds(_, P, I), owl_range(P, T)
+               ),
+            Direct0),
+    sort(Direct0, Direct),
+    findall(C, ( member(T, Direct), owl_subclass_of(T, C) ), Types0),
+    sort(Types0, Types))).
+mcp_owl_rule((owl_inconsistent(I, C, D) :-
+    owl_individual(I),
+    mcp_owl_types(I, Types),
+    member(C, Types),
+    member(D, Types),
+    owl_disjoint_with(C, D))).
+
+%!  mcp_clpfd_solve(+Id, +Text, +Limits, +Max) is det.
+%
+%   Solve a finite-domain model built by constraints.py. Text reads as
+%   model(Goal, Options): Goal posts the domains and constraints and
+%   Options are passed to labeling/2. Every variable named in Text is
+%   labeled, and each of at most Max SOLUTION lines is a JSON object
+%   mapping the variable names to integers or lists of integers.
+%   library(clpfd) is loaded before Text is read, as its operators are
+%   needed to parse it.
+
+mcp_clpfd_solve(Id, Text, Limits, Max) :-
+    catch(( use_module(library(clpfd)),
+            term_string(model(Goal, Options), Text, [variable_names(Bindings)]),
+            term_variables(Bindings, Vars),
+            mcp_limited(Limits,
+                        forall(limit(Max, ( call(Goal), labeling(Options, Vars) )),
+                               ( findall(Name-Value, member(Name=Value, Bindings), Pairs),
+                                 dict_pairs(Dict, _, Pairs),
+                                 mcp_emit_json(Id, Dict)
+                               )))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_acl_check(+Acl, :Goal) is det.
+%
+%   Check Goal against a client's access control list before it runs
+%   (see PredicateAcl in sandbox.py). Acl is acl(Call, Assert, Retract),
+%   each any or a list of wildcard patterns on Module:Name/Arity, where
+%   Module is the module defining the predicate (for one not defined
+%   yet, the module it would be created in). Every predicate Goal calls
+%   directly, through control constructs and meta-predicate arguments,
+%   must match a Call pattern; the clauses given to assert/1 and its
+%   variants an Assert pattern, and those given to retract/1,
+%   retractall/1 and abolish/1 a Retract pattern. What the callees call
+%   in turn is not checked. Goals whose target is not known before they
+%   run are refused where their actions are restricted: erase/1 as a
+%   retract, recorda/recordz and compile_aux_clauses/1 as an assert, and
+%   loading files (consult/1, load_files/2, ...) as both. Raises
+%   permission_error(Action, procedure, PI) for the first predicate that
+%   matches none, and for a goal only known at runtime (PI runtime_goal)
+%   where Action is restricted.
+
+:- meta_predicate mcp_acl_check(+, :).
+
+mcp_acl_check(Acl, Goal) :-
+    \+ \+ mcp_acl_walk(Goal, Acl).
+
+mcp_acl_walk(M:Goal, Acl) :-
+    (   var(Goal)
+    ->  mcp_acl_opaque(call, Acl)
+    ;   Goal = M1:Goal1
+    ->  (   atom(M1)
+        ->  mcp_acl_walk(M1:Goal1, Acl)
+        ;   mcp_acl_opaque(call, Acl)
+        )
+    ;   mcp_acl_control(Goal)
+    ->  forall(arg(_, Goal, Sub), mcp_acl_walk(M:Sub, Acl))
+    ;   mcp_acl_database(Goal, _, _)
+    ->  forall(mcp_acl_database(Goal, Action, Target),
+               mcp_acl_target(Target, M, Action, Acl))
+    ;   callable(Goal)
+    ->  mcp_acl_allow(call, M, Goal, Acl),
+        mcp_acl_meta(M, Goal, Acl)
+    ;   true
+    ).
+
+mcp_acl_control((_,_)).
+mcp_acl_control((_;_)).
+mcp_acl_control((_->_)).
+mcp_acl_control((_*->_)).
+mcp_acl_control(\+ _).
+
+mcp_acl_database(assert(C), assert, clause(C)).
+mcp_acl_database(asserta(C), assert, clause(C)).
+mcp_acl_database(assertz(C), assert, clause(C)).
+mcp_acl_database(assert(C, _), assert, clause(C)).
+mcp_acl_database(asserta(C, _), assert, clause(C)).
+mcp_acl_database(assertz(C, _), assert, clause(C)).
+mcp_acl_database(retract(C), retract, clause(C)).
+mcp_acl_database(retractall(H), retract, clause(H)).
+mcp_acl_database(abolish(PI), retract, indicator(PI)).
+mcp_acl_database(abolish(Name, Arity), retract, indicator(Name/Arity)).
+mcp_acl_database(compile_aux_clauses(_), assert, opaque).
+% Clause references and the recorded database name no predicate
+mcp_acl_database(erase(_), retract, opaque).
+mcp_acl_database(recorda(_, _), assert, opaque).
+mcp_acl_database(recorda(_, _, _), assert, opaque).
+mcp_acl_database(recordz(_, _), assert, opaque).
+mcp_acl_database(recordz(_, _, _), assert, opaque).
+% The directives of a loaded file may assert and retract anything
+mcp_acl_database(Load, Action, opaque) :-
+    mcp_acl_load(Load),
+    member(Action, [assert, retract]).
+
+mcp_acl_load(consult(_)).
+mcp_acl_load(load_files(_)).
+mcp_acl_load(load_files(_, _)).
+mcp_acl_load(ensure_loaded(_)).
+mcp_acl_load(include(_)).
+mcp_acl_load(make).
+mcp_acl_load([_|_]).
+
+mcp_acl_target(clause(C), M, Action, Acl) :-
+    (   var(C)
+    ->  mcp_acl_opaque(Action, Acl)
+    ;   C = M1:C1
+    ->  (   atom(M1)
+        ->  mcp_acl_target(clause(C1), M1, Action, Acl)
+        ;   mcp_acl_opaque(Action, Acl)
+        )
+    ;   C = (Head :- _)
+    ->  mcp_acl_target(clause(Head), M, Action, Acl)
+    ;   callable(C)
+    ->  mcp_acl_allow(Action, M, C, Acl)
+    ;   true
+    ).
+mcp_acl_target(opaque, _, Action, Acl) :-
+    mcp_acl_opaque(Action, Acl).
+mcp_acl_target(indicator(PI), M, Action, Acl) :-
+    (   PI = M1:PI1, atom(M1)
+    ->  mcp_acl_target(indicator(PI1), M1, Action, Acl)
+    ;   PI = Name/Arity, atom(Name), integer(Arity)
+    ->  functor(Head, Name, Arity),
+        mcp_acl_allow(Action, M, Head, Acl)
+    ;   mcp_acl_opaque(Action, Acl)
+    ).
+
+% Goal arguments of meta-predicates such as findall/3 and forall/2
+mcp_acl_meta(M, Goal, Acl) :-
+    (   predicate_property(M:Goal, meta_predicate(Spec))
+    ->  forall(( arg(N, Spec, ArgSpec),
+                 mcp_acl_meta_arg(ArgSpec, Extra)
+               ),
+               ( arg(N, Goal, Arg),
+                 mcp_acl_meta_goal(Extra, M, Arg, Acl)
+               ))
+    ;   true
+    ).
+
+mcp_acl_meta_arg(N, N) :- integer(N).
+mcp_acl_meta_arg(^, 0).
+mcp_acl_meta_arg(//, dcg).
+
+mcp_acl_meta_goal(_, _, Arg, Acl) :-
+    var(Arg), !,
+    mcp_acl_opaque(call, Acl).
+mcp_acl_meta_goal(dcg, _, _, Acl) :- !,
+    mcp_acl_opaque(call, Acl).
+mcp_acl_meta_goal(Extra, M, M1:Arg, Acl) :- !,
+    (   atom(M1)
+    ->  mcp_acl_meta_goal(Extra, M1, Arg, Acl)
+    ;   mcp_acl_opaque(call, Acl)
+    ).
+mcp_acl_meta_goal(0, M, _^Arg, Acl) :- !,
+    mcp_acl_meta_goal(0, M, Arg, Acl).
+mcp_acl_meta_goal(Extra, M, Arg, Acl) :-
+    (   callable(Arg)
+    ->  length(Args, Extra),
+        Arg =.. List0,
+        append(List0, Args, List),
+        Goal =.. List,
+        mcp_acl_walk(M:Goal, Acl)
+    ;   true
+    ).
+
+mcp_acl_allow(Action, M, Head, Acl) :-
+    mcp_acl_patterns(Action, Acl, Patterns),
+    (   Patterns == any
+    ->  true
+    ;   functor(Head, Name, Arity),
+        (   predicate_property(M:Head, implementation_module(Impl))
+        ->  true
+        ;   Impl = M
+        ),
+        format(atom(PI), "~w:~w/~w", [Impl, Name, Arity]),
+        (   member(Pattern, Patterns),
+            wildcard_match(Pattern, PI)
+        ->  true
+        ;   permission_error(Action, procedure, Impl:Name/Arity)
+        )
+    ).
+
+mcp_acl_opaque(Action, Acl) :-
+    mcp_acl_patterns(Action, Acl, Patterns),
+    (   Patterns == any
+    ->  true
+    ;   permission_error(Action, procedure, runtime_goal)
+    ).
+
+mcp_acl_patterns(call, acl(Patterns, _, _), Patterns).
+mcp_acl_patterns(assert, acl(_, Patterns, _), Patterns).
+mcp_acl_patterns(retract, acl(_, _, Patterns), Patterns).
+
+%!  mcp_consult_vet(+File) is det.
+%!  mcp_consult_vet(+Id, +File) is det.
+%
+%   Vet the file consulting File would load for the strict sandbox
+%   policy (see policy_guard in sandbox.py), whose safe_goal/1 cannot
+%   look into a consult. Each directive and initialization goal must be
+%   a declaration (dynamic/1, discontiguous/1, table/1, module/2, op/3
+%   or use_module of a library) or pass safe_goal/1, and the file may
+%   not define the hooks that run code as it loads or later, such as
+%   term_expansion/2 and exception/3. Raises the error safe_goal/1
+%   raises, or permission_error(load, source_sink, Culprit). The second
+%   form reports the error as ERROR and ends with END.
+
+mcp_consult_vet(File) :-
+    use_module(library(sandbox)),
+    absolute_file_name(File, Path, [file_type(prolog), access(read)]),
+    % The file's operators are declared in a module of its own, not in user
+    in_temporary_module(Module,
+                        true,
+                        setup_call_cleanup(open(Path, read, In),
+                                           mcp_consult_vet_stream(In, Module),
+                                           close(In))).
+
+mcp_consult_vet(Id, File) :-
+    catch(mcp_consult_vet(File), Error, mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_consult_vet_stream(In, Module) :-
+    read_term(In, Term, [module(Module)]),
+    (   Term == end_of_file
+    ->  true
+    ;   mcp_consult_vet_term(Term, Module),
+        mcp_consult_vet_stream(In, Module)
+    ).
+
+mcp_consult_vet_term((:- Directive), Module) :- !,
+    mcp_consult_vet_directive(Directive, Module).
+mcp_consult_vet_term((?- Directive), Module) :- !,
+    mcp_consult_vet_directive(Directive, Module).
+mcp_consult_vet_term((Head --> _), _) :- !,
+    mcp_consult_vet_head(Head).
+mcp_consult_vet_term((Head :- _), _) :- !,
+    mcp_consult_vet_head(Head).
+mcp_consult_vet_term(Head, _) :-
+    mcp_consult_vet_head(Head).
+
+mcp_consult_vet_head(Head) :-
+    (   var(Head)
+    ->  true
+    ;   Head = _:_
+    ->  permission_error(load, source_sink, Head)
+    ;   callable(Head),
+        functor(Head, Name, Arity),
+        mcp_consult_hook(Name/Arity)
+    ->  permission_error(load, source_sink, Name/Arity)
+    ;   true
+    ).
+
+mcp_consult_hook(term_expansion/2).
+mcp_consult_hook(term_expansion/4).
+mcp_consult_hook(goal_expansion/2).
+mcp_consult_hook(goal_expansion/4).
+mcp_consult_hook(exception/3).
+mcp_consult_hook(message_hook/3).
+mcp_consult_hook(portray/1).
+mcp_consult_hook(prolog_load_file/2).
+mcp_consult_hook(file_search_path/2).
+
+mcp_consult_vet_directive(Directive, Module) :-
+    (   var(Directive)
+    ->  instantiation_error(Directive)
+    ;   mcp_consult_declaration(Directive)
+    ->  true
+    ;   Directive = op(Priority, Type, Name)
+    ->  Module:op(Priority, Type, Name)
+    ;   Directive = initialization(Goal)
+    ->  safe_goal(user:Goal)
+    ;   Directive = initialization(Goal, _)
+    ->  safe_goal(user:Goal)
+    ;   safe_goal(user:Directive)
+    ).
+
+mcp_consult_declaration(dynamic(_)).
+mcp_consult_declaration(discontiguous(_)).
+mcp_consult_declaration(table(_)).
+mcp_consult_declaration(module(_, _)).
+mcp_consult_declaration(use_module(library(_))).
+mcp_consult_declaration(use_module(library(_), _)).
+
+%!  mcp_batch(+Id, +Texts, +Limits) is det.
+%
+%   Run a list of goal texts in order, each once, inside a single
+%   transaction/1 so their changes to dynamic predicates are kept only if
+%   every goal succeeds. A SOLUTION line per goal run reports
+%   {"goal": N, "status": "succeeded"|"failed"|"error", ...}: bindings
+%   (text) and json (typed, see mcp_term_json/2) for a success, error for
+%   an exception. A final {"committed": Bool} line says whether the
+%   changes were kept. The goals stop at the first that does not succeed.
+%   Each text is read separately, so variables are not shared between
+%   goals; all are read before any runs.
+
+mcp_batch(Id, Texts, Limits) :-
+    catch(( maplist(mcp_batch_goal, Texts, Goals),
+            mcp_limited(Limits,
+                        (   transaction(mcp_batch_run(Id, Goals, 1))
+                        ->  Committed = true
+                        ;   Committed = false
+                        )),
+            mcp_emit_json(Id, _{committed:Committed})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_batch_goal(Text, goal(Goal, Bindings)) :-
+    mcp_read_goal(Text, Goal, Bindings).
+
+mcp_batch_run(_, [], _).
+mcp_batch_run(Id, [goal(Goal, Bindings)|Goals], N) :-
+    catch(( once(Goal) -> Result = succeeded ; Result = failed ), Error, Result = error(Error)),
+    mcp_batch_report(Id, N, Result, Bindings),
+    Result == succeeded,
+    N1 is N + 1,
+    mcp_batch_run(Id, Goals, N1).
+
+mcp_batch_report(Id, N, succeeded, Bindings) :-
+    mcp_bindings_text(Bindings, Text),
+    mcp_bindings_json(Bindings, Json),
+    mcp_emit_json(Id, _{goal:N, status:succeeded, bindings:Text, json:Json}).
+mcp_batch_report(Id, N, failed, _) :-
+    mcp_emit_json(Id, _{goal:N, status:failed}).
+mcp_batch_report(Id, N, error(Error), _) :-
+    format(string(Text), "~q", [Error]),
+    mcp_emit_json(Id, _{goal:N, status:error, error:Text}).
+
+%!  mcp_db_snapshot(+Id) is det.
+%!  mcp_db_snapshot(+Id, +Module) is det.
+%!  mcp_db_snapshot(+Id, +Module, +Max) is det.
+%!  mcp_db_restore(+Id, +Predicates) is det.
+%!  mcp_db_restore(+Id, +Module, +Predicates) is det.
+%
+%   Capture and restore the dynamic database of Module (default user)
+%   for the audit log's undo stack. The snapshot emits one SOLUTION per
+%   dynamic predicate, {"name": N, "arity": A, "clauses": [Text, ...]},
+%   with each clause written canonically so it reads back unchanged.
+%   Restoring takes a list of pred(Name, Arity, Clauses) in the same
+%   form: those predicates get exactly these clauses, and dynamic
+%   predicates missing from the list (created after the snapshot) are
+%   emptied.
+%
+%   With Max, a database of more than Max clauses is not written out:
+%   each predicate emits {"name": N, "arity": A, "count": C} instead,
+%   counted from number_of_clauses without touching the clauses.
+
+mcp_db_snapshot(Id) :-
+    mcp_db_snapshot(Id, user).
+
+mcp_db_snapshot(Id, Module) :-
+    catch(forall(mcp_db_predicate(Module, Head, Name, Arity),
+                 ( findall(Text,
+                           ( clause(Module:Head, Body),
+                             mcp_clause_text(Head, Body, Text)
+                           ),
+                           Clauses),
+                   mcp_emit_json(Id, _{name:Name, arity:Arity, clauses:Clauses})
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_db_snapshot(Id, Module, Max) :-
+    aggregate_all(sum(Count),
+                  ( mcp_db_predicate(Module, Head, _, _),
+                    mcp_db_clause_count(Module:Head, Count)
+                  ),
+                  Total),
+    (   Total > Max
+    ->  catch(forall(( mcp_db_predicate(Module, Head, Name, Arity),
+                       mcp_db_clause_count(Module:Head, Count)
+                     ),
+                     mcp_emit_json(Id, _{name:Name, arity:Arity, count:Count})),
+              Error,
+              mcp_emit(Id, 'ERROR', Error)),
+        mcp_end(Id)
+    ;   mcp_db_snapshot(Id, Module)
+    ).
+
+mcp_db_clause_count(Head, Count) :-
+    (   predicate_property(Head, number_of_clauses(Count))
+    ->  true
+    ;   Count = 0
+    ).
+
+mcp_db_restore(Id, Predicates) :-
+    mcp_db_restore(Id, user, Predicates).
+
+mcp_db_restore(Id, Module, Predicates) :-
+    catch(( forall(( mcp_db_predicate(Module, Head, Name, Arity),
+                     \+ memberchk(pred(Name, Arity, _), Predicates)
+                   ),
+                   retractall(Module:Head)),
+            forall(member(pred(Name, Arity, Clauses), Predicates),
+                   ( functor(Head, Name, Arity),
+                     dynamic(Module:Name/Arity),
+                     retractall(Module:Head),
+                     forall(member(Text, Clauses),
+                            ( term_string(Clause, Text),
+                              assertz(Module:Clause)
+                            ))
+                   )),
+            length(Predicates, Count),
+            mcp_emit_json(Id, _{restored:Count})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%   Dynamic predicates defined in Module by the user's own code: helpers
+%   and multifile hooks such as term_expansion/2 are left alone.
+mcp_db_predicate(Module, Head, Name, Arity) :-
+    current_predicate(Module:Name/Arity),
+    \+ sub_atom(Name, 0, _, _, mcp_),
+    \+ sub_atom(Name, 0, _, _, '$'),
+    functor(Head, Name, Arity),
+    predicate_property(Module:Head, dynamic),
+    \+ predicate_property(Module:Head, multifile),
+    \+ predicate_property(Module:Head, imported_from(_)).
+
+mcp_clause_text(Head, true, Text) :- !,
+    format(string(Text), "~k", [Head]).
+mcp_clause_text(Head, Body, Text) :-
+    format(string(Text), "~k", [(Head :- Body)]).
+
+%!  mcp_asof_load(+Id, +Source, +Scratch, +Predicates) is det.
+%!  mcp_asof_drop(+Id, +Scratch) is det.
+%
+%   The knowledge base of Source as it was at a past moment, for
+%   execute_prolog_query's as_of (see timetravel.py). Loading copies the
+%   clauses of Source's own static predicates into the new module
+%   Scratch, so that its rules call the historical facts rather than
+%   Source's, then gives Scratch the dynamic predicates Predicates, a
+%   list of pred(Name, Arity, Clauses) as for mcp_db_restore/3. Emits
+%   {"static": S, "dynamic": D} with the number of predicates of each.
+%   Scratch imports from user like any new module. Dropping destroys it
+%   with everything the query asserted there.
+
+mcp_asof_load(Id, Source, Scratch, Predicates) :-
+    catch(( findall(Name/Arity, mcp_asof_static(Source, Name, Arity), Static),
+            forall(member(Name/Arity, Static),
+                   ( functor(Head, Name, Arity),
+                     forall(clause(Source:Head, Body),
+                            assertz(Scratch:(Head :- Body)))
+                   )),
+            forall(member(pred(Name, Arity, Clauses), Predicates),
+                   ( dynamic(Scratch:Name/Arity),
+                     forall(member(Text, Clauses),
+                            ( term_string(Clause, Text),
+                              assertz(Scratch:Clause)
+                            ))
+                   )),
+            length(Static, StaticCount),
+            length(Predicates, DynamicCount),
+            mcp_emit_json(Id, _{static:StaticCount, dynamic:DynamicCount})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_asof_static(Module, Name, Arity) :-
+    current_predicate(Module:Name/Arity),
+    \+ sub_atom(Name, 0, _, _, mcp_),
+    \+ sub_atom(Name, 0, _, _, '$'),
+    functor(Head, Name, Arity),
+    \+ predicate_property(Module:Head, dynamic),
+    \+ predicate_property(Module:Head, imported_from(_)),
+    \+ predicate_property(Module:Head, built_in),
+    \+ predicate_property(Module:Head, foreign),
+    \+ predicate_property(Module:Head, multifile).
+
+mcp_asof_drop(Id, Scratch) :-
+    % in_temporary_module/3 cannot span the separate query that runs between load and drop
+    catch(( current_module(Scratch)
+          ->  '$destroy_module'(Scratch)
+          ;   true
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_group(+Text, +Names, +Aggregates, -Groups, -Values) is nondet.
+%
+%   The solutions of the goal Text grouped by the values of its
+%   variables Names, for execute_prolog_query's group_by, count and sum
+%   (see shaping.py). Groups is unified with those values, once per
+%   distinct combination in the order they are first found, and Values
+%   with the Aggregates (count, or sum(Name) of its variable Name) of the
+%   goal's solutions in the group. Without Names all solutions are one
+%   group. The goal is a string so that its other variables stay out of
+%   the bindings reported.
+
+mcp_group(Text, Names, Aggregates, Groups, Values) :-
+    mcp_read_goal(Text, Goal, Bindings),
+    maplist(mcp_group_var(Bindings), Names, Groups),
+    (   Names == []
+    ->  true
+    ;   Key =.. [group|Groups],
+        findall(Key, distinct(Key, Goal), Keys),
+        member(Key, Keys)
+    ),
+    maplist(mcp_group_value(Bindings, Goal), Aggregates, Values).
+
+mcp_group_var(Bindings, Name, Var) :-
+    (   memberchk(Name=Var, Bindings)
+    ->  true
+    ;   existence_error(variable, Name)
+    ).
+
+mcp_group_value(_, Goal, count, Count) :-
+    aggregate_all(count, Goal, Count).
+mcp_group_value(Bindings, Goal, sum(Name), Sum) :-
+    mcp_group_var(Bindings, Name, Var),
+    aggregate_all(sum(Var), Goal, Sum).
+
+%!  mcp_kb_state(+Id, +Root) is det.
+%!  mcp_kb_replay(+Id, +Loads) is det.
+%
+%   What a session has loaded, so upgrade_swish can rebuild it in a new
+%   container. mcp_kb_state/2 emits one SOLUTION {"file": Path,
+%   "module": M} per file below Root consulted from the toplevel (files
+%   those load come back with them), in load order, with M the module
+%   it was loaded into, then one {"database": M} per user module, whose
+%   dynamic database mcp_db_snapshot/2 then captures. mcp_kb_replay/2
+%   consults a list of load(Module, Path) in order and emits {"file":
+%   Path} for each, with "error" set when it could not be loaded.
+
+mcp_kb_state(Id, Root) :-
+    catch(( forall(( mcp_kb_data_file(Root, File),
+                     \+ ( source_file_property(File, load_context(_, Parent:_, _)),
+                          mcp_kb_data_file(Root, Parent)
+                        ),
+                     mcp_kb_load_module(File, Module)
+                   ),
+                   mcp_emit_json(Id, _{file:File, module:Module})),
+            forall(( current_module(Module),
+                     module_property(Module, class(user))
+                   ),
+                   mcp_emit_json(Id, _{database:Module}))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_kb_load_module(File, Module) :-
+    (   source_file_property(File, load_context(Module, _, _))
+    ->  true
+    ;   Module = user
+    ).
+
+mcp_kb_replay(Id, Loads) :-
+    forall(member(load(Module, File), Loads),
+           catch(( Module:consult(File),
+                   mcp_emit_json(Id, _{file:File})
+                 ),
+                 Error,
+                 ( format(string(Text), "~q", [Error]),
+                   mcp_emit_json(Id, _{file:File, error:Text})
+                 ))),
+    mcp_end(Id).
+
+%!  mcp_env_flags(+Id, +Flags) is det.
+%!  mcp_env_set_flags(+Id, +Pairs) is det.
+%
+%   The Prolog flags a reproduce bundle records. mcp_env_flags/2 emits
+%   one SOLUTION {"flag": F, "value": Text} for each of Flags that
+%   exists, with Text its value written by ~q. mcp_env_set_flags/2 sets
+%   each Flag-Text of Pairs back and emits {"flag": F}, with "error"
+%   set when the flag could not be set.
+
+mcp_env_flags(Id, Flags) :-
+    forall(( member(Flag, Flags),
+             current_prolog_flag(Flag, Value)
+           ),
+           ( format(string(Text), "~q", [Value]),
+             mcp_emit_json(Id, _{flag:Flag, value:Text})
+           )),
+    mcp_end(Id).
+
+mcp_env_set_flags(Id, Pairs) :-
+    forall(member(Flag-Text, Pairs),
+           catch(( term_string(Value, Text),
+                   set_prolog_flag(Flag, Value),
+                   mcp_emit_json(Id, _{flag:Flag})
+                 ),
+                 Error,
+                 ( format(string(Message), "~q", [Error]),
+                   mcp_emit_json(Id, _{flag:Flag, error:Message})
+                 ))),
+    mcp_end(Id).
+
+%!  mcp_flags_get(+Id, +Keys) is det.
+%!  mcp_flags_set(+Id, +Pairs) is det.
+%
+%   The flags of get_flags and set_flag (see prolog_flags.py). A key is
+%   a flag name or, for a flag SWI-Prolog keeps per module, Module:Name.
+%   mcp_flags_get/2 emits one SOLUTION {"flag": Name, "value": Text} per
+%   key that exists, with Text its value written by ~q. mcp_flags_set/2
+%   sets each Key-Text of Pairs and emits {"flag": Name, "value": Text}
+%   with the value it then has, or {"flag": Name, "error": Message}.
+
+mcp_flags_get(Id, Keys) :-
+    forall(member(Key, Keys),
+           (   mcp_flag_name(Key, Flag),
+               catch(current_prolog_flag(Key, Value), _, fail)
+           ->  format(string(Text), "~q", [Value]),
+               mcp_emit_json(Id, _{flag:Flag, value:Text})
+           ;   true
+           )),
+    mcp_end(Id).
+
+mcp_flags_set(Id, Pairs) :-
+    forall(member(Key-Text, Pairs),
+           (   mcp_flag_name(Key, Flag),
+               catch(( term_string(Value, Text),
+                       set_prolog_flag(Key, Value),
+                       current_prolog_flag(Key, Now),
+                       format(string(NowText), "~q", [Now]),
+                       mcp_emit_json(Id, _{flag:Flag, value:NowText})
+                     ),
+                     Error,
+                     ( format(string(Message), "~q", [Error]),
+                       mcp_emit_json(Id, _{flag:Flag, error:Message})
+                     ))
+           )),
+    mcp_end(Id).
+
+mcp_flag_name(_:Flag, Flag) :- !.
+mcp_flag_name(Flag, Flag).
+
+%!  mcp_kb_graph(+Id, +Module, +Kind, +Max) is det.
+%
+%   Graph of the knowledge base in Module, for kb_graph. With Kind
+%   calls, one SOLUTION {"node": PI, "dynamic": Bool, "clauses": N} per
+%   user-defined predicate and {"from": PI, "to": PI} per call between
+%   them, found by walking clause bodies through control constructs and
+%   meta-predicate arguments. With Kind facts(Name, Arity), one
+%   {"from": Text, "to": Text, "label": Text} per clause of Name/Arity,
+%   linking its first argument to its second and labelled with the rest.
+%   At most Max edges are emitted.
+
+mcp_kb_graph(Id, Module, calls, Max) :-
+    catch(( forall(mcp_kb_predicate(Module, Head, PI),
+                   ( (   predicate_property(Module:Head, number_of_clauses(Count))
+                     ->  true
+                     ;   Count = 0
+                     ),
+                     (   predicate_property(Module:Head, dynamic)
+                     ->  Dynamic = true
+                     ;   Dynamic = false
+                     ),
+                     mcp_emit_json(Id, _{node:PI, dynamic:Dynamic, clauses:Count})
+                   )),
+            findall(From-To, mcp_kb_call_edge(Module, From, To), Edges0),
+            sort(Edges0, Edges),
+            forall(limit(Max, member(From-To, Edges)),
+                   mcp_emit_json(Id, _{from:From, to:To}))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+mcp_kb_graph(Id, Module, facts(Name, Arity), Max) :-
+    catch(( functor(Head, Name, Arity),
+            forall(limit(Max, clause(Module:Head, true)),
+                   ( Head =.. [_, From, To|Rest],
+                     format(string(FromText), "~q", [From]),
+                     format(string(ToText), "~q", [To]),
+                     (   Rest == []
+                     ->  Label = ""
+                     ;   Label0 =.. [Name|Rest],
+                         format(string(Label), "~q", [Label0])
+                     ),
+                     mcp_emit_json(Id, _{from:FromText, to:ToText, label:Label})
+                   ))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_kb_predicate(Module, Head, PI) :-
+    current_predicate(Module:Name/Arity),
+    \+ sub_atom(Name, 0, _, _, mcp_),
+    \+ sub_atom(Name, 0, _, _, '$'),
+    functor(Head, Name, Arity),
+    \+ predicate_property(Module:Head, imported_from(_)),
+    \+ predicate_property(Module:Head, built_in),
+    \+ predicate_property(Module:Head, foreign),
+    format(string(PI), "~w/~w", [Name, Arity]).
+
+mcp_kb_call_edge(Module, From, To) :-
+    mcp_kb_predicate(Module, Head, From),
+    \+ predicate_property(Module:Head, multifile),
+    clause(Module:Head, Body),
+    mcp_kb_body_call(Module, Body, Called),
+    callable(Called),
+    mcp_kb_predicate(Module, Called, To).
+
+mcp_kb_body_call(_, Goal, _) :-
+    var(Goal), !, fail.
+mcp_kb_body_call(Module, _:Goal, Called) :- !,
+    mcp_kb_body_call(Module, Goal, Called).
+mcp_kb_body_call(Module, _^Goal, Called) :- !,
+    mcp_kb_body_call(Module, Goal, Called).
+mcp_kb_body_call(Module, Goal, Called) :-
+    memberchk(Goal, [(_,_), (_;_), (_->_), (_*->_), \+(_)]), !,
+    arg(_, Goal, Sub),
+    mcp_kb_body_call(Module, Sub, Called).
+mcp_kb_body_call(Module, Goal, Called) :-
+    callable(Goal),
+    (   Called = Goal
+    ;   predicate_property(Module:Goal, meta_predicate(Spec)),
+        arg(I, Spec, S),
+        (   integer(S)
+        ->  Extra = S
+        ;   S == ^
+        ->  Extra = 0
+        ),
+        arg(I, Goal, Sub),
+        callable(Sub),
+        mcp_kb_extend(Sub, Extra, Goal1),
+        mcp_kb_body_call(Module, Goal1, Called)
+    ).
+
+mcp_kb_extend(Goal, 0, Goal) :- !.
+mcp_kb_extend(M:Goal, Extra, M:Goal1) :- !,
+    mcp_kb_extend(Goal, Extra, Goal1).
+mcp_kb_extend(Goal, Extra, Goal1) :-
+    Goal =.. List0,
+    length(Args, Extra),
+    append(List0, Args, List),
+    Goal1 =.. List.
+
+%!  mcp_retract_matching(+Id, +Module, +Text, +DryRun) is det.
+%
+%   Erase every clause of Module whose head unifies with the pattern
+%   Text, "Head" or "Head :- Body", for retract_matching, and emit one
+%   SOLUTION {"predicate": PI, "clause": Text} per clause, as
+%   portray_clause/1 writes it. With DryRun true nothing is erased.
+%   Only dynamic predicates are touched; a static one raises the
+%   permission error retract/1 would.
+
+mcp_retract_matching(Id, Module, Text, DryRun) :-
+    catch(( term_string(Pattern, Text),
+            (   Pattern = (Head :- Body)
+            ->  true
+            ;   Head = Pattern
+            ),
+            must_be(callable, Head),
+            functor(Head, Name, Arity),
+            (   predicate_property(Module:Head, defined),
+                \+ predicate_property(Module:Head, dynamic)
+            ->  permission_error(modify, static_procedure, Name/Arity)
+            ;   true
+            ),
+            format(string(PI), "~q/~w", [Name, Arity]),
+            findall(Ref, clause(Module:Head, Body, Ref), Refs),
+            forall(member(Ref, Refs),
+                   ( clause(ClauseHead, ClauseBody, Ref),
+                     mcp_clause_text(ClauseHead, ClauseBody, Clause),
+                     (   DryRun == true
+                     ->  true
+                     ;   erase(Ref)
+                     ),
+                     mcp_emit_json(Id, _{predicate:PI, clause:Clause})
+                   ))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_clause_text(Head0, Body, Text) :-
+    strip_module(Head0, _, Head),
+    (   Body == true
+    ->  Clause = Head
+    ;   Clause = (Head :- Body)
+    ),
+    with_output_to(string(Text0), portray_clause(Clause)),
+    split_string(Text0, "", "\n", [Text]).
+
+%!  mcp_kb_prune(+Id, +Module, +Goals, +DryRun) is det.
+%
+%   Abolish the user-defined predicates of Module that none of the
+%   entry points in Goals (texts of goals, or Name/Arity) can reach
+%   through the call graph of kb_graph, for kb_prune. Emits one SOLUTION {"predicate": PI,
+%   "clauses": N, "dynamic": Bool, "file": File} per unreachable
+%   predicate, with "error" set when it could not be abolished, then
+%   {"roots": [PI], "kept": N}. With DryRun true nothing is abolished.
+%   Multifile predicates are always kept, and everything is when no
+%   entry point names a predicate of Module.
+
+mcp_kb_prune(Id, Module, Goals, DryRun) :-
+    catch(( findall(PI,
+                    ( member(Text, Goals),
+                      term_string(Goal, Text),
+                      mcp_prune_root(Module, Goal, PI)
+                    ),
+                    Roots0),
+            sort(Roots0, Roots),
+            findall(From-To, mcp_kb_call_edge(Module, From, To), Edges0),
+            sort(Edges0, Edges),
+            mcp_reachable(Roots, Edges, Reachable),
+            findall(Head-PI,
+                    ( Roots \== [],
+                      mcp_kb_predicate(Module, Head, PI),
+                      \+ predicate_property(Module:Head, multifile),
+                      \+ memberchk(PI, Reachable)
+                    ),
+                    Unused),
+            forall(member(Head-PI, Unused),
+                   mcp_prune_predicate(Id, Module, Head, PI, DryRun)),
+            length(Reachable, Kept),
+            mcp_emit_json(Id, _{roots:Roots, kept:Kept})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_prune_root(Module, Name/Arity, PI) :-
+    atom(Name),
+    integer(Arity),
+    !,
+    functor(Head, Name, Arity),
+    mcp_kb_predicate(Module, Head, PI).
+mcp_prune_root(Module, Goal, PI) :-
+    mcp_kb_body_call(Module, Goal, Called),
+    callable(Called),
+    mcp_kb_predicate(Module, Called, PI).
+
+mcp_reachable(Roots, Edges, Reachable) :-
+    mcp_reachable(Roots, Edges, [], Reachable0),
+    sort(Reachable0, Reachable).
+
+mcp_reachable([], _, Seen, Seen).
+mcp_reachable([PI|Queue], Edges, Seen, Reachable) :-
+    (   memberchk(PI, Seen)
+    ->  mcp_reachable(Queue, Edges, Seen, Reachable)
+    ;   findall(To, member(PI-To, Edges), Next),
+        append(Queue, Next, Queue1),
+        mcp_reachable(Queue1, Edges, [PI|Seen], Reachable)
+    ).
+
+mcp_prune_predicate(Id, Module, Head, PI, DryRun) :-
+    (   predicate_property(Module:Head, number_of_clauses(Count))
+    ->  true
+    ;   Count = 0
+    ),
+    (   predicate_property(Module:Head, dynamic)
+    ->  Dynamic = true
+    ;   Dynamic = false
+    ),
+    (   predicate_property(Module:Head, file(File))
+    ->  true
+    ;   File = ""
+    ),
+    Info = _{predicate:PI, clauses:Count, dynamic:Dynamic, file:File},
+    (   DryRun == true
+    ->  mcp_emit_json(Id, Info)
+    ;   functor(Head, Name, Arity),
+        catch(( abolish(Module:Name/Arity),
+                mcp_emit_json(Id, Info)
+              ),
+              Error,
+              ( format(string(Message), "~q", [Error]),
+                put_dict(error, Info, Message, Failed),
+                mcp_emit_json(Id, Failed)
+              ))
+    ).
+
+%!  mcp_kb_clauses(+Id, +Source) is det.
+%
+%   Clauses of Source for kb_diff, one SOLUTION {"predicate": PI,
+%   "clause": Text, "line": N} each. Variables are renamed A, B, ... in
+%   order of appearance (singletons to _), so clauses that only differ in
+%   variable names have the same Text. Source is file(Path), read without
+%   loading it (directives are skipped, DCG rules translated), or
+%   loaded(Module, Path, PIs): the clauses now visible from Module of the
+%   predicates PIs and of those Path defined when it was consulted, with
+%   line 0.
+mcp_kb_clauses(Id, Source) :-
+    catch(forall(mcp_kb_source_clause(Source, Line, Clause),
+                 ( mcp_kb_normal_clause(Clause, PI, Text),
+                   mcp_emit_json(Id, _{predicate:PI, clause:Text, line:Line})
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_kb_source_clause(file(Path), Line, Clause) :-
+    setup_call_cleanup(open(Path, read, In),
+                       mcp_kb_read_clauses(In, Clauses),
+                       close(In)),
+    member(Line-Clause, Clauses).
+mcp_kb_source_clause(loaded(Module, Path, PIs0), 0, (Head :- Body)) :-
+    findall(PI, ( member(PI, PIs0) ; mcp_kb_file_predicate(Path, PI) ), PIs1),
+    sort(PIs1, PIs),
+    member(Name/Arity, PIs),
+    functor(Head, Name, Arity),
+    predicate_property(Module:Head, defined),
+    predicate_property(Module:Head, implementation_module(Impl)),
+    \+ predicate_property(Impl:Head, built_in),
+    \+ predicate_property(Impl:Head, foreign),
+    clause(Impl:Head, Body).
+
+mcp_kb_read_clauses(In, Clauses) :-
+    read_term(In, Term, [term_position(Pos)]),
+    (   Term == end_of_file
+    ->  Clauses = []
+    ;   stream_position_data(line_count, Pos, Line),
+        mcp_kb_expand(Term, Expanded),
+        findall(Line-Clause, member(Clause, Expanded), Here),
+        append(Here, Rest, Clauses),
+        mcp_kb_read_clauses(In, Rest)
+    ).
+
+mcp_kb_expand((:- _), []) :- !.
+mcp_kb_expand((Head --> Body), [Clause]) :- !,
+    dcg_translate_rule((Head --> Body), Clause).
+mcp_kb_expand(Term, [Term]).
+
+mcp_kb_file_predicate(Path, Name/Arity) :-
+    absolute_file_name(Path, File, [file_type(prolog), access(read), file_errors(fail)]),
+    source_file(_:Head, File),
+    functor(Head, Name, Arity).
+
+mcp_kb_normal_clause(Clause0, PI, Text) :-
+    strip_module(Clause0, _, Clause1),
+    (   Clause1 = (Head0 :- Body)
+    ->  true
+    ;   Head0 = Clause1,
+        Body = true
+    ),
+    strip_module(Head0, _, Head),
+    functor(Head, Name, Arity),
+    format(string(PI), "~w/~w", [Name, Arity]),
+    (   Body == true
+    ->  Normal = Head
+    ;   Normal = (Head :- Body)
+    ),
+    copy_term(Normal, Copy),
+    numbervars(Copy, 0, _, [singletons(true)]),
+    format(string(Text), "~W",
+           [Copy, [quoted(true), numbervars(true), portray(false), spacing(next_argument)]]).
+
+%!  mcp_kb_search(+Id, +Root, +Query, +Max) is det.
+%
+%   Search the files loaded from the directory Root for kb_search. Query
+%   is one of
+%
+%     - definitions: one SOLUTION {"predicate", "module", "file", "line",
+%       "clauses"} per predicate the files define (matched by name in
+%       the caller)
+%     - body(Text, File): {"predicate", "file", "line", "clause"} for the
+%       first Max clauses with a body subterm that unifies with the term
+%       Text; File is '' or the one path to search
+%     - comments: {"file", "line", "comment"} for every comment in the
+%       files, to be matched in the caller
+mcp_kb_search(Id, Root, Query, Max) :-
+    catch(mcp_kb_search_(Id, Root, Query, Max),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_kb_search_(Id, Root, definitions, _) :-
+    forall(mcp_kb_data_predicate(Root, File, M:Head),
+           ( functor(Head, Name, Arity),
+             format(string(PI), "~w/~w", [Name, Arity]),
+             findall(Line, mcp_kb_clause_line(M:Head, File, _, Line), Lines),
+             (   Lines = [First|_]
+             ->  true
+             ;   First = 0
+             ),
+             length(Lines, Count),
+             mcp_emit_json(Id, _{predicate:PI, module:M, file:File, line:First, clauses:Count})
+           )).
+mcp_kb_search_(Id, Root, body(Text, Only), Max) :-
+    term_string(Pattern, Text),
+    forall(limit(Max, ( mcp_kb_data_predicate(Root, File, M:Head),
+                        ( Only == '' -> true ; File == Only ),
+                        mcp_kb_clause_line(M:Head, File, Ref, Line),
+                        clause(M:H, Body, Ref),
+                        once(( sub_term(Sub, Body), nonvar(Sub), \+ Sub \= Pattern ))
+                      )),
+           ( mcp_kb_normal_clause((H :- Body), PI, Clause),
+             mcp_emit_json(Id, _{predicate:PI, file:File, line:Line, clause:Clause})
+           )).
+mcp_kb_search_(Id, Root, comments, _) :-
+    forall(( mcp_kb_data_file(Root, File),
+             mcp_kb_file_comment(File, Line, Comment)
+           ),
+           mcp_emit_json(Id, _{file:File, line:Line, comment:Comment})).
+
+mcp_kb_data_file(Root, File) :-
+    atom_concat(Root, '/', Prefix),
+    source_file(File),
+    sub_atom(File, 0, _, _, Prefix).
+
+mcp_kb_data_predicate(Root, File, M:Head) :-
+    mcp_kb_data_file(Root, File),
+    source_file(M:Head, File),
+    \+ predicate_property(M:Head, imported_from(_)).
+
+mcp_kb_clause_line(M:Head, File, Ref, Line) :-
+    nth_clause(M:Head, _, Ref),
+    clause_property(Ref, file(File)),
+    clause_property(Ref, line_count(Line)).
+
+mcp_kb_file_comment(File, Line, Comment) :-
+    setup_call_cleanup(open(File, read, In),
+                       mcp_kb_read_comments(In, Comments),
+                       close(In)),
+    member(Pos-Comment, Comments),
+    stream_position_data(line_count, Pos, Line).
+
+%   Reading stops at the first syntax error, keeping what was read so far.
+
+mcp_kb_read_comments(In, Comments) :-
+    catch(read_term(In, Term, [comments(Here)]), _, Term = end_of_file),
+    (   var(Here)
+    ->  Here = []
+    ;   true
+    ),
+    (   Term == end_of_file
+    ->  Comments = Here
+    ;   append(Here, Rest, Comments),
+        mcp_kb_read_comments(In, Rest)
+    ).
+
+%!  mcp_clause_spans(+Id, +Path, +Clause, +Head) is det.
+%
+%   Where the clauses of the file Path are, for the clause edit tools: one
+%   SOLUTION {"predicate": PI, "start": S, "end": E, "line": L, "matches":
+%   Bool} per clause, in source order. S and E are the character offsets
+%   of the clause term without its full stop; directives are left out.
+%   matches tells whether the clause head unifies with the term Head ('' to
+%   match nothing). Unless Clause is '', the new clause is parsed as well
+%   and reported last as {"predicate": PI, "new": true}.
+mcp_clause_spans(Id, Path, Clause, Head) :-
+    catch(( mcp_clause_pattern(Head, Pattern),
+            setup_call_cleanup(open(Path, read, In, [encoding(utf8)]),
+                               mcp_clause_emit_spans(Id, In, Pattern),
+                               close(In)),
+            (   Clause == ''
+            ->  true
+            ;   term_string(New, Clause),
+                (   mcp_clause_pi(New, PI)
+                ->  mcp_emit_json(Id, _{predicate:PI, new:true})
+                ;   domain_error(clause, New)
+                )
+            )
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_clause_pattern('', none) :- !.
+mcp_clause_pattern(Text, head(Head)) :-
+    term_string(Head, Text).
+
+mcp_clause_emit_spans(Id, In, Pattern) :-
+    read_term(In, Term, [subterm_positions(Pos), term_position(TermPos)]),
+    (   Term == end_of_file
+    ->  true
+    ;   (   mcp_clause_pi(Term, PI)
+        ->  arg(1, Pos, Start),
+            arg(2, Pos, End),
+            stream_position_data(line_count, TermPos, Line),
+            (   mcp_clause_matches(Term, Pattern)
+            ->  Matches = true
+            ;   Matches = false
+            ),
+            mcp_emit_json(Id, _{predicate:PI, start:Start, end:End, line:Line, matches:Matches})
+        ;   true
+        ),
+        mcp_clause_emit_spans(Id, In, Pattern)
+    ).
+
+mcp_clause_pi(Term, PI) :-
+    mcp_clause_head(Term, Head, Extra),
+    functor(Head, Name, Arity0),
+    Arity is Arity0 + Extra,
+    format(string(PI), "~w/~w", [Name, Arity]).
+
+%   The head of a clause, and the arguments DCG translation adds to it.
+
+mcp_clause_head(Term, _, _) :-
+    var(Term), !, fail.
+mcp_clause_head((:- _), _, _) :- !, fail.
+mcp_clause_head((?- _), _, _) :- !, fail.
+mcp_clause_head((Head0 --> _), Head, 2) :- !,
+    (   nonvar(Head0), Head0 = (Head1, _)
+    ->  true
+    ;   Head1 = Head0
+    ),
+    strip_module(Head1, _, Head),
+    callable(Head).
+mcp_clause_head((Head0 :- _), Head, 0) :- !,
+    strip_module(Head0, _, Head),
+    callable(Head).
+mcp_clause_head(Head0, Head, 0) :-
+    strip_module(Head0, _, Head),
+    callable(Head).
+
+mcp_clause_matches(_, none) :- !, fail.
+mcp_clause_matches(Term, head(Pattern)) :-
+    mcp_clause_head(Term, Head, 0),
+    strip_module(Pattern, _, Wanted),
+    \+ Head \= Wanted.
+
+%!  mcp_cache_deps(+Id, +Text) is det.
+%
+%   Dynamic predicates the goal Text can reach through the clauses of the
+%   predicates it calls, for the query cache. Emits one SOLUTION
+%   {"predicate": "Module:Name/Arity"} per dependency, then {"opaque":
+%   Bool}: true when a call could not be followed (a goal only known at
+%   runtime) or the program is too large, so the result must not be
+%   cached. Each dependency gets a prolog_listen/2 hook that prints
+%   "@MCP cache INVALIDATE Module:Name/Arity" whenever a clause is added
+%   to or removed from it, whichever query does so.
+
+:- dynamic mcp_cache_watched/1.
+
+mcp_cache_deps(Id, Text) :-
+    catch(( term_string(Goal, Text),
+            mcp_cache_walk([user:Goal], [], [], Deps, Opaque),
+            forall(member(Dep, Deps),
+                   ( mcp_cache_watch(Dep),
+                     format(string(PI), "~q", [Dep]),
+                     mcp_emit_json(Id, _{predicate:PI})
+                   )),
+            mcp_emit_json(Id, _{opaque:Opaque})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_cache_walk([], _, Deps, Deps, false).
+mcp_cache_walk([M:Goal|Todo], Seen, Deps0, Deps, Opaque) :-
+    findall(Called, mcp_cache_call(M, Goal, Called), Calls),
+    length(Seen, Visited),
+    (   (   memberchk(opaque, Calls)
+        ;   Visited > 2000
+        )
+    ->  Deps = Deps0,
+        Opaque = true
+    ;   mcp_cache_visit(Calls, Todo, Todo1, Seen, Seen1, Deps0, Deps1),
+        mcp_cache_walk(Todo1, Seen1, Deps1, Deps, Opaque)
+    ).
+
+mcp_cache_call(_, Goal, opaque) :-
+    var(Goal), !.
+mcp_cache_call(Module, M:Goal, Called) :- !,
+    (   atom(M)
+    ->  mcp_cache_call(M, Goal, Called)
+    ;   Called = opaque
+    ).
+mcp_cache_call(Module, _^Goal, Called) :- !,
+    mcp_cache_call(Module, Goal, Called).
+mcp_cache_call(Module, Goal, Called) :-
+    memberchk(Goal, [(_,_), (_;_), (_->_), (_*->_), \+(_)]), !,
+    arg(_, Goal, Sub),
+    mcp_cache_call(Module, Sub, Called).
+mcp_cache_call(Module, Goal, Called) :-
+    callable(Goal),
+    (   Called = Module:call(Goal)
+    ;   predicate_property(Module:Goal, meta_predicate(Spec)),
+        arg(I, Spec, S),
+        (   integer(S)
+        ->  Extra = S
+        ;   S == ^
+        ->  Extra = 0
+        ),
+        arg(I, Goal, Sub),
+        (   callable(Sub)
+        ->  mcp_kb_extend(Sub, Extra, Goal1),
+            mcp_cache_call(Module, Goal1, Called)
+        ;   Called = opaque
+        )
+    ).
+
+mcp_cache_visit([], Todo, Todo, Seen, Seen, Deps, Deps).
+mcp_cache_visit([M:call(Head)|Calls], Todo0, Todo, Seen0, Seen, Deps0, Deps) :-
+    (   predicate_property(M:Head, implementation_module(Impl))
+    ->  true
+    ;   Impl = M
+    ),
+    functor(Head, Name, Arity),
+    Key = Impl:Name/Arity,
+    (   memberchk(Key, Seen0)
+    ->  Todo1 = Todo0,
+        Seen1 = Seen0,
+        Deps1 = Deps0
+    ;   Seen1 = [Key|Seen0],
+        (   predicate_property(Impl:Head, dynamic)
+        ->  Deps1 = [Key|Deps0]
+        ;   Deps1 = Deps0
+        ),
+        (   mcp_cache_opens(Impl, Head)
+        ->  findall(Impl:Body, catch(clause(Impl:Head, Body), _, fail), Bodies),
+            append(Bodies, Todo0, Todo1)
+        ;   Todo1 = Todo0
+        )
+    ),
+    mcp_cache_visit(Calls, Todo1, Todo, Seen1, Seen, Deps1, Deps).
+
+% Only user code is walked; libraries do not depend on the knowledge base
+mcp_cache_opens(Impl, Head) :-
+    \+ predicate_property(Impl:Head, built_in),
+    \+ predicate_property(Impl:Head, foreign),
+    \+ ( module_property(Impl, class(Class)),
+         memberchk(Class, [system, library])
+       ).
+
+mcp_cache_watch(Key) :-
+    mcp_cache_watched(Key), !.
+mcp_cache_watch(Impl:Name/Arity) :-
+    functor(Head, Name, Arity),
+    prolog_listen(Impl:Head, mcp_cache_changed(Impl:Name/Arity)),
+    assertz(mcp_cache_watched(Impl:Name/Arity)).
+
+% Printed on user_output so it is seen even inside with_output_to/2
+mcp_cache_changed(Key, _Event) :-
+    format(user_output, "@MCP cache INVALIDATE ~q~n", [Key]),
+    flush_output(user_output).
+
+%!  mcp_feed_watch(+Id, +Feed, +Module, +Indicator, +Pattern) is det.
+%
+%   Hook the dynamic predicate Indicator ("Name/Arity", resolved in
+%   Module) for the fact feed Feed, so that every fact asserted to or
+%   retracted from it whose head unifies with Pattern (a head text, or ""
+%   for any) prints "@MCP feed FACT Json" with Json {"feed": Feed,
+%   "action": "asserted" | "retracted", "fact": Text, "args": [Term]},
+%   whichever query makes the change. A predicate that does not exist yet
+%   is declared dynamic. Emits SOLUTION {"predicate": "Module:Name/Arity",
+%   "created": Bool}. Watching a feed again replaces its hook.
+
+:- dynamic mcp_feed_hook/3.
+
+mcp_feed_watch(Id, Feed, Module, Indicator, PatternText) :-
+    catch(( term_string(Name/Arity, Indicator),
+            must_be(atom, Name),
+            must_be(nonneg, Arity),
+            functor(Head, Name, Arity),
+            (   PatternText == ""
+            ->  Pattern = Head
+            ;   term_string(Pattern, PatternText),
+                (   functor(Pattern, Name, Arity)
+                ->  true
+                ;   domain_error(Indicator, Pattern)
+                )
+            ),
+            (   predicate_property(Module:Head, defined)
+            ->  Created = false
+            ;   dynamic(Module:Name/Arity),
+                Created = true
+            ),
+            (   predicate_property(Module:Head, implementation_module(Impl))
+            ->  true
+            ;   Impl = Module
+            ),
+            (   predicate_property(Impl:Head, dynamic)
+            ->  true
+            ;   permission_error(watch, static_procedure, Impl:Name/Arity)
+            ),
+            mcp_feed_unhook(Feed),
+            Closure = mcp_feed_changed(Feed, Pattern),
+            prolog_listen(Impl:Head, Closure),
+            assertz(mcp_feed_hook(Feed, Impl:Head, Closure)),
+            format(string(PI), "~q", [Impl:Name/Arity]),
+            mcp_emit_json(Id, _{predicate:PI, created:Created})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_feed_unwatch(+Id, +Feed) is det.
+%
+%   Remove the hook of fact feed Feed, if it has one.
+
+mcp_feed_unwatch(Id, Feed) :-
+    mcp_feed_unhook(Feed),
+    mcp_end(Id).
+
+mcp_feed_unhook(Feed) :-
+    forall(retract(mcp_feed_hook(Feed, Head, Closure)),
+           prolog_unlisten(Head, Closure)).
+
+% Printed on user_output so it is seen even inside with_output_to/2;
+% rules added to the predicate are not facts and are not reported
+mcp_feed_changed(Feed, Pattern, Event) :-
+    Event =.. [Action, Ref],
+    mcp_feed_action(Action, Change),
+    catch(clause(Qualified, true, Ref), _, fail),
+    strip_module(Qualified, _, Fact),
+    \+ Fact \= Pattern,
+    !,
+    Fact =.. [_|Args],
+    maplist(mcp_term_json, Args, Json),
+    format(string(Text), "~q", [Fact]),
+    with_output_to(string(Line),
+                   json_write_dict(current_output,
+                                   _{feed:Feed, action:Change, fact:Text, args:Json},
+                                   [width(0)])),
+    format(user_output, "@MCP feed FACT ~w~n", [Line]),
+    flush_output(user_output).
+mcp_feed_changed(_, _, _).
+
+mcp_feed_action(asserta, asserted).
+mcp_feed_action(assertz, asserted).
+mcp_feed_action(retract, retracted).
+mcp_feed_action(erase, retracted).
+
+%!  mcp_store_load(+Id, +Module, +Indicator, +Key, +Replace, +Facts) is det.
+%
+%   Mirror the dynamic predicate Indicator ("Name/Arity", resolved in
+%   Module) to the shared fact store under Key (see fact_store.py), and
+%   assert the fact texts Facts into it; with Replace true its clauses
+%   are retracted first. Neither is reported back to the store. From
+%   then on every fact asserted to or retracted from the predicate
+%   outside a transaction prints "@MCP store STORE Json" with Json
+%   {"predicate": Key, "module": Impl, "action": "asserted" |
+%   "retracted", "fact": Text}, Impl the module holding the clauses and
+%   Text written canonically. A predicate that does not exist yet is
+%   declared dynamic. Emits SOLUTION {"predicate": "Module:Name/Arity",
+%   "loaded": Count}.
+
+:- dynamic mcp_store_hook/3.
+
+mcp_store_load(Id, Module, Indicator, Key, Replace, Facts) :-
+    catch(( term_string(Name/Arity, Indicator),
+            must_be(atom, Name),
+            must_be(nonneg, Arity),
+            functor(Head, Name, Arity),
+            (   predicate_property(Module:Head, defined)
+            ->  true
+            ;   dynamic(Module:Name/Arity)
+            ),
+            (   predicate_property(Module:Head, implementation_module(Impl))
+            ->  true
+            ;   Impl = Module
+            ),
+            (   predicate_property(Impl:Head, dynamic)
+            ->  true
+            ;   permission_error(store, static_procedure, Impl:Name/Arity)
+            ),
+            setup_call_cleanup(nb_setval(mcp_store_quiet, true),
+                               mcp_store_assert(Impl, Head, Replace, Facts),
+                               nb_setval(mcp_store_quiet, false)),
+            (   mcp_store_hook(Key, Impl:Head, _)
+            ->  true
+            ;   Closure = mcp_store_changed(Key),
+                prolog_listen(Impl:Head, Closure),
+                assertz(mcp_store_hook(Key, Impl:Head, Closure))
+            ),
+            length(Facts, Count),
+            format(string(PI), "~q", [Impl:Name/Arity]),
+            mcp_emit_json(Id, _{predicate:PI, loaded:Count})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_store_assert(Impl, Head, Replace, Facts) :-
+    (   Replace == true
+    ->  retractall(Impl:Head)
+    ;   true
+    ),
+    forall(member(Text, Facts),
+           ( term_string(Fact, Text),
+             (   Fact = Head
+             ->  assertz(Impl:Fact)
+             ;   type_error(Head, Fact)
+             )
+           )).
+
+%!  mcp_store_facts(+Id, +Module, +Indicator) is det.
+%
+%   The facts of Indicator in Module, written as mcp_store_load/6 reads
+%   them: SOLUTION {"fact": Text} each, in order. A predicate that does
+%   not exist has none.
+
+mcp_store_facts(Id, Module, Indicator) :-
+    catch(( term_string(Name/Arity, Indicator),
+            functor(Head, Name, Arity),
+            forall(( predicate_property(Module:Head, defined),
+                     clause(Module:Head, true)
+                   ),
+                   ( format(string(Text), "~k", [Head]),
+                     mcp_emit_json(Id, _{fact:Text})
+                   ))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_store_unwatch(+Id, +Key) is det.
+%
+%   Stop mirroring the predicates stored under Key; their facts stay.
+
+mcp_store_unwatch(Id, Key) :-
+    forall(retract(mcp_store_hook(Key, Head, Closure)),
+           prolog_unlisten(Head, Closure)),
+    mcp_end(Id).
+
+% Changes inside a transaction may still be rolled back; query_batch
+% copies the predicates to the store once its transaction is committed
+mcp_store_changed(Key, Event) :-
+    \+ nb_current(mcp_store_quiet, true),
+    \+ current_transaction(_),
+    Event =.. [Action, Ref],
+    mcp_feed_action(Action, Change),
+    catch(clause(Qualified, true, Ref), _, fail),
+    !,
+    strip_module(Qualified, Module, Fact),
+    format(string(Text), "~k", [Fact]),
+    with_output_to(string(Line),
+                   json_write_dict(current_output,
+                                   _{predicate:Key, module:Module, action:Change, fact:Text},
+                                   [width(0)])),
+    format(user_output, "@MCP store STORE ~w~n", [Line]),
+    flush_output(user_output).
+mcp_store_changed(_, _).
+
+%!  mcp_lint(+Id, +Path) is det.
+%
+%   Load Path with the singleton, discontiguous, no_effect and
+%   var_branches style checks on, then run check/0, for lint_program.
+%   Each warning and error printed meanwhile is intercepted and emitted
+%   as SOLUTION {"severity": Kind, "message": Text, "file": File,
+%   "line": Line}; file is "" and line 0 when the message is not about
+%   the term being loaded. Loading runs the file's directives, so this is
+%   meant for a fresh process (see lint.py), not the persistent session.
+
+:- multifile user:message_hook/3.
+
+user:message_hook(Term, Kind, Lines) :-
+    nb_current(mcp_lint, Id),
+    Id \== [],
+    memberchk(Kind, [error, warning]),
+    mcp_lint_message(Id, Term, Kind, Lines).
+% Remembers the first error printed while a startup program loads, see
+% mcp_startup/3, and lets it be printed as usual
+user:message_hook(_Term, error, Lines) :-
+    nb_current(mcp_startup_error, ""),
+    with_output_to(string(Text0), print_message_lines(current_output, '', Lines)),
+    split_string(Text0, "", " \n", [Text]),
+    nb_setval(mcp_startup_error, Text),
+    fail.
+% Intercepts the messages printed while mcp_safe_consult/5 loads a file
+user:message_hook(Term, Kind, Lines) :-
+    nb_current(mcp_quarantine, q(Id, Phase, File)),
+    memberchk(Kind, [error, warning]),
+    mcp_quarantine_message(Id, Phase, File, Term, Kind, Lines).
+
+mcp_lint(Id, Path) :-
+    catch(setup_call_cleanup(nb_setval(mcp_lint, Id),
+                             mcp_lint_run(Path),
+                             nb_setval(mcp_lint, [])),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_lint_run(Path) :-
+    style_check(+singleton),
+    style_check(+discontiguous),
+    style_check(+no_effect),
+    style_check(+var_branches),
+    catch(load_files(Path, [if(true)]), Error, print_message(error, Error)),
+    check.
+
+mcp_lint_message(Id, Term, Kind, Lines) :-
+    mcp_lint_location(Term, File, Line),
+    with_output_to(string(Text0), print_message_lines(current_output, '', Lines)),
+    split_string(Text0, "", " \n", [Text]),
+    mcp_emit_json(Id, _{severity:Kind, message:Text, file:File, line:Line}).
+
+mcp_lint_location(error(_, file(File, Line, _, _)), File, Line) :- !.
+mcp_lint_location(_, File, Line) :-
+    source_location(File, Line), !.
+mcp_lint_location(_, "", 0).
+
+%!  mcp_safe_consult(+Id, +Module, +Path, +Scratch, +Text) is det.
+%
+%   Consult Path into Module only if it loads without errors, see
+%   quarantine.py. Text, the content of Path with its module header
+%   renamed to Scratch, is first loaded into Scratch as the source
+%   .mcp-quarantine-<Base> next to Path, and each warning and error
+%   printed meanwhile emitted as SOLUTION {"severity": Kind, "message":
+%   Text, "line": Line, "phase": "check"}. A file the copy loads that is
+%   already loaded into another module only gives a warning. If there
+%   were no errors, the checked load is swapped in: Path is compiled
+%   into Module with the goals of its directives, which ran in the check
+%   already, blanked out, its messages emitted with phase "consult", and
+%   the clauses those goals asserted into Scratch are copied over. The copy is unloaded either way. Ends with SOLUTION
+%   {"loaded": Bool}.
+
+mcp_safe_consult(Id, Module, Path, Scratch, Text) :-
+    catch(( mcp_quarantine_copy(Path, Copy),
+            setup_call_cleanup(true,
+                               ( mcp_quarantine_compile(Id, Copy, Scratch, Text, Errors),
+                                 (   Errors =:= 0
+                                 ->  mcp_quarantine_load(Id, Module, Path, Scratch),
+                                     Loaded = true
+                                 ;   Loaded = false
+                                 )
+                               ),
+                               catch(unload_file(Copy), _, true)),
+            mcp_emit_json(Id, _{loaded:Loaded})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_quarantine_check(Id, Path, Scratch, Text, Errors) :-
+    mcp_quarantine_copy(Path, Copy),
+    setup_call_cleanup(true,
+                       mcp_quarantine_compile(Id, Copy, Scratch, Text, Errors),
+                       catch(unload_file(Copy), _, true)).
+
+mcp_quarantine_copy(Path, Copy) :-
+    file_directory_name(Path, Dir),
+    file_base_name(Path, Base),
+    atomic_list_concat([Dir, '/.mcp-quarantine-', Base], Copy).
+
+mcp_quarantine_compile(Id, Copy, Scratch, Text, Errors) :-
+    nb_setval(mcp_quarantine_errors, 0),
+    setup_call_cleanup(( open_string(Text, In),
+                         nb_setval(mcp_quarantine, q(Id, check, Copy))
+                       ),
+                       catch(load_files(Scratch:Copy, [stream(In), if(true), silent(true)]),
+                             Error, print_message(error, Error)),
+                       ( nb_setval(mcp_quarantine, []),
+                         close(In)
+                       )),
+    nb_getval(mcp_quarantine_errors, Errors).
+
+mcp_quarantine_load(Id, Module, Path, Scratch) :-
+    read_file_to_string(Path, Source, []),
+    mcp_quarantine_declarations(Source, Scratch, Declarations),
+    setup_call_cleanup(( open_string(Declarations, In),
+                         nb_setval(mcp_quarantine, q(Id, consult, Path))
+                       ),
+                       catch(load_files(Module:Path, [stream(In), if(true)]),
+                             Error, print_message(error, Error)),
+                       ( nb_setval(mcp_quarantine, []),
+                         close(In)
+                       )),
+    (   source_file_property(Path, module(Target))
+    ->  true
+    ;   Target = Module
+    ),
+    mcp_quarantine_state(Scratch, Target).
+
+%   Source with the goal of each directive blanked out to "!", so that
+%   only the declarations run again; every clause keeps its line.
+
+mcp_quarantine_declarations(Source, Scratch, Text) :-
+    setup_call_cleanup(open_string(Source, In),
+                       mcp_quarantine_goals(In, Scratch, Ranges),
+                       close(In)),
+    string_codes(Source, Codes0),
+    mcp_quarantine_blank(Codes0, 0, Ranges, Codes),
+    string_codes(Text, Codes).
+
+% The file's operators were declared in Scratch as the check loaded it
+mcp_quarantine_goals(In, Scratch, Ranges) :-
+    catch(read_term(In, Term, [module(Scratch), subterm_positions(Pos)]), _, Term = end_of_file),
+    (   Term == end_of_file
+    ->  Ranges = []
+    ;   mcp_quarantine_goal(Term, Pos, Range)
+    ->  Ranges = [Range|Rest],
+        mcp_quarantine_goals(In, Scratch, Rest)
+    ;   mcp_quarantine_goals(In, Scratch, Ranges)
+    ).
+
+mcp_quarantine_goal(Directive, term_position(_, _, _, _, [GoalPos]), From-To) :-
+    (   Directive = (:- Goal)
+    ;   Directive = (?- Goal)
+    ),
+    nonvar(Goal),
+    \+ mcp_quarantine_declaration(Goal),
+    arg(1, GoalPos, From),
+    arg(2, GoalPos, To).
+
+mcp_quarantine_declaration(_:Goal) :-
+    nonvar(Goal),
+    mcp_quarantine_declaration(Goal).
+mcp_quarantine_declaration(module(_, _)).
+mcp_quarantine_declaration(use_module(_)).
+mcp_quarantine_declaration(use_module(_, _)).
+mcp_quarantine_declaration(ensure_loaded(_)).
+mcp_quarantine_declaration(include(_)).
+mcp_quarantine_declaration(encoding(_)).
+mcp_quarantine_declaration(op(_, _, _)).
+mcp_quarantine_declaration(set_prolog_flag(_, _)).
+mcp_quarantine_declaration(style_check(_)).
+mcp_quarantine_declaration(dynamic(_)).
+mcp_quarantine_declaration(discontiguous(_)).
+mcp_quarantine_declaration(multifile(_)).
+mcp_quarantine_declaration(table(_)).
+mcp_quarantine_declaration(thread_local(_)).
+mcp_quarantine_declaration(meta_predicate(_)).
+mcp_quarantine_declaration(module_transparent(_)).
+mcp_quarantine_declaration(public(_)).
+mcp_quarantine_declaration(det(_)).
+
+mcp_quarantine_blank([], _, _, []).
+mcp_quarantine_blank([C0|Cs0], I, Ranges, Codes) :-
+    (   Ranges = [_-To|Rest],
+        I >= To
+    ->  mcp_quarantine_blank([C0|Cs0], I, Rest, Codes)
+    ;   Ranges = [From-_|_],
+        I >= From
+    ->  (   I =:= From
+        ->  C = 0'!
+        ;   C0 =:= 0'\n
+        ->  C = C0
+        ;   C = 0'\s
+        ),
+        Codes = [C|Cs],
+        I1 is I + 1,
+        mcp_quarantine_blank(Cs0, I1, Ranges, Cs)
+    ;   Codes = [C0|Cs],
+        I1 is I + 1,
+        mcp_quarantine_blank(Cs0, I1, Ranges, Cs)
+    ).
+
+% The clauses the file's directives added to Scratch's dynamic predicates
+mcp_quarantine_state(Scratch, Target) :-
+    forall(( current_predicate(_, Scratch:Head),
+             predicate_property(Scratch:Head, implementation_module(Scratch)),
+             predicate_property(Scratch:Head, dynamic),
+             clause(Scratch:Head, Body, Ref),
+             \+ clause_property(Ref, file(_))
+           ),
+           assertz(Target:(Head :- Body))).
+
+mcp_quarantine_message(Id, Phase, File, Term, Kind0, Lines) :-
+    (   Phase == check,
+        Term = error(permission_error(load, source, _), _)
+    ->  Kind = warning
+    ;   Kind = Kind0
+    ),
+    (   Kind == error
+    ->  nb_getval(mcp_quarantine_errors, Errors0),
+        Errors is Errors0 + 1,
+        nb_setval(mcp_quarantine_errors, Errors)
+    ;   true
+    ),
+    mcp_quarantine_line(Term, File, Line),
+    with_output_to(string(Text0), print_message_lines(current_output, '', Lines)),
+    split_string(Text0, "", " \n", [Text]),
+    mcp_emit_json(Id, _{severity:Kind, message:Text, line:Line, phase:Phase}).
+
+mcp_quarantine_line(error(_, stream(_, Line, _, _)), _, Line) :- !.
+mcp_quarantine_line(Term, File, Line) :-
+    mcp_lint_location(Term, At, Line0),
+    (   At == File
+    ->  Line = Line0
+    ;   Line = 0
+    ).
+
+%!  mcp_kb_schema(+Id, +Module, +Examples) is det.
+%
+%   What a model drafting rules for Module (see drafting.py) needs to
+%   know about it: one SOLUTION {"predicate": PI, "dynamic": Bool,
+%   "clauses": N, "examples": [Text]} per user predicate, with the first
+%   Examples clauses of each as portray_clause/1 writes them.
+
+mcp_kb_schema(Id, Module, Examples) :-
+    catch(forall(mcp_kb_predicate(Module, Head, PI),
+                 ( (   predicate_property(Module:Head, number_of_clauses(Count))
+                   ->  true
+                   ;   Count = 0
+                   ),
+                   (   predicate_property(Module:Head, dynamic)
+                   ->  Dynamic = true
+                   ;   Dynamic = false
+                   ),
+                   findall(Text,
+                           ( limit(Examples, catch(clause(Module:Head, Body), _, fail)),
+                             mcp_schema_clause(Head, Body, Text)
+                           ),
+                           Texts),
+                   mcp_emit_json(Id, _{predicate:PI, dynamic:Dynamic, clauses:Count, examples:Texts})
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_schema_clause(Head, true, Text) :- !,
+    with_output_to(string(Text0), portray_clause(Head)),
+    split_string(Text0, "", " \n", [Text]).
+mcp_schema_clause(Head, Body, Text) :-
+    with_output_to(string(Text0), portray_clause((Head :- Body))),
+    split_string(Text0, "", " \n", [Text]).
+
+%!  mcp_rules_export(+Id, +Module, +Max) is det.
+%
+%   The clauses of Module for export_rules (see rule_export.py) to
+%   translate: one SOLUTION {"predicate": PI, "name": Name, "arity":
+%   Arity, "count": N, "clauses": [{"head": Json, "body": Json, "text":
+%   Text}]} per user predicate, with at most Max of its N clauses as
+%   mcp_term_json/2 terms. Variables keep one name within a clause.
+
+mcp_rules_export(Id, Module, Max) :-
+    catch(forall(mcp_kb_predicate(Module, Head, PI),
+                 ( functor(Head, Name, Arity),
+                   (   predicate_property(Module:Head, number_of_clauses(Count))
+                   ->  true
+                   ;   Count = 0
+                   ),
+                   findall(_{head:HeadJson, body:BodyJson, text:Text},
+                           ( limit(Max, catch(clause(Module:Head, Body), _, fail)),
+                             mcp_term_json(Head, HeadJson),
+                             mcp_term_json(Body, BodyJson),
+                             mcp_schema_clause(Head, Body, Text)
+                           ),
+                           Clauses),
+                   atom_string(Name, NameText),
+                   mcp_emit_json(Id, _{predicate:PI, name:NameText, arity:Arity,
+                                       count:Count, clauses:Clauses})
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_draft_check(+Id, +Scratch, +Candidates) is det.
+%
+%   Load each of the drafted programs Candidates (strings) into Scratch
+%   the way mcp_safe_consult/5 checks a file, emitting its messages as
+%   SOLUTION {"severity": Kind, "message": Text, "line": Line, "phase":
+%   "check"} and then {"candidate": N, "errors": Count}, N counting from
+%   1. Nothing is kept: each is unloaded before the next is loaded.
+
+mcp_draft_check(Id, Scratch, Candidates) :-
+    catch(forall(nth1(N, Candidates, Text),
+                 ( format(atom(Path), '/tmp/mcp-draft/candidate-~d.pl', [N]),
+                   mcp_quarantine_check(Id, Path, Scratch, Text, Errors),
+                   mcp_emit_json(Id, _{candidate:N, errors:Errors})
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_startup(+Id, +Items, +Policy) is det.
+%
+%   Load the startup programs Items in order when the session starts
+%   (see startup.py): file(Path) is consulted into user and code(Name,
+%   Text) loaded into user as the source Name. Emits one SOLUTION
+%   {"item": Name, "error": Text} per item, with error "" when it loaded;
+%   an exception or an error message printed while loading is a failure.
+%   With Policy abort nothing after the first failure is loaded.
+
+mcp_startup(Id, Items, Policy) :-
+    catch(mcp_startup_items(Id, Items, Policy),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_startup_items(_, [], _).
+mcp_startup_items(Id, [Item|Items], Policy) :-
+    mcp_startup_load(Item, Name, Error),
+    mcp_emit_json(Id, _{item:Name, error:Error}),
+    (   Error \== "", Policy == abort
+    ->  true
+    ;   mcp_startup_items(Id, Items, Policy)
+    ).
+
+mcp_startup_load(Item, Name, Error) :-
+    mcp_startup_name(Item, Name),
+    setup_call_cleanup(nb_setval(mcp_startup_error, ""),
+                       ( catch(mcp_startup_consult(Item), E, true),
+                         (   var(E)
+                         ->  nb_getval(mcp_startup_error, Error)
+                         ;   format(string(Error), "~q", [E])
+                         )
+                       ),
+                       nb_setval(mcp_startup_error, [])).
+
+mcp_startup_consult(file(Path)) :-
+    load_files(user:Path, [if(true)]).
+mcp_startup_consult(code(Name, Text)) :-
+    setup_call_cleanup(open_string(Text, In),
+                       load_files(user:Name, [stream(In)]),
+                       close(In)).
+
+mcp_startup_name(file(Path), Path).
+mcp_startup_name(code(Name, _), Name).
+
+%!  mcp_syntax_check(+Id, +Text) is det.
+%
+%   Read Text as the terms of a file, without loading it, and emit one
+%   SOLUTION {"line": Line, "message": Text} per syntax error, for
+%   write_resource. Nothing runs, except that op/3 directives (and the
+%   operators a module/2 header exports) take effect in a temporary
+%   module, so later clauses read as they would when consulted.
+
+mcp_syntax_check(Id, Text) :-
+    catch(in_temporary_module(Module,
+                              true,
+                              setup_call_cleanup(open_string(Text, In),
+                                                 mcp_syntax_terms(Id, In, Module),
+                                                 close(In))),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_syntax_terms(Id, In, Module) :-
+    character_count(In, Start),
+    catch(read_term(In, Term, [module(Module), syntax_errors(error)]), Error, true),
+    (   nonvar(Error)
+    ->  mcp_syntax_report(Id, Error),
+        character_count(In, End),
+        (   End > Start
+        ->  mcp_syntax_terms(Id, In, Module)
+        ;   true
+        )
+    ;   Term == end_of_file
+    ->  true
+    ;   mcp_syntax_directive(Term, Module),
+        mcp_syntax_terms(Id, In, Module)
+    ).
+
+mcp_syntax_report(Id, error(syntax_error(What), stream(_, Line, _, _))) :- !,
+    format(string(Text), "~w", [What]),
+    mcp_emit_json(Id, _{line:Line, message:Text}).
+mcp_syntax_report(Id, Error) :-
+    format(string(Text), "~q", [Error]),
+    mcp_emit_json(Id, _{line:0, message:Text}).
+
+mcp_syntax_directive((:- op(Priority, Type, Name)), Module) :- !,
+    catch(op(Priority, Type, Module:Name), _, true).
+mcp_syntax_directive((:- module(_, Exports)), Module) :- !,
+    forall(member(op(Priority, Type, Name), Exports),
+           catch(op(Priority, Type, Module:Name), _, true)).
+mcp_syntax_directive(_, _).
+
+%!  mcp_describe(+Id, +Module, +Name, +Arity) is det.
+%
+%   Describe the predicates Name/Arity visible in Module for
+%   describe_predicate; Arity -1 stands for any arity. Predicates that
+%   would be autoloaded are loaded first. Emits one SOLUTION per
+%   predicate: {"predicate": PI, "module": M, "file": File, "line": L,
+%   "exported": Bool, "properties": [...], "meta": Spec, "clauses": N,
+%   "summary": Text, "comment": Text, "modes": [{"mode", "det"}],
+%   "manual": Text}, where summary, comment and modes come from the
+%   PlDoc comment of the predicate in its source file (read with
+%   prolog_xref) and manual is the summary line of the reference
+%   manual for built-ins. Absent values are "", 0 or [].
+
+mcp_describe(Id, Module, Name, Arity) :-
+    catch(forall(mcp_describe_head(Module, Name, Arity, Definer:Head),
+                 ( mcp_describe_info(Definer:Head, Info),
+                   mcp_emit_json(Id, Info)
+                 )),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_describe_head(Module, Name, Arity, Definer:Head) :-
+    setof(A, mcp_describe_arity(Module, Name, Arity, A), Arities),
+    member(A, Arities),
+    functor(Head0, Name, A),
+    mcp_describe_load(Module:Head0),
+    mcp_describe_definer(Module:Head0, Definer:Head).
+
+mcp_describe_arity(Module, Name, Arity, A) :-
+    (   integer(Arity), Arity >= 0
+    ->  A = Arity
+    ;   current_predicate(Name, _:Head),
+        functor(Head, Name, A)
+    ;   between(0, 10, A),
+        functor(Head, Name, A),
+        predicate_property(Module:Head, autoload(_))
+    ).
+
+%   Autoloadable predicates are described from their library, which is
+%   loaded without importing anything into Module
+
+mcp_describe_load(Module:Head) :-
+    (   predicate_property(Module:Head, autoload(File))
+    ->  Module:use_module(File, [])
+    ;   true
+    ).
+
+mcp_describe_definer(Module:Head, Definer:Head) :-
+    (   predicate_property(Module:Head, imported_from(Definer))
+    ->  true
+    ;   predicate_property(Module:Head, built_in)
+    ->  Definer = system
+    ;   predicate_property(Module:Head, defined)
+    ->  Definer = Module
+    ;   predicate_property(Definer:Head, exported),
+        \+ predicate_property(Definer:Head, imported_from(_))
+    ->  true
+    ).
+
+mcp_describe_info(M:Head, Info) :-
+    functor(Head, Name, Arity),
+    format(string(PI), "~q/~w", [Name, Arity]),
+    (   predicate_property(M:Head, file(File)),
+        predicate_property(M:Head, line_count(Line))
+    ->  true
+    ;   File = "", Line = 0
+    ),
+    findall(P, ( member(P, [dynamic, multifile, discontiguous, built_in, foreign,
+                            tabled, thread_local, det, iso, transparent]),
+                 predicate_property(M:Head, P)
+               ),
+            Properties),
+    (   predicate_property(M:Head, meta_predicate(Spec))
+    ->  format(string(Meta), "~q", [Spec])
+    ;   Meta = ""
+    ),
+    (   predicate_property(M:Head, number_of_clauses(Clauses))
+    ->  true
+    ;   Clauses = 0
+    ),
+    (   predicate_property(M:Head, exported)
+    ->  Exported = true
+    ;   Exported = false
+    ),
+    mcp_describe_doc(File, Head, Summary, Comment, Modes),
+    mcp_describe_manual(Name/Arity, Manual),
+    Info = _{predicate:PI, module:M, file:File, line:Line, exported:Exported,
+             properties:Properties, meta:Meta, clauses:Clauses, summary:Summary,
+             comment:Comment, modes:Modes, manual:Manual}.
+
+mcp_describe_doc("", _, "", "", []) :- !.
+mcp_describe_doc(File, Head, Summary, Comment, Modes) :-
+    catch(xref_source(File, [comments(store), silent(true)]), _, fail),
+    functor(Head, Name, Arity),
+    (   xref_comment(File, Doc, Summary0, Comment0),
+        mcp_describe_head_of(Doc, Name, Arity)
+    ->  format(string(Summary), "~w", [Summary0]),
+        format(string(Comment), "~w", [Comment0])
+    ;   Summary = "", Comment = ""
+    ),
+    findall(_{mode:ModeText, det:Det},
+            ( xref_mode(File, Mode, Det),
+              mcp_describe_head_of(Mode, Name, Arity),
+              format(string(ModeText), "~W",
+                     [Mode, [quoted(true), numbervars(true), portray(true), spacing(next_argument)]])
+            ),
+            Modes),
+    !.
+mcp_describe_doc(_, _, "", "", []).
+
+mcp_describe_head_of(Term, Name, Arity) :-
+    (   Term = _:Head
+    ->  true
+    ;   Head = Term
+    ),
+    functor(Head, Name, Arity).
+
+%   The reference manual index only exists where the documentation is installed
+
+mcp_describe_manual(PI, Manual) :-
+    catch(( use_module(library(pldoc/man_index)),
+            man_object_property(PI, summary(Summary0))
+          ),
+          _,
+          fail),
+    !,
+    format(string(Manual), "~w", [Summary0]).
+mcp_describe_manual(_, "").
+
+%!  mcp_stats(+Id) is det.
+%
+%   Emit one SOLUTION for prolog_stats: {"statistics": {Key: Value},
+%   "flags": {Flag: Value}} with the memory, program and time keys of
+%   statistics/2 for the session's thread, and its stack_limit,
+%   table_space and shared_table_space flags. Keys the running version
+%   of SWI-Prolog does not know are left out.
+
+mcp_stats(Id) :-
+    catch(( findall(Key-Value,
+                    ( mcp_stats_key(Key),
+                      catch(statistics(Key, Value), _, fail)
+                    ),
+                    Stats),
+            findall(Flag-Value,
+                    ( member(Flag, [stack_limit, table_space, shared_table_space]),
+                      current_prolog_flag(Flag, Value)
+                    ),
+                    Flags),
+            dict_pairs(StatsDict, _, Stats),
+            dict_pairs(FlagsDict, _, Flags),
+            mcp_emit_json(Id, _{statistics:StatsDict, flags:FlagsDict})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_stats_key(Key) :-
+    member(Key, [ stack, globalused, localused, trailused, global, local, trail,
+                  c_stack, table_space_used, atoms, functors, predicates,
+                  modules, clauses, codes, heapused, threads, cputime,
+                  process_cputime, inferences, epoch, garbage_collection,
+                  agc, stack_shifts
+                ]).
+
+%!  mcp_table(+Id, +Module, +Specs, +Mode) is det.
+%!  mcp_untable(+Id, +Module, +Specs) is det.
+%
+%   Tabling for table_declare and table_remove. Specs are the texts
+%   of table/1 specifications (Name/Arity or a mode-directed head).
+%   mcp_table/4 tables each in Module at runtime, as Mode unless Mode
+%   is variant, wrapping the clauses already loaded; mcp_untable/3
+%   undoes that with untable/1. Both emit one SOLUTION {"spec": Text}
+%   per spec, with "error" set when SWI-Prolog refused it.
+
+mcp_table(Id, Module, Specs, Mode) :-
+    forall(member(Text, Specs),
+           catch(( term_string(Spec0, Text),
+                   mcp_table_mode(Mode, Spec0, Spec),
+                   Module:table(Spec),
+                   mcp_emit_json(Id, _{spec:Text})
+                 ),
+                 Error,
+                 mcp_table_error(Id, Text, Error))),
+    mcp_end(Id).
+
+mcp_untable(Id, Module, Specs) :-
+    forall(member(Text, Specs),
+           catch(( term_string(Spec, Text),
+                   Module:untable(Spec),
+                   mcp_emit_json(Id, _{spec:Text})
+                 ),
+                 Error,
+                 mcp_table_error(Id, Text, Error))),
+    mcp_end(Id).
+
+mcp_table_mode(variant, Spec, Spec) :- !.
+mcp_table_mode(Mode, Spec, Spec as Mode).
+
+mcp_table_error(Id, Text, Error) :-
+    format(string(Message), "~q", [Error]),
+    mcp_emit_json(Id, _{spec:Text, error:Message}).
+
+%!  mcp_table_abolish(+Id, +Module, +Text) is det.
+%
+%   Abolish every table when Text is "", else the tables of the
+%   predicate Name/Arity of Module, and emit {"abolished": Text}.
+
+mcp_table_abolish(Id, Module, Text) :-
+    catch(( (   Text == ""
+            ->  abolish_all_tables
+            ;   term_string(Name/Arity, Text),
+                functor(Head, Name, Arity),
+                abolish_table_subgoals(Module:Head)
+            ),
+            mcp_emit_json(Id, _{abolished:Text})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_table_stats(+Id, +Module) is det.
+%
+%   Emit one SOLUTION per tabled predicate defined in Module for
+%   table_statistics: {"predicate": PI, "modes": [Mode], "tables": N,
+%   "answers": N, "bytes": N}, counting its variant tables and the
+%   answers and memory of their tries. A last SOLUTION holds
+%   {"table_space_used": Bytes, "table_space": Bytes}.
+
+mcp_table_stats(Id, Module) :-
+    catch(( forall(mcp_tabled_predicate(Module, Head),
+                   mcp_emit_table_stats(Id, Module, Head)),
+            statistics(table_space_used, Used),
+            current_prolog_flag(table_space, Space),
+            mcp_emit_json(Id, _{table_space_used:Used, table_space:Space})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_tabled_predicate(Module, Head) :-
+    current_predicate(Module:Name/Arity),
+    functor(Head, Name, Arity),
+    predicate_property(Module:Head, tabled),
+    \+ predicate_property(Module:Head, imported_from(_)).
+
+mcp_emit_table_stats(Id, Module, Head) :-
+    functor(Head, Name, Arity),
+    format(string(PI), "~q/~w", [Name, Arity]),
+    findall(Mode,
+            ( predicate_property(Module:Head, tabled(Mode0)),
+              format(string(Mode), "~w", [Mode0])
+            ),
+            Modes),
+    findall(Answers-Bytes,
+            ( current_table(Module:Head, Trie),
+              mcp_trie_size(Trie, Answers, Bytes)
+            ),
+            Sizes),
+    length(Sizes, Tables),
+    pairs_keys_values(Sizes, AnswerCounts, ByteCounts),
+    sum_list(AnswerCounts, Answers),
+    sum_list(ByteCounts, Bytes),
+    mcp_emit_json(Id, _{predicate:PI, modes:Modes, tables:Tables,
+                        answers:Answers, bytes:Bytes}).
+
+mcp_trie_size(Trie, Answers, Bytes) :-
+    (   trie_property(Trie, value_count(Answers))
+    ->  true
+    ;   Answers = 0
+    ),
+    (   trie_property(Trie, size(Bytes))
+    ->  true
+    ;   Bytes = 0
+    ).
+
+%!  mcp_profile(+Id, +Text, +Limits) is det.
+%
+%   Run the goal Text once under the execution profiler for
+%   profile_query and emit one SOLUTION row: the outcome (true with the
+%   bindings, false, or error with the exception), the profiler's
+%   summary (samples, ticks, time, ...) and a node per predicate that
+%   ran, with its call, redo and exit counts and the ticks it spent in
+%   itself and in its callees. A goal that raises or runs into one of
+%   Limits is still reported, with the profile up to that point.
+
+mcp_profile(Id, Text, Limits) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            catch(mcp_limited(Limits, mcp_profile_goal(Goal, Bindings, Outcome)),
+                  Caught,
+                  Outcome = error(Caught)),
+            mcp_profile_report(Outcome, Report),
+            mcp_emit_json(Id, Report)
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_profile_goal(Goal, Bindings, Outcome) :-
+    reset_profiler,
+    setup_call_cleanup(profiler(_, cputime),
+                       (   call(Goal)
+                       ->  mcp_bindings_json(Bindings, Json),
+                           Outcome = true(Json)
+                       ;   Outcome = false
+                       ),
+                       profiler(_, false)).
+
+mcp_profile_report(Outcome, Report) :-
+    profile_data(Data),
+    get_dict(summary, Data, Summary),
+    get_dict(nodes, Data, Nodes0),
+    maplist(mcp_profile_node, Nodes0, Nodes),
+    mcp_profile_outcome(Outcome, Report0),
+    put_dict(_{summary:Summary, nodes:Nodes}, Report0, Report).
+
+mcp_profile_outcome(true(Bindings), _{outcome:true, bindings:Bindings}).
+mcp_profile_outcome(false, _{outcome:false}).
+mcp_profile_outcome(error(Error), _{outcome:error, error:Text}) :-
+    format(string(Text), "~q", [Error]).
+
+mcp_profile_node(Node, _{predicate:PIText, calls:Calls, redos:Redos, exits:Exits,
+                         ticks_self:Self, ticks_children:Children}) :-
+    get_dict(predicate, Node, PI),
+    format(string(PIText), "~q", [PI]),
+    get_dict(call, Node, Calls),
+    get_dict(redo, Node, Redos),
+    get_dict(exit, Node, Exits),
+    get_dict(ticks_self, Node, Self),
+    get_dict(ticks_siblings, Node, Children).
+
+%!  mcp_parse(+Id, +StartText, +Input, +Limits, +Options) is det.
+%
+%   Parse the string Input with the nonterminal StartText for
+%   parse_with_grammar. Options is parse(Type, Max): Input becomes a
+%   list of codes, chars or (Type tokens) atoms split at white space,
+%   and at most Max parses that consume all of it are emitted as
+%   SOLUTION {"bindings": Dict}. If there are none, one SOLUTION
+%   {"failure": {"position": P, "rule": Rule, "prefix": N}} is emitted
+%   instead: P is the furthest position a rule of the grammar completed
+%   at (see mcp_dcg_mark//1), Rule that rule as Name//Arity and N the
+%   furthest position StartText itself reached.
+
+mcp_parse(Id, StartText, Input, Limits, parse(Type, Max)) :-
+    catch(( term_string(Start, StartText, [variable_names(Bindings)]),
+            mcp_parse_input(Type, Input, List),
+            mcp_limited(Limits, mcp_parse_run(Id, Start, Bindings, List, Max))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_parse_input(codes, Input, List) :-
+    string_codes(Input, List).
+mcp_parse_input(chars, Input, List) :-
+    string_chars(Input, List).
+mcp_parse_input(tokens, Input, List) :-
+    split_string(Input, " \t\n\r", " \t\n\r", Parts),
+    exclude(==(""), Parts, Words),
+    maplist([Word, Atom]>>atom_string(Atom, Word), Words, List).
+
+mcp_parse_run(Id, Start, Bindings, List, Max) :-
+    length(List, Length),
+    nb_setval(mcp_dcg_furthest, Length-''),
+    findall(Bindings, limit(Max, phrase(user:Start, List)), Parses),
+    (   Parses \== []
+    ->  forall(member(Parsed, Parses),
+               ( mcp_bindings_json(Parsed, Json),
+                 mcp_emit_json(Id, _{bindings:Json})
+               ))
+    ;   (   aggregate_all(min(Rest),
+                          ( limit(1000, phrase(user:Start, List, Tail)),
+                            length(Tail, Rest)
+                          ),
+                          MinRest)
+        ->  Prefix is Length - MinRest
+        ;   Prefix = 0
+        ),
+        nb_getval(mcp_dcg_furthest, Furthest-Rule),
+        Position is max(Length - Furthest, Prefix),
+        mcp_emit_json(Id, _{failure:_{position:Position, rule:Rule, prefix:Prefix}})
+    ).
+
+%!  mcp_dcg_mark(+Rule)// is det.
+%
+%   Appended to the body of every rule of a grammar parse_with_grammar
+%   loads: records the rest of the input after Rule completed in
+%   mcp_dcg_furthest when it is shorter than any before.
+
+mcp_dcg_mark(Rule, Rest, Rest) :-
+    (   is_list(Rest),
+        length(Rest, Length),
+        nb_current(mcp_dcg_furthest, Furthest-_),
+        Length < Furthest
+    ->  nb_setval(mcp_dcg_furthest, Length-Rule)
+    ;   true
+    ).
+
+%!  mcp_tests(+Id, +Units, +Limits) is det.
+%
+%   Run the plunit tests loaded in the session for run_tests, emitting
+%   one SOLUTION {"unit", "test", "line", "status", ...} per test. Units
+%   is [] for every unit. status is passed, failed, error, blocked or
+%   skipped (its condition is false). A failed test has a "reason" and,
+%   where one applies, the "check" that failed as instantiated by the
+%   test, with "got" and "expected"; an error has the exception in
+%   "error". Tests marked fixme carry "fixme". Tests are enumerated
+%   with current_test/5 and their options are evaluated here, so each
+%   result can say what went wrong, instead of parsing plunit's report.
+%   Each test runs under Limits with its output captured in "output".
+mcp_tests(Id, Units, Limits) :-
+    catch(( use_module(library(plunit)),
+            forall(( plunit:current_test_unit(Unit, UnitOptions),
+                     ( Units == [] -> true ; memberchk(Unit, Units) )
+                   ),
+                   mcp_test_unit(Id, Unit, UnitOptions, Limits))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_test_unit(Id, Unit, UnitOptions0, Limits) :-
+    mcp_test_options(UnitOptions0, UnitOptions),
+    findall(test(Test, Line, Body, Options),
+            plunit:current_test(Unit, Test, Line, Body, Options),
+            Tests),
+    (   Tests = [test(_, _, Module:_, _)|_]
+    ->  true
+    ;   Module = user
+    ),
+    (   mcp_test_skipped(Module, UnitOptions, Skipped)
+    ->  forall(member(test(Test, Line, _, _), Tests),
+               mcp_test_emit(Id, Unit, Test, Line, Skipped))
+    ;   catch(mcp_test_option_call(setup, UnitOptions, Module), Error, true)
+    ->  (   var(Error)
+        ->  forall(member(Test, Tests), mcp_test_run(Id, Unit, Module, Test, Limits))
+        ;   mcp_test_text(Error, Text),
+            forall(member(test(Test, Line, _, _), Tests),
+                   mcp_test_emit(Id, Unit, Test, Line, _{status:error, reason:"unit setup raised an exception", error:Text}))
+        ),
+        ignore(catch(mcp_test_option_call(cleanup, UnitOptions, Module), _, true))
+    ;   forall(member(test(Test, Line, _, _), Tests),
+               mcp_test_emit(Id, Unit, Test, Line, _{status:failed, reason:"unit setup failed"}))
+    ).
+
+mcp_test_run(Id, Unit, Module, test(Test, Line, Body, Options0), Limits) :-
+    mcp_test_options(Options0, Options),
+    (   mcp_test_skipped(Module, Options, Result)
+    ->  true
+    ;   with_output_to(string(Output),
+                       catch(mcp_limited(Limits, mcp_test_instances(Module, Body, Options, Result0)),
+                             Error,
+                             mcp_test_error(Error, Result0))),
+        (   Output == ""
+        ->  Result1 = Result0
+        ;   put_dict(output, Result0, Output, Result1)
+        ),
+        (   memberchk(fixme(Reason), Options)
+        ->  mcp_test_text(Reason, Fixme),
+            put_dict(fixme, Result1, Fixme, Result)
+        ;   Result = Result1
+        )
+    ),
+    mcp_test_emit(Id, Unit, Test, Line, Result).
+
+mcp_test_options(Options, List) :-
+    (   is_list(Options)
+    ->  List = Options
+    ;   List = [Options]
+    ).
+
+mcp_test_skipped(_, Options, _{status:blocked, reason:Text}) :-
+    memberchk(blocked(Reason), Options), !,
+    mcp_test_text(Reason, Text).
+mcp_test_skipped(Module, Options, _{status:skipped, reason:"condition is false"}) :-
+    memberchk(condition(Condition), Options),
+    \+ catch(Module:Condition, _, fail).
+
+%   forall(Generator) runs the test once per solution of Generator, and
+%   the first instance that does not pass is reported.
+
+mcp_test_instances(Module, Body, Options, Result) :-
+    (   memberchk(forall(Generator), Options)
+    ->  findall(Body-Options, Module:Generator, Instances),
+        mcp_test_first_failure(Instances, Module, Result)
+    ;   mcp_test_once(Module, Body, Options, Result)
+    ).
+
+mcp_test_first_failure([], _, _{status:passed}).
+mcp_test_first_failure([Body-Options|Instances], Module, Result) :-
+    mcp_test_once(Module, Body, Options, Result0),
+    (   get_dict(status, Result0, passed)
+    ->  mcp_test_first_failure(Instances, Module, Result)
+    ;   Result = Result0
+    ).
+
+mcp_test_once(Module, Body, Options, Result) :-
+    (   catch(mcp_test_option_call(setup, Options, Module), Error, true)
+    ->  (   var(Error)
+        ->  call_cleanup(mcp_test_expect(Module, Body, Options, Result),
+                         catch(mcp_test_option_call(cleanup, Options, Module), _, true))
+        ;   mcp_test_error(Error, Result0),
+            put_dict(reason, Result0, "setup raised an exception", Result)
+        )
+    ;   Result = _{status:failed, reason:"setup failed"}
+    ).
+
+mcp_test_option_call(Key, Options, Module) :-
+    Option =.. [Key, Goal],
+    (   memberchk(Option, Options)
+    ->  once(Module:Goal)
+    ;   true
+    ).
+
+mcp_test_expect(_, Body, Options, Result) :-
+    (   memberchk(throws(Expected), Options)
+    ;   memberchk(error(Formal), Options),
+        Expected = error(Formal, _)
+    ), !,
+    catch(( call(Body) -> Outcome = succeeded ; Outcome = failed ), Caught, Outcome = caught),
+    (   Outcome == caught,
+        subsumes_term(Expected, Caught)
+    ->  Result = _{status:passed}
+    ;   Outcome == caught
+    ->  mcp_test_text(Caught, Got),
+        mcp_test_text(Expected, Want),
+        Result = _{status:failed, reason:"wrong exception", got:Got, expected:Want}
+    ;   mcp_test_text(Expected, Want),
+        format(string(Reason), "expected an exception, but the test ~w", [Outcome]),
+        Result = _{status:failed, reason:Reason, expected:Want}
+    ).
+mcp_test_expect(_, Body, Options, Result) :-
+    (   memberchk(fail, Options)
+    ;   memberchk(false, Options)
+    ), !,
+    (   catch(call(Body), Error, true)
+    ->  (   var(Error)
+        ->  Result = _{status:failed, reason:"test succeeded, but should fail"}
+        ;   mcp_test_error(Error, Result)
+        )
+    ;   Result = _{status:passed}
+    ).
+mcp_test_expect(Module, Body, Options, Result) :-
+    (   memberchk(all(Check), Options)
+    ->  Kind = all
+    ;   memberchk(set(Check), Options)
+    ->  Kind = set
+    ), !,
+    Check =.. [Op, Var, Expected0],
+    catch(( findall(Var, Body, Got0),
+            ( Kind == set -> sort(Got0, Got) ; Got = Got0 ),
+            ( Kind == set -> sort(Expected0, Expected) ; Expected = Expected0 ),
+            Check1 =.. [Op, Got, Expected],
+            ( Module:Check1 -> Pass = true ; Pass = false )
+          ),
+          Error,
+          true),
+    (   nonvar(Error)
+    ->  mcp_test_error(Error, Result)
+    ;   Pass == true
+    ->  Result = _{status:passed}
+    ;   format(string(Reason), "wrong ~w of answers", [Kind]),
+        mcp_test_failed_check(Reason, Check1, Got, Expected, Result)
+    ).
+mcp_test_expect(Module, Body, Options, Result) :-
+    mcp_test_checks(Options, Checks),
+    (   catch(Body, Error, true)
+    ->  (   nonvar(Error)
+        ->  mcp_test_error(Error, Result)
+        ;   member(Check, Checks),
+            \+ catch(Module:Check, _, fail)
+        ->  (   mcp_test_comparison(Check, Got, Expected)
+            ->  mcp_test_failed_check("check failed", Check, Got, Expected, Result)
+            ;   mcp_test_text(Check, CheckText),
+                Result = _{status:failed, reason:"check failed", check:CheckText}
+            )
+        ;   Result = _{status:passed}
+        )
+    ;   Result = _{status:failed, reason:"test body failed"}
+    ),
+    !.
+
+%   The true(Check) options, and comparisons written directly as options.
+%   The checks share their variables with the test body, so they are
+%   collected without copying.
+
+mcp_test_checks([], []).
+mcp_test_checks([true(Check)|Options], [Check|Checks]) :- !,
+    mcp_test_checks(Options, Checks).
+mcp_test_checks([Option|Options], [Option|Checks]) :-
+    mcp_test_comparison(Option, _, _), !,
+    mcp_test_checks(Options, Checks).
+mcp_test_checks([_|Options], Checks) :-
+    mcp_test_checks(Options, Checks).
+
+mcp_test_comparison(Check, Got, Expected) :-
+    compound(Check),
+    compound_name_arguments(Check, Op, [Got, Expected]),
+    memberchk(Op, [=, ==, =@=, \==, \=@=, =:=, =\=, <, >, =<, >=, \=]).
+
+mcp_test_failed_check(Reason, Check, Got, Expected, _{status:failed, reason:Reason, check:CheckText, got:GotText, expected:ExpectedText}) :-
+    mcp_test_text(Check, CheckText),
+    mcp_test_text(Got, GotText),
+    mcp_test_text(Expected, ExpectedText).
+
+mcp_test_error(Error, _{status:error, error:Text}) :-
+    mcp_test_text(Error, Text).
+
+mcp_test_emit(Id, Unit, Test, Line, Result) :-
+    mcp_test_text(Unit, UnitText),
+    mcp_test_text(Test, TestText),
+    put_dict(_{unit:UnitText, test:TestText, line:Line}, Result, Json),
+    mcp_emit_json(Id, Json).
+
+mcp_test_text(Term, Text) :-
+    copy_term(Term, Copy),
+    numbervars(Copy, 0, _, [singletons(true)]),
+    format(string(Text), "~W", [Copy, [quoted(true), numbervars(true), max_depth(30)]]).
+
+%!  mcp_import_facts(+Id, +Module, +PI, +Text, +Replace) is det.
+%
+%   Assert the facts listed in Text into Module for import_data, declaring
+%   PI (Name/Arity) dynamic and, when Replace is true, retracting its
+%   clauses first. All or nothing: a failing assert rolls the transaction
+%   back. Emits SOLUTION {"asserted": N, "total": Clauses}.
+mcp_import_facts(Id, Module, Name/Arity, Text, Replace) :-
+    catch(( term_string(Facts, Text),
+            functor(Head, Name, Arity),
+            transaction(( dynamic(Module:Name/Arity),
+                          (   Replace == true
+                          ->  retractall(Module:Head)
+                          ;   true
+                          ),
+                          forall(member(Fact, Facts), assertz(Module:Fact))
+                        )),
+            length(Facts, Asserted),
+            (   predicate_property(Module:Head, number_of_clauses(Total))
+            ->  true
+            ;   Total = 0
+            ),
+            mcp_emit_json(Id, _{asserted:Asserted, total:Total})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%   The inference helpers below call into packs that are only loaded by
+%   the processes of probabilistic_query and scasp_query, which load the
+%   library before this file. Elsewhere they are left out, so check/0 in
+%   lint_program does not report their calls as undefined.
+
+%!  mcp_geo_load(+Id, +Index, +Features) is det.
+%
+%   Add Features, a list of URI-Shape pairs with shapes as the space
+%   pack writes them (point(Lat, Long), polygon([Ring|Holes]), ...), to
+%   the spatial index Index for geo_load. Emits one SOLUTION
+%   {"id": URI, "error": Message} per shape the pack refused and a last
+%   one {"added": N}. mcp_geo_index/2 builds the index afterwards.
+
+mcp_geo_load(Id, Index, Features) :-
+    catch(( mcp_use_space,
+            foldl(mcp_geo_add(Id, Index), Features, 0, Added),
+            mcp_emit_json(Id, _{added:Added})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_geo_add(Id, Index, URI-Shape, N0, N) :-
+    (   catch(space_assert(URI, Shape, Index), E, true)
+    ->  (   var(E)
+        ->  N is N0 + 1
+        ;   format(string(Message), "~q", [E]),
+            mcp_emit_json(Id, _{id:URI, error:Message}),
+            N = N0
+        )
+    ;   mcp_emit_json(Id, _{id:URI, error:"space_assert/3 failed"}),
+        N = N0
+    ).
+
+%!  mcp_geo_index(+Id, +Index) is det.
+%
+%   Build the R-tree of Index from the shapes added since it was last
+%   built and emit {"index": Index}.
+
+mcp_geo_index(Id, Index) :-
+    catch(( mcp_use_space,
+            space_index(Index),
+            mcp_emit_json(Id, _{index:Index})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%!  mcp_geo_query(+Id, +Index, +Operation, +Shape, +Limit) is det.
+%
+%   Emit one SOLUTION {"id": URI} for each of at most Limit shapes of
+%   Index that intersect Shape (intersects), lie inside it (contains)
+%   or, nearest first, are closest to it (nearest).
+
+mcp_geo_query(Id, Index, Operation, Shape, Limit) :-
+    catch(( mcp_use_space,
+            forall(limit(Limit, distinct(URI, mcp_geo_match(Operation, Shape, URI, Index))),
+                   mcp_emit_json(Id, _{id:URI}))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_geo_match(intersects, Shape, URI, Index) :-
+    space_intersects(Shape, URI, Index).
+mcp_geo_match(contains, Shape, URI, Index) :-
+    space_contains(Shape, URI, Index).
+mcp_geo_match(nearest, Shape, URI, Index) :-
+    space_nearest(Shape, URI, Index).
+
+%!  mcp_geo_clear(+Id, +Index) is det.
+%
+%   Remove every shape from Index for geo_clear and emit
+%   {"cleared": Index}.
+
+mcp_geo_clear(Id, Index) :-
+    catch(( mcp_use_space,
+            space_clear(Index),
+            mcp_emit_json(Id, _{cleared:Index})
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+%   The space pack is loaded on first use: it is only installed with
+%   SWISH_MCP_GEO=on.
+
+mcp_use_space :-
+    use_module(library(space/space)).
+
+:- if(current_predicate(prob/2)).
+
+%!  mcp_prob(+Id, +Text, +Limits) is det.
+%
+%   Parse Text as a query against an LPAD program loaded with cplint's
+%   PITA and emit one SOLUTION {"bindings": Dict, "probability": P} for
+%   every ground instance of the query that prob/2 enumerates.
+
+mcp_prob(Id, Text, Limits) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            mcp_limited(Limits,
+                        forall(prob(Goal, P),
+                               ( mcp_bindings_json(Bindings, Json),
+                                 mcp_emit_json(Id, _{bindings:Json, probability:P})
+                               )))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+:- endif.
+
+:- if(current_predicate(scasp/2)).
+
+%!  mcp_scasp(+Id, +Text, +MaxModels, +Limits) is det.
+%
+%   Parse Text as an s(CASP) query and emit one SOLUTION per answer, up
+%   to MaxModels:
+%
+%     {"bindings": Dict, "constraints": ["X \\= a", ...],
+%      "model": ["p(a)", "not q(a)", ...], "justification": Text}
+%
+%   constraints are the disequalities and clpq constraints s(CASP) left
+%   on the query's variables, and justification is the proof tree in
+%   the English form human_justification_tree/2 prints.
+
+mcp_scasp(Id, Text, MaxModels, Limits) :-
+    catch(( mcp_read_goal(Text, Goal, Bindings),
+            mcp_limited(Limits,
+                        forall(limit(MaxModels, scasp(Goal, [model(Model), tree(Tree)])),
+                               mcp_scasp_answer(Id, Bindings, Model, Tree)))
+          ),
+          Error,
+          mcp_emit(Id, 'ERROR', Error)),
+    mcp_end(Id).
+
+mcp_scasp_answer(Id, Bindings, Model, Tree) :-
+    mcp_bindings_json(Bindings, Json),
+    copy_term(Bindings, Named, Goals),
+    forall(member(Name=Var, Named), ignore(Var = '$VAR'(Name))),
+    findall(Constraint,
+            ( member(G, Goals),
+              format(string(Constraint), "~W", [G, [numbervars(true), quoted(true)]])
+            ),
+            Constraints),
+    findall(Literal,
+            ( member(L, Model),
+              format(string(Literal), "~W", [L, [numbervars(true), quoted(true), portray(true)]])
+            ),
+            Literals),
+    with_output_to(string(Justification), human_justification_tree(Tree, [])),
+    mcp_emit_json(Id, _{bindings:Json, constraints:Constraints,
+                        model:Literals, justification:Justification}).
+
+:- endif.
+
+%!  mcp_bindings_json(+Bindings, -Dict) is det.
+%!  mcp_term_json(+Term, -Dict) is det.
+%
+%   Describe terms as JSON-ready dicts tagged with their type, so clients
+%   never have to parse Prolog syntax:
+%
+%     var       {"type": "var", "name": "_123"}
+%     integer   {"type": "integer", "value": 42}
+%     float     {"type": "float", "value": 1.5}  (inf/nan as strings)
+%     atom      {"type": "atom", "value": "foo"}
+%     string    {"type": "string", "value": "text"}
+%     list      {"type": "list", "items": [...]}
+%     compound  {"type": "compound", "functor": "f", "arity": 2, "args": [...]}
+
+mcp_bindings_json(Bindings, Dict) :-
+    findall(Name-Json,
+            ( member(Name=Value, Bindings),
+              mcp_term_json(Value, Json)
+            ),
+            Pairs),
+    dict_pairs(Dict, _, Pairs).
+
+mcp_term_json(Term, _{type:var, name:Name}) :-
+    var(Term), !,
+    format(string(Name), "~w", [Term]).
+mcp_term_json(Term, _{type:integer, value:Term}) :-
+    integer(Term), !.
+mcp_term_json(Term, _{type:float, value:Value}) :-
+    float(Term), !,
+    (   catch(float_class(Term, Class), _, Class = normal),
+        memberchk(Class, [nan, infinite])
+    ->  format(string(Value), "~w", [Term])
+    ;   Value = Term
+    ).
+%   Lists come before atoms: atom([]) is true in SWI-Prolog 7.
+mcp_term_json(Term, _{type:list, items:Items}) :-
+    is_list(Term), !,
+    maplist(mcp_term_json, Term, Items).
+mcp_term_json(Term, _{type:atom, value:Value}) :-
+    atom(Term), !,
+    atom_string(Term, Value).
+mcp_term_json(Term, _{type:string, value:Term}) :-
+    string(Term), !.
+mcp_term_json(Term, _{type:compound, functor:Functor, arity:Arity, args:Args}) :-
+    compound(Term), !,
+    compound_name_arguments(Term, Name, Arguments),
+    atom_string(Name, Functor),
+    length(Arguments, Arity),
+    maplist(mcp_term_json, Arguments, Args).
+mcp_term_json(Term, _{type:term, text:Text}) :-
+    format(string(Text), "~q", [Term]).
+
+%!  mcp_limited(+Limits, :Goal) is det.
+%
+%   Run a deterministic Goal under limits(Wall, Cpu, Inferences), where a
+%   limit of 0 means "no limit". Exceeding a limit raises
+%   time_limit_exceeded, cpu_time_limit_exceeded or
+%   inference_limit_exceeded(Max).
+%
+%   limits(Wall, Cpu, Inferences, memory(StackLimit, TableSpace)) also
+%   sets the stack_limit and table_space flags (in bytes, 0 keeps the
+%   current value) while Goal runs, and restores them afterwards.
+%
+%   While Goal runs, SIGINT raises mcp_cancelled in it (see
+%   mcp_interrupt/1).
+
+mcp_limited(limits(Wall, Cpu, Inferences), Goal) :-
+    setup_call_cleanup(nb_setval(mcp_cancellable, true),
+                       mcp_with_wall(Wall, mcp_with_cpu(Cpu, mcp_with_inferences(Inferences, Goal))),
+                       nb_setval(mcp_cancellable, false)).
+mcp_limited(limits(Wall, Cpu, Inferences, memory(StackLimit, TableSpace)), Goal) :-
+    findall(Flag-Value,
+            ( member(Flag-Value, [stack_limit-StackLimit, table_space-TableSpace]),
+              Value > 0
+            ),
+            Flags),
+    setup_call_cleanup(mcp_set_flags(Flags, Old),
+                       mcp_limited(limits(Wall, Cpu, Inferences), Goal),
+                       catch(mcp_set_flags(Old, _), _, true)).
+
+mcp_set_flags(Flags, Old) :-
+    findall(Flag-Value,
+            ( member(Flag-_, Flags),
+              current_prolog_flag(Flag, Value)
+            ),
+            Old),
+    forall(member(Flag-Value, Flags), set_prolog_flag(Flag, Value)).
+
+mcp_with_wall(Wall, Goal) :-
+    Wall =< 0, !,
+    once(Goal).
+mcp_with_wall(Wall, Goal) :-
+    call_with_time_limit(Wall, Goal).
+
+mcp_with_inferences(Max, Goal) :-
+    Max =< 0, !,
+    once(Goal).
+mcp_with_inferences(Max, Goal) :-
+    call_with_inference_limit(once(Goal), Max, Result),
+    (   Result == inference_limit_exceeded
+    ->  throw(inference_limit_exceeded(Max))
+    ;   true
+    ).
+
+%   SWI-Prolog has no CPU-time equivalent of call_with_time_limit/2, so a
+%   short alarm re-arms itself and compares statistics(cputime) against
+%   the deadline.
+
+mcp_with_cpu(Max, Goal) :-
+    Max =< 0, !,
+    once(Goal).
+mcp_with_cpu(Max, Goal) :-
+    statistics(cputime, Start),
+    Deadline is Start + Max,
+    setup_call_cleanup(mcp_arm_cpu_alarm(Deadline),
+                       once(Goal),
+                       mcp_disarm_cpu_alarm).
+
+mcp_arm_cpu_alarm(Deadline) :-
+    alarm(0.1, mcp_check_cpu(Deadline), Id, [remove(true)]),
+    nb_setval(mcp_cpu_alarm, Id).
+
+mcp_check_cpu(Deadline) :-
+    statistics(cputime, Now),
+    (   Now > Deadline
+    ->  nb_setval(mcp_cpu_alarm, none),
+        throw(cpu_time_limit_exceeded)
+    ;   mcp_arm_cpu_alarm(Deadline)
+    ).
+
+mcp_disarm_cpu_alarm :-
+    (   nb_current(mcp_cpu_alarm, Id),
+        Id \== none
+    ->  catch(remove_alarm(Id), _, true),
+        nb_setval(mcp_cpu_alarm, none)
+    ;   true
+    ).
+
+%!  mcp_interrupt(+Signal) is det.
+%
+%   SIGINT handler. The server sends SIGINT to cancel the running query
+%   (see cancellation.py), whose goal then raises mcp_cancelled; callers
+%   such as mcp_run/4 report it as an ERROR line like any exception.
+%   Outside mcp_limited/2 the signal is ignored, so one that arrives
+%   just as a query finished does not stop the next.
+
+mcp_interrupt(_) :-
+    nb_current(mcp_cancellable, true),
+    !,
+    throw(mcp_cancelled).
+mcp_interrupt(_).
+
+:- on_signal(int, _, mcp_interrupt).
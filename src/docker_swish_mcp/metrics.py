@@ -0,0 +1,279 @@
+"""
+Prometheus Metrics for Docker SWISH MCP
+
+A small metrics registry rendering the Prometheus text exposition format,
+served on its own /metrics listener (SWISH_MCP_METRICS_LISTEN) so it works
+with every MCP transport. The listener runs in a daemon thread; metric
+updates and scrapes are serialized by a lock.
+
+Exported metrics:
+
+- swish_mcp_tool_calls_total{tool, status} and swish_mcp_tool_duration_seconds{tool}
+- swish_mcp_queries_total{mode, outcome}, swish_mcp_query_duration_seconds{mode}
+  and swish_mcp_query_solutions{mode}
+- swish_mcp_container_restarts_total{container, result}
+- swish_mcp_transport_errors_total{transport, reason}
+- swish_mcp_container_ready{container}, swish_mcp_worker_pool_running and
+  swish_mcp_worker_pool_queued, set when scraped
+"""
+
+import logging
+import threading
+import time
+from collections.abc import Callable
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import Any
+
+from .errors import ERROR_TAG
+
+logger = logging.getLogger("docker-swish-mcp.metrics")
+
+LATENCY_BUCKETS = (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0)
+SOLUTION_BUCKETS = (0, 1, 2, 5, 10, 25, 50, 100, 250, 1000)
+CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+
+def _escape(value: str) -> str:
+    return value.replace("\\", "\\\\").replace("\n", "\\n").replace('"', '\\"')
+
+
+def _labels(names: tuple[str, ...], values: tuple[str, ...], extra: str = "") -> str:
+    pairs = [f'{name}="{_escape(value)}"' for name, value in zip(names, values)]
+    if extra:
+        pairs.append(extra)
+    return "{" + ",".join(pairs) + "}" if pairs else ""
+
+
+def _number(value: float) -> str:
+    return str(int(value)) if float(value).is_integer() else repr(float(value))
+
+
+class Metric:
+    """Base for labelled metrics."""
+    kind = "untyped"
+
+    def __init__(self, name: str, help_text: str, label_names: tuple[str, ...] = ()):
+        self.name = name
+        self.help_text = help_text
+        self.label_names = label_names
+        self.lock = threading.Lock()
+
+    def _key(self, labels: dict[str, str]) -> tuple[str, ...]:
+        return tuple(str(labels.get(name, "")) for name in self.label_names)
+
+    def samples(self) -> list[str]:
+        raise NotImplementedError
+
+    def render(self) -> str:
+        lines = [f"# HELP {self.name} {self.help_text}", f"# TYPE {self.name} {self.kind}"]
+        with self.lock:
+            lines.extend(self.samples())
+        return "\n".join(lines)
+
+
+class Counter(Metric):
+    kind = "counter"
+
+    def __init__(self, name: str, help_text: str, label_names: tuple[str, ...] = ()):
+        super().__init__(name, help_text, label_names)
+        self.values: dict[tuple[str, ...], float] = {}
+
+    def inc(self, amount: float = 1, **labels: str) -> None:
+        key = self._key(labels)
+        with self.lock:
+            self.values[key] = self.values.get(key, 0) + amount
+
+    def samples(self) -> list[str]:
+        return [f"{self.name}{_labels(self.label_names, key)} {_number(v)}" for key, v in sorted(self.values.items())]
+
+
+class Gauge(Metric):
+    kind = "gauge"
+
+    def __init__(self, name: str, help_text: str, label_names: tuple[str, ...] = ()):
+        super().__init__(name, help_text, label_names)
+        self.values: dict[tuple[str, ...], float] = {}
+
+    def set(self, value: float, **labels: str) -> None:
+        key = self._key(labels)
+        with self.lock:
+            self.values[key] = value
+
+    def samples(self) -> list[str]:
+        return [f"{self.name}{_labels(self.label_names, key)} {_number(v)}" for key, v in sorted(self.values.items())]
+
+
+class Histogram(Metric):
+    kind = "histogram"
+
+    def __init__(
+        self,
+        name: str,
+        help_text: str,
+        label_names: tuple[str, ...] = (),
+        buckets: tuple[float, ...] = LATENCY_BUCKETS
+    ):
+        super().__init__(name, help_text, label_names)
+        self.buckets = tuple(sorted(buckets))
+        self.counts: dict[tuple[str, ...], list[int]] = {}
+        self.sums: dict[tuple[str, ...], float] = {}
+
+    def observe(self, value: float, **labels: str) -> None:
+        key = self._key(labels)
+        with self.lock:
+            counts = self.counts.setdefault(key, [0] * (len(self.buckets) + 1))
+            for i, bound in enumerate(self.buckets):
+                if value <= bound:
+                    counts[i] += 1
+            counts[-1] += 1
+            self.sums[key] = self.sums.get(key, 0.0) + value
+
+    def samples(self) -> list[str]:
+        lines = []
+        for key, counts in sorted(self.counts.items()):
+            for bound, count in zip(self.buckets, counts):
+                le = f'le="{_number(bound)}"'
+                lines.append(f"{self.name}_bucket{_labels(self.label_names, key, le)} {count}")
+            inf = 'le="+Inf"'
+            lines.append(f"{self.name}_bucket{_labels(self.label_names, key, inf)} {counts[-1]}")
+            lines.append(f"{self.name}_sum{_labels(self.label_names, key)} {_number(self.sums[key])}")
+            lines.append(f"{self.name}_count{_labels(self.label_names, key)} {counts[-1]}")
+        return lines
+
+
+class ServerMetrics:
+    """The server's metrics plus hooks refreshing gauges before a scrape."""
+
+    def __init__(self) -> None:
+        self.tool_calls = Counter("swish_mcp_tool_calls_total", "MCP tool calls by result", ("tool", "status"))
+        self.tool_seconds = Histogram("swish_mcp_tool_duration_seconds", "MCP tool call latency", ("tool",))
+        self.queries = Counter("swish_mcp_queries_total", "Prolog queries by outcome", ("mode", "outcome"))
+        self.query_seconds = Histogram("swish_mcp_query_duration_seconds", "Prolog query latency", ("mode",))
+        self.query_solutions = Histogram(
+            "swish_mcp_query_solutions", "Solutions returned per query", ("mode",), SOLUTION_BUCKETS
+        )
+        self.cache_lookups = Counter("swish_mcp_query_cache_total", "Query cache lookups by result", ("result",))
+        self.container_restarts = Counter(
+            "swish_mcp_container_restarts_total", "SWISH container restarts", ("container", "result")
+        )
+        self.transport_errors = Counter(
+            "swish_mcp_transport_errors_total", "Failed HTTP requests on the MCP transport", ("transport", "reason")
+        )
+        self.container_ready = Gauge("swish_mcp_container_ready", "1 if the SWISH container is ready", ("container",))
+        self.workers_running = Gauge("swish_mcp_worker_pool_running", "Queries running on the worker pool")
+        self.workers_queued = Gauge("swish_mcp_worker_pool_queued", "Queries waiting for the worker pool")
+        self.collectors: list[Callable[["ServerMetrics"], None]] = []
+
+    @property
+    def all(self) -> list[Metric]:
+        return [
+            self.tool_calls, self.tool_seconds, self.queries, self.query_seconds, self.query_solutions,
+            self.cache_lookups, self.container_restarts, self.transport_errors, self.container_ready,
+            self.workers_running, self.workers_queued,
+        ]
+
+    def observe_query(self, mode: str, outcome: str, seconds: float, solutions: int) -> None:
+        """Record one query; outcome is success, failure (no solutions), error or timeout."""
+        self.queries.inc(mode=mode, outcome=outcome)
+        self.query_seconds.observe(seconds, mode=mode)
+        self.query_solutions.observe(solutions, mode=mode)
+
+    def render(self) -> str:
+        for collect in self.collectors:
+            try:
+                collect(self)
+            except Exception as e:
+                logger.debug(f"Metrics collector failed: {e}")
+        return "\n".join(metric.render() for metric in self.all) + "\n"
+
+
+def query_outcome(error: str | None, solutions: int) -> str:
+    """Classify a finished query for swish_mcp_queries_total."""
+    if error is None:
+        return "success" if solutions else "failure"
+    if "limit_exceeded" in error or "timeout" in error:
+        return "timeout"
+    return "error"
+
+
+def result_failed(result: Any) -> bool:
+    """Whether a tool result reports failure: "❌ ..." text, or a typed error line."""
+    content = result[0] if isinstance(result, tuple) else result
+    if isinstance(content, str):
+        return _failed_text(content)
+    if isinstance(content, (list, tuple)):
+        return any(_failed_text(str(getattr(block, "text", ""))) for block in content)
+    return False
+
+
+def _failed_text(text: str) -> bool:
+    return text.startswith("❌") or f"\n{ERROR_TAG} " in text
+
+
+def instrument_tool_calls(server: Any, metrics: ServerMetrics) -> None:
+    """Count and time every tool call of a FastMCP server."""
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        started = time.monotonic()
+        status = "exception"
+        try:
+            result = await base_call_tool(name, arguments, *args, **kwargs)
+            status = "error" if result_failed(result) else "ok"
+            return result
+        finally:
+            metrics.tool_calls.inc(tool=name, status=status)
+            metrics.tool_seconds.observe(time.monotonic() - started, tool=name)
+
+    tool_manager.call_tool = call_tool
+
+
+class TransportMetricsMiddleware:
+    """ASGI middleware counting HTTP error responses and exceptions of the MCP app."""
+
+    def __init__(self, app: Any, metrics: ServerMetrics, transport: str):
+        self.app = app
+        self.metrics = metrics
+        self.transport = transport
+
+    async def __call__(self, scope: Any, receive: Any, send: Any) -> None:
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        async def counting_send(message: Any) -> None:
+            if message["type"] == "http.response.start" and message["status"] >= 400:
+                self.metrics.transport_errors.inc(transport=self.transport, reason=f"http_{message['status']}")
+            await send(message)
+
+        try:
+            await self.app(scope, receive, counting_send)
+        except Exception:
+            self.metrics.transport_errors.inc(transport=self.transport, reason="exception")
+            raise
+
+
+def start_metrics_server(metrics: ServerMetrics, host: str, port: int) -> ThreadingHTTPServer:
+    """Serve GET /metrics on host:port from a daemon thread."""
+
+    class Handler(BaseHTTPRequestHandler):
+        def do_GET(self) -> None:
+            if self.path.split("?", 1)[0] != "/metrics":
+                self.send_error(404)
+                return
+            body = metrics.render().encode()
+            self.send_response(200)
+            self.send_header("Content-Type", CONTENT_TYPE)
+            self.send_header("Content-Length", str(len(body)))
+            self.end_headers()
+            self.wfile.write(body)
+
+        def log_message(self, format: str, *args: object) -> None:
+            logger.debug(format % args)
+
+    server = ThreadingHTTPServer((host, port), Handler)
+    server.daemon_threads = True
+    threading.Thread(target=server.serve_forever, name="metrics", daemon=True).start()
+    logger.info(f"📈 Serving Prometheus metrics at http://{host}:{port}/metrics")
+    return server
@@ -0,0 +1,824 @@
+"""
+In-Memory Mock Backend for Docker SWISH MCP
+
+With --backend=mock (or SWISH_MCP_BACKEND=mock) nothing is started: the
+persistent session runs on mock_prolog.py, a small Prolog interpreter in
+this process, so the MCP surface can be demoed, and clients tested
+against it, without Docker or an installed swipl. Answers are
+deterministic (random/1 and friends are seeded, and nothing depends on
+the machine), so runs can be compared.
+
+MockProcessClient stands in for the local backend's client: what its
+open_exec() starts looks like a swipl process from outside, with stdin,
+stdout and stderr streams, signals and an exit status, and speaks the
+session's @MCP line protocol. The helpers of mcp_helpers.pl the session
+relies on most are written in Python here (mcp_run, cursors, the
+snapshot and restore of dynamic predicates, safe consults, startup
+programs, syntax checks and statistics); the text the session consults
+with [user] is skipped. Facts and rules, assert/retract, findall and
+friends, lists, strings, arithmetic, format/2, DCGs and consulting files
+from the data directory work, as do the wall, CPU and inference limits
+and cancelling a query.
+
+What does not:
+
+- Modules: Module:Goal calls Goal, and every program shares user.
+- Tabling (:- table is accepted and ignored), constraints, dicts,
+  threads, engines, RDF, tracing and explain, and the rest of
+  mcp_helpers.pl; calling one of those is an existence_error.
+- Output files, streams other than the current output, and the pretty
+  layout of bindings, which print as writeq does.
+- Speed: the interpreter runs some ten thousand times slower than
+  swipl, fine for demos and tests, not for benchmarks.
+
+As with the local backend, what needs the SWISH web server or a
+container says so.
+"""
+
+import asyncio
+import codecs
+import copy
+import json
+import logging
+import math
+import queue
+import re
+import signal
+import threading
+import time
+from collections.abc import Callable
+from pathlib import Path
+from typing import Any
+
+from .local_backend import LocalProcessClient
+from .mock_prolog import (
+    BUILTINS,
+    NIL,
+    TRUE,
+    Atom,
+    Halt,
+    Machine,
+    Operators,
+    PrologError,
+    PrologSyntaxError,
+    Reader,
+    Struct,
+    Var,
+    deref,
+    error_term,
+    format_float,
+    list_items,
+    make_list,
+    parse_term,
+    portray_clause_text,
+    text_of,
+    tokenize,
+)
+from .simple_session import LINE_BREAK
+
+logger = logging.getLogger("docker-swish-mcp.mock_backend")
+
+# The -g goal of run_swipl_with_program(), which sends the program on stdin
+PROGRAM_GOAL = "load_files(mcp_program, [stream(user_input)])"
+# Query ids as simple_session.py makes them
+QUERY_ID_RE = re.compile(r"^q\d+x[0-9a-f]{6}$")
+# swipl options whose value the mock has no use for
+VALUE_OPTIONS = frozenset({"-f", "-F", "-x", "-p", "-O", "--home"})
+# stderr kept for a process nobody reads it from
+STDERR_LIMIT = 1 << 20
+
+
+class MockProcessClient(LocalProcessClient):
+    """
+    Runs the session's swipl commands on the in-memory interpreter.
+
+    Args:
+        data_dir: Working directory the programs consult files from
+    """
+
+    def __init__(self, data_dir: Path):
+        super().__init__(data_dir, "mock")
+
+    def for_dir(self, data_dir: Path) -> "MockProcessClient":
+        return MockProcessClient(data_dir)
+
+    def ping(self) -> bool:
+        return True
+
+    @property
+    def version_text(self) -> str:
+        return "mock"
+
+    @property
+    def engine(self) -> str:
+        return "in-memory mock Prolog (no swipl)"
+
+    async def open_exec(self, container_name: str, cmd: list[str], stdin: bool = True) -> Any:
+        """Start cmd on the interpreter in a thread; container_name is ignored."""
+        return MockProcess(self.data_dir, cmd, stdin)
+
+
+class MockStdin:
+    """The stdin of a MockProcess, passing what is written on to its thread."""
+
+    def __init__(self, chunks: "queue.Queue[bytes | None]"):
+        self.chunks = chunks
+        self.closed = False
+
+    def write(self, data: bytes) -> None:
+        if not self.closed and data:
+            self.chunks.put(bytes(data))
+
+    async def drain(self) -> None:
+        pass
+
+    def close(self) -> None:
+        if not self.closed:
+            self.closed = True
+            self.chunks.put(None)
+
+    def is_closing(self) -> bool:
+        return self.closed
+
+
+class MockProcess:
+    """
+    A swipl process as an asyncio.subprocess.Process looks from outside,
+    run by a MockSwipl in a daemon thread. SIGINT interrupts the running
+    goal; terminate() and kill() stop the thread at its next check.
+    """
+
+    def __init__(self, data_dir: Path, cmd: list[str], stdin: bool = True):
+        self.loop = asyncio.get_running_loop()
+        self.stdout = asyncio.StreamReader()
+        self.stderr = asyncio.StreamReader()
+        self.chunks: queue.Queue[bytes | None] = queue.Queue()
+        self.stdin = MockStdin(self.chunks) if stdin else None
+        if not stdin:
+            self.chunks.put(None)
+        self.returncode: int | None = None
+        self.signalled = 0
+        self._exited: asyncio.Future[int] = self.loop.create_future()
+        self._stderr_size = 0
+        self.swipl = MockSwipl(data_dir, self._write_stdout, self._write_stderr, LineInput(self.chunks))
+        self.thread = threading.Thread(target=self._run, args=(cmd,), name="mock-swipl", daemon=True)
+        self.thread.start()
+
+    def _feed(self, stream: asyncio.StreamReader, text: str) -> None:
+        try:
+            self.loop.call_soon_threadsafe(stream.feed_data, text.encode("utf-8"))
+        except RuntimeError:
+            # The event loop is gone; nobody reads any more
+            pass
+
+    def _write_stdout(self, text: str) -> None:
+        self._feed(self.stdout, text)
+
+    def _write_stderr(self, text: str) -> None:
+        if self._stderr_size < STDERR_LIMIT:
+            self._stderr_size += len(text)
+            self._feed(self.stderr, text)
+
+    def _run(self, cmd: list[str]) -> None:
+        try:
+            status = self.swipl.run(cmd)
+        except Halt as e:
+            status = e.status
+        except Exception as e:
+            logger.exception("Mock Prolog process failed")
+            self._write_stderr(f"ERROR: mock Prolog failed: {e}\n")
+            status = 1
+        if self.signalled:
+            status = -self.signalled
+        try:
+            self.loop.call_soon_threadsafe(self._exit, status)
+        except RuntimeError:
+            pass
+
+    def _exit(self, status: int) -> None:
+        self.returncode = status
+        self.stdout.feed_eof()
+        self.stderr.feed_eof()
+        if not self._exited.done():
+            self._exited.set_result(status)
+
+    async def wait(self) -> int:
+        return await asyncio.shield(self._exited)
+
+    async def communicate(self, input: bytes | None = None) -> tuple[bytes, bytes]:
+        if input and self.stdin is not None:
+            self.stdin.write(input)
+        if self.stdin is not None:
+            self.stdin.close()
+        stdout, stderr = await asyncio.gather(self.stdout.read(), self.stderr.read())
+        await self.wait()
+        return stdout, stderr
+
+    def send_signal(self, sig: int) -> None:
+        if sig == signal.SIGINT:
+            self.swipl.machine.interrupted = True
+        else:
+            self._stop(sig)
+
+    def terminate(self) -> None:
+        self._stop(signal.SIGTERM)
+
+    def kill(self) -> None:
+        self._stop(getattr(signal, "SIGKILL", 9))
+
+    def _stop(self, sig: int) -> None:
+        if self.returncode is None and not self.signalled:
+            self.signalled = int(sig)
+            self.swipl.machine.killed = True
+            self.chunks.put(None)
+
+
+class LineInput:
+    """The lines of a MockProcess's stdin, read in its thread; None at the end."""
+
+    def __init__(self, chunks: "queue.Queue[bytes | None]"):
+        self.chunks = chunks
+        self.decoder = codecs.getincrementaldecoder("utf-8")("replace")
+        self.buffer = ""
+        self.eof = False
+
+    def _more(self) -> bool:
+        if self.eof:
+            return False
+        chunk = self.chunks.get()
+        if chunk is None:
+            self.eof = True
+            self.buffer += self.decoder.decode(b"", final=True)
+            return False
+        self.buffer += self.decoder.decode(chunk)
+        return True
+
+    def readline(self) -> str | None:
+        while "\n" not in self.buffer:
+            if not self._more():
+                line, self.buffer = self.buffer, ""
+                return line or None
+        line, self.buffer = self.buffer.split("\n", 1)
+        return line + "\n"
+
+    def read(self) -> str:
+        while self._more():
+            pass
+        text, self.buffer = self.buffer, ""
+        return text
+
+
+class Cursor:
+    """
+    An open cursor's solutions. They run on a trail of their own, as an
+    engine runs on stacks of its own, so the bindings of a cursor waiting
+    for its next page survive the queries run meanwhile.
+    """
+
+    def __init__(self, machine: Machine, goal: Any, names: list[tuple[str, Var]], fmt: Any):
+        self.trail: list[Var] = []
+        self.solutions = machine.solve(goal)
+        self.names = names
+        self.fmt = fmt
+
+
+class MockSwipl:
+    """
+    What a MockProcess runs: swipl's command line and toplevel on a
+    Machine, with the protocol's helpers as Python built-ins.
+
+    Args:
+        data_dir: Initial working directory
+        out: Receives what is written to stdout
+        err: Receives what is written to stderr
+        stdin: The process's input
+    """
+
+    def __init__(self, data_dir: Path, out: Callable[[str], None], err: Callable[[str], None], stdin: LineInput):
+        self.out = out
+        self.err = err
+        self.stdin = stdin
+        self.machine = Machine(out, data_dir, messages=err)
+        self.cursors: dict[str, Cursor] = {}
+        self.machine.extra.update({
+            ("mcp_run", 3): self.mcp_run,
+            ("mcp_run", 4): self.mcp_run,
+            ("mcp_end", 1): self.mcp_end,
+            ("mcp_emit", 3): self.mcp_emit,
+            ("mcp_cursor_open", 6): self.mcp_cursor_open,
+            ("mcp_cursor_next", 4): self.mcp_cursor_next,
+            ("mcp_cursor_close", 1): self.mcp_cursor_close,
+            ("mcp_db_snapshot", 1): self.mcp_db_snapshot,
+            ("mcp_db_snapshot", 2): self.mcp_db_snapshot,
+            ("mcp_db_snapshot", 3): self.mcp_db_snapshot,
+            ("mcp_db_restore", 2): self.mcp_db_restore,
+            ("mcp_db_restore", 3): self.mcp_db_restore,
+            ("mcp_safe_consult", 5): self.mcp_safe_consult,
+            ("mcp_startup", 3): self.mcp_startup,
+            ("mcp_syntax_check", 2): self.mcp_syntax_check,
+            ("mcp_stats", 1): self.mcp_stats,
+        })
+
+    # --- Command line
+
+    def run(self, cmd: list[str]) -> int:
+        """Run cmd as swipl would and return its exit status."""
+        if not cmd or cmd[0] != "swipl":
+            self.err(f"{cmd[0] if cmd else ''}: command not found (the mock backend only runs swipl)\n")
+            return 127
+        steps: list[tuple[str, str]] = []
+        toplevel: str | None = None
+        args = iter(cmd[1:])
+        for arg in args:
+            if arg == "-g":
+                steps.append(("goal", next(args, "true")))
+            elif arg == "-t":
+                toplevel = next(args, "halt")
+            elif arg in ("-l", "-s"):
+                steps.append(("consult", next(args, "")))
+            elif arg in VALUE_OPTIONS:
+                next(args, None)
+            elif arg == "--":
+                break
+            elif not arg.startswith("-"):
+                steps.append(("consult", arg))
+        for kind, value in steps:
+            status = self.run_step(kind, value)
+            if status:
+                return status
+        if toplevel is None:
+            return self.toplevel()
+        if toplevel == "halt":
+            return 0
+        return 0 if self.run_step("goal", toplevel) is None else 1
+
+    def run_step(self, kind: str, text: str) -> int | None:
+        """Consult a file or run a -g goal; the exit status if it ends the process."""
+        m = self.machine
+        try:
+            if kind == "consult":
+                m.consult(Atom(text))
+                return None
+            if text == PROGRAM_GOAL:
+                m.consult_string(self.stdin.read(), file="mcp_program")
+                return None
+            goal, _ = parse_term(text, m.ops)
+            mark = len(m.trail)
+            succeeded = m.solve_once(goal)
+            m.undo(mark)
+            if succeeded:
+                return None
+            self.err(f"Warning: goal ({text}) failed\n")
+            return 1
+        except PrologSyntaxError as e:
+            self.err(f"ERROR: {text}: Syntax error: {e.message}\n")
+        except PrologError as e:
+            self.err(f"ERROR: {text}: {m.message(e.term)}\n")
+        return 2
+
+    def toplevel(self) -> int:
+        """Answer the queries on stdin until halt/0 or its end."""
+        text = ""
+        skipping = False
+        while True:
+            line = self.stdin.readline()
+            if line is None:
+                return 0
+            if skipping:
+                # The helper predicates, which are built in here
+                skipping = line.strip() != "end_of_file."
+                continue
+            text += line
+            if not text.strip() or not query_complete(text):
+                continue
+            query, text = text, ""
+            skipping = self.answer(query)
+
+    def answer(self, text: str) -> bool:
+        """Run the queries of text as the toplevel does; True after [user]."""
+        m = self.machine
+        reader = None
+        try:
+            reader = Reader(text, m.ops)
+            while True:
+                goal = reader.read_clause()
+                if goal is None:
+                    return False
+                goal = deref(goal)
+                if is_user_consult(goal):
+                    return True
+                self.answer_goal(goal, reader.bindings)
+        except PrologSyntaxError as e:
+            line = reader.line(e.position) if reader is not None else 1
+            self.err(f"ERROR: user://1:{line}: Syntax error: {e.message}\n")
+        return False
+
+    def answer_goal(self, goal: Any, bindings: list[tuple[str, Var]]) -> None:
+        m = self.machine
+        mark = len(m.trail)
+        try:
+            if m.solve_once(goal):
+                writer = m.writer()
+                shown = [
+                    f"{name} = {writer.write(value, 699)}" for name, value in bindings
+                    if not name.startswith("_") and type(deref(value)) is not Var
+                ]
+                self.out((",\n".join(shown) or "true") + ".\n\n")
+            else:
+                self.out("false.\n\n")
+        except PrologError as e:
+            self.err(f"ERROR: {m.message(e.term)}\n")
+            # A goal of the session that raised before its helper could end
+            # it, e.g. an unknown helper: end it so the session's reader
+            # gets its answer
+            query_id = protocol_query_id(goal)
+            if query_id:
+                self.emit(query_id, "ERROR", m.show(e.term))
+                self.end(query_id)
+        finally:
+            m.undo(mark)
+
+    # --- Protocol
+
+    def emit(self, query_id: str, kind: str, text: str) -> None:
+        self.out(f"@MCP {query_id} {kind} {text}\n")
+
+    def emit_json(self, query_id: str, data: dict[str, Any]) -> None:
+        self.emit(query_id, "SOLUTION", json.dumps(data, ensure_ascii=False, sort_keys=True))
+
+    def end(self, query_id: str) -> None:
+        clauses = sum(len(p.clauses) for p in self.machine.user_predicates())
+        self.out(f"@MCP {query_id} END {time.thread_time():.6f} {clauses}\n")
+
+    def guarded(self, query_id: str, action: Callable[[], None]) -> None:
+        """Run action, emitting the error it raises, then end the query, as the helpers' catch/3 does."""
+        try:
+            action()
+        except PrologError as e:
+            self.emit(query_id, "ERROR", self.machine.show(e.term))
+        self.end(query_id)
+
+    def read_goal(self, text: Any) -> tuple[Any, list[tuple[str, Var]]]:
+        """The goal of a query text and its variable names, as term_string/3 reads them."""
+        source = text_of(text, "string")
+        try:
+            return parse_term(source, self.machine.ops)
+        except PrologSyntaxError as e:
+            raise error_term(
+                Struct("syntax_error", (Atom(e.message),)),
+                Struct("string", (source, e.position))
+            ) from None
+
+    def limit_values(self, limits: Any) -> tuple[float, float, int]:
+        """(wall, cpu, inferences) of a limits/3,4 term."""
+        limits = deref(limits)
+        if type(limits) is not Struct or limits.name != "limits" or len(limits.args) < 3:
+            return 0.0, 0.0, 0
+        m = self.machine
+        wall, cpu, inferences = (m.evaluate(arg) for arg in limits.args[:3])
+        return float(wall), float(cpu), int(inferences)
+
+    def solution_text(self, fmt: Any, names: list[tuple[str, Var]]) -> str:
+        """The SOLUTION payload of the bindings names in format fmt, as mcp_solution_text/3 makes it."""
+        fmt = deref(fmt)
+        kind = fmt.name
+        depth, length, style = 0, 0, "plain"
+        if type(fmt) is Struct:
+            options = [deref(arg) for arg in deref(fmt.args[0]).args]
+            depth, length, style = int(options[0]), int(options[1]), options[2].name
+        values = [(name, cut_term(value, depth, length)) for name, value in names]
+        if kind == "json":
+            return json.dumps(
+                {name: term_json(value, self.machine) for name, value in values},
+                ensure_ascii=False, sort_keys=True
+            )
+        if not values:
+            return "true"
+        if style == "clause":
+            parts = []
+            for name, value in values:
+                text = portray_clause_text(self.machine, value, TRUE).removesuffix(".\n")
+                parts.append(f"{name} = {text}")
+            return ",\n".join(parts).replace("\n", LINE_BREAK)
+        writer = self.machine.writer()
+        parts = [f"{name} = {writer.write(value)}" for name, value in values]
+        return (", " if style == "plain" else f",{LINE_BREAK}").join(parts)
+
+    def mcp_run(self, m: Machine, query_id: Any, text: Any, limits: Any, fmt: Any = Atom("text")) -> bool:
+        def run() -> None:
+            goal, names = self.read_goal(text)
+            with m.limits(*self.limit_values(limits)):
+                for _ in m.solve(goal):
+                    self.emit(qid, "SOLUTION", self.solution_text(fmt, names))
+        qid = text_of(query_id)
+        self.guarded(qid, run)
+        return True
+
+    def mcp_end(self, m: Machine, query_id: Any) -> bool:
+        self.end(text_of(query_id))
+        return True
+
+    def mcp_emit(self, m: Machine, query_id: Any, kind: Any, term: Any) -> bool:
+        self.emit(text_of(query_id), text_of(kind), m.show(term))
+        return True
+
+    # --- Cursors
+
+    def mcp_cursor_open(self, m: Machine, query_id: Any, cursor: Any, text: Any, limits: Any, fmt: Any,
+                        page_size: Any) -> bool:
+        def open_cursor() -> None:
+            goal, names = self.read_goal(text)
+            self.cursors[text_of(cursor)] = Cursor(m, goal, names, fmt)
+            self.cursor_page(qid, text_of(cursor), limits, int(deref(page_size)))
+        qid = text_of(query_id)
+        self.guarded(qid, open_cursor)
+        return True
+
+    def mcp_cursor_next(self, m: Machine, query_id: Any, cursor: Any, limits: Any, page_size: Any) -> bool:
+        qid = text_of(query_id)
+        self.guarded(qid, lambda: self.cursor_page(qid, text_of(cursor), limits, int(deref(page_size))))
+        return True
+
+    def cursor_page(self, query_id: str, name: str, limits: Any, page_size: int) -> None:
+        cursor = self.cursors.get(name)
+        if cursor is None:
+            raise PrologError(Struct("existence_error", (Atom("mcp_cursor"), Atom(name))))
+        m = self.machine
+        state = "more"
+        saved, m.trail = m.trail, cursor.trail
+        try:
+            with m.limits(*self.limit_values(limits)):
+                for _ in range(page_size):
+                    try:
+                        next(cursor.solutions)
+                    except StopIteration:
+                        state = "done"
+                        break
+                    self.emit(query_id, "SOLUTION", self.solution_text(cursor.fmt, cursor.names))
+        except PrologError:
+            m.trail = saved
+            self.close_cursor(name)
+            raise
+        finally:
+            m.trail = saved
+        if state == "done":
+            self.close_cursor(name)
+        self.out(f"@MCP {query_id} CURSOR {state}\n")
+
+    def close_cursor(self, name: str) -> None:
+        cursor = self.cursors.pop(name, None)
+        if cursor is None:
+            return
+        m = self.machine
+        saved, m.trail = m.trail, cursor.trail
+        try:
+            cursor.solutions.close()
+        finally:
+            m.trail = saved
+
+    def mcp_cursor_close(self, m: Machine, cursor: Any) -> bool:
+        self.close_cursor(text_of(cursor))
+        return True
+
+    # --- Dynamic predicates
+
+    def dynamic_predicates(self) -> list[Any]:
+        """The user's dynamic predicates, as mcp_db_predicate/4 finds them."""
+        return sorted(
+            (p for p in self.machine.user_predicates() if p.dynamic and not p.name.startswith(("mcp_", "$"))),
+            key=lambda p: (p.name, p.arity)
+        )
+
+    def mcp_db_snapshot(self, m: Machine, query_id: Any, module: Any = None, most: Any = None) -> bool:
+        def snapshot() -> None:
+            predicates = self.dynamic_predicates()
+            if most is not None and sum(len(p.clauses) for p in predicates) > int(deref(most)):
+                for predicate in predicates:
+                    self.emit_json(qid, {"name": predicate.name, "arity": predicate.arity, "count": len(predicate.clauses)})
+                return
+            for predicate in predicates:
+                clauses = []
+                for clause in list(predicate.clauses):
+                    head, body = clause.instance()
+                    term = head if deref(body) is TRUE else Struct(":-", (head, body))
+                    clauses.append(m.writer(ignore_ops=True).write(term))
+                self.emit_json(qid, {"name": predicate.name, "arity": predicate.arity, "clauses": clauses})
+        qid = text_of(query_id)
+        self.guarded(qid, snapshot)
+        return True
+
+    def mcp_db_restore(self, m: Machine, query_id: Any, *args: Any) -> bool:
+        def restore() -> None:
+            entries = []
+            for entry in list_items(deref(args[-1])) or []:
+                entry = deref(entry)
+                if type(entry) is not Struct or entry.name != "pred" or len(entry.args) != 3:
+                    raise PrologError(Struct("type_error", (Atom("pred"), entry)))
+                entries.append((text_of(entry.args[0]), int(deref(entry.args[1])), list_items(deref(entry.args[2])) or []))
+            listed = {(name, arity) for name, arity, _ in entries}
+            for predicate in self.dynamic_predicates():
+                if (predicate.name, predicate.arity) not in listed:
+                    clear_clauses(predicate)
+            for name, arity, clauses in entries:
+                BUILTINS[("dynamic", 1)](m, Struct("/", (Atom(name), arity)))
+                clear_clauses(m.predicate(name, arity))
+                for text in clauses:
+                    clause, _ = self.read_goal(text)
+                    m.add_clause(clause)
+            self.emit_json(qid, {"restored": len(entries)})
+        qid = text_of(query_id)
+        self.guarded(qid, restore)
+        return True
+
+    # --- Loading
+
+    def load(self, spec: Any, errors: list[tuple[int, str]], file: str | None = None, text: str | None = None) -> None:
+        """Consult spec, or text as the source file, collecting (line, message) of the errors."""
+        m = self.machine
+        if text is None:
+            path = m.resolve_file(spec)
+            if path is None:
+                return
+            text = path.read_text(encoding="utf-8", errors="replace")
+            m.loaded[str(path)] = path.stat().st_mtime
+            file = str(path)
+        m.consult_string(text, file=file, errors=errors)
+
+    def mcp_safe_consult(self, m: Machine, query_id: Any, module: Any, path: Any, scratch: Any, text: Any) -> bool:
+        def safe_consult() -> None:
+            problems = syntax_errors(text_of(text, "string"), m.ops)
+            for line, message in problems:
+                self.emit_json(qid, {"severity": "error", "message": message, "line": line, "phase": "check"})
+            if not problems:
+                errors: list[tuple[int, str]] = []
+                self.load(path, errors)
+                for line, message in errors:
+                    self.emit_json(qid, {"severity": "error", "message": message, "line": line, "phase": "consult"})
+            self.emit_json(qid, {"loaded": not problems})
+        qid = text_of(query_id)
+        self.guarded(qid, safe_consult)
+        return True
+
+    def mcp_startup(self, m: Machine, query_id: Any, items: Any, policy: Any) -> bool:
+        def startup() -> None:
+            for item in list_items(deref(items)) or []:
+                item = deref(item)
+                errors: list[tuple[int, str]] = []
+                try:
+                    if type(item) is Struct and item.name == "code" and len(item.args) == 2:
+                        name = text_of(item.args[0])
+                        self.load(None, errors, file=name, text=text_of(item.args[1], "string"))
+                    else:
+                        name = text_of(item.args[0]) if type(item) is Struct else text_of(item)
+                        self.load(item.args[0] if type(item) is Struct else item, errors)
+                    error = errors[0][1] if errors else ""
+                except PrologError as e:
+                    error = m.show(e.term)
+                except OSError as e:
+                    error = str(e)
+                self.emit_json(qid, {"item": name, "error": error})
+                if error and deref(policy) is Atom("abort"):
+                    return
+        qid = text_of(query_id)
+        self.guarded(qid, startup)
+        return True
+
+    def mcp_syntax_check(self, m: Machine, query_id: Any, text: Any) -> bool:
+        def check() -> None:
+            for line, message in syntax_errors(text_of(text, "string"), m.ops):
+                self.emit_json(qid, {"line": line, "message": message})
+        qid = text_of(query_id)
+        self.guarded(qid, check)
+        return True
+
+    def mcp_stats(self, m: Machine, query_id: Any) -> bool:
+        def stats() -> None:
+            predicates = m.user_predicates()
+            self.emit_json(qid, {
+                "statistics": {
+                    "cputime": time.thread_time(),
+                    "process_cputime": time.process_time(),
+                    "inferences": m.inferences,
+                    "predicates": len(predicates),
+                    "clauses": sum(len(p.clauses) for p in predicates),
+                    "atoms": len(Atom.table),
+                },
+                "flags": {},
+            })
+        qid = text_of(query_id)
+        self.guarded(qid, stats)
+        return True
+
+
+def query_complete(text: str) -> bool:
+    """Whether text holds a whole query, ending in a full stop."""
+    try:
+        tokens = tokenize(text)
+    except PrologSyntaxError:
+        # An open quote goes on on the next line; anything else is an error the reader reports
+        return text.rstrip().endswith(".") and not text.count("'") % 2 and not text.count('"') % 2
+    return len(tokens) > 1 and tokens[-2].kind == "end"
+
+
+def is_user_consult(goal: Any) -> bool:
+    """Whether goal is [user], consulting what follows on stdin up to end_of_file."""
+    items = list_items(goal) if type(goal) is Struct else None
+    return items is not None and len(items) == 1 and deref(items[0]) is Atom("user")
+
+
+def protocol_query_id(goal: Any) -> str:
+    """The query id a goal of the session carries, or ""."""
+    stack = [goal]
+    while stack:
+        term = deref(stack.pop())
+        if type(term) is Atom and QUERY_ID_RE.match(term.name):
+            return term.name
+        if type(term) is Struct:
+            stack.extend(term.args)
+    return ""
+
+
+def syntax_errors(text: str, ops: Operators) -> list[tuple[int, str]]:
+    """(line, message) of each syntax error of text, read as a file; its op/3 directives apply as it is read."""
+    ops = copy.copy(ops)
+    ops.prefix, ops.infix = dict(ops.prefix), dict(ops.infix)
+    try:
+        reader = Reader(text, ops)
+    except PrologSyntaxError as e:
+        return [(text.count("\n", 0, e.position) + 1, f"Syntax error: {e.message}")]
+    problems = []
+    while True:
+        try:
+            term = reader.read_clause()
+        except PrologSyntaxError as e:
+            problems.append((reader.line(e.position), f"Syntax error: {e.message}"))
+            reader.skip_clause()
+            continue
+        if term is None:
+            return problems
+        directive = deref(term)
+        if type(directive) is Struct and directive.name == ":-" and len(directive.args) == 1:
+            declare_ops(ops, deref(directive.args[0]))
+
+
+def declare_ops(ops: Operators, directive: Any) -> None:
+    """Apply an op/3 directive to ops; anything else is left alone."""
+    if type(directive) is not Struct or directive.name != "op" or len(directive.args) != 3:
+        return
+    priority, kind = deref(directive.args[0]), deref(directive.args[1])
+    if type(priority) is not int or type(kind) is not Atom:
+        return
+    names = deref(directive.args[2])
+    for name in list_items(names) or [names]:
+        name = deref(name)
+        if type(name) is Atom:
+            ops.add(priority, kind.name, name.name)
+
+
+def clear_clauses(predicate: Any) -> None:
+    for clause in predicate.clauses:
+        clause.erased = True
+    predicate.clauses = []
+
+
+def cut_term(term: Any, depth: Any, length: int) -> Any:
+    """term with subterms deeper than depth and list elements past length cut to '...', as mcp_cut_term/4 does."""
+    term = deref(term)
+    if type(term) is not Struct or (depth == 0 and length == 0):
+        return term
+    if depth == "stop":
+        return Atom("...")
+    inner = 0 if depth == 0 else "stop" if depth == 1 else depth - 1
+    items = list_items(term)
+    if items is not None:
+        kept = [cut_term(item, inner, length) for item in items[:length or None]]
+        if length > 0 and len(items) > length:
+            kept.append(Atom("..."))
+        return make_list(kept)
+    return Struct(term.name, tuple(cut_term(arg, inner, length) for arg in term.args))
+
+
+def term_json(term: Any, machine: Machine) -> Any:
+    """The typed JSON of a term, as mcp_term_json/2 makes it."""
+    term = deref(term)
+    kind = type(term)
+    if kind is Var:
+        return {"type": "var", "name": machine.show(term)}
+    if kind is int:
+        return {"type": "integer", "value": term}
+    if kind is float:
+        value = format_float(term) if math.isnan(term) or math.isinf(term) else term
+        return {"type": "float", "value": value}
+    items = list_items(term) if term is NIL or kind is Struct else None
+    if items is not None:
+        return {"type": "list", "items": [term_json(item, machine) for item in items]}
+    if kind is Atom:
+        return {"type": "atom", "value": term.name}
+    if kind is str:
+        return {"type": "string", "value": term}
+    if kind is Struct:
+        return {
+            "type": "compound", "functor": term.name, "arity": len(term.args),
+            "args": [term_json(arg, machine) for arg in term.args],
+        }
+    return {"type": "term", "text": machine.show(term)}
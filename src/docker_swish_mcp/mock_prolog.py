@@ -0,0 +1,3127 @@
+"""
+A Small Prolog Interpreter for the Mock Backend of Docker SWISH MCP
+
+Terms, a reader and writer for standard Prolog syntax (with op/3), and a
+solver with backtracking, cut, if-then-else, negation, catch/throw and
+the common built-ins; mock_backend.py speaks the @MCP line protocol on
+top of it. Goals and choice points are kept on explicit stacks rather
+than Python's, so a deep recursion runs into the inference budget, not
+Python's recursion limit; the reader, writer and copying still recurse
+into the arguments of a term other than its last (a list or conjunction
+of any length is fine, a term nested a thousand levels deep to the left
+is not).
+
+Atoms are Atom, compound terms Struct, variables Var; integers, floats
+and strings are Python's int, float and str. Lists are '[|]'/2 cells
+ending in [], as in SWI-Prolog 7.
+"""
+
+import functools
+import itertools
+import math
+import random
+import re
+import time
+from collections.abc import Callable, Iterator
+from contextlib import contextmanager
+from pathlib import Path
+from typing import Any
+
+SYMBOL_CHARS = frozenset("+-*/\\^<>=~:.?@#&$")
+SOLO_CHARS = frozenset("!;")
+PUNCTUATION = frozenset("()[]{},|")
+# Calls between checks of the wall-clock deadline and of interrupts
+CHECK_EVERY = 4096
+
+_var_ids = itertools.count()
+
+
+class Var:
+    """A logic variable; ref is what it is bound to, None while unbound."""
+    __slots__ = ("ref", "id")
+
+    def __init__(self) -> None:
+        self.ref: Any = None
+        self.id = next(_var_ids)
+
+
+class Atom:
+    """An atom; atoms are interned, so each name has one Atom and `is` compares them."""
+    __slots__ = ("name",)
+    table: dict[str, "Atom"] = {}
+
+    def __new__(cls, name: str) -> "Atom":
+        atom = cls.table.get(name)
+        if atom is None:
+            atom = super().__new__(cls)
+            atom.name = name
+            cls.table[name] = atom
+        return atom
+
+    def __repr__(self) -> str:
+        return f"Atom({self.name!r})"
+
+
+class Struct:
+    """A compound term name(args...)."""
+    __slots__ = ("name", "args")
+
+    def __init__(self, name: str, args: tuple):
+        self.name = name
+        self.args = args
+
+    def __repr__(self) -> str:
+        return f"Struct({self.name!r}, {self.args!r})"
+
+
+NIL = Atom("[]")
+TRUE = Atom("true")
+FALSE = Atom("false")
+EMPTY_BLOCK = Atom("{}")
+END_OF_FILE = Atom("end_of_file")
+
+
+class PrologError(Exception):
+    """A Prolog exception, carrying the thrown term."""
+
+    def __init__(self, term: Any):
+        super().__init__(term)
+        self.term = term
+
+
+class PrologSyntaxError(Exception):
+    def __init__(self, message: str, position: int):
+        super().__init__(message)
+        self.message = message
+        self.position = position
+
+
+class Halt(Exception):
+    """Raised by halt/0,1."""
+
+    def __init__(self, status: int = 0):
+        super().__init__(status)
+        self.status = status
+
+
+def deref(term: Any) -> Any:
+    while type(term) is Var and term.ref is not None:
+        term = term.ref
+    return term
+
+
+def make_list(items: list[Any], tail: Any = NIL) -> Any:
+    result = tail
+    for item in reversed(items):
+        result = Struct("[|]", (item, result))
+    return result
+
+
+def list_items(term: Any) -> list[Any] | None:
+    """Items of a proper list; None for anything else."""
+    items = []
+    term = deref(term)
+    while type(term) is Struct and term.name == "[|]" and len(term.args) == 2:
+        items.append(term.args[0])
+        term = deref(term.args[1])
+    return items if term is NIL else None
+
+
+def indicator(name: str, arity: int) -> Struct:
+    return Struct("/", (Atom(name), arity))
+
+
+def error_term(formal: Any, context: Any = None) -> PrologError:
+    return PrologError(Struct("error", (formal, Var() if context is None else context)))
+
+
+def instantiation_error() -> PrologError:
+    return error_term(Atom("instantiation_error"))
+
+
+def type_error(kind: str, culprit: Any) -> PrologError:
+    return error_term(Struct("type_error", (Atom(kind), culprit)))
+
+
+def domain_error(kind: str, culprit: Any) -> PrologError:
+    return error_term(Struct("domain_error", (Atom(kind), culprit)))
+
+
+def existence_error(kind: str, culprit: Any) -> PrologError:
+    return error_term(Struct("existence_error", (Atom(kind), culprit)), culprit if kind == "procedure" else None)
+
+
+def permission_error(action: str, kind: str, culprit: Any) -> PrologError:
+    return error_term(Struct("permission_error", (Atom(action), Atom(kind), culprit)))
+
+
+def evaluation_error(kind: str) -> PrologError:
+    return error_term(Struct("evaluation_error", (Atom(kind),)))
+
+
+def representation_error(kind: str) -> PrologError:
+    return error_term(Struct("representation_error", (Atom(kind),)))
+
+
+# --- Operators -------------------------------------------------------------
+
+class Operators:
+    """The operator table the reader and writer share; op/3 changes it."""
+
+    def __init__(self) -> None:
+        self.prefix: dict[str, tuple[int, str]] = {}
+        self.infix: dict[str, tuple[int, str]] = {}
+        for priority, kind, names in (
+            (1200, "xfx", ":- -->"), (1200, "fx", ":- ?-"),
+            (1150, "fx", "dynamic discontiguous initialization multifile table module_transparent"),
+            (1105, "xfy", "|"), (1100, "xfy", ";"), (1050, "xfy", "-> *->"), (1000, "xfy", ","),
+            (990, "xfx", ":="), (900, "fy", "\\+"),
+            (700, "xfx", "= \\= == \\== @< @> @=< @>= =.. is =:= =\\= < > =< >= >:< :< as"),
+            (600, "xfy", ":"), (500, "yfx", "+ - /\\ \\/ xor"), (500, "fx", "?"),
+            (400, "yfx", "* / // mod rem << >> div rdiv divmod"), (200, "xfx", "**"), (200, "xfy", "^"),
+            (200, "fy", "- + \\"), (100, "yfx", "."), (1, "fx", "$"),
+        ):
+            for name in names.split():
+                self.add(priority, kind, name)
+
+    def add(self, priority: int, kind: str, name: str) -> None:
+        table = self.prefix if kind in ("fx", "fy") else self.infix
+        if priority == 0:
+            table.pop(name, None)
+        else:
+            table[name] = (priority, kind)
+
+    def priority(self, name: str) -> int:
+        return max(self.prefix.get(name, (0, ""))[0], self.infix.get(name, (0, ""))[0])
+
+
+# --- Reader ----------------------------------------------------------------
+
+class Token:
+    __slots__ = ("kind", "text", "value", "layout", "position", "quoted")
+
+    def __init__(self, kind: str, text: str, position: int, layout: bool, value: Any = None, quoted: bool = False):
+        self.kind = kind
+        self.text = text
+        self.value = value
+        self.layout = layout
+        self.position = position
+        self.quoted = quoted
+
+
+ESCAPES = {"n": "\n", "t": "\t", "r": "\r", "a": "\a", "b": "\b", "f": "\f", "v": "\v", "0": "\0",
+           "e": "\x1b", "s": " ", "\\": "\\", "'": "'", '"': '"', "`": "`"}
+
+
+def _read_quoted(text: str, start: int, quote: str) -> tuple[str, int]:
+    """The text of a quoted item starting at its opening quote, and the position after it."""
+    chars = []
+    i = start + 1
+    while True:
+        if i >= len(text):
+            raise PrologSyntaxError("end of file in quoted item", start)
+        c = text[i]
+        if c == quote:
+            if text.startswith(quote, i + 1):
+                chars.append(quote)
+                i += 2
+                continue
+            return "".join(chars), i + 1
+        if c == "\\":
+            i += 1
+            if i >= len(text):
+                raise PrologSyntaxError("end of file in quoted item", start)
+            c = text[i]
+            if c == "\n":
+                i += 1
+            elif c == "x":
+                match = re.match(r"([0-9a-fA-F]+)\\?", text[i + 1:])
+                if match is None:
+                    raise PrologSyntaxError("bad \\x escape", i)
+                chars.append(chr(int(match.group(1), 16)))
+                i += 1 + match.end()
+            elif c.isdigit() and c in "01234567" and re.match(r"[0-7]+\\", text[i:]):
+                match = re.match(r"([0-7]+)\\", text[i:])
+                chars.append(chr(int(match.group(1), 8)))
+                i += match.end()
+            elif c in ESCAPES:
+                chars.append(ESCAPES[c])
+                i += 1
+            else:
+                raise PrologSyntaxError(f"undefined escape sequence \\{c}", i)
+            continue
+        chars.append(c)
+        i += 1
+
+
+def _read_number(text: str, i: int) -> tuple[Any, int]:
+    n = len(text)
+    if text.startswith("0'", i) and i + 2 < n:
+        c = text[i + 2]
+        if c == "\\":
+            escape = text[i + 3] if i + 3 < n else ""
+            if escape in ESCAPES:
+                return ord(ESCAPES[escape]), i + 4
+            raise PrologSyntaxError("bad character code", i)
+        if c == "'" and text.startswith("''", i + 2):
+            return 39, i + 4
+        return ord(c), i + 3
+    for prefix, base, digits in (("0x", 16, "0-9a-fA-F"), ("0o", 8, "0-7"), ("0b", 2, "01")):
+        match = re.compile(rf"{prefix}([{digits}]+)").match(text, i)
+        if match:
+            return int(match.group(1), base), match.end()
+    match = re.compile(r"\d+(?:_\d+)*").match(text, i)
+    end = match.end()
+    integer = match.group(0).replace("_", "")
+    fraction = re.compile(r"\.\d+").match(text, end)
+    if fraction is None:
+        exponent = re.compile(r"[eE][+-]?\d+").match(text, end)
+        if exponent is None:
+            return int(integer), end
+        return float(integer + exponent.group(0)), exponent.end()
+    end = fraction.end()
+    exponent = re.compile(r"[eE][+-]?\d+").match(text, end)
+    if exponent:
+        end = exponent.end()
+    value = float(text[i:end].replace("_", ""))
+    if text.startswith("Inf", end):
+        return math.inf, end + 3
+    if text.startswith("NaN", end):
+        return math.nan, end + 3
+    return value, end
+
+
+def tokenize(text: str) -> list[Token]:
+    tokens = []
+    i, n = 0, len(text)
+    while True:
+        layout = False
+        while i < n:
+            c = text[i]
+            if c.isspace():
+                i += 1
+                layout = True
+            elif c == "%":
+                end = text.find("\n", i)
+                i = n if end < 0 else end + 1
+                layout = True
+            elif text.startswith("/*", i):
+                end = text.find("*/", i + 2)
+                if end < 0:
+                    raise PrologSyntaxError("end of file in block comment", i)
+                i = end + 2
+                layout = True
+            else:
+                break
+        if i >= n:
+            tokens.append(Token("eof", "", i, layout))
+            return tokens
+        start, c = i, text[i]
+        if c.isdigit():
+            value, i = _read_number(text, i)
+            tokens.append(Token("number", text[start:i], start, layout, value))
+        elif c == "_" or c.isupper():
+            while i < n and (text[i].isalnum() or text[i] == "_"):
+                i += 1
+            tokens.append(Token("var", text[start:i], start, layout))
+        elif c.isalpha():
+            while i < n and (text[i].isalnum() or text[i] == "_"):
+                i += 1
+            tokens.append(Token("atom", text[start:i], start, layout))
+        elif c == "'":
+            value, i = _read_quoted(text, i, "'")
+            tokens.append(Token("atom", value, start, layout, quoted=True))
+        elif c == '"':
+            value, i = _read_quoted(text, i, '"')
+            tokens.append(Token("string", value, start, layout, value))
+        elif c == "`":
+            value, i = _read_quoted(text, i, "`")
+            tokens.append(Token("codes", value, start, layout, value))
+        elif c in PUNCTUATION:
+            i += 1
+            tokens.append(Token("punct", c, start, layout))
+        elif c in SOLO_CHARS:
+            i += 1
+            tokens.append(Token("atom", c, start, layout))
+        elif c in SYMBOL_CHARS:
+            while i < n and text[i] in SYMBOL_CHARS:
+                i += 1
+            name = text[start:i]
+            if name == "." and (i >= n or text[i].isspace() or text[i] == "%"):
+                tokens.append(Token("end", ".", start, layout))
+            else:
+                tokens.append(Token("atom", name, start, layout))
+        else:
+            raise PrologSyntaxError(f"illegal character {c!r}", i)
+
+
+class Reader:
+    """Reads the clauses of a text one after another."""
+
+    def __init__(self, text: str, ops: Operators):
+        self.text = text
+        self.ops = ops
+        self.tokens = tokenize(text)
+        self.pos = 0
+        self.varmap: dict[str, Var] = {}
+        # (name, variable) in order of first appearance, without _
+        self.bindings: list[tuple[str, Var]] = []
+
+    def line(self, position: int) -> int:
+        return self.text.count("\n", 0, position) + 1
+
+    def peek(self) -> Token:
+        return self.tokens[self.pos]
+
+    def advance(self) -> Token:
+        token = self.tokens[self.pos]
+        if token.kind != "eof":
+            self.pos += 1
+        return token
+
+    def accept(self, text: str) -> bool:
+        token = self.peek()
+        if token.kind == "punct" and token.text == text:
+            self.pos += 1
+            return True
+        return False
+
+    def expect(self, text: str) -> None:
+        if not self.accept(text):
+            token = self.peek()
+            raise PrologSyntaxError(f"expected {text!r}, found {token.text or token.kind!r}", token.position)
+
+    def at_eof(self) -> bool:
+        return self.peek().kind == "eof"
+
+    def skip_clause(self) -> None:
+        """Skip to after the next end token, after a syntax error."""
+        while self.peek().kind not in ("end", "eof"):
+            self.pos += 1
+        self.advance()
+
+    def read_clause(self) -> Any:
+        """The next clause, or None at the end of the text."""
+        self.varmap, self.bindings = {}, []
+        if self.at_eof():
+            return None
+        term = self.parse(1200)
+        token = self.peek()
+        if token.kind != "end":
+            raise PrologSyntaxError(f"operator expected, found {token.text or token.kind!r}", token.position)
+        self.advance()
+        return term
+
+    def read_term(self) -> Any:
+        """The whole text as one term, with or without a final end token."""
+        self.varmap, self.bindings = {}, []
+        term = self.parse(1200)
+        if self.peek().kind == "end":
+            self.advance()
+        if not self.at_eof():
+            token = self.peek()
+            raise PrologSyntaxError(f"operator expected, found {token.text or token.kind!r}", token.position)
+        return term
+
+    def variable(self, name: str) -> Var:
+        if name == "_":
+            return Var()
+        var = self.varmap.get(name)
+        if var is None:
+            var = self.varmap[name] = Var()
+            self.bindings.append((name, var))
+        return var
+
+    def parse(self, max_priority: int) -> Any:
+        left, priority = self.primary(max_priority)
+        return self.infix(left, priority, max_priority)[0]
+
+    def arguments(self) -> tuple:
+        args = [self.parse(999)]
+        while self.accept(","):
+            args.append(self.parse(999))
+        self.expect(")")
+        return tuple(args)
+
+    def term_ends(self, token: Token) -> bool:
+        """Whether token cannot start the operand of a prefix operator before it."""
+        if token.kind in ("eof", "end"):
+            return True
+        if token.kind == "punct":
+            return token.text in ")]},|"
+        if token.kind == "atom" and not token.quoted and token.text in self.ops.infix:
+            following = self.tokens[self.pos + 1] if self.pos + 1 < len(self.tokens) else token
+            functional = following.kind == "punct" and following.text == "(" and not following.layout
+            return token.text not in self.ops.prefix and not functional
+        return False
+
+    def primary(self, max_priority: int) -> tuple[Any, int]:
+        token = self.peek()
+        if token.kind == "end":
+            # Left for skip_clause(), so the next clause still reads
+            raise PrologSyntaxError("unexpected '.'", token.position)
+        token = self.advance()
+        kind = token.kind
+        if kind == "number":
+            return token.value, 0
+        if kind == "var":
+            return self.variable(token.text), 0
+        if kind == "string":
+            return token.value, 0
+        if kind == "codes":
+            return make_list([ord(c) for c in token.value]), 0
+        if kind == "punct":
+            if token.text == "(":
+                term = self.parse(1200)
+                self.expect(")")
+                return term, 0
+            if token.text == "[":
+                if self.accept("]"):
+                    return self.name_term("[]", token, max_priority)
+                items = [self.parse(999)]
+                while self.accept(","):
+                    items.append(self.parse(999))
+                tail = self.parse(999) if self.accept("|") else NIL
+                self.expect("]")
+                return make_list(items, tail), 0
+            if token.text == "{":
+                if self.accept("}"):
+                    return self.name_term("{}", token, max_priority)
+                term = self.parse(1200)
+                self.expect("}")
+                return Struct("{}", (term,)), 0
+        if kind == "atom":
+            return self.name_term(token.text, token, max_priority)
+        raise PrologSyntaxError(f"unexpected {token.text or kind!r}", token.position)
+
+    def name_term(self, name: str, token: Token, max_priority: int) -> tuple[Any, int]:
+        following = self.peek()
+        if following.kind == "punct" and following.text == "(" and not following.layout:
+            self.advance()
+            return Struct(name, self.arguments()), 0
+        if name == "-" and not token.quoted and following.kind == "number" and not following.layout:
+            self.advance()
+            return -following.value, 0
+        if not token.quoted and name in self.ops.prefix and not self.term_ends(following):
+            priority, kind = self.ops.prefix[name]
+            if priority > max_priority:
+                priority = 999
+            operand, _ = self.primary_operand(priority if kind == "fy" else priority - 1)
+            return Struct(name, (operand,)), priority
+        return Atom(name), 0
+
+    def primary_operand(self, max_priority: int) -> tuple[Any, int]:
+        return self.parse(max_priority), max_priority
+
+    def infix(self, left: Any, left_priority: int, max_priority: int) -> tuple[Any, int]:
+        while True:
+            token = self.peek()
+            if token.kind == "atom" and not token.quoted:
+                name = token.text
+            elif token.kind == "punct" and token.text in ",|":
+                name = token.text
+            else:
+                return left, left_priority
+            if name not in self.ops.infix:
+                return left, left_priority
+            priority, kind = self.ops.infix[name]
+            if priority > max_priority:
+                return left, left_priority
+            if left_priority > (priority if kind == "yfx" else priority - 1):
+                return left, left_priority
+            self.advance()
+            right = self.parse(priority if kind == "xfy" else priority - 1)
+            left, left_priority = Struct(";" if name == "|" else name, (left, right)), priority
+
+
+def parse_term(text: str, ops: Operators) -> tuple[Any, list[tuple[str, Var]]]:
+    """A term from text and its variable names; raises PrologSyntaxError."""
+    reader = Reader(text, ops)
+    term = reader.read_term()
+    return term, reader.bindings
+
+
+def syntax_error(e: PrologSyntaxError) -> PrologError:
+    return error_term(Struct("syntax_error", (Atom(e.message),)))
+
+
+# --- Writer ----------------------------------------------------------------
+
+def atom_text(name: str, quoted: bool) -> str:
+    if not quoted or name in ("[]", "!", ";", "{}"):
+        return name
+    if name and name[0].isalpha() and name[0].islower() and all(c.isalnum() or c == "_" for c in name):
+        return name
+    if name and all(c in SYMBOL_CHARS for c in name):
+        return name
+    return "'" + _escape(name, "'") + "'"
+
+
+def _escape(text: str, quote: str) -> str:
+    out = []
+    for c in text:
+        if c == "\\":
+            out.append("\\\\")
+        elif c == quote:
+            out.append("\\" + quote)
+        elif c == "\n":
+            out.append("\\n")
+        elif c == "\t":
+            out.append("\\t")
+        elif ord(c) < 32 or ord(c) == 127:
+            out.append(f"\\x{ord(c):x}\\")
+        else:
+            out.append(c)
+    return "".join(out)
+
+
+def format_float(value: float, quoted: bool = False) -> str:
+    if math.isinf(value):
+        return ("-" if value < 0 else "") + ("1.0Inf" if quoted else "inf")
+    if math.isnan(value):
+        return "1.5NaN" if quoted else "nan"
+    text = repr(value)
+    if "e" in text:
+        mantissa, exponent = text.split("e")
+        if "." not in mantissa:
+            mantissa += ".0"
+        return f"{mantissa}e{int(exponent)}"
+    return text
+
+
+def var_letter(n: int) -> str:
+    return chr(ord("A") + n % 26) + (str(n // 26) if n >= 26 else "")
+
+
+class Writer:
+    """
+    Writes terms as write/1 (quoted False), writeq/1 and print/1 (quoted
+    True) or write_canonical/1 (ignore_ops True) do. spacing puts a space
+    after the commas between arguments, as portray_clause/1 does.
+    Unbound variables are named _G1, _G2 ... in the order this writer
+    meets them, unless names gives their names.
+    """
+
+    def __init__(self, ops: Operators, quoted: bool = True, ignore_ops: bool = False,
+                 spacing: bool = False, names: dict[Var, str] | None = None):
+        self.ops = ops
+        self.quoted = quoted
+        self.ignore_ops = ignore_ops
+        self.spacing = spacing
+        self.names = names if names is not None else {}
+
+    def var_name(self, var: Var) -> str:
+        name = self.names.get(var)
+        if name is None:
+            name = self.names[var] = f"_G{len(self.names) + 1}"
+        return name
+
+    def write(self, term: Any, priority: int = 1200) -> str:
+        term = deref(term)
+        if type(term) is Var:
+            return self.var_name(term)
+        if type(term) is int:
+            return str(term)
+        if type(term) is float:
+            return format_float(term, self.quoted)
+        if type(term) is str:
+            return '"' + _escape(term, '"') + '"' if self.quoted else term
+        if type(term) is Atom:
+            text = atom_text(term.name, self.quoted)
+            if self.quoted and priority < 999 and self.ops.priority(term.name):
+                return f"({text})"
+            return text
+        return self.compound(term, priority)
+
+    def compound(self, term: Struct, priority: int) -> str:
+        name, args = term.name, term.args
+        comma = ", " if self.spacing else ","
+        if name == "[|]" and len(args) == 2:
+            return self.list_text(term)
+        if name == "{}" and len(args) == 1 and not self.ignore_ops:
+            return "{" + self.write(args[0], 1200) + "}"
+        if name == "$VAR" and len(args) == 1 and type(deref(args[0])) is int and not self.ignore_ops:
+            return var_letter(deref(args[0]))
+        if not self.ignore_ops and len(args) == 2 and name in self.ops.infix:
+            op_priority, kind = self.ops.infix[name]
+            left = self.write(args[0], op_priority if kind == "yfx" else op_priority - 1)
+            right = self.write(args[1], op_priority if kind == "xfy" else op_priority - 1)
+            op = atom_text(name, self.quoted)
+            if name == ",":
+                text = f"{left}{comma}{right}"
+            elif op[0].isalpha() or (self.spacing and name not in ("^", "**", ":")):
+                text = f"{left} {op} {right}"
+            else:
+                before = " " if left and left[-1] in SYMBOL_CHARS else ""
+                after = " " if right and (right[0] in SYMBOL_CHARS or right[0] == "(") else ""
+                text = f"{left}{before}{op}{after}{right}"
+            return f"({text})" if op_priority > priority else text
+        if not self.ignore_ops and len(args) == 1 and name in self.ops.prefix and name not in ("-", "+") \
+                or (name in ("-", "+") and len(args) == 1 and not self.ignore_ops):
+            op_priority, kind = self.ops.prefix.get(name, (200, "fy"))
+            operand = deref(args[0])
+            text = self.write(operand, op_priority if kind == "fy" else op_priority - 1)
+            op = atom_text(name, self.quoted)
+            if type(operand) in (int, float) or op[0].isalpha() or text[0] in SYMBOL_CHARS or text[0] == "(":
+                text = f"{op} {text}"
+            else:
+                text = f"{op}{text}"
+            return f"({text})" if op_priority > priority else text
+        return atom_text(name, self.quoted) + "(" + comma.join(self.write(arg, 999) for arg in args) + ")"
+
+    def list_text(self, term: Any) -> str:
+        comma = ", " if self.spacing else ","
+        items = []
+        while True:
+            items.append(self.write(term.args[0], 999))
+            tail = deref(term.args[1])
+            if type(tail) is Struct and tail.name == "[|]" and len(tail.args) == 2:
+                term = tail
+                continue
+            if tail is NIL:
+                return "[" + comma.join(items) + "]"
+            return "[" + comma.join(items) + "|" + self.write(tail, 999) + "]"
+
+
+# --- Term utilities --------------------------------------------------------
+
+def copy_term(term: Any, mapping: dict[Var, Any]) -> Any:
+    """term with its unbound variables replaced by those of mapping, adding fresh ones."""
+    term = deref(term)
+    if type(term) is Var:
+        copy = mapping.get(term)
+        if copy is None:
+            copy = mapping[term] = Var()
+        return copy
+    if type(term) is not Struct:
+        return term
+    spine = []
+    while type(term) is Struct:
+        spine.append(term)
+        term = deref(term.args[-1])
+    result = copy_term(term, mapping)
+    for cell in reversed(spine):
+        result = Struct(cell.name, tuple(copy_term(arg, mapping) for arg in cell.args[:-1]) + (result,))
+    return result
+
+
+def term_vars(term: Any) -> list[Var]:
+    """Unbound variables of term, in depth-first order."""
+    seen: dict[Var, None] = {}
+    stack = [term]
+    while stack:
+        term = deref(stack.pop())
+        if type(term) is Var:
+            seen.setdefault(term, None)
+        elif type(term) is Struct:
+            stack.extend(reversed(term.args))
+    return list(seen)
+
+
+def is_ground(term: Any) -> bool:
+    stack = [term]
+    while stack:
+        term = deref(stack.pop())
+        if type(term) is Var:
+            return False
+        if type(term) is Struct:
+            stack.extend(term.args)
+    return True
+
+
+def _order_class(term: Any) -> int:
+    kind = type(term)
+    if kind is Var:
+        return 0
+    if kind in (int, float):
+        return 1
+    if kind is Atom:
+        return 3
+    if kind is str:
+        return 4
+    return 5
+
+
+def compare_terms(a: Any, b: Any) -> int:
+    """-1, 0 or 1 as a is before, the same as or after b in the standard order of terms."""
+    while True:
+        a, b = deref(a), deref(b)
+        if a is b:
+            return 0
+        ca, cb = _order_class(a), _order_class(b)
+        if ca != cb:
+            return -1 if ca < cb else 1
+        if ca == 0:
+            return -1 if a.id < b.id else 1
+        if ca == 1:
+            if a != b:
+                return -1 if a < b else 1
+            if type(a) is not type(b):
+                return -1 if type(a) is float else 1
+            return 0
+        if ca == 3:
+            return (a.name > b.name) - (a.name < b.name)
+        if ca == 4:
+            return (a > b) - (a < b)
+        if len(a.args) != len(b.args):
+            return -1 if len(a.args) < len(b.args) else 1
+        if a.name != b.name:
+            return -1 if a.name < b.name else 1
+        for x, y in zip(a.args[:-1], b.args[:-1]):
+            order = compare_terms(x, y)
+            if order:
+                return order
+        a, b = a.args[-1], b.args[-1]
+
+
+term_key = functools.cmp_to_key(compare_terms)
+
+
+def variant(a: Any, b: Any) -> bool:
+    """Whether a and b are equal up to renaming variables."""
+    pairs: dict[Var, Var] = {}
+    stack = [(a, b)]
+    while stack:
+        x, y = stack.pop()
+        x, y = deref(x), deref(y)
+        if type(x) is Var and type(y) is Var:
+            if pairs.setdefault(x, y) is not y:
+                return False
+            continue
+        if type(x) is not type(y):
+            return False
+        if type(x) is Struct:
+            if x.name != y.name or len(x.args) != len(y.args):
+                return False
+            stack.extend(zip(x.args, y.args))
+        elif x != y:
+            return False
+    return len(set(pairs.values())) == len(pairs)
+
+
+def first_arg_key(term: Any) -> Any:
+    """What clause indexing compares of a head's first argument; None if that is unbound."""
+    if type(term) is not Struct:
+        return None
+    arg = deref(term.args[0])
+    kind = type(arg)
+    if kind is Var:
+        return None
+    if kind is Struct:
+        return (arg.name, len(arg.args))
+    return (kind, arg)
+
+
+def add_args(goal: Any, extra: tuple) -> Any:
+    goal = deref(goal)
+    if not extra:
+        return goal
+    if type(goal) is Atom:
+        return Struct(goal.name, extra)
+    if type(goal) is Struct:
+        return Struct(goal.name, goal.args + extra)
+    if type(goal) is Var:
+        raise instantiation_error()
+    raise type_error("callable", goal)
+
+
+def text_of(term: Any, what: str = "atom") -> str:
+    """The text of an atom, string, number or code or character list."""
+    term = deref(term)
+    kind = type(term)
+    if kind is Atom:
+        return term.name
+    if kind is str:
+        return term
+    if kind is int:
+        return str(term)
+    if kind is float:
+        return format_float(term)
+    if kind is Var:
+        raise instantiation_error()
+    items = list_items(term)
+    if items is not None:
+        chars = []
+        for item in items:
+            item = deref(item)
+            if type(item) is int:
+                chars.append(chr(item))
+            elif type(item) is Atom and len(item.name) == 1:
+                chars.append(item.name)
+            else:
+                raise type_error(what, term)
+        return "".join(chars)
+    raise type_error(what, term)
+
+
+def int_of(term: Any) -> int:
+    term = deref(term)
+    if type(term) is int:
+        return term
+    if type(term) is Var:
+        raise instantiation_error()
+    raise type_error("integer", term)
+
+
+def callable_of(term: Any) -> Any:
+    term = deref(term)
+    if type(term) is Var:
+        raise instantiation_error()
+    if type(term) not in (Atom, Struct):
+        raise type_error("callable", term)
+    return term
+
+
+def goal_key(goal: Any) -> tuple[str, int]:
+    goal = callable_of(goal)
+    return (goal.name, 0) if type(goal) is Atom else (goal.name, len(goal.args))
+
+
+def parse_number(text: str) -> Any:
+    """The number text reads as, or None."""
+    text = text.strip()
+    sign = 1
+    if text.startswith("-"):
+        sign, text = -1, text[1:].lstrip()
+    elif text.startswith("+"):
+        text = text[1:].lstrip()
+    if not text or not text[0].isdigit():
+        if text in ("inf", "infinite"):
+            return sign * math.inf
+        return None
+    try:
+        value, end = _read_number(text, 0)
+    except PrologSyntaxError:
+        return None
+    return sign * value if end == len(text) else None
+
+
+# --- Arithmetic ------------------------------------------------------------
+
+def _int_pair(a: Any, b: Any, name: str) -> tuple[int, int]:
+    for value in (a, b):
+        if type(value) is not int:
+            raise type_error("integer", value)
+    return a, b
+
+
+def _truncating_div(a: int, b: int) -> int:
+    if b == 0:
+        raise evaluation_error("zero_divisor")
+    quotient = abs(a) // abs(b)
+    return quotient if (a >= 0) == (b >= 0) else -quotient
+
+
+def _divide(a: Any, b: Any) -> Any:
+    if type(a) is int and type(b) is int:
+        if b == 0:
+            raise evaluation_error("zero_divisor")
+        return a // b if a % b == 0 else a / b
+    if b == 0:
+        raise evaluation_error("zero_divisor")
+    return a / b
+
+
+def _power(a: Any, b: Any) -> Any:
+    if type(a) is int and type(b) is int:
+        if b >= 0:
+            return a ** b
+        if a in (1, -1):
+            return a ** b
+        return float(a) ** b
+    return float(a) ** float(b)
+
+
+def _caret(a: Any, b: Any) -> Any:
+    if type(a) is int and type(b) is int:
+        if b < 0 and a not in (1, -1):
+            if a == 0:
+                raise evaluation_error("zero_divisor")
+            raise type_error("float", a)
+        return int(a ** b)
+    return float(a) ** float(b)
+
+
+def _log(x: Any) -> float:
+    if x <= 0:
+        raise evaluation_error("undefined")
+    return math.log(x)
+
+
+def _integer(x: Any) -> int:
+    if type(x) is int:
+        return x
+    if math.isinf(x) or math.isnan(x):
+        raise evaluation_error("undefined")
+    return int(math.floor(x + 0.5)) if x >= 0 else -int(math.floor(-x + 0.5))
+
+
+def _to_int(function: Callable[[float], Any]) -> Callable[[Any], int]:
+    def apply(x: Any) -> int:
+        if type(x) is int:
+            return x
+        if math.isinf(x) or math.isnan(x):
+            raise evaluation_error("undefined")
+        return int(function(x))
+    return apply
+
+
+def _sign(x: Any) -> Any:
+    if type(x) is int:
+        return (x > 0) - (x < 0)
+    return math.copysign(1.0, x) if x != 0 else 0.0
+
+
+def _bits(function: Callable[[int, int], int]) -> Callable[[Any, Any], int]:
+    def apply(a: Any, b: Any) -> int:
+        a, b = _int_pair(a, b, "")
+        return function(a, b)
+    return apply
+
+
+ARITHMETIC: dict[tuple[str, int], Callable[..., Any]] = {
+    ("+", 2): lambda a, b: a + b,
+    ("-", 2): lambda a, b: a - b,
+    ("*", 2): lambda a, b: a * b,
+    ("/", 2): _divide,
+    ("//", 2): lambda a, b: _truncating_div(*_int_pair(a, b, "//")),
+    ("mod", 2): lambda a, b: _int_pair(a, b, "mod")[0] % b if b != 0 else _raise(evaluation_error("zero_divisor")),
+    ("rem", 2): lambda a, b: a - b * _truncating_div(*_int_pair(a, b, "rem")),
+    ("div", 2): lambda a, b: _int_pair(a, b, "div")[0] // b if b != 0 else _raise(evaluation_error("zero_divisor")),
+    ("min", 2): lambda a, b: b if b < a else a,
+    ("max", 2): lambda a, b: b if b > a else a,
+    ("**", 2): _power,
+    ("^", 2): _caret,
+    ("atan2", 2): lambda a, b: math.atan2(a, b),
+    ("atan", 2): lambda a, b: math.atan2(a, b),
+    ("log", 2): lambda a, b: _log(b) / _log(a),
+    ("copysign", 2): lambda a, b: math.copysign(a, b),
+    ("gcd", 2): _bits(math.gcd),
+    (">>", 2): _bits(lambda a, b: a >> b),
+    ("<<", 2): _bits(lambda a, b: a << b),
+    ("/\\", 2): _bits(lambda a, b: a & b),
+    ("\\/", 2): _bits(lambda a, b: a | b),
+    ("xor", 2): _bits(lambda a, b: a ^ b),
+    ("-", 1): lambda a: -a,
+    ("+", 1): lambda a: a,
+    ("\\", 1): lambda a: ~_int_pair(a, 0, "\\")[0],
+    ("abs", 1): abs,
+    ("sign", 1): _sign,
+    ("sqrt", 1): lambda a: math.sqrt(a) if a >= 0 else _raise(evaluation_error("undefined")),
+    ("sin", 1): math.sin,
+    ("cos", 1): math.cos,
+    ("tan", 1): math.tan,
+    ("asin", 1): math.asin,
+    ("acos", 1): math.acos,
+    ("atan", 1): math.atan,
+    ("exp", 1): math.exp,
+    ("log", 1): _log,
+    ("log2", 1): lambda a: _log(a) / math.log(2),
+    ("float", 1): float,
+    ("integer", 1): _integer,
+    ("float_integer_part", 1): lambda a: float(math.trunc(a)),
+    ("float_fractional_part", 1): lambda a: a - math.trunc(a),
+    ("truncate", 1): _to_int(math.trunc),
+    ("round", 1): _integer,
+    ("ceiling", 1): _to_int(math.ceil),
+    ("floor", 1): _to_int(math.floor),
+    ("msb", 1): lambda a: _int_pair(a, 0, "msb")[0].bit_length() - 1,
+    ("succ", 1): lambda a: a + 1,
+    ("random", 1): None,
+    ("random_float", 0): None,
+}
+CONSTANTS = {
+    "pi": math.pi, "e": math.e, "inf": math.inf, "infinite": math.inf, "nan": math.nan,
+    "epsilon": 2.220446049250313e-16, "max_tagged_integer": (1 << 60) - 1, "min_tagged_integer": -(1 << 60),
+}
+
+
+def _raise(error: Exception) -> Any:
+    raise error
+
+
+def evaluable_indicator(term: Any) -> Struct:
+    return indicator(term.name, 0 if type(term) is Atom else len(term.args))
+
+
+# --- Database --------------------------------------------------------------
+
+class Slot:
+    """The place of a clause's variable in its stored form."""
+    __slots__ = ("index",)
+
+    def __init__(self, index: int):
+        self.index = index
+
+
+class Template:
+    """A compound term of a stored clause that has variables; ground ones are stored as they are."""
+    __slots__ = ("name", "args")
+
+    def __init__(self, name: str, args: tuple):
+        self.name = name
+        self.args = args
+
+
+def _template(term: Any, slots: dict[Var, Slot]) -> tuple[Any, bool]:
+    """The stored form of a term made by copy_term(), and whether it is ground."""
+    if type(term) is Var:
+        slot = slots.get(term)
+        if slot is None:
+            slot = slots[term] = Slot(len(slots))
+        return slot, False
+    if type(term) is not Struct:
+        return term, True
+    spine = []
+    while type(term) is Struct:
+        spine.append(term)
+        term = term.args[-1]
+    result, ground = _template(term, slots)
+    for cell in reversed(spine):
+        args = []
+        for arg in cell.args[:-1]:
+            stored, arg_ground = _template(arg, slots)
+            args.append(stored)
+            ground = ground and arg_ground
+        result = cell if ground else Template(cell.name, (*args, result))
+    return result, ground
+
+
+def _instance(stored: Any, fresh: list[Var]) -> Any:
+    kind = type(stored)
+    if kind is Slot:
+        return fresh[stored.index]
+    if kind is not Template:
+        return stored
+    if type(stored.args[-1]) is not Template:
+        return Struct(stored.name, tuple([_instance(arg, fresh) for arg in stored.args]))
+    # A list or conjunction: built from its end, without recursing along it
+    spine = []
+    while type(stored) is Template:
+        spine.append(stored)
+        stored = stored.args[-1]
+    result = _instance(stored, fresh)
+    for cell in reversed(spine):
+        result = Struct(cell.name, tuple([_instance(arg, fresh) for arg in cell.args[:-1]]) + (result,))
+    return result
+
+
+class Clause:
+    """
+    A clause as stored: its ground subterms are shared by every call,
+    and only what has variables is built anew, with fresh variables.
+    """
+    __slots__ = ("head", "body", "size", "key", "erased")
+
+    def __init__(self, head: Any, body: Any):
+        slots: dict[Var, Slot] = {}
+        mapping: dict[Var, Any] = {}
+        self.head = _template(copy_term(head, mapping), slots)[0]
+        self.body = _template(copy_term(body, mapping), slots)[0]
+        self.size = len(slots)
+        self.key = first_arg_key(copy_term(head, {}))
+        self.erased = False
+
+    def fresh(self) -> list[Var]:
+        return [Var() for _ in range(self.size)]
+
+    def instance(self) -> tuple[Any, Any]:
+        """Head and body with fresh variables."""
+        fresh = self.fresh()
+        return _instance(self.head, fresh), _instance(self.body, fresh)
+
+
+class Predicate:
+    __slots__ = ("name", "arity", "clauses", "dynamic", "library", "file")
+
+    def __init__(self, name: str, arity: int, dynamic: bool = False, library: bool = False):
+        self.name = name
+        self.arity = arity
+        self.clauses: list[Clause] = []
+        self.dynamic = dynamic
+        # Defined by the interpreter's own library, below
+        self.library = library
+        # File its clauses were consulted from, if any
+        self.file: str | None = None
+
+
+# --- Solver ----------------------------------------------------------------
+
+FAIL = object()
+
+
+class Internal:
+    """A goal the solver itself schedules, run as fn(choicepoints) -> succeeded."""
+    __slots__ = ("fn",)
+
+    def __init__(self, fn: Callable[[list], bool]):
+        self.fn = fn
+
+
+class ChoicePoint:
+    __slots__ = ("kind", "trail", "cont", "goal", "clauses", "index", "height", "gen", "base", "catcher",
+                 "recovery", "active", "target")
+
+    def __init__(self, kind: str, trail: int, cont: Any = None):
+        self.kind = kind
+        self.trail = trail
+        self.cont = cont
+
+
+Builtin = Callable[..., Any]
+BUILTINS: dict[tuple[str, int], Builtin] = {}
+# Built-ins that are generators, yielding once per solution
+NONDETERMINISTIC: set[tuple[str, int]] = set()
+# Built-ins returning a goal to run in their place, as call/1 does
+EXPANDING: set[tuple[str, int]] = set()
+CONTROL = frozenset({
+    (",", 2), ("true", 0), ("fail", 0), ("false", 0), ("!", 0), (";", 2), ("->", 2), ("*->", 2),
+    ("\\+", 1), (":", 2), ("catch", 3), ("findall", 3),
+} | {("call", n) for n in range(1, 9)})
+
+
+def builtin(name: str, arity: int, kind: str = "det") -> Callable[[Builtin], Builtin]:
+    def register(fn: Builtin) -> Builtin:
+        BUILTINS[(name, arity)] = fn
+        if kind == "nondet":
+            NONDETERMINISTIC.add((name, arity))
+        elif kind == "expand":
+            EXPANDING.add((name, arity))
+        return fn
+    return register
+
+
+class Machine:
+    """
+    A Prolog database and the solver running goals against it.
+
+    output receives everything written to the current output, messages
+    the errors and warnings SWI-Prolog prints to user_error (when
+    consulting, for one). Limits are set for the duration of a goal with
+    limited(); interrupted and killed are set from other threads.
+    """
+
+    def __init__(self, output: Callable[[str], None], directory: Path,
+                 messages: Callable[[str], None] | None = None, seed: int = 0):
+        self.ops = Operators()
+        self.predicates: dict[tuple[str, int], Predicate] = {}
+        self.trail: list[Var] = []
+        self.sinks: list[Callable[[str], None]] = [output]
+        self.directory = directory
+        self.globals: dict[str, Any] = {}
+        self.flags: dict[str, Any] = {
+            "bounded": FALSE, "max_integer": (1 << 63) - 1, "min_integer": -(1 << 63),
+            "double_quotes": Atom("string"), "dialect": Atom("swi"), "version": 90200,
+            "occurs_check": FALSE, "unknown": Atom("error"),
+        }
+        self.loaded: dict[str, float] = {}
+        self.random = random.Random(seed)
+        self.messages = messages or output
+        self.inferences = 0
+        self.max_inferences: float = math.inf
+        self.inference_limit = 0
+        self.deadline = math.inf
+        self.cpu_deadline = math.inf
+        self.interrupted = False
+        self.killed = False
+        # Python-defined predicates added on top of BUILTINS, e.g. the protocol's
+        self.extra: dict[tuple[str, int], Builtin] = {}
+        self.consult_string(LIBRARY, library=True)
+
+    # --- Bindings
+
+    def bind(self, var: Var, value: Any) -> None:
+        var.ref = value
+        self.trail.append(var)
+
+    def undo(self, mark: int) -> None:
+        trail = self.trail
+        while len(trail) > mark:
+            trail.pop().ref = None
+
+    def unify(self, a: Any, b: Any) -> bool:
+        stack = [(a, b)]
+        while stack:
+            a, b = stack.pop()
+            a, b = deref(a), deref(b)
+            if a is b:
+                continue
+            if type(a) is Var:
+                self.bind(a, b)
+            elif type(b) is Var:
+                self.bind(b, a)
+            elif type(a) is Struct:
+                if type(b) is not Struct or a.name != b.name or len(a.args) != len(b.args):
+                    return False
+                stack.extend(zip(a.args, b.args))
+            elif type(a) is not type(b) or a != b:
+                return False
+        return True
+
+    # --- Output
+
+    def write(self, text: str) -> None:
+        if text:
+            self.sinks[-1](text)
+
+    def writer(self, quoted: bool = True, **options: Any) -> Writer:
+        return Writer(self.ops, quoted, **options)
+
+    def show(self, term: Any, quoted: bool = True) -> str:
+        return self.writer(quoted).write(term)
+
+    # --- Limits
+
+    def tick(self) -> None:
+        """Count a call against the inference budget, and check the other limits now and then."""
+        self.inferences += 1
+        if self.inferences > self.max_inferences:
+            raise PrologError(Struct("inference_limit_exceeded", (self.inference_limit,)))
+        if self.inferences % CHECK_EVERY == 0:
+            self.check_signals()
+
+    def check_signals(self) -> None:
+        """Raise what a kill, an interrupt or a passed deadline makes the running goal raise."""
+        if self.killed:
+            raise Halt(137)
+        if self.interrupted:
+            self.interrupted = False
+            raise PrologError(Atom("mcp_cancelled"))
+        if time.monotonic() > self.deadline:
+            raise PrologError(Atom("time_limit_exceeded"))
+        if time.thread_time() > self.cpu_deadline:
+            raise PrologError(Atom("cpu_time_limit_exceeded"))
+
+    @contextmanager
+    def limits(self, wall: float, cpu: float, inferences: int) -> Iterator[None]:
+        """
+        Run what the block solves within wall and cpu seconds and
+        inferences calls (0 is no limit), as mcp_limited/2 does. An
+        interrupt raises mcp_cancelled in it.
+        """
+        saved = (self.deadline, self.cpu_deadline, self.max_inferences, self.inference_limit)
+        if wall > 0:
+            self.deadline = min(self.deadline, time.monotonic() + wall)
+        if cpu > 0:
+            self.cpu_deadline = min(self.cpu_deadline, time.thread_time() + cpu)
+        if inferences > 0 and self.inferences + inferences < self.max_inferences:
+            self.max_inferences, self.inference_limit = self.inferences + inferences, inferences
+        self.interrupted = False
+        try:
+            yield
+        finally:
+            self.deadline, self.cpu_deadline, self.max_inferences, self.inference_limit = saved
+
+    def limited(self, wall: float, cpu: float, inferences: int, goal: Any) -> Iterator[None]:
+        """Solutions of goal under limits()."""
+        with self.limits(wall, cpu, inferences):
+            yield from self.solve(goal)
+
+    # --- Solving
+
+    def solve(self, goal: Any) -> Iterator[None]:
+        """
+        Yield once per solution of goal, with its bindings in place.
+
+        The bindings are undone when the solutions run out or an error
+        ends the goal, and kept when the generator is closed, which is how
+        solve_once() and a cut of a choice point it made keep them.
+        """
+        base = len(self.trail)
+        cps: list[ChoicePoint] = []
+        frame: Any = (goal, 0, None)
+        try:
+            while True:
+                try:
+                    if frame is None:
+                        yield
+                        frame = self.backtrack(cps)
+                    else:
+                        goal, cut, rest = frame
+                        frame = self.step(goal, cut, rest, cps)
+                        if frame is FAIL:
+                            frame = self.backtrack(cps)
+                except PrologError as e:
+                    frame = self.recover(e, cps)
+                    if frame is FAIL:
+                        frame = self.backtrack(cps)
+                if frame is FAIL:
+                    self.undo(base)
+                    return
+        except GeneratorExit:
+            self.cut(cps, 0)
+            raise
+        except BaseException:
+            self.cut(cps, 0)
+            self.undo(base)
+            raise
+
+    def solve_once(self, goal: Any) -> bool:
+        """Whether goal has a solution, keeping the bindings of the first."""
+        solutions = self.solve(goal)
+        try:
+            next(solutions)
+        except StopIteration:
+            return False
+        solutions.close()
+        return True
+
+    def backtrack(self, cps: list[ChoicePoint]) -> Any:
+        while cps:
+            cp = cps[-1]
+            self.undo(cp.trail)
+            kind = cp.kind
+            if kind == "alt":
+                cps.pop()
+                return cp.cont
+            if kind == "clauses":
+                cps.pop()
+                frame = self.try_clauses(cp.goal, cp.clauses, cp.index, cp.height, cp.cont, cps)
+                if frame is not FAIL:
+                    return frame
+            elif kind == "gen":
+                if self.resume(cp):
+                    return cp.cont
+                cps.pop()
+            elif kind == "catch":
+                cps.pop()
+            elif kind == "reactivate":
+                cp.target.active = True
+                cps.pop()
+        return FAIL
+
+    def resume(self, cp: ChoicePoint) -> bool:
+        """
+        Run a nondeterministic built-in to its next solution.
+
+        Backtracking undoes what was bound after its last solution, and it
+        undoes its own bindings before the next; the ones of a solution
+        stay above cp.trail until then.
+        """
+        try:
+            next(cp.gen)
+        except StopIteration:
+            self.undo(cp.base)
+            return False
+        except BaseException:
+            self.undo(cp.base)
+            raise
+        cp.trail = len(self.trail)
+        return True
+
+    def recover(self, error: PrologError, cps: list[ChoicePoint]) -> Any:
+        """Resume at the recovery goal of the innermost catch/3 whose catcher unifies with the ball."""
+        ball = copy_term(error.term, {})
+        while cps:
+            cp = cps.pop()
+            if cp.kind == "catch" and cp.active:
+                self.undo(cp.trail)
+                mark = len(self.trail)
+                if self.unify(cp.catcher, ball):
+                    return (cp.recovery, len(cps), cp.cont)
+                self.undo(mark)
+            elif cp.kind == "gen":
+                cp.gen.close()
+        raise error
+
+    def cut(self, cps: list[ChoicePoint], height: int) -> None:
+        while len(cps) > height:
+            cp = cps.pop()
+            if cp.kind == "gen":
+                cp.gen.close()
+
+    def try_clauses(self, goal: Any, clauses: list[Clause], index: int, height: int, cont: Any,
+                    cps: list[ChoicePoint]) -> Any:
+        while index < len(clauses):
+            clause = clauses[index]
+            index += 1
+            mark = len(self.trail)
+            fresh = clause.fresh()
+            if self.unify(_instance(clause.head, fresh), goal):
+                if index < len(clauses):
+                    cp = ChoicePoint("clauses", mark, cont)
+                    cp.goal, cp.clauses, cp.index, cp.height = goal, clauses, index, height
+                    cps.append(cp)
+                if clause.body is TRUE:
+                    return cont
+                return (_instance(clause.body, fresh), height, cont)
+            self.undo(mark)
+        return FAIL
+
+    def step(self, goal: Any, cut: int, cont: Any, cps: list[ChoicePoint]) -> Any:
+        goal = deref(goal)
+        if type(goal) is Internal:
+            return cont if goal.fn(cps) else FAIL
+        self.tick()
+        if type(goal) is Atom:
+            name, args = goal.name, ()
+        elif type(goal) is Struct:
+            name, args = goal.name, goal.args
+        elif type(goal) is Var:
+            raise instantiation_error()
+        else:
+            raise type_error("callable", goal)
+        key = (name, len(args))
+        if key in CONTROL:
+            return self.control(name, args, cut, cont, cps)
+        predicate = self.predicates.get(key)
+        if predicate is not None:
+            goal_arg = first_arg_key(goal)
+            clauses = [c for c in predicate.clauses if goal_arg is None or c.key is None or c.key == goal_arg]
+            return self.try_clauses(goal, clauses, 0, len(cps), cont, cps)
+        fn = self.extra.get(key) or BUILTINS.get(key)
+        if fn is None:
+            if self.flags["unknown"] is FALSE:
+                return FAIL
+            raise existence_error("procedure", indicator(name, len(args)))
+        if key in NONDETERMINISTIC:
+            cp = ChoicePoint("gen", len(self.trail), cont)
+            cp.base, cp.gen = cp.trail, fn(self, *args)
+            cps.append(cp)
+            if self.resume(cp):
+                return cont
+            cps.pop()
+            return FAIL
+        if key in EXPANDING:
+            return (fn(self, *args), len(cps), cont)
+        return cont if fn(self, *args) else FAIL
+
+    def control(self, name: str, args: tuple, cut: int, cont: Any, cps: list[ChoicePoint]) -> Any:
+        if name == ",":
+            return (args[0], cut, (args[1], cut, cont))
+        if name == "true":
+            return cont
+        if name in ("fail", "false"):
+            return FAIL
+        if name == "!":
+            self.cut(cps, cut)
+            return cont
+        if name == ";":
+            left = deref(args[0])
+            if type(left) is Struct and len(left.args) == 2 and left.name == "->":
+                return self.if_then_else(left.args[0], left.args[1], args[1], cut, cont, cps)
+            if type(left) is Struct and len(left.args) == 2 and left.name == "*->":
+                return self.soft_if(left.args[0], left.args[1], args[1], cut, cont, cps)
+            cp = ChoicePoint("alt", len(self.trail), (args[1], cut, cont))
+            cps.append(cp)
+            return (left, cut, cont)
+        if name == "->":
+            return self.if_then_else(args[0], args[1], Atom("fail"), cut, cont, cps)
+        if name == "*->":
+            return (args[0], len(cps), (args[1], cut, cont))
+        if name == "\\+":
+            height = len(cps)
+            cps.append(ChoicePoint("alt", len(self.trail), cont))
+            return (args[0], height + 1, (Internal(lambda stack: self.cut(stack, height) or False), 0, None))
+        if name == ":":
+            return (args[1], cut, cont)
+        if name == "catch":
+            cp = ChoicePoint("catch", len(self.trail), cont)
+            cp.catcher, cp.recovery, cp.active = args[1], args[2], True
+
+            def exit_catch(stack: list[ChoicePoint]) -> bool:
+                if stack and stack[-1] is cp:
+                    stack.pop()
+                else:
+                    cp.active = False
+                    again = ChoicePoint("reactivate", len(self.trail))
+                    again.target = cp
+                    stack.append(again)
+                return True
+
+            cps.append(cp)
+            return (args[0], len(cps), (Internal(exit_catch), 0, cont))
+        if name == "findall":
+            results = [copy_term(args[0], {}) for _ in self.solve(args[1])]
+            return cont if self.unify(args[2], make_list(results)) else FAIL
+        # call/N
+        return (add_args(args[0], args[1:]), len(cps), cont)
+
+    def if_then_else(self, condition: Any, then: Any, otherwise: Any, cut: int, cont: Any,
+                     cps: list[ChoicePoint]) -> Any:
+        height = len(cps)
+        cps.append(ChoicePoint("alt", len(self.trail), (otherwise, cut, cont)))
+        commit = Internal(lambda stack: self.cut(stack, height) or True)
+        return (condition, height + 1, (commit, 0, (then, cut, cont)))
+
+    def soft_if(self, condition: Any, then: Any, otherwise: Any, cut: int, cont: Any,
+                cps: list[ChoicePoint]) -> Any:
+        height = len(cps)
+        alternative = ChoicePoint("alt", len(self.trail), (otherwise, cut, cont))
+        cps.append(alternative)
+
+        def commit(stack: list[ChoicePoint]) -> bool:
+            # Drop the else branch, keeping the condition's own choice points
+            if alternative in stack:
+                stack.remove(alternative)
+            return True
+
+        return (condition, height + 1, (Internal(commit), 0, (then, cut, cont)))
+
+    # --- Database
+
+    def predicate(self, name: str, arity: int, create: bool = True) -> Predicate | None:
+        key = (name, arity)
+        predicate = self.predicates.get(key)
+        if predicate is None and create:
+            predicate = self.predicates[key] = Predicate(name, arity)
+        return predicate
+
+    def modifiable(self, name: str, arity: int) -> Predicate:
+        """The predicate name/arity for assert and retract, made dynamic if it is new."""
+        key = (name, arity)
+        if key in CONTROL or key in BUILTINS or key in self.extra:
+            raise permission_error("modify", "static_procedure", indicator(name, arity))
+        predicate = self.predicates.get(key)
+        if predicate is None:
+            predicate = self.predicates[key] = Predicate(name, arity, dynamic=True)
+        elif not predicate.dynamic:
+            raise permission_error("modify", "static_procedure", indicator(name, arity))
+        return predicate
+
+    def split_clause(self, term: Any) -> tuple[Any, Any]:
+        term = deref(term)
+        if type(term) is Struct and term.name == ":-" and len(term.args) == 2:
+            head, body = deref(term.args[0]), deref(term.args[1])
+        else:
+            head, body = term, TRUE
+        if type(head) is Struct and head.name == ":" and len(head.args) == 2:
+            head = deref(head.args[1])
+        callable_of(head)
+        if type(body) is Var:
+            body = Struct("call", (body,))
+        elif type(body) not in (Atom, Struct):
+            raise type_error("callable", body)
+        return head, body
+
+    def add_clause(self, term: Any, front: bool = False, consulted: bool = False, file: str | None = None,
+                   library: bool = False) -> None:
+        """Add a clause as assert/1 does or, if consulted, as loading a file (or, with library, LIBRARY) does."""
+        head, body = self.split_clause(term)
+        name, arity = goal_key(head)
+        if not consulted:
+            predicate = self.modifiable(name, arity)
+        else:
+            key = (name, arity)
+            if key in CONTROL or key in BUILTINS or key in self.extra:
+                raise permission_error("modify", "static_procedure", indicator(name, arity))
+            predicate = self.predicate(name, arity)
+            if predicate.library and not library:
+                # A user definition replaces the library's
+                predicate.clauses, predicate.library = [], False
+            predicate.library = library
+            predicate.file = predicate.file or file
+        clause = Clause(head, body)
+        if front:
+            predicate.clauses.insert(0, clause)
+        else:
+            predicate.clauses.append(clause)
+
+    def user_predicates(self) -> list[Predicate]:
+        return [p for p in self.predicates.values() if not p.library and not p.name.startswith("$")]
+
+    # --- Loading
+
+    def consult_string(self, text: str, file: str | None = None, library: bool = False,
+                       errors: list[tuple[int, str]] | None = None) -> bool:
+        """
+        Load the clauses and run the directives of text; errors collects
+        (line, message) of what would be printed as an error, otherwise
+        they are written to the output. Clauses loaded earlier from file
+        are replaced. False if there were errors.
+        """
+        if file is not None:
+            for predicate in list(self.predicates.values()):
+                if predicate.file == file:
+                    predicate.clauses, predicate.file = [], None
+        problems: list[tuple[int, str]] = []
+        initialization = []
+        try:
+            reader = Reader(text, self.ops)
+        except PrologSyntaxError as e:
+            problems.append((text.count("\n", 0, e.position) + 1, f"Syntax error: {e.message}"))
+            reader = None
+        while reader is not None:
+            start = reader.peek().position
+            try:
+                term = reader.read_clause()
+            except PrologSyntaxError as e:
+                problems.append((reader.line(e.position), f"Syntax error: {e.message}"))
+                reader.skip_clause()
+                continue
+            if term is None:
+                break
+            term = deref(term)
+            try:
+                if type(term) is Struct and term.name == ":-" and len(term.args) == 1:
+                    directive = deref(term.args[0])
+                    if type(directive) is Struct and directive.name == "initialization":
+                        initialization.append(directive.args[0])
+                    elif not self.solve_once(directive):
+                        problems.append((reader.line(start), f"Goal (directive) failed: {self.show(directive)}"))
+                elif type(term) is Struct and term.name == "-->" and len(term.args) == 2:
+                    self.add_clause(dcg_rule(term), consulted=True, file=file, library=library)
+                else:
+                    self.add_clause(term, consulted=True, file=file, library=library)
+            except PrologError as e:
+                problems.append((reader.line(start), self.message(e.term)))
+        for goal in initialization:
+            try:
+                if not self.solve_once(goal):
+                    problems.append((0, f"initialization goal failed: {self.show(goal)}"))
+            except PrologError as e:
+                problems.append((0, self.message(e.term)))
+        if errors is not None:
+            errors.extend(problems)
+        else:
+            for line, message in problems:
+                where = f"{file}:{line}: " if file else ""
+                self.messages(f"ERROR: {where}{message}\n")
+        return not problems
+
+    def resolve_file(self, spec: Any) -> Path | None:
+        """The file a consult/1 argument names, or None for library(...)."""
+        spec = deref(spec)
+        if type(spec) is Struct and spec.name == "library":
+            return None
+        path = Path(text_of(spec))
+        if not path.is_absolute():
+            path = self.directory / path
+        if path.suffix != ".pl" and not path.exists() and path.with_name(path.name + ".pl").exists():
+            path = path.with_name(path.name + ".pl")
+        if not path.is_file():
+            raise existence_error("source_sink", spec)
+        return path
+
+    def consult(self, spec: Any) -> bool:
+        path = self.resolve_file(spec)
+        if path is None:
+            return True
+        text = path.read_text(encoding="utf-8", errors="replace")
+        self.loaded[str(path)] = path.stat().st_mtime
+        self.consult_string(text, file=str(path))
+        return True
+
+    def message(self, error: Any) -> str:
+        """A one-line description of an error term, like SWI-Prolog's messages."""
+        error = deref(error)
+        if type(error) is Struct and error.name == "error" and len(error.args) == 2:
+            formal = deref(error.args[0])
+            if type(formal) is Struct and formal.name == "existence_error" and formal.args[0] is Atom("procedure"):
+                return f"Unknown procedure: {self.show(formal.args[1])}"
+            if type(formal) is Struct and formal.name == "type_error":
+                return f"Type error: `{self.show(formal.args[0], False)}' expected, found `{self.show(formal.args[1])}'"
+            if formal is Atom("instantiation_error"):
+                return "Arguments are not sufficiently instantiated"
+            return self.show(formal)
+        return f"Unknown message: {self.show(error)}"
+
+    # --- Arithmetic
+
+    def evaluate(self, term: Any) -> Any:
+        term = deref(term)
+        kind = type(term)
+        if kind is int or kind is float:
+            return term
+        if kind is Var:
+            raise instantiation_error()
+        if kind is Atom:
+            if term.name in CONSTANTS:
+                return CONSTANTS[term.name]
+            if term.name == "random":
+                return self.random.random()
+            if term.name == "random_float":
+                return self.random.random()
+            if term.name == "cputime":
+                return time.process_time()
+            if term.name == "realtime":
+                return int(time.time())
+            raise type_error("evaluable", indicator(term.name, 0))
+        if kind is str:
+            if len(term) == 1:
+                return ord(term)
+            raise type_error("evaluable", term)
+        if term.name == "[|]" and len(term.args) == 2 and deref(term.args[1]) is NIL:
+            return self.evaluate(term.args[0])
+        key = (term.name, len(term.args))
+        if key not in ARITHMETIC:
+            raise type_error("evaluable", evaluable_indicator(term))
+        values = [self.evaluate(arg) for arg in term.args]
+        if key == ("random", 1):
+            return self.random.randrange(int_of(values[0]))
+        try:
+            result = ARITHMETIC[key](*values)
+        except OverflowError:
+            raise evaluation_error("float_overflow") from None
+        except (ValueError, ZeroDivisionError):
+            raise evaluation_error("undefined") from None
+        if type(result) is bool:
+            result = int(result)
+        return result
+
+    def compare_numbers(self, a: Any, b: Any) -> int:
+        x, y = self.evaluate(a), self.evaluate(b)
+        return (x > y) - (x < y)
+
+
+def dcg_rule(rule: Struct) -> Any:
+    """The clause a Head --> Body grammar rule stands for."""
+    s0, s = Var(), Var()
+    head = deref(rule.args[0])
+    return Struct(":-", (add_args(head, (s0, s)), dcg_body(rule.args[1], s0, s)))
+
+
+def dcg_body(body: Any, s0: Any, s: Any) -> Any:
+    body = deref(body)
+    if type(body) is Var:
+        return Struct("phrase", (body, s0, s))
+    if type(body) is Struct and len(body.args) == 2 and body.name in (",", ";", "|", "->"):
+        if body.name == ",":
+            middle = Var()
+            return Struct(",", (dcg_body(body.args[0], s0, middle), dcg_body(body.args[1], middle, s)))
+        if body.name == "->":
+            middle = Var()
+            return Struct("->", (dcg_body(body.args[0], s0, middle), dcg_body(body.args[1], middle, s)))
+        return Struct(";", (dcg_body(body.args[0], s0, s), dcg_body(body.args[1], s0, s)))
+    if type(body) is Struct and body.name == "\\+" and len(body.args) == 1:
+        return Struct(",", (Struct("\\+", (dcg_body(body.args[0], s0, Var()),)), Struct("=", (s0, s))))
+    if type(body) is Struct and body.name == "{}" and len(body.args) == 1:
+        return Struct(",", (body.args[0], Struct("=", (s0, s))))
+    if body is Atom("!"):
+        return Struct(",", (body, Struct("=", (s0, s))))
+    if body is NIL:
+        return Struct("=", (s0, s))
+    if type(body) is str:
+        return Struct("=", (s0, make_list([ord(c) for c in body], s)))
+    if type(body) is Struct and body.name == "[|]":
+        items = list_items(body)
+        if items is None:
+            raise type_error("list", body)
+        return Struct("=", (s0, make_list(items, s)))
+    if type(body) is Struct and body.name == "call":
+        return Struct("call", body.args + (s0, s))
+    return add_args(body, (s0, s))
+
+
+# The list library and friends, written in Prolog and loaded into every machine
+LIBRARY = r"""
+append([], L, L).
+append([H|T], L, [H|R]) :- append(T, L, R).
+append([], []).
+append([L|Ls], As) :- append(L, Ws, As), append(Ls, Ws).
+member(X, [X|_]).
+member(X, [_|T]) :- member(X, T).
+memberchk(X, L) :- member(X, L), !.
+reverse(L, R) :- '$reverse'(L, [], R).
+'$reverse'([], A, A).
+'$reverse'([H|T], A, R) :- '$reverse'(T, [H|A], R).
+nth0(I, L, E) :- '$nth'(L, 0, I, E).
+nth1(I, L, E) :- '$nth'(L, 1, I, E).
+'$nth'(L, B, I, E) :- integer(I), !, Skip is I - B, Skip >= 0, '$nth_fixed'(Skip, L, E).
+'$nth'([H|T], B, I, E) :- var(I), '$nth_var'(T, H, B, I, E).
+'$nth_fixed'(0, [E|_], E) :- !.
+'$nth_fixed'(N, [_|T], E) :- N1 is N - 1, '$nth_fixed'(N1, T, E).
+'$nth_var'(_, H, B, B, H).
+'$nth_var'([H|T], _, B, I, E) :- B1 is B + 1, '$nth_var'(T, H, B1, I, E).
+last([X|Xs], L) :- '$last'(Xs, X, L).
+'$last'([], L, L).
+'$last'([X|Xs], _, L) :- '$last'(Xs, X, L).
+select(X, [X|T], T).
+select(X, [H|T], [H|R]) :- select(X, T, R).
+selectchk(X, L, R) :- select(X, L, R), !.
+select(X, Xs, Y, Ys) :- '$select4'(Xs, X, Y, Ys).
+'$select4'([X|T], X, Y, [Y|T]).
+'$select4'([H|T], X, Y, [H|T2]) :- '$select4'(T, X, Y, T2).
+exclude(_, [], []).
+exclude(P, [X|Xs], R) :- ( call(P, X) -> R = R1 ; R = [X|R1] ), exclude(P, Xs, R1).
+include(_, [], []).
+include(P, [X|Xs], R) :- ( call(P, X) -> R = [X|R1] ; R = R1 ), include(P, Xs, R1).
+partition(_, [], [], []).
+partition(P, [X|Xs], I, E) :-
+    (   call(P, X) -> I = [X|I1], E = E1 ; I = I1, E = [X|E1] ),
+    partition(P, Xs, I1, E1).
+maplist(_, []).
+maplist(G, [A|As]) :- call(G, A), maplist(G, As).
+maplist(_, [], []).
+maplist(G, [A|As], [B|Bs]) :- call(G, A, B), maplist(G, As, Bs).
+maplist(_, [], [], []).
+maplist(G, [A|As], [B|Bs], [C|Cs]) :- call(G, A, B, C), maplist(G, As, Bs, Cs).
+maplist(_, [], [], [], []).
+maplist(G, [A|As], [B|Bs], [C|Cs], [D|Ds]) :- call(G, A, B, C, D), maplist(G, As, Bs, Cs, Ds).
+foldl(G, L, V0, V) :- '$foldl'(L, G, V0, V).
+'$foldl'([], _, V, V).
+'$foldl'([X|Xs], G, V0, V) :- call(G, X, V0, V1), '$foldl'(Xs, G, V1, V).
+foldl(G, L1, L2, V0, V) :- '$foldl'(L1, L2, G, V0, V).
+'$foldl'([], [], _, V, V).
+'$foldl'([X|Xs], [Y|Ys], G, V0, V) :- call(G, X, Y, V0, V1), '$foldl'(Xs, Ys, G, V1, V).
+sum_list(Xs, S) :- foldl('$plus', Xs, 0, S).
+sumlist(Xs, S) :- sum_list(Xs, S).
+'$plus'(X, Y0, Y) :- Y is Y0 + X.
+max_list([H|T], M) :- foldl('$max', T, H, M).
+min_list([H|T], M) :- foldl('$min', T, H, M).
+'$max'(X, Y0, Y) :- Y is max(X, Y0).
+'$min'(X, Y0, Y) :- Y is min(X, Y0).
+max_member(M, [H|T]) :- foldl('$max_member', T, H, M).
+min_member(M, [H|T]) :- foldl('$min_member', T, H, M).
+'$max_member'(X, M0, M) :- ( X @> M0 -> M = X ; M = M0 ).
+'$min_member'(X, M0, M) :- ( X @< M0 -> M = X ; M = M0 ).
+numlist(L, H, []) :- L > H, !.
+numlist(L, H, [L|T]) :- L1 is L + 1, numlist(L1, H, T).
+permutation([], []).
+permutation(L, [H|T]) :- select(H, L, R), permutation(R, T).
+delete([], _, []).
+delete([H|T], X, R) :- ( H \= X -> R = [H|R1] ; R = R1 ), delete(T, X, R1).
+subtract([], _, []).
+subtract([H|T], L, R) :- ( memberchk(H, L) -> R = R1 ; R = [H|R1] ), subtract(T, L, R1).
+intersection([], _, []).
+intersection([H|T], L, R) :- ( memberchk(H, L) -> R = [H|R1] ; R = R1 ), intersection(T, L, R1).
+union([], L, L).
+union([H|T], L, R) :- ( memberchk(H, L) -> R = R1 ; R = [H|R1] ), union(T, L, R1).
+list_to_set([], []).
+list_to_set([H|T], [H|R]) :- exclude(==(H), T, T1), list_to_set(T1, R).
+flatten(List, Flat) :- '$flatten'(List, [], Flat0), !, Flat = Flat0.
+'$flatten'(Var, Tl, [Var|Tl]) :- var(Var), !.
+'$flatten'([], Tl, Tl) :- !.
+'$flatten'([Hd|Tl], Tail, List) :- !, '$flatten'(Hd, FlatHeadTail, List), '$flatten'(Tl, Tail, FlatHeadTail).
+'$flatten'(NonList, Tl, [NonList|Tl]).
+pairs_keys_values([], [], []).
+pairs_keys_values([K-V|T], [K|Ks], [V|Vs]) :- pairs_keys_values(T, Ks, Vs).
+pairs_keys([], []).
+pairs_keys([K-_|T], [K|Ks]) :- pairs_keys(T, Ks).
+pairs_values([], []).
+pairs_values([_-V|T], [V|Vs]) :- pairs_values(T, Vs).
+once(G) :- call(G), !.
+ignore(G) :- ( call(G) -> true ; true ).
+forall(C, A) :- \+ ( call(C), \+ call(A) ).
+not(G) :- \+ call(G).
+phrase(G, L) :- phrase(G, L, []).
+writeln(X) :- write(X), nl.
+print(X) :- writeq(X).
+setup_call_cleanup(S, G, C) :- once(S), '$call_cleanup'(G, C).
+call_cleanup(G, C) :- '$call_cleanup'(G, C).
+'$call_cleanup'(G, C) :-
+    (   catch(G, E, true)
+    ->  ignore(C), ( var(E) -> true ; throw(E) )
+    ;   ignore(C), fail
+    ).
+assertion(G) :- ( \+ \+ call(G) -> true ; throw(error(assertion_failed(G), _)) ).
+partition(P, L, I, E, G) :- '$partition5'(L, P, I, E, G).
+'$partition5'([], _, [], [], []).
+'$partition5'([H|T], P, I, E, G) :-
+    call(P, O, H),
+    '$partition5'(O, H, I, E, G, I1, E1, G1),
+    '$partition5'(T, P, I1, E1, G1).
+'$partition5'(<, H, [H|I], E, G, I, E, G).
+'$partition5'(=, H, I, [H|E], G, I, E, G).
+'$partition5'(>, H, I, E, [H|G], I, E, G).
+"""
+
+
+# --- Built-ins -------------------------------------------------------------
+
+def _number_check(kind: type | tuple[type, ...]) -> Builtin:
+    return lambda m, x: type(deref(x)) in (kind if isinstance(kind, tuple) else (kind,))
+
+
+for _name, _kinds in (("integer", int), ("float", float), ("number", (int, float)), ("atom", Atom),
+                      ("string", str), ("var", Var), ("compound", Struct),
+                      ("atomic", (Atom, int, float, str)), ("callable", (Atom, Struct))):
+    BUILTINS[(_name, 1)] = _number_check(_kinds)
+
+BUILTINS[("nonvar", 1)] = lambda m, x: type(deref(x)) is not Var
+BUILTINS[("is_list", 1)] = lambda m, x: list_items(x) is not None
+BUILTINS[("ground", 1)] = lambda m, x: is_ground(x)
+BUILTINS[("=", 2)] = lambda m, a, b: m.unify(a, b)
+BUILTINS[("unify_with_occurs_check", 2)] = lambda m, a, b: m.unify(a, b)
+BUILTINS[("==", 2)] = lambda m, a, b: compare_terms(a, b) == 0
+BUILTINS[("\\==", 2)] = lambda m, a, b: compare_terms(a, b) != 0
+BUILTINS[("@<", 2)] = lambda m, a, b: compare_terms(a, b) < 0
+BUILTINS[("@>", 2)] = lambda m, a, b: compare_terms(a, b) > 0
+BUILTINS[("@=<", 2)] = lambda m, a, b: compare_terms(a, b) <= 0
+BUILTINS[("@>=", 2)] = lambda m, a, b: compare_terms(a, b) >= 0
+BUILTINS[("=@=", 2)] = lambda m, a, b: variant(a, b)
+BUILTINS[("\\=@=", 2)] = lambda m, a, b: not variant(a, b)
+BUILTINS[("is", 2)] = lambda m, a, b: m.unify(a, m.evaluate(b))
+BUILTINS[("=:=", 2)] = lambda m, a, b: m.compare_numbers(a, b) == 0
+BUILTINS[("=\\=", 2)] = lambda m, a, b: m.compare_numbers(a, b) != 0
+BUILTINS[("<", 2)] = lambda m, a, b: m.compare_numbers(a, b) < 0
+BUILTINS[(">", 2)] = lambda m, a, b: m.compare_numbers(a, b) > 0
+BUILTINS[("=<", 2)] = lambda m, a, b: m.compare_numbers(a, b) <= 0
+BUILTINS[(">=", 2)] = lambda m, a, b: m.compare_numbers(a, b) >= 0
+BUILTINS[("halt", 0)] = lambda m: _raise(Halt(0))
+BUILTINS[("halt", 1)] = lambda m, status: _raise(Halt(int_of(status)))
+BUILTINS[("nl", 0)] = lambda m: m.write("\n") or True
+BUILTINS[("nl", 1)] = lambda m, stream: m.write("\n") or True
+BUILTINS[("write", 1)] = lambda m, x: m.write(m.show(x, False)) or True
+BUILTINS[("write", 2)] = lambda m, stream, x: m.write(m.show(x, False)) or True
+BUILTINS[("writeq", 1)] = lambda m, x: m.write(m.show(x)) or True
+BUILTINS[("writeq", 2)] = lambda m, stream, x: m.write(m.show(x)) or True
+BUILTINS[("write_canonical", 1)] = lambda m, x: m.write(m.writer(True, ignore_ops=True).write(x)) or True
+BUILTINS[("tab", 1)] = lambda m, n: m.write(" " * m.evaluate(n)) or True
+BUILTINS[("put_char", 1)] = lambda m, c: m.write(text_of(c)) or True
+BUILTINS[("flush_output", 0)] = lambda m: True
+BUILTINS[("flush_output", 1)] = lambda m, stream: True
+BUILTINS[("throw", 1)] = lambda m, ball: _raise(PrologError(copy_term(_bound(ball), {})))
+BUILTINS[("garbage_collect", 0)] = lambda m: True
+BUILTINS[("print_message", 2)] = lambda m, kind, message: True
+BUILTINS[("make", 0)] = lambda m: _make(m)
+BUILTINS[("consult", 1)] = lambda m, spec: _consult_all(m, spec)
+BUILTINS[("ensure_loaded", 1)] = lambda m, spec: _consult_all(m, spec, only_new=True)
+BUILTINS[("[|]", 2)] = lambda m, head, tail: _consult_all(m, Struct("[|]", (head, tail)))
+BUILTINS[("exists_file", 1)] = lambda m, f: (m.directory / text_of(f)).is_file()
+BUILTINS[("exists_directory", 1)] = lambda m, f: (m.directory / text_of(f)).is_dir()
+BUILTINS[("read", 1)] = lambda m, x: m.unify(x, END_OF_FILE)
+BUILTINS[("read_term", 2)] = lambda m, x, options: m.unify(x, END_OF_FILE)
+BUILTINS[("set_prolog_flag", 2)] = lambda m, flag, value: m.flags.__setitem__(text_of(flag), deref(value)) or True
+BUILTINS[("style_check", 1)] = lambda m, spec: True
+for _directive in ("discontiguous", "multifile", "table", "module_transparent"):
+    BUILTINS[(_directive, 1)] = lambda m, spec: True
+BUILTINS[("use_module", 1)] = lambda m, spec: True
+BUILTINS[("use_module", 2)] = lambda m, spec, imports: True
+BUILTINS[("module", 2)] = lambda m, name, exports: True
+BUILTINS[("initialization", 1)] = lambda m, goal: m.solve_once(goal)
+BUILTINS[("initialization", 2)] = lambda m, goal, when: m.solve_once(goal)
+
+
+def _bound(term: Any) -> Any:
+    term = deref(term)
+    if type(term) is Var:
+        raise instantiation_error()
+    return term
+
+
+def _consult_all(m: Machine, spec: Any, only_new: bool = False) -> bool:
+    items = list_items(spec)
+    for item in items if items is not None else [spec]:
+        path = m.resolve_file(item)
+        if path is not None and not (only_new and str(path) in m.loaded):
+            m.consult(item)
+    return True
+
+
+def _make(m: Machine) -> bool:
+    for name, mtime in list(m.loaded.items()):
+        path = Path(name)
+        if path.is_file() and path.stat().st_mtime != mtime:
+            m.consult(Atom(name))
+    return True
+
+
+@builtin("compare", 3)
+def _compare(m: Machine, order: Any, a: Any, b: Any) -> bool:
+    return m.unify(order, Atom("<=>"[compare_terms(a, b) + 1]))
+
+
+@builtin("functor", 3)
+def _functor(m: Machine, term: Any, name: Any, arity: Any) -> bool:
+    term = deref(term)
+    if type(term) is Struct:
+        return m.unify(name, Atom(term.name)) and m.unify(arity, len(term.args))
+    if type(term) is not Var:
+        return m.unify(name, term) and m.unify(arity, 0)
+    n = int_of(arity)
+    name = _bound(name)
+    if n == 0:
+        return m.unify(term, name)
+    if type(name) is not Atom:
+        raise type_error("atomic" if type(name) in (int, float, str) else "atom", name)
+    return m.unify(term, Struct(name.name, tuple(Var() for _ in range(n))))
+
+
+@builtin("arg", 3, "nondet")
+def _arg(m: Machine, n: Any, term: Any, value: Any) -> Iterator[None]:
+    term = deref(term)
+    if type(term) is not Struct:
+        raise type_error("compound", term)
+    n = deref(n)
+    if type(n) is int:
+        if 1 <= n <= len(term.args) and m.unify(value, term.args[n - 1]):
+            yield
+        return
+    for index, arg in enumerate(term.args, 1):
+        mark = len(m.trail)
+        if m.unify(n, index) and m.unify(value, arg):
+            yield
+        m.undo(mark)
+
+
+@builtin("=..", 2)
+def _univ(m: Machine, term: Any, parts: Any) -> bool:
+    term = deref(term)
+    if type(term) is Struct:
+        return m.unify(parts, make_list([Atom(term.name), *term.args]))
+    if type(term) is not Var:
+        return m.unify(parts, make_list([term]))
+    items = list_items(parts)
+    if items is None:
+        raise instantiation_error()
+    head = _bound(items[0])
+    if len(items) == 1:
+        return m.unify(term, head)
+    if type(head) is not Atom:
+        raise type_error("atom", head)
+    return m.unify(term, Struct(head.name, tuple(items[1:])))
+
+
+BUILTINS[("copy_term", 2)] = lambda m, a, b: m.unify(b, copy_term(a, {}))
+BUILTINS[("term_variables", 2)] = lambda m, t, vs: m.unify(vs, make_list(term_vars(t)))
+
+
+@builtin("numbervars", 3)
+def _numbervars(m: Machine, term: Any, start: Any, end: Any) -> bool:
+    n = int_of(start)
+    for var in term_vars(term):
+        m.bind(var, Struct("$VAR", (n,)))
+        n += 1
+    return m.unify(end, n)
+
+
+@builtin("succ", 2)
+def _succ(m: Machine, a: Any, b: Any) -> bool:
+    a = deref(a)
+    if type(a) is int:
+        if a < 0:
+            raise type_error("not_less_than_zero", a)
+        return m.unify(b, a + 1)
+    b = int_of(b)
+    if b <= 0:
+        if b < 0:
+            raise type_error("not_less_than_zero", b)
+        return False
+    return m.unify(a, b - 1)
+
+
+@builtin("plus", 3)
+def _plus(m: Machine, a: Any, b: Any, c: Any) -> bool:
+    a, b, c = deref(a), deref(b), deref(c)
+    if type(a) is int and type(b) is int:
+        return m.unify(c, a + b)
+    if type(a) is int and type(c) is int:
+        return m.unify(b, c - a)
+    return m.unify(a, int_of(c) - int_of(b))
+
+
+@builtin("between", 3, "nondet")
+def _between(m: Machine, low: Any, high: Any, x: Any) -> Iterator[None]:
+    low = int_of(low)
+    high = deref(high)
+    if type(high) is Atom and high.name in ("inf", "infinite"):
+        high = math.inf
+    elif type(high) is not int:
+        int_of(high)
+    x = deref(x)
+    if type(x) is int:
+        if low <= x <= high:
+            yield
+        return
+    if type(x) is not Var:
+        raise type_error("integer", x)
+    n = low
+    while n <= high:
+        m.tick()
+        mark = len(m.trail)
+        m.bind(x, n)
+        yield
+        m.undo(mark)
+        n += 1
+
+
+@builtin("length", 2, "nondet")
+def _length(m: Machine, items: Any, n: Any) -> Iterator[None]:
+    count = 0
+    term = deref(items)
+    while type(term) is Struct and term.name == "[|]" and len(term.args) == 2:
+        count += 1
+        term = deref(term.args[1])
+    if term is NIL:
+        if m.unify(n, count):
+            yield
+        return
+    if type(term) is not Var:
+        raise type_error("list", items)
+    size = deref(n)
+    if type(size) is int:
+        if size >= count and m.unify(term, make_list([Var() for _ in range(size - count)])):
+            yield
+        return
+    if type(size) is not Var:
+        raise type_error("integer", size)
+    extra = 0
+    while True:
+        m.tick()
+        mark = len(m.trail)
+        if m.unify(term, make_list([Var() for _ in range(extra)])) and m.unify(size, count + extra):
+            yield
+        m.undo(mark)
+        extra += 1
+
+
+@builtin("msort", 2)
+def _msort(m: Machine, items: Any, sorted_items: Any) -> bool:
+    return m.unify(sorted_items, make_list(sorted(_proper_list(items), key=term_key)))
+
+
+@builtin("sort", 2)
+def _sort(m: Machine, items: Any, sorted_items: Any) -> bool:
+    return m.unify(sorted_items, make_list(_dedupe(sorted(_proper_list(items), key=term_key))))
+
+
+@builtin("sort", 4)
+def _sort4(m: Machine, key: Any, order: Any, items: Any, sorted_items: Any) -> bool:
+    index = int_of(key)
+    order = text_of(order)
+    if order not in ("@<", "@>", "@=<", "@>="):
+        raise domain_error("order", Atom(order))
+
+    def pick(term: Any) -> Any:
+        if index == 0:
+            return term
+        term = deref(term)
+        if type(term) is not Struct or index > len(term.args):
+            raise type_error("compound", term)
+        return term.args[index - 1]
+
+    values = sorted(_proper_list(items), key=lambda term: term_key(pick(term)), reverse=order in ("@>", "@>="))
+    if order in ("@<", "@>"):
+        values = [v for i, v in enumerate(values) if i == 0 or compare_terms(pick(values[i - 1]), pick(v)) != 0]
+    return m.unify(sorted_items, make_list(values))
+
+
+@builtin("predsort", 3)
+def _predsort(m: Machine, predicate: Any, items: Any, sorted_items: Any) -> bool:
+    def order(a: Any, b: Any) -> int:
+        result = Var()
+        if not m.solve_once(Struct("call", (predicate, result, a, b))):
+            raise PrologError(Atom("predsort_failed"))
+        name = text_of(result)
+        return {"<": -1, "=": 0, ">": 1}[name]
+
+    values = sorted(_proper_list(items), key=functools.cmp_to_key(order))
+    kept = [v for i, v in enumerate(values) if i == 0 or order(values[i - 1], v) != 0]
+    return m.unify(sorted_items, make_list(kept))
+
+
+@builtin("keysort", 2)
+def _keysort(m: Machine, pairs: Any, sorted_pairs: Any) -> bool:
+    items = _proper_list(pairs)
+    for item in items:
+        item = deref(item)
+        if type(item) is not Struct or item.name != "-" or len(item.args) != 2:
+            raise type_error("pair", item)
+    return m.unify(sorted_pairs, make_list(sorted(items, key=lambda pair: term_key(deref(pair).args[0]))))
+
+
+def _proper_list(term: Any) -> list[Any]:
+    items = list_items(term)
+    if items is None:
+        if type(deref(term)) is Var:
+            raise instantiation_error()
+        raise type_error("list", term)
+    return items
+
+
+def _dedupe(items: list[Any]) -> list[Any]:
+    return [item for i, item in enumerate(items) if i == 0 or compare_terms(items[i - 1], item) != 0]
+
+
+# --- Atoms and strings
+
+def _text_result(kind: type) -> Callable[[str], Any]:
+    return (lambda text: text) if kind is str else Atom
+
+
+@builtin("atom_codes", 2)
+def _atom_codes(m: Machine, atom: Any, codes: Any) -> bool:
+    if type(deref(atom)) is not Var:
+        return m.unify(codes, make_list([ord(c) for c in text_of(atom)]))
+    return m.unify(atom, Atom(text_of(codes)))
+
+
+@builtin("atom_chars", 2)
+def _atom_chars(m: Machine, atom: Any, chars: Any) -> bool:
+    if type(deref(atom)) is not Var:
+        return m.unify(chars, make_list([Atom(c) for c in text_of(atom)]))
+    return m.unify(atom, Atom(text_of(chars)))
+
+
+@builtin("char_code", 2)
+def _char_code(m: Machine, char: Any, code: Any) -> bool:
+    if type(deref(char)) is not Var:
+        return m.unify(code, ord(text_of(char)))
+    return m.unify(char, Atom(chr(int_of(code))))
+
+
+@builtin("atom_length", 2)
+def _atom_length(m: Machine, atom: Any, length: Any) -> bool:
+    return m.unify(length, len(text_of(atom)))
+
+
+BUILTINS[("string_length", 2)] = _atom_length
+
+
+@builtin("atom_number", 2)
+def _atom_number(m: Machine, atom: Any, number: Any) -> bool:
+    if type(deref(atom)) is Var:
+        number = deref(number)
+        if type(number) not in (int, float):
+            raise instantiation_error()
+        return m.unify(atom, Atom(text_of(number)))
+    value = parse_number(text_of(atom))
+    return value is not None and m.unify(number, value)
+
+
+@builtin("number_codes", 2)
+def _number_codes(m: Machine, number: Any, codes: Any) -> bool:
+    if type(deref(number)) is not Var:
+        return m.unify(codes, make_list([ord(c) for c in text_of(number)]))
+    value = parse_number(text_of(codes))
+    if value is None:
+        raise error_term(Struct("syntax_error", (Atom("illegal_number"),)))
+    return m.unify(number, value)
+
+
+@builtin("number_string", 2)
+def _number_string(m: Machine, number: Any, string: Any) -> bool:
+    if type(deref(string)) is not Var:
+        value = parse_number(text_of(string))
+        if value is None:
+            raise error_term(Struct("syntax_error", (Atom("illegal_number"),)))
+        return m.unify(number, value)
+    return m.unify(string, text_of(number))
+
+
+BUILTINS[("atom_string", 2)] = lambda m, a, s: (
+    m.unify(s, text_of(a)) if type(deref(a)) is not Var else m.unify(a, Atom(text_of(s)))
+)
+BUILTINS[("string_to_atom", 2)] = lambda m, s, a: (
+    m.unify(a, Atom(text_of(s))) if type(deref(s)) is not Var else m.unify(s, text_of(a))
+)
+BUILTINS[("string_chars", 2)] = lambda m, s, cs: (
+    m.unify(cs, make_list([Atom(c) for c in text_of(s)])) if type(deref(s)) is not Var else m.unify(s, text_of(cs))
+)
+BUILTINS[("string_codes", 2)] = lambda m, s, cs: (
+    m.unify(cs, make_list([ord(c) for c in text_of(s)])) if type(deref(s)) is not Var else m.unify(s, text_of(cs))
+)
+BUILTINS[("term_to_atom", 2)] = lambda m, t, a: _term_text(m, t, a, Atom)
+BUILTINS[("term_string", 2)] = lambda m, t, s: _term_text(m, t, s, str)
+BUILTINS[("term_string", 3)] = lambda m, t, s, options: _term_text(m, t, s, str)
+BUILTINS[("upcase_atom", 2)] = lambda m, a, u: m.unify(u, Atom(text_of(a).upper()))
+BUILTINS[("downcase_atom", 2)] = lambda m, a, u: m.unify(u, Atom(text_of(a).lower()))
+BUILTINS[("string_upper", 2)] = lambda m, a, u: m.unify(u, text_of(a).upper())
+BUILTINS[("string_lower", 2)] = lambda m, a, u: m.unify(u, text_of(a).lower())
+BUILTINS[("string_code", 3)] = lambda m, i, s, c: (
+    1 <= int_of(i) <= len(text_of(s)) and m.unify(c, ord(text_of(s)[int_of(i) - 1]))
+)
+
+
+def _term_text(m: Machine, term: Any, text: Any, kind: type) -> bool:
+    if type(deref(text)) is not Var:
+        try:
+            parsed, _ = parse_term(text_of(text), m.ops)
+        except PrologSyntaxError as e:
+            raise syntax_error(e) from None
+        return m.unify(term, parsed)
+    return m.unify(text, _text_result(kind)(m.show(term)))
+
+
+@builtin("atom_to_term", 3)
+def _atom_to_term(m: Machine, atom: Any, term: Any, bindings: Any) -> bool:
+    try:
+        parsed, names = parse_term(text_of(atom), m.ops)
+    except PrologSyntaxError as e:
+        raise syntax_error(e) from None
+    return m.unify(term, parsed) and m.unify(
+        bindings, make_list([Struct("=", (Atom(name), var)) for name, var in names])
+    )
+
+
+BUILTINS[("term_to_atom", 3)] = _atom_to_term
+
+
+def _concat(kind: type) -> Builtin:
+    def concat(m: Machine, a: Any, b: Any, c: Any) -> Iterator[None]:
+        make = _text_result(kind)
+        if type(deref(a)) is not Var and type(deref(b)) is not Var:
+            if m.unify(c, make(text_of(a) + text_of(b))):
+                yield
+            return
+        whole = text_of(c)
+        for i in range(len(whole) + 1):
+            mark = len(m.trail)
+            if m.unify(a, make(whole[:i])) and m.unify(b, make(whole[i:])):
+                yield
+            m.undo(mark)
+    return concat
+
+
+builtin("atom_concat", 3, "nondet")(_concat(Atom))
+builtin("string_concat", 3, "nondet")(_concat(str))
+
+
+def _sub_text(kind: type) -> Builtin:
+    def sub(m: Machine, whole: Any, before: Any, length: Any, after: Any, part: Any) -> Iterator[None]:
+        text = text_of(whole)
+        make = _text_result(kind)
+        n = len(text)
+        known = deref(part)
+        if type(known) is not Var:
+            needle = text_of(known)
+            start = text.find(needle)
+            while start >= 0:
+                mark = len(m.trail)
+                if m.unify(before, start) and m.unify(length, len(needle)) and m.unify(after, n - start - len(needle)):
+                    yield
+                m.undo(mark)
+                start = text.find(needle, start + 1)
+            return
+        b, l_, a = deref(before), deref(length), deref(after)
+        starts = [b] if type(b) is int else range(n + 1)
+        for start in starts:
+            if type(l_) is int:
+                lengths = [l_]
+            elif type(a) is int:
+                lengths = [n - start - a]
+            else:
+                lengths = range(n - start + 1)
+            for size in lengths:
+                if start < 0 or size < 0 or start + size > n:
+                    continue
+                m.tick()
+                mark = len(m.trail)
+                if m.unify(before, start) and m.unify(length, size) and m.unify(after, n - start - size) \
+                        and m.unify(part, make(text[start:start + size])):
+                    yield
+                m.undo(mark)
+    return sub
+
+
+builtin("sub_atom", 5, "nondet")(_sub_text(Atom))
+builtin("sub_string", 5, "nondet")(_sub_text(str))
+
+
+@builtin("atomic_list_concat", 2)
+def _atomic_list_concat2(m: Machine, parts: Any, atom: Any) -> bool:
+    return m.unify(atom, Atom("".join(text_of(part) for part in _proper_list(parts))))
+
+
+@builtin("atomic_list_concat", 3)
+def _atomic_list_concat3(m: Machine, parts: Any, separator: Any, atom: Any) -> bool:
+    sep = text_of(separator)
+    items = list_items(parts)
+    if items is not None and all(type(deref(item)) is not Var for item in items):
+        return m.unify(atom, Atom(sep.join(text_of(item) for item in items)))
+    if not sep:
+        raise domain_error("non_empty_atom", separator)
+    return m.unify(parts, make_list([Atom(piece) for piece in text_of(atom).split(sep)]))
+
+
+@builtin("split_string", 4)
+def _split_string(m: Machine, string: Any, separators: Any, pad: Any, parts: Any) -> bool:
+    text, seps, padding = text_of(string), text_of(separators), text_of(pad)
+    if seps:
+        pieces, current = [], []
+        for c in text:
+            if c in seps:
+                pieces.append("".join(current))
+                current = []
+            else:
+                current.append(c)
+        pieces.append("".join(current))
+    else:
+        pieces = [text]
+    return m.unify(parts, make_list([piece.strip(padding) if padding else piece for piece in pieces]))
+
+
+# --- Database
+
+@builtin("assert", 1)
+def _assert(m: Machine, clause: Any) -> bool:
+    m.add_clause(clause)
+    return True
+
+
+BUILTINS[("assertz", 1)] = _assert
+BUILTINS[("asserta", 1)] = lambda m, clause: m.add_clause(clause, front=True) or True
+
+
+def _clause_parts(m: Machine, term: Any) -> tuple[Any, Any]:
+    term = deref(term)
+    if type(term) is Struct and term.name == ":-" and len(term.args) == 2:
+        head, body = deref(term.args[0]), term.args[1]
+    else:
+        head, body = term, TRUE
+    if type(head) is Struct and head.name == ":" and len(head.args) == 2:
+        head = deref(head.args[1])
+    return callable_of(head), body
+
+
+@builtin("retract", 1, "nondet")
+def _retract(m: Machine, term: Any) -> Iterator[None]:
+    head, body = _clause_parts(m, term)
+    predicate = m.modifiable(*goal_key(head))
+    for clause in list(predicate.clauses):
+        if clause.erased:
+            continue
+        mark = len(m.trail)
+        clause_head, clause_body = clause.instance()
+        if m.unify(head, clause_head) and m.unify(body, clause_body):
+            clause.erased = True
+            predicate.clauses.remove(clause)
+            yield
+            m.undo(mark)
+            return
+        m.undo(mark)
+
+
+@builtin("retractall", 1)
+def _retractall(m: Machine, head: Any) -> bool:
+    head = callable_of(head)
+    if type(head) is Struct and head.name == ":" and len(head.args) == 2:
+        head = callable_of(head.args[1])
+    predicate = m.modifiable(*goal_key(head))
+    for clause in list(predicate.clauses):
+        mark = len(m.trail)
+        if m.unify(head, clause.instance()[0]):
+            clause.erased = True
+            predicate.clauses.remove(clause)
+        m.undo(mark)
+    return True
+
+
+@builtin("abolish", 1)
+def _abolish(m: Machine, spec: Any) -> bool:
+    name, arity = _indicator(spec)
+    predicate = m.modifiable(name, arity)
+    del m.predicates[(predicate.name, predicate.arity)]
+    return True
+
+
+def _indicator(spec: Any) -> tuple[str, int]:
+    spec = _bound(spec)
+    if type(spec) is Struct and spec.name == ":" and len(spec.args) == 2:
+        spec = _bound(spec.args[1])
+    if type(spec) is not Struct or spec.name not in ("/", "//") or len(spec.args) != 2:
+        raise type_error("predicate_indicator", spec)
+    arity = int_of(spec.args[1]) + (2 if spec.name == "//" else 0)
+    return text_of(spec.args[0]), arity
+
+
+@builtin("dynamic", 1)
+def _dynamic(m: Machine, specs: Any) -> bool:
+    specs = _bound(specs)
+    items = list_items(specs)
+    if items is None:
+        items = []
+        while type(specs) is Struct and specs.name == "," and len(specs.args) == 2:
+            items.append(specs.args[0])
+            specs = _bound(specs.args[1])
+        items.append(specs)
+    for spec in items:
+        name, arity = _indicator(spec)
+        key = (name, arity)
+        if key in BUILTINS or key in CONTROL:
+            raise permission_error("modify", "static_procedure", indicator(name, arity))
+        predicate = m.predicate(name, arity)
+        predicate.dynamic = True
+        predicate.library = False
+    return True
+
+
+@builtin("clause", 2, "nondet")
+def _clause(m: Machine, head: Any, body: Any) -> Iterator[None]:
+    head = callable_of(head)
+    predicate = m.predicates.get(goal_key(head))
+    if predicate is None:
+        return
+    for clause in list(predicate.clauses):
+        mark = len(m.trail)
+        clause_head, clause_body = clause.instance()
+        if m.unify(head, clause_head) and m.unify(body, clause_body):
+            yield
+        m.undo(mark)
+
+
+@builtin("current_predicate", 1, "nondet")
+def _current_predicate(m: Machine, spec: Any) -> Iterator[None]:
+    spec = deref(spec)
+    if type(spec) is Struct and spec.name == ":" and len(spec.args) == 2:
+        spec = deref(spec.args[1])
+    name, arity = Var(), Var()
+    if type(spec) is not Var and not (type(spec) is Struct and spec.name == "/" and len(spec.args) == 2):
+        raise type_error("predicate_indicator", spec)
+    for predicate in sorted(m.user_predicates(), key=lambda p: (p.name, p.arity)):
+        if not predicate.clauses and not predicate.dynamic:
+            continue
+        mark = len(m.trail)
+        if m.unify(spec, Struct("/", (name, arity))) and m.unify(name, Atom(predicate.name)) \
+                and m.unify(arity, predicate.arity):
+            yield
+        m.undo(mark)
+
+
+@builtin("predicate_property", 2, "nondet")
+def _predicate_property(m: Machine, head: Any, prop: Any) -> Iterator[None]:
+    head = deref(head)
+    if type(head) is Struct and head.name == ":" and len(head.args) == 2:
+        head = deref(head.args[1])
+    if type(head) is Var:
+        return
+    key = goal_key(head)
+    predicate = m.predicates.get(key)
+    if predicate is not None:
+        properties: list[Any] = [Atom("defined"), Struct("number_of_clauses", (len(predicate.clauses),))]
+        properties.append(Atom("dynamic") if predicate.dynamic else Atom("static"))
+        if predicate.file:
+            properties.append(Struct("file", (Atom(predicate.file),)))
+    elif key in BUILTINS or key in CONTROL or key in m.extra:
+        properties = [Atom("defined"), Atom("built_in"), Atom("static"), Atom("system")]
+    else:
+        return
+    for value in properties:
+        mark = len(m.trail)
+        if m.unify(prop, value):
+            yield
+        m.undo(mark)
+
+
+# --- Finding all solutions
+
+@builtin("findall", 4)
+def _findall4(m: Machine, template: Any, goal: Any, results: Any, tail: Any) -> bool:
+    found = [copy_term(template, {}) for _ in m.solve(goal)]
+    return m.unify(results, make_list(found, tail))
+
+
+def _strip_carets(goal: Any) -> tuple[Any, list[Var]]:
+    """The goal of Var^Goal, and the variables bound by ^."""
+    bound: list[Var] = []
+    goal = deref(goal)
+    while type(goal) is Struct and goal.name == "^" and len(goal.args) == 2:
+        bound.extend(term_vars(goal.args[0]))
+        goal = deref(goal.args[1])
+    return goal, bound
+
+
+def _bagof(m: Machine, template: Any, goal: Any, results: Any, sort: bool) -> Iterator[None]:
+    inner, bound = _strip_carets(goal)
+    excluded = set(term_vars(template)) | set(bound)
+    free = [var for var in term_vars(inner) if var not in excluded]
+    witness = make_list(free)
+    pairs = [copy_term(Struct("-", (witness, template)), {}) for _ in m.solve(inner)]
+    if not pairs:
+        return
+    if not free:
+        values = [pair.args[1] for pair in pairs]
+        if sort:
+            values = _dedupe(sorted(values, key=term_key))
+        if m.unify(results, make_list(values)):
+            yield
+        return
+    groups: list[tuple[Any, list[Any]]] = []
+    for pair in sorted(pairs, key=lambda pair: term_key(pair.args[0])):
+        if groups and variant(groups[-1][0], pair.args[0]):
+            groups[-1][1].append(pair.args[1])
+        else:
+            groups.append((pair.args[0], [pair.args[1]]))
+    for key, values in groups:
+        if sort:
+            values = _dedupe(sorted(values, key=term_key))
+        mark = len(m.trail)
+        if m.unify(witness, key) and m.unify(results, make_list(values)):
+            yield
+        m.undo(mark)
+
+
+builtin("bagof", 3, "nondet")(lambda m, t, g, r: _bagof(m, t, g, r, False))
+builtin("setof", 3, "nondet")(lambda m, t, g, r: _bagof(m, t, g, r, True))
+
+
+@builtin("aggregate_all", 3)
+def _aggregate_all(m: Machine, spec: Any, goal: Any, result: Any) -> bool:
+    spec = _bound(spec)
+    if spec is Atom("count"):
+        return m.unify(result, sum(1 for _ in m.solve(goal)))
+    if type(spec) is not Struct or len(spec.args) not in (1, 2):
+        raise domain_error("aggregate_spec", spec)
+    kind = spec.name
+    if kind == "count":
+        return m.unify(result, sum(1 for _ in m.solve(goal)))
+    values = [copy_term(Struct("-", spec.args) if len(spec.args) == 2 else spec.args[0], {}) for _ in m.solve(goal)]
+    if kind == "bag":
+        return m.unify(result, make_list(values))
+    if kind == "set":
+        return m.unify(result, make_list(_dedupe(sorted(values, key=term_key))))
+    if kind == "sum":
+        total: Any = 0
+        for value in values:
+            total = total + m.evaluate(value)
+        return m.unify(result, total)
+    if kind in ("max", "min"):
+        if not values:
+            return False
+        if len(spec.args) == 2:
+            best = values[0]
+            for value in values[1:]:
+                x, y = m.evaluate(value.args[0]), m.evaluate(best.args[0])
+                if (x > y) if kind == "max" else (x < y):
+                    best = value
+            return m.unify(result, Struct(kind, (m.evaluate(best.args[0]), best.args[1])))
+        numbers = [m.evaluate(value) for value in values]
+        return m.unify(result, max(numbers) if kind == "max" else min(numbers))
+    raise domain_error("aggregate_spec", spec)
+
+
+# --- Output
+
+@builtin("with_output_to", 2)
+def _with_output_to(m: Machine, sink: Any, goal: Any) -> bool:
+    sink = _bound(sink)
+    if type(sink) is not Struct or len(sink.args) != 1 or sink.name not in ("atom", "string", "codes", "chars"):
+        raise domain_error("output_sink", sink)
+    chunks: list[str] = []
+    m.sinks.append(chunks.append)
+    try:
+        succeeded = m.solve_once(goal)
+    finally:
+        m.sinks.pop()
+    return succeeded and m.unify(sink.args[0], _sink_value(sink.name, "".join(chunks)))
+
+
+def _sink_value(kind: str, text: str) -> Any:
+    if kind == "atom":
+        return Atom(text)
+    if kind == "string":
+        return text
+    if kind == "codes":
+        return make_list([ord(c) for c in text])
+    return make_list([Atom(c) for c in text])
+
+
+@builtin("format", 1)
+def _format1(m: Machine, text: Any) -> bool:
+    m.write(format_text(m, text_of(text), []))
+    return True
+
+
+@builtin("format", 2)
+def _format2(m: Machine, text: Any, args: Any) -> bool:
+    items = list_items(args)
+    m.write(format_text(m, text_of(text), items if items is not None else [args]))
+    return True
+
+
+@builtin("format", 3)
+def _format3(m: Machine, sink: Any, text: Any, args: Any) -> bool:
+    items = list_items(args)
+    output = format_text(m, text_of(text), items if items is not None else [args])
+    sink = _bound(sink)
+    if type(sink) is Struct and len(sink.args) == 1 and sink.name in ("atom", "string", "codes", "chars"):
+        return m.unify(sink.args[0], _sink_value(sink.name, output))
+    m.write(output)
+    return True
+
+
+def format_text(m: Machine, directives: str, args: list[Any]) -> str:
+    """What format/2 writes for directives and args."""
+    out: list[str] = []
+    args = list(args)
+
+    def take() -> Any:
+        if not args:
+            raise error_term(Struct("format", (Atom("not enough arguments"),)))
+        return args.pop(0)
+
+    i = 0
+    while i < len(directives):
+        c = directives[i]
+        i += 1
+        if c != "~":
+            out.append(c)
+            continue
+        numeric = None
+        if i < len(directives) and directives[i] == "*":
+            numeric = int_of(take())
+            i += 1
+        elif i < len(directives) and directives[i] == "`":
+            numeric = ord(directives[i + 1])
+            i += 2
+        else:
+            match = re.compile(r"\d+").match(directives, i)
+            if match:
+                numeric = int(match.group(0))
+                i = match.end()
+        if i >= len(directives):
+            raise error_term(Struct("format", (Atom("truncated format specification"),)))
+        d = directives[i]
+        i += 1
+        if d == "w":
+            out.append(m.show(take(), False))
+        elif d in ("p", "q"):
+            out.append(m.show(take()))
+        elif d == "a":
+            out.append(text_of(take()))
+        elif d in ("d", "D"):
+            value = deref(take())
+            if type(value) is not int:
+                value = m.evaluate(value)
+                if type(value) is not int:
+                    raise error_term(Struct("format", (Atom("~d expects an integer argument"),)))
+            digits = str(abs(value))
+            if numeric:
+                digits = digits.rjust(numeric + 1, "0")
+                whole, fraction = digits[:-numeric], digits[-numeric:]
+            else:
+                whole, fraction = digits, ""
+            if d == "D":
+                whole = f"{int(whole):,}"
+            out.append(("-" if value < 0 else "") + whole + (f".{fraction}" if fraction else ""))
+        elif d in ("f", "e", "g"):
+            value = float(m.evaluate(take()))
+            out.append(f"{value:.{6 if numeric is None else numeric}{d}}")
+        elif d == "s":
+            out.append(text_of(take()))
+        elif d == "n":
+            out.append("\n" * (numeric or 1))
+        elif d == "c":
+            out.append(chr(int_of(take())) * (numeric or 1))
+        elif d in ("r", "R"):
+            value = int_of(take())
+            base = numeric or 8
+            digits = ""
+            n = abs(value)
+            while True:
+                n, r = divmod(n, base)
+                digits = "0123456789abcdefghijklmnopqrstuvwxyz"[r] + digits
+                if n == 0:
+                    break
+            out.append(("-" if value < 0 else "") + (digits.upper() if d == "R" else digits))
+        elif d == "~":
+            out.append("~")
+        elif d == "i":
+            take()
+        elif d in ("t", "|", "+"):
+            # Column alignment is not laid out; the text is kept as is
+            pass
+        else:
+            raise error_term(Struct("format", (Atom(f"unknown directive ~{d}"),)))
+    if args:
+        raise error_term(Struct("format", (Atom("too many arguments"),)))
+    return "".join(out)
+
+
+def portray_clause_text(m: Machine, head: Any, body: Any) -> str:
+    """A clause laid out as portray_clause/1 and listing/1 do, with its variables named A, B, ..."""
+    names: dict[Var, str] = {}
+    for var in term_vars(Struct(":-", (head, body))):
+        names[var] = var_letter(len(names))
+    writer = Writer(m.ops, True, spacing=True, names=names)
+    body = deref(body)
+    if body is TRUE:
+        return writer.write(head, 1199) + ".\n"
+    return writer.write(head, 1199) + " :-\n" + _portray_body(writer, body, 1) + ".\n"
+
+
+def _portray_body(writer: Writer, body: Any, depth: int) -> str:
+    indent = "    " * depth
+    body = deref(body)
+    if type(body) is Struct and body.name == "," and len(body.args) == 2:
+        return _portray_body(writer, body.args[0], depth) + ",\n" + _portray_body(writer, body.args[1], depth)
+    if type(body) is Struct and body.name in (";", "->") and len(body.args) == 2:
+        lines = []
+        branches = [body]
+        if body.name == ";":
+            branches = []
+            while type(body) is Struct and body.name == ";" and len(body.args) == 2:
+                branches.append(deref(body.args[0]))
+                body = deref(body.args[1])
+            branches.append(body)
+        for index, branch in enumerate(branches):
+            lead = "(   " if index == 0 else ";   "
+            if type(branch) is Struct and branch.name == "->" and len(branch.args) == 2:
+                lines.append(indent + lead + writer.write(branch.args[0], 999))
+                lines.append(indent + "->  " + _portray_body(writer, branch.args[1], depth + 1).lstrip())
+            else:
+                lines.append(indent + lead + _portray_body(writer, branch, depth + 1).lstrip())
+        lines.append(indent + ")")
+        return "\n".join(lines)
+    return indent + writer.write(body, 999)
+
+
+@builtin("portray_clause", 1)
+def _portray_clause(m: Machine, clause: Any) -> bool:
+    head, body = _clause_parts(m, copy_term(clause, {}))
+    m.write(portray_clause_text(m, head, body))
+    return True
+
+
+def _listing(m: Machine, predicates: list[Predicate]) -> None:
+    for predicate in predicates:
+        if predicate.dynamic:
+            m.write(f":- dynamic {atom_text(predicate.name, True)}/{predicate.arity}.\n\n")
+        for clause in predicate.clauses:
+            m.write(portray_clause_text(m, *clause.instance()))
+        m.write("\n")
+
+
+@builtin("listing", 0)
+def _listing0(m: Machine) -> bool:
+    _listing(m, sorted(m.user_predicates(), key=lambda p: (p.name, p.arity)))
+    return True
+
+
+@builtin("listing", 1)
+def _listing1(m: Machine, spec: Any) -> bool:
+    spec = _bound(spec)
+    if type(spec) is Struct and spec.name == "/" and len(spec.args) == 2:
+        name, arity = _indicator(spec)
+        selected = [p for p in m.user_predicates() if p.name == name and p.arity == arity]
+    else:
+        name = text_of(spec)
+        selected = sorted((p for p in m.user_predicates() if p.name == name), key=lambda p: p.arity)
+    _listing(m, selected)
+    return True
+
+
+# --- Global variables, flags and the rest
+
+BUILTINS[("nb_setval", 2)] = lambda m, k, v: m.globals.__setitem__(text_of(k), copy_term(v, {})) or True
+BUILTINS[("b_setval", 2)] = BUILTINS[("nb_setval", 2)]
+
+
+@builtin("nb_getval", 2)
+def _nb_getval(m: Machine, key: Any, value: Any) -> bool:
+    name = text_of(key)
+    if name not in m.globals:
+        raise existence_error("variable", Atom(name))
+    return m.unify(value, m.globals[name])
+
+
+BUILTINS[("b_getval", 2)] = _nb_getval
+
+
+@builtin("current_prolog_flag", 2, "nondet")
+def _current_prolog_flag(m: Machine, flag: Any, value: Any) -> Iterator[None]:
+    for name, setting in list(m.flags.items()):
+        mark = len(m.trail)
+        if m.unify(flag, Atom(name)) and m.unify(value, setting):
+            yield
+        m.undo(mark)
+
+
+@builtin("current_op", 3, "nondet")
+def _current_op(m: Machine, priority: Any, kind: Any, name: Any) -> Iterator[None]:
+    for table in (m.ops.prefix, m.ops.infix):
+        for op, (p, k) in list(table.items()):
+            mark = len(m.trail)
+            if m.unify(name, Atom(op)) and m.unify(priority, p) and m.unify(kind, Atom(k)):
+                yield
+            m.undo(mark)
+
+
+@builtin("op", 3)
+def _op(m: Machine, priority: Any, kind: Any, names: Any) -> bool:
+    p, k = int_of(priority), text_of(kind)
+    if not 0 <= p <= 1200:
+        raise domain_error("operator_priority", p)
+    if k not in ("xfx", "xfy", "yfx", "fy", "fx"):
+        raise domain_error("operator_specifier", Atom(k))
+    items = list_items(names)
+    for name in items if items is not None else [names]:
+        m.ops.add(p, k, text_of(name))
+    return True
+
+
+@builtin("statistics", 2)
+def _statistics(m: Machine, key: Any, value: Any) -> bool:
+    name = text_of(key)
+    cpu = time.process_time()
+    if name in ("runtime", "process_cputime"):
+        return m.unify(value, make_list([int(cpu * 1000), 0]))
+    if name == "cputime":
+        return m.unify(value, cpu)
+    if name == "inferences":
+        return m.unify(value, m.inferences)
+    if name in ("walltime", "real_time"):
+        return m.unify(value, make_list([int(time.time() * 1000), 0]))
+    raise domain_error("statistics_key", Atom(name))
+
+
+@builtin("sleep", 1)
+def _sleep(m: Machine, seconds: Any) -> bool:
+    until = time.monotonic() + float(m.evaluate(seconds))
+    while time.monotonic() < until:
+        m.check_signals()
+        time.sleep(min(0.05, max(until - time.monotonic(), 0)))
+    return True
+
+
+@builtin("phrase", 3, "expand")
+def _phrase(m: Machine, body: Any, s0: Any, s: Any) -> Any:
+    return dcg_body(callable_of(body), s0, s)
+
+
+@builtin("tab", 2)
+def _tab2(m: Machine, stream: Any, n: Any) -> bool:
+    m.write(" " * m.evaluate(n))
+    return True
+
+
+@builtin("apply", 2, "expand")
+def _apply(m: Machine, goal: Any, extra: Any) -> Any:
+    return add_args(goal, tuple(_proper_list(extra)))
+
+
+@builtin("call_with_time_limit", 2, "expand")
+def _call_with_time_limit(m: Machine, seconds: Any, goal: Any) -> Any:
+    # Runs as once/1 under the machine's own limits; the given time is not added
+    float(m.evaluate(seconds))
+    return Struct("once", (goal,))
+
+
+@builtin("working_directory", 2)
+def _working_directory(m: Machine, old: Any, new: Any) -> bool:
+    if not m.unify(old, Atom(str(m.directory) + "/")):
+        return False
+    target = deref(new)
+    if type(target) is not Var:
+        path = Path(text_of(target))
+        path = path if path.is_absolute() else m.directory / path
+        if not path.is_dir():
+            raise existence_error("directory", target)
+        m.directory = path.resolve()
+    return True
+
+
+@builtin("limit", 2, "nondet")
+def _limit(m: Machine, count: Any, goal: Any) -> Iterator[None]:
+    n = int_of(count)
+    if n <= 0:
+        return
+    for found, _ in enumerate(m.solve(goal), 1):
+        yield
+        if found >= n:
+            return
+
+
+@builtin("offset", 2, "nondet")
+def _offset(m: Machine, count: Any, goal: Any) -> Iterator[None]:
+    n = int_of(count)
+    for found, _ in enumerate(m.solve(goal), 1):
+        if found > n:
+            yield
+
+
+@builtin("write_term", 2)
+def _write_term(m: Machine, term: Any, options: Any) -> bool:
+    quoted = False
+    for option in _proper_list(options):
+        option = deref(option)
+        if type(option) is Struct and option.name == "quoted" and len(option.args) == 1:
+            quoted = deref(option.args[0]) is TRUE
+    m.write(m.show(term, quoted))
+    return True
+
+
+BUILTINS[("write_term", 3)] = lambda m, stream, term, options: _write_term(m, term, options)
+BUILTINS[("nb_current", 2)] = lambda m, k, v: (
+    type(deref(k)) is not Var and text_of(k) in m.globals and m.unify(v, m.globals[text_of(k)])
+)
+
+
+@builtin("must_be", 2)
+def _must_be(m: Machine, kind: Any, value: Any) -> bool:
+    name = text_of(kind)
+    value = deref(value)
+    if name == "var":
+        if type(value) is not Var:
+            raise error_term(Struct("uninstantiation_error", (value,)))
+        return True
+    if type(value) is Var:
+        raise instantiation_error()
+    checks = {
+        "integer": type(value) is int,
+        "positive_integer": type(value) is int,
+        "nonneg": type(value) is int,
+        "atom": type(value) is Atom,
+        "atomic": type(value) in (Atom, int, float, str),
+        "callable": type(value) in (Atom, Struct),
+        "boolean": value in (TRUE, FALSE),
+        "number": type(value) in (int, float),
+        "string": type(value) is str,
+        "list": list_items(value) is not None,
+        "compound": type(value) is Struct,
+    }
+    if name not in checks:
+        raise domain_error("type", kind)
+    if not checks[name]:
+        raise type_error("integer" if name in ("positive_integer", "nonneg") else name, value)
+    if name == "positive_integer" and value < 1:
+        raise type_error("positive_integer", value)
+    if name == "nonneg" and value < 0:
+        raise type_error("nonneg", value)
+    return True
+
+
+CHAR_TYPES: dict[str, Callable[[str], bool]] = {
+    "alpha": lambda c: c.isalnum() or c == "_",
+    "alnum": str.isalnum,
+    "digit": str.isdigit,
+    "space": str.isspace,
+    "white": lambda c: c in " \t",
+    "upper": str.isupper,
+    "lower": str.islower,
+    "punct": lambda c: c.isprintable() and not c.isalnum() and not c.isspace(),
+    "csym": lambda c: c.isalnum() or c == "_",
+    "csymf": lambda c: c.isalpha() or c == "_",
+    "graph": lambda c: c.isprintable() and not c.isspace(),
+    "end_of_line": lambda c: c in "\n\r",
+}
+
+
+def _char_type(as_code: bool) -> Builtin:
+    def char_type(m: Machine, char: Any, kind: Any) -> bool:
+        char = deref(char)
+        c = chr(char) if type(char) is int else text_of(char)
+        kind = _bound(kind)
+        if type(kind) is Atom:
+            check = CHAR_TYPES.get(kind.name)
+            if check is None:
+                raise domain_error("char_type", kind)
+            return len(c) == 1 and check(c)
+        if type(kind) is Struct and len(kind.args) == 1:
+            make = (lambda x: ord(x)) if as_code else Atom
+            if kind.name == "digit":
+                return c.isdigit() and m.unify(kind.args[0], int(c))
+            if kind.name == "to_lower":
+                return m.unify(kind.args[0], make(c.lower()))
+            if kind.name == "to_upper":
+                return m.unify(kind.args[0], make(c.upper()))
+            if kind.name == "upper":
+                return c.isupper() and m.unify(kind.args[0], make(c.lower()))
+            if kind.name == "lower":
+                return c.islower() and m.unify(kind.args[0], make(c.upper()))
+        raise domain_error("char_type", kind)
+    return char_type
+
+
+BUILTINS[("char_type", 2)] = _char_type(False)
+BUILTINS[("code_type", 2)] = _char_type(True)
+
+
+def _lambda(m: Machine, parameters: Any, body: Any, *extra: Any) -> Any:
+    """Parameters>>Body of library(yall) called with extra arguments; Free/Parameters shares Free."""
+    mapping: dict[Var, Any] = {}
+    parameters = deref(parameters)
+    if type(parameters) is Struct and parameters.name == "/" and len(parameters.args) == 2:
+        mapping = {var: var for var in term_vars(parameters.args[0])}
+        parameters = parameters.args[1]
+    lam = copy_term(Struct(">>", (parameters, body)), mapping)
+    names = _proper_list(lam.args[0])
+    for name, value in zip(names, extra):
+        if not m.unify(name, value):
+            return Atom("fail")
+    return add_args(lam.args[1], extra[len(names):])
+
+
+for _arity in range(2, 10):
+    builtin(">>", _arity, "expand")(_lambda)
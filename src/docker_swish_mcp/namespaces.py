@@ -0,0 +1,84 @@
+"""
+Per-Client Prolog Modules for Docker SWISH MCP
+
+When several MCP clients share one persistent session, facts asserted by
+one client would otherwise land in module user next to everyone else's.
+With isolation on (SWISH_MCP_ISOLATION), each client's goals run as
+Module:Goal in a module of its own, so plain assertz/1, retract/1 and
+consult/1 go to that module. Client modules inherit from user, so what is
+loaded there stays visible to everybody.
+
+share_module moves a client into a named shared module instead, letting
+clients opt in to working on the same facts. Isolation separates clients
+that cooperate; it is not a security boundary, as a goal can still name
+another module explicitly (see the sandbox policy for that).
+"""
+
+import hashlib
+import re
+
+from .rdf import prolog_atom
+
+SHARED_NAME_RE = re.compile(r"^[a-z][a-z0-9_]{0,47}$")
+# Modules that are not handed out as shared modules
+RESERVED_MODULES = frozenset({"system", "user", "prolog", "lists", "apply", "sandbox"})
+
+
+def private_module(client_id: str) -> str:
+    """Stable module name for a client: readable prefix plus a hash of the id."""
+    readable = re.sub(r"[^a-z0-9]+", "_", client_id.lower()).strip("_")[:32] or "anon"
+    digest = hashlib.sha256(client_id.encode()).hexdigest()[:8]
+    return f"client_{readable}_{digest}"
+
+
+def validate_shared_name(name: str) -> str:
+    name = name.strip()
+    if not SHARED_NAME_RE.match(name):
+        raise ValueError(
+            f"Invalid module name '{name}': use lowercase letters, digits and '_', starting with a letter"
+        )
+    if name in RESERVED_MODULES or name.startswith("client_"):
+        raise ValueError(f"Module '{name}' cannot be shared; pick another name")
+    return name
+
+
+def in_module(goal: str, module: str) -> str:
+    """Goal text qualified to run in module; user goals are left as they are."""
+    if module == "user":
+        return goal
+    return f"{prolog_atom(module)}:({goal})"
+
+
+class ModuleTable:
+    """
+    Which module each client's goals run in.
+
+    Args:
+        enabled: Whether clients get their own modules; otherwise all use user
+    """
+
+    def __init__(self, enabled: bool = False):
+        self.enabled = enabled
+        # client id -> shared module joined with share_module
+        self.shared: dict[str, str] = {}
+
+    def module_for(self, client_id: str) -> str:
+        if not self.enabled:
+            return "user"
+        return self.shared.get(client_id) or private_module(client_id)
+
+    def share(self, client_id: str, name: str) -> str:
+        """Move a client into a shared module; "user" shares the global module."""
+        if name.strip() == "user":
+            self.shared[client_id] = "user"
+            return "user"
+        self.shared[client_id] = validate_shared_name(name)
+        return self.shared[client_id]
+
+    def leave(self, client_id: str) -> str:
+        """Return a client to its private module."""
+        self.shared.pop(client_id, None)
+        return self.module_for(client_id)
+
+    def members(self, module: str) -> list[str]:
+        return sorted(client for client, shared in self.shared.items() if shared == module)
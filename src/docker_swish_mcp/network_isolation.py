@@ -0,0 +1,354 @@
+"""
+Container Network Isolation for Docker SWISH MCP
+
+Sandboxed Prolog code can still call http_open/3 or tcp_connect/2, and
+on Docker's default bridge network the container reaches anything.
+SWISH_MCP_NETWORK (or network in the config file's [container] table)
+picks how much of the network the container gets:
+
+- bridge (default): Docker's default network, with the SWISH port
+  published on localhost
+- none: no network at all; queries and probes run with docker exec, so
+  pengine-based tools (isolated queries, SWISH links) are unavailable
+- internal: the container joins swish-mcp-internal, a Docker network
+  created with --internal, so it has no route out; no port is published
+  and the server reaches the pengine API at the container's address on
+  that network
+- allowlist: internal, plus the http_proxy environment pointing to a
+  proxy embedded in this server. It serves CONNECT and plain HTTP
+  requests for the hosts in SWISH_MCP_NETWORK_ALLOW (network_allow),
+  e.g. "pypi.org,*.swi-prolog.org,example.org:443", and refuses the
+  rest. SWI-Prolog's http_open/3 honours http_proxy, https_proxy and
+  no_proxy
+
+The proxy listens on the internal network's gateway, the host's address
+on it, at SWISH_MCP_NETWORK_PROXY_PORT (network_proxy_port, default
+3128); only containers on the network can reach it. When the server
+itself runs in a container, that container joins the network instead,
+and the proxy listens on its address there. This relies on the host
+reaching its bridge networks, which holds on Linux but not for Docker
+Desktop, where internal and allowlist fall back to docker exec as none
+does.
+
+network_status shows the profile, the addresses in use and the
+connections the proxy let through or refused. Changing the profile
+recreates the container.
+"""
+
+import asyncio
+import ipaddress
+import logging
+import os
+import re
+import socket
+import time
+from collections import deque
+from dataclasses import dataclass, field
+from typing import Any
+from urllib.parse import urlsplit
+
+from .lifecycle import MANAGED_BY
+
+logger = logging.getLogger("docker-swish-mcp.network")
+
+NETWORK_MODES = ("bridge", "none", "internal", "allowlist")
+INTERNAL_NETWORK = "swish-mcp-internal"
+DEFAULT_PROXY_PORT = 3128
+HOST_PATTERN_RE = re.compile(r"^(\*\.)?[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:\d{1,5})?$")
+# Refused connections network_status lists
+RECENT_DENIALS = 20
+# Bytes of a request line and its headers the proxy reads
+MAX_HEADER = 64 * 1024
+RELAY_CHUNK = 64 * 1024
+CONNECT_TIMEOUT = 10
+
+
+def parse_allow(value: Any) -> tuple[str, ...]:
+    """Host patterns from "a.org,*.b.org:443" or a list of them."""
+    if isinstance(value, str):
+        items = [item.strip() for item in value.split(",")]
+    elif isinstance(value, (list, tuple)):
+        items = [str(item).strip() for item in value]
+    else:
+        raise ValueError(f"network_allow must be a string or a list of hosts, not {value!r}")
+    patterns = []
+    for item in filter(None, items):
+        if not HOST_PATTERN_RE.match(item):
+            raise ValueError(f"network_allow has an invalid host {item!r}; use host, *.domain or host:port")
+        patterns.append(item.lower())
+    return tuple(dict.fromkeys(patterns))
+
+
+def host_allowed(host: str, port: int, patterns: tuple[str, ...]) -> bool:
+    """Whether host:port matches one of patterns; *.domain matches the domain's subdomains."""
+    host = host.lower().rstrip(".")
+    for pattern in patterns:
+        name, _, wanted_port = pattern.partition(":")
+        if wanted_port and int(wanted_port) != port:
+            continue
+        if name.startswith("*."):
+            if host.endswith(name[1:]):
+                return True
+        elif host == name:
+            return True
+    return False
+
+
+@dataclass(frozen=True)
+class NetworkProfile:
+    """Which network the SWISH container is attached to, see the module docstring."""
+    mode: str = "bridge"
+    # Host patterns the allowlist proxy lets through
+    allow: tuple[str, ...] = field(default_factory=tuple)
+    proxy_port: int = DEFAULT_PROXY_PORT
+
+    def __str__(self) -> str:
+        if self.mode == "allowlist":
+            return f"allowlist {','.join(self.allow) or '(nothing)'}"
+        return self.mode
+
+    @property
+    def isolated(self) -> bool:
+        """Whether the container is on the internal network."""
+        return self.mode in ("internal", "allowlist")
+
+    @property
+    def publishes_port(self) -> bool:
+        return self.mode == "bridge"
+
+    @property
+    def has_http(self) -> bool:
+        """Whether the server can reach the pengine API at all."""
+        return self.mode != "none"
+
+    @classmethod
+    def from_settings(cls, raw: dict[str, Any], base: "NetworkProfile | None" = None) -> "NetworkProfile":
+        """A profile from network/network_allow/network_proxy_port values, defaulting to base's."""
+        base = base or cls()
+        mode = raw.get("network") or base.mode
+        if mode not in NETWORK_MODES:
+            raise ValueError(f"network must be one of {', '.join(NETWORK_MODES)}, not {mode!r}")
+        allow = parse_allow(raw["network_allow"]) if raw.get("network_allow") not in (None, "") else base.allow
+        port = raw.get("network_proxy_port", base.proxy_port)
+        if isinstance(port, str):
+            try:
+                port = int(port) if port.strip() else base.proxy_port
+            except ValueError:
+                raise ValueError(f"network_proxy_port must be a port number, not {port!r}")
+        if isinstance(port, bool) or not isinstance(port, int) or not 1 <= port <= 65535:
+            raise ValueError(f"network_proxy_port must be a port number, not {port!r}")
+        if mode == "allowlist" and not allow:
+            logger.warning("⚠️ network is allowlist but network_allow is empty; every connection is refused")
+        return cls(mode=mode, allow=allow, proxy_port=port)
+
+    def run_options(self, proxy_url: str = "") -> dict[str, Any]:
+        """Keyword arguments of containers.run() attaching the container as this profile says."""
+        if self.mode == "none":
+            return {"network_mode": "none"}
+        if not self.isolated:
+            return {}
+        options: dict[str, Any] = {"network": INTERNAL_NETWORK}
+        if proxy_url:
+            options["environment"] = {
+                name: proxy_url for name in ("http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY")
+            } | {"no_proxy": "localhost,127.0.0.1", "NO_PROXY": "localhost,127.0.0.1"}
+        return options
+
+
+def ensure_network(client: Any) -> Any:
+    """The internal network, created (internal, labelled as ours) unless it exists."""
+    networks = getattr(client, "networks", None)
+    if networks is None:
+        raise ValueError("This container runtime has no network API; internal and allowlist need docker or podman")
+    try:
+        network = networks.get(INTERNAL_NETWORK)
+    except Exception as e:
+        if type(e).__name__ != "NotFound":
+            raise
+        network = networks.create(INTERNAL_NETWORK, driver="bridge", internal=True, labels={"managed-by": MANAGED_BY})
+        logger.info(f"🔒 Created internal network {INTERNAL_NETWORK}")
+    network.reload()
+    if not network.attrs.get("Internal"):
+        raise ValueError(f"Network {INTERNAL_NETWORK} exists but is not internal; remove it so it can be recreated")
+    return network
+
+
+def network_gateway(network: Any) -> str:
+    """The host's address on a bridge network."""
+    for config in (network.attrs.get("IPAM") or {}).get("Config") or []:
+        if config.get("Gateway"):
+            return config["Gateway"]
+    return ""
+
+
+def container_address(container: Any, name: str = INTERNAL_NETWORK) -> str:
+    """container's IP address on network name; "" when it is not attached."""
+    networks = (container.attrs.get("NetworkSettings") or {}).get("Networks") or {}
+    return (networks.get(name) or {}).get("IPAddress", "")
+
+
+def own_container(client: Any) -> Any:
+    """The container this server runs in, if it runs in one of client's."""
+    if not os.path.exists("/.dockerenv") and not os.path.exists("/run/.containerenv"):
+        return None
+    try:
+        return client.containers.get(socket.gethostname())
+    except Exception:
+        return None
+
+
+def join_network(client: Any, network: Any) -> str:
+    """Attach this server's own container to network; returns its address there, or ""."""
+    container = own_container(client)
+    if container is None:
+        return ""
+    if not container_address(container, network.name):
+        network.connect(container)
+        logger.info(f"🔒 Joined {network.name} to reach the SWISH container")
+        container.reload()
+    return container_address(container, network.name)
+
+
+@dataclass
+class ProxyStats:
+    allowed: int = 0
+    denied: int = 0
+    # (time, host:port) of the latest refused connections
+    recent_denials: deque = field(default_factory=lambda: deque(maxlen=RECENT_DENIALS))
+
+
+class AllowlistProxy:
+    """
+    HTTP proxy letting the SWISH container reach allowed hosts only.
+
+    Args:
+        allow: Host patterns, see host_allowed()
+        host: Address to listen on
+        port: Port to listen on
+    """
+
+    def __init__(self, allow: tuple[str, ...], host: str, port: int = DEFAULT_PROXY_PORT):
+        self.allow = allow
+        self.host = host
+        self.port = port
+        self.stats = ProxyStats()
+        self.server: asyncio.AbstractServer | None = None
+
+    @property
+    def url(self) -> str:
+        host = f"[{self.host}]" if ":" in self.host else self.host
+        return f"http://{host}:{self.port}"
+
+    async def start(self) -> None:
+        self.server = await asyncio.start_server(self.handle, self.host, self.port, limit=MAX_HEADER)
+        logger.info(f"🔒 Allowlist proxy on {self.url} for {', '.join(self.allow) or 'no hosts'}")
+
+    async def stop(self) -> None:
+        if self.server:
+            self.server.close()
+            await self.server.wait_closed()
+            self.server = None
+
+    async def handle(self, reader: asyncio.StreamReader, writer: asyncio.StreamWriter) -> None:
+        try:
+            head = await asyncio.wait_for(reader.readuntil(b"\r\n\r\n"), CONNECT_TIMEOUT)
+        except (asyncio.IncompleteReadError, asyncio.LimitOverrunError, asyncio.TimeoutError, ConnectionError):
+            writer.close()
+            return
+        lines = head.decode("latin-1").split("\r\n")
+        try:
+            method, target, version = lines[0].split(" ")
+            host, port, request = self.parse_request(method, target, version, lines[1:])
+        except ValueError:
+            await self.reply(writer, 400, "Bad Request")
+            return
+        if not self.allowed(host, port):
+            self.stats.denied += 1
+            self.stats.recent_denials.append((time.time(), f"{host}:{port}"))
+            logger.warning(f"🔒 Proxy refused a connection to {host}:{port}")
+            await self.reply(writer, 403, "Forbidden", f"{host}:{port} is not in SWISH_MCP_NETWORK_ALLOW\n")
+            return
+        try:
+            upstream_reader, upstream_writer = await asyncio.wait_for(
+                asyncio.open_connection(host, port), CONNECT_TIMEOUT
+            )
+        except (OSError, asyncio.TimeoutError) as e:
+            await self.reply(writer, 502, "Bad Gateway", f"{host}:{port}: {e}\n")
+            return
+        self.stats.allowed += 1
+        if request is None:
+            writer.write(b"HTTP/1.1 200 Connection established\r\n\r\n")
+        else:
+            upstream_writer.write(request)
+        await asyncio.gather(
+            self.relay(reader, upstream_writer), self.relay(upstream_reader, writer), return_exceptions=True
+        )
+
+    def allowed(self, host: str, port: int) -> bool:
+        try:
+            address = ipaddress.ip_address(host)
+        except ValueError:
+            return host_allowed(host, port, self.allow)
+        # Addresses only by an explicit pattern, never through a *.domain one
+        return host_allowed(str(address), port, tuple(p for p in self.allow if not p.startswith("*.")))
+
+    @staticmethod
+    def parse_request(method: str, target: str, version: str, headers: list[str]) -> tuple[str, int, bytes | None]:
+        """Host, port and the request to forward (None for CONNECT) of a proxy request."""
+        if method == "CONNECT":
+            host, _, port = target.rpartition(":")
+            return host.strip("[]"), int(port), None
+        url = urlsplit(target)
+        if url.scheme != "http" or not url.hostname:
+            raise ValueError(f"not an absolute http URL: {target}")
+        path = url.path or "/"
+        if url.query:
+            path += f"?{url.query}"
+        kept = [line for line in headers if line and not line.lower().startswith(("proxy-", "connection:"))]
+        request = "\r\n".join([f"{method} {path} {version}", *kept, "Connection: close", "", ""])
+        return url.hostname, url.port or 80, request.encode("latin-1")
+
+    @staticmethod
+    async def relay(reader: asyncio.StreamReader, writer: asyncio.StreamWriter) -> None:
+        try:
+            while data := await reader.read(RELAY_CHUNK):
+                writer.write(data)
+                await writer.drain()
+        finally:
+            writer.close()
+
+    @staticmethod
+    async def reply(writer: asyncio.StreamWriter, status: int, reason: str, body: str = "") -> None:
+        data = body.encode()
+        writer.write(
+            f"HTTP/1.1 {status} {reason}\r\nContent-Type: text/plain\r\n"
+            f"Content-Length: {len(data)}\r\nConnection: close\r\n\r\n".encode() + data
+        )
+        try:
+            await writer.drain()
+        finally:
+            writer.close()
+
+
+def format_network_status(
+    profile: NetworkProfile, address: str, base_url: str, proxy: AllowlistProxy | None
+) -> str:
+    """network_status' text report."""
+    lines = [f"🔒 Network profile: {profile}"]
+    if profile.mode == "bridge":
+        lines.append("   Docker's default network: Prolog code can connect anywhere (set SWISH_MCP_NETWORK to isolate it)")
+    elif profile.mode == "none":
+        lines.append("   No network: queries run with docker exec; pengine-based tools are unavailable")
+    else:
+        lines.append(f"   Internal network {INTERNAL_NETWORK}, no route out; container address {address or 'unknown'}")
+        lines.append(f"🌐 Pengine API: {base_url}")
+    if profile.mode == "allowlist":
+        lines.append(f"🚦 Allowed hosts: {', '.join(profile.allow) or 'none'}")
+        if proxy is None:
+            lines.append("❌ The allowlist proxy is not running")
+        else:
+            stats = proxy.stats
+            lines.append(f"🔁 Proxy {proxy.url}: {stats.allowed} connection(s) let through, {stats.denied} refused")
+            for when, target in reversed(stats.recent_denials):
+                lines.append(f"   • {time.strftime('%Y-%m-%d %H:%M:%S', time.localtime(when))} refused {target}")
+    return "\n".join(lines)
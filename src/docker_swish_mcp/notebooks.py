@@ -0,0 +1,240 @@
+"""
+SWISH Notebooks for Docker SWISH MCP
+
+Notebooks are stored in SWISH's .swinb format under notebooks/ in the data
+directory: an HTML document whose cells are div.nb-cell elements.
+
+    <div class="notebook">
+    <div class="nb-cell markdown" name="md1">
+    # Family relations
+    </div>
+    <div class="nb-cell program" data-background="true" name="p1">
+    parent(tom, bob).
+    </div>
+    <div class="nb-cell query" name="q1">
+    parent(tom, X).
+    </div>
+    </div>
+
+Notebooks written by enhanced_tools (a JSON document with a "cells" list)
+are read as well and converted to .swinb when saved.
+
+Running a notebook concatenates its program cells into notebooks/<name>.pl,
+consults that file and then runs each query cell in order.
+"""
+
+import html
+import json
+import logging
+import re
+from dataclasses import dataclass
+from html.parser import HTMLParser
+from pathlib import Path
+
+from .host_platform import normalize_newlines
+
+logger = logging.getLogger("docker-swish-mcp.notebooks")
+
+NOTEBOOK_DIR = "notebooks"
+NAME_RE = re.compile(r"^[A-Za-z][A-Za-z0-9_-]*$")
+CELL_TYPES = ("markdown", "program", "query", "html")
+NAME_PREFIXES = {"markdown": "md", "program": "p", "query": "q", "html": "h"}
+
+
+class NotebookError(Exception):
+    """Raised for invalid notebook names, cells or files."""
+
+
+@dataclass
+class NotebookCell:
+    """One notebook cell."""
+    type: str
+    text: str
+    name: str = ""
+
+
+def validate_notebook_name(name: str) -> str:
+    if not NAME_RE.match(name):
+        raise NotebookError(
+            f"Invalid notebook name '{name}': use letters, digits, '_' or '-', starting with a letter"
+        )
+    return name
+
+
+def notebook_path(data_dir: Path, name: str) -> Path:
+    return data_dir / NOTEBOOK_DIR / f"{validate_notebook_name(name)}.swinb"
+
+
+def program_consult_name(name: str) -> str:
+    """Consult target of a notebook's extracted program, relative to /data."""
+    return f"{NOTEBOOK_DIR}/{validate_notebook_name(name)}"
+
+
+def new_cell(cell_type: str, text: str, name: str = "") -> NotebookCell:
+    """Validate a cell's type; the name is assigned later if empty."""
+    cell_type = cell_type.strip().lower()
+    if cell_type not in CELL_TYPES:
+        raise NotebookError(f"Unknown cell type '{cell_type}'. Use one of: {', '.join(CELL_TYPES)}")
+    if name and not NAME_RE.match(name):
+        raise NotebookError(f"Invalid cell name '{name}'")
+    return NotebookCell(cell_type, text.strip("\n"), name)
+
+
+def make_cells(raw_cells: list[dict[str, str]]) -> list[NotebookCell]:
+    """Validate cells given as {"type": ..., "text": ...} and name them."""
+    cells = []
+    for i, raw in enumerate(raw_cells, 1):
+        try:
+            cells.append(new_cell(str(raw.get("type", "")), str(raw.get("text", "")), str(raw.get("name", ""))))
+        except NotebookError as e:
+            raise NotebookError(f"Cell {i}: {e}") from e
+    names = [cell.name for cell in cells if cell.name]
+    if len(names) != len(set(names)):
+        raise NotebookError("Cell names must be unique")
+    return assign_names(cells)
+
+
+def assign_names(cells: list[NotebookCell]) -> list[NotebookCell]:
+    """Give unnamed cells SWISH-style names (md1, p1, q1, ...)."""
+    taken = {cell.name for cell in cells if cell.name}
+    counters = dict.fromkeys(NAME_PREFIXES, 0)
+    for cell in cells:
+        if cell.name:
+            continue
+        while True:
+            counters[cell.type] += 1
+            candidate = f"{NAME_PREFIXES[cell.type]}{counters[cell.type]}"
+            if candidate not in taken:
+                break
+        cell.name = candidate
+        taken.add(candidate)
+    return cells
+
+
+def render_swinb(cells: list[NotebookCell]) -> str:
+    """Serialize cells to the .swinb format."""
+    parts = ['<div class="notebook">\n']
+    for cell in cells:
+        attrs = ' data-background="true"' if cell.type == "program" else ""
+        # HTML cells hold markup, everything else is text
+        body = cell.text if cell.type == "html" else html.escape(cell.text, quote=False)
+        parts.append(f'<div class="nb-cell {cell.type}"{attrs} name="{html.escape(cell.name)}">\n{body}\n</div>\n')
+    parts.append("</div>\n")
+    return "\n".join(parts)
+
+
+class _SwinbParser(HTMLParser):
+    """Collects the nb-cell divs of a notebook, keeping nested markup verbatim."""
+
+    def __init__(self) -> None:
+        super().__init__(convert_charrefs=True)
+        self.cells: list[NotebookCell] = []
+        self._current: NotebookCell | None = None
+        self._depth = 0
+
+    def handle_starttag(self, tag: str, attrs: list[tuple[str, str | None]]) -> None:
+        if self._current is not None:
+            if tag == "div":
+                self._depth += 1
+            self._current.text += self.get_starttag_text() or ""
+            return
+        if tag != "div":
+            return
+        attributes = dict(attrs)
+        classes = (attributes.get("class") or "").split()
+        if "nb-cell" not in classes:
+            return
+        cell_type = next((c for c in classes if c in CELL_TYPES), "")
+        if not cell_type:
+            logger.debug(f"Treating notebook cell with classes {classes} as html")
+            cell_type = "html"
+        self._current = NotebookCell(cell_type, "", attributes.get("name") or "")
+        self._depth = 0
+
+    def handle_endtag(self, tag: str) -> None:
+        if self._current is None:
+            return
+        if tag == "div":
+            if self._depth == 0:
+                self._current.text = self._current.text.strip("\n")
+                self.cells.append(self._current)
+                self._current = None
+                return
+            self._depth -= 1
+        self._current.text += f"</{tag}>"
+
+    def handle_data(self, data: str) -> None:
+        if self._current is not None:
+            self._current.text += data
+
+
+def parse_legacy_json(text: str) -> list[NotebookCell]:
+    """Read a notebook in the JSON layout used by enhanced_tools."""
+    try:
+        raw = json.loads(text)
+    except json.JSONDecodeError as e:
+        raise NotebookError(f"Notebook is neither .swinb HTML nor valid JSON: {e}") from e
+    cells = []
+    for raw_cell in raw.get("cells", []):
+        cell_type = raw_cell.get("type", "markdown")
+        if cell_type not in CELL_TYPES:
+            cell_type = "html"
+        cells.append(NotebookCell(cell_type, str(raw_cell.get("content", "")).strip("\n"), str(raw_cell.get("name", ""))))
+    return cells
+
+
+def parse_swinb(text: str) -> list[NotebookCell]:
+    """Read the cells of a .swinb document."""
+    if text.lstrip().startswith("{"):
+        return assign_names(parse_legacy_json(text))
+    parser = _SwinbParser()
+    parser.feed(text)
+    parser.close()
+    return assign_names(parser.cells)
+
+
+def load_notebook(data_dir: Path, name: str) -> list[NotebookCell]:
+    path = notebook_path(data_dir, name)
+    if not path.exists():
+        raise NotebookError(f"Notebook '{name}' not found")
+    return parse_swinb(path.read_text(encoding="utf-8"))
+
+
+def save_notebook(data_dir: Path, name: str, cells: list[NotebookCell]) -> Path:
+    path = notebook_path(data_dir, name)
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_text(render_swinb(cells), encoding="utf-8", newline="\n")
+    return path
+
+
+def insert_cell(cells: list[NotebookCell], cell: NotebookCell, position: int | None = None) -> list[NotebookCell]:
+    """Insert cell at position (0-based; default: append) and name it if needed."""
+    if cell.name and any(c.name == cell.name for c in cells):
+        raise NotebookError(f"A cell named '{cell.name}' already exists")
+    if position is None or position >= len(cells):
+        cells.append(cell)
+    else:
+        cells.insert(max(0, position), cell)
+    return assign_names(cells)
+
+
+def write_program(data_dir: Path, name: str, cells: list[NotebookCell]) -> str | None:
+    """
+    Write the notebook's program cells to notebooks/<name>.pl.
+
+    Returns:
+        The consult target, or None if the notebook has no program (any
+        stale program file is removed)
+    """
+    program_path = data_dir / NOTEBOOK_DIR / f"{validate_notebook_name(name)}.pl"
+    programs = [cell for cell in cells if cell.type == "program" and cell.text.strip()]
+    if not programs:
+        program_path.unlink(missing_ok=True)
+        return None
+    source = "\n\n".join(f"% ---- cell {cell.name} ----\n{cell.text}" for cell in programs)
+    program_path.parent.mkdir(parents=True, exist_ok=True)
+    program_path.write_text(
+        f"% Program of notebook {name}.swinb (generated, do not edit)\n\n{normalize_newlines(source)}\n",
+        encoding="utf-8", newline="\n"
+    )
+    return program_consult_name(name)
@@ -0,0 +1,97 @@
+"""
+Cluster Specs for Docker SWISH MCP
+
+Parses a declarative, compose-style description of several named SWISH
+instances (e.g. one per tenant or knowledge base) that the MCP server
+brings up alongside its primary container.
+
+Example spec:
+
+    {
+      "instances": [
+        {"name": "tenant-a", "port": 3051},
+        {"name": "tenant-b", "port": 3052, "data_dir": "/srv/kb/tenant-b"}
+      ]
+    }
+"""
+
+import json
+import re
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+INSTANCE_NAME_RE = re.compile(r"^[a-z0-9][a-z0-9_.-]{0,62}$")
+PRIMARY_PORT = 3050
+
+
+@dataclass
+class InstanceSpec:
+    """Declarative description of one named SWISH instance."""
+    name: str
+    port: int
+    data_dir: Path
+
+    @property
+    def container_name(self) -> str:
+        return f"swish-mcp-{self.name}"
+
+
+def parse_cluster_spec(spec: dict[str, Any], base_dir: Path) -> list[InstanceSpec]:
+    """
+    Validate a cluster spec and resolve defaults.
+
+    Instances without a port get the next free one after the primary
+    container's; instances without a data_dir get swish-data-<name>
+    next to base_dir.
+
+    Raises:
+        ValueError: if the spec is malformed or names/ports collide
+    """
+    entries = spec.get("instances")
+    if not isinstance(entries, list) or not entries:
+        raise ValueError("Cluster spec needs a non-empty 'instances' list")
+
+    used_ports = {PRIMARY_PORT}
+    explicit_ports = {e.get("port") for e in entries if isinstance(e, dict) and e.get("port")}
+    next_port = PRIMARY_PORT + 1
+    names: set[str] = set()
+    instances = []
+
+    for entry in entries:
+        if not isinstance(entry, dict):
+            raise ValueError(f"Instance entries must be objects, got: {entry!r}")
+
+        name = str(entry.get("name", ""))
+        if not INSTANCE_NAME_RE.match(name):
+            raise ValueError(f"Invalid instance name '{name}' (use lowercase letters, digits, '.', '_' or '-')")
+        if name in names:
+            raise ValueError(f"Duplicate instance name '{name}'")
+        names.add(name)
+
+        port = entry.get("port")
+        if port is None:
+            while next_port in used_ports or next_port in explicit_ports:
+                next_port += 1
+            port = next_port
+        port = int(port)
+        if port in used_ports:
+            raise ValueError(f"Port {port} for instance '{name}' is already taken")
+        used_ports.add(port)
+
+        data_dir = Path(entry.get("data_dir") or base_dir / f"swish-data-{name}").expanduser()
+        instances.append(InstanceSpec(name=name, port=port, data_dir=data_dir))
+
+    return instances
+
+
+def load_cluster_spec(source: str, base_dir: Path) -> list[InstanceSpec]:
+    """Load a cluster spec from inline JSON or a path to a JSON file."""
+    text = source.strip()
+    if not text.startswith("{"):
+        text = Path(text).expanduser().read_text(encoding="utf-8")
+    try:
+        spec = json.loads(text)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"Cluster spec is not valid JSON: {e}") from e
+    return parse_cluster_spec(spec, base_dir)
@@ -0,0 +1,65 @@
+"""
+OWL Ontology Import for Docker SWISH MCP
+
+owl_import loads an OWL ontology (RDF/XML, Turtle, ...) into the semweb
+triple store like rdf_load does, then converts its named classes,
+properties, individuals and axioms to plain facts (see
+mcp_owl_import/5 in mcp_helpers.pl), e.g.
+
+    owl_subclass(dog, mammal).   owl_type(rex, dog).   owl_value(rex, owner, alice).
+
+and adds rules that reason over them in the spirit of OWL RL:
+subclass and subproperty closure (through equivalences), property
+values entailed by inverse, symmetric and transitive properties, class
+membership from types, domains and ranges, and disjointness clashes.
+Class expressions built from blank nodes (restrictions, unions, ...)
+are not converted; the import reports how many triples it skipped.
+
+Names are the IRIs' local names ("dog" for http://example.org/zoo#dog)
+unless two IRIs share one, which then keep their full IRIs; with
+names="iri" every resource is its full IRI.
+"""
+
+from typing import Any
+
+from .rdf import prolog_atom
+
+OWL_NAME_MODES = ("local", "iri")
+
+REASONING_PREDICATES = {
+    "owl_subclass_of(C, D)": "C is D or one of its subclasses",
+    "owl_instance_of(I, C)": "I belongs to C (asserted, by domain/range, or through superclasses)",
+    "owl_subproperty_of(P, Q)": "P is Q or one of its subproperties",
+    "owl_holds(S, P, O)": "S has value O for P, also through subproperties, inverses, symmetry and transitivity",
+    "owl_inconsistent(I, C, D)": "I belongs to the disjoint classes C and D",
+}
+
+
+def owl_content_format(content: str) -> str:
+    """The format of ontology text given inline: RDF/XML when it starts with a tag, else Turtle."""
+    return "xml" if content.lstrip().startswith("<") else "turtle"
+
+
+def owl_import_call(module: str, graph: str, names: str, replace: bool) -> tuple[str, list[str]]:
+    """The mcp_owl_import/5 call converting graph into owl_* facts in module."""
+    if names not in OWL_NAME_MODES:
+        raise ValueError(f"Unknown names '{names}'. Use: {', '.join(OWL_NAME_MODES)}")
+    return "mcp_owl_import", [prolog_atom(module), prolog_atom(graph), names, "true" if replace else "false"]
+
+
+def format_owl_import(relative: str, report: dict[str, Any], replace: bool) -> str:
+    facts = {name: count for name, count in report.get("facts", {}).items() if count}
+    total = sum(facts.values())
+    lines = [
+        f"🦉 Imported {relative} (graph '{report.get('graph', '')}', {report.get('triples', 0)} triples)",
+        f"📊 {total} fact(s) in the knowledge base" + (", replacing the previous ontology facts" if replace else ""),
+    ]
+    if facts:
+        lines.extend(f"   • {name}: {count}" for name, count in sorted(facts.items()))
+    skipped = report.get("skipped", 0)
+    if skipped:
+        lines.append(f"⏭️ {skipped} triple(s) about blank nodes (restrictions, class expressions) were not converted")
+    lines.append("🧠 Reasoning predicates" + (" added:" if report.get("rules_added") else ":"))
+    lines.extend(f"   • {head}: {meaning}" for head, meaning in REASONING_PREDICATES.items())
+    lines.append("💡 Try: ?- owl_instance_of(X, C).   ?- owl_subclass_of(C, D).")
+    return "\n".join(lines)
@@ -0,0 +1,73 @@
+"""
+Prolog Pack Management for Docker SWISH MCP
+
+Builds non-interactive pack_install/2, pack_remove/2 and pack listing goals
+to run inside the SWISH container.
+"""
+
+import re
+
+PACK_NAME_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+PACK_URL_RE = re.compile(r"^https://[\w.-]+(/[\w.~%+-]*)*(\.git|\.zip|\.tgz|\.tar\.gz)?/?$")
+
+# Marker written before each listed pack so warnings on stdout are ignored
+PACK_LINE_PREFIX = "PACK\t"
+
+
+def validate_pack_name(name: str) -> None:
+    """Raise ValueError unless name is a valid pack name."""
+    if not PACK_NAME_RE.match(name):
+        raise ValueError(f"Invalid pack name '{name}'")
+
+
+def pack_source(name: str, url: str = "") -> str:
+    """Return the Prolog term identifying what to install."""
+    if url:
+        if not PACK_URL_RE.match(url):
+            raise ValueError(f"Pack URL must be a plain https:// URL, got '{url}'")
+        return f"'{url}'"
+    validate_pack_name(name)
+    return name
+
+
+def install_goal(name: str, url: str = "", upgrade: bool = False) -> str:
+    """Goal installing a pack without prompting."""
+    options = ["interactive(false)", "silent(true)"]
+    if upgrade:
+        options.append("upgrade(true)")
+    if url and name:
+        validate_pack_name(name)
+        options.append(f"pack({name})")
+    source = pack_source(name, url)
+    return f"pack_install({source}, [{', '.join(options)}])"
+
+
+def remove_goal(name: str) -> str:
+    """Goal removing a pack, falling back to pack_remove/1 on older SWI-Prolog."""
+    validate_pack_name(name)
+    return (
+        f"catch(pack_remove({name}, [interactive(false)]), "
+        f"error(existence_error(procedure, _), _), pack_remove({name}))"
+    )
+
+
+def list_goal() -> str:
+    """Goal printing one tab-separated line per installed pack."""
+    return (
+        "use_module(library(prolog_pack)), "
+        "forall(pack_property(P, version(V)), "
+        "( ( pack_property(P, title(T)) -> true ; T = '' ), "
+        'format("PACK\\t~w\\t~w\\t~w~n", [P, V, T]) ))'
+    )
+
+
+def parse_pack_list(stdout: str) -> list[dict[str, str]]:
+    """Parse the output of list_goal() into pack records."""
+    packs = []
+    for line in stdout.splitlines():
+        if not line.startswith(PACK_LINE_PREFIX):
+            continue
+        fields = line[len(PACK_LINE_PREFIX):].split("\t")
+        fields += [""] * (3 - len(fields))
+        packs.append({"name": fields[0], "version": fields[1], "title": fields[2]})
+    return sorted(packs, key=lambda p: p["name"])
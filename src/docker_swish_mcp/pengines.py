@@ -0,0 +1,279 @@
+"""
+Pengine Session Manager for Docker SWISH MCP
+
+Creates and tracks SWISH pengines (Prolog engines served over HTTP) per MCP
+client, so solutions can be pulled one chunk at a time across tool calls
+instead of re-running the whole query. Requests go through SwishHttp, so
+a container that is still starting raises SwishUnavailable rather than
+PengineError.
+"""
+
+import asyncio
+import logging
+import time
+from dataclasses import dataclass, field
+from typing import Any
+
+from .swish_http import SwishHttp, SwishRequestFailed
+
+logger = logging.getLogger("docker-swish-mcp.pengines")
+
+
+class PengineError(Exception):
+    """Raised when SWISH rejects a pengine request."""
+
+
+@dataclass
+class PengineState:
+    """Book-keeping for one pengine owned by an MCP client."""
+    pengine_id: str
+    client_id: str
+    src_text: str = ""
+    query: str | None = None
+    more: bool = False
+    solutions_seen: int = 0
+    created: float = field(default_factory=time.time)
+    last_used: float = field(default_factory=time.time)
+
+
+class PengineManager:
+    """
+    Manages SWISH pengines on behalf of MCP clients.
+
+    Each pengine lives on the SWISH server until it is destroyed, keeping
+    its query open so that "next" resumes backtracking where it stopped.
+    """
+
+    def __init__(self, base_url: str, max_per_client: int = 8, http: SwishHttp | None = None):
+        self.base_url = base_url.rstrip("/")
+        self.max_per_client = max_per_client
+        self.http = http or SwishHttp(self.base_url)
+        self.pengines: dict[str, PengineState] = {}
+        # Aborts of cancelled run_once() queries still under way
+        self.aborting: set[asyncio.Task] = set()
+
+    async def _post(self, path: str, timeout: float = 60, **kwargs: Any) -> dict[str, Any]:
+        """POST to the pengine API and return the decoded JSON event."""
+        try:
+            reply = await self.http.request("POST", f"/pengine/{path}", timeout, idempotent=False, **kwargs)
+        except SwishRequestFailed as e:
+            raise PengineError(str(e)) from e
+        result: dict[str, Any] = reply.json()
+        return result
+
+    async def _send(self, state: PengineState, event: str, timeout: float = 60) -> dict[str, Any]:
+        """Send a Prolog event term (e.g. "next") to a pengine."""
+        state.last_used = time.time()
+        return await self._post(
+            "send",
+            timeout,
+            params={"id": state.pengine_id, "format": "json"},
+            data=f"{event}.\n".encode(),
+            headers={"Content-Type": "application/x-prolog; charset=UTF-8"}
+        )
+
+    def get(self, client_id: str, pengine_id: str) -> PengineState:
+        """Look up a pengine, making sure it belongs to the calling client."""
+        state = self.pengines.get(pengine_id)
+        if state is None or state.client_id != client_id:
+            raise PengineError(f"Unknown pengine '{pengine_id}'. Use pengine_list() to see yours.")
+        return state
+
+    def list_for(self, client_id: str) -> list[PengineState]:
+        """Return all pengines owned by a client."""
+        return [s for s in self.pengines.values() if s.client_id == client_id]
+
+    async def create(
+        self,
+        client_id: str,
+        src_text: str = "",
+        query: str | None = None,
+        chunk: int = 1
+    ) -> tuple[PengineState, dict[str, Any] | None]:
+        """
+        Create a pengine, optionally asking a first query straight away.
+
+        Returns:
+            The new pengine state and the first answer event, if a query was given
+        """
+        if len(self.list_for(client_id)) >= self.max_per_client:
+            raise PengineError(
+                f"Client already owns {self.max_per_client} pengines; stop one with pengine_stop()"
+            )
+
+        payload: dict[str, Any] = {
+            "format": "json",
+            "application": "swish",
+            "destroy": False,
+            "chunk": max(1, chunk),
+        }
+        if src_text:
+            payload["src_text"] = src_text
+        if query:
+            payload["ask"] = query
+
+        event = await self._post("create", json=payload)
+        if event.get("event") != "create":
+            raise PengineError(f"Unexpected reply to create: {event}")
+
+        state = PengineState(
+            pengine_id=event["id"],
+            client_id=client_id,
+            src_text=src_text,
+            query=query
+        )
+        self.pengines[state.pengine_id] = state
+        logger.info(f"Created pengine {state.pengine_id} for client {client_id}")
+
+        answer = event.get("answer")
+        if answer is not None:
+            self._track_answer(state, answer)
+        return state, answer
+
+    async def run_once(
+        self,
+        query: str,
+        src_text: str = "",
+        max_solutions: int = 100,
+        timeout: float = 30
+    ) -> dict[str, Any]:
+        """
+        Run a query on a throwaway pengine and return its first answer.
+
+        Up to max_solutions solutions come back in one chunk. The pengine
+        is not tracked, and is destroyed even if more solutions remain.
+        It is created before the query is asked, so that a cancelled call
+        knows which pengine to abort.
+        """
+        payload: dict[str, Any] = {
+            "format": "json",
+            "application": "swish",
+            "destroy": True,
+        }
+        if src_text:
+            payload["src_text"] = src_text
+
+        event = await self._post("create", timeout=timeout, json=payload)
+        if event.get("event") != "create":
+            raise PengineError(f"Unexpected reply to create: {event}")
+        state = PengineState(pengine_id=event["id"], client_id="")
+        goal = query.strip().removesuffix(".")
+        try:
+            answer = await self._send(state, f"ask(({goal}), [chunk({max(1, max_solutions)})])", timeout)
+        except asyncio.CancelledError:
+            # Not awaited: the task is being cancelled
+            task = asyncio.ensure_future(self.abort(state))
+            self.aborting.add(task)
+            task.add_done_callback(self.aborting.discard)
+            raise
+        if answer.get("event") == "destroy" and isinstance(answer.get("data"), dict):
+            answer = answer["data"]
+        if answer.get("more"):
+            await self.destroy(state)
+        return answer
+
+    async def ask(self, client_id: str, pengine_id: str, query: str, chunk: int = 1) -> dict[str, Any]:
+        """Ask a new query on an idle pengine."""
+        state = self.get(client_id, pengine_id)
+        if state.more:
+            raise PengineError("Pengine still has an open query; call pengine_next() or pengine_stop() first")
+        goal = query.strip().removesuffix(".")
+        answer = await self._send(state, f"ask(({goal}), [chunk({max(1, chunk)})])")
+        state.query = goal
+        state.solutions_seen = 0
+        self._track_answer(state, answer)
+        return answer
+
+    async def next(self, client_id: str, pengine_id: str) -> dict[str, Any]:
+        """Backtrack into the open query for the next chunk of solutions."""
+        state = self.get(client_id, pengine_id)
+        if not state.more:
+            raise PengineError("No open query on this pengine; use pengine_ask() first")
+        answer = await self._send(state, "next")
+        self._track_answer(state, answer)
+        return answer
+
+    async def stop(self, client_id: str, pengine_id: str, destroy: bool = False) -> dict[str, Any]:
+        """Stop the open query, and optionally destroy the pengine."""
+        state = self.get(client_id, pengine_id)
+        answer: dict[str, Any] = {"event": "stop"}
+        if state.more:
+            answer = await self._send(state, "stop")
+            state.more = False
+        if destroy:
+            await self.destroy(state)
+            answer = {"event": "destroy"}
+        return answer
+
+    async def destroy(self, state: PengineState) -> None:
+        """Destroy a pengine on the server and forget about it."""
+        self.pengines.pop(state.pengine_id, None)
+        try:
+            await self._send(state, "destroy")
+        except Exception as e:
+            logger.debug(f"Destroying pengine {state.pengine_id}: {e}")
+
+    async def abort(self, state: PengineState) -> None:
+        """Abort the query a pengine is running; one created with destroy set then goes away."""
+        try:
+            await self._post("abort", timeout=10, params={"id": state.pengine_id, "format": "json"})
+        except Exception as e:
+            logger.debug(f"Aborting pengine {state.pengine_id}: {e}")
+
+    async def cleanup(self) -> None:
+        """Destroy every tracked pengine."""
+        for state in list(self.pengines.values()):
+            await self.destroy(state)
+
+    def _track_answer(self, state: PengineState, answer: dict[str, Any]) -> None:
+        """Update the open-query state from an answer event."""
+        if answer.get("event") == "destroy" and isinstance(answer.get("data"), dict):
+            answer = answer["data"]
+        if answer.get("event") == "success":
+            state.more = bool(answer.get("more"))
+            state.solutions_seen += len(answer.get("data", []))
+        else:
+            state.more = False
+
+    def get_status(self, client_id: str) -> list[dict[str, Any]]:
+        """Summarise a client's pengines."""
+        return [
+            {
+                "id": s.pengine_id,
+                "query": s.query,
+                "more": s.more,
+                "solutions_seen": s.solutions_seen,
+                "idle_seconds": round(time.time() - s.last_used, 1),
+            }
+            for s in self.list_for(client_id)
+        ]
+
+
+def answer_rows(answer: dict[str, Any]) -> list[str]:
+    """Bindings of a success answer, one "X = a, Y = b" row per solution."""
+    rows = []
+    for bindings in answer.get("data", []):
+        if bindings:
+            rows.append(", ".join(f"{k} = {v}" for k, v in bindings.items()))
+        else:
+            rows.append("true")
+    return rows
+
+
+def format_answer(answer: dict[str, Any]) -> str:
+    """Render a pengine answer event the way the query tool renders results."""
+    if answer.get("event") == "destroy" and isinstance(answer.get("data"), dict):
+        answer = answer["data"]
+
+    event = answer.get("event")
+    if event == "success":
+        rows = answer_rows(answer)
+        more = "💡 More solutions available: call pengine_next()" if answer.get("more") else "💡 No more solutions"
+        return "📋 Results:\n" + "\n".join(f"  • {r}" for r in rows) + f"\n\n{more}"
+    if event == "failure":
+        return "📋 Result: false (no more solutions)"
+    if event == "error":
+        return f"📋 Error: {answer.get('data')}"
+    if event == "stop":
+        return "📋 Query stopped"
+    return f"📋 Event: {event}"
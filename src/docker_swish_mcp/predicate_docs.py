@@ -0,0 +1,115 @@
+"""
+Predicate Documentation for Docker SWISH MCP
+
+describe_predicate looks up a predicate the way a Prolog programmer
+would with help/1: mcp_describe/4 (see mcp_helpers.pl) finds every
+predicate of that name visible in the client's module (loading it from
+its library if it would be autoloaded), and reports its properties,
+where it is defined and the PlDoc comment in front of it, read from the
+source file with prolog_xref. Built-ins, which have no source, get the
+summary line of the reference manual instead, where the image has it
+installed.
+
+The result is markdown: one section per predicate with its mode lines
+and determinism, summary, source location, properties and the rest of
+the comment.
+"""
+
+import re
+import textwrap
+from typing import Any
+
+from .rdf import prolog_atom
+
+# [Module:]Name[/Arity or //Arity]; Name may be quoted
+SPEC_RE = re.compile(r"^(?:(?P<module>[a-z]\w*):)?(?P<name>.+?)(?:(?P<slashes>//?)(?P<arity>\d+))?$")
+# Leading comment markers of the lines of a PlDoc comment
+MARKER_RE = re.compile(r"^\s*(?:%!?|/\*\*|\*(?!/))\s?")
+
+
+def parse_spec(text: str) -> tuple[str, str, int]:
+    """
+    The module ("" if not given), name and arity (-1 for any) of a predicate spec.
+
+    Name//Arity is a DCG nonterminal, described as Name/(Arity+2).
+    """
+    match = SPEC_RE.match(text.strip())
+    if match is None:
+        raise ValueError(f"Invalid predicate '{text}': use name, name/arity or module:name/arity, e.g. foldl/4")
+    name = match["name"]
+    if len(name) > 1 and name[0] == name[-1] == "'":
+        name = name[1:-1].replace("''", "'")
+    arity = -1
+    if match["arity"] is not None:
+        arity = int(match["arity"]) + (2 if match["slashes"] == "//" else 0)
+    return match["module"] or "", name, arity
+
+
+def describe_call(name: str, arity: int, module: str = "user") -> tuple[str, list[str]]:
+    return "mcp_describe", [prolog_atom(module), prolog_atom(name), str(int(arity))]
+
+
+def comment_body(comment: str) -> str:
+    """The text of a PlDoc comment after its mode lines, without comment markers."""
+    text = comment.strip()
+    if text.startswith("/**"):
+        text = text[3:]
+    if text.endswith("*/"):
+        text = text[:-2]
+    lines = [MARKER_RE.sub("", line, count=1).rstrip() for line in text.splitlines()]
+    # The first paragraph holds the mode lines
+    while lines and lines[0].strip():
+        lines.pop(0)
+    return textwrap.dedent("\n".join(lines)).strip()
+
+
+def source_location(row: dict[str, Any], data_dir: str) -> str:
+    file = str(row.get("file") or "")
+    if not file:
+        return ""
+    prefix = data_dir.rstrip("/") + "/"
+    if file.startswith(prefix):
+        file = file[len(prefix):]
+    line = int(row.get("line") or 0)
+    return f"{file}:{line}" if line else file
+
+
+def format_predicate(row: dict[str, Any], data_dir: str) -> str:
+    """One predicate's markdown section."""
+    title = f"`{row['module']}:{row['predicate']}`"
+    parts = [f"### {title}"]
+    modes = row.get("modes") or []
+    if modes:
+        parts.append("\n".join(
+            f"- `{mode['mode']}`" + (f" is **{mode['det']}**" if mode.get("det") else "") for mode in modes
+        ))
+    summary = str(row.get("summary") or row.get("manual") or "").strip()
+    if summary:
+        parts.append(summary)
+
+    facts = []
+    location = source_location(row, data_dir)
+    if location:
+        facts.append(f"- **Source:** `{location}`")
+    elif "built_in" in row.get("properties", []):
+        facts.append("- **Source:** built-in")
+    properties = [p for p in row.get("properties", []) if p != "built_in" or location]
+    if properties:
+        facts.append(f"- **Properties:** {', '.join(properties)}")
+    if row.get("meta"):
+        facts.append(f"- **Meta-predicate:** `{row['meta']}`")
+    if row.get("clauses") and "built_in" not in row.get("properties", []):
+        facts.append(f"- **Clauses:** {row['clauses']}")
+    if facts:
+        parts.append("\n".join(facts))
+
+    body = comment_body(str(row.get("comment") or ""))
+    if body:
+        parts.append(body)
+    elif not summary:
+        parts.append("_No PlDoc comment._")
+    return "\n\n".join(parts)
+
+
+def format_descriptions(rows: list[dict[str, Any]], data_dir: str) -> str:
+    return "\n\n".join(format_predicate(row, data_dir) for row in rows)
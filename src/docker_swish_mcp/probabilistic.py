@@ -0,0 +1,76 @@
+"""
+Probabilistic Inference for Docker SWISH MCP
+
+With SWISH_MCP_PROBABILISTIC=cplint the server installs the cplint pack
+in the container when it starts (which needs a C compiler in the image
+for its bddem dependency) and enables probabilistic_query. Programs are
+Logic Programs with Annotated Disjunctions, written between the
+begin_lpad/end_lpad directives that this module adds:
+
+    heads(Coin):0.5 ; tails(Coin):0.5 :- toss(Coin).
+    toss(coin).
+
+Each query runs in a fresh swipl process with the program loaded by
+cplint's PITA, so nothing reaches the persistent session. mcp_prob/3
+(see mcp_helpers.pl) emits one row per ground instance of the query,
+with the probability prob/2 computed for it.
+"""
+
+import json
+from typing import Any
+
+from .config import QueryLimits
+from .data_export import term_text
+from .simple_session import HELPERS_PATH, MARKER_RE, prolog_string
+
+CPLINT_PACK = "cplint"
+
+# Query id of the probability rows in the process output
+PROB_ID = "prob"
+
+
+def lpad_program(program: str) -> str:
+    """PITA, the helpers (mcp_prob/3 needs prob/2 loaded) and program."""
+    return "\n".join([
+        ":- use_module(library(pita)).",
+        HELPERS_PATH.read_text(encoding="utf-8"),
+        ":- pita.",
+        ":- begin_lpad.",
+        program,
+        ":- end_lpad.",
+        "",
+    ])
+
+
+def prob_goal(query: str, limits: QueryLimits) -> str:
+    return f"mcp_prob({PROB_ID}, {prolog_string(query)}, {limits.to_prolog()})"
+
+
+def parse_prob_output(stdout: str) -> list[dict[str, Any]]:
+    """Rows of {"bindings", "probability"}; raises RuntimeError if the query failed."""
+    rows = []
+    finished = False
+    for line in stdout.splitlines():
+        match = MARKER_RE.search(line)
+        if match is None or match.group(1) != PROB_ID:
+            continue
+        kind, payload = match.group(2), match.group(3) or ""
+        if kind == "ERROR":
+            raise RuntimeError(payload)
+        if kind == "SOLUTION":
+            rows.append(json.loads(payload))
+        elif kind == "END":
+            finished = True
+    if not finished:
+        raise RuntimeError("the inference process exited before reporting")
+    return rows
+
+
+def format_probabilities(query: str, rows: list[dict[str, Any]]) -> str:
+    if not rows:
+        return f"❌ Query: {query}\n📋 No instances of the query were found in the program"
+    lines = [f"🎲 Query: {query}", f"📋 {len(rows)} solution(s):"]
+    for row in sorted(rows, key=lambda row: -row["probability"]):
+        bindings = ", ".join(f"{name} = {term_text(value)}" for name, value in row["bindings"].items())
+        lines.append(f"  • P = {row['probability']:.6g}" + (f"  ({bindings})" if bindings else ""))
+    return "\n".join(lines)
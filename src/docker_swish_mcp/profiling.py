@@ -0,0 +1,119 @@
+"""
+Execution Profiling for Docker SWISH MCP
+
+profile_query runs a goal once in the persistent session under
+SWI-Prolog's sampling profiler (profiler/2, what profile/1 runs on) and
+reports, for every predicate that ran, how often it was called, retried
+and exited and how much CPU time it took:
+
+- exclusive (self): time in the predicate's own clauses
+- inclusive: that plus the time of everything it called
+
+Times are sample ticks scaled to the profiled CPU time, so a predicate
+that ran for less than a tick shows as 0; the call counts are exact.
+The helpers that drive the profiler (mcp_*) are left out.
+"""
+
+import re
+from dataclasses import asdict, dataclass
+from typing import Any
+
+from .config import QueryLimits
+from .simple_session import clean_query_text, prolog_string
+
+SORT_KEYS = ("inclusive", "exclusive", "calls")
+MAX_TOP = 500
+
+HELPER_RE = re.compile(r"^(?:\w+:)?mcp_")
+
+
+@dataclass
+class ProfileEntry:
+    """One predicate's line of the profile, times in seconds."""
+    predicate: str
+    calls: int
+    redos: int
+    exits: int
+    inclusive: float
+    exclusive: float
+    inclusive_percent: float
+    exclusive_percent: float
+
+    @property
+    def fails(self) -> int:
+        return max(0, self.calls + self.redos - self.exits)
+
+    def to_json(self) -> dict[str, Any]:
+        return {**asdict(self), "fails": self.fails}
+
+
+def profile_call(query: str, limits: QueryLimits) -> tuple[str, list[str]]:
+    """The mcp_profile/3 call running query once under the profiler."""
+    return ("mcp_profile", [prolog_string(clean_query_text(query)), limits.to_prolog()])
+
+
+def profile_entries(report: dict[str, Any], sort_by: str = "inclusive", top: int = 20) -> list[ProfileEntry]:
+    """The top predicates of an mcp_profile/3 report by sort_by."""
+    if sort_by not in SORT_KEYS:
+        raise ValueError(f"Unknown sort_by '{sort_by}'. Use: {', '.join(SORT_KEYS)}")
+    if not 1 <= top <= MAX_TOP:
+        raise ValueError(f"top must be between 1 and {MAX_TOP}")
+    summary = report.get("summary", {})
+    ticks = summary.get("ticks") or 0
+    seconds = float(summary.get("time") or 0)
+    entries = []
+    for node in report.get("nodes", []):
+        predicate = str(node.get("predicate", ""))
+        if HELPER_RE.match(predicate):
+            continue
+        own = int(node.get("ticks_self", 0))
+        total = own + int(node.get("ticks_children", 0))
+        entries.append(ProfileEntry(
+            predicate=predicate,
+            calls=int(node.get("calls", 0)),
+            redos=int(node.get("redos", 0)),
+            exits=int(node.get("exits", 0)),
+            inclusive=round(total / ticks * seconds, 6) if ticks else 0.0,
+            exclusive=round(own / ticks * seconds, 6) if ticks else 0.0,
+            inclusive_percent=round(100 * total / ticks, 2) if ticks else 0.0,
+            exclusive_percent=round(100 * own / ticks, 2) if ticks else 0.0,
+        ))
+    key = {
+        "inclusive": lambda e: (e.inclusive, e.calls),
+        "exclusive": lambda e: (e.exclusive, e.calls),
+        "calls": lambda e: (e.calls, e.inclusive),
+    }[sort_by]
+    return sorted(entries, key=key, reverse=True)[:top]
+
+
+def outcome_text(report: dict[str, Any]) -> str:
+    outcome = report.get("outcome")
+    if outcome is True:
+        bindings = report.get("bindings") or {}
+        return f"✅ Succeeded ({len(bindings)} binding(s))" if bindings else "✅ Succeeded"
+    if outcome is False:
+        return "❌ Failed (no solutions)"
+    return f"💥 Error: {report.get('error', '')}"
+
+
+def format_profile(query: str, report: dict[str, Any], entries: list[ProfileEntry], sort_by: str) -> str:
+    summary = report.get("summary", {})
+    order = "calls" if sort_by == "calls" else f"{sort_by} time"
+    lines = [
+        f"⏱️ Profile of: {clean_query_text(query)}.",
+        outcome_text(report),
+        f"📊 {summary.get('samples', 0):,} samples over {float(summary.get('time') or 0):.3f}s CPU, "
+        f"{len(report.get('nodes', []))} predicates ran; top {len(entries)} by {order}",
+    ]
+    if not entries:
+        lines.append("\n(no predicates were sampled)")
+        return "\n".join(lines)
+    width = max(len("Predicate"), *(len(e.predicate) for e in entries))
+    lines.append("")
+    lines.append(f"{'Predicate':<{width}}  {'Calls':>10}  {'Redos':>8}  {'Fails':>8}  {'Inclusive':>16}  {'Self':>16}")
+    for e in entries:
+        lines.append(
+            f"{e.predicate:<{width}}  {e.calls:>10,}  {e.redos:>8,}  {e.fails:>8,}  "
+            f"{e.inclusive:>8.3f}s {e.inclusive_percent:>5.1f}%  {e.exclusive:>8.3f}s {e.exclusive_percent:>5.1f}%"
+        )
+    return "\n".join(lines)
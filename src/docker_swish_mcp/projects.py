@@ -0,0 +1,203 @@
+"""
+Multi-file Knowledge Base Projects for Docker SWISH MCP
+
+A project is a subdirectory of the data directory holding several .pl files
+and a project.json manifest that records their load order:
+
+    {"name": "family", "description": "...", "files": ["facts.pl", "rules.pl"]}
+
+Files are consulted in manifest order, so later files may rely on
+predicates and operators defined by earlier ones.
+"""
+
+import json
+import logging
+import re
+from dataclasses import asdict, dataclass, field
+from pathlib import Path
+
+from .host_platform import normalize_newlines
+
+logger = logging.getLogger("docker-swish-mcp.projects")
+
+MANIFEST_NAME = "project.json"
+NAME_RE = re.compile(r"^[A-Za-z][A-Za-z0-9_-]*$")
+
+
+class ProjectError(Exception):
+    """Raised for invalid project names, files or manifests."""
+
+
+@dataclass
+class ProjectManifest:
+    """Manifest of a multi-file project."""
+    name: str
+    description: str = ""
+    files: list[str] = field(default_factory=list)
+
+    def to_json(self) -> str:
+        return json.dumps(asdict(self), indent=2)
+
+
+def validate_name(name: str, what: str = "project") -> str:
+    """Return name if it is a safe project or file stem, else raise ProjectError."""
+    if not NAME_RE.match(name):
+        raise ProjectError(
+            f"Invalid {what} name '{name}': use letters, digits, '_' or '-', starting with a letter"
+        )
+    return name
+
+
+def normalize_filename(filename: str) -> str:
+    """Validate a project file name and ensure it has the .pl extension."""
+    stem = filename[:-3] if filename.endswith(".pl") else filename
+    validate_name(stem, "file")
+    return f"{stem}.pl"
+
+
+def project_dir(data_dir: Path, name: str) -> Path:
+    return data_dir / validate_name(name)
+
+
+def load_manifest(data_dir: Path, name: str) -> ProjectManifest:
+    """
+    Read a project's manifest, dropping entries whose files are gone.
+
+    The project is always named after its directory, whatever the
+    manifest says, and entries that are not plain project file names
+    are dropped too, so an edited manifest cannot point outside it.
+    """
+    base = project_dir(data_dir, name)
+    manifest_path = base / MANIFEST_NAME
+    if not manifest_path.exists():
+        raise ProjectError(f"Project '{name}' not found")
+    try:
+        raw = json.loads(manifest_path.read_text(encoding="utf-8"))
+    except json.JSONDecodeError as e:
+        raise ProjectError(f"Manifest of '{name}' is not valid JSON: {e}") from e
+    files = []
+    for entry in raw.get("files", []):
+        entry = str(entry)
+        try:
+            valid = normalize_filename(entry) == entry
+        except ProjectError:
+            valid = False
+        if valid and (base / entry).is_file():
+            files.append(entry)
+        else:
+            logger.warning(f"Dropping '{entry}' from the manifest of '{name}': not a file of the project")
+    return ProjectManifest(
+        name=name,
+        description=raw.get("description", ""),
+        files=files,
+    )
+
+
+def save_manifest(data_dir: Path, manifest: ProjectManifest) -> None:
+    path = project_dir(data_dir, manifest.name)
+    path.mkdir(parents=True, exist_ok=True)
+    (path / MANIFEST_NAME).write_text(manifest.to_json() + "\n", encoding="utf-8")
+
+
+def list_projects(data_dir: Path) -> list[ProjectManifest]:
+    """All projects in the data directory, sorted by name."""
+    if not data_dir.exists():
+        return []
+    projects = []
+    for manifest_path in sorted(data_dir.glob(f"*/{MANIFEST_NAME}")):
+        try:
+            projects.append(load_manifest(data_dir, manifest_path.parent.name))
+        except ProjectError as e:
+            logger.warning(f"Skipping project {manifest_path.parent.name}: {e}")
+    return projects
+
+
+def create_project(data_dir: Path, name: str, description: str = "") -> ProjectManifest:
+    """Create an empty project with a manifest."""
+    if (project_dir(data_dir, name) / MANIFEST_NAME).exists():
+        raise ProjectError(f"Project '{name}' already exists")
+    manifest = ProjectManifest(name=name, description=description)
+    save_manifest(data_dir, manifest)
+    return manifest
+
+
+def write_file(
+    data_dir: Path,
+    name: str,
+    filename: str,
+    content: str,
+    overwrite: bool = False,
+    position: int | None = None
+) -> ProjectManifest:
+    """
+    Create or replace a file in a project.
+
+    New files are appended to the load order, or inserted at position
+    (0-based) when given. Replacing a file keeps its position.
+    """
+    manifest = load_manifest(data_dir, name)
+    filename = normalize_filename(filename)
+    file_path = project_dir(data_dir, name) / filename
+    if file_path.exists() and not overwrite:
+        raise ProjectError(f"File '{filename}' already exists in '{name}'. Use overwrite=True to replace.")
+    file_path.write_text(normalize_newlines(content), encoding="utf-8", newline="\n")
+
+    if filename not in manifest.files:
+        if position is None or position >= len(manifest.files):
+            manifest.files.append(filename)
+        else:
+            manifest.files.insert(max(position, 0), filename)
+    save_manifest(data_dir, manifest)
+    return manifest
+
+
+def rename_file(data_dir: Path, name: str, old: str, new: str) -> ProjectManifest:
+    """Rename a project file, keeping its place in the load order."""
+    manifest = load_manifest(data_dir, name)
+    old, new = normalize_filename(old), normalize_filename(new)
+    base = project_dir(data_dir, name)
+    if not (base / old).exists():
+        raise ProjectError(f"File '{old}' not found in '{name}'")
+    if (base / new).exists():
+        raise ProjectError(f"File '{new}' already exists in '{name}'")
+    (base / old).rename(base / new)
+    manifest.files = [new if f == old else f for f in manifest.files]
+    save_manifest(data_dir, manifest)
+    return manifest
+
+
+def delete_file(data_dir: Path, name: str, filename: str) -> ProjectManifest:
+    """Delete a project file and drop it from the load order."""
+    manifest = load_manifest(data_dir, name)
+    filename = normalize_filename(filename)
+    file_path = project_dir(data_dir, name) / filename
+    if not file_path.exists() and filename not in manifest.files:
+        raise ProjectError(f"File '{filename}' not found in '{name}'")
+    file_path.unlink(missing_ok=True)
+    manifest.files = [f for f in manifest.files if f != filename]
+    save_manifest(data_dir, manifest)
+    return manifest
+
+
+def set_load_order(data_dir: Path, name: str, files: list[str]) -> ProjectManifest:
+    """Replace the load order; it must list every project file exactly once."""
+    manifest = load_manifest(data_dir, name)
+    order = [normalize_filename(f) for f in files]
+    if len(set(order)) != len(order):
+        raise ProjectError("Load order lists a file more than once")
+    present = {p.name for p in project_dir(data_dir, name).glob("*.pl")}
+    missing = [f for f in order if f not in present]
+    if missing:
+        raise ProjectError(f"Files not in project '{name}': {', '.join(missing)}")
+    unlisted = sorted(present - set(order))
+    if unlisted:
+        raise ProjectError(f"Load order must include every file; missing {', '.join(unlisted)}")
+    manifest.files = order
+    save_manifest(data_dir, manifest)
+    return manifest
+
+
+def consult_targets(data_dir: Path, name: str) -> list[str]:
+    """Paths to consult, in load order, relative to the container's /data."""
+    manifest = load_manifest(data_dir, name)
+    return [f"{name}/{f[:-3]}" for f in manifest.files]
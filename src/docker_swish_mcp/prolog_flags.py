@@ -0,0 +1,155 @@
+"""
+Prolog Flags of the Persistent Session for Docker SWISH MCP
+
+get_flags() shows and set_flag() changes the SWI-Prolog flags that
+decide how queries read and run, so a client sets them once instead of
+starting every query with set_prolog_flag/2:
+
+- double_quotes:        what "text" reads as: codes, chars, atom or string (the default)
+- back_quotes:          what `text` reads as: codes (the default), chars, string or symbol_char
+- unknown:              calling an undefined predicate raises an error (the default), warns or fails
+- occurs_check:         X = f(X) makes a cyclic term (false, the default), fails (true) or raises (error)
+- answer_write_options: how the toplevel of repl_send writes answers
+- print_write_options:  how print/1 and format's ~p write terms
+- prefer_rationals:     whether 1/3 evaluates to the rational 1r3 rather than a float
+
+SWI-Prolog keeps double_quotes, back_quotes and unknown per module:
+set_flag sets them in the client's module (see namespaces.py), whose
+queries are read with them, and the modules of other clients keep their
+own. The others are flags of the whole session, shared by every client.
+What set_flag sets is set again when the session restarts, after the
+startup programs; the value "default" puts a flag back and forgets it.
+"""
+
+import re
+from dataclasses import dataclass
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+FLAG_NAME_RE = re.compile(r"^[a-z][a-zA-Z0-9_]*$")
+
+
+@dataclass(frozen=True)
+class FlagSpec:
+    """A flag set_flag may change."""
+    name: str
+    # Values set_flag accepts; empty for a list of write options
+    values: tuple[str, ...]
+    default: str
+    # Kept per module by SWI-Prolog
+    module: bool = False
+
+
+FLAGS = {spec.name: spec for spec in (
+    FlagSpec("double_quotes", ("codes", "chars", "atom", "string"), "string", module=True),
+    FlagSpec("back_quotes", ("codes", "chars", "string", "symbol_char"), "codes", module=True),
+    FlagSpec("unknown", ("error", "warning", "fail"), "error", module=True),
+    FlagSpec("occurs_check", ("false", "true", "error"), "false"),
+    FlagSpec("answer_write_options", (), "[quoted(true),portray(true),max_depth(10),spacing(next_argument)]"),
+    FlagSpec("print_write_options", (), "[portray(true),numbervars(true)]"),
+    FlagSpec("prefer_rationals", ("false", "true"), "false"),
+)}
+
+
+def flag_value(name: str, value: str) -> str:
+    """The Prolog text of value for flag name ("default" is its default); raises ValueError."""
+    spec = FLAGS.get(name)
+    if spec is None:
+        raise ValueError(f"set_flag does not set '{name}'. Use one of: {', '.join(FLAGS)}")
+    value = value.strip()
+    if value == "default":
+        return spec.default
+    if spec.values:
+        if value not in spec.values:
+            raise ValueError(f"Invalid value '{value}' for {name}. Use one of: {', '.join(spec.values)} or default")
+        return value
+    if not (value.startswith("[") and value.endswith("]")) or "\n" in value:
+        raise ValueError(f"{name} takes a list of write options, e.g. [quoted(true), max_depth(20)]")
+    return value
+
+
+def flag_key(module: str, name: str) -> str:
+    """The key of flag name in set_prolog_flag/2: Module:Name for a module flag."""
+    spec = FLAGS.get(name)
+    return f"{prolog_atom(module)}:{name}" if spec is not None and spec.module else name
+
+
+def get_flags_call(module: str, names: list[str]) -> tuple[str, list[str]]:
+    """mcp_flags_get/2 call reading names, module flags as module has them."""
+    for name in names:
+        if not FLAG_NAME_RE.match(name):
+            raise ValueError(f"Invalid flag name '{name}'")
+    return "mcp_flags_get", ["[" + ", ".join(flag_key(module, name) for name in names) + "]"]
+
+
+def set_flag_call(module: str, values: dict[str, str]) -> tuple[str, list[str]]:
+    """mcp_flags_set/2 call setting values (checked by flag_value), module flags in module."""
+    pairs = ", ".join(f"{flag_key(module, name)}-{prolog_string(text)}" for name, text in values.items())
+    return "mcp_flags_set", [f"[{pairs}]"]
+
+
+def is_default(name: str, value: str) -> bool:
+    spec = FLAGS.get(name)
+    return spec is not None and value.replace(" ", "") == spec.default
+
+
+class SessionFlags:
+    """The flags set_flag set in one session, to set again when it restarts."""
+
+    def __init__(self) -> None:
+        # (module, flag) -> value text; module is "" for a flag of the whole session
+        self.values: dict[tuple[str, str], str] = {}
+
+    def record(self, module: str, name: str, value: str) -> None:
+        key = (module if FLAGS[name].module else "", name)
+        if is_default(name, value):
+            self.values.pop(key, None)
+        else:
+            self.values[key] = value
+
+    def is_set(self, module: str, name: str) -> bool:
+        return (module, name) in self.values or ("", name) in self.values
+
+    def restore_calls(self) -> list[tuple[str, list[str]]]:
+        """Helper calls setting the flags again, one per module, for a session that (re)starts."""
+        by_module: dict[str, dict[str, str]] = {}
+        for (module, name), value in self.values.items():
+            by_module.setdefault(module or "user", {})[name] = value
+        return [set_flag_call(module, values) for module, values in by_module.items()]
+
+
+def set_flag_errors(rows: list[dict[str, Any]]) -> list[str]:
+    return [f"{row['flag']}: {row['error']}" for row in rows if row.get("error")]
+
+
+def flag_rows(rows: list[dict[str, Any]], module: str, flags: SessionFlags) -> list[dict[str, Any]]:
+    """get_flags' JSON entries of mcp_flags_get/2 rows."""
+    entries = []
+    for row in rows:
+        name, value = row["flag"], row.get("value", "")
+        spec = FLAGS.get(name)
+        entries.append({
+            "flag": name,
+            "value": value,
+            "scope": f"module {module}" if spec is not None and spec.module else "session",
+            "default": is_default(name, value) if spec is not None else None,
+            "set_by_set_flag": flags.is_set(module, name),
+        })
+    return entries
+
+
+def format_flags(entries: list[dict[str, Any]], missing: list[str]) -> str:
+    lines = ["🚩 Prolog flags"]
+    for entry in entries:
+        notes = [entry["scope"]]
+        if entry["set_by_set_flag"]:
+            notes.append("set with set_flag")
+        elif entry["default"] is False:
+            notes.append("changed from its default")
+        lines.append(f"• {entry['flag']} = {entry['value']}  ({', '.join(notes)})")
+    if missing:
+        lines.append(f"\n❓ No such flag: {', '.join(missing)}")
+    lines.append(f"\n💡 set_flag changes {', '.join(FLAGS)}")
+    return "\n".join(lines)
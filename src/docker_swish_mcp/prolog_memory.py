@@ -0,0 +1,127 @@
+"""
+SWI-Prolog Memory Limits for Docker SWISH MCP
+
+SWI-Prolog stops a query with a resource error once its stacks grow past
+stack_limit (1 GiB on 64-bit systems unless changed), or the answer
+tables of tabled predicates past table_space. Deep recursion over a big
+input reaches the first sooner than one would think. The persistent
+session's swipl can be started with other limits, from the environment
+or the [prolog] table of the config file:
+
+- SWISH_MCP_STACK_LIMIT / stack_limit:               --stack-limit, e.g. "4g"
+- SWISH_MCP_TABLE_SPACE / table_space:               --table-space
+- SWISH_MCP_SHARED_TABLE_SPACE / shared_table_space: --shared-table-space,
+                                                     for tables shared by threads
+
+Unset or 0 keeps SWI-Prolog's default. Since SWI-Prolog 7.7 the global,
+local and trail stacks grow within the one stack_limit, so there is no
+separate global stack size to set. A changed limit applies the next time
+the session starts.
+
+execute_prolog_query can also set stack_limit and table_space for a
+single query: mcp_limited/2 sets the flags around the goal and restores
+them when it is done. prolog_stats reports the session's usage from
+statistics/2.
+"""
+
+from dataclasses import dataclass
+from typing import Any
+
+from .resources import MEMORY_RE, MEMORY_UNITS, format_bytes
+
+MIN_SIZE = 1024 * 1024
+SETTINGS = ("stack_limit", "table_space", "shared_table_space")
+
+# statistics/2 keys shown by prolog_stats, by section
+STACK_KEYS = ("stack", "globalused", "localused", "trailused", "global", "local", "trail", "c_stack")
+TABLE_KEYS = ("table_space_used",)
+PROGRAM_KEYS = ("atoms", "functors", "predicates", "modules", "clauses", "codes", "heapused", "threads")
+TIME_KEYS = ("cputime", "process_cputime", "inferences", "epoch")
+GC_KEYS = ("garbage_collection", "agc", "stack_shifts")
+SIZE_KEYS = {"stack", "globalused", "localused", "trailused", "global", "local", "trail", "c_stack",
+             "table_space_used", "heapused", "stack_limit", "table_space", "shared_table_space"}
+
+
+def parse_size(name: str, value: Any) -> int:
+    """Bytes of a size such as 4294967296, "4g" or "512MiB"; 0 keeps the default."""
+    if isinstance(value, bool):
+        raise ValueError(f"{name} must be a size such as '4g', not {value!r}")
+    if isinstance(value, int):
+        size = value
+    else:
+        match = MEMORY_RE.match(str(value))
+        if match is None:
+            raise ValueError(f"{name} must be a size such as '4g' or '512m', not {value!r}")
+        size = int(float(match[1]) * MEMORY_UNITS[match[2].lower()])
+    if size < 0 or 0 < size < MIN_SIZE:
+        raise ValueError(f"{name} must be 0 (the default) or at least 1m, not {value!r}")
+    return size
+
+
+@dataclass(frozen=True)
+class PrologMemory:
+    """Stack and table space limits the persistent session's swipl starts with, in bytes."""
+    stack_limit: int = 0
+    table_space: int = 0
+    shared_table_space: int = 0
+
+    @classmethod
+    def from_settings(cls, raw: dict[str, Any], base: "PrologMemory | None" = None) -> "PrologMemory":
+        """Limits from a settings table; empty values keep those of base."""
+        base = base or cls()
+        unknown = [key for key in raw if key not in SETTINGS]
+        if unknown:
+            raise ValueError(f"Unknown prolog settings {unknown}. Use: {', '.join(SETTINGS)}")
+        values = {}
+        for name in SETTINGS:
+            value = raw.get(name, "")
+            values[name] = getattr(base, name) if value == "" else parse_size(f"prolog.{name}", value)
+        return cls(**values)
+
+    def swipl_options(self) -> list[str]:
+        """Command-line options of swipl, e.g. ["--stack-limit=4294967296"]."""
+        return [
+            f"--{name.replace('_', '-')}={getattr(self, name)}"
+            for name in SETTINGS if getattr(self, name)
+        ]
+
+    def describe(self) -> str:
+        parts = [f"{name} {format_bytes(getattr(self, name))}" for name in SETTINGS if getattr(self, name)]
+        return ", ".join(parts) or "SWI-Prolog defaults"
+
+
+def stats_value(key: str, value: Any) -> str:
+    if key in SIZE_KEYS and isinstance(value, (int, float)):
+        return format_bytes(value)
+    if key == "garbage_collection" and isinstance(value, list) and len(value) >= 3:
+        return f"{value[0]} collections, {format_bytes(value[1])} reclaimed in {float(value[2]):.2f}s"
+    if key in ("cputime", "process_cputime") and isinstance(value, (int, float)):
+        return f"{value:.2f}s"
+    if isinstance(value, list):
+        return ", ".join(str(item) for item in value)
+    return f"{value:,}" if isinstance(value, int) else str(value)
+
+
+def format_stats(row: dict[str, Any]) -> str:
+    """prolog_stats' text report of an mcp_stats/1 row."""
+    stats = row.get("statistics", {})
+    flags = row.get("flags", {})
+    lines = ["📊 Prolog session statistics"]
+    limits = [f"{name} {stats_value(name, flags[name])}" for name in SETTINGS if name in flags]
+    if limits:
+        lines.append(f"\n⚙️ Limits: {', '.join(limits)}")
+    for title, keys in (
+        ("🥞 Stacks", STACK_KEYS),
+        ("📑 Tables", TABLE_KEYS),
+        ("📚 Program", PROGRAM_KEYS),
+        ("⏱️ Time", TIME_KEYS),
+        ("🧹 Garbage collection", GC_KEYS),
+    ):
+        present = [key for key in keys if key in stats]
+        if present:
+            lines.append(f"\n{title}:")
+            lines.extend(f"  {key}: {stats_value(key, stats[key])}" for key in present)
+    used, limit = stats.get("stack"), flags.get("stack_limit")
+    if isinstance(used, (int, float)) and isinstance(limit, (int, float)) and limit > 0:
+        lines.append(f"\n💡 Stacks use {used / limit:.1%} of stack_limit")
+    return "\n".join(lines)
@@ -0,0 +1,180 @@
+"""
+Workflow Prompts for Docker SWISH MCP
+
+MCP prompt templates for tasks Prolog newcomers ask for most: modelling
+a problem as constraints, turning facts written in prose or tables into
+Prolog, and finding out why a goal fails. Each prompt carries a summary
+of the current knowledge base, so the assistant builds on the files and
+predicates already loaded instead of guessing:
+
+- the knowledge base files in the data directory
+- the user-defined predicates of the client's module, with clause counts
+- for debug_failing_goal, the listing of the predicates the goal calls,
+  and which of them are not defined at all
+"""
+
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+
+# Predicates and files summarised in a prompt
+MAX_CONTEXT_PREDICATES = 60
+MAX_CONTEXT_FILES = 30
+# Characters of listing text included for debug_failing_goal
+MAX_LISTING_CHARS = 6000
+
+# name( in a goal, not inside a quoted atom's tail or a longer name
+GOAL_FUNCTOR_RE = re.compile(r"(?<![\w'\"])([a-z][A-Za-z0-9_]*)\s*\(")
+# Control constructs and common built-ins never reported as undefined
+KNOWN_GOALS = frozenset({
+    "call", "findall", "forall", "aggregate_all", "bagof", "setof", "member", "memberchk",
+    "append", "length", "nth0", "nth1", "last", "msort", "sort", "between", "succ", "plus",
+    "format", "write", "writeln", "print", "nl", "atom_length", "atom_codes", "atom_chars",
+    "atom_string", "number_codes", "sub_atom", "string_concat", "split_string", "atomic_list_concat",
+    "catch", "ignore", "once", "not", "assertz", "asserta", "retract", "retractall", "is",
+    "limit", "offset", "order_by", "distinct", "maplist", "foldl", "include", "exclude",
+    "sum_list", "max_list", "min_list", "list_to_set", "reverse", "select", "label",
+    "labeling", "all_different", "all_distinct", "sum", "tuples_in", "copy_term", "functor", "arg",
+})
+
+
+@dataclass
+class KbContext:
+    """What a prompt is told about the knowledge base."""
+    # False when the Prolog session is not running
+    available: bool = True
+    module: str = "user"
+    files: list[str] = field(default_factory=list)
+    # {"node": "parent/2", "dynamic": bool, "clauses": int} rows of mcp_kb_graph/4
+    predicates: list[dict[str, Any]] = field(default_factory=list)
+    listing: str = ""
+
+    def names(self) -> set[str]:
+        return {row["node"].rsplit("/", 1)[0] for row in self.predicates}
+
+    def describe(self) -> str:
+        if not self.available:
+            return "(The Prolog session is not running, so the current knowledge base is unknown.)"
+        lines = []
+        if self.files:
+            shown = self.files[:MAX_CONTEXT_FILES]
+            more = f" and {len(self.files) - len(shown)} more" if len(self.files) > len(shown) else ""
+            lines.append(f"Files in the data directory: {', '.join(shown)}{more}")
+        else:
+            lines.append("Files in the data directory: none")
+        if self.predicates:
+            rows = sorted(self.predicates, key=lambda row: row["node"])[:MAX_CONTEXT_PREDICATES]
+            lines.append(f"Predicates defined in module {self.module}:")
+            lines.extend(
+                f"- {row['node']}: {row['clauses']} clause(s){' (dynamic)' if row.get('dynamic') else ''}"
+                for row in rows
+            )
+            if len(self.predicates) > len(rows):
+                lines.append(f"- ... {len(self.predicates) - len(rows)} more")
+        else:
+            lines.append(f"Predicates defined in module {self.module}: none yet")
+        return "\n".join(lines)
+
+
+def goal_names(goal: str) -> list[str]:
+    """Names of the predicates a goal text calls, in order of appearance."""
+    names = []
+    for name in GOAL_FUNCTOR_RE.findall(goal):
+        if name not in names:
+            names.append(name)
+    return names
+
+
+def undefined_names(goal: str, context: KbContext) -> list[str]:
+    if not context.available:
+        return []
+    defined = context.names()
+    return [name for name in goal_names(goal) if name not in defined and name not in KNOWN_GOALS]
+
+
+def listing_goal(names: list[str], module: str) -> str:
+    """Goal binding Listing to the source of the named predicates."""
+    items = ", ".join(names)
+    return f"with_output_to(string(Listing), forall(member(N, [{items}]), ignore(listing({prolog_atom(module)}:N))))"
+
+
+def constraint_model_prompt(problem: str, context: KbContext) -> str:
+    return f"""You are helping model a problem as a constraint satisfaction problem in SWI-Prolog's clpfd.
+
+**Problem**: {problem}
+
+**Current knowledge base**:
+{context.describe()}
+
+Please:
+1. **Identify the decision variables** and their finite domains, and explain what each one stands for
+2. **State every constraint** in plain words first, then as clpfd constraints (#=, #\\=, #<, all_distinct/1, sum/3, tuples_in/2, ...)
+3. **Write the model** as one predicate, e.g. `solve(Vars) :- Vars = [...], Vars ins 1..9, ..., label(Vars).`
+4. **Say what to optimise**, if anything, with labeling options such as min(Expr) or max(Expr)
+5. **Check the model** against a small instance you can solve by hand
+
+Reuse the predicates already in the knowledge base as data where they fit, rather than restating facts.
+
+The user can run the model directly:
+- solve_constraints({{"variables": {{"X": [1, 10], "Y": [1, 20]}}, "constraints": ["Y #= X * 2", "X + Y #= 12"]}}) for a quick check without writing clpfd code
+- create_prolog_file("model", "...") and load_knowledge_base("model") for the full model
+- execute_prolog_query("solve(Vars)") to get solutions
+"""
+
+
+def facts_conversion_prompt(text: str, predicate: str, context: KbContext) -> str:
+    naming = f"Use the predicate name `{predicate}`." if predicate else (
+        "Choose short, lower_case predicate names, one predicate per kind of relation."
+    )
+    return f"""You are converting information into Prolog facts.
+
+**Information to convert**:
+{text}
+
+**Current knowledge base**:
+{context.describe()}
+
+Please:
+1. **Name the relations**: {naming} Prefer the names and argument orders of predicates that already exist above, so new facts join the existing knowledge base
+2. **Fix an argument order** for each predicate and document it with a comment such as `% parent(Parent, Child)`
+3. **Write the facts** with atoms in lower_case (quote anything else, e.g. 'New York'), numbers as numbers and strings only for free text
+4. **Keep one fact per line**, grouped by predicate, so the clauses of each predicate are contiguous
+5. **Add example queries** that check the facts read back as intended
+
+For tabular data (CSV, TSV, JSON), import_data(predicate, data, columns=["name:atom", "age:integer"]) asserts the rows directly; use create_prolog_file() to save hand-written facts instead.
+"""
+
+
+def debug_goal_prompt(goal: str, context: KbContext, undefined: list[str]) -> str:
+    listing = context.listing.strip()
+    if len(listing) > MAX_LISTING_CHARS:
+        listing = listing[:MAX_LISTING_CHARS] + "\n% ... (truncated)"
+    definitions = f"```prolog\n{listing}\n```" if listing else "(No clauses found for the predicates this goal calls.)"
+    missing = (
+        f"\n**Not defined anywhere**: {', '.join(undefined)}. A typo or a file that was never loaded is the likely cause.\n"
+        if undefined else ""
+    )
+    return f"""You are helping find out why a Prolog goal fails (or gives unexpected answers).
+
+**Goal**: `{goal}`
+
+**Current knowledge base**:
+{context.describe()}
+{missing}
+**Definitions the goal uses**:
+{definitions}
+
+Please work through the failure step by step:
+1. **Check the names and arities** the goal calls against the definitions above
+2. **Trace the resolution by hand** with the goal's arguments: which clause heads unify, which subgoal fails first
+3. **Look for the usual causes**: misspelt atoms, wrong argument order, a missing base case, a cut pruning solutions, arithmetic on unbound variables, `=` where `is` is needed
+4. **Propose a fix** as corrected clauses, and explain why it works
+5. **Give a query that confirms the fix**
+
+The user can check each step:
+- trace_query("{goal}") shows every call, exit, redo and fail port
+- execute_prolog_query("...") runs smaller parts of the goal
+- lint_program("file.pl") reports singleton variables, undefined predicates and discontiguous clauses
+"""
@@ -0,0 +1,149 @@
+"""
+Proof Trees of Query Answers for Docker SWISH MCP
+
+execute_prolog_query(query, explain=True) proves the goal with a
+meta-interpreter (mcp_explain/6 in mcp_helpers.pl) instead of calling it,
+and returns each solution with the proof that derived it: for every goal,
+the fact or rule clause it was resolved with (its text and file:line),
+and below a rule, the proofs of the goals of its body:
+
+    grandparent(tom, ann)    rule family.pl:7
+    ├─ parent(tom, bob)      fact family.pl:1
+    └─ parent(bob, ann)      fact family.pl:3
+
+This is what an assistant grounds an explanation of a derived answer in.
+Built-in and library predicates are leaves (they are run, not proved),
+as are tabled predicates, which answer from their tables, and negations
+(\\+ G holds because G has no proof). Goals nested deeper than
+MAX_DEPTH are run without recording their proofs, and a proof stops
+after MAX_NODES nodes, so a deep recursion still answers in bounded
+space. The meta-interpreter is slower than calling the goal, and at most
+MAX_SOLUTIONS solutions are explained.
+"""
+
+from dataclasses import dataclass, field
+from typing import Any
+
+from .config import QueryLimits
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+MAX_SOLUTIONS = 10
+MAX_DEPTH = 30
+MAX_NODES = 200
+
+KIND_LABELS = {
+    "fact": "fact",
+    "rule": "rule",
+    "builtin": "built-in",
+    "tabled": "from its table",
+    "negation": "no proof of the negated goal",
+    "depth": f"not expanded: nested deeper than {MAX_DEPTH}",
+}
+
+
+def explain_call(goal: str, guard: str, module: str, limits: QueryLimits) -> tuple[str, list[str]]:
+    """mcp_explain/6 call proving goal in module, after running guard (the sandbox's vetting)."""
+    return "mcp_explain", [
+        prolog_string(guard), prolog_string(goal), prolog_atom(module), limits.to_prolog(),
+        f"explain({MAX_SOLUTIONS}, {MAX_DEPTH}, {MAX_NODES})",
+    ]
+
+
+@dataclass
+class ProofNode:
+    """A goal of a proof and how it was proved."""
+    goal: str
+    # fact, rule, builtin, tabled, negation or depth
+    kind: str
+    clause: str = ""
+    source: str = ""
+    children: list["ProofNode"] = field(default_factory=list)
+    truncated: bool = False
+
+    @classmethod
+    def from_json(cls, data: dict[str, Any]) -> "ProofNode":
+        return cls(
+            data["goal"], data["kind"], data.get("clause", ""), data.get("source", ""),
+            [cls.from_json(child) for child in data.get("children", [])], bool(data.get("truncated", False)),
+        )
+
+    @property
+    def incomplete(self) -> bool:
+        return self.truncated or any(child.incomplete for child in self.children)
+
+    def label(self) -> str:
+        label = KIND_LABELS.get(self.kind, self.kind)
+        if self.source:
+            label = f"{label} {self.source}"
+        if self.kind == "rule" and self.clause:
+            label = f"{label}: {' '.join(self.clause.split())}"
+        return label
+
+    def render(self, lines: list[str], prefix: str = "", last: bool = True, top: bool = True) -> None:
+        branch = "" if top else ("└─ " if last else "├─ ")
+        lines.append(f"{prefix}{branch}{self.goal}    [{self.label()}]")
+        inner = prefix if top else prefix + ("   " if last else "│  ")
+        for index, child in enumerate(self.children):
+            child.render(lines, inner, index == len(self.children) - 1 and not self.truncated, top=False)
+        if self.truncated:
+            lines.append(f"{inner}└─ … (proof cut off after {MAX_NODES} nodes)")
+
+    def to_json(self) -> dict[str, Any]:
+        data: dict[str, Any] = {
+            "goal": self.goal, "kind": self.kind, "clause": self.clause, "source": self.source,
+            "children": [child.to_json() for child in self.children],
+        }
+        if self.truncated:
+            data["truncated"] = True
+        return data
+
+
+@dataclass
+class Explanation:
+    """A solution and its proof, one tree per goal of the query."""
+    bindings: str
+    values: dict[str, Any]
+    proof: list[ProofNode]
+    truncated: bool = False
+
+    @property
+    def incomplete(self) -> bool:
+        return self.truncated or any(node.incomplete for node in self.proof)
+
+    def proof_json(self) -> dict[str, Any]:
+        return {"proof": [node.to_json() for node in self.proof], "complete": not self.incomplete}
+
+
+def parse_explanations(rows: list[dict[str, Any]]) -> tuple[list[Explanation], bool, str]:
+    """Explanations from mcp_explain/6's rows, whether more solutions exist, and the goal's output."""
+    explanations = []
+    more, output = False, ""
+    for row in rows:
+        if "proof" in row:
+            explanations.append(Explanation(
+                row["bindings"], row.get("json", {}), [ProofNode.from_json(node) for node in row["proof"]],
+                bool(row.get("truncated", False)),
+            ))
+        else:
+            more, output = bool(row.get("more", False)), row.get("output", "")
+    return explanations, more, output
+
+
+def format_explanations(query: str, explanations: list[Explanation], more: bool, output: str) -> str:
+    printed = f"\n🖨️ Output:\n{output.rstrip()}" if output.strip() else ""
+    if not explanations:
+        return f"❌ Query: {query}\n📋 Result: false (no solutions found, so there is no proof){printed}"
+    lines = [f"✅ Query: {query}", "📋 Results with their proofs:"]
+    for explanation in explanations:
+        lines.append(f"  • {explanation.bindings}")
+        tree: list[str] = []
+        for node in explanation.proof:
+            node.render(tree)
+        if explanation.truncated:
+            tree.append(f"… (proof cut off after {MAX_NODES} nodes)")
+        if not tree:
+            tree.append("true    [no goals to prove]")
+        lines.extend(f"      {line}" for line in tree)
+    more_note = f"; there are more, only the first {MAX_SOLUTIONS} are explained" if more else ""
+    return "\n".join(lines) + f"{printed}\n\n💡 {len(explanations)} solution(s) explained{more_note}"
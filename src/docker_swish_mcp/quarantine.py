@@ -0,0 +1,140 @@
+"""
+Transactional Consults for Docker SWISH MCP
+
+A file that fails to consult halfway leaves the session with some of
+its predicates redefined and the rest gone. load_knowledge_base (and,
+with SWISH_MCP_AUTO_RELOAD=on, every change of a loaded file on disk)
+consults through mcp_safe_consult/5 instead:
+
+1. the file's text is loaded into a scratch module, as if it were a
+   file next to the real one, so relative includes still resolve and
+   unqualified calls reach the predicates already loaded into user; a
+   module file's header is renamed to the scratch module first
+2. every warning and error printed meanwhile becomes a diagnostic
+3. only without errors is the checked load swapped in; otherwise the
+   file is quarantined with its diagnostics and the version loaded
+   before stays live
+
+The file's directives run once, in the scratch load. Swapping it in
+compiles the real file with their goals blanked out, so its clauses
+keep their file and lines and only declarations (dynamic/1, op/3,
+use_module/1, ...) run again, then copies over the clauses the goals
+asserted into the scratch module. The scratch copy is unloaded either
+way. Loads the scratch module cannot check (another non-module file
+already loaded into a different module) are reported as warnings and
+left to the real consult.
+"""
+
+import re
+import time
+import uuid
+from dataclasses import asdict, dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+SCRATCH_PREFIX = "mcp_quarantine_"
+# The module header of a module file, after leading comments
+MODULE_HEADER_RE = re.compile(
+    r"\A((?:\s+|%[^\n]*(?:\n|\Z)|/\*.*?\*/)*:-\s*module\(\s*)([a-z]\w*|'(?:[^'\\]|\\.)*')",
+    re.S,
+)
+
+
+def scratch_module() -> str:
+    return f"{SCRATCH_PREFIX}{uuid.uuid4().hex[:8]}"
+
+
+def scratch_text(text: str, scratch: str) -> str:
+    """text with its module header (if any) declaring scratch instead."""
+    return MODULE_HEADER_RE.sub(lambda match: match.group(1) + scratch, text, count=1)
+
+
+def safe_consult_call(module: str, path: str, text: str) -> tuple[str, list[str]]:
+    """Consult path (as the session sees it, with content text) into module if it loads cleanly."""
+    scratch = scratch_module()
+    return "mcp_safe_consult", [
+        prolog_atom(module), prolog_atom(path), prolog_atom(scratch), prolog_string(scratch_text(text, scratch))
+    ]
+
+
+def vet_call(path: str) -> tuple[str, list[str]]:
+    """Vet the directives of path for the strict sandbox policy before it is consulted."""
+    return "mcp_consult_vet", [prolog_atom(path)]
+
+
+@dataclass
+class ConsultMessage:
+    # "error" or "warning"
+    severity: str
+    message: str
+    line: int = 0
+    # "check" for the scratch load, "consult" for the real one
+    phase: str = "check"
+
+    def describe(self) -> str:
+        where = f"line {self.line}: " if self.line else ""
+        return f"{'❌' if self.severity == 'error' else '⚠️'} {where}{self.message}"
+
+
+def parse_consult(rows: list[dict[str, Any]]) -> tuple[bool, list[ConsultMessage]]:
+    """Whether mcp_safe_consult/5 consulted the file, and what it printed."""
+    diagnostics = [
+        ConsultMessage(row["severity"], row["message"], row.get("line", 0), row.get("phase", "check"))
+        for row in rows if "severity" in row
+    ]
+    loaded = next((bool(row["loaded"]) for row in rows if "loaded" in row), False)
+    return loaded, diagnostics
+
+
+@dataclass
+class QuarantinedFile:
+    # Relative to the data directory
+    path: str
+    # Module the file is consulted into once it loads
+    module: str
+    diagnostics: list[ConsultMessage]
+    since: float = field(default_factory=time.time)
+    attempts: int = 1
+
+
+class Quarantine:
+    """The files of one session whose last consult was refused, by relative path."""
+
+    def __init__(self) -> None:
+        self.files: dict[str, QuarantinedFile] = {}
+
+    def reject(self, path: str, module: str, diagnostics: list[ConsultMessage]) -> QuarantinedFile:
+        entry = self.files.get(path)
+        if entry is None:
+            entry = self.files[path] = QuarantinedFile(path, module, diagnostics)
+        else:
+            entry.module, entry.diagnostics = module, diagnostics
+            entry.attempts += 1
+        return entry
+
+    def release(self, path: str) -> bool:
+        return self.files.pop(path, None) is not None
+
+    def status(self) -> list[dict[str, Any]]:
+        return [asdict(entry) for entry in sorted(self.files.values(), key=lambda entry: entry.path)]
+
+
+def format_messages(diagnostics: list[ConsultMessage], limit: int = 20) -> list[str]:
+    lines = [f"  {diagnostic.describe()}" for diagnostic in diagnostics[:limit]]
+    if len(diagnostics) > limit:
+        lines.append(f"  … and {len(diagnostics) - limit} more")
+    return lines
+
+
+def format_quarantine(quarantine: Quarantine) -> str:
+    if not quarantine.files:
+        return "✅ No quarantined files; every consult loaded cleanly"
+    lines = [f"🚧 Quarantined files: {len(quarantine.files)} (the version loaded before stays live)"]
+    for entry in sorted(quarantine.files.values(), key=lambda entry: entry.path):
+        since = time.strftime("%H:%M:%S", time.localtime(entry.since))
+        lines.append(f"• {entry.path} (into {entry.module}, since {since}, {entry.attempts} attempt(s)):")
+        lines.extend(format_messages([d for d in entry.diagnostics if d.severity == "error"], 5))
+    lines.append("\n💡 Fix the file and run load_knowledge_base again")
+    return "\n".join(lines)
@@ -0,0 +1,152 @@
+"""
+Query Result Cache for Docker SWISH MCP
+
+Clients retrying a tool call, or asking the same question twice, would
+otherwise run the same goal in the persistent session again. With
+SWISH_MCP_CACHE_SIZE set, execute_prolog_query keeps the results of goals
+that only read the knowledge base, keyed by goal, module, output format
+and limits.
+
+An entry depends on the dynamic predicates its goal can reach, found by
+mcp_cache_deps/2 (see mcp_helpers.pl), which also hooks each of them with
+prolog_listen/2. When one is asserted to or retracted from, by any query,
+the session reports it and every entry depending on it is dropped.
+Consulting files clears the whole cache, and entries from an earlier
+session process are never served.
+
+Goals with side effects, goals whose answers change without the database
+changing (random numbers, time, global variables) and goals that print
+output are not cached.
+"""
+
+import re
+from collections import OrderedDict
+from dataclasses import dataclass
+
+from .config import QueryLimits
+from .sandbox import SandboxPolicy, find_violations
+from .simple_session import prolog_string
+
+# Answers that can change while the database stays the same
+IMPURE_RE = re.compile(
+    r"\b(random\w*|get_time|statistics|nb_getval|b_getval|nb_current|flag|"
+    r"gensym|get_char|read|read_term|read_line_to_\w+)\s*\("
+)
+# Goals that (re)load code, after which dependencies are out of date
+LOADS_CODE_RE = re.compile(
+    r"\b(consult|ensure_loaded|load_files|use_module|reexport|make|unload_file)\b"
+    r"|^\s*\[\s*[a-z'][^\]|]*\]\s*$"
+)
+
+CacheKey = tuple[str, str, str, str, str, int]
+
+# Static screen for side effects, as the readonly sandbox applies it
+_READONLY = SandboxPolicy(mode="readonly")
+
+
+def cacheable(goal: str) -> bool:
+    """Whether a goal's results may be cached at all."""
+    return not find_violations(goal, _READONLY) and not IMPURE_RE.search(goal) and not loads_code(goal)
+
+
+def loads_code(goal: str) -> bool:
+    return bool(LOADS_CODE_RE.search(goal))
+
+
+def deps_call(goal: str) -> tuple[str, list[str]]:
+    return "mcp_cache_deps", [prolog_string(goal)]
+
+
+@dataclass
+class CacheEntry:
+    result: str
+    # "Module:Name/Arity" of every dynamic predicate the goal can reach
+    deps: frozenset[str]
+    generation: int
+    hits: int = 0
+
+
+class QueryCache:
+    """
+    LRU cache of formatted query results, invalidated per predicate.
+
+    Args:
+        max_entries: Entries kept; 0 disables the cache
+    """
+
+    def __init__(self, max_entries: int = 0):
+        self.max_entries = max_entries
+        self.entries: OrderedDict[CacheKey, CacheEntry] = OrderedDict()
+        self.hits = 0
+        self.misses = 0
+        self.invalidations = 0
+        # Bumped on every change reported by the session; see put()
+        self.sequence = 0
+        self.changed_at: dict[tuple[str, str], int] = {}
+
+    @property
+    def enabled(self) -> bool:
+        return self.max_entries > 0
+
+    @staticmethod
+    def key(
+        container: str, goal: str, module: str, output_format: str, limits: QueryLimits, answer_bytes: int = 0
+    ) -> CacheKey:
+        # The answer size limit decides how much of a result fits
+        return (container, goal, module, output_format, limits.to_prolog(), answer_bytes)
+
+    def get(self, key: CacheKey, generation: int) -> str | None:
+        entry = self.entries.get(key)
+        if entry is None or entry.generation != generation:
+            if entry is not None:
+                del self.entries[key]
+            self.misses += 1
+            return None
+        self.entries.move_to_end(key)
+        entry.hits += 1
+        self.hits += 1
+        return entry.result
+
+    def put(self, key: CacheKey, result: str, deps: frozenset[str], generation: int, since: int) -> None:
+        """
+        Cache a result computed after sequence was since.
+
+        Skipped if a dependency changed meanwhile, as the result may
+        predate the change.
+        """
+        if not self.enabled:
+            return
+        if any(self.changed_at.get((key[0], dep), 0) > since for dep in deps):
+            return
+        self.entries[key] = CacheEntry(result, deps, generation)
+        self.entries.move_to_end(key)
+        while len(self.entries) > self.max_entries:
+            self.entries.popitem(last=False)
+
+    def invalidate(self, container: str, dep: str) -> int:
+        """Drop the entries of a container that depend on dep; returns how many."""
+        self.sequence += 1
+        self.changed_at[(container, dep)] = self.sequence
+        stale = [key for key, entry in self.entries.items() if key[0] == container and dep in entry.deps]
+        for key in stale:
+            del self.entries[key]
+        self.invalidations += len(stale)
+        return len(stale)
+
+    def clear(self, container: str | None = None) -> None:
+        if container is None:
+            self.invalidations += len(self.entries)
+            self.entries.clear()
+            return
+        for key in [key for key in self.entries if key[0] == container]:
+            del self.entries[key]
+            self.invalidations += 1
+
+    def stats(self) -> dict[str, int]:
+        return {
+            "entries": len(self.entries),
+            "max_entries": self.max_entries,
+            "hits": self.hits,
+            "misses": self.misses,
+            "invalidations": self.invalidations,
+        }
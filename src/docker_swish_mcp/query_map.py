@@ -0,0 +1,128 @@
+"""
+Mapping a Goal over Many Bindings for Docker SWISH MCP
+
+query_map("eligible(Person, Plan)", [{"Person": "alice"}, {"Person":
+"bob"}, ...]) evaluates one goal template once per binding, as agents
+checking a rule against hundreds of entities otherwise do in a loop of
+calls. Each binding's values are written as Prolog literals (text is an
+atom, see infer_literal) and unified with the goal's variables before
+it runs, so a value is always one term and never part of the goal:
+
+    Person = alice, (eligible(Person, Plan))
+
+The items run on isolated pengines through the worker pool, a few at a
+time (no more than the client's share of the pool, so a long list never
+overflows its queue), and the results come back in input order. Every
+item stands alone: a binding that does not convert, a goal that raises
+or times out, or a worker that is unavailable fails that item only.
+"""
+
+import json
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .templates import infer_literal
+
+MAX_ITEMS = 1000
+VARIABLE_RE = re.compile(r"^[A-Z_][A-Za-z0-9_]*$")
+# A variable of the goal: a word starting with a capital or _, outside quotes
+TOKEN_RE = re.compile(r"'(?:[^'\\]|\\.)*'|\"(?:[^\"\\]|\\.)*\"|`(?:[^`\\]|\\.)*`|%[^\n]*|0'.|\b[A-Z_][A-Za-z0-9_]*")
+
+
+def goal_variables(goal: str) -> set[str]:
+    return {token for token in TOKEN_RE.findall(goal) if VARIABLE_RE.match(token)}
+
+
+@dataclass
+class MapItem:
+    """One binding's run of the goal."""
+    index: int
+    bindings: dict[str, Any]
+    goal: str = ""
+    # "success", "failure" or "error"
+    status: str = "error"
+    solutions: list[dict[str, Any]] = field(default_factory=list)
+    more: bool = False
+    error: str | None = None
+
+    def record(self, answer: dict[str, Any]) -> None:
+        """Take the outcome from a pengine-style answer."""
+        event = answer.get("event")
+        if event == "success":
+            self.status = "success"
+            self.solutions = list(answer.get("data", []))
+            self.more = bool(answer.get("more"))
+        elif event == "failure":
+            self.status = "failure"
+        else:
+            self.status = "error"
+            self.error = str(answer.get("data") or event)
+
+    def describe(self) -> str:
+        label = ", ".join(
+            f"{name} = {value if isinstance(value, str) else json.dumps(value)}"
+            for name, value in self.bindings.items()
+        ) or "(no bindings)"
+        if self.status == "success":
+            rows = [
+                ", ".join(f"{name} = {value}" for name, value in solution.items())
+                if solution else "true"
+                for solution in self.solutions
+            ]
+            more = " …" if self.more else ""
+            return f"[{self.index}] ✅ {label}: {'; '.join(rows)}{more}"
+        if self.status == "failure":
+            return f"[{self.index}] ❌ {label}: false"
+        return f"[{self.index}] 💥 {label}: {self.error}"
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "index": self.index, "bindings": self.bindings, "status": self.status,
+            "solutions": self.solutions, "more": self.more, "error": self.error,
+        }
+
+
+def build_items(goal: str, bindings: list[dict[str, Any]]) -> list[MapItem]:
+    """
+    One item per binding with its goal, or with its error when the binding does not convert.
+
+    Raises ValueError for an unusable list: too long, or naming
+    something that is not a variable of the goal.
+    """
+    if len(bindings) > MAX_ITEMS:
+        raise ValueError(f"query_map takes at most {MAX_ITEMS} bindings per call, got {len(bindings)}")
+    variables = goal_variables(goal)
+    items = []
+    for index, binding in enumerate(bindings, 1):
+        if not isinstance(binding, dict):
+            raise ValueError(f"Binding {index} must be an object mapping variables to values, got {binding!r}")
+        unknown = sorted(name for name in binding if name not in variables)
+        if unknown:
+            raise ValueError(f"Binding {index} names {', '.join(unknown)}, which are not variables of the goal")
+        item = MapItem(index, binding)
+        try:
+            unifications = [f"{name} = {infer_literal(value, name)}" for name, value in binding.items()]
+        except ValueError as e:
+            item.error = str(e)
+        else:
+            item.goal = ", ".join([*unifications, f"({goal})"])
+        items.append(item)
+    return items
+
+
+def summarize(items: list[MapItem]) -> dict[str, int]:
+    counts = {"success": 0, "failure": 0, "error": 0}
+    for item in items:
+        counts[item.status] += 1
+    return counts
+
+
+def format_items(items: list[MapItem], workers: int) -> str:
+    counts = summarize(items)
+    lines = [item.describe() for item in items]
+    lines.append(
+        f"\n🗺️ {len(items)} item(s): {counts['success']} succeeded, {counts['failure']} failed, "
+        f"{counts['error']} error(s); up to {workers} at a time"
+    )
+    return "\n".join(lines)
@@ -0,0 +1,225 @@
+"""
+Rate Limits and Quotas for Docker SWISH MCP
+
+Every client (its API key id, or without keys the address it connects
+from; see quota_client_id) can be limited in how often it calls tools
+and how much it uses the shared Prolog session:
+
+- SWISH_MCP_RATE_LIMIT:   tool calls per minute, as a token bucket that
+                          holds SWISH_MCP_RATE_BURST calls (default: the
+                          rate), so short bursts pass
+- SWISH_MCP_CPU_QUOTA:    CPU seconds of Prolog per quota window
+- SWISH_MCP_CLAUSE_QUOTA: clauses the client's queries add to the session
+                          per window, asserted or consulted
+- SWISH_MCP_QUOTA_WINDOW: length of the quota window in seconds (default
+                          3600); usage starts from zero in each window
+
+0 turns a limit off, and all are off by default. A refused call fails
+with a JSON error payload saying which limit was hit and when to retry:
+
+    {"error": "rate_limited", "tool": "execute_prolog_query",
+     "limit": 60, "retry_after": 0.8}
+    {"error": "quota_exceeded", "quota": "cpu_seconds", "limit": 120,
+     "used": 121.3, "retry_after": 1740}
+
+CPU time is what the session's thread used between the end of one query
+and the end of the next, and clauses are what the program grew by
+meanwhile, however the goal that added them was built. A query already
+running is not stopped when it crosses the CPU quota, but
+execute_prolog_query lowers its CPU limit to what is left of the quota.
+"""
+
+import json
+import logging
+import time
+from collections.abc import Callable
+from dataclasses import dataclass, field
+from typing import Any
+
+from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp.exceptions import ToolError
+
+logger = logging.getLogger("docker-swish-mcp.quotas")
+
+# Tools that still answer once a quota is used up, so clients can see why
+QUOTA_EXEMPT_TOOLS = frozenset({"quota_status", "get_swish_status", "swish_status"})
+# Seconds between sweeps for clients that have been idle long enough to forget
+SWEEP_INTERVAL = 60.0
+
+
+@dataclass(frozen=True)
+class QuotaSettings:
+    """Per-client limits; 0 turns a limit off."""
+    calls_per_minute: float = 0.0
+    # Calls the token bucket holds; 0 means calls_per_minute
+    burst: int = 0
+    cpu_seconds: float = 0.0
+    clauses: int = 0
+    window: float = 3600.0
+
+    @property
+    def capacity(self) -> float:
+        return float(self.burst) if self.burst > 0 else max(self.calls_per_minute, 1.0)
+
+
+class TokenBucket:
+    """Refills at rate tokens per second up to capacity; each call takes one."""
+
+    def __init__(self, rate: float, capacity: float, now: float):
+        self.rate = rate
+        self.capacity = capacity
+        self.tokens = capacity
+        self.updated = now
+
+    def take(self, now: float) -> float:
+        """Take a token; returns 0, or the seconds until one is available."""
+        self.tokens = min(self.capacity, self.tokens + (now - self.updated) * self.rate)
+        self.updated = now
+        if self.tokens >= 1:
+            self.tokens -= 1
+            return 0.0
+        return (1 - self.tokens) / self.rate
+
+
+@dataclass
+class ClientUsage:
+    window_start: float
+    last_seen: float = 0.0
+    cpu_seconds: float = 0.0
+    clauses: int = 0
+    calls: int = 0
+    refused: int = 0
+    bucket: TokenBucket | None = field(default=None, repr=False)
+
+
+class QuotaExceeded(ToolError):
+    """A refused tool call; the message is the JSON payload."""
+
+    def __init__(self, payload: dict[str, Any]):
+        super().__init__(json.dumps(payload))
+        self.payload = payload
+
+
+class QuotaTracker:
+    """
+    Usage of every client in the current quota window.
+
+    Args:
+        settings: The limits to enforce
+        clock: Monotonic time source, in seconds
+    """
+
+    def __init__(self, settings: QuotaSettings, clock: Callable[[], float] = time.monotonic):
+        self.settings = settings
+        self.clock = clock
+        self.clients: dict[str, ClientUsage] = {}
+        self.swept = clock()
+
+    def usage(self, client: str) -> ClientUsage:
+        now = self.clock()
+        if now - self.swept >= SWEEP_INTERVAL:
+            self.evict_idle(now)
+        usage = self.clients.get(client)
+        if usage is None:
+            usage = self.clients[client] = ClientUsage(now)
+        elif now - usage.window_start >= self.settings.window:
+            usage = self.clients[client] = ClientUsage(now, bucket=usage.bucket)
+        usage.last_seen = now
+        return usage
+
+    def evict_idle(self, now: float) -> None:
+        """Forget the clients idle long enough that their window is over and their bucket full again."""
+        self.swept = now
+        settings = self.settings
+        idle = settings.window
+        if settings.calls_per_minute > 0:
+            idle = max(idle, settings.capacity / (settings.calls_per_minute / 60))
+        for client, usage in list(self.clients.items()):
+            if now - usage.last_seen >= idle:
+                del self.clients[client]
+
+    def window_left(self, usage: ClientUsage) -> float:
+        return max(0.0, usage.window_start + self.settings.window - self.clock())
+
+    def check(self, client: str, tool: str) -> None:
+        """Count a tool call; raises QuotaExceeded if a limit refuses it."""
+        settings = self.settings
+        usage = self.usage(client)
+        if settings.calls_per_minute > 0:
+            if usage.bucket is None:
+                usage.bucket = TokenBucket(settings.calls_per_minute / 60, settings.capacity, self.clock())
+            wait = usage.bucket.take(self.clock())
+            if wait > 0:
+                usage.refused += 1
+                raise QuotaExceeded({
+                    "error": "rate_limited",
+                    "tool": tool,
+                    "limit": settings.calls_per_minute,
+                    "retry_after": round(wait, 2),
+                })
+        if tool not in QUOTA_EXEMPT_TOOLS:
+            for quota, limit, used in (
+                ("cpu_seconds", settings.cpu_seconds, usage.cpu_seconds),
+                ("clauses", settings.clauses, usage.clauses),
+            ):
+                if limit > 0 and used >= limit:
+                    usage.refused += 1
+                    raise QuotaExceeded({
+                        "error": "quota_exceeded",
+                        "tool": tool,
+                        "quota": quota,
+                        "limit": limit,
+                        "used": round(used, 3),
+                        "retry_after": round(self.window_left(usage), 1),
+                    })
+        usage.calls += 1
+
+    def charge_cpu(self, client: str, seconds: float) -> None:
+        if self.settings.cpu_seconds > 0 and seconds > 0:
+            self.usage(client).cpu_seconds += seconds
+
+    def charge_clauses(self, client: str, count: int) -> None:
+        if self.settings.clauses > 0 and count > 0:
+            self.usage(client).clauses += count
+
+    def cpu_left(self, client: str) -> float | None:
+        """CPU seconds left in the client's quota, None without a CPU quota."""
+        if self.settings.cpu_seconds <= 0:
+            return None
+        return max(0.0, self.settings.cpu_seconds - self.usage(client).cpu_seconds)
+
+    def describe(self, client: str) -> dict[str, Any]:
+        settings = self.settings
+        usage = self.usage(client)
+        bucket = usage.bucket
+        return {
+            "client": client,
+            "window_seconds": settings.window,
+            "window_resets_in": round(self.window_left(usage), 1),
+            "calls": usage.calls,
+            "refused": usage.refused,
+            "rate_limit": {
+                "calls_per_minute": settings.calls_per_minute,
+                "burst": settings.capacity if settings.calls_per_minute > 0 else 0,
+                "tokens": round(bucket.tokens, 2) if bucket else None,
+            },
+            "cpu_seconds": {"limit": settings.cpu_seconds, "used": round(usage.cpu_seconds, 3)},
+            "clauses": {"limit": settings.clauses, "used": usage.clauses},
+        }
+
+
+def enforce_quotas(server: FastMCP, tracker: Callable[[], QuotaTracker], current_client: Callable[[], str]) -> None:
+    """Refuse tool calls over the caller's rate limit or quotas."""
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        client = current_client()
+        try:
+            tracker().check(client, name)
+        except QuotaExceeded as e:
+            logger.warning(f"Refused {name} for {client}: {e.payload['error']}")
+            raise
+        return await base_call_tool(name, arguments, *args, **kwargs)
+
+    tool_manager.call_tool = call_tool
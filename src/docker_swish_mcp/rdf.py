@@ -0,0 +1,146 @@
+"""
+RDF Bridge for Docker SWISH MCP
+
+Loads RDF files into SWI-Prolog's semweb triple store (library(semweb/rdf_db))
+inside the persistent session and queries it. Results use the term layout
+of SPARQL's JSON results format:
+
+    {"type": "uri", "value": "http://example.org/alice"}
+    {"type": "literal", "value": "Alice", "xml:lang": "en"}
+    {"type": "literal", "value": "42", "datatype": "http://www.w3.org/2001/XMLSchema#integer"}
+    {"type": "bnode", "value": "_:genid1"}
+
+Triple patterns are written the way Turtle writes terms: <http://...> or
+a bare http(s)/urn IRI, prefix:local for registered prefixes (rdf, rdfs,
+owl, xsd, foaf, dc, ... or ones declared with rdf_register_prefix/2),
+"text", "text"@en or "text"^^xsd:type for literals, and "" for "any".
+"""
+
+import re
+
+from .simple_session import prolog_string
+
+RDF_EXTENSIONS = {
+    ".ttl": "turtle",
+    ".nt": "ntriples",
+    ".nq": "nquads",
+    ".trig": "trig",
+    ".rdf": "xml",
+    ".owl": "xml",
+    ".xml": "xml",
+}
+RDF_FORMATS = ("auto", "turtle", "ntriples", "nquads", "trig", "xml")
+RDF_DIR = "rdf"
+
+PNAME_RE = re.compile(r"^([A-Za-z][\w-]*)?:([\w.-]*)$")
+IRI_RE = re.compile(r"^(?:https?|urn|file|mailto):\S+$")
+LITERAL_RE = re.compile(r'^"((?:[^"\\]|\\.)*)"(?:@([A-Za-z]+(?:-[A-Za-z0-9]+)*)|\^\^(\S+))?$')
+NAME_RE = re.compile(r"^[A-Za-z][A-Za-z0-9_-]*$")
+
+
+def prolog_atom(text: str) -> str:
+    """Quote text as a Prolog atom."""
+    return "'" + text.replace("\\", "\\\\").replace("'", "\\'") + "'"
+
+
+def resource_pattern(text: str, what: str) -> str:
+    """Pattern term for an IRI or prefixed name, or any for ""."""
+    text = text.strip()
+    if not text:
+        return "any"
+    if text.startswith("<") and text.endswith(">"):
+        return f"iri({prolog_atom(text[1:-1])})"
+    if text.startswith("_:"):
+        return f"iri({prolog_atom(text)})"
+    if IRI_RE.match(text) and not PNAME_RE.match(text):
+        return f"iri({prolog_atom(text)})"
+    match = PNAME_RE.match(text)
+    if match:
+        return f"pname({prolog_atom(match.group(1) or '')}, {prolog_atom(match.group(2))})"
+    raise ValueError(f"Cannot read {what} '{text}': use <iri>, prefix:local or leave it empty")
+
+
+def object_pattern(text: str) -> str:
+    """Pattern term for a triple's object, which may also be a literal."""
+    stripped = text.strip()
+    if re.match(r"^-?\d+(\.\d+)?$", stripped):
+        return f"lit({prolog_string(stripped)}, none, none)"
+    match = LITERAL_RE.match(stripped)
+    if not match:
+        return resource_pattern(stripped, "object")
+    value = re.sub(r"\\(.)", r"\1", match.group(1))
+    lang = prolog_atom(match.group(2).lower()) if match.group(2) else "none"
+    datatype = resource_pattern(match.group(3), "datatype") if match.group(3) else "none"
+    return f"lit({prolog_string(value)}, {lang}, {datatype})"
+
+
+def rdf_format(filename: str, fmt: str = "auto") -> str:
+    """Resolve the parser to use for a file."""
+    if fmt not in RDF_FORMATS:
+        raise ValueError(f"Unknown RDF format '{fmt}'. Use one of: {', '.join(RDF_FORMATS)}")
+    if fmt != "auto":
+        return fmt
+    for extension, name in RDF_EXTENSIONS.items():
+        if filename.lower().endswith(extension):
+            return name
+    raise ValueError(f"Cannot tell the RDF format of '{filename}'; pass format=\"turtle\" (or ntriples, xml, ...)")
+
+
+def default_graph(filename: str) -> str:
+    """Graph name used when none is given: the file name without extension."""
+    stem = filename.rsplit("/", 1)[-1].split(".", 1)[0]
+    return stem or "default"
+
+
+def validate_rdf_name(name: str) -> str:
+    if not NAME_RE.match(name):
+        raise ValueError(f"Invalid RDF file name '{name}': use letters, digits, '_' or '-', starting with a letter")
+    return name
+
+
+def load_call(container_path: str, graph: str, fmt: str) -> tuple[str, list[str]]:
+    return "mcp_rdf_load", [prolog_atom(container_path), prolog_atom(graph), fmt]
+
+
+def graph_pattern(graph: str) -> str:
+    """Graph pattern: graph names are matched as plain atoms; "" means any graph."""
+    graph = graph.strip()
+    if graph.startswith("<") and graph.endswith(">"):
+        graph = graph[1:-1]
+    return f"iri({prolog_atom(graph)})" if graph else "any"
+
+
+def triples_call(subject: str, predicate: str, obj: str, graph: str, limit: int) -> tuple[str, list[str]]:
+    return "mcp_rdf_triples", [
+        resource_pattern(subject, "subject"),
+        resource_pattern(predicate, "predicate"),
+        object_pattern(obj),
+        graph_pattern(graph),
+        str(int(limit)),
+    ]
+
+
+def query_call(goal: str, limits_term: str, limit: int) -> tuple[str, list[str]]:
+    return "mcp_rdf_run", [prolog_string(goal), limits_term, str(int(limit))]
+
+
+def graphs_call() -> tuple[str, list[str]]:
+    return "mcp_rdf_graphs", []
+
+
+def format_term(term: dict) -> str:
+    """Compact Turtle-like rendering of a SPARQL JSON term."""
+    kind = term.get("type")
+    value = term.get("value", "")
+    if kind == "uri":
+        return f"<{value}>"
+    if kind == "bnode":
+        return str(value)
+    if kind == "literal":
+        escaped = str(value).replace('"', '\\"')
+        if "xml:lang" in term:
+            return f'"{escaped}"@{term["xml:lang"]}'
+        if "datatype" in term:
+            return f'"{escaped}"^^<{term["datatype"]}>'
+        return f'"{escaped}"'
+    return str(term.get("text", value))
@@ -0,0 +1,379 @@
+"""
+Session Recording and Replay for Docker SWISH MCP
+
+recording_start() records every tool call the client makes from then on,
+with its arguments and result, until recording_stop() writes the
+recording to swish-recordings/<name>.json next to the data directory:
+
+    {"format": "docker-swish-mcp-recording", "version": 1, "name": "bug-42",
+     "started": "2026-10-14T08:30:00+00:00", "stopped": "2026-10-14T08:41:12+00:00",
+     "environment": {"server": "...", "backend": "container", "image": "swipl/swish:latest", ...},
+     "files": {"family.pl": "parent(tom, bob).\\n"},
+     "steps": [{"tool": "load_knowledge_base", "args": {"filename": "family.pl"},
+                "result": "...", "failed": false, "seconds": 0.41}]}
+
+files are the text files of the data directory as they were when the
+recording started (up to MAX_FILE_BYTES each and MAX_FILES_BYTES in
+all), so a recording is a whole bug report: it can be replayed on
+another machine. Calls made from within a call (the dashboard's, a
+replay's) and the recording tools themselves are not recorded.
+
+replay_session() runs a recording again against a fresh container: a
+temporary workspace with a container of its own (or, with the local
+backend, a session of its own) gets the recorded files, and every step
+is called again with instance set to it. Tools without an instance
+argument act on the server rather than the session and are skipped.
+Each result is compared with the recorded one, ignoring durations,
+timestamps, correlation IDs and the data directory's path. With step
+mode, each call of replay_session runs the next step, so a session can
+be walked through; the workspace goes when the last step has run,
+unless it is kept.
+
+docker-swish-mcp --replay recording.json does the same without a running
+server, in an ephemeral container like --test-harness. As the whole
+server is ephemeral there, every step runs, on its primary session. It
+exits non-zero when a result differs.
+"""
+
+import contextvars
+import json
+import logging
+import re
+import time
+import uuid
+from collections.abc import Callable
+from dataclasses import asdict, dataclass, field
+from datetime import datetime, timezone
+from pathlib import Path, PurePosixPath
+from typing import Any
+
+from .harness import CONTAINER_PREFIX, Scenario, Step
+from .metrics import result_failed
+
+logger = logging.getLogger("docker-swish-mcp.recordings")
+
+RECORDING_FORMAT = "docker-swish-mcp-recording"
+RECORDING_VERSION = 1
+RECORDING_NAME_RE = re.compile(r"^[A-Za-z0-9][\w.-]{0,63}$")
+# The tools recording and replaying sessions
+NOT_RECORDED = ("recording_start", "recording_stop", "replay_session")
+# Data directory files kept in a recording
+MAX_FILE_BYTES = 1_000_000
+MAX_FILES_BYTES = 5_000_000
+# Steps and result characters kept; later steps are counted, not kept
+MAX_STEPS = 2000
+MAX_RESULT_CHARS = 200_000
+# Characters of a differing result shown by replay_session
+RESULT_PREVIEW = 1500
+
+# What differs between two runs of the same call without the result differing
+VOLATILE_PATTERNS = (
+    (re.compile(r"\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?"), "<time>"),
+    (re.compile(r"\b\d+(\.\d+)?\s?(ms|µs|s|sec|seconds?)\b"), "<duration>"),
+    (re.compile(r"\breq-[0-9a-f]{12}\b"), "<correlation-id>"),
+    (re.compile(r'"correlation_id": "[^"]*"'), '"correlation_id": "<correlation-id>"'),
+    # The data directory and container of an ephemeral --replay server (see harness.py)
+    (re.compile(r"[^\s'\"]*" + CONTAINER_PREFIX + r"[0-9a-f]{8}-\w+"), "<data>"),
+    (re.compile(CONTAINER_PREFIX + r"[0-9a-f]{8}"), "<container>"),
+)
+
+# Set while a tool call runs, so the calls it makes are not recorded
+in_tool_call: contextvars.ContextVar[bool] = contextvars.ContextVar("in_tool_call", default=False)
+
+
+class RecordingError(ValueError):
+    """Raised for recordings that cannot be started, read or replayed."""
+
+
+def recordings_dir_for(data_dir: Path) -> Path:
+    """Directory holding the session recordings of a data directory (kept outside the mount)."""
+    return data_dir.parent / "swish-recordings" / data_dir.name
+
+
+def replay_workspace_name() -> str:
+    return f"replay-{uuid.uuid4().hex[:8]}"
+
+
+def validate_recording_name(name: str) -> str:
+    if not RECORDING_NAME_RE.match(name):
+        raise RecordingError(f"Invalid recording name '{name}': use letters, digits, '.', '_' or '-'")
+    return name
+
+
+def now_iso() -> str:
+    return datetime.now(timezone.utc).isoformat(timespec="seconds")
+
+
+@dataclass
+class RecordedStep:
+    tool: str
+    args: dict[str, Any]
+    result: str
+    failed: bool = False
+    seconds: float = 0.0
+    # The result was cut at MAX_RESULT_CHARS
+    truncated: bool = False
+
+
+@dataclass
+class Recording:
+    name: str
+    # server, backend, image, data_dir and client_module of the recorded session
+    environment: dict[str, Any] = field(default_factory=dict)
+    # Data directory path -> content, as it was when recording started
+    files: dict[str, str] = field(default_factory=dict)
+    # Files left out: too large, not text, or past MAX_FILES_BYTES
+    skipped_files: list[str] = field(default_factory=list)
+    steps: list[RecordedStep] = field(default_factory=list)
+    # Steps made after MAX_STEPS were reached
+    dropped_steps: int = 0
+    started: str = field(default_factory=now_iso)
+    stopped: str = ""
+
+    def record(self, step: RecordedStep) -> None:
+        if len(self.steps) >= MAX_STEPS:
+            self.dropped_steps += 1
+            return
+        if len(step.result) > MAX_RESULT_CHARS:
+            step.result, step.truncated = step.result[:MAX_RESULT_CHARS], True
+        self.steps.append(step)
+
+    def to_json(self) -> dict[str, Any]:
+        return {"format": RECORDING_FORMAT, "version": RECORDING_VERSION, **asdict(self)}
+
+    @classmethod
+    def from_json(cls, data: Any) -> "Recording":
+        if not isinstance(data, dict) or data.get("format") != RECORDING_FORMAT:
+            raise RecordingError("Not a session recording")
+        if data.get("version", 0) > RECORDING_VERSION:
+            raise RecordingError(f"Recording version {data['version']} is newer than this server supports")
+        fields = {key: value for key, value in data.items() if key not in ("format", "version", "steps")}
+        try:
+            recording = cls(**fields, steps=[RecordedStep(**step) for step in data.get("steps", [])])
+        except TypeError as e:
+            raise RecordingError(f"Malformed recording: {e}") from e
+        for path in recording.files:
+            parts = PurePosixPath(path).parts
+            if not parts or PurePosixPath(path).is_absolute() or ".." in parts:
+                raise RecordingError(f"File '{path}' of the recording is not a path inside the data directory")
+        return recording
+
+    def summary(self) -> str:
+        dropped = f" (+{self.dropped_steps} not kept)" if self.dropped_steps else ""
+        return f"{len(self.steps)} step(s){dropped}, {len(self.files)} file(s), recorded {self.started}"
+
+    def scenario(self) -> Scenario:
+        """The recording as a test harness scenario; run_replay_cli compares its results itself."""
+        return Scenario(
+            name=self.name,
+            steps=tuple(
+                Step(f"{index}. {step.tool}", step.tool, {k: v for k, v in step.args.items() if k != "instance"})
+                for index, step in enumerate(self.steps, start=1)
+            ),
+            files=self.files,
+        )
+
+
+def capture_files(data_dir: Path) -> tuple[dict[str, str], list[str]]:
+    """The text files of data_dir to keep in a recording, and those left out."""
+    files: dict[str, str] = {}
+    skipped: list[str] = []
+    total = 0
+    if not data_dir.is_dir():
+        return files, skipped
+    for path in sorted(data_dir.rglob("*")):
+        relative = path.relative_to(data_dir)
+        if not path.is_file() or any(part.startswith(".") for part in relative.parts):
+            continue
+        name = relative.as_posix()
+        size = path.stat().st_size
+        if size > MAX_FILE_BYTES or total + size > MAX_FILES_BYTES:
+            skipped.append(name)
+            continue
+        try:
+            files[name] = path.read_text(encoding="utf-8")
+        except (UnicodeDecodeError, OSError):
+            skipped.append(name)
+            continue
+        total += size
+    return files, skipped
+
+
+def write_recording(data_dir: Path, recording: Recording) -> Path:
+    directory = recordings_dir_for(data_dir)
+    directory.mkdir(parents=True, exist_ok=True)
+    path = directory / f"{recording.name}.json"
+    partial = path.with_suffix(".tmp")
+    partial.write_text(json.dumps(recording.to_json(), indent=2, ensure_ascii=False), encoding="utf-8")
+    partial.replace(path)
+    return path
+
+
+def read_recording(path: Path) -> Recording:
+    try:
+        return Recording.from_json(json.loads(path.read_text(encoding="utf-8")))
+    except (OSError, ValueError) as e:
+        if isinstance(e, RecordingError):
+            raise
+        raise RecordingError(f"Could not read recording {path.name}: {e}") from e
+
+
+def list_recordings(data_dir: Path) -> list[Path]:
+    directory = recordings_dir_for(data_dir)
+    if not directory.is_dir():
+        return []
+    return sorted(directory.glob("*.json"), key=lambda path: path.stat().st_mtime, reverse=True)
+
+
+def resolve_recording(data_dir: Path, name: str) -> Path:
+    """A recording by name in swish-recordings/ ("latest" for the newest), or by path."""
+    if name == "latest":
+        recordings = list_recordings(data_dir)
+        if not recordings:
+            raise FileNotFoundError(f"No recordings in {recordings_dir_for(data_dir)}")
+        return recordings[0]
+    candidate = recordings_dir_for(data_dir) / (name if name.endswith(".json") else f"{name}.json")
+    if "/" not in name and candidate.is_file():
+        return candidate
+    path = Path(name).expanduser()
+    if path.is_file():
+        return path
+    raise FileNotFoundError(f"No recording '{name}' in {recordings_dir_for(data_dir)}")
+
+
+def result_text(result: Any) -> str:
+    """The text of a tool result, whichever form the tool manager returned it in."""
+    content = result[0] if isinstance(result, tuple) else result
+    if isinstance(content, (list, tuple)):
+        return "\n".join(str(getattr(block, "text", "")) for block in content)
+    return str(content)
+
+
+def normalize_result(text: str, paths: dict[str, str]) -> str:
+    """text without what differs between runs; paths maps run-specific texts to placeholders."""
+    for value, placeholder in sorted(paths.items(), key=lambda item: -len(item[0])):
+        if value:
+            text = text.replace(value, placeholder)
+    for pattern, placeholder in VOLATILE_PATTERNS:
+        text = pattern.sub(placeholder, text)
+    return text.strip()
+
+
+def result_difference(step: RecordedStep, result: str, failed: bool, paths: dict[str, str]) -> str:
+    """How result differs from the recorded one, as one line; "" if it does not."""
+    if failed != step.failed:
+        return "the call failed" if failed else "the recorded call failed, this one succeeded"
+    recorded = normalize_result(step.result, paths)
+    now = normalize_result(result[:MAX_RESULT_CHARS] if step.truncated else result, paths)
+    if recorded == now:
+        return ""
+    recorded_lines, now_lines = recorded.splitlines(), now.splitlines()
+    for number, (before, after) in enumerate(zip(recorded_lines, now_lines), start=1):
+        if before != after:
+            return f"line {number} differs: recorded {before[:120]!r}, now {after[:120]!r}"
+    return f"recorded {len(recorded_lines)} line(s), now {len(now_lines)}"
+
+
+@dataclass
+class StepOutcome:
+    index: int
+    tool: str
+    difference: str = ""
+    # Why the step was not replayed
+    skipped: str = ""
+    result: str = ""
+
+    @property
+    def same(self) -> bool:
+        return not self.difference and not self.skipped
+
+    def describe(self, recorded: RecordedStep | None = None) -> list[str]:
+        if self.skipped:
+            return [f"⏭️ {self.index}. {self.tool}: {self.skipped}"]
+        if not self.difference:
+            return [f"✅ {self.index}. {self.tool}"]
+        lines = [f"❌ {self.index}. {self.tool}: {self.difference}"]
+        if recorded is not None:
+            lines.append(f"   📼 Recorded:\n{recorded.result[:RESULT_PREVIEW]}")
+            lines.append(f"   🔁 Now:\n{self.result[:RESULT_PREVIEW]}")
+        return lines
+
+
+@dataclass
+class Replay:
+    """A recording being replayed into a workspace, a step at a time in step mode."""
+    recording: Recording
+    workspace: str
+    # Run-specific texts -> placeholders, for comparing results
+    paths: dict[str, str] = field(default_factory=dict)
+    outcomes: list[StepOutcome] = field(default_factory=list)
+    keep: bool = False
+
+    @property
+    def position(self) -> int:
+        return len(self.outcomes)
+
+    @property
+    def finished(self) -> bool:
+        return self.position >= len(self.recording.steps)
+
+    def summary(self) -> str:
+        same = sum(outcome.same for outcome in self.outcomes)
+        differing = sum(bool(outcome.difference) for outcome in self.outcomes)
+        skipped = sum(bool(outcome.skipped) for outcome in self.outcomes)
+        icon = "✅" if not differing else "❌"
+        return (
+            f"{icon} {self.recording.name}: {same} same, {differing} different, {skipped} skipped "
+            f"of {len(self.recording.steps)} step(s)"
+        )
+
+
+class SessionRecorder:
+    """The recordings in progress, by client."""
+
+    def __init__(self) -> None:
+        self.active: dict[str, Recording] = {}
+
+    def start(self, client: str, recording: Recording) -> None:
+        current = self.active.get(client)
+        if current is not None:
+            raise RecordingError(f"Already recording '{current.name}'; stop it with recording_stop() first")
+        self.active[client] = recording
+
+    def stop(self, client: str) -> Recording:
+        recording = self.active.pop(client, None)
+        if recording is None:
+            raise RecordingError("Not recording; start with recording_start()")
+        recording.stopped = now_iso()
+        return recording
+
+    def attach(self, server: Any, client_id: Callable[[], str]) -> None:
+        """Record the tool calls of a FastMCP server into the recording of the client making them."""
+        tool_manager = server._tool_manager
+        base_call_tool = tool_manager.call_tool
+
+        async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+            if in_tool_call.get():
+                return await base_call_tool(name, arguments, *args, **kwargs)
+            token = in_tool_call.set(True)
+            started = time.monotonic()
+            try:
+                result = await base_call_tool(name, arguments, *args, **kwargs)
+            except Exception as e:
+                # Refused calls raise, with the rendered error
+                self._record(client_id(), name, arguments, str(e), True, time.monotonic() - started)
+                raise
+            else:
+                self._record(client_id(), name, arguments, result_text(result), result_failed(result),
+                             time.monotonic() - started)
+                return result
+            finally:
+                in_tool_call.reset(token)
+
+        tool_manager.call_tool = call_tool
+
+    def _record(self, client: str, tool: str, arguments: dict[str, Any], text: str, failed: bool, seconds: float) -> None:
+        recording = self.active.get(client)
+        if recording is None or tool in NOT_RECORDED:
+            return
+        recording.record(RecordedStep(tool, dict(arguments or {}), text, failed, round(seconds, 3)))
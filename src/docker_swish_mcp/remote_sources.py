@@ -0,0 +1,271 @@
+"""
+Remote Prolog Sources for Docker SWISH MCP
+
+Downloads Prolog files from HTTP(S) URLs into a cache inside the data
+directory (so the container sees them under /data/url-cache), verifies an
+optional checksum, and remembers ETag/Last-Modified so a refresh only
+downloads files that changed.
+
+URLs are fetched from the server's host, so only public addresses are
+reached: a host that is or resolves to a loopback, private, link-local
+(cloud metadata) or otherwise reserved address is refused, and so is a
+redirect to one. Startup programs, which the operator configures, may
+name any host.
+"""
+
+import asyncio
+import hashlib
+import ipaddress
+import json
+import logging
+import re
+import socket
+import time
+from collections.abc import Callable
+from dataclasses import asdict, dataclass
+from pathlib import Path
+from typing import Any
+from urllib.parse import urljoin, urlparse
+
+import aiohttp
+from aiohttp.abc import AbstractResolver
+from aiohttp.resolver import DefaultResolver
+
+logger = logging.getLogger("docker-swish-mcp.remote_sources")
+
+CACHE_DIR_NAME = "url-cache"
+MAX_SOURCE_BYTES = 10 * 1024 * 1024
+CHECKSUM_RE = re.compile(r"^(?:(sha256|sha512|sha1|md5):)?([0-9a-fA-F]+)$")
+DIGEST_LENGTHS = {"md5": 32, "sha1": 40, "sha256": 64, "sha512": 128}
+REDIRECT_STATUSES = (301, 302, 303, 307, 308)
+MAX_REDIRECTS = 5
+
+
+class RemoteSourceError(Exception):
+    """Raised for invalid URLs, failed downloads or checksum mismatches."""
+
+
+@dataclass
+class CachedSource:
+    """A downloaded source file and where it came from."""
+    url: str
+    path: Path
+    sha256: str
+    size: int
+    fetched_at: float
+    etag: str = ""
+    last_modified: str = ""
+    from_cache: bool = False
+
+    @property
+    def consult_name(self) -> str:
+        """Path to consult, relative to the container's /data."""
+        return f"{CACHE_DIR_NAME}/{self.path.stem}"
+
+
+def parse_checksum(checksum: str) -> tuple[str, str]:
+    """Split "algo:hex" (or bare hex, algorithm inferred from length)."""
+    match = CHECKSUM_RE.match(checksum.strip())
+    if not match:
+        raise RemoteSourceError(f"Invalid checksum '{checksum}'. Use e.g. sha256:<hex>")
+    algorithm, digest = match.group(1), match.group(2).lower()
+    if algorithm is None:
+        algorithm = next((a for a, n in DIGEST_LENGTHS.items() if n == len(digest)), "")
+        if not algorithm:
+            raise RemoteSourceError(f"Cannot tell the algorithm of checksum '{checksum}'; prefix it, e.g. sha256:")
+    if len(digest) != DIGEST_LENGTHS[algorithm]:
+        raise RemoteSourceError(f"A {algorithm} checksum has {DIGEST_LENGTHS[algorithm]} hex digits")
+    return algorithm, digest
+
+
+def verify_checksum(content: bytes, checksum: str) -> None:
+    algorithm, expected = parse_checksum(checksum)
+    actual = hashlib.new(algorithm, content).hexdigest()
+    if actual != expected:
+        raise RemoteSourceError(f"Checksum mismatch: expected {algorithm}:{expected}, got {algorithm}:{actual}")
+
+
+def cache_paths(data_dir: Path, url: str) -> tuple[Path, Path]:
+    """Cache file and metadata sidecar for a URL."""
+    parsed = urlparse(url)
+    if parsed.scheme not in ("http", "https") or not parsed.netloc:
+        raise RemoteSourceError(f"Only http(s) URLs can be consulted, got '{url}'")
+    base = Path(parsed.path).stem or "source"
+    safe = re.sub(r"[^A-Za-z0-9_]", "_", base)[:40]
+    key = hashlib.sha256(url.encode()).hexdigest()[:12]
+    cache_dir = data_dir / CACHE_DIR_NAME
+    stem = f"{safe}_{key}"
+    return cache_dir / f"{stem}.pl", cache_dir / f"{stem}.json"
+
+
+def check_public_address(host: str, address: str) -> None:
+    """Raise RemoteSourceError unless address, which host is or resolves to, is a public one."""
+    ip = ipaddress.ip_address(address.split("%", 1)[0])
+    if isinstance(ip, ipaddress.IPv6Address) and ip.ipv4_mapped:
+        ip = ip.ipv4_mapped
+    if not ip.is_global:
+        raise RemoteSourceError(f"{host} is a loopback, private or reserved address ({ip}); only public hosts are fetched")
+
+
+def check_url_host(url: str) -> None:
+    """Raise RemoteSourceError if url names a host by an address that is not public."""
+    host = urlparse(url).hostname or ""
+    try:
+        ipaddress.ip_address(host.split("%", 1)[0])
+    except ValueError:
+        # A name, vetted by PublicResolver when it is looked up
+        return
+    check_public_address(host, host)
+
+
+class PublicResolver(AbstractResolver):
+    """Resolves host names like aiohttp's default resolver, refusing those with an address that is not public."""
+
+    def __init__(self) -> None:
+        self.resolver = DefaultResolver()
+
+    async def resolve(self, host: str, port: int = 0, family: int = socket.AF_INET) -> list[dict[str, Any]]:
+        hosts = await self.resolver.resolve(host, port, family)
+        for entry in hosts:
+            check_public_address(host, entry["host"])
+        return hosts
+
+    async def close(self) -> None:
+        await self.resolver.close()
+
+
+def _load_cached(url: str, path: Path, meta_path: Path) -> CachedSource | None:
+    if not path.exists() or not meta_path.exists():
+        return None
+    try:
+        meta = json.loads(meta_path.read_text(encoding="utf-8"))
+    except (OSError, json.JSONDecodeError):
+        return None
+    return CachedSource(
+        url=url,
+        path=path,
+        sha256=meta.get("sha256", ""),
+        size=meta.get("size", path.stat().st_size),
+        fetched_at=meta.get("fetched_at", 0.0),
+        etag=meta.get("etag", ""),
+        last_modified=meta.get("last_modified", ""),
+        from_cache=True,
+    )
+
+
+def _store(source: CachedSource, content: bytes, meta_path: Path) -> None:
+    source.path.parent.mkdir(parents=True, exist_ok=True)
+    source.path.write_bytes(content)
+    meta = asdict(source)
+    meta.pop("path")
+    meta.pop("from_cache")
+    meta_path.write_text(json.dumps(meta, indent=2) + "\n", encoding="utf-8")
+
+
+async def _read_response(url: str, response: Any) -> tuple[bytes, str, str]:
+    """The body of a successful response, with its ETag and Last-Modified headers."""
+    if response.status != 200:
+        raise RemoteSourceError(f"Download failed: HTTP {response.status} for {url}")
+    if (response.content_length or 0) > MAX_SOURCE_BYTES:
+        raise RemoteSourceError(f"{url} is larger than {MAX_SOURCE_BYTES // (1024 * 1024)} MB")
+    chunks = []
+    received = 0
+    async for chunk in response.content.iter_chunked(64 * 1024):
+        received += len(chunk)
+        if received > MAX_SOURCE_BYTES:
+            raise RemoteSourceError(f"{url} is larger than {MAX_SOURCE_BYTES // (1024 * 1024)} MB")
+        chunks.append(chunk)
+    return b"".join(chunks), response.headers.get("ETag", ""), response.headers.get("Last-Modified", "")
+
+
+async def fetch_source(
+    data_dir: Path,
+    url: str,
+    checksum: str = "",
+    refresh: bool = False,
+    timeout: float = 30,
+    check: Callable[[str], None] | None = None,
+    any_host: bool = False
+) -> CachedSource:
+    """
+    Return the cached copy of url, downloading it when needed.
+
+    A cached copy is used as-is unless refresh is set, in which case the
+    server is asked whether it changed (If-None-Match/If-Modified-Since).
+    The checksum, when given, is verified against whatever is returned,
+    and check (e.g. a sandbox policy's) against its text, before a
+    download is written to the cache. Unless any_host is set, hosts that
+    are not public are refused.
+    """
+    path, meta_path = cache_paths(data_dir, url)
+    if checksum:
+        parse_checksum(checksum)
+    cached = _load_cached(url, path, meta_path)
+
+    if cached and not refresh:
+        if checksum:
+            verify_checksum(path.read_bytes(), checksum)
+        if check:
+            check(path.read_text(encoding="utf-8"))
+        return cached
+
+    headers = {}
+    if cached:
+        if cached.etag:
+            headers["If-None-Match"] = cached.etag
+        if cached.last_modified:
+            headers["If-Modified-Since"] = cached.last_modified
+
+    connector = None if any_host else aiohttp.TCPConnector(resolver=PublicResolver())
+    try:
+        async with aiohttp.ClientSession(connector=connector) as session:
+            target = url
+            for _ in range(MAX_REDIRECTS + 1):
+                if not any_host:
+                    check_url_host(target)
+                async with session.get(
+                    target, headers=headers, allow_redirects=False, timeout=aiohttp.ClientTimeout(total=timeout)
+                ) as response:
+                    location = response.headers.get("Location")
+                    if response.status in REDIRECT_STATUSES and location:
+                        target = urljoin(target, location)
+                        if urlparse(target).scheme not in ("http", "https"):
+                            raise RemoteSourceError(f"{url} redirects to '{target}', which is not an http(s) URL")
+                        continue
+                    if response.status == 304 and cached:
+                        logger.info(f"{url} not modified, using cache")
+                        if checksum:
+                            verify_checksum(path.read_bytes(), checksum)
+                        if check:
+                            check(path.read_text(encoding="utf-8"))
+                        return cached
+                    content, etag, last_modified = await _read_response(url, response)
+                    break
+            else:
+                raise RemoteSourceError(f"{url} redirects more than {MAX_REDIRECTS} times")
+    except asyncio.TimeoutError as e:
+        raise RemoteSourceError(f"Download of {url} timed out after {timeout:g}s") from e
+    except aiohttp.ClientError as e:
+        raise RemoteSourceError(f"Download of {url} failed: {e}") from e
+
+    if checksum:
+        verify_checksum(content, checksum)
+    try:
+        text = content.decode("utf-8")
+    except UnicodeDecodeError as e:
+        raise RemoteSourceError(f"{url} is not UTF-8 text; is it really a Prolog source?") from e
+    if check:
+        check(text)
+
+    source = CachedSource(
+        url=url,
+        path=path,
+        sha256=hashlib.sha256(content).hexdigest(),
+        size=len(content),
+        fetched_at=time.time(),
+        etag=etag,
+        last_modified=last_modified,
+    )
+    _store(source, content, meta_path)
+    logger.info(f"Cached {url} as {path.name} ({len(content)} bytes)")
+    return source
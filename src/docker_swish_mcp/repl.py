@@ -0,0 +1,113 @@
+"""
+Interactive Toplevel for Docker SWISH MCP
+
+repl_send gives each client a ?- prompt of its own inside the persistent
+session: a long-lived Prolog engine (see mcp_repl_send/4 in
+mcp_helpers.pl) that runs every query the client types. Unlike
+execute_prolog_query, which collects all solutions of a goal at once:
+
+- answers come one at a time; ";" asks for the next one, "." (or an
+  empty input) accepts the last, and a new query abandons the old one
+- Prolog flags and global variables (b_setval/nb_setval) set by one
+  query are still set for the next, as they live in the engine
+- operators declared with op/3 or a ":- op(...)" directive apply to
+  the text of later queries, which is read in the client's module
+
+Everything else (the clauses in the database, loaded files) is shared
+with the rest of the session. The toplevel is lost when the session
+restarts, and repl_send(reset=True) starts a fresh one.
+"""
+
+import hashlib
+from dataclasses import dataclass
+from typing import Any
+
+from .errors import from_message
+from .rdf import prolog_atom
+from .sandbox import SandboxPolicy, apply_policy
+from .simple_session import clean_query_text, prolog_string
+
+# Inputs asking for the next answer, and inputs accepting the last one
+MORE_INPUTS = frozenset({";", "n", "r"})
+STOP_INPUTS = frozenset({"", ".", "a", "c"})
+
+
+def repl_alias(client_id: str) -> str:
+    """Alias of a client's toplevel engine."""
+    return f"mcp_repl_{hashlib.sha256(client_id.encode()).hexdigest()[:12]}"
+
+
+def parse_input(text: str) -> tuple[str, str]:
+    """("more" | "stop" | "query", goal text) for what was typed at the prompt."""
+    stripped = text.strip()
+    if stripped in MORE_INPUTS:
+        return "more", ""
+    if stripped in STOP_INPUTS:
+        return "stop", ""
+    goal = clean_query_text(stripped)
+    if not goal:
+        return "stop", ""
+    return "query", goal
+
+
+def vetted_goal(goal: str, policy: SandboxPolicy) -> str:
+    """goal as apply_policy would run it; a directive has its body vetted."""
+    if goal.startswith(":-"):
+        return f":- {apply_policy(goal[2:].strip(), policy)}"
+    return apply_policy(goal, policy)
+
+
+def repl_request(kind: str, goal: str, module: str) -> str:
+    """Request term for mcp_repl_send/4."""
+    if kind == "query":
+        return f"query({prolog_string(goal)}, {prolog_atom(module)})"
+    return kind
+
+
+@dataclass
+class ReplReply:
+    """What the toplevel answered; kind is answer, false, done, idle, error or reset."""
+    kind: str
+    text: str = ""
+    more: bool = False
+    message: str = ""
+    # The engine was created for this request
+    new: bool = False
+
+    @classmethod
+    def from_json(cls, row: dict[str, Any]) -> "ReplReply":
+        return cls(
+            kind=row.get("kind", "error"),
+            text=row.get("text", ""),
+            more=bool(row.get("more")),
+            message=row.get("message", ""),
+            new=bool(row.get("new")),
+        )
+
+    def to_json(self) -> dict[str, Any]:
+        entry: dict[str, Any] = {"kind": self.kind, "text": self.text, "more": self.more, "message": self.message}
+        if self.kind == "error":
+            entry["error"] = from_message(self.message).to_json()
+        return entry
+
+
+def format_reply(reply: ReplReply, output: list[str]) -> str:
+    """The reply as the ?- prompt would print it, after the goal's own output."""
+    lines = list(output)
+    if reply.kind == "answer" and reply.more:
+        lines.append(f"{reply.text} ;")
+        lines.append('💡 More answers may follow: send ";" for the next one, or "." to stop')
+    elif reply.kind == "answer":
+        lines.append(f"{reply.text}.")
+    elif reply.kind == "false":
+        lines.append("false.")
+    elif reply.kind == "done":
+        lines.append("✅ Answer accepted")
+    elif reply.kind == "idle":
+        lines.append("💤 No query is waiting for more answers; send a new query")
+    elif reply.kind == "reset":
+        lines.append("🔄 Toplevel reset: its flags, global variables and pending answers are gone")
+    else:
+        lines.append(f"❌ ERROR: {reply.message}")
+        lines.append(from_message(reply.message).tag())
+    return "\n".join(lines)
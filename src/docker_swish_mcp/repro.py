@@ -0,0 +1,228 @@
+"""
+Reproduce Bundles for Docker SWISH MCP
+
+execute_prolog_query(..., reproduce_bundle=True) records what the
+answer depended on, just before the query runs, in a zip kept in
+swish-repro/ next to the data directory:
+
+    manifest.json   the goal and the client module it ran in, the
+                    limits and print options, the image reference and
+                    digest (or the local swipl), the Prolog flags in
+                    REPRO_FLAGS, every consulted file below the data
+                    directory with its module and SHA-256 hash, each
+                    user module's dynamic database, and the result with
+                    its hash
+    files/<path>    the consulted files as they were
+
+replay(bundle) compares the environment now with the recorded one
+(image, SWI-Prolog version, flags, file hashes), runs the goal again
+with the recorded limits and print options, and says whether the
+result is the same. With restore=True the bundled files are written
+back and reloaded, the flags set and the databases restored first, so
+the goal runs against exactly the recorded state.
+"""
+
+import hashlib
+import json
+import time
+import zipfile
+from dataclasses import asdict, dataclass, field
+from datetime import datetime, timezone
+from pathlib import Path, PurePosixPath
+from typing import Any
+
+from .bundles import safe_member_path
+from .simple_session import prolog_string
+
+REPRO_FORMAT = "docker-swish-mcp-repro"
+REPRO_VERSION = 1
+MANIFEST = "manifest.json"
+FILES_PREFIX = "files/"
+# Prolog flags answers may depend on
+REPRO_FLAGS = (
+    "version", "bounded", "double_quotes", "back_quotes", "occurs_check", "unknown", "iso",
+    "prefer_rationals", "float_zero_div", "float_overflow", "float_undefined", "stack_limit", "table_space",
+)
+# Flags replay(restore=True) cannot set back
+READONLY_FLAGS = ("version", "bounded")
+# Characters of a differing result shown by replay
+RESULT_PREVIEW = 2000
+
+
+class ReproError(ValueError):
+    """Raised for an unreadable or tampered reproduce bundle."""
+
+
+def repro_dir_for(data_dir: Path) -> Path:
+    """Directory holding the reproduce bundles of a data directory (kept outside the mount)."""
+    return data_dir.parent / "swish-repro" / data_dir.name
+
+
+def sha256(data: bytes) -> str:
+    return hashlib.sha256(data).hexdigest()
+
+
+def flags_call() -> tuple[str, list[str]]:
+    return "mcp_env_flags", ["[" + ", ".join(REPRO_FLAGS) + "]"]
+
+
+def set_flags_call(flags: dict[str, str]) -> tuple[str, list[str]]:
+    pairs = ", ".join(
+        f"{name}-{prolog_string(value)}" for name, value in flags.items()
+        if name in REPRO_FLAGS and name not in READONLY_FLAGS
+    )
+    return "mcp_env_set_flags", [f"[{pairs}]"]
+
+
+def parse_flags(rows: list[dict[str, Any]]) -> dict[str, str]:
+    return {row["flag"]: row["value"] for row in rows if "value" in row}
+
+
+def flag_errors(rows: list[dict[str, Any]]) -> list[str]:
+    return [f"flag {row['flag']}: {row['error']}" for row in rows if row.get("error")]
+
+
+@dataclass
+class ReproFile:
+    # Relative to the data directory
+    path: str
+    module: str
+    sha256: str
+    size: int
+
+    @classmethod
+    def of(cls, path: str, module: str, data: bytes) -> "ReproFile":
+        return cls(path, module, sha256(data), len(data))
+
+
+@dataclass
+class ReproManifest:
+    goal: str
+    module: str
+    output_format: str
+    limits: dict[str, Any]
+    printing: dict[str, Any]
+    # backend, image, image_id, repo_digests, swipl and server versions
+    environment: dict[str, Any]
+    flags: dict[str, str]
+    files: list[ReproFile] = field(default_factory=list)
+    # module -> mcp_db_snapshot/2 rows
+    databases: dict[str, list[dict[str, Any]]] = field(default_factory=dict)
+    result: str = ""
+    result_sha256: str = ""
+    created: str = field(default_factory=lambda: datetime.now(timezone.utc).isoformat(timespec="seconds"))
+
+    def record_result(self, result: str) -> None:
+        self.result = result
+        self.result_sha256 = sha256(result.encode("utf-8"))
+
+    def same_result(self, result: str) -> bool:
+        return sha256(result.encode("utf-8")) == self.result_sha256
+
+    def to_json(self) -> dict[str, Any]:
+        return {"format": REPRO_FORMAT, "version": REPRO_VERSION, **asdict(self)}
+
+    @classmethod
+    def from_json(cls, data: dict[str, Any]) -> "ReproManifest":
+        if data.get("format") != REPRO_FORMAT:
+            raise ReproError("Not a reproduce bundle manifest")
+        if data.get("version", 0) > REPRO_VERSION:
+            raise ReproError(f"Reproduce bundle version {data['version']} is newer than this server supports")
+        fields = {key: value for key, value in data.items() if key not in ("format", "version", "files")}
+        try:
+            return cls(**fields, files=[ReproFile(**entry) for entry in data.get("files", [])])
+        except TypeError as e:
+            raise ReproError(f"Malformed reproduce bundle manifest: {e}") from e
+
+    def summary(self) -> str:
+        clauses = sum(len(row.get("clauses", [])) for rows in self.databases.values() for row in rows)
+        return f"{len(self.files)} file(s), {clauses} dynamic clause(s), {len(self.flags)} flag(s)"
+
+
+def write_repro_bundle(data_dir: Path, manifest: ReproManifest, contents: dict[str, bytes]) -> Path:
+    """Zip manifest and the consulted files' contents into swish-repro/; returns the bundle."""
+    directory = repro_dir_for(data_dir)
+    directory.mkdir(parents=True, exist_ok=True)
+    stamp = time.strftime("%Y%m%d-%H%M%S")
+    path = directory / f"repro-{stamp}-{manifest.result_sha256[:8]}.zip"
+    partial = path.with_suffix(".tmp")
+    with zipfile.ZipFile(partial, "w", zipfile.ZIP_DEFLATED) as archive:
+        archive.writestr(MANIFEST, json.dumps(manifest.to_json(), indent=2))
+        for entry in manifest.files:
+            archive.writestr(FILES_PREFIX + entry.path, contents[entry.path])
+    partial.replace(path)
+    return path
+
+
+def read_repro_bundle(path: Path) -> tuple[ReproManifest, dict[str, bytes]]:
+    """A bundle's manifest and files; raises ReproError unless every hash matches."""
+    try:
+        with zipfile.ZipFile(path) as archive:
+            manifest = ReproManifest.from_json(json.loads(archive.read(MANIFEST)))
+            contents = {}
+            for entry in manifest.files:
+                data = archive.read(FILES_PREFIX + safe_member_path(entry.path))
+                if sha256(data) != entry.sha256:
+                    raise ReproError(f"{entry.path} in {path.name} does not match its recorded hash")
+                contents[entry.path] = data
+    except (zipfile.BadZipFile, KeyError, OSError) as e:
+        raise ReproError(f"Could not read reproduce bundle {path.name}: {e}") from e
+    if not manifest.same_result(manifest.result):
+        raise ReproError(f"The result recorded in {path.name} does not match its hash")
+    return manifest, contents
+
+
+def resolve_repro_bundle(data_dir: Path, name: str) -> Path:
+    """A bundle by file name in swish-repro/ ("latest" for the newest), or by path."""
+    if name == "latest":
+        bundles = list_repro_bundles(data_dir)
+        if not bundles:
+            raise FileNotFoundError(f"No reproduce bundles in {repro_dir_for(data_dir)}")
+        return bundles[0]
+    candidate = repro_dir_for(data_dir) / name
+    if "/" not in name and candidate.is_file():
+        return candidate
+    path = Path(name).expanduser()
+    if path.is_file():
+        return path
+    raise FileNotFoundError(f"No reproduce bundle '{name}' in {repro_dir_for(data_dir)}")
+
+
+def list_repro_bundles(data_dir: Path) -> list[Path]:
+    directory = repro_dir_for(data_dir)
+    if not directory.is_dir():
+        return []
+    return sorted(directory.glob("*.zip"), key=lambda path: path.stat().st_mtime, reverse=True)
+
+
+def environment_differences(
+    manifest: ReproManifest,
+    environment: dict[str, Any],
+    flags: dict[str, str],
+    data_dir: Path
+) -> list[str]:
+    """How the environment now differs from the recorded one, one line per difference."""
+    differences = []
+    recorded = manifest.environment
+    for key, label in (("image_id", "image"), ("swipl", "SWI-Prolog"), ("backend", "backend")):
+        if recorded.get(key) != environment.get(key):
+            differences.append(f"{label}: recorded {recorded.get(key) or 'none'}, now {environment.get(key) or 'none'}")
+    for name, value in manifest.flags.items():
+        if flags.get(name) != value:
+            differences.append(f"flag {name}: recorded {value}, now {flags.get(name, 'unset')}")
+    for entry in manifest.files:
+        path = data_dir.joinpath(*PurePosixPath(entry.path).parts)
+        if not path.is_file():
+            differences.append(f"{entry.path}: missing")
+        elif sha256(path.read_bytes()) != entry.sha256:
+            differences.append(f"{entry.path}: changed since it was recorded")
+    return differences
+
+
+def restore_files(data_dir: Path, contents: dict[str, bytes]) -> int:
+    """Write a bundle's files back into data_dir; returns how many."""
+    for relative, data in contents.items():
+        path = data_dir.joinpath(*PurePosixPath(safe_member_path(relative)).parts)
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_bytes(data)
+    return len(contents)
@@ -0,0 +1,171 @@
+"""
+Structured Logging and Request Correlation IDs for Docker SWISH MCP
+
+Every MCP tool call gets a correlation ID: the X-Correlation-ID header of
+the HTTP request when the client sends one, otherwise a new req-<hex>.
+While the call runs, the ID is
+
+- on every record the server logs, from whichever module, so the docker
+  operations and SWISH requests a call makes can be told apart from a
+  concurrent call's
+- sent to SWISH as the X-Correlation-ID header, and set as
+  MCP_CORRELATION_ID in the environment of the processes the call starts
+  in the container
+- the mcp.correlation_id attribute of the call's OpenTelemetry spans
+- returned to the client as correlation_id in the tool's structured result
+
+SWISH_MCP_LOG_LEVEL (or --log-level) is debug, info (the default),
+warning or error. SWISH_MCP_LOG_FORMAT (or --log-format) is text, the
+usual lines with the ID in brackets after the level, or json, one object
+per line for a log collector:
+
+    {"time": "2026-10-14T08:31:32.623Z", "level": "info", "logger": "docker-swish-mcp",
+     "message": "...", "correlation_id": "req-3f2a9c01b7de", "tool": "execute_prolog_query"}
+
+Fields a log call passes with extra={...} become keys of the object. At
+debug level, the end of each tool call is logged with its status and
+duration. Background work (health probes, sync, scheduled queries) logs
+without an ID.
+"""
+
+import contextvars
+import json
+import logging
+import re
+import sys
+import time
+import uuid
+from collections.abc import Callable
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.requests")
+
+CORRELATION_HEADER = "X-Correlation-ID"
+# Environment variable of processes started in the container for a tool call
+CORRELATION_ENV = "MCP_CORRELATION_ID"
+# IDs taken from clients; anything else gets a new one
+CLIENT_ID_RE = re.compile(r"^[A-Za-z0-9._:-]{1,64}$")
+
+LOG_LEVELS = {
+    "debug": logging.DEBUG,
+    "info": logging.INFO,
+    "warning": logging.WARNING,
+    "error": logging.ERROR,
+}
+LOG_FORMATS = ("text", "json")
+TEXT_FORMAT = "%(asctime)s [%(name)s] [%(levelname)s]%(correlation)s %(message)s"
+
+correlation_id: contextvars.ContextVar[str] = contextvars.ContextVar("correlation_id", default="")
+current_tool: contextvars.ContextVar[str] = contextvars.ContextVar("current_tool", default="")
+
+# Attributes every LogRecord has; the rest were passed with extra={...}
+RECORD_ATTRS = set(vars(logging.LogRecord("", 0, "", 0, "", None, None))) | {
+    "message", "asctime", "correlation", "correlation_id", "mcp_tool",
+}
+
+
+def new_correlation_id() -> str:
+    return f"req-{uuid.uuid4().hex[:12]}"
+
+
+def client_correlation_id(value: str | None) -> str:
+    """The ID a client sent, if it is one that can be logged and passed on as is."""
+    value = (value or "").strip()
+    return value if CLIENT_ID_RE.match(value) else ""
+
+
+def correlation_headers() -> dict[str, str]:
+    """Headers passing the current call's ID on to SWISH."""
+    current = correlation_id.get()
+    return {CORRELATION_HEADER: current} if current else {}
+
+
+def correlation_environment() -> list[str]:
+    """Environment of an exec passing the current call's ID on to the process."""
+    current = correlation_id.get()
+    return [f"{CORRELATION_ENV}={current}"] if current else []
+
+
+class CorrelationFilter(logging.Filter):
+    """Adds the current call's correlation ID and tool to log records."""
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        current = correlation_id.get()
+        record.correlation_id = current
+        record.mcp_tool = current_tool.get()
+        record.correlation = f" [{current}]" if current else ""
+        return True
+
+
+class JsonFormatter(logging.Formatter):
+    """One JSON object per record."""
+
+    def format(self, record: logging.LogRecord) -> str:
+        entry: dict[str, Any] = {
+            "time": time.strftime("%Y-%m-%dT%H:%M:%S", time.gmtime(record.created)) + f".{int(record.msecs):03d}Z",
+            "level": record.levelname.lower(),
+            "logger": record.name,
+            "message": record.getMessage(),
+        }
+        if getattr(record, "correlation_id", ""):
+            entry["correlation_id"] = record.correlation_id
+        if getattr(record, "mcp_tool", ""):
+            entry["tool"] = record.mcp_tool
+        for key, value in vars(record).items():
+            if key not in RECORD_ATTRS and not key.startswith("_"):
+                entry[key] = value
+        if record.exc_info:
+            entry["exception"] = self.formatException(record.exc_info)
+        elif record.exc_text:
+            entry["exception"] = record.exc_text
+        return json.dumps(entry, ensure_ascii=False, default=str)
+
+
+def configure_logging(level: str = "info", fmt: str = "text") -> None:
+    """Set the level of the root logger, and the format and correlation filter of its handlers."""
+    root = logging.getLogger()
+    root.setLevel(LOG_LEVELS.get(level, logging.INFO))
+    if not root.handlers:
+        root.addHandler(logging.StreamHandler(sys.stderr))
+    formatter = JsonFormatter() if fmt == "json" else logging.Formatter(TEXT_FORMAT)
+    for handler in root.handlers:
+        handler.setFormatter(formatter)
+        if not any(isinstance(existing, CorrelationFilter) for existing in handler.filters):
+            handler.addFilter(CorrelationFilter())
+
+
+def attach_correlation_ids(
+    server: Any,
+    header: Callable[[], str | None],
+    failed: Callable[[Any], bool]
+) -> None:
+    """
+    Run every tool call of a FastMCP server under a correlation ID.
+
+    header returns the X-Correlation-ID the current request was sent with,
+    if any. Calls made from within a call (the dashboard's, the harness's)
+    keep the ID of the call they are made from.
+    """
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        current = correlation_id.get() or client_correlation_id(header()) or new_correlation_id()
+        id_token = correlation_id.set(current)
+        tool_token = current_tool.set(name)
+        started = time.monotonic()
+        status = "error"
+        try:
+            result = await base_call_tool(name, arguments, *args, **kwargs)
+            status = "error" if failed(result) else "ok"
+            return result
+        finally:
+            seconds = time.monotonic() - started
+            logger.debug(
+                f"{name} finished ({status}) in {seconds:.3f}s",
+                extra={"status": status, "duration_ms": round(seconds * 1000, 1)}
+            )
+            current_tool.reset(tool_token)
+            correlation_id.reset(id_token)
+
+    tool_manager.call_tool = call_tool
@@ -0,0 +1,322 @@
+"""
+Container Resource Limits for Docker SWISH MCP
+
+A runaway query (a findall/3 over an infinite generator, say) can take
+all of the host's memory. The SWISH container can be started with
+limits, from the environment or the [container] table of the config
+file:
+
+- SWISH_MCP_MEMORY / memory:         memory limit, e.g. "2g" or "512m";
+                                     swap is limited to the same amount,
+                                     so the container is OOM-killed rather
+                                     than the host swapping
+- SWISH_MCP_CPUS / cpus:             CPUs the container may use, e.g. 1.5
+- SWISH_MCP_PIDS_LIMIT / pids_limit: processes and threads in the container
+- SWISH_MCP_ULIMITS / ulimits:       "nofile=1024:2048,core=0", or a table
+                                     such as {nofile = [1024, 2048]}
+
+Unset or 0 means unlimited. Changing a limit recreates the container.
+container_stats reports live usage against these limits from the
+runtime's stats API.
+"""
+
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+# Docker refuses memory limits below 6 MiB
+MIN_MEMORY = 6 * 1024 * 1024
+
+MEMORY_RE = re.compile(r"^\s*(\d+(?:\.\d+)?)\s*([bkmgt]?)(?:i?b)?\s*$", re.IGNORECASE)
+MEMORY_UNITS = {"": 1, "b": 1, "k": 1024, "m": 1024 ** 2, "g": 1024 ** 3, "t": 1024 ** 4}
+ULIMIT_NAME_RE = re.compile(r"^[a-z]+$")
+
+
+def parse_memory(value: Any) -> int:
+    """Bytes of a memory size such as 536870912, "512m" or "2GiB"; 0 is unlimited."""
+    if isinstance(value, bool):
+        raise ValueError(f"memory must be a size such as '2g', not {value!r}")
+    if isinstance(value, int):
+        size = value
+    else:
+        match = MEMORY_RE.match(str(value))
+        if match is None:
+            raise ValueError(f"memory must be a size such as '2g' or '512m', not {value!r}")
+        size = int(float(match[1]) * MEMORY_UNITS[match[2].lower()])
+    if size < 0 or 0 < size < MIN_MEMORY:
+        raise ValueError(f"memory must be 0 (unlimited) or at least 6m, not {value!r}")
+    return size
+
+
+def format_bytes(size: float) -> str:
+    for unit in ("B", "KiB", "MiB", "GiB"):
+        if abs(size) < 1024:
+            return f"{size:.0f}{unit}" if unit == "B" else f"{size:.1f}{unit}"
+        size /= 1024
+    return f"{size:.1f}TiB"
+
+
+def _memory_text(size: int) -> str:
+    """Shortest exact docker size for a byte count, e.g. "2g"."""
+    for unit in ("t", "g", "m", "k"):
+        if size % MEMORY_UNITS[unit] == 0:
+            return f"{size // MEMORY_UNITS[unit]}{unit}"
+    return str(size)
+
+
+@dataclass(frozen=True)
+class Ulimit:
+    name: str
+    soft: int
+    hard: int
+
+    def __str__(self) -> str:
+        return f"{self.name}={self.soft}:{self.hard}"
+
+
+def _ulimit(name: str, value: Any) -> Ulimit:
+    name = str(name).strip().lower()
+    if not ULIMIT_NAME_RE.match(name):
+        raise ValueError(f"ulimits has an invalid name {name!r}")
+    if isinstance(value, str):
+        value = value.split(":")
+    elif not isinstance(value, (list, tuple)):
+        value = [value]
+    try:
+        if isinstance(value, (list, tuple)) and any(isinstance(v, bool) for v in value):
+            raise ValueError
+        numbers = [int(v) for v in value]
+    except (TypeError, ValueError):
+        raise ValueError(f"ulimit {name} must be a number or soft:hard, not {value!r}")
+    if len(numbers) not in (1, 2) or min(numbers) < -1:
+        raise ValueError(f"ulimit {name} must be a number or soft:hard, not {value!r}")
+    soft, hard = numbers[0], numbers[-1]
+    if hard != -1 and (soft == -1 or soft > hard):
+        raise ValueError(f"ulimit {name}: the soft limit {soft} is above the hard limit {hard}")
+    return Ulimit(name, soft, hard)
+
+
+def parse_ulimits(value: Any) -> tuple[Ulimit, ...]:
+    """Ulimits from "nofile=1024:2048,core=0" or a {name: limit or [soft, hard]} mapping."""
+    if isinstance(value, dict):
+        items = list(value.items())
+    elif isinstance(value, str):
+        items = []
+        for part in value.split(","):
+            if not part.strip():
+                continue
+            name, sep, limit = part.partition("=")
+            if not sep:
+                raise ValueError(f"ulimits entries look like nofile=1024:2048, not {part.strip()!r}")
+            items.append((name, limit.strip()))
+    else:
+        raise ValueError(f"ulimits must be a string or a table, not {value!r}")
+    ulimits = {limit.name: limit for limit in (_ulimit(name, v) for name, v in items)}
+    return tuple(sorted(ulimits.values(), key=lambda limit: limit.name))
+
+
+@dataclass(frozen=True)
+class ContainerResources:
+    """Limits the SWISH container is started with; 0 or () means unlimited."""
+    memory: int = 0
+    cpus: float = 0.0
+    pids_limit: int = 0
+    ulimits: tuple[Ulimit, ...] = field(default_factory=tuple)
+
+    def __bool__(self) -> bool:
+        return bool(self.memory or self.cpus or self.pids_limit or self.ulimits)
+
+    def __str__(self) -> str:
+        parts = []
+        if self.memory:
+            parts.append(f"memory={_memory_text(self.memory)}")
+        if self.cpus:
+            parts.append(f"cpus={self.cpus:g}")
+        if self.pids_limit:
+            parts.append(f"pids_limit={self.pids_limit}")
+        parts.extend(f"ulimit {limit}" for limit in self.ulimits)
+        return " ".join(parts)
+
+    @classmethod
+    def from_settings(cls, raw: dict[str, Any], base: "ContainerResources | None" = None) -> "ContainerResources":
+        """Resources from memory/cpus/pids_limit/ulimits values, defaulting to base's."""
+        base = base or cls()
+        memory = parse_memory(raw["memory"]) if raw.get("memory") not in (None, "") else base.memory
+
+        cpus = raw.get("cpus", base.cpus)
+        if isinstance(cpus, str):
+            try:
+                cpus = float(cpus) if cpus.strip() else 0.0
+            except ValueError:
+                raise ValueError(f"cpus must be a number, not {cpus!r}")
+        if isinstance(cpus, bool) or not isinstance(cpus, (int, float)) or cpus < 0:
+            raise ValueError(f"cpus must be a non-negative number, not {cpus!r}")
+
+        pids_limit = raw.get("pids_limit", base.pids_limit)
+        if isinstance(pids_limit, str):
+            try:
+                pids_limit = int(pids_limit) if pids_limit.strip() else 0
+            except ValueError:
+                raise ValueError(f"pids_limit must be an integer, not {pids_limit!r}")
+        if isinstance(pids_limit, bool) or not isinstance(pids_limit, int) or pids_limit < 0:
+            raise ValueError(f"pids_limit must be a non-negative integer, not {pids_limit!r}")
+
+        ulimits = parse_ulimits(raw["ulimits"]) if raw.get("ulimits") not in (None, "") else base.ulimits
+        return cls(memory=memory, cpus=float(cpus), pids_limit=pids_limit, ulimits=ulimits)
+
+    def run_options(self) -> dict[str, Any]:
+        """Keyword arguments of containers.run() applying these limits."""
+        options: dict[str, Any] = {}
+        if self.memory:
+            options["mem_limit"] = self.memory
+            options["memswap_limit"] = self.memory
+        if self.cpus:
+            options["nano_cpus"] = int(self.cpus * 1e9)
+        if self.pids_limit:
+            options["pids_limit"] = self.pids_limit
+        if self.ulimits:
+            options["ulimits"] = [
+                {"Name": limit.name, "Soft": limit.soft, "Hard": limit.hard} for limit in self.ulimits
+            ]
+        return options
+
+
+@dataclass
+class ContainerUsage:
+    """A sample of a container's resource use; None where the runtime did not say."""
+    cpu_percent: float | None = None
+    memory_used: int | None = None
+    memory_limit: int | None = None
+    pids: int | None = None
+    pids_limit: int | None = None
+    network_rx: int | None = None
+    network_tx: int | None = None
+    block_read: int | None = None
+    block_write: int | None = None
+
+    @property
+    def memory_percent(self) -> float | None:
+        if self.memory_used is None or not self.memory_limit:
+            return None
+        return 100.0 * self.memory_used / self.memory_limit
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "cpu_percent": None if self.cpu_percent is None else round(self.cpu_percent, 2),
+            "memory": {
+                "used": self.memory_used,
+                "limit": self.memory_limit,
+                "percent": None if self.memory_percent is None else round(self.memory_percent, 2),
+            },
+            "pids": {"current": self.pids, "limit": self.pids_limit},
+            "network": {"rx_bytes": self.network_rx, "tx_bytes": self.network_tx},
+            "block_io": {"read_bytes": self.block_read, "write_bytes": self.block_write},
+        }
+
+
+def usage_from_stats(stats: dict[str, Any]) -> ContainerUsage:
+    """Usage from a Docker Engine stats response (container.stats(stream=False))."""
+    usage = ContainerUsage()
+
+    cpu, precpu = stats.get("cpu_stats") or {}, stats.get("precpu_stats") or {}
+    cpu_delta = (cpu.get("cpu_usage") or {}).get("total_usage", 0) - (precpu.get("cpu_usage") or {}).get("total_usage", 0)
+    system_delta = cpu.get("system_cpu_usage", 0) - precpu.get("system_cpu_usage", 0)
+    online = cpu.get("online_cpus") or len((cpu.get("cpu_usage") or {}).get("percpu_usage") or []) or 1
+    if system_delta > 0 and cpu_delta >= 0:
+        usage.cpu_percent = 100.0 * cpu_delta / system_delta * online
+
+    memory = stats.get("memory_stats") or {}
+    if "usage" in memory:
+        # Page cache the kernel can reclaim is not counted, as `docker stats` does
+        details = memory.get("stats") or {}
+        cache = details.get("inactive_file", details.get("total_inactive_file", 0))
+        usage.memory_used = max(memory["usage"] - cache, 0)
+        usage.memory_limit = memory.get("limit")
+
+    pids = stats.get("pids_stats") or {}
+    usage.pids = pids.get("current")
+    usage.pids_limit = pids.get("limit")
+
+    networks = stats.get("networks")
+    if networks:
+        usage.network_rx = sum(n.get("rx_bytes", 0) for n in networks.values())
+        usage.network_tx = sum(n.get("tx_bytes", 0) for n in networks.values())
+
+    io = (stats.get("blkio_stats") or {}).get("io_service_bytes_recursive")
+    if io:
+        usage.block_read = sum(row.get("value", 0) for row in io if row.get("op", "").lower() == "read")
+        usage.block_write = sum(row.get("value", 0) for row in io if row.get("op", "").lower() == "write")
+    return usage
+
+
+def _size_value(text: str) -> int | None:
+    match = MEMORY_RE.match(text)
+    if match is None:
+        return None
+    return int(float(match[1]) * MEMORY_UNITS[match[2].lower()])
+
+
+def _size_pair(text: str) -> tuple[int | None, int | None]:
+    left, _, right = text.partition("/")
+    return _size_value(left), _size_value(right) if right else None
+
+
+def usage_from_summary(row: dict[str, Any]) -> ContainerUsage:
+    """Usage from a `nerdctl stats --format '{{json .}}'` row, as text like "10MiB / 2GiB"."""
+    usage = ContainerUsage()
+    percent = str(row.get("CPUPerc", "")).rstrip("%").strip()
+    if percent:
+        try:
+            usage.cpu_percent = float(percent)
+        except ValueError:
+            pass
+    usage.memory_used, usage.memory_limit = _size_pair(str(row.get("MemUsage", "")))
+    usage.network_rx, usage.network_tx = _size_pair(str(row.get("NetIO", "")))
+    usage.block_read, usage.block_write = _size_pair(str(row.get("BlockIO", "")))
+    pids = str(row.get("PIDs", "")).strip()
+    usage.pids = int(pids) if pids.isdigit() else None
+    return usage
+
+
+def usage_from_any(stats: dict[str, Any]) -> ContainerUsage:
+    return usage_from_summary(stats) if "MemUsage" in stats else usage_from_stats(stats)
+
+
+def format_usage(name: str, usage: ContainerUsage, resources: ContainerResources, oom_kills: int) -> str:
+    def size(value: int | None) -> str:
+        return "unknown" if value is None else format_bytes(value)
+
+    lines = [f"📊 Container {name}:"]
+    if usage.cpu_percent is not None:
+        limit = f" of {resources.cpus:g} CPU(s)" if resources.cpus else ""
+        lines.append(f"  🧮 CPU: {usage.cpu_percent:.1f}%{limit}")
+    memory = f"  🧠 Memory: {size(usage.memory_used)}"
+    if resources.memory:
+        percent = usage.memory_percent
+        memory += f" / {format_bytes(resources.memory)}" + (f" ({percent:.1f}%)" if percent is not None else "")
+    elif usage.memory_limit:
+        memory += f" / {format_bytes(usage.memory_limit)} (no limit set; host memory)"
+    lines.append(memory)
+    if usage.pids is not None:
+        limit = resources.pids_limit or (usage.pids_limit if usage.pids_limit and usage.pids_limit < 2 ** 62 else 0)
+        lines.append(f"  🧵 Processes: {usage.pids}" + (f" / {limit}" if limit else ""))
+    if usage.network_rx is not None:
+        lines.append(f"  🌐 Network: {size(usage.network_rx)} in, {size(usage.network_tx)} out")
+    if usage.block_read is not None:
+        lines.append(f"  💾 Block I/O: {size(usage.block_read)} read, {size(usage.block_write)} written")
+    if resources.ulimits:
+        lines.append("  📏 Ulimits: " + ", ".join(str(limit) for limit in resources.ulimits))
+    if oom_kills:
+        lines.append(f"  ⚠️ Out of memory: the container was OOM-killed {oom_kills} time(s) since the server started")
+    if not resources:
+        lines.append("  💡 No resource limits set; see SWISH_MCP_MEMORY, SWISH_MCP_CPUS and SWISH_MCP_PIDS_LIMIT")
+    return "\n".join(lines)
+
+
+def was_oom_killed(container: Any) -> bool:
+    """Whether the kernel killed the container for exceeding its memory; blocking."""
+    try:
+        container.reload()
+    except Exception:
+        return False
+    return bool((container.attrs.get("State") or {}).get("OOMKilled"))
@@ -0,0 +1,161 @@
+"""
+Result Post-Processing Hooks for Docker SWISH MCP
+
+Packages embedding the server can register hooks that rewrite query
+solutions before they are formatted for the client, e.g. to turn
+date(Y,M,D) terms into ISO dates, map atoms to display labels or leave
+out bindings of internal predicates:
+
+    from docker_swish_mcp.main import result_hooks
+    from docker_swish_mcp.result_hooks import Solution
+
+    def iso_dates(solution: Solution) -> Solution | None:
+        for name, value in solution.bindings.items():
+            if value.get("type") == "compound" and value.get("functor") == "date":
+                y, m, d = (arg["value"] for arg in value["args"])
+                solution.bindings[name] = {"type": "string", "value": f"{y:04d}-{m:02d}-{d:02d}"}
+        return solution
+
+    result_hooks.register("iso_dates", iso_dates, order=10)
+
+Without code, SWISH_MCP_RESULT_HOOKS lists hooks to import when the
+server starts, as module:function, comma-separated; a function's order
+attribute, if it has one, is its order.
+
+A hook is called with each Solution of execute_prolog_query, on the
+persistent session or isolated: the query, the client's module and the
+output format, bindings (variable name -> typed value, see
+mcp_term_json/2 in mcp_helpers.pl) with JSON output and text (the
+printed solution) with text output. It returns the solution, changed or
+a new one, or None to leave it out. Hooks run by ascending order, then
+in the order they were registered; registering a name again replaces
+that hook.
+
+Each hook is isolated from the others: it gets a copy of the solution,
+and one that raises, or returns something else than a Solution or None,
+is skipped for that solution, which goes on to the next hook as it was.
+The failure is logged and counted, and get_swish_status lists the hooks
+with their failures.
+"""
+
+import copy
+import importlib
+import logging
+import os
+from collections.abc import Callable
+from dataclasses import dataclass, field, replace
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.result-hooks")
+
+
+@dataclass
+class Solution:
+    """One solution of a query, as hooks see and change it."""
+    query: str
+    module: str
+    # "text" or "json"
+    output_format: str
+    # Variable name -> typed value, with JSON output
+    bindings: dict[str, Any] = field(default_factory=dict)
+    # The printed solution, with text output
+    text: str = ""
+
+
+ResultHook = Callable[[Solution], "Solution | None"]
+
+
+@dataclass
+class HookEntry:
+    name: str
+    hook: ResultHook
+    order: int = 0
+    # Registration number, so equal orders keep registration order
+    sequence: int = 0
+    calls: int = 0
+    failures: int = 0
+    last_error: str = ""
+
+    def describe(self) -> str:
+        failed = f", {self.failures} failed (last: {self.last_error})" if self.failures else ""
+        return f"{self.name} (order {self.order}, {self.calls} call(s){failed})"
+
+
+class ResultHooks:
+    """The registered hooks, applied to every solution of a query."""
+
+    def __init__(self) -> None:
+        self.entries: dict[str, HookEntry] = {}
+        self.sequence = 0
+
+    def register(self, name: str, hook: ResultHook, order: int = 0) -> None:
+        if not callable(hook):
+            raise TypeError(f"Result hook {name} is not callable")
+        self.sequence += 1
+        self.entries[name] = HookEntry(name, hook, order, self.sequence)
+        logger.info(f"🪝 Registered result hook {name} (order {order})")
+
+    def unregister(self, name: str) -> bool:
+        return self.entries.pop(name, None) is not None
+
+    def __bool__(self) -> bool:
+        return bool(self.entries)
+
+    def ordered(self) -> list[HookEntry]:
+        return sorted(self.entries.values(), key=lambda entry: (entry.order, entry.sequence))
+
+    def apply(self, solution: Solution) -> Solution | None:
+        """solution after every hook; None once a hook leaves it out."""
+        for entry in self.ordered():
+            entry.calls += 1
+            try:
+                # A copy, so that a hook failing halfway leaves no changes behind
+                processed = entry.hook(replace(solution, bindings=copy.deepcopy(solution.bindings)))
+                if processed is not None and not isinstance(processed, Solution):
+                    raise TypeError(f"returned {type(processed).__name__}, not a Solution or None")
+            except Exception as e:
+                entry.failures += 1
+                entry.last_error = f"{type(e).__name__}: {e}"[:200]
+                logger.warning(f"Result hook {entry.name} failed on a solution of {solution.query}: {entry.last_error}")
+                continue
+            if processed is None:
+                return None
+            solution = processed
+        return solution
+
+    def process(self, query: str, module: str, output_format: str, value: Any) -> Any:
+        """
+        A solution as the session gives it (bindings with JSON output, its
+        text otherwise) after the hooks; None if a hook leaves it out.
+        """
+        if not self.entries:
+            return value
+        structured = output_format == "json" and isinstance(value, dict)
+        solution = self.apply(Solution(
+            query, module, output_format,
+            bindings=dict(value) if structured else {},
+            text="" if structured else str(value),
+        ))
+        if solution is None:
+            return None
+        return solution.bindings if structured else solution.text
+
+    def load(self, specs: str) -> list[str]:
+        """Import module:function hooks, comma-separated; returns the problems, if any."""
+        problems = []
+        for spec in (part.strip() for part in specs.split(",")):
+            if not spec:
+                continue
+            module_name, _, attribute = spec.partition(":")
+            try:
+                if not attribute:
+                    raise ValueError("expected module:function")
+                hook = getattr(importlib.import_module(module_name), attribute)
+                self.register(spec, hook, int(getattr(hook, "order", 0)))
+            except Exception as e:
+                problems.append(f"{spec}: {e}")
+                logger.error(f"❌ Could not load result hook {spec}: {e}")
+        return problems
+
+    def load_from_env(self) -> list[str]:
+        return self.load(os.environ.get("SWISH_MCP_RESULT_HOOKS", ""))
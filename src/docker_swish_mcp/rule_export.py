@@ -0,0 +1,802 @@
+"""
+Rule Export to Soufflé Datalog and SQL for Docker SWISH MCP
+
+export_rules translates the function-free part of the knowledge base, as
+mcp_rules_export/3 in mcp_helpers.pl reads it, into a Soufflé program or
+SQL tables and views, for rules prototyped in SWISH and deployed on a
+Datalog engine or a database. A clause translates when
+
+- its arguments are constants (atoms, strings and numbers) and variables
+- its body is a conjunction of predicates of the knowledge base, their
+  negations (\\+) and comparisons (=, \\=, ==, \\==, <, >, =<, >=, =:=
+  and =\\=) of constants and variables
+- it is safe: every variable of the head, a comparison or a negation
+  (unless it occurs only once) is bound by a positive goal
+- its negations are stratified: no predicate depends on its own negation
+
+X = Y is solved before translating, replacing X by Y. Every other clause
+is left out and reported with the reason: compound terms and lists,
+cuts, disjunctions, calls of built-ins and library predicates, ...,
+together with the predicates that depend on the ones it leaves
+incomplete. The translation has Datalog's meaning, sets of ground facts:
+goals are not ordered, atoms and strings are the same text and answers
+are not repeated.
+
+Soufflé columns are symbol, number or float, taken from the constants
+reaching them; a column mixing text and numbers is a symbol column and
+its numbers are written as text. Facts are inline and the derived
+relations (or the requested ones) are .output. SQL has a table per
+relation with facts (p_facts when it also has rules) and a view per
+relation with rules, ordered so that every view follows what it reads;
+a recursive predicate is a RECURSIVE VIEW, as PostgreSQL writes it,
+which takes one recursive rule calling the predicate once and no mutual
+recursion.
+"""
+
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+
+EXPORT_TARGETS = ("souffle", "sql")
+TARGET_SUFFIXES = {"souffle": ".dl", "sql": ".sql"}
+# Clauses read per predicate
+MAX_RULE_CLAUSES = 10_000
+
+IDENTIFIER_RE = re.compile(r"[^A-Za-z0-9_]")
+# Prolog comparison -> (Soufflé and SQL operator, arithmetic)
+COMPARISONS = {
+    "\\=": ("!=", False),
+    "==": ("=", False),
+    "\\==": ("!=", False),
+    "<": ("<", True),
+    ">": (">", True),
+    "=<": ("<=", True),
+    ">=": (">=", True),
+    "=:=": ("=", True),
+    "=\\=": ("!=", True),
+}
+# \+ X = Y is X \= Y, and \+ X == Y is X \== Y
+NEGATED_COMPARISONS = {"=/2": "\\=", "==/2": "\\=="}
+CONTROL = {
+    ";": "uses a disjunction or if-then-else",
+    "->": "uses if-then-else",
+    "*->": "uses soft-cut",
+    ":": "calls a goal in another module",
+    "^": "uses ^ outside bagof/setof",
+}
+NUMBER_KINDS = ("integer", "float")
+SOUFFLE_TYPES = {"symbol": "symbol", "integer": "number", "float": "float"}
+SQL_TYPES = {"symbol": "TEXT", "integer": "INTEGER", "float": "DOUBLE PRECISION"}
+
+
+class Untranslatable(ValueError):
+    """A clause outside the function-free subset; the message is the reason."""
+
+
+@dataclass(frozen=True)
+class Var:
+    name: str
+
+
+@dataclass(frozen=True)
+class Const:
+    # "integer", "float" or "symbol" (atoms and strings)
+    kind: str
+    value: Any
+
+
+Arg = Var | Const
+
+
+@dataclass
+class Literal:
+    relation: str
+    args: list[Arg]
+    negated: bool = False
+
+
+@dataclass
+class Comparison:
+    op: str
+    left: Arg
+    right: Arg
+
+
+@dataclass
+class Clause:
+    relation: str
+    head: list[Arg]
+    literals: list[Literal] = field(default_factory=list)
+    comparisons: list[Comparison] = field(default_factory=list)
+    text: str = ""
+
+    @property
+    def is_fact(self) -> bool:
+        return not self.literals and not self.comparisons
+
+    def positive(self) -> list[Literal]:
+        return [literal for literal in self.literals if not literal.negated]
+
+    def occurrences(self) -> dict[str, int]:
+        counts: dict[str, int] = {}
+        args = [*self.head, *(arg for literal in self.literals for arg in literal.args),
+                *(arg for c in self.comparisons for arg in (c.left, c.right))]
+        for arg in args:
+            if isinstance(arg, Var):
+                counts[arg.name] = counts.get(arg.name, 0) + 1
+        return counts
+
+
+@dataclass
+class Skipped:
+    relation: str
+    text: str
+    reason: str
+
+
+@dataclass
+class Relation:
+    indicator: str
+    name: str
+    arity: int
+    clauses: list[Clause] = field(default_factory=list)
+    # Clauses in the knowledge base, and how many of them were read
+    count: int = 0
+    read: int = 0
+    # Column types: "symbol", "integer" or "float"
+    types: list[str] = field(default_factory=list)
+
+    @property
+    def facts(self) -> list[Clause]:
+        return [clause for clause in self.clauses if clause.is_fact]
+
+    @property
+    def rules(self) -> list[Clause]:
+        return [clause for clause in self.clauses if not clause.is_fact]
+
+
+@dataclass
+class RuleExport:
+    target: str
+    module: str
+    text: str
+    relations: list[str]
+    translated: int
+    skipped: list[Skipped]
+    warnings: list[str]
+    # Relations missing clauses, and those depending on them
+    incomplete: list[str]
+    affected: list[str]
+
+    def document(self) -> dict[str, Any]:
+        return {
+            "target": self.target,
+            "module": self.module,
+            "relations": self.relations,
+            "translated": self.translated,
+            "skipped": [{"predicate": s.relation, "clause": s.text, "reason": s.reason} for s in self.skipped],
+            "warnings": self.warnings,
+            "incomplete": self.incomplete,
+            "affected": self.affected,
+        }
+
+
+def rules_export_call(module: str) -> tuple[str, list[str]]:
+    return "mcp_rules_export", [prolog_atom(module), str(MAX_RULE_CLAUSES)]
+
+
+def parse_arg(term: dict[str, Any]) -> Arg:
+    kind = term.get("type")
+    if kind == "var":
+        return Var(term["name"])
+    if kind == "integer":
+        return Const("integer", term["value"])
+    if kind == "float":
+        if isinstance(term["value"], str):
+            raise Untranslatable(f"has the float {term['value']}")
+        return Const("float", term["value"])
+    if kind in ("atom", "string"):
+        return Const("symbol", term["value"])
+    if kind == "list":
+        raise Untranslatable("has a list argument")
+    if kind == "compound":
+        raise Untranslatable(f"has the compound term {term['functor']}/{term['arity']} as an argument")
+    raise Untranslatable(f"has the term {term.get('text', '?')}")
+
+
+def goal_indicator(goal: dict[str, Any]) -> tuple[str, list[dict[str, Any]]]:
+    if goal.get("type") == "atom":
+        return f"{goal['value']}/0", []
+    if goal.get("type") == "compound":
+        return f"{goal['functor']}/{goal['arity']}", goal["args"]
+    if goal.get("type") == "var":
+        raise Untranslatable("calls a variable goal")
+    raise Untranslatable(f"calls {goal.get('value', goal.get('text', '?'))}, which is not a goal")
+
+
+def parse_goal(goal: dict[str, Any], relations: dict[str, Relation], clause: Clause,
+               equalities: list[tuple[Arg, Arg]]) -> None:
+    indicator, args = goal_indicator(goal)
+    if indicator == "true/0":
+        return
+    if indicator == ",/2":
+        for sub in args:
+            parse_goal(sub, relations, clause, equalities)
+        return
+    if indicator == "!/0":
+        raise Untranslatable("uses a cut")
+    if indicator == "\\+/1":
+        negated, negated_args = goal_indicator(args[0])
+        if negated in NEGATED_COMPARISONS:
+            left, right = (parse_arg(arg) for arg in negated_args)
+            clause.comparisons.append(Comparison(NEGATED_COMPARISONS[negated], left, right))
+            return
+        if negated not in relations:
+            raise Untranslatable(f"negates {negated}, which is not a predicate of the knowledge base")
+        clause.literals.append(Literal(negated, [parse_arg(arg) for arg in negated_args], negated=True))
+        return
+    name = indicator.rsplit("/", 1)[0]
+    if name in CONTROL and len(args) == 2:
+        raise Untranslatable(CONTROL[name])
+    if indicator == "=/2":
+        equalities.append((parse_arg(args[0]), parse_arg(args[1])))
+        return
+    if name in COMPARISONS and len(args) == 2:
+        left, right = parse_arg(args[0]), parse_arg(args[1])
+        if COMPARISONS[name][1] and any(isinstance(a, Const) and a.kind not in NUMBER_KINDS for a in (left, right)):
+            raise Untranslatable(f"compares text arithmetically with {name}")
+        clause.comparisons.append(Comparison(name, left, right))
+        return
+    if indicator not in relations:
+        raise Untranslatable(
+            f"calls {indicator}, which is not a predicate of the knowledge base"
+            " (built-ins other than comparisons are not translated)"
+        )
+    clause.literals.append(Literal(indicator, [parse_arg(arg) for arg in args]))
+
+
+def solve_equalities(clause: Clause, equalities: list[tuple[Arg, Arg]]) -> None:
+    """Replace X by Y for every X = Y; raises Untranslatable for one that fails."""
+    binding: dict[str, Arg] = {}
+
+    def resolve(arg: Arg) -> Arg:
+        while isinstance(arg, Var) and arg.name in binding:
+            arg = binding[arg.name]
+        return arg
+
+    for left, right in equalities:
+        left, right = resolve(left), resolve(right)
+        if left == right:
+            continue
+        if isinstance(left, Var):
+            binding[left.name] = right
+        elif isinstance(right, Var):
+            binding[right.name] = left
+        else:
+            raise Untranslatable(f"never succeeds: {left.value!r} = {right.value!r}")
+    if not binding:
+        return
+    clause.head = [resolve(arg) for arg in clause.head]
+    for literal in clause.literals:
+        literal.args = [resolve(arg) for arg in literal.args]
+    for comparison in clause.comparisons:
+        comparison.left, comparison.right = resolve(comparison.left), resolve(comparison.right)
+
+
+def check_safety(clause: Clause) -> None:
+    bound = {arg.name for literal in clause.positive() for arg in literal.args if isinstance(arg, Var)}
+    counts = clause.occurrences()
+    if clause.is_fact:
+        if any(isinstance(arg, Var) for arg in clause.head):
+            raise Untranslatable("is a fact with variables")
+        return
+    for arg in clause.head:
+        if isinstance(arg, Var) and arg.name not in bound:
+            raise Untranslatable("has a head variable no positive goal binds")
+    for comparison in clause.comparisons:
+        if any(isinstance(arg, Var) and arg.name not in bound for arg in (comparison.left, comparison.right)):
+            raise Untranslatable(f"has a variable in {comparison.op} that no positive goal binds")
+    for literal in clause.literals:
+        if literal.negated and any(
+            isinstance(arg, Var) and arg.name not in bound and counts[arg.name] > 1 for arg in literal.args
+        ):
+            raise Untranslatable(f"negates {literal.relation} with a variable no positive goal binds")
+
+
+def parse_clause(relation: Relation, row: dict[str, Any], relations: dict[str, Relation]) -> Clause:
+    _, head_args = goal_indicator(row["head"])
+    clause = Clause(relation.indicator, [parse_arg(arg) for arg in head_args], text=row.get("text", ""))
+    equalities: list[tuple[Arg, Arg]] = []
+    parse_goal(row["body"], relations, clause, equalities)
+    solve_equalities(clause, equalities)
+    check_safety(clause)
+    return clause
+
+
+def components(graph: dict[str, set[str]]) -> list[list[str]]:
+    """Strongly connected components, each after the components it reaches."""
+    index: dict[str, int] = {}
+    low: dict[str, int] = {}
+    stack: list[str] = []
+    on_stack: set[str] = set()
+    result: list[list[str]] = []
+
+    def visit(node: str) -> None:
+        index[node] = low[node] = len(index)
+        stack.append(node)
+        on_stack.add(node)
+        for target in sorted(graph.get(node, ())):
+            if target not in index:
+                visit(target)
+                low[node] = min(low[node], low[target])
+            elif target in on_stack:
+                low[node] = min(low[node], index[target])
+        if low[node] == index[node]:
+            component = []
+            while True:
+                member = stack.pop()
+                on_stack.discard(member)
+                component.append(member)
+                if member == node:
+                    break
+            result.append(sorted(component))
+
+    for node in sorted(graph):
+        if node not in index:
+            visit(node)
+    return result
+
+
+def dependency_graph(relations: dict[str, Relation]) -> dict[str, set[str]]:
+    return {
+        indicator: {literal.relation for clause in relation.clauses for literal in clause.literals}
+        for indicator, relation in relations.items()
+    }
+
+
+def drop_clauses(relations: dict[str, Relation], skipped: list[Skipped], reason_of) -> None:
+    """Leave out the clauses reason_of(clause, component) gives a reason for."""
+    member_of = {}
+    for component in components(dependency_graph(relations)):
+        for indicator in component:
+            member_of[indicator] = set(component)
+    for relation in relations.values():
+        kept = []
+        for clause in relation.clauses:
+            reason = reason_of(clause, member_of[relation.indicator])
+            if reason:
+                skipped.append(Skipped(relation.indicator, clause.text, reason))
+            else:
+                kept.append(clause)
+        relation.clauses = kept
+
+
+def unstratified(clause: Clause, component: set[str]) -> str:
+    for literal in clause.literals:
+        if literal.negated and literal.relation == clause.relation:
+            return "negates its own predicate (negation is not stratified)"
+        if literal.negated and literal.relation in component:
+            return f"negates {literal.relation}, which depends on {clause.relation} (negation is not stratified)"
+    return ""
+
+
+def sql_recursion(relations: dict[str, Relation]):
+    """The reason_of of drop_clauses for what recursive SQL views cannot express."""
+    recursive_rules: dict[str, int] = {}
+
+    def reason_of(clause: Clause, component: set[str]) -> str:
+        mutual = sorted({literal.relation for literal in clause.literals
+                         if literal.relation in component and literal.relation != clause.relation})
+        if mutual:
+            return f"is mutually recursive with {', '.join(mutual)}, which SQL views cannot express"
+        calls = sum(1 for literal in clause.literals if literal.relation == clause.relation)
+        if calls > 1:
+            return "calls its own predicate more than once (non-linear recursion), which SQL views cannot express"
+        if calls:
+            recursive_rules[clause.relation] = recursive_rules.get(clause.relation, 0) + 1
+            if recursive_rules[clause.relation] > 1:
+                return "is a second recursive rule; a recursive SQL view takes one"
+        return ""
+
+    return reason_of
+
+
+def infer_types(relations: dict[str, Relation], warnings: list[str]) -> None:
+    """Set every relation's column types from the constants reaching them."""
+    parent: dict[Any, Any] = {}
+    kinds: dict[Any, set[str]] = {}
+
+    def find(key: Any) -> Any:
+        parent.setdefault(key, key)
+        while parent[key] != key:
+            parent[key] = parent[parent[key]]
+            key = parent[key]
+        return key
+
+    def union(a: Any, b: Any) -> None:
+        a, b = find(a), find(b)
+        if a != b:
+            parent[a] = b
+            kinds.setdefault(b, set()).update(kinds.pop(a, set()))
+
+    def mark(key: Any, kind: str) -> None:
+        kinds.setdefault(find(key), set()).add(kind)
+
+    def link(key: Any, arg: Arg, number: int, hint: str = "") -> None:
+        if isinstance(arg, Var):
+            union(("var", number, arg.name), key)
+            if hint:
+                mark(key, hint)
+        else:
+            mark(key, arg.kind)
+
+    number = 0
+    for relation in relations.values():
+        for i in range(relation.arity):
+            find(("column", relation.indicator, i))
+        for clause in relation.clauses:
+            number += 1
+            for i, arg in enumerate(clause.head):
+                link(("column", relation.indicator, i), arg, number)
+            for literal in clause.literals:
+                for i, arg in enumerate(literal.args):
+                    link(("column", literal.relation, i), arg, number)
+            for comparison in clause.comparisons:
+                hint = "integer" if COMPARISONS[comparison.op][1] else ""
+                left, right = comparison.left, comparison.right
+                if isinstance(left, Var):
+                    link(("var", number, left.name), right, number, hint)
+                elif isinstance(right, Var):
+                    link(("var", number, right.name), left, number, hint)
+
+    for relation in relations.values():
+        relation.types = []
+        for i in range(relation.arity):
+            found = kinds.get(find(("column", relation.indicator, i)), set())
+            if "symbol" in found:
+                if found & set(NUMBER_KINDS):
+                    warnings.append(
+                        f"{relation.indicator} argument {i + 1} mixes text and numbers; its numbers are written as text"
+                    )
+                relation.types.append("symbol")
+            else:
+                relation.types.append("float" if "float" in found else "integer" if found else "symbol")
+
+
+class Names:
+    """Identifiers of the target language, one per relation and never two alike."""
+
+    def __init__(self, relations: dict[str, Relation]) -> None:
+        self.used: set[str] = set()
+        self.names: dict[str, str] = {}
+        overloaded = {r.name for r in relations.values() if sum(o.name == r.name for o in relations.values()) > 1}
+        for indicator, relation in relations.items():
+            base = IDENTIFIER_RE.sub("_", relation.name) or "relation"
+            if not (base[0].isalpha() or base[0] == "_"):
+                base = f"r_{base}"
+            if relation.name in overloaded:
+                base = f"{base}_{relation.arity}"
+            self.names[indicator] = self.fresh(base)
+
+    def fresh(self, base: str) -> str:
+        name, n = base, 1
+        while name in self.used:
+            n += 1
+            name = f"{base}_{n}"
+        self.used.add(name)
+        return name
+
+    def __getitem__(self, indicator: str) -> str:
+        return self.names[indicator]
+
+
+def variable_names(clause: Clause) -> dict[str, str]:
+    """Readable variable names for a clause: A, B, ... in order of appearance; "_" for singletons."""
+    counts = clause.occurrences()
+    names: dict[str, str] = {}
+    order = [arg.name for arg in [*clause.head, *(a for lit in clause.literals for a in lit.args),
+                                  *(a for c in clause.comparisons for a in (c.left, c.right))]
+             if isinstance(arg, Var)]
+    for name in order:
+        if name in names:
+            continue
+        if counts[name] == 1:
+            names[name] = "_"
+            continue
+        n = sum(1 for given in names.values() if given != "_")
+        names[name] = chr(ord("A") + n % 26) + (str(n // 26) if n >= 26 else "")
+    return names
+
+
+def souffle_const(const: Const, kind: str) -> str:
+    if kind == "symbol":
+        text = str(const.value)
+        return '"' + text.replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n") + '"'
+    if kind == "float":
+        return repr(float(const.value))
+    return str(const.value)
+
+
+def souffle_arg(arg: Arg, kind: str, names: dict[str, str]) -> str:
+    return names[arg.name] if isinstance(arg, Var) else souffle_const(arg, kind)
+
+
+def comparison_kind(comparison: Comparison, kinds: dict[str, str]) -> str:
+    """Type a constant compared with is written as: the variable's, or its own."""
+    for arg in (comparison.left, comparison.right):
+        if isinstance(arg, Var) and arg.name in kinds:
+            return kinds[arg.name]
+    const = comparison.left if isinstance(comparison.left, Const) else comparison.right
+    return const.kind if isinstance(const, Const) else "symbol"
+
+
+def variable_kinds(clause: Clause, relations: dict[str, Relation]) -> dict[str, str]:
+    kinds: dict[str, str] = {}
+    for literal in clause.positive():
+        for arg, kind in zip(literal.args, relations[literal.relation].types):
+            if isinstance(arg, Var):
+                kinds.setdefault(arg.name, kind)
+    return kinds
+
+
+def souffle_clause(clause: Clause, relations: dict[str, Relation], names: Names) -> str:
+    variables = variable_names(clause)
+    kinds = variable_kinds(clause, relations)
+
+    def atom(indicator: str, args: list[Arg]) -> str:
+        types = relations[indicator].types
+        return f"{names[indicator]}({', '.join(souffle_arg(a, k, variables) for a, k in zip(args, types))})"
+
+    head = atom(clause.relation, clause.head)
+    if clause.is_fact:
+        return f"{head}."
+    goals = [("!" if literal.negated else "") + atom(literal.relation, literal.args) for literal in clause.literals]
+    for comparison in clause.comparisons:
+        kind = comparison_kind(comparison, kinds)
+        left = souffle_arg(comparison.left, kind, variables)
+        right = souffle_arg(comparison.right, kind, variables)
+        goals.append(f"{left} {COMPARISONS[comparison.op][0]} {right}")
+    return f"{head} :- {', '.join(goals)}."
+
+
+def comment_lines(prefix: str, text: str) -> list[str]:
+    return [f"{prefix} {line}" for line in text.splitlines() or [""]]
+
+
+def render_souffle(relations: dict[str, Relation], order: list[str], outputs: list[str],
+                   skipped: list[Skipped], module: str) -> str:
+    names = Names(relations)
+    lines = [f"// Soufflé Datalog translated from SWISH module {module} by docker-swish-mcp", ""]
+    for indicator in order:
+        relation = relations[indicator]
+        columns = ", ".join(f"a{i + 1}: {SOUFFLE_TYPES[kind]}" for i, kind in enumerate(relation.types))
+        lines.append(f".decl {names[indicator]}({columns})")
+    lines.extend(f".output {names[indicator]}" for indicator in outputs)
+    for indicator in order:
+        relation = relations[indicator]
+        if relation.clauses:
+            lines.append("")
+            lines.append(f"// {indicator}")
+            lines.extend(souffle_clause(clause, relations, names) for clause in relation.clauses)
+    if skipped:
+        lines.extend(["", "// Not translated:"])
+        for entry in skipped:
+            lines.extend(comment_lines("//", entry.text))
+            lines.append(f"//   ({entry.reason})")
+    return "\n".join(lines) + "\n"
+
+
+def sql_identifier(name: str) -> str:
+    return '"' + name.replace('"', '""') + '"'
+
+
+def sql_const(const: Const, kind: str) -> str:
+    if kind == "symbol":
+        return "'" + str(const.value).replace("'", "''") + "'"
+    if kind == "float":
+        return repr(float(const.value))
+    return str(const.value)
+
+
+def sql_columns(relation: Relation) -> list[str]:
+    # A relation without arguments is a table of one flag column, holding 1 when it is true
+    return [f"a{i + 1}" for i in range(relation.arity)] or ["holds"]
+
+
+def sql_select(clause: Clause, relations: dict[str, Relation], tables: dict[str, str]) -> str:
+    """The SELECT of a rule, reading every relation through tables."""
+    columns: dict[str, str] = {}
+    kinds = variable_kinds(clause, relations)
+    sources: list[str] = []
+    conditions: list[str] = []
+
+    def bind(literal: Literal, alias: str, where: list[str], local: dict[str, str]) -> None:
+        relation = relations[literal.relation]
+        for column, arg, kind in zip(sql_columns(relation), literal.args, relation.types):
+            ref = f"{alias}.{column}"
+            if isinstance(arg, Const):
+                where.append(f"{ref} = {sql_const(arg, kind)}")
+            elif arg.name in columns:
+                where.append(f"{ref} = {columns[arg.name]}")
+            elif arg.name in local:
+                where.append(f"{ref} = {local[arg.name]}")
+            else:
+                local[arg.name] = ref
+
+    for n, literal in enumerate(clause.positive(), 1):
+        alias = f"t{n}"
+        sources.append(f"{tables[literal.relation]} AS {alias}")
+        local: dict[str, str] = {}
+        bind(literal, alias, conditions, local)
+        columns.update(local)
+
+    for n, literal in enumerate(clause.literals, 1):
+        if not literal.negated:
+            continue
+        alias = f"n{n}"
+        where: list[str] = []
+        bind(literal, alias, where, {})
+        inner = f"SELECT 1 FROM {tables[literal.relation]} AS {alias}"
+        if where:
+            inner += " WHERE " + " AND ".join(where)
+        conditions.append(f"NOT EXISTS ({inner})")
+
+    for comparison in clause.comparisons:
+        kind = comparison_kind(comparison, kinds)
+        operands = [columns[a.name] if isinstance(a, Var) else sql_const(a, kind)
+                    for a in (comparison.left, comparison.right)]
+        operator = COMPARISONS[comparison.op][0].replace("!=", "<>")
+        conditions.append(f"{operands[0]} {operator} {operands[1]}")
+
+    relation = relations[clause.relation]
+    if relation.arity:
+        values = [columns[a.name] if isinstance(a, Var) else sql_const(a, kind)
+                  for a, kind in zip(clause.head, relation.types)]
+    else:
+        values = ["1"]
+    select = "SELECT " + ", ".join(f"{value} AS {column}" for value, column in zip(values, sql_columns(relation)))
+    if sources:
+        select += " FROM " + ", ".join(sources)
+    if conditions:
+        select += " WHERE " + " AND ".join(conditions)
+    return select
+
+
+def render_sql(relations: dict[str, Relation], order: list[str], skipped: list[Skipped], module: str) -> str:
+    names = Names(relations)
+    tables = {indicator: sql_identifier(names[indicator]) for indicator in order}
+    lines = [f"-- SQL translated from SWISH module {module} by docker-swish-mcp"]
+    for indicator in order:
+        relation = relations[indicator]
+        columns = sql_columns(relation)
+        types = [SQL_TYPES[kind] for kind in relation.types] or ["INTEGER"]
+        facts, rules = relation.facts, relation.rules
+        lines.extend(["", f"-- {indicator}"])
+        table = tables[indicator] if not rules else sql_identifier(names.fresh(f"{names[indicator]}_facts"))
+        if facts or not rules:
+            definition = ", ".join(f"{column} {kind}" for column, kind in zip(columns, types))
+            lines.append(f"CREATE TABLE {table} ({definition});")
+            for fact in facts:
+                values = [sql_const(arg, kind) for arg, kind in zip(fact.head, relation.types)] or ["1"]
+                lines.append(f"INSERT INTO {table} VALUES ({', '.join(values)});")
+        if not rules:
+            continue
+        recursive = [r for r in rules if any(lit.relation == indicator for lit in r.literals)]
+        parts = ([f"SELECT {', '.join(columns)} FROM {table}"] if facts else [])
+        parts.extend(sql_select(rule, relations, tables) for rule in rules if rule not in recursive)
+        if recursive and not parts:
+            # A recursive view needs a base query, which is empty here
+            empty = ", ".join(f"CAST(NULL AS {kind}) AS {column}" for column, kind in zip(columns, types))
+            parts.append(f"SELECT {empty} WHERE 1 = 0")
+        parts.extend(sql_select(rule, relations, tables) for rule in recursive)
+        view = "RECURSIVE VIEW" if recursive else "VIEW"
+        body = "\nUNION\n".join(parts)
+        lines.append(f"CREATE {view} {tables[indicator]} ({', '.join(columns)}) AS\n{body};")
+    if skipped:
+        lines.extend(["", "-- Not translated:"])
+        for entry in skipped:
+            lines.extend(comment_lines("--", entry.text))
+            lines.append(f"--   ({entry.reason})")
+    return "\n".join(lines) + "\n"
+
+
+def translate_rules(rows: list[dict[str, Any]], target: str, module: str, requested: list[str]) -> RuleExport:
+    """Translate mcp_rules_export/3 rows of the requested predicates (all when empty) and what they use."""
+    if target not in EXPORT_TARGETS:
+        raise ValueError(f"Unknown target '{target}'. Use one of: {', '.join(EXPORT_TARGETS)}")
+    relations = {
+        row["predicate"]: Relation(row["predicate"], row["name"], int(row["arity"]),
+                                   count=int(row.get("count", 0)), read=len(row.get("clauses", [])))
+        for row in rows
+    }
+    missing = [indicator for indicator in requested if indicator not in relations]
+    if missing:
+        raise ValueError(f"No predicate {', '.join(missing)} in the knowledge base")
+
+    skipped: list[Skipped] = []
+    for row in rows:
+        relation = relations[row["predicate"]]
+        for clause_row in row.get("clauses", []):
+            try:
+                relation.clauses.append(parse_clause(relation, clause_row, relations))
+            except Untranslatable as e:
+                skipped.append(Skipped(relation.indicator, clause_row.get("text", ""), str(e)))
+
+    if requested:
+        graph = dependency_graph(relations)
+        wanted: set[str] = set()
+        pending = list(requested)
+        while pending:
+            indicator = pending.pop()
+            if indicator not in wanted:
+                wanted.add(indicator)
+                pending.extend(graph[indicator])
+        relations = {indicator: r for indicator, r in relations.items() if indicator in wanted}
+        skipped = [entry for entry in skipped if entry.relation in wanted]
+
+    drop_clauses(relations, skipped, unstratified)
+    if target == "sql":
+        drop_clauses(relations, skipped, sql_recursion(relations))
+
+    warnings: list[str] = []
+    for relation in relations.values():
+        if relation.read < relation.count:
+            warnings.append(f"Only the first {relation.read} of the {relation.count} clauses of {relation.indicator} were read")
+    infer_types(relations, warnings)
+
+    graph = dependency_graph(relations)
+    order = [indicator for component in components(graph) for indicator in component]
+    incomplete = sorted({entry.relation for entry in skipped}
+                        | {r.indicator for r in relations.values() if r.read < r.count})
+    affected: set[str] = set()
+    changed = True
+    while changed:
+        changed = False
+        for indicator, called in graph.items():
+            if indicator not in affected and indicator not in incomplete and called & (affected | set(incomplete)):
+                affected.add(indicator)
+                changed = True
+
+    outputs = requested or [indicator for indicator in order if relations[indicator].rules]
+    if target == "souffle":
+        text = render_souffle(relations, order, outputs, skipped, module)
+    else:
+        text = render_sql(relations, order, skipped, module)
+    return RuleExport(
+        target=target,
+        module=module,
+        text=text,
+        relations=order,
+        translated=sum(len(r.clauses) for r in relations.values()),
+        skipped=skipped,
+        warnings=warnings,
+        incomplete=incomplete,
+        affected=sorted(affected),
+    )
+
+
+def rule_export_filename(filename: str, target: str) -> str:
+    suffix = TARGET_SUFFIXES[target]
+    return filename if filename.endswith(suffix) else filename + suffix
+
+
+def format_rule_export(export: RuleExport, written: str = "") -> str:
+    target = "Soufflé Datalog" if export.target == "souffle" else "SQL"
+    lines = [f"✅ Translated {export.translated} clause(s) of {len(export.relations)} predicate(s) to {target}"]
+    if written:
+        lines[0] += f", written to {written}"
+    if export.skipped:
+        lines.append(f"\n⚠️ {len(export.skipped)} clause(s) not translated:")
+        for entry in export.skipped:
+            clause = " ".join(entry.text.split())
+            lines.append(f"  • {clause}  ({entry.reason})")
+    if export.incomplete:
+        lines.append(f"\n🧩 Incomplete: {', '.join(export.incomplete)}")
+        if export.affected:
+            lines.append(f"   and so, through them: {', '.join(export.affected)}")
+    lines.extend(f"⚠️ {warning}" for warning in export.warnings)
+    if not written:
+        lines.extend(["", export.text.rstrip("\n")])
+    return "\n".join(lines)
@@ -0,0 +1,362 @@
+"""
+Container Runtime Backends for Docker SWISH MCP
+
+The server manages containers through a client exposing docker-py's
+surface (client.containers.get/list/run, client.images.get/pull/build, container
+status/reload/start/stop/remove/logs). Each runtime produces such a client:
+
+- docker:  the Docker Engine API (DOCKER_HOST etc.)
+- podman:  Podman's Docker-compatible API socket, with fully qualified
+           image names and SELinux relabelling of the data mount
+- nerdctl: containerd through the nerdctl CLI, which has no API socket;
+           archive-based snapshot of the container is not available
+
+Select one with SWISH_MCP_RUNTIME (default docker).
+"""
+
+import asyncio
+import json
+import logging
+import os
+import subprocess
+from abc import ABC, abstractmethod
+from collections.abc import Iterable, Iterator
+from pathlib import Path
+from typing import Any
+
+from .host_platform import connect_docker, is_windows
+
+logger = logging.getLogger("docker-swish-mcp.runtimes")
+
+SWISH_IMAGE = "swipl/swish:latest"
+
+
+class ContainerRuntimeError(Exception):
+    """Raised when a runtime cannot be reached or refuses an operation."""
+
+
+class ContainerRuntime(ABC):
+    """A container engine the SWISH container can run on."""
+
+    name: str = ""
+    # Image reference to pull and run
+    image: str = SWISH_IMAGE
+    # Mode string for the /data bind mount
+    volume_mode: str = "rw"
+
+    @abstractmethod
+    def connect(self) -> Any:
+        """Return a client with docker-py's containers/images API."""
+
+
+class DockerRuntime(ContainerRuntime):
+    """Docker Engine, configured by DOCKER_HOST / DOCKER_TLS_VERIFY / DOCKER_CERT_PATH, or found (see host_platform.py)."""
+
+    name = "docker"
+
+    def connect(self) -> Any:
+        return connect_docker()
+
+
+def podman_socket_candidates() -> list[str]:
+    """Podman API sockets to try, rootless first."""
+    candidates = []
+    for env in ("CONTAINER_HOST", "DOCKER_HOST"):
+        value = os.environ.get(env, "")
+        if value.startswith("unix://") and "podman" in value:
+            candidates.append(value)
+    if is_windows():
+        # Podman Desktop's default machine
+        candidates.append("npipe:////./pipe/podman-machine-default")
+        return candidates
+    runtime_dir = os.environ.get("XDG_RUNTIME_DIR") or f"/run/user/{os.getuid()}"
+    candidates.append(f"unix://{runtime_dir}/podman/podman.sock")
+    candidates.append("unix:///run/podman/podman.sock")
+    return candidates
+
+
+class PodmanRuntime(ContainerRuntime):
+    """
+    Podman through its Docker-compatible REST API.
+
+    Start the API with `systemctl --user enable --now podman.socket`, or
+    point SWISH_MCP_PODMAN_SOCKET at a custom unix:// socket.
+    """
+
+    name = "podman"
+    # Podman does not assume docker.io for short names
+    image = f"docker.io/{SWISH_IMAGE}"
+    # Relabel the data directory so SELinux (e.g. Fedora) allows access
+    volume_mode = "rw,Z"
+
+    def __init__(self, socket_url: str = ""):
+        self.socket_url = socket_url
+
+    def connect(self) -> Any:
+        import docker
+        candidates = [self.socket_url] if self.socket_url else podman_socket_candidates()
+        for url in candidates:
+            if url.startswith("unix://") and not Path(url[len("unix://"):]).exists():
+                continue
+            logger.info(f"Using Podman API at {url}")
+            return docker.DockerClient(base_url=url)
+        raise ContainerRuntimeError(
+            "No Podman API socket found. Run `systemctl --user enable --now podman.socket` "
+            "or set SWISH_MCP_PODMAN_SOCKET"
+        )
+
+
+class _NerdctlLogStream:
+    """Iterator over a following `nerdctl logs` process, closable like docker-py's."""
+
+    def __init__(self, process: subprocess.Popen, pipe: Any):
+        self._process = process
+        self._pipe = pipe
+
+    def __iter__(self) -> Iterator[bytes]:
+        yield from iter(self._pipe.readline, b"")
+
+    def close(self) -> None:
+        self._process.terminate()
+
+
+class NerdctlContainer:
+    """Subset of docker-py's Container backed by the nerdctl CLI."""
+
+    def __init__(self, client: "NerdctlClient", attrs: dict[str, Any]):
+        self.client = client
+        self.attrs = attrs
+
+    @property
+    def id(self) -> str:
+        return self.attrs.get("Id", "")
+
+    @property
+    def name(self) -> str:
+        return self.attrs.get("Name", "").lstrip("/")
+
+    @property
+    def status(self) -> str:
+        return self.attrs.get("State", {}).get("Status", "unknown")
+
+    @property
+    def ports(self) -> dict[str, Any]:
+        return self.attrs.get("NetworkSettings", {}).get("Ports") or {}
+
+    def reload(self) -> None:
+        self.attrs = self.client._inspect(self.id or self.name)
+
+    def start(self) -> None:
+        self.client._run(["start", self.id])
+
+    def stop(self, timeout: int = 10) -> None:
+        self.client._run(["stop", "-t", str(timeout), self.id])
+
+    def remove(self, force: bool = False) -> None:
+        self.client._run(["rm", *(["-f"] if force else []), self.id])
+
+    def logs(
+        self,
+        stdout: bool = True,
+        stderr: bool = True,
+        tail: int | str = "all",
+        timestamps: bool = False,
+        stream: bool = False,
+        follow: bool = False
+    ) -> Any:
+        args = ["logs", "--tail", str(tail)]
+        if timestamps:
+            args.append("--timestamps")
+        if follow:
+            args.append("--follow")
+        # nerdctl relays the container's stdout and stderr on its own, so a
+        # stream is selected by which pipe is read
+        if stdout and stderr:
+            pipes = {"stdout": subprocess.PIPE, "stderr": subprocess.STDOUT}
+        elif stdout:
+            pipes = {"stdout": subprocess.PIPE, "stderr": subprocess.DEVNULL}
+        else:
+            pipes = {"stdout": subprocess.DEVNULL, "stderr": subprocess.PIPE}
+        command = [self.client.binary, *args, self.id]
+        if stream:
+            process = subprocess.Popen(command, **pipes)
+            return _NerdctlLogStream(process, process.stdout if stdout else process.stderr)
+        result = subprocess.run(command, timeout=120, **pipes)
+        output = (result.stdout if stdout else result.stderr) or b""
+        if result.returncode != 0:
+            detail = output.decode(errors="replace").strip() or f"exit status {result.returncode}"
+            raise ContainerRuntimeError(f"nerdctl logs failed: {detail}")
+        return output
+
+    def stats(self, stream: bool = False) -> dict[str, Any]:
+        """The `nerdctl stats` summary row, with sizes as text such as "10MiB / 2GiB"."""
+        output = self.client._run(["stats", "--no-stream", "--format", "{{json .}}", self.id])
+        return json.loads(output.decode().strip().splitlines()[0])
+
+    def get_archive(self, path: str) -> Any:
+        raise ContainerRuntimeError("nerdctl has no archive API; snapshot the host data directory instead")
+
+    def put_archive(self, path: str, data: Any) -> bool:
+        raise ContainerRuntimeError("nerdctl has no archive API; restore into the host data directory instead")
+
+
+class _NerdctlContainers:
+    def __init__(self, client: "NerdctlClient"):
+        self.client = client
+
+    def get(self, name: str) -> NerdctlContainer:
+        return NerdctlContainer(self.client, self.client._inspect(name))
+
+    def list(self, all: bool = False) -> list[NerdctlContainer]:
+        output = self.client._run(["ps", *(["-a"] if all else []), "-q"]).decode()
+        return [self.get(container_id) for container_id in output.split()]
+
+    def run(
+        self,
+        image: str,
+        name: str = "",
+        ports: dict[str, int] | None = None,
+        volumes: dict[str, dict[str, str]] | None = None,
+        environment: dict[str, str] | None = None,
+        labels: dict[str, str] | None = None,
+        restart_policy: dict[str, str] | None = None,
+        detach: bool = True,
+        mem_limit: int = 0,
+        memswap_limit: int = 0,
+        nano_cpus: int = 0,
+        pids_limit: int = 0,
+        ulimits: Iterable[dict[str, Any]] = (),
+        platform: str = "",
+        **_ignored: Any
+    ) -> NerdctlContainer:
+        args = ["run", "-d"] if detach else ["run"]
+        if name:
+            args += ["--name", name]
+        if platform:
+            args += ["--platform", platform]
+        for container_port, host_port in (ports or {}).items():
+            args += ["-p", f"{host_port}:{container_port.split('/')[0]}"]
+        for source, bind in (volumes or {}).items():
+            args += ["-v", f"{source}:{bind['bind']}:{bind.get('mode', 'rw')}"]
+        for key, value in (environment or {}).items():
+            args += ["-e", f"{key}={value}"]
+        for key, value in (labels or {}).items():
+            args += ["--label", f"{key}={value}"]
+        if restart_policy:
+            args += ["--restart", restart_policy.get("Name", "no")]
+        if mem_limit:
+            args += ["--memory", str(mem_limit)]
+        if memswap_limit:
+            args += ["--memory-swap", str(memswap_limit)]
+        if nano_cpus:
+            args += ["--cpus", f"{nano_cpus / 1e9:g}"]
+        if pids_limit:
+            args += ["--pids-limit", str(pids_limit)]
+        for limit in ulimits:
+            args += ["--ulimit", f"{limit['Name']}={limit['Soft']}:{limit['Hard']}"]
+        container_id = self.client._run([*args, image]).decode().strip()
+        return self.get(container_id)
+
+
+class _NerdctlImages:
+    def __init__(self, client: "NerdctlClient"):
+        self.client = client
+
+    def pull(self, repository: str, platform: str = "") -> None:
+        self.client._run(["pull", "--quiet", *(["--platform", platform] if platform else []), repository], timeout=600)
+
+    def get(self, name: str) -> dict[str, Any]:
+        return json.loads(self.client._run(["image", "inspect", name]))[0]
+
+    def build(
+        self,
+        path: str,
+        dockerfile: str = "Dockerfile",
+        tag: str = "",
+        pull: bool = False,
+        nocache: bool = False,
+        platform: str = "",
+        **_ignored: Any
+    ) -> tuple[None, list[dict[str, str]]]:
+        """Build with `nerdctl build` (needs buildkitd), returning the log as docker-py does."""
+        args = ["build", "-f", str(Path(path) / dockerfile)]
+        if tag:
+            args += ["-t", tag]
+        if pull:
+            args.append("--pull")
+        if nocache:
+            args.append("--no-cache")
+        if platform:
+            args += ["--platform", platform]
+        output = self.client._run([*args, path], timeout=1800, stderr_to_stdout=True).decode(errors="replace")
+        return None, [{"stream": line} for line in output.splitlines()]
+
+
+class NerdctlClient:
+    """docker-py lookalike client driving the nerdctl CLI."""
+
+    def __init__(self, binary: str = "nerdctl"):
+        self.binary = binary
+        self.containers = _NerdctlContainers(self)
+        self.images = _NerdctlImages(self)
+
+    def _run(self, args: list[str], timeout: float = 120, stderr_to_stdout: bool = False) -> bytes:
+        result = subprocess.run(
+            [self.binary, *args],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.STDOUT if stderr_to_stdout else subprocess.PIPE,
+            timeout=timeout
+        )
+        if result.returncode != 0:
+            detail = (result.stderr or result.stdout or b"").decode(errors="replace").strip()
+            raise ContainerRuntimeError(f"nerdctl {args[0]} failed: {detail}")
+        return result.stdout
+
+    def _inspect(self, name: str) -> dict[str, Any]:
+        data = json.loads(self._run(["container", "inspect", "--mode", "dockercompat", name]))
+        if not data:
+            raise ContainerRuntimeError(f"Container {name} not found")
+        return data[0]
+
+    def ping(self) -> bool:
+        self._run(["version"], timeout=10)
+        return True
+
+    def info(self) -> dict[str, Any]:
+        return json.loads(self._run(["info", "--format", "{{json .}}"], timeout=10))
+
+    async def open_exec(self, container_name: str, cmd: list[str], stdin: bool = True) -> Any:
+        """Start cmd with `nerdctl exec`; asyncio's Process has the interface the session needs."""
+        return await asyncio.create_subprocess_exec(
+            self.binary, "exec", *(["-i"] if stdin else []), container_name, *cmd,
+            stdin=asyncio.subprocess.PIPE if stdin else asyncio.subprocess.DEVNULL,
+            stdout=asyncio.subprocess.PIPE,
+            stderr=asyncio.subprocess.PIPE
+        )
+
+
+class NerdctlRuntime(ContainerRuntime):
+    """containerd via nerdctl (rootless or rootful, whichever nerdctl is set up for)."""
+
+    name = "nerdctl"
+    image = f"docker.io/{SWISH_IMAGE}"
+
+    def __init__(self, binary: str = "nerdctl"):
+        self.binary = binary
+
+    def connect(self) -> Any:
+        return NerdctlClient(self.binary)
+
+
+RUNTIMES = ("docker", "podman", "nerdctl")
+
+
+def get_runtime(name: str, podman_socket: str = "") -> ContainerRuntime:
+    """Build the runtime selected by name."""
+    if name == "docker":
+        return DockerRuntime()
+    if name == "podman":
+        return PodmanRuntime(podman_socket)
+    if name == "nerdctl":
+        return NerdctlRuntime()
+    raise ValueError(f"Unknown container runtime '{name}'. Use one of: {', '.join(RUNTIMES)}")
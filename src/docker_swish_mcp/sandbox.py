@@ -0,0 +1,463 @@
+"""
+Sandbox Policy for Untrusted Queries
+
+Screens queries and program text for side-effecting predicates (shell
+access, database mutation, file I/O, process control) before they reach
+the container. Two modes are enforced:
+
+- readonly: static check; offending goals are rejected with a reason.
+            Loading files counts as file I/O, as a file's directives run
+            when it loads; only consulting a data directory file by its
+            relative name (DATA_CONSULT_RE) passes.
+- strict:   the static check, plus the goal is rewritten to pass SWI-Prolog's
+            library(sandbox) safe_goal/1 before it runs. This catches goals
+            built at runtime (e.g. atom_concat + call) that no static scan
+            can see.
+
+Policies are chosen per client: SWISH_MCP_SANDBOX sets the default mode,
+SWISH_MCP_SANDBOX_ALLOW a comma-separated allowlist (name or name/arity),
+and SWISH_MCP_SANDBOX_CLIENTS maps API key ids to their own policy,
+either as inline JSON or a path to a JSON file (without keys, clients
+are only told apart by their session, so they all get the default):
+
+    {"agent-1": {"mode": "strict", "allow": ["format/2"], "modules": ["scratch"]}}
+
+Database predicates (assert/retract/...) are allowed when their clause is
+qualified with one of the policy's modules, e.g. assertz(scratch:seen(x)).
+The allowlist and modules relax only the static check; in strict mode
+safe_goal/1 still applies SWI-Prolog's own notion of a safe goal.
+
+A policy may also carry an access control list, in any mode: "call",
+"assert" and "retract" list the predicates the client may call, assert
+to and retract from, as glob patterns on Module:Name/Arity ("user:*",
+"*:counter/1", "parent/2" for any module; a missing key allows all, an
+empty list none). A client that may only query a rule base:
+
+    {"reader": {"call": ["user:*", "system:*", "lists:*"], "assert": [], "retract": []}}
+
+Goals are prefixed with mcp_acl_check/2, which reads the goal before it
+runs and checks the predicates it calls directly, through control
+constructs and meta-predicate arguments (not those its callees call),
+against the module defining each, so "user:*" covers the knowledge base
+that client modules inherit. Goals whose target only shows when they
+run are refused where their action is restricted: erase/1 as a retract,
+the recorded database as an assert, and loading a file as both.
+"""
+
+import json
+import logging
+import os
+import re
+from dataclasses import dataclass, field, replace
+from fnmatch import fnmatchcase
+from pathlib import Path
+
+logger = logging.getLogger("docker-swish-mcp.sandbox")
+
+SANDBOX_MODES = ("off", "readonly", "strict")
+
+# name -> (category, denied arities; None means every arity)
+DENIED_PREDICATES: dict[str, tuple[str, tuple[int, ...] | None]] = {
+    # Escaping to the operating system
+    "shell": ("shell", None),
+    "process_create": ("shell", None),
+    "process_kill": ("shell", None),
+    "win_exec": ("shell", None),
+    "win_shell": ("shell", None),
+    "halt": ("process", None),
+    "setenv": ("process", None),
+    "unsetenv": ("process", None),
+    "set_prolog_flag": ("process", None),
+    "qsave_program": ("process", None),
+    "thread_create": ("process", None),
+    # Mutating the database
+    "assert": ("database", None),
+    "asserta": ("database", None),
+    "assertz": ("database", None),
+    "retract": ("database", None),
+    "retractall": ("database", None),
+    "abolish": ("database", None),
+    "erase": ("database", None),
+    "recorda": ("database", None),
+    "recordz": ("database", None),
+    "op": ("database", (3,)),
+    # File and stream I/O
+    "open": ("file", None),
+    "see": ("file", (1,)),
+    "tell": ("file", (1,)),
+    "append": ("file", (1,)),
+    "delete_file": ("file", None),
+    "rename_file": ("file", None),
+    "copy_file": ("file", None),
+    "make_directory": ("file", None),
+    "make_directory_path": ("file", None),
+    "delete_directory": ("file", None),
+    "chdir": ("file", None),
+    "working_directory": ("file", None),
+    "tmp_file_stream": ("file", None),
+    "open_shared_object": ("file", None),
+    # Loading files runs their directives
+    "consult": ("file", None),
+    "load_files": ("file", None),
+    "ensure_loaded": ("file", None),
+    "include": ("file", None),
+    "use_module": ("file", None),
+    "reexport": ("file", None),
+    "use_foreign_library": ("file", None),
+}
+# Loading predicates that may still load a library: use_module(library(lists))
+LIBRARY_LOADS = frozenset({"consult", "load_files", "ensure_loaded", "use_module", "reexport"})
+FILE_CATEGORY = "file"
+
+DATABASE_CATEGORY = "database"
+
+# consult/1 of a data directory file by a relative name, as issued by
+# load_knowledge_base and project_consult. safe_goal/1 refuses consults, so
+# strict mode vets the file's directives with mcp_consult_vet/1 instead.
+DATA_CONSULT_RE = re.compile(r"^consult\((?P<file>'?[A-Za-z0-9_-][A-Za-z0-9_/-]*'?)\)$")
+
+TOKEN_RE = re.compile(
+    r"""
+      (?P<comment>%[^\n]*|/\*.*?\*/)
+    | (?P<string>"(?:[^"\\]|\\.)*"|`(?:[^`\\]|\\.)*`)
+    | (?P<char>0'(?:\\.|''|.))
+    | (?P<qatom>'(?:[^'\\]|\\.|'')*')
+    | (?P<name>[a-z][A-Za-z0-9_]*)
+    | (?P<var>[A-Z_][A-Za-z0-9_]*)
+    | (?P<number>\d[\d_]*(?:\.\d+)?(?:[eE][+-]?\d+)?)
+    | (?P<punct>[()\[\]{},|;])
+    | (?P<symbol>[-+*/\\^<>=~:.?@#&$]+)
+    | (?P<space>\s+)
+    """,
+    re.VERBOSE | re.DOTALL,
+)
+
+
+# Characters an ACL pattern may not contain, so it can be written as a quoted atom
+ACL_PATTERN_RE = re.compile(r"^[^'\"\\\s]+$")
+
+
+class SandboxViolation(Exception):
+    """Raised when a query or program uses predicates the policy denies."""
+
+    def __init__(self, violations: list[str]):
+        self.violations = violations
+        super().__init__("Sandbox policy rejected " + ", ".join(violations))
+
+
+def acl_pattern(pattern: str) -> str:
+    """pattern as a full Module:Name/Arity glob: "parent/2" is "*:parent/2", "user:p" is "user:p/*"."""
+    pattern = pattern.strip()
+    if not ACL_PATTERN_RE.match(pattern):
+        raise ValueError(f"Invalid predicate pattern {pattern!r}; use Module:Name/Arity globs such as \"user:*\"")
+    if ":" not in pattern:
+        pattern = f"*:{pattern}"
+    if "/" not in pattern.partition(":")[2]:
+        pattern = f"{pattern}/*"
+    return pattern
+
+
+@dataclass(frozen=True)
+class PredicateAcl:
+    """Patterns of the predicates a client may call, assert to and retract from; None allows every one."""
+    calls: tuple[str, ...] | None = None
+    asserts: tuple[str, ...] | None = None
+    retracts: tuple[str, ...] | None = None
+
+    @property
+    def restricted(self) -> bool:
+        return any(patterns is not None for patterns in (self.calls, self.asserts, self.retracts))
+
+    def allows(self, action: str, predicate: str) -> bool:
+        """Whether action ("call", "assert" or "retract") may touch Module:Name/Arity predicate."""
+        patterns = {"call": self.calls, "assert": self.asserts, "retract": self.retracts}[action]
+        return patterns is None or any(fnmatchcase(predicate, pattern) for pattern in patterns)
+
+    def to_prolog(self) -> str:
+        """The acl/3 term mcp_acl_check/2 takes."""
+        def term(patterns: tuple[str, ...] | None) -> str:
+            if patterns is None:
+                return "any"
+            return "[" + ", ".join(f"'{pattern}'" for pattern in patterns) + "]"
+        return f"acl({term(self.calls)}, {term(self.asserts)}, {term(self.retracts)})"
+
+    def describe(self) -> str:
+        parts = []
+        for action, patterns in (("call", self.calls), ("assert", self.asserts), ("retract", self.retracts)):
+            if patterns is not None:
+                parts.append(f"{action}: {', '.join(patterns) or 'nothing'}")
+        return "; ".join(parts) or "unrestricted"
+
+
+@dataclass(frozen=True)
+class SandboxPolicy:
+    """What one client may run."""
+    mode: str = "off"
+    allow: frozenset[str] = field(default_factory=frozenset)
+    modules: frozenset[str] = field(default_factory=frozenset)
+    acl: PredicateAcl = field(default_factory=PredicateAcl)
+
+    @property
+    def enabled(self) -> bool:
+        return self.mode != "off"
+
+    @property
+    def checks_goals(self) -> bool:
+        """Whether goals must go through apply_policy: a sandbox mode or an access control list."""
+        return self.enabled or self.acl.restricted
+
+    def allows(self, name: str, arity: int) -> bool:
+        return name in self.allow or f"{name}/{arity}" in self.allow
+
+
+@dataclass
+class _Token:
+    kind: str
+    text: str
+
+
+def _tokenize(text: str) -> list[_Token]:
+    tokens = []
+    for match in TOKEN_RE.finditer(text):
+        kind = match.lastgroup
+        if kind in ("comment", "space"):
+            continue
+        value = match.group()
+        if kind == "qatom":
+            kind, value = "name", value[1:-1].replace("''", "'")
+        tokens.append(_Token(kind, value))
+    return tokens
+
+
+def _call_arity(tokens: list[_Token], open_index: int) -> int:
+    """Count the arguments of a call whose '(' is at open_index."""
+    depth, arity = 0, 1
+    for token in tokens[open_index:]:
+        if token.text in ("(", "[", "{"):
+            depth += 1
+        elif token.text in (")", "]", "}"):
+            depth -= 1
+            if depth == 0:
+                return arity
+        elif token.text == "," and depth == 1:
+            arity += 1
+    return arity
+
+
+def _qualifier(tokens: list[_Token], index: int) -> str | None:
+    """Module name in a Module:Goal prefix ending just before index."""
+    if index >= 2 and tokens[index - 1].text == ":" and tokens[index - 2].kind == "name":
+        return tokens[index - 2].text
+    return None
+
+
+def _first_arg_module(tokens: list[_Token], open_index: int) -> str | None:
+    """Module of a Module:Clause first argument, as in assertz(m:fact)."""
+    if (
+        open_index + 2 < len(tokens)
+        and tokens[open_index + 1].kind == "name"
+        and tokens[open_index + 2].text == ":"
+    ):
+        return tokens[open_index + 1].text
+    return None
+
+
+def data_consult(text: str) -> re.Match[str] | None:
+    """The match if text is nothing but a consult of a data directory file by its relative name."""
+    return DATA_CONSULT_RE.match(text.strip().removesuffix(".").rstrip())
+
+
+def _list_goals(tokens: list[_Token]) -> list[int]:
+    """Indexes of the '[' tokens that open a list in goal position, i.e. [File] consults."""
+    found = []
+    # One entry per open bracket: whether it holds goals (a bare parenthesis) or arguments
+    goals: list[bool] = []
+    # Lists in the body of a grammar rule are terminals
+    grammar = False
+    for i, token in enumerate(tokens):
+        previous = tokens[i - 1] if i else None
+        if token.text in ("-->", "."):
+            grammar = token.text == "-->"
+        if token.text == "[" and not grammar and (
+            previous is None
+            or previous.text in (":-", "?-", "->", "*->", "\\+", ";")
+            or (previous.text in (",", "(") and (not goals or goals[-1]))
+        ):
+            found.append(i)
+        if token.text == "(":
+            goals.append(previous is None or previous.kind != "name")
+        elif token.text in ("[", "{"):
+            goals.append(token.text == "{")
+        elif token.text in (")", "]", "}") and goals:
+            goals.pop()
+    return found
+
+
+def _library_load(tokens: list[_Token], open_index: int) -> bool:
+    """Whether a loading call whose '(' is at open_index loads a library."""
+    return (
+        open_index + 2 < len(tokens)
+        and tokens[open_index + 1].text == "library"
+        and tokens[open_index + 2].text == "("
+    )
+
+
+def find_violations(text: str, policy: SandboxPolicy) -> list[str]:
+    """
+    List the denied predicates used in a query or program text.
+
+    Any occurrence counts, not only goal positions, so passing a denied
+    name to call/N or maplist/N is caught as well. A text that only
+    consults a data directory file by its relative name passes.
+    """
+    if data_consult(text):
+        return []
+    tokens = _tokenize(text)
+    found: list[str] = []
+    if _list_goals(tokens) and not policy.allows("consult", 1):
+        found.append(f"[File] consult ({FILE_CATEGORY})")
+    for i, token in enumerate(tokens):
+        if token.kind != "name" or token.text not in DENIED_PREDICATES:
+            continue
+        category, arities = DENIED_PREDICATES[token.text]
+        is_call = i + 1 < len(tokens) and tokens[i + 1].text == "("
+        arity = _call_arity(tokens, i + 1) if is_call else 0
+        if arities is not None and (not is_call or arity not in arities):
+            continue
+        if token.text in LIBRARY_LOADS and is_call and _library_load(tokens, i + 1):
+            continue
+        if policy.allows(token.text, arity):
+            continue
+        if category == DATABASE_CATEGORY and policy.modules:
+            module = _first_arg_module(tokens, i + 1) if is_call else None
+            if module in policy.modules or _qualifier(tokens, i) in policy.modules:
+                continue
+        label = f"{token.text}/{arity} ({category})" if is_call else f"{token.text} ({category})"
+        if label not in found:
+            found.append(label)
+    return found
+
+
+def uses_category(text: str, category: str) -> bool:
+    """Whether text calls any denied predicate of the given category."""
+    suffix = f"({category})"
+    return any(v.endswith(suffix) for v in find_violations(text, SandboxPolicy(mode="readonly")))
+
+
+def check_text(text: str, policy: SandboxPolicy) -> None:
+    """Raise SandboxViolation if text uses denied predicates."""
+    if not policy.enabled:
+        return
+    violations = find_violations(text, policy)
+    if violations:
+        raise SandboxViolation(violations)
+
+
+def apply_policy(goal: str, policy: SandboxPolicy) -> str:
+    """
+    Check a goal (without the trailing '.') and return the text to run.
+
+    In strict mode the goal is wrapped so safe_goal/1 vets it first; it
+    repeats the goal text so variable bindings are reported as usual.
+    """
+    check_text(goal, policy)
+    guard = policy_guard(goal, policy)
+    return goal if guard == "true" else f"({guard}, ({goal}))"
+
+
+def policy_guard(goal: str, policy: SandboxPolicy) -> str:
+    """The goal vetting goal when it runs under policy, "true" if nothing is vetted at run time."""
+    checks = []
+    if policy.acl.restricted:
+        checks.append(f"mcp_acl_check({policy.acl.to_prolog()}, ({goal}))")
+    if policy.mode == "strict":
+        consult = data_consult(goal)
+        if consult:
+            checks.append(f"mcp_consult_vet({consult.group('file')})")
+        else:
+            checks.append(f"use_module(library(sandbox)), safe_goal(({goal}))")
+    return ", ".join(checks) or "true"
+
+
+def require_unrestricted(policy: SandboxPolicy, action: str, what: str) -> None:
+    """Raise SandboxViolation if the policy's access control list restricts action, for what cannot be checked."""
+    patterns = {"call": policy.acl.calls, "assert": policy.acl.asserts, "retract": policy.acl.retracts}[action]
+    if patterns is not None:
+        raise SandboxViolation([f"{what} (the access control list restricts {action})"])
+
+
+def _parse_acl(raw: dict, default: PredicateAcl) -> PredicateAcl:
+    lists = {}
+    for key in ("call", "assert", "retract"):
+        value = raw.get(key)
+        if value is not None and (not isinstance(value, list) or not all(isinstance(item, str) for item in value)):
+            raise ValueError(f"Sandbox {key} must be a list of predicate patterns")
+        lists[key] = tuple(acl_pattern(item) for item in value) if value is not None else None
+    return PredicateAcl(
+        calls=lists["call"] if "call" in raw else default.calls,
+        asserts=lists["assert"] if "assert" in raw else default.asserts,
+        retracts=lists["retract"] if "retract" in raw else default.retracts,
+    )
+
+
+def _parse_entry(raw: dict, default: SandboxPolicy) -> SandboxPolicy:
+    mode = raw.get("mode", default.mode)
+    if mode not in SANDBOX_MODES:
+        raise ValueError(f"Unknown sandbox mode '{mode}'")
+    return replace(
+        default,
+        mode=mode,
+        allow=frozenset(raw.get("allow", default.allow)),
+        modules=frozenset(raw.get("modules", default.modules)),
+        acl=_parse_acl(raw, default.acl),
+    )
+
+
+@dataclass
+class SandboxConfig:
+    """Default policy plus per-client overrides."""
+    default: SandboxPolicy = field(default_factory=SandboxPolicy)
+    clients: dict[str, SandboxPolicy] = field(default_factory=dict)
+
+    def policy_for(self, client_id: str) -> SandboxPolicy:
+        return self.clients.get(client_id, self.default)
+
+    def with_settings(self, raw: dict) -> "SandboxConfig":
+        """Copy with the policies of a config file's [sandbox] table."""
+        for key in ("allow", "modules"):
+            if not isinstance(raw.get(key, []), list):
+                raise ValueError(f"sandbox.{key} must be a list")
+        default = _parse_entry(raw, self.default)
+        clients = raw.get("clients", {})
+        if not isinstance(clients, dict):
+            raise ValueError("sandbox.clients must map client ids to policies")
+        config = SandboxConfig(default=default, clients=dict(self.clients))
+        for client_id, entry in clients.items():
+            if not isinstance(entry, dict):
+                raise ValueError(f"Sandbox policy of client '{client_id}' must be a table")
+            config.clients[client_id] = _parse_entry(entry, default)
+        return config
+
+    @classmethod
+    def from_env(cls) -> "SandboxConfig":
+        mode = os.environ.get("SWISH_MCP_SANDBOX", "off").strip() or "off"
+        if mode not in SANDBOX_MODES:
+            logger.warning(f"Ignoring unknown SWISH_MCP_SANDBOX={mode!r}, sandbox is off")
+            mode = "off"
+        allow = frozenset(
+            item.strip() for item in os.environ.get("SWISH_MCP_SANDBOX_ALLOW", "").split(",") if item.strip()
+        )
+        modules = frozenset(
+            item.strip() for item in os.environ.get("SWISH_MCP_SANDBOX_MODULES", "").split(",") if item.strip()
+        )
+        config = cls(default=SandboxPolicy(mode=mode, allow=allow, modules=modules))
+
+        source = os.environ.get("SWISH_MCP_SANDBOX_CLIENTS", "").strip()
+        if source:
+            try:
+                text = source if source.startswith("{") else Path(source).read_text(encoding="utf-8")
+                for client_id, raw in json.loads(text).items():
+                    config.clients[client_id] = _parse_entry(raw, config.default)
+            except (OSError, ValueError, AttributeError) as e:
+                logger.error(f"Invalid SWISH_MCP_SANDBOX_CLIENTS: {e}")
+        return config
@@ -0,0 +1,113 @@
+"""
+Answer Set Programming for Docker SWISH MCP
+
+With SWISH_MCP_SCASP=on the server installs the scasp pack in the
+container when it starts and enables scasp_query. Programs are normal
+Prolog syntax read as s(CASP) rules, where not/1 is negation as failure
+under the stable model semantics, -p is classical negation and even
+loops over negation describe alternative models:
+
+    p(X) :- not q(X).
+    q(X) :- not p(X).
+
+Unlike the persistent session, s(CASP) answers a query with variables
+without grounding the program, so an answer can leave constraints on
+its variables (X \\= a). Each answer comes with its partial stable model
+and the justification tree: the chain of rules and negated goals that
+supports it, in English, for an assistant to ground its explanation in.
+
+Each query runs in a fresh swipl process, like probabilistic_query, so
+nothing reaches the persistent session. mcp_scasp/4 (see
+mcp_helpers.pl) emits one row per answer.
+"""
+
+import json
+from dataclasses import dataclass, field
+from typing import Any
+
+from .config import QueryLimits
+from .data_export import term_text
+from .simple_session import HELPERS_PATH, MARKER_RE, prolog_string
+
+SCASP_PACK = "scasp"
+
+# Query id of the answer rows in the process output
+SCASP_ID = "scasp"
+
+# Answers computed for one query at most
+MAX_MODELS = 20
+
+
+@dataclass
+class ScaspAnswer:
+    bindings: dict[str, Any]
+    constraints: list[str] = field(default_factory=list)
+    model: list[str] = field(default_factory=list)
+    justification: str = ""
+
+    def to_json(self) -> dict[str, Any]:
+        return {
+            "bindings": self.bindings,
+            "constraints": self.constraints,
+            "model": self.model,
+            "justification": self.justification,
+        }
+
+
+def scasp_program(program: str) -> str:
+    """s(CASP), the helpers (mcp_scasp/4 needs scasp/2 loaded) and program."""
+    return "\n".join([
+        ":- use_module(library(scasp)).",
+        ":- use_module(library(scasp/human)).",
+        HELPERS_PATH.read_text(encoding="utf-8"),
+        program,
+        "",
+    ])
+
+
+def scasp_goal(query: str, max_models: int, limits: QueryLimits) -> str:
+    return f"mcp_scasp({SCASP_ID}, {prolog_string(query)}, {max_models}, {limits.to_prolog()})"
+
+
+def parse_scasp_output(stdout: str) -> list[ScaspAnswer]:
+    """Answers in the order s(CASP) found them; raises RuntimeError if the query failed."""
+    answers = []
+    finished = False
+    for line in stdout.splitlines():
+        match = MARKER_RE.search(line)
+        if match is None or match.group(1) != SCASP_ID:
+            continue
+        kind, payload = match.group(2), match.group(3) or ""
+        if kind == "ERROR":
+            raise RuntimeError(payload)
+        if kind == "SOLUTION":
+            row = json.loads(payload)
+            answers.append(ScaspAnswer(
+                bindings=row.get("bindings", {}),
+                constraints=row.get("constraints", []),
+                model=row.get("model", []),
+                justification=row.get("justification", ""),
+            ))
+        elif kind == "END":
+            finished = True
+    if not finished:
+        raise RuntimeError("the s(CASP) process exited before reporting")
+    return answers
+
+
+def format_answers(query: str, answers: list[ScaspAnswer], show_model: bool) -> str:
+    if not answers:
+        return f"❌ Query: {query}\n📋 No stable model supports the query"
+    lines = [f"🧩 Query: {query}", f"📋 {len(answers)} answer(s):"]
+    for number, answer in enumerate(answers, 1):
+        # Unbound variables are described by the constraints, if at all
+        bindings = [
+            f"{name} = {term_text(value)}" for name, value in answer.bindings.items() if value.get("type") != "var"
+        ]
+        lines.append(f"\n  Answer {number}: " + (", ".join(bindings + answer.constraints) or "true"))
+        if show_model and answer.model:
+            lines.append("  Model: { " + ", ".join(answer.model) + " }")
+        if answer.justification.strip():
+            lines.append("  Justification:")
+            lines.extend(f"    {text}" for text in answer.justification.rstrip().splitlines())
+    return "\n".join(lines)
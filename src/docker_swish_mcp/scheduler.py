@@ -0,0 +1,386 @@
+"""
+Scheduled Queries for Docker SWISH MCP
+
+schedule_query registers a goal with a cron expression; the scheduler runs
+it in the persistent session when it is due and keeps the most recent
+results. Each job is published as a swish://jobs/<id> resource, and its
+subscribers get resources/updated whenever a run's solutions (or error)
+differ from the previous run's, so a client can watch a rule over a
+changing fact base instead of polling it.
+
+Cron expressions have the usual five fields (minute, hour, day of month,
+month, day of week) with *, ranges, steps and lists, three-letter month
+and day names, and the @hourly, @daily, @weekly, @monthly and @yearly
+shorthands. They are evaluated in the server's local time.
+
+Jobs and their recent runs are saved next to the data directory, like the
+audit log, so they survive restarts.
+"""
+
+import asyncio
+import json
+import logging
+import time
+import uuid
+from collections.abc import Awaitable, Callable
+from dataclasses import asdict, dataclass, field
+from datetime import datetime, timedelta
+from pathlib import Path
+from typing import Any
+
+from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp.resources import FunctionResource
+
+logger = logging.getLogger("docker-swish-mcp.scheduler")
+
+JOBS_URI_PREFIX = "swish://jobs/"
+MAX_JOBS = 50
+# Runs kept per job
+RUN_HISTORY = 20
+# Seconds between checks for due jobs
+CHECK_INTERVAL = 15.0
+
+CRON_ALIASES = {
+    "@hourly": "0 * * * *",
+    "@daily": "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly": "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+    "@yearly": "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+}
+MONTH_NAMES = ["jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"]
+DAY_NAMES = ["sun", "mon", "tue", "wed", "thu", "fri", "sat"]
+# Give up looking for the next run after this many days (e.g. "0 0 30 2 *")
+MAX_LOOKAHEAD_DAYS = 366 * 5
+
+
+def jobs_path(data_dir: Path) -> Path:
+    """Job file for a data directory, kept outside the mount like the audit log."""
+    return data_dir.parent / "swish-jobs" / f"{data_dir.name}.json"
+
+
+def job_uri(job_id: str) -> str:
+    return f"{JOBS_URI_PREFIX}{job_id}"
+
+
+def _cron_value(text: str, low: int, names: list[str] | None) -> int:
+    if names and text.lower() in names:
+        return names.index(text.lower()) + low
+    if not text.isdigit():
+        raise ValueError(f"'{text}' is not a number")
+    return int(text)
+
+
+def parse_cron_field(text: str, low: int, high: int, names: list[str] | None = None) -> frozenset[int]:
+    """Values a cron field matches; raises ValueError for malformed fields."""
+    values: set[int] = set()
+    for part in text.split(","):
+        base, _, step_text = part.partition("/")
+        step = int(step_text) if step_text.isdigit() else 0
+        if step_text and step <= 0:
+            raise ValueError(f"Invalid step in '{part}'")
+        if base == "*":
+            start, end = low, high
+        elif "-" in base:
+            first, _, last = base.partition("-")
+            start, end = _cron_value(first, low, names), _cron_value(last, low, names)
+        else:
+            start = _cron_value(base, low, names)
+            end = high if step_text else start
+        if not low <= start <= end <= high:
+            raise ValueError(f"'{part}' is outside {low}-{high}")
+        values.update(range(start, end + 1, step or 1))
+    return frozenset(values)
+
+
+@dataclass(frozen=True)
+class CronSchedule:
+    """A parsed five-field cron expression."""
+    expression: str
+    minutes: frozenset[int]
+    hours: frozenset[int]
+    days: frozenset[int]
+    months: frozenset[int]
+    weekdays: frozenset[int]
+    # As in cron, a restricted day of month and day of week match either
+    days_restricted: bool
+    weekdays_restricted: bool
+
+    @classmethod
+    def parse(cls, expression: str) -> "CronSchedule":
+        text = CRON_ALIASES.get(expression.strip().lower(), expression.strip())
+        fields = text.split()
+        if len(fields) != 5:
+            raise ValueError(
+                f"Invalid cron expression '{expression}': expected 5 fields "
+                "(minute hour day-of-month month day-of-week) or @hourly/@daily/@weekly/@monthly/@yearly"
+            )
+        try:
+            # Day of week 7 is Sunday too
+            weekdays = frozenset(d % 7 for d in parse_cron_field(fields[4], 0, 7, DAY_NAMES))
+            return cls(
+                expression=expression.strip(),
+                minutes=parse_cron_field(fields[0], 0, 59),
+                hours=parse_cron_field(fields[1], 0, 23),
+                days=parse_cron_field(fields[2], 1, 31),
+                months=parse_cron_field(fields[3], 1, 12, MONTH_NAMES),
+                weekdays=weekdays,
+                days_restricted=fields[2] != "*",
+                weekdays_restricted=fields[4] != "*",
+            )
+        except ValueError as e:
+            raise ValueError(f"Invalid cron expression '{expression}': {e}") from e
+
+    def _day_matches(self, moment: datetime) -> bool:
+        day = moment.day in self.days
+        weekday = (moment.weekday() + 1) % 7 in self.weekdays
+        if self.days_restricted and self.weekdays_restricted:
+            return day or weekday
+        return day and weekday
+
+    def matches(self, moment: datetime) -> bool:
+        return (
+            moment.minute in self.minutes
+            and moment.hour in self.hours
+            and moment.month in self.months
+            and self._day_matches(moment)
+        )
+
+    def next_after(self, moment: datetime) -> datetime | None:
+        """First matching minute after moment, or None if there is none in the next years."""
+        candidate = moment.replace(second=0, microsecond=0) + timedelta(minutes=1)
+        limit = moment + timedelta(days=MAX_LOOKAHEAD_DAYS)
+        while candidate <= limit:
+            if candidate.month not in self.months:
+                year, month = divmod(candidate.month, 12)
+                candidate = candidate.replace(year=candidate.year + year, month=month + 1, day=1, hour=0, minute=0)
+            elif not self._day_matches(candidate):
+                candidate = (candidate + timedelta(days=1)).replace(hour=0, minute=0)
+            elif candidate.hour not in self.hours:
+                candidate = (candidate + timedelta(hours=1)).replace(minute=0)
+            elif candidate.minute not in self.minutes:
+                candidate += timedelta(minutes=1)
+            else:
+                return candidate
+        return None
+
+
+@dataclass
+class JobRun:
+    """Results of one run of a job."""
+    time: float
+    solutions: list[str] = field(default_factory=list)
+    output: list[str] = field(default_factory=list)
+    error: str | None = None
+    # Whether the solutions or error differ from the previous run
+    changed: bool = False
+    seconds: float = 0.0
+
+    def same_result(self, other: "JobRun") -> bool:
+        return self.solutions == other.solutions and self.error == other.error
+
+    def summary(self) -> str:
+        stamp = time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(self.time))
+        if self.error:
+            result = f"error: {self.error}"
+        elif not self.solutions:
+            result = "false"
+        else:
+            result = f"{len(self.solutions)} solution(s)"
+        return f"{stamp} {result}{' (changed)' if self.changed else ''}"
+
+
+@dataclass
+class ScheduledJob:
+    """A goal run on a cron schedule."""
+    job_id: str
+    goal: str
+    cron: str
+    client: str
+    # Goal as run: sandboxed and qualified with the client's module
+    runnable: str
+    max_solutions: int = 100
+    timeout: float | None = None
+    created: float = field(default_factory=time.time)
+    next_run: float | None = None
+    runs: list[JobRun] = field(default_factory=list)
+
+    @property
+    def uri(self) -> str:
+        return job_uri(self.job_id)
+
+    @property
+    def schedule(self) -> CronSchedule:
+        return CronSchedule.parse(self.cron)
+
+    def plan_next(self, after: float) -> None:
+        upcoming = self.schedule.next_after(datetime.fromtimestamp(after))
+        self.next_run = upcoming.timestamp() if upcoming else None
+
+    def record(self, run: JobRun, history: int = RUN_HISTORY) -> bool:
+        """Keep a run; returns whether its result differs from the previous one."""
+        run.changed = not self.runs or not run.same_result(self.runs[-1])
+        self.runs.append(run)
+        del self.runs[:-history]
+        return run.changed
+
+    def to_json(self) -> dict[str, Any]:
+        return asdict(self)
+
+    @classmethod
+    def from_json(cls, data: dict[str, Any]) -> "ScheduledJob":
+        runs = [JobRun(**run) for run in data.pop("runs", [])]
+        return cls(**data, runs=runs)
+
+    @property
+    def next_run_text(self) -> str:
+        return time.strftime("%Y-%m-%d %H:%M", time.localtime(self.next_run)) if self.next_run else "never"
+
+    def describe(self) -> str:
+        last = self.runs[-1].summary() if self.runs else "not run yet"
+        return f"⏰ {self.job_id} [{self.cron}] {self.goal}\n   👤 {self.client} · next: {self.next_run_text} · last: {last}"
+
+
+class QueryScheduler:
+    """
+    Runs scheduled jobs and publishes them as swish://jobs/ resources.
+
+    Args:
+        server: FastMCP server to register job resources with
+        run: Coroutine running a job's goal once
+        notify_updated: Coroutine sending resources/updated for a URI
+        notify_list_changed: Coroutine sending resources/list_changed
+    """
+
+    def __init__(
+        self,
+        server: FastMCP,
+        run: Callable[[ScheduledJob], Awaitable[JobRun]],
+        notify_updated: Callable[[str], Awaitable[None]],
+        notify_list_changed: Callable[[], Awaitable[None]],
+        max_jobs: int = MAX_JOBS
+    ):
+        self.server = server
+        self.run = run
+        self.notify_updated = notify_updated
+        self.notify_list_changed = notify_list_changed
+        self.max_jobs = max_jobs
+        self.jobs: dict[str, ScheduledJob] = {}
+        self.path: Path | None = None
+
+    def load(self, path: Path) -> None:
+        """Read saved jobs; an unreadable file is logged and left alone."""
+        self.path = path
+        if not path.exists():
+            return
+        try:
+            entries = json.loads(path.read_text(encoding="utf-8"))
+            jobs = [ScheduledJob.from_json(entry) for entry in entries]
+        except (OSError, ValueError, TypeError) as e:
+            logger.error(f"Could not load scheduled queries from {path}: {e}")
+            return
+        now = time.time()
+        for job in jobs:
+            # Runs missed while the server was down are skipped
+            job.plan_next(now)
+            self.jobs[job.job_id] = job
+            self._register(job)
+        if jobs:
+            logger.info(f"⏰ Loaded {len(jobs)} scheduled queries from {path}")
+
+    def _save(self) -> None:
+        if self.path is None:
+            return
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        tmp = self.path.with_suffix(".tmp")
+        tmp.write_text(json.dumps([job.to_json() for job in self.jobs.values()], indent=2), encoding="utf-8")
+        tmp.replace(self.path)
+
+    async def _persist(self) -> None:
+        try:
+            await asyncio.to_thread(self._save)
+        except OSError as e:
+            logger.warning(f"Could not save scheduled queries: {e}")
+
+    def _register(self, job: ScheduledJob) -> None:
+        async def read() -> str:
+            return json.dumps(job.to_json(), indent=2)
+
+        self.server.add_resource(FunctionResource(
+            uri=job.uri,
+            name=f"job {job.job_id}",
+            description=f"Results of the scheduled query {job.goal} ({job.cron})",
+            mime_type="application/json",
+            fn=read,
+        ))
+
+    def _unregister(self, job: ScheduledJob) -> None:
+        # ResourceManager has no public removal API
+        self.server._resource_manager._resources.pop(job.uri, None)
+
+    async def add(
+        self,
+        goal: str,
+        cron: str,
+        client: str,
+        runnable: str,
+        max_solutions: int = 100,
+        timeout: float | None = None
+    ) -> ScheduledJob:
+        """Schedule a goal; raises ValueError for a bad cron expression or too many jobs."""
+        schedule = CronSchedule.parse(cron)
+        if len(self.jobs) >= self.max_jobs:
+            raise ValueError(f"At most {self.max_jobs} queries can be scheduled; cancel one first")
+        if schedule.next_after(datetime.now()) is None:
+            raise ValueError(f"Cron expression '{cron}' never matches")
+        job = ScheduledJob(
+            job_id=f"job-{uuid.uuid4().hex[:8]}",
+            goal=goal,
+            cron=schedule.expression,
+            client=client,
+            runnable=runnable,
+            max_solutions=max_solutions,
+            timeout=timeout,
+        )
+        job.plan_next(time.time())
+        self.jobs[job.job_id] = job
+        self._register(job)
+        await self._persist()
+        await self.notify_list_changed()
+        return job
+
+    async def remove(self, job_id: str) -> ScheduledJob:
+        job = self.jobs.pop(job_id, None)
+        if job is None:
+            raise ValueError(f"Unknown scheduled query '{job_id}'")
+        self._unregister(job)
+        await self._persist()
+        await self.notify_list_changed()
+        return job
+
+    async def run_job(self, job: ScheduledJob) -> JobRun:
+        """Run a job now, record the result and notify subscribers if it changed."""
+        started = time.monotonic()
+        try:
+            run = await self.run(job)
+        except Exception as e:
+            run = JobRun(time=time.time(), error=str(e))
+        run.seconds = round(time.monotonic() - started, 3)
+        if job.record(run):
+            logger.info(f"⏰ {job.job_id} result changed: {run.summary()}")
+            await self.notify_updated(job.uri)
+        await self._persist()
+        return run
+
+    def due(self, now: float) -> list[ScheduledJob]:
+        return [job for job in self.jobs.values() if job.next_run is not None and job.next_run <= now]
+
+    async def watch(self, interval: float = CHECK_INTERVAL) -> None:
+        while True:
+            await asyncio.sleep(interval)
+            for job in self.due(time.time()):
+                # Planned before running, so a slow run does not fire twice
+                job.plan_next(time.time())
+                if job.job_id in self.jobs:
+                    await self.run_job(job)
@@ -0,0 +1,127 @@
+"""
+Result Shaping for Docker SWISH MCP
+
+execute_prolog_query takes database-style options and compiles them
+into wrapper goals around the query, so clients need not write them:
+
+- distinct=True keeps the first of solutions with the same bindings,
+  distinct=["X"] the first per value of X (distinct/1, distinct/2)
+- order_by=["X", "-Y"] sorts by X ascending, then Y descending;
+  "asc(X)" and "desc(Y)" work too (order_by/2)
+- group_by=["X"] gives one solution per value of X, with count=True
+  binding Count to the number of solutions in the group and
+  sum=["Price"] binding SumPrice to the sum of Price over them
+  (aggregate_all/3 per group, see mcp_group/5)
+
+With count or sum but no group_by the whole result is one group, so
+count=True answers Count = 0 when the query has no solutions. Grouped
+solutions bind only the group_by variables and the aggregates; the
+others are left out, and order_by may sort by any of them. limit pages
+the shaped solutions.
+"""
+
+import re
+from dataclasses import dataclass, field
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+VARIABLE_RE = re.compile(r"^[A-Z][A-Za-z0-9_]*$")
+# "X", "-X", "+X", "asc(X)" or "desc(X)"
+ORDER_RE = re.compile(r"^(?:(?P<sign>[-+])\s*(?P<signed>\w+)|(?P<key>asc|desc)\(\s*(?P<wrapped>\w+)\s*\)|(?P<plain>\w+))$")
+COUNT_VARIABLE = "Count"
+
+
+def _variable(name: str, option: str) -> str:
+    name = name.strip()
+    if not VARIABLE_RE.match(name):
+        raise ValueError(f"{option} takes variable names of the query, e.g. \"X\"; got '{name}'")
+    return name
+
+
+def sum_variable(name: str) -> str:
+    return f"Sum{name}"
+
+
+@dataclass
+class ResultShape:
+    distinct: bool | list[str] = False
+    # (variable, "asc" or "desc")
+    order_by: list[tuple[str, str]] = field(default_factory=list)
+    group_by: list[str] = field(default_factory=list)
+    count: bool = False
+    sum: list[str] = field(default_factory=list)
+
+    @classmethod
+    def parse(
+        cls,
+        distinct: bool | list[str] = False,
+        order_by: list[str] | None = None,
+        group_by: list[str] | None = None,
+        count: bool = False,
+        sum: list[str] | None = None
+    ) -> "ResultShape":
+        """Check the options; raises ValueError for names that are not variables."""
+        if not isinstance(distinct, bool):
+            distinct = [_variable(name, "distinct") for name in distinct]
+        order = []
+        for entry in order_by or []:
+            match = ORDER_RE.match(entry.strip())
+            if not match:
+                raise ValueError(f"order_by entries are \"X\", \"-X\", \"asc(X)\" or \"desc(X)\"; got '{entry}'")
+            name = match.group("signed") or match.group("wrapped") or match.group("plain")
+            if match.group("sign"):
+                direction = "desc" if match.group("sign") == "-" else "asc"
+            else:
+                direction = match.group("key") or "asc"
+            order.append((_variable(name, "order_by"), direction))
+        shape = cls(
+            distinct,
+            order,
+            [_variable(name, "group_by") for name in group_by or []],
+            count,
+            [_variable(name, "sum") for name in sum or []],
+        )
+        outputs = shape.outputs()
+        if len(set(outputs)) != len(outputs):
+            raise ValueError(f"Grouped results bind {', '.join(outputs)}; group by other variables than the aggregates")
+        unbound = [name for name, _ in order if outputs and name not in outputs]
+        if unbound:
+            raise ValueError(f"Grouped results only bind {', '.join(outputs)}; cannot order by {', '.join(unbound)}")
+        return shape
+
+    @property
+    def grouped(self) -> bool:
+        return bool(self.group_by or self.count or self.sum)
+
+    @property
+    def active(self) -> bool:
+        return self.grouped or bool(self.distinct) or bool(self.order_by)
+
+    def outputs(self) -> list[str]:
+        """Variables a grouped solution binds."""
+        if not self.grouped:
+            return []
+        aggregates = [COUNT_VARIABLE] if self.count else []
+        return [*self.group_by, *aggregates, *(sum_variable(name) for name in self.sum)]
+
+    def wrap(self, goal: str) -> str:
+        """goal (text, without the trailing '.') with the shaping wrappers around it."""
+        if self.grouped:
+            aggregates = ["count"] if self.count else []
+            aggregates.extend(f"sum({prolog_atom(name)})" for name in self.sum)
+            names = ", ".join(prolog_atom(name) for name in self.group_by)
+            values = [COUNT_VARIABLE] if self.count else []
+            values.extend(sum_variable(name) for name in self.sum)
+            goal = (
+                f"mcp_group({prolog_string(goal)}, [{names}], [{', '.join(aggregates)}], "
+                f"[{', '.join(self.group_by)}], [{', '.join(values)}])"
+            )
+        elif self.distinct is True:
+            goal = f"distinct(({goal}))"
+        elif self.distinct:
+            goal = f"distinct([{', '.join(self.distinct)}], ({goal}))"
+        if self.order_by:
+            specs = ", ".join(f"{direction}({name})" for name, direction in self.order_by)
+            goal = f"order_by([{specs}], ({goal}))"
+        return goal
@@ -5,66 +5,147 @@ This version focuses on core functionality with robust communication.
 """
 
 import asyncio
+import json
 import logging
 import re
+import signal
+import uuid
+from collections.abc import AsyncIterator, Awaitable, Callable
+from pathlib import Path
 from typing import Any
 
+from .config import PrintOptions, QueryLimits
+from .container_exec import open_exec
+from .metrics import query_outcome
+from .telemetry import telemetry
+
 logger = logging.getLogger("docker-swish-mcp.session")
 
+HELPERS_PATH = Path(__file__).with_name("mcp_helpers.pl")
+
+# Extra time allowed past a query's wall-clock limit before the session is
+# considered wedged, so Prolog's own time_limit_exceeded normally wins.
+LIMIT_GRACE_SECONDS = 5.0
+
+# Stands for the line breaks of a pretty-printed text solution on its SOLUTION line
+LINE_BREAK = "\x1e"
+# Every line the streaming protocol emits carries this tag, so user output
+# and toplevel chatter ("true.") can be told apart from our own events.
+MARKER_RE = re.compile(r"@MCP (\w+) (SOLUTION|ERROR|CURSOR|TRACE|INVALIDATE|FACT|STORE|END)(?: (.*))?$")
+
+
+def clean_query_text(query: str) -> str:
+    """Strip a leading ?- and the trailing period from a query."""
+    clean_query = query.strip()
+    if clean_query.startswith("?-"):
+        clean_query = clean_query[2:].strip()
+    if clean_query.endswith('.'):
+        clean_query = clean_query[:-1].rstrip()
+    return clean_query
+
+
+def prolog_string(text: str) -> str:
+    """Quote text as a double-quoted Prolog string literal."""
+    escaped = (
+        text.replace("\\", "\\\\")
+        .replace('"', '\\"')
+        .replace("\n", "\\n")
+    )
+    return f'"{escaped}"'
+
 
 class SimplePrologSession:
     """
     A simplified persistent SWI-Prolog session that maintains state between queries.
     """
 
-    def __init__(self, container_name: str):
+    def __init__(self, container_name: str, docker_client: Any = None, working_dir: str = ""):
         self.container_name = container_name
-        self.process: asyncio.subprocess.Process | None = None
+        self.docker_client = docker_client
+        # Directory relative consults resolve against; "" keeps swipl's own
+        self.working_dir = working_dir
+        # ExecProcess, or an asyncio Process for CLI-driven runtimes
+        self.process: Any = None
         self.session_lock = asyncio.Lock()
         self.session_active = False
         self.query_counter = 0
+        # Bumped on every (re)start; cursors opened earlier are gone with the old process
+        self.generation = 0
+        # Called with "Module:Name/Arity" when a predicate watched by the
+        # query cache changes (see mcp_cache_deps/2)
+        self.on_invalidate: Callable[[str], None] | None = None
+        # Called with the JSON payload of each change a fact feed reports
+        # (see mcp_feed_watch/5), whichever query made it
+        self.on_fact: Callable[[str], None] | None = None
+        # Called with the JSON payload of each change of a predicate kept
+        # in the shared fact store (see mcp_store_load/6)
+        self.on_store: Callable[[str], None] | None = None
+        # Returns the helper calls that put back state living in the
+        # process, e.g. fact feed hooks, made after the startup programs
+        self.restore: Callable[[], list[tuple[str, list[str]]]] | None = None
+        # Called with the CPU seconds each query used, from the totals on END lines
+        self.on_cpu: Callable[[float], None] | None = None
+        self.cpu_total: float | None = None
+        # Called with the clauses each query added, however it added them, likewise
+        self.on_clauses: Callable[[int], None] | None = None
+        self.clause_total: int | None = None
+        # Returns the startup.StartupPlan loaded after the helpers on every start
+        self.startup: Callable[[], Awaitable[Any]] | None = None
+        # {"item", "error"} per startup program of the last start
+        self.startup_results: list[dict[str, str]] = []
+        # Returns swipl's command-line options, e.g. --stack-limit, on every start
+        self.swipl_options: Callable[[], list[str]] | None = None
 
     async def start_session(self) -> bool:
         """Start the persistent Prolog session."""
         async with self.session_lock:
-            try:
-                if self.session_active and self.process and self.process.returncode is None:
-                    return True
+            return await self._start_unlocked()
 
-                logger.info(f"Starting simplified Prolog session in {self.container_name}")
+    async def _start_unlocked(self) -> bool:
+        """Start the session; the caller must hold session_lock."""
+        try:
+            if self.session_active and self.process and self.process.returncode is None:
+                return True
 
-                # Start interactive SWI-Prolog
-                cmd = ["docker", "exec", "-i", self.container_name, "swipl", "-q"]
+            logger.info(f"Starting simplified Prolog session in {self.container_name}")
 
-                self.process = await asyncio.create_subprocess_exec(
-                    *cmd,
-                    stdin=asyncio.subprocess.PIPE,
-                    stdout=asyncio.subprocess.PIPE,
-                    stderr=asyncio.subprocess.PIPE
-                )
+            # Start interactive SWI-Prolog with stdin attached
+            cmd = ["swipl", *(self.swipl_options() if self.swipl_options else []), "-q"]
+            if self.working_dir:
+                cmd += ["-g", f"working_directory(_, {prolog_string(self.working_dir)})"]
+            self.process = await open_exec(self.docker_client, self.container_name, cmd)
 
-                # Wait for startup
-                await asyncio.sleep(1.5)
+            # Wait for startup
+            await asyncio.sleep(1.5)
 
-                if self.process.returncode is not None:
-                    logger.error("Process failed to start")
-                    return False
+            if self.process.returncode is not None:
+                logger.error("Process failed to start")
+                return False
 
-                # Simple test
-                success = await self._test_session()
-                if success:
-                    self.session_active = True
-                    logger.info("✅ Simplified session started")
-                    return True
-                else:
-                    logger.error("Session test failed")
-                    await self._internal_cleanup()
+            # Simple test
+            success = await self._test_session()
+            if success:
+                self.session_active = True
+                self.generation += 1
+                if not await self._load_helpers():
+                    logger.warning("Helper predicates failed to load; streaming queries will not work")
+                if not await self._load_startup():
+                    await self._cleanup()
                     return False
-
-            except Exception as e:
-                logger.error(f"Session start failed: {e}")
-                await self._cleanup()
+                await self._restore_unlocked()
+                await self._baseline_unlocked()
+                logger.info("✅ Simplified session started")
+                return True
+            else:
+                logger.error("Session test failed")
+                await self._internal_cleanup()
                 return False
+
+        except Exception as e:
+            logger.error(f"Session start failed: {e}")
+            await self._cleanup()
+            return False
+
     async def _internal_cleanup(self) -> None:
         """Clean up resources for the Prolog session."""
         try:
@@ -108,6 +189,111 @@ class SimplePrologSession:
             logger.error(f"Session test error: {e}")
             return False
 
+    async def _load_helpers(self) -> bool:
+        """Consult mcp_helpers.pl into the session through its stdin."""
+        try:
+            if not self.process or not self.process.stdin or not self.process.stdout:
+                return False
+
+            program = HELPERS_PATH.read_text(encoding="utf-8")
+            payload = f"[user].\n{program}\nend_of_file.\nformat(\"@MCP boot END~n\"), flush_output.\n"
+            self.process.stdin.write(payload.encode())
+            await self.process.stdin.drain()
+
+            while True:
+                line = await asyncio.wait_for(self.process.stdout.readline(), timeout=10.0)
+                if not line:
+                    return False
+                if "@MCP boot END" in line.decode(errors="replace"):
+                    return True
+
+        except asyncio.TimeoutError:
+            logger.error("Timed out loading helper predicates")
+            return False
+        except Exception as e:
+            logger.error(f"Loading helper predicates failed: {e}")
+            return False
+
+    async def _load_startup(self) -> bool:
+        """Load the startup programs; False if one failed and their policy is abort."""
+        if self.startup is None:
+            return True
+        plan = await self.startup()
+        results = [{"item": label, "error": error} for label, error in plan.failed]
+        if plan.items and not (plan.failed and plan.policy == "abort"):
+            arguments = "".join(f", {arg}" for arg in plan.call_args())
+            span = telemetry.open_span("prolog.query", {"prolog.helper": "mcp_startup"})
+            try:
+                async for event in self._exchange_unlocked(
+                    lambda query_id: f"\\+ \\+ mcp_startup({query_id}{arguments}).\n",
+                    QueryLimits(wall_seconds=plan.wall_seconds),
+                    "json",
+                    span
+                ):
+                    if event["type"] == "solution":
+                        results.append(event["bindings"])
+                    elif event["type"] == "error":
+                        results.append({"item": "startup", "error": str(event["error"])})
+            except (asyncio.TimeoutError, ConnectionError) as e:
+                results.append({"item": "startup", "error": str(e) or "timed out"})
+            finally:
+                span.end()
+        self.startup_results = results
+        failed = [result for result in results if result.get("error")]
+        for result in failed:
+            logger.warning(f"⚠️ Startup program {result['item']} failed to load: {result['error']}")
+        if results and not failed:
+            logger.info(f"📚 Loaded {len(results)} startup program(s)")
+        if failed and plan.policy == "abort":
+            logger.error("Not starting the session: a startup program failed and on_error is abort")
+            return False
+        return True
+
+    async def restore_state(self) -> None:
+        """Make the restore calls again, e.g. after taking over a process started for another context."""
+        async with self.session_lock:
+            if self.session_active:
+                await self._restore_unlocked()
+
+    async def _restore_unlocked(self) -> None:
+        """Make the restore calls; one failing is logged and does not stop the session."""
+        if self.restore is None:
+            return
+        for predicate, args in self.restore():
+            arguments = "".join(f", {arg}" for arg in args)
+            span = telemetry.open_span("prolog.query", {"prolog.helper": predicate})
+            try:
+                async for event in self._exchange_unlocked(
+                    lambda query_id: f"\\+ \\+ {predicate}({query_id}{arguments}).\n",
+                    QueryLimits(wall_seconds=10),
+                    "json",
+                    span
+                ):
+                    if event["type"] == "error":
+                        logger.warning(f"⚠️ Restoring {predicate} failed: {event['error']}")
+            except (asyncio.TimeoutError, ConnectionError) as e:
+                logger.warning(f"⚠️ Restoring {predicate} failed: {e or 'timed out'}")
+            finally:
+                span.end()
+
+    async def _baseline_unlocked(self) -> None:
+        """Take the CPU and clause totals queries are charged from, so starting is charged to no one."""
+        self.cpu_total = None
+        self.clause_total = None
+        span = telemetry.open_span("prolog.query", {"prolog.helper": "mcp_end"})
+        try:
+            async for _event in self._exchange_unlocked(
+                lambda query_id: f"\\+ \\+ mcp_end({query_id}).\n",
+                QueryLimits(wall_seconds=5),
+                "text",
+                span
+            ):
+                pass
+        except (asyncio.TimeoutError, ConnectionError) as e:
+            logger.warning(f"⚠️ Reading the session's usage totals failed: {e or 'timed out'}")
+        finally:
+            span.end()
+
     async def execute_query(self, query: str, timeout: int = 30) -> dict[str, Any]:
         """Execute a query in the persistent session."""
         async with self.session_lock:
@@ -116,10 +302,329 @@ class SimplePrologSession:
 
             return await self._run_query(query, timeout)
 
+    async def stream_query(
+        self,
+        query: str,
+        limits: QueryLimits | None = None,
+        output_format: str = "text",
+        printing: PrintOptions | None = None
+    ) -> AsyncIterator[dict[str, Any]]:
+        """
+        Execute a query and yield events as SWI-Prolog produces solutions.
+
+        Events are dicts with a "type" of "solution" (with "text" holding
+        the bindings), "output" (text printed by the goal itself) or
+        "error". The goal is parsed by Prolog via term_string/3, so
+        variable names come from the reader rather than a regex.
+
+        Limits are enforced inside Prolog (see mcp_limited/2); exceeding
+        one is reported as an "error" event. With output_format "json",
+        solution events also carry "bindings": a dict of typed values as
+        produced by mcp_term_json/2. printing sets how bindings are printed
+        (see PrintOptions).
+
+        Raises:
+            asyncio.TimeoutError: if Prolog does not answer within the wall
+                clock limit plus a grace period
+        """
+        limits = limits or QueryLimits()
+        if output_format not in ("text", "json"):
+            raise ValueError(f"Unknown output format '{output_format}'")
+        text = prolog_string(clean_query_text(query))
+        fmt = (printing or PrintOptions()).to_prolog(output_format)
+        async for event in self._stream(
+            lambda query_id: f"\\+ \\+ mcp_run({query_id}, {text}, {limits.to_prolog()}, {fmt}).\n",
+            limits,
+            output_format,
+            goal=query
+        ):
+            yield event
+
+    async def open_cursor(
+        self,
+        cursor_id: str,
+        query: str,
+        limits: QueryLimits,
+        output_format: str,
+        page_size: int,
+        printing: PrintOptions | None = None
+    ) -> AsyncIterator[dict[str, Any]]:
+        """
+        Start a query in a Prolog engine and yield its first page.
+
+        Besides the stream_query events, a final "cursor" event reports
+        whether the engine has more solutions ("more") or was exhausted
+        and destroyed ("done").
+        """
+        if output_format not in ("text", "json"):
+            raise ValueError(f"Unknown output format '{output_format}'")
+        text = prolog_string(clean_query_text(query))
+        fmt = (printing or PrintOptions()).to_prolog(output_format)
+        async for event in self._stream(
+            lambda query_id: (
+                f"\\+ \\+ mcp_cursor_open({query_id}, {cursor_id}, {text}, "
+                f"{limits.to_prolog()}, {fmt}, {int(page_size)}).\n"
+            ),
+            limits,
+            output_format,
+            goal=query
+        ):
+            yield event
+
+    async def next_page(
+        self,
+        cursor_id: str,
+        limits: QueryLimits,
+        output_format: str,
+        page_size: int
+    ) -> AsyncIterator[dict[str, Any]]:
+        """Yield the next page of an open cursor, as for open_cursor()."""
+        async for event in self._stream(
+            lambda query_id: f"\\+ \\+ mcp_cursor_next({query_id}, {cursor_id}, {limits.to_prolog()}, {int(page_size)}).\n",
+            limits,
+            output_format,
+            helper="mcp_cursor_next"
+        ):
+            yield event
+
+    async def trace_query(
+        self,
+        query: str,
+        limits: QueryLimits,
+        max_depth: int = 10,
+        max_ports: int = 500,
+        safe: bool = False
+    ) -> AsyncIterator[dict[str, Any]]:
+        """
+        Run a query once under the tracer and yield its port events.
+
+        Besides "solution", "output" and "error" events, "trace" events
+        carry either "port" (a dict with port, depth, goal, predicate and,
+        for exceptions, error) or "truncated" once max_ports is reached.
+        With safe set, the goal is vetted by safe_goal/1 before tracing.
+        """
+        text = prolog_string(clean_query_text(query))
+        options = f"trace({int(max_depth)}, {int(max_ports)}, {'true' if safe else 'false'})"
+        async for event in self._stream(
+            lambda query_id: f"\\+ \\+ mcp_trace({query_id}, {text}, {limits.to_prolog()}, {options}).\n",
+            limits,
+            goal=query
+        ):
+            yield event
+
+    async def run_helper(
+        self,
+        predicate: str,
+        args: list[str],
+        limits: QueryLimits,
+        output_format: str = "json"
+    ) -> AsyncIterator[dict[str, Any]]:
+        """
+        Call a helper from mcp_helpers.pl that takes the query id first.
+
+        args are Prolog term texts; the events are those of stream_query.
+        """
+        arguments = "".join(f", {arg}" for arg in args)
+        async for event in self._stream(
+            lambda query_id: f"\\+ \\+ {predicate}({query_id}{arguments}).\n",
+            limits,
+            output_format,
+            helper=predicate
+        ):
+            yield event
+
+    async def close_cursors(self, cursor_ids: list[str]) -> None:
+        """Destroy the engines behind cursors that are no longer needed."""
+        if not cursor_ids or not self.session_active:
+            return
+        ids = ", ".join(cursor_ids)
+        async for _event in self._stream(
+            lambda query_id: f"\\+ \\+ forall(member(C, [{ids}]), mcp_cursor_close(C)), mcp_end({query_id}).\n",
+            QueryLimits(wall_seconds=5),
+            helper="mcp_cursor_close"
+        ):
+            pass
+
+    async def _stream(
+        self,
+        build_goal: Callable[[str], str],
+        limits: QueryLimits,
+        output_format: str = "text",
+        goal: str = "",
+        helper: str = ""
+    ) -> AsyncIterator[dict[str, Any]]:
+        """
+        Send the goal built for a fresh query id and yield its events until END.
+
+        goal (the user's query) or helper (the mcp_helpers.pl predicate)
+        describes the exchange on its prolog.query span.
+        """
+        span = telemetry.open_span("prolog.query", {
+            "prolog.goal": telemetry.goal(goal) if goal else None,
+            "prolog.helper": helper or None,
+        })
+        solutions = 0
+        error: str | None = None
+        try:
+            async for event in self._exchange(build_goal, limits, output_format, span):
+                if event["type"] == "solution":
+                    solutions += 1
+                elif event["type"] == "error" and error is None:
+                    error = str(event.get("error", ""))
+                yield event
+        except asyncio.TimeoutError:
+            error = "timeout"
+            raise
+        except Exception as e:
+            error = str(e) or type(e).__name__
+            raise
+        finally:
+            span.set_attribute("prolog.solutions", solutions)
+            span.set_attribute("prolog.outcome", query_outcome(error, solutions))
+            span.end()
+
+    async def _exchange(
+        self,
+        build_goal: Callable[[str], str],
+        limits: QueryLimits,
+        output_format: str,
+        span: Any
+    ) -> AsyncIterator[dict[str, Any]]:
+        async with self.session_lock:
+            if not await self._ensure_active():
+                yield {"type": "error", "error": "Session not available"}
+                return
+            async for event in self._exchange_unlocked(build_goal, limits, output_format, span):
+                yield event
+
+    async def _exchange_unlocked(
+        self,
+        build_goal: Callable[[str], str],
+        limits: QueryLimits,
+        output_format: str,
+        span: Any
+    ) -> AsyncIterator[dict[str, Any]]:
+        """The exchange of _exchange(); the caller must hold session_lock."""
+        if self.process is None or self.process.stdin is None or self.process.stdout is None:
+            yield {"type": "error", "error": "Process or its pipes are None"}
+            return
+
+        self.query_counter += 1
+        query_id = f"q{self.query_counter}x{uuid.uuid4().hex[:6]}"
+        span.set_attribute("prolog.query.id", query_id)
+        goal = build_goal(query_id)
+        self.process.stdin.write(goal.encode())
+        await self.process.stdin.drain()
+
+        loop = asyncio.get_running_loop()
+        deadline = loop.time() + limits.wall_seconds + LIMIT_GRACE_SECONDS
+        finished = False
+        try:
+            while True:
+                remaining = deadline - loop.time()
+                if remaining <= 0:
+                    raise asyncio.TimeoutError
+                line_bytes = await asyncio.wait_for(
+                    self.process.stdout.readline(),
+                    timeout=remaining
+                )
+                if not line_bytes:
+                    raise ConnectionError("Prolog process closed its output")
+
+                line = line_bytes.decode('utf-8', errors='replace').rstrip('\n')
+                match = MARKER_RE.search(line)
+                if match is None:
+                    # Skip toplevel answers left over from earlier goals
+                    if line.strip() and line.strip() not in ("true.", "false."):
+                        yield {"type": "output", "text": line}
+                    continue
+                if match.group(2) == "INVALIDATE":
+                    # Printed by whichever query changed the predicate
+                    if self.on_invalidate is not None:
+                        self.on_invalidate((match.group(3) or "").strip())
+                    if line[:match.start()].strip():
+                        yield {"type": "output", "text": line[:match.start()]}
+                    continue
+                if match.group(2) == "FACT":
+                    if self.on_fact is not None:
+                        self.on_fact((match.group(3) or "").strip())
+                    if line[:match.start()].strip():
+                        yield {"type": "output", "text": line[:match.start()]}
+                    continue
+                if match.group(2) == "STORE":
+                    if self.on_store is not None:
+                        self.on_store((match.group(3) or "").strip())
+                    if line[:match.start()].strip():
+                        yield {"type": "output", "text": line[:match.start()]}
+                    continue
+                if match.group(1) != query_id:
+                    continue
+
+                if line[:match.start()].strip():
+                    yield {"type": "output", "text": line[:match.start()]}
+
+                kind, payload = match.group(2), match.group(3) or ""
+                if kind == "END":
+                    finished = True
+                    self._count_usage(payload)
+                    return
+                if kind == "SOLUTION" and output_format == "json":
+                    yield {"type": "solution", "text": payload, "bindings": json.loads(payload)}
+                elif kind == "SOLUTION":
+                    yield {"type": "solution", "text": payload.replace(LINE_BREAK, "\n")}
+                elif kind == "CURSOR":
+                    yield {"type": "cursor", "state": payload.strip()}
+                elif kind == "TRACE" and payload.strip() == "truncated":
+                    yield {"type": "trace", "truncated": True}
+                elif kind == "TRACE":
+                    yield {"type": "trace", "port": json.loads(payload)}
+                else:
+                    # Only END follows an ERROR, and a later query skips it by
+                    # its id: the goal is over, so a caller stopping here
+                    # leaves the session as it is
+                    finished = True
+                    yield {"type": "error", "error": payload}
+        except asyncio.CancelledError:
+            # Stopped by cancel_query or an MCP cancellation: interrupt just
+            # this goal. What it prints from now on carries its own id, which
+            # later queries skip, so the session and its state are kept.
+            self._interrupt(query_id)
+            finished = True
+            raise
+        finally:
+            if not finished:
+                # The goal may still be running, so later replies could
+                # not be framed reliably; drop the process and start over.
+                logger.warning(f"Query {query_id} did not complete, resetting session")
+                await self._cleanup()
+
+    def _interrupt(self, query_id: str) -> None:
+        """Send swipl SIGINT, on which the running goal raises mcp_cancelled (see mcp_interrupt/1)."""
+        process = self.process
+        if process is None or process.returncode is not None:
+            return
+        logger.info(f"Interrupting query {query_id}")
+        # Not awaited: the task asking for it is being cancelled
+        asyncio.get_running_loop().run_in_executor(None, process.send_signal, signal.SIGINT)
+
+    def _count_usage(self, payload: str) -> None:
+        """Report what a query used from the CPU and clause totals of its END line."""
+        try:
+            cpu, clauses = payload.split()
+            total, count = float(cpu), int(clauses)
+        except ValueError:
+            return
+        if self.cpu_total is not None and self.on_cpu is not None and total > self.cpu_total:
+            self.on_cpu(total - self.cpu_total)
+        self.cpu_total = total
+        if self.clause_total is not None and self.on_clauses is not None and count > self.clause_total:
+            self.on_clauses(count - self.clause_total)
+        self.clause_total = count
+
     async def _ensure_active(self) -> bool:
         """Ensure session is active."""
         if not self.session_active or not self.process or self.process.returncode is not None:
-            return await self.start_session()
+            return await self._start_unlocked()
         return True
 
     async def _run_query(self, query: str, timeout: int) -> dict[str, Any]:
@@ -263,5 +768,6 @@ class SimplePrologSession:
             "active": self.session_active,
             "process_alive": self.process is not None and self.process.returncode is None,
             "query_count": self.query_counter,
-            "container": self.container_name
+            "container": self.container_name,
+            "startup": self.startup_results
         }
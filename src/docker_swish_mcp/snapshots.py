@@ -0,0 +1,144 @@
+"""
+Knowledge Base Snapshots for Docker SWISH MCP
+
+Archives the mounted Prolog program directory (or, for named volumes, the
+container's /data directory) into timestamped tarballs and restores them.
+
+Both sources produce archives with a single top-level "data/" directory,
+matching what Docker's archive API returns for /data, so any snapshot can
+be restored either way.
+"""
+
+import io
+import logging
+import shutil
+import tarfile
+from datetime import datetime
+from pathlib import Path, PurePosixPath
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.snapshots")
+
+ARCHIVE_ROOT = "data"
+SNAPSHOT_SUFFIXES = (".tar.gz", ".tar")
+
+
+def snapshot_dir_for(data_dir: Path) -> Path:
+    """Directory holding snapshots of a data directory (kept outside the mount)."""
+    return data_dir.parent / "swish-snapshots" / data_dir.name
+
+
+def _snapshot_name(label: str, suffix: str) -> str:
+    stamp = datetime.now().strftime("%Y%m%d-%H%M%S")
+    safe_label = "".join(c if c.isalnum() or c in "-_" else "_" for c in label) or "kb"
+    return f"{safe_label}-{stamp}{suffix}"
+
+
+def snapshot_host_dir(data_dir: Path, label: str = "kb") -> Path:
+    """Tar up a host data directory into a gzip-compressed snapshot."""
+    target_dir = snapshot_dir_for(data_dir)
+    target_dir.mkdir(parents=True, exist_ok=True)
+    archive = target_dir / _snapshot_name(label, ".tar.gz")
+    with tarfile.open(archive, "w:gz") as tar:
+        tar.add(data_dir, arcname=ARCHIVE_ROOT)
+    logger.info(f"Created snapshot {archive}")
+    return archive
+
+
+def snapshot_container_dir(container: Any, data_dir: Path, label: str = "kb") -> Path:
+    """Archive the container's /data directory through the Docker API."""
+    target_dir = snapshot_dir_for(data_dir)
+    target_dir.mkdir(parents=True, exist_ok=True)
+    archive = target_dir / _snapshot_name(label, ".tar")
+    stream, _stat = container.get_archive(f"/{ARCHIVE_ROOT}")
+    with open(archive, "wb") as f:
+        for chunk in stream:
+            f.write(chunk)
+    logger.info(f"Created snapshot {archive} from container")
+    return archive
+
+
+def list_snapshots(data_dir: Path) -> list[Path]:
+    """Return snapshots for a data directory, newest first."""
+    target_dir = snapshot_dir_for(data_dir)
+    if not target_dir.exists():
+        return []
+    archives = [p for p in target_dir.iterdir() if p.name.endswith(SNAPSHOT_SUFFIXES)]
+    return sorted(archives, key=lambda p: p.stat().st_mtime, reverse=True)
+
+
+def resolve_snapshot(data_dir: Path, name: str) -> Path:
+    """Find a snapshot by file name, or the newest when name is "latest"."""
+    snapshots = list_snapshots(data_dir)
+    if name == "latest" and snapshots:
+        return snapshots[0]
+    for archive in snapshots:
+        if archive.name == name:
+            return archive
+    raise FileNotFoundError(f"Snapshot '{name}' not found")
+
+
+def checked_members(tar: tarfile.TarFile) -> list[tarfile.TarInfo]:
+    """Reject archive members that would escape the data directory."""
+    members = []
+    for member in tar.getmembers():
+        path = PurePosixPath(member.name)
+        if path.is_absolute() or ".." in path.parts or path.parts[:1] != (ARCHIVE_ROOT,):
+            raise ValueError(f"Refusing unsafe archive member: {member.name}")
+        if member.issym() or member.islnk():
+            raise ValueError(f"Refusing link in archive: {member.name}")
+        members.append(member)
+    return members
+
+
+def restore_host_dir(archive: Path, data_dir: Path, clean: bool = False) -> int:
+    """
+    Extract a snapshot into a host data directory.
+
+    Args:
+        archive: Snapshot to restore
+        data_dir: Directory mounted as /data
+        clean: Remove the current contents before extracting
+
+    Returns:
+        Number of files restored
+    """
+    with tarfile.open(archive, "r:*") as tar:
+        members = checked_members(tar)
+        if clean and data_dir.exists():
+            for child in data_dir.iterdir():
+                if child.is_dir():
+                    shutil.rmtree(child)
+                else:
+                    child.unlink()
+        data_dir.mkdir(parents=True, exist_ok=True)
+
+        restored = 0
+        for member in members:
+            relative = PurePosixPath(member.name).relative_to(ARCHIVE_ROOT)
+            destination = data_dir.joinpath(*relative.parts)
+            if member.isdir():
+                destination.mkdir(parents=True, exist_ok=True)
+            elif member.isfile():
+                destination.parent.mkdir(parents=True, exist_ok=True)
+                source = tar.extractfile(member)
+                if source is not None:
+                    with open(destination, "wb") as f:
+                        shutil.copyfileobj(source, f)
+                    restored += 1
+    logger.info(f"Restored {restored} files from {archive}")
+    return restored
+
+
+def restore_container_dir(archive: Path, container: Any) -> int:
+    """Upload a snapshot into the container's /data through the Docker API."""
+    with tarfile.open(archive, "r:*") as tar:
+        members = checked_members(tar)
+        # put_archive only accepts uncompressed tar streams
+        buffer = io.BytesIO()
+        with tarfile.open(fileobj=buffer, mode="w") as out:
+            for member in members:
+                out.addfile(member, tar.extractfile(member) if member.isfile() else None)
+    if not container.put_archive("/", buffer.getvalue()):
+        raise RuntimeError("Docker rejected the archive upload")
+    return sum(1 for m in members if m.isfile())
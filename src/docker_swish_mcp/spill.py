@@ -0,0 +1,105 @@
+"""
+Large Result Spilling for Docker SWISH MCP
+
+A query with more solutions than SWISH_MCP_SPILL_SOLUTIONS (default
+1000), or whose solutions take more than SWISH_MCP_SPILL_BYTES (default
+256 KiB), does not put them all in the tool result, where clients would
+truncate them. Every solution is written to results/ in the data
+directory instead, one per line (a JSON object per line in json output
+format), and the result says how many there were, shows the first few,
+and links the file as the resource swish://results/<name>.
+
+The last KEEP_FILES spill files are kept; older ones are removed as new
+ones are written. Setting either threshold to 0 disables that check.
+"""
+
+import json
+import re
+import time
+import uuid
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+RESULTS_DIR = "results"
+URI_PREFIX = "swish://results/"
+RESULTS_URI_TEMPLATE = URI_PREFIX + "{name}"
+# Solutions shown in the tool result of a spilled query
+SAMPLE_SIZE = 10
+KEEP_FILES = 50
+
+NAME_RE = re.compile(r"^q-[0-9]{8}-[0-9]{6}-[0-9a-f]{8}\.(?:txt|jsonl)$")
+
+
+class SpillError(ValueError):
+    """Raised for a spill resource that does not exist."""
+
+
+@dataclass
+class SpillThresholds:
+    """When query results are written to a file instead of returned."""
+    solutions: int = 1000
+    bytes: int = 256 * 1024
+
+    def exceeded(self, solutions: list[Any], structured: bool) -> bool:
+        if self.solutions > 0 and len(solutions) > self.solutions:
+            return True
+        return self.bytes > 0 and results_size(solutions, structured) > self.bytes
+
+
+@dataclass
+class SpilledResult:
+    """Where the solutions of a query went."""
+    name: str
+    count: int
+    size: int
+
+    @property
+    def uri(self) -> str:
+        return URI_PREFIX + self.name
+
+    @property
+    def path(self) -> str:
+        return f"{RESULTS_DIR}/{self.name}"
+
+    def to_json(self) -> dict[str, Any]:
+        return {"uri": self.uri, "file": self.path, "solutions": self.count, "bytes": self.size}
+
+
+def solution_lines(solutions: list[Any], structured: bool) -> list[str]:
+    """One line per solution: its JSON bindings, or its text with newlines escaped."""
+    if structured:
+        return [json.dumps(solution, ensure_ascii=False) for solution in solutions]
+    return [str(solution).replace("\\", "\\\\").replace("\n", "\\n") for solution in solutions]
+
+
+def results_size(solutions: list[Any], structured: bool) -> int:
+    return sum(len(line.encode("utf-8")) + 1 for line in solution_lines(solutions, structured))
+
+
+def spill_results(data_dir: Path, solutions: list[Any], structured: bool) -> SpilledResult:
+    """Write every solution to a new file in data_dir/results and prune old ones."""
+    directory = data_dir / RESULTS_DIR
+    directory.mkdir(parents=True, exist_ok=True)
+    suffix = "jsonl" if structured else "txt"
+    name = f"q-{time.strftime('%Y%m%d-%H%M%S')}-{uuid.uuid4().hex[:8]}.{suffix}"
+    text = "".join(f"{line}\n" for line in solution_lines(solutions, structured))
+    (directory / name).write_text(text, encoding="utf-8")
+    prune_results(directory)
+    return SpilledResult(name, len(solutions), len(text.encode("utf-8")))
+
+
+def prune_results(directory: Path, keep: int = KEEP_FILES) -> None:
+    files = sorted((path for path in directory.iterdir() if NAME_RE.match(path.name)), key=lambda path: path.name)
+    for path in files[:-keep] if keep > 0 else files:
+        path.unlink(missing_ok=True)
+
+
+def read_result(data_dir: Path, name: str) -> str:
+    """The text of a spill file, by its name in swish://results/<name>."""
+    if not NAME_RE.match(name):
+        raise SpillError(f"'{name}' is not a spilled query result")
+    path = data_dir / RESULTS_DIR / name
+    if not path.is_file():
+        raise SpillError(f"{URI_PREFIX}{name} no longer exists; only the last {KEEP_FILES} results are kept")
+    return path.read_text(encoding="utf-8")
@@ -0,0 +1,76 @@
+"""
+Startup Programs for Docker SWISH MCP
+
+Programs listed here are loaded into the persistent session every time
+it starts: when the server starts, whenever the supervisor restarts the
+container, and when the session itself is restarted. In the config file:
+
+    [startup]
+    on_error = "warn"
+    programs = [
+        "family.pl",
+        "https://example.org/rules.pl",
+        { url = "https://example.org/lib.pl", checksum = "sha256:…" },
+        { code = ":- set_prolog_flag(double_quotes, codes)." },
+    ]
+
+or, without a file, SWISH_MCP_STARTUP="family.pl,https://example.org/rules.pl".
+A string is a .pl file in the data directory, or a URL, which is
+downloaded into url-cache/ once and loaded from the cache afterwards
+(see remote_sources.py); code is loaded as an inline source.
+
+Programs load in the order listed. A program fails when loading it
+raises an exception or prints an error. With on_error="warn" (the
+default, SWISH_MCP_STARTUP_ON_ERROR) the failure is logged and the rest
+still load; with "abort" loading stops there and the session does not
+start, so no query runs against a half-built knowledge base.
+"""
+
+from dataclasses import dataclass, field
+from pathlib import Path
+
+from .config import StartupSettings
+from .rdf import prolog_atom
+from .remote_sources import RemoteSourceError, fetch_source
+from .simple_session import prolog_string
+
+# Wall-clock limit for loading all startup programs
+STARTUP_WALL_SECONDS = 300.0
+
+
+@dataclass
+class StartupPlan:
+    """The startup programs, resolved to what mcp_startup/3 loads."""
+    # file(Path) and code(Name, Text) terms, in load order
+    items: list[str] = field(default_factory=list)
+    policy: str = "warn"
+    # (label, error) of programs that failed before reaching Prolog
+    failed: list[tuple[str, str]] = field(default_factory=list)
+    wall_seconds: float = STARTUP_WALL_SECONDS
+
+    def call_args(self) -> list[str]:
+        return [f"[{', '.join(self.items)}]", self.policy]
+
+
+async def startup_plan(settings: StartupSettings, data_dir: Path) -> StartupPlan:
+    """
+    Resolve the startup programs: URLs are fetched into url-cache/ (from the cache when it has them).
+
+    With the abort policy nothing after a failed download is loaded.
+    """
+    plan = StartupPlan(policy=settings.on_error)
+    for index, program in enumerate(settings.programs, start=1):
+        if program.code:
+            plan.items.append(f"code({prolog_atom(f'startup-{index}')}, {prolog_string(program.code)})")
+        elif program.file:
+            plan.items.append(f"file({prolog_atom(program.file)})")
+        else:
+            try:
+                source = await fetch_source(data_dir, program.url, program.checksum, False, any_host=True)
+            except RemoteSourceError as e:
+                plan.failed.append((program.url, str(e)))
+                if settings.on_error == "abort":
+                    break
+                continue
+            plan.items.append(f"file({prolog_atom(source.consult_name)})")
+    return plan
@@ -0,0 +1,148 @@
+"""
+Container Health Supervisor for Docker SWISH MCP
+
+Periodically probes the SWISH HTTP endpoint and restarts the container
+with exponential backoff when it stops answering, so a crashed container
+recovers instead of silently failing every tool call.
+"""
+
+import asyncio
+import logging
+import time
+from collections.abc import Awaitable, Callable
+from dataclasses import asdict, dataclass
+from typing import Any
+
+logger = logging.getLogger("docker-swish-mcp.supervisor")
+
+
+@dataclass
+class HealthState:
+    """Latest health information, as reported by swish_status."""
+    status: str = "starting"  # starting | healthy | unhealthy | restarting | failed
+    last_probe: float | None = None
+    last_healthy: float | None = None
+    consecutive_failures: int = 0
+    restarts: int = 0
+    last_error: str | None = None
+    next_restart_in: float | None = None
+
+
+class ContainerSupervisor:
+    """
+    Watches one SWISH container and restarts it when probes keep failing.
+
+    Args:
+        name: Label used in logs (container name)
+        probe: Coroutine returning True when SWISH answers
+        restart: Coroutine recreating the container, returning True on success
+        interval: Seconds between probes
+        failure_threshold: Consecutive failed probes before restarting
+        backoff_base: First restart delay in seconds, doubled per failed restart
+        backoff_max: Upper bound for the restart delay
+    """
+
+    def __init__(
+        self,
+        name: str,
+        probe: Callable[[], Awaitable[bool]],
+        restart: Callable[[], Awaitable[bool]],
+        interval: float = 15.0,
+        failure_threshold: int = 2,
+        backoff_base: float = 2.0,
+        backoff_max: float = 300.0
+    ):
+        self.name = name
+        self.probe = probe
+        self.restart = restart
+        self.interval = interval
+        self.failure_threshold = failure_threshold
+        self.backoff_base = backoff_base
+        self.backoff_max = backoff_max
+        self.state = HealthState()
+        self.task: asyncio.Task | None = None
+        self._backoff = backoff_base
+
+    def start(self) -> asyncio.Task:
+        """Start the supervision loop as a background task."""
+        if self.task is None or self.task.done():
+            self.task = asyncio.get_running_loop().create_task(self._run())
+        return self.task
+
+    async def stop(self) -> None:
+        """Cancel the supervision loop."""
+        if self.task and not self.task.done():
+            self.task.cancel()
+            try:
+                await self.task
+            except asyncio.CancelledError:
+                pass
+        self.task = None
+
+    async def check_now(self) -> bool:
+        """Probe once and update the health state."""
+        self.state.last_probe = time.time()
+        try:
+            healthy = await self.probe()
+            error = None if healthy else "SWISH did not answer"
+        except Exception as e:
+            healthy, error = False, str(e)
+
+        if healthy:
+            self.state.status = "healthy"
+            self.state.last_healthy = self.state.last_probe
+            self.state.consecutive_failures = 0
+            self.state.last_error = None
+            self.state.next_restart_in = None
+            self._backoff = self.backoff_base
+        else:
+            self.state.consecutive_failures += 1
+            self.state.last_error = error
+            if self.state.status not in ("restarting", "failed"):
+                self.state.status = "unhealthy"
+        return healthy
+
+    async def _run(self) -> None:
+        logger.info(f"🩺 Supervising {self.name} every {self.interval:g}s")
+        while True:
+            healthy = await self.check_now()
+            if not healthy and self.state.consecutive_failures >= self.failure_threshold:
+                await self._restart_with_backoff()
+            await asyncio.sleep(self.interval)
+
+    async def _restart_with_backoff(self) -> None:
+        delay = self._backoff
+        self.state.status = "restarting"
+        self.state.next_restart_in = delay
+        logger.warning(
+            f"⚠️ {self.name} failed {self.state.consecutive_failures} health checks, "
+            f"restarting in {delay:g}s"
+        )
+        await asyncio.sleep(delay)
+        self.state.next_restart_in = None
+
+        try:
+            success = await self.restart()
+        except Exception as e:
+            logger.error(f"Restart of {self.name} raised: {e}")
+            success = False
+
+        self.state.restarts += 1
+        if success:
+            logger.info(f"✅ {self.name} restarted")
+            self.state.status = "healthy"
+            self.state.consecutive_failures = 0
+            self.state.last_error = None
+            self.state.last_healthy = time.time()
+            self._backoff = self.backoff_base
+        else:
+            self.state.status = "failed"
+            self._backoff = min(self._backoff * 2, self.backoff_max)
+            logger.error(f"❌ Restart of {self.name} failed, next attempt backs off to {self._backoff:g}s")
+
+    def get_status(self) -> dict[str, Any]:
+        """Health state as a JSON-ready dict."""
+        status = asdict(self.state)
+        status["container"] = self.name
+        status["supervising"] = self.task is not None and not self.task.done()
+        return status
@@ -0,0 +1,248 @@
+"""
+Resilient HTTP Calls to SWISH for Docker SWISH MCP
+
+Every request the server makes to a SWISH container (pengines, readiness
+and health probes) goes through SwishHttp, which tells apart a container
+that cannot be reached from a request SWISH answered with an error:
+
+- connection refused or reset, and 502/503/504 replies, are retried with
+  full-jitter exponential backoff (SWISH_MCP_HTTP_RETRIES,
+  SWISH_MCP_HTTP_BACKOFF, SWISH_MCP_HTTP_BACKOFF_MAX). Requests that
+  must not run twice (creating a pengine, sending it an event) are only
+  retried when the connection was refused, as SWISH never saw them
+- a request's timeout is a deadline for all its attempts together, so
+  retrying never makes a tool call take longer than asked for
+- after SWISH_MCP_HTTP_BREAKER_FAILURES unreachable requests in a row
+  the circuit opens: calls fail at once for SWISH_MCP_HTTP_BREAKER_RESET
+  seconds, then one trial request decides whether it closes again
+
+SwishUnavailable means SWISH could not be reached (typically while the
+container is still starting) and the call may simply be repeated;
+SwishRequestFailed carries the HTTP status of a reply that was an error.
+"""
+
+import asyncio
+import json
+import logging
+import random
+import time
+from dataclasses import dataclass
+from typing import Any
+
+import aiohttp
+
+from .request_logging import correlation_headers
+from .telemetry import telemetry
+
+logger = logging.getLogger("docker-swish-mcp.swish_http")
+
+# Replies that mean a proxy or SWISH itself is not ready yet
+RETRY_STATUSES = frozenset({502, 503, 504})
+
+
+class SwishUnavailable(Exception):
+    """SWISH did not accept the request; the container may still be starting."""
+
+
+class SwishRequestFailed(Exception):
+    """SWISH replied to the request with an error status."""
+
+    def __init__(self, status: int, text: str):
+        super().__init__(f"SWISH returned HTTP {status}: {text[:200]}")
+        self.status = status
+        self.text = text
+
+
+@dataclass(frozen=True)
+class RetryPolicy:
+    """How SwishHttp retries; retries=0 makes a single attempt."""
+    retries: int = 4
+    backoff: float = 0.25
+    max_backoff: float = 4.0
+    breaker_failures: int = 5
+    breaker_reset: float = 15.0
+
+    def delay(self, attempt: int) -> float:
+        """Full-jitter backoff before retry number attempt (from 0)."""
+        return random.uniform(0, min(self.max_backoff, self.backoff * 2 ** attempt))
+
+
+class CircuitBreaker:
+    """Opens after consecutive failures; half-open lets one trial through."""
+
+    def __init__(self, failures: int, reset_seconds: float):
+        self.threshold = failures
+        self.reset_seconds = reset_seconds
+        self.failures = 0
+        self.opened_at: float | None = None
+        self.trial = False
+
+    @property
+    def state(self) -> str:
+        if self.opened_at is None:
+            return "closed"
+        if time.monotonic() - self.opened_at >= self.reset_seconds:
+            return "half-open"
+        return "open"
+
+    def allow(self) -> bool:
+        state = self.state
+        if state == "closed":
+            return True
+        if state == "half-open" and not self.trial:
+            self.trial = True
+            return True
+        return False
+
+    def retry_in(self) -> float:
+        if self.opened_at is None:
+            return 0.0
+        return max(0.0, self.reset_seconds - (time.monotonic() - self.opened_at))
+
+    def success(self) -> None:
+        if self.opened_at is not None:
+            logger.info("SWISH is reachable again; closing the circuit")
+        self.failures = 0
+        self.opened_at = None
+        self.trial = False
+
+    def failure(self) -> None:
+        self.failures += 1
+        self.trial = False
+        if self.threshold > 0 and self.failures >= self.threshold:
+            if self.opened_at is None:
+                logger.warning(f"SWISH unreachable {self.failures} times in a row; opening the circuit")
+            self.opened_at = time.monotonic()
+
+
+@dataclass
+class HttpReply:
+    status: int
+    text: str
+
+    def json(self) -> Any:
+        return json.loads(self.text)
+
+
+class SwishHttp:
+    """
+    HTTP client for one SWISH container, with retries and a circuit breaker.
+
+    Args:
+        base_url: The container's URL, e.g. "http://localhost:3050"
+        policy: Retry and circuit breaker settings
+    """
+
+    def __init__(self, base_url: str, policy: RetryPolicy | None = None):
+        self.base_url = base_url.rstrip("/")
+        self.policy = policy or RetryPolicy()
+        self.breaker = CircuitBreaker(self.policy.breaker_failures, self.policy.breaker_reset)
+        self.retries = 0
+
+    async def request(
+        self,
+        method: str,
+        path: str,
+        timeout: float = 30,
+        idempotent: bool = True,
+        **kwargs: Any
+    ) -> HttpReply:
+        """
+        Send a request and return the reply; raises SwishRequestFailed on an error status.
+
+        Raises SwishUnavailable when SWISH cannot be reached within the
+        retries, and asyncio.TimeoutError when timeout runs out first.
+        """
+        if not self.breaker.allow():
+            raise SwishUnavailable(
+                f"SWISH at {self.base_url} failed {self.breaker.failures} requests in a row; "
+                f"not trying again for {self.breaker.retry_in():.0f}s"
+            )
+        attributes = {"http.request.method": method, "url.path": path.split("?", 1)[0], "server.address": self.base_url}
+        with telemetry.span(f"swish.http {method}", attributes) as span:
+            try:
+                reply = await self._request(method, path, timeout, idempotent, **kwargs)
+            except SwishRequestFailed as e:
+                span.set_attribute("http.response.status_code", e.status)
+                raise
+            except asyncio.TimeoutError:
+                # Too slow is not unreachable; let the next call be the trial
+                self.breaker.trial = False
+                raise
+            span.set_attribute("http.response.status_code", reply.status)
+            return reply
+
+    async def _request(self, method: str, path: str, timeout: float, idempotent: bool, **kwargs: Any) -> HttpReply:
+        loop = asyncio.get_running_loop()
+        deadline = loop.time() + timeout
+        attempt = 0
+        while True:
+            remaining = deadline - loop.time()
+            if remaining <= 0:
+                raise asyncio.TimeoutError
+            try:
+                reply = await self._attempt(method, path, remaining, **kwargs)
+            except aiohttp.ClientConnectorError as e:
+                # Refused before anything was sent, so always safe to repeat
+                problem = f"cannot connect ({e})"
+            except (aiohttp.ServerDisconnectedError, aiohttp.ClientOSError) as e:
+                if not idempotent:
+                    self.breaker.failure()
+                    raise SwishUnavailable(f"SWISH at {self.base_url} dropped the connection: {e}") from e
+                problem = f"connection lost ({e})"
+            else:
+                if reply.status not in RETRY_STATUSES:
+                    self.breaker.success()
+                    if reply.status >= 400:
+                        raise SwishRequestFailed(reply.status, reply.text)
+                    return reply
+                if not idempotent:
+                    self.breaker.failure()
+                    raise SwishRequestFailed(reply.status, reply.text)
+                problem = f"HTTP {reply.status}"
+
+            if attempt >= self.policy.retries:
+                self.breaker.failure()
+                raise SwishUnavailable(
+                    f"SWISH at {self.base_url} is not accepting requests ({problem}); "
+                    f"the container may still be starting, so try again shortly"
+                )
+            delay = self.policy.delay(attempt)
+            if loop.time() + delay >= deadline:
+                self.breaker.failure()
+                raise asyncio.TimeoutError
+            logger.debug(f"{method} {path}: {problem}, retrying in {delay:.2f}s")
+            self.retries += 1
+            attempt += 1
+            await asyncio.sleep(delay)
+
+    async def _attempt(self, method: str, path: str, timeout: float, **kwargs: Any) -> HttpReply:
+        headers = {**correlation_headers(), **kwargs.pop("headers", {})}
+        async with aiohttp.ClientSession() as session:
+            async with session.request(
+                method,
+                f"{self.base_url}{path}",
+                timeout=aiohttp.ClientTimeout(total=timeout),
+                headers=headers,
+                **kwargs
+            ) as response:
+                return HttpReply(response.status, await response.text())
+
+    async def probe(self, timeout: float = 3) -> bool:
+        """Whether SWISH answers GET / now: one attempt that ignores an open circuit."""
+        try:
+            reply = await self._attempt("GET", "/", timeout)
+        except (aiohttp.ClientError, asyncio.TimeoutError, OSError):
+            self.breaker.failure()
+            return False
+        if reply.status == 200:
+            self.breaker.success()
+            return True
+        return False
+
+    def get_status(self) -> dict[str, Any]:
+        return {
+            "circuit": self.breaker.state,
+            "consecutive_failures": self.breaker.failures,
+            "retries": self.retries,
+        }
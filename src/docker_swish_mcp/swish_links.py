@@ -0,0 +1,94 @@
+"""
+SWISH Web UI Links for Docker SWISH MCP
+
+share_program hands a program (and the query worked on) over to people
+using SWISH in a browser, in one of two ways:
+
+- a permalink: the program is saved in SWISH's gitty storage with a
+  POST to /p/, and SWISH replies with the /p/<name>.pl URL it is served
+  at. The query is kept as the file's examples block, so it shows up
+  in the editor's query pane
+- an editor link: /?code=<program>&q=<query> opens the editor prefilled
+  without saving anything; long programs make long URLs, so these are
+  capped at MAX_EDITOR_URL characters
+
+Links are built on SWISH_MCP_PUBLIC_URL when it is set (e.g. the address
+a reverse proxy publishes the container under), and on the container's
+own URL otherwise.
+"""
+
+import re
+from typing import Any
+from urllib.parse import urlencode, urljoin, urlsplit
+
+LINK_KINDS = ("permalink", "editor", "both")
+# Longest editor link most browsers and proxies reliably accept
+MAX_EDITOR_URL = 8000
+STORAGE_PATH = "/p/"
+
+# Characters SWISH allows in a storage file name (before .pl)
+STORAGE_NAME_RE = re.compile(r"^[A-Za-z0-9_-]+$")
+
+
+def with_examples(program: str, query: str) -> str:
+    """The program with query added as a SWISH examples block."""
+    if not query:
+        return program
+    goal = query.strip().rstrip(".").removeprefix("?-").strip()
+    return f"{program.rstrip()}\n\n/** <examples>\n\n?- {goal}.\n\n*/\n"
+
+
+def storage_payload(program: str, name: str = "", title: str = "", public: bool = False) -> dict[str, Any]:
+    """
+    Body of the POST that saves program in SWISH's storage.
+
+    Raises:
+        ValueError: if name is not a valid storage file name
+    """
+    meta: dict[str, Any] = {"public": public}
+    if name:
+        stem = name.removesuffix(".pl")
+        if not STORAGE_NAME_RE.match(stem):
+            raise ValueError(f"Invalid permalink name '{name}' (use letters, digits, '_' or '-')")
+        meta["name"] = f"{stem}.pl"
+    if title:
+        meta["title"] = title
+    return {"data": program, "type": "pl", "meta": meta}
+
+
+def public_link(base_url: str, url: str) -> str:
+    """url (absolute or a path, as SWISH replies) on base_url's host."""
+    path = urlsplit(url)
+    relative = path.path + (f"?{path.query}" if path.query else "")
+    return urljoin(base_url.rstrip("/") + "/", relative.lstrip("/"))
+
+
+def permalink(base_url: str, reply: dict[str, Any]) -> str:
+    """
+    The shareable URL of a storage reply.
+
+    Raises:
+        ValueError: if SWISH reported an error instead of a file
+    """
+    if reply.get("error") or not reply.get("url"):
+        raise ValueError(f"SWISH did not save the program: {reply.get('error') or reply}")
+    return public_link(base_url, str(reply["url"]))
+
+
+def editor_link(base_url: str, program: str, query: str = "") -> str:
+    """
+    A link that opens SWISH's editor with program and query filled in.
+
+    Raises:
+        ValueError: if the link would be longer than MAX_EDITOR_URL
+    """
+    params = {"code": program}
+    if query:
+        params["q"] = query.strip().rstrip(".")
+    link = f"{base_url.rstrip('/')}/?{urlencode(params)}"
+    if len(link) > MAX_EDITOR_URL:
+        raise ValueError(
+            f"The editor link would be {len(link)} characters (at most {MAX_EDITOR_URL}); "
+            "save a permalink instead"
+        )
+    return link
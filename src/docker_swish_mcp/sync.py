@@ -0,0 +1,288 @@
+"""
+Workspace Sync for Docker SWISH MCP
+
+With SWISH_MCP_SYNC_DIR (or --sync-dir) set, a host workspace, such as
+the repository a program is developed in, is kept in step with the data
+directory mounted at /data, in both directions:
+
+- files edited in the workspace are copied into the data directory, then
+  the persistent session runs make/0 so that files it consulted are
+  reloaded;
+- files changed in the data directory, by the MCP tools, by queries that
+  write files or by SWISH's editor saving a file opened from /data, are
+  copied back into the workspace.
+
+Both trees are polled every SWISH_MCP_SYNC_INTERVAL seconds, like the
+knowledge base watcher, as the standard library has no portable file
+notification API. Only source files (SYNC_SUFFIXES) are synced, and
+hidden directories and those the server manages itself are skipped.
+Symlinks are never followed: a path that is, or lies under, a symlink
+on either side is left alone, so that neither tree can read or write
+files outside itself through one.
+
+What was synced last is saved next to the data directory, so a file
+deleted on one side while the server was down is deleted on the other
+rather than copied back. On the first sync the workspace wins. When a
+file changed on both sides since the last sync, the newer version wins
+and the other is kept in the workspace as <file>.conflict.
+"""
+
+import asyncio
+import json
+import logging
+import os
+import shutil
+import time
+from collections.abc import Awaitable, Callable
+from dataclasses import dataclass, field
+from pathlib import Path, PurePath
+
+logger = logging.getLogger("docker-swish-mcp.sync")
+
+SYNC_SUFFIXES = (".pl", ".swinb", ".ttl", ".nt", ".rdf", ".owl")
+# Directories of derived files (url-cache) or of SWISH itself
+SKIPPED_DIRS = frozenset({"url-cache", "storage", "config-enabled"})
+CONFLICT_SUFFIX = ".conflict"
+
+
+def sync_state_path(data_dir: Path) -> Path:
+    return data_dir.parent / "swish-sync" / f"{data_dir.name}.json"
+
+
+def check_sync_dirs(workspace: Path, data_dir: Path) -> None:
+    """Raise ValueError unless the workspace and data directory are separate trees."""
+    workspace, data_dir = workspace.resolve(), data_dir.resolve()
+    if not workspace.is_dir():
+        raise ValueError(f"Sync workspace {workspace} is not a directory")
+    if workspace.is_relative_to(data_dir) or data_dir.is_relative_to(workspace):
+        raise ValueError(f"Sync workspace {workspace} and data directory {data_dir} must not contain each other")
+
+
+def is_synced(relative: PurePath) -> bool:
+    """Whether a file at relative is a source file outside hidden and managed directories."""
+    return relative.suffix in SYNC_SUFFIXES and not any(
+        part.startswith(".") or part in SKIPPED_DIRS for part in relative.parts
+    )
+
+
+def is_linked(root: Path, relative: str) -> bool:
+    """Whether root/relative, or a directory on the way to it, is a symlink."""
+    path = root
+    for part in PurePath(relative).parts:
+        path = path / part
+        if path.is_symlink():
+            return True
+    return False
+
+
+def scan_tree(root: Path) -> dict[str, int]:
+    """Map each synced file under root (relative path) to its mtime in ns, leaving out symlinks."""
+    if not root.is_dir():
+        return {}
+    files = {}
+    for path in root.rglob("*"):
+        relative = path.relative_to(root)
+        if not is_synced(relative):
+            continue
+        try:
+            if not is_linked(root, relative.as_posix()) and path.is_file():
+                files[relative.as_posix()] = path.stat().st_mtime_ns
+        except OSError:
+            continue
+    return files
+
+
+def _same_content(a: Path, b: Path) -> bool:
+    try:
+        return a.stat().st_size == b.stat().st_size and a.read_bytes() == b.read_bytes()
+    except OSError:
+        return False
+
+
+def _copy(source: Path, target: Path) -> None:
+    """Replace target with a copy of source without exposing a partial file."""
+    target.parent.mkdir(parents=True, exist_ok=True)
+    partial = target.with_name(f".{target.name}.sync")
+    shutil.copyfile(source, partial)
+    os.replace(partial, target)
+
+
+@dataclass
+class SyncReport:
+    """Files one sync pass copied or deleted, by relative path."""
+    to_data: list[str] = field(default_factory=list)
+    to_workspace: list[str] = field(default_factory=list)
+    deleted: list[str] = field(default_factory=list)
+    conflicts: list[str] = field(default_factory=list)
+
+    def __bool__(self) -> bool:
+        return bool(self.to_data or self.to_workspace or self.deleted or self.conflicts)
+
+    def describe(self) -> str:
+        parts = [
+            f"{len(self.to_data)} → data",
+            f"{len(self.to_workspace)} → workspace",
+            f"{len(self.deleted)} deleted",
+        ]
+        if self.conflicts:
+            parts.append(f"{len(self.conflicts)} conflict(s)")
+        return ", ".join(parts)
+
+
+class WorkspaceSync:
+    """
+    Two-way mirror of source files between a host workspace and the data directory.
+
+    Args:
+        workspace: Host directory to mirror
+        data_dir: Host data directory mounted at /data
+        on_data_changed: Coroutine called with the files copied into data_dir
+        interval: Seconds between polls
+    """
+
+    def __init__(
+        self,
+        workspace: Path,
+        data_dir: Path,
+        on_data_changed: Callable[[list[str]], Awaitable[None]],
+        interval: float = 2.0
+    ):
+        self.workspace = workspace
+        self.data_dir = data_dir
+        self.on_data_changed = on_data_changed
+        self.interval = interval
+        # Relative path -> (workspace mtime, data mtime) after the last sync of the file
+        self.synced: dict[str, tuple[int, int]] = {}
+        self.last_sync: float | None = None
+        self.last_report = SyncReport()
+        self.last_error = ""
+        self.lock = asyncio.Lock()
+
+    @property
+    def state_path(self) -> Path:
+        return sync_state_path(self.data_dir)
+
+    def load(self) -> None:
+        """Restore the last sync state, unless it was for another workspace."""
+        self.synced = {}
+        try:
+            saved = json.loads(self.state_path.read_text(encoding="utf-8"))
+        except FileNotFoundError:
+            return
+        except (OSError, ValueError) as e:
+            logger.warning(f"Ignoring sync state {self.state_path}: {e}")
+            return
+        if saved.get("workspace") != str(self.workspace.resolve()):
+            return
+        self.synced = {rel: (int(times[0]), int(times[1])) for rel, times in saved.get("files", {}).items()}
+
+    def save(self) -> None:
+        self.state_path.parent.mkdir(parents=True, exist_ok=True)
+        state = {"workspace": str(self.workspace.resolve()), "files": self.synced}
+        partial = self.state_path.with_suffix(".tmp")
+        partial.write_text(json.dumps(state, indent=2), encoding="utf-8")
+        os.replace(partial, self.state_path)
+
+    def set_data_dir(self, data_dir: Path) -> None:
+        """Follow the data directory to a new place, with that directory's sync state."""
+        self.data_dir = data_dir
+        self.load()
+
+    def _record(self, rel: str) -> None:
+        self.synced[rel] = (
+            (self.workspace / rel).stat().st_mtime_ns,
+            (self.data_dir / rel).stat().st_mtime_ns,
+        )
+
+    def _to_data(self, rel: str, report: SyncReport) -> None:
+        _copy(self.workspace / rel, self.data_dir / rel)
+        self._record(rel)
+        report.to_data.append(rel)
+
+    def _to_workspace(self, rel: str, report: SyncReport) -> None:
+        _copy(self.data_dir / rel, self.workspace / rel)
+        self._record(rel)
+        report.to_workspace.append(rel)
+
+    def _delete(self, path: Path, rel: str, report: SyncReport) -> None:
+        path.unlink(missing_ok=True)
+        self.synced.pop(rel, None)
+        report.deleted.append(rel)
+
+    def _resolve_conflict(self, rel: str, workspace_mtime: int, data_mtime: int, report: SyncReport) -> None:
+        kept = self.workspace / f"{rel}{CONFLICT_SUFFIX}"
+        if data_mtime > workspace_mtime:
+            _copy(self.workspace / rel, kept)
+            self._to_workspace(rel, report)
+        else:
+            _copy(self.data_dir / rel, kept)
+            self._to_data(rel, report)
+        logger.warning(f"⚠️ {rel} changed on both sides; the older version is kept as {kept}")
+        report.conflicts.append(rel)
+
+    def sync_once(self) -> SyncReport:
+        """Bring both trees in step; blocking, run it in a thread."""
+        workspace, data = scan_tree(self.workspace), scan_tree(self.data_dir)
+        report = SyncReport()
+        for rel in sorted(workspace.keys() | data.keys() | self.synced.keys()):
+            if is_linked(self.workspace, rel) or is_linked(self.data_dir, rel):
+                logger.debug(f"Not syncing {rel}: a symlink is on its path")
+                continue
+            w, d = workspace.get(rel), data.get(rel)
+            before = self.synced.get(rel)
+            if w is None and d is None:
+                self.synced.pop(rel, None)
+            elif before is None:
+                if w is None:
+                    self._to_workspace(rel, report)
+                elif d is None or not _same_content(self.workspace / rel, self.data_dir / rel):
+                    self._to_data(rel, report)
+                else:
+                    self._record(rel)
+            elif w is None:
+                # Deleted in the workspace; a newer edit in the data directory survives
+                if d == before[1]:
+                    self._delete(self.data_dir / rel, rel, report)
+                else:
+                    self._to_workspace(rel, report)
+            elif d is None:
+                if w == before[0]:
+                    self._delete(self.workspace / rel, rel, report)
+                else:
+                    self._to_data(rel, report)
+            elif w != before[0] and d != before[1]:
+                if _same_content(self.workspace / rel, self.data_dir / rel):
+                    self._record(rel)
+                else:
+                    self._resolve_conflict(rel, w, d, report)
+            elif w != before[0]:
+                self._to_data(rel, report)
+            elif d != before[1]:
+                self._to_workspace(rel, report)
+        self.save()
+        return report
+
+    async def run(self) -> SyncReport:
+        """One sync pass, then reload what changed in the data directory."""
+        async with self.lock:
+            try:
+                report = await asyncio.to_thread(self.sync_once)
+            except OSError as e:
+                self.last_error = str(e)
+                raise
+            self.last_sync = time.time()
+            self.last_error = ""
+            if report:
+                self.last_report = report
+                logger.info(f"🔁 Workspace sync: {report.describe()}")
+        if report.to_data or report.deleted:
+            await self.on_data_changed(report.to_data)
+        return report
+
+    async def watch(self) -> None:
+        while True:
+            try:
+                await self.run()
+            except Exception as e:
+                logger.warning(f"Workspace sync failed: {e}")
+            await asyncio.sleep(self.interval)
@@ -0,0 +1,106 @@
+"""
+Tabling Controls for Docker SWISH MCP
+
+A left-recursive rule such as
+
+    path(X, Y) :- path(X, Z), edge(Z, Y).
+    path(X, Y) :- edge(X, Y).
+
+loops forever under plain SLD resolution. Tabled (SLG resolution), it
+terminates with every answer. table_declare(["path/2"]) makes a
+predicate tabled at runtime with table/1, wrapping the clauses already
+loaded, so nothing in the source file has to change; table_remove
+undoes it with untable/1. Specifications are Name/Arity or a
+mode-directed head such as path(_, _, min) (answer subsumption), and
+mode picks variant (the default), subsumptive, incremental or shared
+tabling.
+
+Tables hold answers until abolished: table_abolish clears them all, or
+one predicate's, e.g. after the facts they were computed from changed
+(incremental tabling does this by itself for dynamic incremental
+predicates). table_statistics reports each tabled predicate's tables,
+answers and bytes, with table space used against the table_space flag.
+
+execute_prolog_query takes tabled=[...] and abolish_tables=True to do
+the same just before a query.
+"""
+
+import re
+from typing import Any
+
+from .prolog_memory import format_bytes
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+TABLE_MODES = ("variant", "subsumptive", "incremental", "shared")
+# Modes of the arguments of a mode-directed table specification
+ARGUMENT_MODE = r"(?:_|index|first|last|min|max|sum|(?:lattice|po)\([a-z]\w*(?:/\d+)?\))"
+SPEC_RE = re.compile(
+    rf"^[a-z]\w*(?:/\d+|\(\s*{ARGUMENT_MODE}(?:\s*,\s*{ARGUMENT_MODE})*\s*\))$"
+)
+INDICATOR_RE = re.compile(r"^[a-z]\w*/\d+$")
+
+
+def table_spec(text: str) -> str:
+    """text as a table/1 specification; raises ValueError unless it is a plain one."""
+    spec = text.strip()
+    if not SPEC_RE.match(spec):
+        raise ValueError(
+            f"Invalid table specification '{text}'; use Name/Arity (path/2) "
+            "or a mode-directed head (path(_, _, min))"
+        )
+    return spec
+
+
+def table_specs(predicates: list[str]) -> list[str]:
+    specs = [table_spec(predicate) for predicate in predicates]
+    if not specs:
+        raise ValueError("No predicates given; name them as Name/Arity, e.g. [\"path/2\"]")
+    return specs
+
+
+def table_call(module: str, predicates: list[str], mode: str = "variant") -> tuple[str, list[str]]:
+    if mode not in TABLE_MODES:
+        raise ValueError(f"Unknown tabling mode '{mode}'. Use: {', '.join(TABLE_MODES)}")
+    specs = ", ".join(prolog_string(spec) for spec in table_specs(predicates))
+    return "mcp_table", [prolog_atom(module), f"[{specs}]", mode]
+
+
+def untable_call(module: str, predicates: list[str]) -> tuple[str, list[str]]:
+    specs = ", ".join(prolog_string(spec) for spec in table_specs(predicates))
+    return "mcp_untable", [prolog_atom(module), f"[{specs}]"]
+
+
+def abolish_call(module: str, predicate: str = "") -> tuple[str, list[str]]:
+    """Abolish every table, or those of predicate (Name/Arity) in module."""
+    predicate = predicate.strip()
+    if predicate and not INDICATOR_RE.match(predicate):
+        raise ValueError(f"Invalid predicate indicator '{predicate}'; use Name/Arity, e.g. path/2")
+    return "mcp_table_abolish", [prolog_atom(module), prolog_string(predicate)]
+
+
+def stats_call(module: str) -> tuple[str, list[str]]:
+    return "mcp_table_stats", [prolog_atom(module)]
+
+
+def spec_errors(rows: list[dict[str, Any]]) -> list[str]:
+    return [f"{row['spec']}: {row['error']}" for row in rows if row.get("error")]
+
+
+def format_table_stats(module: str, rows: list[dict[str, Any]]) -> str:
+    predicates = [row for row in rows if "predicate" in row]
+    totals = next((row for row in rows if "table_space_used" in row), {})
+    lines = [f"📊 Tabled predicates in {module}: {len(predicates)}"]
+    for row in predicates:
+        modes = ", ".join(row.get("modes", [])) or "variant"
+        lines.append(
+            f"  • {row['predicate']} ({modes}): {row['tables']} table(s), "
+            f"{row['answers']} answer(s), {format_bytes(row['bytes'])}"
+        )
+    if not predicates:
+        lines.append("  (none; declare some with table_declare, e.g. [\"path/2\"])")
+    if totals:
+        limit = totals.get("table_space") or 0
+        of = f" of {format_bytes(limit)}" if limit else ""
+        lines.append(f"💾 Table space used: {format_bytes(totals['table_space_used'])}{of}")
+    return "\n".join(lines)
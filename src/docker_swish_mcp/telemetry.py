@@ -0,0 +1,168 @@
+"""
+OpenTelemetry Tracing for Docker SWISH MCP
+
+With SWISH_MCP_OTEL=on every tool call becomes a trace, with child spans
+for what it does on the way to Prolog:
+
+    tools/call execute_prolog_query      mcp.tool.name, mcp.client.id, mcp.correlation_id, mcp.tool.status
+    └─ prolog.query                      prolog.goal, prolog.solutions, prolog.outcome
+    tools/call lint_program
+    └─ container.exec                    container.name, process.command, process.exit.code
+    tools/call pengine_create
+    └─ swish.http POST                   url.path, http.response.status_code
+
+Spans are exported over OTLP, configured with the standard OTEL_*
+variables (OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_PROTOCOL
+grpc or http/protobuf, OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME).
+This needs the otel extra (pip install 'docker-swish-mcp[otel]');
+without it, or with SWISH_MCP_OTEL=off, spans cost nothing.
+
+Goals are recorded as written by default. SWISH_MCP_OTEL_GOALS=redacted
+replaces their atoms, numbers and strings with ?, keeping the shape of
+the goal (parent(?, X)), and omit leaves them out.
+"""
+
+import logging
+import os
+import re
+from collections.abc import Callable, Iterator
+from contextlib import contextmanager
+from typing import Any
+
+from .request_logging import correlation_id
+
+logger = logging.getLogger("docker-swish-mcp.telemetry")
+
+GOAL_MODES = ("full", "redacted", "omit")
+SERVICE_NAME = "docker-swish-mcp"
+
+# Quoted atoms, strings and back-quoted text, then numbers, then plain atoms
+QUOTED_RE = re.compile(r"'(?:[^'\\]|\\.|'')*'|\"(?:[^\"\\]|\\.)*\"|`(?:[^`\\]|\\.)*`")
+NUMBER_RE = re.compile(r"(?<![\w.])\d+(?:\.\d+)?(?:[eE][+-]?\d+)?(?:'\w+)?")
+ATOM_RE = re.compile(r"(?<![\w])[a-z]\w*(?!\w|\s*\()")
+# Atoms read as operators or control constructs, which say nothing about the data
+KEPT_ATOMS = frozenset({"is", "mod", "rem", "div", "rdiv", "xor", "true", "fail", "false", "not"})
+
+
+def redact_goal(goal: str) -> str:
+    """The goal with its constants replaced by ?; functors and variables stay."""
+    goal = QUOTED_RE.sub("?", goal)
+    goal = NUMBER_RE.sub("?", goal)
+    return ATOM_RE.sub(lambda m: m.group(0) if m.group(0) in KEPT_ATOMS else "?", goal)
+
+
+def present(attributes: dict[str, Any] | None) -> dict[str, Any]:
+    """attributes without those that are None, which OpenTelemetry rejects."""
+    return {key: value for key, value in (attributes or {}).items() if value is not None}
+
+
+class NoSpan:
+    """Stands in for a span while tracing is off."""
+
+    def set_attribute(self, key: str, value: Any) -> None:
+        pass
+
+    def end(self) -> None:
+        pass
+
+
+class Telemetry:
+    """The server's tracer; a no-op until configure() turns it on."""
+
+    def __init__(self) -> None:
+        self.tracer: Any = None
+        self.provider: Any = None
+        self.goals = "full"
+
+    @property
+    def enabled(self) -> bool:
+        return self.tracer is not None
+
+    def configure(self, mode: str, goals: str, version: str) -> None:
+        """Start exporting spans over OTLP when mode is "on"."""
+        self.goals = goals
+        if mode != "on" or self.enabled:
+            return
+        try:
+            from opentelemetry import trace
+            from opentelemetry.sdk.resources import Resource
+            from opentelemetry.sdk.trace import TracerProvider
+            from opentelemetry.sdk.trace.export import BatchSpanProcessor
+            if os.environ.get("OTEL_EXPORTER_OTLP_PROTOCOL", "").strip() == "grpc":
+                from opentelemetry.exporter.otlp.proto.grpc.trace_exporter import (
+                    OTLPSpanExporter,
+                )
+            else:
+                from opentelemetry.exporter.otlp.proto.http.trace_exporter import (
+                    OTLPSpanExporter,
+                )
+        except ImportError:
+            logger.warning("⚠️ SWISH_MCP_OTEL=on needs the otel extra: pip install 'docker-swish-mcp[otel]'")
+            return
+        resource = Resource.create({
+            "service.name": os.environ.get("OTEL_SERVICE_NAME", SERVICE_NAME),
+            "service.version": version,
+        })
+        self.provider = TracerProvider(resource=resource)
+        self.provider.add_span_processor(BatchSpanProcessor(OTLPSpanExporter()))
+        self.tracer = self.provider.get_tracer(SERVICE_NAME, version)
+        trace.set_tracer_provider(self.provider)
+        logger.info("🔭 Exporting OpenTelemetry spans over OTLP")
+
+    def shutdown(self) -> None:
+        """Flush the spans not exported yet."""
+        if self.provider is not None:
+            try:
+                self.provider.shutdown()
+            except Exception as e:
+                logger.debug(f"Span export shutdown: {e}")
+
+    def goal(self, text: str) -> str | None:
+        """A goal as SWISH_MCP_OTEL_GOALS says to record it; None to leave it out."""
+        if self.goals == "omit":
+            return None
+        return redact_goal(text) if self.goals == "redacted" else text
+
+    def open_span(self, name: str, attributes: dict[str, Any] | None = None) -> Any:
+        """
+        A span under the current one that the caller ends.
+
+        For async generators, which must not change the current span of
+        whoever is iterating them.
+        """
+        if self.tracer is None:
+            return NoSpan()
+        return self.tracer.start_span(name, attributes=present(attributes))
+
+    @contextmanager
+    def span(self, name: str, attributes: dict[str, Any] | None = None) -> Iterator[Any]:
+        """A span, current for the body; attributes that are None are left out."""
+        if self.tracer is None:
+            yield NoSpan()
+            return
+        with self.tracer.start_as_current_span(name, attributes=present(attributes)) as span:
+            yield span
+
+
+telemetry = Telemetry()
+
+
+def instrument_tool_spans(
+    server: Any,
+    client_id: Callable[[], str],
+    failed: Callable[[Any], bool]
+) -> None:
+    """Open a tools/call span around every tool call of a FastMCP server."""
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        if not telemetry.enabled:
+            return await base_call_tool(name, arguments, *args, **kwargs)
+        attributes = {"mcp.tool.name": name, "mcp.client.id": client_id(), "mcp.correlation_id": correlation_id.get()}
+        with telemetry.span(f"tools/call {name}", attributes) as span:
+            result = await base_call_tool(name, arguments, *args, **kwargs)
+            span.set_attribute("mcp.tool.status", "error" if failed(result) else "ok")
+            return result
+
+    tool_manager.call_tool = call_tool
@@ -0,0 +1,346 @@
+"""
+Query Templates for Docker SWISH MCP
+
+template_register stores a goal with {{name}} placeholders and the type
+of each parameter, e.g.
+
+    goal:   "ancestor({{person}}, Descendant), age(Descendant, Age), Age >= {{min_age}}"
+    params: {"person": "atom", "min_age": {"type": "integer", "default": 18}}
+
+template_run then takes just the values. Each value is checked against
+its parameter's type and written as a Prolog literal of that type (a
+quoted atom, a string, a number, a list), never pasted in as text, so a
+value such as "bob), retractall(x(_)" stays one atom instead of becoming
+part of the goal.
+
+Placeholders stand for whole terms; they may not appear inside quotes.
+Templates are saved next to the data directory, like scheduled queries,
+so they survive restarts.
+"""
+
+import json
+import logging
+import math
+import re
+import time
+from dataclasses import asdict, dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .rdf import prolog_atom
+from .simple_session import prolog_string
+
+logger = logging.getLogger("docker-swish-mcp.templates")
+
+SCALAR_TYPES = ("atom", "string", "integer", "float", "number", "boolean")
+PARAM_TYPES = SCALAR_TYPES + tuple(f"list[{name}]" for name in SCALAR_TYPES)
+PLACEHOLDER_RE = re.compile(r"\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}")
+PARAM_NAME_RE = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*$")
+TEMPLATE_NAME_RE = re.compile(r"^[A-Za-z][A-Za-z0-9_.-]*$")
+INTEGER_RE = re.compile(r"^[+-]?\d+$")
+MAX_TEMPLATES = 200
+# A value of each type, for checking a template's goal when it is registered
+SAMPLE_VALUES = {"atom": "a", "string": "s", "integer": 0, "float": 0.0, "number": 0, "boolean": True}
+
+
+def templates_path(data_dir: Path) -> Path:
+    """Template file for a data directory, kept outside the mount like the job file."""
+    return data_dir.parent / "swish-templates" / f"{data_dir.name}.json"
+
+
+def _scalar_literal(kind: str, value: Any, name: str) -> str:
+    def wrong() -> ValueError:
+        return ValueError(f"Parameter '{name}' must be {'an' if kind[0] in 'ai' else 'a'} {kind}, got {value!r}")
+
+    if kind == "atom":
+        if not isinstance(value, str):
+            raise wrong()
+        return prolog_atom(value)
+    if kind == "string":
+        if not isinstance(value, str):
+            raise wrong()
+        return prolog_string(value)
+    if kind == "boolean":
+        if not isinstance(value, bool):
+            raise wrong()
+        return "true" if value else "false"
+    if isinstance(value, bool):
+        raise wrong()
+    if kind == "integer":
+        if isinstance(value, str) and INTEGER_RE.match(value.strip()):
+            return str(int(value))
+        if isinstance(value, int) or (isinstance(value, float) and value.is_integer()):
+            return str(int(value))
+        raise wrong()
+    if kind not in ("float", "number"):
+        raise ValueError(f"Parameter '{name}' has unknown type '{kind}'")
+    if kind == "number" and isinstance(value, int):
+        return str(value)
+    try:
+        number = float(value)
+    except (TypeError, ValueError):
+        raise wrong() from None
+    if not math.isfinite(number):
+        raise wrong()
+    if kind == "number" and number.is_integer() and not isinstance(value, float):
+        return str(int(number))
+    text = repr(number)
+    # Python writes 1e-05 and 100.0; Prolog floats need the dot before the exponent
+    mantissa, _, exponent = text.partition("e")
+    if "." not in mantissa:
+        mantissa += ".0"
+    return f"{mantissa}e{exponent}" if exponent else mantissa
+
+
+def infer_literal(value: Any, name: str) -> str:
+    """
+    value as a Prolog literal of the type its JSON form suggests.
+
+    Text is an atom, {"string": text} a string; numbers, booleans and
+    lists (of any of these) are written as such.
+    """
+    if isinstance(value, bool):
+        return _scalar_literal("boolean", value, name)
+    if isinstance(value, str):
+        return _scalar_literal("atom", value, name)
+    if isinstance(value, (int, float)):
+        return _scalar_literal("number", value, name)
+    if isinstance(value, list):
+        return "[" + ",".join(infer_literal(element, name) for element in value) + "]"
+    if isinstance(value, dict) and set(value) == {"string"}:
+        return _scalar_literal("string", value["string"], name)
+    raise ValueError(
+        f"Value of '{name}' must be text (an atom), {{\"string\": text}}, a number, a boolean or a list, got {value!r}"
+    )
+
+
+@dataclass
+class TemplateParam:
+    """A typed parameter; default None makes it required."""
+    name: str
+    type: str = "atom"
+    default: Any = None
+    description: str = ""
+    # Values it may take; empty allows any of its type
+    choices: list[Any] = field(default_factory=list)
+
+    @classmethod
+    def parse(cls, name: str, spec: Any) -> "TemplateParam":
+        """A parameter from "type" or {"type", "default", "description", "choices"}."""
+        if not PARAM_NAME_RE.match(name):
+            raise ValueError(f"Invalid parameter name '{name}' (use letters, digits and '_')")
+        if isinstance(spec, str):
+            spec = {"type": spec}
+        if not isinstance(spec, dict):
+            raise ValueError(f"Parameter '{name}' must be a type name or an object with a \"type\"")
+        unknown = sorted(set(spec) - {"type", "default", "description", "choices"})
+        if unknown:
+            raise ValueError(f"Parameter '{name}' has unknown keys {unknown}")
+        kind = str(spec.get("type", "atom")).strip()
+        if kind not in PARAM_TYPES:
+            raise ValueError(f"Parameter '{name}' has unknown type '{kind}'. Use: {', '.join(PARAM_TYPES)}")
+        choices = spec.get("choices") or []
+        if not isinstance(choices, list):
+            raise ValueError(f"Parameter '{name}': choices must be a list")
+        param = cls(name, kind, spec.get("default"), str(spec.get("description", "")), choices)
+        for value in choices:
+            param.literal(value, check_choices=False)
+        if param.default is not None:
+            param.literal(param.default)
+        return param
+
+    @property
+    def required(self) -> bool:
+        return self.default is None
+
+    def literal(self, value: Any, check_choices: bool = True) -> str:
+        """value as a Prolog literal of the parameter's type; raises ValueError if it is not one."""
+        if check_choices and self.choices and value not in self.choices:
+            allowed = ", ".join(json.dumps(choice) for choice in self.choices)
+            raise ValueError(f"Parameter '{self.name}' must be one of {allowed}, got {value!r}")
+        if self.type.startswith("list["):
+            if not isinstance(value, list):
+                raise ValueError(f"Parameter '{self.name}' must be a list, got {value!r}")
+            item = self.type[len("list["):-1]
+            return "[" + ",".join(_scalar_literal(item, element, self.name) for element in value) + "]"
+        return _scalar_literal(self.type, value, self.name)
+
+    def sample(self) -> str:
+        if self.default is not None:
+            return self.literal(self.default)
+        if self.choices:
+            return self.literal(self.choices[0])
+        if self.type.startswith("list["):
+            return "[]"
+        return self.literal(SAMPLE_VALUES[self.type])
+
+    def describe(self) -> str:
+        text = f"{self.name}: {self.type}"
+        if self.choices:
+            text += f" one of {', '.join(json.dumps(choice) for choice in self.choices)}"
+        if not self.required:
+            text += f" = {json.dumps(self.default)}"
+        if self.description:
+            text += f" - {self.description}"
+        return text
+
+
+def quoted_placeholders(goal: str) -> list[str]:
+    """Placeholders inside quoted atoms, strings or back-quoted text of goal."""
+    found = []
+    quote = ""
+    i = 0
+    while i < len(goal):
+        char = goal[i]
+        if quote:
+            if char == "\\":
+                i += 1
+            elif char == quote:
+                if goal[i + 1:i + 2] == quote:
+                    i += 1
+                else:
+                    quote = ""
+            elif goal.startswith("{{", i):
+                match = PLACEHOLDER_RE.match(goal, i)
+                if match:
+                    found.append(match.group(1))
+        elif char == "%":
+            # Line comment
+            end = goal.find("\n", i)
+            i = len(goal) if end < 0 else end
+        elif char in "'\"`":
+            # 0'c is a character code, not a quote
+            if char == "'" and i > 0 and goal[i - 1] == "0" and (i < 2 or not goal[i - 2].isalnum()):
+                i += 2
+                continue
+            quote = char
+        i += 1
+    return found
+
+
+@dataclass
+class QueryTemplate:
+    name: str
+    goal: str
+    params: list[TemplateParam]
+    description: str = ""
+    client: str = ""
+    created: float = field(default_factory=time.time)
+
+    @classmethod
+    def build(cls, name: str, goal: str, params: dict[str, Any], description: str = "", client: str = "") -> "QueryTemplate":
+        """A checked template; raises ValueError for bad names, types or placeholders."""
+        if not TEMPLATE_NAME_RE.match(name):
+            raise ValueError(f"Invalid template name '{name}' (use letters, digits, '_', '.' or '-')")
+        goal = goal.strip().removesuffix(".").rstrip()
+        if goal.startswith("?-"):
+            goal = goal[2:].lstrip()
+        if not goal:
+            raise ValueError("Empty goal provided")
+        declared = [TemplateParam.parse(param, spec) for param, spec in params.items()]
+        used = set(PLACEHOLDER_RE.findall(goal))
+        names = {param.name for param in declared}
+        undeclared = sorted(used - names)
+        if undeclared:
+            raise ValueError(f"Placeholders {undeclared} have no parameter; declare their types in params")
+        unused = sorted(names - used)
+        if unused:
+            raise ValueError(f"Parameters {unused} do not appear in the goal as {{{{name}}}}")
+        quoted = quoted_placeholders(goal)
+        if quoted:
+            raise ValueError(
+                f"Placeholders {sorted(set(quoted))} are inside quotes; "
+                "write {{name}} on its own, the value is quoted for its type"
+            )
+        return cls(name, goal, declared, description, client)
+
+    def substitute(self, literals: dict[str, str]) -> str:
+        return PLACEHOLDER_RE.sub(lambda match: literals[match.group(1)], self.goal)
+
+    def render(self, values: dict[str, Any]) -> str:
+        """The goal with values in place; raises ValueError for unknown, missing or mistyped values."""
+        params = {param.name: param for param in self.params}
+        unknown = sorted(set(values) - set(params))
+        if unknown:
+            raise ValueError(f"Template '{self.name}' has no parameters {unknown} (it takes: {', '.join(params) or 'none'})")
+        literals = {}
+        for param in self.params:
+            if param.name in values:
+                literals[param.name] = param.literal(values[param.name])
+            elif param.required:
+                raise ValueError(f"Template '{self.name}' needs a value for '{param.name}' ({param.type})")
+            else:
+                literals[param.name] = param.literal(param.default)
+        return self.substitute(literals)
+
+    def sample(self) -> str:
+        """The goal with a value of each parameter's type, for checking it."""
+        return self.substitute({param.name: param.sample() for param in self.params})
+
+    def signature(self) -> str:
+        return f"{self.name}({', '.join(param.name if param.required else f'{param.name}?' for param in self.params)})"
+
+    def describe(self) -> str:
+        lines = [f"🧩 {self.signature()}: {self.goal}"]
+        if self.description:
+            lines.append(f"   {self.description}")
+        lines.extend(f"   • {param.describe()}" for param in self.params)
+        return "\n".join(lines)
+
+    def to_json(self) -> dict[str, Any]:
+        return asdict(self)
+
+    @classmethod
+    def from_json(cls, data: dict[str, Any]) -> "QueryTemplate":
+        params = [TemplateParam(**param) for param in data.pop("params", [])]
+        return cls(**data, params=params)
+
+
+class TemplateRegistry:
+    """Registered templates by name, saved to a file once loaded from it."""
+
+    def __init__(self, max_templates: int = MAX_TEMPLATES):
+        self.max_templates = max_templates
+        self.templates: dict[str, QueryTemplate] = {}
+        self.path: Path | None = None
+
+    def load(self, path: Path) -> None:
+        """Read saved templates; an unreadable file is logged and left alone."""
+        self.path = path
+        self.templates = {}
+        if not path.exists():
+            return
+        try:
+            entries = json.loads(path.read_text(encoding="utf-8"))
+            templates = [QueryTemplate.from_json(entry) for entry in entries]
+        except (OSError, ValueError, TypeError) as e:
+            logger.error(f"Could not load query templates from {path}: {e}")
+            return
+        self.templates = {template.name: template for template in templates}
+        if templates:
+            logger.info(f"🧩 Loaded {len(templates)} query templates from {path}")
+
+    def save(self) -> None:
+        if self.path is None:
+            return
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        tmp = self.path.with_suffix(".tmp")
+        tmp.write_text(json.dumps([t.to_json() for t in self.templates.values()], indent=2), encoding="utf-8")
+        tmp.replace(self.path)
+
+    def add(self, template: QueryTemplate, replace: bool = False) -> None:
+        if template.name in self.templates and not replace:
+            raise ValueError(f"Template '{template.name}' already exists; pass replace=True to overwrite it")
+        if template.name not in self.templates and len(self.templates) >= self.max_templates:
+            raise ValueError(f"There are already {self.max_templates} templates; delete one with template_delete")
+        self.templates[template.name] = template
+
+    def get(self, name: str) -> QueryTemplate:
+        template = self.templates.get(name)
+        if template is None:
+            known = ", ".join(sorted(self.templates)) or "none"
+            raise ValueError(f"Unknown template '{name}' (known: {known})")
+        return template
+
+    def remove(self, name: str) -> QueryTemplate:
+        return self.templates.pop(self.get(name).name)
@@ -0,0 +1,166 @@
+"""
+Time-Travel Queries for Docker SWISH MCP
+
+execute_prolog_query(query, as_of=...) answers a goal against the
+knowledge base as it was at a past moment, to find out why something
+that worked yesterday does not today. The moment is a timestamp
+("2026-10-13 09:00", ISO 8601, local time unless it has an offset), a
+Unix time, a span back from now ("2h ago", "-30m", "1d") or an entry of
+kb_history() ("#12": just after that change).
+
+The audit log keeps the clauses each logged change added and removed
+(see audit.py), so the state at the moment is rebuilt backwards: start
+from the client module's dynamic database now and undo every database
+change logged after the moment, newest first (restored clauses go to
+the end of their predicates, so clause order may differ). The result is
+loaded into a temporary module together with a copy of the module's
+static predicates (mcp_asof_load/4), the goal runs there, and the module
+is destroyed again; whatever the goal asserts is discarded with it.
+
+Only the dynamic database travels. Files consulted since are used as
+loaded now, which the answer points out, and changes the log does not
+see (consults, fact feeds, the shared fact store, restarts) are not
+undone. Changes logged without their clauses (before the log kept them,
+or larger than MAX_DELTA_CLAUSES) cannot be replayed; as_of goes back
+no further than the most recent one.
+"""
+
+import re
+import time
+import uuid
+from collections.abc import Iterable
+from dataclasses import dataclass
+from datetime import datetime
+from typing import Any
+
+from .audit import AuditEntry, restore_call
+from .rdf import prolog_atom
+
+SCRATCH_PREFIX = "mcp_asof_"
+SPAN_RE = re.compile(r"^(?:-\s*(?P<back>\d+(?:\.\d+)?)\s*(?P<back_unit>[smhdw])|(?P<ago>\d+(?:\.\d+)?)\s*(?P<ago_unit>[smhdw])(?:\s+ago)?)$", re.I)
+UNIT_SECONDS = {"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+# A file change summary of the audit log, e.g. "modified family.pl"
+FILE_CHANGE_RE = re.compile(r"^(?:created|modified|deleted) (.+)$")
+
+
+@dataclass(frozen=True)
+class AsOf:
+    """A past moment: a time, or just after an audit log entry."""
+    time: float | None = None
+    seq: int | None = None
+
+    @classmethod
+    def parse(cls, text: str, now: float | None = None) -> "AsOf":
+        """Raises ValueError for text that is not a moment in the past."""
+        now = time.time() if now is None else now
+        text = text.strip()
+        if text.startswith("#"):
+            if not text[1:].isdigit():
+                raise ValueError(f"as_of '#N' takes a kb_history() entry number; got '{text}'")
+            return cls(seq=int(text[1:]))
+        span = SPAN_RE.match(text)
+        if span:
+            amount = float(span.group("back") or span.group("ago"))
+            unit = (span.group("back_unit") or span.group("ago_unit")).lower()
+            return cls(time=now - amount * UNIT_SECONDS[unit])
+        try:
+            moment = float(text)
+        except ValueError:
+            try:
+                moment = datetime.fromisoformat(text).timestamp()
+            except ValueError:
+                raise ValueError(
+                    f"as_of takes a timestamp such as \"2026-10-13 09:00\", a Unix time, "
+                    f"a span such as \"2h ago\" or a kb_history() entry such as \"#12\"; got '{text}'"
+                )
+        if moment > now:
+            raise ValueError(f"as_of {text} is in the future")
+        return cls(time=moment)
+
+    def after(self, entry: AuditEntry) -> bool:
+        """Whether entry was logged after this moment."""
+        if self.seq is not None:
+            return entry.seq > self.seq
+        return entry.time > (self.time or 0)
+
+    def describe(self) -> str:
+        if self.seq is not None:
+            return f"just after #{self.seq}"
+        return time.strftime("%Y-%m-%d %H:%M:%S", time.localtime(self.time or 0))
+
+
+def scratch_module() -> str:
+    return f"{SCRATCH_PREFIX}{uuid.uuid4().hex[:8]}"
+
+
+def _remove_last(clauses: list[str], clause: str) -> None:
+    # Asserted clauses usually went to the end, so the last copy is the one added
+    for index in range(len(clauses) - 1, -1, -1):
+        if clauses[index] == clause:
+            del clauses[index]
+            return
+
+
+def replay_database(
+    entries: Iterable[AuditEntry],
+    current: list[dict[str, Any]],
+    module: str,
+    moment: AsOf
+) -> tuple[list[dict[str, Any]], list[str]]:
+    """
+    A module's dynamic database at moment, from its current one and the entries logged since.
+
+    Returns the predicates in mcp_db_snapshot/2's form and notes on how
+    faithful they are. Raises ValueError if a change after moment was
+    logged without its clauses.
+    """
+    state = {f"{row['name']}/{row['arity']}": list(row["clauses"]) for row in current}
+    entries = list(entries)
+    later = [entry for entry in entries if moment.after(entry)]
+    if moment.seq is not None and not any(entry.seq == moment.seq for entry in entries):
+        raise ValueError(f"kb_history() has no entry #{moment.seq}")
+    files: set[str] = set()
+    undone = 0
+    for entry in reversed(later):
+        if entry.kind == "files":
+            files.update(match.group(1) for match in map(FILE_CHANGE_RE.match, entry.changes) if match)
+            continue
+        if entry.delta is None:
+            raise ValueError(
+                f"#{entry.seq} ({entry.tool}) was logged without its clauses, so the database before it "
+                f"cannot be rebuilt; use an as_of of #{entry.seq} or later"
+            )
+        for key, change in entry.delta.get(module, {}).items():
+            clauses = state.setdefault(key, [])
+            for clause in change["added"]:
+                _remove_last(clauses, clause)
+            clauses.extend(change["removed"])
+        if module in entry.delta:
+            undone += 1
+    notes = [f"Rebuilt by undoing {undone} logged database change(s) since"] if undone else [
+        "No logged database change since"
+    ]
+    notes[0] += f" {moment.describe()}"
+    if entries and moment.seq is None and moment.time is not None and moment.time < entries[0].time:
+        notes.append(f"The log starts at #{entries[0].seq}; changes before it are not known")
+    if files:
+        notes.append(f"Files changed since, used as loaded now: {', '.join(sorted(files))}")
+    predicates = []
+    for key, clauses in sorted(state.items()):
+        name, _, arity = key.rpartition("/")
+        predicates.append({"name": name, "arity": int(arity), "clauses": clauses})
+    return predicates, notes
+
+
+def asof_load_call(module: str, scratch: str, predicates: list[dict[str, Any]]) -> tuple[str, list[str]]:
+    """mcp_asof_load/4 call giving scratch module's static predicates and the historical database."""
+    _helper, (_module, terms) = restore_call(predicates, module)
+    return "mcp_asof_load", [prolog_atom(module), prolog_atom(scratch), terms]
+
+
+def asof_drop_call(scratch: str) -> tuple[str, list[str]]:
+    return "mcp_asof_drop", [prolog_atom(scratch)]
+
+
+def format_notes(notes: list[str]) -> str:
+    return "\n".join(f"🕰️ {note}" for note in notes)
@@ -0,0 +1,191 @@
+"""
+Tool Exposure Profiles for Docker SWISH MCP
+
+Scopes (see auth.py) stop a key from calling tools it may not use, but
+every client still sees every tool in tools/list, and an agent offered
+a destructive tool sooner or later calls it. A profile is the set of
+tools a connection is shown and may call:
+
+- analyst: the query scope's tools (queries, search, inspection)
+- developer: the write scope's tools as well (files, consults, edits)
+- admin: every tool, container controls included
+
+SWISH_MCP_PROFILES adds or redefines profiles, as JSON or the path of a
+JSON file, starting from a scope's tools and adding or removing tools
+by name or glob pattern:
+
+    {"profiles": {
+        "reviewer": {"scope": "query", "exclude": ["repl_*", "fact_feed_*"]},
+        "ops": {"scope": "query", "include": ["restart_prolog_session", "swish_logs"]}
+    }}
+
+Which profile a connection gets:
+
+- with an API key, the key's "profile", or the one matching its
+  scope (query: analyst, write: developer, admin: admin)
+- without one (stdio, or HTTP without keys), SWISH_MCP_PROFILE,
+  default admin
+
+A client may narrow that further for its own connection with the
+X-MCP-Profile header; it is never shown tools outside the profile it
+was given, and an unknown profile name shows it none.
+"""
+
+import fnmatch
+import json
+import logging
+import os
+from collections.abc import Callable
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+from mcp.server.fastmcp import FastMCP
+from mcp.server.fastmcp.exceptions import ToolError
+
+from .auth import IMPLIED_SCOPES, SCOPES, ApiKey, required_scope
+
+logger = logging.getLogger("docker-swish-mcp.profiles")
+
+# Request header a client narrows its connection's profile with
+PROFILE_HEADER = "x-mcp-profile"
+# Profile of a key by its widest scope
+SCOPE_PROFILES = {"query": "analyst", "write": "developer", "admin": "admin"}
+
+
+@dataclass(frozen=True)
+class ToolProfile:
+    name: str
+    # Tools of this scope (and the scopes it implies) are in the profile
+    scope: str
+    # Tool names or glob patterns added to, or removed from, the scope's tools
+    include: tuple[str, ...] = ()
+    exclude: tuple[str, ...] = ()
+
+    def allows(self, tool: str) -> bool:
+        if any(fnmatch.fnmatchcase(tool, pattern) for pattern in self.exclude):
+            return False
+        if required_scope(tool) in IMPLIED_SCOPES[self.scope]:
+            return True
+        return any(fnmatch.fnmatchcase(tool, pattern) for pattern in self.include)
+
+    def describe(self) -> str:
+        parts = [f"{self.name}: {self.scope} tools"]
+        if self.include:
+            parts.append(f"plus {', '.join(self.include)}")
+        if self.exclude:
+            parts.append(f"without {', '.join(self.exclude)}")
+        return " ".join(parts)
+
+
+BUILTIN_PROFILES = {name: ToolProfile(name, scope) for scope, name in SCOPE_PROFILES.items()}
+
+# Shown nothing: the profile of a connection asking for one that does not exist
+NO_TOOLS = ToolProfile("none", "query", exclude=("*",))
+
+
+def _patterns(entry: dict[str, Any], key: str, name: str) -> tuple[str, ...]:
+    value = entry.get(key, [])
+    if isinstance(value, str):
+        value = [value]
+    if not isinstance(value, list) or not all(isinstance(item, str) for item in value):
+        raise ValueError(f"Profile '{name}': {key} must be a list of tool names or patterns")
+    return tuple(value)
+
+
+def parse_profiles(raw: Any) -> dict[str, ToolProfile]:
+    entries = raw.get("profiles", raw) if isinstance(raw, dict) else None
+    if not isinstance(entries, dict):
+        raise ValueError("Profiles must be an object of profile names, or one with a \"profiles\" object")
+    profiles = {}
+    for name, entry in entries.items():
+        if not isinstance(entry, dict):
+            raise ValueError(f"Profile '{name}' must be an object")
+        scope = entry.get("scope", "query")
+        if scope not in SCOPES:
+            raise ValueError(f"Profile '{name}' has unknown scope '{scope}'. Use: {', '.join(SCOPES)}")
+        profiles[name] = ToolProfile(name, scope, _patterns(entry, "include", name), _patterns(entry, "exclude", name))
+    return profiles
+
+
+class ProfileSet:
+    """
+    The built-in and configured profiles, and the default of connections without a key.
+
+    Args:
+        source: JSON text or path of a JSON file with extra profiles (SWISH_MCP_PROFILES)
+        default: Profile of connections without an API key (SWISH_MCP_PROFILE)
+    """
+
+    def __init__(self, source: str = "", default: str = "admin"):
+        self.profiles = dict(BUILTIN_PROFILES)
+        source = source.strip()
+        if source:
+            path = None if source.startswith("{") else Path(source).expanduser()
+            try:
+                text = path.read_text(encoding="utf-8") if path else source
+                self.profiles.update(parse_profiles(json.loads(text)))
+            except (OSError, ValueError) as e:
+                # Fail closed: a broken definition must not leave every tool exposed
+                logger.error(f"❌ Cannot read SWISH_MCP_PROFILES, exposing only the analyst profile: {e}")
+                default = "analyst"
+        if default not in self.profiles:
+            logger.error(f"❌ Unknown SWISH_MCP_PROFILE '{default}', using analyst")
+            default = "analyst"
+        self.default = default
+
+    @classmethod
+    def from_env(cls) -> "ProfileSet":
+        return cls(
+            os.environ.get("SWISH_MCP_PROFILES", ""),
+            os.environ.get("SWISH_MCP_PROFILE", "").strip() or "admin",
+        )
+
+    def key_profile(self, key: ApiKey | None) -> ToolProfile:
+        """The profile a key (None: no key) gives its connections."""
+        if key is None:
+            return self.profiles[self.default]
+        name = key.profile or next(SCOPE_PROFILES[scope] for scope in reversed(SCOPES) if key.allows(scope))
+        return self.profiles.get(name, NO_TOOLS)
+
+    def connection_profiles(self, key: ApiKey | None, requested: str = "") -> list[ToolProfile]:
+        """Profiles a tool must be in for a connection: its key's, and the one it asked for."""
+        profiles = [self.key_profile(key)]
+        if requested:
+            profiles.append(self.profiles.get(requested, NO_TOOLS))
+        return profiles
+
+    def names(self) -> list[str]:
+        return sorted(self.profiles)
+
+
+def exposed(tool: str, profiles: list[ToolProfile], key: ApiKey | None) -> bool:
+    if key is not None and not key.allows(required_scope(tool)):
+        return False
+    return all(profile.allows(tool) for profile in profiles)
+
+
+def enforce_tool_profiles(
+    server: FastMCP,
+    current_profiles: Callable[[], list[ToolProfile]],
+    current_key: Callable[[], ApiKey | None]
+) -> None:
+    """List only the tools of the connection's profiles, and refuse calls to the others."""
+    tool_manager = server._tool_manager
+    base_list_tools = tool_manager.list_tools
+    base_call_tool = tool_manager.call_tool
+
+    def list_tools(*args: Any, **kwargs: Any) -> Any:
+        profiles, key = current_profiles(), current_key()
+        return [tool for tool in base_list_tools(*args, **kwargs) if exposed(tool.name, profiles, key)]
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        profiles = current_profiles()
+        refusing = next((profile for profile in profiles if not profile.allows(name)), None)
+        if refusing is not None:
+            logger.warning(f"Refused {name}: not in the {refusing.name} profile")
+            raise ToolError(f"{name} is not available in the {refusing.name} profile")
+        return await base_call_tool(name, arguments, *args, **kwargs)
+
+    tool_manager.list_tools = list_tools
+    tool_manager.call_tool = call_tool
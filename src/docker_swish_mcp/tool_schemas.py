@@ -0,0 +1,575 @@
+"""
+JSON Schemas of Tool Arguments and Results for Docker SWISH MCP
+
+FastMCP's generated inputSchema gives each argument a type and default,
+but no description, none of the words an argument accepts and no
+ranges, so clients guess at output_format="JSON" or top=0 and learn
+from the error text. describe_tools() replaces it with a schema built
+from the tool's signature and docstring:
+
+- every argument's description is its entry in the docstring's Args
+- ARGUMENT_RULES (by argument name) and TOOL_RULES (by tool and
+  argument) add enum, minimum and maximum
+- arguments a tool does not take are rejected (additionalProperties)
+
+enforce_tool_schemas() checks every call against that schema before the
+tool runs and refuses a bad one with an invalid_argument error listing
+each problem, e.g. "output_format must be one of "text", "json", not
+"JSON"".
+
+Results stay text for people. With an SDK that supports structured
+results (mcp 1.10 and later), every text tool also declares the
+outputSchema of RESULT_ENVELOPE and returns it as structuredContent:
+
+    {"text": "...", "json": {...} or null, "error": {"kind": ...} or null}
+
+json is the result parsed when it is a JSON document (output_format
+"json"), described by RESULT_SCHEMAS for the query tools, which encode
+Prolog terms as TERM_SCHEMA (see mcp_term_json/2 in mcp_helpers.pl);
+error is the typed error of errors.py.
+"""
+
+import inspect
+import json
+import logging
+import re
+import types
+import typing
+from collections.abc import Callable
+from typing import Any
+
+from mcp.server.fastmcp.exceptions import ToolError as McpToolError
+
+from .config import PRINT_STYLES
+from .constraints import BRANCHINGS, STRATEGIES, VALUE_ORDERS
+from .data_export import EXPORT_FORMATS
+from .data_import import IMPORT_FORMATS
+from .drafting import MAX_CANDIDATES
+from .errors import ERROR_KINDS, ERROR_TAG, ToolError
+from .geospatial import GEO_FORMATS, GEO_OPERATIONS, MAX_RESULTS as MAX_GEO_RESULTS
+from .grammars import INPUT_TYPES, MAX_PARSES
+from .kb_graph import GRAPH_FORMATS, GRAPH_KINDS
+from .kb_search import SEARCH_KINDS
+from .log_stream import STREAMS
+from .notebooks import CELL_TYPES
+from .owl import OWL_NAME_MODES
+from .profiling import MAX_TOP, SORT_KEYS
+from .prolog_flags import FLAGS
+from .rdf import RDF_FORMATS
+from .request_logging import correlation_id
+from .rule_export import EXPORT_TARGETS
+from .swish_links import LINK_KINDS
+from .tabling import TABLE_MODES
+from .volumes import COPY_DIRECTIONS
+
+logger = logging.getLogger("docker-swish-mcp.schemas")
+
+JSON_TYPES = {str: "string", int: "integer", float: "number", bool: "boolean"}
+
+# Constraints of arguments that mean the same in every tool taking them
+ARGUMENT_RULES: dict[str, dict[str, Any]] = {
+    "output_format": {"enum": ["text", "json"]},
+    "timeout": {"exclusiveMinimum": 0},
+    "cpu_limit": {"minimum": 0},
+    "inference_limit": {"minimum": 0},
+    "limit": {"minimum": 0},
+    "batch_size": {"minimum": 1},
+    "max_solutions": {"minimum": 1},
+    "chunk": {"minimum": 1},
+    "max_results": {"minimum": 1},
+    "max_edges": {"minimum": 1},
+    "lines": {"minimum": 1},
+    "tail": {"minimum": 1},
+    "follow_seconds": {"minimum": 0},
+    "wait_seconds": {"minimum": 0},
+    "steps": {"minimum": 1},
+}
+
+# Choices and ranges of one tool's argument
+TOOL_RULES: dict[tuple[str, str], dict[str, Any]] = {
+    ("execute_prolog_query", "print_style"): {"enum": ["", *PRINT_STYLES]},
+    ("execute_prolog_query", "max_depth"): {"minimum": 0},
+    ("execute_prolog_query", "max_list"): {"minimum": 0},
+    ("execute_prolog_query", "max_answer_bytes"): {"minimum": 0},
+    ("trace_query", "max_depth"): {"minimum": 1},
+    ("trace_query", "max_ports"): {"minimum": 1},
+    ("profile_query", "top"): {"minimum": 1, "maximum": MAX_TOP},
+    ("profile_query", "sort_by"): {"enum": list(SORT_KEYS)},
+    ("import_data", "data_format"): {"enum": list(IMPORT_FORMATS)},
+    ("export_results", "data_format"): {"enum": list(EXPORT_FORMATS)},
+    ("export_rules", "target"): {"enum": list(EXPORT_TARGETS)},
+    ("rdf_load", "format"): {"enum": list(RDF_FORMATS)},
+    ("owl_import", "format"): {"enum": list(RDF_FORMATS)},
+    ("owl_import", "names"): {"enum": list(OWL_NAME_MODES)},
+    ("solve_constraints", "strategy"): {"enum": list(STRATEGIES)},
+    ("solve_constraints", "value_order"): {"enum": list(VALUE_ORDERS)},
+    ("solve_constraints", "branching"): {"enum": list(BRANCHINGS)},
+    ("notebook_add_cell", "cell_type"): {"enum": list(CELL_TYPES)},
+    ("share_program", "link"): {"enum": list(LINK_KINDS)},
+    ("kb_graph", "kind"): {"enum": list(GRAPH_KINDS)},
+    ("kb_graph", "format"): {"enum": list(GRAPH_FORMATS)},
+    ("kb_search", "kind"): {"enum": list(SEARCH_KINDS)},
+    ("swish_logs", "stream"): {"enum": list(STREAMS)},
+    ("parse_with_grammar", "input_type"): {"enum": list(INPUT_TYPES)},
+    ("parse_with_grammar", "max_parses"): {"minimum": 1, "maximum": MAX_PARSES},
+    ("scasp_query", "max_models"): {"minimum": 1},
+    ("geo_load", "data_format"): {"enum": list(GEO_FORMATS)},
+    ("geo_query", "operation"): {"enum": list(GEO_OPERATIONS)},
+    ("geo_query", "limit"): {"minimum": 1, "maximum": MAX_GEO_RESULTS},
+    ("draft_rules", "candidates"): {"minimum": 1, "maximum": MAX_CANDIDATES},
+    ("undo_last", "to_entry"): {"minimum": 0},
+    ("fact_feed_events", "since"): {"minimum": 0},
+    ("volume_copy", "direction"): {"enum": list(COPY_DIRECTIONS)},
+    ("table_declare", "mode"): {"enum": list(TABLE_MODES)},
+    ("set_flag", "flag"): {"enum": list(FLAGS)},
+}
+
+# A Prolog term as the JSON results encode it
+TERM_SCHEMA: dict[str, Any] = {
+    "oneOf": [
+        {"type": "object", "properties": {"type": {"const": "var"}, "name": {"type": "string"}},
+         "required": ["type", "name"]},
+        {"type": "object", "properties": {"type": {"const": "integer"}, "value": {"type": "integer"}},
+         "required": ["type", "value"]},
+        {"type": "object", "properties": {"type": {"const": "float"}, "value": {"type": ["number", "string"]}},
+         "required": ["type", "value"]},
+        {"type": "object", "properties": {"type": {"enum": ["atom", "string"]}, "value": {"type": "string"}},
+         "required": ["type", "value"]},
+        {"type": "object", "properties": {"type": {"const": "list"},
+                                          "items": {"type": "array", "items": {"$ref": "#/$defs/term"}}},
+         "required": ["type", "items"]},
+        {"type": "object", "properties": {"type": {"const": "compound"}, "functor": {"type": "string"},
+                                          "arity": {"type": "integer", "minimum": 1},
+                                          "args": {"type": "array", "items": {"$ref": "#/$defs/term"}}},
+         "required": ["type", "functor", "arity", "args"]},
+        {"type": "object", "properties": {"type": {"const": "term"}, "text": {"type": "string"}},
+         "required": ["type", "text"]},
+    ],
+}
+# Variable names to their values, one object per solution
+BINDINGS_SCHEMA: dict[str, Any] = {"type": "object", "additionalProperties": {"$ref": "#/$defs/term"}}
+ERROR_SCHEMA: dict[str, Any] = {
+    "type": "object",
+    "properties": {"kind": {"enum": list(ERROR_KINDS)}, "message": {"type": "string"}},
+    "required": ["kind", "message"],
+}
+DEFS = {"term": TERM_SCHEMA, "bindings": BINDINGS_SCHEMA, "error": ERROR_SCHEMA}
+NULLABLE_ERROR = {"anyOf": [{"$ref": "#/$defs/error"}, {"type": "null"}]}
+
+TRACE_NODE_SCHEMA: dict[str, Any] = {
+    "type": "object",
+    "properties": {
+        "goal": {"type": "string"},
+        "predicate": {"type": "string"},
+        "depth": {"type": "integer"},
+        "outcome": {"enum": ["exit", "fail", "exception", "running"]},
+        "ports": {"type": "array", "items": {"type": "object"}},
+        "children": {"type": "array", "items": {"$ref": "#/$defs/trace_node"}},
+    },
+    "required": ["goal", "predicate", "depth", "outcome", "ports", "children"],
+}
+
+PROOF_NODE_SCHEMA: dict[str, Any] = {
+    "type": "object",
+    "properties": {
+        "goal": {"type": "string"},
+        "kind": {"enum": ["fact", "rule", "builtin", "tabled", "negation", "depth"]},
+        "clause": {"type": "string"},
+        "source": {"type": "string"},
+        "children": {"type": "array", "items": {"$ref": "#/$defs/proof_node"}},
+        "truncated": {"type": "boolean"},
+    },
+    "required": ["goal", "kind", "children"],
+}
+
+# The JSON documents tools return with output_format="json"
+RESULT_SCHEMAS: dict[str, dict[str, Any]] = {
+    "execute_prolog_query": {
+        "type": "object",
+        "properties": {
+            "query": {"type": "string"},
+            "success": {"type": "boolean"},
+            "solutions": {"type": "array", "items": {"$ref": "#/$defs/bindings"}},
+            "output": {"type": "array", "items": {"type": "string"}},
+            "error": NULLABLE_ERROR,
+            "page": {"type": "integer"},
+            "next_cursor": {"type": ["string", "null"]},
+            "truncated": {"type": "boolean"},
+            "solutions_returned": {"type": "integer"},
+            "solutions_found": {"type": "integer"},
+            "max_answer_bytes": {"type": "integer"},
+            "spilled": {"type": "object", "properties": {
+                "uri": {"type": "string"}, "file": {"type": "string"},
+                "solutions": {"type": "integer"}, "bytes": {"type": "integer"},
+            }},
+            "proofs": {"type": "array", "items": {
+                "type": "object",
+                "properties": {
+                    "proof": {"type": "array", "items": {"$ref": "#/$defs/proof_node"}},
+                    "complete": {"type": "boolean"},
+                },
+                "required": ["proof", "complete"],
+            }},
+            "more": {"type": "boolean"},
+        },
+        "required": ["query", "success", "solutions", "output", "error"],
+    },
+    "trace_query": {
+        "type": "object",
+        "properties": {
+            "query": {"type": "string"},
+            "success": {"type": "boolean"},
+            "solution": {"type": ["string", "null"]},
+            "error": NULLABLE_ERROR,
+            "truncated": {"type": "boolean"},
+            "ports": {"type": "integer"},
+            "tree": {"type": "array", "items": {"$ref": "#/$defs/trace_node"}},
+            "output": {"type": "array", "items": {"type": "string"}},
+        },
+        "required": ["query", "success", "error", "tree"],
+    },
+    "profile_query": {
+        "type": "object",
+        "properties": {
+            "query": {"type": "string"},
+            "outcome": {"enum": [True, False, "error", None]},
+            "bindings": {"anyOf": [{"$ref": "#/$defs/bindings"}, {"type": "null"}]},
+            "error": NULLABLE_ERROR,
+            "summary": {"type": "object"},
+            "sort_by": {"enum": list(SORT_KEYS)},
+            "predicates": {"type": "array", "items": {
+                "type": "object",
+                "properties": {
+                    "predicate": {"type": "string"},
+                    "calls": {"type": "integer"}, "redos": {"type": "integer"},
+                    "exits": {"type": "integer"}, "fails": {"type": "integer"},
+                    "inclusive": {"type": "number"}, "exclusive": {"type": "number"},
+                    "inclusive_percent": {"type": "number"}, "exclusive_percent": {"type": "number"},
+                },
+                "required": ["predicate", "calls", "inclusive", "exclusive"],
+            }},
+        },
+        "required": ["query", "outcome", "predicates"],
+    },
+    "query_batch": {
+        "type": "object",
+        "properties": {
+            "committed": {"type": "boolean"},
+            "results": {"type": "array", "items": {
+                "type": "object",
+                "properties": {
+                    "index": {"type": "integer", "minimum": 1},
+                    "goal": {"type": "string"},
+                    "status": {"enum": ["succeeded", "failed", "error", "skipped"]},
+                    "bindings": {"$ref": "#/$defs/bindings"},
+                    "error": {"type": "string"},
+                },
+                "required": ["index", "goal", "status"],
+            }},
+        },
+        "required": ["committed", "results"],
+    },
+    "cancel_query": {
+        "oneOf": [
+            {"type": "object", "properties": {"running": {"type": "array", "items": {
+                "type": "object",
+                "properties": {
+                    "query_id": {"type": "string"}, "goal": {"type": "string"},
+                    "where": {"enum": ["session", "isolated"]}, "instance": {"type": "string"},
+                    "client": {"type": "string"}, "seconds": {"type": "number"},
+                },
+                "required": ["query_id", "goal", "where"],
+            }}}, "required": ["running"]},
+            {"type": "object", "properties": {"cancelled": {"type": "string"}}, "required": ["cancelled"]},
+        ],
+    },
+}
+
+
+def result_envelope(tool: str) -> dict[str, Any]:
+    """The outputSchema of a text tool: its text, the JSON it holds, its typed error and the call's correlation ID."""
+    document = RESULT_SCHEMAS.get(tool, {"type": ["object", "array"]})
+    return {
+        "type": "object",
+        "properties": {
+            "text": {"type": "string"},
+            "json": {"anyOf": [document, {"type": "null"}]},
+            "error": NULLABLE_ERROR,
+            "correlation_id": {"type": "string"},
+        },
+        "required": ["text", "json", "error"],
+        "$defs": {**DEFS, "trace_node": TRACE_NODE_SCHEMA, "proof_node": PROOF_NODE_SCHEMA},
+    }
+
+
+ARGS_HEADER_RE = re.compile(r"^\s*Args:\s*$")
+SECTION_RE = re.compile(r"^\s*(Returns|Raises|Yields|Examples?|Notes?):\s*$")
+ARG_RE = re.compile(r"^(\s+)(\w+)(?:\s*\([^)]*\))?:\s*(.*)$")
+
+
+def docstring_args(doc: str) -> dict[str, str]:
+    """Argument descriptions from a docstring's Args section, continuation lines joined."""
+    descriptions: dict[str, str] = {}
+    lines = inspect.cleandoc(doc or "").splitlines()
+    try:
+        start = next(i for i, line in enumerate(lines) if ARGS_HEADER_RE.match(line))
+    except StopIteration:
+        return descriptions
+    indent: int | None = None
+    current = ""
+    for line in lines[start + 1:]:
+        if SECTION_RE.match(line):
+            break
+        if not line.strip():
+            continue
+        match = ARG_RE.match(line)
+        if match and (indent is None or len(match[1]) == indent):
+            indent = len(match[1])
+            current = match[2]
+            descriptions[current] = match[3].strip()
+        elif current and indent is not None and len(line) - len(line.lstrip()) > indent:
+            descriptions[current] = f"{descriptions[current]} {line.strip()}".strip()
+        elif indent is not None:
+            break
+    return descriptions
+
+
+def type_schema(annotation: Any) -> dict[str, Any]:
+    """The JSON Schema of a parameter annotation."""
+    if annotation is inspect.Parameter.empty or annotation is Any:
+        return {}
+    origin = typing.get_origin(annotation)
+    if origin in (typing.Union, types.UnionType):
+        options = [type_schema(arg) for arg in typing.get_args(annotation)]
+        simple = [o["type"] for o in options if set(o) == {"type"} and isinstance(o["type"], str)]
+        if len(simple) == len(options):
+            return {"type": simple}
+        return {"anyOf": options}
+    if origin is list:
+        (item,) = typing.get_args(annotation) or (Any,)
+        return {"type": "array", "items": type_schema(item)}
+    if origin is dict:
+        _, value = typing.get_args(annotation) or (str, Any)
+        values = type_schema(value)
+        return {"type": "object", **({"additionalProperties": values} if values else {})}
+    if annotation is type(None):
+        return {"type": "null"}
+    if annotation in JSON_TYPES:
+        return {"type": JSON_TYPES[annotation]}
+    return {}
+
+
+def tool_input_schema(name: str, fn: Callable[..., Any]) -> dict[str, Any]:
+    """The inputSchema of a tool function: types, defaults, descriptions and constraints."""
+    try:
+        hints = typing.get_type_hints(fn)
+    except Exception:
+        hints = {}
+    descriptions = docstring_args(fn.__doc__ or "")
+    properties: dict[str, Any] = {}
+    required = []
+    for parameter in inspect.signature(fn).parameters.values():
+        if parameter.kind in (parameter.VAR_POSITIONAL, parameter.VAR_KEYWORD):
+            continue
+        schema = type_schema(hints.get(parameter.name, parameter.annotation))
+        if parameter.name in descriptions:
+            schema["description"] = descriptions[parameter.name]
+        schema.update(ARGUMENT_RULES.get(parameter.name, {}))
+        schema.update(TOOL_RULES.get((name, parameter.name), {}))
+        if parameter.default is inspect.Parameter.empty:
+            required.append(parameter.name)
+        else:
+            schema["default"] = parameter.default
+        properties[parameter.name] = schema
+    schema = {"type": "object", "properties": properties, "additionalProperties": False}
+    if required:
+        schema["required"] = required
+    return schema
+
+
+def describe(value: Any) -> str:
+    text = json.dumps(value) if not isinstance(value, str) or len(value) <= 40 else json.dumps(value[:37] + "...")
+    return f"{text} ({json_type(value)})"
+
+
+def json_type(value: Any) -> str:
+    if value is None:
+        return "null"
+    if isinstance(value, bool):
+        return "boolean"
+    if isinstance(value, int):
+        return "integer"
+    if isinstance(value, float):
+        return "number"
+    if isinstance(value, str):
+        return "string"
+    if isinstance(value, list):
+        return "array"
+    if isinstance(value, dict):
+        return "object"
+    return type(value).__name__
+
+
+def type_matches(value: Any, expected: str) -> bool:
+    actual = json_type(value)
+    if expected == "integer" and actual == "number":
+        # Some clients send every number as a float; 30.0 is still an integer
+        return float(value).is_integer()
+    return actual == expected or (expected == "number" and actual == "integer")
+
+
+def validate(value: Any, schema: dict[str, Any], where: str, defs: dict[str, Any] | None = None) -> list[str]:
+    """
+    Problems of value against the subset of JSON Schema these schemas use.
+
+    where names the value in the messages, e.g. "timeout" or "goals[2]".
+    """
+    defs = defs if defs is not None else schema.get("$defs", {})
+    if "$ref" in schema:
+        return validate(value, defs[schema["$ref"].rsplit("/", 1)[-1]], where, defs)
+    for key in ("anyOf", "oneOf"):
+        if key in schema:
+            branches = [validate(value, option, where, defs) for option in schema[key]]
+            if all(branches):
+                # Report the closest branch; "must be null" helps no one
+                closest = [b for o, b in zip(schema[key], branches) if o != {"type": "null"}] or branches
+                return min(closest, key=len)
+    expected = schema.get("type")
+    if expected is not None:
+        allowed = expected if isinstance(expected, list) else [expected]
+        if not any(type_matches(value, t) for t in allowed):
+            return [f"{where} must be {' or '.join(a_type(t) for t in allowed)}, not {describe(value)}"]
+    if "const" in schema and value != schema["const"]:
+        return [f"{where} must be {json.dumps(schema['const'])}, not {describe(value)}"]
+    if "enum" in schema and not any(value == option and type(value) is type(option) for option in schema["enum"]):
+        choices = ", ".join(json.dumps(option) for option in schema["enum"])
+        return [f"{where} must be one of {choices}, not {describe(value)}"]
+    problems: list[str] = []
+    if isinstance(value, (int, float)) and not isinstance(value, bool):
+        if "minimum" in schema and value < schema["minimum"]:
+            problems.append(f"{where} must be at least {schema['minimum']}, not {value}")
+        if "exclusiveMinimum" in schema and value <= schema["exclusiveMinimum"]:
+            problems.append(f"{where} must be more than {schema['exclusiveMinimum']}, not {value}")
+        if "maximum" in schema and value > schema["maximum"]:
+            problems.append(f"{where} must be at most {schema['maximum']}, not {value}")
+    if isinstance(value, list) and "items" in schema:
+        for index, item in enumerate(value):
+            problems += validate(item, schema["items"], f"{where}[{index}]", defs)
+    if isinstance(value, dict):
+        properties = schema.get("properties", {})
+        for name in schema.get("required", []):
+            if name not in value:
+                problems.append(f"{where}.{name} is required" if where else f"{name} is required")
+        extra = schema.get("additionalProperties", True)
+        for name, item in value.items():
+            path = f"{where}.{name}" if where else name
+            if name in properties:
+                problems += validate(item, properties[name], path, defs)
+            elif extra is False:
+                known = ", ".join(properties) or "none"
+                problems.append(f"{path} is not known here; expected one of: {known}")
+            elif isinstance(extra, dict):
+                problems += validate(item, extra, path, defs)
+    return problems
+
+
+def a_type(name: str) -> str:
+    return {"integer": "an integer", "array": "an array", "object": "an object", "null": "null"}.get(name, f"a {name}")
+
+
+def invalid_arguments(tool: str, problems: list[str]) -> ToolError:
+    listed = "; ".join(problems)
+    return ToolError("invalid_argument", f"Invalid arguments for {tool}: {listed}", {"problems": problems})
+
+
+def tool_table(server: Any) -> dict[str, Any]:
+    """FastMCP's registered tools by name."""
+    tools: dict[str, Any] = getattr(server._tool_manager, "_tools", {})
+    return tools
+
+
+def describe_tools(server: Any) -> dict[str, dict[str, Any]]:
+    """
+    Replace every registered tool's inputSchema with tool_input_schema(), and
+    give text tools the outputSchema of result_envelope() where the SDK has one.
+
+    Returns the input schemas by tool name, which enforce_tool_schemas() checks
+    calls against.
+    """
+    schemas = {}
+    for name, tool in tool_table(server).items():
+        fn = getattr(tool, "fn", None)
+        if fn is None:
+            continue
+        schemas[name] = tool.parameters = tool_input_schema(name, fn)
+        metadata = getattr(tool, "fn_metadata", None)
+        if structured_tool(fn) and metadata is not None and hasattr(metadata, "output_schema"):
+            metadata.output_schema = result_envelope(name)
+    return schemas
+
+
+def structured_tool(fn: Callable[..., Any]) -> bool:
+    """Whether a tool returns plain text, which can be wrapped in the result envelope."""
+    try:
+        return typing.get_type_hints(fn).get("return") is str
+    except Exception:
+        return False
+
+
+def structured_result(tool: str, text: str) -> dict[str, Any]:
+    """The result envelope of a tool's text result."""
+    document: Any = None
+    stripped = text.lstrip()
+    if stripped[:1] in ("{", "["):
+        try:
+            document = json.loads(text)
+        except ValueError:
+            document = None
+    error = None
+    if isinstance(document, dict) and isinstance(document.get("error"), dict):
+        error = document["error"]
+    else:
+        last = text.rstrip().rsplit("\n", 1)[-1]
+        if last.startswith(f"{ERROR_TAG} "):
+            try:
+                error = json.loads(last[len(ERROR_TAG) + 1:])
+            except ValueError:
+                error = None
+    envelope = {"text": text, "json": document, "error": error}
+    problems = validate(envelope, result_envelope(tool), "result")
+    if problems:
+        # Never fail a call over its own result schema; report it and drop the parsed parts
+        logger.warning(f"{tool} result does not match its schema: {'; '.join(problems[:5])}")
+        envelope = {"text": text, "json": None, "error": error if not validate(
+            error, NULLABLE_ERROR, "error", DEFS) else None}
+    if correlation_id.get():
+        envelope["correlation_id"] = correlation_id.get()
+    return envelope
+
+
+def enforce_tool_schemas(server: Any, schemas: dict[str, dict[str, Any]]) -> None:
+    """
+    Refuse tool calls whose arguments do not match the tool's inputSchema, and
+    return text results with their result envelope as structured content.
+
+    schemas is filled by describe_tools() once the tools are registered.
+    """
+    tool_manager = server._tool_manager
+    base_call_tool = tool_manager.call_tool
+
+    async def call_tool(name: str, arguments: dict[str, Any], *args: Any, **kwargs: Any) -> Any:
+        schema = schemas.get(name)
+        if schema is not None:
+            problems = validate(arguments or {}, schema, "")
+            if problems:
+                logger.info(f"Refused {name}: {'; '.join(problems)}")
+                raise McpToolError(invalid_arguments(name, problems).render())
+        tool = tool_table(server).get(name)
+        if not kwargs.get("convert_result") or tool is None or not structured_tool(tool.fn):
+            return await base_call_tool(name, arguments, *args, **kwargs)
+        result = await base_call_tool(name, arguments, *args, **{**kwargs, "convert_result": False})
+        from mcp.types import TextContent
+        text = str(result)
+        return [TextContent(type="text", text=text)], structured_result(name, text)
+
+    tool_manager.call_tool = call_tool
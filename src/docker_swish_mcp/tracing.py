@@ -0,0 +1,104 @@
+"""
+Trace Trees for Docker SWISH MCP
+
+Turns the flat port events emitted by mcp_trace/4 (see mcp_helpers.pl)
+into a call tree: one node per traced call, holding every port it passed
+through (call, exit, redo, fail, exception) and the calls it made.
+"""
+
+from dataclasses import dataclass, field
+from typing import Any
+
+PORT_ICONS = {"call": "📞", "exit": "✅", "redo": "🔁", "fail": "❌", "exception": "💥"}
+
+
+@dataclass
+class TraceNode:
+    """A traced call and the ports it passed through."""
+    goal: str
+    predicate: str
+    depth: int
+    ports: list[dict[str, Any]] = field(default_factory=list)
+    children: list["TraceNode"] = field(default_factory=list)
+
+    @property
+    def outcome(self) -> str:
+        """Last exit, fail or exception port, or "running" if none was seen."""
+        for port in reversed(self.ports):
+            if port["port"] in ("exit", "fail", "exception"):
+                return port["port"]
+        return "running"
+
+    def to_dict(self) -> dict[str, Any]:
+        return {
+            "goal": self.goal,
+            "predicate": self.predicate,
+            "depth": self.depth,
+            "outcome": self.outcome,
+            "ports": self.ports,
+            "children": [child.to_dict() for child in self.children],
+        }
+
+
+def build_trace_tree(ports: list[dict[str, Any]]) -> list[TraceNode]:
+    """
+    Nest port events by depth.
+
+    A call port opens a node under the most recent node one level up.
+    Other ports belong to the call with the same frame reference (or,
+    without one, the most recent node at their depth); a redo makes that
+    call the current one at its depth again.
+    """
+    roots: list[TraceNode] = []
+    latest: dict[int, TraceNode] = {}
+    by_frame: dict[Any, TraceNode] = {}
+
+    for event in ports:
+        depth = int(event.get("depth", 1))
+        entry = {"port": event["port"], "goal": event.get("goal", "")}
+        if "error" in event:
+            entry["error"] = event["error"]
+
+        frame = event.get("frame")
+        node = by_frame.get(frame) if frame is not None else latest.get(depth)
+        if event["port"] == "call" or node is None:
+            node = TraceNode(event.get("goal", ""), event.get("predicate", ""), depth)
+            parent = latest.get(depth - 1)
+            (parent.children if parent is not None else roots).append(node)
+            if frame is not None:
+                by_frame[frame] = node
+        if event["port"] in ("call", "redo"):
+            latest[depth] = node
+            for deeper in [d for d in latest if d > depth]:
+                del latest[deeper]
+        node.ports.append(entry)
+
+    return roots
+
+
+def format_trace(ports: list[dict[str, Any]]) -> str:
+    """Render port events the way the SWI-Prolog tracer prints them."""
+    lines = []
+    for event in ports:
+        indent = "   " * (int(event.get("depth", 1)) - 1)
+        icon = PORT_ICONS.get(event["port"], "▫️")
+        line = f"{indent}{icon} {event['port'].capitalize()}: {event.get('goal', '')}"
+        if "error" in event:
+            line += f"  ⟶ {event['error']}"
+        lines.append(line)
+    return "\n".join(lines)
+
+
+def failed_calls(nodes: list[TraceNode]) -> list[TraceNode]:
+    """Calls that ended in fail or exception, deepest first."""
+    found: list[TraceNode] = []
+
+    def walk(node: TraceNode) -> None:
+        for child in node.children:
+            walk(child)
+        if node.outcome in ("fail", "exception"):
+            found.append(node)
+
+    for root in nodes:
+        walk(root)
+    return found
@@ -0,0 +1,97 @@
+"""
+plunit Test Runner for Docker SWISH MCP
+
+run_tests runs the plunit units loaded in the persistent session, e.g. a
+file with
+
+    :- begin_tests(lists).
+    test(reverse, [true(L == [c, b, a])]) :- reverse([a, b, c], L).
+    :- end_tests(lists).
+
+mcp_tests/3 (see mcp_helpers.pl) evaluates each test's options itself,
+so a failure reports the check that failed with the value the test
+produced and the one it expected, not just plunit's summary line. It
+understands the options plunit tests use most: true/1 and bare
+comparisons, fail, throws/1, error/1, all/1, set/1, setup/1, cleanup/1,
+forall/1, condition/1, blocked/1 and fixme/1.
+"""
+
+from collections import Counter
+from dataclasses import dataclass
+from typing import Any
+
+from .config import QueryLimits
+from .rdf import prolog_atom
+
+TEST_STATUSES = ("passed", "failed", "error", "blocked", "skipped")
+# Wall-clock limit for a whole run; each test has the per-query limits
+RUN_WALL_SECONDS = 600.0
+
+STATUS_ICONS = {"passed": "✅", "failed": "❌", "error": "💥", "blocked": "⏸️", "skipped": "⏭️"}
+
+
+def tests_call(units: list[str], limits: QueryLimits) -> tuple[str, list[str]]:
+    return "mcp_tests", [f"[{', '.join(prolog_atom(unit) for unit in units)}]", limits.to_prolog()]
+
+
+@dataclass
+class TestResult:
+    unit: str
+    test: str
+    line: int
+    status: str
+    reason: str = ""
+    check: str = ""
+    got: str = ""
+    expected: str = ""
+    error: str = ""
+    fixme: str = ""
+    output: str = ""
+
+    @classmethod
+    def from_json(cls, row: dict[str, Any]) -> "TestResult":
+        return cls(**{key: row[key] for key in cls.__dataclass_fields__ if key in row})
+
+    def to_json(self) -> dict[str, Any]:
+        entry: dict[str, Any] = {"unit": self.unit, "test": self.test, "line": self.line, "status": self.status}
+        for key in ("reason", "check", "got", "expected", "error", "fixme", "output"):
+            if getattr(self, key):
+                entry[key] = getattr(self, key)
+        return entry
+
+
+def summary(results: list[TestResult]) -> dict[str, int]:
+    counts = Counter(result.status for result in results)
+    return {status: counts[status] for status in TEST_STATUSES if counts[status]}
+
+
+def format_results(results: list[TestResult]) -> str:
+    if not results:
+        return "🧪 No plunit tests are loaded. Load a file with :- begin_tests(Unit). ... :- end_tests(Unit)."
+    counts = ", ".join(f"{count} {status}" for status, count in summary(results).items())
+    lines = [f"🧪 {len(results)} test(s): {counts}"]
+
+    passed: dict[str, list[str]] = {}
+    for result in results:
+        if result.status == "passed" and not result.fixme:
+            passed.setdefault(result.unit, []).append(result.test)
+    for unit, tests in passed.items():
+        lines.append(f"{STATUS_ICONS['passed']} {unit}: {', '.join(tests)}")
+
+    for result in results:
+        if result.status == "passed" and not result.fixme:
+            continue
+        detail = result.reason or result.status
+        lines.append(f"{STATUS_ICONS[result.status]} {result.unit}:{result.test} (line {result.line}): {detail}")
+        if result.check:
+            lines.append(f"   check: {result.check}")
+        if result.got or result.expected:
+            lines.append(f"   got: {result.got}")
+            lines.append(f"   expected: {result.expected}")
+        if result.error:
+            lines.append(f"   error: {result.error}")
+        if result.fixme:
+            lines.append(f"   fixme: {result.fixme}")
+        if result.output:
+            lines.append("   output: " + result.output.rstrip().replace("\n", "\n           "))
+    return "\n".join(lines)
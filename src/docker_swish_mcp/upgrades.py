@@ -0,0 +1,69 @@
+"""
+Zero-Downtime Image Upgrades for Docker SWISH MCP
+
+upgrade_swish moves a container to a new image without losing what its
+session has loaded:
+
+1. the new image is pulled (or built from the Dockerfile)
+2. a standby container, <name>-standby, starts on it on a free port
+3. the knowledge base of the running session is replayed into the
+   standby's: the files it consulted from the data directory (see
+   mcp_kb_state/2), then the dynamic database of every user module,
+   including what was asserted after loading (mcp_db_snapshot/2)
+4. the standby is health-checked; if it or the replay fails it is
+   removed and the old container keeps serving
+5. traffic switches: the old container is renamed <name>-retired, the
+   standby takes its name, and the old one is stopped and removed
+
+The web UI moves to the standby's port, as a published port cannot be
+handed from one container to another.
+"""
+
+from dataclasses import dataclass, field
+from typing import Any
+
+from .rdf import prolog_atom
+
+STANDBY_SUFFIX = "-standby"
+RETIRED_SUFFIX = "-retired"
+
+
+class UpgradeError(Exception):
+    """Raised when the standby container cannot take over."""
+
+
+@dataclass
+class KnowledgeState:
+    """What a session has loaded: files by module, and each module's dynamic database."""
+    # (container path, module) in load order
+    files: list[tuple[str, str]] = field(default_factory=list)
+    # module -> mcp_db_snapshot/2 rows
+    databases: dict[str, list[dict[str, Any]]] = field(default_factory=dict)
+
+    @property
+    def clauses(self) -> int:
+        return sum(len(row["clauses"]) for rows in self.databases.values() for row in rows)
+
+    def summary(self) -> str:
+        modules = sum(1 for rows in self.databases.values() if rows)
+        return f"{len(self.files)} file(s), {self.clauses} dynamic clause(s) in {modules} module(s)"
+
+
+def state_call(root: str) -> tuple[str, list[str]]:
+    return "mcp_kb_state", [prolog_atom(root)]
+
+
+def parse_state(rows: list[dict[str, Any]]) -> tuple[list[tuple[str, str]], list[str]]:
+    """The loaded files and the modules whose databases to snapshot."""
+    files = [(row["file"], row["module"]) for row in rows if "file" in row]
+    modules = [row["database"] for row in rows if "database" in row]
+    return files, modules
+
+
+def replay_call(files: list[tuple[str, str]]) -> tuple[str, list[str]]:
+    loads = ", ".join(f"load({prolog_atom(module)}, {prolog_atom(path)})" for path, module in files)
+    return "mcp_kb_replay", [f"[{loads}]"]
+
+
+def replay_errors(rows: list[dict[str, Any]]) -> list[str]:
+    return [f"{row['file']}: {row['error']}" for row in rows if row.get("error")]
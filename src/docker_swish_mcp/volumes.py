@@ -0,0 +1,405 @@
+"""
+Named Data Volumes for Docker SWISH MCP
+
+Bind-mounting the host data directory at /data breaks on Docker Desktop,
+where the files cross into the VM with odd owners and permissions. With
+SWISH_MCP_DATA_VOLUME=<name> (or volume in the config file's [container]
+table) the container mounts that named Docker volume at /data instead,
+creating it, labelled as ours, if it does not exist.
+
+The server's tools keep reading and writing the host data directory,
+which becomes a working copy: VolumeMirror keeps it in step with the
+volume in both directions through the Docker API (find in the container
+to list files, the archive API to copy them) every
+SWISH_MCP_SYNC_INTERVAL seconds and whenever a tool wrote files. Like
+the workspace sync (see sync.py) only source files are mirrored. On the
+first sync, files on one side only are copied to the other and, where
+the two differ, the volume wins, as SWISH may have edited it. After
+that the side that changed since the last sync wins, the newer one when
+both did, and deletions are carried over.
+
+volume_list, volume_create and volume_prune manage volumes; volume_copy
+copies files between any volume and the host data directory, through a
+stopped helper container when the volume is not the mounted one.
+"""
+
+import asyncio
+import io
+import json
+import logging
+import os
+import re
+import tarfile
+import time
+from collections.abc import Awaitable, Callable
+from dataclasses import dataclass, field
+from pathlib import Path, PurePosixPath
+from typing import Any
+
+from .container_exec import exec_in_container
+from .lifecycle import MANAGED_BY
+from .snapshots import ARCHIVE_ROOT, checked_members
+from .sync import is_synced, scan_tree
+
+logger = logging.getLogger("docker-swish-mcp.volumes")
+
+VOLUME_NAME_RE = re.compile(r"^[A-Za-z0-9][A-Za-z0-9_.-]*$")
+COPY_DIRECTIONS = ("to_host", "to_volume")
+MOUNT_POINT = f"/{ARCHIVE_ROOT}"
+# Lists every file below /data with its modification time
+LIST_COMMAND = ["find", MOUNT_POINT, "-type", "f", "-printf", "%P\\t%T@\\n"]
+
+
+def validate_volume_name(name: str) -> str:
+    if not VOLUME_NAME_RE.match(name):
+        raise ValueError(f"Invalid volume name '{name}' (use letters, digits, '_', '.' or '-')")
+    return name
+
+
+def volume_api(client: Any) -> Any:
+    """client's volume collection; raises ValueError for runtimes without one."""
+    volumes = getattr(client, "volumes", None)
+    if volumes is None:
+        raise ValueError("This container runtime has no volume API; named volumes need docker or podman")
+    return volumes
+
+
+def _not_found(error: Exception) -> bool:
+    return type(error).__name__ == "NotFound"
+
+
+def ensure_volume(client: Any, name: str) -> bool:
+    """Create volume name unless it exists; True if it was created."""
+    volumes = volume_api(client)
+    try:
+        volumes.get(validate_volume_name(name))
+        return False
+    except Exception as e:
+        if not _not_found(e):
+            raise
+    volumes.create(name=name, labels={"managed-by": MANAGED_BY})
+    logger.info(f"📦 Created volume {name}")
+    return True
+
+
+def volume_users(client: Any) -> dict[str, list[str]]:
+    """Names of the containers mounting each volume, running or not."""
+    users: dict[str, list[str]] = {}
+    for container in client.containers.list(all=True):
+        for mount in container.attrs.get("Mounts") or []:
+            if mount.get("Type") == "volume" and mount.get("Name"):
+                users.setdefault(mount["Name"], []).append(container.name)
+    return users
+
+
+def describe_volumes(client: Any, everything: bool = False) -> list[dict[str, Any]]:
+    """Volumes this server created (every volume with everything), with the containers using them."""
+    filters = None if everything else {"label": f"managed-by={MANAGED_BY}"}
+    users = volume_users(client)
+    rows = []
+    for volume in volume_api(client).list(filters=filters):
+        attrs = volume.attrs or {}
+        rows.append({
+            "name": volume.name,
+            "driver": attrs.get("Driver", ""),
+            "created": attrs.get("CreatedAt", ""),
+            "managed": (attrs.get("Labels") or {}).get("managed-by") == MANAGED_BY,
+            "containers": sorted(users.get(volume.name, [])),
+        })
+    return sorted(rows, key=lambda row: row["name"])
+
+
+def prune_volumes(client: Any, everything: bool = False, keep: frozenset[str] = frozenset()) -> list[str]:
+    """Remove volumes no container uses, only ours unless everything; returns their names."""
+    removed = []
+    for row in describe_volumes(client, everything):
+        if row["containers"] or row["name"] in keep:
+            continue
+        try:
+            volume_api(client).get(row["name"]).remove()
+            removed.append(row["name"])
+        except Exception as e:
+            logger.warning(f"Could not remove volume {row['name']}: {e}")
+    return removed
+
+
+def helper_container(client: Any, image: str, volume: str) -> Any:
+    """A created, never started container of image with volume at /data, for the archive API."""
+    return client.containers.create(
+        image,
+        command=["true"],
+        volumes={validate_volume_name(volume): {"bind": MOUNT_POINT, "mode": "rw"}},
+        labels={"managed-by": MANAGED_BY},
+    )
+
+
+def _relative(path: str) -> PurePosixPath:
+    relative = PurePosixPath(path.strip("/")) if path else PurePosixPath()
+    if relative.is_absolute() or ".." in relative.parts:
+        raise ValueError(f"Path '{path}' must be inside the data directory")
+    return relative
+
+
+def copy_to_host(container: Any, data_dir: Path, path: str = "") -> int:
+    """Copy /data (or path below it) out of container into data_dir; returns the files copied."""
+    relative = _relative(path)
+    stream, _stat = container.get_archive(str(PurePosixPath(MOUNT_POINT, relative)))
+    buffer = io.BytesIO(b"".join(stream))
+    copied = 0
+    with tarfile.open(fileobj=buffer, mode="r:") as tar:
+        # The archive of /data/a/b starts with b/; put it back under data/a/
+        prefix = PurePosixPath(ARCHIVE_ROOT, *relative.parts[:-1]) if relative.parts else PurePosixPath()
+        for member in tar.getmembers():
+            member.name = str(prefix / member.name)
+        for member in checked_members(tar):
+            destination = data_dir.joinpath(*PurePosixPath(member.name).relative_to(ARCHIVE_ROOT).parts)
+            if member.isdir():
+                destination.mkdir(parents=True, exist_ok=True)
+            elif member.isfile():
+                source = tar.extractfile(member)
+                if source is not None:
+                    _write(destination, source.read(), member.mtime)
+                    copied += 1
+    return copied
+
+
+def copy_to_volume(container: Any, data_dir: Path, path: str = "") -> int:
+    """Copy data_dir (or path below it) into container's /data; returns the files copied."""
+    relative = _relative(path)
+    source = data_dir.joinpath(*relative.parts)
+    if not source.exists():
+        raise ValueError(f"{source} does not exist")
+    files = [source] if source.is_file() else sorted(p for p in source.rglob("*") if p.is_file())
+    if not container.put_archive("/", pack_files(data_dir, [p.relative_to(data_dir).as_posix() for p in files])):
+        raise RuntimeError("Docker rejected the archive upload")
+    return len(files)
+
+
+def pack_files(data_dir: Path, files: list[str], owner: tuple[int, int] = (0, 0)) -> bytes:
+    """An uncompressed tar of data_dir's files under data/, with their directories and mtimes."""
+    buffer = io.BytesIO()
+    with tarfile.open(fileobj=buffer, mode="w", format=tarfile.PAX_FORMAT) as tar:
+        directories: set[str] = set()
+        for rel in files:
+            for parent in reversed(PurePosixPath(ARCHIVE_ROOT, rel).parents[:-1]):
+                if str(parent) not in directories:
+                    directories.add(str(parent))
+                    info = tarfile.TarInfo(str(parent))
+                    info.type, info.mode, info.mtime = tarfile.DIRTYPE, 0o755, int(time.time())
+                    info.uid, info.gid = owner
+                    tar.addfile(info)
+            path = data_dir / rel
+            stat = path.stat()
+            info = tarfile.TarInfo(str(PurePosixPath(ARCHIVE_ROOT, rel)))
+            info.size, info.mode, info.mtime = stat.st_size, 0o644, stat.st_mtime
+            info.uid, info.gid = owner
+            with open(path, "rb") as f:
+                tar.addfile(info, f)
+    return buffer.getvalue()
+
+
+def _write(path: Path, content: bytes, mtime: float) -> None:
+    """Replace path with content without exposing a partial file, and give it mtime."""
+    path.parent.mkdir(parents=True, exist_ok=True)
+    partial = path.with_name(f".{path.name}.volume")
+    partial.write_bytes(content)
+    os.utime(partial, (mtime, mtime))
+    os.replace(partial, path)
+
+
+@dataclass
+class VolumeSyncReport:
+    """Files one mirror pass copied or deleted, by relative path."""
+    pushed: list[str] = field(default_factory=list)
+    pulled: list[str] = field(default_factory=list)
+    deleted: list[str] = field(default_factory=list)
+
+    def __bool__(self) -> bool:
+        return bool(self.pushed or self.pulled or self.deleted)
+
+    def describe(self) -> str:
+        return f"{len(self.pushed)} → volume, {len(self.pulled)} → host, {len(self.deleted)} deleted"
+
+
+class VolumeMirror:
+    """
+    Two-way mirror of source files between the host data directory and the volume at /data.
+
+    Args:
+        volume: Name of the volume, for the saved sync state
+        data_dir: Host data directory
+        container: Returns the running container that mounts the volume, or None
+        docker_client: Client the container belongs to, for commands run in it
+        on_pushed: Coroutine called with the files copied into the volume
+        interval: Seconds between polls
+    """
+
+    def __init__(
+        self,
+        volume: str,
+        data_dir: Path,
+        container: Callable[[], Any],
+        docker_client: Any,
+        on_pushed: Callable[[list[str]], Awaitable[None]],
+        interval: float = 2.0
+    ):
+        self.volume = volume
+        self.data_dir = data_dir
+        self.container = container
+        self.docker_client = docker_client
+        self.on_pushed = on_pushed
+        self.interval = interval
+        # Relative path -> (host mtime in ns, volume mtime as find prints it) after the last sync
+        self.synced: dict[str, tuple[int, str]] = {}
+        self.last_sync: float | None = None
+        self.last_report = VolumeSyncReport()
+        self.last_error = ""
+        self.lock = asyncio.Lock()
+
+    @property
+    def state_path(self) -> Path:
+        return self.data_dir.parent / "swish-volumes" / f"{self.volume}.json"
+
+    def load(self) -> None:
+        """Restore the last sync state, unless it was for another data directory."""
+        self.synced = {}
+        try:
+            saved = json.loads(self.state_path.read_text(encoding="utf-8"))
+        except FileNotFoundError:
+            return
+        except (OSError, ValueError) as e:
+            logger.warning(f"Ignoring volume sync state {self.state_path}: {e}")
+            return
+        if saved.get("data_dir") != str(self.data_dir.resolve()):
+            return
+        self.synced = {rel: (int(times[0]), str(times[1])) for rel, times in saved.get("files", {}).items()}
+
+    def save(self) -> None:
+        self.state_path.parent.mkdir(parents=True, exist_ok=True)
+        state = {"data_dir": str(self.data_dir.resolve()), "files": self.synced}
+        partial = self.state_path.with_suffix(".tmp")
+        partial.write_text(json.dumps(state, indent=2), encoding="utf-8")
+        os.replace(partial, self.state_path)
+
+    async def _run(self, container: Any, cmd: list[str]) -> str:
+        code, stdout, stderr = await exec_in_container(self.docker_client, container.name, cmd, timeout=30)
+        if code != 0:
+            raise RuntimeError(f"{cmd[0]} in {container.name} failed: {stderr.strip() or f'exit status {code}'}")
+        return stdout
+
+    async def list_volume(self, container: Any) -> dict[str, str]:
+        """Map each mirrored file in the volume (relative path) to its mtime as find prints it."""
+        files = {}
+        for line in (await self._run(container, LIST_COMMAND)).splitlines():
+            rel, _, mtime = line.partition("\t")
+            if rel and mtime and is_synced(PurePosixPath(rel)):
+                files[rel] = mtime
+        return files
+
+    async def _fetch(self, container: Any, rel: str) -> tuple[bytes, float]:
+        def fetch() -> tuple[bytes, float]:
+            stream, _stat = container.get_archive(f"{MOUNT_POINT}/{rel}")
+            with tarfile.open(fileobj=io.BytesIO(b"".join(stream)), mode="r:") as tar:
+                member = tar.getmembers()[0]
+                source = tar.extractfile(member)
+                return (source.read() if source else b""), member.mtime
+        return await asyncio.to_thread(fetch)
+
+    async def sync_once(self, container: Any) -> VolumeSyncReport:
+        """Bring the data directory and the volume in step."""
+        host = await asyncio.to_thread(scan_tree, self.data_dir)
+        volume = await self.list_volume(container)
+        report = VolumeSyncReport()
+        push: list[str] = []
+        for rel in sorted(host.keys() | volume.keys() | self.synced.keys()):
+            h, v = host.get(rel), volume.get(rel)
+            before = self.synced.get(rel)
+            if h is None and v is None:
+                self.synced.pop(rel, None)
+            elif before is None:
+                if h is None:
+                    await self._pull(container, rel, v, report)
+                elif v is None:
+                    push.append(rel)
+                else:
+                    content, _mtime = await self._fetch(container, rel)
+                    if content != await asyncio.to_thread((self.data_dir / rel).read_bytes):
+                        await self._pull(container, rel, v, report)
+                    else:
+                        self.synced[rel] = (h, v)
+            elif h is None:
+                # Deleted on the host; a newer edit in the volume survives
+                if v == before[1]:
+                    await self._run(container, ["rm", "-f", "--", f"{MOUNT_POINT}/{rel}"])
+                    self.synced.pop(rel, None)
+                    report.deleted.append(rel)
+                else:
+                    await self._pull(container, rel, v, report)
+            elif v is None:
+                if h == before[0]:
+                    (self.data_dir / rel).unlink(missing_ok=True)
+                    self.synced.pop(rel, None)
+                    report.deleted.append(rel)
+                else:
+                    push.append(rel)
+            elif h != before[0] and v != before[1]:
+                if float(v) > h / 1e9:
+                    await self._pull(container, rel, v, report)
+                else:
+                    push.append(rel)
+            elif h != before[0]:
+                push.append(rel)
+            elif v != before[1]:
+                await self._pull(container, rel, v, report)
+        if push:
+            await self._push(container, push, report)
+        self.save()
+        return report
+
+    async def _pull(self, container: Any, rel: str, volume_mtime: str, report: VolumeSyncReport) -> None:
+        content, mtime = await self._fetch(container, rel)
+        path = self.data_dir / rel
+        await asyncio.to_thread(_write, path, content, float(volume_mtime) or mtime)
+        self.synced[rel] = (path.stat().st_mtime_ns, volume_mtime)
+        report.pulled.append(rel)
+
+    async def _push(self, container: Any, files: list[str], report: VolumeSyncReport) -> None:
+        # New files belong to whoever owns /data, as SWISH runs as that user
+        owner = await self._run(container, ["stat", "-c", "%u %g", MOUNT_POINT])
+        uid, gid = (int(part) for part in owner.split())
+        archive = await asyncio.to_thread(pack_files, self.data_dir, files, (uid, gid))
+        if not await asyncio.to_thread(container.put_archive, "/", archive):
+            raise RuntimeError("Docker rejected the archive upload")
+        # Record the mtimes the volume ended up with, so the copies do not come back
+        listed = await self.list_volume(container)
+        for rel in files:
+            self.synced[rel] = ((self.data_dir / rel).stat().st_mtime_ns, listed.get(rel, ""))
+        report.pushed.extend(files)
+
+    async def run(self) -> VolumeSyncReport:
+        """One mirror pass, then reload what changed in the volume; a no-op without a container."""
+        async with self.lock:
+            container = self.container()
+            if container is None:
+                return VolumeSyncReport()
+            try:
+                report = await self.sync_once(container)
+            except Exception as e:
+                self.last_error = str(e)
+                raise
+            self.last_sync = time.time()
+            self.last_error = ""
+            if report:
+                self.last_report = report
+                logger.info(f"📦 Volume sync: {report.describe()}")
+        if report.pushed or report.deleted:
+            await self.on_pushed(report.pushed)
+        return report
+
+    async def watch(self) -> None:
+        while True:
+            try:
+                await self.run()
+            except Exception as e:
+                logger.warning(f"Volume sync failed: {e}")
+            await asyncio.sleep(self.interval)
@@ -0,0 +1,106 @@
+"""
+WebAssembly SWI-Prolog Backend for Docker SWISH MCP
+
+With --backend=wasm (or SWISH_MCP_BACKEND=wasm) the persistent session
+runs SWI-Prolog compiled to WebAssembly under a WASI runtime instead of
+in the container, so simple queries need neither Docker nor an installed
+swipl. SWISH_MCP_WASM_MODULE is the path of the swipl .wasm module and
+SWISH_MCP_WASM_RUNTIME the runtime that runs it: wasmtime (default),
+wasmer or wazero, or any other command taking the module and its
+arguments. The data directory is the module's only preopened directory.
+
+Findings that shaped this backend:
+
+- The WebAssembly build SWI-Prolog publishes (swipl-wasm, the one behind
+  the browser version) is an Emscripten build. It needs Emscripten's
+  JavaScript glue and runs under Node or a browser, not a WASI runtime,
+  so it cannot be used here. A WASI build has to be made from source
+  with the WASI SDK; SWISH_MCP_WASM_MODULE points to the result.
+- Embedding the module in this process (wasmtime-py) was ruled out:
+  WASI gives a module files for stdin and stdout, not the pipes the
+  session's line protocol runs over. The runtime therefore runs as a
+  subprocess, just like the local swipl, and is the one dependency left.
+- A WASI build has no threads, sockets or processes. Plain queries,
+  consulting files, assert/retract and the line protocol work, but
+  threads, timeouts that need signal threads, HTTP, shell/1 and
+  library(process) do not.
+
+Whatever needs more than that goes to the container, as the local
+backend's limits do: pengines, isolated and concurrent queries and the
+container tools say so. And when the module or the runtime is missing,
+or fails to run, the server falls back to the container backend at
+startup instead of running without Prolog.
+"""
+
+import shutil
+import subprocess
+from pathlib import Path
+
+from .local_backend import LocalBackendError, LocalProcessClient, parse_swipl_version
+
+# How each runtime runs a module with the working directory preopened; {module} is replaced
+WASM_RUNTIMES = {
+    "wasmtime": ["run", "--dir=.", "{module}"],
+    "wasmer": ["run", "--dir=.", "{module}", "--"],
+    "wazero": ["run", "-mount=.:.", "{module}"],
+}
+DEFAULT_WASM_RUNTIME = "wasmtime"
+
+
+class WasmBackendError(LocalBackendError):
+    """Raised when the wasm module or its runtime is unusable."""
+
+
+class WasmProcessClient(LocalProcessClient):
+    """
+    Runs the session's swipl as a WebAssembly module under a WASI runtime.
+
+    Args:
+        data_dir: Working directory, preopened for the module
+        module: Path of the swipl .wasm module
+        runtime: wasmtime, wasmer, wazero, or another runtime command
+    """
+
+    def __init__(self, data_dir: Path, module: str, runtime: str = DEFAULT_WASM_RUNTIME):
+        super().__init__(data_dir, runtime or DEFAULT_WASM_RUNTIME)
+        self.module = module
+
+    def for_dir(self, data_dir: Path) -> "WasmProcessClient":
+        return WasmProcessClient(data_dir, self.module, self.binary)
+
+    def command(self, args: list[str]) -> list[str]:
+        """The runtime command running the module with swipl's args."""
+        if not self.module:
+            raise WasmBackendError("SWISH_MCP_WASM_MODULE is not set; point it to a WASI build of swipl")
+        module = Path(self.module).expanduser()
+        if not module.is_file():
+            raise WasmBackendError(f"WebAssembly module {module} not found")
+        runtime = shutil.which(self.binary)
+        if runtime is None:
+            raise WasmBackendError(
+                f"'{self.binary}' not found on PATH; install a WASI runtime or set SWISH_MCP_WASM_RUNTIME"
+            )
+        template = WASM_RUNTIMES.get(Path(self.binary).name, ["{module}"])
+        return [runtime, *(str(module.resolve()) if part == "{module}" else part for part in template), *args]
+
+    def ping(self) -> bool:
+        """Check that the module runs, recording the SWI-Prolog version it reports."""
+        cmd = self.command(["--version"])
+        try:
+            result = subprocess.run(cmd, cwd=self.data_dir, capture_output=True, timeout=60)
+        except (OSError, subprocess.TimeoutExpired) as e:
+            raise WasmBackendError(f"Could not run {self.module} under {self.binary}: {e}") from e
+        if result.returncode != 0:
+            raise WasmBackendError(
+                f"{self.module} --version failed under {self.binary}: "
+                f"{result.stderr.decode(errors='replace').strip()}"
+            )
+        self.version = parse_swipl_version(result.stdout.decode(errors="replace"))
+        return True
+
+    @property
+    def engine(self) -> str:
+        return f"{self.module} on {self.binary} ({self.version_text}, WebAssembly)"
+
+    def host_command(self, cmd: list[str]) -> list[str]:
+        return self.command(cmd[1:]) if cmd and cmd[0] == "swipl" else cmd
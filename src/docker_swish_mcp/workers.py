@@ -0,0 +1,144 @@
+"""
+Concurrent Query Worker Pool for Docker SWISH MCP
+
+The persistent session runs one goal at a time, so a slow query makes
+every other client wait. Independent queries can instead run on their own
+pengines through this pool, which caps how many run at once (to protect
+the container) and how many one client may hold, and hands free slots to
+waiting clients in round-robin order so a client submitting a large batch
+cannot starve the others.
+"""
+
+import asyncio
+import logging
+from collections import deque
+from collections.abc import Awaitable, Callable
+from typing import Any, TypeVar
+
+logger = logging.getLogger("docker-swish-mcp.workers")
+
+T = TypeVar("T")
+
+
+class WorkerPoolError(Exception):
+    """Raised when the pool's wait queue is full."""
+
+
+class WorkerPool:
+    """
+    Fair, bounded scheduler for concurrent jobs.
+
+    Args:
+        max_concurrency: Jobs allowed to run at the same time
+        max_per_client: Jobs one client may run at the same time
+        max_queued: Jobs allowed to wait for a slot; more are refused
+    """
+
+    def __init__(self, max_concurrency: int = 4, max_per_client: int = 2, max_queued: int = 64):
+        self.max_concurrency = max(1, max_concurrency)
+        self.max_per_client = max(1, min(max_per_client, self.max_concurrency))
+        self.max_queued = max(0, max_queued)
+        self.running: dict[str, int] = {}
+        self.waiting: dict[str, deque[asyncio.Future[None]]] = {}
+        self.rotation: deque[str] = deque()
+        self.completed = 0
+
+    @property
+    def running_total(self) -> int:
+        return sum(self.running.values())
+
+    @property
+    def queued_total(self) -> int:
+        return sum(len(q) for q in self.waiting.values())
+
+    async def submit(self, client_id: str, job: Callable[[], Awaitable[T]]) -> T:
+        """Wait for a slot for client_id, then run job and return its result."""
+        await self._acquire(client_id)
+        try:
+            return await job()
+        finally:
+            self._release(client_id)
+
+    async def run_all(self, client_id: str, jobs: list[Callable[[], Awaitable[T]]]) -> list[T | BaseException]:
+        """Submit several jobs and gather their results (or exceptions) in order."""
+        return await asyncio.gather(*(self.submit(client_id, job) for job in jobs), return_exceptions=True)
+
+    async def _acquire(self, client_id: str) -> None:
+        if self.queued_total >= self.max_queued and not self._has_slot(client_id):
+            raise WorkerPoolError(
+                f"Worker pool queue is full ({self.max_queued} queries waiting); try again shortly"
+            )
+        slot: asyncio.Future[None] = asyncio.get_running_loop().create_future()
+        self.waiting.setdefault(client_id, deque()).append(slot)
+        if client_id not in self.rotation:
+            self.rotation.append(client_id)
+        self._dispatch()
+        try:
+            await slot
+        except asyncio.CancelledError:
+            if slot.done() and not slot.cancelled():
+                # Cancelled after the slot was granted: hand it back
+                self._release(client_id)
+            else:
+                queue = self.waiting.get(client_id)
+                if queue and slot in queue:
+                    queue.remove(slot)
+            raise
+
+    def _has_slot(self, client_id: str) -> bool:
+        return (
+            self.running_total < self.max_concurrency
+            and self.running.get(client_id, 0) < self.max_per_client
+        )
+
+    def _release(self, client_id: str) -> None:
+        self.running[client_id] -= 1
+        if self.running[client_id] <= 0:
+            del self.running[client_id]
+        self.completed += 1
+        self._dispatch()
+
+    def _dispatch(self) -> None:
+        """Grant free slots, one client at a time in rotation order."""
+        while self.running_total < self.max_concurrency:
+            client_id = self._next_eligible()
+            if client_id is None:
+                return
+            queue = self.waiting[client_id]
+            queue.popleft().set_result(None)
+            self.running[client_id] = self.running.get(client_id, 0) + 1
+            if not queue:
+                del self.waiting[client_id]
+                self.rotation.remove(client_id)
+
+    def _next_eligible(self) -> str | None:
+        """Next client in rotation with a waiting job and a free per-client slot."""
+        for client_id in list(self.rotation):
+            queue = self.waiting.get(client_id)
+            while queue and queue[0].done():
+                queue.popleft()
+            if not queue:
+                self.waiting.pop(client_id, None)
+                self.rotation.remove(client_id)
+        for _ in range(len(self.rotation)):
+            client_id = self.rotation[0]
+            self.rotation.rotate(-1)
+            if self.running.get(client_id, 0) < self.max_per_client:
+                return client_id
+        return None
+
+    def get_status(self) -> dict[str, Any]:
+        return {
+            "max_concurrency": self.max_concurrency,
+            "max_per_client": self.max_per_client,
+            "running": self.running_total,
+            "queued": self.queued_total,
+            "completed": self.completed,
+            "clients": {
+                client_id: {
+                    "running": self.running.get(client_id, 0),
+                    "queued": len(self.waiting.get(client_id, ())),
+                }
+                for client_id in sorted(set(self.running) | set(self.waiting))
+            },
+        }
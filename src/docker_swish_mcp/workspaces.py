@@ -0,0 +1,161 @@
+"""
+Workspaces for Docker SWISH MCP
+
+A workspace is a named, separate program directory with its own
+persistent Prolog session, so sessions for different projects stop
+sharing files, consulted predicates and asserted facts. Pass the
+workspace name as the `instance` argument of query and file tools to
+route calls to it.
+
+A workspace either shares the primary container, with its files in
+workspaces/<name> below the primary data directory (mounted at
+/data/workspaces/<name>), or runs its own container on its own port
+with its own data directory, like a cluster instance.
+
+The registry is kept in workspaces.json in the workspaces root
+(SWISH_MCP_WORKSPACES_DIR), so workspaces come back when the server
+restarts:
+
+    {"workspaces": [{"name": "thesis", "container": false, ...}]}
+"""
+
+import json
+import logging
+import time
+from dataclasses import asdict, dataclass, field
+from pathlib import Path
+from typing import Any
+
+from .orchestration import INSTANCE_NAME_RE, PRIMARY_PORT
+
+logger = logging.getLogger("docker-swish-mcp.workspaces")
+
+REGISTRY_NAME = "workspaces.json"
+# Subdirectory of the primary data directory holding shared-container workspaces
+SHARED_DIR = "workspaces"
+
+
+class WorkspaceError(ValueError):
+    """Raised for invalid, duplicate or unknown workspaces."""
+
+
+@dataclass
+class Workspace:
+    """One registered workspace."""
+    name: str
+    data_dir: Path
+    # True when the workspace runs its own container on port
+    container: bool = False
+    port: int = 0
+    description: str = ""
+    created: float = field(default_factory=time.time)
+
+    @property
+    def container_name(self) -> str:
+        return f"swish-mcp-ws-{self.name}"
+
+    def to_json(self) -> dict[str, Any]:
+        data = asdict(self)
+        data["data_dir"] = str(self.data_dir)
+        return data
+
+    @classmethod
+    def from_json(cls, data: dict[str, Any]) -> "Workspace":
+        return cls(
+            name=str(data["name"]),
+            data_dir=Path(data["data_dir"]),
+            container=bool(data.get("container", False)),
+            port=int(data.get("port") or 0),
+            description=str(data.get("description", "")),
+            created=float(data.get("created") or time.time()),
+        )
+
+
+def validate_name(name: str, taken: set[str]) -> str:
+    """Return name if it can name a new workspace, else raise WorkspaceError."""
+    if not INSTANCE_NAME_RE.match(name):
+        raise WorkspaceError(f"Invalid workspace name '{name}' (use lowercase letters, digits, '.', '_' or '-')")
+    if name in taken:
+        raise WorkspaceError(f"'{name}' is already the name of a workspace or cluster instance")
+    return name
+
+
+def free_port(used: set[int]) -> int:
+    """The first port after the primary container's that nothing uses."""
+    port = PRIMARY_PORT + 1
+    while port in used:
+        port += 1
+    return port
+
+
+class WorkspaceRegistry:
+    """Workspaces by name, saved to workspaces.json in root."""
+
+    def __init__(self, root: Path):
+        self.root = root
+        self.workspaces: dict[str, Workspace] = {}
+
+    @property
+    def path(self) -> Path:
+        return self.root / REGISTRY_NAME
+
+    def load(self) -> None:
+        """Read the registry, skipping entries that no longer parse."""
+        self.workspaces.clear()
+        if not self.path.exists():
+            return
+        try:
+            entries = json.loads(self.path.read_text(encoding="utf-8")).get("workspaces", [])
+        except (OSError, json.JSONDecodeError) as e:
+            logger.warning(f"Could not read {self.path}: {e}")
+            return
+        for entry in entries:
+            try:
+                workspace = Workspace.from_json(entry)
+            except (KeyError, TypeError, ValueError) as e:
+                logger.warning(f"Skipping workspace entry {entry!r}: {e}")
+                continue
+            self.workspaces[workspace.name] = workspace
+
+    def save(self) -> None:
+        self.root.mkdir(parents=True, exist_ok=True)
+        data = {"workspaces": [w.to_json() for w in sorted(self.workspaces.values(), key=lambda w: w.name)]}
+        self.path.write_text(json.dumps(data, indent=2) + "\n", encoding="utf-8")
+
+    def create(
+        self,
+        name: str,
+        shared_root: Path,
+        container: bool = False,
+        description: str = "",
+        taken: set[str] | None = None,
+        used_ports: set[int] | None = None
+    ) -> Workspace:
+        """
+        Register a new workspace and create its data directory.
+
+        Shared-container workspaces live in shared_root/workspaces/<name>;
+        those with their own container in <root>/<name> and get the
+        first free port.
+
+        Raises:
+            WorkspaceError: if the name is invalid or taken
+        """
+        validate_name(name, set(self.workspaces) | (taken or set()))
+        if container:
+            ports = {w.port for w in self.workspaces.values() if w.container} | (used_ports or set())
+            workspace = Workspace(name, self.root / name, True, free_port(ports), description)
+        else:
+            workspace = Workspace(name, shared_root / SHARED_DIR / name, description=description)
+        workspace.data_dir.mkdir(parents=True, exist_ok=True)
+        self.workspaces[name] = workspace
+        self.save()
+        return workspace
+
+    def remove(self, name: str) -> Workspace:
+        """Unregister a workspace; its files stay on disk."""
+        if name not in self.workspaces:
+            raise WorkspaceError(f"Unknown workspace '{name}'")
+        workspace = self.workspaces.pop(name)
+        self.save()
+        return workspace
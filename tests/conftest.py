@@ -0,0 +1,93 @@
+"""
+Shared fixtures. Tests that need a Prolog session run against the
+in-memory mock backend (see mock_backend.py), so they need neither
+Docker nor swipl. Those about the session's wire protocol drive it
+through FakeProlog, a stand-in for the swipl process.
+"""
+
+import os
+import re
+import tempfile
+from collections.abc import Callable
+
+# Read when main is imported, so set before any test imports it
+os.environ["SWISH_MCP_BACKEND"] = "mock"
+os.environ["SWISH_MCP_DATA_DIR"] = tempfile.mkdtemp(prefix="swish-mcp-tests-")
+
+import pytest  # noqa: E402
+
+from docker_swish_mcp import main  # noqa: E402
+from docker_swish_mcp.simple_session import SimplePrologSession  # noqa: E402
+
+QUERY_ID_RE = re.compile(r"\b(q\d+x[0-9a-f]{6})\b")
+
+
+class FakeProlog:
+    """
+    A swipl process whose replies are scripted.
+
+    reply is called with each goal written to stdin and returns the lines
+    swipl would print for it; "{id}" in a line stands for the query id
+    the goal carries.
+    """
+
+    def __init__(self, reply: Callable[[str], list[str]]):
+        self.reply = reply
+        self.goals: list[str] = []
+        self.pending: list[str] = []
+        self.returncode: int | None = None
+        self.signals: list[int] = []
+        self.stdin = self
+        self.stdout = self
+
+    def write(self, data: bytes) -> None:
+        goal = data.decode()
+        if goal == "halt.\n":
+            self.returncode = 0
+            return
+        self.goals.append(goal)
+        match = QUERY_ID_RE.search(goal)
+        query_id = match.group(1) if match else ""
+        self.pending += [line.replace("{id}", query_id) for line in self.reply(goal)]
+
+    async def drain(self) -> None:
+        pass
+
+    def close(self) -> None:
+        pass
+
+    async def readline(self) -> bytes:
+        if not self.pending:
+            return b""
+        return self.pending.pop(0).encode() + b"\n"
+
+    async def wait(self) -> int:
+        self.returncode = self.returncode if self.returncode is not None else 0
+        return self.returncode
+
+    def send_signal(self, sig: int) -> None:
+        self.signals.append(sig)
+
+    def terminate(self) -> None:
+        self.returncode = -15
+
+    def kill(self) -> None:
+        self.returncode = -9
+
+
+@pytest.fixture
+def fake_session() -> Callable[[Callable[[str], list[str]]], SimplePrologSession]:
+    """Make a started session whose swipl is a FakeProlog with the given replies."""
+    def make(reply: Callable[[str], list[str]]) -> SimplePrologSession:
+        session = SimplePrologSession("swish-test")
+        session.process = FakeProlog(reply)
+        session.session_active = True
+        return session
+    return make
+
+
+@pytest.fixture
+async def swish():
+    """A started mock backend context, as the server's lifespan sets it up."""
+    async with main.swish_environment(main.mcp) as context:
+        yield context
@@ -0,0 +1,97 @@
+"""Cutting answers to their size limit."""
+
+import json
+
+from docker_swish_mcp import main
+from docker_swish_mcp.answer_limits import Truncation, cut, fitting
+from docker_swish_mcp.config import QueryLimits, ServerConfig
+from docker_swish_mcp.query_cache import QueryCache
+from docker_swish_mcp.tool_schemas import DEFS, RESULT_SCHEMAS, validate
+
+GOAL = "member(X, [aaaa, bbbb, cccc, dddd])"
+
+
+def test_fitting_counts_each_line_with_its_newline():
+    solutions = ["X = 1", "X = 2", "X = 3"]
+
+    assert fitting(solutions, False, 0) == 3
+    assert fitting(solutions, False, 12) == 2
+    assert fitting(solutions, False, 11) == 1
+    assert fitting([{"X": 1}], True, 9) == 1
+
+
+def test_cut_holds_back_the_rest():
+    solutions = ["X = 1", "X = 2", "X = 3"]
+
+    held, truncation = cut(solutions, False, 12)
+
+    assert solutions == ["X = 1", "X = 2"]
+    assert held == ["X = 3"]
+    assert (truncation.returned, truncation.found, truncation.oversized) == (2, 3, False)
+
+
+def test_cut_keeps_an_oversized_first_solution():
+    solutions = ["X = " + "a" * 50, "X = b"]
+
+    held, truncation = cut(solutions, False, 10)
+
+    assert len(solutions) == 1 and held == ["X = b"]
+    assert truncation.oversized
+    assert "the first alone is larger than the limit" in truncation.note()
+
+
+def test_answer_within_limit_is_left_alone():
+    solutions = ["X = 1"]
+
+    assert cut(solutions, False, 100) == ([], None)
+    assert solutions == ["X = 1"]
+
+
+def test_truncation_notes():
+    truncation = Truncation(2, 4, 20, next_cursor="c1")
+
+    assert truncation.to_json() == {
+        "truncated": True, "solutions_returned": 2, "solutions_found": 4,
+        "max_answer_bytes": 20, "next_cursor": "c1",
+    }
+    assert truncation.note().endswith('Fetch the rest with cursor="c1", or refine the query.')
+    assert truncation.note(paged=True) == (
+        "✂️ Answer size limit (20 bytes) reached: "
+        "returned 2 of the 4 solutions fetched for this page; the rest start the next page."
+    )
+    assert Truncation(1, 2, 20).to_json()["next_cursor"] is None
+
+
+def test_server_limit_from_the_environment(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_MAX_ANSWER_BYTES", "4096")
+    assert ServerConfig.from_env().max_answer_bytes == 4096
+
+    monkeypatch.setenv("SWISH_MCP_MAX_ANSWER_BYTES", "-5")
+    assert ServerConfig.from_env().max_answer_bytes == 0
+
+
+def test_answer_limit_is_part_of_the_cache_key():
+    limits = QueryLimits()
+
+    assert QueryCache.key("swish", GOAL, "user", "text", limits) != QueryCache.key(
+        "swish", GOAL, "user", "text", limits, answer_bytes=40
+    )
+
+
+async def test_truncated_json_answer_continues_with_its_cursor(swish):
+    answer = json.loads(await main.execute_prolog_query(GOAL, output_format="json", max_answer_bytes=40))
+
+    assert validate(answer, RESULT_SCHEMAS["execute_prolog_query"], "result", DEFS) == []
+    assert [solution["X"]["value"] for solution in answer["solutions"]] == ["aaaa"]
+    assert (answer["truncated"], answer["solutions_returned"], answer["solutions_found"]) == (True, 1, 4)
+
+    rest = json.loads(await main.execute_prolog_query("", cursor=answer["next_cursor"], output_format="json"))
+
+    assert [solution["X"]["value"] for solution in rest["solutions"]] == ["bbbb"]
+
+
+async def test_truncated_text_answer_ends_with_a_note(swish):
+    answer = await main.execute_prolog_query(GOAL, max_answer_bytes=20)
+
+    assert "X = bbbb" in answer and "X = cccc" not in answer
+    assert "returned 2 of the 4 solutions found" in answer.splitlines()[-1]
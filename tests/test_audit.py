@@ -0,0 +1,46 @@
+"""Audit log entries and the undo state they keep."""
+
+from docker_swish_mcp import main
+from docker_swish_mcp.audit import AuditLog
+
+
+def test_binary_files_round_trip(tmp_path):
+    data_dir = tmp_path / "data"
+    data_dir.mkdir()
+    image = data_dir / "diagram.png"
+    image.write_bytes(b"\x89PNG\r\n\x1a\n\xff\xfe")
+    log = AuditLog(data_dir)
+
+    before = log.capture_files([data_dir])
+    image.write_bytes(b"overwritten")
+    entry = log.record_files("test", "save_file", "diagram.png", [data_dir], before)
+    log.restore_files(log.pop_undo()[0][1].files)
+
+    assert entry.changes == ["modified diagram.png"]
+    assert image.read_bytes() == b"\x89PNG\r\n\x1a\n\xff\xfe"
+
+
+def test_counted_database_logs_net_change(tmp_path):
+    log = AuditLog(tmp_path / "data")
+    before = [{"name": "fact", "arity": 1, "count": 3}]
+    after = [{"name": "fact", "arity": 1, "count": 5}, {"name": "seen", "arity": 0, "count": 1}]
+
+    entry = log.record_database("test", "execute_prolog_query", "assertz(...)", before, after)
+
+    assert entry.changes == ["fact/1 +2 net", "seen/0 +1 net"]
+    assert not entry.undoable
+    assert entry.delta is None
+    assert log.record_database("test", "execute_prolog_query", "x", before, before) is None
+
+
+async def test_large_database_is_not_dumped(swish, monkeypatch):
+    monkeypatch.setattr(main, "MAX_UNDO_CLAUSES", 1)
+    await main.execute_prolog_query("assertz(item(1)), assertz(item(2))")
+
+    rows = await main.database_snapshot(swish, "user", main.MAX_UNDO_CLAUSES)
+    await main.execute_prolog_query("assertz(item(3))")
+    entry = main.audit_log(swish).read()[-1]
+
+    assert {"name": "item", "arity": 1, "count": 2} in rows
+    assert entry.changes == ["item/1 +1 net"]
+    assert not entry.undoable
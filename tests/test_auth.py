@@ -0,0 +1,116 @@
+"""Scopes of API keys, and the middleware that checks them."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp import main
+from docker_swish_mcp.auth import (
+    IMPLIED_SCOPES,
+    TOOL_SCOPES,
+    ApiKey,
+    ApiKeyStore,
+    BearerAuthMiddleware,
+    hash_key,
+)
+
+# Tools that run goals able to change the database or files
+WRITE_TOOLS = (
+    "create_prolog_file",
+    "load_knowledge_base",
+    "consult_url",
+    "query_batch",
+    "share_module",
+    "schedule_query",
+    "repl_send",
+    "template_register",
+    "query_map",
+    "engine_query",
+)
+
+
+def key(scope):
+    return ApiKey("test", "", IMPLIED_SCOPES[scope])
+
+
+def test_query_key_runs_strict(monkeypatch):
+    monkeypatch.setattr(main, "current_api_key", lambda: key("query"))
+
+    assert main.sandbox_policy().mode == "strict"
+
+
+def test_write_key_keeps_policy(monkeypatch):
+    monkeypatch.setattr(main, "current_api_key", lambda: key("write"))
+
+    assert main.sandbox_policy().mode == main.server_config.sandbox.default.mode
+
+
+@pytest.mark.parametrize("tool", WRITE_TOOLS)
+def test_state_changing_tools_need_write(tool):
+    assert TOOL_SCOPES[tool] == "write"
+
+
+def test_api_key_identifies_client(monkeypatch):
+    monkeypatch.setattr(main, "current_api_key", lambda: key("query"))
+
+    assert main.current_client_id() == "test"
+
+
+def test_keys_are_parsed_with_implied_scopes():
+    store = ApiKeyStore(json.dumps({"keys": [
+        {"id": "ci", "key": "s3cret", "scopes": "write"},
+        {"sha256": hash_key("other")},
+    ]}), single_key="root")
+
+    assert store.authenticate("s3cret").scopes == {"query", "write"}
+    assert store.authenticate("other").key_id == "key2"
+    assert store.authenticate("root").allows("admin")
+    assert store.authenticate("guess") is None
+
+
+@pytest.mark.parametrize("entry, message", [
+    ({"id": "bad"}, "neither"),
+    ({"id": "bad", "sha256": "abc"}, "malformed sha256"),
+    ({"id": "bad", "key": "k", "scopes": ["root"]}, "unknown scopes"),
+])
+def test_malformed_keys_refuse_every_request(entry, message):
+    store = ApiKeyStore(json.dumps([entry]))
+
+    assert message in store.error
+    assert store.enabled and not store.keys
+
+
+def test_reload_keeps_previous_keys_when_the_file_breaks(tmp_path):
+    path = tmp_path / "keys.json"
+    path.write_text(json.dumps([{"key": "one"}]), encoding="utf-8")
+    store = ApiKeyStore(str(path))
+
+    path.write_text("[{", encoding="utf-8")
+
+    assert not store.reload()
+    assert store.authenticate("one") is not None
+
+
+async def call(app, headers):
+    sent = []
+
+    async def send(message):
+        sent.append(message)
+
+    await app({"type": "http", "headers": headers}, None, send)
+    return sent
+
+
+async def test_middleware_checks_the_bearer_key():
+    seen = []
+
+    async def app(scope, receive, send):
+        seen.append(scope["state"]["api_key"].key_id)
+
+    middleware = BearerAuthMiddleware(app, ApiKeyStore('[{"id": "ci", "key": "s3cret"}]'))
+
+    refused = await call(middleware, [(b"authorization", b"Bearer wrong")])
+    await call(middleware, [(b"authorization", b"Bearer s3cret")])
+
+    assert refused[0]["status"] == 401
+    assert seen == ["ci"]
@@ -0,0 +1,59 @@
+"""Batch goals and the report of a batch's rows."""
+
+import pytest
+
+from docker_swish_mcp.batches import (
+    MAX_BATCH_GOALS,
+    BatchResult,
+    batch_call,
+    batch_goals,
+)
+
+GOALS = ["assertz(stock(apple, 3))", "retract(stock(pear, _))", "X is 1/0"]
+
+
+def test_goals_are_cleaned_and_quoted():
+    goals = batch_goals(["?- assertz(a).", 'atom_length("x y", N).'])
+
+    assert goals == ["assertz(a)", 'atom_length("x y", N)']
+    assert batch_call(goals, "limits(1)") == ("mcp_batch", ['["assertz(a)", "atom_length(\\"x y\\", N)"]', "limits(1)"])
+
+
+@pytest.mark.parametrize("goals, message", [
+    ([], "has no goals"),
+    (["true", 3], "must be a string"),
+    (["true", "?- ."], "empty goal"),
+    (["true"] * (MAX_BATCH_GOALS + 1), "at most"),
+])
+def test_malformed_batches_are_refused(goals, message):
+    with pytest.raises(ValueError, match=message):
+        batch_goals(goals)
+
+
+def test_rolled_back_batch_reports_the_goal_that_stopped_it():
+    result = BatchResult.from_rows(GOALS, [
+        {"goal": 1, "status": "succeeded"},
+        {"goal": 2, "status": "failed"},
+        {"committed": False},
+    ])
+
+    assert result.format() == (
+        "↩️ Batch rolled back: no database changes were kept\n"
+        "  ✅ 1. assertz(stock(apple, 3)) → true\n"
+        "  ❌ 2. retract(stock(pear, _)) → failed\n"
+        "  ⏭️ 1 remaining goal(s) not run"
+    )
+
+
+def test_committed_batch_as_json():
+    result = BatchResult.from_rows(GOALS[:1] + ["X = 2"], [
+        {"goal": 1, "status": "succeeded"},
+        {"goal": 2, "status": "succeeded", "bindings": "X = 2", "json": [{"X": 2}]},
+        {"committed": True},
+    ])
+
+    assert result.format().startswith("📦 Batch committed: all 2 goal(s) succeeded\n")
+    assert result.to_json() == {"committed": True, "results": [
+        {"index": 1, "goal": "assertz(stock(apple, 3))", "status": "succeeded"},
+        {"index": 2, "goal": "X = 2", "status": "succeeded", "bindings": [{"X": 2}]},
+    ]}
@@ -0,0 +1,167 @@
+"""Bundle export, and the checks a bundle must pass before it is installed."""
+
+import base64
+import json
+import zipfile
+
+import pytest
+
+from docker_swish_mcp.bundles import (
+    MANIFEST,
+    SIGNATURE,
+    BundleError,
+    BundleSettings,
+    export_bundle,
+    install_files,
+    verify_bundle,
+)
+
+UNSIGNED = BundleSettings()
+
+
+@pytest.fixture
+def data_dir(tmp_path):
+    directory = tmp_path / "data"
+    (directory / "lib").mkdir(parents=True)
+    (directory / "family.pl").write_text("parent(tom, bob).\n")
+    (directory / "lib" / "util.pl").write_text("twice(X, Y) :- Y is 2 * X.\n")
+    (directory / "results").mkdir()
+    (directory / "results" / "answer.pl").write_text("% written by the server\n")
+    return directory
+
+
+def rewrite(bundle, **members):
+    """Replace, add (bytes) or drop (None) members of a bundle."""
+    with zipfile.ZipFile(bundle) as archive:
+        contents = {name: archive.read(name) for name in archive.namelist()}
+    contents.update(members)
+    with zipfile.ZipFile(bundle, "w") as archive:
+        for name, data in contents.items():
+            if data is not None:
+                archive.writestr(name, data)
+
+
+def manifest_of(bundle):
+    with zipfile.ZipFile(bundle) as archive:
+        return json.loads(archive.read(MANIFEST))
+
+
+def test_export_and_verify_round_trip(data_dir):
+    bundle, exported = export_bundle(data_dir, "family rules", None, UNSIGNED)
+
+    verification, contents = verify_bundle(bundle, UNSIGNED)
+
+    assert bundle.parent == data_dir.parent / "swish-bundles" / "data"
+    assert bundle.name.startswith("family_rules-")
+    assert sorted(contents) == ["family.pl", "lib/util.pl"]
+    assert verification.files == exported.files and verification.describe() == "unsigned"
+
+
+def test_changed_files_fail_their_hash(data_dir):
+    bundle, _ = export_bundle(data_dir, "kb", None, UNSIGNED)
+    rewrite(bundle, **{"files/family.pl": b"parent(eve, bob).\n"})
+
+    with pytest.raises(BundleError, match="family.pl does not match its hash in the manifest"):
+        verify_bundle(bundle, UNSIGNED)
+
+
+def test_members_outside_the_manifest_are_refused(data_dir):
+    bundle, _ = export_bundle(data_dir, "kb", None, UNSIGNED)
+    rewrite(bundle, **{"files/extra.pl": b":- initialization(halt).\n"})
+
+    with pytest.raises(BundleError, match="files not in its manifest: files/extra.pl"):
+        verify_bundle(bundle, UNSIGNED)
+
+
+def test_missing_and_escaping_manifest_paths(data_dir):
+    bundle, _ = export_bundle(data_dir, "kb", None, UNSIGNED)
+    rewrite(bundle, **{"files/lib/util.pl": None})
+    with pytest.raises(BundleError, match="lib/util.pl is listed in the manifest but missing"):
+        verify_bundle(bundle, UNSIGNED)
+
+    manifest = manifest_of(bundle)
+    manifest["files"][0]["path"] = "../outside.pl"
+    rewrite(bundle, **{MANIFEST: json.dumps(manifest)})
+    with pytest.raises(BundleError, match="'../outside.pl' is not a plain relative path"):
+        verify_bundle(bundle, UNSIGNED)
+
+
+def test_unsigned_bundles_under_required_signatures(data_dir):
+    bundle, _ = export_bundle(data_dir, "kb", None, UNSIGNED)
+
+    with pytest.raises(BundleError, match="is not signed, and SWISH_MCP_BUNDLE_SIGNATURES is required"):
+        verify_bundle(bundle, BundleSettings(signatures="required"))
+
+
+def test_not_a_bundle(tmp_path):
+    (tmp_path / "notes.zip").write_text("plain text")
+    with pytest.raises(BundleError, match="notes.zip is not a zip file"):
+        verify_bundle(tmp_path / "notes.zip", UNSIGNED)
+
+    with zipfile.ZipFile(tmp_path / "other.zip", "w") as archive:
+        archive.writestr(MANIFEST, json.dumps({"format": "something-else"}))
+    with pytest.raises(BundleError, match="is not a knowledge base bundle"):
+        verify_bundle(tmp_path / "other.zip", UNSIGNED)
+
+
+def test_install_keeps_files_inside_the_data_directory(data_dir):
+    contents = {"family.pl": b"parent(tom, bob).\n", "new.pl": b"new.\n"}
+
+    assert install_files(data_dir, contents) == (["new.pl"], ["family.pl"])
+    assert install_files(data_dir, contents, target="imported") == (["imported/family.pl", "imported/new.pl"], [])
+    with pytest.raises(BundleError, match="not a plain relative path"):
+        install_files(data_dir, contents, target="../elsewhere")
+    (data_dir / "lib" / "escape").symlink_to(data_dir.parent)
+    with pytest.raises(BundleError, match="target 'lib/escape' is outside the data directory"):
+        install_files(data_dir, contents, target="lib/escape")
+
+
+def test_install_does_not_overwrite_by_default(data_dir):
+    contents = {"family.pl": b"parent(eve, bob).\n", "new.pl": b"new.\n"}
+
+    with pytest.raises(BundleError, match="These files exist with other contents: family.pl"):
+        install_files(data_dir, contents)
+    assert not (data_dir / "new.pl").exists()
+    assert install_files(data_dir, contents, overwrite=True) == (["family.pl", "new.pl"], [])
+
+
+def signing_key(tmp_path, name):
+    """A new ed25519 key in a PEM file, and its base64 public key."""
+    ed25519 = pytest.importorskip("cryptography.hazmat.primitives.asymmetric.ed25519")
+    from cryptography.hazmat.primitives import serialization
+    key = ed25519.Ed25519PrivateKey.generate()
+    path = tmp_path / f"{name}.pem"
+    path.write_bytes(key.private_bytes(
+        serialization.Encoding.PEM, serialization.PrivateFormat.PKCS8, serialization.NoEncryption(),
+    ))
+    public = key.public_key().public_bytes(serialization.Encoding.Raw, serialization.PublicFormat.Raw)
+    return path, base64.b64encode(public).decode()
+
+
+def test_signed_bundles_need_a_trusted_key(data_dir, tmp_path):
+    ours, our_public = signing_key(tmp_path, "ours")
+    theirs, _ = signing_key(tmp_path, "theirs")
+    trusted = tmp_path / "trusted"
+    trusted.write_text(f"# team keys\n{our_public} team-a\n")
+    settings = BundleSettings(trusted_keys_file=trusted, signatures="required")
+
+    bundle, _ = export_bundle(data_dir, "kb", None, BundleSettings(signing_key=ours))
+    assert verify_bundle(bundle, settings)[0].trusted_as == "team-a"
+
+    foreign, _ = export_bundle(data_dir, "other", None, BundleSettings(signing_key=theirs))
+    with pytest.raises(BundleError, match="which is not in SWISH_MCP_BUNDLE_TRUSTED_KEYS"):
+        verify_bundle(foreign, settings)
+
+
+def test_changed_manifests_break_the_signature(data_dir, tmp_path):
+    key, _ = signing_key(tmp_path, "ours")
+    bundle, _ = export_bundle(data_dir, "kb", None, BundleSettings(signing_key=key))
+    manifest = manifest_of(bundle)
+    manifest["name"] = "renamed"
+    rewrite(bundle, **{MANIFEST: json.dumps(manifest, indent=2, sort_keys=True)})
+
+    with pytest.raises(BundleError, match="signature does not verify"):
+        verify_bundle(bundle, UNSIGNED)
+    rewrite(bundle, **{SIGNATURE: b"not base64!"})
+    with pytest.raises(BundleError, match="signature cannot be read"):
+        verify_bundle(bundle, UNSIGNED)
@@ -0,0 +1,62 @@
+"""Running queries by client, and cancelling their tasks."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp.cancellation import QueryRegistry, current_query
+from docker_swish_mcp.errors import from_prolog
+
+
+async def test_track_registers_the_query_while_it_runs():
+    registry = QueryRegistry()
+
+    with registry.track("alice", "member(X, [1])", "session") as query:
+        assert current_query.get() is query
+        assert registry.visible("alice") == [query]
+        assert registry.visible("bob") == [] and registry.visible("bob", everyone=True) == [query]
+
+    assert registry.running == {} and current_query.get() is None
+
+
+async def test_cancel_stops_the_queries_task():
+    registry = QueryRegistry()
+    started = asyncio.Event()
+
+    async def run():
+        with registry.track("alice", "repeat, fail", "isolated", "worker-1"):
+            started.set()
+            await asyncio.sleep(60)
+
+    task = asyncio.create_task(run())
+    await started.wait()
+    (query,) = registry.visible("alice")
+    assert query.describe().startswith(f"{query.query_id}: repeat, fail (isolated, worker-1, running ")
+
+    with pytest.raises(ValueError, match="was started by another client"):
+        registry.cancel(query.query_id, "bob")
+    registry.cancel(query.query_id, "bob", everyone=True)
+    with pytest.raises(asyncio.CancelledError):
+        await task
+
+    assert query.cancelled_by == "bob" and registry.running == {}
+    with pytest.raises(ValueError, match="may have finished already"):
+        registry.cancel(query.query_id, "alice")
+
+
+def test_interrupted_goals_report_a_cancelled_error():
+    error = from_prolog("mcp_cancelled", message="Query c1x0 was cancelled")
+
+    assert (error.kind, error.render().splitlines()[0]) == ("cancelled", "🛑 Query c1x0 was cancelled")
+
+
+async def test_finished_queries_are_kept_with_their_status():
+    registry = QueryRegistry()
+
+    with registry.track("alice", "true", "session"):
+        pass
+    with pytest.raises(RuntimeError):
+        with registry.track("alice", "boom", "session"):
+            raise RuntimeError("lost the session")
+
+    assert [(query.goal, query.status) for query in registry.recent] == [("true", "done"), ("boom", "error")]
@@ -0,0 +1,82 @@
+"""Chaos mode settings, fault rolls and injected errors."""
+
+import random
+
+import pytest
+
+from docker_swish_mcp.chaos import (
+    ChaosMonkey,
+    ChaosSettings,
+    awkward_message,
+    format_chaos_status,
+)
+from docker_swish_mcp.tool_schemas import DEFS, ERROR_SCHEMA, validate
+
+
+def test_settings_from_env(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_CHAOS", "On")
+    monkeypatch.setenv("SWISH_MCP_CHAOS_ERRORS", "2")
+    monkeypatch.setenv("SWISH_MCP_CHAOS_LATENCY", "often")
+    monkeypatch.setenv("SWISH_MCP_CHAOS_SEED", "-7")
+    monkeypatch.setenv("SWISH_MCP_CHAOS_TOOLS", "execute_prolog_query, ,consult_file")
+
+    settings = ChaosSettings.from_env()
+
+    assert settings.enabled and settings.seed == -7
+    # Probabilities are clamped, and unreadable ones keep their default
+    assert (settings.errors, settings.latency) == (1.0, 0.2)
+    assert settings.describe() == (
+        "latency 20% (up to 3s), errors 100%, restarts 1%, dropped notifications 10%; "
+        "execute_prolog_query, consult_file"
+    )
+
+
+def test_faults_apply_to_the_listed_tools_but_never_chaos_status():
+    monkey = ChaosMonkey(ChaosSettings(enabled=True, tools=("consult_file",)))
+
+    assert monkey.applies("consult_file")
+    assert not monkey.applies("execute_prolog_query")
+    assert not ChaosMonkey(ChaosSettings(enabled=True)).applies("chaos_status")
+    assert not ChaosMonkey(ChaosSettings()).applies("consult_file")
+
+
+def test_rolls_are_repeatable_and_remembered():
+    settings = ChaosSettings(enabled=True, errors=0.5, drops=0.0, seed=3)
+    first, second = ChaosMonkey(settings), ChaosMonkey(settings)
+
+    rolls = [first.roll("error", "consult_file") for _ in range(20)]
+
+    assert rolls == [second.roll("error", "consult_file") for _ in range(20)]
+    assert not first.roll("drop", "log_message")
+    status = first.status()
+    assert status["injected"] == {"latency": 0, "error": sum(rolls), "restart": 0, "drop": 0}
+    assert {entry["target"] for entry in status["recent"]} == {"consult_file"}
+
+
+def test_injected_errors_are_valid_but_awkward():
+    monkey = ChaosMonkey(ChaosSettings(enabled=True, seed=1))
+
+    errors = [monkey.error() for _ in range(50)]
+
+    assert all(validate(error.to_json(), ERROR_SCHEMA, "error", DEFS) == [] for error in errors)
+    assert any(error.message == "" for error in errors)
+    assert any("\n" in error.message for error in errors)
+
+
+@pytest.mark.parametrize("seed", range(6))
+def test_awkward_messages_keep_or_extend_the_message(seed):
+    message = awkward_message(random.Random(seed), "SWISH did not answer")
+
+    assert message == "" or message.startswith("SWISH did not answer")
+
+
+def test_format_chaos_status():
+    monkey = ChaosMonkey(ChaosSettings(enabled=True, errors=1.0, seed=1))
+    monkey.calls = 2
+    monkey.roll("error", "consult_file")
+
+    lines = format_chaos_status(monkey.status()).splitlines()
+
+    assert lines[1] == "📊 2 call(s) exposed; injected 0 latency, 1 error, 0 restart, 0 drop"
+    assert lines[2] == "Recent faults:" and lines[3].endswith(" error → consult_file")
+    assert format_chaos_status(ChaosMonkey(ChaosSettings()).status()).startswith("🐒 Chaos mode is off")
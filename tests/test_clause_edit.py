@@ -0,0 +1,81 @@
+"""Clause edits made on the file text, keeping the layout around them."""
+
+import pytest
+
+from docker_swish_mcp.clause_edit import (
+    ClauseEditError,
+    insert_clause,
+    nth_span,
+    parse_spans,
+    predicate_indicator,
+    remove_clauses,
+    replace_clause,
+    retract_targets,
+)
+
+TEXT = """% Family
+parent(tom, bob).
+parent(bob, ann).  % newest
+
+grand(A, C) :- parent(A, B), parent(B, C).
+"""
+
+
+def spans(text, *clauses, matches=()):
+    """Spans as mcp_clause_spans/4 reports them: end is where the term's text stops, before the full stop."""
+    rows = []
+    for predicate, clause in clauses:
+        start = text.index(clause)
+        rows.append({
+            "predicate": predicate, "start": start, "end": start + len(clause) - 1,
+            "line": text.count("\n", 0, start) + 1, "matches": clause in matches,
+        })
+    return parse_spans(text, rows)[0]
+
+
+SPANS = spans(TEXT, ("parent/2", "parent(tom, bob)."), ("parent/2", "parent(bob, ann)."),
+              ("grand/2", "grand(A, C) :- parent(A, B), parent(B, C)."), matches=("parent(bob, ann).",))
+
+
+def test_predicate_indicators():
+    assert predicate_indicator(" parent/2 ") == "parent/2"
+    assert predicate_indicator("greeting//1") == "greeting/3"
+    with pytest.raises(ClauseEditError, match="Invalid predicate indicator 'parent'"):
+        predicate_indicator("parent")
+
+
+def test_new_clause_row_names_its_predicate():
+    assert parse_spans("", [{"new": True, "predicate": "age/2"}]) == ([], "age/2")
+
+
+def test_insert_after_a_clause_or_before_the_first():
+    after_first, index = insert_clause(TEXT, SPANS, "parent/2", "parent(tom, liz)", after=1)
+    before, _ = insert_clause(TEXT, SPANS, "parent/2", "parent(eve, tom).", after=0)
+
+    assert index == 2
+    assert after_first.splitlines()[1:4] == ["parent(tom, bob).", "parent(tom, liz).", "parent(bob, ann).  % newest"]
+    assert before.splitlines()[:2] == ["% Family", "parent(eve, tom)."]
+    with pytest.raises(ClauseEditError, match="cannot insert after clause 3"):
+        insert_clause(TEXT, SPANS, "parent/2", "parent(a, b)", after=3)
+
+
+def test_new_predicate_is_appended():
+    assert insert_clause(TEXT, SPANS, "age/2", "age(tom, 70)") == (TEXT + "\nage(tom, 70).\n", 1)
+    assert insert_clause("p.", [], "q/0", "q") == ("p.\n\nq.\n", 1)
+
+
+def test_replace_keeps_what_shares_the_line():
+    replaced = replace_clause(TEXT, nth_span(SPANS, "parent/2", 2), "parent(bob, eve)")
+
+    assert replaced.splitlines()[2] == "parent(bob, eve).  % newest"
+    with pytest.raises(ClauseEditError, match="there is no clause 3"):
+        nth_span(SPANS, "parent/2", 3)
+    with pytest.raises(ClauseEditError, match="no clauses for age/2"):
+        nth_span(SPANS, "age/2", 1)
+
+
+def test_retract_takes_lone_clauses_lines_along():
+    assert remove_clauses(TEXT, [SPANS[0]]).splitlines()[:2] == ["% Family", "parent(bob, ann).  % newest"]
+    assert remove_clauses(TEXT, retract_targets(SPANS, all_matches=False)).splitlines()[2] == "% newest"
+    with pytest.raises(ClauseEditError, match="No clause in the file"):
+        retract_targets(SPANS[:1], all_matches=True)
@@ -0,0 +1,37 @@
+"""Clients are told apart by their MCP session, whatever client_id they send."""
+
+from types import SimpleNamespace
+
+from docker_swish_mcp import main
+
+
+class Session:
+    pass
+
+
+class Context:
+    client_id = "agent-1"
+
+    def __init__(self):
+        self.session = Session()
+        self.request_context = SimpleNamespace(request=None)
+
+
+def test_client_id_from_metadata_is_ignored(monkeypatch):
+    context = Context()
+    monkeypatch.setattr(main.mcp, "get_context", lambda: context)
+
+    assert main.current_client_id() == main.session_id(context.session)
+    assert main.current_client_id() != "agent-1"
+
+
+def test_sessions_one_after_another_get_new_ids(monkeypatch):
+    seen = set()
+    for _ in range(20):
+        context = Context()
+        monkeypatch.setattr(main.mcp, "get_context", lambda: context)
+        assert main.current_client_id() == main.current_client_id()
+        seen.add(main.current_client_id())
+        # The next session may well get this one's address
+        del context
+    assert len(seen) == 20
@@ -0,0 +1,106 @@
+"""The bridge to SWISH's collaboration websocket, with scripted storage replies."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.collab import (
+    CollabBridge,
+    CollabError,
+    SharedFile,
+    chat_url,
+    commit_of,
+    message_file,
+    storage_name,
+    text_diff,
+)
+from docker_swish_mcp.swish_http import HttpReply, SwishRequestFailed
+
+
+class Storage:
+    """SWISH's gitty storage answering GET, POST and PUT of /p/ files."""
+
+    def __init__(self, **files):
+        self.base_url = "https://swish.example.org"
+        # name -> (commit, text)
+        self.files = dict(files)
+        self.requests = []
+
+    async def request(self, method, path, timeout=30, idempotent=True, **kwargs):
+        self.requests.append((method, path, kwargs.get("json")))
+        name = path.removeprefix("/p/").split("?")[0]
+        if method == "GET":
+            if name not in self.files:
+                raise SwishRequestFailed(404, "not found")
+            commit, text = self.files[name]
+            return HttpReply(200, json.dumps({"data": text, "commit": commit}))
+        body = kwargs["json"]
+        name = body["meta"]["name"]
+        if method == "PUT" and body["meta"]["previous"] != self.files[name][0]:
+            raise SwishRequestFailed(409, "file was modified")
+        commit = f"c{len(self.requests)}"
+        self.files[name] = (commit, body["data"])
+        return HttpReply(200, json.dumps({"meta": {"commit": commit}}))
+
+
+def test_names_and_urls():
+    assert storage_name("https://swish.example.org/p/family.pl") == "family.pl"
+    assert storage_name("family") == "family.pl"
+    with pytest.raises(ValueError, match="Invalid SWISH file name"):
+        storage_name("my family")
+    assert chat_url("https://swish.example.org/", "agent one") == "wss://swish.example.org/chat?nickname=agent+one"
+    assert message_file({"docid": "gitty:family.pl"}) == "family.pl"
+    assert commit_of({"meta": {"commit": "abc"}}) == "abc" and commit_of({}) == ""
+
+
+def test_text_diff():
+    assert text_diff("f.pl", "a.\nb.\n", "a.\nc.\n").splitlines()[2:] == ["@@ -1,2 +1,2 @@", " a.", "-b.", "+c."]
+
+
+async def test_push_builds_on_the_version_last_seen():
+    bridge = CollabBridge(Storage(**{"family.pl": ("c0", "a.\n")}), "agent", notify=None)
+    bridge.files["family.pl"] = SharedFile("family.pl", "c0", "a.\n")
+
+    saved = await bridge.push("family.pl", "a.\nb.\n", "add b")
+    bridge.files["family.pl"] = SharedFile("family.pl", "c0", "a.\n")
+
+    assert bridge.http.requests[-1][2]["meta"] == {"name": "family.pl", "commit_message": "add b", "previous": "c0"}
+    assert saved.commit == bridge.http.files["family.pl"][0]
+    with pytest.raises(CollabError, match="was changed in SWISH"):
+        await bridge.push("family.pl", "c.\n")
+
+
+async def test_new_files_are_created_but_existing_ones_need_a_join():
+    bridge = CollabBridge(Storage(**{"family.pl": ("c0", "a.\n")}), "agent", notify=None)
+
+    assert (await bridge.push("notes.pl", "n.\n")).text == "n.\n"
+    with pytest.raises(CollabError, match="collab_join it first"):
+        await bridge.push("family.pl", "b.\n")
+    with pytest.raises(CollabError, match="no stored file other.pl"):
+        await bridge.fetch("other.pl")
+
+
+async def test_browser_saves_arrive_with_their_diff():
+    storage = Storage(**{"family.pl": ("c1", "a.\nb.\n")})
+    notified = []
+
+    async def notify(uri):
+        notified.append(uri)
+
+    bridge = CollabBridge(storage, "agent", notify)
+    bridge.files["family.pl"] = SharedFile("family.pl", "c0", "a.\n")
+
+    await bridge._handle({"type": "welcome", "wsid": "me"})
+    await bridge._handle({"type": "notify", "action": "updated", "file": "family.pl", "commit": "c1", "user": "ann"})
+    await bridge._handle({"type": "notify", "action": "opened", "file": "family.pl", "wsid": "me"})
+    await bridge._handle({"type": "chat-message", "docid": "gitty:family.pl", "nick_name": "ann", "text": "hi"})
+    await bridge._handle({"type": "notify", "action": "updated", "file": "other.pl"})
+
+    events = bridge.take_events()
+    assert [(event.kind, event.user) for event in events] == [("updated", "ann"), ("chat", "ann")]
+    assert events[0].diff.endswith("+b.") and events[0].commit == "c1"
+    assert bridge.files["family.pl"].text == "a.\nb.\n"
+    assert notified == ["swish://collab/family.pl"]
+    # The echo of the bridge's own save is no news
+    await bridge._handle({"type": "notify", "action": "updated", "file": "family.pl", "commit": "c1"})
+    assert bridge.take_events() == []
@@ -0,0 +1,72 @@
+"""Settings read from the environment and from the config file."""
+
+from pathlib import Path
+
+import pytest
+
+from docker_swish_mcp.config import PrintOptions, QueryLimits, ServerConfig
+
+
+def test_limits_from_environment(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_QUERY_TIMEOUT", "0")
+    monkeypatch.setenv("SWISH_MCP_CPU_LIMIT", "2.5")
+    monkeypatch.setenv("SWISH_MCP_INFERENCE_LIMIT", "lots")
+
+    assert ServerConfig.from_env().limits == QueryLimits(wall_seconds=30.0, cpu_seconds=2.5, inferences=0)
+
+
+def test_config_file_overlays_the_environment(tmp_path, monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_CPU_LIMIT", "2.5")
+    path = tmp_path / "swish-mcp.toml"
+    path.write_text(
+        '[container]\nport = 3060\ndata_dir = "/srv/swish"\n\n'
+        '[limits]\nwall_seconds = 10\n\n'
+        '[sandbox]\nmode = "strict"\n\n[sandbox.clients.ci]\nmode = "off"\n',
+        encoding="utf-8",
+    )
+
+    config = ServerConfig.from_env().with_file(path)
+
+    assert config.limits == QueryLimits(wall_seconds=10, cpu_seconds=2.5)
+    assert (config.container.port, config.container.data_dir) == (3060, Path("/srv/swish"))
+    assert config.sandbox.default.mode == "strict"
+    assert config.sandbox.clients["ci"].mode == "off"
+    assert config.config_path == path
+
+
+@pytest.mark.parametrize("text, message", [
+    ("[server]\nport = 1\n", "Unknown config sections \\['server'\\]"),
+    ("limits = 3\n", "\\[limits\\] in .* must be a table"),
+    ("[limits]\nwall_seconds = 0\n", "must be positive"),
+    ("[limits]\ntimeout = 5\n", "Unknown limits \\['timeout'\\]"),
+    ("[container]\nport = 70000\n", "container.port must be a port number"),
+    ("[limits\n", "Invalid TOML"),
+])
+def test_invalid_config_files_are_refused(tmp_path, text, message):
+    path = tmp_path / "swish-mcp.toml"
+    path.write_text(text, encoding="utf-8")
+
+    with pytest.raises(ValueError, match=message):
+        ServerConfig.from_env().with_file(path)
+
+
+def test_unreadable_config_file_keeps_the_environment(tmp_path, monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_CONFIG", str(tmp_path / "missing.toml"))
+    monkeypatch.setenv("SWISH_MCP_QUERY_TIMEOUT", "12")
+
+    config = ServerConfig.load()
+
+    assert config.limits.wall_seconds == 12
+    assert config.config_path == tmp_path / "missing.toml"
+
+
+def test_print_options_override_and_format():
+    defaults = ServerConfig.from_env().printing.override(max_list=5)
+
+    assert defaults == PrintOptions(max_list=5)
+    assert defaults.override(style="clause", portray=True).to_prolog("json") == "json(print(0, 5, clause, true))"
+    assert PrintOptions().to_prolog("text") == "text"
+    with pytest.raises(ValueError, match="Unknown print style 'fancy'"):
+        defaults.override(style="fancy")
+    with pytest.raises(ValueError, match="must not be negative"):
+        defaults.override(max_depth=-1)
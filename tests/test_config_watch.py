@@ -0,0 +1,53 @@
+"""Reloading the config file and sorting what changed."""
+
+import asyncio
+
+from docker_swish_mcp.config import ServerConfig
+from docker_swish_mcp.config_watch import ConfigWatcher
+
+
+def watcher(path, applied):
+    async def apply(config, changes):
+        applied.append((config, changes))
+
+    return ConfigWatcher(path, ServerConfig.from_env, apply, interval=0.01)
+
+
+async def test_reload_splits_live_and_recreating_changes(tmp_path):
+    path = tmp_path / "swish-mcp.toml"
+    path.write_text("[limits]\nwall_seconds = 5\n\n[container]\nport = 3999\n", encoding="utf-8")
+    applied = []
+
+    changes = await watcher(path, applied).reload()
+
+    assert changes.live == ["limits limits(5.0, 0.0, 0)"]
+    assert changes.recreate == ["port 3050 → 3999"]
+    assert changes.describe() == "limits limits(5.0, 0.0, 0), port 3050 → 3999 (recreating container)"
+    assert applied[0][0].container.port == 3999
+
+
+async def test_unchanged_or_invalid_files_are_not_applied(tmp_path):
+    path = tmp_path / "swish-mcp.toml"
+    path.write_text("[limits]\n", encoding="utf-8")
+    applied = []
+    config_watcher = watcher(path, applied)
+
+    assert not await config_watcher.reload()
+    path.write_text("[limits]\nwall_seconds = -1\n", encoding="utf-8")
+    assert await config_watcher.reload() is None
+    assert applied == []
+
+
+async def test_requested_reload_runs_on_the_next_check(tmp_path):
+    path = tmp_path / "swish-mcp.toml"
+    path.write_text("[limits]\ncpu_seconds = 3\n", encoding="utf-8")
+    applied = []
+    config_watcher = watcher(path, applied)
+
+    config_watcher.request_reload()
+    task = asyncio.ensure_future(config_watcher.watch())
+    await asyncio.sleep(0.1)
+    task.cancel()
+
+    assert not config_watcher.pending
+    assert [config.limits.cpu_seconds for config, _changes in applied] == [3]
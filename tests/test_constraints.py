@@ -0,0 +1,74 @@
+"""Compiling JSON constraint models to CLP(FD) goals."""
+
+import pytest
+
+from docker_swish_mcp.constraints import (
+    ModelError,
+    domain_text,
+    format_solution,
+    labeling_options,
+    parse_model,
+    solve_call,
+)
+
+
+def test_model_compiles_to_one_goal():
+    model = parse_model({
+        "variables": {"X": [0, 9], "Queens": {"length": 4, "domain": [1, 4]}},
+        "constraints": ["X + 1 #= 3.", "all_distinct(Queens)"],
+        "maximize": "X",
+    })
+
+    assert model.goal() == "X in 0..9, length(Queens, 4), Queens ins 1..4, (X + 1 #= 3), (all_distinct(Queens))"
+    assert labeling_options(model, "ff", "down", "step") == "[ff, down, step, max(X)]"
+
+
+@pytest.mark.parametrize("spec, domain", [
+    (5, "5"),
+    ([-2, 3], "-2..3"),
+    ({"values": [3, 1, 3]}, "1 \\/ 3"),
+    ("1..5 \\/ 9", "1..5 \\/ 9"),
+    ("inf..0", "inf..0"),
+])
+def test_domains(spec, domain):
+    assert domain_text(spec, "X") == domain
+
+
+@pytest.mark.parametrize("spec, message", [
+    (True, "Invalid domain"),
+    ([3, 1], "Empty domain"),
+    ({"values": []}, "non-empty list"),
+    ("1..five", "Invalid domain"),
+])
+def test_malformed_domains_are_refused(spec, message):
+    with pytest.raises(ModelError, match=message):
+        domain_text(spec, "X")
+
+
+@pytest.mark.parametrize("raw, message", [
+    ({"variables": {}}, "needs a \"variables\" object"),
+    ({"variables": {"x": 1}}, "Invalid variable name 'x'"),
+    ({"variables": {"X": 1}, "constraints": ["X #= Y"]}, "Undeclared variable Y"),
+    ({"variables": {"X": 1}, "constraints": ["shell(X)"]}, "'shell' is not a clpfd constraint"),
+    ({"variables": {"X": 1}, "constraints": ["X := 1"]}, "':=' is not a clpfd operator"),
+    ({"variables": {"X": 1}, "constraints": ["X #= 'a'"]}, "Unexpected character"),
+    ({"variables": {"X": 1}, "minimize": "X", "maximize": "X"}, "not both"),
+])
+def test_malformed_models_are_refused(raw, message):
+    with pytest.raises(ModelError, match=message):
+        parse_model(raw)
+
+
+def test_unknown_labeling_option_is_refused():
+    with pytest.raises(ModelError, match="Unknown labeling strategy 'random'"):
+        labeling_options(parse_model({"variables": {"X": 1}}), "random", "up", "step")
+
+
+def test_solve_call_and_solution_text():
+    model = parse_model({"variables": {"X": [1, 2]}})
+
+    name, args = solve_call(model, "[leftmost, up, step]", "limits(1, 2)", 5)
+
+    assert name == "mcp_clpfd_solve"
+    assert args == ['"model((X in 1..2), [leftmost, up, step])"', "limits(1, 2)", "5"]
+    assert format_solution({"X": 1, "L": [1, 2]}) == "X = 1, L = [1, 2]"
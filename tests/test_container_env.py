@@ -0,0 +1,69 @@
+"""Container environment variables, secret files and their redaction."""
+
+import logging
+
+import pytest
+
+from docker_swish_mcp.container_env import (
+    ContainerEnvironment,
+    Redactor,
+    parse_env,
+    parse_secrets,
+)
+
+
+def test_env_from_text_tables_and_the_servers_environment():
+    assert parse_env("MODE=prod, API_TOKEN,MISSING", {"API_TOKEN": "s3cret"}) == {"MODE": "prod", "API_TOKEN": "s3cret"}
+    assert parse_env({"PORT": 8080, "API_TOKEN": True}, {"API_TOKEN": "s3cret"}) == {"PORT": "8080", "API_TOKEN": "s3cret"}
+    assert parse_env('{"A": "x=y"}', {}) == {"A": "x=y"}
+
+
+@pytest.mark.parametrize("value, message", [
+    ("1X=2", "invalid variable name '1X'"),
+    ({"A": False}, "env A must be a string, a number or true"),
+    ("{A", "env is not valid JSON"),
+    (3, "env must be a string, a table or a list of strings"),
+])
+def test_bad_env(value, message):
+    with pytest.raises(ValueError, match=message):
+        parse_env(value, {})
+
+
+def test_secrets_are_files(tmp_path):
+    token = tmp_path / "token"
+    token.write_text("tok-123456\n", encoding="utf-8")
+
+    environment = ContainerEnvironment.from_settings({"env": "MODE=prod", "secrets": f"api_token={token}"})
+
+    assert environment.mounts() == {str(token.resolve()): {"bind": "/run/secrets/api_token", "mode": "ro"}}
+    assert environment.describe() == "MODE (values hidden); secrets /run/secrets/api_token"
+    with pytest.raises(ValueError, match="secrets has an invalid name '../x'"):
+        parse_secrets(f"../x={token}")
+    with pytest.raises(ValueError, match="is not a file"):
+        ContainerEnvironment.from_settings({"secrets": {"missing": str(tmp_path / "missing")}})
+
+
+def test_label_holds_names_and_a_digest_of_the_values(tmp_path):
+    token = tmp_path / "token"
+    token.write_text("tok-123456", encoding="utf-8")
+    environment = ContainerEnvironment({"MODE": "prod"}, {"api_token": token})
+
+    label = str(environment)
+    token.write_text("tok-654321", encoding="utf-8")
+
+    assert label.startswith("MODE,secret:api_token sha256:") and "prod" not in label
+    assert str(environment) != label
+    assert str(ContainerEnvironment()) == "" and ContainerEnvironment().describe() == "none"
+
+
+def test_redactor_hides_values_in_text_and_log_records(tmp_path):
+    token = tmp_path / "token"
+    token.write_text("tok-123456", encoding="utf-8")
+    redactor = Redactor()
+    redactor.update(ContainerEnvironment({"MODE": "on", "URL": "https://x", "URL_PATH": "https://x/api"}, {"t": token}))
+    record = logging.LogRecord("swish", logging.INFO, "", 0, "calling %s with %s", ("https://x/api", "tok-123456"), None)
+
+    # Values this short are left alone; a value containing another is replaced whole
+    assert redactor.redact("MODE on at https://x/api") == "MODE on at ***"
+    assert redactor.filter(record) and record.getMessage() == "calling *** with ***"
+    assert Redactor().redact("tok-123456") == "tok-123456"
@@ -0,0 +1,96 @@
+"""Commands run through a scripted Docker exec API."""
+
+import socket
+import threading
+from types import SimpleNamespace
+
+import pytest
+from docker.errors import NotFound
+from docker.utils.socket import STDERR, STDOUT
+
+from docker_swish_mcp.container_exec import (
+    ContainerExecError,
+    ExecProcess,
+    exec_in_container,
+    open_exec,
+)
+
+
+class FakeAPI:
+    """The low-level exec endpoints, recording the commands they start."""
+
+    def __init__(self, exit_code=0):
+        self.exit_code = exit_code
+        self.commands = []
+        self.sockets = []
+
+    def exec_create(self, container_id, cmd, **kwargs):
+        self.commands.append(cmd)
+        return {"Id": f"exec{len(self.commands)}"}
+
+    def exec_start(self, exec_id, **kwargs):
+        if not kwargs.get("socket"):
+            return b""
+        ours, theirs = socket.socketpair()
+        self.sockets += [ours, theirs]
+        return ours
+
+    def exec_inspect(self, exec_id):
+        return {"ExitCode": self.exit_code}
+
+
+def fake_client(api, missing=False):
+    def get(name):
+        if missing:
+            raise NotFound(f"No such container: {name}")
+        return SimpleNamespace(id=f"id-{name}")
+    return SimpleNamespace(api=api, containers=SimpleNamespace(get=get))
+
+
+def scripted_frames(monkeypatch, frames):
+    monkeypatch.setattr(ExecProcess, "_frames", lambda self: iter(frames))
+
+
+async def test_output_is_demultiplexed_and_the_pid_line_stripped(monkeypatch):
+    api = FakeAPI(exit_code=3)
+    scripted_frames(monkeypatch, [
+        (STDOUT, b"@PID 42\nhel"), (STDERR, b"warn"), (STDOUT, b"lo\n"),
+    ])
+
+    exit_code, stdout, stderr = await exec_in_container(fake_client(api), "swish", ["swipl", "--version"])
+
+    assert (exit_code, stdout, stderr) == (3, "hello\n", "warn")
+    assert api.commands[0][-2:] == ["swipl", "--version"]
+
+
+async def test_output_without_a_pid_line_is_kept(monkeypatch):
+    scripted_frames(monkeypatch, [(STDOUT, b"no marker\nrest")])
+
+    process = await open_exec(fake_client(FakeAPI()), "swish", ["cat"], stdin=False)
+    stdout, _stderr = await process.communicate()
+
+    assert process.pid is None
+    assert stdout == b"no marker\nrest"
+
+
+async def test_kill_signals_the_pid_inside_the_container(monkeypatch):
+    api = FakeAPI()
+    killed = threading.Event()
+
+    def frames(self):
+        yield STDOUT, b"@PID 42\n"
+        killed.wait(5)
+
+    monkeypatch.setattr(ExecProcess, "_frames", frames)
+    process = await open_exec(fake_client(api), "swish", ["sleep", "60"], stdin=False)
+    process.kill()
+    killed.set()
+    await process.wait()
+
+    assert process.pid == 42
+    assert api.commands[-1] == ["kill", "-KILL", "42"]
+
+
+async def test_missing_container_is_reported():
+    with pytest.raises(ContainerExecError, match="Container swish not found"):
+        await exec_in_container(fake_client(FakeAPI(), missing=True), "swish", ["true"])
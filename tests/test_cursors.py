@@ -0,0 +1,56 @@
+"""The cursor table, and the CURSOR events that page through an engine."""
+
+import pytest
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.cursors import CursorError, CursorTable
+
+
+def test_opening_past_the_limit_evicts_least_recently_used():
+    table = CursorTable(max_per_client=2)
+    first, _ = table.open("alice", "p(X)", "text", 10, 1)
+    second, _ = table.open("alice", "q(X)", "text", 10, 1)
+    table.open("bob", "r(X)", "text", 10, 1)
+    first.last_used, second.last_used = 2.0, 1.0
+
+    third, evicted = table.open("alice", "s(X)", "text", 10, 1)
+
+    assert evicted == [second.cursor_id]
+    assert table.get(first.cursor_id, "alice", 1) is first
+    assert third.cursor_id in table.cursors and len(table.cursors) == 3
+
+
+def test_cursors_belong_to_their_client_and_session():
+    table = CursorTable()
+    cursor, _ = table.open("alice", "p(X)", "json", 5, 1)
+
+    with pytest.raises(CursorError, match="Unknown cursor"):
+        table.get(cursor.cursor_id, "bob", 1)
+    with pytest.raises(CursorError, match="session restarted"):
+        table.get(cursor.cursor_id, "alice", 2)
+    assert cursor.cursor_id not in table.cursors
+
+
+def test_idle_cursors_expire():
+    table = CursorTable(ttl=60)
+    idle, _ = table.open("alice", "p(X)", "text", 10, 1)
+    busy, _ = table.open("alice", "q(X)", "text", 10, 1)
+    idle.last_used -= 120
+
+    assert table.expire() == [idle.cursor_id]
+    assert list(table.cursors) == [busy.cursor_id]
+
+
+async def test_open_cursor_reports_whether_more_remain(fake_session):
+    session = fake_session(lambda goal: [
+        "@MCP {id} SOLUTION X = 1",
+        "@MCP {id} SOLUTION X = 2",
+        "@MCP {id} CURSOR more",
+        "@MCP {id} END",
+    ])
+
+    events = [e async for e in session.open_cursor("c1", "between(1, 9, X)", QueryLimits(), "text", 2)]
+
+    assert [e["type"] for e in events] == ["solution", "solution", "cursor"]
+    assert events[-1]["state"] == "more"
+    assert "mcp_cursor_open(" in session.process.goals[0] and ", c1, " in session.process.goals[0]
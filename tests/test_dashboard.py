@@ -0,0 +1,112 @@
+"""Who may open the dashboard, and where its actions may be posted from."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.auth import ApiKeyStore
+from docker_swish_mcp.dashboard import COOKIE, DashboardMiddleware
+
+KEYS = json.dumps({"keys": [
+    {"id": "ops", "key": "admin-key", "scopes": "admin"},
+    {"id": "ci", "key": "write-key", "scopes": "write"},
+]})
+HOST = "mcp.example.org"
+
+
+def dashboard(keys=KEYS):
+    ran = []
+
+    async def state():
+        return {"health": {}}
+
+    async def restart():
+        ran.append("restart")
+        return "🔄 Session restarted"
+
+    async def app(scope, receive, send):
+        ran.append("app")
+
+    return DashboardMiddleware(app, ApiKeyStore(keys), state, {"restart": restart}), ran
+
+
+async def request(app, method, path, *headers, body=b""):
+    sent = []
+
+    async def receive():
+        return {"type": "http.request", "body": body}
+
+    async def send(message):
+        sent.append(message)
+
+    scope = {
+        "type": "http", "method": method, "path": path, "scheme": "https",
+        "headers": [(b"host", HOST.encode()), *((name.encode(), value.encode()) for name, value in headers)],
+    }
+    await app(scope, receive, send)
+    if not sent:
+        return None, {}, b""
+    status = sent[0]["status"]
+    response_headers = dict(sent[0]["headers"])
+    content = b"".join(message.get("body", b"") for message in sent[1:])
+    return status, response_headers, content
+
+
+async def test_other_paths_pass_through():
+    app, ran = dashboard()
+
+    assert await request(app, "GET", "/mcp") == (None, {}, b"")
+    assert ran == ["app"]
+
+
+@pytest.mark.parametrize("headers, status", [
+    ((("authorization", "Bearer admin-key"), ("origin", f"https://{HOST}")), 200),
+    ((("authorization", "Bearer admin-key"),), 200),
+    ((("authorization", "Bearer admin-key"), ("origin", "https://evil.example")), 403),
+    ((("cookie", f"{COOKIE}=admin-key"), ("origin", "https://evil.example")), 403),
+    ((("cookie", f"{COOKIE}=admin-key"),), 403),
+    ((("cookie", f"{COOKIE}=admin-key"), ("origin", f"https://{HOST}")), 200),
+    ((("authorization", "Bearer write-key"), ("origin", f"https://{HOST}")), 401),
+    ((("origin", f"https://{HOST}"),), 401),
+])
+async def test_actions_need_an_admin_key_from_the_dashboard_origin(headers, status):
+    app, ran = dashboard()
+
+    answer, _, body = await request(app, "POST", "/dashboard/actions/restart", *headers)
+
+    assert answer == status
+    assert ran == (["restart"] if status == 200 else [])
+    if status == 200:
+        assert json.loads(body) == {"action": "restart", "result": "🔄 Session restarted"}
+
+
+async def test_state_needs_the_admin_scope():
+    app, _ = dashboard()
+
+    assert (await request(app, "GET", "/dashboard/state", ("authorization", "Bearer write-key")))[0] == 401
+    status, _, body = await request(app, "GET", "/dashboard/state", ("authorization", "Bearer admin-key"))
+    assert (status, json.loads(body)) == (200, {"health": {}})
+    # Without a key the page is the login form
+    status, _, page = await request(app, "GET", "/dashboard")
+    assert status == 200 and b'name="key"' in page
+
+
+async def test_login_sets_a_strict_cookie():
+    app, _ = dashboard()
+
+    status, headers, _ = await request(app, "POST", "/dashboard/login", body=b"key=admin-key")
+    refused, _, _ = await request(app, "POST", "/dashboard/login", body=b"key=write-key")
+
+    assert status == 303 and headers[b"location"] == b"/dashboard/"
+    assert headers[b"set-cookie"] == (
+        f"{COOKIE}=admin-key; Path=/dashboard; Max-Age=43200; HttpOnly; SameSite=Strict; Secure".encode()
+    )
+    assert refused == 401
+
+
+async def test_unknown_actions():
+    app, _ = dashboard()
+
+    status, _, body = await request(app, "POST", "/dashboard/actions/reboot", ("authorization", "Bearer admin-key"))
+
+    assert status == 404 and "Unknown action 'reboot'. Use: restart" in json.loads(body)["message"]
@@ -0,0 +1,73 @@
+"""Writing solutions as tabular data."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.data_export import (
+    build_export,
+    export_filename,
+    render,
+    render_csv,
+    render_jsonl,
+    term_text,
+)
+
+
+def atom(text):
+    return {"type": "atom", "value": text}
+
+
+def integer(value):
+    return {"type": "integer", "value": value}
+
+
+def floating(value):
+    return {"type": "float", "value": value}
+
+
+SOLUTIONS = [
+    {"Name": atom("ann"), "Age": floating(31.5), "Tags": {"type": "list", "items": [atom("a"), integer(1)]}},
+    {"Name": atom("bob"), "Age": integer(7), "Tags": {"type": "list", "items": []}},
+    {"Name": integer(3), "Age": atom("old"), "Tags": {"type": "var"}},
+]
+
+
+def test_term_text():
+    assert term_text(atom("Ann Lee")) == "'Ann Lee'"
+    assert term_text({"type": "string", "value": 'say "hi"'}) == '"say \\"hi\\""'
+    assert term_text({"type": "compound", "functor": "-", "args": [atom("a"), integer(1)]}) == "'-'(a,1)"
+    assert term_text({"type": "var"}) == "_"
+
+
+def test_columns_take_the_first_solutions_types():
+    export = build_export(SOLUTIONS)
+
+    assert export.schema() == "Name:string, Age:float, Tags:list"
+    # Integers widen to floats; the other mismatches are counted
+    assert [row[:2] for row in export.rows] == [["ann", 31.5], ["bob", 7.0], ["3", None]]
+    assert export.mismatched == 3
+
+
+def test_csv_and_json_lines():
+    export = build_export(SOLUTIONS[:2])
+
+    assert render_csv(export) == "Name,Age,Tags\nann,31.5,\"[a,1]\"\nbob,7.0,[]\n"
+    assert [json.loads(line) for line in render_jsonl(export).splitlines()] == [
+        {"Name": "ann", "Age": 31.5, "Tags": ["a", 1]},
+        {"Name": "bob", "Age": 7.0, "Tags": []},
+    ]
+
+
+def test_infinite_floats_have_no_json_value():
+    export = build_export([{"X": floating("inf")}, {"X": floating("1.5NaN")}])
+
+    assert render(export, "jsonl") == b'{"X": null}\n{"X": null}\n'
+    assert render(export, "csv") == b"X\ninf\nnan\n"
+
+
+def test_export_filename():
+    assert export_filename("people", "csv") == "people.csv"
+    assert export_filename("people.txt", "jsonl") == "people.txt"
+    with pytest.raises(ValueError, match="does not match data_format csv"):
+        export_filename("people.jsonl", "csv")
@@ -0,0 +1,93 @@
+"""Turning tabular data into facts."""
+
+import pytest
+
+from docker_swish_mcp.data_import import (
+    CellError,
+    Column,
+    detect_format,
+    format_plan,
+    import_call,
+    parse_columns,
+    plan_import,
+    prolog_value,
+    read_rows,
+)
+
+
+def test_column_specs():
+    assert parse_columns(["name", "age:Integer", "url:http://x"]) == [
+        Column("name"), Column("age", "integer"), Column("url:http://x"),
+    ]
+    with pytest.raises(ValueError, match="must not be empty"):
+        parse_columns([" "])
+
+
+@pytest.mark.parametrize("text, source, data_format", [
+    ("a,b\n1,2\n", "", "csv"),
+    ("a\tb\n1\t2\n", "", "tsv"),
+    ('[{"a": 1}]', "", "json"),
+    ('{"a": 1}\n{"a": 2}\n', "", "jsonl"),
+    ("a,b\n", "https://example.org/people.tsv?raw=1", "tsv"),
+])
+def test_detect_format(text, source, data_format):
+    assert detect_format(text, source) == data_format
+
+
+def test_rows_of_each_format():
+    assert read_rows("name,age\nann,31\n\nbob,7\n", "csv") == (["name", "age"], [["ann", "31"], ["bob", "7"]])
+    assert read_rows("ann\t31\textra\n", "tsv", header=False) == (["1", "2", "3"], [["ann", "31", "extra"]])
+    # Keys first seen in a later object are filled in for the earlier ones
+    assert read_rows('{"name": "ann"}\n{"name": "bob", "age": 7}\n', "jsonl") == (
+        ["name", "age"], [["ann", None], ["bob", 7]],
+    )
+    with pytest.raises(ValueError, match="must be objects or arrays"):
+        read_rows("[1, 2]", "json")
+
+
+@pytest.mark.parametrize("value, kind, text", [
+    ("42", "auto", "42"),
+    ("007", "auto", "'007'"),
+    ("2.5e3", "auto", "2500.0"),
+    ("Ann Lee", "auto", "'Ann Lee'"),
+    ("ann", "atom", "ann"),
+    ('say "hi"', "string", '"say \\"hi\\""'),
+    ("Yes", "boolean", "true"),
+    (3.0, "integer", "3"),
+    ("4", "float", "4.0"),
+    (None, "auto", "''"),
+    (["a", 1], "auto", "[a, 1]"),
+])
+def test_cells_become_prolog_terms(value, kind, text):
+    assert prolog_value(value, kind) == text
+
+
+@pytest.mark.parametrize("value, kind", [("x", "integer"), ("", "number"), ("maybe", "boolean"), (float("nan"), "float")])
+def test_cells_that_do_not_fit_their_type(value, kind):
+    with pytest.raises(CellError):
+        prolog_value(value, kind)
+
+
+def test_rows_with_bad_cells_are_skipped():
+    plan = plan_import("person", ["name", "age"], [["ann", "31"], ["bob", "old"]], parse_columns(["name", "age:integer"]))
+
+    assert plan.facts == ["person(ann, 31)"]
+    assert plan.skipped == [(2, "'old' is not an integer")]
+    assert import_call("user", plan, replace=True) == (
+        "mcp_import_facts", ["'user'", "person/2", '"[person(ann, 31)]"', "true"],
+    )
+    assert format_plan(plan, 2).splitlines() == [
+        "🔍 Dry run: 1 of 2 row(s) would become person/2 facts",
+        "📐 person(name:auto, age:integer)",
+        "📋 Preview:",
+        "  person(ann, 31).",
+        "⚠️ 1 row(s) skipped:",
+        "  row 2: 'old' is not an integer",
+    ]
+
+
+def test_plan_checks_predicate_and_columns():
+    with pytest.raises(ValueError, match="Invalid predicate name 'Person'"):
+        plan_import("Person", ["name"], [], [])
+    with pytest.raises(ValueError, match="Unknown column\\(s\\) age. The data has: name"):
+        plan_import("person", ["name"], [], [Column("age")])
@@ -0,0 +1,99 @@
+"""Disk usage of the data directory and its quota and reserve."""
+
+import os
+
+import pytest
+
+from docker_swish_mcp.disk_usage import (
+    DiskMonitor,
+    DiskQuotaExceeded,
+    DiskSettings,
+    measure_dir,
+    writes_files,
+)
+
+
+class Clock:
+    def __init__(self):
+        self.now = 0.0
+
+    def __call__(self):
+        return self.now
+
+
+@pytest.fixture
+def data_dir(tmp_path):
+    data_dir = tmp_path / "data"
+    (data_dir / "exports").mkdir(parents=True)
+    (data_dir / "family.pl").write_bytes(b"x" * 100)
+    (data_dir / "exports" / "people.csv").write_bytes(b"x" * 2000)
+    os.symlink(tmp_path, data_dir / "outside")
+    return data_dir
+
+
+@pytest.mark.parametrize("goal, writes", [
+    ("tell(out), write(x), told", True),
+    ("open('log.txt', append, S)", True),
+    ("csv_write_file('a.csv', Rows)", True),
+    ("open('in.txt', read, S)", False),
+    ("append([1], [2], L)", False),
+])
+def test_writes_files(goal, writes):
+    assert writes_files(goal) == writes
+
+
+def test_settings_from_env(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_DISK_QUOTA", "5g")
+    monkeypatch.setenv("SWISH_MCP_DISK_RESERVE", "plenty")
+
+    settings = DiskSettings.from_env()
+
+    assert settings == DiskSettings(quota=5 * 1024 ** 3)
+    assert settings.describe() == "quota 5.0GiB"
+    assert DiskSettings().describe() == "no limits"
+
+
+def test_measure_dir_does_not_follow_links(data_dir):
+    usage = measure_dir(data_dir)
+
+    assert (usage.bytes, usage.files) == (2100, 2)
+    assert usage.largest == [(2000, "exports/people.csv"), (100, "family.pl")]
+
+
+def test_measurements_are_reused_and_charged(data_dir):
+    clock = Clock()
+    monitor = DiskMonitor(DiskSettings(), clock)
+    areas = {"data": data_dir}
+
+    first = monitor.usage(areas)
+    monitor.charge(data_dir, 500)
+    (data_dir / "more.pl").write_bytes(b"x" * 1000)
+
+    assert monitor.usage(areas) is first and first.written == 500
+    clock.now = 11
+    assert monitor.usage(areas).written == 0 and monitor.usage(areas).areas["data"].files == 3
+
+
+def test_quota_and_reserve_refuse_writes(data_dir):
+    used = measure_dir(data_dir).bytes
+    monitor = DiskMonitor(DiskSettings(quota=used + 100))
+
+    monitor.check({"data": data_dir}, "export_results", adding=100)
+    with pytest.raises(DiskQuotaExceeded, match="export_results refused: .* disk quota") as refused:
+        monitor.check({"data": data_dir}, "export_results", adding=101)
+    assert refused.value.details == {"quota": used + 100, "used": used}
+    with pytest.raises(DiskQuotaExceeded, match="kept in reserve") as reserved:
+        DiskMonitor(DiskSettings(reserve=2 ** 60)).check({"data": data_dir}, "kb_snapshot")
+    assert set(reserved.value.details) == {"reserve", "free"}
+
+
+def test_describe(data_dir):
+    monitor = DiskMonitor(DiskSettings(quota=4096))
+    usage = monitor.usage({"data": data_dir, "snapshots": data_dir.parent / "swish-snapshots"})
+
+    lines = usage.describe(monitor.settings).splitlines()
+
+    assert lines[1].startswith("• data: ") and lines[2].startswith("• snapshots: 0B in 0 file(s)")
+    assert lines[4].startswith("📏 ") and lines[4].endswith(" of the 4.0KiB quota (51%)")
+    assert lines[lines.index("📦 Largest files:") + 1] == "  2.0KiB  data/exports/people.csv"
+    assert usage.to_json(monitor.settings)["quota"] == 4096
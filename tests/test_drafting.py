@@ -0,0 +1,119 @@
+"""Drafting clauses through sampling: the prompt, the drafts and their checks."""
+
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.drafting import (
+    SYSTEM_PROMPT,
+    draft_check_call,
+    draft_prompt,
+    first_line,
+    format_drafts,
+    format_schema,
+    has_directive,
+    parse_candidates,
+    parse_draft_check,
+    sample,
+)
+from docker_swish_mcp.quarantine import ConsultMessage
+
+ROWS = [
+    {"predicate": "parent/2", "dynamic": True, "clauses": 3, "examples": ["parent(ann, bob)."]},
+    {"predicate": "age/2", "dynamic": False, "clauses": 1, "examples": ["age(ann, 31)."]},
+]
+
+
+class Session:
+    def __init__(self, sampling=True, content=None):
+        self.sampling = sampling
+        self.content = content or SimpleNamespace(type="text", text="```prolog\ngrand(X, Z).\n```")
+        self.requests = []
+
+    def check_client_capability(self, capabilities):
+        return self.sampling
+
+    async def create_message(self, **request):
+        self.requests.append(request)
+        return SimpleNamespace(content=self.content)
+
+
+def test_format_schema():
+    assert format_schema(ROWS).splitlines() == [
+        "% age/2 (1 clause(s))",
+        "age(ann, 31).",
+        "% parent/2 (dynamic, 3 clause(s))",
+        "parent(ann, bob).",
+    ]
+    assert format_schema(ROWS, limit=1).splitlines()[-1] == "% ... and 1 more predicates"
+    assert format_schema([]) == "% The knowledge base is empty."
+    assert draft_prompt("  a grandparent  ", "% age/2", 2).endswith("Write 2 alternative draft(s) of Prolog clauses for:\n\na grandparent")
+
+
+def test_candidates_are_the_fenced_blocks():
+    answer = "Two options:\n```prolog\ngrand(X, Z) :- parent(X, Y), parent(Y, Z).\n```\nor\n```PL\n:- dynamic g/2.\n```\n```\n\n```"
+
+    assert parse_candidates(answer) == ["grand(X, Z) :- parent(X, Y), parent(Y, Z).", ":- dynamic g/2."]
+    assert parse_candidates("grand(a, c).\n") == ["grand(a, c)."]
+    assert has_directive("g(1).\n  :- initialization(main).")
+    assert not has_directive("g(X) :- h(X).")
+
+
+def test_draft_check_rows_are_grouped_by_candidate():
+    name, (scratch, texts) = draft_check_call(["g(1).", 'h("x").'])
+    rows = [
+        {"candidate": 1, "errors": 0},
+        {"severity": "warning", "message": "Singleton variables: [Y]", "line": 1},
+        {"severity": "error", "message": "Syntax error"},
+        {"candidate": 2, "errors": 1},
+    ]
+
+    assert name == "mcp_draft_check" and scratch.startswith("'mcp_quarantine")
+    assert texts == '["g(1).", "h(\\"x\\")."]'
+    assert parse_draft_check(rows) == [
+        (0, []),
+        (1, [ConsultMessage("warning", "Singleton variables: [Y]", 1), ConsultMessage("error", "Syntax error")]),
+    ]
+
+
+def test_format_drafts():
+    text = format_drafts([("g(1).", [ConsultMessage("warning", "Discontiguous", 2)])], [(":- halt.", "has a directive")])
+
+    assert text.splitlines() == [
+        "📝 1 draft(s) load cleanly (not consulted):",
+        "",
+        "Draft 1:",
+        "```prolog",
+        "g(1).",
+        "```",
+        "  ⚠️ line 2: Discontiguous",
+        "",
+        "🗑️ Dropped 1 draft(s):",
+        "  • :- halt.: has a directive",
+        "",
+        "💡 Keep a draft with create_prolog_file or clause_insert, then load_knowledge_base",
+    ]
+    assert format_drafts([], [("g(", "syntax error")]).splitlines() == [
+        "❌ None of the drafts loads cleanly:",
+        "  • g(: syntax error",
+    ]
+    assert first_line("x" * 80) == "x" * 57 + "..."
+
+
+async def test_sample_asks_the_clients_model():
+    session = Session()
+
+    assert await sample(session, "Write it") == "```prolog\ngrand(X, Z).\n```"
+    (request,) = session.requests
+    assert request["system_prompt"] == SYSTEM_PROMPT
+    assert request["messages"][0].content.text == "Write it"
+
+
+@pytest.mark.parametrize("session, message", [
+    (None, "does not support MCP sampling"),
+    (Session(sampling=False), "does not support MCP sampling"),
+    (Session(content=SimpleNamespace(type="image")), "did not answer with text"),
+])
+async def test_sample_needs_a_text_answer(session, message):
+    with pytest.raises(RuntimeError, match=message):
+        await sample(session, "Write it")
@@ -0,0 +1,17 @@
+"""Engine job states as mcp_engine_poll/3 reports them."""
+
+from docker_swish_mcp.engines import EngineJob
+
+
+def test_unknown_job_is_done():
+    state = EngineJob.from_row({"job": "j1", "status": "unknown"})
+
+    assert state.done
+    assert "No job j1" in state.describe("mcp_engine_abc")
+
+
+def test_running_job_is_not_done():
+    state = EngineJob.from_row({"job": "j1", "goal": "sleep(1)", "status": "running", "seconds": 0.5})
+
+    assert not state.done
+    assert "running" in state.describe("mcp_engine_abc")
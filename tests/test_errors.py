@@ -0,0 +1,79 @@
+"""Typed errors classified from Prolog error terms, messages and exceptions."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp.errors import (
+    ToolError,
+    error_result,
+    from_exception,
+    from_message,
+    from_prolog,
+    split_arguments,
+    unquote,
+)
+from docker_swish_mcp.sandbox import SandboxViolation
+from docker_swish_mcp.swish_http import SwishRequestFailed
+
+
+def test_arguments_split_at_the_top_level_only():
+    assert split_arguments("a, f(b, c), [d, e], 'x, y', \"p(, q\"") == ["a", "f(b, c)", "[d, e]", "'x, y'", '"p(, q"']
+    assert unquote("'it\\'s\\n'") == "it's\n"
+
+
+@pytest.mark.parametrize("error, kind, details", [
+    ("error(existence_error(procedure, user:foo/1), foo/1)", "existence_error", {"predicate": "foo/1"}),
+    ("error(existence_error(source_sink, 'kb.pl'), _)", "existence_error", {"type": "source_sink", "culprit": "kb.pl"}),
+    ("error(type_error(integer, abc), context(succ/2, _))", "type_error", {"expected": "integer", "culprit": "abc"}),
+    ("error(permission_error(modify, static_procedure, append/3), _)", "permission_error",
+     {"action": "modify", "type": "static_procedure", "culprit": "append/3"}),
+    ("error(evaluation_error(zero_divisor), context(system:(/)/2, _))", "evaluation_error", {"culprit": "zero_divisor"}),
+    ("error(instantiation_error, _)", "instantiation_error", {}),
+    ("time_limit_exceeded", "timeout", {"limit": "wall"}),
+    ("inference_limit_exceeded", "resource_limit", {"limit": "inferences"}),
+    ("error(my_error, _)", "prolog_error", {}),
+])
+def test_prolog_error_terms(error, kind, details):
+    assert (from_prolog(error).kind, from_prolog(error).details) == (kind, details)
+
+
+def test_syntax_errors_are_positioned_within_the_query():
+    query = "X = a,\n  b c"
+    # The session read the query wrapped by the sandbox; repr quotes as ~q does
+    wrapped = f"safe_goal(({query}))"
+    error = f"error(syntax_error(operator_expected), string({wrapped!r}, {wrapped.index('c')}))"
+
+    assert from_prolog(error, query).details == {"reason": "operator_expected", "line": 2, "column": 5}
+    assert from_prolog("error(syntax_error(eof), file('/data/kb.pl', 3, 7, 40))").details == {
+        "reason": "eof", "line": 3, "column": 8, "file": "/data/kb.pl",
+    }
+
+
+@pytest.mark.parametrize("message, kind, details", [
+    ("Unknown procedure: user:foo/1", "existence_error", {"predicate": "foo/1"}),
+    ("Type error: `integer' expected, found `abc' (an atom)", "type_error", {"expected": "integer", "culprit": "abc"}),
+    ("Arithmetic: evaluation error: zero_divisor", "evaluation_error", {"culprit": "zero_divisor"}),
+    ("Time limit exceeded", "timeout", {"limit": "wall"}),
+    ("Something else", "prolog_error", {}),
+])
+def test_pengine_messages(message, kind, details):
+    assert (from_message(message).kind, from_message(message).details) == (kind, details)
+
+
+def test_exceptions():
+    assert from_exception(SandboxViolation(["assertz/1 (database)"])).to_json() == {
+        "kind": "sandbox_violation", "message": str(SandboxViolation(["assertz/1 (database)"])),
+        "violations": ["assertz/1 (database)"],
+    }
+    assert from_exception(asyncio.TimeoutError()).message == "The operation timed out"
+    assert from_exception(SwishRequestFailed(502, "bad gateway")).details == {"status": 502}
+    assert from_exception(ValueError("bad name")).kind == "invalid_argument"
+    assert from_exception(RuntimeError("odd"), fallback="prolog_error").kind == "prolog_error"
+
+
+def test_results_end_with_the_tag():
+    assert ToolError("timeout", "Too slow", {"limit": "wall"}).render("Query") == (
+        '⏱️ Query: Too slow\n🏷️ {"kind": "timeout", "message": "Too slow", "limit": "wall"}'
+    )
+    assert error_result(KeyError("x")).startswith("❌ 'x'\n🏷️ ")
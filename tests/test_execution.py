@@ -0,0 +1,82 @@
+"""Queries run over the pengine API or with docker exec, in the shape of a pengine answer."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.execution import (
+    ExecutionStrategy,
+    FallbackStrategy,
+    exec_goal,
+    parse_exec_answer,
+)
+from docker_swish_mcp.swish_http import SwishUnavailable
+
+
+def solution(**bindings):
+    return f"@MCP exec SOLUTION {json.dumps(bindings)}\n"
+
+
+def atom(text):
+    return {"type": "atom", "value": text}
+
+
+def test_exec_goal_asks_for_one_solution_more():
+    limits = QueryLimits(wall_seconds=5)
+
+    assert exec_goal("member(X, [a, b]).", 2, limits) == (
+        f'mcp_run(exec, "limit(3, (member(X, [a, b])))", {limits.to_prolog()}, json)'
+    )
+
+
+def test_solutions_become_text_bindings():
+    stdout = "Welcome\n" + solution(X=atom("a")) + solution(X=atom("New York")) + "@MCP exec END\n"
+
+    assert parse_exec_answer(stdout, "", 0, 1) == {"event": "success", "data": [{"X": "a"}], "more": True}
+    assert parse_exec_answer(stdout, "", 0, 5)["data"] == [{"X": "a"}, {"X": "'New York'"}]
+
+
+@pytest.mark.parametrize("stdout, stderr, answer", [
+    ("@MCP exec END\n", "", {"event": "failure"}),
+    ("@MCP exec ERROR Unknown procedure: foo/0\n", "", {"event": "error", "data": "Unknown procedure: foo/0"}),
+    ("", "Killed\n", {"event": "error", "data": "Killed"}),
+    ("", "", {"event": "error", "data": "swipl exited with code 137 before answering"}),
+])
+def test_other_answers(stdout, stderr, answer):
+    assert parse_exec_answer(stdout, stderr, 137, 10) == answer
+
+
+class Scripted(ExecutionStrategy):
+    def __init__(self, name, up, answer=None):
+        self.name = name
+        self.up = up
+        self.answer = answer
+        self.container_name = "swish"
+
+    async def probe(self, timeout=3):
+        return self.up
+
+    async def run_once(self, query, src_text="", max_solutions=100, timeout=30):
+        if isinstance(self.answer, Exception):
+            raise self.answer
+        return self.answer
+
+
+async def test_auto_falls_back_to_exec():
+    exec_ = Scripted("exec", True, {"event": "failure"})
+    strategy = FallbackStrategy("auto", Scripted("http", False, SwishUnavailable("refused")), exec_)
+
+    assert await strategy.probe()
+    assert not await strategy.probe(fallback=False)
+    assert await strategy.run_once("true") == {"event": "failure"}
+    assert strategy.get_status() == {"mode": "auto", "active": "exec", "fallbacks": 1}
+
+
+async def test_http_mode_does_not_fall_back():
+    strategy = FallbackStrategy("http", Scripted("http", False, SwishUnavailable("refused")), Scripted("exec", True))
+
+    assert not await strategy.probe()
+    with pytest.raises(SwishUnavailable):
+        await strategy.run_once("true")
+    assert FallbackStrategy("auto", None, Scripted("exec", True)).mode == "exec"
@@ -0,0 +1,73 @@
+"""Fact feeds: the changes FACT lines report, numbered per feed."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.fact_feeds import (
+    MAX_FEED_EVENTS,
+    FactFeeds,
+    feed_document,
+    feed_indicator,
+    format_feed_events,
+)
+
+
+def fact(feed, action, text):
+    return json.dumps({"feed": feed.feed_id, "action": action, "fact": text, "args": []})
+
+
+def test_feed_indicator():
+    assert feed_indicator(" alert/02") == "alert/2"
+    with pytest.raises(ValueError, match="use Name/Arity"):
+        feed_indicator("alert")
+
+
+def test_changes_are_numbered_per_feed():
+    feeds = FactFeeds()
+    alerts = feeds.create("alert/2", "user", "alert(high, _).")
+    other = feeds.create("seen/1", "user")
+
+    feeds.record(fact(alerts, "asserted", "alert(high, disk)"))
+    feeds.record(fact(other, "asserted", "seen(a)"))
+    feeds.record(fact(alerts, "retracted", "alert(high, disk)"))
+
+    assert alerts.pattern == "alert(high, _)"
+    assert [(event.seq, event.action) for event in alerts.since(0)] == [(1, "asserted"), (2, "retracted")]
+    assert other.last_seq == 1
+    assert alerts.watch_call() == ("mcp_feed_watch", [f"'{alerts.feed_id}'", "'user'", '"alert/2"', '"alert(high, _)"'])
+    # Lines of feeds removed meanwhile are dropped
+    feeds.remove(other.feed_id)
+    assert feeds.record(fact(other, "asserted", "seen(b)")) is None
+    assert feeds.record("not json") is None
+    with pytest.raises(ValueError, match=f"Unknown fact feed '{other.feed_id}'"):
+        feeds.get(other.feed_id)
+
+
+def test_events_report_and_document():
+    feeds = FactFeeds()
+    feed = feeds.create("alert/2", "user")
+    feeds.record(fact(feed, "asserted", "alert(high, disk)"))
+    feeds.record(fact(feed, "retracted", "alert(low, cpu)"))
+
+    report = format_feed_events(feed, feed.since(0), 0).splitlines()
+
+    assert report[1:] == [
+        "  1. + alert(high, disk)",
+        "  2. - alert(low, cpu)",
+        f'💡 Next: fact_feed_events(feed_id="{feed.feed_id}", since=2)',
+    ]
+    assert format_feed_events(feed, [], 2).splitlines()[1] == "No changes after 2"
+    document = json.loads(feed_document(feed, feed.since(1)))
+    assert (document["predicate"], document["last_seq"], len(document["events"])) == ("alert/2", 2, 1)
+
+
+def test_changes_no_longer_kept_are_reported():
+    feeds = FactFeeds()
+    feed = feeds.create("alert/2", "user")
+    for n in range(MAX_FEED_EVENTS + 5):
+        feeds.record(fact(feed, "asserted", f"alert(high, {n})"))
+
+    report = format_feed_events(feed, feed.since(0), 0)
+
+    assert f"⚠️ Changes 1-5 are no longer kept (the feed keeps {MAX_FEED_EVENTS})" in report
@@ -0,0 +1,132 @@
+"""Designated predicates mirrored to a SQLite fact store."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.fact_store import (
+    FactStore,
+    FactStoreSettings,
+    SessionFacts,
+    SqliteBackend,
+    format_store_status,
+    implementation_module,
+    load_chunks,
+    open_backend,
+    store_key,
+    store_load_call,
+)
+
+
+def change(action, fact, module="user", predicate="seen/1"):
+    return json.dumps({"module": module, "predicate": predicate, "action": action, "fact": fact})
+
+
+class Session:
+    """Answers the store's helper calls with the facts it holds."""
+
+    def __init__(self, *facts):
+        self.facts = list(facts)
+        self.calls = []
+
+    async def run(self, call):
+        self.calls.append(call)
+        if call[0] == "mcp_store_facts":
+            return [{"fact": fact} for fact in self.facts]
+        return [{"predicate": "user:seen/1"}]
+
+
+@pytest.fixture
+def store(tmp_path):
+    store = FactStore(SqliteBackend(tmp_path / "facts.db"), ("seen/1",))
+    yield store
+    store.close()
+
+
+def test_backends_by_url(tmp_path):
+    backend = open_backend(FactStoreSettings("facts.db"), tmp_path)
+
+    assert backend.description == f"SQLite {tmp_path / 'facts.db'}"
+    backend.close()
+    with pytest.raises(ValueError, match="Unknown fact store 'mysql://db'"):
+        open_backend(FactStoreSettings("mysql://db"), tmp_path)
+    assert FactStore.from_settings(FactStoreSettings(), tmp_path) is None
+
+
+def test_store_keys():
+    assert store_key(" seen/01") == "seen/1"
+    with pytest.raises(ValueError, match="shared by every module"):
+        store_key("team:seen/1")
+
+
+def test_sqlite_counts_copies_and_bumps_versions(tmp_path):
+    backend = SqliteBackend(tmp_path / "facts.db")
+
+    assert backend.apply("seen/1", [("asserted", "seen(a)"), ("asserted", "seen(a)"), ("asserted", "seen(b)")]) == 1
+    assert backend.apply("seen/1", [("retracted", "seen(b)")]) == 2
+    assert backend.load("seen/1") == (["seen(a)", "seen(a)"], 2)
+    assert backend.replace("seen/1", ["seen(c)"]) == 3
+    assert backend.counts() == {"seen/1": 1}
+    assert backend.release("seen/1", drop=True) == 1
+    assert backend.versions(["seen/1"]) == {"seen/1": 0}
+    backend.close()
+
+
+async def test_changes_are_written_in_one_batch(store):
+    facts = SessionFacts()
+    facts.mark("user", "seen/1", 0, "user")
+
+    store.record(facts, change("asserted", "seen(a)"))
+    store.record(facts, change("asserted", "seen(b)"))
+    store.record(facts, change("asserted", "other(a)", predicate="other/1"))
+    store.record(facts, "not json")
+    store.flush()
+
+    assert store.backend.load("seen/1") == (["seen(a)", "seen(b)"], 1)
+    # The session wrote the version after the one it had, so its copy is current
+    assert facts.loaded[("user", "seen/1")] == 1
+    assert store.status()["written"] == 2
+
+
+async def test_prepare_seeds_empty_predicates_then_loads_stored_ones(store):
+    first, second = Session("seen(a)"), Session()
+    first_facts, second_facts = SessionFacts(), SessionFacts()
+
+    assert await store.prepare(first_facts, 1, "user", first.run) == ["seen/1"]
+    assert await store.prepare(second_facts, 1, "user", second.run) == ["seen/1"]
+    assert await store.prepare(second_facts, 1, "user", second.run) == []
+
+    assert store.backend.load("seen/1") == (["seen(a)"], 1)
+    assert second.calls == [store_load_call("user", "seen/1", True, ["seen(a)"])]
+    # A restarted session has nothing loaded
+    second_facts.reset(2)
+    assert second_facts.loaded == {}
+
+
+async def test_large_loads_are_chunked():
+    session = Session()
+
+    await load_chunks(session.run, "user", "seen/1", [f"seen({n})" for n in range(501)])
+    await load_chunks(session.run, "user", "seen/1", [])
+
+    assert [(len(call[1][4].split(", ")), call[1][3]) for call in session.calls[:2]] == [(500, "true"), (1, "false")]
+    assert session.calls[2] == store_load_call("user", "seen/1", True, [])
+
+
+def test_implementation_module():
+    assert implementation_module([{"predicate": "'base':seen/1"}], "team") == "base"
+    assert implementation_module([], "team") == "team"
+
+
+def test_attach_detach_and_status(store):
+    facts = SessionFacts()
+    facts.mark("user", "seen/1", 0, "user")
+
+    assert store.attach("alert/2") == "alert/2"
+    assert store.detach("alert/2") == ("alert/2", 0)
+    with pytest.raises(ValueError, match=r"alert/2 is not stored \(stored: seen/1\)"):
+        store.detach("alert/2")
+    assert format_store_status(store, facts, "user").splitlines()[1:] == [
+        "  • seen/1: 0 fact(s), version 0 (loaded)",
+        "✍️ Changes written: 0, waiting: 0",
+    ]
@@ -0,0 +1,106 @@
+"""GeoJSON and WKT parsing, space pack shapes and geo query results."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.geospatial import (
+    GeoLayer,
+    check_geometry,
+    feature_collection,
+    format_matches,
+    haversine,
+    matched_features,
+    parse_features,
+    query_geometry,
+    shape_term,
+    wkt_geometry,
+)
+
+AMSTERDAM = [4.9, 52.37]
+UTRECHT = [5.12, 52.09]
+
+DEPOTS = json.dumps({"type": "FeatureCollection", "features": [
+    {"type": "Feature", "id": "ams", "geometry": {"type": "Point", "coordinates": AMSTERDAM}, "properties": {"cap": 3}},
+    {"type": "Feature", "geometry": {"type": "Point", "coordinates": UTRECHT}, "properties": {"code": "ut"}},
+    {"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[4, 52], [5, 52], [5, 53]]]}},
+]})
+
+
+@pytest.mark.parametrize("text, geometry", [
+    ("POINT (4.9 52.37)", {"type": "Point", "coordinates": AMSTERDAM}),
+    ("SRID=4326;point z (4.9 52.37 1)", {"type": "Point", "coordinates": AMSTERDAM}),
+    ("MULTIPOINT ((1 2), (3 4))", {"type": "MultiPoint", "coordinates": [[1, 2], [3, 4]]}),
+    ("MULTIPOINT (1 2, 3 4)", {"type": "MultiPoint", "coordinates": [[1, 2], [3, 4]]}),
+    ("POLYGON ((0 0, 1 0, 1 1))", {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}),
+])
+def test_wkt_geometry(text, geometry):
+    assert wkt_geometry(text) == geometry
+
+
+@pytest.mark.parametrize("text, message", [
+    ("CIRCLE (1 2)", "Unsupported WKT geometry 'CIRCLE'"),
+    ("POINT EMPTY", "Empty Point geometries cannot be indexed"),
+    ("LINESTRING (1 2, 3 4", "WKT ends early"),
+    ("POINT (200 0)", "outside longitude -180..180"),
+    ("POINT (1 2) extra", "Unexpected 'extra' after the WKT geometry"),
+])
+def test_bad_wkt(text, message):
+    with pytest.raises(ValueError, match=message):
+        wkt_geometry(text)
+
+
+def test_geometries_are_checked():
+    with pytest.raises(ValueError, match="at least three distinct positions"):
+        check_geometry({"type": "Polygon", "coordinates": [[[0, 0], [1, 1]]]})
+    with pytest.raises(ValueError, match="Unsupported geometry type 'Circle'"):
+        check_geometry({"type": "Circle", "coordinates": [0, 0]})
+
+
+def test_features_from_geojson_and_wkt_lines():
+    features = parse_features(DEPOTS, id_property="code")
+    lines = parse_features("# depots\nams POINT (4.9 52.37)\nLINESTRING (1 2, 3 4)\n")
+
+    assert [feature.id for feature in features] == ["ams", "ut", ""]
+    assert features[2].geometry["coordinates"][0][-1] == [4, 52]
+    assert [(feature.id, feature.geometry["type"]) for feature in lines] == [("ams", "Point"), ("", "LineString")]
+    with pytest.raises(ValueError, match="Line 2: "):
+        parse_features("POINT (1 2)\nPOINT (1)")
+    with pytest.raises(ValueError, match="Feature 1: "):
+        parse_features('{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1]}}')
+
+
+def test_shapes_put_latitude_first():
+    assert shape_term({"type": "Point", "coordinates": [4.9, 52.37]}) == "point(52.37, 4.9)"
+    assert shape_term(wkt_geometry("LINESTRING (1 2, 3 4)")) == "linestring([point(2.0, 1.0), point(4.0, 3.0)])"
+    assert shape_term(wkt_geometry("POLYGON ((0 0, 1 0, 1 1))")).startswith("polygon([[point(0.0, 0.0), ")
+
+
+def test_layers_number_features_without_an_id():
+    layer = GeoLayer("depots")
+    layer.add(parse_features(DEPOTS))
+
+    assert list(layer.features) == ["ams", "depots-1", "depots-2"]
+    assert layer.describe() == "depots (index geo_depots): 3 feature(s), 2 Point, 1 Polygon; bbox [4, 52, 5.12, 53]"
+    (load, build) = layer.load_calls()
+    assert load[0] == "mcp_geo_load" and build == ("mcp_geo_index", ["'geo_depots'"])
+    with pytest.raises(ValueError, match="Feature id 'ams' is already in layer depots"):
+        layer.add(parse_features(DEPOTS))
+    with pytest.raises(ValueError, match="Unknown operation 'within'"):
+        layer.query_call("within", query_geometry("POINT (1 2)"), 5)
+
+
+def test_nearest_matches_carry_their_distance():
+    layer = GeoLayer("depots")
+    layer.add(parse_features(DEPOTS))
+    here = query_geometry(json.dumps({"type": "Point", "coordinates": AMSTERDAM}))
+
+    features = matched_features(layer, [{"id": "ams"}, {"id": "depots-1"}, {"id": "gone"}], "nearest", here)
+
+    assert [feature["properties"]["rank"] for feature in features] == [1, 2]
+    assert features[0]["properties"]["distance_m"] == 0
+    assert features[1]["properties"]["distance_m"] == round(haversine(AMSTERDAM, UTRECHT), 1)
+    assert 34_000 < haversine(AMSTERDAM, UTRECHT) < 35_000
+    assert feature_collection(features)["bbox"] == [4.9, 52.09, 5.12, 52.37]
+    assert format_matches("depots", "nearest", features).splitlines()[1] == '  • ams (Point, 0 m away) {"cap": 3}'
+    assert format_matches("depots", "contains", []) == "🔍 No features of depots match (contains)"
@@ -0,0 +1,86 @@
+"""DCG parse goals, and the parses or failure position they report."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.grammars import (
+    ParseFailure,
+    ParseOutcome,
+    format_parse,
+    parse_goal,
+    parse_grammar_output,
+    start_goal,
+)
+
+TEXT = "1 + 2\n* x"
+
+
+def output(*rows, end=True):
+    lines = [f"@MCP parse SOLUTION {json.dumps(row)}" for row in rows] + ["some user output"]
+    return "\n".join(lines + (["@MCP parse END"] if end else []))
+
+
+@pytest.mark.parametrize("start, goal", [
+    ("expr//1", "expr(A1)"),
+    ("sentence//0", "sentence"),
+    ("expr(Tree).", "expr(Tree)"),
+])
+def test_start_goal(start, goal):
+    assert start_goal(start) == goal
+
+
+def test_parse_goal_asks_for_one_parse_more():
+    goal = parse_goal("expr//1", "1+2", "chars", 3, QueryLimits())
+
+    assert goal.startswith('mcp_parse(parse, "expr(A1)", "1+2", ')
+    assert goal.endswith(", parse(chars, 4))")
+    with pytest.raises(ValueError, match="Unknown input_type 'bytes'"):
+        parse_goal("expr", "1", "bytes", 3, QueryLimits())
+    with pytest.raises(ValueError, match="between 1 and 100"):
+        parse_goal("expr", "1", "codes", 0, QueryLimits())
+
+
+def test_parses_beyond_the_limit_mean_more():
+    rows = [{"bindings": {"T": {"type": "integer", "value": n}}} for n in range(3)]
+
+    outcome = parse_grammar_output(output(*rows), TEXT, "codes", 2)
+
+    assert len(outcome.parses) == 2 and outcome.more
+    assert format_parse("expr(T)", TEXT, "codes", outcome).splitlines() == [
+        "✅ Parsed as expr(T): 2 parse(s), and more exist",
+        "  1. T = 0",
+        "  2. T = 1",
+        "💡 The input is ambiguous: raise max_parses to see more parses",
+    ]
+
+
+def test_failures_are_located_by_line_and_column():
+    failure = {"failure": {"position": 8, "rule": "factor//1", "prefix": 5}}
+
+    outcome = parse_grammar_output(output(failure), TEXT, "codes", 5)
+
+    assert outcome.failure == ParseFailure(8, "factor//1", 5, line=2, column=3, near="x")
+    assert format_parse("expr(T)", TEXT, "codes", outcome).splitlines() == [
+        "❌ No parse of the whole input as expr(T)",
+        "📍 Stuck at character 8 (line 2, column 3)",
+        "   after factor//1 matched up to there",
+        "   near: 'x'",
+        "💡 expr(T) itself parses the first 5 character(s)",
+    ]
+
+
+def test_token_failures_show_the_next_tokens():
+    outcome = parse_grammar_output(output({"failure": {"position": 2}}), "the dog barks loudly", "tokens", 5)
+
+    assert outcome.failure.near == "barks loudly"
+    assert "Stuck at token 2\n" in format_parse("s", "the dog barks loudly", "tokens", outcome)
+
+
+def test_errors_and_missing_end():
+    with pytest.raises(RuntimeError, match="Unknown procedure expr//1"):
+        parse_grammar_output("@MCP parse ERROR Unknown procedure expr//1\n@MCP parse END", TEXT, "codes", 5)
+    with pytest.raises(RuntimeError, match="exited before reporting"):
+        parse_grammar_output(output(end=False), TEXT, "codes", 5)
+    assert parse_grammar_output(output(), TEXT, "codes", 5) == ParseOutcome()
@@ -0,0 +1,96 @@
+"""Test harness scenarios: reading them, running their steps and checking results."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.errors import ToolError
+from docker_swish_mcp.harness import (
+    Expectation,
+    Scenario,
+    ScenarioError,
+    format_report,
+    json_contains,
+    load_scenario,
+    run_scenario,
+)
+
+TOM = {"type": "atom", "value": "tom"}
+SCENARIO = {
+    "name": "family",
+    "files": {"family.pl": "parent(tom, bob).\n"},
+    "steps": [
+        {"tool": "load_knowledge_base", "args": {"filename": "family.pl"}, "expect": {"contains": "Loaded"}},
+        {"name": "bob has a parent", "tool": "execute_prolog_query", "expect": {"json": {"solutions": [{"X": TOM}]}}},
+        {"tool": "execute_prolog_query", "expect": {"error": "timeout"}},
+    ],
+}
+
+
+def test_scenario_from_a_json_file(tmp_path):
+    path = tmp_path / "family.json"
+    path.write_text(json.dumps({**SCENARIO, "name": ""}))
+
+    scenario = load_scenario(path)
+
+    assert scenario.name == "family"
+    assert [step.name for step in scenario.steps] == ["1. load_knowledge_base", "bob has a parent", "3. execute_prolog_query"]
+    assert scenario.steps[0].expect == Expectation(contains=("Loaded",))
+
+
+@pytest.mark.parametrize("raw, message", [
+    ({"steps": []}, "steps must be a non-empty list"),
+    ({"steps": [{"tool": "x"}], "files": {"../x.pl": ""}}, "must be a path inside the data directory"),
+    ({"steps": [{"tool": "x", "expect": {"has": "a"}}]}, r"Unknown checks \['has'\] in steps\[1\].expect"),
+    ({"steps": [{"tool": "x", "expect": {"matches": "("}}]}, "is not a regular expression"),
+    ({"steps": [{"args": {}}]}, r"steps\[1\] needs the name of a tool"),
+])
+def test_malformed_scenarios(raw, message):
+    with pytest.raises(ScenarioError, match=message):
+        Scenario.from_setting(raw)
+
+
+def test_json_contains_compares_tables_by_key_and_lists_from_the_start():
+    actual = {"solutions": [{"X": "tom", "Y": 1}, {"X": "ann"}], "count": 2}
+
+    assert json_contains({"solutions": [{"X": "tom"}]}, actual) == []
+    assert json_contains({"solutions": [{"X": "bob"}], "more": True}, actual) == [
+        'json.solutions[0].X is "tom", expected "bob"',
+        "json has no more",
+    ]
+    assert json_contains([1, 2], [1]) == ["json has 1 item(s), expected at least 2"]
+
+
+async def test_run_scenario_checks_every_step():
+    results = {
+        "load_knowledge_base": "✅ Loaded family.pl",
+        "execute_prolog_query": json.dumps({
+            "query": "parent(X, bob).", "success": True, "output": [], "error": None,
+            "solutions": [{"X": {"type": "atom", "value": "ann"}}],
+        }),
+    }
+
+    async def call_tool(name, args):
+        return results[name]
+
+    report = await run_scenario(Scenario.from_setting(SCENARIO), call_tool)
+
+    assert [step.passed for step in report.steps] == [True, False, False]
+    assert report.steps[1].problems == ['json.solutions[0].X.value is "ann", expected "tom"']
+    assert report.steps[2].problems == ["expected a timeout error, the call succeeded"]
+    assert not report.passed
+
+
+async def test_stop_on_failure_skips_the_remaining_steps():
+    async def call_tool(name, args):
+        if name == "load_knowledge_base":
+            raise RuntimeError("no container")
+        return ToolError("timeout", "Query timed out").render()
+
+    report = await run_scenario(Scenario.from_setting({**SCENARIO, "stop_on_failure": True}), call_tool)
+
+    assert report.steps[0].problems[0].startswith("the call failed with ")
+    assert [step.skipped for step in report.steps] == [False, True, True]
+    lines = format_report(report).splitlines()
+    assert lines[0].startswith("❌ family (")
+    assert lines[-1] == "   ⏭️ 3. execute_prolog_query (skipped)"
@@ -0,0 +1,69 @@
+"""Daemon endpoints, bind-mount paths and line endings on Windows and WSL2."""
+
+import os
+from pathlib import Path
+
+import pytest
+
+from docker_swish_mcp import host_platform
+from docker_swish_mcp.host_platform import (
+    WINDOWS_PIPES,
+    daemon_path,
+    docker_endpoints,
+    has_crlf,
+    normalize_newlines,
+    path_style,
+    process_alive,
+)
+
+
+@pytest.fixture
+def platform(monkeypatch):
+    monkeypatch.delenv("DOCKER_HOST", raising=False)
+
+    def pretend(windows=False, wsl=False):
+        monkeypatch.setattr(host_platform, "is_windows", lambda: windows)
+        monkeypatch.setattr(host_platform, "is_wsl", lambda: wsl)
+    return pretend
+
+
+def test_endpoints_without_docker_host(platform, monkeypatch):
+    platform(windows=True)
+    assert docker_endpoints() == list(WINDOWS_PIPES)
+    platform(wsl=True)
+    assert docker_endpoints()[-1] == "tcp://localhost:2375"
+    platform()
+    assert docker_endpoints() == []
+    monkeypatch.setenv("DOCKER_HOST", "tcp://docker:2375")
+    platform(windows=True)
+    assert docker_endpoints() == []
+
+
+def test_auto_path_style(platform, monkeypatch):
+    platform(windows=True)
+    assert path_style() == "windows"
+    platform(wsl=True)
+    assert path_style() == "posix"
+    monkeypatch.setenv("DOCKER_HOST", "tcp://localhost:2375")
+    assert path_style() == "wsl" and path_style("posix") == "posix"
+
+
+def test_wsl_drive_paths_are_written_for_docker_desktop():
+    assert daemon_path(Path("/mnt/c/Users/me/data"), "wsl") == "/c/Users/me/data"
+    assert daemon_path(Path("/mnt/D"), "wsl") == "/d"
+    # Paths inside the distribution itself stay as they are
+    assert daemon_path(Path("/home/me/data"), "wsl") == "/home/me/data"
+    assert daemon_path(Path("/mnt/c/data"), "posix") == "/mnt/c/data"
+
+
+def test_line_endings(tmp_path):
+    path = tmp_path / "family.pl"
+    path.write_bytes(b"parent(tom, bob).\r\nparent(bob, ann).\r\n")
+
+    assert has_crlf(path) and not has_crlf(tmp_path / "missing.pl")
+    assert normalize_newlines("a.\r\nb.\rc.\n") == "a.\nb.\nc.\n"
+
+
+def test_process_alive():
+    assert process_alive(os.getpid())
+    assert not process_alive(2 ** 22 + 1)
@@ -0,0 +1,96 @@
+"""Trusted proxy headers, CORS and the transport settings."""
+
+import ipaddress
+
+import pytest
+
+from docker_swish_mcp.http_serving import (
+    CorsMiddleware,
+    ForwardedHeadersMiddleware,
+    HttpSettings,
+    origin_allowed,
+    parse_networks,
+)
+
+
+async def call(app, scope):
+    seen, sent = [], []
+
+    async def inner(scope, receive, send):
+        seen.append(scope)
+        await send({"type": "http.response.start", "status": 200, "headers": []})
+
+    async def send(message):
+        sent.append(message)
+
+    await app(inner)({"type": "http", "method": "GET", "headers": [], **scope}, None, send)
+    return seen, sent
+
+
+def test_trusted_networks():
+    assert parse_networks("127.0.0.1, 10.0.0.0/8,,::1") == (
+        ipaddress.ip_network("127.0.0.1/32"), ipaddress.ip_network("10.0.0.0/8"), ipaddress.ip_network("::1/128"),
+    )
+    with pytest.raises(ValueError, match="Invalid trusted proxy address 'proxy'"):
+        parse_networks("proxy")
+
+
+def test_settings_errors_are_collected(monkeypatch, tmp_path):
+    monkeypatch.setenv("SWISH_MCP_TLS", "yes")
+    monkeypatch.setenv("SWISH_MCP_TLS_CERT", str(tmp_path / "cert.pem"))
+
+    error = HttpSettings.from_env().error
+
+    assert "SWISH_MCP_TLS must be auto or off, not 'yes'" in error
+    assert "must be set together" in error
+    assert "cert.pem does not exist" in error
+
+
+def test_cors_origins_are_read_without_trailing_slashes(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_CORS_ORIGINS", "https://app.example.com/, https://*.example.org")
+
+    settings = HttpSettings.from_env()
+
+    assert settings.cors_origins == ("https://app.example.com", "https://*.example.org")
+    assert not settings.tls and not settings.error
+
+
+@pytest.mark.parametrize("origin, allowed", [
+    ("https://app.example.com/", True),
+    ("https://a.b.example.org", True),
+    ("https://example.org", False),
+    ("http://app.example.com", False),
+])
+def test_origin_allowed(origin, allowed):
+    assert origin_allowed(origin, ("https://app.example.com", "https://*.example.org")) == allowed
+
+
+async def test_forwarded_headers_only_from_trusted_proxies():
+    trusted = parse_networks("10.0.0.0/8")
+    headers = [(b"x-forwarded-for", b"203.0.113.9, 10.0.0.7"), (b"x-forwarded-proto", b"https")]
+
+    def app(inner):
+        return ForwardedHeadersMiddleware(inner, trusted)
+
+    (proxied,), _ = await call(app, {"client": ("10.0.0.2", 4000), "scheme": "http", "headers": headers})
+    (direct,), _ = await call(app, {"client": ("198.51.100.1", 4000), "scheme": "http", "headers": headers})
+
+    assert (proxied["client"], proxied["scheme"]) == (("203.0.113.9", 0), "https")
+    assert (direct["client"], direct["scheme"]) == (("198.51.100.1", 4000), "http")
+
+
+async def test_cors_refuses_unlisted_origins_and_answers_preflights():
+    def app(inner):
+        return CorsMiddleware(inner, ("https://app.example.com",))
+
+    seen, refused = await call(app, {"headers": [(b"origin", b"https://evil.example")]})
+    _, preflight = await call(app, {"method": "OPTIONS", "headers": [
+        (b"origin", b"https://app.example.com"), (b"access-control-request-method", b"POST"),
+    ]})
+    _, allowed = await call(app, {"headers": [(b"origin", b"https://app.example.com")]})
+
+    assert seen == [] and refused[0]["status"] == 403
+    assert preflight[0]["status"] == 204
+    assert (b"access-control-allow-origin", b"https://app.example.com") in allowed[0]["headers"]
+    # Requests without an Origin are not from a browser and pass untouched
+    assert (await call(app, {}))[1][0]["headers"] == []
@@ -0,0 +1,158 @@
+"""Pulling and building the image, against a scripted runtime client."""
+
+from pathlib import Path
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.images import (
+    CUSTOM_IMAGE_TAG,
+    ImageError,
+    PullProgress,
+    emulation_warning,
+    ensure_image,
+    image_platform,
+    image_reference,
+    normalize_platform,
+    pull_image,
+    validate_image,
+    validate_platform,
+)
+
+SWISH = "swipl/swish:latest"
+
+
+class FakeImages:
+    def __init__(self, present=None):
+        # Reference -> image attributes
+        self.present = dict(present or {})
+        self.pulls = []
+        self.builds = []
+
+    def get(self, reference):
+        if reference not in self.present:
+            raise LookupError(f"No such image: {reference}")
+        return SimpleNamespace(attrs=self.present[reference])
+
+    def pull(self, reference, **kwargs):
+        self.pulls.append((reference, kwargs))
+        self.present[reference] = {}
+
+    def build(self, **kwargs):
+        self.builds.append(kwargs)
+        return None, [{"stream": "Step 1/2 : FROM swipl\n"}, {"aux": {}}, {"stream": "Successfully tagged\n"}]
+
+
+def fake_client(present=None, architecture="x86_64", events=None):
+    client = SimpleNamespace(
+        images=FakeImages(present),
+        info=lambda: {"OSType": "linux", "Architecture": architecture},
+    )
+    if events is not None:
+        client.api = SimpleNamespace(pull=lambda reference, **kwargs: iter(events))
+    return client
+
+
+def test_image_references():
+    validate_image("registry.example.org:5000/team/swish:9.2")
+    with pytest.raises(ValueError, match="Invalid image reference"):
+        validate_image("Swish Latest")
+    assert image_reference("", None, SWISH) == SWISH
+    assert image_reference("team/swish:1", None, SWISH) == "team/swish:1"
+    assert image_reference("", Path("Dockerfile"), SWISH) == CUSTOM_IMAGE_TAG
+
+
+def test_present_image_is_not_pulled_unless_the_policy_says_always():
+    client = fake_client({SWISH: {}})
+
+    assert ensure_image(client, SWISH, None, "missing") == ("present", [])
+    assert client.images.pulls == []
+    assert ensure_image(client, SWISH, None, "always")[0] == "pulled"
+
+
+def test_missing_image_is_pulled_or_refused():
+    client = fake_client()
+
+    with pytest.raises(ImageError, match="pull_policy is never"):
+        ensure_image(client, SWISH, None, "never")
+    assert ensure_image(client, SWISH, None, "missing")[0] == "pulled"
+    assert client.images.pulls[0][0] == SWISH
+
+
+def test_dockerfile_is_built_and_its_output_kept(tmp_path):
+    dockerfile = tmp_path / "Dockerfile"
+    dockerfile.write_text("FROM swipl/swish\n", encoding="utf-8")
+    client = fake_client()
+
+    done, log = ensure_image(client, CUSTOM_IMAGE_TAG, dockerfile, "missing")
+
+    assert (done, log) == ("built", ["Step 1/2 : FROM swipl", "Successfully tagged"])
+    assert client.images.builds[0]["path"] == str(tmp_path)
+    assert client.images.builds[0]["tag"] == CUSTOM_IMAGE_TAG
+    with pytest.raises(ImageError, match="does not exist"):
+        ensure_image(client, CUSTOM_IMAGE_TAG, tmp_path / "missing", "always")
+
+
+def test_offline_mode_never_pulls():
+    with pytest.raises(ImageError, match="offline mode is on"):
+        ensure_image(fake_client(), SWISH, None, "always", offline=True)
+    assert ensure_image(fake_client({SWISH: {}}), SWISH, None, "always", offline=True) == ("present", [])
+
+
+def test_pull_progress_counts_layers():
+    progress = PullProgress(SWISH)
+
+    assert not progress.update({"status": "Pulling from swipl/swish", "id": "latest"})
+    assert progress.update({"status": "Downloading", "id": "a", "progressDetail": {"current": 1_000_000, "total": 4_000_000}})
+    assert progress.update({"status": "Already exists", "id": "b"})
+    assert progress.update({"status": "Pull complete", "id": "a"})
+    assert not progress.update({"status": "Pull complete", "id": "a"})
+
+    assert progress.describe() == "⬇️ Pulling swipl/swish:latest: 4.0/4.0 MB, 2/2 layers"
+
+
+def test_pull_errors_in_the_event_stream_are_raised():
+    client = fake_client(events=[{"status": "Pulling from swipl/swish", "id": "latest"}, {"error": "denied"}])
+
+    with pytest.raises(ImageError, match="Could not pull swipl/swish:latest: denied"):
+        pull_image(client, SWISH)
+
+
+@pytest.mark.parametrize("os_name, machine, platform", [
+    ("linux", "x86_64", "linux/amd64"),
+    ("", "aarch64", "linux/arm64"),
+    ("Linux", "armv7l", "linux/arm/v7"),
+    ("linux", "mips", ""),
+])
+def test_normalize_platform(os_name, machine, platform):
+    assert normalize_platform(os_name, machine) == platform
+
+
+def test_validate_platform():
+    validate_platform("auto")
+    validate_platform("linux/arm/v7")
+    with pytest.raises(ValueError, match="Invalid platform 'arm64'"):
+        validate_platform("arm64")
+
+
+def test_emulated_images_are_warned_about():
+    client = fake_client({SWISH: {"Os": "linux", "Architecture": "arm64", "Variant": "v8"}})
+
+    assert image_platform(client, SWISH) == "linux/arm64/v8"
+    assert emulation_warning(client, SWISH, "linux/arm64") == ""
+    assert "runs emulated" in emulation_warning(client, SWISH, "linux/amd64")
+
+
+def test_pulls_ask_for_the_daemon_platform():
+    client = fake_client(architecture="aarch64")
+
+    ensure_image(client, SWISH, None, "missing")
+
+    assert client.images.pulls == [(SWISH, {"platform": "linux/arm64"})]
+
+
+def test_forced_platform_replaces_an_image_of_another():
+    client = fake_client({SWISH: {"Os": "linux", "Architecture": "amd64"}})
+
+    assert ensure_image(client, SWISH, None, "missing", platform="linux/arm64")[0] == "pulled"
+    assert client.images.pulls == [(SWISH, {"platform": "linux/arm64"})]
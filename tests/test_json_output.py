@@ -0,0 +1,42 @@
+"""Typed JSON bindings, from the session's SOLUTION lines to the query result."""
+
+import json
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp import main
+from docker_swish_mcp.config import QueryLimits
+
+BINDINGS = {"X": {"type": "compound", "functor": "f", "arity": 1, "args": [{"type": "atom", "value": "a"}]}}
+
+
+def replies(goal):
+    return [f"@MCP {{id}} SOLUTION {json.dumps(BINDINGS)}", "@MCP {id} END"]
+
+
+async def test_json_solutions_carry_bindings(fake_session):
+    session = fake_session(replies)
+
+    [event] = [event async for event in session.stream_query("X = f(a)", QueryLimits(), "json")]
+
+    assert event["bindings"] == BINDINGS
+    assert "json" in session.process.goals[0]
+
+
+async def test_unknown_output_format_is_refused(fake_session):
+    session = fake_session(replies)
+
+    with pytest.raises(ValueError, match="Unknown output format 'xml'"):
+        [event async for event in session.stream_query("true", QueryLimits(), "xml")]
+
+
+async def test_json_result_document(fake_session):
+    context = SimpleNamespace(prolog_session=fake_session(replies))
+
+    result = json.loads(await main.run_session_query(context, "?- X = f(a).", QueryLimits(), output_format="json"))
+
+    assert result["query"] == "X = f(a)."
+    assert result["success"] is True
+    assert result["solutions"] == [BINDINGS]
+    assert result["error"] is None
@@ -0,0 +1,52 @@
+"""The retract_matching and kb_prune calls and their reports."""
+
+import pytest
+
+from docker_swish_mcp.kb_cleanup import (
+    format_pruned,
+    format_retracted,
+    prune_call,
+    retract_call,
+)
+
+
+def test_calls():
+    assert retract_call("user", "visited(_, _)", True) == ("mcp_retract_matching", ["'user'", '"visited(_, _)"', "true"])
+    assert prune_call("user", ["main.", " solve/2 ", ""], False) == ("mcp_kb_prune", ["'user'", '["main", "solve/2"]', "false"])
+    with pytest.raises(ValueError, match="No entry points given"):
+        prune_call("user", [" "], True)
+
+
+def test_format_retracted():
+    rows = [{"predicate": "visited/2", "clause": "visited(a, 1)"}, {"predicate": "visited/2", "clause": "visited(b, 2)"}]
+
+    assert format_retracted("visited(_, _)", rows, True).splitlines() == [
+        "🔍 Would retract 2 clause(s) of visited/2:",
+        "  • visited(a, 1)",
+        "  • visited(b, 2)",
+        "",
+        "💡 Run again with dry_run=False to retract them",
+    ]
+    assert format_retracted("seen(_)", [], False) == "🔍 No clauses match seen(_)"
+
+
+def test_format_pruned():
+    rows = [
+        {"roots": ["main/0"], "kept": 4},
+        {"predicate": "helper/1", "clauses": 2, "dynamic": False, "file": "/data/family.pl"},
+        {"predicate": "memo/2", "clauses": 9, "dynamic": True},
+        {"predicate": "lib_pred/0", "error": "No permission to modify static procedure"},
+    ]
+
+    assert format_pruned(rows, False).splitlines() == [
+        "🌱 Entry points: main/0; 4 predicate(s) reachable",
+        "🗑️ Abolished 2 unreachable predicate(s):",
+        "  • helper/1 (2 clause(s), static, from /data/family.pl)",
+        "  • memo/2 (9 clause(s), dynamic)",
+        "❌ Could not abolish:",
+        "  • lib_pred/0: No permission to modify static procedure",
+        "",
+        "💡 Static predicates come back when their file is consulted again",
+    ]
+    assert format_pruned(rows[:1], True).endswith("✅ Every predicate is reachable; nothing to prune")
+    assert format_pruned([{"roots": [], "kept": 0}], True).startswith("❌ None of the entry points")
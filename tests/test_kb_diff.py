@@ -0,0 +1,65 @@
+"""Clause-by-clause diffs of two versions of a program."""
+
+from docker_swish_mcp.kb_diff import (
+    diff_clauses,
+    file_clauses_call,
+    format_diff,
+    loaded_clauses_call,
+)
+
+
+def rows(*clauses):
+    return [{"predicate": predicate, "clause": clause, "line": line} for predicate, clause, line in clauses]
+
+
+OLD = rows(
+    ("parent/2", "parent(tom,bob)", 1),
+    ("parent/2", "parent(bob,ann)", 2),
+    ("grand/2", "grand(A,B):-parent(A,C),parent(C,B)", 4),
+)
+
+
+def test_same_clauses_have_no_changes():
+    assert diff_clauses(OLD, list(OLD)) == []
+    assert format_diff([], "a.pl", "b.pl", (3, 3)) == (
+        "✅ a.pl and b.pl have the same clauses (3 clause(s), variable names ignored)"
+    )
+
+
+def test_changes_are_matched_per_predicate():
+    new = rows(
+        ("parent/2", "parent(tom,bob)", 1),
+        ("parent/2", "parent(bob,eve)", 2),
+        ("parent/2", "parent(eve,joe)", 3),
+        ("age/2", "age(tom,70)", 5),
+    )
+
+    changes = diff_clauses(OLD, new)
+
+    assert [(c.kind, c.predicate) for c in changes] == [
+        ("modified", "parent/2"), ("added", "parent/2"), ("removed", "grand/2"), ("added", "age/2"),
+    ]
+    assert changes[0].to_json() == {
+        "kind": "modified", "predicate": "parent/2",
+        "old": "parent(bob,ann)", "old_line": 2, "new": "parent(bob,eve)", "new_line": 2,
+    }
+    assert format_diff(changes, "old.pl", "loaded", (3, 4)).splitlines()[:4] == [
+        "🔀 old.pl → loaded: 2 added, 1 removed, 1 modified (3 → 4 clause(s))",
+        "",
+        "📌 parent/2",
+        "  ~ parent(bob,ann) (line 2)",
+    ]
+
+
+def test_ignore_order_skips_moved_clauses():
+    moved = [OLD[1], OLD[0], OLD[2]]
+
+    assert [c.kind for c in diff_clauses(OLD, moved)] == ["added", "removed"]
+    assert diff_clauses(OLD, moved, ignore_order=True) == []
+
+
+def test_clause_calls():
+    assert file_clauses_call("kb/it's.pl") == ("mcp_kb_clauses", ["file('kb/it\\'s.pl')"])
+    assert loaded_clauses_call("user", "kb.pl", ["parent/2", "a/b/1"]) == (
+        "mcp_kb_clauses", ["loaded('user', 'kb.pl', ['parent'/2, 'a/b'/1])"],
+    )
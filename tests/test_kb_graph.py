@@ -0,0 +1,66 @@
+"""DOT graphs drawn from the knowledge base's rows."""
+
+import pytest
+
+from docker_swish_mcp.kb_graph import (
+    call_graph_dot,
+    fact_graph_dot,
+    graph_call,
+    reachable_from,
+    render_command,
+)
+
+ROWS = [
+    {"node": "grand/2", "clauses": 1},
+    {"node": "parent/2", "clauses": 3, "dynamic": True},
+    {"node": "report/0", "clauses": 1},
+    {"from": "grand/2", "to": "parent/2"},
+    {"from": "report/0", "to": "grand/2"},
+]
+
+
+def test_graph_call_checks_the_relation():
+    assert graph_call("facts", "parent/2", 10)[1][-2:] == ["facts('parent', 2)", "10"]
+    with pytest.raises(ValueError, match="needs at least two arguments"):
+        graph_call("facts", "person/1", 10)
+    with pytest.raises(ValueError, match="needs relation"):
+        graph_call("facts", "", 10)
+    with pytest.raises(ValueError, match="Unknown graph kind 'tree'"):
+        graph_call("tree", "", 10)
+
+
+def test_call_graph_marks_dynamic_predicates():
+    dot, nodes, edges = call_graph_dot(ROWS)
+
+    assert (nodes, edges) == (3, 2)
+    assert '  "parent/2" [label="parent/2\\n3 clause(s)", style=dashed];' in dot
+    assert '  "report/0" -> "grand/2";' in dot
+
+
+def test_focus_keeps_what_the_predicate_reaches():
+    dot, nodes, edges = call_graph_dot(ROWS, focus="grand/2")
+
+    assert reachable_from("grand/2", [("grand/2", "parent/2"), ("report/0", "grand/2")]) == {"grand/2", "parent/2"}
+    assert (nodes, edges) == (2, 1)
+    assert "report/0" not in dot and "penwidth=2" in dot
+    with pytest.raises(ValueError, match="not defined"):
+        call_graph_dot(ROWS, focus="missing/1")
+
+
+def test_fact_graph_labels_edges():
+    dot, nodes, edges = fact_graph_dot([
+        {"from": "tom", "to": "bob", "label": "since 1990"},
+        {"from": "bob", "to": 'a "quoted" name'},
+    ], "parent/2")
+
+    assert (nodes, edges) == (3, 2)
+    assert dot.splitlines()[0] == 'digraph "parent/2" {'
+    assert '  "tom" -> "bob" [label="since 1990"];' in dot
+    assert '  "bob" -> "a \\"quoted\\" name";' in dot
+
+
+def test_render_command_pipes_through_graphviz():
+    command = render_command("digraph {}", "png")
+
+    assert command[:2] == ["sh", "-c"]
+    assert command[2].endswith("| base64 -d | dot -Tpng | base64 -w0")
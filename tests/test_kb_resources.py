@@ -0,0 +1,88 @@
+"""swish://kb/ resources follow the data directory and notify their subscribers."""
+
+import os
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.kb_resources import (
+    KnowledgeBaseResources,
+    kb_relative_path,
+    kb_uri,
+    scan_kb_files,
+)
+
+
+class Server:
+    def __init__(self):
+        self._resource_manager = SimpleNamespace(_resources={})
+
+    def add_resource(self, resource):
+        self._resource_manager._resources[resource.uri] = resource
+
+
+class Session:
+    def __init__(self):
+        self.sent = []
+
+    async def send_resource_updated(self, uri):
+        self.sent.append(("updated", uri))
+
+    async def send_resource_list_changed(self):
+        self.sent.append(("list_changed",))
+
+
+def test_scan_finds_files_one_level_down(tmp_path):
+    for name in ("a.pl", "project/b.pl", "project/deep/c.pl", "notes.txt"):
+        (tmp_path / name).parent.mkdir(parents=True, exist_ok=True)
+        (tmp_path / name).write_text("x.\n", encoding="utf-8")
+
+    assert sorted(scan_kb_files(tmp_path)) == ["a.pl", "project/b.pl"]
+    assert scan_kb_files(tmp_path / "missing") == {}
+
+
+async def test_refresh_registers_files_and_notifies(tmp_path):
+    server = Server()
+    resources = KnowledgeBaseResources(server, data_dir=tmp_path)
+    session, subscriber = Session(), Session()
+    resources.sessions.add(session)
+    (tmp_path / "family.pl").write_text("parent(tom, bob).\n", encoding="utf-8")
+
+    await resources.refresh()
+    resources.subscribers[kb_uri("family.pl")] = {subscriber}
+    os.utime(tmp_path / "family.pl", ns=(0, 0))
+    await resources.refresh()
+    (tmp_path / "family.pl").unlink()
+    await resources.refresh()
+
+    assert session.sent == [("list_changed",), ("list_changed",)]
+    assert subscriber.sent == [("updated", "swish://kb/family.pl")] * 2
+    assert server._resource_manager._resources == {}
+
+
+async def test_read_appends_runtime_clauses(tmp_path):
+    (tmp_path / "family.pl").write_text("parent(tom, bob).\n", encoding="utf-8")
+    asked = []
+
+    async def runtime_clauses(path):
+        asked.append(path)
+        return "parent(bob, ann).\n"
+
+    resources = KnowledgeBaseResources(Server(), data_dir=tmp_path, runtime_clauses=runtime_clauses)
+    text = await resources.read("family.pl")
+
+    assert text.startswith("parent(tom, bob).\n")
+    assert text.endswith("(swish://kb/family.pl) ----\nparent(bob, ann).\n")
+    assert asked[0].endswith("/family.pl")
+
+
+def test_writeable_uris_name_files_in_the_data_directory():
+    assert kb_relative_path("swish://kb/project/family.pl/") == "project/family.pl"
+    with pytest.raises(ValueError, match="is not a knowledge base resource"):
+        kb_relative_path("file:///data/family.pl")
+
+
+@pytest.mark.parametrize("uri", ["swish://kb/notes.txt", "swish://kb/a/b/c.pl", "swish://kb/../family.pl"])
+def test_other_paths_cannot_be_written(uri):
+    with pytest.raises(ValueError, match="must name a .pl file"):
+        kb_relative_path(uri)
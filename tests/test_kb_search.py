@@ -0,0 +1,58 @@
+"""Search hits in the loaded files, from the helper's rows."""
+
+import pytest
+
+from docker_swish_mcp.kb_search import (
+    SearchHit,
+    collect_hits,
+    compile_pattern,
+    format_hits,
+    search_call,
+)
+
+ROOT = "/data"
+
+
+def test_search_calls_ask_for_one_row_more():
+    assert search_call("definition", "^parent", ROOT, "", 10) == ("mcp_kb_search", ["'/data'", "definitions", "11"])
+    assert search_call("body", "parent(_, bob).", ROOT, "/data/kb.pl", 0)[1][1:] == [
+        "body(\"parent(_, bob)\", '/data/kb.pl')", "2",
+    ]
+
+
+def test_invalid_regexes_are_refused():
+    assert compile_pattern("body", "(") is None
+    with pytest.raises(ValueError, match="Invalid regex '\\('"):
+        compile_pattern("comment", "(")
+
+
+def test_definitions_match_the_regex_and_are_sorted():
+    rows = [
+        {"file": "/data/b.pl", "line": 3, "predicate": "parent/2", "module": "user", "clauses": 2},
+        {"file": "/data/a.pl", "line": 9, "predicate": "grandparent/2", "module": "family", "clauses": 1},
+        {"file": "/data/a.pl", "line": 1, "predicate": "age/2", "module": "user", "clauses": 4},
+    ]
+
+    hits, truncated = collect_hits("definition", compile_pattern("definition", "parent"), rows, ROOT, "", 1)
+
+    assert (hits, truncated) == ([SearchHit("a.pl", 9, "family:grandparent/2", clauses=1)], True)
+    assert format_hits("definition", "parent", hits, truncated).splitlines() == [
+        "🔍 1 definition match(es) for: parent",
+        "",
+        "📄 a.pl",
+        "      9: family:grandparent/2 (1 clause(s))",
+        "",
+        "✂️ More matches were found; raise max_results or narrow the search",
+    ]
+
+
+def test_comment_hits_are_reported_at_their_line():
+    rows = [
+        {"file": "/data/a.pl", "line": 10, "comment": "% Family facts\n% TODO: add ages"},
+        {"file": "/data/b.pl", "line": 1, "comment": "% TODO: elsewhere"},
+    ]
+
+    hits, _ = collect_hits("comment", compile_pattern("comment", "TODO"), rows, ROOT, "a.pl", 10)
+
+    assert [hit.to_json() for hit in hits] == [{"file": "a.pl", "line": 11, "text": "% TODO: add ages"}]
+    assert format_hits("comment", "FIXME", [], False) == "🔍 No comment matches for: FIXME"
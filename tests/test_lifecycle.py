@@ -0,0 +1,97 @@
+"""Containers left behind by a server that is gone."""
+
+import socket
+import subprocess
+
+from docker_swish_mcp.lifecycle import (
+    OWNER_HOST_LABEL,
+    OWNER_PID_LABEL,
+    WantedContainer,
+    container_labels,
+    is_orphan,
+    shutdown_container,
+    sweep_orphans,
+)
+
+IMAGE = "swipl/swish:latest"
+
+
+def dead_pid():
+    process = subprocess.Popen(["true"])
+    process.wait()
+    return str(process.pid)
+
+
+class Container:
+    def __init__(self, name, labels, image=IMAGE):
+        self.name = name
+        self.attrs = {"Config": {"Labels": labels, "Image": image}}
+        self.calls = []
+
+    def stop(self, timeout):
+        self.calls.append("stop")
+
+    def remove(self, force):
+        self.calls.append("remove")
+
+
+class Client:
+    def __init__(self, *containers):
+        self.containers = self
+        self.all = list(containers)
+
+    def list(self, all):
+        return self.all
+
+
+def orphan(name, data_dir, port=3050, image=IMAGE):
+    labels = container_labels("0.1.0", port, data_dir)
+    labels[OWNER_PID_LABEL] = dead_pid()
+    return Container(name, labels, image)
+
+
+def test_owner_decides_what_is_an_orphan(tmp_path):
+    mine = Container("swish-mcp-a", container_labels("0.1.0", 3050, tmp_path))
+    elsewhere = Container("swish-mcp-b", {**mine.attrs["Config"]["Labels"], OWNER_HOST_LABEL: socket.gethostname() + "-x"})
+
+    assert not is_orphan(mine)
+    assert not is_orphan(elsewhere)
+    assert is_orphan(orphan("swish-mcp-c", tmp_path))
+    # Started before containers were labelled with their owner
+    assert is_orphan(Container("swish-mcp-d", {}))
+
+
+def test_orphan_like_the_wanted_container_is_adopted(tmp_path):
+    same = orphan("swish-mcp-a", tmp_path)
+    other_port = orphan("swish-mcp-b", tmp_path, port=3051)
+    unmanaged = Container("postgres", {})
+    client = Client(same, other_port, unmanaged)
+    wanted = [WantedContainer("swish-mcp-a", IMAGE, 3050, tmp_path), WantedContainer("swish-mcp-b", IMAGE, 3050, tmp_path)]
+
+    report = sweep_orphans(client, "adopt", wanted)
+
+    assert (report.adopted, report.removed) == (["swish-mcp-a"], ["swish-mcp-b"])
+    assert (same.calls, other_port.calls, unmanaged.calls) == ([], ["remove"], [])
+    assert report.describe() == "1 adopted, 1 removed"
+
+
+def test_keep_policy_leaves_orphans_alone(tmp_path):
+    container = orphan("swish-mcp-a", tmp_path)
+
+    report = sweep_orphans(Client(container), "keep", [])
+
+    assert report.describe() == "0 adopted, 0 removed, 1 kept"
+    assert container.calls == []
+
+
+def test_shutdown_policies():
+    results = {}
+    for policy in ("remove", "stop", "keep"):
+        container = Container("swish-mcp-a", {})
+        results[policy] = (shutdown_container(container, policy), container.calls)
+
+    assert results == {
+        "remove": ("removed", ["stop", "remove"]),
+        "stop": ("stopped", ["stop"]),
+        "keep": ("kept", []),
+    }
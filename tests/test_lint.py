@@ -0,0 +1,73 @@
+"""Diagnostics read back from the lint process."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.lint import (
+    Diagnostic,
+    diagnostics_from_row,
+    format_diagnostics,
+    lint_goal,
+    parse_lint_output,
+    syntax_call,
+)
+
+DATA = "/data"
+
+
+def rows(*payloads):
+    return "".join(f"@MCP lint SOLUTION {json.dumps(payload)}\n" for payload in payloads)
+
+
+def test_located_warning_is_relative_to_the_data_directory():
+    row = {"severity": "warning", "file": "/data/kb/family.pl", "line": 3, "message": "Singleton variables: [X]"}
+
+    assert diagnostics_from_row(row, DATA) == [Diagnostic("kb/family.pl", 3, "warning", "Singleton variables: [X]")]
+
+
+def test_syntax_error_takes_its_location_from_the_message():
+    row = {"severity": "error", "message": "/data/kb.pl:7:12: Syntax error: Operator expected\n"}
+
+    assert diagnostics_from_row(row, DATA) == [Diagnostic("kb.pl", 7, "error", "Syntax error: Operator expected")]
+
+
+def test_check_report_gives_one_diagnostic_per_location():
+    row = {"message": "Unknown procedure: grand/2\n  called from\n/data/kb.pl:4:8: 1-st clause of report/0\n"
+                      "/data/other.pl:9: 2-nd clause of audit/1"}
+
+    assert diagnostics_from_row(row, DATA) == [
+        Diagnostic("kb.pl", 4, "warning", "called from 1-st clause of report/0"),
+        Diagnostic("other.pl", 9, "warning", "called from 2-nd clause of audit/1"),
+    ]
+    assert diagnostics_from_row({"message": "Something odd"}, DATA) == [Diagnostic("", 0, "warning", "Something odd")]
+
+
+def test_output_without_end_or_with_an_error_fails():
+    stdout = "user chatter\n" + rows({"file": "/data/kb.pl", "line": 1, "message": "Clauses not together"})
+
+    assert len(parse_lint_output(stdout + "@MCP lint END\n", DATA)) == 1
+    with pytest.raises(RuntimeError, match="exited before reporting"):
+        parse_lint_output(stdout, DATA)
+    with pytest.raises(RuntimeError, match="no such file"):
+        parse_lint_output("@MCP lint ERROR no such file\n", DATA)
+
+
+def test_format_lists_the_file_itself_first():
+    diagnostics = [
+        Diagnostic("other.pl", 2, "warning", "Clauses not together"),
+        Diagnostic("kb.pl", 9, "error", "Syntax error:\nOperator expected"),
+    ]
+
+    assert format_diagnostics("kb.pl", []) == "✅ kb.pl: no warnings or errors"
+    assert format_diagnostics("kb.pl", diagnostics).splitlines() == [
+        "🔎 kb.pl: 1 error(s), 1 warning(s)",
+        "  ❌ kb.pl:9: Syntax error:",
+        "     Operator expected",
+        "  ⚠️ other.pl:2: Clauses not together",
+    ]
+
+
+def test_calls():
+    assert lint_goal("/data/it's.pl") == "mcp_lint(lint, '/data/it\\'s.pl')"
+    assert syntax_call('a("x").\n') == ("mcp_syntax_check", ['"a(\\"x\\").\\n"'])
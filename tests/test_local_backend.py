@@ -0,0 +1,61 @@
+"""The host swipl backend, with a shell script standing in for swipl."""
+
+import logging
+
+import pytest
+
+from docker_swish_mcp.local_backend import (
+    LocalBackendError,
+    LocalProcessClient,
+    find_swipl,
+    parse_swipl_version,
+)
+
+
+def fake_swipl(tmp_path, version="9.2.9"):
+    """An executable answering --version and echoing its arguments and directory otherwise."""
+    script = tmp_path / "swipl"
+    script.write_text(
+        "#!/bin/sh\n"
+        f'if [ "$1" = --version ]; then echo "SWI-Prolog version {version} for x86_64-linux"; exit 0; fi\n'
+        'echo "$PWD $*"\n',
+        encoding="utf-8",
+    )
+    script.chmod(0o755)
+    return str(script)
+
+
+@pytest.mark.parametrize("text, version", [
+    ("SWI-Prolog version 9.2.9 for x86_64-linux", (9, 2, 9)),
+    ("swipl 10.0.0", (10, 0, 0)),
+    ("SWI-Prolog version unknown", None),
+])
+def test_parse_swipl_version(text, version):
+    assert parse_swipl_version(text) == version
+
+
+def test_missing_swipl_is_reported(tmp_path):
+    with pytest.raises(LocalBackendError, match="not found on PATH"):
+        find_swipl(str(tmp_path / "no-swipl"))
+
+
+def test_ping_records_the_version(tmp_path, caplog):
+    client = LocalProcessClient(tmp_path, fake_swipl(tmp_path, "8.4.1"))
+
+    with caplog.at_level(logging.WARNING):
+        assert client.ping()
+
+    assert client.version == (8, 4, 1)
+    assert client.version_text == "8.4.1"
+    assert "older than 9.0.0" in caplog.text
+
+
+async def test_commands_run_in_the_data_directory(tmp_path):
+    data_dir = tmp_path / "data"
+    data_dir.mkdir()
+    client = LocalProcessClient(data_dir, fake_swipl(tmp_path))
+
+    process = await client.open_exec("ignored", ["swipl", "-q", "-g", "halt"], stdin=False)
+    stdout, _stderr = await process.communicate()
+
+    assert stdout.decode().strip() == f"{data_dir} -q -g halt"
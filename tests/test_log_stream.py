@@ -0,0 +1,90 @@
+"""Reading and following the container log."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp.log_stream import LogFollower, read_logs, stream_options
+
+
+class FakeContainer:
+    def __init__(self, log=b"", chunks=()):
+        self.log = log
+        self.chunks = list(chunks)
+        self.calls = []
+
+    def logs(self, **kwargs):
+        self.calls.append(kwargs)
+        if kwargs.get("stream"):
+            return FakeStream(self.chunks)
+        return self.log
+
+
+class FakeStream:
+    def __init__(self, chunks):
+        self.chunks = chunks
+        self.closed = False
+
+    def __iter__(self):
+        return iter(self.chunks)
+
+    def close(self):
+        self.closed = True
+
+
+LOG = b"10:00 started\n10:01 warning: low memory\n10:02 served /\n10:03 warning: slow query\n"
+
+
+def test_last_lines_are_read_with_timestamps():
+    container = FakeContainer(LOG)
+
+    assert read_logs(container, 2) == ["10:02 served /", "10:03 warning: slow query"]
+    assert container.calls == [{"tail": 2, "timestamps": True, "stdout": True, "stderr": True}]
+
+
+def test_grep_reads_the_whole_log():
+    container = FakeContainer(LOG)
+
+    assert read_logs(container, 5, stream="stderr", grep="warning") == [
+        "10:01 warning: low memory", "10:03 warning: slow query",
+    ]
+    assert container.calls[0]["tail"] == "all" and not container.calls[0]["stdout"]
+    assert read_logs(container, 0) == []
+
+
+@pytest.mark.parametrize("stream, grep, message", [
+    ("errors", "", "Unknown log stream 'errors'"),
+    ("both", "(", "Invalid grep pattern"),
+])
+def test_bad_options_are_refused(stream, grep, message):
+    with pytest.raises(ValueError, match=message):
+        read_logs(FakeContainer(), 10, stream=stream, grep=grep)
+    assert stream_options("stdout") == {"stdout": True, "stderr": False}
+
+
+async def test_follower_notifies_subscribers_about_new_output():
+    notified = []
+
+    async def notify():
+        notified.append(True)
+
+    follower = LogFollower(notify, lambda: True, interval=0.01)
+    container = FakeContainer(chunks=[b"line 1\nline 2\n"])
+
+    await follower._follow(container)
+
+    assert notified == [True]
+    assert container.calls == [{"stream": True, "follow": True, "tail": 0}]
+
+
+async def test_follower_waits_while_nobody_subscribes():
+    container = FakeContainer(chunks=[b"line\n"])
+
+    async def notify():
+        raise AssertionError("notified without subscribers")
+
+    watch = asyncio.ensure_future(LogFollower(notify, lambda: False, interval=0.01).watch(lambda: container))
+    await asyncio.sleep(0.05)
+    watch.cancel()
+
+    assert container.calls == []
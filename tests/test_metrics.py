@@ -0,0 +1,79 @@
+"""The Prometheus text rendering and the hooks that feed it."""
+
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.metrics import (
+    Counter,
+    Histogram,
+    ServerMetrics,
+    TransportMetricsMiddleware,
+    instrument_tool_calls,
+    query_outcome,
+)
+
+
+def test_counter_renders_escaped_labels():
+    counter = Counter("calls_total", "Calls", ("tool",))
+    counter.inc(tool='say "hi"')
+    counter.inc(2, tool='say "hi"')
+
+    assert counter.render() == '# HELP calls_total Calls\n# TYPE calls_total counter\ncalls_total{tool="say \\"hi\\""} 3'
+
+
+def test_histogram_buckets_are_cumulative():
+    histogram = Histogram("latency_seconds", "Latency", buckets=(1, 0.1))
+    histogram.observe(0.05)
+    histogram.observe(0.5)
+    histogram.observe(2.5)
+
+    assert histogram.samples() == [
+        'latency_seconds_bucket{le="0.1"} 1',
+        'latency_seconds_bucket{le="1"} 2',
+        'latency_seconds_bucket{le="+Inf"} 3',
+        "latency_seconds_sum 3.05",
+        "latency_seconds_count 3",
+    ]
+
+
+@pytest.mark.parametrize("error, solutions, outcome", [
+    (None, 2, "success"),
+    (None, 0, "failure"),
+    ("time_limit_exceeded", 0, "timeout"),
+    ("existence_error(procedure, foo/0)", 0, "error"),
+])
+def test_query_outcome(error, solutions, outcome):
+    assert query_outcome(error, solutions) == outcome
+
+
+async def test_tool_calls_are_counted_by_status():
+    async def call_tool(name, arguments):
+        if name == "boom":
+            raise RuntimeError(name)
+        return "❌ refused" if name == "refuse" else "ok"
+
+    server = SimpleNamespace(_tool_manager=SimpleNamespace(call_tool=call_tool))
+    metrics = ServerMetrics()
+    instrument_tool_calls(server, metrics)
+
+    await server._tool_manager.call_tool("query", {})
+    await server._tool_manager.call_tool("refuse", {})
+    with pytest.raises(RuntimeError):
+        await server._tool_manager.call_tool("boom", {})
+
+    assert metrics.tool_calls.values == {("query", "ok"): 1, ("refuse", "error"): 1, ("boom", "exception"): 1}
+    assert "swish_mcp_tool_duration_seconds_count{tool=\"boom\"} 1" in metrics.render()
+
+
+async def test_transport_errors_are_counted():
+    async def app(scope, receive, send):
+        await send({"type": "http.response.start", "status": 404})
+
+    async def send(message):
+        pass
+
+    metrics = ServerMetrics()
+    await TransportMetricsMiddleware(app, metrics, "http")({"type": "http"}, None, send)
+
+    assert metrics.transport_errors.values == {("http", "http_404"): 1}
@@ -0,0 +1,66 @@
+"""The mock backend's interpreter, through the tools that drive it."""
+
+import re
+
+from docker_swish_mcp import main
+
+
+async def test_facts_and_rules(swish):
+    await main.execute_prolog_query(
+        "assertz(parent(tom, bob)), assertz(parent(bob, ann)), "
+        "assertz((grand(X, Z) :- parent(X, Y), parent(Y, Z)))"
+    )
+
+    assert "Who = ann" in await main.execute_prolog_query("grand(tom, Who)")
+    assert '"value": "ann"' in await main.execute_prolog_query("grand(tom, Who)", output_format="json")
+
+
+async def test_builtins(swish):
+    assert "L = [a,b,c]" in await main.execute_prolog_query("setof(X, member(X, [c,a,b,a]), L)")
+    assert "X = 2, Y = 2" in await main.execute_prolog_query("( member(X, [1,2,3]), X > 1 -> Y = X ; Y = none )")
+    assert "E = evaluation_error(zero_divisor)" in await main.execute_prolog_query(
+        "catch(X is 1/0, error(E, _), true)"
+    )
+    assert "true" in await main.execute_prolog_query("phrase(([a], [b]), [a, b])")
+
+
+async def test_output_is_captured(swish):
+    answer = await main.execute_prolog_query('atom_length(abc, N), format("~w~n", [N])')
+
+    assert "N = 3" in answer
+    assert "🖨️ Output:\n3" in answer
+
+
+async def test_syntax_error_has_position(swish):
+    answer = await main.execute_prolog_query("X = f(")
+
+    assert '"kind": "syntax_error"' in answer
+    assert '"column": 7' in answer
+
+
+async def test_inference_limit_stops_runaway_query(swish):
+    session = swish.prolog_session
+    generation = session.generation
+
+    answer = await main.execute_prolog_query("length(L, N), N > 100000000", inference_limit=10000)
+
+    assert "inference_limit_exceeded(10000)" in answer
+    assert session.generation == generation
+
+
+async def test_cursor_pages_through_solutions(swish):
+    first = await main.execute_prolog_query("between(1, 5, X)", limit=2)
+    cursor = re.search(r'cursor="(\w+)"', first).group(1)
+
+    second = await main.execute_prolog_query("", cursor=cursor, limit=2)
+
+    assert "X = 2" in first and "X = 3" not in first
+    assert "X = 3" in second and "X = 4" in second
+
+
+async def test_consults_data_directory_files(swish):
+    (swish.data_dir / "colors.pl").write_text("color(red).\ncolor(green).\n", encoding="utf-8")
+
+    await main.execute_prolog_query("consult(colors)")
+
+    assert "L = [red,green]" in await main.execute_prolog_query("findall(C, color(C), L)")
@@ -0,0 +1,43 @@
+"""Which module a client's goals run in."""
+
+import pytest
+
+from docker_swish_mcp.namespaces import (
+    ModuleTable,
+    in_module,
+    private_module,
+    validate_shared_name,
+)
+
+
+def test_private_modules_are_stable_and_distinct():
+    module = private_module("Alice@Example.org")
+
+    assert module.startswith("client_alice_example_org_")
+    assert module == private_module("Alice@Example.org")
+    assert module != private_module("alice@example.org")
+    assert private_module("✨").startswith("client_anon_")
+
+
+def test_clients_share_and_leave_modules():
+    table = ModuleTable(enabled=True)
+
+    assert table.share("alice", " team ") == "team"
+    table.share("bob", "team")
+    table.share("carol", "user")
+
+    assert table.members("team") == ["alice", "bob"]
+    assert table.module_for("carol") == "user"
+    assert table.leave("alice") == private_module("alice")
+    assert ModuleTable().module_for("alice") == "user"
+
+
+@pytest.mark.parametrize("name", ["Team", "system", "client_x", "9lives", "a-b"])
+def test_unsafe_shared_names_are_refused(name):
+    with pytest.raises(ValueError):
+        validate_shared_name(name)
+
+
+def test_goals_are_qualified_unless_they_run_in_user():
+    assert in_module("assertz(a), b", "team") == "'team':(assertz(a), b)"
+    assert in_module("assertz(a)", "user") == "assertz(a)"
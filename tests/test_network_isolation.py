@@ -0,0 +1,106 @@
+"""Network profiles, and the allowlist proxy that is the container's only way out."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp.network_isolation import (
+    INTERNAL_NETWORK,
+    AllowlistProxy,
+    NetworkProfile,
+    format_network_status,
+    host_allowed,
+    parse_allow,
+)
+
+ALLOW = ("pypi.org", "*.swi-prolog.org", "example.org:443")
+
+
+def test_parse_allow():
+    assert parse_allow("PyPI.org, *.swi-prolog.org,,example.org:443, pypi.org") == ALLOW
+    assert parse_allow(["a.org"]) == ("a.org",)
+    with pytest.raises(ValueError, match="invalid host 'http://a.org'"):
+        parse_allow("http://a.org")
+
+
+@pytest.mark.parametrize("host, port, allowed", [
+    ("pypi.org", 80, True),
+    ("files.pypi.org", 443, False),
+    ("www.swi-prolog.org", 443, True),
+    ("swi-prolog.org", 443, False),
+    ("example.org", 443, True),
+    ("example.org", 80, False),
+    ("PYPI.ORG.", 443, True),
+])
+def test_host_allowed(host, port, allowed):
+    assert host_allowed(host, port, ALLOW) == allowed
+
+
+def test_profiles_become_run_options():
+    allowlist = NetworkProfile.from_settings({"network": "allowlist", "network_allow": "pypi.org", "network_proxy_port": "3129"})
+
+    assert (str(allowlist), allowlist.proxy_port, allowlist.isolated) == ("allowlist pypi.org", 3129, True)
+    options = allowlist.run_options("http://172.20.0.1:3129")
+    assert options["network"] == INTERNAL_NETWORK
+    assert options["environment"]["https_proxy"] == "http://172.20.0.1:3129"
+    assert NetworkProfile.from_settings({"network": "none"}).run_options() == {"network_mode": "none"}
+    assert NetworkProfile().run_options() == {} and NetworkProfile().publishes_port
+    with pytest.raises(ValueError, match="network must be one of"):
+        NetworkProfile.from_settings({"network": "host"})
+    with pytest.raises(ValueError, match="network_proxy_port must be a port number"):
+        NetworkProfile.from_settings({"network_proxy_port": 70000})
+
+
+def test_parse_request():
+    assert AllowlistProxy.parse_request("CONNECT", "[::1]:443", "HTTP/1.1", []) == ("::1", 443, None)
+    host, port, request = AllowlistProxy.parse_request(
+        "GET", "http://pypi.org/simple/?q=1", "HTTP/1.1",
+        ["Host: pypi.org", "Proxy-Authorization: x", "Connection: keep-alive", ""],
+    )
+    assert (host, port) == ("pypi.org", 80)
+    assert request == b"GET /simple/?q=1 HTTP/1.1\r\nHost: pypi.org\r\nConnection: close\r\n\r\n"
+    with pytest.raises(ValueError, match="not an absolute http URL"):
+        AllowlistProxy.parse_request("GET", "/simple/", "HTTP/1.1", [])
+
+
+def test_addresses_need_an_explicit_pattern():
+    proxy = AllowlistProxy(("*.example.org", "10.0.0.5:80"), "127.0.0.1")
+
+    assert proxy.allowed("10.0.0.5", 80) and not proxy.allowed("10.0.0.5", 22)
+    assert not proxy.allowed("10.0.0.6", 80)
+
+
+async def exchange(proxy, request):
+    reader, writer = await asyncio.open_connection(proxy.host, proxy.server.sockets[0].getsockname()[1])
+    writer.write(request)
+    await writer.drain()
+    answer = await asyncio.wait_for(reader.read(), 5)
+    writer.close()
+    return answer
+
+
+async def test_proxy_relays_allowed_hosts_and_refuses_the_rest():
+    async def upstream(reader, writer):
+        await reader.readuntil(b"\r\n\r\n")
+        writer.write(b"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+        await writer.drain()
+        writer.close()
+
+    server = await asyncio.start_server(upstream, "127.0.0.1", 0)
+    port = server.sockets[0].getsockname()[1]
+    proxy = AllowlistProxy(("127.0.0.1",), "127.0.0.1", 0)
+    await proxy.start()
+    try:
+        relayed = await exchange(proxy, f"GET http://127.0.0.1:{port}/ HTTP/1.1\r\nHost: x\r\n\r\n".encode())
+        refused = await exchange(proxy, b"CONNECT evil.example:443 HTTP/1.1\r\n\r\n")
+        bad = await exchange(proxy, b"nonsense\r\n\r\n")
+    finally:
+        await proxy.stop()
+        server.close()
+
+    assert relayed.endswith(b"\r\n\r\nok")
+    assert refused.startswith(b"HTTP/1.1 403 Forbidden") and b"evil.example:443 is not in" in refused
+    assert bad.startswith(b"HTTP/1.1 400 Bad Request")
+    assert (proxy.stats.allowed, proxy.stats.denied) == (1, 1)
+    status = format_network_status(NetworkProfile("allowlist", ("127.0.0.1",)), "172.20.0.2", "http://172.20.0.2:3050", proxy)
+    assert "1 connection(s) let through, 1 refused" in status and status.endswith("refused evil.example:443")
@@ -0,0 +1,83 @@
+"""Reading and writing .swinb notebooks."""
+
+import pytest
+
+from docker_swish_mcp.notebooks import (
+    NotebookCell,
+    NotebookError,
+    insert_cell,
+    load_notebook,
+    make_cells,
+    new_cell,
+    parse_swinb,
+    render_swinb,
+    save_notebook,
+    write_program,
+)
+
+
+def test_cells_are_named_like_swish():
+    cells = make_cells([
+        {"type": "markdown", "text": "# Family"},
+        {"type": "program", "text": "parent(tom, bob)."},
+        {"type": "query", "text": "parent(X, Y)", "name": "q1"},
+        {"type": "Query", "text": "parent(tom, Y)"},
+    ])
+
+    assert [(cell.type, cell.name) for cell in cells] == [
+        ("markdown", "md1"), ("program", "p1"), ("query", "q1"), ("query", "q2"),
+    ]
+
+
+@pytest.mark.parametrize("raw, message", [
+    ([{"type": "python", "text": "1"}], "Cell 1: Unknown cell type 'python'"),
+    ([{"type": "query", "text": "a", "name": "q 1"}], "Cell 1: Invalid cell name"),
+    ([{"type": "query", "text": "a", "name": "q"}, {"type": "program", "text": "b", "name": "q"}], "unique"),
+])
+def test_malformed_cells_are_refused(raw, message):
+    with pytest.raises(NotebookError, match=message):
+        make_cells(raw)
+
+
+def test_swinb_round_trip_keeps_text_and_markup(tmp_path):
+    cells = make_cells([
+        {"type": "program", "text": "less(X, Y) :- X < Y, Y > 0 & true."},
+        {"type": "html", "text": "<div><b>nested</b></div>"},
+    ])
+
+    save_notebook(tmp_path, "demo", cells)
+    loaded = load_notebook(tmp_path, "demo")
+
+    assert "X &lt; Y" in render_swinb(cells)
+    assert loaded == cells
+
+
+def test_legacy_json_notebooks_are_read():
+    cells = parse_swinb('{"cells": [{"type": "program", "content": "a."}, {"type": "chart", "content": "x"}]}')
+
+    assert [(cell.type, cell.name, cell.text) for cell in cells] == [("program", "p1", "a."), ("html", "h1", "x")]
+
+
+def test_insert_cell_refuses_duplicate_names():
+    cells = insert_cell([NotebookCell("query", "a", "q1")], new_cell("query", "b"), 0)
+
+    assert [cell.name for cell in cells] == ["q2", "q1"]
+    with pytest.raises(NotebookError, match="already exists"):
+        insert_cell(cells, new_cell("program", "c", "q1"))
+
+
+def test_write_program_collects_program_cells(tmp_path):
+    cells = make_cells([
+        {"type": "program", "text": "a."}, {"type": "query", "text": "a"}, {"type": "program", "text": "b."},
+    ])
+
+    assert write_program(tmp_path, "demo", cells) == "notebooks/demo"
+    source = (tmp_path / "notebooks" / "demo.pl").read_text(encoding="utf-8")
+    assert "% ---- cell p1 ----\na." in source and "% ---- cell p2 ----\nb." in source
+    assert write_program(tmp_path, "demo", cells[1:2]) is None
+    assert not (tmp_path / "notebooks" / "demo.pl").exists()
+
+
+def test_load_refuses_unsafe_names(tmp_path):
+    with pytest.raises(NotebookError, match="Invalid notebook name"):
+        load_notebook(tmp_path, "../etc/passwd")
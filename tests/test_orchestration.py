@@ -0,0 +1,41 @@
+"""Cluster specs: validation and the defaults they resolve."""
+
+import json
+from pathlib import Path
+
+import pytest
+
+from docker_swish_mcp.orchestration import load_cluster_spec, parse_cluster_spec
+
+
+def test_defaults_fill_free_ports_and_data_dirs():
+    spec = {"instances": [{"name": "a"}, {"name": "b", "port": 3051}, {"name": "c"}]}
+
+    instances = parse_cluster_spec(spec, Path("/srv"))
+
+    assert [(i.name, i.port) for i in instances] == [("a", 3052), ("b", 3051), ("c", 3053)]
+    assert instances[0].data_dir == Path("/srv/swish-data-a")
+    assert instances[0].container_name == "swish-mcp-a"
+
+
+@pytest.mark.parametrize("spec, error", [
+    ({}, "non-empty 'instances' list"),
+    ({"instances": ["a"]}, "must be objects"),
+    ({"instances": [{"name": "Tenant"}]}, "Invalid instance name 'Tenant'"),
+    ({"instances": [{"name": "a"}, {"name": "a"}]}, "Duplicate instance name 'a'"),
+    ({"instances": [{"name": "a", "port": 3050}]}, "Port 3050 for instance 'a' is already taken"),
+])
+def test_malformed_specs_are_refused(spec, error):
+    with pytest.raises(ValueError, match=error):
+        parse_cluster_spec(spec, Path("/srv"))
+
+
+def test_spec_loads_from_a_file(tmp_path):
+    path = tmp_path / "cluster.json"
+    path.write_text(json.dumps({"instances": [{"name": "kb", "data_dir": str(tmp_path / "kb")}]}), encoding="utf-8")
+
+    [instance] = load_cluster_spec(str(path), tmp_path)
+
+    assert instance.data_dir == tmp_path / "kb"
+    with pytest.raises(ValueError, match="not valid JSON"):
+        load_cluster_spec("{nope", tmp_path)
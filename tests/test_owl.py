@@ -0,0 +1,37 @@
+"""The owl_import call and its report."""
+
+import pytest
+
+from docker_swish_mcp.owl import format_owl_import, owl_content_format, owl_import_call
+
+
+def test_inline_content_format():
+    assert owl_content_format('  <?xml version="1.0"?><rdf:RDF/>') == "xml"
+    assert owl_content_format("@prefix : <http://example.org/> .") == "turtle"
+
+
+def test_import_call():
+    assert owl_import_call("zoo", "http://example.org/zoo", "local", True) == (
+        "mcp_owl_import", ["'zoo'", "'http://example.org/zoo'", "local", "true"],
+    )
+    with pytest.raises(ValueError, match="Unknown names 'short'. Use: local, iri"):
+        owl_import_call("zoo", "g", "short", False)
+
+
+def test_report_lists_converted_facts_and_skipped_triples():
+    report = {
+        "graph": "zoo.owl", "triples": 12, "skipped": 2, "rules_added": True,
+        "facts": {"owl_subclass": 3, "owl_type": 5, "owl_disjoint": 0},
+    }
+
+    lines = format_owl_import("zoo.owl", report, replace=True).splitlines()
+
+    assert lines[:5] == [
+        "🦉 Imported zoo.owl (graph 'zoo.owl', 12 triples)",
+        "📊 8 fact(s) in the knowledge base, replacing the previous ontology facts",
+        "   • owl_subclass: 3",
+        "   • owl_type: 5",
+        "⏭️ 2 triple(s) about blank nodes (restrictions, class expressions) were not converted",
+    ]
+    assert lines[5] == "🧠 Reasoning predicates added:"
+    assert "   • owl_instance_of(I, C): " in "\n".join(lines)
@@ -0,0 +1,40 @@
+"""Pack goals and the listing they print."""
+
+import pytest
+
+from docker_swish_mcp.packs import install_goal, list_goal, parse_pack_list, remove_goal
+
+
+def test_install_goal():
+    assert install_goal("func") == "pack_install(func, [interactive(false), silent(true)])"
+    assert install_goal("tabling_x", "https://github.com/x/tabling_x.git", upgrade=True) == (
+        "pack_install('https://github.com/x/tabling_x.git', "
+        "[interactive(false), silent(true), upgrade(true), pack(tabling_x)])"
+    )
+
+
+@pytest.mark.parametrize("name, url", [
+    ("Func", ""),
+    ("func), shell(ls", ""),
+    ("", "http://example.com/p.zip"),
+    ("", "https://example.com/p'.zip"),
+])
+def test_unsafe_names_and_urls_are_refused(name, url):
+    with pytest.raises(ValueError):
+        install_goal(name, url)
+
+
+def test_remove_goal_falls_back_to_pack_remove_1():
+    assert remove_goal("func").endswith("pack_remove(func))")
+    with pytest.raises(ValueError, match="Invalid pack name"):
+        remove_goal("a b")
+
+
+def test_parse_pack_list_skips_other_lines():
+    stdout = "Warning: something\nPACK\tyall\t1.0\tLambda expressions\nPACK\tfunc\t0.4.2\n"
+
+    assert parse_pack_list(stdout) == [
+        {"name": "func", "version": "0.4.2", "title": ""},
+        {"name": "yall", "version": "1.0", "title": "Lambda expressions"},
+    ]
+    assert "PACK\\t~w" in list_goal()
@@ -0,0 +1,73 @@
+"""Pengine book-keeping against a scripted pengine API."""
+
+import pytest
+
+from docker_swish_mcp.pengines import PengineError, PengineManager, format_answer
+
+
+def scripted(manager, replies):
+    """Answer the manager's POSTs from replies, recording (path, event) pairs."""
+    sent = []
+
+    async def post(path, *args, **kwargs):
+        sent.append((path, kwargs.get("data", b"").decode().strip()))
+        return replies.pop(0)
+
+    manager._post = post
+    return sent
+
+
+async def test_next_resumes_an_open_query():
+    manager = PengineManager("http://swish")
+    sent = scripted(manager, [
+        {"event": "create", "id": "p1", "answer": {"event": "success", "data": [{"X": 1}], "more": True}},
+        {"event": "success", "data": [{"X": 2}], "more": False},
+    ])
+
+    state, _answer = await manager.create("alice", query="member(X, [1, 2])")
+    answer = await manager.next("alice", "p1")
+
+    assert sent[-1] == ("send", "next.")
+    assert format_answer(answer) == "📋 Results:\n  • X = 2\n\n💡 No more solutions"
+    assert state.solutions_seen == 2 and not state.more
+    with pytest.raises(PengineError, match="No open query"):
+        await manager.next("alice", "p1")
+
+
+async def test_pengines_belong_to_their_client():
+    manager = PengineManager("http://swish", max_per_client=1)
+    scripted(manager, [{"event": "create", "id": "p1"}])
+
+    await manager.create("alice")
+
+    assert [state.pengine_id for state in manager.list_for("alice")] == ["p1"]
+    with pytest.raises(PengineError, match="Unknown pengine"):
+        manager.get("bob", "p1")
+    with pytest.raises(PengineError, match="already owns 1 pengines"):
+        await manager.create("alice")
+
+
+async def test_ask_refuses_while_a_query_is_open():
+    manager = PengineManager("http://swish")
+    scripted(manager, [
+        {"event": "create", "id": "p1", "answer": {"event": "success", "data": [{}], "more": True}},
+        {"event": "stop"},
+        {"event": "failure"},
+    ])
+    await manager.create("alice", query="repeat")
+
+    with pytest.raises(PengineError, match="still has an open query"):
+        await manager.ask("alice", "p1", "true")
+    await manager.stop("alice", "p1")
+
+    assert format_answer(await manager.ask("alice", "p1", "fail.")) == "📋 Result: false (no more solutions)"
+    assert manager.get("alice", "p1").query == "fail"
+
+
+@pytest.mark.parametrize("answer, text", [
+    ({"event": "success", "data": [{}], "more": True}, "📋 Results:\n  • true\n\n💡 More solutions available"),
+    ({"event": "destroy", "data": {"event": "error", "data": "boom"}}, "📋 Error: boom"),
+    ({"event": "stop"}, "📋 Query stopped"),
+])
+def test_format_answer(answer, text):
+    assert format_answer(answer).startswith(text)
@@ -0,0 +1,57 @@
+"""Predicate specs, PlDoc comments and the markdown describe_predicate returns."""
+
+import pytest
+
+from docker_swish_mcp.predicate_docs import (
+    comment_body,
+    describe_call,
+    format_predicate,
+    parse_spec,
+)
+
+
+@pytest.mark.parametrize("text, spec", [
+    ("foldl/4", ("", "foldl", 4)),
+    ("lists:append", ("lists", "append", -1)),
+    ("greeting//1", ("", "greeting", 3)),
+    ("'it''s'/0", ("", "it's", 0)),
+])
+def test_parse_spec(text, spec):
+    assert parse_spec(text) == spec
+
+
+def test_describe_call():
+    assert describe_call("it's", 2, "team") == ("mcp_describe", ["'team'", "'it\\'s'", "2"])
+
+
+def test_comment_body_skips_the_mode_lines():
+    assert comment_body("%!  grand(?A, ?C) is nondet.\n%\n%   True when A is a grandparent\n%   of C.\n") == (
+        "True when A is a grandparent\nof C."
+    )
+    assert comment_body("/** grand(A, C)\n *\n * Grandparents.\n */") == "Grandparents."
+
+
+def test_user_predicate_section():
+    row = {
+        "module": "user", "predicate": "grand/2", "file": "/data/family.pl", "line": 4, "clauses": 1,
+        "properties": ["dynamic"], "modes": [{"mode": "grand(?A, ?C)", "det": "nondet"}],
+        "summary": "Grandparents.", "comment": "%!  grand(?A, ?C) is nondet.\n%\n%   Through parent/2.",
+    }
+
+    assert format_predicate(row, "/data") == "\n\n".join([
+        "### `user:grand/2`",
+        "- `grand(?A, ?C)` is **nondet**",
+        "Grandparents.",
+        "- **Source:** `family.pl:4`\n- **Properties:** dynamic\n- **Clauses:** 1",
+        "Through parent/2.",
+    ])
+
+
+def test_built_in_section():
+    row = {"module": "system", "predicate": "atom_length/2", "properties": ["built_in", "static"], "clauses": 1}
+
+    assert format_predicate(row, "/data") == "\n\n".join([
+        "### `system:atom_length/2`",
+        "- **Source:** built-in\n- **Properties:** static",
+        "_No PlDoc comment._",
+    ])
@@ -0,0 +1,51 @@
+"""Probabilities read back from the cplint process."""
+
+import pytest
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.probabilistic import (
+    format_probabilities,
+    lpad_program,
+    parse_prob_output,
+    prob_goal,
+)
+
+
+def test_program_is_wrapped_in_lpad_directives():
+    lines = lpad_program("heads(C):0.5 ; tails(C):0.5 :- toss(C).").splitlines()
+
+    assert lines[0] == ":- use_module(library(pita))."
+    assert lines[-3:] == [":- begin_lpad.", "heads(C):0.5 ; tails(C):0.5 :- toss(C).", ":- end_lpad."]
+
+
+def test_goal_quotes_the_query():
+    limits = QueryLimits()
+
+    assert prob_goal('heads("c")', limits) == f'mcp_prob(prob, "heads(\\"c\\")", {limits.to_prolog()})'
+
+
+def test_rows_are_read_until_end():
+    stdout = (
+        'noise\n@MCP prob SOLUTION {"bindings": {"C": {"type": "atom", "value": "coin"}}, "probability": 0.25}\n'
+        '@MCP prob SOLUTION {"bindings": {"C": {"type": "atom", "value": "Big Coin"}}, "probability": 0.5}\n'
+        "@MCP prob END\n"
+    )
+
+    rows = parse_prob_output(stdout)
+
+    assert format_probabilities("heads(C)", rows).splitlines() == [
+        "🎲 Query: heads(C)",
+        "📋 2 solution(s):",
+        "  • P = 0.5  (C = 'Big Coin')",
+        "  • P = 0.25  (C = coin)",
+    ]
+    with pytest.raises(RuntimeError, match="exited before reporting"):
+        parse_prob_output(stdout.replace("@MCP prob END\n", ""))
+    with pytest.raises(RuntimeError, match="pita: no such"):
+        parse_prob_output("@MCP prob ERROR pita: no such\n")
+
+
+def test_no_instances():
+    assert format_probabilities("heads(x)", []) == (
+        "❌ Query: heads(x)\n📋 No instances of the query were found in the program"
+    )
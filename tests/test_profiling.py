@@ -0,0 +1,52 @@
+"""Profiler reports as per-predicate times and call counts."""
+
+import pytest
+
+from docker_swish_mcp.profiling import format_profile, outcome_text, profile_entries
+
+REPORT = {
+    "outcome": True,
+    "bindings": {"N": 10},
+    "summary": {"ticks": 200, "time": 2.0, "samples": 200},
+    "nodes": [
+        {"predicate": "fib/2", "calls": 177, "redos": 0, "exits": 177, "ticks_self": 150, "ticks_children": 40},
+        {"predicate": "plus/3", "calls": 500, "redos": 0, "exits": 500, "ticks_self": 40},
+        {"predicate": "lists:member/2", "calls": 3, "redos": 4, "exits": 5, "ticks_self": 0},
+        {"predicate": "user:mcp_profile/3", "calls": 1, "exits": 1, "ticks_self": 10, "ticks_children": 190},
+    ],
+}
+
+
+def test_ticks_are_scaled_to_the_cpu_time():
+    entries = profile_entries(REPORT)
+
+    assert [e.predicate for e in entries] == ["fib/2", "plus/3", "lists:member/2"]
+    fib = entries[0]
+    assert (fib.inclusive, fib.exclusive, fib.inclusive_percent, fib.exclusive_percent) == (1.9, 1.5, 95.0, 75.0)
+    assert entries[2].fails == 2 and entries[2].to_json()["fails"] == 2
+
+
+def test_sort_keys_and_top():
+    assert [e.predicate for e in profile_entries(REPORT, "calls", top=2)] == ["plus/3", "fib/2"]
+    with pytest.raises(ValueError, match="Unknown sort_by 'name'"):
+        profile_entries(REPORT, "name")
+    with pytest.raises(ValueError, match="top must be between 1 and 500"):
+        profile_entries(REPORT, top=0)
+
+
+def test_outcomes():
+    assert outcome_text(REPORT) == "✅ Succeeded (1 binding(s))"
+    assert outcome_text({"outcome": False}) == "❌ Failed (no solutions)"
+    assert outcome_text({"outcome": "error", "error": "boom"}) == "💥 Error: boom"
+
+
+def test_format_profile():
+    lines = format_profile("fib(20, N)", REPORT, profile_entries(REPORT, top=1), "inclusive").splitlines()
+
+    assert lines[:3] == [
+        "⏱️ Profile of: fib(20, N).",
+        "✅ Succeeded (1 binding(s))",
+        "📊 200 samples over 2.000s CPU, 4 predicates ran; top 1 by inclusive time",
+    ]
+    assert lines[-1].split() == ["fib/2", "177", "0", "0", "1.900s", "95.0%", "1.500s", "75.0%"]
+    assert format_profile("true", {}, [], "calls").endswith("(no predicates were sampled)")
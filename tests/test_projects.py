@@ -0,0 +1,38 @@
+"""Multi-file projects and their manifests."""
+
+import json
+
+from docker_swish_mcp.projects import (
+    MANIFEST_NAME,
+    consult_targets,
+    create_project,
+    load_manifest,
+    write_file,
+)
+
+
+def test_manifest_drops_missing_and_foreign_files(tmp_path):
+    create_project(tmp_path, "family")
+    write_file(tmp_path, "family", "facts", "parent(a, b).\n")
+    write_file(tmp_path, "family", "rules", "grand(X, Z) :- parent(X, Y), parent(Y, Z).\n")
+    (tmp_path / "secret.pl").write_text("secret.\n", encoding="utf-8")
+    manifest_path = tmp_path / "family" / MANIFEST_NAME
+    raw = json.loads(manifest_path.read_text(encoding="utf-8"))
+    raw["files"] += ["gone.pl", "../secret.pl"]
+    manifest_path.write_text(json.dumps(raw), encoding="utf-8")
+    (tmp_path / "family" / "facts.pl").unlink()
+
+    assert load_manifest(tmp_path, "family").files == ["rules.pl"]
+    assert consult_targets(tmp_path, "family") == ["family/rules"]
+
+
+def test_manifest_name_follows_directory(tmp_path):
+    create_project(tmp_path, "family")
+    manifest_path = tmp_path / "family" / MANIFEST_NAME
+    manifest_path.write_text(json.dumps({"name": "other", "files": []}), encoding="utf-8")
+
+    write_file(tmp_path, "family", "facts", "parent(a, b).\n")
+
+    assert load_manifest(tmp_path, "family").name == "family"
+    assert not (tmp_path / "other").exists()
+    assert json.loads(manifest_path.read_text(encoding="utf-8"))["files"] == ["facts.pl"]
@@ -0,0 +1,81 @@
+"""Session Prolog flags: checked values, module keys and restoring them."""
+
+import pytest
+
+from docker_swish_mcp.prolog_flags import (
+    SessionFlags,
+    flag_rows,
+    flag_value,
+    format_flags,
+    get_flags_call,
+    set_flag_call,
+    set_flag_errors,
+)
+
+
+@pytest.mark.parametrize("name, value, text", [
+    ("double_quotes", " codes ", "codes"),
+    ("unknown", "default", "error"),
+    ("answer_write_options", "[quoted(true), max_depth(20)]", "[quoted(true), max_depth(20)]"),
+])
+def test_flag_value(name, value, text):
+    assert flag_value(name, value) == text
+
+
+@pytest.mark.parametrize("name, value, message", [
+    ("gc", "false", "set_flag does not set 'gc'"),
+    ("occurs_check", "maybe", "Invalid value 'maybe' for occurs_check. Use one of: false, true, error or default"),
+    ("print_write_options", "portray(true)", "takes a list of write options"),
+    ("print_write_options", "[a,\nb]", "takes a list of write options"),
+])
+def test_bad_flag_values(name, value, message):
+    with pytest.raises(ValueError, match=message):
+        flag_value(name, value)
+
+
+def test_module_flags_are_set_in_the_clients_module():
+    assert get_flags_call("team", ["double_quotes", "occurs_check", "bounded"]) == (
+        "mcp_flags_get", ["['team':double_quotes, occurs_check, bounded]"],
+    )
+    assert set_flag_call("team", {"unknown": "fail", "prefer_rationals": "true"}) == (
+        "mcp_flags_set", ["['team':unknown-\"fail\", prefer_rationals-\"true\"]"],
+    )
+    with pytest.raises(ValueError, match="Invalid flag name 'Gc'"):
+        get_flags_call("team", ["Gc"])
+
+
+def test_set_flags_are_restored_until_put_back():
+    flags = SessionFlags()
+    flags.record("team", "double_quotes", "codes")
+    flags.record("team", "occurs_check", "true")
+    flags.record("other", "unknown", "fail")
+    flags.record("other", "unknown", "error")
+
+    assert flags.is_set("team", "double_quotes") and flags.is_set("other", "occurs_check")
+    assert not flags.is_set("other", "unknown")
+    assert flags.restore_calls() == [set_flag_call("team", {"double_quotes": "codes"}), set_flag_call("user", {"occurs_check": "true"})]
+
+
+def test_format_flags():
+    flags = SessionFlags()
+    flags.record("team", "double_quotes", "codes")
+    rows = [
+        {"flag": "double_quotes", "value": "codes"},
+        {"flag": "occurs_check", "value": "true"},
+        {"flag": "print_write_options", "value": "[portray(true), numbervars(true)]"},
+        {"flag": "bounded", "value": "false"},
+    ]
+
+    entries = flag_rows(rows, "team", flags)
+
+    assert [entry["default"] for entry in entries] == [False, False, True, None]
+    assert format_flags(entries, ["nosuch"]).splitlines()[:7] == [
+        "🚩 Prolog flags",
+        "• double_quotes = codes  (module team, set with set_flag)",
+        "• occurs_check = true  (session, changed from its default)",
+        "• print_write_options = [portray(true), numbervars(true)]  (session)",
+        "• bounded = false  (session)",
+        "",
+        "❓ No such flag: nosuch",
+    ]
+    assert set_flag_errors([{"flag": "unknown", "error": "permission"}, {"flag": "occurs_check"}]) == ["unknown: permission"]
@@ -0,0 +1,58 @@
+"""Stack and table space limits, and the prolog_stats report."""
+
+import pytest
+
+from docker_swish_mcp.prolog_memory import PrologMemory, format_stats, parse_size
+
+
+@pytest.mark.parametrize("value, size", [(0, 0), ("4g", 4 * 1024 ** 3), ("512MiB", 512 * 1024 ** 2), (2 ** 20, 2 ** 20)])
+def test_parse_size(value, size):
+    assert parse_size("stack_limit", value) == size
+
+
+@pytest.mark.parametrize("value, message", [
+    ("huge", "must be a size such as '4g' or '512m'"),
+    (True, "must be a size such as '4g'"),
+    ("100k", "at least 1m"),
+    (-1, "at least 1m"),
+])
+def test_bad_sizes_are_refused(value, message):
+    with pytest.raises(ValueError, match=message):
+        parse_size("prolog.stack_limit", value)
+
+
+def test_limits_become_swipl_options():
+    memory = PrologMemory.from_settings({"stack_limit": "4g", "table_space": ""}, PrologMemory(table_space=2 ** 30))
+
+    assert memory.swipl_options() == ["--stack-limit=4294967296", "--table-space=1073741824"]
+    assert memory.describe() == "stack_limit 4.0GiB, table_space 1.0GiB"
+    assert PrologMemory().swipl_options() == [] and PrologMemory().describe() == "SWI-Prolog defaults"
+    with pytest.raises(ValueError, match="Unknown prolog settings"):
+        PrologMemory.from_settings({"global_stack": "1g"})
+
+
+def test_format_stats():
+    report = format_stats({
+        "statistics": {"stack": 256 * 1024 ** 2, "cputime": 1.234, "atoms": 12345, "garbage_collection": [3, 2048, 0.5]},
+        "flags": {"stack_limit": 1024 ** 3},
+    })
+
+    assert report.splitlines() == [
+        "📊 Prolog session statistics",
+        "",
+        "⚙️ Limits: stack_limit 1.0GiB",
+        "",
+        "🥞 Stacks:",
+        "  stack: 256.0MiB",
+        "",
+        "📚 Program:",
+        "  atoms: 12,345",
+        "",
+        "⏱️ Time:",
+        "  cputime: 1.23s",
+        "",
+        "🧹 Garbage collection:",
+        "  garbage_collection: 3 collections, 2.0KiB reclaimed in 0.50s",
+        "",
+        "💡 Stacks use 25.0% of stack_limit",
+    ]
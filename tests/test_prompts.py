@@ -0,0 +1,52 @@
+"""The knowledge base summary carried by the workflow prompts."""
+
+from docker_swish_mcp.prompts import (
+    MAX_LISTING_CHARS,
+    KbContext,
+    debug_goal_prompt,
+    goal_names,
+    listing_goal,
+    undefined_names,
+)
+
+CONTEXT = KbContext(
+    module="team",
+    files=["family.pl"],
+    predicates=[{"node": "parent/2", "clauses": 3, "dynamic": True}, {"node": "grand/2", "clauses": 1}],
+)
+
+
+def test_describe_lists_files_and_predicates():
+    assert CONTEXT.describe().splitlines() == [
+        "Files in the data directory: family.pl",
+        "Predicates defined in module team:",
+        "- grand/2: 1 clause(s)",
+        "- parent/2: 3 clause(s) (dynamic)",
+    ]
+    assert KbContext().describe().splitlines()[-1] == "Predicates defined in module user: none yet"
+    assert "not running" in KbContext(available=False).describe()
+
+
+def test_goal_names_skip_quoted_atoms():
+    assert goal_names("grand(X, Y), member(Y, 'ann(b)'), grand(Y, _)") == ["grand", "member"]
+
+
+def test_only_unknown_names_are_undefined():
+    assert undefined_names("grand(X, Y), findall(Z, sibling(X, Z), L)", CONTEXT) == ["sibling"]
+    assert undefined_names("sibling(X, Y)", KbContext(available=False)) == []
+
+
+def test_debug_prompt_truncates_the_listing():
+    context = KbContext(listing="x" * (MAX_LISTING_CHARS + 10))
+
+    prompt = debug_goal_prompt("sibling(a, b)", context, ["sibling"])
+
+    assert "% ... (truncated)" in prompt
+    assert "**Not defined anywhere**: sibling." in prompt
+    assert "(No clauses found" in debug_goal_prompt("a", KbContext(), [])
+
+
+def test_listing_goal():
+    assert listing_goal(["grand", "parent"], "team") == (
+        "with_output_to(string(Listing), forall(member(N, [grand, parent]), ignore(listing('team':N))))"
+    )
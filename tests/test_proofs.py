@@ -0,0 +1,83 @@
+"""Proof trees of explained query answers."""
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.proofs import (
+    MAX_NODES,
+    explain_call,
+    format_explanations,
+    parse_explanations,
+)
+
+GRANDPARENT = {
+    "goal": "grandparent(tom, ann)", "kind": "rule", "source": "family.pl:7",
+    "clause": "grandparent(X, Z) :-\n    parent(X, Y),\n    parent(Y, Z)",
+    "children": [
+        {"goal": "parent(tom, bob)", "kind": "fact", "source": "family.pl:1"},
+        {"goal": "parent(bob, ann)", "kind": "fact", "source": "family.pl:3"},
+    ],
+}
+ROWS = [
+    {"bindings": "X = ann", "json": {"X": "ann"}, "proof": [GRANDPARENT]},
+    {"bindings": "X = bob", "proof": [{"goal": "X = bob", "kind": "builtin"}], "truncated": True},
+    {"more": True, "output": "checked\n"},
+]
+
+
+def test_explain_call():
+    name, args = explain_call("grandparent(tom, X)", "true", "team", QueryLimits())
+
+    assert name == "mcp_explain"
+    assert args[:3] == ['"true"', '"grandparent(tom, X)"', "'team'"]
+    assert args[4] == "explain(10, 30, 200)"
+
+
+def test_rows_become_explanations():
+    explanations, more, output = parse_explanations(ROWS)
+
+    assert (more, output) == (True, "checked\n")
+    assert [explanation.values for explanation in explanations] == [{"X": "ann"}, {}]
+    assert explanations[0].proof_json()["complete"] and not explanations[1].proof_json()["complete"]
+    assert explanations[0].proof[0].to_json()["children"][0] == {
+        "goal": "parent(tom, bob)", "kind": "fact", "clause": "", "source": "family.pl:1", "children": [],
+    }
+
+
+def test_format_explanations_draws_the_trees():
+    explanations, more, output = parse_explanations(ROWS)
+
+    assert format_explanations("grandparent(tom, X)", explanations, more, output).splitlines() == [
+        "✅ Query: grandparent(tom, X)",
+        "📋 Results with their proofs:",
+        "  • X = ann",
+        "      grandparent(tom, ann)    [rule family.pl:7: grandparent(X, Z) :- parent(X, Y), parent(Y, Z)]",
+        "      ├─ parent(tom, bob)    [fact family.pl:1]",
+        "      └─ parent(bob, ann)    [fact family.pl:3]",
+        "  • X = bob",
+        "      X = bob    [built-in]",
+        f"      … (proof cut off after {MAX_NODES} nodes)",
+        "🖨️ Output:",
+        "checked",
+        "",
+        "💡 2 solution(s) explained; there are more, only the first 10 are explained",
+    ]
+
+
+def test_truncated_nodes_end_their_branch():
+    node = {"goal": "path(a, z)", "kind": "rule", "truncated": True, "children": [
+        {"goal": "edge(a, b)", "kind": "fact"},
+        {"goal": "path(b, z)", "kind": "depth"},
+    ]}
+    (explanation,), _, _ = parse_explanations([{"bindings": "true", "proof": [node]}])
+
+    assert format_explanations("path(a, z)", [explanation], False, "").splitlines()[4:8] == [
+        "      ├─ edge(a, b)    [fact]",
+        "      ├─ path(b, z)    [not expanded: nested deeper than 30]",
+        f"      └─ … (proof cut off after {MAX_NODES} nodes)",
+        "",
+    ]
+
+
+def test_no_solutions_have_no_proof():
+    assert format_explanations("q", [], False, "") == "❌ Query: q\n📋 Result: false (no solutions found, so there is no proof)"
+    (empty,), _, _ = parse_explanations([{"bindings": "true", "proof": []}])
+    assert format_explanations("true", [empty], False, "").splitlines()[3] == "      true    [no goals to prove]"
@@ -0,0 +1,34 @@
+"""Transactional consults of load_knowledge_base."""
+
+from docker_swish_mcp import main
+from docker_swish_mcp.quarantine import scratch_text
+
+
+def test_scratch_text_renames_module_header():
+    text = "% Family\n:- module(family, [parent/2]).\nparent(a, b).\n"
+
+    assert scratch_text(text, "mcp_quarantine_1") == "% Family\n:- module(mcp_quarantine_1, [parent/2]).\nparent(a, b).\n"
+
+
+def test_scratch_text_leaves_plain_files():
+    assert scratch_text("parent(a, b).\n", "mcp_quarantine_1") == "parent(a, b).\n"
+
+
+async def test_directives_run_once(swish):
+    (swish.data_dir / "counted.pl").write_text(":- assertz(loads(counted)).\nitem(1).\n", encoding="utf-8")
+
+    assert "✅" in await main.load_knowledge_base("counted.pl")
+
+    assert "N = 1" in await main.execute_prolog_query("aggregate_all(count, loads(counted), N)")
+
+
+async def test_broken_file_keeps_previous_version(swish):
+    path = swish.data_dir / "broken.pl"
+    path.write_text("colour(red).\n", encoding="utf-8")
+    await main.load_knowledge_base("broken.pl")
+    path.write_text("colour(blue).\ncolour(green\n", encoding="utf-8")
+
+    assert "quarantined" in await main.load_knowledge_base("broken.pl")
+
+    assert "X = red" in await main.execute_prolog_query("colour(X)")
+    assert "broken.pl" in swish.quarantine.files
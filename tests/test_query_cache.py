@@ -0,0 +1,57 @@
+"""Invalidation of cached query results."""
+
+from docker_swish_mcp.config import QueryLimits
+from docker_swish_mcp.query_cache import QueryCache, cacheable
+
+
+def key(container, goal):
+    return QueryCache.key(container, goal, "user", "text", QueryLimits())
+
+
+def test_change_drops_dependent_entries_only():
+    cache = QueryCache(max_entries=10)
+    cache.put(key("swish", "parent(X, Y)"), "parents", frozenset({"user:parent/2"}), 1, cache.sequence)
+    cache.put(key("swish", "age(X, A)"), "ages", frozenset({"user:age/2"}), 1, cache.sequence)
+    cache.put(key("other", "parent(X, Y)"), "theirs", frozenset({"user:parent/2"}), 1, cache.sequence)
+
+    assert cache.invalidate("swish", "user:parent/2") == 1
+
+    assert cache.get(key("swish", "parent(X, Y)"), 1) is None
+    assert cache.get(key("swish", "age(X, A)"), 1) == "ages"
+    assert cache.get(key("other", "parent(X, Y)"), 1) == "theirs"
+
+
+def test_result_older_than_a_change_is_not_cached():
+    cache = QueryCache(max_entries=10)
+    since = cache.sequence
+    cache.invalidate("swish", "user:parent/2")
+
+    cache.put(key("swish", "parent(X, Y)"), "stale", frozenset({"user:parent/2"}), 1, since)
+
+    assert cache.get(key("swish", "parent(X, Y)"), 1) is None
+
+
+def test_new_session_process_is_never_served():
+    cache = QueryCache(max_entries=10)
+    cache.put(key("swish", "parent(X, Y)"), "parents", frozenset(), 1, cache.sequence)
+
+    assert cache.get(key("swish", "parent(X, Y)"), 2) is None
+    assert cache.get(key("swish", "parent(X, Y)"), 1) is None
+
+
+def test_least_recently_used_goes_first():
+    cache = QueryCache(max_entries=2)
+    for goal in ("a", "b"):
+        cache.put(key("swish", goal), goal, frozenset(), 1, cache.sequence)
+    cache.get(key("swish", "a"), 1)
+    cache.put(key("swish", "c"), "c", frozenset(), 1, cache.sequence)
+
+    assert cache.get(key("swish", "b"), 1) is None
+    assert cache.get(key("swish", "a"), 1) == "a"
+
+
+def test_impure_goals_are_not_cached():
+    assert cacheable("parent(X, Y)")
+    assert not cacheable("assertz(parent(a, b))")
+    assert not cacheable("random_between(1, 6, X)")
+    assert not cacheable("consult(family)")
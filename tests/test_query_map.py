@@ -0,0 +1,65 @@
+"""Goal templates mapped over many bindings."""
+
+import pytest
+
+from docker_swish_mcp.query_map import (
+    MAX_ITEMS,
+    MapItem,
+    build_items,
+    format_items,
+    goal_variables,
+)
+
+GOAL = "eligible(Person, Plan)"
+
+
+def test_goal_variables_skip_quoted_text_and_comments():
+    goal = "p(X, 'Y', \"Z\", `W`, _T), % Comment\n q(0'A, Rest)"
+
+    assert goal_variables(goal) == {"X", "_T", "Rest"}
+
+
+def test_bindings_are_unified_before_the_goal():
+    (alice, numbers, text) = build_items(GOAL, [{"Person": "alice"}, {"Person": [1, 2.5]}, {"Person": {"string": "Bo"}}])
+
+    assert alice.goal == "Person = 'alice', (eligible(Person, Plan))"
+    assert numbers.goal == "Person = [1,2.5], (eligible(Person, Plan))"
+    assert text.goal == 'Person = "Bo", (eligible(Person, Plan))'
+    assert build_items(GOAL, [{}])[0].goal == f"({GOAL})"
+
+
+def test_a_value_that_does_not_convert_fails_only_its_item():
+    (bad, good) = build_items(GOAL, [{"Person": {"id": 1}}, {"Person": "bob"}])
+
+    assert (bad.goal, bad.status) == ("", "error") and "Value of 'Person' must be text" in bad.error
+    assert good.goal and good.error is None
+
+
+@pytest.mark.parametrize("bindings, message", [
+    ([{}] * (MAX_ITEMS + 1), f"at most {MAX_ITEMS} bindings per call"),
+    (["alice"], "Binding 1 must be an object"),
+    ([{"Person": "alice"}, {"Who": "bob"}], "Binding 2 names Who, which are not variables of the goal"),
+])
+def test_unusable_lists(bindings, message):
+    with pytest.raises(ValueError, match=message):
+        build_items(GOAL, bindings)
+
+
+def test_items_record_pengine_answers():
+    items = [MapItem(1, {"Person": "alice"}), MapItem(2, {"Person": "bob"}), MapItem(3, {"Person": 7}), MapItem(4, {})]
+    items[0].record({"event": "success", "data": [{"Plan": "gold"}, {"Plan": "basic"}], "more": True})
+    items[1].record({"event": "failure"})
+    items[2].record({"event": "error", "data": "Time limit exceeded"})
+    items[3].record({"event": "success", "data": [{}]})
+
+    assert format_items(items, 4).splitlines() == [
+        "[1] ✅ Person = alice: Plan = gold; Plan = basic …",
+        "[2] ❌ Person = bob: false",
+        "[3] 💥 Person = 7: Time limit exceeded",
+        "[4] ✅ (no bindings): true",
+        "",
+        "🗺️ 4 item(s): 2 succeeded, 1 failed, 1 error(s); up to 4 at a time",
+    ]
+    assert items[1].to_json() == {
+        "index": 2, "bindings": {"Person": "bob"}, "status": "failure", "solutions": [], "more": False, "error": None,
+    }
@@ -0,0 +1,77 @@
+"""Rate limits and quotas per client."""
+
+import pytest
+
+from docker_swish_mcp import main
+from docker_swish_mcp.quotas import (
+    SWEEP_INTERVAL,
+    QuotaExceeded,
+    QuotaSettings,
+    QuotaTracker,
+    TokenBucket,
+)
+
+
+class Clock:
+    def __init__(self):
+        self.now = 1000.0
+
+    def __call__(self):
+        return self.now
+
+
+def test_token_bucket_allows_bursts_then_waits():
+    bucket = TokenBucket(rate=1.0, capacity=2, now=0.0)
+
+    assert bucket.take(0.0) == 0
+    assert bucket.take(0.0) == 0
+    assert bucket.take(0.0) == pytest.approx(1.0)
+    assert bucket.take(0.5) == pytest.approx(0.5)
+    assert bucket.take(1.5) == 0
+
+
+def test_rate_limit_refuses_with_retry_after():
+    tracker = QuotaTracker(QuotaSettings(calls_per_minute=60, burst=1), clock=Clock())
+    tracker.check("a", "execute_prolog_query")
+
+    with pytest.raises(QuotaExceeded) as refused:
+        tracker.check("a", "execute_prolog_query")
+
+    assert refused.value.payload["error"] == "rate_limited"
+    assert refused.value.payload["retry_after"] == pytest.approx(1.0)
+    tracker.check("b", "execute_prolog_query")
+
+
+def test_clause_quota_resets_with_window():
+    clock = Clock()
+    tracker = QuotaTracker(QuotaSettings(clauses=10, window=100), clock=clock)
+    tracker.charge_clauses("a", 10)
+
+    with pytest.raises(QuotaExceeded, match="clauses"):
+        tracker.check("a", "execute_prolog_query")
+    tracker.check("a", "quota_status")
+
+    clock.now += 100
+    tracker.check("a", "execute_prolog_query")
+
+
+def test_idle_clients_are_evicted():
+    clock = Clock()
+    tracker = QuotaTracker(QuotaSettings(calls_per_minute=60, window=100), clock=clock)
+    tracker.check("gone", "execute_prolog_query")
+    clock.now += 50
+    tracker.check("busy", "execute_prolog_query")
+
+    clock.now += max(50, SWEEP_INTERVAL)
+    tracker.check("busy", "execute_prolog_query")
+
+    assert set(tracker.clients) == {"busy"}
+
+
+async def test_runtime_built_asserts_are_charged(swish, monkeypatch):
+    tracker = QuotaTracker(QuotaSettings(clauses=1000))
+    monkeypatch.setattr(main, "quota_tracker", tracker)
+
+    await main.execute_prolog_query("atom_concat(asser, tz, F), G =.. [F, built(1)], call(G)")
+
+    assert tracker.usage("local").clauses == 1
@@ -0,0 +1,74 @@
+"""Triple patterns and terms passed between the RDF tools and the semweb helpers."""
+
+import pytest
+
+from docker_swish_mcp.rdf import (
+    default_graph,
+    format_term,
+    graph_pattern,
+    object_pattern,
+    rdf_format,
+    resource_pattern,
+    triples_call,
+    validate_rdf_name,
+)
+
+
+@pytest.mark.parametrize("text, pattern", [
+    ("", "any"),
+    ("<http://example.org/tom>", "iri('http://example.org/tom')"),
+    ("https://example.org/it's", "iri('https://example.org/it\\'s')"),
+    ("_:b0", "iri('_:b0')"),
+    ("foaf:knows", "pname('foaf', 'knows')"),
+    (":local", "pname('', 'local')"),
+])
+def test_resource_pattern(text, pattern):
+    assert resource_pattern(text, "subject") == pattern
+
+
+def test_resource_pattern_refuses_free_text():
+    with pytest.raises(ValueError, match="Cannot read subject 'two words'"):
+        resource_pattern("two words", "subject")
+
+
+@pytest.mark.parametrize("text, pattern", [
+    ("42", 'lit("42", none, none)'),
+    ('"Tom"@EN', "lit(\"Tom\", 'en', none)"),
+    ('"say \\"hi\\""', 'lit("say \\"hi\\"", none, none)'),
+    ('"3"^^xsd:integer', "lit(\"3\", none, pname('xsd', 'integer'))"),
+    ("foaf:Person", "pname('foaf', 'Person')"),
+])
+def test_object_pattern(text, pattern):
+    assert object_pattern(text) == pattern
+
+
+def test_triples_call_builds_its_arguments():
+    name, args = triples_call("", "foaf:knows", "", "<people>", 10)
+
+    assert name == "mcp_rdf_triples"
+    assert args == ["any", "pname('foaf', 'knows')", "any", "iri('people')", "10"]
+    assert graph_pattern("") == "any"
+
+
+def test_rdf_format_and_default_graph():
+    assert rdf_format("rdf/People.TTL") == "turtle"
+    assert rdf_format("data.txt", "ntriples") == "ntriples"
+    assert default_graph("rdf/people.v2.ttl") == "people"
+    with pytest.raises(ValueError, match="Cannot tell the RDF format"):
+        rdf_format("data.txt")
+    with pytest.raises(ValueError, match="Unknown RDF format 'json'"):
+        rdf_format("data.ttl", "json")
+    with pytest.raises(ValueError, match="Invalid RDF file name"):
+        validate_rdf_name("../people")
+
+
+@pytest.mark.parametrize("term, text", [
+    ({"type": "uri", "value": "http://example.org/tom"}, "<http://example.org/tom>"),
+    ({"type": "bnode", "value": "_:b1"}, "_:b1"),
+    ({"type": "literal", "value": 'a "b"', "xml:lang": "en"}, '"a \\"b\\""@en'),
+    ({"type": "literal", "value": "3", "datatype": "http://www.w3.org/2001/XMLSchema#integer"},
+     '"3"^^<http://www.w3.org/2001/XMLSchema#integer>'),
+    ({"type": "literal", "value": "plain"}, '"plain"'),
+])
+def test_format_term(term, text):
+    assert format_term(term) == text
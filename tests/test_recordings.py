@@ -0,0 +1,134 @@
+"""Session recordings: capture, files on disk and comparing replayed results."""
+
+import json
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp import recordings
+from docker_swish_mcp.recordings import (
+    MAX_RESULT_CHARS,
+    RecordedStep,
+    Recording,
+    RecordingError,
+    Replay,
+    SessionRecorder,
+    StepOutcome,
+    capture_files,
+    normalize_result,
+    read_recording,
+    resolve_recording,
+    result_difference,
+    validate_recording_name,
+    write_recording,
+)
+
+
+class ToolManager:
+    async def call_tool(self, name, arguments, *args, **kwargs):
+        if name == "refused":
+            raise RuntimeError("❌ not allowed")
+        return f"✅ {name} ran in 12 ms"
+
+
+def test_recording_names():
+    assert validate_recording_name("bug-42.v2") == "bug-42.v2"
+    with pytest.raises(RecordingError, match="Invalid recording name '../x'"):
+        validate_recording_name("../x")
+
+
+def test_capture_files_keeps_visible_text_files(tmp_path):
+    (tmp_path / "lib").mkdir()
+    (tmp_path / "family.pl").write_text("parent(tom, bob).\n", encoding="utf-8")
+    (tmp_path / "lib" / "util.pl").write_text("util.\n", encoding="utf-8")
+    (tmp_path / ".hidden.pl").write_text("x.\n", encoding="utf-8")
+    (tmp_path / "image.png").write_bytes(b"\x89PNG\xff")
+
+    files, skipped = capture_files(tmp_path)
+
+    assert files == {"family.pl": "parent(tom, bob).\n", "lib/util.pl": "util.\n"}
+    assert skipped == ["image.png"]
+
+
+def test_recordings_round_trip_and_resolve(tmp_path):
+    data_dir = tmp_path / "data"
+    recording = Recording("bug-42", files={"family.pl": "parent(tom, bob).\n"})
+    recording.record(RecordedStep("execute_prolog_query", {"query": "parent(X, Y)", "instance": "a"}, "✅"))
+
+    path = write_recording(data_dir, recording)
+
+    assert path == tmp_path / "swish-recordings" / "data" / "bug-42.json"
+    assert read_recording(path) == recording
+    assert resolve_recording(data_dir, "latest") == path == resolve_recording(data_dir, "bug-42")
+    assert resolve_recording(data_dir, str(path)) == path
+    with pytest.raises(FileNotFoundError, match="No recording 'other'"):
+        resolve_recording(data_dir, "other")
+    # Replayed steps leave the instance to the replay
+    assert recording.scenario().steps[0].args == {"query": "parent(X, Y)"}
+
+
+@pytest.mark.parametrize("data, message", [
+    ({"format": "other"}, "Not a session recording"),
+    ({"format": "docker-swish-mcp-recording", "version": 9}, "Recording version 9 is newer"),
+    ({"format": "docker-swish-mcp-recording", "name": "x", "colour": 1}, "Malformed recording"),
+    ({"format": "docker-swish-mcp-recording", "name": "x", "files": {"../etc/passwd": ""}}, "not a path inside"),
+])
+def test_bad_recordings(tmp_path, data, message):
+    path = tmp_path / "bad.json"
+    path.write_text(json.dumps(data), encoding="utf-8")
+
+    with pytest.raises(RecordingError, match=message):
+        read_recording(path)
+
+
+def test_long_results_are_cut_and_extra_steps_counted(monkeypatch):
+    monkeypatch.setattr(recordings, "MAX_STEPS", 1)
+    recording = Recording("long")
+
+    recording.record(RecordedStep("export_results", {}, "x" * (MAX_RESULT_CHARS + 5)))
+    recording.record(RecordedStep("export_results", {}, "again"))
+
+    assert recording.steps[0].truncated and len(recording.steps[0].result) == MAX_RESULT_CHARS
+    assert recording.summary().startswith("1 step(s) (+1 not kept), 0 file(s), recorded ")
+
+
+def test_results_are_compared_without_what_varies_between_runs():
+    recorded = RecordedStep("get_swish_status", {}, "⏱️ 2026-10-14T08:30:00Z took 12 ms (req-0123456789ab)\n/data/a")
+
+    assert normalize_result("in /tmp/x/data, 3.5 s", {"/tmp/x/data": "<data>"}) == "in <data>, <duration>"
+    assert result_difference(recorded, "⏱️ 2026-10-15 09:00:00 took 9 ms (req-ba9876543210)\n/data/a", False, {}) == ""
+    assert result_difference(recorded, "x\n/data/b", False, {}).startswith("line 1 differs")
+    assert result_difference(recorded, recorded.result, True, {}) == "the call failed"
+    assert result_difference(recorded, recorded.result + "\nmore", False, {}) == "recorded 2 line(s), now 3"
+
+
+def test_replay_summary():
+    recording = Recording("bug", steps=[RecordedStep("a", {}, "1"), RecordedStep("b", {}, "2"), RecordedStep("c", {}, "3")])
+    replay = Replay(recording, "replay-1", outcomes=[StepOutcome(1, "a"), StepOutcome(2, "b", difference="line 1 differs")])
+
+    assert not replay.finished and replay.position == 2
+    assert replay.summary() == "❌ bug: 1 same, 1 different, 0 skipped of 3 step(s)"
+    assert StepOutcome(3, "c", skipped="acts on the server").describe() == ["⏭️ 3. c: acts on the server"]
+    assert replay.outcomes[1].describe(recording.steps[1])[0] == "❌ 2. b: line 1 differs"
+
+
+async def test_recorder_records_each_clients_calls():
+    manager = ToolManager()
+    recorder = SessionRecorder()
+    client = iter(["alice", "alice", "bob", "alice"])
+    recorder.attach(SimpleNamespace(_tool_manager=manager), lambda: next(client))
+    recorder.start("alice", Recording("mine"))
+
+    await manager.call_tool("execute_prolog_query", {"query": "true"})
+    await manager.call_tool("recording_stop", {})
+    await manager.call_tool("consult_file", {})
+    with pytest.raises(RuntimeError):
+        await manager.call_tool("refused", {})
+
+    recording = recorder.stop("alice")
+    assert [(step.tool, step.result, step.failed) for step in recording.steps] == [
+        ("execute_prolog_query", "✅ execute_prolog_query ran in 12 ms", False),
+        ("refused", "❌ not allowed", True),
+    ]
+    with pytest.raises(RecordingError, match="Not recording"):
+        recorder.stop("alice")
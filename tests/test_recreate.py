@@ -0,0 +1,33 @@
+"""Recreating the container around running queries."""
+
+import asyncio
+
+from docker_swish_mcp import main
+
+
+async def test_waits_for_running_queries(swish, monkeypatch):
+    monkeypatch.setattr(main, "RECREATE_GRACE_SECONDS", 3)
+    finished = asyncio.Event()
+
+    async def query():
+        with main.running_queries.track("test", "sleep(1)", "session"):
+            await asyncio.sleep(0.5)
+        finished.set()
+
+    task = asyncio.create_task(query())
+    await asyncio.sleep(0)
+    assert main.queries_running_on(swish)
+
+    assert await main.wait_for_queries(swish, "the test") == []
+    assert finished.is_set()
+    await task
+
+
+async def test_reports_queries_it_kills(swish, monkeypatch):
+    monkeypatch.setattr(main, "RECREATE_GRACE_SECONDS", 1)
+
+    with main.running_queries.track("test", "repeat, fail", "session"):
+        notes = await main.wait_for_queries(swish, "the test")
+
+    assert notes[0].startswith("⚠️ 1 query still running after 1s, killed by the test")
+    assert "repeat, fail" in notes[1]
@@ -0,0 +1,96 @@
+"""Downloads of remote Prolog sources."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp import remote_sources
+from docker_swish_mcp.remote_sources import (
+    RemoteSourceError,
+    check_url_host,
+    fetch_source,
+    parse_checksum,
+)
+
+
+@pytest.mark.parametrize("url", [
+    "http://127.0.0.1/kb.pl",
+    "http://10.0.0.5/kb.pl",
+    "http://169.254.169.254/latest/meta-data/",
+    "http://[::1]/kb.pl",
+    "http://[::ffff:192.168.1.1]/kb.pl",
+])
+def test_private_addresses_are_refused(url):
+    with pytest.raises(RemoteSourceError, match="only public hosts"):
+        check_url_host(url)
+
+
+def test_public_addresses_and_names_pass():
+    check_url_host("https://93.184.216.34/kb.pl")
+    check_url_host("https://example.org/kb.pl")
+
+
+def test_checksum_algorithm_is_inferred():
+    assert parse_checksum("a" * 64) == ("sha256", "a" * 64)
+    with pytest.raises(RemoteSourceError):
+        parse_checksum("sha256:abc")
+
+
+class Content:
+    def __init__(self, body):
+        self.body = body
+
+    async def iter_chunked(self, size):
+        yield self.body
+
+
+class Response:
+    def __init__(self, body):
+        self.status = 200
+        self.headers = {}
+        self.content_length = len(body)
+        self.content = Content(body)
+
+    async def __aenter__(self):
+        return self
+
+    async def __aexit__(self, *exc):
+        return False
+
+
+class Session:
+    def __init__(self, response=None, error=None, **kwargs):
+        self.response = response
+        self.error = error
+
+    async def __aenter__(self):
+        return self
+
+    async def __aexit__(self, *exc):
+        return False
+
+    def get(self, url, **kwargs):
+        if self.error:
+            raise self.error
+        return self.response
+
+
+async def test_rejected_content_is_not_written(tmp_path, monkeypatch):
+    monkeypatch.setattr(remote_sources.aiohttp, "ClientSession", lambda **kwargs: Session(Response(b":- shell(ls).\n")))
+
+    def refuse(text):
+        raise ValueError("shell")
+
+    with pytest.raises(ValueError):
+        await fetch_source(tmp_path, "https://example.org/kb.pl", check=refuse)
+
+    assert not (tmp_path / remote_sources.CACHE_DIR_NAME).exists()
+
+
+async def test_timeout_is_a_remote_source_error(tmp_path, monkeypatch):
+    monkeypatch.setattr(
+        remote_sources.aiohttp, "ClientSession", lambda **kwargs: Session(error=asyncio.TimeoutError())
+    )
+
+    with pytest.raises(RemoteSourceError, match="timed out"):
+        await fetch_source(tmp_path, "https://example.org/kb.pl", timeout=5)
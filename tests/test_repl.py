@@ -0,0 +1,49 @@
+"""What is typed at a client's ?- prompt, and how the answers print."""
+
+import pytest
+
+from docker_swish_mcp.repl import (
+    ReplReply,
+    format_reply,
+    parse_input,
+    repl_alias,
+    repl_request,
+    vetted_goal,
+)
+from docker_swish_mcp.sandbox import SandboxPolicy, SandboxViolation
+
+
+@pytest.mark.parametrize("text, parsed", [
+    (" ; ", ("more", "")),
+    ("", ("stop", "")),
+    (".", ("stop", "")),
+    ("member(X, [a, b]).", ("query", "member(X, [a, b])")),
+])
+def test_parse_input(text, parsed):
+    assert parse_input(text) == parsed
+
+
+def test_aliases_are_per_client():
+    assert repl_alias("alice").startswith("mcp_repl_")
+    assert repl_alias("alice") == repl_alias("alice") != repl_alias("bob")
+
+
+def test_requests_and_vetting():
+    assert repl_request("query", 'X = "a"', "team") == "query(\"X = \\\"a\\\"\", 'team')"
+    assert repl_request("more", "", "team") == "more"
+    assert vetted_goal(":- op(700, xfx, ===>)", SandboxPolicy()) == ":- op(700, xfx, ===>)"
+    with pytest.raises(SandboxViolation):
+        vetted_goal(":- assertz(p)", SandboxPolicy(mode="readonly"))
+
+
+def test_replies_print_as_the_toplevel_would():
+    more = ReplReply.from_json({"kind": "answer", "text": "X = a", "more": True})
+
+    assert format_reply(more, ["hello"]).splitlines() == [
+        "hello",
+        "X = a ;",
+        '💡 More answers may follow: send ";" for the next one, or "." to stop',
+    ]
+    assert format_reply(ReplReply("answer", "X = b"), []) == "X = b."
+    assert format_reply(ReplReply("false"), []) == "false."
+    assert format_reply(ReplReply("error", message="boom"), []).splitlines()[0] == "❌ ERROR: boom"
@@ -0,0 +1,94 @@
+"""Reproduce bundles: writing, reading back and comparing environments."""
+
+import zipfile
+
+import pytest
+
+from docker_swish_mcp.repro import (
+    ReproError,
+    ReproFile,
+    ReproManifest,
+    environment_differences,
+    parse_flags,
+    read_repro_bundle,
+    resolve_repro_bundle,
+    restore_files,
+    set_flags_call,
+    write_repro_bundle,
+)
+
+FAMILY = b"parent(tom, bob).\n"
+
+
+def manifest():
+    recorded = ReproManifest(
+        goal="parent(X, bob)", module="user", output_format="text", limits={"timeout": 10}, printing={},
+        environment={"backend": "docker", "image_id": "sha256:abc", "swipl": "9.2.9"},
+        flags={"double_quotes": "codes", "version": "90209"},
+        files=[ReproFile.of("family.pl", "user", FAMILY)],
+        databases={"user": [{"predicate": "seen/1", "clauses": ["seen(a)", "seen(b)"]}]},
+    )
+    recorded.record_result("X = tom.")
+    return recorded
+
+
+@pytest.fixture
+def data_dir(tmp_path):
+    directory = tmp_path / "data"
+    directory.mkdir()
+    (directory / "family.pl").write_bytes(FAMILY)
+    return directory
+
+
+def test_bundle_round_trip(data_dir):
+    path = write_repro_bundle(data_dir, manifest(), {"family.pl": FAMILY})
+
+    recorded, contents = read_repro_bundle(path)
+
+    assert resolve_repro_bundle(data_dir, "latest") == path == resolve_repro_bundle(data_dir, path.name)
+    assert (recorded.goal, recorded.files, recorded.databases) == (manifest().goal, manifest().files, manifest().databases)
+    assert contents == {"family.pl": FAMILY}
+    assert recorded.summary() == "1 file(s), 2 dynamic clause(s), 2 flag(s)"
+    assert recorded.same_result("X = tom.") and not recorded.same_result("X = ann.")
+
+
+def test_tampered_files_are_refused(data_dir, tmp_path):
+    path = write_repro_bundle(data_dir, manifest(), {"family.pl": FAMILY})
+    tampered = tmp_path / "tampered.zip"
+    with zipfile.ZipFile(path) as source, zipfile.ZipFile(tampered, "w") as target:
+        target.writestr("manifest.json", source.read("manifest.json"))
+        target.writestr("files/family.pl", b"parent(eve, bob).\n")
+
+    with pytest.raises(ReproError, match="family.pl in tampered.zip does not match its recorded hash"):
+        read_repro_bundle(tampered)
+    with pytest.raises(ReproError, match="Not a reproduce bundle manifest"):
+        ReproManifest.from_json({"format": "other"})
+
+
+def test_environment_differences(data_dir):
+    (data_dir / "family.pl").write_bytes(b"parent(ann, bob).\n")
+
+    differences = environment_differences(
+        manifest(), {"backend": "docker", "image_id": "sha256:def", "swipl": "9.2.9"},
+        {"double_quotes": "string", "version": "90209"}, data_dir,
+    )
+
+    assert differences == [
+        "image: recorded sha256:abc, now sha256:def",
+        "flag double_quotes: recorded codes, now string",
+        "family.pl: changed since it was recorded",
+    ]
+
+
+def test_flags_and_restore(data_dir):
+    assert parse_flags([{"flag": "unknown", "value": "error"}, {"flag": "iso", "error": "no such flag"}]) == {
+        "unknown": "error",
+    }
+    # Read-only flags are not set back
+    assert set_flags_call({"version": "90209", "double_quotes": "codes", "other": "x"}) == (
+        "mcp_env_set_flags", ['[double_quotes-"codes"]'],
+    )
+    assert restore_files(data_dir, {"lib/util.pl": b"u.\n"}) == 1
+    assert (data_dir / "lib" / "util.pl").read_bytes() == b"u.\n"
+    with pytest.raises(ValueError, match="not a plain relative path"):
+        restore_files(data_dir, {"../escape.pl": b""})
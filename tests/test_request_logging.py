@@ -0,0 +1,88 @@
+"""Correlation IDs of tool calls and the JSON log format."""
+
+import json
+import logging
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.request_logging import (
+    CorrelationFilter,
+    JsonFormatter,
+    attach_correlation_ids,
+    client_correlation_id,
+    correlation_environment,
+    correlation_headers,
+    correlation_id,
+    current_tool,
+)
+
+
+class ToolManager:
+    def __init__(self):
+        self.seen = []
+
+    async def call_tool(self, name, arguments, *args, **kwargs):
+        self.seen.append((name, correlation_id.get(), current_tool.get(), correlation_headers()))
+        return arguments.get("result", "ok")
+
+
+def record(message="hello", **extra):
+    record = logging.LogRecord("docker-swish-mcp", logging.INFO, "", 0, message, None, None)
+    record.__dict__.update(extra)
+    CorrelationFilter().filter(record)
+    return record
+
+
+@pytest.mark.parametrize("value, taken", [
+    ("req-abc.1:x", "req-abc.1:x"),
+    ("  trace_42 ", "trace_42"),
+    ("", ""),
+    (None, ""),
+    ("has space", ""),
+    ("x" * 65, ""),
+])
+def test_client_correlation_id(value, taken):
+    assert client_correlation_id(value) == taken
+
+
+def test_ids_are_passed_on_only_within_a_call():
+    token = correlation_id.set("req-1")
+    try:
+        assert correlation_headers() == {"X-Correlation-ID": "req-1"}
+        assert correlation_environment() == ["MCP_CORRELATION_ID=req-1"]
+    finally:
+        correlation_id.reset(token)
+    assert correlation_headers() == {} and correlation_environment() == []
+
+
+def test_json_records_carry_the_id_tool_and_extra_fields():
+    token, tool = correlation_id.set("req-1"), current_tool.set("execute_prolog_query")
+    try:
+        entry = json.loads(JsonFormatter().format(record(status="ok", duration_ms=1.5)))
+    finally:
+        current_tool.reset(tool)
+        correlation_id.reset(token)
+
+    assert entry["time"].endswith("Z")
+    assert {key: entry[key] for key in ("level", "logger", "message", "correlation_id", "tool")} == {
+        "level": "info", "logger": "docker-swish-mcp", "message": "hello",
+        "correlation_id": "req-1", "tool": "execute_prolog_query",
+    }
+    assert (entry["status"], entry["duration_ms"]) == ("ok", 1.5)
+    assert set(json.loads(JsonFormatter().format(record()))) == {"time", "level", "logger", "message"}
+    assert record().correlation == ""
+
+
+async def test_calls_run_under_the_clients_id_or_a_new_one():
+    manager = ToolManager()
+    headers = iter(["trace-7", "not valid!"])
+    attach_correlation_ids(SimpleNamespace(_tool_manager=manager), lambda: next(headers), lambda result: result == "boom")
+
+    await manager.call_tool("execute_prolog_query", {})
+    await manager.call_tool("consult_file", {"result": "boom"})
+
+    (first, second) = manager.seen
+    assert first == ("execute_prolog_query", "trace-7", "execute_prolog_query", {"X-Correlation-ID": "trace-7"})
+    assert second[1].startswith("req-") and len(second[1]) == 16
+    assert correlation_id.get() == "" and current_tool.get() == ""
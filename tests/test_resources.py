@@ -0,0 +1,87 @@
+"""Container limits from settings, and usage from the runtime's stats."""
+
+import pytest
+
+from docker_swish_mcp.resources import (
+    ContainerResources,
+    ContainerUsage,
+    Ulimit,
+    format_usage,
+    parse_memory,
+    parse_ulimits,
+    usage_from_stats,
+    usage_from_summary,
+)
+
+
+@pytest.mark.parametrize("value, size", [(0, 0), ("512m", 512 * 1024 ** 2), ("2GiB", 2 * 1024 ** 3), ("1.5g", 1610612736)])
+def test_parse_memory(value, size):
+    assert parse_memory(value) == size
+
+
+@pytest.mark.parametrize("value", ["lots", "1m", -1, True])
+def test_bad_memory_sizes_are_refused(value):
+    with pytest.raises(ValueError, match="memory must be"):
+        parse_memory(value)
+
+
+def test_ulimits_from_text_or_table():
+    assert parse_ulimits("nofile=1024:2048, core=0") == (Ulimit("core", 0, 0), Ulimit("nofile", 1024, 2048))
+    assert parse_ulimits({"nofile": [1024, 2048]}) == (Ulimit("nofile", 1024, 2048),)
+    with pytest.raises(ValueError, match="soft limit 4096 is above the hard limit 2048"):
+        parse_ulimits("nofile=4096:2048")
+    with pytest.raises(ValueError, match="look like nofile=1024:2048"):
+        parse_ulimits("nofile")
+
+
+def test_settings_become_run_options():
+    resources = ContainerResources.from_settings({"memory": "2g", "cpus": "1.5", "pids_limit": "256", "ulimits": "core=0"})
+
+    assert str(resources) == "memory=2g cpus=1.5 pids_limit=256 ulimit core=0:0"
+    assert resources.run_options() == {
+        "mem_limit": 2 * 1024 ** 3, "memswap_limit": 2 * 1024 ** 3, "nano_cpus": 1_500_000_000,
+        "pids_limit": 256, "ulimits": [{"Name": "core", "Soft": 0, "Hard": 0}],
+    }
+    # Unset values keep the base's
+    assert ContainerResources.from_settings({"cpus": 2}, resources).memory == resources.memory
+    assert not ContainerResources() and ContainerResources().run_options() == {}
+    with pytest.raises(ValueError, match="pids_limit must be an integer"):
+        ContainerResources.from_settings({"pids_limit": "many"})
+
+
+def test_usage_from_engine_stats():
+    usage = usage_from_stats({
+        "cpu_stats": {"cpu_usage": {"total_usage": 300}, "system_cpu_usage": 2000, "online_cpus": 2},
+        "precpu_stats": {"cpu_usage": {"total_usage": 100}, "system_cpu_usage": 1000},
+        "memory_stats": {"usage": 600, "limit": 1000, "stats": {"inactive_file": 100}},
+        "pids_stats": {"current": 3},
+        "networks": {"eth0": {"rx_bytes": 10, "tx_bytes": 20}, "eth1": {"rx_bytes": 1, "tx_bytes": 2}},
+        "blkio_stats": {"io_service_bytes_recursive": [{"op": "Read", "value": 7}, {"op": "Write", "value": 9}]},
+    })
+
+    assert usage == ContainerUsage(
+        cpu_percent=40.0, memory_used=500, memory_limit=1000, pids=3,
+        network_rx=11, network_tx=22, block_read=7, block_write=9,
+    )
+    assert usage.memory_percent == 50.0
+
+
+def test_usage_from_a_summary_row():
+    usage = usage_from_summary({"CPUPerc": "12.5%", "MemUsage": "10MiB / 2GiB", "NetIO": "1kB / 2kB", "PIDs": "4"})
+
+    assert (usage.cpu_percent, usage.memory_used, usage.memory_limit) == (12.5, 10 * 1024 ** 2, 2 * 1024 ** 3)
+    assert (usage.network_rx, usage.network_tx, usage.pids) == (1024, 2048, 4)
+
+
+def test_format_usage():
+    usage = ContainerUsage(cpu_percent=40.0, memory_used=512 * 1024 ** 2, pids=3, pids_limit=2 ** 63)
+    resources = ContainerResources(memory=1024 ** 3, cpus=2)
+
+    assert format_usage("swish", usage, resources, oom_kills=1).splitlines() == [
+        "📊 Container swish:",
+        "  🧮 CPU: 40.0% of 2 CPU(s)",
+        "  🧠 Memory: 512.0MiB / 1.0GiB",
+        "  🧵 Processes: 3",
+        "  ⚠️ Out of memory: the container was OOM-killed 1 time(s) since the server started",
+    ]
+    assert format_usage("swish", ContainerUsage(), ContainerResources(), 0).splitlines()[-1].startswith("  💡 No resource limits")
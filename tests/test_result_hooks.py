@@ -0,0 +1,89 @@
+"""Result hooks rewriting query solutions before they are formatted."""
+
+from docker_swish_mcp.result_hooks import ResultHooks, Solution
+
+
+def upper(solution):
+    solution.text = solution.text.upper()
+    return solution
+
+
+def mark(solution):
+    solution.text += "!"
+    return solution
+
+
+def half_done(solution):
+    solution.bindings["X"] = "changed"
+    raise RuntimeError("gave up")
+
+
+def iso_dates(solution):
+    for name, value in solution.bindings.items():
+        if value.get("type") == "compound" and value.get("functor") == "date":
+            y, m, d = (arg["value"] for arg in value["args"])
+            solution.bindings[name] = {"type": "string", "value": f"{y:04d}-{m:02d}-{d:02d}"}
+    return solution
+
+
+def integer(value):
+    return {"type": "integer", "value": value}
+
+
+def test_hooks_run_by_order_then_registration():
+    hooks = ResultHooks()
+    hooks.register("mark", mark)
+    hooks.register("upper", upper, order=-1)
+    hooks.register("again", mark)
+
+    assert hooks.process("q", "user", "text", "x = a") == "X = A!!"
+    assert [entry.name for entry in hooks.ordered()] == ["upper", "mark", "again"]
+    # Registering a name again replaces it, at the end of its order
+    hooks.register("mark", upper, order=-1)
+    assert [entry.name for entry in hooks.ordered()] == ["upper", "mark", "again"]
+    assert hooks.unregister("again") and not hooks.unregister("again")
+
+
+def test_json_bindings_are_rewritten():
+    hooks = ResultHooks()
+    hooks.register("iso_dates", iso_dates)
+    value = {"D": {"type": "compound", "functor": "date", "args": [integer(2026), integer(3), integer(9)]}}
+
+    assert hooks.process("q", "user", "json", value) == {"D": {"type": "string", "value": "2026-03-09"}}
+    assert value["D"]["type"] == "compound"
+
+
+def test_a_failing_hook_is_skipped_and_counted():
+    hooks = ResultHooks()
+    hooks.register("half_done", half_done)
+    hooks.register("wrong", lambda solution: "text")
+    hooks.register("upper", upper)
+
+    solution = hooks.apply(Solution("q", "user", "json", bindings={"X": "a"}, text="x"))
+
+    assert (solution.bindings, solution.text) == ({"X": "a"}, "X")
+    assert [entry.describe() for entry in hooks.ordered()] == [
+        "half_done (order 0, 1 call(s), 1 failed (last: RuntimeError: gave up))",
+        "wrong (order 0, 1 call(s), 1 failed (last: TypeError: returned str, not a Solution or None))",
+        "upper (order 0, 1 call(s))",
+    ]
+
+
+def test_none_leaves_the_solution_out():
+    hooks = ResultHooks()
+    hooks.register("drop", lambda solution: None)
+    hooks.register("upper", upper)
+
+    assert hooks.process("q", "user", "text", "x") is None
+    assert hooks.entries["upper"].calls == 0
+    assert ResultHooks().process("q", "user", "text", "x") == "x"
+
+
+def test_hooks_load_from_specs():
+    hooks = ResultHooks()
+
+    problems = hooks.load("string:capwords, ,json, json:no_such_hook")
+
+    assert list(hooks.entries) == ["string:capwords"]
+    assert [problem.split(":")[0] for problem in problems] == ["json", "json"]
+    assert problems[0] == "json: expected module:function"
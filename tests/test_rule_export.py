@@ -0,0 +1,169 @@
+"""Translating the function-free rules of a knowledge base to Soufflé and SQL."""
+
+import pytest
+
+from docker_swish_mcp.rule_export import (
+    format_rule_export,
+    rule_export_filename,
+    rules_export_call,
+    translate_rules,
+)
+
+
+def atom(value):
+    return {"type": "atom", "value": value}
+
+
+def var(name):
+    return {"type": "var", "name": name}
+
+
+def integer(value):
+    return {"type": "integer", "value": value}
+
+
+def term(functor, *args):
+    return {"type": "compound", "functor": functor, "arity": len(args), "args": list(args)}
+
+
+TRUE = atom("true")
+
+
+def predicate(indicator, *clauses, count=None):
+    name, _, arity = indicator.rpartition("/")
+    return {
+        "predicate": indicator, "name": name, "arity": int(arity),
+        "count": len(clauses) if count is None else count,
+        "clauses": [{"head": head, "body": body, "text": text} for head, body, text in clauses],
+    }
+
+
+X, Y, Z, A = var("X"), var("Y"), var("Z"), var("A")
+ROWS = [
+    predicate("parent/2",
+              (term("parent", atom("tom"), atom("bob")), TRUE, "parent(tom, bob)."),
+              (term("parent", atom("bob"), atom("ann")), TRUE, "parent(bob, ann).")),
+    predicate("ancestor/2",
+              (term("ancestor", X, Y), term("parent", X, Y), "ancestor(X, Y) :- parent(X, Y)."),
+              (term("ancestor", X, Z), term(",", term("parent", X, Y), term("ancestor", Y, Z)),
+               "ancestor(X, Z) :- parent(X, Y), ancestor(Y, Z).")),
+    predicate("age/2", (term("age", atom("tom"), integer(60)), TRUE, "age(tom, 60)."), count=3),
+    predicate("senior/1",
+              (term("senior", X), term(",", term("age", X, A), term(">", A, integer(30))), "senior(X) :- age(X, A), A > 30."),
+              (term("senior", X), term(",", term("age", X, A), atom("!")), "senior(X) :- age(X, A), !.")),
+    predicate("childless/1",
+              (term("childless", X), term(",", term("age", X, var("_")), term("\\+", term("parent", X, var("_C")))),
+               "childless(X) :- age(X, _), \\+ parent(X, _C).")),
+]
+
+
+def translated(head, body, target="souffle"):
+    """Why the clause head :- body of p/1 is left out, if it is, and the last line of the translation."""
+    export = translate_rules([ROWS[0], predicate("p/1", (head, body, "p."))], target, "user", [])
+    return [entry.reason for entry in export.skipped], export.text.splitlines()[-1]
+
+
+def test_requested_predicates_bring_what_they_use():
+    export = translate_rules(ROWS, "souffle", "team", ["ancestor/2"])
+
+    assert export.relations == ["parent/2", "ancestor/2"]
+    assert export.text.splitlines() == [
+        "// Soufflé Datalog translated from SWISH module team by docker-swish-mcp",
+        "",
+        ".decl parent(a1: symbol, a2: symbol)",
+        ".decl ancestor(a1: symbol, a2: symbol)",
+        ".output ancestor",
+        "",
+        "// parent/2",
+        'parent("tom", "bob").',
+        'parent("bob", "ann").',
+        "",
+        "// ancestor/2",
+        "ancestor(A, B) :- parent(A, B).",
+        "ancestor(A, B) :- parent(A, C), ancestor(C, B).",
+    ]
+    with pytest.raises(ValueError, match="No predicate sibling/2 in the knowledge base"):
+        translate_rules(ROWS, "souffle", "team", ["sibling/2"])
+    with pytest.raises(ValueError, match="Unknown target 'prolog'"):
+        translate_rules(ROWS, "prolog", "team", [])
+
+
+def test_souffle_types_negation_and_comparisons():
+    lines = translate_rules(ROWS, "souffle", "team", []).text.splitlines()
+
+    assert ".decl age(a1: symbol, a2: number)" in lines
+    assert "childless(A) :- age(A, _), !parent(A, _)." in lines
+    assert "senior(A) :- age(A, B), B > 30." in lines
+    assert lines[-3:] == ["// Not translated:", "// senior(X) :- age(X, A), !.", "//   (uses a cut)"]
+
+
+def test_sql_tables_views_and_recursion():
+    text = translate_rules(ROWS, "sql", "team", []).text
+
+    assert 'CREATE TABLE "age" (a1 TEXT, a2 INTEGER);\nINSERT INTO "age" VALUES (\'tom\', 60);' in text
+    assert (
+        'CREATE RECURSIVE VIEW "ancestor" (a1, a2) AS\n'
+        'SELECT t1.a1 AS a1, t1.a2 AS a2 FROM "parent" AS t1\n'
+        "UNION\n"
+        'SELECT t1.a1 AS a1, t2.a2 AS a2 FROM "parent" AS t1, "ancestor" AS t2 WHERE t2.a1 = t1.a2;'
+    ) in text
+    assert (
+        'SELECT t1.a1 AS a1 FROM "age" AS t1 WHERE NOT EXISTS (SELECT 1 FROM "parent" AS n2 WHERE n2.a1 = t1.a1);'
+    ) in text
+
+
+def test_incomplete_predicates_and_what_depends_on_them():
+    export = translate_rules(ROWS, "sql", "team", [])
+
+    assert (export.incomplete, export.affected) == (["age/2", "senior/1"], ["childless/1"])
+    assert export.warnings == ["Only the first 1 of the 3 clauses of age/2 were read"]
+    assert format_rule_export(export, "rules.sql").splitlines() == [
+        "✅ Translated 7 clause(s) of 5 predicate(s) to SQL, written to rules.sql",
+        "",
+        "⚠️ 1 clause(s) not translated:",
+        "  • senior(X) :- age(X, A), !.  (uses a cut)",
+        "",
+        "🧩 Incomplete: age/2, senior/1",
+        "   and so, through them: childless/1",
+        "⚠️ Only the first 1 of the 3 clauses of age/2 were read",
+    ]
+    assert export.document()["skipped"] == [
+        {"predicate": "senior/1", "clause": "senior(X) :- age(X, A), !.", "reason": "uses a cut"},
+    ]
+
+
+def test_equalities_are_solved():
+    assert translated(term("p", X), term(",", term("parent", X, Y), term("=", Y, atom("ann")))) == (
+        [], 'p(A) :- parent(A, "ann").'
+    )
+    assert translated(term("p", X), term(",", term("parent", X, Y), term("\\+", term("=", Y, atom("bob"))))) == (
+        [], 'p(A) :- parent(A, B), B != "bob".'
+    )
+
+
+@pytest.mark.parametrize("head, body, reason", [
+    (term("p", X), term(",", term("parent", X, Y), term("=", atom("a"), atom("b"))), "never succeeds: 'a' = 'b'"),
+    (term("p", X), term(";", term("parent", X, Y), term("parent", Y, X)), "uses a disjunction or if-then-else"),
+    (term("p", X), term("parent", X, {"type": "list", "items": []}), "has a list argument"),
+    (term("p", X), term("member", X, Y), "calls member/2, which is not a predicate of the knowledge base"),
+    (term("p", X), term("parent", Y, Y), "has a head variable no positive goal binds"),
+    (term("p", X), term(",", term("parent", X, Y), term("\\+", term("p", Y))), "negates its own predicate"),
+    (term("p", X), term(",", term("parent", X, Y), term("<", Y, atom("z"))), "compares text arithmetically"),
+    (term("p", X), var("G"), "calls a variable goal"),
+])
+def test_clauses_outside_the_subset_are_left_out(head, body, reason):
+    (skipped,), _ = translated(head, body)
+
+    assert skipped.startswith(reason)
+
+
+def test_sql_cannot_express_non_linear_recursion():
+    skipped, _ = translated(term("p", X), term(",", term("p", X), term("p", X)), "sql")
+
+    assert skipped == ["calls its own predicate more than once (non-linear recursion), which SQL views cannot express"]
+
+
+def test_call_and_filename():
+    assert rules_export_call("team") == ("mcp_rules_export", ["'team'", "10000"])
+    assert rule_export_filename("rules", "souffle") == "rules.dl"
+    assert rule_export_filename("rules.sql", "sql") == "rules.sql"
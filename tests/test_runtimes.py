@@ -0,0 +1,75 @@
+"""Runtime selection and the nerdctl client's command lines."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.runtimes import (
+    ContainerRuntimeError,
+    NerdctlClient,
+    PodmanRuntime,
+    get_runtime,
+    podman_socket_candidates,
+)
+
+
+def scripted(client, outputs):
+    """Answer the client's nerdctl calls from outputs, recording their arguments."""
+    calls = []
+
+    def run(args, timeout=120, stderr_to_stdout=False):
+        calls.append(args)
+        return outputs.pop(0)
+
+    client._run = run
+    return calls
+
+
+def test_podman_sockets_prefer_the_environment(monkeypatch):
+    monkeypatch.setenv("CONTAINER_HOST", "unix:///tmp/podman/api.sock")
+    monkeypatch.delenv("DOCKER_HOST", raising=False)
+    monkeypatch.setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+
+    assert podman_socket_candidates()[:2] == [
+        "unix:///tmp/podman/api.sock",
+        "unix:///run/user/1000/podman/podman.sock",
+    ]
+
+
+def test_get_runtime():
+    runtime = get_runtime("podman", "unix:///tmp/podman.sock")
+
+    assert isinstance(runtime, PodmanRuntime) and runtime.socket_url == "unix:///tmp/podman.sock"
+    assert runtime.image.startswith("docker.io/")
+    assert get_runtime("nerdctl").name == "nerdctl"
+    with pytest.raises(ValueError, match="Unknown container runtime 'lxc'"):
+        get_runtime("lxc")
+
+
+def test_nerdctl_run_builds_docker_style_arguments():
+    client = NerdctlClient()
+    attrs = {"Id": "abc123", "Name": "/swish", "State": {"Status": "running"}}
+    calls = scripted(client, [b"abc123\n", json.dumps([attrs]).encode()])
+
+    container = client.containers.run(
+        "swipl/swish:latest",
+        name="swish",
+        ports={"3050/tcp": 3050},
+        volumes={"/data": {"bind": "/data", "mode": "rw"}},
+        environment={"LANG": "C.UTF-8"},
+    )
+
+    assert calls[0] == [
+        "run", "-d", "--name", "swish", "-p", "3050:3050", "-v", "/data:/data:rw",
+        "-e", "LANG=C.UTF-8", "swipl/swish:latest",
+    ]
+    assert calls[1][-1] == "abc123"
+    assert (container.id, container.name, container.status) == ("abc123", "swish", "running")
+
+
+def test_nerdctl_missing_container_is_reported():
+    client = NerdctlClient()
+    scripted(client, [b"[]"])
+
+    with pytest.raises(ContainerRuntimeError, match="Container swish not found"):
+        client.containers.get("swish")
@@ -0,0 +1,136 @@
+"""Static checks and run-time guards of sandbox policies."""
+
+import pytest
+
+from docker_swish_mcp import main
+from docker_swish_mcp.sandbox import (
+    PredicateAcl,
+    SandboxConfig,
+    SandboxPolicy,
+    SandboxViolation,
+    acl_pattern,
+    apply_policy,
+    find_violations,
+    policy_guard,
+)
+
+STRICT = SandboxPolicy(mode="strict")
+
+
+def test_strict_vets_data_consult():
+    assert policy_guard("consult('family')", STRICT) == "mcp_consult_vet('family')"
+    assert policy_guard("consult(kb/family)", STRICT) == "mcp_consult_vet(kb/family)"
+
+
+@pytest.mark.parametrize("goal", ["consult('/etc/passwd')", "consult('../secret')", "consult(library(lists))"])
+def test_strict_consult_elsewhere_goes_through_safe_goal(goal):
+    assert "safe_goal" in policy_guard(goal, STRICT)
+
+
+def test_strict_wraps_runtime_goals():
+    goal = "atom_concat(she, ll, F), G =.. [F, id], call(G)"
+
+    assert apply_policy(goal, STRICT) == f"(use_module(library(sandbox)), safe_goal(({goal})), ({goal}))"
+
+
+def test_readonly_rejects_database_changes():
+    with pytest.raises(SandboxViolation, match="assertz/1"):
+        apply_policy("assertz(p(1))", SandboxPolicy(mode="readonly"))
+
+
+def test_policy_modules_and_allowlist_relax_the_static_check():
+    policy = SandboxPolicy(mode="readonly", allow=frozenset({"open/3"}), modules=frozenset({"scratch"}))
+
+    assert find_violations("assertz(scratch:seen(x)), open(f, read, S)", policy) == []
+    assert find_violations("assertz(seen(x))", policy) == ["assertz/1 (database)"]
+    assert find_violations("shell(ls)", policy) == ["shell/1 (shell)"]
+
+
+def test_policies_from_environment(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_SANDBOX", "readonly")
+    monkeypatch.setenv("SWISH_MCP_SANDBOX_ALLOW", "format/2, tab")
+    monkeypatch.setenv("SWISH_MCP_SANDBOX_CLIENTS", '{"agent-1": {"mode": "strict", "modules": ["scratch"]}}')
+
+    config = SandboxConfig.from_env()
+
+    assert config.default == SandboxPolicy(mode="readonly", allow=frozenset({"format/2", "tab"}))
+    assert config.policy_for("agent-1").mode == "strict"
+    assert config.policy_for("agent-1").modules == frozenset({"scratch"})
+    assert config.policy_for("agent-2") is config.default
+
+
+READONLY = SandboxPolicy(mode="readonly")
+
+
+@pytest.mark.parametrize("goal, violation", [
+    ("consult('/etc/passwd')", "consult/1 (file)"),
+    ("load_files('/tmp/x', [])", "load_files/2 (file)"),
+    ("ensure_loaded('/tmp/x')", "ensure_loaded/1 (file)"),
+    ("include('/tmp/x')", "include/1 (file)"),
+    ("use_module('/tmp/m')", "use_module/1 (file)"),
+    ("use_foreign_library('/tmp/x.so')", "use_foreign_library/1 (file)"),
+    ("['/tmp/x']", "[File] consult (file)"),
+    ("true, ['/tmp/x']", "[File] consult (file)"),
+    ("\\+ [x]", "[File] consult (file)"),
+])
+def test_readonly_refuses_loading_files(goal, violation):
+    assert violation in find_violations(goal, READONLY)
+    with pytest.raises(SandboxViolation, match="file"):
+        apply_policy(goal, READONLY)
+
+
+@pytest.mark.parametrize("text", [
+    "consult(family)",
+    "consult('kb/family').",
+    ":- use_module(library(lists)).",
+    "member(X, [a, b])",
+    "format(\"~w~n\", [x])",
+    "greeting --> [hello], [world].",
+])
+def test_readonly_allows_data_consults_and_lists(text):
+    assert find_violations(text, READONLY) == []
+
+
+@pytest.mark.parametrize("pattern, full", [
+    ("parent/2", "*:parent/2"),
+    ("user:parent", "user:parent/*"),
+    ("user:*", "user:*/*"),
+    (" *:counter/1 ", "*:counter/1"),
+])
+def test_acl_patterns_are_normalised(pattern, full):
+    assert acl_pattern(pattern) == full
+
+
+@pytest.mark.parametrize("pattern", ["'user':p", "a b/1", "p\\1", ""])
+def test_acl_refuses_malformed_patterns(pattern):
+    with pytest.raises(ValueError, match="Invalid predicate pattern"):
+        acl_pattern(pattern)
+
+
+def test_acl_matches_module_qualified_predicates():
+    acl = PredicateAcl(calls=(acl_pattern("user:*"), acl_pattern("lists:*")), asserts=())
+
+    assert acl.allows("call", "user:parent/2")
+    assert acl.allows("call", "lists:append/3")
+    assert not acl.allows("call", "system:shell/1")
+    assert not acl.allows("assert", "user:parent/2")
+    assert acl.allows("retract", "user:parent/2")
+    assert acl.describe() == "call: user:*/*, lists:*/*; assert: nothing"
+
+
+def test_local_backend_runs_strict(monkeypatch):
+    monkeypatch.setattr(main.server_config, "backend", "local")
+
+    assert main.sandbox_policy().mode == "strict"
+
+
+async def test_acl_refuses_loading_files(swish, monkeypatch):
+    (swish.data_dir / "facts.pl").write_text("likes(bob, pizza).\n", encoding="utf-8")
+    policies = [SandboxPolicy(acl=PredicateAcl(asserts=()))]
+    monkeypatch.setattr(main, "sandbox_policy", lambda: policies[0])
+
+    result = await main.load_knowledge_base("facts.pl")
+
+    assert "access control list restricts assert" in result
+    policies[0] = SandboxPolicy()
+    assert "false" in await main.execute_prolog_query("catch(likes(bob, X), _, fail)")
@@ -0,0 +1,45 @@
+"""Answers read back from the s(CASP) process."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.scasp import ScaspAnswer, format_answers, parse_scasp_output
+
+
+def row(payload):
+    return f"@MCP scasp SOLUTION {json.dumps(payload)}\n"
+
+
+def test_answers_keep_their_model_and_justification():
+    stdout = row({
+        "bindings": {"X": {"type": "var"}},
+        "constraints": ["X \\= a"],
+        "model": ["p(X)", "not q(X)"],
+        "justification": "p holds for X\n  because q does not\n",
+    }) + row({"bindings": {"X": {"type": "atom", "value": "b"}}}) + "@MCP scasp END\n"
+
+    answers = parse_scasp_output(stdout)
+
+    assert answers[1] == ScaspAnswer({"X": {"type": "atom", "value": "b"}})
+    assert format_answers("p(X)", answers, show_model=True).splitlines() == [
+        "🧩 Query: p(X)",
+        "📋 2 answer(s):",
+        "",
+        "  Answer 1: X \\= a",
+        "  Model: { p(X), not q(X) }",
+        "  Justification:",
+        "    p holds for X",
+        "      because q does not",
+        "",
+        "  Answer 2: X = b",
+    ]
+    assert "Model:" not in format_answers("p(X)", answers, show_model=False)
+
+
+def test_failures_are_raised():
+    with pytest.raises(RuntimeError, match="exited before reporting"):
+        parse_scasp_output(row({"bindings": {}}))
+    with pytest.raises(RuntimeError, match="existence_error"):
+        parse_scasp_output("@MCP scasp ERROR existence_error\n")
+    assert format_answers("p", [], show_model=False) == "❌ Query: p\n📋 No stable model supports the query"
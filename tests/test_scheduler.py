@@ -0,0 +1,42 @@
+"""Cron expressions of scheduled queries."""
+
+from datetime import datetime
+
+import pytest
+
+from docker_swish_mcp.scheduler import CronSchedule, parse_cron_field
+
+
+def test_fields_take_ranges_steps_lists_and_names():
+    assert parse_cron_field("*/15", 0, 59) == {0, 15, 30, 45}
+    assert parse_cron_field("1-5,10", 0, 59) == {1, 2, 3, 4, 5, 10}
+    assert parse_cron_field("10/20", 0, 59) == {10, 30, 50}
+    assert CronSchedule.parse("0 9 * jan-mar mon-fri").months == {1, 2, 3}
+    assert CronSchedule.parse("0 0 * * 7").weekdays == {0}
+
+
+@pytest.mark.parametrize("expression", ["* * * *", "60 * * * *", "*/0 * * * *", "0 0 * foo *", "5-1 * * * *"])
+def test_malformed_expressions_are_refused(expression):
+    with pytest.raises(ValueError, match="Invalid cron expression"):
+        CronSchedule.parse(expression)
+
+
+def test_next_run():
+    moment = datetime(2026, 3, 14, 10, 7, 30)
+
+    assert CronSchedule.parse("*/15 * * * *").next_after(moment) == datetime(2026, 3, 14, 10, 15)
+    assert CronSchedule.parse("@daily").next_after(moment) == datetime(2026, 3, 15, 0, 0)
+    assert CronSchedule.parse("30 8 1 * *").next_after(moment) == datetime(2026, 4, 1, 8, 30)
+    assert CronSchedule.parse("0 0 1 1 *").next_after(moment) == datetime(2027, 1, 1, 0, 0)
+
+
+def test_day_of_month_or_weekday():
+    # Restricted on both, either matches as in cron: the 13th, or any Friday
+    schedule = CronSchedule.parse("0 12 13 * fri")
+
+    assert schedule.next_after(datetime(2026, 3, 9, 0, 0)) == datetime(2026, 3, 13, 12, 0)
+    assert schedule.next_after(datetime(2026, 3, 13, 13, 0)) == datetime(2026, 3, 20, 12, 0)
+
+
+def test_impossible_date_has_no_next_run():
+    assert CronSchedule.parse("0 0 30 2 *").next_after(datetime(2026, 1, 1)) is None
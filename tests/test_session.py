@@ -0,0 +1,147 @@
+"""The persistent session's streaming protocol, and the state it keeps across failing queries."""
+
+import pytest
+
+from docker_swish_mcp import main
+from docker_swish_mcp.config import PrintOptions, QueryLimits
+from docker_swish_mcp.scheduler import ScheduledJob
+from docker_swish_mcp.simple_session import clean_query_text, prolog_string
+
+
+async def events(stream):
+    return [event async for event in stream]
+
+
+@pytest.mark.parametrize("query, clean", [
+    ("?- member(X, [a]).", "member(X, [a])"),
+    ("  true.  ", "true"),
+    ("X = 'a.b'", "X = 'a.b'"),
+])
+def test_clean_query_text(query, clean):
+    assert clean_query_text(query) == clean
+
+
+def test_prolog_string_escapes_quotes_and_newlines():
+    assert prolog_string('say("hi")\\n\n') == '"say(\\"hi\\")\\\\n\\n"'
+
+
+async def test_stream_yields_solutions_and_output(fake_session):
+    session = fake_session(lambda goal: [
+        "hello",
+        "@MCP q0x000000 SOLUTION X = stale",
+        "@MCP {id} SOLUTION X = 1",
+        "@MCP {id} SOLUTION X = 2",
+        "true.",
+        "@MCP {id} END",
+    ])
+
+    found = await events(session.stream_query("member(X, [1, 2])"))
+
+    assert found == [
+        {"type": "output", "text": "hello"},
+        {"type": "solution", "text": "X = 1"},
+        {"type": "solution", "text": "X = 2"},
+    ]
+    assert '"member(X, [1, 2])"' in session.process.goals[0]
+
+
+async def test_closed_output_resets_session(fake_session):
+    session = fake_session(lambda goal: ["@MCP {id} SOLUTION X = 1"])
+
+    with pytest.raises(ConnectionError):
+        await events(session.stream_query("member(X, [1, 2])"))
+
+    assert not session.session_active
+    assert session.process is None
+
+
+def test_limits_override_keeps_defaults_for_unset_values():
+    limits = QueryLimits(wall_seconds=30, cpu_seconds=5)
+
+    assert limits.override(10, None, 0) == QueryLimits(wall_seconds=10, cpu_seconds=5)
+    assert limits.override(inferences=1000).to_prolog() == "limits(30.0, 5.0, 1000)"
+
+
+@pytest.mark.parametrize("error, message", [
+    ("time_limit_exceeded", "wall-clock limit of 2 seconds"),
+    ("cpu_time_limit_exceeded", "CPU time limit of 1.5 seconds"),
+    ("inference_limit_exceeded(500)", "limit of 500 inferences"),
+])
+def test_describe_limit_error(error, message):
+    limits = QueryLimits(wall_seconds=2, cpu_seconds=1.5, inferences=500)
+
+    assert message in main.describe_limit_error(error, limits)
+    assert main.describe_limit_error("type_error(integer, a)", limits) is None
+
+
+async def test_limit_error_keeps_fake_session(fake_session):
+    session = fake_session(lambda goal: [
+        "@MCP {id} SOLUTION X = 1",
+        "@MCP {id} ERROR time_limit_exceeded",
+        "@MCP {id} END",
+    ])
+    process = session.process
+
+    found = await events(session.stream_query("repeat", QueryLimits(wall_seconds=1)))
+
+    assert found[-1] == {"type": "error", "error": "time_limit_exceeded"}
+    assert "limits(1.0, 0.0, 0)" in process.goals[0]
+    assert session.process is process and session.session_active
+
+
+async def test_pretty_solutions_keep_their_line_breaks(fake_session):
+    session = fake_session(lambda goal: ["@MCP {id} SOLUTION X = f(a,\x1e  b)", "@MCP {id} END"])
+
+    found = await events(session.stream_query("X = f(a, b)", printing=PrintOptions(max_depth=3, style="pretty")))
+
+    assert found == [{"type": "solution", "text": "X = f(a,\n  b)"}]
+    assert ", text(print(3, 0, pretty, false)))." in session.process.goals[0]
+
+
+async def test_query_error_keeps_session(swish):
+    session = swish.prolog_session
+    await main.execute_prolog_query("assertz(likes(bob, pizza))")
+    generation = session.generation
+
+    failed = await main.execute_prolog_query("no_such(X)")
+
+    assert "existence_error" in failed
+    assert "X = pizza" in await main.execute_prolog_query("likes(bob, X)")
+    assert session.generation == generation
+
+
+async def test_json_query_error_keeps_session(swish):
+    session = swish.prolog_session
+    await main.execute_prolog_query("assertz(likes(ann, soup))")
+    generation = session.generation
+
+    failed = await main.execute_prolog_query("no_such(X)", output_format="json")
+
+    assert '"existence_error"' in failed
+    assert "X = soup" in await main.execute_prolog_query("likes(ann, X)")
+    assert session.generation == generation
+
+
+async def test_helper_error_keeps_session(swish):
+    session = swish.prolog_session
+    await main.execute_prolog_query("assertz(likes(cy, tea))")
+    generation = session.generation
+
+    with pytest.raises(RuntimeError, match="existence_error"):
+        await main.run_json_helper(swish, ("mcp_no_such_helper", []))
+
+    assert "X = tea" in await main.execute_prolog_query("likes(cy, X)")
+    assert session.generation == generation
+
+
+async def test_scheduled_error_keeps_session(swish):
+    session = swish.prolog_session
+    await main.execute_prolog_query("assertz(likes(di, jam))")
+    generation = session.generation
+    job = ScheduledJob("job1", "no_such(X)", "* * * * *", "client", runnable="no_such(X)")
+
+    run = await main.run_scheduled_job(job)
+
+    assert "existence_error" in run.error
+    assert "X = jam" in await main.execute_prolog_query("likes(di, X)")
+    assert session.generation == generation
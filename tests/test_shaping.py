@@ -0,0 +1,47 @@
+"""Shaping options compiled into wrapper goals around a query."""
+
+import pytest
+
+from docker_swish_mcp.shaping import ResultShape
+
+
+@pytest.mark.parametrize("entry, order", [
+    ("X", ("X", "asc")),
+    ("-Y", ("Y", "desc")),
+    ("+ Y", ("Y", "asc")),
+    ("desc( Age )", ("Age", "desc")),
+])
+def test_order_by_entries(entry, order):
+    assert ResultShape.parse(order_by=[entry]).order_by == [order]
+
+
+@pytest.mark.parametrize("options, message", [
+    ({"order_by": ["X Y"]}, "order_by entries are"),
+    ({"order_by": ["-x"]}, "order_by takes variable names of the query"),
+    ({"distinct": ["_"]}, "distinct takes variable names"),
+    ({"group_by": ["Count"], "count": True}, "group by other variables than the aggregates"),
+    ({"group_by": ["X"], "order_by": ["Y"]}, "Grouped results only bind X; cannot order by Y"),
+])
+def test_bad_options(options, message):
+    with pytest.raises(ValueError, match=message):
+        ResultShape.parse(**options)
+
+
+def test_distinct_and_order_wrap_the_goal():
+    assert not ResultShape.parse().active
+    assert ResultShape.parse(distinct=True).wrap("p(X)") == "distinct((p(X)))"
+    assert ResultShape.parse(distinct=["X"], order_by=["-X"]).wrap("p(X), q(X)") == (
+        "order_by([desc(X)], (distinct([X], (p(X), q(X)))))"
+    )
+
+
+def test_groups_bind_their_keys_and_aggregates():
+    shape = ResultShape.parse(group_by=["Dept"], count=True, sum=["Pay"], order_by=["-SumPay"])
+
+    assert shape.grouped and shape.outputs() == ["Dept", "Count", "SumPay"]
+    assert shape.wrap('staff(N, Dept, Pay), N \\== "x"') == (
+        "order_by([desc(SumPay)], (mcp_group(\"staff(N, Dept, Pay), N \\\\== \\\"x\\\"\", "
+        "['Dept'], [count, sum('Pay')], [Dept], [Count, SumPay])))"
+    )
+    # A count without group_by counts the whole result
+    assert ResultShape.parse(count=True).wrap("p(X)") == 'mcp_group("p(X)", [], [count], [], [Count])'
@@ -0,0 +1,65 @@
+"""Snapshots round-trip a data directory, and restores refuse archives that escape it."""
+
+import io
+import tarfile
+
+import pytest
+
+from docker_swish_mcp.snapshots import (
+    list_snapshots,
+    resolve_snapshot,
+    restore_host_dir,
+    snapshot_host_dir,
+)
+
+
+def write_archive(path, members):
+    """Write a tar of (TarInfo, content) pairs."""
+    with tarfile.open(path, "w") as tar:
+        for info, content in members:
+            if content is not None:
+                info.size = len(content)
+            tar.addfile(info, io.BytesIO(content) if content is not None else None)
+    return path
+
+
+def link(name, target, kind):
+    info = tarfile.TarInfo(name)
+    info.type = kind
+    info.linkname = target
+    return info, None
+
+
+def test_snapshot_round_trip(tmp_path):
+    data_dir = tmp_path / "data"
+    (data_dir / "kb").mkdir(parents=True)
+    (data_dir / "kb" / "family.pl").write_text("parent(tom, bob).\n", encoding="utf-8")
+
+    archive = snapshot_host_dir(data_dir, label="before change")
+    (data_dir / "kb" / "family.pl").write_text("broken(", encoding="utf-8")
+    (data_dir / "stray.pl").write_text("x.\n", encoding="utf-8")
+    restored = restore_host_dir(resolve_snapshot(data_dir, "latest"), data_dir, clean=True)
+
+    assert archive.name.startswith("before_change-")
+    assert list_snapshots(data_dir) == [archive]
+    assert restored == 1
+    assert (data_dir / "kb" / "family.pl").read_text(encoding="utf-8") == "parent(tom, bob).\n"
+    assert not (data_dir / "stray.pl").exists()
+
+
+@pytest.mark.parametrize("member", [
+    (tarfile.TarInfo("/etc/cron.d/evil"), b"x"),
+    (tarfile.TarInfo("data/../../evil.pl"), b"x"),
+    (tarfile.TarInfo("other/evil.pl"), b"x"),
+    link("data/passwd", "/etc/passwd", tarfile.SYMTYPE),
+    link("data/shadow", "/etc/shadow", tarfile.LNKTYPE),
+])
+def test_restore_refuses_escaping_members(tmp_path, member):
+    data_dir = tmp_path / "data"
+    archive = write_archive(tmp_path / "evil.tar", [(tarfile.TarInfo("data/ok.pl"), b"ok.\n"), member])
+
+    with pytest.raises(ValueError, match="Refusing"):
+        restore_host_dir(archive, data_dir, clean=True)
+
+    assert not data_dir.exists()
+    assert not (tmp_path / "evil.pl").exists()
@@ -0,0 +1,55 @@
+"""Results too large for the tool result, written to results/ instead."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.spill import (
+    SpillError,
+    SpillThresholds,
+    prune_results,
+    read_result,
+    results_size,
+    solution_lines,
+    spill_results,
+)
+
+
+def test_thresholds():
+    thresholds = SpillThresholds(solutions=2, bytes=20)
+
+    assert not thresholds.exceeded(["X = 1", "X = 2"], structured=False)
+    assert thresholds.exceeded(["X = 1", "X = 2", "X = 3"], structured=False)
+    assert thresholds.exceeded(["X = 'an atom longer than that'"], structured=False)
+    assert not SpillThresholds(solutions=0, bytes=0).exceeded(["x"] * 5000, structured=False)
+
+
+def test_one_line_per_solution():
+    assert solution_lines(["X = a\\b,\nY = c"], structured=False) == ["X = a\\\\b,\\nY = c"]
+    assert solution_lines([{"X": "é"}], structured=True) == ['{"X": "é"}']
+    assert results_size([{"X": "é"}], structured=True) == len('{"X": "é"}'.encode()) + 1
+
+
+def test_spilled_results_can_be_read_back(tmp_path):
+    spilled = spill_results(tmp_path, [{"X": 1}, {"X": 2}], structured=True)
+
+    assert spilled.uri == f"swish://results/{spilled.name}" and spilled.name.endswith(".jsonl")
+    assert (spilled.count, spilled.path) == (2, f"results/{spilled.name}")
+    assert [json.loads(line) for line in read_result(tmp_path, spilled.name).splitlines()] == [{"X": 1}, {"X": 2}]
+
+
+def test_only_spill_files_can_be_read(tmp_path):
+    with pytest.raises(SpillError, match="not a spilled query result"):
+        read_result(tmp_path, "../kb.pl")
+    with pytest.raises(SpillError, match="no longer exists"):
+        read_result(tmp_path, "q-20260101-000000-0123abcd.txt")
+
+
+def test_old_files_are_pruned(tmp_path):
+    names = [f"q-2026010{day}-000000-0123abcd.txt" for day in range(1, 5)]
+    for name in [*names, "notes.txt"]:
+        (tmp_path / name).write_text("", encoding="utf-8")
+
+    prune_results(tmp_path, keep=2)
+
+    assert sorted(path.name for path in tmp_path.iterdir()) == ["notes.txt", *names[2:]]
@@ -0,0 +1,75 @@
+"""Startup programs from settings, resolved to what the session loads."""
+
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp import startup
+from docker_swish_mcp.config import StartupProgram, StartupSettings
+from docker_swish_mcp.remote_sources import RemoteSourceError
+from docker_swish_mcp.startup import startup_plan
+
+
+def test_programs_from_settings():
+    settings = StartupSettings().with_settings({"on_error": "abort", "programs": [
+        "family.pl", "https://example.org/rules.pl", {"code": ":- dynamic seen/1.\nseen(a)."},
+    ]})
+
+    assert settings.on_error == "abort"
+    assert [program.label() for program in settings.programs] == [
+        "family.pl", "https://example.org/rules.pl", "code: :- dynamic seen/1.",
+    ]
+
+
+@pytest.mark.parametrize("entry, message", [
+    ("../etc/rules.pl", "must be relative to the data directory"),
+    ({"file": "a.pl", "code": "a."}, "exactly one of file, url or code"),
+    ({"path": "a.pl"}, "Unknown startup program keys"),
+    (3, "a string or a table"),
+])
+def test_bad_programs_are_refused(entry, message):
+    with pytest.raises(ValueError, match=message):
+        StartupProgram.from_setting(entry)
+
+
+def test_from_env_skips_bad_entries(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_STARTUP", "family.pl, /etc/passwd, https://example.org/rules.pl")
+    monkeypatch.setenv("SWISH_MCP_STARTUP_ON_ERROR", "panic")
+
+    settings = StartupSettings.from_env()
+
+    assert [program.label() for program in settings.programs] == ["family.pl", "https://example.org/rules.pl"]
+    assert settings.on_error == "warn"
+
+
+def fetch_from(sources):
+    async def fetch_source(data_dir, url, checksum="", refresh=False, **kwargs):
+        if url not in sources:
+            raise RemoteSourceError(f"Cannot fetch {url}")
+        return SimpleNamespace(consult_name=sources[url])
+    return fetch_source
+
+
+async def test_plan_keeps_the_listed_order(monkeypatch, tmp_path):
+    monkeypatch.setattr(startup, "fetch_source", fetch_from({"https://example.org/rules.pl": "url-cache/rules.pl"}))
+    settings = StartupSettings().with_settings({"programs": [
+        "https://example.org/rules.pl", "family.pl", {"code": 'x("a").'},
+    ]})
+
+    plan = await startup_plan(settings, tmp_path)
+
+    assert plan.items == ["file('url-cache/rules.pl')", "file('family.pl')", "code('startup-3', \"x(\\\"a\\\").\")"]
+    assert plan.call_args()[1] == "warn" and plan.failed == []
+
+
+@pytest.mark.parametrize("policy, items", [("warn", ["file('family.pl')"]), ("abort", [])])
+async def test_failed_downloads(monkeypatch, tmp_path, policy, items):
+    monkeypatch.setattr(startup, "fetch_source", fetch_from({}))
+    settings = StartupSettings(
+        (StartupProgram(url="https://example.org/gone.pl"), StartupProgram(file="family.pl")), policy,
+    )
+
+    plan = await startup_plan(settings, tmp_path)
+
+    assert plan.items == items
+    assert plan.failed == [("https://example.org/gone.pl", "Cannot fetch https://example.org/gone.pl")]
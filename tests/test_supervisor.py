@@ -0,0 +1,43 @@
+"""Health probes, and restarts backing off while they fail."""
+
+from docker_swish_mcp.supervisor import ContainerSupervisor
+
+
+def supervisor(probes, restarts):
+    async def probe():
+        result = probes.pop(0)
+        if isinstance(result, Exception):
+            raise result
+        return result
+
+    async def restart():
+        return restarts.pop(0)
+
+    return ContainerSupervisor("swish-test", probe, restart, backoff_base=0.001, backoff_max=0.004)
+
+
+async def test_failed_probes_mark_unhealthy_until_one_succeeds():
+    watcher = supervisor([False, ConnectionError("refused"), True], [])
+
+    assert not await watcher.check_now()
+    assert not await watcher.check_now()
+    status = watcher.get_status()
+    assert (status["status"], status["consecutive_failures"], status["last_error"]) == ("unhealthy", 2, "refused")
+
+    assert await watcher.check_now()
+    assert watcher.get_status()["status"] == "healthy"
+    assert watcher.get_status()["consecutive_failures"] == 0
+
+
+async def test_failed_restarts_double_the_backoff_up_to_its_cap():
+    watcher = supervisor([], [False, False, False, True])
+
+    for _ in range(3):
+        await watcher._restart_with_backoff()
+    assert watcher.state.status == "failed"
+    assert watcher._backoff == 0.004
+
+    await watcher._restart_with_backoff()
+    assert watcher.state.status == "healthy"
+    assert watcher.state.restarts == 4
+    assert watcher._backoff == 0.001
@@ -0,0 +1,94 @@
+"""Retries and the circuit breaker of SwishHttp, with scripted attempts."""
+
+from types import SimpleNamespace
+
+import aiohttp
+import pytest
+
+from docker_swish_mcp import swish_http
+from docker_swish_mcp.swish_http import (
+    CircuitBreaker,
+    HttpReply,
+    RetryPolicy,
+    SwishHttp,
+    SwishRequestFailed,
+    SwishUnavailable,
+)
+
+NO_WAIT = RetryPolicy(retries=2, backoff=0, breaker_failures=2, breaker_reset=30)
+
+
+def scripted(monkeypatch, client, outcomes):
+    """Make client's attempts return or raise outcomes in turn; returns the attempts made."""
+    attempts = []
+
+    async def attempt(method, path, timeout, **kwargs):
+        attempts.append(path)
+        outcome = outcomes.pop(0)
+        if isinstance(outcome, Exception):
+            raise outcome
+        return HttpReply(*outcome)
+
+    monkeypatch.setattr(client, "_attempt", attempt)
+    return attempts
+
+
+async def test_unready_replies_are_retried(monkeypatch):
+    client = SwishHttp("http://localhost:3050/", NO_WAIT)
+    attempts = scripted(monkeypatch, client, [(503, "starting"), (200, '{"ok": true}')])
+
+    reply = await client.request("GET", "/pengine/list")
+
+    assert reply.json() == {"ok": True}
+    assert attempts == ["/pengine/list", "/pengine/list"]
+    assert client.get_status() == {"circuit": "closed", "consecutive_failures": 0, "retries": 1}
+
+
+async def test_requests_that_must_not_run_twice_are_not_retried(monkeypatch):
+    client = SwishHttp("http://localhost:3050", NO_WAIT)
+    attempts = scripted(monkeypatch, client, [(503, "busy"), aiohttp.ServerDisconnectedError()])
+
+    with pytest.raises(SwishRequestFailed) as raised:
+        await client.request("POST", "/pengine/create", idempotent=False)
+    with pytest.raises(SwishUnavailable, match="dropped the connection"):
+        await client.request("POST", "/pengine/send", idempotent=False)
+
+    assert raised.value.status == 503
+    assert len(attempts) == 2
+
+
+async def test_error_replies_are_not_retried(monkeypatch):
+    client = SwishHttp("http://localhost:3050", NO_WAIT)
+    scripted(monkeypatch, client, [(404, "no such pengine")])
+
+    with pytest.raises(SwishRequestFailed, match="SWISH returned HTTP 404: no such pengine"):
+        await client.request("GET", "/pengine/ping")
+    assert client.breaker.failures == 0
+
+
+async def test_unreachable_swish_opens_the_circuit(monkeypatch):
+    client = SwishHttp("http://localhost:3050", NO_WAIT)
+    attempts = scripted(monkeypatch, client, [aiohttp.ServerDisconnectedError()] * 6)
+
+    for _ in range(2):
+        with pytest.raises(SwishUnavailable, match="not accepting requests"):
+            await client.request("GET", "/")
+    with pytest.raises(SwishUnavailable, match="failed 2 requests in a row"):
+        await client.request("GET", "/")
+
+    assert len(attempts) == 6
+    assert client.breaker.state == "open"
+
+
+def test_half_open_circuit_lets_one_trial_through(monkeypatch):
+    now = [100.0]
+    monkeypatch.setattr(swish_http, "time", SimpleNamespace(monotonic=lambda: now[0]))
+    breaker = CircuitBreaker(failures=1, reset_seconds=10)
+
+    breaker.failure()
+    assert (breaker.state, breaker.allow(), breaker.retry_in()) == ("open", False, 10.0)
+    now[0] += 10
+    assert breaker.state == "half-open"
+    assert breaker.allow() and not breaker.allow()
+    breaker.success()
+    assert breaker.state == "closed" and breaker.allow()
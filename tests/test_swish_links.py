@@ -0,0 +1,44 @@
+"""Permalinks and editor links to programs in the SWISH web UI."""
+
+from urllib.parse import parse_qs, urlsplit
+
+import pytest
+
+from docker_swish_mcp.swish_links import (
+    MAX_EDITOR_URL,
+    editor_link,
+    permalink,
+    public_link,
+    storage_payload,
+    with_examples,
+)
+
+BASE = "https://swish.example.org/prolog/"
+
+
+def test_query_becomes_the_examples_block():
+    assert with_examples("p(1).\n", "?- p(X).") == "p(1).\n\n/** <examples>\n\n?- p(X).\n\n*/\n"
+    assert with_examples("p(1).\n", "") == "p(1).\n"
+
+
+def test_storage_payload_checks_the_name():
+    assert storage_payload("p(1).", name="family.pl", title="Family") == {
+        "data": "p(1).", "type": "pl", "meta": {"public": False, "name": "family.pl", "title": "Family"},
+    }
+    with pytest.raises(ValueError, match="Invalid permalink name '../x'"):
+        storage_payload("p(1).", name="../x")
+
+
+def test_links_use_the_public_host():
+    assert public_link(BASE, "http://localhost:3050/p/family.pl") == "https://swish.example.org/prolog/p/family.pl"
+    assert permalink(BASE, {"url": "/p/family.pl"}) == "https://swish.example.org/prolog/p/family.pl"
+    with pytest.raises(ValueError, match="did not save the program: denied"):
+        permalink(BASE, {"error": "denied"})
+
+
+def test_editor_link_is_capped():
+    link = editor_link(BASE, "p(1).", "p(X).")
+
+    assert parse_qs(urlsplit(link).query) == {"code": ["p(1)."], "q": ["p(X)"]}
+    with pytest.raises(ValueError, match=f"at most {MAX_EDITOR_URL}"):
+        editor_link(BASE, "p(1).\n" * MAX_EDITOR_URL)
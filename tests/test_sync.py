@@ -0,0 +1,95 @@
+"""Two-way workspace sync."""
+
+import os
+
+import pytest
+
+from docker_swish_mcp.sync import CONFLICT_SUFFIX, WorkspaceSync, scan_tree
+
+
+async def ignore(files):
+    pass
+
+
+@pytest.fixture
+def trees(tmp_path):
+    workspace, data = tmp_path / "workspace", tmp_path / "data"
+    workspace.mkdir()
+    data.mkdir()
+    return workspace, data, WorkspaceSync(workspace, data, ignore)
+
+
+def touch(path, text, mtime):
+    path.parent.mkdir(parents=True, exist_ok=True)
+    path.write_text(text, encoding="utf-8")
+    os.utime(path, ns=(mtime, mtime))
+
+
+def test_first_sync_copies_workspace_to_data(trees):
+    workspace, data, sync = trees
+    touch(workspace / "lib" / "family.pl", "parent(a, b).\n", 1_000)
+    touch(workspace / "notes.txt", "not synced\n", 1_000)
+
+    report = sync.sync_once()
+
+    assert report.to_data == ["lib/family.pl"]
+    assert (data / "lib" / "family.pl").read_text(encoding="utf-8") == "parent(a, b).\n"
+    assert not (data / "notes.txt").exists()
+
+
+def test_data_changes_go_back_and_deletes_follow(trees):
+    workspace, data, sync = trees
+    touch(workspace / "kb.pl", "a.\n", 1_000)
+    sync.sync_once()
+
+    touch(data / "kb.pl", "b.\n", 2_000)
+    assert sync.sync_once().to_workspace == ["kb.pl"]
+    assert (workspace / "kb.pl").read_text(encoding="utf-8") == "b.\n"
+
+    (workspace / "kb.pl").unlink()
+    assert sync.sync_once().deleted == ["kb.pl"]
+    assert not (data / "kb.pl").exists()
+
+
+def test_conflict_keeps_older_version(trees):
+    workspace, data, sync = trees
+    touch(workspace / "kb.pl", "base.\n", 1_000)
+    sync.sync_once()
+
+    touch(workspace / "kb.pl", "mine.\n", 2_000)
+    touch(data / "kb.pl", "theirs.\n", 3_000)
+    report = sync.sync_once()
+
+    assert report.conflicts == ["kb.pl"]
+    assert (workspace / "kb.pl").read_text(encoding="utf-8") == "theirs.\n"
+    assert (workspace / f"kb.pl{CONFLICT_SUFFIX}").read_text(encoding="utf-8") == "mine.\n"
+
+
+def test_state_survives_restart(trees):
+    workspace, data, sync = trees
+    touch(workspace / "kb.pl", "a.\n", 1_000)
+    sync.sync_once()
+    (data / "kb.pl").unlink()
+
+    restarted = WorkspaceSync(workspace, data, ignore)
+    restarted.load()
+
+    assert restarted.sync_once().deleted == ["kb.pl"]
+    assert not (workspace / "kb.pl").exists()
+
+
+def test_symlinks_are_not_followed(trees, tmp_path):
+    workspace, data, sync = trees
+    outside = tmp_path / "outside"
+    touch(outside / "secret.pl", "secret.\n", 1_000)
+    (workspace / "secret.pl").symlink_to(outside / "secret.pl")
+    (workspace / "linked").symlink_to(outside, target_is_directory=True)
+    touch(workspace / "kb.pl", "a.\n", 1_000)
+    (data / "kb.pl").symlink_to(outside / "secret.pl")
+
+    report = sync.sync_once()
+
+    assert scan_tree(workspace) == {"kb.pl": 1_000}
+    assert not report
+    assert not (data / "secret.pl").exists()
+    assert (outside / "secret.pl").read_text(encoding="utf-8") == "secret.\n"
@@ -0,0 +1,48 @@
+"""Table specifications, the calls declaring them and table statistics."""
+
+import pytest
+
+from docker_swish_mcp.tabling import (
+    abolish_call,
+    format_table_stats,
+    table_call,
+    table_spec,
+)
+
+
+@pytest.mark.parametrize("text", ["path/2", " path(_, _, min) ", "conn(_, lattice(shortest/3))", "tc(index, _, po(lt))"])
+def test_table_specs(text):
+    assert table_spec(text) == text.strip()
+
+
+@pytest.mark.parametrize("text", ["Path/2", "path", "path(X, Y)", "path/2, shell(rm)", "path(_, _, min) :- true"])
+def test_bad_table_specs(text):
+    with pytest.raises(ValueError, match="Invalid table specification"):
+        table_spec(text)
+
+
+def test_calls():
+    assert table_call("user", ["path/2", "cost(_, min)"], "incremental") == (
+        "mcp_table", ["'user'", '["path/2", "cost(_, min)"]', "incremental"],
+    )
+    assert abolish_call("user") == ("mcp_table_abolish", ["'user'", '""'])
+    with pytest.raises(ValueError, match="Unknown tabling mode 'lazy'"):
+        table_call("user", ["path/2"], "lazy")
+    with pytest.raises(ValueError, match="No predicates given"):
+        table_call("user", [])
+    with pytest.raises(ValueError, match="Invalid predicate indicator 'path'"):
+        abolish_call("user", "path")
+
+
+def test_format_table_stats():
+    rows = [
+        {"predicate": "path/2", "modes": [], "tables": 3, "answers": 12, "bytes": 2048},
+        {"table_space_used": 4096, "table_space": 1024 ** 3},
+    ]
+
+    assert format_table_stats("user", rows).splitlines() == [
+        "📊 Tabled predicates in user: 1",
+        "  • path/2 (variant): 3 table(s), 12 answer(s), 2.0KiB",
+        "💾 Table space used: 4.0KiB of 1.0GiB",
+    ]
+    assert "(none; declare some" in format_table_stats("user", [])
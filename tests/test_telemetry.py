@@ -0,0 +1,66 @@
+"""Goal redaction and the tools/call spans, with a recording tracer."""
+
+from contextlib import contextmanager
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp.telemetry import (
+    NoSpan,
+    Telemetry,
+    instrument_tool_spans,
+    redact_goal,
+    telemetry,
+)
+
+
+@pytest.mark.parametrize("goal, redacted", [
+    ("parent(tom, X)", "parent(?, X)"),
+    ("age(P, 42), A is 2.5e3 * 'Big Ben'", "age(P, ?), A is ? * ?"),
+    ('format("~w", [secret]), true', "format(?, [?]), true"),
+    ("X = 0'a", "X = ?"),
+])
+def test_redact_goal(goal, redacted):
+    assert redact_goal(goal) == redacted
+
+
+def test_goal_modes():
+    tracing = Telemetry()
+
+    assert tracing.goal("p(a)") == "p(a)"
+    tracing.configure("off", "redacted", "1.0")
+    assert (tracing.enabled, tracing.goal("p(a)")) == (False, "p(?)")
+    tracing.goals = "omit"
+    assert tracing.goal("p(a)") is None
+    with tracing.span("off", {"a": 1}) as span:
+        assert isinstance(span, NoSpan)
+
+
+class Tracer:
+    def __init__(self):
+        self.spans = []
+
+    @contextmanager
+    def start_as_current_span(self, name, attributes):
+        span = SimpleNamespace(name=name, attributes=dict(attributes))
+        span.set_attribute = span.attributes.__setitem__
+        self.spans.append(span)
+        yield span
+
+
+async def test_tool_calls_get_a_span(monkeypatch):
+    tracer = Tracer()
+    monkeypatch.setattr(telemetry, "tracer", tracer)
+
+    async def call_tool(name, arguments):
+        return f"❌ {name} failed" if arguments.get("fail") else "ok"
+
+    server = SimpleNamespace(_tool_manager=SimpleNamespace(call_tool=call_tool))
+    instrument_tool_spans(server, lambda: "alice", lambda result: result.startswith("❌"))
+
+    assert await server._tool_manager.call_tool("lint_program", {}) == "ok"
+    await server._tool_manager.call_tool("lint_program", {"fail": True})
+
+    assert [span.name for span in tracer.spans] == ["tools/call lint_program"] * 2
+    assert tracer.spans[0].attributes["mcp.client.id"] == "alice"
+    assert [span.attributes["mcp.tool.status"] for span in tracer.spans] == ["ok", "error"]
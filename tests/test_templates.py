@@ -0,0 +1,48 @@
+"""Typed literals of query templates."""
+
+import pytest
+
+from docker_swish_mcp.templates import QueryTemplate, TemplateParam, infer_literal
+
+
+def test_values_cannot_escape_their_literal():
+    template = QueryTemplate.build("likes", "likes({{person}}, X)", {"person": "atom"})
+
+    assert template.render({"person": "bob), retractall(x(_)"}) == "likes('bob), retractall(x(_)', X)"
+    assert template.render({"person": "o'brien"}) == "likes('o\\'brien', X)"
+
+
+def test_literals_of_each_type():
+    assert TemplateParam.parse("n", "integer").literal("21") == "21"
+    assert TemplateParam.parse("f", "float").literal(1e-05) == "1.0e-05"
+    assert TemplateParam.parse("f", "float").literal(100) == "100.0"
+    assert TemplateParam.parse("s", "string").literal('say "hi"') == '"say \\"hi\\""'
+    assert TemplateParam.parse("b", "boolean").literal(False) == "false"
+    assert TemplateParam.parse("l", "list[atom]").literal(["a", "b"]) == "['a','b']"
+    assert infer_literal([1, 2.5, True, {"string": "x"}], "v") == '[1,2.5,true,"x"]'
+
+
+@pytest.mark.parametrize("kind, value", [
+    ("integer", 1.5), ("integer", True), ("float", float("inf")), ("atom", 3), ("list[integer]", [1, "x"]),
+])
+def test_mistyped_values_are_refused(kind, value):
+    with pytest.raises(ValueError):
+        TemplateParam.parse("p", kind).literal(value)
+
+
+def test_defaults_and_choices():
+    template = QueryTemplate.build(
+        "older", "age(P, A), A >= {{min}}, role(P, {{role}})",
+        {"min": {"type": "integer", "default": 18}, "role": {"type": "atom", "choices": ["dev", "ops"]}},
+    )
+
+    assert template.render({"role": "dev"}) == "age(P, A), A >= 18, role(P, 'dev')"
+    with pytest.raises(ValueError, match="must be one of"):
+        template.render({"role": "root"})
+    with pytest.raises(ValueError, match="needs a value for 'role'"):
+        template.render({})
+
+
+def test_placeholders_inside_quotes_are_refused():
+    with pytest.raises(ValueError, match="inside quotes"):
+        QueryTemplate.build("quoted", "format('{{name}}')", {"name": "atom"})
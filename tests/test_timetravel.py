@@ -0,0 +1,91 @@
+"""Past moments and the database replayed back to them."""
+
+import time
+
+import pytest
+
+from docker_swish_mcp.audit import AuditEntry
+from docker_swish_mcp.timetravel import (
+    AsOf,
+    asof_load_call,
+    format_notes,
+    replay_database,
+)
+
+NOW = 1_800_000_000.0
+CURRENT = [{"name": "seen", "arity": 1, "clauses": ["seen(a)", "seen(c)"]}]
+
+
+def entry(seq, at, kind="database", delta=None, changes=()):
+    return AuditEntry(seq, at, "client", "execute_prolog_query", kind, "", list(changes), delta=delta)
+
+
+ENTRIES = [
+    entry(1, NOW - 300, delta={"user": {"seen/1": {"added": ["seen(a)"], "removed": []}}}),
+    entry(2, NOW - 200, kind="files", changes=["modified family.pl"]),
+    entry(3, NOW - 100, delta={"user": {"seen/1": {"added": ["seen(c)"], "removed": ["seen(b)"]}}}),
+]
+
+
+@pytest.mark.parametrize("text, moment", [
+    ("#12", AsOf(seq=12)),
+    ("2h ago", AsOf(time=NOW - 7200)),
+    ("-30m", AsOf(time=NOW - 1800)),
+    ("1.5d", AsOf(time=NOW - 1.5 * 86400)),
+    (str(NOW - 5), AsOf(time=NOW - 5)),
+])
+def test_moments(text, moment):
+    assert AsOf.parse(text, now=NOW) == moment
+
+
+@pytest.mark.parametrize("text, message", [
+    ("#last", "takes a kb_history\\(\\) entry number"),
+    ("yesterday", "as_of takes a timestamp"),
+    (str(NOW + 60), "is in the future"),
+])
+def test_bad_moments(text, message):
+    with pytest.raises(ValueError, match=message):
+        AsOf.parse(text, now=NOW)
+
+
+def test_timestamps_are_local_time_unless_they_have_an_offset():
+    moment = AsOf.parse("2026-10-13 09:00", now=NOW)
+
+    assert moment.time == time.mktime((2026, 10, 13, 9, 0, 0, 0, 0, -1))
+    assert AsOf.parse("2026-10-13T09:00:00+00:00", now=NOW).time == 1791882000.0
+    assert moment.describe() == "2026-10-13 09:00:00"
+    assert AsOf(seq=3).describe() == "just after #3"
+
+
+def test_replay_undoes_later_changes_newest_first():
+    predicates, notes = replay_database(ENTRIES, CURRENT, "user", AsOf(seq=1))
+
+    assert predicates == [{"name": "seen", "arity": 1, "clauses": ["seen(a)", "seen(b)"]}]
+    assert notes == [
+        "Rebuilt by undoing 1 logged database change(s) since just after #1",
+        "Files changed since, used as loaded now: family.pl",
+    ]
+    assert replay_database(ENTRIES, CURRENT, "user", AsOf(time=NOW - 400))[0][0]["clauses"] == ["seen(b)"]
+    assert replay_database(ENTRIES, CURRENT, "team", AsOf(seq=3))[1] == ["No logged database change since just after #3"]
+
+
+def test_replay_stops_at_changes_logged_without_clauses():
+    entries = [*ENTRIES, entry(4, NOW - 50)]
+
+    with pytest.raises(ValueError, match="#4 \\(execute_prolog_query\\) was logged without its clauses"):
+        replay_database(entries, CURRENT, "user", AsOf(seq=3))
+    with pytest.raises(ValueError, match="kb_history\\(\\) has no entry #9"):
+        replay_database(entries, CURRENT, "user", AsOf(seq=9))
+
+
+def test_moments_before_the_log_are_noted():
+    _, notes = replay_database(ENTRIES, CURRENT, "user", AsOf(time=NOW - 400))
+
+    assert notes[1] == "The log starts at #1; changes before it are not known"
+    assert format_notes(notes[:1]).startswith("🕰️ Rebuilt by undoing 2 logged database change(s) since ")
+
+
+def test_asof_load_call():
+    assert asof_load_call("user", "mcp_asof_1", CURRENT) == (
+        "mcp_asof_load", ["'user'", "'mcp_asof_1'", "[pred('seen', 1, [\"seen(a)\", \"seen(c)\"])]"],
+    )
@@ -0,0 +1,83 @@
+"""Tool exposure profiles and the profile each connection gets."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.auth import ApiKey
+from docker_swish_mcp.tool_profiles import (
+    BUILTIN_PROFILES,
+    ProfileSet,
+    ToolProfile,
+    exposed,
+    parse_profiles,
+)
+
+PROFILES = {"profiles": {
+    "reviewer": {"scope": "query", "exclude": ["kb_*"]},
+    "ops": {"scope": "query", "include": "restart_swish_container"},
+}}
+
+
+def key(*scopes, profile=""):
+    return ApiKey("k", "digest", frozenset(scopes), profile)
+
+
+@pytest.mark.parametrize("tool, analyst, developer, admin", [
+    ("execute_prolog_query", True, True, True),
+    ("create_prolog_file", False, True, True),
+    ("restart_swish_container", False, False, True),
+])
+def test_builtin_profiles_follow_the_scopes(tool, analyst, developer, admin):
+    assert [BUILTIN_PROFILES[name].allows(tool) for name in ("analyst", "developer", "admin")] == [analyst, developer, admin]
+
+
+def test_configured_profiles_add_and_remove_tools():
+    profiles = parse_profiles(PROFILES)
+
+    assert not profiles["reviewer"].allows("kb_search") and profiles["reviewer"].allows("execute_prolog_query")
+    assert profiles["ops"].allows("restart_swish_container") and not profiles["ops"].allows("create_prolog_file")
+    assert profiles["reviewer"].describe() == "reviewer: query tools without kb_*"
+    assert ToolProfile("x", "write", include=("a",), exclude=("b",)).describe() == "x: write tools plus a without b"
+
+
+@pytest.mark.parametrize("raw, message", [
+    ([], "Profiles must be an object"),
+    ({"a": "query"}, "Profile 'a' must be an object"),
+    ({"a": {"scope": "root"}}, "Profile 'a' has unknown scope 'root'"),
+    ({"a": {"include": [1]}}, "Profile 'a': include must be a list"),
+])
+def test_bad_profiles(raw, message):
+    with pytest.raises(ValueError, match=message):
+        parse_profiles(raw)
+
+
+def test_profiles_from_a_file_or_text(tmp_path):
+    path = tmp_path / "profiles.json"
+    path.write_text(json.dumps(PROFILES), encoding="utf-8")
+
+    assert ProfileSet(str(path), "ops").default == "ops"
+    assert ProfileSet(json.dumps(PROFILES)).names() == ["admin", "analyst", "developer", "ops", "reviewer"]
+    # A broken definition, or an unknown default, falls back to the analyst profile
+    assert ProfileSet("{not json", "admin").default == "analyst"
+    assert ProfileSet("", "nobody").default == "analyst"
+
+
+def test_connection_profiles():
+    profiles = ProfileSet(json.dumps(PROFILES), "developer")
+
+    assert profiles.key_profile(None).name == "developer"
+    assert profiles.key_profile(key("query", "write")).name == "developer"
+    assert profiles.key_profile(key("query", profile="ops")).name == "ops"
+    assert profiles.key_profile(key("query", profile="gone")).name == "none"
+    assert [profile.name for profile in profiles.connection_profiles(None, "reviewer")] == ["developer", "reviewer"]
+    assert profiles.connection_profiles(None, "nobody")[1].name == "none"
+
+
+def test_exposed_needs_every_profile_and_the_keys_scope():
+    developer, reviewer = BUILTIN_PROFILES["developer"], parse_profiles(PROFILES)["reviewer"]
+
+    assert exposed("execute_prolog_query", [developer, reviewer], None)
+    assert not exposed("kb_search", [developer, reviewer], None)
+    assert not exposed("create_prolog_file", [developer], key("query"))
+    assert not exposed("execute_prolog_query", [BUILTIN_PROFILES["admin"]], key())
@@ -0,0 +1,85 @@
+"""Tool input schemas from signatures and docstrings, and the checks against them."""
+
+import pytest
+
+from docker_swish_mcp.errors import ToolError
+from docker_swish_mcp.tool_schemas import (
+    docstring_args,
+    structured_result,
+    tool_input_schema,
+    type_schema,
+    validate,
+)
+
+
+async def run_goal(goal: str, timeout: float = 10.0, output_format: str = "text", tags: list[str] | None = None) -> str:
+    """
+    Run a goal.
+
+    Args:
+        goal: The goal to run, with or
+            without its final full stop
+        timeout (float): Seconds to wait
+
+    Returns:
+        The answers
+    """
+    return goal
+
+
+def test_docstring_args_join_continuation_lines():
+    assert docstring_args(run_goal.__doc__) == {
+        "goal": "The goal to run, with or without its final full stop",
+        "timeout": "Seconds to wait",
+    }
+    assert docstring_args("No arguments here.") == {}
+
+
+@pytest.mark.parametrize("annotation, schema", [
+    (int, {"type": "integer"}),
+    (str | None, {"type": ["string", "null"]}),
+    (list[str], {"type": "array", "items": {"type": "string"}}),
+    (dict[str, int], {"type": "object", "additionalProperties": {"type": "integer"}}),
+    (list[int] | None, {"anyOf": [{"type": "array", "items": {"type": "integer"}}, {"type": "null"}]}),
+])
+def test_type_schema(annotation, schema):
+    assert type_schema(annotation) == schema
+
+
+def test_input_schema_has_descriptions_defaults_and_rules():
+    schema = tool_input_schema("run_goal", run_goal)
+
+    assert schema["required"] == ["goal"] and schema["additionalProperties"] is False
+    assert schema["properties"]["timeout"] == {
+        "type": "number", "description": "Seconds to wait", "exclusiveMinimum": 0, "default": 10.0,
+    }
+    assert schema["properties"]["output_format"]["enum"] == ["text", "json"]
+
+
+def test_validate_lists_every_problem():
+    schema = tool_input_schema("run_goal", run_goal)
+
+    assert validate({"goal": "true", "timeout": 30}, schema, "") == []
+    assert validate({"timeout": 0, "output_format": "JSON", "tags": ["a", 1], "verbose": True}, schema, "") == [
+        "goal is required",
+        "timeout must be more than 0, not 0",
+        'output_format must be one of "text", "json", not "JSON" (string)',
+        "tags[1] must be a string, not 1 (integer)",
+        "verbose is not known here; expected one of: goal, timeout, output_format, tags",
+    ]
+
+
+def test_whole_floats_pass_as_integers():
+    assert validate(30.0, {"type": "integer"}, "limit") == []
+    assert validate(2.5, {"type": "integer"}, "limit") == ["limit must be an integer, not 2.5 (number)"]
+    assert validate(True, {"type": "integer"}, "limit") == ["limit must be an integer, not true (boolean)"]
+
+
+def test_structured_results_carry_json_and_typed_errors():
+    document = structured_result("describe_term", '{"functor": "f", "arity": 2}')
+    failed = structured_result("describe_term", ToolError("timeout", "Query timed out", {"limit": "wall"}).render())
+
+    assert document["json"] == {"functor": "f", "arity": 2} and document["error"] is None
+    assert failed["json"] is None
+    assert (failed["error"]["kind"], failed["error"]["limit"]) == ("timeout", "wall")
+    assert failed["text"].startswith("⏱️ Query timed out")
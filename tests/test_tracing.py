@@ -0,0 +1,50 @@
+"""Call trees built from tracer port events."""
+
+from docker_swish_mcp.tracing import build_trace_tree, failed_calls, format_trace
+
+
+def port(name, goal, depth, frame=None, **extra):
+    event = {"port": name, "goal": goal, "predicate": goal.split("(")[0], "depth": depth, **extra}
+    if frame is not None:
+        event["frame"] = frame
+    return event
+
+
+PORTS = [
+    port("call", "grand(tom, Z)", 1, 1),
+    port("call", "parent(tom, Y)", 2, 2),
+    port("exit", "parent(tom, bob)", 2, 2),
+    port("call", "parent(bob, Z)", 2, 3),
+    port("fail", "parent(bob, Z)", 2, 3),
+    port("redo", "parent(tom, Y)", 2, 2),
+    port("fail", "parent(tom, Y)", 2, 2),
+    port("fail", "grand(tom, Z)", 1, 1),
+]
+
+
+def test_ports_nest_by_depth_and_frame():
+    [root] = build_trace_tree(PORTS)
+
+    assert root.outcome == "fail"
+    assert [child.goal for child in root.children] == ["parent(tom, Y)", "parent(bob, Z)"]
+    assert [entry["port"] for entry in root.children[0].ports] == ["call", "exit", "redo", "fail"]
+    assert root.to_dict()["children"][1]["outcome"] == "fail"
+
+
+def test_ports_without_frames_attach_to_the_latest_call():
+    [root] = build_trace_tree([port("call", "a", 1), port("call", "b", 2), port("exit", "b", 2), port("exit", "a", 1)])
+
+    assert root.outcome == "exit"
+    assert [entry["port"] for entry in root.children[0].ports] == ["call", "exit"]
+
+
+def test_failed_calls_are_listed_deepest_first():
+    assert [node.goal for node in failed_calls(build_trace_tree(PORTS))] == [
+        "parent(tom, Y)", "parent(bob, Z)", "grand(tom, Z)",
+    ]
+
+
+def test_format_trace_indents_by_depth():
+    text = format_trace([port("call", "a", 1), port("exception", "b", 2, error="boom")])
+
+    assert text == "📞 Call: a\n   💥 Exception: b  ⟶ boom"
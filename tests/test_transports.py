@@ -0,0 +1,56 @@
+"""Transport options, and the SWISH environment HTTP sessions share."""
+
+import argparse
+from contextlib import asynccontextmanager
+
+import pytest
+
+from docker_swish_mcp import main
+
+
+@pytest.mark.parametrize("listen, address", [
+    (":8080", ("0.0.0.0", 8080)),
+    ("127.0.0.1:9000", ("127.0.0.1", 9000)),
+    ("[::1]:9000", ("[::1]", 9000)),
+])
+def test_parse_listen_address(listen, address):
+    assert main.parse_listen_address(listen) == address
+
+
+def test_listen_address_needs_a_port():
+    with pytest.raises(argparse.ArgumentTypeError, match="expected host:port"):
+        main.parse_listen_address("localhost")
+
+
+def test_transport_defaults_from_environment(monkeypatch):
+    monkeypatch.setenv("SWISH_MCP_TRANSPORT", "sse")
+
+    args = main.parse_args(["--listen", ":9001"])
+
+    assert args.transport == "sse"
+    assert args.listen == ("0.0.0.0", 9001)
+    assert main.parse_args(["--transport", "http"]).transport == "http"
+
+
+async def test_sessions_share_one_environment(monkeypatch):
+    entered = []
+    context = object()
+
+    @asynccontextmanager
+    async def environment(server):
+        entered.append("enter")
+        main.global_swish_context = context
+        yield context
+        entered.append("exit")
+
+    monkeypatch.setattr(main, "swish_environment", environment)
+    monkeypatch.setattr(main, "global_swish_context", None)
+    monkeypatch.setattr(main, "keep_environment_alive", False)
+
+    async with main.app_lifespan(main.mcp) as first:
+        async with main.app_lifespan(main.mcp) as second:
+            assert first is second is context
+        assert entered == ["enter"]
+
+    assert entered == ["enter", "exit"]
+    assert main.shared_environment is None and main.lifespan_users == 0
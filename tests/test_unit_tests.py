@@ -0,0 +1,50 @@
+"""plunit results as run_tests reports them."""
+
+from docker_swish_mcp import unit_tests
+from docker_swish_mcp.config import QueryLimits
+
+# Imported through the module, so pytest does not collect it as a test class
+Result = unit_tests.TestResult
+
+
+def test_tests_call_lists_the_units():
+    limits = QueryLimits()
+
+    assert unit_tests.tests_call(["lists", "my unit"], limits) == (
+        "mcp_tests", ["['lists', 'my unit']", limits.to_prolog()],
+    )
+
+
+def test_results_round_trip_without_empty_fields():
+    row = {"unit": "lists", "test": "reverse", "line": 2, "status": "failed", "got": "[a]", "expected": "[b]", "extra": 1}
+
+    result = Result.from_json(row)
+
+    assert result.to_json() == {k: v for k, v in row.items() if k != "extra"}
+
+
+def test_passed_tests_are_grouped_and_the_rest_detailed():
+    results = [
+        Result("lists", "reverse", 2, "passed"),
+        Result("lists", "append", 5, "passed"),
+        Result("lists", "last", 8, "failed", reason="wrong answer", check="L == c", got="b", expected="c"),
+        Result("io", "read", 3, "error", error="existence_error", output="line 1\nline 2\n"),
+        Result("io", "slow", 9, "passed", fixme="too slow"),
+    ]
+
+    assert unit_tests.summary(results) == {"passed": 3, "failed": 1, "error": 1}
+    assert unit_tests.format_results(results).splitlines() == [
+        "🧪 5 test(s): 3 passed, 1 failed, 1 error",
+        "✅ lists: reverse, append",
+        "❌ lists:last (line 8): wrong answer",
+        "   check: L == c",
+        "   got: b",
+        "   expected: c",
+        "💥 io:read (line 3): error",
+        "   error: existence_error",
+        "   output: line 1",
+        "           line 2",
+        "✅ io:slow (line 9): passed",
+        "   fixme: too slow",
+    ]
+    assert unit_tests.format_results([]).startswith("🧪 No plunit tests are loaded")
@@ -0,0 +1,19 @@
+"""Handing the session over from a warm standby container."""
+
+from docker_swish_mcp import main
+
+
+def test_callbacks_follow_the_context(monkeypatch):
+    calls = []
+    monkeypatch.setattr(main.query_cache, "invalidate", lambda scope, dep: calls.append(("invalidate", scope)))
+    monkeypatch.setattr(main, "record_fact_change", lambda context, payload: calls.append(("fact", context.container_name)))
+    monkeypatch.setattr(main, "record_stored_fact", lambda context, payload: calls.append(("store", context.container_name)))
+    live, standby = main.SwishContext(container_name="live"), main.SwishContext(container_name="standby")
+    session = main.new_prolog_session(standby)
+
+    main.bind_session_callbacks(session, live)
+    session.on_invalidate("parent/2")
+    session.on_fact("{}")
+    session.on_store("{}")
+
+    assert calls == [("invalidate", "live"), ("fact", "live"), ("store", "live")]
@@ -0,0 +1,158 @@
+"""Named data volumes, and the mirror between a volume and the host data directory."""
+
+import io
+import os
+import tarfile
+from types import SimpleNamespace
+
+import pytest
+
+from docker_swish_mcp import volumes
+from docker_swish_mcp.volumes import (
+    VolumeMirror,
+    copy_to_host,
+    ensure_volume,
+    prune_volumes,
+    validate_volume_name,
+)
+
+
+class NotFound(Exception):
+    pass
+
+
+class Volume:
+    def __init__(self, name, labels=None):
+        self.name = name
+        self.attrs = {"Driver": "local", "Labels": labels or {}}
+        self.removed = False
+
+    def remove(self):
+        self.removed = True
+
+
+class Volumes:
+    def __init__(self, *existing):
+        self.existing = {volume.name: volume for volume in existing}
+
+    def get(self, name):
+        if name not in self.existing:
+            raise NotFound(name)
+        return self.existing[name]
+
+    def create(self, name, labels):
+        self.existing[name] = Volume(name, labels)
+
+    def list(self, filters=None):
+        label = (filters or {}).get("label", "")
+        return [v for v in self.existing.values() if not label or f"managed-by={v.attrs['Labels'].get('managed-by')}" == label]
+
+
+def client(*existing, mounts=()):
+    container = SimpleNamespace(name="swish", attrs={"Mounts": [{"Type": "volume", "Name": name} for name in mounts]})
+    return SimpleNamespace(volumes=Volumes(*existing), containers=SimpleNamespace(list=lambda all: [container]))
+
+
+class VolumeContainer:
+    """A container with a volume at /data: the archive API and the commands the mirror runs."""
+
+    def __init__(self, **files):
+        self.name = "swish"
+        # path below /data -> (content, mtime)
+        self.files = {rel: (content.encode(), 1000.0) for rel, content in files.items()}
+
+    def get_archive(self, path):
+        rel = path.removeprefix("/data").strip("/")
+        buffer = io.BytesIO()
+        with tarfile.open(fileobj=buffer, mode="w") as tar:
+            for name, (content, mtime) in sorted(self.files.items()):
+                if rel and name != rel and not name.startswith(rel + "/"):
+                    continue
+                info = tarfile.TarInfo(name.removeprefix(rel.rpartition("/")[0]).lstrip("/") if rel else f"data/{name}")
+                info.size, info.mtime = len(content), mtime
+                tar.addfile(info, io.BytesIO(content))
+        return [buffer.getvalue()], {}
+
+    def put_archive(self, path, data):
+        with tarfile.open(fileobj=io.BytesIO(data)) as tar:
+            for member in tar.getmembers():
+                if member.isfile():
+                    self.files[member.name.removeprefix("data/")] = (tar.extractfile(member).read(), member.mtime)
+        return True
+
+    async def run(self, docker_client, name, cmd, timeout=30):
+        if cmd[0] == "find":
+            return 0, "".join(f"{rel}\t{mtime:.7f}\n" for rel, (_, mtime) in sorted(self.files.items())), ""
+        if cmd[0] == "rm":
+            self.files.pop(cmd[-1].removeprefix("/data/"), None)
+            return 0, "", ""
+        if cmd[0] == "stat":
+            return 0, "1000 1000\n", ""
+        return 1, "", f"unexpected {cmd}"
+
+
+@pytest.fixture
+def mirror(tmp_path, monkeypatch):
+    data_dir = tmp_path / "data"
+    data_dir.mkdir()
+    container = VolumeContainer(**{"family.pl": "parent(tom, bob).\n", "url-cache/rules.pl": "x.\n"})
+    monkeypatch.setattr(volumes, "exec_in_container", container.run)
+
+    async def on_pushed(files):
+        pass
+
+    return VolumeMirror("kb", data_dir, lambda: container, None, on_pushed), container
+
+
+def test_volume_names():
+    assert validate_volume_name("swish-data_1.0") == "swish-data_1.0"
+    with pytest.raises(ValueError, match="Invalid volume name '-x'"):
+        validate_volume_name("-x")
+    with pytest.raises(ValueError, match="has no volume API"):
+        ensure_volume(SimpleNamespace(), "kb")
+
+
+def test_ensure_and_prune_only_touch_our_volumes():
+    docker = client(Volume("used", {"managed-by": volumes.MANAGED_BY}), Volume("postgres"), mounts=["used"])
+
+    assert ensure_volume(docker, "kb") and not ensure_volume(docker, "kb")
+
+    assert prune_volumes(docker) == ["kb"]
+    assert not docker.volumes.existing["used"].removed and not docker.volumes.existing["postgres"].removed
+
+
+def test_copy_to_host_puts_a_subdirectory_back_in_place(tmp_path):
+    container = VolumeContainer(**{"lib/util.pl": "u.\n", "family.pl": "f.\n"})
+
+    assert copy_to_host(container, tmp_path, "lib") == 1
+    assert (tmp_path / "lib" / "util.pl").read_text() == "u.\n" and not (tmp_path / "family.pl").exists()
+
+
+async def test_first_sync_copies_both_ways(mirror):
+    mirror, container = mirror
+    (mirror.data_dir / "notes.pl").write_text("note.\n")
+
+    report = await mirror.run()
+
+    assert (report.pulled, report.pushed) == (["family.pl"], ["notes.pl"])
+    assert (mirror.data_dir / "family.pl").read_text() == "parent(tom, bob).\n"
+    assert container.files["notes.pl"][0] == b"note.\n"
+    # Server output is not mirrored
+    assert not (mirror.data_dir / "url-cache").exists()
+    assert not await mirror.run()
+
+
+async def test_changes_and_deletions_are_carried_over(mirror):
+    mirror, container = mirror
+    await mirror.run()
+    container.files["family.pl"] = (b"parent(ann, bob).\n", 2000.0)
+    (mirror.data_dir / "family.pl").unlink()
+    (mirror.data_dir / "new.pl").write_text("n.\n")
+    os.utime(mirror.data_dir / "new.pl", (3000, 3000))
+
+    report = await mirror.run()
+
+    # The volume's newer edit survives the host deletion
+    assert report.pulled == ["family.pl"] and report.pushed == ["new.pl"]
+    (mirror.data_dir / "new.pl").unlink()
+    assert (await mirror.run()).deleted == ["new.pl"] and "new.pl" not in container.files
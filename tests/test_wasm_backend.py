@@ -0,0 +1,68 @@
+"""The WebAssembly backend, with a shell script standing in for the WASI runtime."""
+
+import pytest
+
+from docker_swish_mcp.wasm_backend import WasmBackendError, WasmProcessClient
+
+
+def fake_runtime(tmp_path, name="wasmtime", fails=False):
+    """An executable taking a runtime's arguments and answering as swipl.wasm would."""
+    script = tmp_path / name
+    script.write_text(
+        "#!/bin/sh\n"
+        + ('echo "trap: out of bounds" >&2; exit 1\n' if fails else "")
+        + 'for last; do :; done\n'
+        'if [ "$last" = --version ]; then echo "SWI-Prolog version 9.3.2 for wasm32-wasi"; exit 0; fi\n'
+        'echo "$*"\n',
+        encoding="utf-8",
+    )
+    script.chmod(0o755)
+    return str(script)
+
+
+@pytest.fixture
+def module(tmp_path):
+    path = tmp_path / "swipl.wasm"
+    path.write_bytes(b"\0asm")
+    return str(path)
+
+
+@pytest.mark.parametrize("runtime, args", [
+    ("wasmtime", ["run", "--dir=.", "{module}", "-q"]),
+    ("wasmer", ["run", "--dir=.", "{module}", "--", "-q"]),
+    ("wazero", ["run", "-mount=.:.", "{module}", "-q"]),
+    ("my-runtime", ["{module}", "-q"]),
+])
+def test_each_runtime_gets_its_arguments(tmp_path, module, runtime, args):
+    client = WasmProcessClient(tmp_path, module, fake_runtime(tmp_path, runtime))
+
+    assert client.host_command(["swipl", "-q"])[1:] == [module if arg == "{module}" else arg for arg in args]
+    # Commands other than swipl run as they are
+    assert client.host_command(["ls"]) == ["ls"]
+
+
+def test_ping_records_the_version(tmp_path, module):
+    client = WasmProcessClient(tmp_path, module, fake_runtime(tmp_path))
+
+    assert client.ping()
+    assert client.version == (9, 3, 2)
+    assert client.engine.endswith("(9.3.2, WebAssembly)")
+
+
+@pytest.mark.parametrize("module_name, runtime, message", [
+    ("", "wasmtime", "SWISH_MCP_WASM_MODULE is not set"),
+    ("missing.wasm", "wasmtime", "missing.wasm not found"),
+    (None, "no-such-runtime", "'no-such-runtime' not found on PATH"),
+])
+def test_missing_module_or_runtime(tmp_path, module, module_name, runtime, message):
+    client = WasmProcessClient(tmp_path, module if module_name is None else module_name, runtime)
+
+    with pytest.raises(WasmBackendError, match=message):
+        client.ping()
+
+
+def test_failing_module_is_reported(tmp_path, module):
+    client = WasmProcessClient(tmp_path, module, fake_runtime(tmp_path, fails=True))
+
+    with pytest.raises(WasmBackendError, match="--version failed under .*: trap: out of bounds"):
+        client.ping()
@@ -0,0 +1,88 @@
+"""The worker pool's caps and its round-robin hand-out of slots."""
+
+import asyncio
+
+import pytest
+
+from docker_swish_mcp.workers import WorkerPool, WorkerPoolError
+
+
+def gated(started, name, gate):
+    """A job that records its start and then waits for gate."""
+    async def job():
+        started.append(name)
+        await gate.wait()
+        return name
+    return job
+
+
+async def settle():
+    for _ in range(5):
+        await asyncio.sleep(0)
+
+
+async def test_slots_go_round_robin_between_clients():
+    pool = WorkerPool(max_concurrency=1, max_per_client=1)
+    started, gate = [], asyncio.Event()
+
+    first = asyncio.ensure_future(pool.submit("carol", gated(started, "c0", gate)))
+    await settle()
+    batch = asyncio.ensure_future(pool.run_all("alice", [gated(started, f"a{i}", gate) for i in range(3)]))
+    await settle()
+    single = asyncio.ensure_future(pool.submit("bob", gated(started, "b0", gate)))
+    await settle()
+    gate.set()
+
+    assert await first == "c0"
+    assert await batch == ["a0", "a1", "a2"]
+    assert await single == "b0"
+    assert started == ["c0", "a0", "b0", "a1", "a2"]
+    assert pool.get_status()["completed"] == 5
+
+
+async def test_one_client_is_held_to_its_share():
+    pool = WorkerPool(max_concurrency=4, max_per_client=2)
+    started, gate = [], asyncio.Event()
+
+    batch = asyncio.ensure_future(pool.run_all("alice", [gated(started, i, gate) for i in range(3)]))
+    await settle()
+
+    assert started == [0, 1]
+    assert pool.get_status()["clients"] == {"alice": {"running": 2, "queued": 1}}
+    gate.set()
+    await batch
+
+
+async def test_full_queue_is_refused_and_errors_are_returned():
+    pool = WorkerPool(max_concurrency=1, max_per_client=1, max_queued=1)
+    gate = asyncio.Event()
+
+    async def boom():
+        raise ValueError("boom")
+
+    running = asyncio.ensure_future(pool.submit("alice", gated([], "first", gate)))
+    queued = asyncio.ensure_future(pool.run_all("bob", [boom]))
+    await settle()
+
+    with pytest.raises(WorkerPoolError, match="queue is full"):
+        await pool.submit("carol", gated([], "late", gate))
+    gate.set()
+    assert await running == "first"
+    [error] = await queued
+    assert isinstance(error, ValueError)
+
+
+async def test_cancelled_waiter_gives_up_its_place():
+    pool = WorkerPool(max_concurrency=1, max_per_client=1)
+    started, gate = [], asyncio.Event()
+
+    running = asyncio.ensure_future(pool.submit("alice", gated(started, "a", gate)))
+    waiting = asyncio.ensure_future(pool.submit("bob", gated(started, "b", gate)))
+    later = asyncio.ensure_future(pool.submit("carol", gated(started, "c", gate)))
+    await settle()
+    waiting.cancel()
+    gate.set()
+
+    assert (await running, await later) == ("a", "c")
+    assert started == ["a", "c"]
+    assert pool.running_total == 0 and pool.queued_total == 0
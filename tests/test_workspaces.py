@@ -0,0 +1,51 @@
+"""The workspace registry and where workspaces keep their files."""
+
+import json
+
+import pytest
+
+from docker_swish_mcp.orchestration import PRIMARY_PORT
+from docker_swish_mcp.workspaces import WorkspaceError, WorkspaceRegistry, free_port
+
+
+def test_shared_and_own_container_workspaces(tmp_path):
+    registry = WorkspaceRegistry(tmp_path / "registry")
+    data = tmp_path / "data"
+
+    shared = registry.create("thesis", data)
+    own = registry.create("lab", data, container=True, used_ports={PRIMARY_PORT + 1})
+
+    assert shared.data_dir == data / "workspaces" / "thesis" and shared.data_dir.is_dir()
+    assert (own.data_dir, own.port) == (tmp_path / "registry" / "lab", PRIMARY_PORT + 2)
+    assert own.container_name == "swish-mcp-ws-lab"
+    assert free_port({PRIMARY_PORT + 1, PRIMARY_PORT + 2}) == PRIMARY_PORT + 3
+
+
+def test_names_must_be_valid_and_free(tmp_path):
+    registry = WorkspaceRegistry(tmp_path)
+    registry.create("thesis", tmp_path)
+
+    with pytest.raises(WorkspaceError, match="already the name"):
+        registry.create("thesis", tmp_path)
+    with pytest.raises(WorkspaceError, match="already the name"):
+        registry.create("replica", tmp_path, taken={"replica"})
+    with pytest.raises(WorkspaceError, match="Invalid workspace name"):
+        registry.create("My Thesis", tmp_path)
+    with pytest.raises(WorkspaceError, match="Unknown workspace 'other'"):
+        registry.remove("other")
+
+
+def test_registry_survives_a_restart_and_skips_bad_entries(tmp_path):
+    registry = WorkspaceRegistry(tmp_path)
+    registry.create("thesis", tmp_path, description="PhD")
+    entries = json.loads(registry.path.read_text(encoding="utf-8"))
+    entries["workspaces"].append({"description": "no name"})
+    registry.path.write_text(json.dumps(entries), encoding="utf-8")
+
+    restarted = WorkspaceRegistry(tmp_path)
+    restarted.load()
+
+    assert list(restarted.workspaces) == ["thesis"]
+    assert restarted.workspaces["thesis"].description == "PhD"
+    restarted.remove("thesis")
+    assert (tmp_path / "workspaces" / "thesis").is_dir()